@@ -82,9 +82,16 @@ pub fn build(b: *std.Build) void {
     });
     const run_exe_unit_tests = b.addRunArtifact(exe_unit_tests);
 
+    // C API tests
+    const capi_unit_tests = b.addTest(.{
+        .root_module = shared_lib.root_module,
+    });
+    const run_capi_unit_tests = b.addRunArtifact(capi_unit_tests);
+
     const test_step = b.step("test", "Run all unit tests");
     test_step.dependOn(&run_lib_unit_tests.step);
     test_step.dependOn(&run_exe_unit_tests.step);
+    test_step.dependOn(&run_capi_unit_tests.step);
 
     // Individual module tests for faster iteration
     const types_tests = b.addTest(.{
@@ -19,11 +19,37 @@ extern "C" {
 #define PGZ_OK        0   /* Success */
 #define PGZ_ERR      -1   /* Generic error */
 #define PGZ_NOT_FOUND 1   /* Key not found */
+#define PGZ_CANCELED  2   /* Stopped before it finished */
+
+/*
+ * Error details: the codes pgz_last_error returns for the last call that
+ * returned PGZ_ERR, or NULL for a handle.
+ */
+#define PGZ_E_NONE             0  /* No call has failed */
+#define PGZ_E_INVALID_ARGUMENT 1  /* A malformed argument or buffer */
+#define PGZ_E_IO               2  /* Reading or writing a file failed */
+#define PGZ_E_CORRUPTION       3  /* Data on disk does not match its checksum */
+#define PGZ_E_READ_ONLY        4  /* A write to a read-only database */
+#define PGZ_E_OUT_OF_MEMORY    5  /* An allocation failed */
+#define PGZ_E_CONFLICT         6  /* A transaction conflicts with another */
+#define PGZ_E_NO_SPACE         7  /* The disk is full */
+#define PGZ_E_INTERNAL         8  /* Any other failure */
+#define PGZ_E_UNSUPPORTED      9  /* An operation the engine does not implement */
+
+/*
+ * Returns the code of the last call that failed on the calling thread, or
+ * PGZ_E_NONE if none has. Copies its message into buf, truncated to
+ * buf_len bytes and not NUL-terminated, and sets msg_len to its full
+ * length. A call that succeeds leaves the last error as it was, so it is
+ * only meaningful right after a call that failed, on the same thread.
+ */
+int pgz_last_error(char* buf, size_t buf_len, size_t* msg_len);
 
 /* Opaque handles */
 typedef struct DB DB;
 typedef struct Transaction Transaction;
 typedef struct Iterator Iterator;
+typedef struct ChangeIterator ChangeIterator;
 
 /* ==========================================================================
  * Database Operations
@@ -35,11 +61,208 @@ typedef struct Iterator Iterator;
  */
 DB* pgz_open(const char* path);
 
+/*
+ * Opens the database at the given path, which must exist, without writing
+ * to it: the log is replayed in memory up to its last committed
+ * transaction, and writes fail. Another process may have it open and go
+ * on writing; what it commits later is not read.
+ * Returns a handle to the database, or NULL on error.
+ */
+DB* pgz_open_read_only(const char* path);
+
+/*
+ * Opens the database at the given path, as pgz_open does, or as
+ * pgz_open_read_only does if read_only is nonzero, with its log, sstables
+ * and manifest to be encrypted with AES-256-GCM under the key_len bytes of
+ * key, which must be 32. Encryption at rest is not implemented yet: a key
+ * of the right length fails with PGZ_E_UNSUPPORTED, rather than open a
+ * database stored in the clear, and one of another length with
+ * PGZ_E_INVALID_ARGUMENT.
+ * Returns a handle to the database, or NULL on error.
+ */
+DB* pgz_open_encrypted(const char* path, const uint8_t* key, size_t key_len,
+                       int read_only);
+
+/*
+ * Opens a new, empty database with no disk backing: its log, sstables and
+ * manifest are kept in memory, and are lost when it is closed. Each call
+ * returns a database of its own.
+ * Returns a handle to the database, or NULL on error.
+ */
+DB* pgz_open_in_memory(void);
+
 /*
  * Closes a database and frees its resources.
  */
 void pgz_close(DB* db);
 
+/*
+ * Makes the writes of committed transactions durable.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_sync(DB* db);
+
+/*
+ * Copies a consistent snapshot of the committed data into the directory
+ * dir, which is created and must not exist, as a database pgz_open can
+ * open. Transactions may run and commit meanwhile; those that commit after
+ * it starts are not in the copy. Copying the data files is not implemented
+ * yet: it fails with PGZ_E_UNSUPPORTED, and creates no directory.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_backup(DB* db, const char* dir);
+
+/*
+ * Reports the space the keys in [start_key, end_key) take: in live_bytes,
+ * the size of the keys and values of the latest committed version of each
+ * key, and in dead_bytes, the size of what compaction would reclaim, the
+ * versions overwritten or deleted and their tombstones. An empty end_key
+ * is the end of the keyspace. The space of ranges is not accounted yet:
+ * it fails with PGZ_E_UNSUPPORTED.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_space(DB* db,
+              const char* start_key, size_t start_len,
+              const char* end_key, size_t end_len,
+              uint64_t* live_bytes, uint64_t* dead_bytes);
+
+/*
+ * Compacts the keys in [start_key, end_key) on demand, as background
+ * compaction would in time: their sstables are rewritten into the last
+ * level without the versions no open snapshot reads and the tombstones of
+ * deleted keys, and the value log segments whose garbage that frees are
+ * collected. It returns once the space is reclaimed. An empty end_key is
+ * the end of the keyspace, so empty keys compact the whole database.
+ * Returns PGZ_OK on success, PGZ_ERR on failure. There is no compactor
+ * yet, so it fails with PGZ_E_UNSUPPORTED.
+ */
+int pgz_compact_range(DB* db,
+                      const char* start_key, size_t start_len,
+                      const char* end_key, size_t end_len);
+
+/* Compression algorithms of pgz_set_compression */
+#define PGZ_COMPRESSION_DEFAULT -1
+#define PGZ_COMPRESSION_NONE 0
+#define PGZ_COMPRESSION_SNAPPY 1
+#define PGZ_COMPRESSION_ZSTD 2
+
+/*
+ * Sets how the sstable blocks of the keys starting with prefix are
+ * compressed: with algorithm, and for PGZ_COMPRESSION_ZSTD, a dictionary
+ * of up to dictionary_bytes (at most 1 MiB) trained on the blocks of the
+ * prefix, or none if 0. PGZ_COMPRESSION_DEFAULT removes the rule of the
+ * prefix. A block takes the rule of the longest prefix matching its first
+ * key, and an empty prefix sets the default of the database, which is
+ * PGZ_COMPRESSION_NONE until set. The rules are kept in the manifest, and
+ * apply to the blocks written from then on; existing blocks are rewritten
+ * with them as compaction reaches them. Blocks are not compressed yet:
+ * the rule is validated, and then fails with PGZ_E_UNSUPPORTED unless it
+ * is PGZ_COMPRESSION_NONE or PGZ_COMPRESSION_DEFAULT.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_set_compression(DB* db, const char* prefix, size_t prefix_len,
+                        int algorithm, uint32_t dictionary_bytes);
+
+/*
+ * Reports a block or record whose checksum does not match to the function
+ * pgz_verify_checksums was given, with its argument arg: the file it is
+ * in, relative to the database directory, its offset in the file, and the
+ * first and last keys it holds, as far as the index of its sstable or its
+ * header tells them, or empty.
+ * Returns 0 to go on verifying, nonzero to stop.
+ */
+typedef int (*pgz_corrupt_fn)(uintptr_t arg, const char* file, uint64_t offset,
+                              const char* start_key, size_t start_len,
+                              const char* end_key, size_t end_len);
+
+/*
+ * Reads every sstable block and value log record of the database and
+ * checks it against its checksum, counting those it reads in checked and
+ * those whose checksum does not match in corrupt, and calling fn, unless
+ * NULL, with each of the latter. If cancel is not NULL, it is read between
+ * blocks, and verification stops once it is nonzero, as another thread
+ * may set it. No blocks or records are written to disk yet, so checked
+ * is 0, which callers must not take for a database found intact.
+ * Returns PGZ_OK if it read them all, corrupt or not, PGZ_CANCELED if
+ * cancel or fn stopped it, PGZ_ERR on failure.
+ */
+int pgz_verify_checksums(DB* db, const int* cancel, pgz_corrupt_fn fn,
+                         uintptr_t arg, uint64_t* checked, uint64_t* corrupt);
+
+/*
+ * Statistics of the database as a whole.
+ */
+typedef struct DBStats {
+    uint64_t key_count;                /* Keys with a live version */
+    uint64_t data_bytes;               /* Size of the sstables and log on disk */
+    uint64_t live_bytes;               /* As pgz_space, over the keyspace */
+    uint64_t dead_bytes;
+    uint64_t compaction_pending_bytes; /* Size compaction is due to rewrite */
+    uint64_t block_cache_hits;         /* Since the database was opened */
+    uint64_t block_cache_misses;
+    uint64_t pending_wal_bytes;        /* Log written but not yet synced */
+    uint64_t uncompressed_bytes;       /* Size of the sstable blocks before */
+    uint64_t compressed_bytes;         /* and after compression */
+    int compacting;                    /* 1 while a compaction runs */
+} DBStats;
+
+/*
+ * Reports the statistics of the database in out.
+ * Returns PGZ_OK on success, PGZ_ERR on failure. The database keeps no
+ * statistics yet, so it fails with PGZ_E_UNSUPPORTED.
+ */
+int pgz_stats(DB* db, DBStats* out);
+
+/* ==========================================================================
+ * Log Archiving and Recovery
+ * ========================================================================== */
+
+/*
+ * Log segments are files named by their number, from 1, as 16 hexadecimal
+ * digits followed by ".log", e.g. 000000000000002A.log.
+ */
+
+/*
+ * Archives the log segment at path, which is full and synced. arg is the
+ * argument pgz_set_archive_fn was given.
+ * Returns PGZ_OK once the segment is archived; the database keeps it and
+ * calls again for it later otherwise.
+ */
+typedef int (*pgz_archive_fn)(uintptr_t arg, const char* path, uint64_t segment);
+
+/*
+ * Sets the function the database calls with each log segment, in order,
+ * to archive it, or stops archiving if fn is NULL.
+ */
+void pgz_set_archive_fn(DB* db, pgz_archive_fn fn, uintptr_t arg);
+
+/*
+ * Replays the log segments in the directory segments, in order, onto the
+ * database at path, which must not be open: a backup taken with pgz_backup
+ * before the first of them. Replay stops before the first transaction
+ * committed after target_us, in microseconds since the Unix epoch, or at
+ * the end of the last segment if target_us is negative. Replaying the log
+ * is not implemented yet: it fails with PGZ_E_UNSUPPORTED.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_recover(const char* path, const char* segments, int64_t target_us);
+
+/* ==========================================================================
+ * Encryption
+ * ========================================================================== */
+
+/*
+ * Rewrites the database at path, which must not be open, encrypted with
+ * new_key instead of old_key, to rotate its key. A key of length 0 stands
+ * for no encryption; other keys must be 32 bytes. Encryption at rest is
+ * not implemented yet, so it fails with PGZ_E_UNSUPPORTED and leaves the
+ * database as it is.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_reencrypt(const char* path,
+                  const uint8_t* old_key, size_t old_len,
+                  const uint8_t* new_key, size_t new_len);
+
 /* ==========================================================================
  * Transaction Operations
  * ========================================================================== */
@@ -66,7 +289,8 @@ void pgz_txn_abort(DB* db, Transaction* txn);
  * ========================================================================== */
 
 /*
- * Gets a value by key within a transaction.
+ * Gets a value by key within a transaction, reading the writes staged in
+ * txn, which must not be NULL, before the database.
  *
  * On success (PGZ_OK), allocates memory for the value and sets out_val/out_len.
  * Caller must free the returned memory with pgz_free().
@@ -81,7 +305,8 @@ int pgz_get(DB* db, Transaction* txn,
             char** out_val, size_t* out_len);
 
 /*
- * Puts a key-value pair within a transaction.
+ * Puts a key-value pair within a transaction, staging it in txn, which
+ * must not be NULL, for its commit to apply.
  * Returns PGZ_OK on success, PGZ_ERR on failure.
  */
 int pgz_put(DB* db, Transaction* txn,
@@ -89,18 +314,46 @@ int pgz_put(DB* db, Transaction* txn,
             const char* val, size_t val_len);
 
 /*
- * Deletes a key within a transaction.
+ * Deletes a key within a transaction, staging the delete in txn, which
+ * must not be NULL, for its commit to apply.
  * Returns PGZ_OK on success, PGZ_ERR on failure.
  */
 int pgz_delete(DB* db, Transaction* txn,
                const char* key, size_t key_len);
 
+/*
+ * Deletes every key in the range [start_key, end_key) within a transaction.
+ * An empty end_key deletes to the end of the keyspace. Range deletes are
+ * not implemented yet: it fails with PGZ_E_UNSUPPORTED, and callers delete
+ * the keys one by one.
+ * Returns PGZ_OK on success, PGZ_ERR on failure.
+ */
+int pgz_delete_range(DB* db, Transaction* txn,
+                     const char* start_key, size_t start_len,
+                     const char* end_key, size_t end_len);
+
+/* Operations of a write batch */
+#define PGZ_BATCH_PUT    0
+#define PGZ_BATCH_DELETE 1
+
+/*
+ * Stages a batch of writes in the write set of txn, in order, for its
+ * commit to apply. The batch is a sequence of operations, each an
+ * operation byte followed by the key and, for PGZ_BATCH_PUT, the value.
+ * Keys and values are prefixed by their length as a little-endian uint32.
+ * A malformed batch stages nothing, and txn must not be NULL.
+ * Returns PGZ_OK on success, PGZ_ERR on failure or a malformed batch.
+ */
+int pgz_write_batch(DB* db, Transaction* txn,
+                    const char* batch, size_t batch_len);
+
 /* ==========================================================================
  * Iterator Operations
  * ========================================================================== */
 
 /*
  * Creates an iterator for scanning a key range [start_key, end_key).
+ * Iteration is not implemented yet, so it fails with PGZ_E_UNSUPPORTED.
  * Returns an iterator handle, or NULL on error.
  */
 Iterator* pgz_scan(DB* db, Transaction* txn,
@@ -122,17 +375,129 @@ int pgz_iter_next(Iterator* iter,
                   char** out_key, size_t* out_key_len,
                   char** out_val, size_t* out_val_len);
 
+/*
+ * Advances the iterator by up to max_n pairs, returned in one response, so
+ * that a scan costs a call per batch rather than per pair.
+ *
+ * On success (PGZ_OK), sets out/out_len to the response and out_count to
+ * the number of pairs in it. The response holds each pair's key then
+ * value, prefixed by their length as a little-endian uint32. It stops
+ * early once the response holds at least max_bytes, unless max_bytes is 0.
+ * Caller must free the response with pgz_free().
+ *
+ * Returns:
+ *   PGZ_OK        - Pairs returned
+ *   PGZ_NOT_FOUND - Iterator exhausted
+ *   PGZ_ERR       - Error occurred
+ */
+int pgz_iter_next_n(Iterator* iter, size_t max_n, size_t max_bytes,
+                    char** out, size_t* out_len, size_t* out_count);
+
 /*
  * Closes an iterator and frees its resources.
  */
 void pgz_iter_close(Iterator* iter);
 
+/* ==========================================================================
+ * Command Buffers
+ * ========================================================================== */
+
+/* Operations of a command buffer */
+#define PGZ_CMD_GET          0
+#define PGZ_CMD_PUT          1
+#define PGZ_CMD_DELETE       2
+#define PGZ_CMD_DELETE_RANGE 3
+#define PGZ_CMD_ITER_NEXT    4
+
+/*
+ * Runs a buffer of commands within a transaction, in order, so that many
+ * storage operations cost one call.
+ *
+ * Each command is an operation byte followed by its arguments:
+ *   PGZ_CMD_GET          - key
+ *   PGZ_CMD_PUT          - key, value
+ *   PGZ_CMD_DELETE       - key
+ *   PGZ_CMD_DELETE_RANGE - start key, end key (empty for the end of the
+ *                          keyspace)
+ *   PGZ_CMD_ITER_NEXT    - an Iterator* as a little-endian uint64
+ * Keys and values are prefixed by their length as a little-endian uint32.
+ * Puts and deletes are staged in txn, which must not be NULL, and gets
+ * read its staged writes before the database. PGZ_CMD_DELETE_RANGE and
+ * PGZ_CMD_ITER_NEXT fail with PGZ_E_UNSUPPORTED until range deletes and
+ * iteration are implemented.
+ *
+ * On success (PGZ_OK), sets out/out_len to the response, which holds a
+ * status byte per command, PGZ_OK or PGZ_NOT_FOUND, followed for a found
+ * PGZ_CMD_GET by the value, and for a PGZ_CMD_ITER_NEXT that returned a
+ * pair by the key and value, length-prefixed as in the commands. Caller
+ * must free the response with pgz_free().
+ *
+ * Returns:
+ *   PGZ_OK  - Every command ran
+ *   PGZ_ERR - A command failed, or the buffer is malformed; the commands
+ *             before it took effect, and there is no response
+ */
+int pgz_execute_batch(DB* db, Transaction* txn,
+                      const char* cmds, size_t cmds_len,
+                      char** out, size_t* out_len);
+
+/* ==========================================================================
+ * Change Export
+ * ========================================================================== */
+
+/*
+ * The sequence number of a change is the commit timestamp of the
+ * transaction that made it: the changes of a transaction share one, and
+ * those of transactions committed later have greater ones.
+ */
+
+/* Kinds of change */
+#define PGZ_CHANGE_PUT          0
+#define PGZ_CHANGE_DELETE       1
+#define PGZ_CHANGE_DELETE_RANGE 2
+
+/*
+ * Creates an iterator over the changes of the transactions committed
+ * after sequence number since, up to the last committed one, whose
+ * sequence number it sets in through. 0 is before the first transaction.
+ * Commits do not record their changes yet, so it fails with
+ * PGZ_E_UNSUPPORTED.
+ *
+ * Returns:
+ *   PGZ_OK        - Iterator created in out_iter
+ *   PGZ_NOT_FOUND - The changes after since are no longer kept
+ *   PGZ_ERR       - Error occurred
+ */
+int pgz_changes(DB* db, uint64_t since, uint64_t* through,
+                ChangeIterator** out_iter);
+
+/*
+ * Returns the next change, in commit order: its kind, sequence number,
+ * key and value. A delete has an empty value; for a deleted range, the key
+ * is its start and the value its end, empty for the end of the keyspace.
+ * Caller must free the key and value with pgz_free().
+ *
+ * Returns:
+ *   PGZ_OK        - Next change returned
+ *   PGZ_NOT_FOUND - Iterator exhausted
+ *   PGZ_ERR       - Error occurred
+ */
+int pgz_change_next(ChangeIterator* iter, int* out_kind, uint64_t* out_seq,
+                    char** out_key, size_t* out_key_len,
+                    char** out_val, size_t* out_val_len);
+
+/*
+ * Closes a change iterator and frees its resources.
+ */
+void pgz_changes_close(ChangeIterator* iter);
+
 /* ==========================================================================
  * Memory Management
  * ========================================================================== */
 
 /*
- * Frees memory allocated by pgz_get or pgz_iter_next.
+ * Frees memory allocated by pgz_get, pgz_iter_next, pgz_iter_next_n,
+ * pgz_execute_batch or pgz_change_next.
  */
 void pgz_free(char* ptr, size_t len);
 
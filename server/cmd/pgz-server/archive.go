@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/config"
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// recoverySignal is the file in the data directory that makes the server
+// recover the database from archived log segments before opening it, as
+// in PostgreSQL.
+const recoverySignal = "recovery.signal"
+
+// archiveFunc returns the function the storage engine archives each full
+// log segment with under archive_mode: it runs archive_command, with %p
+// replaced by the path of the segment and %f by its file name. While the
+// command fails, or is not set, the engine keeps the segment.
+func archiveFunc(log *slog.Logger, cfg *config.Config) func(path string, segment uint64) error {
+	return func(path string, segment uint64) error {
+		command := cfg.Get("archive_command")
+		if command == "" {
+			err := errors.New("archive_command is not set")
+			log.Warn("could not archive log segment", "segment", filepath.Base(path), "err", err)
+			return err
+		}
+		if err := runCommand(command, path, filepath.Base(path)); err != nil {
+			log.Warn("archive command failed", "segment", filepath.Base(path), "command", command, "err", err)
+			return err
+		}
+		log.Debug("archived log segment", "segment", filepath.Base(path))
+		return nil
+	}
+}
+
+// recoverIfSignaled recovers the database at dbPath, a backup, if it holds
+// recovery.signal: it retrieves the log segments archived since, with
+// restore_command, until the command fails for one, and replays them up to
+// recovery_target_time, or to the end if it is not set. The signal file is
+// removed once the database has recovered.
+func recoverIfSignaled(log *slog.Logger, cfg *config.Config, backend engine.Backend, dbPath string) error {
+	signal := filepath.Join(dbPath, recoverySignal)
+	if _, err := os.Stat(signal); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if backend.Recover == nil {
+		return fmt.Errorf("the %s storage engine has no log to recover from", backend.Name)
+	}
+	command := cfg.Get("restore_command")
+	if command == "" {
+		return errors.New("restore_command must be set to recover")
+	}
+	target, err := parseTargetTime(cfg.Get("recovery_target_time"))
+	if err != nil {
+		return err
+	}
+	segments, err := os.MkdirTemp(dbPath, "restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(segments)
+	n := uint64(1)
+	for ; ; n++ {
+		name := backend.SegmentName(n)
+		if err := runCommand(command, filepath.Join(segments, name), name); err != nil {
+			// As in PostgreSQL, the first segment the command cannot
+			// retrieve ends the archive.
+			log.Info("restore command could not retrieve log segment, which ends recovery", "segment", name, "err", err)
+			break
+		}
+	}
+	log.Info("recovering from archived log segments", "segments", n-1, "target_time", cfg.Get("recovery_target_time"))
+	if err := backend.Recover(dbPath, segments, target); errors.Is(err, engine.ErrUnsupported) {
+		return fmt.Errorf("the %s storage engine cannot replay archived log segments yet", backend.Name)
+	} else if err != nil {
+		return err
+	}
+	log.Info("recovery complete")
+	return os.Remove(signal)
+}
+
+// runCommand runs the shell command of archive_command or restore_command,
+// with %p replaced by path, %f by file and %% by %.
+func runCommand(command, path, file string) error {
+	var b strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			b.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'p':
+			b.WriteString(path)
+		case 'f':
+			b.WriteString(file)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(command[i])
+		}
+	}
+	out, err := exec.Command("/bin/sh", "-c", b.String()).CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return err
+}
+
+// checkTargetTime checks a recovery_target_time setting.
+func checkTargetTime(v string) error {
+	_, err := parseTargetTime(v)
+	return err
+}
+
+// parseTargetTime parses recovery_target_time as a timestamp with time
+// zone, or returns the zero time if it is empty.
+func parseTargetTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	d, err := types.ParseDatum(types.TimestampTZ, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return d.(types.DTimestampTZ).Time, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+)
+
+// runBackup is pgz-server backup, which takes a hot backup of a running
+// server by calling pgz_backup on it, as a superuser.
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dsn := fs.String("d", "", "the connection `string` of the server, as in libpq; PGHOST and the other PG* environment variables fill in the rest")
+	timeout := fs.Duration("connect-timeout", 10*time.Second, "how long to wait for the connection")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pgz-server backup [flags] <dir>\n\nCopies the databases of the running server into the directory dir on its host,\nwhich must not exist, without stopping it. The server can be started on the copy.\nA relative dir is taken from the current directory. pgz backup verify checks\nthe copy.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	log := slog.Default()
+	dir, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fatal(log, err.Error())
+	}
+	d, err := pgwire.ParseDSN(*dsn)
+	if err != nil {
+		fatal(log, err.Error())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c, err := pgwire.Connect(ctx, d)
+	if err != nil {
+		fatal(log, "could not connect to the server", "addr", fmt.Sprintf("%s:%d", d.Host, d.Port), "err", err)
+	}
+	defer c.Close()
+	results, err := c.Exec("SELECT pgz_backup(" + parser.QuoteString(dir) + ")")
+	if err != nil {
+		fatal(log, "backup failed", "err", err)
+	}
+	fmt.Printf("backup taken at %s into %s\n", *results[0].Rows[0][0], dir)
+}
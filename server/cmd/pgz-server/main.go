@@ -2,38 +2,301 @@
 //
 // It handles the PG wire protocol, SQL parsing, and query planning,
 // delegating storage operations to the Zig-based storage engine via FFI.
+// Clients connect on TCP at listen_addresses and port, and on Unix
+// sockets in unix_socket_directories, as psql does by default.
+//
+// Its settings, which pg_settings and SHOW list, come from the
+// configuration file of -config, PGZ_<NAME> environment variables and -c
+// name=value flags. On SIGHUP it reads them again.
+//
+// With replica_of, it is a read replica of another server; see runReplica.
+//
+// pgz-server backup <dir> takes a hot backup of a running server instead;
+// see runBackup. pgz-server verify <db-path> checks the checksums of a
+// database for corruption; see runVerify. pgz-server rekey <db-path>
+// rotates the key a database is encrypted at rest with, once its storage
+// engine encrypts; see runRekey.
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
-	"github.com/alivenotions/pgz/server/pkg/storage"
+	"github.com/alivenotions/pgz/server/pkg/config"
+	"github.com/alivenotions/pgz/server/pkg/encryption"
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	_ "github.com/alivenotions/pgz/server/pkg/engine/memory"
+	_ "github.com/alivenotions/pgz/server/pkg/engine/native"
+	"github.com/alivenotions/pgz/server/pkg/logging"
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
 )
 
 func main() {
-	fmt.Printf("pgz-server using libpgz version: %s\n", storage.Version())
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		runBackup(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		runRekey(os.Args[2:])
+		return
+	}
+	configFile := flag.String("config", "", "the configuration `file`, of name = value lines as in postgresql.conf")
+	args := make(map[string]string)
+	flag.Func("c", "set the setting `name=value`, overriding the configuration file and environment", func(v string) error {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("%q is not name=value", v)
+		}
+		args[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		return nil
+	})
+	// These flags set the settings of the same names.
+	for _, f := range []struct{ name, setting, usage string }{
+		{"log-format", "log_format", "log format: text or json"},
+		{"log-level", "log_level", "log levels, e.g. info,sql=debug"},
+		{"log-min-duration-statement", "log_min_duration_statement", "log statements that run at least this long, with their plans; -1 disables"},
+		{"replica-of", "replica_of", "run as a read replica of the primary at host:port"},
+		{"shutdown-grace-period", "shutdown_grace_period", "on SIGTERM or SIGINT, how long to wait for open transactions before canceling them"},
+	} {
+		flag.Func(f.name, f.usage+" (the setting "+f.setting+")", func(v string) error {
+			args[f.setting] = v
+			return nil
+		})
+	}
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: pgz-server [flags] [<db-path>]\n       pgz-server backup [flags] <dir>\n       pgz-server verify [flags] <db-path>\n       pgz-server rekey [flags] <db-path>")
+		fmt.Fprintln(flag.CommandLine.Output(), "\nSettings are read from the configuration file, then from PGZ_<NAME> environment\nvariables, then from the command line. The db path sets data_directory.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flag.NArg() == 1 {
+		args["data_directory"] = flag.Arg(0)
+	}
 
-	if len(os.Args) < 2 {
-		log.Fatal("usage: pgz-server <db-path>")
+	defaultBackend, err := engine.Default()
+	if err != nil {
+		fatal(slog.Default(), "no storage engine", "err", err)
+	}
+	cfg := config.New(settings(defaultBackend.Name))
+	if err := cfg.Load(*configFile, os.Environ(), args); err != nil {
+		fatal(slog.Default(), err.Error())
 	}
 
-	dbPath := os.Args[1]
+	levels := new(logging.LevelVar)
+	logger, err := logging.New(os.Stderr, cfg.Get("log_format"), levels)
+	if err != nil {
+		fatal(slog.Default(), err.Error())
+	}
+	slog.SetDefault(logger)
+	log := logging.Component(logger, "server")
+
+	dbPath := cfg.Get("data_directory")
+	if dbPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	backend, _ := engine.Lookup(cfg.Get("storage_engine"))
+	log.Info("using storage engine", "engine", backend.Name, "version", backend.Version())
+	if err := recoverIfSignaled(log, cfg, backend, dbPath); err != nil {
+		fatal(log, "recovery failed", "path", dbPath, "err", err)
+	}
 
 	// Open the database
-	db, err := storage.Open(dbPath)
+	key, err := encryption.Load(context.Background(), cfg.Get("encryption_key"))
+	if err != nil {
+		fatal(log, "could not load the encryption key", "err", err)
+	}
+	db, err := engine.OpenBackend(backend, dbPath, key, false)
 	if err != nil {
-		log.Fatalf("failed to open database: %v", err)
+		fatal(log, "failed to open database", "path", dbPath, "err", err)
+	}
+	log.Info("opened database", "path", dbPath)
+	if err := setCompression(db, cfg); err != nil {
+		fatal(log, "could not set the compression", "err", err)
+	}
+	if cfg.Bool("archive_mode") {
+		a, ok := db.(engine.Archiver)
+		if !ok {
+			fatal(log, "archive_mode is on, but the storage engine has no log to archive", "engine", backend.Name)
+		}
+		a.SetArchiveFunc(archiveFunc(logging.Component(logger, "archiver"), cfg))
+	}
+
+	// The rows statements spilled before a crash are of no use.
+	if cfg.Get("replica_of") == "" {
+		if err := catalog.DropTempRanges(db); err != nil {
+			fatal(log, "could not delete temporary ranges", "err", err)
+		}
 	}
-	defer db.Close()
 
-	fmt.Printf("Opened database at: %s\n", dbPath)
+	srv := sql.NewServer(db)
+	srv.SetLogger(logger)
+	srv.SetSettings(pgSettings(cfg))
+	srv.SetReadOnly(cfg.Get("replica_of") != "")
+	apply(cfg, srv, levels)
 
-	// TODO: Start PostgreSQL wire protocol server
-	// TODO: Initialize SQL parser
-	// TODO: Initialize query planner
+	wc, err := wireConfig(cfg)
+	if err != nil {
+		fatal(log, err.Error())
+	}
+	pg := pgwire.NewServer(srv, wc)
+	pg.SetLogger(logger)
+	listeners, err := listen(log, cfg)
+	if err != nil {
+		fatal(log, "could not listen", "err", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Info("received SIGHUP, reloading configuration files")
+			reload(log, cfg, srv, pg, levels)
+		}
+	}()
+
+	replicaDone := make(chan struct{})
+	if primary := cfg.Get("replica_of"); primary != "" {
+		go func() {
+			defer close(replicaDone)
+			runReplica(ctx, logger, primary, db, srv)
+		}()
+	} else {
+		close(replicaDone)
+	}
+
+	ttlDone := make(chan struct{})
+	go func() {
+		defer close(ttlDone)
+		runTTLJob(ctx, logging.Component(logger, "ttl"), cfg, srv)
+	}()
+	reaperDone := make(chan struct{})
+	go func() {
+		defer close(reaperDone)
+		runReaper(ctx, logging.Component(logger, "reaper"), cfg, srv)
+	}()
+
+	for _, l := range listeners {
+		go func() {
+			if err := pg.Serve(l); !errors.Is(err, pgwire.ErrServerClosed) {
+				log.Error("stopped accepting connections", "addr", l.Addr(), "err", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	// A second signal stops the server without waiting.
+	stop()
+	signal.Stop(hup)
+	gracePeriod := cfg.Duration("shutdown_grace_period")
+	log.Info("shutting down", "grace_period", gracePeriod)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	switch err := srv.Shutdown(shutdownCtx); {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Warn("grace period expired, canceled the remaining transactions")
+	case err != nil:
+		log.Error("failed to sync database", "err", err)
+	}
+	// The sessions have ended, which closes their connections.
+	pg.Close()
+	<-replicaDone
+	<-ttlDone
+	<-reaperDone
+	if err := db.Close(); err != nil {
+		fatal(log, "failed to close database", "err", err)
+	}
+	log.Info("shut down")
+}
+
+// listen listens on port on each of listen_addresses, where * is all
+// addresses, and on a Unix socket in each of unix_socket_directories.
+func listen(log *slog.Logger, cfg *config.Config) ([]net.Listener, error) {
+	port := int(cfg.Int("port"))
+	var listeners []net.Listener
+	for _, host := range splitList(cfg.Get("listen_addresses")) {
+		if host == "*" {
+			host = ""
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+		log.Info("listening", "addr", l.Addr())
+		listeners = append(listeners, l)
+	}
+	// The setting was checked when it was loaded.
+	perm, _ := parsePermissions(cfg.Get("unix_socket_permissions"))
+	for _, dir := range splitList(cfg.Get("unix_socket_directories")) {
+		l, err := pgwire.ListenUnix(dir, port, perm)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("listening", "addr", l.Addr())
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("no listen_addresses or unix_socket_directories")
+	}
+	return listeners, nil
+}
+
+// setCompression sets the default compression of db to that of the
+// compression settings. An engine that does not compress, or not yet with
+// the algorithm, can only have none.
+func setCompression(db engine.Engine, cfg *config.Config) error {
+	c := &engine.Compression{
+		Algorithm:       cfg.Get("compression"),
+		DictionaryBytes: int(cfg.Int("compression_dictionary_size")),
+	}
+	x, ok := db.(engine.Compressor)
+	switch {
+	case ok:
+		err := x.SetCompression(nil, c)
+		if errors.Is(err, engine.ErrUnsupported) {
+			return fmt.Errorf("compression is %s, but the storage engine cannot compress with it yet", c.Algorithm)
+		}
+		return err
+	case c.Algorithm != engine.CompressionNone:
+		return fmt.Errorf("compression is %s, but the storage engine does not compress", c.Algorithm)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated setting, dropping empty elements.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
 
-	fmt.Println("Server ready (not yet implemented)")
-	fmt.Println("FFI connection verified!")
+// fatal logs msg at error level and exits.
+func fatal(log *slog.Logger, msg string, args ...any) {
+	log.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/config"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+// reaperPoll is how often a disabled reaper checks whether reaper_interval
+// has been set since.
+const reaperPoll = time.Minute
+
+// runReaper cleans up what sessions left behind every reaper_interval,
+// until ctx is done: the temporary ranges statements failed to delete, and
+// the transactions open for longer than transaction_timeout. The settings
+// are read again after each run, so that a reload changes them.
+func runReaper(ctx context.Context, log *slog.Logger, cfg *config.Config, srv *sql.Server) {
+	for {
+		wait := cfg.Duration("reaper_interval")
+		if wait <= 0 {
+			wait = reaperPoll
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if cfg.Duration("reaper_interval") <= 0 {
+			continue
+		}
+		r, err := srv.Reap(ctx, cfg.Duration("transaction_timeout"))
+		if r.TempRanges > 0 {
+			log.Info("deleted orphaned temporary ranges", "ranges", r.TempRanges)
+		}
+		if r.Transactions > 0 {
+			log.Warn("terminated sessions for transaction timeout", "sessions", r.Transactions,
+				"transaction_timeout", cfg.Duration("transaction_timeout"))
+		}
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case err != nil:
+			log.Error("could not reap", "err", err)
+		}
+	}
+}
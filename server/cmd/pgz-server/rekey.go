@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/alivenotions/pgz/server/pkg/encryption"
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// runRekey is pgz-server rekey, which re-encrypts the database at a path
+// with a new key, to rotate the key it is encrypted at rest with, or to
+// encrypt a database stored in the clear. The server must be stopped, and
+// started again with encryption_key naming the new key. No storage engine
+// encrypts yet, so it fails and leaves the database as it is.
+func runRekey(args []string) {
+	log := slog.Default()
+	def, err := engine.Default()
+	if err != nil {
+		fatal(log, "no storage engine", "err", err)
+	}
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	name := fs.String("engine", def.Name, "the storage `engine` of the database")
+	oldSpec := fs.String("old-key", "", "the `source` of the key the database is encrypted with, or empty if it is not")
+	newSpec := fs.String("new-key", "", "the `source` of the key to encrypt it with, or empty to decrypt it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pgz-server rekey [flags] <db-path>\n\nRewrites the database at db-path, which no server may have open, encrypted with\nthe key of -new-key instead of that of -old-key. Keys are named by their source\nas the encryption_key setting names them, e.g. file:/etc/pgz/key or env:PGZ_KEY.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	path := fs.Arg(0)
+	if *oldSpec == "" && *newSpec == "" {
+		fatal(log, "-old-key or -new-key must be given")
+	}
+	b, ok := engine.Lookup(*name)
+	if !ok {
+		fatal(log, "unknown storage engine", "engine", *name, "available", engine.Backends())
+	}
+	if b.Reencrypt == nil {
+		fatal(log, "the storage engine does not encrypt", "engine", b.Name)
+	}
+	ctx := context.Background()
+	oldKey, err := encryption.Load(ctx, *oldSpec)
+	if err != nil {
+		fatal(log, "could not load the old key", "err", err)
+	}
+	newKey, err := encryption.Load(ctx, *newSpec)
+	if err != nil {
+		fatal(log, "could not load the new key", "err", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		fatal(log, err.Error())
+	}
+	if err := b.Reencrypt(path, oldKey, newKey); errors.Is(err, engine.ErrUnsupported) {
+		fatal(log, "the storage engine does not encrypt yet", "engine", b.Name)
+	} else if err != nil {
+		fatal(log, "re-encryption failed", "path", path, "err", err)
+	}
+	log.Info("re-encrypted the database", "path", path)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/logging"
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/replica"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+// runReplica makes the server a read replica of the primary at the
+// host:port of replica_of, until ctx is done: it applies the changes the
+// primary streams to db, whose sessions srv has made read-only. The role
+// and password it connects as, which must be a superuser's, are taken from
+// the PGUSER and PGPASSWORD environment variables, and whether to use SSL
+// from PGSSLMODE, as in libpq.
+func runReplica(ctx context.Context, logger *slog.Logger, primary string, db engine.Engine, srv *sql.Server) {
+	log := logging.Component(logger, "replica")
+	dsn, err := pgwire.ParseDSN("")
+	if err != nil {
+		fatal(log, err.Error())
+	}
+	// replica_of was checked when it was loaded.
+	host, port, _ := net.SplitHostPort(primary)
+	dsn.Host = host
+	dsn.Port, _ = strconv.Atoi(port)
+	r := &replica.Replica{DSN: dsn, Engine: db, CatalogChanged: srv.CatalogChanged, Logger: log}
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		fatal(log, "could not follow the primary", "err", err)
+	}
+}
+
+// checkPrimary checks that replica_of is empty or a host:port.
+func checkPrimary(v string) error {
+	if v == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(v)
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid primary %q: must be host:port", v)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q of the primary", port)
+	}
+	return nil
+}
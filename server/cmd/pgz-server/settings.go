@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/alivenotions/pgz/server/pkg/config"
+	"github.com/alivenotions/pgz/server/pkg/encryption"
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/logging"
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// settings returns the server's settings. defaultEngine is the storage
+// engine used when none is configured.
+func settings(defaultEngine string) []*config.Setting {
+	checkLevels := func(v string) error {
+		_, err := logging.ParseLevels(v)
+		return err
+	}
+	checkKeySource := func(v string) error {
+		if v == "" {
+			return nil
+		}
+		_, err := encryption.Open(v)
+		return err
+	}
+	checkPermissions := func(v string) error {
+		_, err := parsePermissions(v)
+		return err
+	}
+	return []*config.Setting{
+		{Name: "listen_addresses", Kind: config.String, Default: "localhost",
+			Description: "Sets the host name or IP address(es) to listen to."},
+		{Name: "port", Kind: config.Int, Default: "5432",
+			Description: "Sets the TCP port the server listens on."},
+		{Name: "unix_socket_directories", Kind: config.String, Default: "/tmp",
+			Description: "Sets the directories where Unix-domain sockets will be created."},
+		{Name: "unix_socket_permissions", Kind: config.String, Default: "0777", Check: checkPermissions,
+			Description: "Sets the access permissions of the Unix-domain socket."},
+		{Name: "data_directory", Kind: config.String,
+			Description: "Sets the path of the database, or :memory: for one kept in memory."},
+		{Name: "storage_engine", Kind: config.Enum, Default: defaultEngine, Values: engine.Backends(),
+			Description: "Sets the storage engine the database is opened with."},
+		{Name: "encryption_key", Kind: config.String, Check: checkKeySource,
+			Description: "Sets the source of the key the database is encrypted at rest with: file:<path>, env:<variable>, or a key management plugin's scheme. No storage engine encrypts yet, so the database fails to open with one."},
+		{Name: "compression", Kind: config.Enum, Default: "none", Values: []string{engine.CompressionNone, engine.CompressionSnappy, engine.CompressionZstd},
+			Description: "Sets how the storage engine compresses the data of tables without a compression storage parameter. The native engine compresses nothing yet, and refuses all but none."},
+		{Name: "compression_dictionary_size", Kind: config.Int, Bytes: true, Default: "0",
+			Description: "Sets the size of the dictionary zstd compression trains on the data. 0 trains none."},
+		{Name: "ssl", Kind: config.Bool, Default: "off", Reloadable: true,
+			Description: "Enables SSL connections."},
+		{Name: "ssl_cert_file", Kind: config.String, Default: "server.crt", Reloadable: true,
+			Description: "Location of the SSL server certificate file."},
+		{Name: "ssl_key_file", Kind: config.String, Default: "server.key", Reloadable: true,
+			Description: "Location of the SSL server private key file."},
+		{Name: "auth_method", Kind: config.Enum, Default: "trust", Values: []string{"trust", "password", "scram-sha-256"}, Reloadable: true,
+			Description: "Sets how clients on TCP authenticate."},
+		{Name: "local_auth_method", Kind: config.Enum, Default: "peer", Values: []string{"trust", "peer", "password", "scram-sha-256"}, Reloadable: true,
+			Description: "Sets how clients on Unix-domain sockets authenticate."},
+		{Name: "log_format", Kind: config.Enum, Default: "text", Values: []string{"text", "json"},
+			Description: "Sets the format of the server log."},
+		{Name: "log_level", Kind: config.String, Default: "info", Reloadable: true, Check: checkLevels,
+			Description: "Sets the levels logged, by default and per component, such as info,sql=debug."},
+		{Name: "log_min_duration_statement", Kind: config.Duration, Default: "-1", Reloadable: true,
+			Description: "Sets the minimum execution time above which statements are logged, with their plans. -1 disables."},
+		{Name: "commit_delay", Kind: config.Duration, Default: "0", Reloadable: true,
+			Description: "Sets the delay between transaction commit and syncing the engine."},
+		{Name: "archive_mode", Kind: config.Bool, Default: "off",
+			Description: "Allows archiving of log segments using archive_command."},
+		{Name: "archive_command", Kind: config.String, Reloadable: true,
+			Description: "Sets the shell command that will be called to archive a log segment."},
+		{Name: "restore_command", Kind: config.String,
+			Description: "Sets the shell command that will be called to retrieve an archived log segment."},
+		{Name: "recovery_target_time", Kind: config.String, Check: checkTargetTime,
+			Description: "Sets the time stamp up to which recovery will proceed."},
+		{Name: "replica_of", Kind: config.String, Check: checkPrimary,
+			Description: "Sets the host:port of the primary the server is a read replica of. Empty for a primary."},
+		{Name: "wal_writer_delay", Kind: config.Duration, Default: "200ms", Reloadable: true,
+			Description: "Sets how long after an asynchronous commit the engine is synced."},
+		{Name: "max_connections", Kind: config.Int, Default: "100", Reloadable: true,
+			Description: "Sets the maximum number of concurrent connections. 0 is no limit."},
+		{Name: "max_active_queries", Kind: config.Int, Default: "0", Reloadable: true,
+			Description: "Sets the maximum number of queries that run at once. 0 is no limit."},
+		{Name: "max_queued_queries", Kind: config.Int, Default: "100", Reloadable: true,
+			Description: "Sets the maximum number of queries that wait for one of max_active_queries to end. Queries beyond it are refused."},
+		{Name: "query_queue_timeout", Kind: config.Duration, Default: "0", Reloadable: true,
+			Description: "Sets the maximum time a query waits to run before it is canceled. 0 disables."},
+		{Name: "client_send_buffer_size", Kind: config.Int, Bytes: true, Default: "64kB", Reloadable: true,
+			Description: "Sets the size of the buffer of what is sent to each client, beyond which queries wait for the client to read."},
+		{Name: "client_send_timeout", Kind: config.Duration, Default: "0", Reloadable: true,
+			Description: "Sets the maximum time a client may take to read what is sent to it before its connection is closed. 0 disables."},
+		{Name: "idle_in_transaction_session_timeout", Kind: config.Duration, Default: "0", Reloadable: true,
+			Description: "Sets the maximum allowed idle time between queries, when in a transaction. 0 disables."},
+		{Name: "idle_session_timeout", Kind: config.Duration, Default: "0", Reloadable: true,
+			Description: "Sets the maximum allowed idle time between queries, when not in a transaction. 0 disables."},
+		{Name: "max_transaction_bytes", Kind: config.Int, Bytes: true, Default: "0", Reloadable: true,
+			Description: "Sets the maximum size of the writes of a transaction. 0 is no limit."},
+		{Name: "max_failed_logins", Kind: config.Int, Default: "0", Reloadable: true,
+			Description: "Sets the number of failed login attempts in a row after which a role is locked. 0 disables."},
+		{Name: "failed_login_lock_time", Kind: config.Duration, Default: "15min", Reloadable: true,
+			Description: "Sets how long a role stays locked after too many failed login attempts. 0 locks it until it is unlocked."},
+		{Name: "transaction_timeout", Kind: config.Duration, Default: "0", Reloadable: true,
+			Description: "Sets the maximum allowed duration of any transaction, checked every reaper_interval. 0 disables."},
+		{Name: "reaper_interval", Kind: config.Duration, Default: "1min", Reloadable: true,
+			Description: "Sets how often orphaned temporary ranges and transactions past transaction_timeout are cleaned up. 0 disables."},
+		{Name: "ttl_job_interval", Kind: config.Duration, Default: "5min", Reloadable: true,
+			Description: "Sets how often the expired rows of tables with a TTL are deleted. 0 disables."},
+		{Name: "shutdown_grace_period", Kind: config.Duration, Default: "20s", Reloadable: true,
+			Description: "Sets how long shutting down waits for open transactions before canceling them."},
+	}
+}
+
+// parsePermissions parses the octal permissions of
+// unix_socket_permissions.
+func parsePermissions(v string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("invalid permissions %q: must be an octal mode such as 0770", v)
+	}
+	return os.FileMode(n), nil
+}
+
+// wireConfig returns how the wire protocol server accepts connections
+// under the settings of cfg, loading the SSL certificate if ssl is on.
+func wireConfig(cfg *config.Config) (pgwire.Config, error) {
+	wc := pgwire.Config{
+		Auth:      pgwire.AuthMethod(cfg.Get("auth_method")),
+		LocalAuth: pgwire.AuthMethod(cfg.Get("local_auth_method")),
+
+		MaxActiveQueries: int(cfg.Int("max_active_queries")),
+		MaxQueuedQueries: int(cfg.Int("max_queued_queries")),
+		QueueTimeout:     cfg.Duration("query_queue_timeout"),
+		SendBufferSize:   int(cfg.Int("client_send_buffer_size")),
+		SendTimeout:      cfg.Duration("client_send_timeout"),
+	}
+	if cfg.Bool("ssl") {
+		cert, err := tls.LoadX509KeyPair(cfg.Get("ssl_cert_file"), cfg.Get("ssl_key_file"))
+		if err != nil {
+			return pgwire.Config{}, fmt.Errorf("could not load SSL certificate: %w", err)
+		}
+		wc.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	return wc, nil
+}
+
+// apply applies the reloadable settings of cfg to srv and the log levels.
+func apply(cfg *config.Config, srv *sql.Server, levels *logging.LevelVar) {
+	// log_level was checked when it was loaded.
+	l, _ := logging.ParseLevels(cfg.Get("log_level"))
+	levels.Set(l)
+	srv.SetLogMinDurationStatement(cfg.Duration("log_min_duration_statement"))
+	srv.SetCommitDelay(cfg.Duration("commit_delay"))
+	srv.SetWalWriterDelay(cfg.Duration("wal_writer_delay"))
+	srv.SetMaxConnections(int(cfg.Int("max_connections")))
+	srv.SetIdleInTransactionSessionTimeout(cfg.Duration("idle_in_transaction_session_timeout"))
+	srv.SetIdleSessionTimeout(cfg.Duration("idle_session_timeout"))
+	srv.SetMaxTransactionBytes(cfg.Int("max_transaction_bytes"))
+	srv.SetLoginLockout(int(cfg.Int("max_failed_logins")), cfg.Duration("failed_login_lock_time"))
+}
+
+// reload reads the configuration again, as on SIGHUP, and applies the
+// settings that changed.
+func reload(log *slog.Logger, cfg *config.Config, srv *sql.Server, pg *pgwire.Server, levels *logging.LevelVar) {
+	changed, err := cfg.Reload()
+	if err != nil {
+		log.Error("configuration file contains errors; no changes were applied", "err", err)
+		return
+	}
+	apply(cfg, srv, levels)
+	if wc, err := wireConfig(cfg); err != nil {
+		log.Error("keeping the previous connection settings", "err", err)
+	} else {
+		pg.SetConfig(wc)
+	}
+	for _, name := range changed {
+		log.Info("parameter changed", "name", name, "value", cfg.Get(name))
+	}
+	for _, v := range cfg.All() {
+		if v.PendingRestart {
+			log.Warn("parameter cannot be changed without restarting the server", "name", v.Name)
+		}
+	}
+}
+
+// pgSettings returns the settings of cfg as pg_settings lists them.
+func pgSettings(cfg *config.Config) func() []vtable.Setting {
+	return func() []vtable.Setting {
+		all := cfg.All()
+		out := make([]vtable.Setting, len(all))
+		for i, v := range all {
+			context := "postmaster"
+			if v.Reloadable {
+				context = "sighup"
+			}
+			out[i] = vtable.Setting{
+				Name:           v.Name,
+				Setting:        v.Value,
+				Description:    v.Description,
+				Context:        context,
+				VarType:        v.Kind.String(),
+				EnumVals:       v.Values,
+				Source:         v.Source,
+				BootVal:        v.Default,
+				PendingRestart: v.PendingRestart,
+			}
+		}
+		return out
+	}
+}
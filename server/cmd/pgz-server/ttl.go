@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/config"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+// ttlJobPoll is how often a disabled TTL job checks whether
+// ttl_job_interval has been set since.
+const ttlJobPoll = time.Minute
+
+// runTTLJob deletes the expired rows of the tables with a TTL every
+// ttl_job_interval, until ctx is done. The interval is read again after
+// each run, so that a reload changes it.
+func runTTLJob(ctx context.Context, log *slog.Logger, cfg *config.Config, srv *sql.Server) {
+	for {
+		wait := cfg.Duration("ttl_job_interval")
+		if wait <= 0 {
+			wait = ttlJobPoll
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if cfg.Duration("ttl_job_interval") <= 0 {
+			continue
+		}
+		start := time.Now()
+		n, err := srv.DeleteExpired(ctx)
+		switch {
+		case errors.Is(err, context.Canceled):
+			return
+		case err != nil:
+			log.Error("could not delete expired rows", "deleted", n, "err", err)
+		case n > 0:
+			log.Info("deleted expired rows", "deleted", n, "duration", time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/alivenotions/pgz/server/pkg/backup"
+	"github.com/alivenotions/pgz/server/pkg/encryption"
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	_ "github.com/alivenotions/pgz/server/pkg/engine/native"
+)
+
+// runBackup runs the pgz backup command its first argument names.
+func runBackup(args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: pgz backup verify [flags] <dir>")
+		os.Exit(2)
+	}
+	runBackupVerify(args[1:])
+}
+
+// runBackupVerify is pgz backup verify, which checks the backup in a
+// directory with backup.Verify and prints its report. It exits with
+// status 1 if the backup failed a check.
+func runBackupVerify(args []string) {
+	fs := flag.NewFlagSet("backup verify", flag.ExitOnError)
+	name := fs.String("engine", "native", "the storage `engine` of the backup")
+	countRows := fs.Bool("count-rows", false, "read every row of every table, and report how many each has")
+	keySpec := fs.String("encryption-key", "", "the `source` of the key the backup is encrypted with, as the encryption_key setting names it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pgz backup verify [flags] <dir>\n\nChecks the backup in the directory dir without changing it: replays its log,\nchecks its checksums and reads the catalog of each database. Prints a report\nin JSON, and exits with status 1 if the backup failed a check.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	b, ok := engine.Lookup(*name)
+	if !ok {
+		fatal(fmt.Errorf("unknown storage engine %q (available: %v)", *name, engine.Backends()))
+	}
+	key, err := encryption.Load(context.Background(), *keySpec)
+	if err != nil {
+		fatal(err)
+	}
+	r, err := backup.Verify(b, fs.Arg(0), key, *countRows)
+	if err != nil {
+		fatal(err)
+	}
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(string(out))
+	if !r.OK {
+		os.Exit(1)
+	}
+}
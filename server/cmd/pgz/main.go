@@ -0,0 +1,111 @@
+// pgz is the command line tool of pgz servers.
+//
+// Its commands are:
+//
+//	pgz diff [flags] <from-dsn> <to-dsn>
+//	pgz backup verify [flags] <dir>
+//
+// diff compares the schemas of the databases the two connection strings
+// name, which may be on different servers, and prints the DDL that changes
+// the first into the second. Connection strings are those of libpq: URLs
+// such as postgres://user@host:5432/db, or keyword=value pairs.
+//
+// backup verify checks a backup pgz-server backup took, without changing
+// it, and prints a report of what it found in JSON; see runBackupVerify.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/schemadiff"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	switch cmd, args := flag.Arg(0), flag.Args()[1:]; cmd {
+	case "diff":
+		runDiff(args)
+	case "backup":
+		runBackup(args)
+	default:
+		fmt.Fprintf(os.Stderr, "pgz: unknown command %q\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pgz <command> [arguments]\n\nCommands:\n  diff    print the DDL that changes the schema of one database into another's\n  backup  check a backup of a server")
+}
+
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	timeout := fs.Duration("connect-timeout", 10*time.Second, "how long to wait for each connection")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: pgz diff [flags] <from-dsn> <to-dsn>\n\nPrints the statements that change the schema of the first database into that of\nthe second.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var cats [2]*schemadiff.Catalog
+	for i, dsn := range fs.Args() {
+		var err error
+		if cats[i], err = loadCatalog(dsn, *timeout); err != nil {
+			fatal(err)
+		}
+	}
+	stmts := schemadiff.Diff(cats[0], cats[1])
+	if len(stmts) == 0 {
+		fmt.Println("-- the schemas are the same")
+		return
+	}
+	fmt.Println(strings.Join(stmts, "\n"))
+}
+
+// loadCatalog reads the catalog of the database s names.
+func loadCatalog(s string, timeout time.Duration) (*schemadiff.Catalog, error) {
+	dsn, err := pgwire.ParseDSN(s)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := pgwire.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s:%d: %w", dsn.Host, dsn.Port, err)
+	}
+	defer c.Close()
+	return schemadiff.Load(querier{c})
+}
+
+// querier runs the queries of schemadiff.Load on a connection.
+type querier struct {
+	c *pgwire.Client
+}
+
+func (q querier) Query(query string) ([][]*string, error) {
+	results, err := q.c.Exec(query)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return results[len(results)-1].Rows, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "pgz:", err)
+	os.Exit(1)
+}
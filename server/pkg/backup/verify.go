@@ -0,0 +1,202 @@
+// Package backup checks the backups pgz_backup takes, so that they can be
+// trusted before they are needed.
+package backup
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+)
+
+// Report is what Verify found, as pgz backup verify prints it in JSON.
+type Report struct {
+	Path   string `json:"path"`
+	Engine string `json:"engine"`
+	// OK reports whether the backup passed every check.
+	OK bool `json:"ok"`
+	// Checksums is what the check of the engine's checksums found, or
+	// nil if the engine keeps none.
+	Checksums *Checksums  `json:"checksums"`
+	Databases []*Database `json:"databases"`
+	// Errors are the problems found, if any.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Checksums is what the check of the engine's checksums found.
+type Checksums struct {
+	// Checked is the number of blocks and records checked, and Corrupt
+	// the number of them whose checksum did not match.
+	Checked int64 `json:"checked"`
+	Corrupt int64 `json:"corrupt"`
+	// Corruptions are the blocks and records that did not match, as far
+	// as the engine lists them.
+	Corruptions []Corruption `json:"corruptions,omitempty"`
+}
+
+// Corruption is a block or record whose checksum did not match, with the
+// range of keys it holds, in hexadecimal, if the engine tells it.
+type Corruption struct {
+	File     string `json:"file"`
+	Offset   int64  `json:"offset"`
+	StartKey string `json:"start_key,omitempty"`
+	EndKey   string `json:"end_key,omitempty"`
+}
+
+// Database is a database of the backup, with the tables its catalog lists.
+type Database struct {
+	Name   string   `json:"name"`
+	Tables []*Table `json:"tables"`
+}
+
+// Table is a table of the backup.
+type Table struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	// Rows is the number of rows of the table, if they were counted.
+	Rows *int64 `json:"rows,omitempty"`
+}
+
+// Verify checks the backup at path, a database of backend b. It opens it
+// read-only, which replays its log to the last transaction committed in
+// it without changing it, checks the engine's checksums, and reads the
+// catalog of each database. If countRows is set, it also reads and
+// decodes every row of every table, counting them. key is the key the
+// backup is encrypted with, as its database was, or nil.
+//
+// Problems with what the backup holds are in the Errors of the report; an
+// error is returned only if the backup cannot be opened at all.
+func Verify(b engine.Backend, path string, key []byte, countRows bool) (*Report, error) {
+	if b.OpenReadOnly == nil && key == nil {
+		return nil, fmt.Errorf("backup: the %s engine cannot open a database read-only", b.Name)
+	}
+	if fi, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("backup: %s is not a directory", path)
+	}
+	e, err := engine.OpenBackend(b, path, key, true)
+	if err != nil {
+		return nil, fmt.Errorf("backup: opening %s: %w", path, err)
+	}
+	defer e.Close()
+	r := &Report{Path: path, Engine: b.Name, Databases: []*Database{}}
+	if v, ok := e.(engine.ChecksumVerifier); ok {
+		s, err := v.VerifyChecksums(context.Background())
+		if err != nil {
+			r.errorf("verifying checksums: %v", err)
+		} else {
+			r.Checksums = &Checksums{Checked: s.Checked, Corrupt: s.Corrupt}
+			for _, c := range s.Corruptions {
+				r.Checksums.Corruptions = append(r.Checksums.Corruptions, Corruption{
+					File: c.File, Offset: c.Offset, StartKey: hex.EncodeToString(c.StartKey), EndKey: hex.EncodeToString(c.EndKey),
+				})
+			}
+			switch {
+			case s.Checked == 0:
+				// Every backup holds at least its catalog, so an engine that
+				// checked nothing has vouched for nothing.
+				r.errorf("no blocks or records were checked, so their checksums are not known to match")
+			case s.Corrupt > 0:
+				r.errorf("%d of %d blocks and records have a checksum that does not match", s.Corrupt, s.Checked)
+			}
+		}
+	}
+	var dbs []*catalog.Database
+	err = readTxn(e, func(txn engine.Txn) (err error) {
+		dbs, err = catalog.ListDatabases(txn)
+		return err
+	})
+	if err != nil {
+		r.errorf("reading the databases: %v", err)
+	}
+	for _, db := range dbs {
+		d := &Database{Name: db.Name, Tables: []*Table{}}
+		r.Databases = append(r.Databases, d)
+		err := readTxn(catalog.Keyspace(e, db), func(txn engine.Txn) error {
+			return r.verifyDatabase(txn, d, countRows)
+		})
+		if err != nil {
+			r.errorf("database %s: %v", db.Name, err)
+		}
+	}
+	r.OK = len(r.Errors) == 0
+	return r, nil
+}
+
+// verifyDatabase lists the tables of the database whose keyspace txn
+// reads in d, counting their rows if countRows is set. A table whose rows
+// cannot be read is reported, and the others are still counted.
+func (r *Report) verifyDatabase(txn engine.Txn, d *Database, countRows bool) error {
+	schemas, err := catalog.ListSchemas(txn)
+	if err != nil {
+		return err
+	}
+	names := make(map[catalog.ID]string, len(schemas))
+	for _, s := range schemas {
+		names[s.ID] = s.Name
+	}
+	tables, err := catalog.ListTables(txn)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		tr := &Table{Schema: names[t.Schema], Name: t.Name}
+		d.Tables = append(d.Tables, tr)
+		if !countRows {
+			continue
+		}
+		n, err := countTable(txn, t)
+		if err != nil {
+			r.errorf("database %s: table %s.%s: %v", d.Name, tr.Schema, tr.Name, err)
+			continue
+		}
+		tr.Rows = &n
+	}
+	return nil
+}
+
+// countTable reads and decodes the rows of t, returning how many there
+// are.
+func countTable(txn engine.Txn, t *catalog.Table) (int64, error) {
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	it, err := txn.Scan(prefix, rowcodec.PrefixEnd(prefix))
+	if err != nil {
+		return 0, err
+	}
+	defer it.Close()
+	var n int64
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if _, err := rowcodec.DecodeRow(t, k, v); err != nil {
+			return n, fmt.Errorf("row %d: %w", n+1, err)
+		}
+		n++
+	}
+}
+
+// readTxn runs fn in a transaction on e, which it aborts, as the backup
+// is not written to.
+func readTxn(e engine.Engine, fn func(engine.Txn) error) error {
+	txn, err := e.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Abort()
+	return fn(txn)
+}
+
+func (r *Report) errorf(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,477 @@
+// Package config reads the server's settings the way PostgreSQL does:
+// from a configuration file of name = value lines, from environment
+// variables, and from the command line, each overriding the ones before.
+//
+// The configuration file can be read again while the server runs, on
+// SIGHUP. Settings that are reloadable take their new values then; the
+// others keep the values they started with, and are pending a restart.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the type of a setting's values.
+type Kind int
+
+const (
+	// Bool values are on, off, true, false, yes, no, 1 or 0.
+	Bool Kind = iota
+	// Int values are integers, which may have a unit of bytes, kB, MB, GB
+	// or TB if the setting counts bytes.
+	Int
+	// Duration values have a unit of us, ms, s, min, h or d, or are
+	// milliseconds without one, as in PostgreSQL. Go durations such as
+	// 1m30s are accepted too.
+	Duration
+	// String values are any text.
+	String
+	// Enum values are one of the setting's Values.
+	Enum
+)
+
+// String returns the name of k as pg_settings.vartype shows it.
+func (k Kind) String() string {
+	switch k {
+	case Bool:
+		return "bool"
+	case Int, Duration:
+		return "integer"
+	case Enum:
+		return "enum"
+	}
+	return "string"
+}
+
+// Setting is a configuration parameter.
+type Setting struct {
+	Name        string
+	Description string
+	Kind        Kind
+	// Default is the value of the setting when it is not set.
+	Default string
+	// Values are the values of an Enum.
+	Values []string
+	// Bytes is set for an Int that counts bytes, so that its values may
+	// have a unit.
+	Bytes bool
+	// Reloadable is set if the setting may change when the configuration
+	// is reloaded.
+	Reloadable bool
+	// Check, if set, validates the values of the setting beyond its kind.
+	Check func(value string) error
+}
+
+// Sources of values, as pg_settings.source shows them.
+const (
+	SourceDefault     = "default"
+	SourceFile        = "configuration file"
+	SourceEnvironment = "environment variable"
+	SourceCommandLine = "command line"
+)
+
+// Value is the effective value of a setting.
+type Value struct {
+	*Setting
+	Value  string
+	Source string
+	// PendingRestart is set if the configuration was reloaded with a
+	// different value of a setting that is not reloadable.
+	PendingRestart bool
+}
+
+// EnvPrefix is the prefix of the environment variables of settings: the
+// setting log_level is set by PGZ_LOG_LEVEL.
+const EnvPrefix = "PGZ_"
+
+// Config holds the values of settings. It is safe for concurrent use.
+type Config struct {
+	settings []*Setting
+	byName   map[string]*Setting
+
+	mu     sync.RWMutex
+	values map[string]Value
+	// file, env and args are where the values were loaded from, which
+	// Reload reads again.
+	file string
+	env  []string
+	args map[string]string
+}
+
+// New returns a configuration of settings, each at its default value. It
+// panics if a default is invalid.
+func New(settings []*Setting) *Config {
+	c := &Config{byName: make(map[string]*Setting), values: make(map[string]Value)}
+	for _, s := range settings {
+		if s.Kind != String {
+			def, err := s.normalize(s.Default)
+			if err != nil {
+				panic(err)
+			}
+			s.Default = def
+		}
+		c.settings = append(c.settings, s)
+		c.byName[s.Name] = s
+		c.values[s.Name] = Value{Setting: s, Value: s.Default, Source: SourceDefault}
+	}
+	slices.SortFunc(c.settings, func(a, b *Setting) int { return strings.Compare(a.Name, b.Name) })
+	return c
+}
+
+// Load sets the settings from the configuration file, if file is not
+// empty, then from env, a list of environment variables in the form
+// key=value, then from args, the values given on the command line by
+// setting name.
+func (c *Config) Load(file string, env []string, args map[string]string) error {
+	values, err := c.read(file, env, args)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values, c.file, c.env, c.args = values, file, env, args
+	return nil
+}
+
+// Reload reads the configuration file again, and returns the names of the
+// reloadable settings whose values changed. Settings that are not
+// reloadable keep their values. If the file has errors, no setting
+// changes.
+func (c *Config) Reload() (changed []string, err error) {
+	c.mu.RLock()
+	file, env, args := c.file, c.env, c.args
+	c.mu.RUnlock()
+	values, err := c.read(file, env, args)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.settings {
+		old, v := c.values[s.Name], values[s.Name]
+		switch {
+		case old.Value == v.Value:
+			old.Source, old.PendingRestart = v.Source, false
+			c.values[s.Name] = old
+		case s.Reloadable:
+			c.values[s.Name] = v
+			changed = append(changed, s.Name)
+		default:
+			old.PendingRestart = true
+			c.values[s.Name] = old
+		}
+	}
+	return changed, nil
+}
+
+// read returns the values of the settings from their sources.
+func (c *Config) read(file string, env []string, args map[string]string) (map[string]Value, error) {
+	values := make(map[string]Value, len(c.settings))
+	for _, s := range c.settings {
+		values[s.Name] = Value{Setting: s, Value: s.Default, Source: SourceDefault}
+	}
+	set := func(name, value, source string) error {
+		s := c.byName[strings.ToLower(name)]
+		if s == nil {
+			return fmt.Errorf("unrecognized configuration parameter %q", name)
+		}
+		value, err := s.normalize(value)
+		if err != nil {
+			return err
+		}
+		values[s.Name] = Value{Setting: s, Value: value, Source: source}
+		return nil
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		entries, err := ParseFile(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("config: %s: %w", file, err)
+		}
+		for _, e := range entries {
+			if err := set(e.Name, e.Value, SourceFile); err != nil {
+				return nil, fmt.Errorf("config: %s:%d: %w", file, e.Line, err)
+			}
+		}
+	}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		name, ok := strings.CutPrefix(k, EnvPrefix)
+		if !ok || c.byName[strings.ToLower(name)] == nil {
+			continue
+		}
+		if err := set(name, v, SourceEnvironment); err != nil {
+			return nil, fmt.Errorf("config: %s: %w", k, err)
+		}
+	}
+	for name, v := range args {
+		if err := set(name, v, SourceCommandLine); err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// normalize validates value and returns it as the setting shows it.
+func (s *Setting) normalize(value string) (string, error) {
+	invalid := func() error {
+		return fmt.Errorf("invalid value for parameter %q: %q", s.Name, value)
+	}
+	switch s.Kind {
+	case Bool:
+		b, ok := parseBool(value)
+		if !ok {
+			return "", invalid()
+		}
+		value = "off"
+		if b {
+			value = "on"
+		}
+	case Int:
+		n, err := parseInt(value, s.Bytes)
+		if err != nil {
+			return "", invalid()
+		}
+		value = strconv.FormatInt(n, 10)
+		if s.Bytes {
+			value = formatBytes(n)
+		}
+	case Duration:
+		d, err := ParseDuration(value)
+		if err != nil {
+			return "", invalid()
+		}
+		value = FormatDuration(d)
+	case Enum:
+		i := slices.IndexFunc(s.Values, func(v string) bool { return strings.EqualFold(v, value) })
+		if i < 0 {
+			return "", fmt.Errorf("invalid value for parameter %q: %q (one of %s)", s.Name, value, strings.Join(s.Values, ", "))
+		}
+		value = s.Values[i]
+	}
+	if s.Check != nil {
+		if err := s.Check(value); err != nil {
+			return "", fmt.Errorf("invalid value for parameter %q: %w", s.Name, err)
+		}
+	}
+	return value, nil
+}
+
+// All returns the values of the settings, ordered by name.
+func (c *Config) All() []Value {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]Value, len(c.settings))
+	for i, s := range c.settings {
+		out[i] = c.values[s.Name]
+	}
+	return out
+}
+
+// Get returns the value of the setting name. It panics if there is no such
+// setting.
+func (c *Config) Get(name string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	v, ok := c.values[name]
+	if !ok {
+		panic("config: no setting " + name)
+	}
+	return v.Value
+}
+
+// Bool returns the value of the Bool setting name.
+func (c *Config) Bool(name string) bool {
+	b, _ := parseBool(c.Get(name))
+	return b
+}
+
+// Int returns the value of the Int setting name, in bytes if it counts
+// bytes.
+func (c *Config) Int(name string) int64 {
+	n, _ := parseInt(c.Get(name), true)
+	return n
+}
+
+// Duration returns the value of the Duration setting name.
+func (c *Config) Duration(name string) time.Duration {
+	d, _ := ParseDuration(c.Get(name))
+	return d
+}
+
+// Entry is a setting of a configuration file, on line Line.
+type Entry struct {
+	Name, Value string
+	Line        int
+}
+
+// ParseFile parses a configuration file in the format of postgresql.conf:
+// a setting per line, as name = value or name value, where the value may
+// be quoted with single quotes, doubling those it contains. Text after #
+// is a comment.
+func ParseFile(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexAny(line, " \t=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing value of %q", n, line)
+		}
+		e := Entry{Name: strings.ToLower(line[:i]), Line: n}
+		rest := strings.TrimSpace(line[i:])
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+		if strings.HasPrefix(rest, "'") {
+			var b strings.Builder
+			closed := false
+			for i = 1; i < len(rest); i++ {
+				if rest[i] != '\'' {
+					b.WriteByte(rest[i])
+					continue
+				}
+				if i+1 < len(rest) && rest[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+					continue
+				}
+				closed = true
+				break
+			}
+			if !closed {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", n)
+			}
+			e.Value, rest = b.String(), strings.TrimSpace(rest[i+1:])
+		} else {
+			e.Value, rest, _ = strings.Cut(rest, "#")
+			e.Value, rest = strings.TrimSpace(e.Value), ""
+		}
+		if rest != "" && rest[0] != '#' {
+			return nil, fmt.Errorf("line %d: syntax error near %q", n, rest)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "on", "true", "yes", "1":
+		return true, true
+	case "off", "false", "no", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// byteUnits are the units of settings that count bytes.
+var byteUnits = []struct {
+	suffix string
+	n      int64
+}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"kB", 1 << 10}, {"B", 1}}
+
+func parseInt(s string, bytes bool) (int64, error) {
+	s = strings.TrimSpace(s)
+	mult := int64(1)
+	if bytes {
+		for _, u := range byteUnits {
+			if num, ok := strings.CutSuffix(s, u.suffix); ok {
+				s, mult = strings.TrimSpace(num), u.n
+				break
+			}
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n * mult, err
+}
+
+// ParseBytes parses the value of a memory setting of a session, such as
+// work_mem: a number with a unit of byteUnits, or a number of unit bytes
+// without one, as PostgreSQL takes work_mem in kB.
+func ParseBytes(s string, unit int64) (int64, error) {
+	if n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+		return n * unit, nil
+	}
+	return parseInt(s, true)
+}
+
+// FormatBytes formats n bytes as PostgreSQL shows memory settings.
+func FormatBytes(n int64) string {
+	return formatBytes(n)
+}
+
+// formatBytes formats n in the largest unit that divides it, as
+// PostgreSQL shows memory settings.
+func formatBytes(n int64) string {
+	if n == 0 {
+		return "0"
+	}
+	for _, u := range byteUnits {
+		if n%u.n == 0 {
+			return strconv.FormatInt(n/u.n, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// durationUnits are the units of PostgreSQL's time settings.
+var durationUnits = []struct {
+	suffix string
+	d      time.Duration
+}{{"us", time.Microsecond}, {"ms", time.Millisecond}, {"min", time.Minute}, {"s", time.Second}, {"h", time.Hour}, {"d", 24 * time.Hour}}
+
+// ParseDuration parses the value of a time setting: a number with a unit
+// of durationUnits, milliseconds without one, or a Go duration. Negative
+// durations, which turn features off, are -1.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n < 0 {
+			return -1, nil
+		}
+		return time.Duration(n) * time.Millisecond, nil
+	}
+	for _, u := range durationUnits {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			if n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64); err == nil && n >= 0 {
+				return time.Duration(n) * u.d, nil
+			}
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d < 0 {
+		d = -1
+	}
+	return d, err
+}
+
+// FormatDuration formats d in the largest unit that divides it, as
+// PostgreSQL shows time settings. A negative duration is -1.
+func FormatDuration(d time.Duration) string {
+	if d < 0 {
+		return "-1"
+	}
+	if d == 0 {
+		return "0"
+	}
+	for i := len(durationUnits) - 1; i >= 0; i-- {
+		if u := durationUnits[i]; d%u.d == 0 {
+			return strconv.FormatInt(int64(d/u.d), 10) + u.suffix
+		}
+	}
+	return d.String()
+}
@@ -0,0 +1,158 @@
+// Package encryption finds the keys databases are encrypted at rest with,
+// from the sources the encryption_key setting names: a file, an
+// environment variable, or a key management service, whose plugin
+// registers a source of its own.
+//
+// A source is named by a specification of the form scheme:argument:
+//
+//	file:/etc/pgz/key    the key in a file, which only its owner may read
+//	env:PGZ_KEY          the key in an environment variable
+//
+// Files and variables hold the key as 64 hexadecimal digits; a file may
+// also hold its 32 bytes as they are.
+//
+// No storage engine encrypts yet: the native one fails to open a database
+// with a key, rather than store it in the clear.
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeySize is the size of a key, in bytes: databases are encrypted with
+// AES-256.
+const KeySize = 32
+
+// Source supplies a key.
+type Source interface {
+	// Key returns the key, of KeySize bytes.
+	Key(ctx context.Context) ([]byte, error)
+}
+
+// Opener returns the source its argument, the part of a specification
+// after the scheme, describes.
+type Opener func(arg string) (Source, error)
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]Opener{
+		"file": func(arg string) (Source, error) { return fileSource(arg), nil },
+		"env":  func(arg string) (Source, error) { return envSource(arg), nil },
+	}
+)
+
+// Register makes the sources of scheme available, for the plugin of a key
+// management service, which registers its scheme in an init function and
+// is linked in with a blank import, as storage engines are. It panics if
+// the scheme is already registered.
+func Register(scheme string, open Opener) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	if _, dup := sources[scheme]; dup {
+		panic("encryption: Register called twice for scheme " + scheme)
+	}
+	sources[scheme] = open
+}
+
+// Schemes returns the registered schemes.
+func Schemes() []string {
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open returns the source spec names.
+func Open(spec string) (Source, error) {
+	scheme, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("encryption: %q is not scheme:argument", spec)
+	}
+	sourcesMu.RLock()
+	open, ok := sources[scheme]
+	sourcesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("encryption: unknown key source %q (registered: %v)", scheme, Schemes())
+	}
+	return open(arg)
+}
+
+// Load returns the key of the source spec names, or nil if spec is empty,
+// for no encryption.
+func Load(ctx context.Context, spec string) ([]byte, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	src, err := Open(spec)
+	if err != nil {
+		return nil, err
+	}
+	key, err := src.Key(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: reading the key of %s: %w", spec, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption: the key of %s is %d bytes, not %d", spec, len(key), KeySize)
+	}
+	return key, nil
+}
+
+// ParseKey decodes a key written as 64 hexadecimal digits, around which
+// spaces are ignored.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != KeySize {
+		return nil, errors.New("a key must be 64 hexadecimal digits")
+	}
+	return key, nil
+}
+
+// fileSource is the key in the file at its path.
+type fileSource string
+
+func (f fileSource) Key(context.Context) ([]byte, error) {
+	fi, err := os.Stat(string(f))
+	if err != nil {
+		return nil, err
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("%s may be read by other users than its owner (mode %04o)", f, fi.Mode().Perm())
+	}
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == KeySize && !isHex(b) {
+		return b, nil
+	}
+	return ParseKey(string(b))
+}
+
+// isHex reports whether b is hexadecimal digits and spaces, as a key
+// written out is rather than its raw bytes.
+func isHex(b []byte) bool {
+	return len(bytes.Trim(b, "0123456789abcdefABCDEF \t\r\n")) == 0
+}
+
+// envSource is the key in the environment variable it names.
+type envSource string
+
+func (e envSource) Key(context.Context) ([]byte, error) {
+	v, ok := os.LookupEnv(string(e))
+	if !ok {
+		return nil, fmt.Errorf("the environment variable %s is not set", string(e))
+	}
+	return ParseKey(v)
+}
@@ -0,0 +1,485 @@
+// Package engine defines the key-value storage interface consumed by the
+// SQL layer.
+//
+// Backends register themselves by name from an init function, the same way
+// database/sql drivers do. The cgo binding to the Zig storage engine
+// (package native) is the default; package memory provides a pure-Go
+// engine for tests and for platforms without the native library.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("key not found")
+	ErrTxnDone  = errors.New("transaction already finished")
+	ErrClosed   = errors.New("engine closed")
+	ErrEmptyKey = errors.New("empty key")
+	// ErrConflict is returned by Commit when another transaction committed
+	// a write to one of the same keys after this transaction began.
+	ErrConflict = errors.New("write conflict with concurrent transaction")
+	// ErrTxnTooLarge is returned by a WriteBuffer for a write that takes
+	// the transaction past its MaxBytes.
+	ErrTxnTooLarge = errors.New("transaction too large")
+	// ErrChangesCompacted is returned by a ChangeFeed for changes it no
+	// longer keeps.
+	ErrChangesCompacted = errors.New("changes since the sequence number are no longer kept")
+	// ErrUnsupported is returned for an operation the backend does not
+	// implement. It matches errors.ErrUnsupported.
+	ErrUnsupported = fmt.Errorf("storage engine: %w", errors.ErrUnsupported)
+)
+
+// Reader provides point and range reads.
+type Reader interface {
+	// Get returns the value stored at key, or ErrNotFound.
+	Get(key []byte) ([]byte, error)
+	// Scan returns an iterator over keys in [start, end). A nil end scans
+	// to the end of the keyspace.
+	Scan(start, end []byte) (Iterator, error)
+}
+
+// Writer provides point writes.
+type Writer interface {
+	// Put stores value at key.
+	Put(key, value []byte) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(key []byte) error
+	// DeleteRange removes every key in [start, end) in one call, rather
+	// than a Scan and a Delete for each key. A nil end deletes to the end
+	// of the keyspace. Backends without range deletes return
+	// ErrUnsupported; the function DeleteRange falls back to deleting
+	// each key.
+	DeleteRange(start, end []byte) error
+}
+
+// Engine is an open key-value store. Reads and writes made directly on the
+// engine run in their own single-operation transaction.
+type Engine interface {
+	Reader
+	Writer
+	// Begin starts a transaction.
+	Begin() (Txn, error)
+	// Close releases the engine. Open transactions must be finished first.
+	Close() error
+}
+
+// Txn is a transaction. Reads observe the transaction's own writes.
+type Txn interface {
+	Reader
+	Writer
+	// Commit makes the transaction's writes visible. Backends that detect
+	// write-write conflicts return ErrConflict and discard the writes.
+	Commit() error
+	// Abort discards the transaction's writes. It is safe to call after
+	// Commit, in which case it does nothing.
+	Abort()
+}
+
+// Backuper is implemented by engines that can copy their data while
+// transactions run, for hot backups.
+type Backuper interface {
+	// Backup copies a consistent snapshot of the transactions committed
+	// so far into the directory dir, which must not exist, as a database
+	// the engine's backend can open. It fails with ErrUnsupported, and
+	// creates nothing, if the backend cannot copy its data.
+	Backup(dir string) error
+}
+
+// SpaceStats is the space the keys of a range take in an engine.
+type SpaceStats struct {
+	// LiveBytes is the size of the keys and values reads see: those of
+	// the latest committed version of each key.
+	LiveBytes int64
+	// DeadBytes is the size of what the engine keeps but new reads do not
+	// see, which compaction reclaims: the versions that were overwritten or
+	// deleted, and the tombstones of deleted keys.
+	DeadBytes int64
+}
+
+// SpaceReporter is implemented by engines that report the space key
+// ranges take, for operators to tell when compaction is due.
+type SpaceReporter interface {
+	// Space returns the space the keys in [start, end) take. A nil end is
+	// the end of the keyspace. It fails with ErrUnsupported if the backend
+	// does not account it.
+	Space(start, end []byte) (SpaceStats, error)
+}
+
+// Compacter is implemented by engines that reclaim the space of dead data
+// by compacting it in the background, and can compact a key range on
+// demand, as after large deletes.
+type Compacter interface {
+	// CompactRange compacts the keys in [start, end), returning once the
+	// space of their versions no open snapshot reads is reclaimed. A nil
+	// end is the end of the keyspace. It fails with ErrUnsupported if the
+	// backend cannot compact on demand.
+	CompactRange(start, end []byte) error
+}
+
+// The compression algorithms of Compression.
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// Compression is how an engine compresses the data of a key range.
+type Compression struct {
+	// Algorithm is CompressionNone, CompressionSnappy or CompressionZstd.
+	Algorithm string
+	// DictionaryBytes is the size of the dictionary zstd trains on the
+	// data of the range, up to MaxDictionaryBytes, or 0 for none.
+	DictionaryBytes int
+}
+
+// MaxDictionaryBytes is the largest DictionaryBytes of a Compression.
+const MaxDictionaryBytes = 1 << 20
+
+// Compressor is implemented by engines that compress their data, and can
+// compress key ranges differently.
+type Compressor interface {
+	// SetCompression sets the compression of the keys starting with
+	// prefix, or if c is nil, removes it, so that they take that of a
+	// shorter prefix. An empty prefix sets the default of the keyspace.
+	// It applies to the data the engine writes from then on. It fails with
+	// ErrUnsupported for an algorithm the backend cannot compress with.
+	SetCompression(prefix []byte, c *Compression) error
+}
+
+// ChecksumStats is what a checksum verification of an engine found.
+type ChecksumStats struct {
+	// Checked is the number of blocks or records read and checked.
+	Checked int64
+	// Corrupt is the number of them whose checksum did not match.
+	Corrupt int64
+	// Corruptions are those of them the engine lists, which may be fewer.
+	Corruptions []Corruption
+}
+
+// Corruption is a block or record whose checksum did not match.
+type Corruption struct {
+	// File is the file of the engine it is in, and Offset where in it.
+	File   string
+	Offset int64
+	// StartKey and EndKey are the first and last keys it holds, or nil if
+	// the engine cannot tell.
+	StartKey, EndKey []byte
+}
+
+// ChecksumVerifier is implemented by engines that store checksums of their
+// data, which can be checked to find corruption before reads return it.
+type ChecksumVerifier interface {
+	// VerifyChecksums reads all the engine stores and checks it against
+	// its checksums, stopping with ctx's error once ctx is done. Corrupt
+	// data is reported, not returned as an error.
+	VerifyChecksums(ctx context.Context) (ChecksumStats, error)
+}
+
+// StorageStats are statistics of an engine's storage as a whole, for
+// operators to watch its health.
+type StorageStats struct {
+	// Keys is the number of keys with a live version.
+	Keys int64
+	// DataBytes is the size of the engine's files on disk.
+	DataBytes int64
+	// LiveBytes and DeadBytes are those of the whole keyspace, as
+	// SpaceStats counts them.
+	LiveBytes, DeadBytes int64
+	// Compacting is whether a compaction is running, and
+	// CompactionPendingBytes the size of the data compaction is due to
+	// rewrite.
+	Compacting             bool
+	CompactionPendingBytes int64
+	// BlockCacheHits and BlockCacheMisses count the reads the block cache
+	// served and those it did not, since the engine was opened.
+	BlockCacheHits, BlockCacheMisses int64
+	// PendingWALBytes is the size of the log written but not yet synced.
+	PendingWALBytes int64
+	// UncompressedBytes and CompressedBytes are the size of the data on
+	// disk before compression and after.
+	UncompressedBytes, CompressedBytes int64
+}
+
+// StatsReporter is implemented by engines that report statistics of their
+// storage.
+type StatsReporter interface {
+	// StorageStats returns the statistics. It fails with ErrUnsupported if
+	// the backend does not keep them.
+	StorageStats() (StorageStats, error)
+}
+
+// Archiver is implemented by engines that log their writes in segments,
+// which can be archived as they fill for point-in-time recovery: a backup
+// and the segments archived since recover the database as of any time
+// after it, with the Recover of its Backend.
+type Archiver interface {
+	// SetArchiveFunc makes the engine call fn with the path of each log
+	// segment, in order, once it is full and synced. Until fn returns nil
+	// the engine keeps the segment and calls fn for it again later. A nil
+	// fn stops archiving.
+	SetArchiveFunc(fn func(path string, segment uint64) error)
+}
+
+// ChangeKind is the kind of a Change.
+type ChangeKind byte
+
+// Kinds of change.
+const (
+	ChangePut ChangeKind = iota
+	ChangeDelete
+	ChangeDeleteRange
+)
+
+// Change is a write of a committed transaction. A put has the key and the
+// value written, and a delete the key. A deleted range has its start as
+// Key and its end as Value, empty for the end of the keyspace.
+type Change struct {
+	Kind       ChangeKind
+	Key, Value []byte
+}
+
+// CommittedTxn is a committed transaction, as a ChangeFeed sends it.
+type CommittedTxn struct {
+	// Seq is the sequence number of the transaction, which orders the
+	// transactions by commit.
+	Seq     uint64
+	Changes []Change
+	// Err is set on the last transaction of a feed that failed. Its other
+	// fields are zero.
+	Err error
+}
+
+// ChangeFeed is implemented by engines that keep the changes of their
+// committed transactions, which read replicas follow them by.
+type ChangeFeed interface {
+	// SubscribeChanges returns a channel that receives the transactions
+	// committed after sequence number from, in commit order, first those
+	// already committed and then the others as they commit. 0 starts from
+	// the first transaction the engine keeps. The channel is closed when
+	// ctx is done or the engine is closed, or after a transaction with
+	// Err. It returns ErrChangesCompacted if the changes after from are
+	// no longer kept, and ErrUnsupported if the backend does not keep
+	// them at all.
+	SubscribeChanges(ctx context.Context, from uint64) (<-chan CommittedTxn, error)
+}
+
+// Iterator walks a key range in ascending key order.
+type Iterator interface {
+	// Next returns the next key-value pair, or ErrNotFound when the range
+	// is exhausted.
+	Next() (key, value []byte, err error)
+	// Close releases the iterator.
+	Close()
+}
+
+// Seeker is implemented by iterators that can skip ahead in their range
+// without reading the keys they skip.
+type Seeker interface {
+	// Seek moves the iterator forward so that Next returns the first key
+	// at or after key. key must not be before a key Next has returned.
+	Seek(key []byte) error
+}
+
+// Seek moves it, an iterator of r over keys before end, forward to key. If
+// it is not a Seeker, it is closed and a new iterator from key returned.
+func Seek(r Reader, it Iterator, key, end []byte) (Iterator, error) {
+	if s, ok := it.(Seeker); ok {
+		return it, s.Seek(key)
+	}
+	it.Close()
+	return r.Scan(key, end)
+}
+
+// MultiGetter is implemented by readers that can read many keys in one
+// call, which saves the cost of a call into the backend per key.
+type MultiGetter interface {
+	// GetMany returns the values stored at keys, in order, with nil for
+	// the keys not found and a non-nil value for those found.
+	GetMany(keys [][]byte) ([][]byte, error)
+}
+
+// GetMany reads keys from r, in one call if it is a MultiGetter, returning
+// their values in order, with nil for the keys not found and a non-nil
+// value for those found.
+func GetMany(r Reader, keys [][]byte) ([][]byte, error) {
+	if m, ok := r.(MultiGetter); ok {
+		return m.GetMany(keys)
+	}
+	vals := make([][]byte, len(keys))
+	for i, key := range keys {
+		v, err := r.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = []byte{}
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// DeleteRange removes every key in [start, end) of rw, in one call if its
+// backend supports range deletes, and otherwise with a Delete of each key
+// rw reads there.
+func DeleteRange(rw interface {
+	Reader
+	Writer
+}, start, end []byte) error {
+	err := rw.DeleteRange(start, end)
+	if !errors.Is(err, ErrUnsupported) {
+		return err
+	}
+	it, err := rw.Scan(start, end)
+	if err != nil {
+		return err
+	}
+	var keys [][]byte
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			it.Close()
+			return err
+		}
+		keys = append(keys, k)
+	}
+	it.Close()
+	for _, k := range keys {
+		if err := rw.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunTxn runs fn in a transaction on e, committing if fn returns nil and
+// aborting otherwise.
+func RunTxn(e Engine, fn func(Txn) error) error {
+	txn, err := e.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(txn); err != nil {
+		txn.Abort()
+		return err
+	}
+	return txn.Commit()
+}
+
+// Backend describes a registered engine implementation.
+type Backend struct {
+	// Name identifies the backend, e.g. "native" or "memory".
+	Name string
+	// Open opens or creates a database at path.
+	Open func(path string) (Engine, error)
+	// OpenReadOnly opens the existing database at path without writing
+	// to it, so that a backup can be checked as it is; writes fail. It is
+	// nil for backends that cannot.
+	OpenReadOnly func(path string) (Engine, error)
+	// Version reports the backend version for diagnostics.
+	Version func() string
+	// SegmentName returns the file name of the segment'th log segment,
+	// which the archived segments Recover replays must keep, and Recover
+	// replays the segments in the directory segments onto the closed
+	// database at path, stopping before the first transaction committed
+	// after target, or at the end if target is zero. Both are nil for
+	// backends that are not Archivers.
+	SegmentName func(segment uint64) string
+	Recover     func(path, segments string, target time.Time) error
+	// OpenEncrypted opens the database at path, as Open does or as
+	// OpenReadOnly does if readOnly is set, encrypted at rest with key,
+	// and Reencrypt rewrites the closed database at path encrypted with
+	// newKey instead of oldKey, to rotate its key; a nil key stands for no
+	// encryption. Both are nil for backends that do not encrypt, and fail
+	// with ErrUnsupported for those that do not yet.
+	OpenEncrypted func(path string, key []byte, readOnly bool) (Engine, error)
+	Reencrypt     func(path string, oldKey, newKey []byte) error
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Backend)
+)
+
+// Register makes a backend available by name. It panics if the name is
+// already registered.
+func Register(b Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, dup := backends[b.Name]; dup {
+		panic("engine: Register called twice for backend " + b.Name)
+	}
+	backends[b.Name] = b
+}
+
+// Backends returns the names of the registered backends.
+func Backends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the backend registered under name.
+func Lookup(name string) (Backend, bool) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	b, ok := backends[name]
+	return b, ok
+}
+
+// Default returns the native backend when it is compiled in, and the
+// in-memory backend otherwise.
+func Default() (Backend, error) {
+	for _, name := range []string{"native", "memory"} {
+		if b, ok := Lookup(name); ok {
+			return b, nil
+		}
+	}
+	return Backend{}, errors.New("engine: no backends registered")
+}
+
+// OpenBackend opens the database at path with b, encrypted with key unless
+// it is nil, and read-only if readOnly is set.
+func OpenBackend(b Backend, path string, key []byte, readOnly bool) (Engine, error) {
+	switch {
+	case key != nil && b.OpenEncrypted == nil:
+		return nil, fmt.Errorf("engine: the %s backend does not encrypt", b.Name)
+	case key != nil:
+		e, err := b.OpenEncrypted(path, key, readOnly)
+		if errors.Is(err, ErrUnsupported) {
+			return nil, fmt.Errorf("engine: the %s backend does not encrypt yet: %w", b.Name, err)
+		}
+		return e, err
+	case readOnly && b.OpenReadOnly == nil:
+		return nil, fmt.Errorf("engine: the %s backend cannot open a database read-only", b.Name)
+	case readOnly:
+		return b.OpenReadOnly(path)
+	}
+	return b.Open(path)
+}
+
+// Open opens a database at path using the named backend.
+func Open(name, path string) (Engine, error) {
+	b, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("engine: unknown backend %q (registered: %v)", name, Backends())
+	}
+	return b.Open(path)
+}
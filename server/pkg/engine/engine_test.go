@@ -0,0 +1,54 @@
+package engine_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+)
+
+// noRangeDeletes is a transaction whose backend does not support range
+// deletes.
+type noRangeDeletes struct{ engine.Txn }
+
+func (noRangeDeletes) DeleteRange(start, end []byte) error { return engine.ErrUnsupported }
+
+func TestDeleteRangeFallsBackToDeletes(t *testing.T) {
+	e := memory.New()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := e.Put([]byte(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txn, err := e.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.DeleteRange(noRangeDeletes{txn}, []byte("b"), []byte("d")); err != nil {
+		t.Fatalf("DeleteRange: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	it, err := e.Scan(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var got []string
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(k))
+	}
+	if fmt.Sprint(got) != "[a d]" {
+		t.Errorf("keys %q remain, want [a d]", got)
+	}
+}
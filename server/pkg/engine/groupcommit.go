@@ -0,0 +1,139 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// Syncer is implemented by engines whose commits are not durable until
+// they are synced, so that the commits of concurrent transactions can be
+// made durable together.
+type Syncer interface {
+	// Sync makes the writes of the transactions committed so far
+	// durable.
+	Sync() error
+}
+
+// GroupCommit commits transactions and makes them durable, syncing the
+// commits of concurrent transactions together. The zero value is ready to
+// use.
+type GroupCommit struct {
+	// syncMu is held while a group syncs, and mu guards the group that
+	// commits join meanwhile, the delays and whether an asynchronous sync
+	// is pending.
+	syncMu sync.Mutex
+	mu     sync.Mutex
+	next   *commitGroup
+	delay  time.Duration
+	// writerDelay is how long after an asynchronous commit the engine is
+	// synced, and pending is set from then until it is.
+	writerDelay time.Duration
+	pending     bool
+}
+
+// commitGroup is commits that are made durable by one sync.
+type commitGroup struct {
+	size int
+	done chan struct{}
+	err  error
+}
+
+// DefaultWriterDelay is how long after an asynchronous commit the engine
+// is synced unless SetWriterDelay says otherwise, as PostgreSQL's
+// wal_writer_delay.
+const DefaultWriterDelay = 200 * time.Millisecond
+
+// SetDelay sets how long the first commit of a group waits for others to
+// join it before syncing, like PostgreSQL's commit_delay. Commits that
+// arrive while another group syncs join a group without it.
+func (g *GroupCommit) SetDelay(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.delay = d
+}
+
+// SetWriterDelay sets how long after an asynchronous commit the engine is
+// synced, like PostgreSQL's wal_writer_delay. The asynchronous commits
+// made meanwhile are synced together. 0 is DefaultWriterDelay.
+func (g *GroupCommit) SetWriterDelay(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writerDelay = d
+}
+
+// Commit commits txn of e and, if e is a Syncer, returns once its writes
+// are durable.
+func (g *GroupCommit) Commit(e Engine, txn Txn) error {
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	return g.Sync(e)
+}
+
+// CommitAsync commits txn of e without waiting for its writes to be
+// durable, as PostgreSQL commits with synchronous_commit off. If e is a
+// Syncer, it is synced in the background within the writer delay, so a
+// crash can lose the transactions committed since the last sync, but
+// never leaves one partly written.
+func (g *GroupCommit) CommitAsync(e Engine, txn Txn) error {
+	if err := txn.Commit(); err != nil {
+		return err
+	}
+	if _, ok := e.(Syncer); !ok {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.pending {
+		return nil
+	}
+	g.pending = true
+	delay := g.writerDelay
+	if delay <= 0 {
+		delay = DefaultWriterDelay
+	}
+	time.AfterFunc(delay, func() {
+		g.mu.Lock()
+		g.pending = false
+		g.mu.Unlock()
+		// There is no commit to report a failure to: the next
+		// synchronous commit or Sync reports it if it persists.
+		g.Sync(e)
+	})
+	return nil
+}
+
+// Sync makes the writes of the transactions of e committed so far durable
+// if e is a Syncer, syncing together with concurrent commits.
+func (g *GroupCommit) Sync(e Engine) error {
+	s, ok := e.(Syncer)
+	if !ok {
+		return nil
+	}
+	g.mu.Lock()
+	c := g.next
+	if c == nil {
+		c = &commitGroup{done: make(chan struct{})}
+		g.next = c
+	}
+	c.size++
+	leader, delay := c.size == 1, g.delay
+	g.mu.Unlock()
+	if !leader {
+		<-c.done
+		return c.err
+	}
+	// The first commit of the group syncs for all of it once the previous
+	// group has synced, which others join while it waits.
+	g.syncMu.Lock()
+	defer g.syncMu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	g.mu.Lock()
+	g.next = nil
+	g.mu.Unlock()
+	c.err = s.Sync()
+	close(c.done)
+	return c.err
+}
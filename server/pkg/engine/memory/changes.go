@@ -0,0 +1,130 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// maxChangeLogBytes bounds the size of the keys and values of the changes
+// an engine keeps for SubscribeChanges. Past it the oldest transactions
+// are dropped, and subscribing from before them fails.
+const maxChangeLogBytes = 64 << 20
+
+// changeLog is the log of the transactions an engine committed, oldest
+// first. It is guarded by the engine's mu.
+type changeLog struct {
+	txns  []engine.CommittedTxn
+	bytes int
+	// dropped is the sequence number of the last transaction dropped from
+	// the log, or 0 if none has been.
+	dropped uint64
+	// committed is closed, and replaced, when a transaction is added or
+	// the engine is closed, to wake the subscriptions waiting for one.
+	committed chan struct{}
+}
+
+func newChangeLog() changeLog {
+	return changeLog{committed: make(chan struct{})}
+}
+
+// add appends txn, dropping the oldest transactions if the log grows past
+// maxChangeLogBytes.
+func (l *changeLog) add(txn engine.CommittedTxn) {
+	l.txns = append(l.txns, txn)
+	l.bytes += changesSize(txn)
+	for l.bytes > maxChangeLogBytes && len(l.txns) > 1 {
+		l.bytes -= changesSize(l.txns[0])
+		l.dropped = l.txns[0].Seq
+		l.txns[0] = engine.CommittedTxn{}
+		l.txns = l.txns[1:]
+	}
+	close(l.committed)
+	l.committed = make(chan struct{})
+}
+
+// close drops the log and wakes the subscriptions, which then see the
+// engine closed.
+func (l *changeLog) close() {
+	l.txns, l.bytes = nil, 0
+	close(l.committed)
+	l.committed = make(chan struct{})
+}
+
+// after returns the transactions of the log committed after seq, and a
+// channel closed once another is added. The caller holds the engine's mu.
+func (l *changeLog) after(seq uint64) ([]engine.CommittedTxn, <-chan struct{}, error) {
+	if seq < l.dropped {
+		return nil, nil, engine.ErrChangesCompacted
+	}
+	i := sort.Search(len(l.txns), func(i int) bool { return l.txns[i].Seq > seq })
+	return l.txns[i:len(l.txns):len(l.txns)], l.committed, nil
+}
+
+func changesSize(txn engine.CommittedTxn) int {
+	n := 0
+	for _, c := range txn.Changes {
+		n += len(c.Key) + len(c.Value)
+	}
+	return n
+}
+
+// SubscribeChanges streams the transactions committed after sequence
+// number from, the commit timestamp of a transaction, as engine.ChangeFeed
+// describes. The engine keeps the changes of the transactions committed
+// since it was created up to maxChangeLogBytes, so 0 replays them all
+// until the oldest are dropped, after which it is ErrChangesCompacted like
+// any sequence number before them. The changes of a DeleteRange are the
+// deletes of the keys it deleted. The keys and values sent are shared
+// with the engine, and must not be modified.
+func (e *Engine) SubscribeChanges(ctx context.Context, from uint64) (<-chan engine.CommittedTxn, error) {
+	e.mu.RLock()
+	closed := e.closed
+	_, _, err := e.changes.after(from)
+	e.mu.RUnlock()
+	if closed {
+		return nil, engine.ErrClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan engine.CommittedTxn)
+	go func() {
+		defer close(ch)
+		seq := from
+		for {
+			e.mu.RLock()
+			closed := e.closed
+			txns, committed, err := e.changes.after(seq)
+			e.mu.RUnlock()
+			if closed {
+				return
+			}
+			if err != nil {
+				// A subscriber that fell behind the log has missed changes.
+				select {
+				case ch <- engine.CommittedTxn{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for _, txn := range txns {
+				select {
+				case ch <- txn:
+					seq = txn.Seq
+				case <-ctx.Done():
+					return
+				}
+			}
+			if len(txns) == 0 {
+				select {
+				case <-committed:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,463 @@
+// Package memory provides a pure-Go, in-memory engine.Engine. It is
+// registered as the "memory" backend and is used by tests and on platforms
+// where the native storage engine is unavailable.
+//
+// Data lives in a skiplist ordered by key. Each key carries a chain of
+// versions stamped with the commit timestamp that wrote them, so a
+// transaction reads a consistent snapshot as of its start and never blocks
+// writers. Commits use first-committer-wins: a transaction whose write set
+// overlaps a write committed after it began fails with engine.ErrConflict.
+// The changes of the latest commits are kept in a log, which
+// SubscribeChanges streams for read replicas.
+package memory
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+func init() {
+	engine.Register(engine.Backend{
+		Name:    "memory",
+		Open:    func(string) (engine.Engine, error) { return New(), nil },
+		Version: func() string { return "memory" },
+	})
+}
+
+// Engine is an in-memory multi-version key-value store.
+type Engine struct {
+	mu     sync.RWMutex
+	data   *skiplist
+	clock  uint64            // timestamp of the latest commit
+	active map[*Txn]struct{} // open transactions, for version pruning
+	closed bool
+	// changes is the log of the committed transactions SubscribeChanges
+	// streams.
+	changes changeLog
+}
+
+// New returns an empty engine.
+func New() *Engine {
+	return &Engine{data: newSkiplist(), active: make(map[*Txn]struct{}), changes: newChangeLog()}
+}
+
+// Begin starts a transaction reading the latest committed snapshot.
+func (e *Engine) Begin() (engine.Txn, error) {
+	return e.begin()
+}
+
+func (e *Engine) begin() (*Txn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return nil, engine.ErrClosed
+	}
+	t := &Txn{e: e, readTS: e.clock, writes: newSkiplist()}
+	e.active[t] = struct{}{}
+	return t, nil
+}
+
+// Get reads the latest committed value of key.
+func (e *Engine) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, engine.ErrEmptyKey
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return nil, engine.ErrClosed
+	}
+	return e.getAt(key, e.clock)
+}
+
+// getAt reads key as of ts. The caller holds e.mu.
+func (e *Engine) getAt(key []byte, ts uint64) ([]byte, error) {
+	n := e.data.get(key)
+	if n == nil {
+		return nil, engine.ErrNotFound
+	}
+	v := n.visible(ts)
+	if v == nil || v.deleted {
+		return nil, engine.ErrNotFound
+	}
+	return bytes.Clone(v.value), nil
+}
+
+// Scan returns an iterator over [start, end) of the latest committed
+// snapshot. The snapshot stays pinned until the iterator is closed.
+func (e *Engine) Scan(start, end []byte) (engine.Iterator, error) {
+	t, err := e.begin()
+	if err != nil {
+		return nil, err
+	}
+	it := t.scan(start, end)
+	it.owned = true
+	return it, nil
+}
+
+// Put writes key in its own transaction.
+func (e *Engine) Put(key, value []byte) error {
+	return engine.RunTxn(e, func(txn engine.Txn) error { return txn.Put(key, value) })
+}
+
+// Delete removes key in its own transaction.
+func (e *Engine) Delete(key []byte) error {
+	return engine.RunTxn(e, func(txn engine.Txn) error { return txn.Delete(key) })
+}
+
+// DeleteRange removes the keys in [start, end) in its own transaction.
+func (e *Engine) DeleteRange(start, end []byte) error {
+	return engine.RunTxn(e, func(txn engine.Txn) error { return txn.DeleteRange(start, end) })
+}
+
+// Close releases the engine's data.
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	e.data = newSkiplist()
+	e.active = make(map[*Txn]struct{})
+	e.changes.close()
+	return nil
+}
+
+// Space reports the space the keys in [start, end) take: the latest
+// committed versions that are not deletions are live, and the older
+// versions kept for open snapshots, until a write to the key or
+// CompactRange prunes them, and tombstones are dead.
+func (e *Engine) Space(start, end []byte) (engine.SpaceStats, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return engine.SpaceStats{}, engine.ErrClosed
+	}
+	var s engine.SpaceStats
+	for n := e.data.findGE(start, nil); n != nil && (end == nil || bytes.Compare(n.key, end) < 0); n = n.next[0] {
+		for v := n.versions; v != nil; v = v.next {
+			size := int64(len(n.key) + len(v.value))
+			if v == n.versions && !v.deleted {
+				s.LiveBytes += size
+			} else {
+				s.DeadBytes += size
+			}
+		}
+	}
+	return s, nil
+}
+
+// CompactRange prunes the versions of the keys in [start, end) that no
+// open transaction can read, as commits prune those of the keys they
+// write, and unlinks the keys only an old tombstone remains of.
+func (e *Engine) CompactRange(start, end []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return engine.ErrClosed
+	}
+	oldest := e.oldestSnapshot()
+	for n := e.data.findGE(start, nil); n != nil && (end == nil || bytes.Compare(n.key, end) < 0); n = n.next[0] {
+		e.prune(n, oldest)
+	}
+	return nil
+}
+
+// oldestSnapshot returns the oldest timestamp any open transaction can
+// read. Versions shadowed at that timestamp are unreachable. The caller
+// holds e.mu.
+func (e *Engine) oldestSnapshot() uint64 {
+	oldest := e.clock
+	for t := range e.active {
+		oldest = min(oldest, t.readTS)
+	}
+	return oldest
+}
+
+// prune drops versions of n that no snapshot at or after oldest can see,
+// and unlinks n once only an old tombstone remains. Unlinked nodes keep
+// their forward pointers so open iterators can step past them.
+func (e *Engine) prune(n *node, oldest uint64) {
+	for v := n.versions; v != nil; v = v.next {
+		if v.ts <= oldest {
+			v.next = nil
+			break
+		}
+	}
+	if v := n.versions; v.next == nil && v.deleted && v.ts <= oldest {
+		e.data.remove(n.key)
+	}
+}
+
+// Txn is a snapshot-isolated transaction on an Engine. It must not be used
+// from more than one goroutine at a time.
+type Txn struct {
+	e      *Engine
+	readTS uint64
+	// writes holds the transaction's own writes. Version timestamps there
+	// are statement sequence numbers: each Scan bumps seq so that the
+	// iterator does not observe writes made after it was opened.
+	writes *skiplist
+	seq    uint64
+	done   bool
+}
+
+// Get returns the transaction's own write for key if any, otherwise the
+// value in the transaction's snapshot.
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	if t.done {
+		return nil, engine.ErrTxnDone
+	}
+	if len(key) == 0 {
+		return nil, engine.ErrEmptyKey
+	}
+	if n := t.writes.get(key); n != nil {
+		if n.versions.deleted {
+			return nil, engine.ErrNotFound
+		}
+		return bytes.Clone(n.versions.value), nil
+	}
+	t.e.mu.RLock()
+	defer t.e.mu.RUnlock()
+	if t.e.closed {
+		return nil, engine.ErrClosed
+	}
+	return t.e.getAt(key, t.readTS)
+}
+
+// Scan returns an iterator over [start, end) that reflects the
+// transaction's writes as of the call.
+func (t *Txn) Scan(start, end []byte) (engine.Iterator, error) {
+	if t.done {
+		return nil, engine.ErrTxnDone
+	}
+	return t.scan(start, end), nil
+}
+
+func (t *Txn) scan(start, end []byte) *Iterator {
+	it := &Iterator{
+		txn:     t,
+		start:   bytes.Clone(start),
+		end:     bytes.Clone(end),
+		localTS: t.seq,
+	}
+	t.seq++
+	return it
+}
+
+// Put buffers a write.
+func (t *Txn) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return t.write(key, bytes.Clone(value), false)
+}
+
+// Delete buffers a delete.
+func (t *Txn) Delete(key []byte) error {
+	return t.write(key, nil, true)
+}
+
+// DeleteRange buffers a delete of each key in [start, end) that the
+// transaction sees. Like a Delete of each, it conflicts only with
+// concurrent writes to those keys.
+func (t *Txn) DeleteRange(start, end []byte) error {
+	if t.done {
+		return engine.ErrTxnDone
+	}
+	in := func(n *node) bool { return n != nil && (end == nil || bytes.Compare(n.key, end) < 0) }
+	var keys [][]byte
+	for n := t.writes.findGE(start, nil); in(n); n = n.next[0] {
+		keys = append(keys, n.key)
+	}
+	t.e.mu.RLock()
+	if t.e.closed {
+		t.e.mu.RUnlock()
+		return engine.ErrClosed
+	}
+	for n := t.e.data.findGE(start, nil); in(n); n = n.next[0] {
+		if v := n.visible(t.readTS); v != nil && !v.deleted {
+			keys = append(keys, n.key)
+		}
+	}
+	t.e.mu.RUnlock()
+	for _, k := range keys {
+		if err := t.write(k, nil, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Txn) write(key, value []byte, deleted bool) error {
+	if t.done {
+		return engine.ErrTxnDone
+	}
+	if len(key) == 0 {
+		return engine.ErrEmptyKey
+	}
+	n := t.writes.getOrInsert(key)
+	if v := n.versions; v != nil && v.ts == t.seq {
+		// No iterator has been opened since the last write to this key,
+		// so it can be overwritten in place.
+		v.value, v.deleted = value, deleted
+		return nil
+	}
+	n.versions = &version{ts: t.seq, value: value, deleted: deleted, next: n.versions}
+	return nil
+}
+
+// Commit checks the write set for conflicts and installs it at a new
+// commit timestamp.
+func (t *Txn) Commit() error {
+	if t.done {
+		return engine.ErrTxnDone
+	}
+	t.done = true
+	writes := t.writes
+	t.writes = nil
+	e := t.e
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.active, t)
+	if e.closed {
+		return engine.ErrClosed
+	}
+	if writes.count == 0 {
+		return nil
+	}
+	for w := writes.first(); w != nil; w = w.next[0] {
+		if n := e.data.get(w.key); n != nil && n.versions != nil && n.versions.ts > t.readTS {
+			return engine.ErrConflict
+		}
+	}
+	e.clock++
+	ts, oldest := e.clock, e.oldestSnapshot()
+	committed := engine.CommittedTxn{Seq: ts, Changes: make([]engine.Change, 0, writes.count)}
+	for w := writes.first(); w != nil; w = w.next[0] {
+		n := e.data.getOrInsert(w.key)
+		n.versions = &version{ts: ts, value: w.versions.value, deleted: w.versions.deleted, next: n.versions}
+		e.prune(n, oldest)
+		c := engine.Change{Kind: engine.ChangePut, Key: n.key, Value: w.versions.value}
+		if w.versions.deleted {
+			c = engine.Change{Kind: engine.ChangeDelete, Key: n.key}
+		}
+		committed.Changes = append(committed.Changes, c)
+	}
+	e.changes.add(committed)
+	return nil
+}
+
+// Abort discards the buffered writes.
+func (t *Txn) Abort() {
+	if t.done {
+		return
+	}
+	t.done = true
+	t.writes = nil
+	t.e.mu.Lock()
+	delete(t.e.active, t)
+	t.e.mu.Unlock()
+}
+
+// Iterator merges a transaction's own writes with its snapshot of the
+// committed data. It reads the skiplists incrementally rather than copying
+// the range up front.
+type Iterator struct {
+	txn        *Txn
+	start, end []byte
+	localTS    uint64
+	// owned iterators were opened by Engine.Scan and finish their implicit
+	// transaction on Close.
+	owned bool
+
+	started bool
+	local   *node // next candidate in txn.writes
+	shared  *node // next candidate in the committed data
+}
+
+// Next returns the next visible key-value pair.
+func (it *Iterator) Next() (key, value []byte, err error) {
+	if it.txn == nil || it.txn.writes == nil {
+		return nil, nil, engine.ErrTxnDone
+	}
+	e := it.txn.e
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return nil, nil, engine.ErrClosed
+	}
+	if !it.started {
+		it.started = true
+		it.local = it.txn.writes.findGE(it.start, nil)
+		it.shared = e.data.findGE(it.start, nil)
+	}
+	for {
+		if it.local != nil && it.past(it.local.key) {
+			it.local = nil
+		}
+		if it.shared != nil && it.past(it.shared.key) {
+			it.shared = nil
+		}
+		if it.local == nil && it.shared == nil {
+			return nil, nil, engine.ErrNotFound
+		}
+
+		var c int
+		switch {
+		case it.local == nil:
+			c = 1
+		case it.shared == nil:
+			c = -1
+		default:
+			c = bytes.Compare(it.local.key, it.shared.key)
+		}
+
+		if c <= 0 {
+			n := it.local
+			it.local = n.next[0]
+			v := n.visible(it.localTS)
+			if v == nil {
+				// Written after the iterator was opened; fall back to
+				// the snapshot for this key.
+				continue
+			}
+			if c == 0 {
+				it.shared = it.shared.next[0]
+			}
+			if v.deleted {
+				continue
+			}
+			return bytes.Clone(n.key), bytes.Clone(v.value), nil
+		}
+
+		n := it.shared
+		it.shared = n.next[0]
+		if v := n.visible(it.txn.readTS); v != nil && !v.deleted {
+			return bytes.Clone(n.key), bytes.Clone(v.value), nil
+		}
+	}
+}
+
+// Seek moves the iterator forward to the first key at or after key.
+func (it *Iterator) Seek(key []byte) error {
+	it.start = bytes.Clone(key)
+	it.started = false
+	return nil
+}
+
+// past reports whether key is at or beyond the end of the range.
+func (it *Iterator) past(key []byte) bool {
+	return it.end != nil && bytes.Compare(key, it.end) >= 0
+}
+
+// Close releases the iterator.
+func (it *Iterator) Close() {
+	if it.txn == nil {
+		return
+	}
+	if it.owned {
+		it.txn.Abort()
+	}
+	it.txn, it.local, it.shared = nil, nil, nil
+}
@@ -0,0 +1,357 @@
+package memory_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+)
+
+func begin(t *testing.T, e engine.Engine) engine.Txn {
+	t.Helper()
+	txn, err := e.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	return txn
+}
+
+func put(t *testing.T, w engine.Writer, key, value string) {
+	t.Helper()
+	if err := w.Put([]byte(key), []byte(value)); err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func commit(t *testing.T, txn engine.Txn) {
+	t.Helper()
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+}
+
+// get returns the value r reads at key, or "<missing>".
+func get(t *testing.T, r engine.Reader, key string) string {
+	t.Helper()
+	v, err := r.Get([]byte(key))
+	if errors.Is(err, engine.ErrNotFound) {
+		return "<missing>"
+	}
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	return string(v)
+}
+
+// scan returns the pairs r reads in [start, end) as "key=value".
+func scan(t *testing.T, r engine.Reader, start, end string) []string {
+	t.Helper()
+	var e []byte
+	if end != "" {
+		e = []byte(end)
+	}
+	it, err := r.Scan([]byte(start), e)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	defer it.Close()
+	var out []string
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		out = append(out, fmt.Sprintf("%s=%s", k, v))
+	}
+}
+
+func equal(t *testing.T, got, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	e := memory.New()
+	put(t, e, "a", "1")
+
+	reader := begin(t, e)
+	writer := begin(t, e)
+	put(t, writer, "a", "2")
+	put(t, writer, "b", "2")
+	commit(t, writer)
+
+	// The reader keeps the snapshot it began with.
+	if got := get(t, reader, "a"); got != "1" {
+		t.Errorf("reader sees a=%s, want 1", got)
+	}
+	if got := get(t, reader, "b"); got != "<missing>" {
+		t.Errorf("reader sees b=%s, want it missing", got)
+	}
+	equal(t, scan(t, reader, "", ""), []string{"a=1"})
+	commit(t, reader)
+
+	// A transaction begun after the commit sees it.
+	equal(t, scan(t, begin(t, e), "", ""), []string{"a=2", "b=2"})
+}
+
+func TestReadOwnWrites(t *testing.T) {
+	e := memory.New()
+	put(t, e, "a", "1")
+	put(t, e, "c", "3")
+
+	txn := begin(t, e)
+	put(t, txn, "b", "2")
+	if err := txn.Delete([]byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, txn, "b"); got != "2" {
+		t.Errorf("b=%s, want 2", got)
+	}
+	equal(t, scan(t, txn, "", ""), []string{"a=1", "b=2"})
+
+	// Others do not see the writes until they are committed.
+	equal(t, scan(t, e, "", ""), []string{"a=1", "c=3"})
+	commit(t, txn)
+	equal(t, scan(t, e, "", ""), []string{"a=1", "b=2"})
+}
+
+func TestFirstCommitterWins(t *testing.T) {
+	e := memory.New()
+	put(t, e, "k", "0")
+
+	t1 := begin(t, e)
+	t2 := begin(t, e)
+	put(t, t1, "k", "1")
+	put(t, t2, "k", "2")
+	commit(t, t1)
+	if err := t2.Commit(); !errors.Is(err, engine.ErrConflict) {
+		t.Fatalf("second commit: %v, want ErrConflict", err)
+	}
+	if got := get(t, e, "k"); got != "1" {
+		t.Errorf("k=%s, want the first committer's 1", got)
+	}
+
+	// Transactions writing different keys both commit.
+	t3 := begin(t, e)
+	t4 := begin(t, e)
+	put(t, t3, "x", "3")
+	put(t, t4, "y", "4")
+	commit(t, t3)
+	commit(t, t4)
+}
+
+func TestConflictWithDelete(t *testing.T) {
+	e := memory.New()
+	put(t, e, "k", "0")
+
+	t1 := begin(t, e)
+	t2 := begin(t, e)
+	if err := t1.Delete([]byte("k")); err != nil {
+		t.Fatal(err)
+	}
+	put(t, t2, "k", "2")
+	commit(t, t1)
+	if err := t2.Commit(); !errors.Is(err, engine.ErrConflict) {
+		t.Fatalf("commit after a concurrent delete: %v, want ErrConflict", err)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	e := memory.New()
+	txn := begin(t, e)
+	put(t, txn, "a", "1")
+	txn.Abort()
+	if got := get(t, e, "a"); got != "<missing>" {
+		t.Errorf("a=%s after abort, want it missing", got)
+	}
+	if err := txn.Put([]byte("b"), nil); !errors.Is(err, engine.ErrTxnDone) {
+		t.Errorf("Put after abort: %v, want ErrTxnDone", err)
+	}
+}
+
+func TestScanRange(t *testing.T) {
+	e := memory.New()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		put(t, e, k, k)
+	}
+	equal(t, scan(t, e, "b", "d"), []string{"b=b", "c=c"})
+	equal(t, scan(t, e, "c", ""), []string{"c=c", "d=d"})
+	equal(t, scan(t, e, "e", ""), nil)
+}
+
+func TestIteratorIgnoresLaterWrites(t *testing.T) {
+	e := memory.New()
+	put(t, e, "a", "1")
+	txn := begin(t, e)
+	it, err := txn.Scan([]byte("a"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	put(t, txn, "a", "2")
+	put(t, txn, "b", "2")
+	var got []string
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s=%s", k, v))
+	}
+	equal(t, got, []string{"a=1"})
+}
+
+func TestDeleteRange(t *testing.T) {
+	e := memory.New()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		put(t, e, k, k)
+	}
+	txn := begin(t, e)
+	put(t, txn, "bb", "bb")
+	if err := txn.DeleteRange([]byte("b"), []byte("d")); err != nil {
+		t.Fatal(err)
+	}
+	equal(t, scan(t, txn, "", ""), []string{"a=a", "d=d"})
+	commit(t, txn)
+	equal(t, scan(t, e, "", ""), []string{"a=a", "d=d"})
+}
+
+func TestVersionsKeptForOpenSnapshots(t *testing.T) {
+	e := memory.New()
+	put(t, e, "k", "1")
+	reader := begin(t, e)
+	for i := 2; i <= 5; i++ {
+		put(t, e, "k", fmt.Sprint(i))
+	}
+	if err := e.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, reader, "k"); got != "1" {
+		t.Errorf("reader sees k=%s after compaction, want 1", got)
+	}
+	reader.Abort()
+	if err := e.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	s, err := e.Space(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.DeadBytes != 0 {
+		t.Errorf("%d dead bytes once no snapshot reads them, want 0", s.DeadBytes)
+	}
+}
+
+// receive returns the next transaction of ch as its sequence number and
+// changes, "+key=value" for a put and "-key" for a delete.
+func receive(t *testing.T, ch <-chan engine.CommittedTxn) string {
+	t.Helper()
+	select {
+	case txn, ok := <-ch:
+		if !ok {
+			t.Fatal("the feed closed")
+		}
+		if txn.Err != nil {
+			t.Fatalf("the feed failed: %v", txn.Err)
+		}
+		s := fmt.Sprint(txn.Seq, ":")
+		for _, c := range txn.Changes {
+			switch c.Kind {
+			case engine.ChangePut:
+				s += fmt.Sprintf(" +%s=%s", c.Key, c.Value)
+			case engine.ChangeDelete:
+				s += fmt.Sprintf(" -%s", c.Key)
+			default:
+				t.Fatalf("change of kind %d", c.Kind)
+			}
+		}
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no transaction was received")
+		return ""
+	}
+}
+
+func TestSubscribeChanges(t *testing.T) {
+	e := memory.New()
+	put(t, e, "a", "1")
+	txn := begin(t, e)
+	put(t, txn, "c", "3")
+	put(t, txn, "b", "2")
+	commit(t, txn)
+	// Transactions that write nothing commit nothing.
+	commit(t, begin(t, e))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := e.SubscribeChanges(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The transactions committed before the subscription come first, with
+	// their changes in key order.
+	if got := receive(t, ch); got != "1: +a=1" {
+		t.Errorf("first transaction %s", got)
+	}
+	if got := receive(t, ch); got != "2: +b=2 +c=3" {
+		t.Errorf("second transaction %s", got)
+	}
+	// Then those committed after it, as they commit.
+	txn = begin(t, e)
+	put(t, txn, "a", "10")
+	if err := txn.DeleteRange([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	commit(t, txn)
+	if got := receive(t, ch); got != "3: +a=10 -b -c" {
+		t.Errorf("third transaction %s", got)
+	}
+
+	// A subscription from a sequence number starts after it.
+	later, err := e.SubscribeChanges(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, later); got != "3: +a=10 -b -c" {
+		t.Errorf("first transaction after 2: %s", got)
+	}
+
+	// The feed closes with its context.
+	cancel()
+	for range ch {
+	}
+}
+
+func TestSubscribeChangesClosed(t *testing.T) {
+	e := memory.New()
+	ch, err := e.SubscribeChanges(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("a transaction was received from a closed engine")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the feed stayed open after the engine closed")
+	}
+	if _, err := e.SubscribeChanges(context.Background(), 0); !errors.Is(err, engine.ErrClosed) {
+		t.Errorf("subscribing to a closed engine: %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,121 @@
+package memory
+
+import (
+	"bytes"
+	"math/rand/v2"
+)
+
+const maxHeight = 16
+
+// version is one value of a key. Versions of a key form a list ordered
+// from newest to oldest.
+type version struct {
+	// ts is the commit timestamp for committed versions, or the statement
+	// sequence number for a transaction's uncommitted writes.
+	ts      uint64
+	value   []byte
+	deleted bool
+	next    *version
+}
+
+// node is a key in the skiplist together with its version chain.
+type node struct {
+	key      []byte
+	versions *version
+	next     []*node
+}
+
+// visible returns the newest version with ts <= maxTS, or nil.
+func (n *node) visible(maxTS uint64) *version {
+	for v := n.versions; v != nil; v = v.next {
+		if v.ts <= maxTS {
+			return v
+		}
+	}
+	return nil
+}
+
+// skiplist is an ordered map from keys to version chains. It does no
+// locking of its own.
+type skiplist struct {
+	head   *node
+	height int
+	count  int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{head: &node{next: make([]*node, maxHeight)}, height: 1}
+}
+
+func randomHeight() int {
+	h := 1
+	for h < maxHeight && rand.IntN(4) == 0 {
+		h++
+	}
+	return h
+}
+
+// findGE returns the first node with key >= key and fills prev, when
+// non-nil, with the rightmost node before it at every level.
+func (s *skiplist) findGE(key []byte, prev []*node) *node {
+	x := s.head
+	for level := s.height - 1; level >= 0; level-- {
+		for next := x.next[level]; next != nil && bytes.Compare(next.key, key) < 0; next = x.next[level] {
+			x = next
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return x.next[0]
+}
+
+// get returns the node for key, or nil.
+func (s *skiplist) get(key []byte) *node {
+	n := s.findGE(key, nil)
+	if n != nil && bytes.Equal(n.key, key) {
+		return n
+	}
+	return nil
+}
+
+// getOrInsert returns the node for key, inserting an empty one if needed.
+func (s *skiplist) getOrInsert(key []byte) *node {
+	prev := make([]*node, maxHeight)
+	n := s.findGE(key, prev)
+	if n != nil && bytes.Equal(n.key, key) {
+		return n
+	}
+	h := randomHeight()
+	if h > s.height {
+		for level := s.height; level < h; level++ {
+			prev[level] = s.head
+		}
+		s.height = h
+	}
+	n = &node{key: bytes.Clone(key), next: make([]*node, h)}
+	for level := 0; level < h; level++ {
+		n.next[level] = prev[level].next[level]
+		prev[level].next[level] = n
+	}
+	s.count++
+	return n
+}
+
+// remove unlinks the node for key if present.
+func (s *skiplist) remove(key []byte) {
+	prev := make([]*node, maxHeight)
+	n := s.findGE(key, prev)
+	if n == nil || !bytes.Equal(n.key, key) {
+		return
+	}
+	for level := 0; level < len(n.next); level++ {
+		prev[level].next[level] = n.next[level]
+	}
+	s.count--
+}
+
+// first returns the first node, or nil.
+func (s *skiplist) first() *node {
+	return s.head.next[0]
+}
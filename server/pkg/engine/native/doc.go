@@ -0,0 +1,7 @@
+// Package native adapts the cgo bindings in package storage to the
+// engine.Engine interface and registers them as the "native" backend.
+//
+// The adapter is only compiled when cgo is enabled. Build with the
+// pgz_nonative tag to leave it out on platforms where the Zig library is
+// not available; engine.Default then falls back to the in-memory backend.
+package native
@@ -0,0 +1,396 @@
+//go:build cgo && !pgz_nonative
+
+package native
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/storage"
+)
+
+func init() {
+	engine.Register(engine.Backend{
+		Name:          "native",
+		Open:          Open,
+		OpenReadOnly:  OpenReadOnly,
+		Version:       storage.Version,
+		SegmentName:   storage.SegmentName,
+		Recover:       recoverLog,
+		OpenEncrypted: OpenEncrypted,
+		Reencrypt:     reencrypt,
+	})
+}
+
+// recoverLog is the Recover of the backend, which storage.Recover does.
+func recoverLog(path, segments string, target time.Time) error {
+	return translate(storage.Recover(path, segments, target))
+}
+
+// Engine wraps a storage.DB.
+type Engine struct {
+	db *storage.DB
+}
+
+// Open opens the Zig storage engine at path, or a new one in memory at
+// storage.MemoryPath.
+func Open(path string) (engine.Engine, error) {
+	db, err := storage.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{db: db}, nil
+}
+
+// OpenReadOnly opens the Zig storage engine at path without writing to
+// it.
+func OpenReadOnly(path string) (engine.Engine, error) {
+	db, err := storage.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{db: db}, nil
+}
+
+// OpenEncrypted opens the Zig storage engine at path, encrypted at rest
+// with key. It does not encrypt yet, and fails with engine.ErrUnsupported.
+func OpenEncrypted(path string, key []byte, readOnly bool) (engine.Engine, error) {
+	db, err := storage.OpenWithOptions(path, storage.OpenOptions{ReadOnly: readOnly, EncryptionKey: key})
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &Engine{db: db}, nil
+}
+
+// reencrypt is the Reencrypt of the backend, which storage.Reencrypt does.
+func reencrypt(path string, oldKey, newKey []byte) error {
+	return translate(storage.Reencrypt(path, oldKey, newKey))
+}
+
+// translate maps storage errors onto the engine sentinels.
+func translate(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return engine.ErrNotFound
+	case errors.Is(err, storage.ErrChangesCompacted):
+		return engine.ErrChangesCompacted
+	case errors.Is(err, storage.ErrClosed):
+		return engine.ErrClosed
+	case errors.Is(err, storage.ErrConflict):
+		return engine.ErrConflict
+	case errors.Is(err, storage.ErrUnsupported):
+		return engine.ErrUnsupported
+	}
+	return err
+}
+
+// Begin starts a transaction.
+func (e *Engine) Begin() (engine.Txn, error) {
+	txn, err := e.db.Begin()
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &Txn{txn: txn}, nil
+}
+
+// Get reads key in its own transaction.
+func (e *Engine) Get(key []byte) (val []byte, err error) {
+	err = engine.RunTxn(e, func(txn engine.Txn) error {
+		val, err = txn.Get(key)
+		return err
+	})
+	return val, err
+}
+
+// Scan returns an iterator in its own transaction, which is committed when
+// the iterator is closed.
+func (e *Engine) Scan(start, end []byte) (engine.Iterator, error) {
+	txn, err := e.db.Begin()
+	if err != nil {
+		return nil, translate(err)
+	}
+	it, err := txn.Scan(start, end)
+	if err != nil {
+		txn.Abort()
+		return nil, translate(err)
+	}
+	return &Iterator{it: it, txn: txn}, nil
+}
+
+// Put writes key in its own transaction.
+func (e *Engine) Put(key, value []byte) error {
+	return engine.RunTxn(e, func(txn engine.Txn) error {
+		return txn.Put(key, value)
+	})
+}
+
+// Delete removes key in its own transaction.
+func (e *Engine) Delete(key []byte) error {
+	return engine.RunTxn(e, func(txn engine.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// DeleteRange removes the keys in [start, end) in its own transaction.
+func (e *Engine) DeleteRange(start, end []byte) error {
+	return engine.RunTxn(e, func(txn engine.Txn) error {
+		return txn.DeleteRange(start, end)
+	})
+}
+
+// Sync makes the writes of committed transactions durable. Commits do not
+// wait for it, so that engine.GroupCommit can sync them together.
+func (e *Engine) Sync() error {
+	return e.db.Sync()
+}
+
+// Backup copies a consistent snapshot of the database into dir. The
+// storage engine cannot copy its files yet, so it fails with
+// engine.ErrUnsupported.
+func (e *Engine) Backup(dir string) error {
+	return translate(e.db.Backup(dir))
+}
+
+// Space reports the live and dead bytes of a key range. The storage
+// engine does not account them yet, so it fails with
+// engine.ErrUnsupported, which pgz_stat_table_space shows as NULL.
+func (e *Engine) Space(start, end []byte) (engine.SpaceStats, error) {
+	live, dead, err := e.db.Space(start, end)
+	if err != nil {
+		return engine.SpaceStats{}, translate(err)
+	}
+	return engine.SpaceStats{LiveBytes: int64(live), DeadBytes: int64(dead)}, nil
+}
+
+// CompactRange compacts the sstables and value log of a key range, or
+// fails with engine.ErrUnsupported while the database has no compactor.
+func (e *Engine) CompactRange(start, end []byte) error {
+	return translate(e.db.CompactRange(start, end))
+}
+
+// SetCompression sets the compression of the sstable blocks of the keys
+// starting with prefix. Blocks are not compressed yet, so any algorithm
+// but none fails with engine.ErrUnsupported.
+func (e *Engine) SetCompression(prefix []byte, c *engine.Compression) error {
+	if c == nil {
+		return translate(e.db.SetCompression(prefix, nil))
+	}
+	opts := &storage.CompressionOptions{DictionaryBytes: c.DictionaryBytes}
+	switch c.Algorithm {
+	case engine.CompressionNone:
+		opts.Algorithm = storage.CompressionNone
+	case engine.CompressionSnappy:
+		opts.Algorithm = storage.CompressionSnappy
+	case engine.CompressionZstd:
+		opts.Algorithm = storage.CompressionZstd
+	default:
+		return fmt.Errorf("unknown compression algorithm %q", c.Algorithm)
+	}
+	return translate(e.db.SetCompression(prefix, opts))
+}
+
+// VerifyChecksums checks the sstable blocks and value log records against
+// their checksums.
+func (e *Engine) VerifyChecksums(ctx context.Context) (engine.ChecksumStats, error) {
+	r, err := e.db.VerifyChecksums(ctx)
+	if err != nil {
+		return engine.ChecksumStats{}, err
+	}
+	s := engine.ChecksumStats{Checked: int64(r.Checked), Corrupt: int64(r.Corrupt)}
+	for _, c := range r.Corruptions {
+		s.Corruptions = append(s.Corruptions, engine.Corruption{
+			File: c.File, Offset: int64(c.Offset), StartKey: c.StartKey, EndKey: c.EndKey,
+		})
+	}
+	return s, nil
+}
+
+// StorageStats reports the statistics of the database, or fails with
+// engine.ErrUnsupported while it keeps none.
+func (e *Engine) StorageStats() (engine.StorageStats, error) {
+	s, err := e.db.Stats()
+	if err != nil {
+		return engine.StorageStats{}, translate(err)
+	}
+	return engine.StorageStats{
+		Keys:                   int64(s.KeyCount),
+		DataBytes:              int64(s.DataBytes),
+		LiveBytes:              int64(s.LiveBytes),
+		DeadBytes:              int64(s.DeadBytes),
+		Compacting:             s.Compacting,
+		CompactionPendingBytes: int64(s.CompactionPendingBytes),
+		BlockCacheHits:         int64(s.BlockCacheHits),
+		BlockCacheMisses:       int64(s.BlockCacheMisses),
+		PendingWALBytes:        int64(s.PendingWALBytes),
+		UncompressedBytes:      int64(s.UncompressedBytes),
+		CompressedBytes:        int64(s.CompressedBytes),
+	}, nil
+}
+
+// SetArchiveFunc makes the database hand each full log segment to fn.
+func (e *Engine) SetArchiveFunc(fn func(path string, segment uint64) error) {
+	e.db.SetArchiveFunc(fn)
+}
+
+// SubscribeChanges streams the transactions committed after sequence
+// number from, as the database's change log keeps them.
+func (e *Engine) SubscribeChanges(ctx context.Context, from uint64) (<-chan engine.CommittedTxn, error) {
+	events, err := e.db.SubscribeChanges(ctx, from)
+	if err != nil {
+		return nil, translate(err)
+	}
+	ch := make(chan engine.CommittedTxn)
+	go func() {
+		defer close(ch)
+		for ev := range events {
+			txn := engine.CommittedTxn{Seq: ev.Seq, Err: translate(ev.Err)}
+			for _, c := range ev.Changes {
+				txn.Changes = append(txn.Changes, engine.Change{Kind: changeKind(c.Kind), Key: c.Key, Value: c.Value})
+			}
+			select {
+			case ch <- txn:
+			case <-ctx.Done():
+				// The subscription sees ctx too, and stops.
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func changeKind(k storage.ChangeKind) engine.ChangeKind {
+	switch k {
+	case storage.ChangeDelete:
+		return engine.ChangeDelete
+	case storage.ChangeDeleteRange:
+		return engine.ChangeDeleteRange
+	}
+	return engine.ChangePut
+}
+
+// Close closes the database.
+func (e *Engine) Close() error {
+	return e.db.Close()
+}
+
+// Txn wraps a storage.Txn.
+type Txn struct {
+	txn *storage.Txn
+}
+
+// Get retrieves a value by key.
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	v, err := t.txn.Get(key)
+	return v, translate(err)
+}
+
+// GetMany reads keys in one call into the storage engine.
+func (t *Txn) GetMany(keys [][]byte) ([][]byte, error) {
+	var c storage.Commands
+	for _, key := range keys {
+		c.Get(key)
+	}
+	results, err := t.txn.Execute(&c)
+	if err != nil {
+		return nil, translate(err)
+	}
+	vals := make([][]byte, len(keys))
+	for i, r := range results {
+		if r.Found {
+			vals[i] = r.Value
+		}
+	}
+	return vals, nil
+}
+
+// Scan creates an iterator for the key range [start, end).
+func (t *Txn) Scan(start, end []byte) (engine.Iterator, error) {
+	it, err := t.txn.Scan(start, end)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &Iterator{it: it}, nil
+}
+
+// Put stores a key-value pair.
+func (t *Txn) Put(key, value []byte) error {
+	return translate(t.txn.Put(key, value))
+}
+
+// Delete removes a key.
+func (t *Txn) Delete(key []byte) error {
+	return translate(t.txn.Delete(key))
+}
+
+// DeleteRange removes the keys in [start, end).
+func (t *Txn) DeleteRange(start, end []byte) error {
+	return translate(t.txn.DeleteRange(start, end))
+}
+
+// WriteBatch applies writes in one call into the storage engine.
+func (t *Txn) WriteBatch(writes []engine.Write) error {
+	var b storage.Batch
+	for _, w := range writes {
+		if w.Delete {
+			b.Delete(w.Key)
+		} else {
+			b.Put(w.Key, w.Value)
+		}
+	}
+	return translate(t.txn.Write(&b))
+}
+
+// Commit commits the transaction.
+func (t *Txn) Commit() error {
+	return translate(t.txn.Commit())
+}
+
+// Abort aborts the transaction.
+func (t *Txn) Abort() {
+	t.txn.Abort()
+}
+
+// readAheadMin and readAheadMax bound how many pairs an Iterator reads
+// ahead in one call into the storage engine. It starts small, so that a
+// scan stopped early reads little ahead, and doubles with each call.
+const (
+	readAheadMin = 16
+	readAheadMax = 1024
+)
+
+// Iterator wraps a storage.Iterator, reading its pairs ahead in batches.
+// When the iterator was opened outside an explicit transaction, txn is the
+// implicit one to finish on Close.
+type Iterator struct {
+	it  *storage.Iterator
+	txn *storage.Txn
+	// keys and values are the pairs read ahead, and n how many the next
+	// call reads.
+	keys, values [][]byte
+	n            int
+}
+
+// Next returns the next key-value pair.
+func (i *Iterator) Next() (key, value []byte, err error) {
+	if len(i.keys) == 0 {
+		i.n = min(max(i.n*2, readAheadMin), readAheadMax)
+		if i.keys, i.values, err = i.it.NextN(i.n); err != nil {
+			return nil, nil, translate(err)
+		}
+	}
+	key, value = i.keys[0], i.values[0]
+	i.keys, i.values = i.keys[1:], i.values[1:]
+	return key, value, nil
+}
+
+// Close closes the iterator.
+func (i *Iterator) Close() {
+	i.it.Close()
+	if i.txn != nil {
+		_ = i.txn.Commit()
+		i.txn = nil
+	}
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Prefixed is an engine whose keys are stored under a prefix of the keys
+// of another engine, so that several keyspaces share one engine. The keys
+// its shared function reports are stored as they are, in the keyspace all
+// of them share; a scan is of that keyspace if its start key is shared.
+type Prefixed struct {
+	e      Engine
+	prefix []byte
+	shared func(key []byte) bool
+}
+
+// NewPrefixed returns the keyspace of e under prefix. shared may be nil if
+// no keys are shared.
+func NewPrefixed(e Engine, prefix []byte, shared func(key []byte) bool) *Prefixed {
+	if shared == nil {
+		shared = func([]byte) bool { return false }
+	}
+	return &Prefixed{e: e, prefix: bytes.Clone(prefix), shared: shared}
+}
+
+// Begin starts a transaction of the underlying engine, which reads and
+// writes the keyspace.
+func (p *Prefixed) Begin() (Txn, error) {
+	txn, err := p.e.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &prefixedTxn{p: p, txn: txn}, nil
+}
+
+func (p *Prefixed) Get(key []byte) ([]byte, error) {
+	return p.e.Get(p.key(key))
+}
+
+func (p *Prefixed) Scan(start, end []byte) (Iterator, error) {
+	return p.scan(p.e, start, end)
+}
+
+func (p *Prefixed) Put(key, value []byte) error {
+	return p.e.Put(p.key(key), value)
+}
+
+func (p *Prefixed) Delete(key []byte) error {
+	return p.e.Delete(p.key(key))
+}
+
+func (p *Prefixed) DeleteRange(start, end []byte) error {
+	start, end = p.span(start, end)
+	return p.e.DeleteRange(start, end)
+}
+
+// Space reports the space of the keyspace's keys in [start, end), or
+// errors.ErrUnsupported if the underlying engine is not a SpaceReporter.
+func (p *Prefixed) Space(start, end []byte) (SpaceStats, error) {
+	r, ok := p.e.(SpaceReporter)
+	if !ok {
+		return SpaceStats{}, errors.ErrUnsupported
+	}
+	start, end = p.span(start, end)
+	return r.Space(start, end)
+}
+
+// CompactRange compacts the keyspace's keys in [start, end). It does
+// nothing if the underlying engine is not a Compacter.
+func (p *Prefixed) CompactRange(start, end []byte) error {
+	c, ok := p.e.(Compacter)
+	if !ok {
+		return nil
+	}
+	start, end = p.span(start, end)
+	return c.CompactRange(start, end)
+}
+
+// SetCompression sets the compression of the keyspace's keys starting
+// with prefix. It does nothing if the underlying engine is not a
+// Compressor.
+func (p *Prefixed) SetCompression(prefix []byte, c *Compression) error {
+	x, ok := p.e.(Compressor)
+	if !ok {
+		return nil
+	}
+	prefix, _ = p.span(prefix, nil)
+	return x.SetCompression(prefix, c)
+}
+
+// Close does nothing: the underlying engine is closed by its owner.
+func (p *Prefixed) Close() error {
+	return nil
+}
+
+// key returns the key of the underlying engine that key is stored at.
+func (p *Prefixed) key(key []byte) []byte {
+	if len(key) == 0 || p.shared(key) {
+		return key
+	}
+	return append(bytes.Clone(p.prefix), key...)
+}
+
+// span returns the span of the underlying engine [start, end) covers. A
+// nil start or end is the start or end of the keyspace.
+func (p *Prefixed) span(start, end []byte) ([]byte, []byte) {
+	if len(start) > 0 && p.shared(start) {
+		return start, end
+	}
+	start = append(bytes.Clone(p.prefix), start...)
+	if end == nil {
+		end = prefixEnd(p.prefix)
+	} else {
+		end = append(bytes.Clone(p.prefix), end...)
+	}
+	return start, end
+}
+
+func (p *Prefixed) scan(r Reader, start, end []byte) (Iterator, error) {
+	trim := len(start) == 0 || !p.shared(start)
+	start, end = p.span(start, end)
+	it, err := r.Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	if !trim {
+		return it, nil
+	}
+	return &prefixedIterator{it: it, p: p, r: r, end: end}, nil
+}
+
+// prefixEnd returns the first key after every key that starts with prefix,
+// or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// prefixedTxn is a transaction of a Prefixed engine.
+type prefixedTxn struct {
+	p   *Prefixed
+	txn Txn
+}
+
+func (t *prefixedTxn) Get(key []byte) ([]byte, error) {
+	return t.txn.Get(t.p.key(key))
+}
+
+// GetMany reads keys in one call if the underlying transaction is a
+// MultiGetter.
+func (t *prefixedTxn) GetMany(keys [][]byte) ([][]byte, error) {
+	mapped := make([][]byte, len(keys))
+	for i, key := range keys {
+		mapped[i] = t.p.key(key)
+	}
+	return GetMany(t.txn, mapped)
+}
+
+func (t *prefixedTxn) Scan(start, end []byte) (Iterator, error) {
+	return t.p.scan(t.txn, start, end)
+}
+
+func (t *prefixedTxn) Put(key, value []byte) error {
+	return t.txn.Put(t.p.key(key), value)
+}
+
+func (t *prefixedTxn) Delete(key []byte) error {
+	return t.txn.Delete(t.p.key(key))
+}
+
+func (t *prefixedTxn) DeleteRange(start, end []byte) error {
+	start, end = t.p.span(start, end)
+	return t.txn.DeleteRange(start, end)
+}
+
+// WriteBatch applies writes in one call if the underlying transaction is a
+// Batcher.
+func (t *prefixedTxn) WriteBatch(writes []Write) error {
+	if bt, ok := t.txn.(Batcher); ok {
+		mapped := make([]Write, len(writes))
+		for i, w := range writes {
+			mapped[i] = Write{Key: t.p.key(w.Key), Value: w.Value, Delete: w.Delete}
+		}
+		return bt.WriteBatch(mapped)
+	}
+	for _, w := range writes {
+		var err error
+		if w.Delete {
+			err = t.Delete(w.Key)
+		} else {
+			err = t.Put(w.Key, w.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *prefixedTxn) Commit() error {
+	return t.txn.Commit()
+}
+
+func (t *prefixedTxn) Abort() {
+	t.txn.Abort()
+}
+
+// prefixedIterator walks a span of a keyspace, returning its keys without
+// the prefix.
+type prefixedIterator struct {
+	it  Iterator
+	p   *Prefixed
+	r   Reader
+	end []byte
+}
+
+func (i *prefixedIterator) Next() (key, value []byte, err error) {
+	key, value, err = i.it.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	return key[len(i.p.prefix):], value, nil
+}
+
+// Seek moves the iterator to key of the keyspace.
+func (i *prefixedIterator) Seek(key []byte) error {
+	var err error
+	i.it, err = Seek(i.r, i.it, append(bytes.Clone(i.p.prefix), key...), i.end)
+	return err
+}
+
+func (i *prefixedIterator) Close() {
+	i.it.Close()
+}
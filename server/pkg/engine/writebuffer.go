@@ -0,0 +1,282 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/trace"
+)
+
+// Write is a Put of Value at Key, or a Delete of Key if Delete is set.
+type Write struct {
+	Key, Value []byte
+	Delete     bool
+}
+
+// Batcher is implemented by transactions that can apply many writes in
+// one call, which saves the cost of a call into the backend per write.
+type Batcher interface {
+	// WriteBatch applies writes in order.
+	WriteBatch(writes []Write) error
+}
+
+// maxBufferedBytes bounds the size of the keys and values a WriteBuffer
+// holds before it flushes them.
+const maxBufferedBytes = 1 << 20
+
+// WriteBuffer is a transaction whose Puts and Deletes are held in memory
+// until they are flushed to the underlying transaction, in one call if it
+// is a Batcher. Reads observe the buffered writes: Get looks them up, and
+// Scan merges them into the scan of the underlying transaction. Like a
+// transaction's own writes, the writes buffered after a Scan are not seen
+// by its iterator. DeleteRange flushes the buffered writes first.
+type WriteBuffer struct {
+	// Trace, if set, is the span each flush is traced within.
+	Trace *trace.Span
+	// Written is the size of the keys and values written through the
+	// buffer, which starts from the size of the transaction's earlier
+	// writes if it is set to that. If MaxBytes is positive, a write that
+	// takes Written past it fails with ErrTxnTooLarge, so that a runaway
+	// statement cannot make the transaction hold more writes in memory.
+	Written, MaxBytes int64
+
+	txn    Txn
+	writes []Write
+	// last indexes the last write of each key in writes, and keys are
+	// those keys in order.
+	last  map[string]int
+	keys  []string
+	bytes int
+}
+
+// NewWriteBuffer returns a buffer of writes to txn.
+func NewWriteBuffer(txn Txn) *WriteBuffer {
+	return &WriteBuffer{txn: txn, last: make(map[string]int)}
+}
+
+// Get returns the buffered write of key if there is one, otherwise the
+// value txn reads.
+func (b *WriteBuffer) Get(key []byte) ([]byte, error) {
+	if i, ok := b.last[string(key)]; ok {
+		if b.writes[i].Delete {
+			return nil, ErrNotFound
+		}
+		return bytes.Clone(b.writes[i].Value), nil
+	}
+	return b.txn.Get(key)
+}
+
+// GetMany returns the buffered writes of keys, reading the others from txn
+// in one call if it is a MultiGetter.
+func (b *WriteBuffer) GetMany(keys [][]byte) ([][]byte, error) {
+	vals := make([][]byte, len(keys))
+	var missed [][]byte
+	var at []int
+	for i, key := range keys {
+		j, ok := b.last[string(key)]
+		switch {
+		case !ok:
+			missed = append(missed, key)
+			at = append(at, i)
+		case !b.writes[j].Delete:
+			vals[i] = append([]byte{}, b.writes[j].Value...)
+		}
+	}
+	if len(missed) == 0 {
+		return vals, nil
+	}
+	read, err := GetMany(b.txn, missed)
+	if err != nil {
+		return nil, err
+	}
+	for k, i := range at {
+		vals[i] = read[k]
+	}
+	return vals, nil
+}
+
+// Scan returns an iterator over [start, end) of txn with the buffered
+// writes applied.
+func (b *WriteBuffer) Scan(start, end []byte) (Iterator, error) {
+	it, err := b.txn.Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.keys) == 0 {
+		return it, nil
+	}
+	i, _ := slices.BinarySearch(b.keys, string(start))
+	var writes []Write
+	for _, k := range b.keys[i:] {
+		if end != nil && k >= string(end) {
+			break
+		}
+		writes = append(writes, b.writes[b.last[k]])
+	}
+	if len(writes) == 0 {
+		return it, nil
+	}
+	return &overlayIterator{txn: b.txn, it: it, all: writes, writes: writes, end: bytes.Clone(end)}, nil
+}
+
+// Put buffers a write of value at key.
+func (b *WriteBuffer) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	return b.add(Write{Key: bytes.Clone(key), Value: bytes.Clone(value)})
+}
+
+// Delete buffers a delete of key.
+func (b *WriteBuffer) Delete(key []byte) error {
+	return b.add(Write{Key: bytes.Clone(key), Delete: true})
+}
+
+func (b *WriteBuffer) add(w Write) error {
+	if len(w.Key) == 0 {
+		return ErrEmptyKey
+	}
+	n := int64(len(w.Key) + len(w.Value))
+	if b.MaxBytes > 0 && b.Written+n > b.MaxBytes {
+		return ErrTxnTooLarge
+	}
+	b.Written += n
+	k := string(w.Key)
+	if _, ok := b.last[k]; !ok {
+		i, _ := slices.BinarySearch(b.keys, k)
+		b.keys = slices.Insert(b.keys, i, k)
+	}
+	b.last[k] = len(b.writes)
+	b.writes = append(b.writes, w)
+	b.bytes += len(w.Key) + len(w.Value)
+	if b.bytes >= maxBufferedBytes {
+		return b.Flush()
+	}
+	return nil
+}
+
+// DeleteRange flushes the buffered writes and deletes the keys in
+// [start, end) of txn, one by one if its backend has no range deletes.
+func (b *WriteBuffer) DeleteRange(start, end []byte) error {
+	if err := b.Flush(); err != nil {
+		return err
+	}
+	return DeleteRange(b.txn, start, end)
+}
+
+// Flush applies the buffered writes to txn.
+func (b *WriteBuffer) Flush() error {
+	if len(b.writes) == 0 {
+		return nil
+	}
+	writes := b.writes
+	b.writes, b.keys, b.bytes = nil, nil, 0
+	clear(b.last)
+	span := b.Trace.Child("pgz.storage.write_batch", slog.Int("pgz.writes", len(writes)))
+	err := b.apply(writes)
+	span.Finish(err)
+	return err
+}
+
+func (b *WriteBuffer) apply(writes []Write) error {
+	if bt, ok := b.txn.(Batcher); ok {
+		return bt.WriteBatch(writes)
+	}
+	for _, w := range writes {
+		var err error
+		if w.Delete {
+			err = b.txn.Delete(w.Key)
+		} else {
+			err = b.txn.Put(w.Key, w.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Commit flushes the buffered writes and commits txn.
+func (b *WriteBuffer) Commit() error {
+	if err := b.Flush(); err != nil {
+		b.txn.Abort()
+		return err
+	}
+	return b.txn.Commit()
+}
+
+// Abort discards the buffered writes and aborts txn.
+func (b *WriteBuffer) Abort() {
+	b.writes, b.keys, b.bytes = nil, nil, 0
+	clear(b.last)
+	b.txn.Abort()
+}
+
+// overlayIterator merges the buffered writes of a WriteBuffer, as of the
+// Scan that opened it, into an iterator of the underlying transaction.
+type overlayIterator struct {
+	txn Txn
+	it  Iterator
+	// all are the last buffered writes of the keys in range, in key
+	// order, and writes those not yet passed. A write shadows the entry of
+	// its key in it.
+	all, writes []Write
+	end         []byte
+	// key and value are the next entry of it, if next is set, and done is
+	// set once it is exhausted.
+	key, value []byte
+	next, done bool
+}
+
+func (o *overlayIterator) Next() (key, value []byte, err error) {
+	for {
+		if !o.next && !o.done {
+			o.key, o.value, err = o.it.Next()
+			switch {
+			case errors.Is(err, ErrNotFound):
+				o.done = true
+			case err != nil:
+				return nil, nil, err
+			default:
+				o.next = true
+			}
+		}
+		if len(o.writes) == 0 || o.next && bytes.Compare(o.key, o.writes[0].Key) < 0 {
+			if !o.next {
+				return nil, nil, ErrNotFound
+			}
+			o.next = false
+			return o.key, o.value, nil
+		}
+		w := o.writes[0]
+		o.writes = o.writes[1:]
+		if o.next && bytes.Equal(o.key, w.Key) {
+			o.next = false
+		}
+		if !w.Delete {
+			return w.Key, w.Value, nil
+		}
+	}
+}
+
+// Seek moves the iterator to key, seeking the underlying iterator.
+func (o *overlayIterator) Seek(key []byte) error {
+	i, _ := slices.BinarySearchFunc(o.all, key, func(w Write, key []byte) int { return bytes.Compare(w.Key, key) })
+	o.writes = o.all[i:]
+	if o.next && bytes.Compare(o.key, key) >= 0 {
+		return nil
+	}
+	o.next = false
+	if o.done {
+		return nil
+	}
+	var err error
+	o.it, err = Seek(o.txn, o.it, key, o.end)
+	return err
+}
+
+func (o *overlayIterator) Close() {
+	o.it.Close()
+}
@@ -0,0 +1,228 @@
+package engine_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+)
+
+// newBuffer returns a buffer of a transaction of a memory engine holding
+// the keys given, each with itself as its value.
+func newBuffer(t *testing.T, keys ...string) *engine.WriteBuffer {
+	t.Helper()
+	e := memory.New()
+	for _, k := range keys {
+		if err := e.Put([]byte(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txn, err := e.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(txn.Abort)
+	return engine.NewWriteBuffer(txn)
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read returns the value r reads at key, or "<missing>".
+func read(t *testing.T, r engine.Reader, key string) string {
+	t.Helper()
+	v, err := r.Get([]byte(key))
+	if errors.Is(err, engine.ErrNotFound) {
+		return "<missing>"
+	}
+	mustDo(t, err)
+	return string(v)
+}
+
+// next returns the next pair of it as "key=value", or "<end>".
+func next(t *testing.T, it engine.Iterator) string {
+	t.Helper()
+	k, v, err := it.Next()
+	if errors.Is(err, engine.ErrNotFound) {
+		return "<end>"
+	}
+	mustDo(t, err)
+	return fmt.Sprintf("%s=%s", k, v)
+}
+
+// drain returns the pairs it has left as "key=value".
+func drain(t *testing.T, it engine.Iterator) []string {
+	t.Helper()
+	var out []string
+	for p := next(t, it); p != "<end>"; p = next(t, it) {
+		out = append(out, p)
+	}
+	return out
+}
+
+// scanAll returns the pairs r reads in [start, end) as "key=value".
+func scanAll(t *testing.T, r engine.Reader, start, end string) []string {
+	t.Helper()
+	var e []byte
+	if end != "" {
+		e = []byte(end)
+	}
+	it, err := r.Scan([]byte(start), e)
+	mustDo(t, err)
+	defer it.Close()
+	return drain(t, it)
+}
+
+func expect(t *testing.T, got, want []string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteBufferShadowsTxn(t *testing.T) {
+	b := newBuffer(t, "a", "b", "c")
+	mustDo(t, b.Put([]byte("b"), []byte("b1")))
+	mustDo(t, b.Delete([]byte("c")))
+	mustDo(t, b.Put([]byte("d"), []byte("d1")))
+	// The last write of a key wins.
+	mustDo(t, b.Put([]byte("e"), []byte("e1")))
+	mustDo(t, b.Delete([]byte("e")))
+	mustDo(t, b.Put([]byte("e"), []byte("e2")))
+
+	for k, want := range map[string]string{"a": "a", "b": "b1", "c": "<missing>", "d": "d1", "e": "e2"} {
+		if got := read(t, b, k); got != want {
+			t.Errorf("Get(%q) = %s, want %s", k, got, want)
+		}
+	}
+	vals, err := b.GetMany([][]byte{[]byte("c"), []byte("a"), []byte("b"), []byte("z")})
+	mustDo(t, err)
+	if fmt.Sprintf("%q", vals) != `["" "a" "b1" ""]` || vals[0] != nil || vals[3] != nil {
+		t.Errorf("GetMany = %q, want [nil a b1 nil]", vals)
+	}
+	expect(t, scanAll(t, b, "", ""), []string{"a=a", "b=b1", "d=d1", "e=e2"})
+
+	// Flushing leaves what the transaction reads unchanged.
+	mustDo(t, b.Flush())
+	expect(t, scanAll(t, b, "", ""), []string{"a=a", "b=b1", "d=d1", "e=e2"})
+}
+
+func TestWriteBufferDeleteThenRead(t *testing.T) {
+	b := newBuffer(t, "a", "b")
+	mustDo(t, b.Delete([]byte("a")))
+	if got := read(t, b, "a"); got != "<missing>" {
+		t.Errorf("a=%s after its delete, want it missing", got)
+	}
+	expect(t, scanAll(t, b, "", ""), []string{"b=b"})
+	expect(t, scanAll(t, b, "a", "b"), nil)
+
+	// A put after the delete brings the key back.
+	mustDo(t, b.Put([]byte("a"), []byte("a1")))
+	if got := read(t, b, "a"); got != "a1" {
+		t.Errorf("a=%s after a put that follows its delete, want a1", got)
+	}
+
+	// Deleting a key only the buffer holds leaves nothing to read.
+	mustDo(t, b.Put([]byte("c"), []byte("c1")))
+	mustDo(t, b.Delete([]byte("c")))
+	if got := read(t, b, "c"); got != "<missing>" {
+		t.Errorf("c=%s, want it missing", got)
+	}
+	expect(t, scanAll(t, b, "", ""), []string{"a=a1", "b=b"})
+}
+
+func TestWriteBufferOverlappingScans(t *testing.T) {
+	b := newBuffer(t, "a", "b", "c", "d", "e", "f")
+	mustDo(t, b.Put([]byte("b"), []byte("b1")))
+	mustDo(t, b.Put([]byte("cc"), []byte("cc")))
+	mustDo(t, b.Delete([]byte("d")))
+	mustDo(t, b.Put([]byte("g"), []byte("g")))
+
+	it1, err := b.Scan([]byte("b"), []byte("e"))
+	mustDo(t, err)
+	defer it1.Close()
+	it2, err := b.Scan([]byte("c"), nil)
+	mustDo(t, err)
+	defer it2.Close()
+
+	// Stepping the iterators in turn, each sees its own range.
+	var got1, got2 []string
+	for i := 0; i < 4; i++ {
+		got1 = append(got1, next(t, it1))
+		got2 = append(got2, next(t, it2))
+	}
+	got1 = append(got1, drain(t, it1)...)
+	got2 = append(got2, drain(t, it2)...)
+	expect(t, got1, []string{"b=b1", "c=c", "cc=cc", "<end>"})
+	expect(t, got2, []string{"c=c", "cc=cc", "e=e", "f=f", "g=g"})
+}
+
+func TestWriteBufferScanIgnoresLaterWrites(t *testing.T) {
+	b := newBuffer(t, "a", "c", "e")
+	mustDo(t, b.Put([]byte("b"), []byte("b")))
+	it, err := b.Scan(nil, nil)
+	mustDo(t, err)
+	defer it.Close()
+	if got := next(t, it); got != "a=a" {
+		t.Fatalf("first pair %s, want a=a", got)
+	}
+
+	// Writes buffered, or flushed, after the Scan are not seen by its
+	// iterator, as the transaction's own are not.
+	mustDo(t, b.Put([]byte("b"), []byte("b1")))
+	mustDo(t, b.Delete([]byte("c")))
+	mustDo(t, b.Put([]byte("d"), []byte("d")))
+	mustDo(t, b.Flush())
+	mustDo(t, b.Put([]byte("f"), []byte("f")))
+	expect(t, drain(t, it), []string{"b=b", "c=c", "e=e"})
+
+	// A new Scan sees them.
+	expect(t, scanAll(t, b, "", ""), []string{"a=a", "b=b1", "d=d", "e=e", "f=f"})
+}
+
+func TestWriteBufferSeek(t *testing.T) {
+	b := newBuffer(t, "a", "c", "e", "g", "i")
+	mustDo(t, b.Put([]byte("b"), []byte("b")))
+	mustDo(t, b.Delete([]byte("e")))
+	mustDo(t, b.Put([]byte("f"), []byte("f")))
+	mustDo(t, b.Put([]byte("g"), []byte("g1")))
+
+	it, err := b.Scan(nil, []byte("i"))
+	mustDo(t, err)
+	defer it.Close()
+	s, ok := it.(engine.Seeker)
+	if !ok {
+		t.Fatal("the iterator of a buffer with writes is not a Seeker")
+	}
+	if got := next(t, it); got != "a=a" {
+		t.Fatalf("first pair %s, want a=a", got)
+	}
+	// Seeking to a key the buffer deletes lands on the next one left.
+	mustDo(t, s.Seek([]byte("e")))
+	if got := next(t, it); got != "f=f" {
+		t.Errorf("after Seek(e): %s, want f=f", got)
+	}
+	// Seeking to a key the buffer overwrites returns the buffered value.
+	mustDo(t, s.Seek([]byte("g")))
+	if got := next(t, it); got != "g=g1" {
+		t.Errorf("after Seek(g): %s, want g=g1", got)
+	}
+	mustDo(t, s.Seek([]byte("h")))
+	if got := next(t, it); got != "<end>" {
+		t.Errorf("after Seek(h): %s, want the end", got)
+	}
+}
+
+func TestWriteBufferDeleteRange(t *testing.T) {
+	b := newBuffer(t, "a", "c", "e")
+	mustDo(t, b.Put([]byte("b"), []byte("b")))
+	mustDo(t, b.Put([]byte("f"), []byte("f")))
+	mustDo(t, b.DeleteRange([]byte("b"), []byte("e")))
+	expect(t, scanAll(t, b, "", ""), []string{"a=a", "e=e", "f=f"})
+}
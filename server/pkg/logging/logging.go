@@ -0,0 +1,139 @@
+// Package logging configures the server's structured logs, which are
+// written with log/slog.
+//
+// Each subsystem logs through a logger for its component, made by
+// Component, and each component can log at its own level: with the levels
+// "info,sql=debug", the sql component logs at debug level and the others
+// at info level. The levels are held in a LevelVar, and may change while
+// the server runs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// ComponentKey is the attribute that names the component a record comes
+// from.
+const ComponentKey = "component"
+
+// Levels are the minimum levels of records that are logged: Default for
+// components without a level of their own in Components.
+type Levels struct {
+	Default    slog.Level
+	Components map[string]slog.Level
+}
+
+// ParseLevels parses levels written as a comma-separated list of a default
+// level and component=level pairs, such as "warn,sql=debug". Levels are
+// those of slog.Level.UnmarshalText, such as "debug" or "info+2".
+func ParseLevels(s string) (Levels, error) {
+	var l Levels
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, level, ok := strings.Cut(part, "=")
+		if !ok {
+			name, level = "", part
+		}
+		var v slog.Level
+		if err := v.UnmarshalText([]byte(level)); err != nil {
+			return Levels{}, fmt.Errorf("logging: invalid level %q", part)
+		}
+		if name == "" {
+			l.Default = v
+			continue
+		}
+		if l.Components == nil {
+			l.Components = make(map[string]slog.Level)
+		}
+		l.Components[strings.TrimSpace(name)] = v
+	}
+	return l, nil
+}
+
+// LevelVar holds Levels that may change while loggers use them, as
+// slog.LevelVar holds a level. Its zero value logs at info level.
+type LevelVar struct {
+	p atomic.Pointer[Levels]
+}
+
+// Levels returns the levels v holds.
+func (v *LevelVar) Levels() Levels {
+	if l := v.p.Load(); l != nil {
+		return *l
+	}
+	return Levels{}
+}
+
+// Set sets the levels of the loggers using v, including those already
+// made.
+func (v *LevelVar) Set(l Levels) {
+	v.p.Store(&l)
+}
+
+// New returns a logger writing to w at the levels of levels, as JSON if
+// format is "json" and as key=value text if it is "text".
+func New(w io.Writer, format string, levels *LevelVar) (*slog.Logger, error) {
+	// The wrapped handler logs all records; levelHandler filters them.
+	opts := &slog.HandlerOptions{Level: slog.Level(-1 << 10)}
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+	return slog.New(&levelHandler{h: h, levels: levels}), nil
+}
+
+// Component returns the logger of component name.
+func Component(l *slog.Logger, name string) *slog.Logger {
+	return l.With(ComponentKey, name)
+}
+
+// levelHandler drops the records below the level of the component its
+// logger belongs to.
+type levelHandler struct {
+	h         slog.Handler
+	levels    *LevelVar
+	component string
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	l := h.levels.Levels()
+	min, ok := l.Components[h.component]
+	if !ok {
+		min = l.Default
+	}
+	return level >= min
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.h.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.h = h.h.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			c.component = a.Value.String()
+		}
+	}
+	return &c
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.h = h.h.WithGroup(name)
+	return &c
+}
@@ -0,0 +1,164 @@
+package pgwire
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// admission bounds the queries the server runs at once, as
+// Config.MaxActiveQueries sets, and queues those that arrive while the
+// limit is reached, up to Config.MaxQueuedQueries, in the order they
+// arrive. Queries beyond the queue are refused, so that a spike of load
+// waits or fails rather than running everything at once and exhausting
+// memory.
+type admission struct {
+	config func() *Config
+
+	mu     sync.Mutex
+	active int
+	queue  []*waiter
+}
+
+// waiter is a query waiting in the queue of admission. ready receives nil
+// once the query is admitted, or the error it is refused with.
+type waiter struct {
+	pid   int32
+	ready chan error
+}
+
+// acquire waits until the query of the session pid may run, and then
+// counts it as running until release. It returns an error, without
+// waiting, if the queue is full, or once the query waited for
+// Config.QueueTimeout, it is canceled, or done is closed. The queries of
+// sessions in a transaction block, inTxn, are admitted at once, so that a
+// transaction holding locks is not queued behind the queries waiting for
+// them.
+func (a *admission) acquire(pid int32, inTxn bool, done <-chan struct{}) error {
+	cfg := a.config()
+	a.mu.Lock()
+	if inTxn || cfg.MaxActiveQueries <= 0 || (a.active < cfg.MaxActiveQueries && len(a.queue) == 0) {
+		a.active++
+		a.mu.Unlock()
+		return nil
+	}
+	if len(a.queue) >= cfg.MaxQueuedQueries {
+		a.mu.Unlock()
+		return pgerror.New(pgerror.CodeInsufficientResources, "too many queries are waiting to run").
+			WithDetail(fmt.Sprintf("The limits are %d running queries and %d waiting.", cfg.MaxActiveQueries, cfg.MaxQueuedQueries)).
+			WithHint("Retry the query later.")
+	}
+	w := &waiter{pid: pid, ready: make(chan error, 1)}
+	a.queue = append(a.queue, w)
+	a.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if cfg.QueueTimeout > 0 {
+		t := time.NewTimer(cfg.QueueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var err error
+	select {
+	case e := <-w.ready:
+		return e
+	case <-timeout:
+		err = pgerror.Newf(pgerror.CodeInsufficientResources,
+			"canceling statement after waiting %v to run", cfg.QueueTimeout).
+			WithDetail(fmt.Sprintf("%d queries are running, the limit.", cfg.MaxActiveQueries))
+	case <-done:
+		err = pgerror.New(pgerror.CodeQueryCanceled, "canceling statement due to user request")
+	}
+	if !a.remove(w) {
+		// It was admitted or canceled as it gave up.
+		return <-w.ready
+	}
+	return err
+}
+
+// release counts a query admitted by acquire as finished, admitting the
+// next ones in the queue.
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.active--
+	a.admit()
+}
+
+// admit admits the queries at the head of the queue while fewer than
+// Config.MaxActiveQueries run, as when one finishes or the limit is
+// raised.
+func (a *admission) admit() {
+	limit := a.config().MaxActiveQueries
+	for len(a.queue) > 0 && (limit <= 0 || a.active < limit) {
+		w := a.queue[0]
+		a.queue = a.queue[1:]
+		a.active++
+		w.ready <- nil
+	}
+}
+
+// wake admits the queries the current configuration allows to run.
+func (a *admission) wake() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.admit()
+}
+
+// cancel refuses the query of the session pid, if it waits in the queue,
+// reporting whether it did.
+func (a *admission) cancel(pid int32) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, w := range a.queue {
+		if w.pid == pid {
+			a.queue = append(a.queue[:i], a.queue[i+1:]...)
+			w.ready <- pgerror.New(pgerror.CodeQueryCanceled, "canceling statement due to user request")
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes w out of the queue, reporting whether it was there.
+func (a *admission) remove(w *waiter) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, q := range a.queue {
+		if q == w {
+			a.queue = append(a.queue[:i], a.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// sendWriter writes what a connection sends to its network connection,
+// failing a write that the client does not take within
+// Config.SendTimeout. The connection's writer buffers up to
+// Config.SendBufferSize bytes in front of it, and writing blocks once the
+// buffer is full: a client that reads its results slowly holds back the
+// query that sends them, rather than having them pile up in the server's
+// memory.
+type sendWriter struct {
+	c *conn
+}
+
+func (w sendWriter) Write(p []byte) (int, error) {
+	if t := w.c.srv.config.Load().SendTimeout; t > 0 {
+		w.c.nc.SetWriteDeadline(time.Now().Add(t))
+	} else {
+		w.c.nc.SetWriteDeadline(time.Time{})
+	}
+	return w.c.nc.Write(p)
+}
+
+// sendTimedOut reports whether err is the error of a write that timed out.
+func sendTimedOut(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
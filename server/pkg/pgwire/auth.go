@@ -0,0 +1,168 @@
+package pgwire
+
+import (
+	"errors"
+
+	"github.com/alivenotions/pgz/server/pkg/scram"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// Codes of the authentication requests of AuthenticationRequest messages.
+const (
+	authOK                = 0
+	authCleartextPassword = 3
+	authSASL              = 10
+	authSASLContinue      = 11
+	authSASLFinal         = 12
+)
+
+// authenticate checks that the client may connect as user, with the
+// method configured for its kind of connection, and tells it so.
+func (c *conn) authenticate(user string) error {
+	cfg := c.srv.config.Load()
+	method := cfg.Auth
+	if c.local {
+		method = cfg.LocalAuth
+	}
+	switch method {
+	case AuthTrust, "":
+	case AuthPassword:
+		c.authRequest(authCleartextPassword)
+		body, err := c.authResponse()
+		if err != nil {
+			return err
+		}
+		m := &message{b: body}
+		password := m.string()
+		if m.err != nil {
+			return m.err
+		}
+		ok, err := c.checkPassword(cfg, user, password)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errPasswordFailed(user)
+		}
+	case AuthSCRAM:
+		if err := c.scram(user); err != nil {
+			return err
+		}
+	case AuthPeer:
+		if !c.local {
+			return pgerror.New(pgerror.CodeInvalidAuthorization, "peer authentication is only supported on local sockets")
+		}
+		name, err := peerUser(c.nc)
+		if err != nil {
+			return pgerror.Newf(pgerror.CodeInvalidAuthorization, "could not get peer credentials: %v", err)
+		}
+		if name != user {
+			c.log.Info("provided user name and authenticated user name do not match", "user", user, "peer", name)
+			return pgerror.Newf(pgerror.CodeInvalidAuthorization, "Peer authentication failed for user %q", user)
+		}
+	default:
+		return pgerror.Newf(pgerror.CodeInvalidAuthorization, "authentication method %q is not supported", method)
+	}
+	c.authRequest(authOK)
+	return nil
+}
+
+// checkPassword reports whether password is the password of user: the one
+// Config.Password accepts if it is set, and otherwise the one of the role.
+func (c *conn) checkPassword(cfg *Config, user, password string) (bool, error) {
+	if cfg.Password != nil {
+		return cfg.Password(user, password), nil
+	}
+	v, err := c.srv.sql.PasswordVerifier(user)
+	if err != nil || v == nil {
+		return false, err
+	}
+	return v.Check(password), nil
+}
+
+// scram runs a SCRAM-SHA-256 exchange with the client, which must prove
+// that it knows the password of the role user. For users without a
+// password, the exchange runs with a mock verifier and fails at its end,
+// as it does for a wrong password.
+func (c *conn) scram(user string) error {
+	v, err := c.srv.sql.PasswordVerifier(user)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		v = scram.MockVerifier(c.srv.mockSecret, user)
+	}
+	c.wr.start('R')
+	c.wr.int32(authSASL)
+	c.wr.string(scram.Mechanism)
+	c.wr.byte(0)
+	c.wr.end()
+	body, err := c.authResponse()
+	if err != nil {
+		return err
+	}
+	m := &message{b: body}
+	mechanism := m.string()
+	first := m.bytes(m.int32())
+	if m.err != nil {
+		return m.err
+	}
+	if mechanism != scram.Mechanism {
+		return pgerror.New(pgerror.CodeProtocolViolation, "client selected an invalid SASL authentication mechanism")
+	}
+	e := scram.NewExchange(v)
+	serverFirst, err := e.First(string(first))
+	if err != nil {
+		return pgerror.New(pgerror.CodeProtocolViolation, err.Error())
+	}
+	c.saslRequest(authSASLContinue, serverFirst)
+	final, err := c.authResponse()
+	if err != nil {
+		return err
+	}
+	serverFinal, err := e.Final(string(final))
+	if errors.Is(err, scram.ErrAuthFailed) {
+		return errPasswordFailed(user)
+	}
+	if err != nil {
+		return pgerror.New(pgerror.CodeProtocolViolation, err.Error())
+	}
+	c.saslRequest(authSASLFinal, serverFinal)
+	return nil
+}
+
+// authResponse sends the buffered authentication request, and returns the
+// body of the client's response to it.
+func (c *conn) authResponse() ([]byte, error) {
+	if err := c.wr.flush(); err != nil {
+		return nil, err
+	}
+	typ, body, err := c.rd.read()
+	if err != nil {
+		return nil, err
+	}
+	if typ != 'p' {
+		return nil, unexpected(typ)
+	}
+	return body, nil
+}
+
+func errPasswordFailed(user string) error {
+	return pgerror.Newf(pgerror.CodeInvalidPassword, "password authentication failed for user %q", user)
+}
+
+// authRequest sends an AuthenticationRequest message of code.
+func (c *conn) authRequest(code int32) {
+	c.wr.start('R')
+	c.wr.int32(code)
+	c.wr.end()
+}
+
+// saslRequest sends an AuthenticationRequest message of code, carrying
+// data of the SASL exchange.
+func (c *conn) saslRequest(code int32, data string) {
+	c.wr.start('R')
+	c.wr.int32(code)
+	c.wr.bytes([]byte(data))
+	c.wr.end()
+}
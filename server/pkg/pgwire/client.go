@@ -0,0 +1,410 @@
+package pgwire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/scram"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// authMD5Password is the code of the request for an MD5-hashed password,
+// which the server does not make but PostgreSQL may.
+const authMD5Password = 5
+
+// Client is a connection to a PostgreSQL server, such as one a Server
+// serves, which runs statements with the simple query protocol. It is
+// not safe for concurrent use.
+type Client struct {
+	nc net.Conn
+	rd reader
+	wr writer
+	// Params are the parameters the server reported, such as
+	// server_version.
+	Params map[string]string
+}
+
+// ClientResult is the outcome of a statement a Client ran.
+type ClientResult struct {
+	Columns []string
+	// Rows are the values of the rows in the text format, with nil for
+	// NULL.
+	Rows [][]*string
+	// Tag is the command tag, e.g. "SELECT 1".
+	Tag string
+}
+
+// Connect opens a connection to the server of dsn and authenticates, with
+// a password in clear text, MD5 or SCRAM-SHA-256 as the server asks.
+func Connect(ctx context.Context, dsn *DSN) (*Client, error) {
+	network, addr := "tcp", net.JoinHostPort(dsn.Host, strconv.Itoa(dsn.Port))
+	if strings.HasPrefix(dsn.Host, "/") {
+		network, addr = "unix", SocketPath(dsn.Host, dsn.Port)
+	}
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{Params: make(map[string]string)}
+	c.setNetConn(nc)
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	if err := c.startup(dsn, network == "unix"); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (c *Client) setNetConn(nc net.Conn) {
+	c.nc = nc
+	c.rd.r = bufio.NewReader(nc)
+	c.wr.w = bufio.NewWriter(nc)
+}
+
+// startup negotiates SSL as dsn.SSLMode says, then sends the
+// StartupMessage and answers the server's authentication requests until it
+// is ready for queries.
+func (c *Client) startup(dsn *DSN, local bool) error {
+	if dsn.SSLMode != "disable" && !local {
+		if err := c.sendStartup(sslRequest); err != nil {
+			return err
+		}
+		answer, err := c.rd.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case answer == 'S':
+			// As in libpq, SSL protects the connection from eavesdropping
+			// but the server's certificate is not verified.
+			tc := tls.Client(c.nc, &tls.Config{ServerName: dsn.Host, InsecureSkipVerify: true})
+			if err := tc.Handshake(); err != nil {
+				return err
+			}
+			c.setNetConn(tc)
+		case dsn.SSLMode == "require":
+			return errors.New("pgwire: server does not support SSL, but SSL was required")
+		}
+	}
+	params := []string{"user", dsn.User, "database", dsn.Database}
+	if dsn.Replication != "" {
+		params = append(params, "replication", dsn.Replication)
+	}
+	if err := c.sendStartup(protocolVersion3, params...); err != nil {
+		return err
+	}
+	var sasl *scram.ClientExchange
+	for {
+		typ, body, err := c.rd.read()
+		if err != nil {
+			return err
+		}
+		m := &message{b: body}
+		switch typ {
+		case 'R':
+			if err := c.authenticate(dsn, m, &sasl); err != nil {
+				return err
+			}
+		case 'S':
+			name := m.string()
+			c.Params[name] = m.string()
+		case 'K', 'N':
+		case 'E':
+			return parseError(m)
+		case 'Z':
+			return nil
+		default:
+			return unexpected(typ)
+		}
+		if m.err != nil {
+			return m.err
+		}
+	}
+}
+
+// sendStartup sends a message of the startup phase, which has no type
+// byte, with code and the null-terminated strings of params.
+func (c *Client) sendStartup(code int32, params ...string) error {
+	msg := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(code))
+	for _, p := range params {
+		msg = append(append(msg, p...), 0)
+	}
+	if len(params) > 0 {
+		msg = append(msg, 0)
+	}
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)))
+	if _, err := c.wr.w.Write(msg); err != nil {
+		return err
+	}
+	return c.wr.flush()
+}
+
+// authenticate answers the AuthenticationRequest m. sasl is the SCRAM
+// exchange in progress, which the request of authSASL starts.
+func (c *Client) authenticate(dsn *DSN, m *message, sasl **scram.ClientExchange) error {
+	var answer []byte
+	switch code := m.int32(); code {
+	case authOK:
+		return nil
+	case authCleartextPassword:
+		answer = append([]byte(dsn.Password), 0)
+	case authMD5Password:
+		salt := m.bytes(4)
+		inner := md5.Sum([]byte(dsn.Password + dsn.User))
+		outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), salt...))
+		answer = append([]byte("md5"+hex.EncodeToString(outer[:])), 0)
+	case authSASL:
+		found := false
+		for mech := m.string(); mech != "" && m.err == nil; mech = m.string() {
+			found = found || mech == scram.Mechanism
+		}
+		if !found {
+			return errors.New("pgwire: server offers no supported SASL mechanism")
+		}
+		*sasl = scram.NewClientExchange(dsn.Password)
+		first, err := (*sasl).First()
+		if err != nil {
+			return err
+		}
+		answer = append([]byte(scram.Mechanism), 0)
+		answer = binary.BigEndian.AppendUint32(answer, uint32(len(first)))
+		answer = append(answer, first...)
+	case authSASLContinue:
+		if *sasl == nil {
+			return unexpected('R')
+		}
+		final, err := (*sasl).Final(string(m.b))
+		if err != nil {
+			return err
+		}
+		answer = []byte(final)
+	case authSASLFinal:
+		if *sasl == nil {
+			return unexpected('R')
+		}
+		return (*sasl).Verify(string(m.b))
+	default:
+		return fmt.Errorf("pgwire: authentication method %d is not supported", code)
+	}
+	if m.err != nil {
+		return m.err
+	}
+	c.wr.start('p')
+	c.wr.bytes(answer)
+	if err := c.wr.end(); err != nil {
+		return err
+	}
+	return c.wr.flush()
+}
+
+// Exec runs the statements of query and returns their results. The first
+// error ends it, as the server skips the statements after it.
+func (c *Client) Exec(query string) ([]*ClientResult, error) {
+	c.wr.start('Q')
+	c.wr.string(query)
+	if err := c.wr.end(); err != nil {
+		return nil, err
+	}
+	if err := c.wr.flush(); err != nil {
+		return nil, err
+	}
+	var results []*ClientResult
+	var res *ClientResult
+	var qerr error
+	for {
+		typ, body, err := c.rd.read()
+		if err != nil {
+			return nil, err
+		}
+		m := &message{b: body}
+		switch typ {
+		case 'T':
+			res = &ClientResult{}
+			for n := m.int16(); n > 0 && m.err == nil; n-- {
+				res.Columns = append(res.Columns, m.string())
+				// The table and column, type, size, modifier and format.
+				m.bytes(18)
+			}
+		case 'D':
+			if res == nil {
+				return nil, unexpected(typ)
+			}
+			row := make([]*string, m.int16())
+			for i := range row {
+				if n := m.int32(); n >= 0 {
+					v := string(m.bytes(n))
+					row[i] = &v
+				}
+			}
+			res.Rows = append(res.Rows, row)
+		case 'C':
+			if res == nil {
+				res = &ClientResult{}
+			}
+			res.Tag = m.string()
+			results, res = append(results, res), nil
+		case 'I':
+			res = nil
+		case 'G':
+			// COPY FROM STDIN, which the client has no data for.
+			c.wr.start('f')
+			c.wr.string("COPY FROM STDIN is not supported by this client")
+			if err := c.wr.end(); err != nil {
+				return nil, err
+			}
+			if err := c.wr.flush(); err != nil {
+				return nil, err
+			}
+		case 'E':
+			qerr = parseError(m)
+		case 'Z':
+			return results, qerr
+		case 'S':
+			name := m.string()
+			c.Params[name] = m.string()
+		case 'N', 'A':
+		default:
+			return nil, unexpected(typ)
+		}
+		if m.err != nil {
+			return nil, m.err
+		}
+	}
+}
+
+// StreamChanges streams the changes the storage engine of the server
+// commits after sequence number from, as a read replica does, on a
+// connection of physical replication: it runs START_REPLICATION PHYSICAL
+// and calls apply with each transaction, in commit order. It answers the
+// server's keepalives with the sequence number of the last transaction
+// applied. It returns when ctx is done, apply fails or the stream does,
+// with the error; the connection cannot be used after it.
+func (c *Client) StreamChanges(ctx context.Context, from uint64, apply func(engine.CommittedTxn) error) error {
+	c.wr.start('Q')
+	c.wr.string("START_REPLICATION PHYSICAL " + catalog.FormatLSN(from))
+	if err := c.wr.end(); err != nil {
+		return err
+	}
+	if err := c.wr.flush(); err != nil {
+		return err
+	}
+	// Canceling ctx interrupts the read the stream waits in.
+	stop := context.AfterFunc(ctx, func() { c.nc.SetReadDeadline(time.Unix(1, 0)) })
+	defer stop()
+	applied := from
+	for {
+		typ, body, err := c.rd.read()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		m := &message{b: body}
+		switch typ {
+		case 'W':
+		case 'd':
+			switch m.byte() {
+			case 'w':
+				// The start and end of the XLogData and its time.
+				txn := engine.CommittedTxn{Seq: uint64(m.int64())}
+				m.int64()
+				m.int64()
+				for len(m.b) > 0 && m.err == nil {
+					kind := engine.ChangeKind(m.byte())
+					key := bytes.Clone(m.bytes(m.int32()))
+					val := bytes.Clone(m.bytes(m.int32()))
+					txn.Changes = append(txn.Changes, engine.Change{Kind: kind, Key: key, Value: val})
+				}
+				if m.err != nil {
+					return m.err
+				}
+				if err := apply(txn); err != nil {
+					return err
+				}
+				applied = txn.Seq
+			case 'k':
+				if err := c.standbyStatus(applied); err != nil {
+					return err
+				}
+			}
+		case 'E':
+			return parseError(m)
+		case 'c', 'C', 'Z':
+			return errors.New("pgwire: the server ended the stream of changes")
+		case 'N', 'S':
+		default:
+			return unexpected(typ)
+		}
+	}
+}
+
+// standbyStatus sends a standby status update, which reports that the
+// changes up to applied were written, flushed and applied.
+func (c *Client) standbyStatus(applied uint64) error {
+	c.wr.start('d')
+	c.wr.byte('r')
+	for range 3 {
+		c.wr.int64(int64(applied))
+	}
+	c.wr.int64(replicationTime(time.Now()))
+	c.wr.byte(0)
+	if err := c.wr.end(); err != nil {
+		return err
+	}
+	return c.wr.flush()
+}
+
+// Close ends the session and closes the connection.
+func (c *Client) Close() error {
+	c.wr.empty('X')
+	c.wr.flush()
+	return c.nc.Close()
+}
+
+// parseError returns the error of an ErrorResponse message.
+func parseError(m *message) error {
+	e := &pgerror.Error{}
+	for code := m.byte(); code != 0 && m.err == nil; code = m.byte() {
+		v := m.string()
+		switch code {
+		case 'C':
+			e.Code = v
+		case 'M':
+			e.Message = v
+		case 'D':
+			e.Detail = v
+		case 'H':
+			e.Hint = v
+		case 't':
+			e.Table = v
+		case 'c':
+			e.Column = v
+		case 'n':
+			e.Constraint = v
+		case 'P':
+			e.Position, _ = strconv.Atoi(v)
+		}
+	}
+	if m.err != nil {
+		return m.err
+	}
+	return e
+}
@@ -0,0 +1,615 @@
+package pgwire
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// serverVersion is the version of PostgreSQL the server reports being, in
+// the server_version parameter.
+const serverVersion = "17.0"
+
+// conn is a client connection.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+	rd  reader
+	wr  writer
+	// local is set for connections on Unix sockets.
+	local bool
+	log   *slog.Logger
+
+	sess    *sql.Session
+	portals map[string]*portal
+	// ignoring is set once a message of the extended query protocol
+	// failed, until the next Sync: the messages in between are skipped.
+	ignoring bool
+	// status are the values of the session's parameters last reported to
+	// the client; see reportStatus.
+	status map[string]string
+	// replication is set for walsender connections, which accept the
+	// commands of the replication protocol; see replicate. physical is set
+	// for those of physical replication, which run no SQL.
+	replication, physical bool
+
+	// mu guards wr while the connection is idle, waiting for the client's
+	// next message, when notifications are sent as they arrive.
+	mu   sync.Mutex
+	idle bool
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	c := &conn{srv: s, local: nc.LocalAddr().Network() == "unix"}
+	c.setNetConn(nc)
+	c.log = s.logger.With("remote", remoteAddr(nc))
+	return c
+}
+
+func (c *conn) setNetConn(nc net.Conn) {
+	c.nc = nc
+	c.rd.r = bufio.NewReader(nc)
+	size := c.srv.config.Load().SendBufferSize
+	if size <= 0 {
+		size = defaultSendBufferSize
+	}
+	c.wr.w = bufio.NewWriterSize(sendWriter{c}, size)
+}
+
+// defaultSendBufferSize is the size of the buffer of what is sent to a
+// client without Config.SendBufferSize.
+const defaultSendBufferSize = 4096
+
+// addr returns the address of the client, without its port, as logins
+// are recorded from.
+func (c *conn) addr() string {
+	addr := remoteAddr(c.nc)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func remoteAddr(nc net.Conn) string {
+	if nc.LocalAddr().Network() == "unix" {
+		return "[local]"
+	}
+	return nc.RemoteAddr().String()
+}
+
+// serve runs the connection until the client disconnects or its session
+// ends.
+func (c *conn) serve() {
+	defer c.nc.Close()
+	params, err := c.startup()
+	if err != nil {
+		c.fatal(err)
+		return
+	}
+	if params == nil {
+		return
+	}
+	user := params["user"]
+	if user == "" {
+		c.fatal(pgerror.New(pgerror.CodeInvalidAuthorization, "no PostgreSQL user name specified in startup packet"))
+		return
+	}
+	if err := c.srv.sql.CheckLogin(user); err != nil {
+		c.log.Info("login refused", "user", user, "err", err)
+		c.fatal(err)
+		return
+	}
+	if err := c.authenticate(user); err != nil {
+		c.log.Info("authentication failed", "user", user, "err", err)
+		if pgerror.GetCode(err) == pgerror.CodeInvalidPassword {
+			c.srv.sql.RecordLogin(user, c.addr(), false)
+		}
+		c.fatal(err)
+		return
+	}
+	if c.replication, c.physical, err = walsender(params["replication"]); err != nil {
+		c.fatal(err)
+		return
+	}
+	options, err := startupOptions(params["options"])
+	if err != nil {
+		c.fatal(err)
+		return
+	}
+	// As in PostgreSQL, the database defaults to the user's name.
+	// Walsenders of physical replication stream every database, and are
+	// connected to the default one.
+	database := params["database"]
+	if database == "" {
+		database = user
+	}
+	if c.physical {
+		database = catalog.DefaultDatabase
+	}
+	sess, err := c.srv.sql.Connect(user, database)
+	if err != nil {
+		c.fatal(err)
+		return
+	}
+	c.sess = sess
+	defer sess.Close()
+	c.srv.sql.RecordLogin(user, c.addr(), true)
+	if c.replication && !sess.Superuser() {
+		c.fatal(pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to start WAL sender").
+			WithDetail("Only superusers may start a WAL sender process."))
+		return
+	}
+	// The parameters override the settings of the options, as in
+	// PostgreSQL.
+	for _, settings := range []map[string]string{options, params} {
+		for name, value := range settings {
+			switch name {
+			case "user", "database", "application_name", "options", "replication":
+				continue
+			}
+			if err := sess.SetParameter(name, value); err != nil {
+				if pgerror.GetCode(err) == pgerror.CodeUndefinedObject {
+					c.log.Debug("ignoring unsupported startup parameter", "name", name)
+					continue
+				}
+				c.fatal(err)
+				return
+			}
+		}
+	}
+	sess.SetApplicationName(params["application_name"])
+	key := c.srv.register(sess.PID())
+	defer c.srv.unregister(sess.PID())
+	c.log = c.log.With("pid", sess.PID(), "user", user, "database", database)
+	c.log.Debug("connection authorized", "application_name", params["application_name"])
+
+	if err := c.ready(user, params, key); err != nil {
+		return
+	}
+	// Terminating the session interrupts the read the connection waits
+	// for its next message in.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-sess.Done():
+				c.nc.SetReadDeadline(time.Unix(1, 0))
+				return
+			case <-sess.Notifications():
+				c.mu.Lock()
+				if c.idle && !sess.InTxn() {
+					c.notifications()
+					c.wr.flush()
+				}
+				c.mu.Unlock()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for {
+		c.mu.Lock()
+		c.idle = true
+		err := c.wr.flush()
+		c.mu.Unlock()
+		if err != nil {
+			if sendTimedOut(err) {
+				c.log.Info("closing the connection of a client that does not read what is sent", "timeout", c.srv.config.Load().SendTimeout)
+			}
+			return
+		}
+		typ, body, err := c.rd.read()
+		c.mu.Lock()
+		c.idle = false
+		c.mu.Unlock()
+		if sess.Terminated() {
+			c.fatal(sess.Err())
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				c.fatal(err)
+			}
+			return
+		}
+		if typ == 'X' {
+			return
+		}
+		sess.TraceRead(c.rd.start, c.rd.end, 5+len(body))
+		err = c.handle(typ, body)
+		if sess.Terminated() {
+			c.fatal(sess.Err())
+			return
+		}
+		if err != nil {
+			c.fatal(err)
+			return
+		}
+	}
+}
+
+// startup runs the startup phase until the client sends its
+// StartupMessage, and returns the parameters of the message. It answers
+// SSLRequest, upgrading the connection to TLS if it can, and
+// GSSENCRequest, which it refuses, so that the client goes on without
+// GSSAPI encryption. A StartupMessage of a newer 3.x version, or with
+// options of protocol extensions, is downgraded to 3.0 without them; see
+// negotiateProtocolVersion. It returns nil parameters for a
+// CancelRequest, after which the connection is closed.
+func (c *conn) startup() (map[string]string, error) {
+	for {
+		body, err := c.rd.readStartup()
+		if err != nil {
+			return nil, err
+		}
+		m := &message{b: body}
+		switch code := m.int32(); {
+		case code == sslRequest:
+			cfg := c.srv.config.Load().TLS
+			if c.local || cfg == nil {
+				if err := c.answer('N'); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := c.answer('S'); err != nil {
+				return nil, err
+			}
+			tc := tls.Server(c.nc, cfg)
+			if err := tc.Handshake(); err != nil {
+				return nil, err
+			}
+			c.setNetConn(tc)
+		case code == gssEncRequest:
+			if err := c.answer('N'); err != nil {
+				return nil, err
+			}
+		case code == cancelRequest:
+			pid, key := m.int32(), m.int32()
+			if m.err == nil {
+				c.srv.cancel(pid, key)
+			}
+			return nil, nil
+		case code>>16 == protocolVersion3>>16:
+			params := make(map[string]string)
+			var unrecognized []string
+			for {
+				name := m.string()
+				if name == "" || m.err != nil {
+					break
+				}
+				value := m.string()
+				// Options of protocol extensions, which the server has
+				// none of, start with _pq_.
+				if strings.HasPrefix(name, "_pq_.") {
+					unrecognized = append(unrecognized, name)
+					continue
+				}
+				params[name] = value
+			}
+			if m.err == nil && (code != protocolVersion3 || len(unrecognized) > 0) {
+				c.negotiateProtocolVersion(code, unrecognized)
+			}
+			return params, m.err
+		default:
+			return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported,
+				"unsupported frontend protocol %d.%d: server supports 3.0 to 3.0", code>>16, code&0xffff)
+		}
+	}
+}
+
+// negotiateProtocolVersion tells a client that asked for a newer minor
+// version of the protocol than 3.0, or for options of protocol
+// extensions, that the connection goes on with 3.0 and without them, with
+// a NegotiateProtocolVersion message. The client may then give up or go
+// on, as libpq does.
+func (c *conn) negotiateProtocolVersion(code int32, unrecognized []string) {
+	c.log.Debug("downgrading the protocol", "requested", fmt.Sprintf("%d.%d", code>>16, code&0xffff),
+		"unrecognized_options", unrecognized)
+	c.wr.start('v')
+	c.wr.int32(protocolVersion3)
+	c.wr.int32(int32(len(unrecognized)))
+	for _, name := range unrecognized {
+		c.wr.string(name)
+	}
+	c.wr.end()
+}
+
+// startupOptions returns the settings of the options startup parameter,
+// the command-line switches of a backend as libpq's options connection
+// parameter passes them: -c name=value and --name=value. Spaces separate
+// the switches, unless escaped with a backslash. The other switches of
+// postgres set what the server does not have, and are refused.
+func startupOptions(options string) (map[string]string, error) {
+	var args []string
+	var b strings.Builder
+	for i := 0; i < len(options); i++ {
+		switch ch := options[i]; {
+		case ch == '\\' && i+1 < len(options):
+			i++
+			b.WriteByte(options[i])
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if b.Len() > 0 {
+				args = append(args, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	if b.Len() > 0 {
+		args = append(args, b.String())
+	}
+	settings := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var setting string
+		switch {
+		case arg == "-c" && i+1 < len(args):
+			i++
+			setting = args[i]
+		case strings.HasPrefix(arg, "-c"):
+			setting = arg[2:]
+		case strings.HasPrefix(arg, "--"):
+			setting = arg[2:]
+		default:
+			return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "unsupported startup option %q", arg).
+				WithHint("Only -c name=value and --name=value are supported in options.")
+		}
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok || name == "" {
+			return nil, pgerror.Newf(pgerror.CodeSyntaxError, "invalid startup option %q: expected name=value", setting)
+		}
+		settings[strings.ReplaceAll(name, "-", "_")] = value
+	}
+	return settings, nil
+}
+
+// walsender reports whether the replication startup parameter asks for a
+// walsender connection, which only superusers may start, and whether it
+// is for physical replication, as true asks, rather than logical, as
+// database does.
+func walsender(replication string) (walsender, physical bool, err error) {
+	switch strings.ToLower(replication) {
+	case "", "false", "off", "no", "0":
+		return false, false, nil
+	case "true", "on", "yes", "1":
+		return true, true, nil
+	case "database":
+		return true, false, nil
+	}
+	return false, false, pgerror.Newf(pgerror.CodeInvalidParameterValue, "invalid value for parameter \"replication\": %q", replication)
+}
+
+// answer answers SSLRequest or GSSENCRequest with a single byte.
+func (c *conn) answer(b byte) error {
+	if err := c.wr.w.WriteByte(b); err != nil {
+		return err
+	}
+	return c.wr.flush()
+}
+
+// ready tells the client that the session started: its parameters, its
+// key for cancel requests and that it is ready for queries.
+func (c *conn) ready(user string, params map[string]string, key int32) error {
+	for _, p := range [][2]string{
+		{"server_version", serverVersion},
+		{"server_encoding", "UTF8"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+		{"is_superuser", onOff(c.sess.Superuser())},
+		{"session_authorization", user},
+		{"application_name", params["application_name"]},
+	} {
+		c.wr.start('S')
+		c.wr.string(p[0])
+		c.wr.string(p[1])
+		if err := c.wr.end(); err != nil {
+			return err
+		}
+	}
+	c.reportStatus()
+	c.wr.start('K')
+	c.wr.int32(c.sess.PID())
+	c.wr.int32(key)
+	if err := c.wr.end(); err != nil {
+		return err
+	}
+	return c.readyForQuery()
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// readyForQuery tells the client that the server is ready for its next
+// query, and whether a transaction block is open or failed. Outside a
+// transaction block, it first sends the notifications delivered to the
+// session.
+func (c *conn) readyForQuery() error {
+	status := byte('I')
+	switch {
+	case c.sess.Failed():
+		status = 'E'
+	case c.sess.InTxn():
+		status = 'T'
+	default:
+		c.notifications()
+	}
+	c.reportStatus()
+	c.wr.start('Z')
+	c.wr.byte(status)
+	return c.wr.end()
+}
+
+// reportStatus sends ParameterStatus messages for the parameters of the
+// session that changed since they were last reported, such as DateStyle
+// after SET DateStyle.
+func (c *conn) reportStatus() {
+	if c.status == nil {
+		c.status = make(map[string]string)
+	}
+	for name, value := range c.sess.ParameterStatus() {
+		if old, ok := c.status[name]; ok && old == value {
+			continue
+		}
+		c.status[name] = value
+		c.wr.start('S')
+		c.wr.string(name)
+		c.wr.string(value)
+		c.wr.end()
+	}
+}
+
+// notifications sends the notifications delivered to the session as
+// NotificationResponse messages.
+func (c *conn) notifications() {
+	for _, n := range c.sess.TakeNotifications() {
+		c.wr.start('A')
+		c.wr.int32(n.PID)
+		c.wr.string(c.encode(n.Channel))
+		c.wr.string(c.encode(n.Payload))
+		c.wr.end()
+	}
+}
+
+// handle handles a message of the client. It returns an error only if the
+// connection cannot go on. Errors writing to the client are not returned:
+// they are kept by the buffered writer, and end the connection when it is
+// next flushed.
+func (c *conn) handle(typ byte, body []byte) error {
+	m := &message{b: body}
+	if c.ignoring && typ != 'S' {
+		return nil
+	}
+	if c.physical && strings.IndexByte("PBDEC", typ) >= 0 {
+		return c.extended(errPhysicalSQL())
+	}
+	switch typ {
+	case 'Q':
+		return c.query(m)
+	case 'P':
+		return c.extended(c.parse(m))
+	case 'B':
+		return c.extended(c.bind(m))
+	case 'D':
+		return c.extended(c.describe(m))
+	case 'E':
+		return c.extended(c.execute(m))
+	case 'C':
+		return c.extended(c.close(m))
+	case 'S':
+		c.ignoring = false
+		if !c.sess.InTxn() {
+			clear(c.portals)
+		}
+		return c.readyForQuery()
+	case 'H':
+		return c.wr.flush()
+	case 'F':
+		// PostgreSQL answers a FunctionCall with ReadyForQuery too.
+		c.sendError(pgerror.New(pgerror.CodeFeatureNotSupported, "fastpath function calls are not supported").
+			WithHint("Call the function in a query, as in SELECT f($1)."), "ERROR")
+		return c.readyForQuery()
+	case 'd', 'c', 'f':
+		// Leftover data of a COPY that failed is ignored.
+		return nil
+	}
+	return unexpected(typ)
+}
+
+// admit waits until the server lets a query of the connection run, as
+// Config.MaxActiveQueries bounds them, and returns the function to call
+// once it ends. The queries of walsenders, which stream for as long as
+// they are connected, are not bounded.
+func (c *conn) admit() (release func(), err error) {
+	if c.replication {
+		return func() {}, nil
+	}
+	if err := c.srv.admission.acquire(c.sess.PID(), c.sess.InTxn(), c.sess.Done()); err != nil {
+		return nil, err
+	}
+	return c.srv.admission.release, nil
+}
+
+// extended finishes a message of the extended query protocol that failed
+// with err, if it did, by sending the error and skipping messages until
+// Sync.
+func (c *conn) extended(err error) error {
+	if err == nil {
+		return nil
+	}
+	c.ignoring = true
+	c.sendError(err, "ERROR")
+	return nil
+}
+
+// sendError sends err with severity. Errors without a SQLSTATE are
+// internal errors.
+func (c *conn) sendError(err error, severity string) error {
+	e := pgerror.Flatten(err)
+	c.wr.start('E')
+	for _, f := range []struct {
+		code  byte
+		value string
+	}{
+		{'S', severity}, {'V', severity}, {'C', e.Code}, {'M', e.Message},
+		{'D', e.Detail}, {'H', e.Hint}, {'t', e.Table}, {'c', e.Column}, {'n', e.Constraint},
+	} {
+		if f.value == "" {
+			continue
+		}
+		c.wr.byte(f.code)
+		c.wr.string(c.encode(f.value))
+	}
+	if e.Position > 0 {
+		c.wr.byte('P')
+		c.wr.string(strconv.Itoa(e.Position))
+	}
+	c.wr.byte(0)
+	return c.wr.end()
+}
+
+// encode converts the text of a message to the client encoding, replacing
+// it with its escaped form if the encoding cannot represent it.
+func (c *conn) encode(s string) string {
+	if c.sess == nil {
+		return s
+	}
+	b, err := c.sess.EncodeText(s)
+	if err != nil {
+		return fmt.Sprintf("%+q", s)
+	}
+	return string(b)
+}
+
+// fatal sends err as a FATAL error, after which the connection is closed.
+func (c *conn) fatal(err error) {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return
+	}
+	if c.sendError(err, "FATAL") == nil {
+		c.wr.flush()
+	}
+}
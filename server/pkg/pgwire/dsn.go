@@ -0,0 +1,156 @@
+package pgwire
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// DSN is where and as whom a Client connects.
+type DSN struct {
+	// Host is the host name or address of the server, or the directory of
+	// its Unix socket if it starts with a slash.
+	Host string
+	Port int
+	User string
+	// Password is sent if the server asks for it.
+	Password string
+	Database string
+	// SSLMode is disable, prefer or require, as in libpq: whether SSL is
+	// not tried, tried, or insisted on. The server's certificate is not
+	// verified.
+	SSLMode string
+	// Replication is the replication startup parameter, as in libpq: true
+	// or database for a walsender connection of physical or logical
+	// replication, or empty for an ordinary one.
+	Replication string
+}
+
+// ParseDSN parses a connection string as libpq does: a postgres:// or
+// postgresql:// URL, or space-separated keyword=value pairs whose values
+// may be single-quoted. What it does not give is taken from the PGHOST,
+// PGPORT, PGUSER, PGPASSWORD, PGDATABASE and PGSSLMODE environment
+// variables, or else defaults to the Unix socket in /tmp on port 5432, the
+// operating system user, the database named after the user and SSL if the
+// server offers it.
+func ParseDSN(s string) (*DSN, error) {
+	params := make(map[string]string)
+	var err error
+	if strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://") {
+		err = parseURL(s, params)
+	} else {
+		err = parseKeywords(s, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for key, env := range map[string]string{
+		"host": "PGHOST", "port": "PGPORT", "user": "PGUSER", "password": "PGPASSWORD",
+		"dbname": "PGDATABASE", "sslmode": "PGSSLMODE",
+	} {
+		if params[key] == "" {
+			params[key] = os.Getenv(env)
+		}
+	}
+	d := &DSN{Host: params["host"], Port: 5432, User: params["user"], Password: params["password"],
+		Database: params["dbname"], SSLMode: params["sslmode"], Replication: params["replication"]}
+	if d.Host == "" {
+		d.Host = "/tmp"
+	}
+	if p := params["port"]; p != "" {
+		if d.Port, err = strconv.Atoi(p); err != nil || d.Port <= 0 || d.Port > 65535 {
+			return nil, fmt.Errorf("pgwire: invalid port %q", p)
+		}
+	}
+	if d.User == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("pgwire: could not get the current user: %w", err)
+		}
+		d.User = u.Username
+	}
+	if d.Database == "" {
+		d.Database = d.User
+	}
+	switch d.SSLMode {
+	case "":
+		d.SSLMode = "prefer"
+	case "disable", "prefer", "require":
+	default:
+		return nil, fmt.Errorf("pgwire: unsupported sslmode %q", d.SSLMode)
+	}
+	return d, nil
+}
+
+func parseURL(s string, params map[string]string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("pgwire: invalid connection URL: %w", err)
+	}
+	params["host"], params["port"] = u.Hostname(), u.Port()
+	if u.User != nil {
+		params["user"] = u.User.Username()
+		params["password"], _ = u.User.Password()
+	}
+	params["dbname"] = strings.TrimPrefix(u.Path, "/")
+	for key, values := range u.Query() {
+		if !knownParam(key) {
+			return fmt.Errorf("pgwire: invalid connection option %q", key)
+		}
+		params[key] = values[len(values)-1]
+	}
+	return nil
+}
+
+func parseKeywords(s string, params map[string]string) error {
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		key, rest, ok := strings.Cut(s, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return fmt.Errorf("pgwire: missing \"=\" after %q in connection string", key)
+		}
+		if !knownParam(key) {
+			return fmt.Errorf("pgwire: invalid connection option %q", key)
+		}
+		rest = strings.TrimLeft(rest, " ")
+		var value strings.Builder
+		quoted := strings.HasPrefix(rest, "'")
+		if quoted {
+			rest = rest[1:]
+		}
+		i := 0
+		for ; i < len(rest); i++ {
+			c := rest[i]
+			if c == '\\' && i+1 < len(rest) {
+				i++
+				value.WriteByte(rest[i])
+				continue
+			}
+			if quoted && c == '\'' || !quoted && c == ' ' {
+				break
+			}
+			value.WriteByte(c)
+		}
+		if quoted {
+			if i == len(rest) {
+				return fmt.Errorf("pgwire: unterminated quoted string in connection string")
+			}
+			i++
+		}
+		params[key] = value.String()
+		s = rest[i:]
+	}
+	return nil
+}
+
+// knownParam reports whether key is a connection option ParseDSN takes.
+func knownParam(key string) bool {
+	switch key {
+	case "host", "port", "user", "password", "dbname", "sslmode", "replication":
+		return true
+	}
+	return false
+}
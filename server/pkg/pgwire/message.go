@@ -0,0 +1,211 @@
+package pgwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// maxMessageSize bounds the messages a client may send, as PostgreSQL
+// bounds them at 1 GB.
+const maxMessageSize = 1 << 30
+
+// Codes of the startup messages that are not a StartupMessage.
+const (
+	protocolVersion3 = 3 << 16
+	cancelRequest    = 1234<<16 | 5678
+	sslRequest       = 1234<<16 | 5679
+	gssEncRequest    = 1234<<16 | 5680
+)
+
+// reader reads the messages a client sends.
+type reader struct {
+	r   *bufio.Reader
+	buf []byte
+	// start is when the first byte of the last message read arrived, and
+	// end when its last did.
+	start, end time.Time
+}
+
+// readStartup reads a message of the startup phase, which has no type
+// byte.
+func (r *reader) readStartup() ([]byte, error) {
+	return r.readBody()
+}
+
+// read reads a message and returns its type and body. The body is valid
+// until the next read.
+func (r *reader) read() (byte, []byte, error) {
+	typ, err := r.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	r.start = time.Now()
+	body, err := r.readBody()
+	r.end = time.Now()
+	return typ, body, err
+}
+
+func (r *reader) readBody() ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r.r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(hdr[:])) - 4
+	if n < 0 || n > maxMessageSize {
+		return nil, pgerror.Newf(pgerror.CodeProtocolViolation, "invalid message length %d", n+4)
+	}
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	r.buf = r.buf[:n]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		return nil, err
+	}
+	return r.buf, nil
+}
+
+// message reads the fields of a message body in order. Reading past its
+// end sets err, and leaves the remaining fields zero.
+type message struct {
+	b   []byte
+	err error
+}
+
+func (m *message) short() {
+	if m.err == nil {
+		m.err = pgerror.New(pgerror.CodeProtocolViolation, "invalid message format")
+	}
+	m.b = nil
+}
+
+func (m *message) byte() byte {
+	if len(m.b) < 1 {
+		m.short()
+		return 0
+	}
+	c := m.b[0]
+	m.b = m.b[1:]
+	return c
+}
+
+func (m *message) int16() int16 {
+	if len(m.b) < 2 {
+		m.short()
+		return 0
+	}
+	v := int16(binary.BigEndian.Uint16(m.b))
+	m.b = m.b[2:]
+	return v
+}
+
+func (m *message) int32() int32 {
+	if len(m.b) < 4 {
+		m.short()
+		return 0
+	}
+	v := int32(binary.BigEndian.Uint32(m.b))
+	m.b = m.b[4:]
+	return v
+}
+
+func (m *message) int64() int64 {
+	if len(m.b) < 8 {
+		m.short()
+		return 0
+	}
+	v := int64(binary.BigEndian.Uint64(m.b))
+	m.b = m.b[8:]
+	return v
+}
+
+// string reads a null-terminated string.
+func (m *message) string() string {
+	for i, c := range m.b {
+		if c == 0 {
+			s := string(m.b[:i])
+			m.b = m.b[i+1:]
+			return s
+		}
+	}
+	m.short()
+	return ""
+}
+
+// bytes reads n bytes, or returns nil if n is -1, the length of NULL.
+func (m *message) bytes(n int32) []byte {
+	switch {
+	case n == -1:
+		return nil
+	case n < 0 || int(n) > len(m.b):
+		m.short()
+		return nil
+	}
+	b := m.b[:n:n]
+	m.b = m.b[n:]
+	return b
+}
+
+// writer buffers the messages the server sends.
+type writer struct {
+	w *bufio.Writer
+	// msg is the message being written, with its type byte and a
+	// placeholder for its length.
+	msg []byte
+}
+
+// start starts a message of type typ.
+func (w *writer) start(typ byte) {
+	w.msg = append(w.msg[:0], typ, 0, 0, 0, 0)
+}
+
+func (w *writer) byte(c byte) {
+	w.msg = append(w.msg, c)
+}
+
+func (w *writer) int16(v int16) {
+	w.msg = binary.BigEndian.AppendUint16(w.msg, uint16(v))
+}
+
+func (w *writer) int32(v int32) {
+	w.msg = binary.BigEndian.AppendUint32(w.msg, uint32(v))
+}
+
+func (w *writer) int64(v int64) {
+	w.msg = binary.BigEndian.AppendUint64(w.msg, uint64(v))
+}
+
+// string writes a null-terminated string.
+func (w *writer) string(s string) {
+	w.msg = append(append(w.msg, s...), 0)
+}
+
+func (w *writer) bytes(b []byte) {
+	w.msg = append(w.msg, b...)
+}
+
+// end finishes the message and buffers it.
+func (w *writer) end() error {
+	binary.BigEndian.PutUint32(w.msg[1:], uint32(len(w.msg)-1))
+	_, err := w.w.Write(w.msg)
+	return err
+}
+
+// empty buffers a message of type typ without a body.
+func (w *writer) empty(typ byte) error {
+	w.start(typ)
+	return w.end()
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
+
+// unexpected returns the error for a message of type typ the server did
+// not expect.
+func unexpected(typ byte) error {
+	return pgerror.Newf(pgerror.CodeProtocolViolation, "unexpected message type 0x%02x", typ)
+}
@@ -0,0 +1,37 @@
+package pgwire
+
+import (
+	"errors"
+	"net"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// peerUser returns the name of the operating system user running the
+// process at the other end of the Unix socket nc.
+func peerUser(nc net.Conn) (string, error) {
+	uc, ok := nc.(*net.UnixConn)
+	if !ok {
+		return "", errors.New("not a Unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return "", err
+	}
+	if credErr != nil {
+		return "", credErr
+	}
+	u, err := user.LookupId(strconv.Itoa(int(cred.Uid)))
+	if err != nil {
+		return "", err
+	}
+	return u.Username, nil
+}
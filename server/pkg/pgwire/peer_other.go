@@ -0,0 +1,15 @@
+//go:build !linux
+
+package pgwire
+
+import (
+	"errors"
+	"net"
+)
+
+// peerUser returns the name of the operating system user running the
+// process at the other end of the Unix socket nc. Peer credentials are
+// only read on Linux.
+func peerUser(nc net.Conn) (string, error) {
+	return "", errors.New("peer authentication is not supported on this platform")
+}
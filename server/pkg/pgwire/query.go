@@ -0,0 +1,472 @@
+package pgwire
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// Formats of values.
+const (
+	formatText   = 0
+	formatBinary = 1
+)
+
+// query runs the statements of a Query message, the simple query
+// protocol, sending the result of each as it ends.
+func (c *conn) query(m *message) error {
+	text := m.string()
+	if m.err != nil {
+		return m.err
+	}
+	if c.replication {
+		if cmd := parseReplicationCommand(text); cmd != nil {
+			return c.replicate(cmd)
+		}
+		if c.physical {
+			c.sendError(errPhysicalSQL(), "ERROR")
+			return c.readyForQuery()
+		}
+	} else if cmd := parseReplicationCommand(text); cmd != nil {
+		c.sendError(pgerror.Newf(pgerror.CodeObjectNotInPrerequisite,
+			"%s can only be run on a replication connection", cmd.name).
+			WithHint("Connect with replication=database for logical replication, or replication=true for physical."), "ERROR")
+		return c.readyForQuery()
+	}
+	release, err := c.admit()
+	if err != nil {
+		c.sendError(err, "ERROR")
+		return c.readyForQuery()
+	}
+	defer release()
+	in := &copyIn{c: c}
+	n := 0
+	err = c.sess.ExecFunc(text, in, func(res *sql.Result) error {
+		n++
+		return c.sendResult(res, nil, res.Rows, res.Tag)
+	})
+	in.drain()
+	switch {
+	case c.sess.Terminated():
+		return nil
+	case err != nil:
+		if pgerror.Flatten(err).Code == pgerror.CodeProtocolViolation && in.started {
+			return err
+		}
+		c.sendError(err, "ERROR")
+	case n == 0:
+		c.wr.empty('I')
+	}
+	return c.readyForQuery()
+}
+
+// sendResult sends rows of the result res, in formats, and then its
+// command tag: RowDescription and DataRow messages for statements
+// returning rows, and CommandComplete.
+func (c *conn) sendResult(res *sql.Result, formats []int16, rows [][]types.Datum, tag string) error {
+	if res.Columns != nil {
+		if err := c.rowDescription(res.Columns, formats); err != nil {
+			return err
+		}
+		if err := c.dataRows(res.Columns, formats, rows); err != nil {
+			return err
+		}
+	}
+	c.commandComplete(tag)
+	return nil
+}
+
+func (c *conn) commandComplete(tag string) {
+	c.wr.start('C')
+	c.wr.string(tag)
+	c.wr.end()
+}
+
+// rowDescription sends the RowDescription of rows of cols in formats.
+func (c *conn) rowDescription(cols []planner.Column, formats []int16) error {
+	c.wr.start('T')
+	c.wr.int16(int16(len(cols)))
+	for i, col := range cols {
+		f, err := resultFormat(formats, i)
+		if err != nil {
+			return err
+		}
+		c.wr.string(c.encode(col.Name))
+		// The columns are not identified as columns of tables.
+		c.wr.int32(0)
+		c.wr.int16(0)
+		c.wr.int32(int32(col.Type.Oid))
+		c.wr.int16(int16(vtable.TypeLen(col.Type)))
+		c.wr.int32(typmod(col.Type))
+		c.wr.int16(f)
+	}
+	c.wr.end()
+	return nil
+}
+
+// typmod is the type modifier of t, as PostgreSQL's atttypmod records it,
+// or -1 if it has none.
+func typmod(t *types.T) int32 {
+	switch {
+	case t.Family == types.StringFamily && t.Width > 0:
+		return t.Width + 4
+	case t.Family == types.DecimalFamily && t.Precision > 0:
+		return t.Precision<<16 | t.Scale + 4
+	case t.Family == types.BitFamily && t.Width > 0:
+		return t.Width
+	}
+	return -1
+}
+
+// resultFormat returns the format of column i from the result formats of
+// Bind: none means text, one applies to all columns.
+func resultFormat(formats []int16, i int) (int16, error) {
+	switch len(formats) {
+	case 0:
+		return formatText, nil
+	case 1:
+		i = 0
+	}
+	if i >= len(formats) {
+		return 0, pgerror.Newf(pgerror.CodeProtocolViolation, "bind message has %d result formats but query has %d columns", len(formats), i+1)
+	}
+	if f := formats[i]; f != formatText && f != formatBinary {
+		return 0, pgerror.Newf(pgerror.CodeProtocolViolation, "unsupported format code: %d", f)
+	}
+	return formats[i], nil
+}
+
+// dataRows sends rows of cols as DataRow messages, their values in
+// formats.
+func (c *conn) dataRows(cols []planner.Column, formats []int16, rows [][]types.Datum) error {
+	for _, row := range rows {
+		c.wr.start('D')
+		c.wr.int16(int16(len(row)))
+		for i, d := range row {
+			if d == types.DNull {
+				c.wr.int32(-1)
+				continue
+			}
+			f, err := resultFormat(formats, i)
+			if err != nil {
+				return err
+			}
+			var b []byte
+			if f == formatBinary {
+				b, err = c.encodeBinary(cols[i].Type, d)
+			} else {
+				b, err = c.sess.EncodeText(c.sess.FormatText(d))
+			}
+			if err != nil {
+				return err
+			}
+			c.wr.int32(int32(len(b)))
+			c.wr.bytes(b)
+		}
+		c.wr.end()
+	}
+	return nil
+}
+
+// portal is a prepared statement bound to parameter values by Bind, which
+// Execute runs.
+type portal struct {
+	stmt    *sql.PreparedStatement
+	args    []types.Datum
+	formats []int16
+	// res is the result of the statement once it ran, of which sent rows
+	// have been sent.
+	res  *sql.Result
+	sent int
+}
+
+// parse handles Parse, which prepares a statement.
+func (c *conn) parse(m *message) error {
+	name, query := m.string(), m.string()
+	typs := make([]*types.T, max(m.int16(), 0))
+	for i := range typs {
+		oid := types.Oid(m.int32())
+		if oid == 0 || m.err != nil {
+			continue
+		}
+		if typs[i] = types.TypeForOid(oid); typs[i] == nil {
+			return pgerror.Newf(pgerror.CodeUndefinedObject, "type with OID %d does not exist", oid)
+		}
+	}
+	if m.err != nil {
+		return m.err
+	}
+	if _, err := c.sess.Prepare(name, query, typs); err != nil {
+		return err
+	}
+	return c.wr.empty('1')
+}
+
+// formatCodes reads the format codes of n values from m: none means text,
+// one applies to all of them.
+func formatCodes(m *message) []int16 {
+	formats := make([]int16, max(m.int16(), 0))
+	for i := range formats {
+		formats[i] = m.int16()
+	}
+	return formats
+}
+
+// bind handles Bind, which binds a prepared statement to parameter values
+// as a portal.
+func (c *conn) bind(m *message) error {
+	name, stmtName := m.string(), m.string()
+	paramFormats := formatCodes(m)
+	values := make([][]byte, max(m.int16(), 0))
+	for i := range values {
+		values[i] = m.bytes(m.int32())
+	}
+	formats := formatCodes(m)
+	if m.err != nil {
+		return m.err
+	}
+	ps := c.sess.Prepared(stmtName)
+	if ps == nil {
+		return pgerror.Newf(pgerror.CodeInvalidSQLStatementName, "prepared statement %q does not exist", stmtName)
+	}
+	if len(values) != len(ps.ParamTypes) {
+		return pgerror.Newf(pgerror.CodeProtocolViolation,
+			"bind message supplies %d parameters, but prepared statement %q requires %d", len(values), stmtName, len(ps.ParamTypes))
+	}
+	if len(paramFormats) > 1 && len(paramFormats) != len(values) {
+		return pgerror.Newf(pgerror.CodeProtocolViolation,
+			"bind message has %d parameter formats but %d parameters", len(paramFormats), len(values))
+	}
+	if _, ok := c.portals[name]; ok && name != "" {
+		return pgerror.Newf(pgerror.CodeDuplicateCursor, "portal %q already exists", name)
+	}
+	args := make([]types.Datum, len(values))
+	for i, v := range values {
+		f := int16(formatText)
+		switch len(paramFormats) {
+		case 1:
+			f = paramFormats[0]
+		case len(values):
+			f = paramFormats[i]
+		}
+		var err error
+		switch {
+		case v == nil:
+			args[i] = types.DNull
+		case f == formatBinary:
+			args[i], err = c.decodeBinary(ps.ParamTypes[i], v)
+		case f == formatText:
+			// The value is cast to the type of its parameter when the
+			// statement runs.
+			var s string
+			s, err = c.sess.DecodeText(v)
+			args[i] = types.DString(s)
+		default:
+			err = pgerror.Newf(pgerror.CodeProtocolViolation, "unsupported format code: %d", f)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if c.portals == nil {
+		c.portals = make(map[string]*portal)
+	}
+	c.portals[name] = &portal{stmt: ps, args: args, formats: formats}
+	return c.wr.empty('2')
+}
+
+// describe handles Describe, which describes the parameters and rows of a
+// prepared statement, or the rows of a portal.
+func (c *conn) describe(m *message) error {
+	kind, name := m.byte(), m.string()
+	if m.err != nil {
+		return m.err
+	}
+	var cols []planner.Column
+	var formats []int16
+	switch kind {
+	case 'S':
+		ps := c.sess.Prepared(name)
+		if ps == nil {
+			return pgerror.Newf(pgerror.CodeInvalidSQLStatementName, "prepared statement %q does not exist", name)
+		}
+		c.wr.start('t')
+		c.wr.int16(int16(len(ps.ParamTypes)))
+		for _, t := range ps.ParamTypes {
+			oid := types.OidUnknown
+			if t != nil {
+				oid = t.Oid
+			}
+			c.wr.int32(int32(oid))
+		}
+		c.wr.end()
+		cols = ps.Columns
+	case 'P':
+		p, err := c.portal(name)
+		if err != nil {
+			return err
+		}
+		cols, formats = p.stmt.Columns, p.formats
+	default:
+		return pgerror.Newf(pgerror.CodeProtocolViolation, "invalid DESCRIBE message subtype %d", kind)
+	}
+	if len(cols) == 0 {
+		return c.wr.empty('n')
+	}
+	return c.rowDescription(cols, formats)
+}
+
+func (c *conn) portal(name string) (*portal, error) {
+	p := c.portals[name]
+	if p == nil {
+		return nil, pgerror.Newf(pgerror.CodeInvalidCursorName, "portal %q does not exist", name)
+	}
+	return p, nil
+}
+
+// execute handles Execute, which runs a portal, sending at most maxRows of
+// its rows if maxRows is positive. A portal that has more rows is
+// suspended, and a later Execute sends the next ones.
+func (c *conn) execute(m *message) error {
+	name, maxRows := m.string(), m.int32()
+	if m.err != nil {
+		return m.err
+	}
+	p, err := c.portal(name)
+	if err != nil {
+		return err
+	}
+	if p.stmt.Stmt == nil {
+		return c.wr.empty('I')
+	}
+	resumed := p.res != nil
+	if !resumed {
+		release, err := c.admit()
+		if err != nil {
+			return err
+		}
+		p.res, err = c.sess.ExecPrepared(p.stmt.Name, p.args)
+		release()
+		if err != nil {
+			return err
+		}
+	}
+	if p.res.Columns == nil {
+		if !resumed {
+			c.commandComplete(p.res.Tag)
+		}
+		return nil
+	}
+	rows := p.res.Rows[p.sent:]
+	suspended := maxRows > 0 && int(maxRows) < len(rows)
+	if suspended {
+		rows = rows[:maxRows]
+	}
+	p.sent += len(rows)
+	if err := c.dataRows(p.res.Columns, p.formats, rows); err != nil {
+		return err
+	}
+	switch {
+	case suspended:
+		return c.wr.empty('s')
+	case resumed || maxRows > 0:
+		// The tag counts the rows of this Execute.
+		tag := p.res.Tag
+		if i := strings.LastIndexByte(tag, ' '); i >= 0 {
+			tag = tag[:i+1] + strconv.Itoa(len(rows))
+		}
+		c.commandComplete(tag)
+	default:
+		c.commandComplete(p.res.Tag)
+	}
+	return nil
+}
+
+// close handles Close, which closes a prepared statement or a portal.
+// Closing one that does not exist is not an error.
+func (c *conn) close(m *message) error {
+	kind, name := m.byte(), m.string()
+	if m.err != nil {
+		return m.err
+	}
+	switch kind {
+	case 'S':
+		c.sess.Deallocate(name)
+	case 'P':
+		delete(c.portals, name)
+	default:
+		return pgerror.Newf(pgerror.CodeProtocolViolation, "invalid CLOSE message subtype %d", kind)
+	}
+	return c.wr.empty('3')
+}
+
+// copyIn reads the data of COPY FROM STDIN from the client's CopyData
+// messages, asking the client for them with CopyInResponse once COPY
+// starts reading.
+type copyIn struct {
+	c       *conn
+	started bool
+	// done is set once the client ended the data, with CopyDone or, with
+	// the error err, CopyFail.
+	done bool
+	err  error
+	buf  []byte
+}
+
+func (r *copyIn) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.c.wr.start('G')
+		r.c.wr.byte(formatText)
+		r.c.wr.int16(0)
+		r.c.wr.end()
+		if err := r.c.wr.flush(); err != nil {
+			r.done, r.err = true, err
+		}
+	}
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads the next message of the COPY data.
+func (r *copyIn) next() {
+	typ, body, err := r.c.rd.read()
+	if err != nil {
+		r.done, r.err = true, err
+		return
+	}
+	switch typ {
+	case 'd':
+		r.buf = append(r.buf[:0], body...)
+	case 'c':
+		r.done, r.err = true, io.EOF
+	case 'f':
+		m := &message{b: body}
+		r.done, r.err = true, pgerror.Newf(pgerror.CodeQueryCanceled, "COPY from stdin failed: %s", m.string())
+	case 'H', 'S':
+		// Flush and Sync are ignored during COPY.
+	default:
+		r.done, r.err = true, pgerror.Newf(pgerror.CodeProtocolViolation, "unexpected message type 0x%02x during COPY from stdin", typ)
+	}
+}
+
+// drain skips the rest of the COPY data if COPY ended before the client
+// did, having failed.
+func (r *copyIn) drain() {
+	for r.started && !r.done {
+		r.next()
+	}
+}
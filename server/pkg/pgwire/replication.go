@@ -0,0 +1,828 @@
+package pgwire
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/exec"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// A connection started with replication=database is a walsender
+// connection, as in PostgreSQL: besides SQL, its simple queries may be the
+// commands of the replication protocol, which create, drop and stream
+// from logical replication slots. Streaming sends the changes with the
+// pgoutput protocol.
+//
+// A connection started with replication=true is a walsender of physical
+// replication, which runs no SQL. START_REPLICATION PHYSICAL lsn streams
+// the changes the storage engine commits after the sequence number lsn,
+// those of every database, as read replicas apply them: each transaction
+// in an XLogData message at its sequence number, whose data is its
+// changes, each a byte of its engine.ChangeKind and its key and value,
+// each prefixed by its length as an Int32.
+
+// keepaliveInterval is how often streaming sends the client a keepalive
+// while there are no changes to send.
+const keepaliveInterval = 10 * time.Second
+
+// postgresEpoch is the epoch of the times of the replication protocol.
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// replicationCommands maps the commands of the replication protocol to
+// whether they are supported.
+var replicationCommands = map[string]bool{
+	"IDENTIFY_SYSTEM":         true,
+	"CREATE_REPLICATION_SLOT": true,
+	"DROP_REPLICATION_SLOT":   true,
+	"START_REPLICATION":       true,
+	"READ_REPLICATION_SLOT":   false,
+	"TIMELINE_HISTORY":        false,
+	"BASE_BACKUP":             false,
+	"UPLOAD_MANIFEST":         false,
+}
+
+// Kinds of the tokens of replication commands.
+const (
+	tokWord   = iota // a keyword, an unquoted name or an LSN
+	tokIdent         // a quoted name
+	tokString        // a string literal
+	tokPunct         // a parenthesis or comma
+)
+
+type replToken struct {
+	kind int
+	text string
+}
+
+// replicationCommand is a command of the replication protocol, read token
+// by token as it is run.
+type replicationCommand struct {
+	name string
+	toks []replToken
+	err  error
+}
+
+// parseReplicationCommand returns the replication command text is, or nil
+// if it is SQL.
+func parseReplicationCommand(text string) *replicationCommand {
+	toks, err := tokenizeReplication(text)
+	if len(toks) == 0 || toks[0].kind != tokWord {
+		return nil
+	}
+	name := strings.ToUpper(toks[0].text)
+	if _, ok := replicationCommands[name]; !ok {
+		return nil
+	}
+	return &replicationCommand{name: name, toks: toks[1:], err: err}
+}
+
+// tokenizeReplication splits a replication command into tokens, ignoring
+// a trailing semicolon.
+func tokenizeReplication(text string) ([]replToken, error) {
+	var toks []replToken
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case c == ';' && strings.TrimSpace(text[i+1:]) == "":
+			return toks, nil
+		case c == '(' || c == ')' || c == ',':
+			toks = append(toks, replToken{tokPunct, string(c)})
+			i++
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(text) {
+					return toks, syntaxError()
+				}
+				if text[j] == c {
+					if j+1 < len(text) && text[j+1] == c {
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(text[j])
+				j++
+			}
+			kind := tokString
+			if c == '"' {
+				kind = tokIdent
+			}
+			toks = append(toks, replToken{kind, b.String()})
+			i = j + 1
+		default:
+			j := i
+			for j < len(text) && !unicode.IsSpace(rune(text[j])) && !strings.ContainsRune("(),;'\"", rune(text[j])) {
+				j++
+			}
+			if j == i {
+				return toks, syntaxError()
+			}
+			toks = append(toks, replToken{tokWord, text[i:j]})
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func syntaxError() error {
+	return pgerror.New(pgerror.CodeSyntaxError, "syntax error in replication command")
+}
+
+// peek returns the next token, or a zero token at the end.
+func (cmd *replicationCommand) peek() replToken {
+	if len(cmd.toks) == 0 {
+		return replToken{kind: -1}
+	}
+	return cmd.toks[0]
+}
+
+// keyword consumes the next token if it is the keyword kw.
+func (cmd *replicationCommand) keyword(kw string) bool {
+	if t := cmd.peek(); t.kind == tokWord && strings.EqualFold(t.text, kw) {
+		cmd.toks = cmd.toks[1:]
+		return true
+	}
+	return false
+}
+
+// punct consumes the next token if it is p.
+func (cmd *replicationCommand) punct(p string) bool {
+	if t := cmd.peek(); t.kind == tokPunct && t.text == p {
+		cmd.toks = cmd.toks[1:]
+		return true
+	}
+	return false
+}
+
+// ident consumes a name, folding it to lower case unless it is quoted.
+func (cmd *replicationCommand) ident() string {
+	t := cmd.peek()
+	switch t.kind {
+	case tokWord:
+		cmd.toks = cmd.toks[1:]
+		return strings.ToLower(t.text)
+	case tokIdent:
+		cmd.toks = cmd.toks[1:]
+		return t.text
+	}
+	cmd.fail()
+	return ""
+}
+
+// word consumes an unquoted word, such as an LSN.
+func (cmd *replicationCommand) word() string {
+	t := cmd.peek()
+	if t.kind != tokWord {
+		cmd.fail()
+		return ""
+	}
+	cmd.toks = cmd.toks[1:]
+	return t.text
+}
+
+// options consumes a parenthesized list of options, each a name with an
+// optional value, or nothing if there is none.
+func (cmd *replicationCommand) options() [][2]string {
+	if !cmd.punct("(") {
+		return nil
+	}
+	var opts [][2]string
+	for cmd.err == nil {
+		name := cmd.ident()
+		var value string
+		if t := cmd.peek(); t.kind == tokString || t.kind == tokWord || t.kind == tokIdent {
+			value = t.text
+			cmd.toks = cmd.toks[1:]
+		}
+		opts = append(opts, [2]string{name, value})
+		if cmd.punct(")") {
+			break
+		}
+		if !cmd.punct(",") {
+			cmd.fail()
+		}
+	}
+	return opts
+}
+
+func (cmd *replicationCommand) fail() {
+	if cmd.err == nil {
+		cmd.err = syntaxError()
+	}
+	cmd.toks = nil
+}
+
+// end returns the error of parsing the command, if it failed or did not
+// consume all of it.
+func (cmd *replicationCommand) end() error {
+	if len(cmd.toks) > 0 {
+		cmd.fail()
+	}
+	return cmd.err
+}
+
+// replicate runs a replication command, sending its result.
+func (c *conn) replicate(cmd *replicationCommand) error {
+	var res *sql.Result
+	var err error
+	switch {
+	case c.sess.InTxn():
+		err = pgerror.Newf(pgerror.CodeActiveSQLTransaction, "%s cannot run inside a transaction block", cmd.name)
+	case !replicationCommands[cmd.name]:
+		err = pgerror.Newf(pgerror.CodeFeatureNotSupported, "%s is not supported", cmd.name)
+	case cmd.name == "IDENTIFY_SYSTEM":
+		res, err = c.identifySystem(cmd)
+	case cmd.name == "CREATE_REPLICATION_SLOT":
+		res, err = c.createReplicationSlot(cmd)
+	case cmd.name == "DROP_REPLICATION_SLOT":
+		res, err = c.dropReplicationSlot(cmd)
+	case cmd.name == "START_REPLICATION":
+		var src replicationSource
+		if src, err = c.startReplication(cmd); err == nil {
+			return c.stream(src)
+		}
+	}
+	switch {
+	case c.sess.Terminated():
+		return nil
+	case err != nil:
+		c.sendError(err, "ERROR")
+	default:
+		c.sendResult(res, nil, res.Rows, res.Tag)
+	}
+	return c.readyForQuery()
+}
+
+// replicationResult returns the result of a replication command with a
+// single row of text columns named names, NULL for nil values.
+func replicationResult(tag string, names []string, values ...*string) *sql.Result {
+	res := &sql.Result{Tag: tag}
+	if names == nil {
+		return res
+	}
+	row := make([]types.Datum, len(values))
+	for i, name := range names {
+		res.Columns = append(res.Columns, planner.Column{Name: name, Type: types.String})
+		row[i] = types.DNull
+		if values[i] != nil {
+			row[i] = types.DString(*values[i])
+		}
+	}
+	res.Rows = [][]types.Datum{row}
+	return res
+}
+
+// identifySystem runs IDENTIFY_SYSTEM, which reports the system
+// identifier, the timeline, the current LSN and the database, NULL for
+// physical replication. There is no system identifier, which is reported
+// as 0, and a single timeline.
+func (c *conn) identifySystem(cmd *replicationCommand) (*sql.Result, error) {
+	if err := cmd.end(); err != nil {
+		return nil, err
+	}
+	lsn, err := c.sess.CurrentLSN()
+	if err != nil {
+		return nil, err
+	}
+	dbname := types.Datum(types.DString(c.sess.Database()))
+	if c.physical {
+		dbname = types.DNull
+	}
+	return &sql.Result{Tag: cmd.name, Result: exec.Result{
+		Columns: []planner.Column{
+			{Name: "systemid", Type: types.String}, {Name: "timeline", Type: types.Int4},
+			{Name: "xlogpos", Type: types.String}, {Name: "dbname", Type: types.String},
+		},
+		Rows: [][]types.Datum{{types.DString("0"), types.DInt(1), types.DString(catalog.FormatLSN(lsn)), dbname}},
+	}}, nil
+}
+
+// createReplicationSlot runs CREATE_REPLICATION_SLOT name LOGICAL plugin
+// [options], which creates a logical replication slot. There are no
+// temporary or physical slots, and no snapshots to export: the options
+// asking for them are ignored, as the slot's changes start at the
+// transactions that commit after it is created.
+func (c *conn) createReplicationSlot(cmd *replicationCommand) (*sql.Result, error) {
+	name := cmd.ident()
+	if cmd.keyword("TEMPORARY") {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "temporary replication slots are not supported")
+	}
+	if cmd.keyword("PHYSICAL") {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "physical replication slots are not supported")
+	}
+	if !cmd.keyword("LOGICAL") {
+		cmd.fail()
+	}
+	if c.physical {
+		return nil, errLogicalDatabase()
+	}
+	plugin := cmd.ident()
+	if !cmd.keyword("EXPORT_SNAPSHOT") && !cmd.keyword("NOEXPORT_SNAPSHOT") && !cmd.keyword("USE_SNAPSHOT") && !cmd.keyword("TWO_PHASE") {
+		cmd.options()
+	}
+	if err := cmd.end(); err != nil {
+		return nil, err
+	}
+	// Creating the slot waits for transactions to end, which terminating
+	// the session interrupts.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.sess.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	lsn, err := c.sess.CreateReplicationSlot(ctx, name, plugin)
+	if err != nil {
+		return nil, err
+	}
+	point := catalog.FormatLSN(lsn)
+	return replicationResult(cmd.name, []string{"slot_name", "consistent_point", "snapshot_name", "output_plugin"},
+		&name, &point, nil, &plugin), nil
+}
+
+// dropReplicationSlot runs DROP_REPLICATION_SLOT name [WAIT]. A slot being
+// streamed from cannot be dropped, with or without WAIT.
+func (c *conn) dropReplicationSlot(cmd *replicationCommand) (*sql.Result, error) {
+	name := cmd.ident()
+	cmd.keyword("WAIT")
+	if err := cmd.end(); err != nil {
+		return nil, err
+	}
+	if c.physical {
+		return nil, errLogicalDatabase()
+	}
+	if err := c.sess.DropReplicationSlot(name); err != nil {
+		return nil, err
+	}
+	return replicationResult(cmd.name, nil), nil
+}
+
+// startReplication starts START_REPLICATION SLOT name LOGICAL lsn
+// (options), with the options of pgoutput: proto_version and
+// publication_names, which are required, and binary, which must be off.
+// Its other options are ignored: transactions are sent as they commit,
+// and only those of the changes. START_REPLICATION [PHYSICAL] lsn
+// [TIMELINE tli] starts physical replication, which has no slots.
+func (c *conn) startReplication(cmd *replicationCommand) (replicationSource, error) {
+	var name string
+	if cmd.keyword("SLOT") {
+		name = cmd.ident()
+	}
+	if !cmd.keyword("LOGICAL") {
+		return c.startPhysical(cmd, name)
+	}
+	if name == "" {
+		cmd.fail()
+	}
+	if c.physical {
+		return nil, errLogicalDatabase()
+	}
+	lsnText := cmd.word()
+	opts := cmd.options()
+	if err := cmd.end(); err != nil {
+		return nil, err
+	}
+	start, err := catalog.ParseLSN(lsnText)
+	if err != nil {
+		return nil, err
+	}
+	var version string
+	var publications []string
+	for _, o := range opts {
+		switch o[0] {
+		case "proto_version":
+			version = o[1]
+		case "publication_names":
+			if publications, err = splitIdentifiers(o[1]); err != nil {
+				return nil, err
+			}
+		case "binary":
+			if on, _ := strconv.ParseBool(o[1]); on || o[1] == "on" {
+				return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "binary transfer of changes is not supported")
+			}
+		case "messages", "streaming", "two_phase", "origin":
+		default:
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized pgoutput option: %s", o[0])
+		}
+	}
+	switch v, err := strconv.Atoi(version); {
+	case version == "":
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "proto_version option missing")
+	case err != nil:
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "invalid proto_version: %q", version)
+	case v < 1 || v > 4:
+		return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported,
+			"client sent proto_version=%d but server only supports protocol 1 to 4", v)
+	}
+	if publications == nil {
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "publication_names parameter missing")
+	}
+	st, err := c.sess.StartReplication(name, start, publications)
+	if err != nil {
+		return nil, err
+	}
+	return &logicalSource{st: st, out: &pgoutput{c: c, relations: make(map[catalog.ID]*sql.Relation)}}, nil
+}
+
+// startPhysical starts START_REPLICATION [SLOT name] [PHYSICAL] lsn
+// [TIMELINE tli], whose SLOT and PHYSICAL keywords have been read. The
+// timeline, of which there is one, is ignored.
+func (c *conn) startPhysical(cmd *replicationCommand, slot string) (replicationSource, error) {
+	cmd.keyword("PHYSICAL")
+	lsnText := cmd.word()
+	if cmd.keyword("TIMELINE") {
+		cmd.word()
+	}
+	if err := cmd.end(); err != nil {
+		return nil, err
+	}
+	if slot != "" {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "physical replication slots are not supported")
+	}
+	start, err := catalog.ParseLSN(lsnText)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := c.sess.SubscribeChanges(ctx, start)
+	if err != nil {
+		cancel()
+		return nil, changesError(err, start)
+	}
+	return &physicalSource{c: c, changes: changes, cancel: cancel, pos: start}, nil
+}
+
+// changesError returns the error of streaming the changes after pos.
+func changesError(err error, pos uint64) error {
+	if errors.Is(err, engine.ErrChangesCompacted) {
+		return pgerror.Newf(pgerror.CodeUndefinedFile, "requested changes after %s have already been removed", catalog.FormatLSN(pos))
+	}
+	return err
+}
+
+// errLogicalDatabase is the error for the commands of logical replication
+// on a walsender of physical replication.
+func errLogicalDatabase() error {
+	return pgerror.New(pgerror.CodeObjectNotInPrerequisite, "logical decoding requires a database connection")
+}
+
+// errPhysicalSQL is the error for SQL on a walsender of physical
+// replication.
+func errPhysicalSQL() error {
+	return pgerror.New(pgerror.CodeFeatureNotSupported, "cannot execute SQL commands in WAL sender for physical replication")
+}
+
+// splitIdentifiers splits a comma-separated list of names, folding those
+// not double-quoted to lower case.
+func splitIdentifiers(s string) ([]string, error) {
+	toks, err := tokenizeReplication(s)
+	if err != nil {
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "invalid publication_names syntax")
+	}
+	cmd := &replicationCommand{toks: toks}
+	names := []string{}
+	for len(cmd.toks) > 0 {
+		names = append(names, cmd.ident())
+		if len(cmd.toks) > 0 && !cmd.punct(",") {
+			cmd.fail()
+		}
+	}
+	if cmd.err != nil {
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "invalid publication_names syntax")
+	}
+	return names, nil
+}
+
+// A replicationSource is what START_REPLICATION streams: the transactions
+// of a logical replication slot, or the changes of the storage engine.
+type replicationSource interface {
+	// next waits for the next transaction, until ctx is done, and sends
+	// it.
+	next(ctx context.Context) error
+	// position is the position of the stream, after the transactions
+	// sent.
+	position() uint64
+	// confirm records that the client flushed the changes before lsn.
+	confirm(lsn uint64) error
+	close()
+}
+
+// stream streams the transactions of src to the client in CopyBoth mode,
+// until the client ends it with CopyDone, and then completes
+// START_REPLICATION. Each transaction is sent in XLogData messages, and
+// keepalives in between. The client's status updates confirm the
+// position it flushed.
+func (c *conn) stream(src replicationSource) error {
+	defer src.close()
+	c.wr.start('W')
+	c.wr.byte(formatText)
+	c.wr.int16(0)
+	c.wr.end()
+	if err := c.wr.flush(); err != nil {
+		return err
+	}
+
+	// The client's messages are read while the transactions are sent.
+	// Terminating the session interrupts the read, as it does when the
+	// connection is idle.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.streamReplies(src)
+		cancel()
+	}()
+	for {
+		next, cancelNext := context.WithTimeout(ctx, keepaliveInterval)
+		err := src.next(next)
+		cancelNext()
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			if err := <-done; err != nil {
+				return err
+			}
+			// The client ended the stream.
+			c.wr.empty('c')
+			c.commandComplete("START_STREAMING")
+			return c.readyForQuery()
+		case errors.Is(err, context.DeadlineExceeded):
+			c.keepalive(src.position())
+		default:
+			return err
+		}
+		if err := c.wr.flush(); err != nil {
+			return err
+		}
+	}
+}
+
+// streamReplies reads the client's messages while streaming, until it
+// sends CopyDone, for which it returns nil.
+func (c *conn) streamReplies(src replicationSource) error {
+	for {
+		typ, body, err := c.rd.read()
+		if err != nil {
+			return err
+		}
+		m := &message{b: body}
+		switch typ {
+		case 'd':
+			switch m.byte() {
+			case 'r':
+				// A standby status update: the positions the client
+				// wrote, flushed and applied, its time and whether it
+				// asks for a reply.
+				m.int64()
+				flushed := m.int64()
+				if m.err != nil {
+					return m.err
+				}
+				if err := src.confirm(uint64(flushed)); err != nil {
+					c.log.Warn("confirming replication position", "err", err)
+				}
+			case 'h':
+				// Hot standby feedback is about the vacuuming of rows
+				// queries of standbys may read, which there is none of.
+			default:
+				return pgerror.New(pgerror.CodeProtocolViolation, "unexpected message type in CopyData during replication")
+			}
+		case 'c':
+			return nil
+		case 'X':
+			return io.EOF
+		case 'H', 'S':
+		default:
+			return pgerror.Newf(pgerror.CodeProtocolViolation, "unexpected message type 0x%02x during replication", typ)
+		}
+	}
+}
+
+// logicalSource streams the transactions of a logical replication slot
+// with pgoutput.
+type logicalSource struct {
+	st  *sql.ReplicationStream
+	out *pgoutput
+}
+
+func (s *logicalSource) next(ctx context.Context) error {
+	txn, err := s.st.Next(ctx)
+	if err != nil {
+		return err
+	}
+	s.out.send(txn, s.st.Position())
+	return nil
+}
+
+func (s *logicalSource) position() uint64         { return s.st.Position() }
+func (s *logicalSource) confirm(lsn uint64) error { return s.st.Confirm(lsn) }
+func (s *logicalSource) close()                   { s.st.Close() }
+
+// physicalSource streams the changes of the storage engine, each
+// transaction in an XLogData message at its sequence number.
+type physicalSource struct {
+	c       *conn
+	changes <-chan engine.CommittedTxn
+	cancel  context.CancelFunc
+	// pos is the sequence number of the last transaction sent.
+	pos uint64
+}
+
+func (s *physicalSource) next(ctx context.Context) error {
+	var txn engine.CommittedTxn
+	var ok bool
+	select {
+	case txn, ok = <-s.changes:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	switch {
+	case !ok:
+		return engine.ErrClosed
+	case txn.Err != nil:
+		return changesError(txn.Err, s.pos)
+	}
+	s.pos = txn.Seq
+	s.c.xlogData(txn.Seq, txn.Seq)
+	for _, ch := range txn.Changes {
+		s.c.wr.byte(byte(ch.Kind))
+		s.c.wr.int32(int32(len(ch.Key)))
+		s.c.wr.bytes(ch.Key)
+		s.c.wr.int32(int32(len(ch.Value)))
+		s.c.wr.bytes(ch.Value)
+	}
+	s.c.wr.end()
+	return nil
+}
+
+func (s *physicalSource) position() uint64 { return s.pos }
+
+// confirm does nothing: without slots, the engine does not keep changes
+// for replicas.
+func (s *physicalSource) confirm(uint64) error { return nil }
+func (s *physicalSource) close()               { s.cancel() }
+
+// pgoutput encodes transactions in the messages of PostgreSQL's pgoutput
+// plugin.
+type pgoutput struct {
+	c *conn
+	// relations are the tables whose Relation messages were sent, which
+	// the client caches. A table's message is sent again when it changes.
+	relations map[catalog.ID]*sql.Relation
+}
+
+// send sends the messages of txn: Begin, the Relation of the tables it
+// changes the client does not have, its changes and Commit. end is the
+// position of the stream after it.
+func (s *pgoutput) send(txn *sql.ReplicatedTxn, end uint64) {
+	commitTime := replicationTime(txn.CommitTime)
+	s.start(txn.LSN, end, 'B')
+	s.c.wr.int64(int64(txn.LSN))
+	s.c.wr.int64(commitTime)
+	s.c.wr.int32(int32(uint32(txn.LSN)))
+	s.c.wr.end()
+	for _, ch := range txn.Changes {
+		rel := txn.Relations[ch.Relation]
+		s.relation(txn.LSN, end, rel)
+		s.start(txn.LSN, end, ch.Kind)
+		if ch.Kind == 'T' {
+			s.c.wr.int32(1)
+			var options byte
+			if ch.RestartIdentity {
+				options = 2
+			}
+			s.c.wr.byte(options)
+		}
+		s.c.wr.int32(int32(rel.ID))
+		if ch.Old != nil {
+			if rel.FullIdentity {
+				s.c.wr.byte('O')
+			} else {
+				s.c.wr.byte('K')
+			}
+			s.tuple(ch.Old)
+		}
+		if ch.New != nil {
+			s.c.wr.byte('N')
+			s.tuple(ch.New)
+		}
+		s.c.wr.end()
+	}
+	// A client that flushed the transaction confirms the position of its
+	// Commit, where it ends.
+	s.start(txn.LSN+1, end, 'C')
+	s.c.wr.byte(0)
+	s.c.wr.int64(int64(txn.LSN))
+	s.c.wr.int64(int64(txn.LSN + 1))
+	s.c.wr.int64(commitTime)
+	s.c.wr.end()
+}
+
+// start starts a CopyData message of an XLogData message at lsn, with a
+// pgoutput message of type typ.
+func (s *pgoutput) start(lsn, end uint64, typ byte) {
+	s.c.xlogData(lsn, end)
+	s.c.wr.byte(typ)
+}
+
+// relation sends the Relation message of rel unless the client has it.
+func (s *pgoutput) relation(lsn, end uint64, rel *sql.Relation) {
+	if old, ok := s.relations[rel.ID]; ok && sameRelation(old, rel) {
+		return
+	}
+	s.relations[rel.ID] = rel
+	s.start(lsn, end, 'R')
+	s.c.wr.int32(int32(rel.ID))
+	s.c.wr.string(rel.Schema)
+	s.c.wr.string(rel.Name)
+	if rel.FullIdentity {
+		s.c.wr.byte('f')
+	} else {
+		s.c.wr.byte('d')
+	}
+	s.c.wr.int16(int16(len(rel.Columns)))
+	for _, col := range rel.Columns {
+		var flags byte
+		if col.Key {
+			flags = 1
+		}
+		s.c.wr.byte(flags)
+		s.c.wr.string(col.Name)
+		s.c.wr.int32(int32(col.Type.Oid))
+		s.c.wr.int32(typmod(col.Type))
+	}
+	s.c.wr.end()
+}
+
+func sameRelation(a, b *sql.Relation) bool {
+	if a.Schema != b.Schema || a.Name != b.Name || a.FullIdentity != b.FullIdentity || len(a.Columns) != len(b.Columns) {
+		return false
+	}
+	for i, col := range a.Columns {
+		other := b.Columns[i]
+		if col.Name != other.Name || col.Key != other.Key || col.Type.Oid != other.Type.Oid || typmod(col.Type) != typmod(other.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// tuple writes the TupleData of values, in text.
+func (s *pgoutput) tuple(values []*string) {
+	s.c.wr.int16(int16(len(values)))
+	for _, v := range values {
+		if v == nil {
+			s.c.wr.byte('n')
+			continue
+		}
+		s.c.wr.byte('t')
+		s.c.wr.int32(int32(len(*v)))
+		s.c.wr.bytes([]byte(*v))
+	}
+}
+
+// xlogData starts a CopyData message of an XLogData message at lsn, in a
+// stream at end, whose data follows.
+func (c *conn) xlogData(lsn, end uint64) {
+	c.wr.start('d')
+	c.wr.byte('w')
+	c.wr.int64(int64(lsn))
+	c.wr.int64(int64(end))
+	c.wr.int64(replicationTime(time.Now()))
+}
+
+// keepalive sends a primary keepalive message, with the position of the
+// stream.
+func (c *conn) keepalive(end uint64) {
+	c.wr.start('d')
+	c.wr.byte('k')
+	c.wr.int64(int64(end))
+	c.wr.int64(replicationTime(time.Now()))
+	c.wr.byte(0)
+	c.wr.end()
+}
+
+// replicationTime returns t as the replication protocol sends times:
+// microseconds since 2000-01-01.
+func replicationTime(t time.Time) int64 {
+	return t.Sub(postgresEpoch).Microseconds()
+}
@@ -0,0 +1,295 @@
+// Package pgwire serves the sessions of a sql.Server to clients over
+// PostgreSQL's v3 frontend/backend protocol, on TCP and Unix domain
+// sockets.
+//
+// It implements the startup handshake, with SSL on TCP connections, trust,
+// password, SCRAM-SHA-256 and peer authentication, the simple and extended query
+// protocols, COPY FROM STDIN and cancel requests. Values are exchanged in
+// the text format, and in the binary format for the types whose binary
+// form is simple. Clients asking for newer versions of the protocol are
+// downgraded to 3.0, and the features the server lacks fail with errors
+// that name them rather than ending the connection; SQL's
+// pgz_supported_features() lists them.
+//
+// Each connection is served by a goroutine of its own. Config bounds the
+// queries that run at once, queuing or refusing the others under load,
+// and how much of their results is buffered for clients that read them
+// slowly.
+//
+// Client is the other end of the protocol, with which tools such as pgz
+// diff connect to servers, this one or PostgreSQL.
+package pgwire
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/logging"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+// AuthMethod is how clients prove who they connect as.
+type AuthMethod string
+
+const (
+	// AuthTrust lets clients connect as any user.
+	AuthTrust AuthMethod = "trust"
+	// AuthPassword asks clients for the password of the user, in clear
+	// text, which Config.Password checks, or without it the password of
+	// the user's role.
+	AuthPassword AuthMethod = "password"
+	// AuthSCRAM has clients prove that they know the password of the
+	// user's role with SCRAM-SHA-256, without sending it.
+	AuthSCRAM AuthMethod = "scram-sha-256"
+	// AuthPeer lets clients on Unix sockets connect as the user of the
+	// operating system they run as, which the socket's peer credentials
+	// tell (SO_PEERCRED). It is only valid for LocalAuth.
+	AuthPeer AuthMethod = "peer"
+)
+
+// Config is how a Server accepts connections.
+type Config struct {
+	// TLS, if set, is the configuration of the SSL connections clients on
+	// TCP may request. Without it, they are refused SSL.
+	TLS *tls.Config
+	// Auth is how clients on TCP authenticate, and LocalAuth how clients on
+	// Unix sockets do. Empty methods are AuthTrust.
+	Auth, LocalAuth AuthMethod
+	// Password reports whether password is the password of user, for
+	// AuthPassword. Without it, the passwords of roles are accepted.
+	Password func(user, password string) bool
+
+	// MaxActiveQueries bounds the queries that run at once, 0 not at all.
+	// The queries that arrive while that many run wait for one of them to
+	// end, up to MaxQueuedQueries of them, for at most QueueTimeout, or
+	// without a timeout if it is 0. Queries beyond those are refused with
+	// insufficient_resources. The queries of sessions in a transaction
+	// block are not queued, and walsenders are not bounded.
+	MaxActiveQueries, MaxQueuedQueries int
+	QueueTimeout                       time.Duration
+	// SendBufferSize is the size of the buffer of what is sent to each
+	// client, 4 kB if 0. Once it is full, the query sending its results
+	// waits for the client to read them. SendTimeout, if positive, ends
+	// the connections of clients that take longer to read what is sent.
+	// Changes to SendBufferSize apply to new connections.
+	SendBufferSize int
+	SendTimeout    time.Duration
+}
+
+// Server serves a sql.Server's sessions to the clients of its listeners.
+type Server struct {
+	sql       *sql.Server
+	logger    *slog.Logger
+	config    atomic.Pointer[Config]
+	admission admission
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	// keys are the secret keys of the sessions by PID, which cancel
+	// requests must present.
+	keys   map[int32]int32
+	closed bool
+	// conns counts the connections being served.
+	conns sync.WaitGroup
+	// mockSecret salts the mock verifiers of SCRAM exchanges for users
+	// without a password.
+	mockSecret []byte
+}
+
+// ErrServerClosed is returned by Serve once the server is closed.
+var ErrServerClosed = errors.New("pgwire: server closed")
+
+// NewServer returns a server for the sessions of s.
+func NewServer(s *sql.Server, cfg Config) *Server {
+	srv := &Server{
+		sql:       s,
+		logger:    logging.Component(slog.Default(), "pgwire"),
+		listeners: make(map[net.Listener]struct{}),
+		keys:      make(map[int32]int32),
+	}
+	srv.admission.config = srv.config.Load
+	srv.mockSecret = make([]byte, 32)
+	rand.Read(srv.mockSecret)
+	srv.SetConfig(cfg)
+	return srv
+}
+
+// SetConfig changes how the server accepts connections. Connections that
+// are already established keep their authentication and send buffer, but
+// their queries take the new limits, and queued queries that a raised
+// MaxActiveQueries allows to run are started.
+func (s *Server) SetConfig(cfg Config) {
+	s.config.Store(&cfg)
+	s.admission.wake()
+}
+
+// SetLogger sets the logger the server logs connections to, as the
+// pgwire component.
+func (s *Server) SetLogger(l *slog.Logger) {
+	s.logger = logging.Component(l, "pgwire")
+}
+
+// Serve accepts connections on l and serves each in its own goroutine,
+// until the server is closed, when it closes l and returns
+// ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		// Close waits for the connections counted before it closed the
+		// server, so one accepted as it closes must be counted under the
+		// lock or refused.
+		s.mu.Lock()
+		if s.closed {
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.conns.Done()
+			c := newConn(s, nc)
+			c.serve()
+		}()
+	}
+}
+
+// Close stops accepting connections, and waits for the connections being
+// served to end. They end as their clients disconnect, or as their
+// sessions are terminated, as sql.Server.Shutdown terminates them.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	s.mu.Unlock()
+	s.conns.Wait()
+	return err
+}
+
+// register assigns a random secret key to the session pid, which cancel
+// requests for it must present.
+func (s *Server) register(pid int32) int32 {
+	var b [4]byte
+	rand.Read(b[:])
+	key := int32(binary.BigEndian.Uint32(b[:]))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[pid] = key
+	return key
+}
+
+func (s *Server) unregister(pid int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, pid)
+}
+
+// cancel cancels the query the session pid runs, or waits to run, if key
+// is its secret key.
+func (s *Server) cancel(pid, key int32) {
+	s.mu.Lock()
+	k, ok := s.keys[pid]
+	s.mu.Unlock()
+	if ok && k == key && !s.admission.cancel(pid) {
+		s.sql.CancelBackend(pid)
+	}
+}
+
+// SocketPath returns the path of the Unix socket of port in the directory
+// dir, which is named as PostgreSQL names it, such as /tmp/.s.PGSQL.5432.
+func SocketPath(dir string, port int) string {
+	return filepath.Join(dir, ".s.PGSQL."+strconv.Itoa(port))
+}
+
+// ListenUnix listens on the Unix socket of port in the directory dir,
+// which clients such as psql find there when they connect to the same
+// port with the host set to dir. The socket's file is created with the
+// permissions perm, and removed when the listener is closed. A socket file
+// left behind by a server that is no longer running is replaced, but it is
+// an error if another server listens on it.
+//
+// The socket is bound in a directory only the process can enter, and given
+// its permissions there before it is renamed into dir, so that clients
+// never find it with the looser permissions of the umask.
+func ListenUnix(dir string, port int, perm os.FileMode) (net.Listener, error) {
+	path := SocketPath(dir, port)
+	if _, err := os.Stat(path); err == nil {
+		if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("pgwire: another server is listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("pgwire: could not remove stale socket: %w", err)
+		}
+	}
+	private, err := os.MkdirTemp(dir, ".s.PGSQL.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(private)
+	tmp := filepath.Join(private, "socket")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The listener would remove the file by the name it was bound to.
+	l.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener is a listener on the Unix socket at path, which it removes
+// when it is closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *unixListener) Addr() net.Addr { return &net.UnixAddr{Name: l.path, Net: "unix"} }
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() { os.Remove(l.path) })
+	return err
+}
@@ -0,0 +1,254 @@
+package pgwire_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// serve serves a server on the storage engine in memory on a TCP port of
+// the loopback interface, and returns its DSN.
+func serve(t *testing.T, cfg pgwire.Config) *pgwire.DSN {
+	t.Helper()
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := sql.NewServer(memory.New())
+	srv.SetLogger(log)
+	pg := pgwire.NewServer(srv, cfg)
+	pg.SetLogger(log)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go pg.Serve(l)
+	t.Cleanup(func() { pg.Close() })
+	return &pgwire.DSN{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port,
+		User: "postgres", Database: "postgres", SSLMode: "disable"}
+}
+
+func connect(t *testing.T, dsn *pgwire.DSN) *pgwire.Client {
+	t.Helper()
+	c, err := pgwire.Connect(context.Background(), dsn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// text returns the rows of res as text, with NULL for nil.
+func text(res *pgwire.ClientResult) string {
+	var rows [][]string
+	for _, row := range res.Rows {
+		var vals []string
+		for _, v := range row {
+			if v == nil {
+				vals = append(vals, "NULL")
+			} else {
+				vals = append(vals, *v)
+			}
+		}
+		rows = append(rows, vals)
+	}
+	return fmt.Sprint(rows)
+}
+
+func TestSimpleQuery(t *testing.T) {
+	c := connect(t, serve(t, pgwire.Config{}))
+	if v := c.Params["server_version"]; v == "" {
+		t.Error("the server did not report server_version")
+	}
+	res, err := c.Exec(`CREATE TABLE t (id int PRIMARY KEY, v text);
+		INSERT INTO t VALUES (1, 'a'), (2, NULL), (3, '');
+		SELECT id, v AS "Value" FROM t ORDER BY id;
+		UPDATE t SET v = 'b' WHERE id > 1;
+		DELETE FROM t WHERE id = 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags []string
+	for _, r := range res {
+		tags = append(tags, r.Tag)
+	}
+	if got := fmt.Sprint(tags); got != "[CREATE TABLE INSERT 0 3 SELECT 3 UPDATE 2 DELETE 1]" {
+		t.Errorf("command tags %s", got)
+	}
+	sel := res[2]
+	if got := fmt.Sprint(sel.Columns); got != "[id Value]" {
+		t.Errorf("columns %s", got)
+	}
+	// NULL and the empty string are told apart.
+	if got := text(sel); got != "[[1 a] [2 NULL] [3 ]]" {
+		t.Errorf("rows %s", got)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	c := connect(t, serve(t, pgwire.Config{}))
+	// The statements after the one that fails are skipped.
+	res, err := c.Exec("SELECT 1; SELECT * FROM missing; SELECT 2")
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeUndefinedTable {
+		t.Fatalf("%v, want undefined_table", err)
+	}
+	if len(res) != 1 || text(res[0]) != "[[1]]" {
+		t.Errorf("results before the error: %d", len(res))
+	}
+	// The position of a syntax error is sent with it.
+	if _, err = c.Exec("SELECT FROM"); !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeSyntaxError || pgErr.Position != 8 {
+		t.Errorf("%v, want a syntax error at 8", err)
+	}
+	// The connection is usable after errors.
+	res, err = c.Exec("SELECT 1 / 0")
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeDivisionByZero {
+		t.Errorf("%v, want division_by_zero", err)
+	}
+	if res, err = c.Exec("SELECT 'ok'"); err != nil || text(res[0]) != "[[ok]]" {
+		t.Errorf("after errors: %v", err)
+	}
+}
+
+func TestTransactionBlock(t *testing.T) {
+	dsn := serve(t, pgwire.Config{})
+	c := connect(t, dsn)
+	if _, err := c.Exec("CREATE TABLE t (id int PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Exec("BEGIN; INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+	// Another connection does not see the uncommitted row.
+	other := connect(t, dsn)
+	res, err := other.Exec("SELECT count(*) FROM t")
+	if err != nil || text(res[0]) != "[[0]]" {
+		t.Fatalf("before COMMIT: %v, %v", res, err)
+	}
+	// A failed statement aborts the block until it ends.
+	if _, err := c.Exec("SELECT * FROM missing"); err == nil {
+		t.Fatal("SELECT from a missing table succeeded")
+	}
+	_, err = c.Exec("SELECT 1")
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeInFailedSQLTransaction {
+		t.Errorf("%v, want in_failed_sql_transaction", err)
+	}
+	if res, err := c.Exec("COMMIT"); err != nil || res[0].Tag != "ROLLBACK" {
+		t.Errorf("COMMIT of an aborted block: %v, %v", res, err)
+	}
+	res, err = other.Exec("SELECT count(*) FROM t")
+	if err != nil || text(res[0]) != "[[0]]" {
+		t.Errorf("after COMMIT: %v, %v", res, err)
+	}
+}
+
+func TestPasswordAuth(t *testing.T) {
+	dsn := serve(t, pgwire.Config{
+		Auth:     pgwire.AuthPassword,
+		Password: func(user, password string) bool { return password == "secret" },
+	})
+	dsn.Password = "wrong"
+	_, err := pgwire.Connect(context.Background(), dsn)
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeInvalidPassword {
+		t.Fatalf("wrong password: %v, want invalid_password", err)
+	}
+	dsn.Password = "secret"
+	c := connect(t, dsn)
+	if res, err := c.Exec("SELECT 1"); err != nil || text(res[0]) != "[[1]]" {
+		t.Errorf("after authenticating: %v", err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := sql.NewServer(memory.New())
+	srv.SetLogger(log)
+	pg := pgwire.NewServer(srv, pgwire.Config{})
+	pg.SetLogger(log)
+	dir := t.TempDir()
+	l, err := pgwire.ListenUnix(dir, 5432, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go pg.Serve(l)
+	defer pg.Close()
+
+	path := pgwire.SocketPath(dir, 5432)
+	if got := l.Addr().String(); got != path {
+		t.Errorf("listening on %s, want %s", got, path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != os.ModeSocket || fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode %v", fi.Mode())
+	}
+	// Nothing of where it was bound is left in the directory.
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files in the socket directory, want 1", len(entries))
+	}
+	c := connect(t, &pgwire.DSN{Host: dir, Port: 5432, User: "postgres", Database: "postgres", SSLMode: "disable"})
+	if res, err := c.Exec("SELECT 1"); err != nil || text(res[0]) != "[[1]]" {
+		t.Errorf("SELECT 1 on the socket: %v", err)
+	}
+	if _, err := pgwire.ListenUnix(dir, 5432, 0600); err == nil {
+		t.Error("listened twice on the same socket")
+	}
+	c.Close()
+	pg.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the socket was not removed: %v", err)
+	}
+}
+
+func TestCloseWhileAccepting(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := sql.NewServer(memory.New())
+	srv.SetLogger(log)
+	pg := pgwire.NewServer(srv, pgwire.Config{})
+	pg.SetLogger(log)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error)
+	go func() { served <- pg.Serve(l) }()
+	// Clients keep connecting as the server closes.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if nc, err := net.Dial("tcp", l.Addr().String()); err == nil {
+					nc.Close()
+				}
+			}
+		})
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := pg.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	close(done)
+	wg.Wait()
+	if err := <-served; !errors.Is(err, pgwire.ErrServerClosed) {
+		t.Errorf("Serve returned %v", err)
+	}
+}
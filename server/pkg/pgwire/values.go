@@ -0,0 +1,93 @@
+package pgwire
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// encodeBinary returns the binary format of d, a value of type t.
+func (c *conn) encodeBinary(t *types.T, d types.Datum) ([]byte, error) {
+	switch v := d.(type) {
+	case types.DBool:
+		if v {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	case types.DInt:
+		switch t.Width {
+		case 16:
+			return binary.BigEndian.AppendUint16(nil, uint16(v)), nil
+		case 32:
+			return binary.BigEndian.AppendUint32(nil, uint32(v)), nil
+		}
+		return binary.BigEndian.AppendUint64(nil, uint64(v)), nil
+	case types.DFloat:
+		if t.Width == 32 {
+			return binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(v))), nil
+		}
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(float64(v))), nil
+	case types.DString:
+		return c.sess.EncodeText(string(v))
+	case types.DCIText:
+		return c.sess.EncodeText(string(v))
+	case types.DBytes:
+		return []byte(v), nil
+	}
+	return nil, binaryNotSupported(t)
+}
+
+// decodeBinary returns the value of type t whose binary format is b. A
+// parameter whose type is not known is taken as text.
+func (c *conn) decodeBinary(t *types.T, b []byte) (types.Datum, error) {
+	if t == nil {
+		t = types.Unknown
+	}
+	switch t.Family {
+	case types.BoolFamily:
+		if len(b) != 1 {
+			return nil, invalidBinary(t)
+		}
+		return types.DBool(b[0] != 0), nil
+	case types.IntFamily:
+		switch {
+		case t.Width == 16 && len(b) == 2:
+			return types.DInt(int16(binary.BigEndian.Uint16(b))), nil
+		case t.Width == 32 && len(b) == 4:
+			if t.Oid == types.OidOid {
+				return types.DInt(binary.BigEndian.Uint32(b)), nil
+			}
+			return types.DInt(int32(binary.BigEndian.Uint32(b))), nil
+		case t.Width == 64 && len(b) == 8:
+			return types.DInt(int64(binary.BigEndian.Uint64(b))), nil
+		}
+		return nil, invalidBinary(t)
+	case types.FloatFamily:
+		switch {
+		case t.Width == 32 && len(b) == 4:
+			return types.DFloat(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+		case t.Width == 64 && len(b) == 8:
+			return types.DFloat(math.Float64frombits(binary.BigEndian.Uint64(b))), nil
+		}
+		return nil, invalidBinary(t)
+	case types.StringFamily, types.UnknownFamily:
+		s, err := c.sess.DecodeText(b)
+		return types.DString(s), err
+	case types.CITextFamily:
+		s, err := c.sess.DecodeText(b)
+		return types.DCIText(s), err
+	case types.BytesFamily:
+		return types.DBytes(b), nil
+	}
+	return nil, binaryNotSupported(t)
+}
+
+func binaryNotSupported(t *types.T) error {
+	return pgerror.Newf(pgerror.CodeFeatureNotSupported, "binary format is not supported for type %s", t.Name)
+}
+
+func invalidBinary(t *types.T) error {
+	return pgerror.Newf(pgerror.CodeBadBinaryFormat, "incorrect binary data format in bind parameter of type %s", t.Name)
+}
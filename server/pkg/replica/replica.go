@@ -0,0 +1,134 @@
+// Package replica makes a server a read replica of another, its primary:
+// it streams the changes the primary's storage engine commits, over a
+// connection of physical replication, and applies them to its own engine,
+// whose sessions only read; see sql.Server.SetReadOnly.
+//
+// A replica starts on an empty data directory, or on one it replicated to
+// before, where it resumes from the last transaction it applied. The
+// primary must still keep the changes after it.
+package replica
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+)
+
+// retryInterval is how long a replica waits to connect to its primary
+// again after the stream of changes ended.
+const retryInterval = 5 * time.Second
+
+// Replica applies the changes of a primary to an engine.
+type Replica struct {
+	// DSN is where and as whom the replica connects to its primary, as a
+	// superuser. Its Replication is set to true.
+	DSN *pgwire.DSN
+	// Engine is the engine the changes are applied to.
+	Engine engine.Engine
+	// CatalogChanged, if set, is called once a transaction that changed
+	// the catalog is applied, so that the server plans statements anew.
+	CatalogChanged func()
+	// Logger is logged to, or slog.Default if nil.
+	Logger *slog.Logger
+}
+
+// Run follows the primary until ctx is done, connecting to it again
+// whenever the stream of changes ends. It returns the error of reading
+// the engine's position, or ctx's error.
+func (r *Replica) Run(ctx context.Context) error {
+	log := r.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	txn, err := r.Engine.Begin()
+	if err != nil {
+		return err
+	}
+	pos, err := catalog.ReplicaPosition(txn)
+	txn.Abort()
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf("%s:%d", r.DSN.Host, r.DSN.Port)
+	for {
+		log.Info("streaming changes from the primary", "primary", addr, "position", catalog.FormatLSN(pos))
+		err := r.stream(ctx, &pos)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warn("stopped streaming changes from the primary", "primary", addr, "position", catalog.FormatLSN(pos),
+			"err", err, "retry_in", retryInterval)
+		select {
+		case <-time.After(retryInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stream connects to the primary and applies the transactions it commits
+// after *pos, moving *pos on as they are applied, until the stream ends.
+func (r *Replica) stream(ctx context.Context, pos *uint64) error {
+	dsn := *r.DSN
+	dsn.Replication = "true"
+	connectCtx, cancel := context.WithTimeout(ctx, retryInterval)
+	c, err := pgwire.Connect(connectCtx, &dsn)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.StreamChanges(ctx, *pos, func(txn engine.CommittedTxn) error {
+		if err := r.apply(txn); err != nil {
+			return err
+		}
+		*pos = txn.Seq
+		return nil
+	})
+}
+
+// apply applies the changes of a transaction of the primary, and the
+// replica's position after it, in a transaction.
+func (r *Replica) apply(ct engine.CommittedTxn) error {
+	changed := false
+	err := engine.RunTxn(r.Engine, func(txn engine.Txn) error {
+		for _, ch := range ct.Changes {
+			var err error
+			switch ch.Kind {
+			case engine.ChangePut:
+				err = txn.Put(ch.Key, ch.Value)
+				changed = changed || catalog.IsCatalogKey(ch.Key)
+			case engine.ChangeDelete:
+				err = txn.Delete(ch.Key)
+				changed = changed || catalog.IsCatalogKey(ch.Key)
+			case engine.ChangeDeleteRange:
+				var end []byte
+				if len(ch.Value) > 0 {
+					end = ch.Value
+				}
+				err = engine.DeleteRange(txn, ch.Key, end)
+				// Ranges are deleted as databases and tables are
+				// dropped.
+				changed = true
+			default:
+				err = fmt.Errorf("replica: unknown kind of change %d", ch.Kind)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return catalog.WriteReplicaPosition(txn, ct.Seq)
+	})
+	if err != nil {
+		return fmt.Errorf("replica: applying the transaction at %s: %w", catalog.FormatLSN(ct.Seq), err)
+	}
+	if changed && r.CatalogChanged != nil {
+		r.CatalogChanged()
+	}
+	return nil
+}
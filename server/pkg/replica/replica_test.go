@@ -0,0 +1,120 @@
+package replica_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/pgwire"
+	"github.com/alivenotions/pgz/server/pkg/replica"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+func exec(t *testing.T, s *sql.Session, query string) {
+	t.Helper()
+	if _, err := s.Exec(query); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+// query returns the rows of query on a session of srv, or the error of
+// connecting or running it.
+func query(srv *sql.Server, query string) (string, error) {
+	s, err := srv.Connect("postgres", "postgres")
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	res, err := s.Exec(query)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(res[len(res)-1].Rows), nil
+}
+
+// await waits until query returns want on srv.
+func await(t *testing.T, srv *sql.Server, q, want string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got, err := query(srv, q)
+		if err == nil && got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s on the replica: %s, %v; want %s", q, got, err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicaFollowsPrimary(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	primary := sql.NewServer(memory.New())
+	primary.SetLogger(log)
+	pg := pgwire.NewServer(primary, pgwire.Config{})
+	pg.SetLogger(log)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go pg.Serve(l)
+	defer pg.Close()
+
+	p, err := primary.Connect("postgres", "postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	exec(t, p, "CREATE TABLE t (id int PRIMARY KEY, v text)")
+	exec(t, p, "INSERT INTO t VALUES (1, 'a'), (2, 'b')")
+
+	e := memory.New()
+	srv := sql.NewServer(e)
+	srv.SetLogger(log)
+	srv.SetReadOnly(true)
+	r := &replica.Replica{
+		DSN: &pgwire.DSN{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port,
+			User: "postgres", Database: "postgres", SSLMode: "disable"},
+		Engine:         e,
+		CatalogChanged: srv.CatalogChanged,
+		Logger:         log,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Run(ctx) }()
+
+	// What the primary committed before the replica connected is applied,
+	// and then what it commits after.
+	await(t, srv, "SELECT id, v FROM t ORDER BY id", "[[1 a] [2 b]]")
+	exec(t, p, "UPDATE t SET v = 'c' WHERE id = 1")
+	exec(t, p, "DELETE FROM t WHERE id = 2")
+	exec(t, p, "CREATE TABLE u (id int PRIMARY KEY)")
+	exec(t, p, "INSERT INTO u VALUES (3)")
+	await(t, srv, "SELECT id, v FROM t ORDER BY id", "[[1 c]]")
+	await(t, srv, "SELECT id FROM u", "[[3]]")
+
+	// The replica's sessions only read.
+	_, err = query(srv, "INSERT INTO t VALUES (4, 'd')")
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeReadOnlySQLTransaction {
+		t.Errorf("writing to the replica: %v, want read_only_sql_transaction", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run: %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the replica did not stop with its context")
+	}
+}
@@ -0,0 +1,271 @@
+// Package schemadiff compares the schemas of two databases and returns the
+// DDL that changes the first into the second: its schemas, sequences,
+// tables with their columns and constraints, and indexes.
+//
+// The schemas are read through SQL, with the pg_tables and pg_sequences
+// catalogs and the DDL pg_get_tabledef reconstructs, so the databases may
+// be on different servers.
+package schemadiff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+)
+
+// Querier runs a query and returns its rows, with values in the text
+// format and nil for NULL.
+type Querier interface {
+	Query(query string) ([][]*string, error)
+}
+
+// Catalog is the schema of a database, keyed by the quoted,
+// schema-qualified names of its objects.
+type Catalog struct {
+	Schemas   map[string]bool
+	Sequences map[string]*Sequence
+	Tables    map[string]*Table
+}
+
+// Sequence is a sequence that is not an identity column's, which its
+// column creates.
+type Sequence struct {
+	Name string
+	// Options are the options of CREATE SEQUENCE that make it.
+	Options string
+}
+
+// Table is a table as pg_get_tabledef describes it.
+type Table struct {
+	Name    string
+	Columns []*Column
+	// PrimaryKey is the definition of the primary key constraint, or
+	// empty if the table has none of its own.
+	PrimaryKey  string
+	Constraints []*Constraint
+	Indexes     []*Index
+}
+
+// Column is a column of a table.
+type Column struct {
+	// Name is the quoted name of the column.
+	Name string
+	Type string
+	// Default is the DEFAULT or GENERATED clause of the column, or empty.
+	Default string
+	NotNull bool
+}
+
+// Def returns the definition of the column in CREATE TABLE.
+func (c *Column) Def() string {
+	def := c.Name + " " + c.Type
+	if c.Default != "" {
+		def += " " + c.Default
+	}
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// Identity returns ALWAYS or BY DEFAULT for an identity column, or empty.
+func (c *Column) Identity() string {
+	switch c.Default {
+	case "GENERATED ALWAYS AS IDENTITY":
+		return "ALWAYS"
+	case "GENERATED BY DEFAULT AS IDENTITY":
+		return "BY DEFAULT"
+	}
+	return ""
+}
+
+// Constraint is a CHECK or FOREIGN KEY constraint.
+type Constraint struct {
+	// Name is the quoted name of the constraint.
+	Name string
+	// Def is its definition in CREATE TABLE, from CONSTRAINT on.
+	Def string
+}
+
+// ForeignKey reports whether the constraint is a foreign key.
+func (c *Constraint) ForeignKey() bool {
+	return strings.Contains(c.Def, " FOREIGN KEY (")
+}
+
+// Index is a secondary index of a table.
+type Index struct {
+	// Name is the quoted, schema-qualified name of the index.
+	Name string
+	// Def is the CREATE INDEX statement that creates it.
+	Def string
+}
+
+// Load reads the catalog of the database q queries.
+func Load(q Querier) (*Catalog, error) {
+	c := &Catalog{Schemas: make(map[string]bool), Sequences: make(map[string]*Sequence), Tables: make(map[string]*Table)}
+	rows, err := q.Query("SELECT nspname FROM pg_namespace WHERE nspname <> 'pg_catalog'")
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		c.Schemas[parser.QuoteIdent(*row[0])] = true
+	}
+	rows, err = q.Query("SELECT schemaname, tablename FROM pg_tables")
+	if err != nil {
+		return nil, err
+	}
+	// The sequences of identity columns are created and dropped with them.
+	identity := make(map[string]bool)
+	for _, row := range rows {
+		name := qualify(*row[0], *row[1])
+		def, err := queryValue(q, "SELECT pg_get_tabledef(%s)", parser.QuoteString(name))
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseTable(def)
+		if err != nil {
+			return nil, fmt.Errorf("schemadiff: table %s: %w", name, err)
+		}
+		c.Tables[name] = t
+		for _, col := range t.Columns {
+			if col.Identity() == "" {
+				continue
+			}
+			seq, err := queryValue(q, "SELECT pg_get_serial_sequence(%s, %s)",
+				parser.QuoteString(name), parser.QuoteString(unquote(col.Name)))
+			if err != nil {
+				return nil, err
+			}
+			identity[seq] = true
+		}
+	}
+	rows, err = q.Query("SELECT schemaname, sequencename, data_type, increment_by, min_value, max_value, start_value, cache_size, cycle FROM pg_sequences")
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		name := qualify(*row[0], *row[1])
+		if identity[name] {
+			continue
+		}
+		opts := fmt.Sprintf("AS %s INCREMENT BY %s MINVALUE %s MAXVALUE %s START WITH %s CACHE %s",
+			*row[2], *row[3], *row[4], *row[5], *row[6], *row[7])
+		if *row[8] == "t" {
+			opts += " CYCLE"
+		}
+		c.Sequences[name] = &Sequence{Name: name, Options: opts}
+	}
+	return c, nil
+}
+
+// queryValue runs the query format makes with args, and returns the value
+// of its single row, or empty if it is NULL.
+func queryValue(q Querier, format string, args ...any) (string, error) {
+	rows, err := q.Query(fmt.Sprintf(format, args...))
+	if err != nil {
+		return "", err
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		return "", fmt.Errorf("schemadiff: %s returned %d rows", format, len(rows))
+	}
+	if rows[0][0] == nil {
+		return "", nil
+	}
+	return *rows[0][0], nil
+}
+
+// qualify returns the quoted name of a relation in schema.
+func qualify(schema, name string) string {
+	return parser.QuoteIdent(schema) + "." + parser.QuoteIdent(name)
+}
+
+// parseTable parses the statements pg_get_tabledef returns: CREATE TABLE,
+// with a line for each column and constraint, followed by a CREATE INDEX
+// statement for each index.
+func parseTable(def string) (*Table, error) {
+	lines := strings.Split(strings.TrimSuffix(def, "\n"), "\n")
+	head, ok := strings.CutPrefix(lines[0], "CREATE TABLE ")
+	if !ok || !strings.HasSuffix(head, " (") {
+		return nil, fmt.Errorf("unexpected definition %q", lines[0])
+	}
+	t := &Table{Name: strings.TrimSuffix(head, " (")}
+	i := 1
+	for ; i < len(lines) && lines[i] != ");"; i++ {
+		elem := strings.TrimSuffix(strings.TrimPrefix(lines[i], "    "), ",")
+		rest, ok := strings.CutPrefix(elem, "CONSTRAINT ")
+		if !ok {
+			t.Columns = append(t.Columns, parseColumn(elem))
+			continue
+		}
+		name, rest := cutIdent(rest)
+		switch {
+		case strings.HasPrefix(rest, " PRIMARY KEY "):
+			t.PrimaryKey = elem
+		default:
+			t.Constraints = append(t.Constraints, &Constraint{Name: name, Def: elem})
+		}
+	}
+	if i == len(lines) {
+		return nil, fmt.Errorf("unterminated definition")
+	}
+	schema, _ := cutIdent(t.Name)
+	for _, line := range lines[i+1:] {
+		stmt := strings.TrimSuffix(line, ";")
+		_, rest, ok := strings.Cut(stmt, "INDEX ")
+		if !ok {
+			return nil, fmt.Errorf("unexpected statement %q", line)
+		}
+		name, _ := cutIdent(rest)
+		t.Indexes = append(t.Indexes, &Index{Name: schema + "." + name, Def: stmt})
+	}
+	return t, nil
+}
+
+// parseColumn parses the definition of a column, as pg_get_tabledef
+// writes it: its name and type, then its DEFAULT or GENERATED clause, then
+// NOT NULL.
+func parseColumn(def string) *Column {
+	c := &Column{}
+	c.Name, def = cutIdent(def)
+	def = strings.TrimPrefix(def, " ")
+	def, c.NotNull = strings.CutSuffix(def, " NOT NULL")
+	c.Type = def
+	for _, kw := range []string{" DEFAULT ", " GENERATED "} {
+		if i := strings.Index(def, kw); i >= 0 && i < len(c.Type) {
+			c.Type, c.Default = def[:i], def[i+1:]
+		}
+	}
+	return c
+}
+
+// cutIdent cuts the identifier s starts with, double-quoted or not, from
+// s.
+func cutIdent(s string) (ident, rest string) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexAny(s, " .(")
+		if i < 0 {
+			return s, ""
+		}
+		return s[:i], s[i:]
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] != '"' {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			i++
+			continue
+		}
+		return s[:i+1], s[i+1:]
+	}
+	return s, ""
+}
+
+// unquote returns the name the identifier ident quotes.
+func unquote(ident string) string {
+	if len(ident) >= 2 && ident[0] == '"' {
+		return strings.ReplaceAll(ident[1:len(ident)-1], `""`, `"`)
+	}
+	return ident
+}
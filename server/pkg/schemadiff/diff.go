@@ -0,0 +1,224 @@
+package schemadiff
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Diff returns the statements that change the schema of from into that
+// of to, each ending in a semicolon. Changes the server cannot make in
+// place are made by dropping and creating the object again, which loses
+// the data of a column, and are preceded by a comment saying so; those it
+// cannot make at all, such as of a primary key, are comments only.
+//
+// The statements create schemas and sequences first and drop them last,
+// and add foreign keys once every table they reference exists.
+func Diff(from, to *Catalog) []string {
+	var d diff
+	for _, name := range sortedKeys(to.Schemas) {
+		if !from.Schemas[name] {
+			d.add("CREATE SCHEMA %s", name)
+		}
+	}
+	for _, name := range sortedKeys(to.Sequences) {
+		seq, old := to.Sequences[name], from.Sequences[name]
+		switch {
+		case old == nil:
+			d.add("CREATE SEQUENCE %s %s", name, seq.Options)
+		case old.Options != seq.Options:
+			d.comment("sequence %s differs: %s, where it should be %s", name, old.Options, seq.Options)
+		}
+	}
+	var fks []string
+	for _, name := range sortedKeys(to.Tables) {
+		t, old := to.Tables[name], from.Tables[name]
+		if old == nil {
+			fks = append(fks, d.createTable(t)...)
+		} else {
+			fks = append(fks, d.alterTable(old, t)...)
+		}
+	}
+	d.stmts = append(d.stmts, fks...)
+	var dropped []string
+	for _, name := range sortedKeys(from.Tables) {
+		if to.Tables[name] == nil {
+			dropped = append(dropped, name)
+		}
+	}
+	if dropped != nil {
+		// Together, as tables referencing each other can only be dropped
+		// together.
+		d.add("DROP TABLE %s", strings.Join(dropped, ", "))
+	}
+	for _, name := range sortedKeys(from.Sequences) {
+		if to.Sequences[name] == nil {
+			// A sequence a dropped column owned is gone with it.
+			d.add("DROP SEQUENCE IF EXISTS %s", name)
+		}
+	}
+	for _, name := range sortedKeys(from.Schemas) {
+		if !to.Schemas[name] {
+			d.add("DROP SCHEMA %s", name)
+		}
+	}
+	return d.stmts
+}
+
+// diff collects the statements of Diff.
+type diff struct {
+	stmts []string
+}
+
+func (d *diff) add(format string, args ...any) {
+	d.stmts = append(d.stmts, fmt.Sprintf(format, args...)+";")
+}
+
+func (d *diff) comment(format string, args ...any) {
+	d.stmts = append(d.stmts, "-- "+fmt.Sprintf(format, args...))
+}
+
+// createTable adds the statements that create t with its indexes, and
+// returns those that add its foreign keys.
+func (d *diff) createTable(t *Table) []string {
+	var defs []string
+	for _, c := range t.Columns {
+		defs = append(defs, c.Def())
+	}
+	if t.PrimaryKey != "" {
+		defs = append(defs, t.PrimaryKey)
+	}
+	var fks []string
+	for _, c := range t.Constraints {
+		if c.ForeignKey() {
+			fks = append(fks, fmt.Sprintf("ALTER TABLE %s ADD %s;", t.Name, c.Def))
+		} else {
+			defs = append(defs, c.Def)
+		}
+	}
+	d.add("CREATE TABLE %s (\n    %s\n)", t.Name, strings.Join(defs, ",\n    "))
+	for _, idx := range t.Indexes {
+		d.add("%s", idx.Def)
+	}
+	return fks
+}
+
+// alterTable adds the statements that change table old into t, and
+// returns those that add its new foreign keys.
+func (d *diff) alterTable(old, t *Table) []string {
+	constraints := make(map[string]*Constraint)
+	for _, c := range t.Constraints {
+		constraints[c.Name] = c
+	}
+	for _, c := range old.Constraints {
+		if n := constraints[c.Name]; n == nil || n.Def != c.Def {
+			d.add("ALTER TABLE %s DROP CONSTRAINT %s", t.Name, c.Name)
+		}
+	}
+	indexes := make(map[string]*Index)
+	for _, idx := range t.Indexes {
+		indexes[idx.Name] = idx
+	}
+	for _, idx := range old.Indexes {
+		if n := indexes[idx.Name]; n == nil || n.Def != idx.Def {
+			d.add("DROP INDEX %s", idx.Name)
+		}
+	}
+	columns := make(map[string]*Column)
+	for _, c := range t.Columns {
+		columns[c.Name] = c
+	}
+	oldColumns := make(map[string]*Column)
+	for _, c := range old.Columns {
+		oldColumns[c.Name] = c
+		if columns[c.Name] == nil {
+			d.add("ALTER TABLE %s DROP COLUMN %s", t.Name, c.Name)
+		}
+	}
+	for _, c := range t.Columns {
+		oc := oldColumns[c.Name]
+		switch {
+		case oc == nil:
+			d.add("ALTER TABLE %s ADD COLUMN %s", t.Name, c.Def())
+		case oc.Def() != c.Def():
+			d.alterColumn(t, oc, c)
+		}
+	}
+	if old.PrimaryKey != t.PrimaryKey {
+		d.comment("the primary key of %s differs: %s, where it should be %s", t.Name, orNone(old.PrimaryKey), orNone(t.PrimaryKey))
+	}
+	oldConstraints := make(map[string]*Constraint)
+	for _, c := range old.Constraints {
+		oldConstraints[c.Name] = c
+	}
+	var fks []string
+	for _, c := range t.Constraints {
+		if o := oldConstraints[c.Name]; o != nil && o.Def == c.Def {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD %s;", t.Name, c.Def)
+		if c.ForeignKey() {
+			fks = append(fks, stmt)
+		} else {
+			d.stmts = append(d.stmts, stmt)
+		}
+	}
+	oldIndexes := make(map[string]*Index)
+	for _, idx := range old.Indexes {
+		oldIndexes[idx.Name] = idx
+	}
+	for _, idx := range t.Indexes {
+		if o := oldIndexes[idx.Name]; o == nil || o.Def != idx.Def {
+			d.add("%s", idx.Def)
+		}
+	}
+	return fks
+}
+
+// alterColumn adds the statements that change column old of t into c.
+// Defaults and identities are changed in place; other changes drop the
+// column and add it again.
+func (d *diff) alterColumn(t *Table, old, c *Column) {
+	if old.Type == c.Type && old.NotNull == c.NotNull && !generated(old) && !generated(c) {
+		switch {
+		case old.Identity() != "" && c.Identity() != "":
+			d.add("ALTER TABLE %s ALTER COLUMN %s SET GENERATED %s", t.Name, c.Name, c.Identity())
+			return
+		case c.Identity() != "" && old.Default == "":
+			d.add("ALTER TABLE %s ALTER COLUMN %s ADD %s", t.Name, c.Name, c.Default)
+			return
+		case old.Identity() != "" && c.Default == "":
+			d.add("ALTER TABLE %s ALTER COLUMN %s DROP IDENTITY", t.Name, c.Name)
+			return
+		case old.Identity() == "" && c.Identity() == "":
+			if def, ok := strings.CutPrefix(c.Default, "DEFAULT "); ok {
+				d.add("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", t.Name, c.Name, def)
+			} else {
+				d.add("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", t.Name, c.Name)
+			}
+			return
+		}
+	}
+	d.comment("column %s of %s is %s, where it should be %s; it is dropped and added again, losing its values",
+		c.Name, t.Name, old.Def(), c.Def())
+	d.add("ALTER TABLE %s DROP COLUMN %s", t.Name, c.Name)
+	d.add("ALTER TABLE %s ADD COLUMN %s", t.Name, c.Def())
+}
+
+// generated reports whether c is a generated column, whose expression
+// cannot be changed in place.
+func generated(c *Column) bool {
+	return strings.HasPrefix(c.Default, "GENERATED ") && c.Identity() == ""
+}
+
+func orNone(def string) string {
+	if def == "" {
+		return "none"
+	}
+	return def
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
@@ -0,0 +1,95 @@
+package scram
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ClientExchange is the client side of one SCRAM-SHA-256 authentication.
+// First returns the message that starts it; the server's answer is passed
+// to Final, whose answer is sent back; and the server's final message to
+// Verify, which checks that the server knew the password too.
+type ClientExchange struct {
+	password string
+	// clientFirstBare and authMessage are the messages the proofs sign,
+	// and serverKey the key of the server's signature.
+	clientFirstBare, authMessage string
+	serverKey                    []byte
+}
+
+// NewClientExchange starts an exchange proving that the client knows
+// password.
+func NewClientExchange(password string) *ClientExchange {
+	return &ClientExchange{password: password}
+}
+
+// First returns the client-first-message. Its user name is empty, as
+// PostgreSQL clients send it: the server takes the user of the startup
+// message.
+func (e *ClientExchange) First() (string, error) {
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	e.clientFirstBare = "n=,r=" + base64.StdEncoding.EncodeToString(nonce)
+	return "n,," + e.clientFirstBare, nil
+}
+
+// Final handles the server-first-message and returns the
+// client-final-message.
+func (e *ClientExchange) Final(serverFirst string) (string, error) {
+	attrs, err := parseAttrs(serverFirst, "r", "s", "i")
+	if err != nil {
+		return "", err
+	}
+	clientNonce := strings.TrimPrefix(e.clientFirstBare, "n=,r=")
+	if !strings.HasPrefix(attrs["r"], clientNonce) {
+		return "", errors.New("scram: nonce does not match")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs["s"])
+	if err != nil {
+		return "", errMalformed()
+	}
+	iterations, err := strconv.Atoi(attrs["i"])
+	if err != nil || iterations <= 0 {
+		return "", errMalformed()
+	}
+	salted, err := pbkdf2.Key(sha256.New, e.password, salt, iterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	// biws is the base64 of the GS2 header "n,,".
+	withoutProof := "c=biws,r=" + attrs["r"]
+	e.authMessage = e.clientFirstBare + "," + serverFirst + "," + withoutProof
+	clientKey := hmacSum(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	signature := hmacSum(storedKey[:], e.authMessage)
+	proof := make([]byte, sha256.Size)
+	for i := range proof {
+		proof[i] = clientKey[i] ^ signature[i]
+	}
+	e.serverKey = hmacSum(salted, "Server Key")
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// Verify checks the server's signature in the server-final-message.
+func (e *ClientExchange) Verify(serverFinal string) error {
+	attrs, err := parseAttrs(serverFinal, "v")
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(attrs["v"])
+	if err != nil {
+		return errMalformed()
+	}
+	if !hmac.Equal(signature, hmacSum(e.serverKey, e.authMessage)) {
+		return errors.New("scram: server signature does not match")
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package scram implements SCRAM-SHA-256 (RFC 5802 and RFC 7677), the
+// password authentication PostgreSQL clients use, on both sides of the
+// exchange, and the verifiers roles' passwords are stored as.
+//
+// A verifier has the format PostgreSQL stores in pg_authid:
+//
+//	SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+//
+// with the salt and keys in base64. It is enough to check a client's proof
+// of the password, but not to recover the password or to log in with it.
+package scram
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Mechanism is the name of the SASL mechanism.
+const Mechanism = "SCRAM-SHA-256"
+
+// Iterations is the PBKDF2 iteration count of new verifiers, as in
+// PostgreSQL's scram_iterations.
+const Iterations = 4096
+
+const (
+	saltLen  = 16
+	nonceLen = 18
+)
+
+// Verifier is the stored form of a password.
+type Verifier struct {
+	Iterations int
+	Salt       []byte
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// NewVerifier returns the verifier of password with a random salt.
+func NewVerifier(password string) (*Verifier, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return newVerifier(password, salt, Iterations)
+}
+
+func newVerifier(password string, salt []byte, iterations int) (*Verifier, error) {
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	clientKey := hmacSum(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	return &Verifier{
+		Iterations: iterations,
+		Salt:       salt,
+		StoredKey:  storedKey[:],
+		ServerKey:  hmacSum(salted, "Server Key"),
+	}, nil
+}
+
+// IsVerifier reports whether s looks like a verifier rather than a
+// password, as PostgreSQL decides whether CREATE ROLE ... PASSWORD is
+// given one already hashed.
+func IsVerifier(s string) bool {
+	_, err := ParseVerifier(s)
+	return err == nil
+}
+
+// ParseVerifier parses the text of a verifier.
+func ParseVerifier(s string) (*Verifier, error) {
+	bad := errors.New("scram: malformed verifier")
+	rest, ok := strings.CutPrefix(s, Mechanism+"$")
+	if !ok {
+		return nil, bad
+	}
+	params, keys, ok := strings.Cut(rest, "$")
+	if !ok {
+		return nil, bad
+	}
+	iter, salt, ok1 := strings.Cut(params, ":")
+	stored, server, ok2 := strings.Cut(keys, ":")
+	if !ok1 || !ok2 {
+		return nil, bad
+	}
+	v := &Verifier{}
+	var err error
+	if v.Iterations, err = strconv.Atoi(iter); err != nil || v.Iterations <= 0 {
+		return nil, bad
+	}
+	for _, f := range []struct {
+		s   string
+		dst *[]byte
+	}{{salt, &v.Salt}, {stored, &v.StoredKey}, {server, &v.ServerKey}} {
+		if *f.dst, err = base64.StdEncoding.DecodeString(f.s); err != nil {
+			return nil, bad
+		}
+	}
+	if len(v.StoredKey) != sha256.Size || len(v.ServerKey) != sha256.Size {
+		return nil, bad
+	}
+	return v, nil
+}
+
+func (v *Verifier) String() string {
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%s$%d:%s$%s:%s", Mechanism, v.Iterations, b64(v.Salt), b64(v.StoredKey), b64(v.ServerKey))
+}
+
+// Check reports whether password is the password of the verifier, for
+// clients that send it in clear text.
+func (v *Verifier) Check(password string) bool {
+	w, err := newVerifier(password, v.Salt, v.Iterations)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(w.StoredKey, v.StoredKey) == 1 &&
+		subtle.ConstantTimeCompare(w.ServerKey, v.ServerKey) == 1
+}
+
+// MockVerifier returns a verifier no password matches, with a salt derived
+// from secret and user. Exchanges for users without a password use it, so
+// that the server answers them as it answers others, without revealing
+// which users exist.
+func MockVerifier(secret []byte, user string) *Verifier {
+	sum := hmacSum(secret, user)
+	return &Verifier{
+		Iterations: Iterations,
+		Salt:       sum[:saltLen],
+		StoredKey:  make([]byte, sha256.Size),
+		ServerKey:  make([]byte, sha256.Size),
+	}
+}
+
+// ErrAuthFailed is the error of an exchange whose client did not prove it
+// knows the password.
+var ErrAuthFailed = errors.New("scram: authentication failed")
+
+// Exchange is the server side of one SCRAM-SHA-256 authentication. The
+// client's first message is passed to First, whose answer is sent to the
+// client; then its final message to Final, whose answer is sent once the
+// client is authenticated. Channel binding is not supported.
+type Exchange struct {
+	v *Verifier
+	// clientFirstBare and serverFirst are the messages of the first round,
+	// and nonce the combined nonce.
+	clientFirstBare, serverFirst, nonce string
+}
+
+// NewExchange starts an exchange checking the client against v.
+func NewExchange(v *Verifier) *Exchange {
+	return &Exchange{v: v}
+}
+
+// First handles the client-first-message and returns the
+// server-first-message.
+func (e *Exchange) First(msg string) (string, error) {
+	// The GS2 header: no channel binding, and no authorization identity.
+	cbind, rest, ok := strings.Cut(msg, ",")
+	if !ok {
+		return "", errMalformed()
+	}
+	switch {
+	case strings.HasPrefix(cbind, "p="):
+		return "", errors.New("scram: channel binding is not supported")
+	case cbind != "n" && cbind != "y":
+		return "", errMalformed()
+	}
+	authzid, bare, ok := strings.Cut(rest, ",")
+	if !ok || authzid != "" {
+		return "", errMalformed()
+	}
+	// The user name of the message is ignored: PostgreSQL clients send an
+	// empty one, and the user is the one of the startup message.
+	attrs, err := parseAttrs(bare, "n", "r")
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, nonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	e.clientFirstBare = bare
+	e.nonce = attrs["r"] + base64.StdEncoding.EncodeToString(nonce)
+	e.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", e.nonce, base64.StdEncoding.EncodeToString(e.v.Salt), e.v.Iterations)
+	return e.serverFirst, nil
+}
+
+// Final handles the client-final-message and returns the
+// server-final-message, or ErrAuthFailed if the client's proof is wrong.
+func (e *Exchange) Final(msg string) (string, error) {
+	withoutProof, proof, ok := strings.Cut(msg, ",p=")
+	if !ok {
+		return "", errMalformed()
+	}
+	attrs, err := parseAttrs(withoutProof, "c", "r")
+	if err != nil {
+		return "", err
+	}
+	if attrs["r"] != e.nonce {
+		return "", errors.New("scram: nonce does not match")
+	}
+	cbind, err := base64.StdEncoding.DecodeString(attrs["c"])
+	if err != nil || (string(cbind) != "n,," && string(cbind) != "y,,") {
+		return "", errMalformed()
+	}
+	clientProof, err := base64.StdEncoding.DecodeString(proof)
+	if err != nil || len(clientProof) != sha256.Size {
+		return "", errMalformed()
+	}
+	authMessage := e.clientFirstBare + "," + e.serverFirst + "," + withoutProof
+	signature := hmacSum(e.v.StoredKey, authMessage)
+	clientKey := make([]byte, sha256.Size)
+	for i := range clientKey {
+		clientKey[i] = clientProof[i] ^ signature[i]
+	}
+	stored := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(stored[:], e.v.StoredKey) != 1 {
+		return "", ErrAuthFailed
+	}
+	return "v=" + base64.StdEncoding.EncodeToString(hmacSum(e.v.ServerKey, authMessage)), nil
+}
+
+// parseAttrs parses the comma-separated attributes of a message, which
+// must start with those named by want, in order. Extensions after them are
+// ignored.
+func parseAttrs(msg string, want ...string) (map[string]string, error) {
+	attrs := make(map[string]string)
+	for i, f := range strings.Split(msg, ",") {
+		name, value, ok := strings.Cut(f, "=")
+		if !ok || (i < len(want) && name != want[i]) {
+			return nil, errMalformed()
+		}
+		attrs[name] = value
+	}
+	if len(attrs) < len(want) {
+		return nil, errMalformed()
+	}
+	return attrs, nil
+}
+
+func errMalformed() error {
+	return errors.New("scram: malformed message")
+}
+
+func hmacSum(key []byte, msg string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
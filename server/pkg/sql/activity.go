@@ -0,0 +1,379 @@
+package sql
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// firstPID is the process ID of the first session. Later sessions count
+// up from it.
+const firstPID = 1000
+
+// backends are the open sessions by process ID, which pg_stat_activity
+// lists and pg_cancel_backend and pg_terminate_backend signal.
+type backends struct {
+	mu       sync.Mutex
+	sessions map[int32]*Session
+	next     int32
+	// max is the max_connections setting, 0 for no limit.
+	max int
+	// closed is set once the server shuts down, after which sessions are
+	// refused.
+	closed bool
+}
+
+// add gives s a process ID and starts listing it. If s is the session of a
+// client connected as role, it refuses s when there are as many sessions
+// as max_connections allows, or when the role has as many as its
+// connection limit allows, which superusers are not held to, as in
+// PostgreSQL.
+func (b *backends) add(s *Session, role *catalog.Role) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.sessions == nil {
+		b.sessions = make(map[int32]*Session)
+		b.next = firstPID
+	}
+	if b.closed {
+		return pgerror.New(pgerror.CodeCannotConnectNow, "the database system is shutting down")
+	}
+	if role != nil {
+		if b.max > 0 && len(b.sessions) >= b.max {
+			return pgerror.New(pgerror.CodeTooManyConnections, "sorry, too many clients already")
+		}
+		if n := role.ConnectionLimit(); n >= 0 && !role.Superuser && b.count(role.ID) >= n {
+			return pgerror.Newf(pgerror.CodeTooManyConnections, "too many connections for role %q", role.Name)
+		}
+	}
+	s.pid = b.next
+	b.next++
+	b.sessions[s.pid] = s
+	return nil
+}
+
+// count returns the number of sessions of the role id, which are counted
+// by ID so that they still count once it is renamed.
+func (b *backends) count(id catalog.ID) int {
+	n := 0
+	for _, s := range b.sessions {
+		if s.user != "" && s.role == id {
+			n++
+		}
+	}
+	return n
+}
+
+// setMax sets max_connections.
+func (b *backends) setMax(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.max = max(n, 0)
+}
+
+// remove stops listing s.
+func (b *backends) remove(s *Session) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, s.pid)
+}
+
+// get returns the session with process ID pid, or nil.
+func (b *backends) get(pid int32) *Session {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sessions[pid]
+}
+
+// close refuses the sessions added from now on.
+func (b *backends) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+}
+
+// all returns the sessions, ordered by process ID.
+func (b *backends) all() []*Session {
+	b.mu.Lock()
+	sessions := make([]*Session, 0, len(b.sessions))
+	for _, s := range b.sessions {
+		sessions = append(sessions, s)
+	}
+	b.mu.Unlock()
+	slices.SortFunc(sessions, func(a, b *Session) int { return int(a.pid - b.pid) })
+	return sessions
+}
+
+// list returns the activity of the sessions, ordered by process ID.
+func (b *backends) list() []vtable.Activity {
+	sessions := b.all()
+	out := make([]vtable.Activity, len(sessions))
+	for i, s := range sessions {
+		out[i] = s.activity.get()
+		out[i].PID = s.pid
+	}
+	return out
+}
+
+// progress returns the progress of the commands the sessions are
+// running, ordered by process ID.
+func (b *backends) progress() []vtable.Progress {
+	var out []vtable.Progress
+	for _, s := range b.all() {
+		if p := s.activity.getProgress(); p.Command != "" {
+			p.PID = s.pid
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// activity is what a session is doing, as pg_stat_activity shows it, and
+// the progress of its command, as the pg_stat_progress_* tables show it.
+// It is written by the session and read by others.
+type activity struct {
+	mu       sync.Mutex
+	a        vtable.Activity
+	progress vtable.Progress
+	// canceled is the error that cancels the running query, set by
+	// pg_cancel_backend or once it runs past statement_timeout.
+	canceled atomic.Pointer[pgerror.Error]
+	// terminated is the error that ended the session, if it has been
+	// terminated by pg_terminate_backend or for idling too long, and done
+	// is closed then.
+	terminated atomic.Pointer[pgerror.Error]
+	done       chan struct{}
+	// idle terminates the session if it stays idle until it fires, and
+	// timeout cancels the running query if it still runs when it fires.
+	idle, timeout *time.Timer
+}
+
+func (a *activity) get() vtable.Activity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.a
+}
+
+func (a *activity) getProgress() vtable.Progress {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.progress
+}
+
+// report records the progress of the running command.
+func (a *activity) report(p vtable.Progress) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.progress = p
+}
+
+// start records that session s started as user.
+func (a *activity) start(s *Session, user string) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.a.BackendStart, a.a.StateChange, a.a.User = now, now, user
+	a.a.Database = s.database.Name
+	a.a.State, a.a.WaitEventType, a.a.WaitEvent = "idle", "Client", "ClientRead"
+	a.done = make(chan struct{})
+	a.wait(s)
+}
+
+// begin records that the session started running query, unless it has
+// been terminated. xactStart is when its open transaction started, if it
+// has one. The query is canceled if it runs for longer than timeout,
+// unless that is 0.
+func (a *activity) begin(query string, xactStart time.Time, timeout time.Duration) error {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.terminated.Load(); err != nil {
+		return err
+	}
+	a.stopIdle()
+	if xactStart.IsZero() {
+		xactStart = now
+	}
+	a.a.Query, a.a.QueryStart, a.a.StateChange, a.a.XactStart = query, now, now, xactStart
+	a.a.State, a.a.WaitEventType, a.a.WaitEvent = "active", "", ""
+	a.canceled.Store(nil)
+	if timeout > 0 {
+		var t *time.Timer
+		t = time.AfterFunc(timeout, func() {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			// The query may have ended while the timer fired.
+			if a.timeout == t {
+				a.timeout = nil
+				a.canceled.Store(pgerror.New(pgerror.CodeQueryCanceled, "canceling statement due to statement timeout"))
+			}
+		})
+		a.timeout = t
+	}
+	return nil
+}
+
+// end records that the session finished its query and waits for the
+// client, in the state its transaction leaves it in.
+func (a *activity) end(s *Session) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.a.StateChange, a.a.XactStart = now, time.Time{}
+	switch {
+	case s.txn == nil:
+		a.a.State = "idle"
+	case s.failed:
+		a.a.State, a.a.XactStart = "idle in transaction (aborted)", s.txnTime
+	default:
+		a.a.State, a.a.XactStart = "idle in transaction", s.txnTime
+	}
+	a.a.WaitEventType, a.a.WaitEvent = "Client", "ClientRead"
+	a.canceled.Store(nil)
+	if a.timeout != nil {
+		a.timeout.Stop()
+		a.timeout = nil
+	}
+	a.wait(s)
+}
+
+// wait starts the timeout of session s waiting for the client in its
+// state: idle_session_timeout when idle, and
+// idle_in_transaction_session_timeout in a transaction.
+func (a *activity) wait(s *Session) {
+	timeout, err := time.Duration(s.server.idleSessionTimeout.Load()),
+		pgerror.New(pgerror.CodeIdleSessionTimeout, "terminating connection due to idle-session timeout")
+	if a.a.State != "idle" {
+		timeout, err = time.Duration(s.server.idleInTxnTimeout.Load()),
+			pgerror.New(pgerror.CodeIdleInTransactionTimeout, "terminating connection due to idle-in-transaction timeout")
+	}
+	if timeout <= 0 {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(timeout, func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		// A query may have begun while the timer fired.
+		if a.idle == t {
+			a.idle = nil
+			a.terminate(err)
+		}
+	})
+	a.idle = t
+}
+
+// stopIdle stops the idle timeout, if it runs.
+func (a *activity) stopIdle() {
+	if a.idle != nil {
+		a.idle.Stop()
+		a.idle = nil
+	}
+}
+
+// stop stops the idle timeout of a session that is closing.
+func (a *activity) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopIdle()
+}
+
+// shutdown terminates the session with err if it is idle outside of a
+// transaction or, if force is set, whatever it is doing. It reports
+// whether the session is terminated.
+func (a *activity) shutdown(err *pgerror.Error, force bool) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if force || a.a.State == "idle" {
+		a.terminate(err)
+	}
+	return a.terminated.Load() != nil
+}
+
+// terminateXactBefore terminates the session with err if its transaction
+// started before t, and reports whether it did.
+func (a *activity) terminateXactBefore(t time.Time, err *pgerror.Error) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.a.XactStart.IsZero() || !a.a.XactStart.Before(t) || a.terminated.Load() != nil {
+		return false
+	}
+	a.terminate(err)
+	return true
+}
+
+// running reports whether the session is running a query.
+func (a *activity) running() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.a.State == "active"
+}
+
+// terminate ends the session with err, unless it has ended already.
+func (a *activity) terminate(err *pgerror.Error) {
+	if a.terminated.CompareAndSwap(nil, err) {
+		close(a.done)
+	}
+}
+
+// cancel cancels the running query, if there is one.
+func (a *activity) cancel() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.a.State == "active" {
+		a.canceled.Store(pgerror.New(pgerror.CodeQueryCanceled, "canceling statement due to user request"))
+	}
+}
+
+// interrupted returns the error that ends the running query if the
+// session has been terminated or the query canceled.
+func (a *activity) interrupted() error {
+	if err := a.terminated.Load(); err != nil {
+		return err
+	}
+	if err := a.canceled.Load(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// errTerminated is the error that pg_terminate_backend ends a session
+// with.
+func errTerminated() *pgerror.Error {
+	return pgerror.New(pgerror.CodeAdminShutdown, "terminating connection due to administrator command")
+}
+
+// signalBackend is pg_cancel_backend(pid) or pg_terminate_backend(pid),
+// which apply signal to the session with process ID pid and return
+// whether there is one.
+func signalBackend(b *backends, signal func(*activity)) *eval.Overload {
+	return &eval.Overload{Params: []*types.T{types.Int4}, ReturnType: types.Bool, Volatility: eval.Volatile,
+		Fn: func(_ *eval.Context, args []types.Datum) (types.Datum, error) {
+			s := b.get(int32(args[0].(types.DInt)))
+			if s == nil {
+				return types.DBool(false), nil
+			}
+			signal(&s.activity)
+			return types.DBool(true), nil
+		}}
+}
+
+// backendPID is pg_backend_pid(), the process ID of the calling session.
+var backendPID = &eval.Overload{ReturnType: types.Int4, Volatility: eval.Stable,
+	Fn: func(ctx *eval.Context, _ []types.Datum) (types.Datum, error) {
+		return types.DInt(ctx.BackendPID), nil
+	}}
+
+// currentDatabase is current_database(), the name of the database the
+// calling session is connected to.
+var currentDatabase = &eval.Overload{ReturnType: types.String, Volatility: eval.Stable,
+	Fn: func(ctx *eval.Context, _ []types.Datum) (types.Datum, error) {
+		return types.DString(ctx.Database), nil
+	}}
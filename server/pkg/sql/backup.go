@@ -0,0 +1,34 @@
+package sql
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// backupFunc is pgz_backup(dir), which takes a hot backup of the server's
+// databases into the directory dir on the server, as Server.Backup does,
+// and returns when it was taken. Only superusers may call it, as it writes
+// files as the server.
+func backupFunc(s *Server) *eval.Overload {
+	return &eval.Overload{Params: []*types.T{types.String}, ReturnType: types.TimestampTZ, Volatility: eval.Volatile,
+		Fn: func(ctx *eval.Context, args []types.Datum) (types.Datum, error) {
+			if ctx.Privileges != nil {
+				if err := ctx.Privileges.CheckSuperuser("take a backup"); err != nil {
+					return nil, err
+				}
+			}
+			dir := string(args[0].(types.DString))
+			if !filepath.IsAbs(dir) {
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "backup directory %q is not an absolute path", dir)
+			}
+			start := time.Now()
+			if err := s.Backup(dir); err != nil {
+				return nil, err
+			}
+			return types.MakeDTimestampTZ(start), nil
+		}}
+}
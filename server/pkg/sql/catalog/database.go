@@ -0,0 +1,171 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// databasePrefix starts the keys of databases, keyed by name. The default
+// database has a key only once it has an owner.
+var databasePrefix = []byte{SystemPrefix, 'b'}
+
+// keyspacePrefix starts the keys of the databases other than the default
+// one, each under the prefix of its ID; see Keyspace.
+const keyspacePrefix byte = 0x02
+
+// DefaultDatabase is the database that exists in every engine, which
+// clients connect to unless they name another. Its keys are those the
+// engine held before there were databases, so engines written then read
+// the same.
+const DefaultDatabase = "postgres"
+
+// DefaultDatabaseID is the ID of the default database.
+const DefaultDatabaseID ID = 0
+
+// Database is what the catalog records of a database, as in PostgreSQL's
+// pg_database. Each database has a catalog and data of its own; roles and
+// databases are shared by all of them.
+type Database struct {
+	ID   ID     `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Owner is the role that owns the database. The default database is
+	// owned by the superusers.
+	Owner ID `json:"owner,omitempty"`
+}
+
+func databaseKey(name string) []byte {
+	return append(append([]byte(nil), databasePrefix...), name...)
+}
+
+// LookupDatabase returns the database named name, or nil if there is
+// none.
+func LookupDatabase(r engine.Reader, name string) (*Database, error) {
+	v, err := r.Get(databaseKey(name))
+	if errors.Is(err, engine.ErrNotFound) {
+		if name == DefaultDatabase {
+			return &Database{ID: DefaultDatabaseID, Name: name}, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var db Database
+	if err := json.Unmarshal(v, &db); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt database %q: %w", name, err)
+	}
+	return &db, nil
+}
+
+// MustLookupDatabase is LookupDatabase but reports a missing database as
+// an error.
+func MustLookupDatabase(r engine.Reader, name string) (*Database, error) {
+	db, err := LookupDatabase(r, name)
+	if err == nil && db == nil {
+		err = pgerror.Newf(pgerror.CodeInvalidCatalogName, "database %q does not exist", name)
+	}
+	return db, err
+}
+
+// ListDatabases returns all databases ordered by name, the default
+// database among them.
+func ListDatabases(r engine.Reader) ([]*Database, error) {
+	it, err := r.Scan(databasePrefix, prefixEnd(databasePrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var dbs []*Database
+	def := false
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var db Database
+		if err := json.Unmarshal(v, &db); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt database %q: %w", k[len(databasePrefix):], err)
+		}
+		def = def || db.ID == DefaultDatabaseID
+		dbs = append(dbs, &db)
+	}
+	if !def {
+		dbs = append(dbs, &Database{ID: DefaultDatabaseID, Name: DefaultDatabase})
+	}
+	sort.Slice(dbs, func(i, j int) bool { return dbs[i].Name < dbs[j].Name })
+	return dbs, nil
+}
+
+// CreateDatabase assigns db an ID and stores it. Its keyspace starts out
+// empty.
+func CreateDatabase(txn engine.Txn, db *Database) error {
+	existing, err := LookupDatabase(txn, db.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pgerror.Newf(pgerror.CodeDuplicateDatabase, "database %q already exists", db.Name)
+	}
+	if db.ID, err = allocateID(txn); err != nil {
+		return err
+	}
+	return WriteDatabase(txn, db)
+}
+
+// WriteDatabase stores a changed database.
+func WriteDatabase(txn engine.Txn, db *Database) error {
+	v, err := json.Marshal(db)
+	if err != nil {
+		return err
+	}
+	return txn.Put(databaseKey(db.Name), v)
+}
+
+// DropDatabase removes db, the role settings in it, and every key of its
+// keyspace.
+func DropDatabase(txn engine.Txn, db *Database) error {
+	if err := txn.Delete(databaseKey(db.Name)); err != nil {
+		return err
+	}
+	if err := dropRoleSettings(txn, func(s *RoleSettings) bool { return s.Database == db.ID }); err != nil {
+		return err
+	}
+	prefix := keyspaceKey(db.ID)
+	return engine.DeleteRange(txn, prefix, prefixEnd(prefix))
+}
+
+func keyspaceKey(id ID) []byte {
+	return binary.BigEndian.AppendUint32([]byte{keyspacePrefix}, uint32(id))
+}
+
+// Keyspace returns the engine the catalog and data of db are read and
+// written through, a view of e, which holds the keys of every database.
+// The keys of roles and databases, and the counter IDs are allocated from,
+// are shared by the databases, so that roles and IDs are unique across
+// them.
+func Keyspace(e engine.Engine, db *Database) engine.Engine {
+	if db.ID == DefaultDatabaseID {
+		return e
+	}
+	return engine.NewPrefixed(e, keyspaceKey(db.ID), sharedKey)
+}
+
+// sharedKey reports whether key is in the keyspace the databases share:
+// those of roles, their login records and settings, of databases, of the ID
+// counter, of replication slots and the replication log, and those of the
+// databases' keyspaces, which DropDatabase deletes.
+func sharedKey(key []byte) bool {
+	return bytes.HasPrefix(key, rolePrefix) || bytes.HasPrefix(key, loginPrefix) || bytes.HasPrefix(key, roleSettingPrefix) ||
+		bytes.HasPrefix(key, databasePrefix) || bytes.Equal(key, idGenKey) || bytes.HasPrefix(key, slotPrefix) ||
+		bytes.HasPrefix(key, replicationLogPrefix) || key[0] == keyspacePrefix
+}
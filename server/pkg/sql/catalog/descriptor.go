@@ -0,0 +1,446 @@
+// Package catalog defines table and index descriptors and stores them in
+// the key-value engine.
+//
+// Descriptors are serialized as JSON under a reserved system prefix, so
+// schema changes commit atomically with the data changes of the same
+// transaction.
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// ID identifies a table. IDs are allocated from a counter in the system
+// keyspace and never reused.
+type ID uint32
+
+// ColumnID identifies a column within a table. Column IDs are never reused
+// within a table, so stored rows remain decodable after columns are
+// dropped.
+type ColumnID uint32
+
+// IndexID identifies an index within a table. The primary index is always
+// PrimaryIndexID.
+type IndexID uint32
+
+// PrimaryIndexID is the ID of every table's primary index.
+const PrimaryIndexID IndexID = 1
+
+// Column describes a table column.
+type Column struct {
+	ID       ColumnID `json:"id"`
+	Name     string   `json:"name"`
+	Type     *types.T `json:"type"`
+	Nullable bool     `json:"nullable"`
+	// Default is the SQL text of the column's DEFAULT expression, or empty
+	// if it has none. It is parsed again by each statement that uses it.
+	Default string `json:"default,omitempty"`
+	// Generated is the SQL text of the expression of a stored generated
+	// column, with column references unqualified, or empty. The column's
+	// value is computed from the rest of the row whenever it is written.
+	Generated string `json:"generated,omitempty"`
+	// Identity is IdentityAlways or IdentityByDefault for an identity
+	// column, whose default takes the next value of the sequence it owns,
+	// or empty.
+	Identity string `json:"identity,omitempty"`
+	// Hidden is set for the rowid column keying a table declared without
+	// a primary key. It can be referred to by name, but * does not expand
+	// to it and INSERT without a column list does not fill it.
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// The kinds of identity column, as in pg_attribute.attidentity.
+const (
+	// IdentityAlways is GENERATED ALWAYS AS IDENTITY, which only takes
+	// other values with OVERRIDING SYSTEM VALUE.
+	IdentityAlways = "a"
+	// IdentityByDefault is GENERATED BY DEFAULT AS IDENTITY.
+	IdentityByDefault = "d"
+)
+
+// Index describes a primary or secondary index.
+type Index struct {
+	ID        IndexID    `json:"id"`
+	Name      string     `json:"name"`
+	Unique    bool       `json:"unique"`
+	ColumnIDs []ColumnID `json:"column_ids"`
+	// Descending marks the columns of ColumnIDs whose keys sort in
+	// descending order, with NULL first. It is nil if all are ascending.
+	Descending []bool `json:"descending,omitempty"`
+	// HNSW is set for an approximate nearest neighbor index on a vector
+	// column. Such an index is a graph rather than an ordered index, so it
+	// cannot be scanned for values or ranges of values.
+	HNSW *HNSWParams `json:"hnsw,omitempty"`
+	// Inverted is set for a GIN index, which has an entry for each key and
+	// each key/value pair of the hstore in its column rather than one for
+	// the whole value.
+	Inverted bool `json:"inverted,omitempty"`
+	// Hash is set for a hash index, which is keyed by a digest of the
+	// value in its column, so it can only be looked up by equality.
+	Hash bool `json:"hash,omitempty"`
+	// BRIN is set for a block range index, which summarizes the values
+	// of its columns in ranges of rows rather than indexing each row.
+	BRIN *BRINParams `json:"brin,omitempty"`
+}
+
+// Desc reports whether the i'th column of idx is in descending order.
+func (idx *Index) Desc(i int) bool {
+	return i < len(idx.Descending) && idx.Descending[i]
+}
+
+// HNSWParams are the parameters of an HNSW index.
+type HNSWParams struct {
+	// Operator is the distance operator the index orders by, e.g. <->.
+	Operator string `json:"operator"`
+	// M is the number of neighbors a node is linked to on each layer but
+	// the lowest, where it has twice as many.
+	M int `json:"m"`
+	// EfConstruction is the number of candidate neighbors considered when
+	// inserting a node.
+	EfConstruction int `json:"ef_construction"`
+}
+
+// BRINParams are the parameters of a BRIN index.
+type BRINParams struct {
+	// PagesPerRange is the number of rows in each summarized range. There
+	// are no pages to count, so the parameter PostgreSQL sizes ranges with
+	// counts rows instead.
+	PagesPerRange int `json:"pages_per_range"`
+}
+
+// CompressionParams are the compression storage parameters of a table.
+type CompressionParams struct {
+	// Algorithm is none, snappy or zstd.
+	Algorithm string `json:"algorithm"`
+	// DictionarySize is the size of the dictionary zstd trains on the
+	// table's data, in bytes, or 0 for none.
+	DictionarySize int `json:"dictionary_size,omitempty"`
+}
+
+// TTLParams are the row expiration parameters of a table. Each row holds
+// the time it expires in a hidden column, which its insert and updates set
+// to ExpireAfter from then, and rows are deleted once that time passes.
+type TTLParams struct {
+	// ExpireAfter is the interval after which a row expires.
+	ExpireAfter string `json:"expire_after"`
+	// ColumnID is the hidden timestamptz column of the expiration times.
+	ColumnID ColumnID `json:"column_id"`
+}
+
+// Check is a CHECK constraint. A row violates it when Expr is false.
+type Check struct {
+	Name string `json:"name"`
+	// Expr is the SQL text of the condition, with column references
+	// unqualified. Like a column default, it is parsed again by each
+	// statement that uses it.
+	Expr string `json:"expr"`
+	// ColumnIDs are the columns Expr refers to.
+	ColumnIDs []ColumnID `json:"column_ids,omitempty"`
+}
+
+// ForeignKey is a FOREIGN KEY constraint. Unless one of them is NULL, the
+// values of ColumnIDs in each row must be those of ReferencedColumnIDs in
+// a row of the Referenced table, which has a primary key or unique index
+// on those columns.
+type ForeignKey struct {
+	Name                string     `json:"name"`
+	ColumnIDs           []ColumnID `json:"column_ids"`
+	Referenced          ID         `json:"referenced"`
+	ReferencedColumnIDs []ColumnID `json:"referenced_column_ids"`
+	OnDelete            RefAction  `json:"on_delete"`
+	OnUpdate            RefAction  `json:"on_update"`
+}
+
+// RefAction is what deleting a referenced row, or changing its key, does
+// to the rows referencing it.
+type RefAction string
+
+// Referential actions. NO ACTION and RESTRICT both reject the change if
+// rows still reference the old key once the statement is done, but NO
+// ACTION accepts it if another row has taken the key by then.
+const (
+	NoAction   RefAction = "NO ACTION"
+	Restrict   RefAction = "RESTRICT"
+	Cascade    RefAction = "CASCADE"
+	SetNull    RefAction = "SET NULL"
+	SetDefault RefAction = "SET DEFAULT"
+)
+
+// Ordered reports whether idx is an ordered index, whose entries can be
+// scanned by key.
+func (idx *Index) Ordered() bool {
+	return idx.HNSW == nil && !idx.Inverted && !idx.Hash && idx.BRIN == nil
+}
+
+// Table describes a table.
+type Table struct {
+	ID   ID     `json:"id"`
+	Name string `json:"name"`
+	// Schema is the ID of the schema the table and its indexes are in.
+	Schema ID `json:"schema,omitempty"`
+	// Version counts the writes of the descriptor. It changes with every
+	// schema change, while stored rows keep the encoding they were written
+	// with: rows are encoded by column ID, so values of dropped columns are
+	// skipped and columns added since read as NULL until rewritten.
+	Version      uint64    `json:"version"`
+	Columns      []*Column `json:"columns"`
+	PrimaryIndex *Index    `json:"primary_index"`
+	Indexes      []*Index  `json:"indexes,omitempty"`
+	Checks       []*Check  `json:"checks,omitempty"`
+	// ForeignKeys are the foreign keys of the table. The tables
+	// referencing a table are found by their descriptors.
+	ForeignKeys  []*ForeignKey `json:"foreign_keys,omitempty"`
+	NextColumnID ColumnID      `json:"next_column_id"`
+	NextIndexID  IndexID       `json:"next_index_id"`
+	// Owner is the role that created the table, which has every privilege
+	// on it and alone may change or drop it, and ACL the privileges
+	// granted to others.
+	Owner ID  `json:"owner,omitempty"`
+	ACL   ACL `json:"acl,omitempty"`
+	// Compression is how the storage engine compresses the rows and index
+	// entries of the table, as the compression storage parameters set it,
+	// or nil for the default of the engine.
+	Compression *CompressionParams `json:"compression,omitempty"`
+	// TTL is set for a table whose rows expire, as the ttl_expire_after
+	// storage parameter sets it.
+	TTL *TTLParams `json:"ttl,omitempty"`
+	// Stats are the statistics ANALYZE gathered, or nil if the table has
+	// not been analyzed. They are read with the descriptor but stored
+	// apart from it.
+	Stats *TableStats `json:"-"`
+}
+
+// NewTable returns a table descriptor with no columns. The ID is assigned
+// by CreateTable.
+func NewTable(name string) *Table {
+	return &Table{Name: name, NextColumnID: 1, NextIndexID: PrimaryIndexID + 1}
+}
+
+// Clone returns a copy of t whose columns and indexes can be changed
+// without affecting t.
+func (t *Table) Clone() *Table {
+	c := *t
+	c.Columns = make([]*Column, len(t.Columns))
+	for i, col := range t.Columns {
+		x := *col
+		c.Columns[i] = &x
+	}
+	pk := *t.PrimaryIndex
+	c.PrimaryIndex = &pk
+	c.Indexes = make([]*Index, len(t.Indexes))
+	for i, idx := range t.Indexes {
+		x := *idx
+		c.Indexes[i] = &x
+	}
+	c.Checks = make([]*Check, len(t.Checks))
+	for i, ck := range t.Checks {
+		x := *ck
+		c.Checks[i] = &x
+	}
+	c.ForeignKeys = make([]*ForeignKey, len(t.ForeignKeys))
+	for i, fk := range t.ForeignKeys {
+		x := *fk
+		c.ForeignKeys[i] = &x
+	}
+	return &c
+}
+
+// AddColumn appends a column and assigns it the next column ID.
+func (t *Table) AddColumn(name string, typ *types.T, nullable bool) *Column {
+	c := &Column{ID: t.NextColumnID, Name: name, Type: typ, Nullable: nullable}
+	t.NextColumnID++
+	t.Columns = append(t.Columns, c)
+	return c
+}
+
+// AddRowID appends the hidden column that keys a table declared without a
+// primary key. As in CockroachDB, it is named rowid, or rowid_1 and so on
+// if a column already has the name, and defaults to unique_rowid().
+func (t *Table) AddRowID() *Column {
+	name := "rowid"
+	for i := 1; t.FindColumn(name) >= 0; i++ {
+		name = fmt.Sprintf("rowid_%d", i)
+	}
+	c := t.AddColumn(name, types.Int8, false)
+	c.Default = "unique_rowid()"
+	c.Hidden = true
+	return c
+}
+
+// AddExpirationColumn appends the hidden column that holds the expiration
+// time of each row of a table with a TTL, which defaults to expireAfter
+// from the time of the statement. It is named pgz_expiration, or
+// pgz_expiration_1 and so on if a column already has the name.
+func (t *Table) AddExpirationColumn(expireAfter string) *Column {
+	name := "pgz_expiration"
+	for i := 1; t.FindColumn(name) >= 0; i++ {
+		name = fmt.Sprintf("pgz_expiration_%d", i)
+	}
+	c := t.AddColumn(name, types.TimestampTZ, false)
+	c.Default = ExpirationDefault(expireAfter)
+	c.Hidden = true
+	return c
+}
+
+// ExpirationDefault returns the DEFAULT expression of the expiration
+// column of a table whose rows expire after expireAfter.
+func ExpirationDefault(expireAfter string) string {
+	return "now() + '" + expireAfter + "'::interval"
+}
+
+// AddIndex appends a secondary index and assigns it the next index ID.
+func (t *Table) AddIndex(name string, unique bool, cols []ColumnID) *Index {
+	idx := &Index{ID: t.NextIndexID, Name: name, Unique: unique, ColumnIDs: cols}
+	t.NextIndexID++
+	t.Indexes = append(t.Indexes, idx)
+	return idx
+}
+
+// FindColumn returns the ordinal of the column named name, or -1.
+func (t *Table) FindColumn(name string) int {
+	for i, c := range t.Columns {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ColumnOrdinal returns the ordinal of the column with the given ID, or -1.
+func (t *Table) ColumnOrdinal(id ColumnID) int {
+	for i, c := range t.Columns {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ColumnOrdinals maps the columns of idx to table ordinals.
+func (t *Table) ColumnOrdinals(idx *Index) []int {
+	ords := make([]int, len(idx.ColumnIDs))
+	for i, id := range idx.ColumnIDs {
+		ords[i] = t.ColumnOrdinal(id)
+	}
+	return ords
+}
+
+// AllIndexes returns the primary index followed by the secondary indexes.
+func (t *Table) AllIndexes() []*Index {
+	return append([]*Index{t.PrimaryIndex}, t.Indexes...)
+}
+
+// FindIndex returns the index named name, or nil.
+func (t *Table) FindIndex(name string) *Index {
+	for _, idx := range t.AllIndexes() {
+		if idx.Name == name {
+			return idx
+		}
+	}
+	return nil
+}
+
+// FindCheck returns the CHECK constraint named name, or nil.
+func (t *Table) FindCheck(name string) *Check {
+	for _, ck := range t.Checks {
+		if ck.Name == name {
+			return ck
+		}
+	}
+	return nil
+}
+
+// FindForeignKey returns the foreign key named name, or nil.
+func (t *Table) FindForeignKey(name string) *ForeignKey {
+	for _, fk := range t.ForeignKeys {
+		if fk.Name == name {
+			return fk
+		}
+	}
+	return nil
+}
+
+// HasConstraint reports whether t has an index or constraint named name.
+func (t *Table) HasConstraint(name string) bool {
+	return t.FindIndex(name) != nil || t.FindCheck(name) != nil || t.FindForeignKey(name) != nil
+}
+
+// ColumnNames returns the names of the columns with the given IDs.
+func (t *Table) ColumnNames(ids []ColumnID) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = t.Columns[t.ColumnOrdinal(id)].Name
+	}
+	return names
+}
+
+// IndexColumnNames returns the names of the columns of idx.
+func (t *Table) IndexColumnNames(idx *Index) []string {
+	return t.ColumnNames(idx.ColumnIDs)
+}
+
+// Validate checks the internal consistency of the descriptor.
+func (t *Table) Validate() error {
+	seen := make(map[string]bool)
+	for _, c := range t.Columns {
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate column %q", c.Name)
+		}
+		seen[c.Name] = true
+	}
+	if t.PrimaryIndex == nil {
+		return fmt.Errorf("table %q has no primary index", t.Name)
+	}
+	for _, idx := range t.AllIndexes() {
+		for _, id := range idx.ColumnIDs {
+			if t.ColumnOrdinal(id) < 0 {
+				return fmt.Errorf("index %q references unknown column %d", idx.Name, id)
+			}
+		}
+	}
+	for _, ck := range t.Checks {
+		for _, id := range ck.ColumnIDs {
+			if t.ColumnOrdinal(id) < 0 {
+				return fmt.Errorf("check constraint %q references unknown column %d", ck.Name, id)
+			}
+		}
+	}
+	for _, fk := range t.ForeignKeys {
+		for _, id := range fk.ColumnIDs {
+			if t.ColumnOrdinal(id) < 0 {
+				return fmt.Errorf("foreign key %q references unknown column %d", fk.Name, id)
+			}
+		}
+	}
+	return nil
+}
+
+// canonicalizeTypes replaces decoded types with the shared predefined
+// instances where they are identical, so pointer comparisons against
+// e.g. types.Int8 keep working after a round trip through JSON. Columns of
+// registered types always take the registered instance, which carries the
+// type's codecs; its family may differ from the one stored.
+func (t *Table) canonicalizeTypes() error {
+	for _, c := range t.Columns {
+		std := types.TypeForOid(c.Type.Oid)
+		switch {
+		case c.Type.Family.IsExtension() && (std == nil || std.Extension() == nil):
+			return fmt.Errorf("column %q of table %q has type OID %d, which is not registered", c.Name, t.Name, c.Type.Oid)
+		case std != nil && std.Identical(c.Type):
+			c.Type = std
+		}
+	}
+	return nil
+}
+
+// DefaultIndexName returns the name PostgreSQL would choose for an index
+// on cols of table, with suffix "pkey", "key" or "idx".
+func DefaultIndexName(table string, cols []string, suffix string) string {
+	if suffix == "pkey" {
+		return table + "_pkey"
+	}
+	return table + "_" + strings.Join(cols, "_") + "_" + suffix
+}
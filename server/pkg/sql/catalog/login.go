@@ -0,0 +1,99 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// loginPrefix starts the keys of the login records of roles, keyed by
+// role ID. They are shared by the databases, as roles are.
+var loginPrefix = []byte{SystemPrefix, 'g'}
+
+// RoleLogins is what the catalog records of the attempts to log in as a
+// role, for auditing, and whether too many failed in a row and locked it.
+// It is stored apart from the role, so that logging in does not change
+// the role.
+type RoleLogins struct {
+	Role ID `json:"role"`
+	// LastLogin is when a client last logged in as the role, from the
+	// address LastLoginAddr.
+	LastLogin     time.Time `json:"last_login,omitzero"`
+	LastLoginAddr string    `json:"last_login_addr,omitempty"`
+	// LastFailure is when a client last failed to authenticate as the
+	// role, from the address LastFailureAddr.
+	LastFailure     time.Time `json:"last_failure,omitzero"`
+	LastFailureAddr string    `json:"last_failure_addr,omitempty"`
+	// Failures counts the failed attempts since the last login, and
+	// TotalFailures all of them.
+	Failures      int   `json:"failures,omitempty"`
+	TotalFailures int64 `json:"total_failures,omitempty"`
+	// Locked is set once Failures reached the lockout policy's limit,
+	// until LockedUntil, or until it is unlocked if that is zero.
+	Locked      bool      `json:"locked,omitempty"`
+	LockedUntil time.Time `json:"locked_until,omitzero"`
+}
+
+// LockedAt reports whether the role is locked at now.
+func (l *RoleLogins) LockedAt(now time.Time) bool {
+	return l.Locked && (l.LockedUntil.IsZero() || now.Before(l.LockedUntil))
+}
+
+func loginKey(role ID) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), loginPrefix...), uint32(role))
+}
+
+// GetRoleLogins returns the login record of the role with ID role, or nil
+// if no client tried to log in as it yet.
+func GetRoleLogins(r engine.Reader, role ID) (*RoleLogins, error) {
+	v, err := r.Get(loginKey(role))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var l RoleLogins
+	if err := json.Unmarshal(v, &l); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt login record of role %d: %w", role, err)
+	}
+	return &l, nil
+}
+
+// ListRoleLogins returns the login records of every role, ordered by role
+// ID.
+func ListRoleLogins(r engine.Reader) ([]*RoleLogins, error) {
+	it, err := r.Scan(loginPrefix, prefixEnd(loginPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var out []*RoleLogins
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var l RoleLogins
+		if err := json.Unmarshal(v, &l); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt login record of role %d: %w", binary.BigEndian.Uint32(k[len(loginPrefix):]), err)
+		}
+		out = append(out, &l)
+	}
+}
+
+// WriteRoleLogins stores the login record of a role.
+func WriteRoleLogins(txn engine.Txn, l *RoleLogins) error {
+	v, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return txn.Put(loginKey(l.Role), v)
+}
@@ -0,0 +1,176 @@
+package catalog
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// Privilege is a set of privileges on a table or schema, which GRANT gives
+// roles and REVOKE takes away.
+type Privilege uint8
+
+const (
+	PrivSelect Privilege = 1 << iota
+	PrivInsert
+	PrivUpdate
+	PrivDelete
+	PrivCreate
+)
+
+// TablePrivileges are the privileges tables have, and SchemaPrivileges
+// those schemas have: what GRANT ALL grants on them.
+const (
+	TablePrivileges  = PrivSelect | PrivInsert | PrivUpdate | PrivDelete
+	SchemaPrivileges = PrivCreate
+)
+
+var privilegeNames = []struct {
+	p    Privilege
+	name string
+}{
+	{PrivSelect, "SELECT"},
+	{PrivInsert, "INSERT"},
+	{PrivUpdate, "UPDATE"},
+	{PrivDelete, "DELETE"},
+	{PrivCreate, "CREATE"},
+}
+
+// ParsePrivilege returns the privilege named name, in any case.
+func ParsePrivilege(name string) (Privilege, bool) {
+	for _, n := range privilegeNames {
+		if strings.EqualFold(n.name, name) {
+			return n.p, true
+		}
+	}
+	return 0, false
+}
+
+// Names returns the names of the privileges of p, in the order
+// information_schema lists them.
+func (p Privilege) Names() []string {
+	var names []string
+	for _, n := range privilegeNames {
+		if p&n.p != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+func (p Privilege) String() string {
+	return strings.Join(p.Names(), ", ")
+}
+
+// Public is the grantee of the privileges granted to PUBLIC, which every
+// role has.
+const Public ID = 0
+
+// Grant is the privileges a role granted to a role, or to PUBLIC.
+type Grant struct {
+	Grantee    ID        `json:"grantee"`
+	Grantor    ID        `json:"grantor"`
+	Privileges Privilege `json:"privileges"`
+	// GrantOption are the privileges Grantee may grant others in turn.
+	GrantOption Privilege `json:"grant_option,omitempty"`
+}
+
+// ACL is the privileges granted on a table or schema, beyond those of its
+// owner, who has them all. It is never changed in place, so that copies of
+// a descriptor may share it.
+type ACL []Grant
+
+// Grant returns a with privileges p granted to grantee by grantor, with
+// the grant option for them if option is set.
+func (a ACL) Grant(grantee, grantor ID, p Privilege, option bool) ACL {
+	a = slices.Clone(a)
+	i := slices.IndexFunc(a, func(g Grant) bool { return g.Grantee == grantee && g.Grantor == grantor })
+	if i < 0 {
+		a = append(a, Grant{Grantee: grantee, Grantor: grantor})
+		i = len(a) - 1
+	}
+	a[i].Privileges |= p
+	if option {
+		a[i].GrantOption |= p
+	}
+	return a
+}
+
+// Revoke returns a with privileges p revoked from grantee, whoever granted
+// them, or only the grant option for them if optionOnly is set.
+func (a ACL) Revoke(grantee ID, p Privilege, optionOnly bool) ACL {
+	a = slices.Clone(a)
+	for i := range a {
+		if a[i].Grantee != grantee {
+			continue
+		}
+		a[i].GrantOption &^= p
+		if !optionOnly {
+			a[i].Privileges &^= p
+		}
+	}
+	return slices.DeleteFunc(a, func(g Grant) bool { return g.Privileges == 0 })
+}
+
+// Has reports whether the roles of roles, as InheritedRoles returns them,
+// have been granted all privileges of p, with the grant option if option
+// is set.
+func (a ACL) Has(roles map[ID]bool, p Privilege, option bool) bool {
+	var has Privilege
+	for _, g := range a {
+		if !roles[g.Grantee] {
+			continue
+		}
+		if option {
+			has |= g.GrantOption
+		} else {
+			has |= g.Privileges
+		}
+	}
+	return has&p == p
+}
+
+// InheritedRoles returns the IDs of the roles whose privileges role has:
+// itself, PUBLIC, and the roles it is a member of through roles with
+// Inherit. A nil role stands for PUBLIC alone.
+func InheritedRoles(r engine.Reader, role *Role) (map[ID]bool, error) {
+	ids := map[ID]bool{Public: true}
+	if role == nil {
+		return ids, nil
+	}
+	roles, err := ListRoles(r)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[ID]*Role, len(roles))
+	for _, x := range roles {
+		byID[x.ID] = x
+	}
+	var walk func(*Role)
+	walk = func(x *Role) {
+		if ids[x.ID] {
+			return
+		}
+		ids[x.ID] = true
+		if !x.Inherit {
+			return
+		}
+		for _, id := range x.MemberOf {
+			if m := byID[id]; m != nil {
+				walk(m)
+			}
+		}
+	}
+	walk(role)
+	return ids, nil
+}
+
+// HasPrivilege reports whether the roles of roles, as InheritedRoles
+// returns them, have privileges p on an object with owner and acl, with
+// the grant option if option is set. Members of the owner have every
+// privilege, with the grant option. Objects without an owner, created
+// before roles or by the embedder, belong to the superusers alone.
+func HasPrivilege(roles map[ID]bool, owner ID, acl ACL, p Privilege, option bool) bool {
+	return owner != Public && roles[owner] || acl.Has(roles, p, option)
+}
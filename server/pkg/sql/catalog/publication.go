@@ -0,0 +1,119 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// publicationPrefix starts the keys of publications, keyed by name.
+var publicationPrefix = []byte{SystemPrefix, 'p'}
+
+// Publication is what the catalog records of a publication, as in
+// PostgreSQL's pg_publication: the tables whose changes logical
+// replication sends to the subscribers that name it.
+type Publication struct {
+	ID    ID     `json:"id"`
+	Name  string `json:"name"`
+	Owner ID     `json:"owner,omitempty"`
+	// AllTables publishes every table of the database, those created
+	// later among them. Otherwise Tables are the IDs of the tables it
+	// publishes; those since dropped are ignored.
+	AllTables bool `json:"all_tables,omitempty"`
+	Tables    []ID `json:"tables,omitempty"`
+	// Insert, Update, Delete and Truncate are the kinds of change it
+	// publishes, as its publish parameter lists them.
+	Insert   bool `json:"insert,omitempty"`
+	Update   bool `json:"update,omitempty"`
+	Delete   bool `json:"delete,omitempty"`
+	Truncate bool `json:"truncate,omitempty"`
+}
+
+// Publishes reports whether p publishes the changes of the table with ID
+// table.
+func (p *Publication) Publishes(table ID) bool {
+	return p.AllTables || slices.Contains(p.Tables, table)
+}
+
+func publicationKey(name string) []byte {
+	return append(append([]byte(nil), publicationPrefix...), name...)
+}
+
+// LookupPublication returns the publication named name, or nil if there
+// is none.
+func LookupPublication(r engine.Reader, name string) (*Publication, error) {
+	v, err := r.Get(publicationKey(name))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Publication
+	if err := json.Unmarshal(v, &p); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt publication %q: %w", name, err)
+	}
+	return &p, nil
+}
+
+// MustLookupPublication is LookupPublication but reports a missing
+// publication as an error.
+func MustLookupPublication(r engine.Reader, name string) (*Publication, error) {
+	p, err := LookupPublication(r, name)
+	if err == nil && p == nil {
+		err = pgerror.Newf(pgerror.CodeUndefinedObject, "publication %q does not exist", name)
+	}
+	return p, err
+}
+
+// ListPublications returns all publications ordered by name.
+func ListPublications(r engine.Reader) ([]*Publication, error) {
+	it, err := r.Scan(publicationPrefix, prefixEnd(publicationPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var pubs []*Publication
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return pubs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var p Publication
+		if err := json.Unmarshal(v, &p); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt publication %q: %w", k[len(publicationPrefix):], err)
+		}
+		pubs = append(pubs, &p)
+	}
+}
+
+// CreatePublication assigns p an ID and stores it.
+func CreatePublication(txn engine.Txn, p *Publication) error {
+	existing, err := LookupPublication(txn, p.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pgerror.Newf(pgerror.CodeDuplicateObject, "publication %q already exists", p.Name)
+	}
+	if p.ID, err = allocateID(txn); err != nil {
+		return err
+	}
+	v, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return txn.Put(publicationKey(p.Name), v)
+}
+
+// DropPublication removes p.
+func DropPublication(txn engine.Txn, p *Publication) error {
+	return txn.Delete(publicationKey(p.Name))
+}
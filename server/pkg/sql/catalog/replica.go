@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// replicaPositionKey is the key of the position of a read replica: the
+// sequence number of the last transaction of its primary it applied, as a
+// big-endian uint64, which it resumes streaming after. It is a key of the
+// engine, outside the keyspaces of the databases.
+var replicaPositionKey = []byte{SystemPrefix, 'f'}
+
+// ReplicaPosition returns the position of the read replica whose engine r
+// reads, or 0 if it has applied no transaction yet.
+func ReplicaPosition(r engine.Reader) (uint64, error) {
+	v, err := r.Get(replicaPositionKey)
+	if errors.Is(err, engine.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, fmt.Errorf("catalog: corrupt replica position of %d bytes", len(v))
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// WriteReplicaPosition stores the position of a read replica, in the
+// transaction that applies the transaction of its primary at seq.
+func WriteReplicaPosition(txn engine.Txn, seq uint64) error {
+	return txn.Put(replicaPositionKey, binary.BigEndian.AppendUint64(nil, seq))
+}
+
+// IsCatalogKey reports whether key, a key of the engine that holds every
+// database, is of the catalog statements are planned against: of the
+// descriptors and names of tables, schemas, publications, roles or
+// databases, or of table statistics. The values of sequences, login
+// records, replication slots and the replication log, which change as
+// statements run, are not, nor are the position of a read replica and
+// the temporary ranges of statements.
+func IsCatalogKey(key []byte) bool {
+	if len(key) > 5 && key[0] == keyspacePrefix {
+		key = key[5:]
+	}
+	for _, p := range [][]byte{seqValuePrefix, loginPrefix, slotPrefix, replicationLogPrefix, replicaPositionKey, tempPrefix} {
+		if bytes.HasPrefix(key, p) {
+			return false
+		}
+	}
+	return len(key) > 0 && key[0] == SystemPrefix
+}
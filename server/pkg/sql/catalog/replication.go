@@ -0,0 +1,156 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// slotPrefix starts the keys of replication slots, keyed by name, and
+// replicationLogPrefix those of the records of the replication log, keyed
+// by their LSN as a big-endian uint64. Both are shared by the databases,
+// as in PostgreSQL, though each slot and record is of one database.
+var (
+	slotPrefix           = []byte{SystemPrefix, 'l'}
+	replicationLogPrefix = []byte{SystemPrefix, 'w'}
+)
+
+// ReplicationSlot is what the catalog records of a logical replication
+// slot, as in PostgreSQL's pg_replication_slots: the position up to which
+// a client has confirmed it received the changes of a database, which
+// the replication log keeps the changes after.
+type ReplicationSlot struct {
+	Name     string `json:"name"`
+	Database ID     `json:"database,omitempty"`
+	// Plugin is the output plugin the changes are sent with.
+	Plugin string `json:"plugin"`
+	// ConfirmedFlush is the LSN of the first change the client has not
+	// confirmed it flushed, which streaming from the slot starts at.
+	ConfirmedFlush uint64 `json:"confirmed_flush"`
+}
+
+func slotKey(name string) []byte {
+	return append(append([]byte(nil), slotPrefix...), name...)
+}
+
+// LookupReplicationSlot returns the replication slot named name, or nil
+// if there is none.
+func LookupReplicationSlot(r engine.Reader, name string) (*ReplicationSlot, error) {
+	v, err := r.Get(slotKey(name))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s ReplicationSlot
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt replication slot %q: %w", name, err)
+	}
+	return &s, nil
+}
+
+// MustLookupReplicationSlot is LookupReplicationSlot but reports a
+// missing slot as an error.
+func MustLookupReplicationSlot(r engine.Reader, name string) (*ReplicationSlot, error) {
+	s, err := LookupReplicationSlot(r, name)
+	if err == nil && s == nil {
+		err = pgerror.Newf(pgerror.CodeUndefinedObject, "replication slot %q does not exist", name)
+	}
+	return s, err
+}
+
+// ListReplicationSlots returns the replication slots of every database,
+// ordered by name.
+func ListReplicationSlots(r engine.Reader) ([]*ReplicationSlot, error) {
+	it, err := r.Scan(slotPrefix, prefixEnd(slotPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var slots []*ReplicationSlot
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return slots, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var s ReplicationSlot
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt replication slot %q: %w", k[len(slotPrefix):], err)
+		}
+		slots = append(slots, &s)
+	}
+}
+
+// CreateReplicationSlot stores a new replication slot.
+func CreateReplicationSlot(txn engine.Txn, s *ReplicationSlot) error {
+	existing, err := LookupReplicationSlot(txn, s.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pgerror.Newf(pgerror.CodeDuplicateObject, "replication slot %q already exists", s.Name)
+	}
+	return WriteReplicationSlot(txn, s)
+}
+
+// WriteReplicationSlot stores a replication slot whose confirmed position
+// advanced.
+func WriteReplicationSlot(txn engine.Txn, s *ReplicationSlot) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return txn.Put(slotKey(s.Name), v)
+}
+
+// DropReplicationSlot removes s.
+func DropReplicationSlot(txn engine.Txn, s *ReplicationSlot) error {
+	return txn.Delete(slotKey(s.Name))
+}
+
+// ReplicationLogKey returns the key of the record of the replication log
+// at lsn.
+func ReplicationLogKey(lsn uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte(nil), replicationLogPrefix...), lsn)
+}
+
+// ReplicationLogLSN returns the LSN of the record of the replication log
+// at key.
+func ReplicationLogLSN(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[len(replicationLogPrefix):])
+}
+
+// ReplicationLogEnd returns the end of the keys of the replication log,
+// for scanning it.
+func ReplicationLogEnd() []byte {
+	return prefixEnd(replicationLogPrefix)
+}
+
+// FormatLSN formats an LSN as PostgreSQL does a pg_lsn: its high and low
+// 32 bits in hexadecimal, separated by a slash.
+func FormatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// ParseLSN parses an LSN in the format FormatLSN writes.
+func ParseLSN(s string) (uint64, error) {
+	hi, lo, ok := strings.Cut(s, "/")
+	if ok {
+		h, err1 := strconv.ParseUint(hi, 16, 32)
+		l, err2 := strconv.ParseUint(lo, 16, 32)
+		if err1 == nil && err2 == nil {
+			return h<<32 | l, nil
+		}
+	}
+	return 0, pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type pg_lsn: %q", s)
+}
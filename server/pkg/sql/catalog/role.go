@@ -0,0 +1,218 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// rolePrefix starts the keys of roles, which are keyed by name. Roles have
+// a namespace of their own, apart from relations.
+var rolePrefix = []byte{SystemPrefix, 'r'}
+
+// Role is a role, which clients connect as, as in PostgreSQL's pg_authid.
+// Users are roles that may log in.
+type Role struct {
+	ID   ID     `json:"id"`
+	Name string `json:"name"`
+	// Superuser roles bypass every permission check.
+	Superuser bool `json:"superuser,omitempty"`
+	// CreateDB and CreateRole let the role create databases and roles.
+	CreateDB   bool `json:"create_db,omitempty"`
+	CreateRole bool `json:"create_role,omitempty"`
+	// Inherit makes the role have the privileges of the roles it is a
+	// member of.
+	Inherit bool `json:"inherit"`
+	// Login lets clients connect as the role.
+	Login bool `json:"login,omitempty"`
+	// Password is the SCRAM-SHA-256 verifier of the role's password, or
+	// empty if it has none; see package scram.
+	Password string `json:"password,omitempty"`
+	// MemberOf are the IDs of the roles the role is a member of, as GRANT
+	// role TO makes it.
+	MemberOf []ID `json:"member_of,omitempty"`
+	// ConnLimit is the number of sessions of the role allowed at once, as
+	// CONNECTION LIMIT sets it, or nil for no limit.
+	ConnLimit *int `json:"conn_limit,omitempty"`
+}
+
+// ConnectionLimit returns the number of sessions of r allowed at once, or
+// -1 for no limit, as in pg_roles.rolconnlimit.
+func (r *Role) ConnectionLimit() int {
+	if r.ConnLimit == nil {
+		return -1
+	}
+	return *r.ConnLimit
+}
+
+func roleKey(name string) []byte {
+	return append(append([]byte(nil), rolePrefix...), name...)
+}
+
+// LookupRole returns the role named name, or nil if there is none.
+func LookupRole(r engine.Reader, name string) (*Role, error) {
+	v, err := r.Get(roleKey(name))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var role Role
+	if err := json.Unmarshal(v, &role); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt role %q: %w", name, err)
+	}
+	return &role, nil
+}
+
+// MustLookupRole is LookupRole but reports a missing role as an error.
+func MustLookupRole(r engine.Reader, name string) (*Role, error) {
+	role, err := LookupRole(r, name)
+	if err == nil && role == nil {
+		err = pgerror.Newf(pgerror.CodeUndefinedObject, "role %q does not exist", name)
+	}
+	return role, err
+}
+
+// ListRoles returns all roles ordered by name.
+func ListRoles(r engine.Reader) ([]*Role, error) {
+	it, err := r.Scan(rolePrefix, prefixEnd(rolePrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var roles []*Role
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var role Role
+		if err := json.Unmarshal(v, &role); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt role %q: %w", k[len(rolePrefix):], err)
+		}
+		roles = append(roles, &role)
+	}
+	return roles, nil
+}
+
+// HasRoles reports whether any role has been created.
+func HasRoles(r engine.Reader) (bool, error) {
+	it, err := r.Scan(rolePrefix, prefixEnd(rolePrefix))
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+	_, _, err = it.Next()
+	if errors.Is(err, engine.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CreateRole assigns role an ID and stores it.
+func CreateRole(txn engine.Txn, role *Role) error {
+	existing, err := LookupRole(txn, role.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pgerror.Newf(pgerror.CodeDuplicateObject, "role %q already exists", role.Name)
+	}
+	if role.ID, err = allocateID(txn); err != nil {
+		return err
+	}
+	return WriteRole(txn, role)
+}
+
+// WriteRole stores an updated role.
+func WriteRole(txn engine.Txn, role *Role) error {
+	v, err := json.Marshal(role)
+	if err != nil {
+		return err
+	}
+	return txn.Put(roleKey(role.Name), v)
+}
+
+// RenameRole stores role, a changed copy of the role named old, under its
+// new name.
+func RenameRole(txn engine.Txn, old string, role *Role) error {
+	existing, err := LookupRole(txn, role.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pgerror.Newf(pgerror.CodeDuplicateObject, "role %q already exists", role.Name)
+	}
+	if err := txn.Delete(roleKey(old)); err != nil {
+		return err
+	}
+	return WriteRole(txn, role)
+}
+
+// DropRole removes role, its login record and settings, and its
+// membership in other roles and theirs in it.
+func DropRole(txn engine.Txn, role *Role) error {
+	if err := txn.Delete(roleKey(role.Name)); err != nil {
+		return err
+	}
+	if err := txn.Delete(loginKey(role.ID)); err != nil {
+		return err
+	}
+	if err := dropRoleSettings(txn, func(s *RoleSettings) bool { return s.Role == role.ID }); err != nil {
+		return err
+	}
+	roles, err := ListRoles(txn)
+	if err != nil {
+		return err
+	}
+	for _, r := range roles {
+		if i := slices.Index(r.MemberOf, role.ID); i >= 0 {
+			r.MemberOf = slices.Delete(r.MemberOf, i, i+1)
+			if err := WriteRole(txn, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MemberOf reports whether role is a member of the role with ID of,
+// directly or through the roles it is a member of, or is that role.
+// Memberships through roles without Inherit count only if all is set, as
+// for SET ROLE, which does not need to inherit privileges.
+func MemberOf(r engine.Reader, role *Role, of ID, all bool) (bool, error) {
+	roles, err := ListRoles(r)
+	if err != nil {
+		return false, err
+	}
+	byID := make(map[ID]*Role, len(roles))
+	for _, x := range roles {
+		byID[x.ID] = x
+	}
+	seen := make(map[ID]bool)
+	var walk func(*Role) bool
+	walk = func(x *Role) bool {
+		if x.ID == of {
+			return true
+		}
+		if seen[x.ID] || (!x.Inherit && !all) {
+			return false
+		}
+		seen[x.ID] = true
+		for _, id := range x.MemberOf {
+			if m := byID[id]; m != nil && walk(m) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(role), nil
+}
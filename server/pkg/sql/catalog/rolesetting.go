@@ -0,0 +1,113 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// roleSettingPrefix starts the keys of the defaults ALTER ROLE ... SET
+// pins, keyed by database and role ID. They are shared by the databases,
+// as roles are.
+var roleSettingPrefix = []byte{SystemPrefix, 'c'}
+
+// AllDatabases and AllRoles stand for every database and every role in
+// RoleSettings. The default database has ID 0, so neither is.
+const (
+	AllDatabases ID = math.MaxUint32
+	AllRoles     ID = math.MaxUint32
+)
+
+// RoleSettings are the defaults of session variables for the sessions of
+// a role in a database, as in PostgreSQL's pg_db_role_setting, which
+// ALTER ROLE {name | ALL} [IN DATABASE db] SET pins. A session starts with
+// those for every role in every database, then those for its database,
+// for its role, and for both, each overriding the ones before, and the
+// parameters of the client override them all.
+type RoleSettings struct {
+	Database ID `json:"database"`
+	Role     ID `json:"role"`
+	// Settings are the values of the variables by name.
+	Settings map[string]string `json:"settings"`
+}
+
+func roleSettingKey(database, role ID) []byte {
+	key := binary.BigEndian.AppendUint32(append([]byte(nil), roleSettingPrefix...), uint32(database))
+	return binary.BigEndian.AppendUint32(key, uint32(role))
+}
+
+// GetRoleSettings returns the settings of role in database, or nil if
+// there are none.
+func GetRoleSettings(r engine.Reader, database, role ID) (*RoleSettings, error) {
+	v, err := r.Get(roleSettingKey(database, role))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s RoleSettings
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt settings of role %d in database %d: %w", role, database, err)
+	}
+	return &s, nil
+}
+
+// ListRoleSettings returns all settings ordered by database and role ID.
+func ListRoleSettings(r engine.Reader) ([]*RoleSettings, error) {
+	it, err := r.Scan(roleSettingPrefix, prefixEnd(roleSettingPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var all []*RoleSettings
+	for {
+		_, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var s RoleSettings
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt role settings: %w", err)
+		}
+		all = append(all, &s)
+	}
+	return all, nil
+}
+
+// WriteRoleSettings stores s, or deletes it if it has no settings left.
+func WriteRoleSettings(txn engine.Txn, s *RoleSettings) error {
+	key := roleSettingKey(s.Database, s.Role)
+	if len(s.Settings) == 0 {
+		return txn.Delete(key)
+	}
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, v)
+}
+
+// dropRoleSettings deletes the settings drop reports true for, as their
+// role or database is dropped.
+func dropRoleSettings(txn engine.Txn, drop func(*RoleSettings) bool) error {
+	all, err := ListRoleSettings(txn)
+	if err != nil {
+		return err
+	}
+	for _, s := range all {
+		if drop(s) {
+			if err := txn.Delete(roleSettingKey(s.Database, s.Role)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// schemaPrefix starts the keys of schemas, keyed by name. The public
+// schema has a key only once its privileges have been changed.
+var schemaPrefix = []byte{SystemPrefix, 'a'}
+
+// PublicSchema is the schema that exists in every database, which tables
+// are created in unless the search path says otherwise.
+const PublicSchema = "public"
+
+// PublicSchemaID is the ID of the public schema. The names of its
+// relations are keyed as they were before schemas, so databases written
+// then read the same.
+const PublicSchemaID ID = 0
+
+// Schema is what the catalog records of a schema, as in PostgreSQL's
+// pg_namespace. Tables, indexes and sequences are in a schema, and their
+// names are unique within it.
+type Schema struct {
+	ID   ID     `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Owner is the role that owns the schema. The public schema is owned
+	// by the superusers, as by pg_database_owner, and only they may create
+	// in it until CREATE is granted on it, as in PostgreSQL 15 and later.
+	Owner ID  `json:"owner,omitempty"`
+	ACL   ACL `json:"acl,omitempty"`
+}
+
+func schemaKey(name string) []byte {
+	return append(append([]byte(nil), schemaPrefix...), name...)
+}
+
+// LookupSchema returns the schema named name, or nil if there is none.
+func LookupSchema(r engine.Reader, name string) (*Schema, error) {
+	v, err := r.Get(schemaKey(name))
+	if errors.Is(err, engine.ErrNotFound) {
+		if name == PublicSchema {
+			return &Schema{ID: PublicSchemaID, Name: name}, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Schema
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt schema %q: %w", name, err)
+	}
+	return &s, nil
+}
+
+// MustLookupSchema is LookupSchema but reports a missing schema as an
+// error.
+func MustLookupSchema(r engine.Reader, name string) (*Schema, error) {
+	s, err := LookupSchema(r, name)
+	if err == nil && s == nil {
+		err = pgerror.Newf(pgerror.CodeInvalidSchemaName, "schema %q does not exist", name)
+	}
+	return s, err
+}
+
+// ListSchemas returns all schemas ordered by name, the public schema
+// among them.
+func ListSchemas(r engine.Reader) ([]*Schema, error) {
+	it, err := r.Scan(schemaPrefix, prefixEnd(schemaPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var schemas []*Schema
+	public := false
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var s Schema
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, fmt.Errorf("catalog: corrupt schema %q: %w", k[len(schemaPrefix):], err)
+		}
+		public = public || s.ID == PublicSchemaID
+		schemas = append(schemas, &s)
+	}
+	if !public {
+		schemas = append(schemas, &Schema{ID: PublicSchemaID, Name: PublicSchema})
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Name < schemas[j].Name })
+	return schemas, nil
+}
+
+// GetSchemaByID returns the schema with the given ID.
+func GetSchemaByID(r engine.Reader, id ID) (*Schema, error) {
+	if id == PublicSchemaID {
+		return LookupSchema(r, PublicSchema)
+	}
+	schemas, err := ListSchemas(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range schemas {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return nil, pgerror.Newf(pgerror.CodeInvalidSchemaName, "schema with OID %d does not exist", id)
+}
+
+// CreateSchema assigns s an ID and stores it.
+func CreateSchema(txn engine.Txn, s *Schema) error {
+	existing, err := LookupSchema(txn, s.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return pgerror.Newf(pgerror.CodeDuplicateSchema, "schema %q already exists", s.Name)
+	}
+	if s.ID, err = allocateID(txn); err != nil {
+		return err
+	}
+	return WriteSchema(txn, s)
+}
+
+// WriteSchema stores a schema with changed privileges.
+func WriteSchema(txn engine.Txn, s *Schema) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return txn.Put(schemaKey(s.Name), v)
+}
+
+// DropSchema removes s. The relations in it must have been dropped.
+func DropSchema(txn engine.Txn, s *Schema) error {
+	return txn.Delete(schemaKey(s.Name))
+}
+
+// UserSchema is the entry of a search path that stands for the schema
+// named after the current role.
+const UserSchema = "$user"
+
+// SearchPath returns the IDs of the schemas that exist of those names
+// lists, in order, as search_path lists them; UserSchema stands for the
+// schema named after the role user, if there is one. Nil names mean the
+// public schema alone.
+func SearchPath(r engine.Reader, names []string, user string) ([]ID, error) {
+	if names == nil {
+		names = []string{PublicSchema}
+	}
+	var ids []ID
+	for _, name := range names {
+		if name == UserSchema {
+			if user == "" {
+				continue
+			}
+			name = user
+		}
+		s, err := LookupSchema(r, name)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil && !slices.Contains(ids, s.ID) {
+			ids = append(ids, s.ID)
+		}
+	}
+	return ids, nil
+}
+
+// Resolve returns the first schema of path holding a relation named name,
+// and false if none does, as PostgreSQL resolves names that are not
+// schema-qualified.
+func Resolve(r engine.Reader, path []ID, name string) (ID, bool, error) {
+	for _, schema := range path {
+		e, err := readName(r, schema, name)
+		if err != nil {
+			return 0, false, err
+		}
+		if e != nil {
+			return schema, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// nameKey returns the namespace key of the relation named name in schema.
+// A NUL, which no name holds, sets the keys of other schemas apart from
+// those of the public schema.
+func nameKey(schema ID, name string) []byte {
+	k := append([]byte(nil), namePrefix...)
+	if schema != PublicSchemaID {
+		k = binary.BigEndian.AppendUint32(append(k, 0), uint32(schema))
+	}
+	return append(k, name...)
+}
@@ -0,0 +1,202 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Sequence describes a sequence, which hands out the values from MinValue
+// to MaxValue in steps of Increment, starting at Start.
+type Sequence struct {
+	ID   ID     `json:"id"`
+	Name string `json:"name"`
+	// Schema is the ID of the schema the sequence is in.
+	Schema ID `json:"schema,omitempty"`
+	// Type is smallint, integer or bigint, which bounds MinValue and
+	// MaxValue.
+	Type      *types.T `json:"type"`
+	Increment int64    `json:"increment"`
+	MinValue  int64    `json:"min_value"`
+	MaxValue  int64    `json:"max_value"`
+	Start     int64    `json:"start"`
+	// Cache is the number of values a session takes from the sequence at
+	// once and hands out from memory. The sequence is advanced past all of
+	// them before the first is used, so a crash leaves a gap but never
+	// hands a value out twice.
+	Cache int64 `json:"cache"`
+	// Cycle is set if the sequence wraps around after its last value
+	// rather than failing.
+	Cycle bool `json:"cycle,omitempty"`
+	// Gapless is set if values are taken in the transaction calling
+	// nextval rather than in one of their own. Rolling back returns the
+	// value, and values increase strictly in commit order, at the cost of
+	// concurrent callers conflicting until the first commits.
+	Gapless bool `json:"gapless,omitempty"`
+	// OwnedBy is the column of a serial column's sequence, which is
+	// dropped with the column.
+	OwnedBy *SequenceOwner `json:"owned_by,omitempty"`
+	// Owner is the role that created the sequence, which alone may drop
+	// it.
+	Owner ID `json:"owner,omitempty"`
+}
+
+// SequenceOwner is the column owning a sequence.
+type SequenceOwner struct {
+	Table  ID       `json:"table"`
+	Column ColumnID `json:"column"`
+}
+
+// SequenceValue is the state of a sequence. Unlike its descriptor, it is
+// advanced outside of the transactions using the sequence, so that
+// concurrent transactions never hand out the same value and rolling back
+// does not return values.
+type SequenceValue struct {
+	Last int64
+	// Called is false until the first nextval, which returns Last itself.
+	Called bool
+}
+
+func seqDescKey(id ID) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), seqDescPrefix...), uint32(id))
+}
+
+func seqValueKey(id ID) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), seqValuePrefix...), uint32(id))
+}
+
+// getSequence reads the sequence descriptor with the given ID.
+func getSequence(r engine.Reader, id ID) (*Sequence, error) {
+	v, err := r.Get(seqDescKey(id))
+	if err != nil {
+		return nil, err
+	}
+	var s Sequence
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt sequence descriptor %d: %w", id, err)
+	}
+	if std := types.TypeForOid(s.Type.Oid); std != nil {
+		s.Type = std
+	}
+	return &s, nil
+}
+
+// LookupSequence returns the sequence named name in schema, or nil if
+// there is none.
+func LookupSequence(r engine.Reader, schema ID, name string) (*Sequence, error) {
+	e, err := readName(r, schema, name)
+	if err != nil || e == nil || !e.Sequence {
+		return nil, err
+	}
+	return getSequence(r, e.Table)
+}
+
+// MustLookupSequence is LookupSequence but reports a missing sequence, or
+// a relation that is not a sequence, as an error.
+func MustLookupSequence(r engine.Reader, schema ID, name string) (*Sequence, error) {
+	e, err := readName(r, schema, name)
+	switch {
+	case err != nil:
+		return nil, err
+	case e == nil:
+		return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", name)
+	case !e.Sequence:
+		return nil, pgerror.Newf(pgerror.CodeWrongObjectType, "%q is not a sequence", name)
+	}
+	return getSequence(r, e.Table)
+}
+
+// ListSequences returns all sequences ordered by name.
+func ListSequences(r engine.Reader) ([]*Sequence, error) {
+	it, err := r.Scan(seqDescPrefix, prefixEnd(seqDescPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var seqs []*Sequence
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s, err := getSequence(r, ID(binary.BigEndian.Uint32(k[len(seqDescPrefix):])))
+		if err != nil {
+			return nil, err
+		}
+		seqs = append(seqs, s)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		if seqs[i].Name != seqs[j].Name {
+			return seqs[i].Name < seqs[j].Name
+		}
+		return seqs[i].Schema < seqs[j].Schema
+	})
+	return seqs, nil
+}
+
+// CreateSequence assigns s an ID and stores it along with its name and its
+// value before the first nextval.
+func CreateSequence(txn engine.Txn, s *Sequence) error {
+	if err := nameInUse(txn, s.Schema, s.Name); err != nil {
+		return err
+	}
+	id, err := allocateID(txn)
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	if err := writeName(txn, s.Schema, s.Name, nameEntry{Table: id, Sequence: true}); err != nil {
+		return err
+	}
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := txn.Put(seqDescKey(id), v); err != nil {
+		return err
+	}
+	return PutSequenceValue(txn, id, SequenceValue{Last: s.Start})
+}
+
+// DropSequence removes s, its name and its value.
+func DropSequence(txn engine.Txn, s *Sequence) error {
+	if err := txn.Delete(nameKey(s.Schema, s.Name)); err != nil {
+		return err
+	}
+	if err := txn.Delete(seqDescKey(s.ID)); err != nil {
+		return err
+	}
+	return txn.Delete(seqValueKey(s.ID))
+}
+
+// GetSequenceValue reads the value of the sequence with the given ID. It
+// returns engine.ErrNotFound if r cannot see the sequence.
+func GetSequenceValue(r engine.Reader, id ID) (SequenceValue, error) {
+	v, err := r.Get(seqValueKey(id))
+	if err != nil {
+		return SequenceValue{}, err
+	}
+	if len(v) != 9 {
+		return SequenceValue{}, fmt.Errorf("catalog: corrupt value of sequence %d", id)
+	}
+	return SequenceValue{Last: int64(binary.BigEndian.Uint64(v)), Called: v[8] != 0}, nil
+}
+
+// PutSequenceValue stores the value of the sequence with the given ID.
+func PutSequenceValue(w engine.Writer, id ID, v SequenceValue) error {
+	buf := binary.BigEndian.AppendUint64(nil, uint64(v.Last))
+	called := byte(0)
+	if v.Called {
+		called = 1
+	}
+	return w.Put(seqValueKey(id), append(buf, called))
+}
@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// TableStats are statistics of the rows of a table, gathered by ANALYZE
+// from a sample of the rows, for the planner to estimate how many rows a
+// plan reads. They are stored apart from the table's descriptor, so that
+// gathering them is not a schema change.
+type TableStats struct {
+	RowCount int64          `json:"row_count"`
+	Columns  []*ColumnStats `json:"columns"`
+}
+
+// ColumnStats are the statistics of one column.
+type ColumnStats struct {
+	Column ColumnID `json:"column"`
+	// NullFrac is the fraction of rows in which the column is NULL.
+	NullFrac float64 `json:"null_frac"`
+	// Distinct estimates the number of distinct non-NULL values.
+	Distinct float64 `json:"distinct"`
+	// Histogram holds the key encodings of the bounds of buckets that
+	// each hold about the same number of non-NULL values, in ascending
+	// order. Columns whose values have no ordered key encoding have none.
+	Histogram [][]byte `json:"histogram,omitempty"`
+}
+
+// Column returns the statistics of the column id, or nil if there are
+// none, as for a column added since the table was analyzed.
+func (s *TableStats) Column(id ColumnID) *ColumnStats {
+	for _, c := range s.Columns {
+		if c.Column == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func statsKey(id ID) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), statsPrefix...), uint32(id))
+}
+
+// GetTableStats reads the statistics of the table with the given ID, or
+// returns nil if it has not been analyzed.
+func GetTableStats(r engine.Reader, id ID) (*TableStats, error) {
+	v, err := r.Get(statsKey(id))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s TableStats
+	if err := json.Unmarshal(v, &s); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt statistics of table %d: %w", id, err)
+	}
+	return &s, nil
+}
+
+// PutTableStats stores the statistics of the table with the given ID.
+func PutTableStats(w engine.Writer, id ID, s *TableStats) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return w.Put(statsKey(id), v)
+}
@@ -0,0 +1,336 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// SystemPrefix starts every catalog key. Table data lives under larger
+// prefixes, so a scan of user data never sees catalog entries.
+const SystemPrefix byte = 0x01
+
+// Keys within the system keyspace.
+var (
+	descPrefix     = []byte{SystemPrefix, 'd'}
+	namePrefix     = []byte{SystemPrefix, 'n'}
+	idGenKey       = []byte{SystemPrefix, 'i'}
+	seqDescPrefix  = []byte{SystemPrefix, 's'}
+	seqValuePrefix = []byte{SystemPrefix, 'v'}
+	statsPrefix    = []byte{SystemPrefix, 't'}
+)
+
+// FirstUserID is the first ID handed out to tables. Lower IDs are reserved
+// for system tables.
+const FirstUserID ID = 100
+
+func descKey(id ID) []byte {
+	return binary.BigEndian.AppendUint32(append([]byte(nil), descPrefix...), uint32(id))
+}
+
+// nameEntry is the value of a namespace key. Tables, indexes and
+// sequences share one namespace, as in PostgreSQL's pg_class. The entry of
+// a sequence holds its ID as Table.
+type nameEntry struct {
+	Table    ID      `json:"table"`
+	Index    IndexID `json:"index,omitempty"`
+	Sequence bool    `json:"sequence,omitempty"`
+}
+
+func readName(r engine.Reader, schema ID, name string) (*nameEntry, error) {
+	v, err := r.Get(nameKey(schema, name))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e nameEntry
+	if err := json.Unmarshal(v, &e); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt name entry %q: %w", name, err)
+	}
+	return &e, nil
+}
+
+func writeName(w engine.Writer, schema ID, name string, e nameEntry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return w.Put(nameKey(schema, name), v)
+}
+
+// nameInUse returns an error if name is already taken by a relation in
+// schema.
+func nameInUse(r engine.Reader, schema ID, name string) error {
+	e, err := readName(r, schema, name)
+	if err != nil || e == nil {
+		return err
+	}
+	return pgerror.Newf(pgerror.CodeDuplicateTable, "relation %q already exists", name)
+}
+
+// GetTableByID reads the descriptor with the given ID.
+func GetTableByID(r engine.Reader, id ID) (*Table, error) {
+	v, err := r.Get(descKey(id))
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "relation with OID %d does not exist", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t Table
+	if err := json.Unmarshal(v, &t); err != nil {
+		return nil, fmt.Errorf("catalog: corrupt descriptor %d: %w", id, err)
+	}
+	if err := t.canonicalizeTypes(); err != nil {
+		return nil, fmt.Errorf("catalog: descriptor %d: %w", id, err)
+	}
+	if t.Stats, err = GetTableStats(r, id); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// LookupTable returns the table named name in schema, or nil if there is
+// none.
+func LookupTable(r engine.Reader, schema ID, name string) (*Table, error) {
+	e, err := readName(r, schema, name)
+	if err != nil || e == nil || e.Index != 0 || e.Sequence {
+		return nil, err
+	}
+	return GetTableByID(r, e.Table)
+}
+
+// MustLookupTable is LookupTable but reports a missing table as an error.
+func MustLookupTable(r engine.Reader, schema ID, name string) (*Table, error) {
+	t, err := LookupTable(r, schema, name)
+	if err == nil && t == nil {
+		err = pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", name)
+	}
+	return t, err
+}
+
+// LookupIndex returns the index named name in schema and its table, or
+// nils if there is none.
+func LookupIndex(r engine.Reader, schema ID, name string) (*Table, *Index, error) {
+	e, err := readName(r, schema, name)
+	if err != nil || e == nil || e.Index == 0 {
+		return nil, nil, err
+	}
+	t, err := GetTableByID(r, e.Table)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, idx := range t.AllIndexes() {
+		if idx.ID == e.Index {
+			return t, idx, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("catalog: index %q missing from table %q", name, t.Name)
+}
+
+// ListTables returns all tables ordered by name.
+func ListTables(r engine.Reader) ([]*Table, error) {
+	it, err := r.Scan(descPrefix, prefixEnd(descPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	var tables []*Table
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := GetTableByID(r, ID(binary.BigEndian.Uint32(k[len(descPrefix):])))
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Name != tables[j].Name {
+			return tables[i].Name < tables[j].Name
+		}
+		return tables[i].Schema < tables[j].Schema
+	})
+	return tables, nil
+}
+
+// allocateID returns the next unused table or sequence ID.
+func allocateID(txn engine.Txn) (ID, error) {
+	id := FirstUserID
+	v, err := txn.Get(idGenKey)
+	switch {
+	case err == nil:
+		id = ID(binary.BigEndian.Uint32(v))
+	case !errors.Is(err, engine.ErrNotFound):
+		return 0, err
+	}
+	if err := txn.Put(idGenKey, binary.BigEndian.AppendUint32(nil, uint32(id+1))); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// CreateTable assigns t an ID and stores it along with the names of the
+// table and its indexes, which are in the table's schema. Foreign keys
+// referencing t itself, whose Referenced is still zero, get the ID too.
+func CreateTable(txn engine.Txn, t *Table) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	names := []string{t.Name}
+	for _, idx := range t.AllIndexes() {
+		names = append(names, idx.Name)
+	}
+	for i, name := range names {
+		for _, prev := range names[:i] {
+			if prev == name {
+				return pgerror.Newf(pgerror.CodeDuplicateTable, "relation %q already exists", name)
+			}
+		}
+		if err := nameInUse(txn, t.Schema, name); err != nil {
+			return err
+		}
+	}
+	id, err := allocateID(txn)
+	if err != nil {
+		return err
+	}
+	t.ID = id
+	for _, fk := range t.ForeignKeys {
+		if fk.Referenced == 0 {
+			fk.Referenced = id
+		}
+	}
+	if err := writeName(txn, t.Schema, t.Name, nameEntry{Table: id}); err != nil {
+		return err
+	}
+	for _, idx := range t.AllIndexes() {
+		if err := writeName(txn, t.Schema, idx.Name, nameEntry{Table: id, Index: idx.ID}); err != nil {
+			return err
+		}
+	}
+	return WriteTable(txn, t)
+}
+
+// WriteTable stores an updated descriptor, bumping its version.
+func WriteTable(txn engine.Txn, t *Table) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+	t.Version++
+	v, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return txn.Put(descKey(t.ID), v)
+}
+
+// AddIndex registers a new secondary index of t by name and stores the
+// updated descriptor. The index must already have been added to t.
+func AddIndex(txn engine.Txn, t *Table, idx *Index) error {
+	if err := nameInUse(txn, t.Schema, idx.Name); err != nil {
+		return err
+	}
+	if err := writeName(txn, t.Schema, idx.Name, nameEntry{Table: t.ID, Index: idx.ID}); err != nil {
+		return err
+	}
+	return WriteTable(txn, t)
+}
+
+// DropIndex removes a secondary index from t and stores the updated
+// descriptor. It does not delete the index entries.
+func DropIndex(txn engine.Txn, t *Table, idx *Index) error {
+	for i, x := range t.Indexes {
+		if x == idx {
+			t.Indexes = append(t.Indexes[:i:i], t.Indexes[i+1:]...)
+			if err := txn.Delete(nameKey(t.Schema, idx.Name)); err != nil {
+				return err
+			}
+			return WriteTable(txn, t)
+		}
+	}
+	return fmt.Errorf("catalog: index %q is not a secondary index of %q", idx.Name, t.Name)
+}
+
+// AlterTable stores t, a changed copy of the descriptor old, renaming the
+// table and adding and removing the names of indexes to match. It does not
+// touch the table's data.
+func AlterTable(txn engine.Txn, old, t *Table) error {
+	if t.Name != old.Name {
+		if err := nameInUse(txn, t.Schema, t.Name); err != nil {
+			return err
+		}
+		if err := txn.Delete(nameKey(t.Schema, old.Name)); err != nil {
+			return err
+		}
+		if err := writeName(txn, t.Schema, t.Name, nameEntry{Table: t.ID}); err != nil {
+			return err
+		}
+	}
+	has := func(t *Table, idx *Index) bool {
+		for _, x := range t.Indexes {
+			if x.ID == idx.ID {
+				return true
+			}
+		}
+		return false
+	}
+	for _, idx := range old.Indexes {
+		if !has(t, idx) {
+			if err := txn.Delete(nameKey(t.Schema, idx.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, idx := range t.Indexes {
+		if !has(old, idx) {
+			if err := nameInUse(txn, t.Schema, idx.Name); err != nil {
+				return err
+			}
+			if err := writeName(txn, t.Schema, idx.Name, nameEntry{Table: t.ID, Index: idx.ID}); err != nil {
+				return err
+			}
+		}
+	}
+	return WriteTable(txn, t)
+}
+
+// DropTable removes t, its index names and its statistics. It does not
+// delete the table's data.
+func DropTable(txn engine.Txn, t *Table) error {
+	for _, idx := range t.AllIndexes() {
+		if err := txn.Delete(nameKey(t.Schema, idx.Name)); err != nil {
+			return err
+		}
+	}
+	if err := txn.Delete(nameKey(t.Schema, t.Name)); err != nil {
+		return err
+	}
+	if err := txn.Delete(statsKey(t.ID)); err != nil {
+		return err
+	}
+	return txn.Delete(descKey(t.ID))
+}
+
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
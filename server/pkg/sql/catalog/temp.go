@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+)
+
+// tempPrefix starts the keys of the temporary ranges statements spill the
+// rows of sorts and hash aggregations to once they exceed work_mem. It is
+// a key of the engine, outside the keyspaces of the databases.
+var tempPrefix = []byte{SystemPrefix, 'x'}
+
+// TempRange returns the bounds of the temporary range id, which is unique
+// among those of a server while it runs.
+func TempRange(id uint64) (start, end []byte) {
+	start = binary.BigEndian.AppendUint64(append([]byte(nil), tempPrefix...), id)
+	return start, prefixEnd(start)
+}
+
+// DropTempRanges deletes every temporary range of e, those a server left
+// behind when it stopped while statements spilled. It must not be called
+// while a server runs on e.
+func DropTempRanges(e engine.Engine) error {
+	return engine.DeleteRange(e, tempPrefix, prefixEnd(tempPrefix))
+}
+
+// TempRangeIDs returns the IDs of the temporary ranges of r that hold
+// rows, in order.
+func TempRangeIDs(r engine.Reader) ([]uint64, error) {
+	end := prefixEnd(tempPrefix)
+	it, err := r.Scan(tempPrefix, end)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			it.Close()
+			return ids, nil
+		}
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if len(k) < len(tempPrefix)+8 {
+			continue
+		}
+		id := binary.BigEndian.Uint64(k[len(tempPrefix):])
+		ids = append(ids, id)
+		// The rows of the range need not be read.
+		_, next := TempRange(id)
+		if it, err = engine.Seek(r, it, next, end); err != nil {
+			if it != nil {
+				it.Close()
+			}
+			return nil, err
+		}
+	}
+}
+
+// DropTempRange deletes the temporary range id.
+func DropTempRange(e engine.Engine, id uint64) error {
+	start, end := TempRange(id)
+	return engine.DeleteRange(e, start, end)
+}
@@ -0,0 +1,239 @@
+package sql
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// encoding is a client encoding: the character set the client's text is
+// in, which the server converts to and from UTF-8, the encoding it stores
+// text in.
+type encoding struct {
+	name string
+	// high maps the bytes 0x80 to 0xFF of a single-byte encoding to their
+	// characters, or to utf8.RuneError where the encoding has none. It is
+	// nil for UTF8.
+	high *[128]rune
+	// low maps the characters of high back to their bytes.
+	low map[rune]byte
+}
+
+var utf8Encoding = &encoding{name: "UTF8"}
+
+// encodings are the client encodings by their names and aliases,
+// normalized by encodingKey.
+var encodings = make(map[string]*encoding)
+
+func init() {
+	latin1 := new([128]rune)
+	for i := range latin1 {
+		latin1[i] = rune(0x80 + i)
+	}
+	// WIN1252 is LATIN1 with printable characters instead of the C1
+	// controls, but for five bytes it leaves undefined.
+	win1252 := *latin1
+	copy(win1252[:32], []rune{
+		'€', utf8.RuneError, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', utf8.RuneError, 'Ž', utf8.RuneError,
+		utf8.RuneError, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', utf8.RuneError, 'ž', 'Ÿ',
+	})
+	for _, e := range []struct {
+		enc     *encoding
+		aliases []string
+	}{
+		{utf8Encoding, []string{"UTF8", "UNICODE"}},
+		{newSingleByteEncoding("LATIN1", latin1), []string{"LATIN1", "ISO88591"}},
+		{newSingleByteEncoding("WIN1252", &win1252), []string{"WIN1252", "WINDOWS1252"}},
+	} {
+		for _, alias := range e.aliases {
+			encodings[encodingKey(alias)] = e.enc
+		}
+	}
+}
+
+func newSingleByteEncoding(name string, high *[128]rune) *encoding {
+	e := &encoding{name: name, high: high, low: make(map[rune]byte)}
+	for i, r := range high {
+		if r != utf8.RuneError {
+			e.low[r] = byte(0x80 + i)
+		}
+	}
+	return e
+}
+
+// encodingKey normalizes the name of an encoding as PostgreSQL does, so
+// that "utf-8" and "UTF8" name the same encoding.
+func encodingKey(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
+
+// lookupEncoding returns the encoding name, or an error if the server does
+// not support it.
+func lookupEncoding(name string) (*encoding, error) {
+	e := encodings[encodingKey(name)]
+	if e == nil {
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "invalid value for parameter \"client_encoding\": %q", name)
+	}
+	return e, nil
+}
+
+// decode converts s from e to UTF-8, checking that it is valid text in e
+// without NUL bytes.
+func (e *encoding) decode(s string) (string, error) {
+	i := firstNonASCII(s)
+	if e.high == nil || i == len(s) {
+		if err := types.CheckText(s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	if j := strings.IndexByte(s, 0); j >= 0 {
+		return "", types.CheckText(s[j : j+1])
+	}
+	var b strings.Builder
+	b.Grow(len(s) + len(s)/2)
+	b.WriteString(s[:i])
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			b.WriteByte(c)
+			continue
+		}
+		r := e.high[c-0x80]
+		if r == utf8.RuneError {
+			return "", untranslatable(s[i:i+1], e.name, "UTF8")
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// encode converts s from UTF-8 to e.
+func (e *encoding) encode(s string) (string, error) {
+	i := firstNonASCII(s)
+	if e.high == nil || i == len(s) {
+		return s, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s[:i])
+	for i < len(s) {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		c, ok := e.low[r]
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteByte(byte(r))
+		case ok:
+			b.WriteByte(c)
+		case r == utf8.RuneError && size == 1:
+			return "", types.CheckText(s[i:])
+		default:
+			return "", untranslatable(s[i:i+size], "UTF8", e.name)
+		}
+		i += size
+	}
+	return b.String(), nil
+}
+
+func firstNonASCII(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// untranslatable returns the error for the character seq of encoding from
+// that encoding to has no equivalent of.
+func untranslatable(seq, from, to string) error {
+	return pgerror.Newf(pgerror.CodeUntranslatableCharacter,
+		"character with byte sequence %s in encoding %q has no equivalent in encoding %q", byteSequence(seq), from, to)
+}
+
+// byteSequence formats the bytes of s as PostgreSQL shows them in errors,
+// such as 0xe2 0x82 0xac.
+func byteSequence(s string) string {
+	parts := make([]string, len(s))
+	for i := 0; i < len(s); i++ {
+		parts[i] = fmt.Sprintf("0x%02x", s[i])
+	}
+	return strings.Join(parts, " ")
+}
+
+// decodingReader converts the text read from r from an encoding to UTF-8.
+// The encoding must be a single-byte one, so that text can be converted
+// however it is split between reads.
+type decodingReader struct {
+	r   io.Reader
+	enc *encoding
+	buf []byte
+	err error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		// A byte decodes to at most 3 bytes of UTF-8.
+		raw := make([]byte, max(len(p)/3, 1))
+		n, err := d.r.Read(raw)
+		d.err = err
+		s, err := d.enc.decode(string(raw[:n]))
+		if err != nil {
+			return 0, err
+		}
+		d.buf = []byte(s)
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// SetClientEncoding sets the encoding of the client's text, as the
+// client_encoding parameter of its startup message does. The client
+// encodings are UTF8, LATIN1 and WIN1252. The text of queries and of COPY
+// FROM STDIN data is converted from it to UTF-8; text sent to the client
+// must be converted back with EncodeText.
+func (s *Session) SetClientEncoding(name string) error {
+	return s.SetParameter("client_encoding", name)
+}
+
+// ClientEncoding returns the name of the session's client encoding.
+func (s *Session) ClientEncoding() string {
+	return s.encoding().name
+}
+
+// encoding returns the session's client encoding.
+func (s *Session) encoding() *encoding {
+	e, err := lookupEncoding(s.getVar("client_encoding"))
+	if err != nil {
+		return utf8Encoding
+	}
+	return e
+}
+
+// DecodeText converts text the client sent, such as a parameter of Bind in
+// the text format, from the client encoding to UTF-8.
+func (s *Session) DecodeText(text []byte) (string, error) {
+	return s.encoding().decode(string(text))
+}
+
+// EncodeText converts text for the client, such as the text format of a
+// value of a result or the message of an error, from UTF-8 to the client
+// encoding. It fails if the text has a character the client encoding
+// cannot represent.
+func (s *Session) EncodeText(text string) ([]byte, error) {
+	out, err := s.encoding().encode(text)
+	return []byte(out), err
+}
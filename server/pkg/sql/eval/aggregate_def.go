@@ -0,0 +1,116 @@
+package eval
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// AggregateDef defines an aggregate through callbacks over an opaque state,
+// like PostgreSQL's CREATE AGGREGATE with its transition, final and
+// combine functions. It is how embedders add aggregates such as t-digest
+// percentiles or geographic centroids.
+type AggregateDef struct {
+	// Params are the declared parameter types. types.Any accepts any type.
+	Params     []*types.T
+	ReturnType *types.T
+	// Init returns the state of a new group. If nil, the state starts as
+	// nil.
+	Init func() any
+	// Step folds one row's arguments into state and returns the new state.
+	Step func(ctx *Context, state any, args []types.Datum) (any, error)
+	// Final computes the result from the state. If nil, the state must be
+	// a types.Datum of ReturnType, or nil for NULL. It must not modify the
+	// state, which over a window takes more rows after a result is read.
+	Final func(ctx *Context, state any) (types.Datum, error)
+	// Merge combines the states of two disjoint sets of rows and returns
+	// the combined state. It is optional; an aggregate without Merge is
+	// always computed serially.
+	Merge func(ctx *Context, a, b any) (any, error)
+	// NullCall means Step is called even for rows where an argument is
+	// NULL. When false, such rows are skipped.
+	NullCall bool
+}
+
+// aggregate returns the overload implemented by d.
+func (d *AggregateDef) aggregate() *Aggregate {
+	return &Aggregate{
+		Params:     d.Params,
+		ReturnType: d.ReturnType,
+		NullCall:   d.NullCall,
+		New: func([]*types.T) AggregateFunc {
+			a := defAgg{def: d}
+			if d.Init != nil {
+				a.state = d.Init()
+			}
+			if d.Merge != nil {
+				return &mergeableDefAgg{a}
+			}
+			return &a
+		},
+	}
+}
+
+func (d *AggregateDef) check(name string) error {
+	switch {
+	case d == nil:
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "aggregate %s has a nil definition", name)
+	case d.Step == nil:
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "aggregate %s has no step function", name)
+	case d.ReturnType == nil:
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "aggregate %s has no return type", name)
+	}
+	for _, p := range d.Params {
+		if p == nil {
+			return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "aggregate %s has a parameter with no type", name)
+		}
+		if p.Family == types.UnknownFamily {
+			return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition,
+				"aggregate %s cannot have a parameter of type unknown", name)
+		}
+	}
+	return nil
+}
+
+// defAgg is the state of a group of an aggregate defined by callbacks.
+type defAgg struct {
+	def   *AggregateDef
+	state any
+}
+
+func (a *defAgg) Add(ctx *Context, args []types.Datum) error {
+	state, err := a.def.Step(ctx, a.state, args)
+	if err != nil {
+		return err
+	}
+	a.state = state
+	return nil
+}
+
+func (a *defAgg) Result(ctx *Context) (types.Datum, error) {
+	if a.def.Final != nil {
+		return a.def.Final(ctx, a.state)
+	}
+	if a.state == nil {
+		return types.DNull, nil
+	}
+	d, ok := a.state.(types.Datum)
+	if !ok {
+		return nil, pgerror.Newf(pgerror.CodeInternalError,
+			"aggregate state of type %T is not a datum and there is no final function", a.state)
+	}
+	return d, nil
+}
+
+// mergeableDefAgg is a defAgg whose definition has a Merge callback.
+type mergeableDefAgg struct {
+	defAgg
+}
+
+func (a *mergeableDefAgg) Merge(ctx *Context, other AggregateFunc) error {
+	state, err := a.def.Merge(ctx, a.state, other.(*mergeableDefAgg).state)
+	if err != nil {
+		return err
+	}
+	a.state = state
+	return nil
+}
@@ -0,0 +1,376 @@
+package eval
+
+import (
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// AggregateFunc accumulates the rows of one group.
+type AggregateFunc interface {
+	// Add folds one row's arguments into the state.
+	Add(ctx *Context, args []types.Datum) error
+	// Result returns the aggregate of the rows added so far. Over a window,
+	// more rows may be added after it is called.
+	Result(ctx *Context) (types.Datum, error)
+}
+
+// MergeableAggregateFunc is an AggregateFunc whose partial states, each
+// accumulated over a disjoint set of rows, can be combined. Aggregates must
+// be mergeable to be computed in parallel.
+type MergeableAggregateFunc interface {
+	AggregateFunc
+	// Merge folds other, a state of the same aggregate overload, into the
+	// receiver.
+	Merge(ctx *Context, other AggregateFunc) error
+}
+
+// Aggregate is one signature of an aggregate function.
+type Aggregate struct {
+	// Params are the declared parameter types. types.Any accepts any type.
+	Params []*types.T
+	// ReturnType is the result type. ReturnFn overrides it for polymorphic
+	// aggregates.
+	ReturnType *types.T
+	ReturnFn   func(args []*types.T) *types.T
+	// New returns the state for a new group, given the argument types.
+	New func(args []*types.T) AggregateFunc
+	// NullCall means rows are added even when an argument is NULL. When
+	// false, such rows are skipped, like a strict transition function in
+	// PostgreSQL.
+	NullCall bool
+	// Oid identifies the aggregate in pg_proc. It is assigned on
+	// registration.
+	Oid types.Oid
+}
+
+func (a *Aggregate) returnType(args []*types.T) *types.T {
+	if a.ReturnFn != nil {
+		return a.ReturnFn(args)
+	}
+	return a.ReturnType
+}
+
+// AggregateCall is a resolved call of an aggregate function. It is not an
+// Expr: it is computed over groups of rows by an aggregation operator.
+type AggregateCall struct {
+	Name string
+	// Args are evaluated over each input row. count(*) has none.
+	Args []Expr
+	// Distinct means each distinct argument tuple is added only once.
+	Distinct bool
+	Agg      *Aggregate
+	Typ      *types.T
+}
+
+// NewAggregateCall resolves the aggregate name in r for args.
+func (r *Registry) NewAggregateCall(name string, args []Expr, distinct bool) (*AggregateCall, error) {
+	argTypes := exprTypes(args)
+	a, err := r.ResolveAggregate(name, argTypes)
+	if err != nil {
+		return nil, err
+	}
+	coerced := make([]Expr, len(args))
+	for i, arg := range args {
+		if coerced[i], err = Coerce(arg, a.Params[i]); err != nil {
+			return nil, err
+		}
+		// Untyped literals passed to polymorphic parameters are text.
+		if coerced[i].ResolvedType().Family == types.UnknownFamily {
+			if coerced[i], err = Coerce(coerced[i], types.String); err != nil {
+				return nil, err
+			}
+		}
+		argTypes[i] = coerced[i].ResolvedType()
+	}
+	return &AggregateCall{Name: name, Args: coerced, Distinct: distinct, Agg: a, Typ: a.returnType(argTypes)}, nil
+}
+
+// ArgTypes returns the types of the call's arguments.
+func (c *AggregateCall) ArgTypes() []*types.T {
+	return exprTypes(c.Args)
+}
+
+func (c *AggregateCall) String() string {
+	if len(c.Args) == 0 {
+		return c.Name + "(*)"
+	}
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('(')
+	if c.Distinct {
+		b.WriteString("DISTINCT ")
+	}
+	b.WriteString(joinExprs(c.Args))
+	b.WriteByte(')')
+	return b.String()
+}
+
+// countAgg implements count(*) and count(x).
+type countAgg struct{ n int64 }
+
+func (a *countAgg) Add(*Context, []types.Datum) error { a.n++; return nil }
+
+func (a *countAgg) Result(*Context) (types.Datum, error) { return types.DInt(a.n), nil }
+
+func (a *countAgg) Merge(_ *Context, other AggregateFunc) error {
+	a.n += other.(*countAgg).n
+	return nil
+}
+
+// intSumAgg sums integers exactly. sum of int8 is numeric, as the total
+// may not fit; narrower integers sum to int8.
+type intSumAgg struct {
+	sum     *types.Dec
+	numeric bool
+}
+
+func (a *intSumAgg) Add(_ *Context, args []types.Datum) error {
+	a.sum = addDecs(a.sum, types.NewDecFromInt(int64(args[0].(types.DInt))))
+	return nil
+}
+
+func (a *intSumAgg) Merge(_ *Context, other AggregateFunc) error {
+	a.sum = addDecs(a.sum, other.(*intSumAgg).sum)
+	return nil
+}
+
+func (a *intSumAgg) Result(*Context) (types.Datum, error) {
+	if a.sum == nil {
+		return types.DNull, nil
+	}
+	if a.numeric {
+		return types.NewDDecimal(a.sum), nil
+	}
+	i, ok := a.sum.Int64()
+	if !ok {
+		return nil, errIntOutOfRange
+	}
+	return types.DInt(i), nil
+}
+
+// floatSumAgg implements sum and avg of floating point values.
+type floatSumAgg struct {
+	sum float64
+	n   int64
+	avg bool
+}
+
+func (a *floatSumAgg) Add(_ *Context, args []types.Datum) error {
+	a.sum += float64(args[0].(types.DFloat))
+	a.n++
+	return nil
+}
+
+func (a *floatSumAgg) Merge(_ *Context, other AggregateFunc) error {
+	o := other.(*floatSumAgg)
+	a.sum += o.sum
+	a.n += o.n
+	return nil
+}
+
+func (a *floatSumAgg) Result(*Context) (types.Datum, error) {
+	switch {
+	case a.n == 0:
+		return types.DNull, nil
+	case a.avg:
+		return types.DFloat(a.sum / float64(a.n)), nil
+	}
+	return types.DFloat(a.sum), nil
+}
+
+// decSumAgg implements sum and avg of numeric values, and avg of
+// integers, which is numeric.
+type decSumAgg struct {
+	sum *types.Dec
+	n   int64
+	avg bool
+}
+
+func (a *decSumAgg) Add(_ *Context, args []types.Datum) error {
+	var v *types.Dec
+	switch d := args[0].(type) {
+	case types.DInt:
+		v = types.NewDecFromInt(int64(d))
+	case *types.DDecimal:
+		v = &d.Dec
+	}
+	a.sum = addDecs(a.sum, v)
+	a.n++
+	return nil
+}
+
+func (a *decSumAgg) Merge(_ *Context, other AggregateFunc) error {
+	o := other.(*decSumAgg)
+	a.sum = addDecs(a.sum, o.sum)
+	a.n += o.n
+	return nil
+}
+
+func (a *decSumAgg) Result(*Context) (types.Datum, error) {
+	switch {
+	case a.n == 0:
+		return types.DNull, nil
+	case a.avg:
+		return types.NewDDecimal(a.sum.Quo(types.NewDecFromInt(a.n))), nil
+	}
+	return types.NewDDecimal(a.sum), nil
+}
+
+// intervalSumAgg implements sum and avg of intervals.
+type intervalSumAgg struct {
+	sum types.DInterval
+	n   int64
+	avg bool
+}
+
+func (a *intervalSumAgg) Add(_ *Context, args []types.Datum) error {
+	a.sum = addIntervals(a.sum, args[0].(types.DInterval))
+	a.n++
+	return nil
+}
+
+func (a *intervalSumAgg) Merge(_ *Context, other AggregateFunc) error {
+	o := other.(*intervalSumAgg)
+	a.sum = addIntervals(a.sum, o.sum)
+	a.n += o.n
+	return nil
+}
+
+func (a *intervalSumAgg) Result(*Context) (types.Datum, error) {
+	switch {
+	case a.n == 0:
+		return types.DNull, nil
+	case a.avg:
+		return scaleInterval(a.sum, 1/float64(a.n)), nil
+	}
+	return a.sum, nil
+}
+
+// extremeAgg implements min and max. sign is -1 for min and 1 for max.
+type extremeAgg struct {
+	best types.Datum
+	sign int
+}
+
+func (a *extremeAgg) Add(_ *Context, args []types.Datum) error {
+	if a.best == nil || types.CompareDatums(args[0], a.best)*a.sign > 0 {
+		a.best = args[0]
+	}
+	return nil
+}
+
+func (a *extremeAgg) Merge(ctx *Context, other AggregateFunc) error {
+	if o := other.(*extremeAgg); o.best != nil {
+		return a.Add(ctx, []types.Datum{o.best})
+	}
+	return nil
+}
+
+func (a *extremeAgg) Result(*Context) (types.Datum, error) {
+	if a.best == nil {
+		return types.DNull, nil
+	}
+	return a.best, nil
+}
+
+// boolAgg implements bool_and and bool_or.
+type boolAgg struct {
+	result, seen bool
+	and          bool
+}
+
+func (a *boolAgg) Add(_ *Context, args []types.Datum) error {
+	v := bool(args[0].(types.DBool))
+	switch {
+	case !a.seen:
+		a.result, a.seen = v, true
+	case a.and:
+		a.result = a.result && v
+	default:
+		a.result = a.result || v
+	}
+	return nil
+}
+
+func (a *boolAgg) Merge(ctx *Context, other AggregateFunc) error {
+	if o := other.(*boolAgg); o.seen {
+		return a.Add(ctx, []types.Datum{types.MakeDBool(o.result)})
+	}
+	return nil
+}
+
+func (a *boolAgg) Result(*Context) (types.Datum, error) {
+	if !a.seen {
+		return types.DNull, nil
+	}
+	return types.MakeDBool(a.result), nil
+}
+
+// addDecs returns a + b, where a nil sum is empty.
+func addDecs(a, b *types.Dec) *types.Dec {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	return a.Add(b)
+}
+
+func addIntervals(a, b types.DInterval) types.DInterval {
+	return types.DInterval{Months: a.Months + b.Months, Days: a.Days + b.Days, Micros: a.Micros + b.Micros}
+}
+
+// intSumType is the result type of sum over integers: int8 for int2 and
+// int4, numeric for int8.
+func intSumType(args []*types.T) *types.T {
+	if args[0].Width == 64 {
+		return types.Decimal
+	}
+	return types.Int8
+}
+
+func init() {
+	r := Builtins
+
+	newCount := func([]*types.T) AggregateFunc { return &countAgg{} }
+	r.RegisterAggregate("count",
+		&Aggregate{ReturnType: types.Int8, New: newCount},
+		&Aggregate{Params: []*types.T{types.Any}, ReturnType: types.Int8, New: newCount},
+	)
+
+	for _, avg := range []bool{false, true} {
+		name := "sum"
+		if avg {
+			name = "avg"
+		}
+		intAgg := &Aggregate{Params: []*types.T{types.Int}, ReturnFn: intSumType,
+			New: func(args []*types.T) AggregateFunc {
+				return &intSumAgg{numeric: args[0].Width == 64}
+			}}
+		floatAgg := &Aggregate{Params: []*types.T{types.Float}, ReturnFn: sameAsArg,
+			New: func([]*types.T) AggregateFunc { return &floatSumAgg{avg: avg} }}
+		if avg {
+			intAgg = &Aggregate{Params: []*types.T{types.Int}, ReturnType: types.Decimal,
+				New: func([]*types.T) AggregateFunc { return &decSumAgg{avg: true} }}
+			floatAgg.ReturnFn, floatAgg.ReturnType = nil, types.Float
+		}
+		r.RegisterAggregate(name, intAgg, floatAgg,
+			&Aggregate{Params: []*types.T{types.Decimal}, ReturnType: types.Decimal,
+				New: func([]*types.T) AggregateFunc { return &decSumAgg{avg: avg} }},
+			&Aggregate{Params: []*types.T{types.Interval}, ReturnType: types.Interval,
+				New: func([]*types.T) AggregateFunc { return &intervalSumAgg{avg: avg} }},
+		)
+	}
+
+	for name, sign := range map[string]int{"min": -1, "max": 1} {
+		r.RegisterAggregate(name, &Aggregate{Params: []*types.T{types.Any}, ReturnFn: sameAsArg,
+			New: func([]*types.T) AggregateFunc { return &extremeAgg{sign: sign} }})
+	}
+
+	for name, and := range map[string]bool{"bool_and": true, "every": true, "bool_or": false} {
+		r.RegisterAggregate(name, &Aggregate{Params: []*types.T{types.Bool}, ReturnType: types.Bool,
+			New: func([]*types.T) AggregateFunc { return &boolAgg{and: and} }})
+	}
+}
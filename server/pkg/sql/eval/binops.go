@@ -0,0 +1,300 @@
+package eval
+
+import (
+	"math"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+var (
+	errDivisionByZero = pgerror.New(pgerror.CodeDivisionByZero, "division by zero")
+	errIntOutOfRange  = pgerror.New(pgerror.CodeNumericValueOutOfRange, "bigint out of range")
+)
+
+// widerInt returns the wider of two integer types, which is the result type
+// of mixed-width integer arithmetic.
+func widerInt(l, r *types.T) *types.T {
+	if l.Family != types.IntFamily || r.Width > l.Width {
+		return r
+	}
+	return l
+}
+
+func intOp(fn func(a, b int64) (int64, error)) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		v, err := fn(int64(l.(types.DInt)), int64(r.(types.DInt)))
+		if err != nil {
+			return nil, err
+		}
+		return types.DInt(v), nil
+	}
+}
+
+func floatOp(fn func(a, b float64) (float64, error)) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		v, err := fn(float64(l.(types.DFloat)), float64(r.(types.DFloat)))
+		if err != nil {
+			return nil, err
+		}
+		return types.DFloat(v), nil
+	}
+}
+
+func decOp(fn func(a, b *types.Dec) (*types.Dec, error)) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		v, err := fn(&l.(*types.DDecimal).Dec, &r.(*types.DDecimal).Dec)
+		if err != nil {
+			return nil, err
+		}
+		return types.NewDDecimal(v), nil
+	}
+}
+
+// AddInterval returns t shifted by iv using calendar arithmetic.
+func AddInterval(t time.Time, iv types.DInterval) time.Time {
+	return t.AddDate(0, int(iv.Months), int(iv.Days)).Add(time.Duration(iv.Micros) * time.Microsecond)
+}
+
+func negInterval(iv types.DInterval) types.DInterval {
+	return types.DInterval{Months: -iv.Months, Days: -iv.Days, Micros: -iv.Micros}
+}
+
+// subTimes returns a - b as an interval of days and microseconds.
+func subTimes(a, b time.Time) types.DInterval {
+	us := a.Sub(b).Microseconds()
+	day := int64(24 * time.Hour / time.Microsecond)
+	return types.DInterval{Days: us / day, Micros: us % day}
+}
+
+func scaleInterval(iv types.DInterval, f float64) types.DInterval {
+	months := float64(iv.Months) * f
+	days := float64(iv.Days)*f + (months-math.Trunc(months))*30
+	micros := float64(iv.Micros)*f + (days-math.Trunc(days))*float64(24*time.Hour/time.Microsecond)
+	return types.DInterval{Months: int64(months), Days: int64(days), Micros: int64(math.Round(micros))}
+}
+
+// intArith holds the checked integer arithmetic operators.
+var intArith = map[string]func(a, b int64) (int64, error){
+	"+": func(a, b int64) (int64, error) {
+		c := a + b
+		if (c > a) != (b > 0) {
+			return 0, errIntOutOfRange
+		}
+		return c, nil
+	},
+	"-": func(a, b int64) (int64, error) {
+		c := a - b
+		if (c < a) != (b > 0) {
+			return 0, errIntOutOfRange
+		}
+		return c, nil
+	},
+	"*": func(a, b int64) (int64, error) {
+		if a == 0 || b == 0 {
+			return 0, nil
+		}
+		c := a * b
+		if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, errIntOutOfRange
+		}
+		return c, nil
+	},
+	"/": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, errIntOutOfRange
+		}
+		return a / b, nil
+	},
+	"%": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errDivisionByZero
+		}
+		if b == -1 {
+			return 0, nil
+		}
+		return a % b, nil
+	},
+	"&":  func(a, b int64) (int64, error) { return a & b, nil },
+	"|":  func(a, b int64) (int64, error) { return a | b, nil },
+	"#":  func(a, b int64) (int64, error) { return a ^ b, nil },
+	"<<": func(a, b int64) (int64, error) { return a << uint64(b&63), nil },
+	">>": func(a, b int64) (int64, error) { return a >> uint64(b&63), nil },
+}
+
+func init() {
+	r := Builtins
+
+	// Integer arithmetic.
+	for op, fn := range intArith {
+		r.RegisterBinOp(op, &BinOp{Left: types.Int, Right: types.Int, ReturnFn: widerInt, Fn: intOp(fn)})
+	}
+
+	// Floating point arithmetic.
+	floatArith := map[string]func(a, b float64) (float64, error){
+		"+": func(a, b float64) (float64, error) { return a + b, nil },
+		"-": func(a, b float64) (float64, error) { return a - b, nil },
+		"*": func(a, b float64) (float64, error) { return a * b, nil },
+		"/": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errDivisionByZero
+			}
+			return a / b, nil
+		},
+		"^": func(a, b float64) (float64, error) { return math.Pow(a, b), nil },
+	}
+	for op, fn := range floatArith {
+		r.RegisterBinOp(op, &BinOp{Left: types.Float, Right: types.Float, ReturnType: types.Float, Fn: floatOp(fn)})
+	}
+
+	// Numeric arithmetic.
+	decArith := map[string]func(a, b *types.Dec) (*types.Dec, error){
+		"+": func(a, b *types.Dec) (*types.Dec, error) { return a.Add(b), nil },
+		"-": func(a, b *types.Dec) (*types.Dec, error) { return a.Sub(b), nil },
+		"*": func(a, b *types.Dec) (*types.Dec, error) { return a.Mul(b), nil },
+		"/": func(a, b *types.Dec) (*types.Dec, error) {
+			if b.IsZero() {
+				return nil, errDivisionByZero
+			}
+			return a.Quo(b), nil
+		},
+		"%": func(a, b *types.Dec) (*types.Dec, error) {
+			if b.IsZero() {
+				return nil, errDivisionByZero
+			}
+			return a.Rem(b), nil
+		},
+	}
+	for op, fn := range decArith {
+		r.RegisterBinOp(op, &BinOp{Left: types.Decimal, Right: types.Decimal, ReturnType: types.Decimal, Fn: decOp(fn)})
+	}
+
+	// String concatenation. Non-string operands are converted to text.
+	concat := func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		return types.DString(l.String() + r.String()), nil
+	}
+	r.RegisterBinOp("||", &BinOp{Left: types.String, Right: types.String, ReturnType: types.String, Fn: concat})
+	r.RegisterBinOp("||", &BinOp{Left: types.Any, Right: types.String, ReturnType: types.String, Fn: concat})
+	r.RegisterBinOp("||", &BinOp{Left: types.String, Right: types.Any, ReturnType: types.String, Fn: concat})
+	r.RegisterBinOp("||", &BinOp{Left: types.Bytes, Right: types.Bytes, ReturnType: types.Bytes,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return l.(types.DBytes) + r.(types.DBytes), nil
+		}})
+
+	// Date arithmetic.
+	r.RegisterBinOp("+", &BinOp{Left: types.Date, Right: types.Int, ReturnType: types.Date,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return l.(types.DDate) + types.DDate(r.(types.DInt)), nil
+		}})
+	r.RegisterBinOp("+", &BinOp{Left: types.Int, Right: types.Date, ReturnType: types.Date,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return r.(types.DDate) + types.DDate(l.(types.DInt)), nil
+		}})
+	r.RegisterBinOp("-", &BinOp{Left: types.Date, Right: types.Int, ReturnType: types.Date,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return l.(types.DDate) - types.DDate(r.(types.DInt)), nil
+		}})
+	r.RegisterBinOp("-", &BinOp{Left: types.Date, Right: types.Date, ReturnType: types.Int4,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return types.DInt(l.(types.DDate) - r.(types.DDate)), nil
+		}})
+
+	// Timestamp and interval arithmetic.
+	for _, ts := range []*types.T{types.Timestamp, types.TimestampTZ} {
+		wrap := func(t time.Time) types.Datum { return types.MakeDTimestamp(t) }
+		if ts == types.TimestampTZ {
+			wrap = func(t time.Time) types.Datum { return types.MakeDTimestampTZ(t) }
+		}
+		r.RegisterBinOp("+", &BinOp{Left: ts, Right: types.Interval, ReturnType: ts,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return wrap(AddInterval(timeOf(l), r.(types.DInterval))), nil
+			}})
+		r.RegisterBinOp("+", &BinOp{Left: types.Interval, Right: ts, ReturnType: ts,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return wrap(AddInterval(timeOf(r), l.(types.DInterval))), nil
+			}})
+		r.RegisterBinOp("-", &BinOp{Left: ts, Right: types.Interval, ReturnType: ts,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return wrap(AddInterval(timeOf(l), negInterval(r.(types.DInterval)))), nil
+			}})
+		r.RegisterBinOp("-", &BinOp{Left: ts, Right: ts, ReturnType: types.Interval,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return subTimes(timeOf(l), timeOf(r)), nil
+			}})
+	}
+	r.RegisterBinOp("+", &BinOp{Left: types.Date, Right: types.Interval, ReturnType: types.Timestamp,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return types.MakeDTimestamp(AddInterval(l.(types.DDate).Time(), r.(types.DInterval))), nil
+		}})
+	r.RegisterBinOp("-", &BinOp{Left: types.Date, Right: types.Interval, ReturnType: types.Timestamp,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return types.MakeDTimestamp(AddInterval(l.(types.DDate).Time(), negInterval(r.(types.DInterval)))), nil
+		}})
+	r.RegisterBinOp("+", &BinOp{Left: types.Interval, Right: types.Interval, ReturnType: types.Interval,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			a, b := l.(types.DInterval), r.(types.DInterval)
+			return types.DInterval{Months: a.Months + b.Months, Days: a.Days + b.Days, Micros: a.Micros + b.Micros}, nil
+		}})
+	r.RegisterBinOp("-", &BinOp{Left: types.Interval, Right: types.Interval, ReturnType: types.Interval,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			a, b := l.(types.DInterval), r.(types.DInterval)
+			return types.DInterval{Months: a.Months - b.Months, Days: a.Days - b.Days, Micros: a.Micros - b.Micros}, nil
+		}})
+	r.RegisterBinOp("*", &BinOp{Left: types.Interval, Right: types.Float, ReturnType: types.Interval,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return scaleInterval(l.(types.DInterval), float64(r.(types.DFloat))), nil
+		}})
+	r.RegisterBinOp("*", &BinOp{Left: types.Float, Right: types.Interval, ReturnType: types.Interval,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return scaleInterval(r.(types.DInterval), float64(l.(types.DFloat))), nil
+		}})
+	r.RegisterBinOp("/", &BinOp{Left: types.Interval, Right: types.Float, ReturnType: types.Interval,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			f := float64(r.(types.DFloat))
+			if f == 0 {
+				return nil, errDivisionByZero
+			}
+			return scaleInterval(l.(types.DInterval), 1/f), nil
+		}})
+
+	// Prefix operators.
+	r.RegisterUnaryOp("-", &UnaryOp{Operand: types.Int, ReturnFn: func(t *types.T) *types.T { return t },
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) {
+			i := d.(types.DInt)
+			if i == math.MinInt64 {
+				return nil, errIntOutOfRange
+			}
+			return -i, nil
+		}})
+	r.RegisterUnaryOp("-", &UnaryOp{Operand: types.Float, ReturnType: types.Float,
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) { return -d.(types.DFloat), nil }})
+	r.RegisterUnaryOp("-", &UnaryOp{Operand: types.Decimal, ReturnType: types.Decimal,
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) {
+			return types.NewDDecimal(d.(*types.DDecimal).Neg()), nil
+		}})
+	r.RegisterUnaryOp("-", &UnaryOp{Operand: types.Interval, ReturnType: types.Interval,
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) { return negInterval(d.(types.DInterval)), nil }})
+	for _, t := range []*types.T{types.Int, types.Float, types.Decimal, types.Interval} {
+		r.RegisterUnaryOp("+", &UnaryOp{Operand: t, ReturnFn: func(t *types.T) *types.T { return t },
+			Fn: func(_ *Context, d types.Datum) (types.Datum, error) { return d, nil }})
+	}
+	r.RegisterUnaryOp("~", &UnaryOp{Operand: types.Int, ReturnFn: func(t *types.T) *types.T { return t },
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) { return ^d.(types.DInt), nil }})
+}
+
+// timeOf returns the instant of a date or timestamp datum.
+func timeOf(d types.Datum) time.Time {
+	switch v := d.(type) {
+	case types.DTimestamp:
+		return v.Time
+	case types.DTimestampTZ:
+		return v.Time
+	case types.DDate:
+		return v.Time()
+	}
+	panic("not a time datum")
+}
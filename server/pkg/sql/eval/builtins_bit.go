@@ -0,0 +1,128 @@
+package eval
+
+import (
+	"math/bits"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// shiftBits returns b shifted left by n bits, or right if n is negative,
+// keeping its length and filling with zeros.
+func shiftBits(b *types.DBitString, n int) *types.DBitString {
+	out := types.NewDBitString(b.Len)
+	for i := 0; i < b.Len; i++ {
+		if j := i + n; j >= 0 && j < b.Len {
+			out.SetBit(i, b.Bit(j))
+		}
+	}
+	return out
+}
+
+// checkBitIndex reports whether n indexes a bit of b.
+func checkBitIndex(b *types.DBitString, n types.DInt) error {
+	if n < 0 || int64(n) >= int64(b.Len) {
+		return pgerror.Newf(pgerror.CodeArraySubscriptError, "bit index %d out of valid range (0..%d)", n, b.Len-1)
+	}
+	return nil
+}
+
+func init() {
+	r := Builtins
+	varbit := types.VarBit
+	sameAsLeft := func(l, _ *types.T) *types.T { return l }
+
+	for op, spec := range map[string]struct {
+		name string
+		fn   func(a, b byte) byte
+	}{
+		"&": {"AND", func(a, b byte) byte { return a & b }},
+		"|": {"OR", func(a, b byte) byte { return a | b }},
+		"#": {"XOR", func(a, b byte) byte { return a ^ b }},
+	} {
+		r.RegisterBinOp(op, &BinOp{Left: varbit, Right: varbit, ReturnFn: sameAsLeft,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				a, b := l.(*types.DBitString), r.(*types.DBitString)
+				if a.Len != b.Len {
+					return nil, pgerror.Newf(pgerror.CodeStringDataLengthMismatch,
+						"cannot %s bit strings of different sizes", spec.name)
+				}
+				out := types.NewDBitString(a.Len)
+				for i := range out.Bytes {
+					out.Bytes[i] = spec.fn(a.Bytes[i], b.Bytes[i])
+				}
+				return out, nil
+			}})
+	}
+	r.RegisterUnaryOp("~", &UnaryOp{Operand: varbit, ReturnFn: func(t *types.T) *types.T { return t },
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) {
+			b := d.(*types.DBitString)
+			out := types.NewDBitString(b.Len)
+			for i := range out.Bytes {
+				out.Bytes[i] = ^b.Bytes[i]
+			}
+			// Keep the bits past the length zero.
+			return out.Resize(b.Len), nil
+		}})
+	for op, sign := range map[string]int{"<<": 1, ">>": -1} {
+		r.RegisterBinOp(op, &BinOp{Left: varbit, Right: types.Int4, ReturnFn: sameAsLeft,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				b, n := l.(*types.DBitString), int64(r.(types.DInt))
+				n = max(min(n, int64(b.Len)), -int64(b.Len))
+				return shiftBits(b, sign*int(n)), nil
+			}})
+	}
+	r.RegisterBinOp("||", &BinOp{Left: varbit, Right: varbit, ReturnType: varbit,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			a, b := l.(*types.DBitString), r.(*types.DBitString)
+			out := a.Resize(a.Len + b.Len)
+			for i := 0; i < b.Len; i++ {
+				out.SetBit(a.Len+i, b.Bit(i))
+			}
+			return out, nil
+		}})
+
+	unary := []*types.T{varbit}
+	bitLength := &Overload{Params: unary, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DInt(args[0].(*types.DBitString).Len), nil
+		}}
+	r.RegisterFunc("length", bitLength)
+	r.RegisterFunc("bit_length", bitLength)
+	r.RegisterFunc("octet_length", &Overload{Params: unary, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DInt(len(args[0].(*types.DBitString).Bytes)), nil
+		}})
+	r.RegisterFunc("bit_count", &Overload{Params: unary, ReturnType: types.Int8,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			n := 0
+			for _, c := range args[0].(*types.DBitString).Bytes {
+				n += bits.OnesCount8(c)
+			}
+			return types.DInt(n), nil
+		}})
+	r.RegisterFunc("get_bit", &Overload{Params: []*types.T{varbit, types.Int4}, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			b, n := args[0].(*types.DBitString), args[1].(types.DInt)
+			if err := checkBitIndex(b, n); err != nil {
+				return nil, err
+			}
+			if b.Bit(int(n)) {
+				return types.DInt(1), nil
+			}
+			return types.DInt(0), nil
+		}})
+	r.RegisterFunc("set_bit", &Overload{Params: []*types.T{varbit, types.Int4, types.Int4}, ReturnFn: sameAsArg,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			b, n, v := args[0].(*types.DBitString), args[1].(types.DInt), args[2].(types.DInt)
+			if err := checkBitIndex(b, n); err != nil {
+				return nil, err
+			}
+			if v != 0 && v != 1 {
+				return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "new bit must be 0 or 1")
+			}
+			out := b.Resize(b.Len)
+			out.SetBit(int(n), v == 1)
+			return out, nil
+		}})
+}
@@ -0,0 +1,54 @@
+package eval
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Definitions reconstructs the DDL of relations from the catalog for
+// pg_get_tabledef and pg_get_indexdef, and finds the sequences of columns
+// for pg_get_serial_sequence.
+type Definitions interface {
+	// TableDef returns the statements that create the table named name in
+	// schema, or in the search path if schema is empty, with its indexes.
+	TableDef(schema, name string) (string, error)
+	// IndexDef returns the CREATE INDEX statement of the index.
+	IndexDef(schema, name string) (string, error)
+	// SerialSequence returns the schema-qualified name of the sequence
+	// owned by column of the table, that of a serial or identity column,
+	// and false if it owns none.
+	SerialSequence(schema, table, column string) (string, bool, error)
+}
+
+// definitionFunc returns the overload of a pg_get_*def function that
+// calls def with the relation its text argument names.
+func definitionFunc(def func(d Definitions, schema, name string) (string, error)) *Overload {
+	return &Overload{Params: []*types.T{types.String}, ReturnType: types.String, Volatility: Stable,
+		Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+			if ctx.Definitions == nil {
+				return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "definitions are not supported here")
+			}
+			schema, name := SequenceName(string(args[0].(types.DString)))
+			s, err := def(ctx.Definitions, schema, name)
+			return types.DString(s), err
+		}}
+}
+
+func init() {
+	r := Builtins
+	r.RegisterFunc("pg_get_tabledef", definitionFunc(Definitions.TableDef))
+	r.RegisterFunc("pg_get_indexdef", definitionFunc(Definitions.IndexDef))
+	// The column name is taken as it is, unlike the table name.
+	r.RegisterFunc("pg_get_serial_sequence", &Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.String, Volatility: Stable,
+		Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+			if ctx.Definitions == nil {
+				return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "definitions are not supported here")
+			}
+			schema, table := SequenceName(string(args[0].(types.DString)))
+			seq, ok, err := ctx.Definitions.SerialSequence(schema, table, string(args[1].(types.DString)))
+			if err != nil || !ok {
+				return types.DNull, err
+			}
+			return types.DString(seq), nil
+		}})
+}
@@ -0,0 +1,128 @@
+package eval
+
+import (
+	"math"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// numberToChar builds the to_char overload for a number type, converting
+// its values to decimals with dec. A nil decimal formats as # signs.
+func numberToChar(t *types.T, dec func(types.Datum) *types.Dec) *Overload {
+	return &Overload{
+		Params:     []*types.T{t, types.String},
+		ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			f, err := parseNumberFormat(string(args[1].(types.DString)))
+			if err != nil {
+				return nil, err
+			}
+			return types.DString(f.format(dec(args[0]))), nil
+		},
+	}
+}
+
+// parseDateArgs reads the text and template arguments of to_date and
+// to_timestamp.
+func parseDateArgs(args []types.Datum) (*dateParse, string, error) {
+	text := string(args[0].(types.DString))
+	p, err := parseDate(text, string(args[1].(types.DString)))
+	return p, text, err
+}
+
+func init() {
+	r := Builtins
+	r.RegisterFunc("to_char",
+		&Overload{Params: []*types.T{types.Timestamp, types.String}, ReturnType: types.String,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.DString(toChar(timeFields(args[0].(types.DTimestamp).Time, false), string(args[1].(types.DString)))), nil
+			}},
+		&Overload{Params: []*types.T{types.TimestampTZ, types.String}, ReturnType: types.String, Volatility: Stable,
+			Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+				t := args[0].(types.DTimestampTZ).In(location(ctx))
+				return types.DString(toChar(timeFields(t, true), string(args[1].(types.DString)))), nil
+			}},
+		&Overload{Params: []*types.T{types.Date, types.String}, ReturnType: types.String,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.DString(toChar(timeFields(args[0].(types.DDate).Time(), false), string(args[1].(types.DString)))), nil
+			}},
+		&Overload{Params: []*types.T{types.Interval, types.String}, ReturnType: types.String,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.DString(toChar(intervalFields(args[0].(types.DInterval)), string(args[1].(types.DString)))), nil
+			}},
+		numberToChar(types.Int, func(d types.Datum) *types.Dec {
+			return types.NewDecFromInt(int64(d.(types.DInt)))
+		}),
+		numberToChar(types.Float, func(d types.Datum) *types.Dec {
+			// Like PostgreSQL, only the digits a float8 holds reliably are
+			// shown.
+			dec, err := types.NewDecFromFloat(float64(d.(types.DFloat)))
+			if err != nil {
+				return nil
+			}
+			if scale := 15 - dec.Digits(); dec.Scale > scale {
+				dec = dec.Round(scale)
+			}
+			return dec
+		}),
+		numberToChar(types.Decimal, func(d types.Datum) *types.Dec {
+			return &d.(*types.DDecimal).Dec
+		}),
+	)
+	r.RegisterFunc("to_number",
+		&Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.Decimal,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				f, err := parseNumberFormat(string(args[1].(types.DString)))
+				if err != nil {
+					return nil, err
+				}
+				if f.roman || f.eeee {
+					return nil, pgerror.New(pgerror.CodeFeatureNotSupported, `"RN" and "EEEE" are not supported for input`)
+				}
+				d, err := f.parseNumber(string(args[0].(types.DString)))
+				if err != nil {
+					return nil, err
+				}
+				return types.NewDDecimal(d), nil
+			}},
+	)
+	r.RegisterFunc("to_date",
+		&Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.Date,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				p, text, err := parseDateArgs(args)
+				if err != nil {
+					return nil, err
+				}
+				t, err := p.time(text, time.UTC)
+				if err != nil {
+					return nil, err
+				}
+				return types.MakeDDate(t), nil
+			}},
+	)
+	r.RegisterFunc("to_timestamp",
+		&Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.TimestampTZ, Volatility: Stable,
+			Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+				p, text, err := parseDateArgs(args)
+				if err != nil {
+					return nil, err
+				}
+				t, err := p.time(text, location(ctx))
+				if err != nil {
+					return nil, err
+				}
+				return types.MakeDTimestampTZ(t.In(location(ctx))), nil
+			}},
+		&Overload{Params: []*types.T{types.Float}, ReturnType: types.TimestampTZ,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				f := float64(args[0].(types.DFloat))
+				if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f) > 1e13 {
+					return nil, pgerror.Newf(pgerror.CodeDatetimeFieldOverflow, "timestamp out of range: \"%g\"", f)
+				}
+				sec, frac := math.Modf(f)
+				return types.MakeDTimestampTZ(time.Unix(int64(sec), int64(math.Round(frac*1e6))*1000).UTC()), nil
+			}},
+	)
+}
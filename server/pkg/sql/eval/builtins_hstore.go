@@ -0,0 +1,162 @@
+package eval
+
+import (
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// hstoreFetch returns the value of key in h, or NULL.
+func hstoreFetch(h *types.DHstore, key string) types.Datum {
+	i := h.Find(key)
+	if i < 0 || h.Pairs[i].Null {
+		return types.DNull
+	}
+	return types.DString(h.Pairs[i].Value)
+}
+
+// hstoreConcat returns the pairs of a and b, taking b's value for keys in
+// both.
+func hstoreConcat(a, b *types.DHstore) *types.DHstore {
+	pairs := make([]types.HstorePair, 0, len(a.Pairs)+len(b.Pairs))
+	pairs = append(append(pairs, b.Pairs...), a.Pairs...)
+	return types.NewDHstore(pairs)
+}
+
+// hstoreContains reports whether every pair of b is in a.
+func hstoreContains(a, b *types.DHstore) bool {
+	for _, p := range b.Pairs {
+		i := a.Find(p.Key)
+		if i < 0 || a.Pairs[i].Null != p.Null || (!p.Null && a.Pairs[i].Value != p.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// hstoreDelete returns h without the pairs for which drop is true.
+func hstoreDelete(h *types.DHstore, drop func(p types.HstorePair) bool) *types.DHstore {
+	out := &types.DHstore{}
+	for _, p := range h.Pairs {
+		if !drop(p) {
+			out.Pairs = append(out.Pairs, p)
+		}
+	}
+	return out
+}
+
+// hstoreToJSONB returns h as a jsonb object whose values are strings, or
+// null for NULL values. The pairs of an hstore are already in the order
+// of jsonb's keys.
+func hstoreToJSONB(h *types.DHstore) types.DJSONB {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, p := range h.Pairs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		types.WriteJSONString(&b, p.Key)
+		b.WriteString(": ")
+		if p.Null {
+			b.WriteString("null")
+			continue
+		}
+		types.WriteJSONString(&b, p.Value)
+	}
+	b.WriteByte('}')
+	return types.DJSONB(b.String())
+}
+
+func init() {
+	r := Builtins
+	hs := types.Hstore
+	hsText := []*types.T{hs, types.String}
+
+	fetch := func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		return hstoreFetch(l.(*types.DHstore), string(r.(types.DString))), nil
+	}
+	exists := func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		return types.MakeDBool(l.(*types.DHstore).Find(string(r.(types.DString))) >= 0), nil
+	}
+	concat := func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		return hstoreConcat(l.(*types.DHstore), r.(*types.DHstore)), nil
+	}
+	deleteKey := func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		key := string(r.(types.DString))
+		return hstoreDelete(l.(*types.DHstore), func(p types.HstorePair) bool { return p.Key == key }), nil
+	}
+	deletePairs := func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		other := r.(*types.DHstore)
+		return hstoreDelete(l.(*types.DHstore), func(p types.HstorePair) bool {
+			return hstoreContains(other, &types.DHstore{Pairs: []types.HstorePair{p}})
+		}), nil
+	}
+	binary := func(fn func(*Context, types.Datum, types.Datum) (types.Datum, error)) func(*Context, []types.Datum) (types.Datum, error) {
+		return func(ctx *Context, args []types.Datum) (types.Datum, error) { return fn(ctx, args[0], args[1]) }
+	}
+
+	r.RegisterBinOp("->", &BinOp{Left: hs, Right: types.String, ReturnType: types.String, Fn: fetch})
+	r.RegisterBinOp("?", &BinOp{Left: hs, Right: types.String, ReturnType: types.Bool, Fn: exists})
+	r.RegisterBinOp("||", &BinOp{Left: hs, Right: hs, ReturnType: hs, Fn: concat})
+	r.RegisterBinOp("-", &BinOp{Left: hs, Right: types.String, ReturnType: hs, Fn: deleteKey})
+	r.RegisterBinOp("-", &BinOp{Left: hs, Right: hs, ReturnType: hs, Fn: deletePairs})
+	r.RegisterBinOp("@>", &BinOp{Left: hs, Right: hs, ReturnType: types.Bool,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return types.MakeDBool(hstoreContains(l.(*types.DHstore), r.(*types.DHstore))), nil
+		}})
+	r.RegisterBinOp("<@", &BinOp{Left: hs, Right: hs, ReturnType: types.Bool,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return types.MakeDBool(hstoreContains(r.(*types.DHstore), l.(*types.DHstore))), nil
+		}})
+
+	r.RegisterFunc("fetchval", &Overload{Params: hsText, ReturnType: types.String, Fn: binary(fetch)})
+	r.RegisterFunc("exist", &Overload{Params: hsText, ReturnType: types.Bool, Fn: binary(exists)})
+	r.RegisterFunc("defined", &Overload{Params: hsText, ReturnType: types.Bool,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.MakeDBool(hstoreFetch(args[0].(*types.DHstore), string(args[1].(types.DString))) != types.DNull), nil
+		}})
+	r.RegisterFunc("delete",
+		&Overload{Params: hsText, ReturnType: hs, Fn: binary(deleteKey)},
+		&Overload{Params: []*types.T{hs, hs}, ReturnType: hs, Fn: binary(deletePairs)})
+	r.RegisterFunc("hs_concat", &Overload{Params: []*types.T{hs, hs}, ReturnType: hs, Fn: binary(concat)})
+
+	// hstore(key, value) makes a single pair; a NULL key gives NULL and a
+	// NULL value a NULL value.
+	r.RegisterFunc("hstore", &Overload{Params: []*types.T{types.String, types.String}, ReturnType: hs, NullCall: true,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			if args[0] == types.DNull {
+				return types.DNull, nil
+			}
+			p := types.HstorePair{Key: string(args[0].(types.DString)), Null: args[1] == types.DNull}
+			if !p.Null {
+				p.Value = string(args[1].(types.DString))
+			}
+			return types.NewDHstore([]types.HstorePair{p}), nil
+		}})
+
+	arrayOf := func(fn func(p types.HstorePair) []types.Datum) func(*Context, []types.Datum) (types.Datum, error) {
+		return func(_ *Context, args []types.Datum) (types.Datum, error) {
+			var elems []types.Datum
+			for _, p := range args[0].(*types.DHstore).Pairs {
+				elems = append(elems, fn(p)...)
+			}
+			return types.NewDArray(types.StringArray, elems), nil
+		}
+	}
+	value := func(p types.HstorePair) types.Datum {
+		if p.Null {
+			return types.DNull
+		}
+		return types.DString(p.Value)
+	}
+	r.RegisterFunc("akeys", &Overload{Params: []*types.T{hs}, ReturnType: types.StringArray,
+		Fn: arrayOf(func(p types.HstorePair) []types.Datum { return []types.Datum{types.DString(p.Key)} })})
+	r.RegisterFunc("avals", &Overload{Params: []*types.T{hs}, ReturnType: types.StringArray,
+		Fn: arrayOf(func(p types.HstorePair) []types.Datum { return []types.Datum{value(p)} })})
+	r.RegisterFunc("hstore_to_jsonb", &Overload{Params: []*types.T{hs}, ReturnType: types.JSONB,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return hstoreToJSONB(args[0].(*types.DHstore)), nil
+		}})
+	r.RegisterFunc("hstore_to_array", &Overload{Params: []*types.T{hs}, ReturnType: types.StringArray,
+		Fn: arrayOf(func(p types.HstorePair) []types.Datum { return []types.Datum{types.DString(p.Key), value(p)} })})
+}
@@ -0,0 +1,168 @@
+package eval
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// floatFunc wraps a float64 function as a single-argument float8 overload.
+func floatFunc(fn func(float64) float64) *Overload {
+	return &Overload{
+		Params:     []*types.T{types.Float},
+		ReturnType: types.Float,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DFloat(fn(float64(args[0].(types.DFloat)))), nil
+		},
+	}
+}
+
+// decFunc wraps a decimal function as a single-argument numeric overload.
+func decFunc(fn func(*types.Dec) *types.Dec) *Overload {
+	return &Overload{
+		Params:     []*types.T{types.Decimal},
+		ReturnType: types.Decimal,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.NewDDecimal(fn(&args[0].(*types.DDecimal).Dec)), nil
+		},
+	}
+}
+
+func sameAsArg(args []*types.T) *types.T { return args[0] }
+
+func init() {
+	r := Builtins
+
+	r.RegisterFunc("abs",
+		&Overload{Params: []*types.T{types.Int}, ReturnFn: sameAsArg,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				i := args[0].(types.DInt)
+				if i == math.MinInt64 {
+					return nil, errIntOutOfRange
+				}
+				if i < 0 {
+					i = -i
+				}
+				return i, nil
+			}},
+		floatFunc(math.Abs),
+		decFunc((*types.Dec).Abs),
+	)
+	r.RegisterFunc("ceil", floatFunc(math.Ceil), decFunc(func(d *types.Dec) *types.Dec {
+		t := d.Trunc(0)
+		if d.Sign() > 0 && t.Cmp(d) != 0 {
+			t = t.Add(types.NewDecFromInt(1))
+		}
+		return t
+	}))
+	r.RegisterFunc("ceiling", r.Overloads("ceil")...)
+	r.RegisterFunc("floor", floatFunc(math.Floor), decFunc(func(d *types.Dec) *types.Dec {
+		t := d.Trunc(0)
+		if d.Sign() < 0 && t.Cmp(d) != 0 {
+			t = t.Sub(types.NewDecFromInt(1))
+		}
+		return t
+	}))
+	r.RegisterFunc("round",
+		floatFunc(math.RoundToEven),
+		decFunc(func(d *types.Dec) *types.Dec { return d.Round(0) }),
+		&Overload{Params: []*types.T{types.Decimal, types.Int4}, ReturnType: types.Decimal,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.NewDDecimal(args[0].(*types.DDecimal).Round(int32(args[1].(types.DInt)))), nil
+			}},
+	)
+	r.RegisterFunc("trunc",
+		floatFunc(math.Trunc),
+		decFunc(func(d *types.Dec) *types.Dec { return d.Trunc(0) }),
+		&Overload{Params: []*types.T{types.Decimal, types.Int4}, ReturnType: types.Decimal,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.NewDDecimal(args[0].(*types.DDecimal).Trunc(int32(args[1].(types.DInt)))), nil
+			}},
+	)
+	r.RegisterFunc("sign",
+		floatFunc(func(f float64) float64 {
+			switch {
+			case f > 0:
+				return 1
+			case f < 0:
+				return -1
+			}
+			return 0
+		}),
+		decFunc(func(d *types.Dec) *types.Dec { return types.NewDecFromInt(int64(d.Sign())) }),
+	)
+	r.RegisterFunc("sqrt", &Overload{Params: []*types.T{types.Float}, ReturnType: types.Float,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			f := float64(args[0].(types.DFloat))
+			if f < 0 {
+				return nil, pgerror.New(pgerror.CodeInvalidArgumentForPower, "cannot take square root of a negative number")
+			}
+			return types.DFloat(math.Sqrt(f)), nil
+		}})
+	r.RegisterFunc("cbrt", floatFunc(math.Cbrt))
+	r.RegisterFunc("exp", floatFunc(math.Exp))
+	r.RegisterFunc("ln", &Overload{Params: []*types.T{types.Float}, ReturnType: types.Float,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			f := float64(args[0].(types.DFloat))
+			if f <= 0 {
+				return nil, pgerror.New(pgerror.CodeInvalidArgumentForLog, "cannot take logarithm of a non-positive number")
+			}
+			return types.DFloat(math.Log(f)), nil
+		}})
+	r.RegisterFunc("log", &Overload{Params: []*types.T{types.Float}, ReturnType: types.Float,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			f := float64(args[0].(types.DFloat))
+			if f <= 0 {
+				return nil, pgerror.New(pgerror.CodeInvalidArgumentForLog, "cannot take logarithm of a non-positive number")
+			}
+			return types.DFloat(math.Log10(f)), nil
+		}})
+	r.RegisterFunc("power", &Overload{Params: []*types.T{types.Float, types.Float}, ReturnType: types.Float,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DFloat(math.Pow(float64(args[0].(types.DFloat)), float64(args[1].(types.DFloat)))), nil
+		}})
+	r.RegisterFunc("pow", r.Overloads("power")...)
+	r.RegisterFunc("mod",
+		&Overload{Params: []*types.T{types.Int, types.Int}, ReturnFn: func(args []*types.T) *types.T { return widerInt(args[0], args[1]) },
+			Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+				return intOp(func(a, b int64) (int64, error) {
+					if b == 0 {
+						return 0, errDivisionByZero
+					}
+					if b == -1 {
+						return 0, nil
+					}
+					return a % b, nil
+				})(ctx, args[0], args[1])
+			}},
+		&Overload{Params: []*types.T{types.Decimal, types.Decimal}, ReturnType: types.Decimal,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				b := &args[1].(*types.DDecimal).Dec
+				if b.IsZero() {
+					return nil, errDivisionByZero
+				}
+				return types.NewDDecimal(args[0].(*types.DDecimal).Rem(b)), nil
+			}},
+	)
+	r.RegisterFunc("degrees", floatFunc(func(f float64) float64 { return f * 180 / math.Pi }))
+	r.RegisterFunc("radians", floatFunc(func(f float64) float64 { return f * math.Pi / 180 }))
+	r.RegisterFunc("pi", &Overload{ReturnType: types.Float,
+		Fn: func(*Context, []types.Datum) (types.Datum, error) { return types.DFloat(math.Pi), nil }})
+	r.RegisterFunc("random", &Overload{ReturnType: types.Float, Volatility: Volatile,
+		Fn: func(*Context, []types.Datum) (types.Datum, error) { return types.DFloat(rand.Float64()), nil }})
+
+	// Date/time functions.
+	now := &Overload{ReturnType: types.TimestampTZ, Volatility: Stable,
+		Fn: func(ctx *Context, _ []types.Datum) (types.Datum, error) {
+			return types.MakeDTimestampTZ(ctx.TxnTimestamp.In(location(ctx))), nil
+		}}
+	r.RegisterFunc("now", now)
+	r.RegisterFunc("current_timestamp", now)
+	r.RegisterFunc("transaction_timestamp", now)
+	r.RegisterFunc("current_date", &Overload{ReturnType: types.Date, Volatility: Stable,
+		Fn: func(ctx *Context, _ []types.Datum) (types.Datum, error) {
+			return types.MakeDDate(ctx.TxnTimestamp.In(location(ctx))), nil
+		}})
+}
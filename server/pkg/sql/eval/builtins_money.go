@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"math"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// moneyArith applies the checked integer operator op to amounts in cents,
+// reporting overflow as money's.
+func moneyArith(op string, a, b int64) (types.Datum, error) {
+	v, err := intArith[op](a, b)
+	if err == errIntOutOfRange {
+		return nil, types.MoneyRangeError()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return types.DMoney(v), nil
+}
+
+// moneyFromFloat rounds f cents to a money amount.
+func moneyFromFloat(f float64) (types.Datum, error) {
+	f = math.RoundToEven(f)
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return nil, types.MoneyRangeError()
+	}
+	return types.DMoney(f), nil
+}
+
+// moneySumAgg implements sum over money.
+type moneySumAgg struct {
+	sum  types.DMoney
+	seen bool
+}
+
+func (a *moneySumAgg) Add(_ *Context, args []types.Datum) error {
+	sum, err := moneyArith("+", int64(a.sum), int64(args[0].(types.DMoney)))
+	if err != nil {
+		return err
+	}
+	a.sum, a.seen = sum.(types.DMoney), true
+	return nil
+}
+
+func (a *moneySumAgg) Merge(ctx *Context, other AggregateFunc) error {
+	if o := other.(*moneySumAgg); o.seen {
+		return a.Add(ctx, []types.Datum{o.sum})
+	}
+	return nil
+}
+
+func (a *moneySumAgg) Result(*Context) (types.Datum, error) {
+	if !a.seen {
+		return types.DNull, nil
+	}
+	return a.sum, nil
+}
+
+func init() {
+	r := Builtins
+	money := types.Money
+
+	for _, op := range []string{"+", "-"} {
+		r.RegisterBinOp(op, &BinOp{Left: money, Right: money, ReturnType: money,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return moneyArith(op, int64(l.(types.DMoney)), int64(r.(types.DMoney)))
+			}})
+	}
+	for _, op := range []string{"*", "/"} {
+		r.RegisterBinOp(op, &BinOp{Left: money, Right: types.Int, ReturnType: money,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return moneyArith(op, int64(l.(types.DMoney)), int64(r.(types.DInt)))
+			}})
+	}
+	r.RegisterBinOp("*", &BinOp{Left: types.Int, Right: money, ReturnType: money,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return moneyArith("*", int64(l.(types.DInt)), int64(r.(types.DMoney)))
+		}})
+	r.RegisterBinOp("*", &BinOp{Left: money, Right: types.Float, ReturnType: money,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return moneyFromFloat(float64(l.(types.DMoney)) * float64(r.(types.DFloat)))
+		}})
+	r.RegisterBinOp("*", &BinOp{Left: types.Float, Right: money, ReturnType: money,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return moneyFromFloat(float64(l.(types.DFloat)) * float64(r.(types.DMoney)))
+		}})
+	r.RegisterBinOp("/", &BinOp{Left: money, Right: types.Float, ReturnType: money,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			if r.(types.DFloat) == 0 {
+				return nil, errDivisionByZero
+			}
+			return moneyFromFloat(float64(l.(types.DMoney)) / float64(r.(types.DFloat)))
+		}})
+	// Dividing amounts gives their ratio.
+	r.RegisterBinOp("/", &BinOp{Left: money, Right: money, ReturnType: types.Float,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			if r.(types.DMoney) == 0 {
+				return nil, errDivisionByZero
+			}
+			return types.DFloat(float64(l.(types.DMoney)) / float64(r.(types.DMoney))), nil
+		}})
+	r.RegisterUnaryOp("-", &UnaryOp{Operand: money, ReturnType: money,
+		Fn: func(_ *Context, d types.Datum) (types.Datum, error) {
+			return moneyArith("-", 0, int64(d.(types.DMoney)))
+		}})
+
+	r.RegisterAggregate("sum", &Aggregate{Params: []*types.T{money}, ReturnType: money,
+		New: func([]*types.T) AggregateFunc { return &moneySumAgg{} }})
+}
@@ -0,0 +1,165 @@
+package eval
+
+import (
+	"math/big"
+	"net/netip"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// inetAdd returns the address n past the address of d, keeping its prefix
+// length.
+func inetAdd(d *types.DInet, n *big.Int) (types.Datum, error) {
+	v := new(big.Int).SetBytes(d.Addr().AsSlice())
+	v.Add(v, n)
+	size := d.MaxBits() / 8
+	if v.Sign() < 0 || v.BitLen() > size*8 {
+		return nil, pgerror.New(pgerror.CodeNumericValueOutOfRange, "result is out of range")
+	}
+	addr, _ := netip.AddrFromSlice(v.FillBytes(make([]byte, size)))
+	return types.NewDInet(addr, d.Bits(), false), nil
+}
+
+// inetAbbrev formats a cidr with the trailing zero octets of an IPv4
+// network dropped, as PostgreSQL's abbrev does.
+func inetAbbrev(d *types.DInet) string {
+	if !d.Cidr || !d.Addr().Is4() {
+		return d.String()
+	}
+	b := d.Addr().As4()
+	n := max((d.Bits()+7)/8, 1)
+	octets := make([]string, n)
+	for i := range octets {
+		octets[i] = netip.AddrFrom4([4]byte{0, 0, 0, b[i]}).String()[len("0.0.0."):]
+	}
+	return strings.Join(octets, ".") + d.String()[strings.IndexByte(d.String(), '/'):]
+}
+
+// inetMerge returns the smallest network containing both a and b.
+func inetMerge(a, b *types.DInet) (types.Datum, error) {
+	if a.Addr().Is4() != b.Addr().Is4() {
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "cannot merge addresses from different families")
+	}
+	bits := min(a.Bits(), b.Bits())
+	for p := 0; p < bits; p++ {
+		if !netip.PrefixFrom(a.Addr(), p+1).Masked().Contains(b.Addr()) {
+			bits = p
+		}
+	}
+	return types.NewDInet(a.Addr(), bits, true), nil
+}
+
+func init() {
+	r := Builtins
+	inet, cidr := types.Inet, types.Cidr
+	unary := []*types.T{inet}
+	arg := func(args []types.Datum) *types.DInet { return args[0].(*types.DInet) }
+
+	for op, fn := range map[string]func(a, b *types.DInet) bool{
+		"<<":  func(a, b *types.DInet) bool { return b.Contains(a, true) },
+		"<<=": func(a, b *types.DInet) bool { return b.Contains(a, false) },
+		">>":  func(a, b *types.DInet) bool { return a.Contains(b, true) },
+		">>=": func(a, b *types.DInet) bool { return a.Contains(b, false) },
+		"&&":  (*types.DInet).Overlaps,
+	} {
+		r.RegisterBinOp(op, &BinOp{Left: inet, Right: inet, ReturnType: types.Bool,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+				return types.MakeDBool(fn(l.(*types.DInet), r.(*types.DInet))), nil
+			}})
+	}
+	r.RegisterBinOp("+", &BinOp{Left: inet, Right: types.Int, ReturnType: inet,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return inetAdd(l.(*types.DInet), big.NewInt(int64(r.(types.DInt))))
+		}})
+	r.RegisterBinOp("+", &BinOp{Left: types.Int, Right: inet, ReturnType: inet,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			return inetAdd(r.(*types.DInet), big.NewInt(int64(l.(types.DInt))))
+		}})
+	r.RegisterBinOp("-", &BinOp{Left: inet, Right: types.Int, ReturnType: inet,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			n := big.NewInt(int64(r.(types.DInt)))
+			return inetAdd(l.(*types.DInet), n.Neg(n))
+		}})
+	r.RegisterBinOp("-", &BinOp{Left: inet, Right: inet, ReturnType: types.Int,
+		Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) {
+			a, b := l.(*types.DInet), r.(*types.DInet)
+			if a.Addr().Is4() != b.Addr().Is4() {
+				return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "cannot subtract inet values of different sizes")
+			}
+			v := new(big.Int).SetBytes(a.Addr().AsSlice())
+			v.Sub(v, new(big.Int).SetBytes(b.Addr().AsSlice()))
+			if !v.IsInt64() {
+				return nil, pgerror.New(pgerror.CodeNumericValueOutOfRange, "result is out of range")
+			}
+			return types.DInt(v.Int64()), nil
+		}})
+
+	r.RegisterFunc("host", &Overload{Params: unary, ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DString(arg(args).Addr().String()), nil
+		}})
+	r.RegisterFunc("masklen", &Overload{Params: unary, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DInt(arg(args).Bits()), nil
+		}})
+	r.RegisterFunc("family", &Overload{Params: unary, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			if arg(args).Addr().Is4() {
+				return types.DInt(4), nil
+			}
+			return types.DInt(6), nil
+		}})
+	r.RegisterFunc("network", &Overload{Params: unary, ReturnType: cidr,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			d := arg(args)
+			return types.NewDInet(d.Addr(), d.Bits(), true), nil
+		}})
+	r.RegisterFunc("broadcast", &Overload{Params: unary, ReturnType: inet,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			d := arg(args)
+			return types.NewDInet(d.Broadcast(), d.Bits(), false), nil
+		}})
+	for name, inverse := range map[string]bool{"netmask": false, "hostmask": true} {
+		r.RegisterFunc(name, &Overload{Params: unary, ReturnType: inet,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				d := arg(args)
+				return types.NewDInet(d.Mask(d.Bits(), inverse), d.MaxBits(), false), nil
+			}})
+	}
+	// set_masklen keeps the type of its argument: a cidr's bits past the
+	// new prefix are cleared.
+	r.RegisterFunc("set_masklen", &Overload{Params: []*types.T{inet, types.Int4}, ReturnFn: sameAsArg,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			d, bits := arg(args), int(args[1].(types.DInt))
+			if bits == -1 {
+				bits = d.MaxBits()
+			}
+			if bits < 0 || bits > d.MaxBits() {
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "invalid mask length: %d", args[1].(types.DInt))
+			}
+			return types.NewDInet(d.Addr(), bits, d.Cidr), nil
+		}})
+	r.RegisterFunc("abbrev", &Overload{Params: unary, ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DString(inetAbbrev(arg(args))), nil
+		}})
+	r.RegisterFunc("inet_same_family", &Overload{Params: []*types.T{inet, inet}, ReturnType: types.Bool,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.MakeDBool(arg(args).Addr().Is4() == args[1].(*types.DInet).Addr().Is4()), nil
+		}})
+	r.RegisterFunc("inet_merge", &Overload{Params: []*types.T{inet, inet}, ReturnType: cidr,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return inetMerge(arg(args), args[1].(*types.DInet))
+		}})
+
+	// trunc sets the last three bytes of a MAC address to zero, leaving
+	// the manufacturer's prefix.
+	r.RegisterFunc("trunc", &Overload{Params: []*types.T{types.Macaddr}, ReturnType: types.Macaddr,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			mac := args[0].(types.DMacaddr)
+			mac[3], mac[4], mac[5] = 0, 0, 0
+			return mac, nil
+		}})
+}
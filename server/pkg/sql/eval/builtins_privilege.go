@@ -0,0 +1,53 @@
+package eval
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Privileges checks the privileges of roles for has_table_privilege and
+// has_schema_privilege.
+type Privileges interface {
+	// HasTablePrivilege reports whether the role user, or the current role
+	// if user is empty, has any of the privileges privilege lists on
+	// table, which is in schema, or in the search path if schema is
+	// empty.
+	HasTablePrivilege(user, schema, table, privilege string) (bool, error)
+	// HasSchemaPrivilege is HasTablePrivilege for a schema.
+	HasSchemaPrivilege(user, schema, privilege string) (bool, error)
+	// CheckSuperuser returns an error unless the current role is a
+	// superuser, as what, such as "take a backup", needs.
+	CheckSuperuser(what string) error
+}
+
+// privilegeFunc returns the overloads of a has_*_privilege function, with
+// and without the user argument, that call has with the text naming the
+// object.
+func privilegeFunc(has func(p Privileges, user, object, privilege string) (bool, error)) []*Overload {
+	fn := func(ctx *Context, args []types.Datum) (types.Datum, error) {
+		if ctx.Privileges == nil {
+			return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "privileges are not supported here")
+		}
+		var user string
+		if len(args) == 3 {
+			user, args = Identifier(string(args[0].(types.DString))), args[1:]
+		}
+		ok, err := has(ctx.Privileges, user, string(args[0].(types.DString)), string(args[1].(types.DString)))
+		return types.DBool(ok), err
+	}
+	return []*Overload{
+		{Params: []*types.T{types.String, types.String}, ReturnType: types.Bool, Volatility: Stable, Fn: fn},
+		{Params: []*types.T{types.String, types.String, types.String}, ReturnType: types.Bool, Volatility: Stable, Fn: fn},
+	}
+}
+
+func init() {
+	r := Builtins
+	r.RegisterFunc("has_table_privilege", privilegeFunc(func(p Privileges, user, object, privilege string) (bool, error) {
+		schema, table := SequenceName(object)
+		return p.HasTablePrivilege(user, schema, table, privilege)
+	})...)
+	r.RegisterFunc("has_schema_privilege", privilegeFunc(func(p Privileges, user, object, privilege string) (bool, error) {
+		return p.HasSchemaPrivilege(user, Identifier(object), privilege)
+	})...)
+}
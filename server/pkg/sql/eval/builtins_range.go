@@ -0,0 +1,446 @@
+package eval
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// rangeSubtype returns the subtype of the range or multirange argument.
+func rangeSubtype(args []*types.T) *types.T {
+	if sub := args[0].RangeContents; sub != nil {
+		return sub
+	}
+	return types.Unknown
+}
+
+func rangeOfArg(args []*types.T) *types.T { return types.RangeOf(args[0].RangeContents) }
+
+func multirangeOfArg(args []*types.T) *types.T {
+	if args[0].Family == types.MultirangeFamily {
+		return args[0]
+	}
+	return types.MultirangeOf(args[0])
+}
+
+// checkRangeOperands reports an error when the operands of op are range
+// or multirange values over different subtypes. Overloads match range
+// types by family, so the subtypes are only known here.
+func checkRangeOperands(op string, l, r types.Datum) error {
+	lt, rt := l.ResolvedType(), r.ResolvedType()
+	if lt.RangeContents.Oid != rt.RangeContents.Oid {
+		return pgerror.Newf(pgerror.CodeUndefinedFunction, "operator does not exist: %s %s %s", lt, op, rt)
+	}
+	return nil
+}
+
+// rangeOp adapts a function of two ranges to a BinOp.
+func rangeOp(op string, fn func(l, r *types.DRange) (types.Datum, error)) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		if err := checkRangeOperands(op, l, r); err != nil {
+			return nil, err
+		}
+		return fn(l.(*types.DRange), r.(*types.DRange))
+	}
+}
+
+// multirangeOp adapts a function of two multiranges to a BinOp. A range
+// operand is taken as the multirange holding it.
+func multirangeOp(op string, fn func(l, r *types.DMultirange) (types.Datum, error)) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		if err := checkRangeOperands(op, l, r); err != nil {
+			return nil, err
+		}
+		return fn(asMultirange(l), asMultirange(r))
+	}
+}
+
+func asMultirange(d types.Datum) *types.DMultirange {
+	if r, ok := d.(*types.DRange); ok {
+		m := &types.DMultirange{Typ: types.MultirangeOf(r.Typ)}
+		if !r.Empty {
+			m.Ranges = []*types.DRange{r}
+		}
+		return m
+	}
+	return d.(*types.DMultirange)
+}
+
+// containsElem reports whether r contains the element v, which is first
+// converted to the range's subtype.
+func containsElem(ctx *Context, r *types.DRange, v types.Datum) (bool, error) {
+	v, err := PerformCast(ctx, v, r.Typ.RangeContents)
+	if err != nil {
+		return false, err
+	}
+	return r.ContainsElem(v), nil
+}
+
+func multirangeContainsElem(ctx *Context, m *types.DMultirange, v types.Datum) (bool, error) {
+	for _, r := range m.Ranges {
+		if ok, err := containsElem(ctx, r, v); ok || err != nil {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// multirangeContains reports whether every range of o lies within a range
+// of m. The ranges of a multirange are disjoint and not adjacent, so each
+// range of o must fit within a single one.
+func multirangeContains(m, o *types.DMultirange) bool {
+	for _, r := range o.Ranges {
+		found := false
+		for _, s := range m.Ranges {
+			if s.Contains(r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func multirangeOverlaps(m, o *types.DMultirange) bool {
+	for _, r := range m.Ranges {
+		for _, s := range o.Ranges {
+			if r.Overlaps(s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func multirangeIntersect(m, o *types.DMultirange) (*types.DMultirange, error) {
+	var out []*types.DRange
+	for _, r := range m.Ranges {
+		for _, s := range o.Ranges {
+			x, err := r.Intersect(s)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, x)
+		}
+	}
+	return types.MakeDMultirange(m.Typ, out)
+}
+
+func multirangeMinus(m, o *types.DMultirange) (*types.DMultirange, error) {
+	var out []*types.DRange
+	for _, r := range m.Ranges {
+		pieces := []*types.DRange{r}
+		for _, s := range o.Ranges {
+			var next []*types.DRange
+			for _, p := range pieces {
+				d, err := p.Minus(s)
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, d...)
+			}
+			pieces = next
+		}
+		out = append(out, pieces...)
+	}
+	return types.MakeDMultirange(m.Typ, out)
+}
+
+// parseBoundFlags parses the bounds argument of a range constructor, such
+// as "[)", into whether the lower and upper bounds are inclusive.
+func parseBoundFlags(s string) (lowerInc, upperInc bool, err error) {
+	if len(s) == 2 && (s[0] == '[' || s[0] == '(') && (s[1] == ']' || s[1] == ')') {
+		return s[0] == '[', s[1] == ']', nil
+	}
+	return false, false, pgerror.New(pgerror.CodeSyntaxError, "invalid range bound flags").
+		WithHint(`Valid values are "[]", "[)", "(]", and "()".`)
+}
+
+// rangeAgg implements range_agg, the union of its inputs as a multirange.
+type rangeAgg struct {
+	typ    *types.T
+	ranges []*types.DRange
+	seen   bool
+}
+
+func (a *rangeAgg) Add(_ *Context, args []types.Datum) error {
+	a.ranges = append(a.ranges, asMultirange(args[0]).Ranges...)
+	a.seen = true
+	return nil
+}
+
+func (a *rangeAgg) Merge(_ *Context, other AggregateFunc) error {
+	o := other.(*rangeAgg)
+	a.ranges = append(a.ranges, o.ranges...)
+	a.seen = a.seen || o.seen
+	return nil
+}
+
+func (a *rangeAgg) Result(*Context) (types.Datum, error) {
+	if !a.seen {
+		return types.DNull, nil
+	}
+	return types.MakeDMultirange(a.typ, a.ranges)
+}
+
+// rangeIntersectAgg implements range_intersect_agg over ranges or
+// multiranges.
+type rangeIntersectAgg struct {
+	result types.Datum
+}
+
+func (a *rangeIntersectAgg) Add(_ *Context, args []types.Datum) error {
+	if a.result == nil {
+		a.result = args[0]
+		return nil
+	}
+	var err error
+	switch v := args[0].(type) {
+	case *types.DRange:
+		a.result, err = a.result.(*types.DRange).Intersect(v)
+	case *types.DMultirange:
+		a.result, err = multirangeIntersect(a.result.(*types.DMultirange), v)
+	}
+	return err
+}
+
+func (a *rangeIntersectAgg) Merge(ctx *Context, other AggregateFunc) error {
+	if o := other.(*rangeIntersectAgg); o.result != nil {
+		return a.Add(ctx, []types.Datum{o.result})
+	}
+	return nil
+}
+
+func (a *rangeIntersectAgg) Result(*Context) (types.Datum, error) {
+	if a.result == nil {
+		return types.DNull, nil
+	}
+	return a.result, nil
+}
+
+func init() {
+	r := Builtins
+	rng, mr := types.AnyRange, types.AnyMultirange
+
+	for _, t := range types.RangeTypes {
+		sub := t.RangeContents
+		m := types.MultirangeOf(t)
+		// Range constructors take NULL bounds as infinite, so they are
+		// not strict.
+		construct := func(ctx *Context, args []types.Datum, lowerInc, upperInc bool) (types.Datum, error) {
+			bounds := [2]types.Datum{}
+			for i := range bounds {
+				if args[i] == types.DNull {
+					continue
+				}
+				d, err := PerformCast(ctx, args[i], sub)
+				if err != nil {
+					return nil, err
+				}
+				bounds[i] = d
+			}
+			return types.MakeDRange(t, bounds[0], bounds[1], lowerInc, upperInc)
+		}
+		r.RegisterFunc(t.Name,
+			&Overload{Params: []*types.T{sub, sub}, ReturnType: t, NullCall: true,
+				Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+					return construct(ctx, args, true, false)
+				}},
+			&Overload{Params: []*types.T{sub, sub, types.String}, ReturnType: t, NullCall: true,
+				Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+					if args[2] == types.DNull {
+						return nil, pgerror.New(pgerror.CodeNullValueNotAllowed,
+							"range constructor flags argument must not be null")
+					}
+					lowerInc, upperInc, err := parseBoundFlags(string(args[2].(types.DString)))
+					if err != nil {
+						return nil, err
+					}
+					return construct(ctx, args, lowerInc, upperInc)
+				}},
+		)
+		r.RegisterFunc(m.Name,
+			&Overload{Variadic: t, ReturnType: m, NullCall: true,
+				Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+					ranges := make([]*types.DRange, len(args))
+					for i, a := range args {
+						if a == types.DNull {
+							return nil, pgerror.New(pgerror.CodeNullValueNotAllowed,
+								"multirange values cannot contain null members")
+						}
+						ranges[i] = a.(*types.DRange)
+					}
+					return types.MakeDMultirange(m, ranges)
+				}},
+		)
+	}
+	r.RegisterFunc("multirange", &Overload{Params: []*types.T{rng}, ReturnFn: multirangeOfArg,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return asMultirange(args[0]), nil
+		}})
+
+	// Accessors of ranges, and of multiranges through the range that spans
+	// them.
+	span := func(d types.Datum) *types.DRange {
+		if m, ok := d.(*types.DMultirange); ok {
+			return m.Span()
+		}
+		return d.(*types.DRange)
+	}
+	bound := func(lower bool) func(*Context, []types.Datum) (types.Datum, error) {
+		return func(_ *Context, args []types.Datum) (types.Datum, error) {
+			s := span(args[0])
+			v := s.Upper
+			if lower {
+				v = s.Lower
+			}
+			if s.Empty || v == nil {
+				return types.DNull, nil
+			}
+			return v, nil
+		}
+	}
+	flag := func(fn func(s *types.DRange) bool) func(*Context, []types.Datum) (types.Datum, error) {
+		return func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.MakeDBool(fn(span(args[0]))), nil
+		}
+	}
+	for _, t := range []*types.T{rng, mr} {
+		params := []*types.T{t}
+		r.RegisterFunc("lower", &Overload{Params: params, ReturnFn: rangeSubtype, Fn: bound(true)})
+		r.RegisterFunc("upper", &Overload{Params: params, ReturnFn: rangeSubtype, Fn: bound(false)})
+		r.RegisterFunc("isempty", &Overload{Params: params, ReturnType: types.Bool,
+			Fn: flag(func(s *types.DRange) bool { return s.Empty })})
+		r.RegisterFunc("lower_inc", &Overload{Params: params, ReturnType: types.Bool,
+			Fn: flag(func(s *types.DRange) bool { return !s.Empty && s.LowerInc })})
+		r.RegisterFunc("upper_inc", &Overload{Params: params, ReturnType: types.Bool,
+			Fn: flag(func(s *types.DRange) bool { return !s.Empty && s.UpperInc })})
+		r.RegisterFunc("lower_inf", &Overload{Params: params, ReturnType: types.Bool,
+			Fn: flag(func(s *types.DRange) bool { return !s.Empty && s.Lower == nil })})
+		r.RegisterFunc("upper_inf", &Overload{Params: params, ReturnType: types.Bool,
+			Fn: flag(func(s *types.DRange) bool { return !s.Empty && s.Upper == nil })})
+	}
+	r.RegisterFunc("range_merge",
+		&Overload{Params: []*types.T{rng, rng}, ReturnFn: sameAsArg,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				if err := checkRangeOperands("+", args[0], args[1]); err != nil {
+					return nil, err
+				}
+				return args[0].(*types.DRange).Merge(args[1].(*types.DRange))
+			}},
+		&Overload{Params: []*types.T{mr}, ReturnFn: rangeOfArg,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return args[0].(*types.DMultirange).Span(), nil
+			}},
+	)
+
+	boolOp := func(fn func(l, r *types.DRange) bool) func(l, r *types.DRange) (types.Datum, error) {
+		return func(l, r *types.DRange) (types.Datum, error) { return types.MakeDBool(fn(l, r)), nil }
+	}
+	spanOp := func(fn func(l, r *types.DRange) bool) func(l, r *types.DMultirange) (types.Datum, error) {
+		return func(l, r *types.DMultirange) (types.Datum, error) {
+			return types.MakeDBool(fn(l.Span(), r.Span())), nil
+		}
+	}
+	// Operators comparing positions, on ranges and, through the ranges
+	// that span them, on multiranges and mixed operands.
+	for _, pos := range []struct {
+		op string
+		fn func(l, r *types.DRange) bool
+	}{
+		{"<<", (*types.DRange).Before},
+		{">>", func(l, r *types.DRange) bool { return r.Before(l) }},
+		{"&<", (*types.DRange).OverLeft},
+		{"&>", (*types.DRange).OverRight},
+		{"-|-", (*types.DRange).Adjacent},
+	} {
+		op, fn := pos.op, pos.fn
+		r.RegisterBinOp(op, &BinOp{Left: rng, Right: rng, ReturnType: types.Bool, Fn: rangeOp(op, boolOp(fn))})
+		for _, p := range [][2]*types.T{{mr, mr}, {mr, rng}, {rng, mr}} {
+			r.RegisterBinOp(op, &BinOp{Left: p[0], Right: p[1], ReturnType: types.Bool, Fn: multirangeOp(op, spanOp(fn))})
+		}
+	}
+
+	r.RegisterBinOp("&&", &BinOp{Left: rng, Right: rng, ReturnType: types.Bool,
+		Fn: rangeOp("&&", boolOp((*types.DRange).Overlaps))})
+	r.RegisterBinOp("@>", &BinOp{Left: rng, Right: rng, ReturnType: types.Bool,
+		Fn: rangeOp("@>", boolOp((*types.DRange).Contains))})
+	r.RegisterBinOp("<@", &BinOp{Left: rng, Right: rng, ReturnType: types.Bool,
+		Fn: rangeOp("<@", boolOp(func(l, r *types.DRange) bool { return r.Contains(l) }))})
+	r.RegisterBinOp("@>", &BinOp{Left: rng, Right: types.Any, ReturnType: types.Bool,
+		Fn: func(ctx *Context, l, r types.Datum) (types.Datum, error) {
+			ok, err := containsElem(ctx, l.(*types.DRange), r)
+			return types.MakeDBool(ok), err
+		}})
+	r.RegisterBinOp("<@", &BinOp{Left: types.Any, Right: rng, ReturnType: types.Bool,
+		Fn: func(ctx *Context, l, r types.Datum) (types.Datum, error) {
+			ok, err := containsElem(ctx, r.(*types.DRange), l)
+			return types.MakeDBool(ok), err
+		}})
+	r.RegisterBinOp("+", &BinOp{Left: rng, Right: rng, ReturnFn: func(l, _ *types.T) *types.T { return l },
+		Fn: rangeOp("+", func(l, r *types.DRange) (types.Datum, error) { return l.Union(r) })})
+	r.RegisterBinOp("*", &BinOp{Left: rng, Right: rng, ReturnFn: func(l, _ *types.T) *types.T { return l },
+		Fn: rangeOp("*", func(l, r *types.DRange) (types.Datum, error) { return l.Intersect(r) })})
+	r.RegisterBinOp("-", &BinOp{Left: rng, Right: rng, ReturnFn: func(l, _ *types.T) *types.T { return l },
+		Fn: rangeOp("-", func(l, r *types.DRange) (types.Datum, error) {
+			pieces, err := l.Minus(r)
+			if err != nil {
+				return nil, err
+			}
+			switch len(pieces) {
+			case 0:
+				return types.EmptyRange(l.Typ), nil
+			case 1:
+				return pieces[0], nil
+			}
+			return nil, pgerror.New(pgerror.CodeDataException, "result of range difference would not be contiguous")
+		})})
+
+	for _, p := range [][2]*types.T{{mr, mr}, {mr, rng}, {rng, mr}} {
+		r.RegisterBinOp("&&", &BinOp{Left: p[0], Right: p[1], ReturnType: types.Bool,
+			Fn: multirangeOp("&&", func(l, r *types.DMultirange) (types.Datum, error) {
+				return types.MakeDBool(multirangeOverlaps(l, r)), nil
+			})})
+		r.RegisterBinOp("@>", &BinOp{Left: p[0], Right: p[1], ReturnType: types.Bool,
+			Fn: multirangeOp("@>", func(l, r *types.DMultirange) (types.Datum, error) {
+				return types.MakeDBool(multirangeContains(l, r)), nil
+			})})
+		r.RegisterBinOp("<@", &BinOp{Left: p[0], Right: p[1], ReturnType: types.Bool,
+			Fn: multirangeOp("<@", func(l, r *types.DMultirange) (types.Datum, error) {
+				return types.MakeDBool(multirangeContains(r, l)), nil
+			})})
+	}
+	r.RegisterBinOp("@>", &BinOp{Left: mr, Right: types.Any, ReturnType: types.Bool,
+		Fn: func(ctx *Context, l, r types.Datum) (types.Datum, error) {
+			ok, err := multirangeContainsElem(ctx, l.(*types.DMultirange), r)
+			return types.MakeDBool(ok), err
+		}})
+	r.RegisterBinOp("<@", &BinOp{Left: types.Any, Right: mr, ReturnType: types.Bool,
+		Fn: func(ctx *Context, l, r types.Datum) (types.Datum, error) {
+			ok, err := multirangeContainsElem(ctx, r.(*types.DMultirange), l)
+			return types.MakeDBool(ok), err
+		}})
+	r.RegisterBinOp("+", &BinOp{Left: mr, Right: mr, ReturnFn: func(l, _ *types.T) *types.T { return l },
+		Fn: multirangeOp("+", func(l, r *types.DMultirange) (types.Datum, error) {
+			return types.MakeDMultirange(l.Typ, append(append([]*types.DRange(nil), l.Ranges...), r.Ranges...))
+		})})
+	r.RegisterBinOp("*", &BinOp{Left: mr, Right: mr, ReturnFn: func(l, _ *types.T) *types.T { return l },
+		Fn: multirangeOp("*", func(l, r *types.DMultirange) (types.Datum, error) { return multirangeIntersect(l, r) })})
+	r.RegisterBinOp("-", &BinOp{Left: mr, Right: mr, ReturnFn: func(l, _ *types.T) *types.T { return l },
+		Fn: multirangeOp("-", func(l, r *types.DMultirange) (types.Datum, error) { return multirangeMinus(l, r) })})
+
+	r.RegisterAggregate("range_agg",
+		&Aggregate{Params: []*types.T{rng}, ReturnFn: multirangeOfArg,
+			New: func(args []*types.T) AggregateFunc { return &rangeAgg{typ: multirangeOfArg(args)} }},
+		&Aggregate{Params: []*types.T{mr}, ReturnFn: sameAsArg,
+			New: func(args []*types.T) AggregateFunc { return &rangeAgg{typ: args[0]} }},
+	)
+	r.RegisterAggregate("range_intersect_agg",
+		&Aggregate{Params: []*types.T{rng}, ReturnFn: sameAsArg,
+			New: func([]*types.T) AggregateFunc { return &rangeIntersectAgg{} }},
+		&Aggregate{Params: []*types.T{mr}, ReturnFn: sameAsArg,
+			New: func([]*types.T) AggregateFunc { return &rangeIntersectAgg{} }},
+	)
+}
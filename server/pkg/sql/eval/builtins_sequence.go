@@ -0,0 +1,122 @@
+package eval
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Sequences advances and reads the sequences of the database for nextval
+// and its kin.
+type Sequences interface {
+	// NextVal advances the sequence named name in schema, or in the
+	// search path if schema is empty, and returns its new value.
+	NextVal(schema, name string) (int64, error)
+	// CurrVal returns the value nextval last returned for the sequence in
+	// the session.
+	CurrVal(schema, name string) (int64, error)
+	// SetVal sets the value of the sequence. If called is false, the next
+	// nextval returns v itself.
+	SetVal(schema, name string, v int64, called bool) error
+	// LastVal returns the value nextval last returned in the session.
+	LastVal() (int64, error)
+}
+
+// SequenceName returns the schema, or empty if it is not
+// schema-qualified, and the name of the sequence the text argument of
+// nextval and its kin names.
+func SequenceName(arg string) (schema, name string) {
+	// The dot separating the schema is the first outside double quotes.
+	quoted := false
+	for i := 0; i < len(arg); i++ {
+		switch {
+		case arg[i] == '"':
+			quoted = !quoted
+		case arg[i] == '.' && !quoted:
+			return Identifier(arg[:i]), Identifier(arg[i+1:])
+		}
+	}
+	return "", Identifier(arg)
+}
+
+// Identifier returns the name the text arg gives: as in SQL, letters are
+// folded to lower case unless the name is double-quoted.
+func Identifier(arg string) string {
+	if len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"' {
+		return strings.ReplaceAll(arg[1:len(arg)-1], `""`, `"`)
+	}
+	return strings.ToLower(arg)
+}
+
+// sequences returns the sequences of ctx.
+func sequences(ctx *Context) (Sequences, error) {
+	if ctx.Sequences == nil {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "sequences are not supported here")
+	}
+	return ctx.Sequences, nil
+}
+
+// rowIDs hands out the values of unique_rowid.
+var rowIDs struct {
+	sync.Mutex
+	last int64
+}
+
+// uniqueRowID returns a value greater than any it returned before. Values
+// are the current time in microseconds, so they stay unique across
+// restarts as long as the clock does not go back.
+func uniqueRowID() int64 {
+	rowIDs.Lock()
+	defer rowIDs.Unlock()
+	rowIDs.last = max(rowIDs.last+1, time.Now().UnixMicro())
+	return rowIDs.last
+}
+
+func init() {
+	r := Builtins
+	r.RegisterFunc("unique_rowid", &Overload{ReturnType: types.Int8, Volatility: Volatile,
+		Fn: func(*Context, []types.Datum) (types.Datum, error) { return types.DInt(uniqueRowID()), nil }})
+	r.RegisterFunc("nextval", &Overload{Params: []*types.T{types.String}, ReturnType: types.Int8, Volatility: Volatile,
+		Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+			s, err := sequences(ctx)
+			if err != nil {
+				return nil, err
+			}
+			v, err := s.NextVal(SequenceName(string(args[0].(types.DString))))
+			return types.DInt(v), err
+		}})
+	r.RegisterFunc("currval", &Overload{Params: []*types.T{types.String}, ReturnType: types.Int8, Volatility: Volatile,
+		Fn: func(ctx *Context, args []types.Datum) (types.Datum, error) {
+			s, err := sequences(ctx)
+			if err != nil {
+				return nil, err
+			}
+			v, err := s.CurrVal(SequenceName(string(args[0].(types.DString))))
+			return types.DInt(v), err
+		}})
+	r.RegisterFunc("lastval", &Overload{ReturnType: types.Int8, Volatility: Volatile,
+		Fn: func(ctx *Context, _ []types.Datum) (types.Datum, error) {
+			s, err := sequences(ctx)
+			if err != nil {
+				return nil, err
+			}
+			v, err := s.LastVal()
+			return types.DInt(v), err
+		}})
+	setval := func(ctx *Context, args []types.Datum) (types.Datum, error) {
+		s, err := sequences(ctx)
+		if err != nil {
+			return nil, err
+		}
+		called := len(args) < 3 || bool(args[2].(types.DBool))
+		schema, name := SequenceName(string(args[0].(types.DString)))
+		return args[1], s.SetVal(schema, name, int64(args[1].(types.DInt)), called)
+	}
+	r.RegisterFunc("setval",
+		&Overload{Params: []*types.T{types.String, types.Int8}, ReturnType: types.Int8, Volatility: Volatile, Fn: setval},
+		&Overload{Params: []*types.T{types.String, types.Int8, types.Bool}, ReturnType: types.Int8, Volatility: Volatile, Fn: setval},
+	)
+}
@@ -0,0 +1,199 @@
+package eval
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// textFunc wraps a string function as a single-argument text overload.
+func textFunc(fn func(string) string) *Overload {
+	return &Overload{
+		Params:     []*types.T{types.String},
+		ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DString(fn(string(args[0].(types.DString)))), nil
+		},
+	}
+}
+
+// trimFunc builds the one- and two-argument overloads of a trim function.
+// The one-argument form trims spaces.
+func trimFunc(trim func(s, cutset string) string) []*Overload {
+	return []*Overload{
+		textFunc(func(s string) string { return trim(s, " ") }),
+		{Params: []*types.T{types.String, types.String}, ReturnType: types.String,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.DString(trim(string(args[0].(types.DString)), string(args[1].(types.DString)))), nil
+			}},
+	}
+}
+
+// substring returns the characters of s from 1-based position start for
+// count characters, clipping at both ends like PostgreSQL. A negative count
+// means "to the end".
+func substring(s string, start, count int64) string {
+	runes := []rune(s)
+	from := start - 1
+	to := int64(len(runes))
+	if count >= 0 {
+		to = min(to, from+count)
+	}
+	from = max(from, 0)
+	if from >= to {
+		return ""
+	}
+	return string(runes[from:to])
+}
+
+// concatText renders args as text for concat, skipping NULLs.
+func concatText(args []types.Datum, sep string) string {
+	var b strings.Builder
+	first := true
+	for _, a := range args {
+		if a == types.DNull {
+			continue
+		}
+		if !first {
+			b.WriteString(sep)
+		}
+		first = false
+		b.WriteString(a.String())
+	}
+	return b.String()
+}
+
+func init() {
+	r := Builtins
+
+	r.RegisterFunc("lower", textFunc(strings.ToLower))
+	r.RegisterFunc("upper", textFunc(strings.ToUpper))
+	r.RegisterFunc("initcap", textFunc(func(s string) string {
+		var b strings.Builder
+		prevAlnum := false
+		for _, c := range s {
+			if prevAlnum {
+				b.WriteRune(unicode.ToLower(c))
+			} else {
+				b.WriteRune(unicode.ToUpper(c))
+			}
+			prevAlnum = unicode.IsLetter(c) || unicode.IsDigit(c)
+		}
+		return b.String()
+	}))
+	r.RegisterFunc("reverse", textFunc(func(s string) string {
+		runes := []rune(s)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return string(runes)
+	}))
+
+	charLength := &Overload{Params: []*types.T{types.String}, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DInt(utf8.RuneCountInString(string(args[0].(types.DString)))), nil
+		}}
+	byteLength := &Overload{Params: []*types.T{types.Bytes}, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DInt(len(args[0].(types.DBytes))), nil
+		}}
+	r.RegisterFunc("length", charLength, byteLength)
+	r.RegisterFunc("char_length", charLength)
+	r.RegisterFunc("character_length", charLength)
+	r.RegisterFunc("octet_length",
+		&Overload{Params: []*types.T{types.String}, ReturnType: types.Int4,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.DInt(len(args[0].(types.DString))), nil
+			}},
+		byteLength,
+	)
+
+	r.RegisterFunc("substring",
+		&Overload{Params: []*types.T{types.String, types.Int}, ReturnType: types.String,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				return types.DString(substring(string(args[0].(types.DString)), int64(args[1].(types.DInt)), -1)), nil
+			}},
+		&Overload{Params: []*types.T{types.String, types.Int, types.Int}, ReturnType: types.String,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				count := int64(args[2].(types.DInt))
+				if count < 0 {
+					return nil, pgerror.New(pgerror.CodeSubstringError, "negative substring length not allowed")
+				}
+				return types.DString(substring(string(args[0].(types.DString)), int64(args[1].(types.DInt)), count)), nil
+			}},
+	)
+	r.RegisterFunc("substr", r.Overloads("substring")...)
+	r.RegisterFunc("left", &Overload{Params: []*types.T{types.String, types.Int}, ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			runes := []rune(string(args[0].(types.DString)))
+			n := int64(args[1].(types.DInt))
+			if n < 0 {
+				n = max(int64(len(runes))+n, 0)
+			}
+			return types.DString(runes[:min(n, int64(len(runes)))]), nil
+		}})
+	r.RegisterFunc("right", &Overload{Params: []*types.T{types.String, types.Int}, ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			runes := []rune(string(args[0].(types.DString)))
+			n := int64(args[1].(types.DInt))
+			if n < 0 {
+				n = max(int64(len(runes))+n, 0)
+			}
+			return types.DString(runes[int64(len(runes))-min(n, int64(len(runes))):]), nil
+		}})
+
+	r.RegisterFunc("concat", &Overload{Variadic: types.Any, ReturnType: types.String, NullCall: true,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DString(concatText(args, "")), nil
+		}})
+	r.RegisterFunc("concat_ws", &Overload{Params: []*types.T{types.String}, Variadic: types.Any,
+		ReturnType: types.String, NullCall: true,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			if args[0] == types.DNull {
+				return types.DNull, nil
+			}
+			return types.DString(concatText(args[1:], string(args[0].(types.DString)))), nil
+		}})
+
+	r.RegisterFunc("btrim", trimFunc(strings.Trim)...)
+	r.RegisterFunc("ltrim", trimFunc(strings.TrimLeft)...)
+	r.RegisterFunc("rtrim", trimFunc(strings.TrimRight)...)
+
+	r.RegisterFunc("strpos", &Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			s, sub := string(args[0].(types.DString)), string(args[1].(types.DString))
+			i := strings.Index(s, sub)
+			if i < 0 {
+				return types.DInt(0), nil
+			}
+			return types.DInt(utf8.RuneCountInString(s[:i]) + 1), nil
+		}})
+	r.RegisterFunc("replace", &Overload{Params: []*types.T{types.String, types.String, types.String},
+		ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			from := string(args[1].(types.DString))
+			if from == "" {
+				return args[0], nil
+			}
+			return types.DString(strings.ReplaceAll(string(args[0].(types.DString)), from, string(args[2].(types.DString)))), nil
+		}})
+	r.RegisterFunc("repeat", &Overload{Params: []*types.T{types.String, types.Int}, ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			n := int64(args[1].(types.DInt))
+			s := string(args[0].(types.DString))
+			if n <= 0 {
+				return types.DString(""), nil
+			}
+			if int64(len(s))*n > 1<<30 {
+				return nil, pgerror.New(pgerror.CodeProgramLimitExceeded, "requested length too large")
+			}
+			return types.DString(strings.Repeat(s, int(n))), nil
+		}})
+	r.RegisterFunc("starts_with", &Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.Bool,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.MakeDBool(strings.HasPrefix(string(args[0].(types.DString)), string(args[1].(types.DString)))), nil
+		}})
+}
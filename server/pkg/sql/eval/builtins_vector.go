@@ -0,0 +1,95 @@
+package eval
+
+import (
+	"math"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// VectorDistances maps pgvector's distance operators to the distance they
+// compute. Approximate nearest neighbor indexes are built for one of them.
+var VectorDistances = map[string]func(a, b *types.DVector) float64{
+	"<->": types.L2Distance,
+	"<=>": types.CosineDistance,
+	"<#>": func(a, b *types.DVector) float64 { return -types.InnerProduct(a, b) },
+}
+
+// vectorDistance wraps a distance as a function of two vectors of the same
+// dimension.
+func vectorDistance(fn func(a, b *types.DVector) float64) func(types.Datum, types.Datum) (types.Datum, error) {
+	return func(l, r types.Datum) (types.Datum, error) {
+		a, b := l.(*types.DVector), r.(*types.DVector)
+		if err := types.SameVectorDims(a, b); err != nil {
+			return nil, err
+		}
+		return types.DFloat(fn(a, b)), nil
+	}
+}
+
+// vectorElementwise wraps an arithmetic operator applied to each pair of
+// elements.
+func vectorElementwise(fn func(a, b float32) float32) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(_ *Context, l, r types.Datum) (types.Datum, error) {
+		a, b := l.(*types.DVector), r.(*types.DVector)
+		if err := types.SameVectorDims(a, b); err != nil {
+			return nil, err
+		}
+		out := make([]float32, len(a.Elems))
+		for i, x := range a.Elems {
+			out[i] = fn(x, b.Elems[i])
+			if math.IsInf(float64(out[i]), 0) {
+				return nil, pgerror.New(pgerror.CodeNumericValueOutOfRange, "value out of range: overflow")
+			}
+		}
+		return types.NewDVector(out), nil
+	}
+}
+
+func init() {
+	r := Builtins
+	vec := []*types.T{types.Vector, types.Vector}
+
+	for op, fn := range VectorDistances {
+		dist := vectorDistance(fn)
+		r.RegisterBinOp(op, &BinOp{Left: types.Vector, Right: types.Vector, ReturnType: types.Float,
+			Fn: func(_ *Context, l, r types.Datum) (types.Datum, error) { return dist(l, r) }})
+	}
+	// A slice rather than a map keeps the order the functions are
+	// registered in, and so their OIDs, stable.
+	for _, f := range []struct {
+		name string
+		fn   func(a, b *types.DVector) float64
+	}{
+		{"l2_distance", types.L2Distance},
+		{"cosine_distance", types.CosineDistance},
+		{"inner_product", types.InnerProduct},
+		{"l1_distance", func(a, b *types.DVector) float64 {
+			var sum float64
+			for i, x := range a.Elems {
+				sum += math.Abs(float64(x - b.Elems[i]))
+			}
+			return sum
+		}},
+	} {
+		dist := vectorDistance(f.fn)
+		r.RegisterFunc(f.name, &Overload{Params: vec, ReturnType: types.Float,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) { return dist(args[0], args[1]) }})
+	}
+
+	r.RegisterBinOp("+", &BinOp{Left: types.Vector, Right: types.Vector, ReturnType: types.Vector,
+		Fn: vectorElementwise(func(a, b float32) float32 { return a + b })})
+	r.RegisterBinOp("-", &BinOp{Left: types.Vector, Right: types.Vector, ReturnType: types.Vector,
+		Fn: vectorElementwise(func(a, b float32) float32 { return a - b })})
+	r.RegisterBinOp("*", &BinOp{Left: types.Vector, Right: types.Vector, ReturnType: types.Vector,
+		Fn: vectorElementwise(func(a, b float32) float32 { return a * b })})
+
+	r.RegisterFunc("vector_dims", &Overload{Params: []*types.T{types.Vector}, ReturnType: types.Int4,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DInt(len(args[0].(*types.DVector).Elems)), nil
+		}})
+	r.RegisterFunc("vector_norm", &Overload{Params: []*types.T{types.Vector}, ReturnType: types.Float,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.DFloat(args[0].(*types.DVector).Norm()), nil
+		}})
+}
@@ -0,0 +1,93 @@
+package eval
+
+import (
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// xpathDocument parses the document xpath() and xpath_exists() query.
+func xpathDocument(x types.DXML) (*types.XMLNode, error) {
+	doc, err := types.ParseXMLTree(string(x), true)
+	if err != nil {
+		return nil, pgerror.New(pgerror.CodeInvalidXMLDocument, "could not parse XML document").WithDetail(err.Error())
+	}
+	return doc, nil
+}
+
+func init() {
+	r := Builtins
+	xml := types.XML
+
+	// xmlparse and xmlserialize implement XMLPARSE(DOCUMENT|CONTENT x)
+	// and XMLSERIALIZE(DOCUMENT|CONTENT x AS type), which the parser
+	// rewrites into calls with the DOCUMENT option as a boolean.
+	r.RegisterFunc("xmlparse", &Overload{Params: []*types.T{types.String, types.Bool}, ReturnType: xml,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			return types.ParseDXML(string(args[0].(types.DString)), bool(args[1].(types.DBool)))
+		}})
+	r.RegisterFunc("xmlserialize", &Overload{Params: []*types.T{xml, types.Bool}, ReturnType: types.String,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			x := args[0].(types.DXML)
+			if args[1].(types.DBool) {
+				if _, err := types.ParseXMLTree(string(x), true); err != nil {
+					return nil, pgerror.New(pgerror.CodeNotAnXMLDocument, "not an XML document")
+				}
+			}
+			return types.DString(x), nil
+		}})
+
+	r.RegisterFunc("xpath", &Overload{Params: []*types.T{types.String, xml}, ReturnType: types.XMLArray,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			doc, err := xpathDocument(args[1].(types.DXML))
+			if err != nil {
+				return nil, err
+			}
+			res, err := evalXPath(string(args[0].(types.DString)), doc)
+			if err != nil {
+				return nil, err
+			}
+			elems := make([]types.Datum, len(res))
+			for i, s := range res {
+				elems[i] = types.DXML(s)
+			}
+			return types.NewDArray(types.XMLArray, elems), nil
+		}})
+	r.RegisterFunc("xpath_exists", &Overload{Params: []*types.T{types.String, xml}, ReturnType: types.Bool,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			doc, err := xpathDocument(args[1].(types.DXML))
+			if err != nil {
+				return nil, err
+			}
+			e, err := compileXPath(string(args[0].(types.DString)))
+			if err != nil {
+				return nil, err
+			}
+			v, err := e.eval(xpathContext{node: doc, position: 1, size: 1})
+			if err != nil {
+				return nil, err
+			}
+			if nodes, ok := v.([]*types.XMLNode); ok {
+				return types.MakeDBool(len(nodes) > 0), nil
+			}
+			// Like PostgreSQL, a value that is not a node set exists.
+			return types.DTrue, nil
+		}})
+
+	for name, document := range map[string]bool{"xml_is_well_formed_document": true, "xml_is_well_formed_content": false, "xml_is_well_formed": false} {
+		r.RegisterFunc(name, &Overload{Params: []*types.T{types.String}, ReturnType: types.Bool,
+			Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+				_, err := types.ParseXMLTree(string(args[0].(types.DString)), document)
+				return types.MakeDBool(err == nil), nil
+			}})
+	}
+	r.RegisterFunc("xmlcomment", &Overload{Params: []*types.T{types.String}, ReturnType: xml,
+		Fn: func(_ *Context, args []types.Datum) (types.Datum, error) {
+			s := string(args[0].(types.DString))
+			if strings.Contains(s, "--") || strings.HasSuffix(s, "-") {
+				return nil, pgerror.New(pgerror.CodeInvalidXMLComment, "invalid XML comment")
+			}
+			return types.DXML("<!--" + s + "-->"), nil
+		}})
+}
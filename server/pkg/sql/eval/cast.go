@@ -0,0 +1,385 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// CanCast reports whether an explicit cast from one type to another exists.
+func CanCast(from, to *types.T) bool {
+	if from.Family == to.Family || from.Family == types.UnknownFamily ||
+		to.Family == types.AnyFamily {
+		return true
+	}
+	// Everything has a text representation, and text can be parsed as any
+	// type. citext converts like text.
+	str := func(f types.Family) bool { return f == types.StringFamily || f == types.CITextFamily }
+	if str(from.Family) || str(to.Family) {
+		return true
+	}
+	numeric := func(f types.Family) bool {
+		return f == types.IntFamily || f == types.FloatFamily || f == types.DecimalFamily
+	}
+	switch {
+	case numeric(from.Family) && numeric(to.Family):
+		return true
+	case from.Family == types.IntFamily && to.Family == types.BoolFamily,
+		from.Family == types.BoolFamily && to.Family == types.IntFamily:
+		return true
+	case from.Family == types.MoneyFamily && to.Family == types.DecimalFamily,
+		to.Family == types.MoneyFamily && (from.Family == types.IntFamily || from.Family == types.DecimalFamily):
+		return true
+	case from.Family == types.IntFamily && to.Family == types.BitFamily,
+		from.Family == types.BitFamily && to.Family == types.IntFamily:
+		return true
+	case from.Family == types.RangeFamily && to.Family == types.MultirangeFamily:
+		return true
+	case from.Family == types.HstoreFamily && to.Family == types.JSONBFamily:
+		return true
+	}
+	datetime := func(f types.Family) bool {
+		return f == types.DateFamily || f == types.TimestampFamily || f == types.TimestampTZFamily
+	}
+	return datetime(from.Family) && datetime(to.Family)
+}
+
+// PerformCast converts d to type to using explicit cast semantics: strings
+// are truncated to the target length and numerics rounded to its scale.
+// ctx may be nil when casting constants at plan time.
+func PerformCast(ctx *Context, d types.Datum, to *types.T) (types.Datum, error) {
+	if d == types.DNull {
+		return d, nil
+	}
+	if s, ok := d.(types.DCIText); ok && to.Family != types.CITextFamily {
+		d = types.DString(s)
+	}
+	switch to.Family {
+	case types.AnyFamily:
+		return d, nil
+	case types.CITextFamily:
+		if _, ok := d.(types.DCIText); ok {
+			return d, nil
+		}
+		s, err := PerformCast(ctx, d, types.String)
+		if err != nil {
+			return nil, err
+		}
+		return types.DCIText(s.(types.DString)), nil
+	case types.StringFamily:
+		s := styles(ctx).Format(d)
+		switch v := d.(type) {
+		case types.DBool:
+			s = "false"
+			if v {
+				s = "true"
+			}
+		case *types.DInet:
+			// As text, an inet always shows its prefix length.
+			s = v.Prefix.String()
+		}
+		return truncateString(s, to), nil
+	case types.BoolFamily:
+		switch v := d.(type) {
+		case types.DBool:
+			return v, nil
+		case types.DInt:
+			return types.MakeDBool(v != 0), nil
+		case types.DString:
+			return types.ParseDBool(string(v))
+		}
+	case types.IntFamily:
+		switch v := d.(type) {
+		case types.DInt:
+			return types.CheckIntWidth(to, int64(v))
+		case types.DBool:
+			if v {
+				return types.DInt(1), nil
+			}
+			return types.DInt(0), nil
+		case types.DFloat:
+			f := math.RoundToEven(float64(v))
+			if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return nil, types.IntRangeError(to)
+			}
+			return types.CheckIntWidth(to, int64(f))
+		case *types.DDecimal:
+			i, ok := v.Int64()
+			if !ok {
+				return nil, types.IntRangeError(to)
+			}
+			return types.CheckIntWidth(to, i)
+		case *types.DBitString:
+			return bitsToInt(v, to)
+		case types.DString:
+			return types.ParseDInt(to, string(v))
+		}
+	case types.FloatFamily:
+		switch v := d.(type) {
+		case types.DFloat:
+			return roundFloat(to, float64(v)), nil
+		case types.DInt:
+			return roundFloat(to, float64(v)), nil
+		case *types.DDecimal:
+			return roundFloat(to, v.Float64()), nil
+		case types.DString:
+			f, err := types.ParseDFloat(string(v))
+			if err != nil {
+				return nil, err
+			}
+			return roundFloat(to, float64(f)), nil
+		}
+	case types.DecimalFamily:
+		var dec *types.Dec
+		switch v := d.(type) {
+		case *types.DDecimal:
+			dec = &v.Dec
+		case types.DInt:
+			dec = types.NewDecFromInt(int64(v))
+		case types.DMoney:
+			dec = &types.Dec{Scale: 2}
+			dec.Coeff.SetInt64(int64(v))
+		case types.DFloat:
+			var err error
+			if dec, err = types.NewDecFromFloat(float64(v)); err != nil {
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "cannot convert %s to numeric", v)
+			}
+		case types.DString:
+			var err error
+			if dec, err = types.ParseDec(string(v)); err != nil {
+				return nil, pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type numeric: %q", string(v))
+			}
+		}
+		if dec != nil {
+			return applyNumericTypmod(dec, to)
+		}
+	case types.DateFamily:
+		switch v := d.(type) {
+		case types.DDate:
+			return v, nil
+		case types.DTimestamp:
+			return types.MakeDDate(v.Time), nil
+		case types.DTimestampTZ:
+			return types.MakeDDate(v.In(location(ctx))), nil
+		case types.DString:
+			return types.ParseDDate(string(v))
+		}
+	case types.TimestampFamily:
+		switch v := d.(type) {
+		case types.DTimestamp:
+			return v, nil
+		case types.DDate:
+			return types.MakeDTimestamp(v.Time()), nil
+		case types.DTimestampTZ:
+			return types.MakeDTimestamp(v.In(location(ctx))), nil
+		case types.DString:
+			return types.ParseDatum(to, string(v))
+		}
+	case types.TimestampTZFamily:
+		switch v := d.(type) {
+		case types.DTimestampTZ:
+			return v, nil
+		case types.DDate:
+			return types.MakeDTimestampTZ(v.Time()), nil
+		case types.DTimestamp:
+			return types.MakeDTimestampTZ(v.Time), nil
+		case types.DString:
+			return types.ParseDatum(to, string(v))
+		}
+	case types.IntervalFamily:
+		switch v := d.(type) {
+		case types.DInterval:
+			return v, nil
+		case types.DString:
+			return types.ParseDInterval(string(v))
+		}
+	case types.InetFamily:
+		switch v := d.(type) {
+		case *types.DInet:
+			return types.NewDInet(v.Addr(), v.Bits(), to.Oid == types.OidCidr), nil
+		case types.DString:
+			return types.ParseDatum(to, string(v))
+		}
+	case types.MoneyFamily:
+		switch v := d.(type) {
+		case types.DInt:
+			if v > math.MaxInt64/100 || v < math.MinInt64/100 {
+				return nil, types.MoneyRangeError()
+			}
+			return types.DMoney(v * 100), nil
+		case *types.DDecimal:
+			cents, ok := v.Round(2).Mul(types.NewDecFromInt(100)).Int64()
+			if !ok {
+				return nil, types.MoneyRangeError()
+			}
+			return types.DMoney(cents), nil
+		}
+	case types.BitFamily:
+		switch v := d.(type) {
+		case *types.DBitString:
+			// An explicit cast pads bit(n) values with zeros and cuts
+			// longer values rather than failing.
+			if to.Width > 0 && (v.Len > int(to.Width) || to.Oid == types.OidBit) {
+				return v.Resize(int(to.Width)), nil
+			}
+			return v, nil
+		case types.DInt:
+			return intToBits(int64(v), to), nil
+		}
+	case types.VectorFamily:
+		switch v := d.(type) {
+		case *types.DVector:
+			return types.CheckVectorDims(to, v)
+		case types.DString:
+			return types.ParseDatum(to, string(v))
+		}
+	case types.JSONBFamily:
+		switch v := d.(type) {
+		case *types.DHstore:
+			return hstoreToJSONB(v), nil
+		case types.DString:
+			return types.ParseDJSONB(string(v))
+		}
+	case types.RangeFamily, types.MultirangeFamily:
+		// Range types share their families, so only the same type converts
+		// without parsing. A range converts to its multirange.
+		switch v := d.(type) {
+		case types.DString:
+			return types.ParseDatum(to, string(v))
+		case *types.DRange:
+			if v.Typ.Oid == to.Oid {
+				return v, nil
+			}
+			if types.MultirangeOf(v.Typ).Oid == to.Oid {
+				return types.MakeDMultirange(to, []*types.DRange{v})
+			}
+		case *types.DMultirange:
+			if v.Typ.Oid == to.Oid {
+				return v, nil
+			}
+		}
+		return nil, pgerror.Newf(pgerror.CodeCannotCoerce, "cannot cast type %s to %s", d.ResolvedType(), to)
+	default:
+		if s, ok := d.(types.DString); ok {
+			return types.ParseDatum(to, string(s))
+		}
+	}
+	if d.ResolvedType().Family == to.Family {
+		return d, nil
+	}
+	if s, ok := d.(types.DString); ok {
+		return types.ParseDatum(to, string(s))
+	}
+	return nil, pgerror.Newf(pgerror.CodeCannotCoerce, "cannot cast type %s to %s", d.ResolvedType(), to)
+}
+
+func location(ctx *Context) *time.Location {
+	if ctx == nil || ctx.Location == nil {
+		return time.UTC
+	}
+	return ctx.Location
+}
+
+// styles returns the DateStyle and IntervalStyle of the session, which
+// the text of dates, times and intervals follows.
+func styles(ctx *Context) types.Styles {
+	if ctx == nil {
+		return types.Styles{}
+	}
+	return ctx.Styles
+}
+
+func roundFloat(to *types.T, f float64) types.DFloat {
+	if to.Width == 32 {
+		return types.DFloat(float32(f))
+	}
+	return types.DFloat(f)
+}
+
+func truncateString(s string, to *types.T) types.DString {
+	if to.Width > 0 && utf8.RuneCountInString(s) > int(to.Width) {
+		runes := []rune(s)
+		s = string(runes[:to.Width])
+	}
+	if to.Oid == types.OidBPChar && to.Width > 0 {
+		if n := utf8.RuneCountInString(s); n < int(to.Width) {
+			s += strings.Repeat(" ", int(to.Width)-n)
+		}
+	}
+	return types.DString(s)
+}
+
+// applyNumericTypmod rounds dec to the scale of a numeric(p,s) type and
+// checks that it fits in the precision.
+func applyNumericTypmod(dec *types.Dec, to *types.T) (types.Datum, error) {
+	if to.Precision == 0 {
+		return types.NewDDecimal(dec), nil
+	}
+	r := dec.Round(to.Scale)
+	if r.Digits() > to.Precision-to.Scale {
+		return nil, pgerror.New(pgerror.CodeNumericValueOutOfRange, "numeric field overflow").
+			WithDetail(fmt.Sprintf("A field with precision %d, scale %d must round to an absolute value less than 10^%d.",
+				to.Precision, to.Scale, to.Precision-to.Scale))
+	}
+	return types.NewDDecimal(r), nil
+}
+
+// bitsToInt returns the integer whose two's complement form, in the width
+// of to, ends with the bits of b.
+func bitsToInt(b *types.DBitString, to *types.T) (types.Datum, error) {
+	if b.Len > int(to.Width) {
+		return nil, types.IntRangeError(to)
+	}
+	var u uint64
+	for i := 0; i < b.Len; i++ {
+		u <<= 1
+		if b.Bit(i) {
+			u |= 1
+		}
+	}
+	// Sign-extend from the width of to.
+	shift := 64 - uint(to.Width)
+	return types.DInt(int64(u<<shift) >> shift), nil
+}
+
+// intToBits returns the rightmost bits of i, sign-extended if the bit(n)
+// type to is wider than 64 bits.
+func intToBits(i int64, to *types.T) *types.DBitString {
+	n := int(to.Width)
+	if n == 0 {
+		n = 64
+	}
+	b := types.NewDBitString(n)
+	for j := 0; j < n; j++ {
+		shift := min(n-1-j, 63)
+		b.SetBit(j, i>>shift&1 != 0)
+	}
+	return b
+}
+
+// AssignCast converts d for storage in a column of type to. Unlike an
+// explicit cast, values too long for a bounded string type are an error.
+func AssignCast(ctx *Context, d types.Datum, to *types.T) (types.Datum, error) {
+	if d == types.DNull {
+		return d, nil
+	}
+	if to.Family == types.StringFamily && to.Width > 0 {
+		s := d.String()
+		trimmed := strings.TrimRight(s, " ")
+		if utf8.RuneCountInString(trimmed) > int(to.Width) {
+			return nil, pgerror.Newf(pgerror.CodeStringDataRightTruncation,
+				"value too long for type %s", to)
+		}
+	}
+	if b, ok := d.(*types.DBitString); ok && to.Family == types.BitFamily {
+		if err := types.CheckBitLength(to, b); err != nil {
+			return nil, err
+		}
+	}
+	return PerformCast(ctx, d, to)
+}
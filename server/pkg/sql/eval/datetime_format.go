@@ -0,0 +1,746 @@
+package eval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// dateKeywords are the patterns of PostgreSQL's date and time templates,
+// matched case-insensitively; the longest match wins. The case a name
+// pattern such as Month or AM is written in sets the case of its output.
+var dateKeywords = []string{
+	"A.D.", "A.M.", "AD", "AM", "B.C.", "BC", "CC", "D", "DAY", "DD", "DDD", "DY",
+	"FF1", "FF2", "FF3", "FF4", "FF5", "FF6", "HH", "HH12", "HH24",
+	"I", "ID", "IDDD", "IW", "IY", "IYY", "IYYY", "J", "MI", "MM", "MON", "MONTH", "MS",
+	"OF", "P.M.", "PM", "Q", "RM", "SS", "SSSS", "SSSSS", "TZ", "TZH", "TZM", "US",
+	"W", "WW", "Y", "Y,YYY", "YY", "YYY", "YYYY",
+}
+
+// dateNode is one element of a parsed date and time template.
+type dateNode struct {
+	// key is the upper-case keyword, or empty for literal text.
+	key string
+	// text is the keyword as written, or the literal text.
+	text string
+	// fill is set by the FM prefix, which suppresses padding.
+	fill bool
+	// suffix is "TH" or "th" for an ordinal suffix.
+	suffix string
+}
+
+// parseDateFormat splits a to_char, to_date or to_timestamp template into
+// keywords and literal text. Double-quoted text is literal, with \"
+// standing for a quote.
+func parseDateFormat(format string) []dateNode {
+	var nodes []dateNode
+	literal := func(s string) {
+		if n := len(nodes); n > 0 && nodes[n-1].key == "" {
+			nodes[n-1].text += s
+			return
+		}
+		nodes = append(nodes, dateNode{text: s})
+	}
+	fill := false
+	for i := 0; i < len(format); {
+		rest := format[i:]
+		if hasPrefixFold(rest, "FM") {
+			fill = true
+			i += 2
+			continue
+		}
+		if hasPrefixFold(rest, "FX") || hasPrefixFold(rest, "TM") {
+			i += 2
+			continue
+		}
+		key := ""
+		for _, k := range dateKeywords {
+			if len(k) > len(key) && hasPrefixFold(rest, k) {
+				key = k
+			}
+		}
+		if key != "" {
+			n := dateNode{key: key, text: rest[:len(key)], fill: fill}
+			i += len(key)
+			switch {
+			case strings.HasPrefix(format[i:], "TH"), strings.HasPrefix(format[i:], "th"):
+				n.suffix = format[i : i+2]
+				i += 2
+			case hasPrefixFold(format[i:], "SP"):
+				i += 2
+			}
+			nodes = append(nodes, n)
+			fill = false
+			continue
+		}
+		switch format[i] {
+		case '"':
+			var b strings.Builder
+			i++
+			for i < len(format) && format[i] != '"' {
+				if format[i] == '\\' && i+1 < len(format) {
+					i++
+				}
+				b.WriteByte(format[i])
+				i++
+			}
+			i++
+			literal(b.String())
+		case '\\':
+			if i+1 < len(format) && format[i+1] == '"' {
+				i++
+			}
+			literal(format[i : i+1])
+			i++
+		default:
+			literal(format[i : i+1])
+			i++
+		}
+	}
+	return nodes
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// letterCase returns how a name pattern was written: all upper case,
+// capitalized, or all lower case.
+func letterCase(text string, s string) string {
+	switch {
+	case unicode.IsLower(rune(text[0])):
+		return strings.ToLower(s)
+	case len(text) > 1 && unicode.IsLower(rune(text[1])):
+		return strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+	}
+	return strings.ToUpper(s)
+}
+
+// dateFields are the fields of a timestamp or interval that to_char
+// formats. Years are astronomical, so 1 BC is year 0.
+type dateFields struct {
+	year, month, day         int
+	hour, minute, second, us int
+	yday, wday               int
+	isoYear, isoWeek, julian int
+	zone                     string
+	offset                   int
+	interval                 bool
+}
+
+// timeFields returns the fields of t. zone is false for a timestamp
+// without time zone, whose zone patterns format as empty.
+func timeFields(t time.Time, zone bool) dateFields {
+	f := dateFields{yday: t.YearDay(), wday: int(t.Weekday())}
+	var month time.Month
+	f.year, month, f.day = t.Date()
+	f.month = int(month)
+	f.hour, f.minute, f.second = t.Clock()
+	f.us = t.Nanosecond() / 1000
+	f.isoYear, f.isoWeek = t.ISOWeek()
+	f.julian = int(types.MakeDDate(t)) + unixJulianDay
+	if zone {
+		f.zone, f.offset = t.Zone()
+		if f.zone == "" || f.zone[0] == '+' || f.zone[0] == '-' {
+			f.zone = formatZoneOffset(f.offset, false)
+		}
+	}
+	return f
+}
+
+// intervalFields returns the fields of an interval: whole years and the
+// months left over, days, and the time part split into hours, minutes and
+// seconds without folding hours into days.
+func intervalFields(iv types.DInterval) dateFields {
+	us := iv.Micros
+	return dateFields{
+		year:     int(iv.Months / 12),
+		month:    int(iv.Months % 12),
+		day:      int(iv.Days),
+		hour:     int(us / int64(time.Hour/time.Microsecond)),
+		minute:   int(us / int64(time.Minute/time.Microsecond) % 60),
+		second:   int(us / int64(time.Second/time.Microsecond) % 60),
+		us:       int(us % int64(time.Second/time.Microsecond)),
+		interval: true,
+	}
+}
+
+// unixJulianDay is the Julian day number of 1970-01-01.
+const unixJulianDay = 2440588
+
+// formatZoneOffset formats an offset from UTC in seconds as +05:30 or -08,
+// always with minutes if full is set.
+func formatZoneOffset(off int, full bool) string {
+	sign := "+"
+	if off < 0 {
+		sign, off = "-", -off
+	}
+	s := fmt.Sprintf("%s%02d", sign, off/3600)
+	if m := off % 3600 / 60; m != 0 || full {
+		s += fmt.Sprintf(":%02d", m)
+	}
+	return s
+}
+
+var romanMonths = []string{"I", "II", "III", "IV", "V", "VI", "VII", "VIII", "IX", "X", "XI", "XII"}
+
+// toChar formats f with a date and time template.
+func toChar(f dateFields, format string) string {
+	var b strings.Builder
+	for _, n := range parseDateFormat(format) {
+		if n.key == "" {
+			b.WriteString(n.text)
+			continue
+		}
+		// num writes a number padded with zeros to width digits, and
+		// its ordinal suffix.
+		num := func(v, width int) {
+			if n.fill {
+				b.WriteString(strconv.Itoa(v))
+			} else {
+				b.WriteString(fmt.Sprintf("%0*d", width, v))
+			}
+			if n.suffix != "" {
+				b.WriteString(ordinalSuffix(int64(v), n.suffix == "TH"))
+			}
+		}
+		// name writes a name in the case of the pattern, padded to width
+		// characters.
+		name := func(s string, width int) {
+			s = letterCase(n.text, s)
+			if !n.fill && len(s) < width {
+				s += strings.Repeat(" ", width-len(s))
+			}
+			b.WriteString(s)
+		}
+		year, bc := f.year, false
+		if year <= 0 && !f.interval {
+			year, bc = 1-year, true
+		}
+		isoYear := f.isoYear
+		if isoYear <= 0 && !f.interval {
+			isoYear = 1 - isoYear
+		}
+		switch n.key {
+		case "HH", "HH12":
+			h := f.hour % 12
+			if h == 0 {
+				h = 12
+			}
+			num(h, 2)
+		case "HH24":
+			num(f.hour, 2)
+		case "MI":
+			num(f.minute, 2)
+		case "SS":
+			num(f.second, 2)
+		case "MS":
+			num(f.us/1000, 3)
+		case "US":
+			num(f.us, 6)
+		case "FF1", "FF2", "FF3", "FF4", "FF5", "FF6":
+			digits := int(n.key[2] - '0')
+			num(f.us/pow10Int(6-digits), digits)
+		case "SSSS", "SSSSS":
+			num(f.hour*3600+f.minute*60+f.second, 0)
+		case "AM", "PM", "A.M.", "P.M.":
+			s := "AM"
+			if f.hour%24 >= 12 {
+				s = "PM"
+			}
+			if strings.Contains(n.key, ".") {
+				s = s[:1] + "." + s[1:] + "."
+			}
+			name(s, 0)
+		case "BC", "AD", "B.C.", "A.D.":
+			s := "AD"
+			if bc {
+				s = "BC"
+			}
+			if strings.Contains(n.key, ".") {
+				s = s[:1] + "." + s[1:] + "."
+			}
+			name(s, 0)
+		case "Y,YYY":
+			b.WriteString(fmt.Sprintf("%d,%03d", year/1000, year%1000))
+		case "YYYY":
+			num(year, 4)
+		case "YYY":
+			num(year%1000, 3)
+		case "YY":
+			num(year%100, 2)
+		case "Y":
+			num(year%10, 1)
+		case "IYYY":
+			num(isoYear, 4)
+		case "IYY":
+			num(isoYear%1000, 3)
+		case "IY":
+			num(isoYear%100, 2)
+		case "I":
+			num(isoYear%10, 1)
+		case "MONTH", "MON":
+			if f.month < 1 || f.month > 12 {
+				break
+			}
+			s := time.Month(f.month).String()
+			if n.key == "MON" {
+				name(s[:3], 0)
+			} else {
+				name(s, 9)
+			}
+		case "MM":
+			num(f.month, 2)
+		case "RM":
+			if f.month < 1 || f.month > 12 {
+				break
+			}
+			name(romanMonths[f.month-1], 4)
+		case "DAY", "DY":
+			if f.interval {
+				break
+			}
+			s := time.Weekday(f.wday).String()
+			if n.key == "DY" {
+				name(s[:3], 0)
+			} else {
+				name(s, 9)
+			}
+		case "DDD":
+			num(f.yday, 3)
+		case "IDDD":
+			num((f.isoWeek-1)*7+isoWeekday(f.wday), 3)
+		case "DD":
+			num(f.day, 2)
+		case "D":
+			num(f.wday+1, 1)
+		case "ID":
+			num(isoWeekday(f.wday), 1)
+		case "W":
+			num((f.day-1)/7+1, 1)
+		case "WW":
+			num((f.yday-1)/7+1, 2)
+		case "IW":
+			num(f.isoWeek, 2)
+		case "CC":
+			cc := (year + 99) / 100
+			if bc {
+				cc = -cc
+			}
+			num(cc, 2)
+		case "J":
+			num(f.julian, 0)
+		case "Q":
+			if f.month >= 1 {
+				num((f.month-1)/3+1, 1)
+			}
+		case "TZ":
+			if f.zone != "" {
+				name(f.zone, 0)
+			}
+		case "TZH":
+			if f.zone != "" {
+				b.WriteString(formatZoneOffset(f.offset, true)[:3])
+			}
+		case "TZM":
+			if f.zone != "" {
+				num(abs(f.offset)%3600/60, 2)
+			}
+		case "OF":
+			if f.zone != "" {
+				b.WriteString(formatZoneOffset(f.offset, false))
+			}
+		}
+	}
+	return b.String()
+}
+
+func pow10Int(n int) int {
+	p := 1
+	for range n {
+		p *= 10
+	}
+	return p
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// isoWeekday returns the ISO day of the week, Monday 1 to Sunday 7.
+func isoWeekday(wday int) int {
+	if wday == 0 {
+		return 7
+	}
+	return wday
+}
+
+// ordinalSuffix returns the English ordinal suffix of v, such as "st".
+func ordinalSuffix(v int64, upper bool) string {
+	if v < 0 {
+		v = -v
+	}
+	s := "th"
+	if v%100 < 11 || v%100 > 13 {
+		switch v % 10 {
+		case 1:
+			s = "st"
+		case 2:
+			s = "nd"
+		case 3:
+			s = "rd"
+		}
+	}
+	if upper {
+		return strings.ToUpper(s)
+	}
+	return s
+}
+
+// dateParse collects the fields to_date and to_timestamp read from their
+// input.
+type dateParse struct {
+	year, yearDigits     int
+	yearKey              string
+	hasYear, bc          bool
+	century              int
+	month, day, yday     int
+	hour, minute, second int
+	us, secondsOfDay     int
+	clock12, pm, hasPM   bool
+	julian               int
+	isoYear, isoWeek     int
+	isoDay               int
+	hasZone              bool
+	offset               int
+}
+
+// invalidValue is the error for input text that does not fit a pattern.
+func invalidValue(value string, n dateNode, detail string) error {
+	return pgerror.Newf(pgerror.CodeInvalidDatetimeFormat, "invalid value %q for %q", value, n.text).WithDetail(detail)
+}
+
+// dateNumberWidth is the number of digits a pattern reads when it is
+// directly followed by another pattern, as in YYYYMMDD.
+var dateNumberWidth = map[string]int{
+	"HH": 2, "HH12": 2, "HH24": 2, "MI": 2, "SS": 2, "MS": 3, "US": 6,
+	"FF1": 1, "FF2": 2, "FF3": 3, "FF4": 4, "FF5": 5, "FF6": 6,
+	"SSSS": 5, "SSSSS": 5, "YYYY": 4, "YYY": 3, "YY": 2, "Y": 1,
+	"IYYY": 4, "IYY": 3, "IY": 2, "I": 1, "MM": 2, "DDD": 3, "IDDD": 3,
+	"DD": 2, "D": 1, "ID": 1, "W": 1, "WW": 2, "IW": 2, "CC": 2, "J": 7,
+	"Q": 1, "TZH": 2, "TZM": 2,
+}
+
+// parseDate reads s according to a date and time template.
+func parseDate(s, format string) (*dateParse, error) {
+	p := &dateParse{}
+	nodes := parseDateFormat(format)
+	i := 0
+	skipSpaces := func() {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+	}
+	for idx, n := range nodes {
+		if n.key == "" {
+			for j := 0; j < len(n.text) && i < len(s); j++ {
+				c := n.text[j]
+				switch {
+				case s[i] == c:
+					i++
+				case c == ' ':
+					skipSpaces()
+				case !isAlnum(s[i]):
+					i++
+				}
+			}
+			continue
+		}
+		skipSpaces()
+		// number reads an integer of at most width digits, or of any
+		// length when a separator or the end of the template follows.
+		number := func(signed bool) (int, int, error) {
+			width := dateNumberWidth[n.key]
+			if n.fill || idx+1 == len(nodes) || nodes[idx+1].key == "" {
+				width = 9
+			}
+			start := i
+			if signed && i < len(s) && (s[i] == '-' || s[i] == '+') {
+				i++
+			}
+			digits := i
+			for i < len(s) && i-digits < width && s[i] >= '0' && s[i] <= '9' {
+				i++
+			}
+			if i == digits {
+				end := min(start+max(dateNumberWidth[n.key], 1), len(s))
+				return 0, 0, invalidValue(s[start:end], n, "Value must be an integer.")
+			}
+			v, err := strconv.Atoi(s[start:i])
+			if err != nil {
+				return 0, 0, invalidValue(s[start:i], n, "Value must be an integer.")
+			}
+			if n.suffix != "" && i+2 <= len(s) && isAlpha(s[i]) && isAlpha(s[i+1]) {
+				i += 2
+			}
+			return v, i - digits, nil
+		}
+		// choice reads one of names, matched case-insensitively, and
+		// returns its index.
+		choice := func(names ...string) (int, error) {
+			best := -1
+			for k, name := range names {
+				if hasPrefixFold(s[i:], name) && (best < 0 || len(name) > len(names[best])) {
+					best = k
+				}
+			}
+			if best < 0 {
+				end := i
+				for end < len(s) && isAlpha(s[end]) {
+					end++
+				}
+				return 0, invalidValue(s[i:max(end, min(i+1, len(s)))], n, "The given value did not match any of the allowed values for this field.")
+			}
+			i += len(names[best])
+			return best, nil
+		}
+		var err error
+		var v, digits int
+		switch n.key {
+		case "HH", "HH12":
+			p.hour, _, err = number(false)
+			p.clock12 = true
+		case "HH24":
+			p.hour, _, err = number(false)
+		case "MI":
+			p.minute, _, err = number(false)
+		case "SS":
+			p.second, _, err = number(false)
+		case "MS", "US", "FF1", "FF2", "FF3", "FF4", "FF5", "FF6":
+			// Fractions are scaled by the digits given, so 12:3 read as
+			// SS:MS is 300 milliseconds.
+			v, digits, err = number(false)
+			if err == nil {
+				scale := 6
+				if n.key == "MS" {
+					scale = 3
+				}
+				if digits > scale {
+					return nil, invalidValue(s[i-digits:i], n, fmt.Sprintf("Field requires %d characters, but only %d could be parsed.", scale, digits))
+				}
+				p.us = v * pow10Int(6-digits)
+			}
+		case "SSSS", "SSSSS":
+			p.secondsOfDay, _, err = number(false)
+		case "AM", "PM", "A.M.", "P.M.":
+			v, err = choice("AM", "PM", "A.M.", "P.M.")
+			p.hasPM, p.pm, p.clock12 = true, v%2 == 1, true
+		case "BC", "AD", "B.C.", "A.D.":
+			v, err = choice("BC", "AD", "B.C.", "A.D.")
+			p.bc = v%2 == 0
+		case "Y,YYY":
+			var thousands int
+			thousands, _, err = number(false)
+			if err == nil {
+				if i >= len(s) || s[i] != ',' {
+					return nil, invalidValue(s[i:], n, "Value must be in the form Y,YYY.")
+				}
+				i++
+				v, _, err = number(false)
+				p.year, p.yearDigits, p.hasYear, p.yearKey = thousands*1000+v, 4, true, "YYYY"
+			}
+		case "YYYY", "YYY", "YY", "Y":
+			p.year, p.yearDigits, err = number(true)
+			p.hasYear, p.yearKey = true, n.key
+		case "IYYY", "IYY", "IY", "I":
+			p.isoYear, digits, err = number(false)
+			if err == nil {
+				p.isoYear = partialYear(p.isoYear, digits, strings.ReplaceAll(n.key, "I", "Y"))
+			}
+		case "MONTH", "MON":
+			names := make([]string, 24)
+			for m := range 12 {
+				names[m] = time.Month(m + 1).String()
+				names[m+12] = names[m][:3]
+			}
+			v, err = choice(names...)
+			p.month = v%12 + 1
+		case "RM":
+			v, err = choice(romanMonths...)
+			p.month = v + 1
+		case "MM":
+			p.month, _, err = number(false)
+		case "DAY", "DY":
+			names := make([]string, 14)
+			for d := range 7 {
+				names[d] = time.Weekday(d).String()
+				names[d+7] = names[d][:3]
+			}
+			_, err = choice(names...)
+		case "DDD":
+			p.yday, _, err = number(false)
+		case "IDDD":
+			v, _, err = number(false)
+			p.isoWeek, p.isoDay = (v-1)/7+1, (v-1)%7+1
+		case "DD":
+			p.day, _, err = number(false)
+		case "ID":
+			p.isoDay, _, err = number(false)
+		case "IW":
+			p.isoWeek, _, err = number(false)
+		case "CC":
+			p.century, _, err = number(true)
+		case "J":
+			p.julian, _, err = number(false)
+		case "D", "W", "WW", "Q":
+			_, _, err = number(false)
+		case "TZH":
+			start := i
+			v, _, err = number(true)
+			p.hasZone, p.offset = true, abs(v)*3600
+			if start < len(s) && s[start] == '-' {
+				p.offset = -p.offset
+			}
+		case "TZM":
+			v, _, err = number(false)
+			p.hasZone = true
+			if p.offset < 0 {
+				p.offset -= v * 60
+			} else {
+				p.offset += v * 60
+			}
+		case "OF":
+			start := i
+			v, _, err = number(true)
+			if err == nil {
+				neg := s[start] == '-'
+				off := abs(v) * 3600
+				if i < len(s) && s[i] == ':' {
+					i++
+					var m int
+					m, _, err = number(false)
+					off += m * 60
+				}
+				if neg {
+					off = -off
+				}
+				p.hasZone, p.offset = true, off
+			}
+		case "TZ":
+			return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "formatting field %q is only supported in to_char", n.text)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func isAlpha(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isAlnum(c byte) bool { return isAlpha(c) || c >= '0' && c <= '9' }
+
+// partialYear completes a year given with fewer digits than four to the
+// one nearest 2020, as PostgreSQL does.
+func partialYear(year, digits int, key string) int {
+	switch {
+	case key == "YYY" && digits <= 3 || key == "YYYY" && digits == 3:
+		if year < 100 {
+			return year + 2000
+		}
+		return year + 1000
+	case key == "YY" && digits <= 2 || key == "YYYY" && digits == 2:
+		if year < 70 {
+			return year + 2000
+		}
+		return year + 1900
+	case key == "Y" && digits == 1 || key == "YYYY" && digits == 1:
+		return year + 2000
+	}
+	return year
+}
+
+// time returns the instant p describes in loc, or in the zone the input
+// gave. text is the input, for errors.
+func (p *dateParse) time(text string, loc *time.Location) (time.Time, error) {
+	outOfRange := pgerror.Newf(pgerror.CodeDatetimeFieldOverflow, "date/time field value out of range: %q", text)
+	year := 1
+	switch {
+	case p.hasYear:
+		year = partialYear(p.year, p.yearDigits, p.yearKey)
+		if p.century != 0 && p.yearDigits <= 2 {
+			year = (p.century-1)*100 + year%100
+		}
+	case p.century != 0:
+		year = (p.century-1)*100 + 1
+	}
+	if p.bc {
+		if year <= 0 {
+			return time.Time{}, pgerror.Newf(pgerror.CodeInvalidDatetimeFormat, "inconsistent use of year %04d and \"BC\"", year)
+		}
+		year = 1 - year
+	}
+	var date time.Time
+	switch {
+	case p.julian != 0:
+		date = time.Unix(int64(p.julian-unixJulianDay)*24*60*60, 0).UTC()
+	case p.isoWeek != 0 || p.isoYear != 0 && p.isoDay != 0:
+		// The 4th of January is always in the first ISO week.
+		y := p.isoYear
+		if y == 0 {
+			y = year
+		}
+		jan4 := time.Date(y, time.January, 4, 0, 0, 0, 0, time.UTC)
+		week1 := jan4.AddDate(0, 0, 1-isoWeekday(int(jan4.Weekday())))
+		day := max(p.isoDay, 1)
+		date = week1.AddDate(0, 0, (max(p.isoWeek, 1)-1)*7+day-1)
+	case p.yday != 0 && p.month == 0:
+		if p.yday > 366 || p.yday == 366 && !isLeap(year) {
+			return time.Time{}, outOfRange
+		}
+		date = time.Date(year, time.January, p.yday, 0, 0, 0, 0, time.UTC)
+	default:
+		month, day := max(p.month, 1), max(p.day, 1)
+		if p.month > 12 || p.month < 0 || p.day > 31 || p.day < 0 {
+			return time.Time{}, outOfRange
+		}
+		date = time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+		if date.Day() != day {
+			return time.Time{}, outOfRange
+		}
+	}
+	hour := p.hour
+	if p.clock12 {
+		if hour < 1 || hour > 12 {
+			if !p.hasPM || hour != 0 {
+				return time.Time{}, pgerror.Newf(pgerror.CodeInvalidDatetimeFormat, "hour \"%d\" is invalid for the 12-hour clock", hour).
+					WithHint("Use the 24-hour clock, or give an hour between 1 and 12.")
+			}
+		}
+		hour %= 12
+		if p.pm {
+			hour += 12
+		}
+	}
+	if hour > 24 || p.minute > 59 || p.second > 60 || p.secondsOfDay >= 24*60*60 {
+		return time.Time{}, outOfRange
+	}
+	if p.hasZone {
+		loc = time.FixedZone("", p.offset)
+	}
+	y, m, d := date.Date()
+	t := time.Date(y, m, d, hour, p.minute, p.second+p.secondsOfDay, p.us*1000, loc)
+	return t, nil
+}
+
+func isLeap(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
@@ -0,0 +1,370 @@
+package eval
+
+import (
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Context carries the state needed to evaluate expressions.
+type Context struct {
+	// Row is the current input row read by ColumnRef.
+	Row []types.Datum
+	// TxnTimestamp is the start time of the current transaction, returned
+	// by now() and current_timestamp.
+	TxnTimestamp time.Time
+	// Location is the session time zone.
+	Location *time.Location
+	// Styles are the session's DateStyle and IntervalStyle, in which
+	// dates, times and intervals are cast to text.
+	Styles types.Styles
+	// Regexps caches compiled regular expressions for the session. It may
+	// be nil, in which case patterns are compiled on every use.
+	Regexps *RegexCache
+	// Outer holds the rows of the enclosing queries while a correlated
+	// subquery runs, innermost last.
+	Outer [][]types.Datum
+	// Subqueries runs the queries of subquery expressions.
+	Subqueries SubqueryRunner
+	// Sequences advances and reads sequences for nextval, currval, setval
+	// and lastval.
+	Sequences Sequences
+	// Privileges checks privileges for has_table_privilege and its kin.
+	Privileges Privileges
+	// Definitions reconstructs DDL for pg_get_tabledef and
+	// pg_get_indexdef.
+	Definitions Definitions
+	// Placeholders are the values of the parameters of the statement.
+	Placeholders []types.Datum
+	// BackendPID is the process ID of the session, returned by
+	// pg_backend_pid().
+	BackendPID int32
+	// Database is the name of the database the session is connected to,
+	// returned by current_database().
+	Database string
+}
+
+// NewContext returns a context for a statement starting now.
+func NewContext() *Context {
+	return &Context{TxnTimestamp: time.Now(), Location: time.UTC}
+}
+
+func (e *Const) Eval(*Context) (types.Datum, error) { return e.Datum, nil }
+
+func (e *ColumnRef) Eval(ctx *Context) (types.Datum, error) {
+	return ctx.Row[e.Idx], nil
+}
+
+func (e *BinaryExpr) Eval(ctx *Context) (types.Datum, error) {
+	l, err := e.Left.Eval(ctx)
+	if err != nil || l == types.DNull {
+		return l, err
+	}
+	r, err := e.Right.Eval(ctx)
+	if err != nil || r == types.DNull {
+		return r, err
+	}
+	d, err := e.Fn.Fn(ctx, l, r)
+	if err != nil {
+		return nil, err
+	}
+	return checkWidth(e.ResolvedType(), d)
+}
+
+func (e *UnaryExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Operand.Eval(ctx)
+	if err != nil || d == types.DNull {
+		return d, err
+	}
+	out, err := e.Fn.Fn(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	return checkWidth(e.ResolvedType(), out)
+}
+
+// checkWidth reports overflow of integer results narrower than int8.
+func checkWidth(t *types.T, d types.Datum) (types.Datum, error) {
+	if i, ok := d.(types.DInt); ok && t.Family == types.IntFamily && t.Width < 64 {
+		return types.CheckIntWidth(t, int64(i))
+	}
+	return d, nil
+}
+
+func (e *ComparisonExpr) Eval(ctx *Context) (types.Datum, error) {
+	l, err := e.Left.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.Right.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(e.Op, l, r), nil
+}
+
+// Compare applies a comparison operator with SQL NULL semantics.
+func Compare(op CompareOp, l, r types.Datum) types.Datum {
+	lNull, rNull := l == types.DNull, r == types.DNull
+	switch op {
+	case IsDistinctFrom, IsNotDistinctFrom:
+		same := lNull && rNull || !lNull && !rNull && l.Compare(r) == 0
+		return types.MakeDBool(same == (op == IsNotDistinctFrom))
+	}
+	if lNull || rNull {
+		return types.DNull
+	}
+	c := l.Compare(r)
+	var b bool
+	switch op {
+	case EQ:
+		b = c == 0
+	case NE:
+		b = c != 0
+	case LT:
+		b = c < 0
+	case LE:
+		b = c <= 0
+	case GT:
+		b = c > 0
+	case GE:
+		b = c >= 0
+	}
+	return types.MakeDBool(b)
+}
+
+func (e *RowComparisonExpr) Eval(ctx *Context) (types.Datum, error) {
+	l := make([]types.Datum, len(e.Left))
+	r := make([]types.Datum, len(e.Right))
+	for i := range e.Left {
+		var err error
+		if l[i], err = e.Left[i].Eval(ctx); err != nil {
+			return nil, err
+		}
+		if r[i], err = e.Right[i].Eval(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return CompareRows(e.Op, l, r), nil
+}
+
+// CompareRows applies a comparison operator to two rows of the same
+// length. Rows are equal if all their values are, and unequal if any are,
+// the result being NULL if that depends on NULL values. They are ordered
+// by the first values that are not equal, or are NULL if those are.
+func CompareRows(op CompareOp, l, r []types.Datum) types.Datum {
+	switch op {
+	case IsDistinctFrom, IsNotDistinctFrom:
+		for i := range l {
+			if Compare(IsDistinctFrom, l[i], r[i]) == types.DTrue {
+				return types.MakeDBool(op == IsDistinctFrom)
+			}
+		}
+		return types.MakeDBool(op == IsNotDistinctFrom)
+	case EQ, NE:
+		sawNull := false
+		for i := range l {
+			switch Compare(EQ, l[i], r[i]) {
+			case types.DFalse:
+				return types.MakeDBool(op == NE)
+			case types.DNull:
+				sawNull = true
+			}
+		}
+		if sawNull {
+			return types.DNull
+		}
+		return types.MakeDBool(op == EQ)
+	}
+	for i := range l {
+		if Compare(EQ, l[i], r[i]) != types.DTrue {
+			return Compare(op, l[i], r[i])
+		}
+	}
+	return types.MakeDBool(op == LE || op == GE)
+}
+
+func (e *AndExpr) Eval(ctx *Context) (types.Datum, error) {
+	l, err := e.Left.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if l == types.DFalse {
+		return types.DFalse, nil
+	}
+	r, err := e.Right.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r == types.DFalse:
+		return types.DFalse, nil
+	case l == types.DNull || r == types.DNull:
+		return types.DNull, nil
+	}
+	return types.DTrue, nil
+}
+
+func (e *OrExpr) Eval(ctx *Context) (types.Datum, error) {
+	l, err := e.Left.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if l == types.DTrue {
+		return types.DTrue, nil
+	}
+	r, err := e.Right.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r == types.DTrue:
+		return types.DTrue, nil
+	case l == types.DNull || r == types.DNull:
+		return types.DNull, nil
+	}
+	return types.DFalse, nil
+}
+
+func (e *NotExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Operand.Eval(ctx)
+	if err != nil || d == types.DNull {
+		return d, err
+	}
+	return types.MakeDBool(!bool(d.(types.DBool))), nil
+}
+
+func (e *IsNullExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Operand.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return types.MakeDBool((d == types.DNull) != e.Not), nil
+}
+
+func (e *IsBoolExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Operand.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	is := d != types.DNull && bool(d.(types.DBool)) == e.Value
+	return types.MakeDBool(is != e.Not), nil
+}
+
+func (e *CaseExpr) Eval(ctx *Context) (types.Datum, error) {
+	var operand types.Datum
+	if e.Operand != nil {
+		var err error
+		if operand, err = e.Operand.Eval(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for _, w := range e.Whens {
+		c, err := w.Cond.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if e.Operand != nil {
+			c = Compare(EQ, operand, c)
+		}
+		if c == types.DTrue {
+			return w.Val.Eval(ctx)
+		}
+	}
+	if e.Else != nil {
+		return e.Else.Eval(ctx)
+	}
+	return types.DNull, nil
+}
+
+func (e *CoalesceExpr) Eval(ctx *Context) (types.Datum, error) {
+	for _, x := range e.Exprs {
+		d, err := x.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if d != types.DNull {
+			return d, nil
+		}
+	}
+	return types.DNull, nil
+}
+
+func (e *NullIfExpr) Eval(ctx *Context) (types.Datum, error) {
+	l, err := e.Left.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.Right.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if Compare(EQ, l, r) == types.DTrue {
+		return types.DNull, nil
+	}
+	return l, nil
+}
+
+func (e *CastExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Operand.Eval(ctx)
+	if err != nil || d == types.DNull {
+		return d, err
+	}
+	return PerformCast(ctx, d, e.Typ)
+}
+
+func (e *FuncExpr) Eval(ctx *Context) (types.Datum, error) {
+	args := make([]types.Datum, len(e.Args))
+	for i, a := range e.Args {
+		d, err := a.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if d == types.DNull && !e.Overload.NullCall {
+			return types.DNull, nil
+		}
+		args[i] = d
+	}
+	out, err := e.Overload.Fn(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	// Integer results take the width of their arguments' type, as abs's
+	// does, which they may not fit.
+	if _, ok := out.(types.DInt); ok {
+		return checkWidth(e.ResolvedType(), out)
+	}
+	return out, nil
+}
+
+func (e *InListExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Operand.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d == types.DNull {
+		return types.DNull, nil
+	}
+	sawNull := false
+	for _, x := range e.List {
+		v, err := x.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch Compare(EQ, d, v) {
+		case types.DTrue:
+			return types.MakeDBool(!e.Not), nil
+		case types.DNull:
+			sawNull = true
+		}
+	}
+	if sawNull {
+		return types.DNull, nil
+	}
+	return types.MakeDBool(e.Not), nil
+}
+
+// IsTrue reports whether d is the boolean TRUE. NULL and FALSE are not.
+func IsTrue(d types.Datum) bool {
+	return d == types.DTrue
+}
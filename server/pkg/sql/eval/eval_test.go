@@ -0,0 +1,248 @@
+package eval_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// typed returns a constant of type t.
+func typed(d types.Datum, t *types.T) eval.Expr {
+	return &eval.Const{Datum: d, Typ: t}
+}
+
+// run evaluates e, failing the test if it cannot be built.
+func run(t *testing.T, e eval.Expr, err error) (types.Datum, error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("building the expression: %v", err)
+	}
+	return e.Eval(&eval.Context{})
+}
+
+// outOfRange reports whether err is a numeric_value_out_of_range error
+// with message msg.
+func outOfRange(err error, msg string) bool {
+	var pgErr *pgerror.Error
+	return errors.As(err, &pgErr) && pgErr.Code == pgerror.CodeNumericValueOutOfRange && pgErr.Message == msg
+}
+
+func TestAbs(t *testing.T) {
+	for _, tc := range []struct {
+		arg  types.Datum
+		typ  *types.T
+		want types.Datum
+		err  string
+	}{
+		{arg: types.DInt(-5), typ: types.Int2, want: types.DInt(5)},
+		{arg: types.DInt(math.MaxInt16), typ: types.Int2, want: types.DInt(math.MaxInt16)},
+		{arg: types.DInt(math.MinInt16), typ: types.Int2, err: "smallint out of range"},
+		{arg: types.DInt(-5), typ: types.Int4, want: types.DInt(5)},
+		{arg: types.DInt(math.MinInt16), typ: types.Int4, want: types.DInt(-math.MinInt16)},
+		{arg: types.DInt(math.MinInt32), typ: types.Int4, err: "integer out of range"},
+		{arg: types.DInt(math.MinInt32), typ: types.Int, want: types.DInt(-math.MinInt32)},
+		{arg: types.DInt(math.MinInt64 + 1), typ: types.Int, want: types.DInt(math.MaxInt64)},
+		{arg: types.DInt(math.MinInt64), typ: types.Int, err: "bigint out of range"},
+		{arg: types.DFloat(-1.5), typ: types.Float, want: types.DFloat(1.5)},
+	} {
+		e, err := eval.Builtins.NewFuncExpr("abs", []eval.Expr{typed(tc.arg, tc.typ)})
+		got, err := run(t, e, err)
+		switch {
+		case tc.err != "":
+			if !outOfRange(err, tc.err) {
+				t.Errorf("abs(%v::%s) = %v, %v; want %q", tc.arg, tc.typ.Name, got, err, tc.err)
+			}
+		case err != nil || got != tc.want:
+			t.Errorf("abs(%v::%s) = %v, %v; want %v", tc.arg, tc.typ.Name, got, err, tc.want)
+		case e.ResolvedType() != tc.typ:
+			t.Errorf("abs(%v::%s) is of type %s", tc.arg, tc.typ.Name, e.ResolvedType().Name)
+		}
+	}
+}
+
+// Booleans of three-valued logic.
+var (
+	tru  = typed(types.DTrue, types.Bool)
+	fls  = typed(types.DFalse, types.Bool)
+	null = typed(types.DNull, types.Bool)
+)
+
+func TestThreeValuedLogic(t *testing.T) {
+	and := func(l, r eval.Expr) (eval.Expr, error) { return eval.NewAndExpr(l, r) }
+	or := func(l, r eval.Expr) (eval.Expr, error) { return eval.NewOrExpr(l, r) }
+	not := func(l, _ eval.Expr) (eval.Expr, error) { return eval.NewNotExpr(l) }
+	isTrue := func(l, _ eval.Expr) (eval.Expr, error) { return eval.NewIsBoolExpr(l, true, false) }
+	isNotFalse := func(l, _ eval.Expr) (eval.Expr, error) { return eval.NewIsBoolExpr(l, false, true) }
+	for _, tc := range []struct {
+		name string
+		op   func(l, r eval.Expr) (eval.Expr, error)
+		l, r eval.Expr
+		want types.Datum
+	}{
+		{"true AND NULL", and, tru, null, types.DNull},
+		{"false AND NULL", and, fls, null, types.DFalse},
+		{"NULL AND false", and, null, fls, types.DFalse},
+		{"NULL AND NULL", and, null, null, types.DNull},
+		{"true OR NULL", or, tru, null, types.DTrue},
+		{"NULL OR true", or, null, tru, types.DTrue},
+		{"false OR NULL", or, fls, null, types.DNull},
+		{"NOT NULL", not, null, nil, types.DNull},
+		{"NOT false", not, fls, nil, types.DTrue},
+		{"NULL IS TRUE", isTrue, null, nil, types.DFalse},
+		{"NULL IS NOT FALSE", isNotFalse, null, nil, types.DTrue},
+	} {
+		e, err := tc.op(tc.l, tc.r)
+		if got, err := run(t, e, err); err != nil || got != tc.want {
+			t.Errorf("%s = %v, %v; want %v", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestNullComparisons(t *testing.T) {
+	one, two := typed(types.DInt(1), types.Int), typed(types.DInt(2), types.Int)
+	nullInt := typed(types.DNull, types.Int)
+	compare := func(op eval.CompareOp) func(l eval.Expr, r []eval.Expr) (eval.Expr, error) {
+		return func(l eval.Expr, r []eval.Expr) (eval.Expr, error) { return eval.NewComparisonExpr(op, l, r[0]) }
+	}
+	in := func(not bool) func(l eval.Expr, r []eval.Expr) (eval.Expr, error) {
+		return func(l eval.Expr, r []eval.Expr) (eval.Expr, error) { return eval.NewInListExpr(l, r, not) }
+	}
+	for _, tc := range []struct {
+		name string
+		op   func(l eval.Expr, r []eval.Expr) (eval.Expr, error)
+		l    eval.Expr
+		r    []eval.Expr
+		want types.Datum
+	}{
+		{"1 = NULL", compare(eval.EQ), one, []eval.Expr{nullInt}, types.DNull},
+		{"NULL <> NULL", compare(eval.NE), nullInt, []eval.Expr{nullInt}, types.DNull},
+		{"1 < 2", compare(eval.LT), one, []eval.Expr{two}, types.DTrue},
+		{"1 IS DISTINCT FROM NULL", compare(eval.IsDistinctFrom), one, []eval.Expr{nullInt}, types.DTrue},
+		{"NULL IS DISTINCT FROM NULL", compare(eval.IsDistinctFrom), nullInt, []eval.Expr{nullInt}, types.DFalse},
+		{"NULL IS NOT DISTINCT FROM NULL", compare(eval.IsNotDistinctFrom), nullInt, []eval.Expr{nullInt}, types.DTrue},
+		{"1 IN (2, 1)", in(false), one, []eval.Expr{two, one}, types.DTrue},
+		{"1 IN (2, NULL)", in(false), one, []eval.Expr{two, nullInt}, types.DNull},
+		{"1 IN (1, NULL)", in(false), one, []eval.Expr{one, nullInt}, types.DTrue},
+		{"1 NOT IN (2, NULL)", in(true), one, []eval.Expr{two, nullInt}, types.DNull},
+		{"1 NOT IN (2)", in(true), one, []eval.Expr{two}, types.DTrue},
+		{"NULL IN (1)", in(false), nullInt, []eval.Expr{one}, types.DNull},
+	} {
+		e, err := tc.op(tc.l, tc.r)
+		if got, err := run(t, e, err); err != nil || got != tc.want {
+			t.Errorf("%s = %v, %v; want %v", tc.name, got, err, tc.want)
+		}
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		op   string
+		l, r int64
+		typ  *types.T
+		want types.Datum
+		err  string
+	}{
+		{op: "+", l: math.MaxInt16 - 1, r: 1, typ: types.Int2, want: types.DInt(math.MaxInt16)},
+		{op: "+", l: math.MaxInt16, r: 1, typ: types.Int2, err: "smallint out of range"},
+		{op: "-", l: math.MinInt16, r: 1, typ: types.Int2, err: "smallint out of range"},
+		{op: "*", l: 256, r: 128, typ: types.Int2, err: "smallint out of range"},
+		{op: "/", l: math.MinInt16, r: -1, typ: types.Int2, err: "smallint out of range"},
+		{op: "+", l: math.MaxInt32, r: 1, typ: types.Int4, err: "integer out of range"},
+		{op: "-", l: math.MinInt32, r: 1, typ: types.Int4, err: "integer out of range"},
+		{op: "*", l: 65536, r: 32768, typ: types.Int4, err: "integer out of range"},
+		{op: "*", l: 65536, r: -32768, typ: types.Int4, want: types.DInt(math.MinInt32)},
+		{op: "+", l: math.MaxInt32, r: 1, typ: types.Int, want: types.DInt(math.MaxInt32 + 1)},
+		{op: "+", l: math.MaxInt64, r: 1, typ: types.Int, err: "bigint out of range"},
+		{op: "-", l: math.MinInt64, r: 1, typ: types.Int, err: "bigint out of range"},
+		{op: "*", l: math.MaxInt64, r: 2, typ: types.Int, err: "bigint out of range"},
+		{op: "/", l: math.MinInt64, r: -1, typ: types.Int, err: "bigint out of range"},
+		{op: "/", l: -7, r: 2, typ: types.Int, want: types.DInt(-3)},
+		{op: "%", l: -7, r: 2, typ: types.Int, want: types.DInt(-1)},
+	} {
+		e, err := eval.Builtins.NewBinaryExpr(tc.op, typed(types.DInt(tc.l), tc.typ), typed(types.DInt(tc.r), tc.typ))
+		got, err := run(t, e, err)
+		switch {
+		case tc.err != "":
+			if !outOfRange(err, tc.err) {
+				t.Errorf("%d %s %d (%s) = %v, %v; want %q", tc.l, tc.op, tc.r, tc.typ.Name, got, err, tc.err)
+			}
+		case err != nil || got != tc.want:
+			t.Errorf("%d %s %d (%s) = %v, %v; want %v", tc.l, tc.op, tc.r, tc.typ.Name, got, err, tc.want)
+		}
+	}
+}
+
+func TestNegateOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		arg int64
+		typ *types.T
+		err string
+	}{
+		{math.MinInt16, types.Int2, "smallint out of range"},
+		{math.MinInt32, types.Int4, "integer out of range"},
+		{math.MinInt64, types.Int, "bigint out of range"},
+	} {
+		e, err := eval.Builtins.NewUnaryExpr("-", typed(types.DInt(tc.arg), tc.typ))
+		if got, err := run(t, e, err); !outOfRange(err, tc.err) {
+			t.Errorf("-(%d::%s) = %v, %v; want %q", tc.arg, tc.typ.Name, got, err, tc.err)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	for _, tc := range []struct {
+		op string
+		l  eval.Expr
+		r  eval.Expr
+	}{
+		{"/", typed(types.DInt(1), types.Int4), typed(types.DInt(0), types.Int4)},
+		{"%", typed(types.DInt(1), types.Int), typed(types.DInt(0), types.Int)},
+		{"/", typed(types.DFloat(1), types.Float), typed(types.DFloat(0), types.Float)},
+	} {
+		e, err := eval.Builtins.NewBinaryExpr(tc.op, tc.l, tc.r)
+		got, err := run(t, e, err)
+		var pgErr *pgerror.Error
+		if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeDivisionByZero {
+			t.Errorf("%s %s %s = %v, %v; want division by zero", tc.l, tc.op, tc.r, got, err)
+		}
+	}
+	// NULL wins over a zero divisor.
+	e, err := eval.Builtins.NewBinaryExpr("/", typed(types.DNull, types.Int), typed(types.DInt(0), types.Int))
+	if got, err := run(t, e, err); err != nil || got != types.DNull {
+		t.Errorf("NULL / 0 = %v, %v; want NULL", got, err)
+	}
+}
+
+func TestHstoreToJSONB(t *testing.T) {
+	for _, tc := range []struct {
+		hstore, want string
+	}{
+		{``, `{}`},
+		// Shorter keys first, as jsonb orders them, and NULL as null.
+		{`"bb"=>"2", "a"=>"1", "c"=>NULL`, `{"a": "1", "c": null, "bb": "2"}`},
+		// Values stay strings, escaped as JSON.
+		{`"n"=>"1", "q"=>"say \"hi\"", "t"=>"a	b"`, `{"n": "1", "q": "say \"hi\"", "t": "a\tb"}`},
+	} {
+		h, err := types.ParseDHstore(tc.hstore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cast, err := eval.NewCastExpr(typed(h, types.Hstore), types.JSONB)
+		got, err := run(t, cast, err)
+		if err != nil || got != types.DJSONB(tc.want) {
+			t.Errorf("'%s'::hstore::jsonb = %v, %v; want %s", tc.hstore, got, err, tc.want)
+		}
+		fn, err := eval.Builtins.NewFuncExpr("hstore_to_jsonb", []eval.Expr{typed(h, types.Hstore)})
+		if got, err := run(t, fn, err); err != nil || got != types.DJSONB(tc.want) {
+			t.Errorf("hstore_to_jsonb('%s') = %v, %v; want %s", tc.hstore, got, err, tc.want)
+		}
+		// The object is jsonb's own text for it.
+		if parsed, err := types.ParseDJSONB(tc.want); err != nil || parsed != types.DJSONB(tc.want) {
+			t.Errorf("'%s'::jsonb = %v, %v", tc.want, parsed, err)
+		}
+	}
+}
@@ -0,0 +1,532 @@
+// Package eval evaluates typed scalar expressions over datums.
+//
+// Expressions are built by the planner after name resolution and type
+// checking, so every node already knows its result type and, for operators
+// and function calls, the exact overload to invoke. Evaluation follows SQL
+// three-valued logic: NULL inputs propagate unless an operator is defined
+// otherwise (AND, OR, IS NULL, COALESCE, ...).
+package eval
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Expr is a typed scalar expression.
+type Expr interface {
+	// ResolvedType returns the type of values produced by the expression.
+	ResolvedType() *types.T
+	// Eval evaluates the expression against the current row in ctx.
+	Eval(ctx *Context) (types.Datum, error)
+	// String formats the expression as SQL.
+	String() string
+}
+
+// Const is a constant value.
+type Const struct {
+	Datum types.Datum
+	Typ   *types.T
+}
+
+// NewConst returns a constant of the datum's own type.
+func NewConst(d types.Datum) *Const {
+	return &Const{Datum: d, Typ: d.ResolvedType()}
+}
+
+// ColumnRef reads a column of the current row by ordinal.
+type ColumnRef struct {
+	Idx  int
+	Name string
+	Typ  *types.T
+}
+
+// BinaryExpr applies a binary operator such as + or ||.
+type BinaryExpr struct {
+	Op          string
+	Left, Right Expr
+	Fn          *BinOp
+}
+
+// UnaryExpr applies a prefix operator such as unary minus.
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+	Fn      *UnaryOp
+}
+
+// CompareOp is a comparison operator.
+type CompareOp uint8
+
+// Comparison operators.
+const (
+	EQ CompareOp = iota
+	NE
+	LT
+	LE
+	GT
+	GE
+	IsDistinctFrom
+	IsNotDistinctFrom
+)
+
+var compareOpNames = [...]string{
+	EQ:                "=",
+	NE:                "<>",
+	LT:                "<",
+	LE:                "<=",
+	GT:                ">",
+	GE:                ">=",
+	IsDistinctFrom:    "IS DISTINCT FROM",
+	IsNotDistinctFrom: "IS NOT DISTINCT FROM",
+}
+
+func (op CompareOp) String() string { return compareOpNames[op] }
+
+// Commute returns the operator that gives the same result with its
+// operands swapped.
+func (op CompareOp) Commute() CompareOp {
+	switch op {
+	case LT:
+		return GT
+	case LE:
+		return GE
+	case GT:
+		return LT
+	case GE:
+		return LE
+	}
+	return op
+}
+
+// Negate returns the operator whose result is the logical negation of op
+// for non-NULL operands.
+func (op CompareOp) Negate() CompareOp {
+	switch op {
+	case EQ:
+		return NE
+	case NE:
+		return EQ
+	case LT:
+		return GE
+	case LE:
+		return GT
+	case GT:
+		return LE
+	case GE:
+		return LT
+	case IsDistinctFrom:
+		return IsNotDistinctFrom
+	}
+	return IsDistinctFrom
+}
+
+// ComparisonExpr compares two operands of equivalent types.
+type ComparisonExpr struct {
+	Op          CompareOp
+	Left, Right Expr
+}
+
+// RowComparisonExpr compares two rows of the same length, whose operands
+// at each position are of equivalent types. The rows are ordered by their
+// first operands that differ.
+type RowComparisonExpr struct {
+	Op          CompareOp
+	Left, Right []Expr
+}
+
+// AndExpr is a boolean conjunction.
+type AndExpr struct {
+	Left, Right Expr
+}
+
+// OrExpr is a boolean disjunction.
+type OrExpr struct {
+	Left, Right Expr
+}
+
+// NotExpr is a boolean negation.
+type NotExpr struct {
+	Operand Expr
+}
+
+// IsNullExpr tests whether its operand is (or is not) NULL.
+type IsNullExpr struct {
+	Operand Expr
+	Not     bool
+}
+
+// IsBoolExpr implements IS [NOT] TRUE and IS [NOT] FALSE.
+type IsBoolExpr struct {
+	Operand Expr
+	Value   bool
+	Not     bool
+}
+
+// CaseWhen is one WHEN ... THEN ... arm of a CASE expression.
+type CaseWhen struct {
+	Cond Expr
+	Val  Expr
+}
+
+// CaseExpr is a searched or simple CASE expression. When Operand is set,
+// each Cond is compared for equality against it.
+type CaseExpr struct {
+	Operand Expr
+	Whens   []CaseWhen
+	Else    Expr
+	Typ     *types.T
+}
+
+// CoalesceExpr returns its first non-NULL argument.
+type CoalesceExpr struct {
+	Exprs []Expr
+	Typ   *types.T
+}
+
+// NullIfExpr returns NULL if its operands are equal, otherwise Left.
+type NullIfExpr struct {
+	Left, Right Expr
+}
+
+// CastExpr converts its operand to another type.
+type CastExpr struct {
+	Operand Expr
+	Typ     *types.T
+}
+
+// Volatility returns the volatility of the cast, which is stable for casts
+// whose result depends on the session's settings.
+func (e *CastExpr) Volatility() Volatility {
+	return castVolatility(e.Operand.ResolvedType(), e.Typ)
+}
+
+// FuncExpr calls a resolved function overload.
+type FuncExpr struct {
+	Name     string
+	Args     []Expr
+	Overload *Overload
+}
+
+// InListExpr implements expr [NOT] IN (list).
+type InListExpr struct {
+	Operand Expr
+	List    []Expr
+	Not     bool
+}
+
+// LikeExpr implements [NOT] LIKE and ILIKE.
+type LikeExpr struct {
+	Left, Pattern Expr
+	// Escape is nil when the default backslash escape applies.
+	Escape          Expr
+	Not             bool
+	CaseInsensitive bool
+
+	compiled *LikePattern
+}
+
+func (e *Const) ResolvedType() *types.T     { return e.Typ }
+func (e *ColumnRef) ResolvedType() *types.T { return e.Typ }
+func (e *BinaryExpr) ResolvedType() *types.T {
+	return e.Fn.returnType(e.Left.ResolvedType(), e.Right.ResolvedType())
+}
+func (e *UnaryExpr) ResolvedType() *types.T         { return e.Fn.returnType(e.Operand.ResolvedType()) }
+func (e *ComparisonExpr) ResolvedType() *types.T    { return types.Bool }
+func (e *RowComparisonExpr) ResolvedType() *types.T { return types.Bool }
+func (e *AndExpr) ResolvedType() *types.T           { return types.Bool }
+func (e *OrExpr) ResolvedType() *types.T            { return types.Bool }
+func (e *NotExpr) ResolvedType() *types.T           { return types.Bool }
+func (e *IsNullExpr) ResolvedType() *types.T        { return types.Bool }
+func (e *IsBoolExpr) ResolvedType() *types.T        { return types.Bool }
+func (e *CaseExpr) ResolvedType() *types.T          { return e.Typ }
+func (e *CoalesceExpr) ResolvedType() *types.T      { return e.Typ }
+func (e *NullIfExpr) ResolvedType() *types.T        { return e.Left.ResolvedType() }
+func (e *CastExpr) ResolvedType() *types.T          { return e.Typ }
+func (e *InListExpr) ResolvedType() *types.T        { return types.Bool }
+func (e *LikeExpr) ResolvedType() *types.T          { return types.Bool }
+
+func (e *FuncExpr) ResolvedType() *types.T {
+	return e.Overload.returnType(exprTypes(e.Args))
+}
+
+func exprTypes(exprs []Expr) []*types.T {
+	ts := make([]*types.T, len(exprs))
+	for i, e := range exprs {
+		ts[i] = e.ResolvedType()
+	}
+	return ts
+}
+
+func (e *Const) String() string {
+	if e.Datum == types.DNull {
+		return "NULL"
+	}
+	switch e.Typ.Family {
+	case types.IntFamily, types.FloatFamily, types.DecimalFamily, types.BoolFamily:
+		if e.Typ.Family == types.BoolFamily {
+			if e.Datum == types.DTrue {
+				return "true"
+			}
+			return "false"
+		}
+		return e.Datum.String()
+	case types.StringFamily, types.UnknownFamily:
+		return QuoteString(e.Datum.String())
+	}
+	return QuoteString(e.Datum.String()) + "::" + e.Typ.String()
+}
+
+// QuoteString quotes s as a SQL string literal.
+func QuoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func (e *ColumnRef) String() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return fmt.Sprintf("@%d", e.Idx+1)
+}
+
+func (e *BinaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
+}
+
+func (e *UnaryExpr) String() string {
+	return fmt.Sprintf("(%s%s)", e.Op, e.Operand)
+}
+
+func (e *ComparisonExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
+}
+
+func (e *RowComparisonExpr) String() string {
+	return fmt.Sprintf("((%s) %s (%s))", joinExprs(e.Left), e.Op, joinExprs(e.Right))
+}
+
+func (e *AndExpr) String() string { return fmt.Sprintf("(%s AND %s)", e.Left, e.Right) }
+func (e *OrExpr) String() string  { return fmt.Sprintf("(%s OR %s)", e.Left, e.Right) }
+func (e *NotExpr) String() string { return fmt.Sprintf("(NOT %s)", e.Operand) }
+
+func (e *IsNullExpr) String() string {
+	if e.Not {
+		return fmt.Sprintf("(%s IS NOT NULL)", e.Operand)
+	}
+	return fmt.Sprintf("(%s IS NULL)", e.Operand)
+}
+
+func (e *IsBoolExpr) String() string {
+	not := ""
+	if e.Not {
+		not = "NOT "
+	}
+	return fmt.Sprintf("(%s IS %s%s)", e.Operand, not, strings.ToUpper(fmt.Sprint(e.Value)))
+}
+
+func (e *CaseExpr) String() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	if e.Operand != nil {
+		fmt.Fprintf(&b, " %s", e.Operand)
+	}
+	for _, w := range e.Whens {
+		fmt.Fprintf(&b, " WHEN %s THEN %s", w.Cond, w.Val)
+	}
+	if e.Else != nil {
+		fmt.Fprintf(&b, " ELSE %s", e.Else)
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+func (e *CoalesceExpr) String() string {
+	return "COALESCE(" + joinExprs(e.Exprs) + ")"
+}
+
+func (e *NullIfExpr) String() string {
+	return fmt.Sprintf("NULLIF(%s, %s)", e.Left, e.Right)
+}
+
+func (e *CastExpr) String() string {
+	return fmt.Sprintf("%s::%s", e.Operand, e.Typ)
+}
+
+func (e *FuncExpr) String() string {
+	return e.Name + "(" + joinExprs(e.Args) + ")"
+}
+
+func (e *InListExpr) String() string {
+	not := ""
+	if e.Not {
+		not = "NOT "
+	}
+	return fmt.Sprintf("(%s %sIN (%s))", e.Operand, not, joinExprs(e.List))
+}
+
+func (e *LikeExpr) String() string {
+	op := "LIKE"
+	if e.CaseInsensitive {
+		op = "ILIKE"
+	}
+	if e.Not {
+		op = "NOT " + op
+	}
+	if e.Escape != nil {
+		return fmt.Sprintf("(%s %s %s ESCAPE %s)", e.Left, op, e.Pattern, e.Escape)
+	}
+	return fmt.Sprintf("(%s %s %s)", e.Left, op, e.Pattern)
+}
+
+func joinExprs(exprs []Expr) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Walk calls fn for e and, while fn returns true, for each of its
+// descendants in depth-first order.
+func Walk(e Expr, fn func(Expr) bool) {
+	if e == nil || !fn(e) {
+		return
+	}
+	for _, c := range Children(e) {
+		Walk(c, fn)
+	}
+}
+
+// Children returns the direct sub-expressions of e.
+func Children(e Expr) []Expr {
+	switch t := e.(type) {
+	case *BinaryExpr:
+		return []Expr{t.Left, t.Right}
+	case *UnaryExpr:
+		return []Expr{t.Operand}
+	case *ComparisonExpr:
+		return []Expr{t.Left, t.Right}
+	case *RowComparisonExpr:
+		return append(append([]Expr(nil), t.Left...), t.Right...)
+	case *AndExpr:
+		return []Expr{t.Left, t.Right}
+	case *OrExpr:
+		return []Expr{t.Left, t.Right}
+	case *NotExpr:
+		return []Expr{t.Operand}
+	case *IsNullExpr:
+		return []Expr{t.Operand}
+	case *IsBoolExpr:
+		return []Expr{t.Operand}
+	case *CaseExpr:
+		var out []Expr
+		if t.Operand != nil {
+			out = append(out, t.Operand)
+		}
+		for _, w := range t.Whens {
+			out = append(out, w.Cond, w.Val)
+		}
+		if t.Else != nil {
+			out = append(out, t.Else)
+		}
+		return out
+	case *CoalesceExpr:
+		return t.Exprs
+	case *NullIfExpr:
+		return []Expr{t.Left, t.Right}
+	case *CastExpr:
+		return []Expr{t.Operand}
+	case *FuncExpr:
+		return t.Args
+	case *InListExpr:
+		return append([]Expr{t.Operand}, t.List...)
+	case *LikeExpr:
+		if t.Escape != nil {
+			return []Expr{t.Left, t.Pattern, t.Escape}
+		}
+		return []Expr{t.Left, t.Pattern}
+	case *SubqueryExpr:
+		if t.Left != nil {
+			return []Expr{t.Left}
+		}
+	}
+	return nil
+}
+
+// WithChildren returns a copy of e whose direct sub-expressions are
+// replaced by children, given in the order Children returns them.
+func WithChildren(e Expr, children []Expr) Expr {
+	switch t := e.(type) {
+	case *BinaryExpr:
+		c := *t
+		c.Left, c.Right = children[0], children[1]
+		return &c
+	case *UnaryExpr:
+		c := *t
+		c.Operand = children[0]
+		return &c
+	case *ComparisonExpr:
+		c := *t
+		c.Left, c.Right = children[0], children[1]
+		return &c
+	case *RowComparisonExpr:
+		n := len(t.Left)
+		return &RowComparisonExpr{Op: t.Op, Left: children[:n:n], Right: children[n:]}
+	case *AndExpr:
+		return &AndExpr{Left: children[0], Right: children[1]}
+	case *OrExpr:
+		return &OrExpr{Left: children[0], Right: children[1]}
+	case *NotExpr:
+		return &NotExpr{Operand: children[0]}
+	case *IsNullExpr:
+		c := *t
+		c.Operand = children[0]
+		return &c
+	case *IsBoolExpr:
+		c := *t
+		c.Operand = children[0]
+		return &c
+	case *CaseExpr:
+		c := *t
+		if c.Operand != nil {
+			c.Operand, children = children[0], children[1:]
+		}
+		c.Whens = make([]CaseWhen, len(t.Whens))
+		for i := range c.Whens {
+			c.Whens[i] = CaseWhen{Cond: children[2*i], Val: children[2*i+1]}
+		}
+		if c.Else != nil {
+			c.Else = children[2*len(c.Whens)]
+		}
+		return &c
+	case *CoalesceExpr:
+		return &CoalesceExpr{Exprs: children, Typ: t.Typ}
+	case *NullIfExpr:
+		return &NullIfExpr{Left: children[0], Right: children[1]}
+	case *CastExpr:
+		return &CastExpr{Operand: children[0], Typ: t.Typ}
+	case *FuncExpr:
+		c := *t
+		c.Args = children
+		return &c
+	case *InListExpr:
+		c := *t
+		c.Operand, c.List = children[0], children[1:]
+		return &c
+	case *LikeExpr:
+		c := *t
+		c.Left, c.Pattern = children[0], children[1]
+		if c.Escape != nil {
+			c.Escape = children[2]
+		}
+		return &c
+	case *SubqueryExpr:
+		c := *t
+		if c.Left != nil {
+			c.Left = children[0]
+		}
+		return &c
+	}
+	return e
+}
@@ -0,0 +1,222 @@
+package eval
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// likeElem is one element of a compiled LIKE pattern.
+type likeElem struct {
+	kind likeKind
+	lit  rune
+}
+
+type likeKind uint8
+
+const (
+	likeLiteral likeKind = iota
+	likeAnyOne           // _
+	likeAnySeq           // %
+)
+
+// LikePattern is a compiled LIKE pattern.
+type LikePattern struct {
+	elems           []likeElem
+	caseInsensitive bool
+}
+
+// likeEscape validates an ESCAPE argument and returns the escape rune, or
+// -1 when escaping is disabled by an empty string.
+func likeEscape(esc string) (rune, error) {
+	switch utf8.RuneCountInString(esc) {
+	case 0:
+		return -1, nil
+	case 1:
+		r, _ := utf8.DecodeRuneInString(esc)
+		return r, nil
+	}
+	return 0, pgerror.New(pgerror.CodeInvalidEscapeSequence, "invalid escape string").
+		WithHint("Escape string must be empty or one character.")
+}
+
+// CompileLike compiles a LIKE pattern. esc is the escape character string
+// from an ESCAPE clause; the default is a backslash.
+func CompileLike(pattern, esc string, caseInsensitive bool) (*LikePattern, error) {
+	escape, err := likeEscape(esc)
+	if err != nil {
+		return nil, err
+	}
+	if caseInsensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	p := &LikePattern{caseInsensitive: caseInsensitive}
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == escape:
+			i++
+			if i == len(runes) {
+				return nil, pgerror.New(pgerror.CodeInvalidEscapeSequence,
+					"LIKE pattern must not end with escape character")
+			}
+			p.elems = append(p.elems, likeElem{kind: likeLiteral, lit: runes[i]})
+		case r == '%':
+			// Consecutive %s are equivalent to one.
+			if n := len(p.elems); n == 0 || p.elems[n-1].kind != likeAnySeq {
+				p.elems = append(p.elems, likeElem{kind: likeAnySeq})
+			}
+		case r == '_':
+			p.elems = append(p.elems, likeElem{kind: likeAnyOne})
+		default:
+			p.elems = append(p.elems, likeElem{kind: likeLiteral, lit: r})
+		}
+	}
+	return p, nil
+}
+
+// Match reports whether s matches the pattern.
+func (p *LikePattern) Match(s string) bool {
+	if p.caseInsensitive {
+		s = strings.ToLower(s)
+	}
+	str := []rune(s)
+	// Greedy matching with backtracking to the most recent %, which runs in
+	// O(len(s) * len(pattern)) in the worst case.
+	si, pi := 0, 0
+	starP, starS := -1, 0
+	for si < len(str) {
+		if pi < len(p.elems) {
+			e := p.elems[pi]
+			switch {
+			case e.kind == likeAnySeq:
+				starP, starS = pi, si
+				pi++
+				continue
+			case e.kind == likeAnyOne || e.lit == str[si]:
+				si++
+				pi++
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starS++
+		si, pi = starS, starP+1
+	}
+	for pi < len(p.elems) && p.elems[pi].kind == likeAnySeq {
+		pi++
+	}
+	return pi == len(p.elems)
+}
+
+// Prefix returns the literal text every match must start with, and whether
+// the pattern matches only that exact text. Case-insensitive patterns have
+// no usable prefix.
+func (p *LikePattern) Prefix() (prefix string, exact bool) {
+	if p.caseInsensitive {
+		return "", false
+	}
+	var b strings.Builder
+	for _, e := range p.elems {
+		if e.kind != likeLiteral {
+			return b.String(), false
+		}
+		b.WriteRune(e.lit)
+	}
+	return b.String(), true
+}
+
+// pattern returns the compiled pattern for the current row, reusing the
+// one compiled at plan time when the pattern and escape are constant.
+func (e *LikeExpr) pattern(ctx *Context) (*LikePattern, bool, error) {
+	if e.compiled != nil {
+		return e.compiled, true, nil
+	}
+	pat, err := e.Pattern.Eval(ctx)
+	if err != nil || pat == types.DNull {
+		return nil, false, err
+	}
+	esc := `\`
+	if e.Escape != nil {
+		d, err := e.Escape.Eval(ctx)
+		if err != nil || d == types.DNull {
+			return nil, false, err
+		}
+		esc = string(d.(types.DString))
+	}
+	p, err := CompileLike(string(pat.(types.DString)), esc, e.CaseInsensitive)
+	return p, err == nil, err
+}
+
+func (e *LikeExpr) Eval(ctx *Context) (types.Datum, error) {
+	d, err := e.Left.Eval(ctx)
+	if err != nil || d == types.DNull {
+		return d, err
+	}
+	p, ok, err := e.pattern(ctx)
+	if err != nil || !ok {
+		return types.DNull, err
+	}
+	return types.MakeDBool(p.Match(string(d.(types.DString))) != e.Not), nil
+}
+
+// Compiled returns the pattern compiled at plan time, or nil when the
+// pattern is not constant.
+func (e *LikeExpr) Compiled() *LikePattern {
+	return e.compiled
+}
+
+// NewLikeExpr type checks left [NOT] LIKE/ILIKE pattern [ESCAPE escape].
+// escape may be nil. Constant patterns are compiled once here.
+func NewLikeExpr(left, pattern, escape Expr, not, caseInsensitive bool) (Expr, error) {
+	op := "~~"
+	if caseInsensitive {
+		op = "~~*"
+	}
+	for _, x := range []Expr{left, pattern} {
+		switch x.ResolvedType().Family {
+		case types.StringFamily, types.UnknownFamily:
+		case types.CITextFamily:
+			// citext's LIKE operators ignore case.
+			caseInsensitive = true
+		default:
+			return nil, pgerror.Newf(pgerror.CodeUndefinedFunction, "operator does not exist: %s %s %s",
+				left.ResolvedType(), op, pattern.ResolvedType()).
+				WithHint("No operator matches the given name and argument types. You might need to add explicit type casts.")
+		}
+	}
+	var err error
+	if left, err = Coerce(left, types.String); err != nil {
+		return nil, err
+	}
+	if pattern, err = Coerce(pattern, types.String); err != nil {
+		return nil, err
+	}
+	if escape != nil {
+		if escape, err = Coerce(escape, types.String); err != nil {
+			return nil, err
+		}
+	}
+	e := &LikeExpr{Left: left, Pattern: pattern, Escape: escape, Not: not, CaseInsensitive: caseInsensitive}
+	pc, ok := pattern.(*Const)
+	if !ok || pc.Datum == types.DNull {
+		return e, nil
+	}
+	esc := `\`
+	if escape != nil {
+		ec, ok := escape.(*Const)
+		if !ok || ec.Datum == types.DNull {
+			return e, nil
+		}
+		esc = string(ec.Datum.(types.DString))
+	}
+	if e.compiled, err = CompileLike(string(pc.Datum.(types.DString)), esc, caseInsensitive); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,418 @@
+package eval
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// numberKeywords are the patterns of PostgreSQL's number templates,
+// matched case-insensitively; the longest match wins.
+var numberKeywords = []string{
+	"9", "0", ".", ",", "D", "G", "S", "L", "MI", "PL", "SG", "PR", "RN", "TH", "V", "EEEE",
+}
+
+// numberNode is one element of a parsed number template.
+type numberNode struct {
+	// key is the upper-case keyword, or empty for literal text.
+	key string
+	// text is the keyword as written, or the literal text.
+	text string
+	// fraction is set on digit positions after the decimal point.
+	fraction bool
+}
+
+// numberFormat is a parsed number template.
+type numberFormat struct {
+	nodes []numberNode
+	// pre and post count the digit positions before and after the
+	// decimal point.
+	pre, post int
+	// zeroStart is the first integer position of a 0 pattern, from where
+	// leading zeros are written, or -1.
+	zeroStart int
+	// multiplier counts the digits after V, which shift the value left.
+	multiplier int
+	fill       bool
+	decimal    bool
+	// leadingSign is set when S precedes the digits, trailingSign when
+	// it follows them.
+	leadingSign, trailingSign bool
+	// minus and plus are set by MI, PL and SG, which write the sign in
+	// place.
+	minus, plus bool
+	bracket     bool
+	eeee, roman bool
+}
+
+// parseNumberFormat parses a to_char or to_number template for numbers.
+func parseNumberFormat(format string) (*numberFormat, error) {
+	f := &numberFormat{zeroStart: -1}
+	syntaxError := func(msg string) error {
+		return pgerror.New(pgerror.CodeSyntaxError, msg)
+	}
+	digits, afterV := false, false
+	for i := 0; i < len(format); {
+		rest := format[i:]
+		if hasPrefixFold(rest, "FM") {
+			f.fill = true
+			i += 2
+			continue
+		}
+		key := ""
+		for _, k := range numberKeywords {
+			if len(k) > len(key) && hasPrefixFold(rest, k) {
+				key = k
+			}
+		}
+		if key == "" {
+			text := format[i : i+1]
+			i++
+			if text == `"` {
+				end := strings.IndexByte(format[i:], '"')
+				if end < 0 {
+					end = len(format) - i
+				}
+				text = format[i : i+end]
+				i = min(i+end+1, len(format))
+			}
+			f.nodes = append(f.nodes, numberNode{text: text})
+			continue
+		}
+		n := numberNode{key: key, text: rest[:len(key)]}
+		i += len(key)
+		if f.eeee {
+			return nil, syntaxError(`"EEEE" must be the last pattern used`)
+		}
+		switch key {
+		case "9", "0":
+			if f.decimal {
+				n.fraction = true
+				f.post++
+				break
+			}
+			if key == "0" && f.zeroStart < 0 {
+				f.zeroStart = f.pre
+			}
+			if afterV {
+				f.multiplier++
+			}
+			f.pre++
+			digits = true
+		case ".", "D":
+			if f.decimal {
+				return nil, syntaxError("multiple decimal points")
+			}
+			if afterV {
+				return nil, syntaxError(`cannot use "V" and decimal point together`)
+			}
+			f.decimal = true
+		case "V":
+			if f.decimal {
+				return nil, syntaxError(`cannot use "V" and decimal point together`)
+			}
+			afterV = true
+		case "S":
+			if f.leadingSign || f.trailingSign {
+				return nil, syntaxError(`cannot use "S" twice`)
+			}
+			if f.minus || f.plus || f.bracket {
+				return nil, syntaxError(`cannot use "S" and "PL"/"MI"/"SG"/"PR" together`)
+			}
+			if digits || f.decimal {
+				f.trailingSign = true
+			} else {
+				f.leadingSign = true
+			}
+		case "MI", "PL", "SG":
+			if f.leadingSign || f.trailingSign || f.bracket {
+				return nil, syntaxError(`cannot use "S" and "PL"/"MI"/"SG"/"PR" together`)
+			}
+			f.minus = f.minus || key != "PL"
+			f.plus = f.plus || key != "MI"
+		case "PR":
+			if f.leadingSign || f.trailingSign || f.minus || f.plus {
+				return nil, syntaxError(`cannot use "PR" and "S"/"PL"/"MI"/"SG" together`)
+			}
+			f.bracket = true
+		case "RN":
+			f.roman = true
+		case "EEEE":
+			if f.roman || f.bracket || f.minus || f.plus || f.leadingSign || f.trailingSign || afterV {
+				return nil, syntaxError(`"EEEE" is incompatible with other formats`).(*pgerror.Error).
+					WithDetail(`"EEEE" may only be used together with digit and decimal point patterns.`)
+			}
+			f.eeee = true
+		}
+		f.nodes = append(f.nodes, n)
+	}
+	return f, nil
+}
+
+// format formats d, or writes # in every digit position when d is nil,
+// as to_char does for values that cannot be shown.
+func (f *numberFormat) format(d *types.Dec) string {
+	if f.roman {
+		return f.formatRoman(d)
+	}
+	if f.eeee {
+		if d == nil {
+			return f.formatDigits(nil, "")
+		}
+		mantissa, exp := scientific(d, f.post)
+		return f.formatDigits(mantissa, fmt.Sprintf("e%+03d", exp))
+	}
+	return f.formatDigits(d, "")
+}
+
+// scientific splits d into a mantissa rounded to post fractional digits,
+// with one integer digit, and a power of ten.
+func scientific(d *types.Dec, post int) (*types.Dec, int) {
+	if d.IsZero() {
+		return d.Round(int32(post)), 0
+	}
+	digits := len(new(big.Int).Abs(&d.Coeff).String())
+	exp := digits - 1 - int(d.Scale)
+	m := &types.Dec{Scale: int32(digits - 1)}
+	m.Coeff.Set(&d.Coeff)
+	m = m.Round(int32(post))
+	if m.Abs().Digits() > 1 {
+		// Rounding carried into a second integer digit, as 9.99 to 10.0.
+		m = (&types.Dec{Coeff: m.Coeff, Scale: m.Scale + 1}).Round(int32(post))
+		exp++
+	}
+	return m, exp
+}
+
+func (f *numberFormat) formatRoman(d *types.Dec) string {
+	s := strings.Repeat("#", 15)
+	if d != nil {
+		if v, ok := d.Int64(); ok && v >= 1 && v <= 3999 {
+			s = romanNumeral(int(v))
+		}
+	}
+	for _, n := range f.nodes {
+		if n.key == "RN" && n.text == "rn" {
+			s = strings.ToLower(s)
+		}
+	}
+	if !f.fill {
+		s = fmt.Sprintf("%15s", s)
+	}
+	return s
+}
+
+func romanNumeral(v int) string {
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	numerals := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var b strings.Builder
+	for i, n := range values {
+		for v >= n {
+			b.WriteString(numerals[i])
+			v -= n
+		}
+	}
+	return b.String()
+}
+
+// formatDigits writes d, or # signs if it is nil, into the template, with
+// exp in place of EEEE.
+func (f *numberFormat) formatDigits(d *types.Dec, exp string) string {
+	var intDigits, fracDigits string
+	neg := false
+	if d != nil {
+		if f.multiplier > 0 {
+			d = d.Mul(&types.Dec{Coeff: *new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(f.multiplier)), nil)})
+		}
+		d = d.Round(int32(f.post))
+		neg = d.Sign() < 0
+		intDigits, fracDigits, _ = strings.Cut(d.Abs().String(), ".")
+		if intDigits == "0" && f.pre == 0 {
+			intDigits = ""
+		}
+	}
+	if d == nil || len(intDigits) > f.pre {
+		intDigits, fracDigits = strings.Repeat("#", f.pre), strings.Repeat("#", f.post)
+		neg = false
+	}
+	preSpaces := f.pre - len(intDigits)
+	// A lone zero before the decimal point is left blank unless a 0
+	// pattern asks for it, so 0.5 is .50 for 9.99.
+	blankZero := intDigits == "0" && f.zeroStart < 0 && f.post > 0
+	// With FM, 9 positions after the last significant fractional digit
+	// are dropped.
+	keep := 0
+	j := 0
+	for _, n := range f.nodes {
+		if n.fraction {
+			if n.key == "0" || fracDigits[j] != '0' {
+				keep = j + 1
+			}
+			j++
+		}
+	}
+
+	var b strings.Builder
+	signWritten := f.minus || f.trailingSign || f.fill && !neg && !f.leadingSign
+	writeSign := func() {
+		if signWritten {
+			return
+		}
+		signWritten = true
+		switch {
+		case f.leadingSign && neg:
+			b.WriteByte('-')
+		case f.leadingSign:
+			b.WriteByte('+')
+		case f.bracket && neg:
+			b.WriteByte('<')
+		case neg:
+			b.WriteByte('-')
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	started := false
+	pos, frac := 0, 0
+	for _, n := range f.nodes {
+		switch n.key {
+		case "":
+			b.WriteString(n.text)
+		case "9", "0":
+			if n.fraction {
+				if !f.fill || frac < keep {
+					b.WriteByte(fracDigits[frac])
+				}
+				frac++
+				break
+			}
+			k := pos
+			pos++
+			if k < preSpaces && (f.zeroStart < 0 || k < f.zeroStart) {
+				if !f.fill {
+					b.WriteByte(' ')
+				}
+				break
+			}
+			if blankZero && k == f.pre-1 {
+				if !f.fill {
+					b.WriteByte(' ')
+				}
+				break
+			}
+			writeSign()
+			if k < preSpaces {
+				b.WriteByte('0')
+			} else {
+				b.WriteByte(intDigits[k-preSpaces])
+			}
+			started = true
+		case ".", "D":
+			writeSign()
+			b.WriteByte('.')
+		case ",", "G":
+			if started {
+				b.WriteByte(',')
+			} else if !f.fill {
+				b.WriteByte(' ')
+			}
+		case "S":
+			if f.trailingSign {
+				b.WriteByte("+-"[boolInt(neg)])
+			}
+		case "MI":
+			if neg {
+				b.WriteByte('-')
+			} else if !f.fill {
+				b.WriteByte(' ')
+			}
+		case "PL":
+			if !neg {
+				b.WriteByte('+')
+			} else if !f.fill {
+				b.WriteByte(' ')
+			}
+		case "SG":
+			b.WriteByte("+-"[boolInt(neg)])
+		case "PR":
+			if neg {
+				b.WriteByte('>')
+			} else if !f.fill {
+				b.WriteByte(' ')
+			}
+		case "L":
+			// The C locale has no currency symbol, for which PostgreSQL
+			// writes a blank.
+			b.WriteByte(' ')
+		case "TH":
+			if d != nil && intDigits != "" && intDigits[0] != '#' {
+				v, _ := new(big.Int).SetString(intDigits, 10)
+				b.WriteString(ordinalSuffix(new(big.Int).Rem(v, big.NewInt(100)).Int64(), n.text == "TH"))
+			}
+		case "EEEE":
+			if d == nil {
+				b.WriteString("####")
+			} else {
+				b.WriteString(exp)
+			}
+		}
+	}
+	return b.String()
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseNumber reads s as to_number does: digits fill the template's digit
+// positions, the decimal point and signs are recognized, and any other
+// text is skipped.
+func (f *numberFormat) parseNumber(s string) (*types.Dec, error) {
+	var intDigits, fracDigits strings.Builder
+	neg, point := false, false
+loop:
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			if point {
+				if fracDigits.Len() < f.post {
+					fracDigits.WriteByte(c)
+				}
+			} else if intDigits.Len() < f.pre {
+				intDigits.WriteByte(c)
+			}
+		case c == '.':
+			if !f.decimal {
+				break loop
+			}
+			point = true
+		case c == '-' || c == '<' && f.bracket:
+			neg = true
+		}
+	}
+	if intDigits.Len()+fracDigits.Len() == 0 {
+		return nil, pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type numeric: %q", " ")
+	}
+	text := intDigits.String()
+	if fracDigits.Len() > 0 {
+		text += "." + fracDigits.String()
+	}
+	if neg {
+		text = "-" + text
+	}
+	d, err := types.ParseDec(text)
+	if err != nil {
+		return nil, err
+	}
+	if f.multiplier > 0 {
+		d = &types.Dec{Coeff: d.Coeff, Scale: d.Scale + int32(f.multiplier)}
+	}
+	return d, nil
+}
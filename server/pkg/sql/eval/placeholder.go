@@ -0,0 +1,49 @@
+package eval
+
+import (
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// PlaceholderTypes are the types of the parameters of a prepared
+// statement, nil where a type is not yet known. A parameter whose type is
+// not declared takes the type it is first coerced or cast to.
+type PlaceholderTypes []*types.T
+
+// Placeholder is the parameter $N of a prepared statement, whose value is
+// supplied when the statement is executed. Idx is N-1.
+type Placeholder struct {
+	Idx int
+	// Types holds the types of all parameters of the statement, which
+	// the placeholders of a parameter share, so that inferring the type
+	// of one types them all.
+	Types *PlaceholderTypes
+}
+
+func (e *Placeholder) Eval(ctx *Context) (types.Datum, error) {
+	if e.Idx >= len(ctx.Placeholders) {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedParameter, "no value found for parameter %d", e.Idx+1)
+	}
+	return ctx.Placeholders[e.Idx], nil
+}
+
+func (e *Placeholder) ResolvedType() *types.T {
+	if t := (*e.Types)[e.Idx]; t != nil {
+		return t
+	}
+	return types.Unknown
+}
+
+func (e *Placeholder) String() string { return fmt.Sprintf("$%d", e.Idx+1) }
+
+// infer types the placeholder e as typ if its type is not yet known, and
+// reports whether it did.
+func (e *Placeholder) infer(typ *types.T) bool {
+	if (*e.Types)[e.Idx] != nil {
+		return false
+	}
+	(*e.Types)[e.Idx] = typ
+	return true
+}
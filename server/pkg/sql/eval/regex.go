@@ -0,0 +1,429 @@
+package eval
+
+import (
+	"container/list"
+	"errors"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// regexCacheSize matches the number of compiled patterns PostgreSQL keeps
+// per backend.
+const regexCacheSize = 32
+
+// regexOptions are the flags accepted by the regexp_* functions.
+type regexOptions struct {
+	caseInsensitive bool
+	// newline makes ^ and $ match at line boundaries and keeps . from
+	// matching a newline.
+	newline bool
+	// literal treats the whole pattern as a literal string.
+	literal bool
+	global  bool
+}
+
+type regexKey struct {
+	pattern string
+	opts    regexOptions
+}
+
+// RegexCache keeps the most recently used compiled regular expressions. It
+// is not safe for concurrent use; each session owns one.
+type RegexCache struct {
+	entries map[regexKey]*list.Element
+	lru     list.List
+}
+
+type regexEntry struct {
+	key regexKey
+	re  *regexp.Regexp
+}
+
+// NewRegexCache returns an empty cache.
+func NewRegexCache() *RegexCache {
+	return &RegexCache{entries: make(map[regexKey]*list.Element)}
+}
+
+func (c *RegexCache) get(key regexKey) (*regexp.Regexp, error) {
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*regexEntry).re, nil
+	}
+	re, err := compileRegex(key.pattern, key.opts)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[key] = c.lru.PushFront(&regexEntry{key: key, re: re})
+	if c.lru.Len() > regexCacheSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*regexEntry).key)
+	}
+	return re, nil
+}
+
+// regex returns the compiled form of a PostgreSQL regular expression,
+// using the session cache when there is one.
+func regex(ctx *Context, pattern string, opts regexOptions) (*regexp.Regexp, error) {
+	opts.global = false // does not affect compilation
+	if ctx != nil && ctx.Regexps != nil {
+		return ctx.Regexps.get(regexKey{pattern, opts})
+	}
+	return compileRegex(pattern, opts)
+}
+
+func compileRegex(pattern string, opts regexOptions) (*regexp.Regexp, error) {
+	var src string
+	if rest, ok := strings.CutPrefix(pattern, "***="); ok || opts.literal {
+		if !ok {
+			rest = pattern
+		}
+		src = regexp.QuoteMeta(rest)
+	} else {
+		var err error
+		if src, err = translateRegex(pattern); err != nil {
+			return nil, err
+		}
+	}
+	// PostgreSQL's default is for . to match newlines and for ^ and $ to
+	// anchor only at the ends of the string.
+	prefix := "(?s)"
+	if opts.newline {
+		prefix = "(?m)"
+	}
+	if opts.caseInsensitive {
+		prefix += "(?i)"
+	}
+	re, err := regexp.Compile(prefix + src)
+	if err != nil {
+		msg := err.Error()
+		var serr *syntax.Error
+		if errors.As(err, &serr) {
+			msg = string(serr.Code)
+		}
+		return nil, invalidRegex(msg)
+	}
+	return re, nil
+}
+
+func invalidRegex(msg string) error {
+	return pgerror.Newf(pgerror.CodeInvalidRegularExpression, "invalid regular expression: %s", msg)
+}
+
+// translateRegex rewrites the parts of PostgreSQL's advanced regular
+// expression syntax that differ from Go's RE2 syntax. Features RE2 cannot
+// express, such as back references and lookaround, are reported as errors.
+func translateRegex(pattern string) (string, error) {
+	var b strings.Builder
+	inBracket := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if inBracket {
+			switch {
+			case strings.HasPrefix(pattern[i:], "[[:<:]]") || strings.HasPrefix(pattern[i:], "[[:>:]]"):
+				return "", invalidRegex("word boundary classes are not supported inside brackets")
+			case strings.HasPrefix(pattern[i:], "[:"):
+				end := strings.Index(pattern[i+2:], ":]")
+				if end < 0 {
+					return "", invalidRegex("brackets [] not balanced")
+				}
+				b.WriteString(pattern[i : i+2+end+2])
+				i += 2 + end + 1
+			case c == '\\' && i+1 < len(pattern):
+				b.WriteString(pattern[i : i+2])
+				i++
+			case c == ']':
+				b.WriteByte(c)
+				inBracket = false
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		}
+		switch c {
+		case '[':
+			if strings.HasPrefix(pattern[i:], "[[:<:]]") || strings.HasPrefix(pattern[i:], "[[:>:]]") {
+				b.WriteString(`\b`)
+				i += len("[[:<:]]") - 1
+				continue
+			}
+			b.WriteByte(c)
+			inBracket = true
+			// A ] right after [ or [^ is a literal member.
+			if i+1 < len(pattern) && pattern[i+1] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+			if i+1 < len(pattern) && pattern[i+1] == ']' {
+				b.WriteString(`\]`)
+				i++
+			}
+		case '(':
+			if strings.HasPrefix(pattern[i:], "(?=") || strings.HasPrefix(pattern[i:], "(?!") ||
+				strings.HasPrefix(pattern[i:], "(?<=") || strings.HasPrefix(pattern[i:], "(?<!") {
+				return "", invalidRegex("lookahead and lookbehind constraints are not supported")
+			}
+			b.WriteByte(c)
+		case '\\':
+			if i+1 >= len(pattern) {
+				return "", invalidRegex("invalid escape \\ sequence")
+			}
+			i++
+			switch e := pattern[i]; e {
+			case 'm', 'M', 'y':
+				b.WriteString(`\b`)
+			case 'Y':
+				b.WriteString(`\B`)
+			case 'Z':
+				b.WriteString(`\z`)
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if i+1+n > len(pattern) {
+					return "", invalidRegex("invalid escape \\ sequence")
+				}
+				b.WriteString(`\x{` + pattern[i+1:i+1+n] + `}`)
+				i += n
+			case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				return "", invalidRegex("back references are not supported")
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if inBracket {
+		return "", invalidRegex("brackets [] not balanced")
+	}
+	return b.String(), nil
+}
+
+// parseRegexFlags parses the flags argument of the regexp_* functions.
+func parseRegexFlags(flags string) (regexOptions, error) {
+	var o regexOptions
+	for _, f := range flags {
+		switch f {
+		case 'i':
+			o.caseInsensitive = true
+		case 'c':
+			o.caseInsensitive = false
+		case 'n', 'm', 'p', 'w':
+			o.newline = true
+		case 's':
+			o.newline = false
+		case 'q':
+			o.literal = true
+		case 'g':
+			o.global = true
+		case 'b', 'e', 't', 'x':
+			return o, pgerror.Newf(pgerror.CodeFeatureNotSupported,
+				"regular expression option %q is not supported", string(f))
+		default:
+			return o, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"invalid regular expression option: %q", string(f))
+		}
+	}
+	return o, nil
+}
+
+// expandReplacement appends repl to dst with \1 ... \9 replaced by the
+// corresponding submatch and \& by the whole match.
+func expandReplacement(dst []byte, repl, src string, m []int) []byte {
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		if c != '\\' || i+1 >= len(repl) {
+			dst = append(dst, c)
+			continue
+		}
+		i++
+		n := -1
+		switch d := repl[i]; {
+		case d == '&':
+			n = 0
+		case d >= '1' && d <= '9':
+			n = int(d - '0')
+		default:
+			// \\ is a literal backslash; other escapes are kept as is.
+			if d != '\\' {
+				dst = append(dst, '\\')
+			}
+			dst = append(dst, d)
+			continue
+		}
+		if 2*n+1 < len(m) && m[2*n] >= 0 {
+			dst = append(dst, src[m[2*n]:m[2*n+1]]...)
+		}
+	}
+	return dst
+}
+
+// similarToRegex translates a SQL SIMILAR TO pattern into a regular
+// expression anchored at both ends. esc is the escape character, or -1
+// for none.
+func similarToRegex(pattern string, esc rune) string {
+	var b strings.Builder
+	b.WriteString("^(?:")
+	afterEscape, inBracket := false, false
+	for _, c := range pattern {
+		switch {
+		case afterEscape:
+			if unicode.IsLetter(c) || unicode.IsDigit(c) {
+				b.WriteRune(c)
+			} else {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+			}
+			afterEscape = false
+		case c == esc:
+			afterEscape = true
+		case inBracket:
+			if c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(c)
+			inBracket = c != ']'
+		case c == '[':
+			b.WriteRune(c)
+			inBracket = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteByte('.')
+		case c == '(':
+			b.WriteString("(?:")
+		case c == '\\' || c == '.' || c == '^' || c == '$':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	b.WriteString(")$")
+	return b.String()
+}
+
+func regexMatchOp(caseInsensitive, not bool) func(*Context, types.Datum, types.Datum) (types.Datum, error) {
+	return func(ctx *Context, l, r types.Datum) (types.Datum, error) {
+		re, err := regex(ctx, string(r.(types.DString)), regexOptions{caseInsensitive: caseInsensitive})
+		if err != nil {
+			return nil, err
+		}
+		return types.MakeDBool(re.MatchString(string(l.(types.DString))) != not), nil
+	}
+}
+
+func init() {
+	r := Builtins
+
+	for _, op := range []struct {
+		name    string
+		ci, not bool
+	}{{"~", false, false}, {"~*", true, false}, {"!~", false, true}, {"!~*", true, true}} {
+		r.RegisterBinOp(op.name, &BinOp{Left: types.String, Right: types.String, ReturnType: types.Bool,
+			Fn: regexMatchOp(op.ci, op.not)})
+	}
+
+	regexpMatch := func(ctx *Context, args []types.Datum) (types.Datum, error) {
+		flags := ""
+		if len(args) == 3 {
+			flags = string(args[2].(types.DString))
+		}
+		opts, err := parseRegexFlags(flags)
+		if err != nil {
+			return nil, err
+		}
+		if opts.global {
+			return nil, pgerror.New(pgerror.CodeInvalidParameterValue,
+				`regexp_match() does not support the "global" option`).
+				WithHint("Use the regexp_matches function instead.")
+		}
+		re, err := regex(ctx, string(args[1].(types.DString)), opts)
+		if err != nil {
+			return nil, err
+		}
+		src := string(args[0].(types.DString))
+		m := re.FindStringSubmatchIndex(src)
+		if m == nil {
+			return types.DNull, nil
+		}
+		// Without capture groups the result is the whole match.
+		if len(m) == 2 {
+			return types.NewDArray(types.StringArray, []types.Datum{types.DString(src[m[0]:m[1]])}), nil
+		}
+		elems := make([]types.Datum, 0, len(m)/2-1)
+		for i := 2; i < len(m); i += 2 {
+			if m[i] < 0 {
+				elems = append(elems, types.DNull)
+			} else {
+				elems = append(elems, types.DString(src[m[i]:m[i+1]]))
+			}
+		}
+		return types.NewDArray(types.StringArray, elems), nil
+	}
+	r.RegisterFunc("regexp_match",
+		&Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.StringArray, Fn: regexpMatch},
+		&Overload{Params: []*types.T{types.String, types.String, types.String}, ReturnType: types.StringArray, Fn: regexpMatch},
+	)
+
+	regexpReplace := func(ctx *Context, args []types.Datum) (types.Datum, error) {
+		flags := ""
+		if len(args) == 4 {
+			flags = string(args[3].(types.DString))
+		}
+		opts, err := parseRegexFlags(flags)
+		if err != nil {
+			return nil, err
+		}
+		re, err := regex(ctx, string(args[1].(types.DString)), opts)
+		if err != nil {
+			return nil, err
+		}
+		src, repl := string(args[0].(types.DString)), string(args[2].(types.DString))
+		n := 1
+		if opts.global {
+			n = -1
+		}
+		var out []byte
+		last := 0
+		for _, m := range re.FindAllStringSubmatchIndex(src, n) {
+			out = append(out, src[last:m[0]]...)
+			out = expandReplacement(out, repl, src, m)
+			last = m[1]
+		}
+		return types.DString(append(out, src[last:]...)), nil
+	}
+	r.RegisterFunc("regexp_replace",
+		&Overload{Params: []*types.T{types.String, types.String, types.String}, ReturnType: types.String, Fn: regexpReplace},
+		&Overload{Params: []*types.T{types.String, types.String, types.String, types.String}, ReturnType: types.String, Fn: regexpReplace},
+	)
+
+	similarEscape := func(_ *Context, args []types.Datum) (types.Datum, error) {
+		esc := '\\'
+		if len(args) == 2 {
+			s := string(args[1].(types.DString))
+			switch utf8.RuneCountInString(s) {
+			case 0:
+				esc = -1
+			case 1:
+				esc, _ = utf8.DecodeRuneInString(s)
+			default:
+				return nil, pgerror.New(pgerror.CodeInvalidEscapeSequence, "invalid escape string").
+					WithHint("Escape string must be empty or one character.")
+			}
+		}
+		return types.DString(similarToRegex(string(args[0].(types.DString)), esc)), nil
+	}
+	r.RegisterFunc("similar_to_escape",
+		&Overload{Params: []*types.T{types.String}, ReturnType: types.String, Fn: similarEscape},
+		&Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.String, Fn: similarEscape},
+	)
+}
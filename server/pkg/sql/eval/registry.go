@@ -0,0 +1,662 @@
+package eval
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Volatility describes whether a function's result may change for the same
+// arguments. It matches PostgreSQL's provolatile.
+type Volatility uint8
+
+const (
+	// Immutable functions always return the same result for the same
+	// arguments and may be folded at plan time.
+	Immutable Volatility = iota
+	// Stable functions return the same result within a statement.
+	Stable
+	// Volatile functions may return a different result on every call.
+	Volatile
+)
+
+// Overload is one signature of a function.
+type Overload struct {
+	// Params are the declared parameter types. types.Any accepts any type.
+	Params []*types.T
+	// Variadic, when set, is the type of any trailing arguments beyond
+	// Params.
+	Variadic *types.T
+	// ReturnType is the result type. ReturnFn overrides it for polymorphic
+	// functions.
+	ReturnType *types.T
+	ReturnFn   func(args []*types.T) *types.T
+	// Fn computes the result.
+	Fn func(ctx *Context, args []types.Datum) (types.Datum, error)
+	// NullCall means Fn is invoked even when an argument is NULL. When
+	// false, the function is strict and any NULL argument yields NULL.
+	NullCall   bool
+	Volatility Volatility
+	// Oid identifies the overload in pg_proc. It is assigned on
+	// registration.
+	Oid types.Oid
+}
+
+func (o *Overload) returnType(args []*types.T) *types.T {
+	if o.ReturnFn != nil {
+		return o.ReturnFn(args)
+	}
+	return o.ReturnType
+}
+
+func (o *Overload) paramType(i int) *types.T {
+	if i < len(o.Params) {
+		return o.Params[i]
+	}
+	return o.Variadic
+}
+
+func (o *Overload) arityMatches(n int) bool {
+	if o.Variadic != nil {
+		return n >= len(o.Params)
+	}
+	return n == len(o.Params)
+}
+
+// BinOp is one signature of a binary operator. Binary operators are strict:
+// a NULL operand yields NULL without calling Fn.
+type BinOp struct {
+	Left, Right *types.T
+	ReturnType  *types.T
+	ReturnFn    func(left, right *types.T) *types.T
+	Fn          func(ctx *Context, left, right types.Datum) (types.Datum, error)
+}
+
+func (o *BinOp) returnType(left, right *types.T) *types.T {
+	if o.ReturnFn != nil {
+		return o.ReturnFn(left, right)
+	}
+	return o.ReturnType
+}
+
+// UnaryOp is one signature of a prefix operator. Unary operators are strict.
+type UnaryOp struct {
+	Operand    *types.T
+	ReturnType *types.T
+	ReturnFn   func(operand *types.T) *types.T
+	Fn         func(ctx *Context, operand types.Datum) (types.Datum, error)
+}
+
+func (o *UnaryOp) returnType(operand *types.T) *types.T {
+	if o.ReturnFn != nil {
+		return o.ReturnFn(operand)
+	}
+	return o.ReturnType
+}
+
+// Registry holds the functions and operators available to queries.
+type Registry struct {
+	mu       sync.RWMutex
+	funcs    map[string][]*Overload
+	binOps   map[string][]*BinOp
+	unaryOps map[string][]*UnaryOp
+	aggs     map[string][]*Aggregate
+	wins     map[string][]*WindowFunc
+	// nextOid is the OID given to the next registered function.
+	nextOid types.Oid
+}
+
+// FirstUserOid is the first OID given to functions that are not builtins,
+// matching PostgreSQL's FirstNormalObjectId.
+const FirstUserOid types.Oid = 16384
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return newRegistry(FirstUserOid)
+}
+
+func newRegistry(firstOid types.Oid) *Registry {
+	return &Registry{
+		funcs:    make(map[string][]*Overload),
+		binOps:   make(map[string][]*BinOp),
+		unaryOps: make(map[string][]*UnaryOp),
+		aggs:     make(map[string][]*Aggregate),
+		wins:     make(map[string][]*WindowFunc),
+		nextOid:  firstOid,
+	}
+}
+
+// Builtins is the registry of built-in functions and operators. Files in
+// this package add to it from init functions.
+var Builtins = newRegistry(1)
+
+// Clone returns a registry holding everything registered in r. Functions
+// registered in the clone are not visible in r, so embedders can extend
+// a copy of Builtins without affecting other servers.
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := newRegistry(max(r.nextOid, FirstUserOid))
+	for name, o := range r.funcs {
+		c.funcs[name] = append([]*Overload(nil), o...)
+	}
+	for op, o := range r.binOps {
+		c.binOps[op] = append([]*BinOp(nil), o...)
+	}
+	for op, o := range r.unaryOps {
+		c.unaryOps[op] = append([]*UnaryOp(nil), o...)
+	}
+	for name, a := range r.aggs {
+		c.aggs[name] = append([]*Aggregate(nil), a...)
+	}
+	for name, w := range r.wins {
+		c.wins[name] = append([]*WindowFunc(nil), w...)
+	}
+	return c
+}
+
+// assignOid returns the OID to give a newly registered function. r.mu must
+// be held.
+func (r *Registry) assignOid(oid types.Oid) types.Oid {
+	if oid != 0 {
+		return oid
+	}
+	r.nextOid++
+	return r.nextOid - 1
+}
+
+// RegisterFunc adds overloads for the named function. It does not check
+// the overloads; see Define.
+func (r *Registry) RegisterFunc(name string, overloads ...*Overload) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = strings.ToLower(name)
+	for _, o := range overloads {
+		o.Oid = r.assignOid(o.Oid)
+	}
+	r.funcs[name] = append(r.funcs[name], overloads...)
+}
+
+// Define adds overloads for the named function, like RegisterFunc, after
+// checking that they are complete, that no overload of the function
+// already has the same parameter types, and that the name is not taken by
+// an aggregate. It is how embedders add functions at run time.
+func (r *Registry) Define(name string, overloads ...*Overload) error {
+	name = strings.ToLower(name)
+	if name == "" {
+		return pgerror.New(pgerror.CodeInvalidFunctionDefinition, "function name must not be empty")
+	}
+	for _, o := range overloads {
+		if err := checkOverload(name, o); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.aggs[name]) > 0 {
+		return pgerror.Newf(pgerror.CodeDuplicateFunction, "%s is an aggregate function", name)
+	}
+	existing := append([]*Overload(nil), r.funcs[name]...)
+	for _, o := range overloads {
+		for _, prev := range existing {
+			if sameParams(o, prev) {
+				return pgerror.Newf(pgerror.CodeDuplicateFunction,
+					"function %s(%s) already exists with same argument types", name, argTypesString(o.Params))
+			}
+		}
+		existing = append(existing, o)
+	}
+	for _, o := range overloads {
+		o.Oid = r.assignOid(0)
+	}
+	r.funcs[name] = append(r.funcs[name], overloads...)
+	return nil
+}
+
+// DefineAggregate adds the aggregates defined by defs under name. Like
+// Define, it rejects incomplete definitions, parameter types the aggregate
+// already accepts, and names taken by ordinary functions.
+func (r *Registry) DefineAggregate(name string, defs ...*AggregateDef) error {
+	name = strings.ToLower(name)
+	if name == "" {
+		return pgerror.New(pgerror.CodeInvalidFunctionDefinition, "aggregate name must not be empty")
+	}
+	for _, d := range defs {
+		if err := d.check(name); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.funcs[name]) > 0 {
+		return pgerror.Newf(pgerror.CodeDuplicateFunction, "function %s already exists and is not an aggregate", name)
+	}
+	existing := append([]*Aggregate(nil), r.aggs[name]...)
+	aggs := make([]*Aggregate, len(defs))
+	for i, d := range defs {
+		for _, prev := range existing {
+			if sameTypes(d.Params, prev.Params) {
+				return pgerror.Newf(pgerror.CodeDuplicateFunction,
+					"function %s(%s) already exists with same argument types", name, argTypesString(d.Params))
+			}
+		}
+		aggs[i] = d.aggregate()
+		aggs[i].Oid = r.assignOid(0)
+		existing = append(existing, aggs[i])
+	}
+	r.aggs[name] = append(r.aggs[name], aggs...)
+	return nil
+}
+
+func checkOverload(name string, o *Overload) error {
+	switch {
+	case o == nil:
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "function %s has a nil overload", name)
+	case o.Fn == nil:
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "function %s has no implementation", name)
+	case o.ReturnType == nil && o.ReturnFn == nil:
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "function %s has no return type", name)
+	}
+	for _, p := range o.Params {
+		if p == nil {
+			return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition, "function %s has a parameter with no type", name)
+		}
+		if p.Family == types.UnknownFamily {
+			return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition,
+				"function %s cannot have a parameter of type unknown", name)
+		}
+	}
+	if o.Variadic != nil && o.Variadic.Family == types.UnknownFamily {
+		return pgerror.Newf(pgerror.CodeInvalidFunctionDefinition,
+			"function %s cannot have a parameter of type unknown", name)
+	}
+	return nil
+}
+
+// sameParams reports whether two overloads accept the same argument types.
+func sameParams(a, b *Overload) bool {
+	if (a.Variadic == nil) != (b.Variadic == nil) || a.Variadic != nil && a.Variadic.Oid != b.Variadic.Oid {
+		return false
+	}
+	return sameTypes(a.Params, b.Params)
+}
+
+func sameTypes(a, b []*types.T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Oid != b[i].Oid {
+			return false
+		}
+	}
+	return true
+}
+
+// RegisterBinOp adds an overload for a binary operator.
+func (r *Registry) RegisterBinOp(op string, o *BinOp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.binOps[op] = append(r.binOps[op], o)
+}
+
+// RegisterUnaryOp adds an overload for a prefix operator.
+func (r *Registry) RegisterUnaryOp(op string, o *UnaryOp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unaryOps[op] = append(r.unaryOps[op], o)
+}
+
+// RegisterAggregate adds overloads for the named aggregate function.
+func (r *Registry) RegisterAggregate(name string, overloads ...*Aggregate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = strings.ToLower(name)
+	for _, a := range overloads {
+		a.Oid = r.assignOid(a.Oid)
+	}
+	r.aggs[name] = append(r.aggs[name], overloads...)
+}
+
+// IsAggregate reports whether name is an aggregate function.
+func (r *Registry) IsAggregate(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.aggs[strings.ToLower(name)]) > 0
+}
+
+// RegisterWindowFunc adds overloads for the named window function.
+func (r *Registry) RegisterWindowFunc(name string, overloads ...*WindowFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = strings.ToLower(name)
+	for _, w := range overloads {
+		w.Oid = r.assignOid(w.Oid)
+	}
+	r.wins[name] = append(r.wins[name], overloads...)
+}
+
+// IsWindowFunc reports whether name is a window function. Aggregates,
+// which can also be computed over a window, are not.
+func (r *Registry) IsWindowFunc(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.wins[strings.ToLower(name)]) > 0
+}
+
+// HasFunc reports whether any overload is registered under name.
+func (r *Registry) HasFunc(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.funcs[strings.ToLower(name)]) > 0
+}
+
+// FuncNames returns the names of all registered functions in sorted order.
+func (r *Registry) FuncNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.funcs))
+	for name := range r.funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AggregateNames returns the names of all registered aggregates in sorted
+// order.
+func (r *Registry) AggregateNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.aggs))
+	for name := range r.aggs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WindowFuncNames returns the names of all registered window functions in
+// sorted order.
+func (r *Registry) WindowFuncNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.wins))
+	for name := range r.wins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WindowFuncs returns the overloads of the window function registered
+// under name.
+func (r *Registry) WindowFuncs(name string) []*WindowFunc {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*WindowFunc(nil), r.wins[strings.ToLower(name)]...)
+}
+
+// Aggregates returns the overloads of the aggregate registered under name.
+func (r *Registry) Aggregates(name string) []*Aggregate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Aggregate(nil), r.aggs[strings.ToLower(name)]...)
+}
+
+// Overloads returns the overloads registered under name.
+func (r *Registry) Overloads(name string) []*Overload {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*Overload(nil), r.funcs[strings.ToLower(name)]...)
+}
+
+// implicitCastCost returns the cost of implicitly converting a value of
+// type from to type to, or -1 if no implicit conversion exists.
+func implicitCastCost(from, to *types.T) int {
+	switch {
+	case to.Family == types.AnyFamily:
+		if from.Family == types.UnknownFamily {
+			return 2
+		}
+		return 1
+	case from.Family == to.Family:
+		return 0
+	case from.Family == types.UnknownFamily:
+		// Untyped literals prefer text, like PostgreSQL's preferred type
+		// rule for the string category.
+		if to.Family == types.StringFamily {
+			return 1
+		}
+		return 2
+	}
+	switch from.Family {
+	case types.IntFamily:
+		switch to.Family {
+		case types.DecimalFamily:
+			return 1
+		case types.FloatFamily:
+			return 2
+		}
+	case types.DecimalFamily:
+		if to.Family == types.FloatFamily {
+			return 1
+		}
+	case types.DateFamily:
+		switch to.Family {
+		case types.TimestampFamily:
+			return 1
+		case types.TimestampTZFamily:
+			return 2
+		}
+	case types.TimestampFamily:
+		if to.Family == types.TimestampTZFamily {
+			return 1
+		}
+	case types.CITextFamily:
+		// As with the citext extension's casts, citext is implicitly text,
+		// so text functions and operators apply and citext = text compares
+		// as text. The conversion is free, so text overloads win over
+		// those taking any.
+		if to.Family == types.StringFamily {
+			return 0
+		}
+	}
+	return -1
+}
+
+// matchCost returns the total implicit cast cost of calling a signature
+// with the given argument types, or -1 if the signature does not apply.
+func matchCost(params func(int) *types.T, args []*types.T) int {
+	total := 0
+	for i, a := range args {
+		c := implicitCastCost(a, params(i))
+		if c < 0 {
+			return -1
+		}
+		total += c
+	}
+	return total
+}
+
+func argTypesString(args []*types.T) string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = a.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// ResolveFunc picks the overload of name that best matches args.
+func (r *Registry) ResolveFunc(name string, args []*types.T) (*Overload, error) {
+	r.mu.RLock()
+	overloads := r.funcs[strings.ToLower(name)]
+	r.mu.RUnlock()
+	if len(overloads) == 0 {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction, "function %s does not exist", name)
+	}
+	var best *Overload
+	bestCost, ties := -1, 0
+	for _, o := range overloads {
+		if !o.arityMatches(len(args)) {
+			continue
+		}
+		c := matchCost(o.paramType, args)
+		switch {
+		case c < 0:
+		case best == nil || c < bestCost:
+			best, bestCost, ties = o, c, 0
+		case c == bestCost:
+			ties++
+		}
+	}
+	if best == nil {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction,
+			"function %s(%s) does not exist", name, argTypesString(args)).
+			WithHint("No function matches the given name and argument types. You might need to add explicit type casts.")
+	}
+	if ties > 0 {
+		return nil, pgerror.Newf(pgerror.CodeAmbiguousFunction,
+			"function %s(%s) is not unique", name, argTypesString(args))
+	}
+	return best, nil
+}
+
+// ResolveAggregate picks the overload of the aggregate name that best
+// matches args.
+func (r *Registry) ResolveAggregate(name string, args []*types.T) (*Aggregate, error) {
+	r.mu.RLock()
+	overloads := r.aggs[strings.ToLower(name)]
+	r.mu.RUnlock()
+	var best *Aggregate
+	bestCost, ties := -1, 0
+	for _, o := range overloads {
+		if len(o.Params) != len(args) {
+			continue
+		}
+		c := matchCost(func(i int) *types.T { return o.Params[i] }, args)
+		switch {
+		case c < 0:
+		case best == nil || c < bestCost:
+			best, bestCost, ties = o, c, 0
+		case c == bestCost:
+			ties++
+		}
+	}
+	if best == nil {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction,
+			"function %s(%s) does not exist", name, argTypesString(args)).
+			WithHint("No function matches the given name and argument types. You might need to add explicit type casts.")
+	}
+	if ties > 0 {
+		return nil, pgerror.Newf(pgerror.CodeAmbiguousFunction,
+			"function %s(%s) is not unique", name, argTypesString(args))
+	}
+	return best, nil
+}
+
+// ResolveWindowFunc picks the overload of the window function name that
+// best matches args.
+func (r *Registry) ResolveWindowFunc(name string, args []*types.T) (*WindowFunc, error) {
+	r.mu.RLock()
+	overloads := r.wins[strings.ToLower(name)]
+	r.mu.RUnlock()
+	var best *WindowFunc
+	bestCost, ties := -1, 0
+	for _, o := range overloads {
+		if len(o.Params) != len(args) {
+			continue
+		}
+		c := matchCost(func(i int) *types.T { return o.Params[i] }, args)
+		switch {
+		case c < 0:
+		case best == nil || c < bestCost:
+			best, bestCost, ties = o, c, 0
+		case c == bestCost:
+			ties++
+		}
+	}
+	if best == nil {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction,
+			"function %s(%s) does not exist", name, argTypesString(args)).
+			WithHint("No function matches the given name and argument types. You might need to add explicit type casts.")
+	}
+	if ties > 0 {
+		return nil, pgerror.Newf(pgerror.CodeAmbiguousFunction,
+			"function %s(%s) is not unique", name, argTypesString(args))
+	}
+	return best, nil
+}
+
+// ResolveBinOp picks the overload of op that best matches the operands.
+// As in PostgreSQL, an untyped literal operand is first assumed to have
+// the type of the other operand.
+func (r *Registry) ResolveBinOp(op string, left, right *types.T) (*BinOp, error) {
+	r.mu.RLock()
+	overloads := r.binOps[op]
+	r.mu.RUnlock()
+	if known := left; (left.Family == types.UnknownFamily) != (right.Family == types.UnknownFamily) {
+		if known.Family == types.UnknownFamily {
+			known = right
+		}
+		var exact *BinOp
+		for _, o := range overloads {
+			if o.Left.Family == known.Family && o.Right.Family == known.Family {
+				if exact != nil {
+					exact = nil
+					break
+				}
+				exact = o
+			}
+		}
+		if exact != nil {
+			return exact, nil
+		}
+	}
+	var best *BinOp
+	bestCost, ties := -1, 0
+	for _, o := range overloads {
+		params := func(i int) *types.T {
+			if i == 0 {
+				return o.Left
+			}
+			return o.Right
+		}
+		c := matchCost(params, []*types.T{left, right})
+		switch {
+		case c < 0:
+		case best == nil || c < bestCost:
+			best, bestCost, ties = o, c, 0
+		case c == bestCost:
+			ties++
+		}
+	}
+	if best == nil || ties > 0 {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction,
+			"operator does not exist: %s %s %s", left, op, right)
+	}
+	return best, nil
+}
+
+// ResolveUnaryOp picks the overload of op that best matches the operand.
+func (r *Registry) ResolveUnaryOp(op string, operand *types.T) (*UnaryOp, error) {
+	r.mu.RLock()
+	overloads := r.unaryOps[op]
+	r.mu.RUnlock()
+	var best *UnaryOp
+	bestCost := -1
+	for _, o := range overloads {
+		c := implicitCastCost(operand, o.Operand)
+		if c >= 0 && (best == nil || c < bestCost) {
+			best, bestCost = o, c
+		}
+	}
+	if best == nil {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction,
+			"operator does not exist: %s %s", op, operand)
+	}
+	return best, nil
+}
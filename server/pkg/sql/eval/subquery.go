@@ -0,0 +1,122 @@
+package eval
+
+import (
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// OuterRef reads a column of the row of an enclosing query from within a
+// correlated subquery. Depth 1 is the query the subquery appears in, 2 the
+// query enclosing that one, and so on.
+type OuterRef struct {
+	Depth int
+	Idx   int
+	Name  string
+	Typ   *types.T
+}
+
+// SubqueryKind is the way a subquery's result is used.
+type SubqueryKind uint8
+
+// Subquery kinds.
+const (
+	// ScalarSubquery returns the single value of the subquery's single
+	// row, or NULL if it returns no rows.
+	ScalarSubquery SubqueryKind = iota
+	// ExistsSubquery reports whether the subquery returns any rows.
+	ExistsSubquery
+	// InSubquery reports whether Left equals a value returned by the
+	// subquery, with the NULL handling of IN lists.
+	InSubquery
+)
+
+// SubqueryExpr is a subquery used as a value. Its query is run by
+// Context.Subqueries, which the executor provides.
+type SubqueryExpr struct {
+	Kind SubqueryKind
+	// ID numbers the subqueries of a statement, for EXPLAIN.
+	ID int
+	// Plan is the planned query, a planner.Node. For scalar and IN
+	// subqueries it returns one column.
+	Plan any
+	// Left is the operand of IN.
+	Left Expr
+	Typ  *types.T
+	// Correlation is how many levels of enclosing queries the subquery
+	// refers to: 0 when it is uncorrelated and can be run once per
+	// statement, 1 when it refers to the query it appears in, and so on.
+	Correlation int
+}
+
+// SubqueryRunner runs the queries of subquery expressions.
+type SubqueryRunner interface {
+	// Rows returns the rows of e's query, at most limit of them unless
+	// limit is negative. The query is run with ctx.Row as the row of the
+	// enclosing query, and ctx.Row is restored afterwards.
+	Rows(ctx *Context, e *SubqueryExpr, limit int) ([][]types.Datum, error)
+	// Contains reports whether d, which is not NULL, is among the values
+	// returned by e's query: true, false, or NULL if it is not but a NULL
+	// value is.
+	Contains(ctx *Context, e *SubqueryExpr, d types.Datum) (types.Datum, error)
+}
+
+func (e *OuterRef) Eval(ctx *Context) (types.Datum, error) {
+	return ctx.Outer[len(ctx.Outer)-e.Depth][e.Idx], nil
+}
+
+func (e *SubqueryExpr) Eval(ctx *Context) (types.Datum, error) {
+	if ctx.Subqueries == nil {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "subqueries are not supported here")
+	}
+	switch e.Kind {
+	case ExistsSubquery:
+		rows, err := ctx.Subqueries.Rows(ctx, e, 1)
+		if err != nil {
+			return nil, err
+		}
+		return types.MakeDBool(len(rows) > 0), nil
+	case InSubquery:
+		d, err := e.Left.Eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if d == types.DNull {
+			// NULL IN (...) is false for an empty result and NULL
+			// otherwise.
+			rows, err := ctx.Subqueries.Rows(ctx, e, 1)
+			if err != nil || len(rows) == 0 {
+				return types.MakeDBool(false), err
+			}
+			return types.DNull, nil
+		}
+		return ctx.Subqueries.Contains(ctx, e, d)
+	}
+	rows, err := ctx.Subqueries.Rows(ctx, e, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(rows) {
+	case 0:
+		return types.DNull, nil
+	case 1:
+		return rows[0][0], nil
+	}
+	return nil, pgerror.New(pgerror.CodeCardinalityViolation, "more than one row returned by a subquery used as an expression")
+}
+
+func (e *OuterRef) ResolvedType() *types.T     { return e.Typ }
+func (e *SubqueryExpr) ResolvedType() *types.T { return e.Typ }
+
+func (e *OuterRef) String() string { return e.Name }
+
+func (e *SubqueryExpr) String() string {
+	switch e.Kind {
+	case ExistsSubquery:
+		return fmt.Sprintf("EXISTS(SubPlan %d)", e.ID)
+	case InSubquery:
+		return fmt.Sprintf("(%s IN (SubPlan %d))", e.Left, e.ID)
+	}
+	return fmt.Sprintf("(SubPlan %d)", e.ID)
+}
@@ -0,0 +1,360 @@
+package eval
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Coerce implicitly converts e to type to, inserting a cast when the
+// families differ. Constant operands are folded immediately so invalid
+// literals are reported at plan time.
+func Coerce(e Expr, to *types.T) (Expr, error) {
+	from := e.ResolvedType()
+	if to.Family == types.AnyFamily || from.Family == to.Family {
+		return e, nil
+	}
+	if implicitCastCost(from, to) < 0 {
+		return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+			"expression of type %s cannot be used as type %s", from, to)
+	}
+	return NewCastExpr(e, to)
+}
+
+// NewCastExpr returns an explicit cast of e to typ.
+func NewCastExpr(e Expr, typ *types.T) (Expr, error) {
+	if p, ok := e.(*Placeholder); ok && p.infer(typ) {
+		return p, nil
+	}
+	from := e.ResolvedType()
+	if !CanCast(from, typ) {
+		return nil, pgerror.Newf(pgerror.CodeCannotCoerce, "cannot cast type %s to %s", from, typ)
+	}
+	if c, ok := e.(*Const); ok && castVolatility(from, typ) == Immutable {
+		d, err := PerformCast(nil, c.Datum, typ)
+		if err != nil {
+			return nil, err
+		}
+		return &Const{Datum: d, Typ: typ}, nil
+	}
+	return &CastExpr{Operand: e, Typ: typ}, nil
+}
+
+// castVolatility returns the volatility of a cast from one type to
+// another. The text of dates, times and intervals depends on the
+// session's DateStyle and IntervalStyle, so casting them to text is only
+// stable.
+func castVolatility(from, to *types.T) Volatility {
+	if to.Family != types.StringFamily && to.Family != types.CITextFamily {
+		return Immutable
+	}
+	for from.ArrayContents != nil || from.RangeContents != nil {
+		if from.ArrayContents != nil {
+			from = from.ArrayContents
+		} else {
+			from = from.RangeContents
+		}
+	}
+	switch from.Family {
+	case types.DateFamily, types.TimestampFamily, types.TimestampTZFamily, types.IntervalFamily:
+		return Stable
+	}
+	return Immutable
+}
+
+// CommonType returns the type that all of ts can be implicitly converted
+// to, following PostgreSQL's UNION/CASE resolution rules. ctx names the
+// construct in error messages.
+func CommonType(ctx string, ts []*types.T) (*types.T, error) {
+	var cand *types.T
+	for _, t := range ts {
+		if t.Family == types.UnknownFamily {
+			continue
+		}
+		if cand == nil {
+			cand = t
+			continue
+		}
+		if cand.Family == t.Family {
+			if t.Family == types.IntFamily && t.Width > cand.Width {
+				cand = t
+			}
+			continue
+		}
+		switch {
+		case implicitCastCost(cand, t) >= 0 && implicitCastCost(t, cand) < 0:
+			cand = t
+		case implicitCastCost(t, cand) >= 0:
+		default:
+			return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch, "%s types %s and %s cannot be matched", ctx, cand, t)
+		}
+	}
+	if cand == nil {
+		return types.String, nil
+	}
+	return cand, nil
+}
+
+// concreteType returns the type that param stands for in a call with args.
+// A polymorphic range or multirange parameter takes its type from the
+// first argument with a range or multirange type, so that untyped literals
+// are parsed as that type. Other parameter types are returned as is.
+func concreteType(param *types.T, args []Expr) *types.T {
+	if !param.Polymorphic() {
+		return param
+	}
+	for _, a := range args {
+		t := a.ResolvedType()
+		switch {
+		case t.RangeContents == nil:
+		case t.Family == param.Family:
+			return t
+		case param.Family == types.RangeFamily:
+			return types.RangeOf(t.RangeContents)
+		default:
+			return types.MultirangeOf(t)
+		}
+	}
+	return param
+}
+
+func coerceAll(exprs []Expr, to *types.T) ([]Expr, error) {
+	out := make([]Expr, len(exprs))
+	for i, e := range exprs {
+		c, err := Coerce(e, to)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = c
+	}
+	return out, nil
+}
+
+// NewFuncExpr resolves a call to name in r and coerces the arguments to the
+// chosen overload's parameter types.
+func (r *Registry) NewFuncExpr(name string, args []Expr) (Expr, error) {
+	o, err := r.ResolveFunc(name, exprTypes(args))
+	if err != nil {
+		return nil, err
+	}
+	coerced := make([]Expr, len(args))
+	for i, a := range args {
+		if coerced[i], err = Coerce(a, concreteType(o.paramType(i), args)); err != nil {
+			return nil, err
+		}
+	}
+	return &FuncExpr{Name: name, Args: coerced, Overload: o}, nil
+}
+
+// NewBinaryExpr resolves a binary operator in r for the operand types.
+func (r *Registry) NewBinaryExpr(op string, left, right Expr) (Expr, error) {
+	o, err := r.ResolveBinOp(op, left.ResolvedType(), right.ResolvedType())
+	if err != nil {
+		return nil, err
+	}
+	operands := []Expr{left, right}
+	if left, err = Coerce(left, concreteType(o.Left, operands)); err != nil {
+		return nil, err
+	}
+	if right, err = Coerce(right, concreteType(o.Right, operands)); err != nil {
+		return nil, err
+	}
+	return &BinaryExpr{Op: op, Left: left, Right: right, Fn: o}, nil
+}
+
+// NewUnaryExpr resolves a prefix operator in r for the operand type.
+func (r *Registry) NewUnaryExpr(op string, operand Expr) (Expr, error) {
+	o, err := r.ResolveUnaryOp(op, operand.ResolvedType())
+	if err != nil {
+		return nil, err
+	}
+	if operand, err = Coerce(operand, o.Operand); err != nil {
+		return nil, err
+	}
+	return &UnaryExpr{Op: op, Operand: operand, Fn: o}, nil
+}
+
+// comparable coerces left and right to a common type so they can be
+// compared.
+func comparable(left, right Expr) (Expr, Expr, error) {
+	lt, rt := left.ResolvedType(), right.ResolvedType()
+	if lt.Equivalent(rt) && lt.Family != types.UnknownFamily && rt.Family != types.UnknownFamily {
+		return left, right, nil
+	}
+	t, err := CommonType("comparison", []*types.T{lt, rt})
+	if err != nil {
+		return nil, nil, pgerror.Newf(pgerror.CodeUndefinedFunction, "operator does not exist: %s = %s", lt, rt).
+			WithHint("No operator matches the given name and argument types. You might need to add explicit type casts.")
+	}
+	if left, err = Coerce(left, t); err != nil {
+		return nil, nil, err
+	}
+	if right, err = Coerce(right, t); err != nil {
+		return nil, nil, err
+	}
+	return left, right, nil
+}
+
+// NewComparisonExpr returns a comparison with operands coerced to a common
+// type.
+func NewComparisonExpr(op CompareOp, left, right Expr) (Expr, error) {
+	left, right, err := comparable(left, right)
+	if err != nil {
+		return nil, err
+	}
+	return &ComparisonExpr{Op: op, Left: left, Right: right}, nil
+}
+
+// NewRowComparisonExpr returns a comparison of the rows left and right,
+// with the operands at each position coerced to a common type.
+func NewRowComparisonExpr(op CompareOp, left, right []Expr) (Expr, error) {
+	if len(left) != len(right) {
+		return nil, pgerror.New(pgerror.CodeSyntaxError, "unequal number of entries in row expressions")
+	}
+	if len(left) == 0 {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "cannot compare rows of zero length")
+	}
+	e := &RowComparisonExpr{Op: op, Left: make([]Expr, len(left)), Right: make([]Expr, len(right))}
+	for i := range left {
+		var err error
+		if e.Left[i], e.Right[i], err = comparable(left[i], right[i]); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func coerceBool(e Expr, ctx string) (Expr, error) {
+	if e.ResolvedType().Family == types.BoolFamily {
+		return e, nil
+	}
+	if e.ResolvedType().Family == types.UnknownFamily {
+		return Coerce(e, types.Bool)
+	}
+	return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+		"argument of %s must be type boolean, not type %s", ctx, e.ResolvedType())
+}
+
+// NewAndExpr returns left AND right.
+func NewAndExpr(left, right Expr) (Expr, error) {
+	l, err := coerceBool(left, "AND")
+	if err != nil {
+		return nil, err
+	}
+	r, err := coerceBool(right, "AND")
+	if err != nil {
+		return nil, err
+	}
+	return &AndExpr{Left: l, Right: r}, nil
+}
+
+// NewOrExpr returns left OR right.
+func NewOrExpr(left, right Expr) (Expr, error) {
+	l, err := coerceBool(left, "OR")
+	if err != nil {
+		return nil, err
+	}
+	r, err := coerceBool(right, "OR")
+	if err != nil {
+		return nil, err
+	}
+	return &OrExpr{Left: l, Right: r}, nil
+}
+
+// NewNotExpr returns NOT operand.
+func NewNotExpr(operand Expr) (Expr, error) {
+	o, err := coerceBool(operand, "NOT")
+	if err != nil {
+		return nil, err
+	}
+	return &NotExpr{Operand: o}, nil
+}
+
+// NewIsBoolExpr returns operand IS [NOT] TRUE/FALSE.
+func NewIsBoolExpr(operand Expr, value, not bool) (Expr, error) {
+	o, err := coerceBool(operand, "IS")
+	if err != nil {
+		return nil, err
+	}
+	return &IsBoolExpr{Operand: o, Value: value, Not: not}, nil
+}
+
+// NewCaseExpr type checks a CASE expression. The result type is the common
+// type of all THEN and ELSE arms.
+func NewCaseExpr(operand Expr, whens []CaseWhen, elseExpr Expr) (Expr, error) {
+	var resultTypes []*types.T
+	for _, w := range whens {
+		resultTypes = append(resultTypes, w.Val.ResolvedType())
+	}
+	if elseExpr != nil {
+		resultTypes = append(resultTypes, elseExpr.ResolvedType())
+	}
+	typ, err := CommonType("CASE", resultTypes)
+	if err != nil {
+		return nil, err
+	}
+	out := &CaseExpr{Operand: operand, Typ: typ}
+	for _, w := range whens {
+		cond := w.Cond
+		if operand != nil {
+			if _, cond, err = comparable(operand, cond); err != nil {
+				return nil, err
+			}
+		} else if cond, err = coerceBool(cond, "CASE/WHEN"); err != nil {
+			return nil, err
+		}
+		val, err := Coerce(w.Val, typ)
+		if err != nil {
+			return nil, err
+		}
+		out.Whens = append(out.Whens, CaseWhen{Cond: cond, Val: val})
+	}
+	if operand != nil && len(whens) > 0 {
+		if out.Operand, _, err = comparable(operand, out.Whens[0].Cond); err != nil {
+			return nil, err
+		}
+	}
+	if elseExpr != nil {
+		if out.Else, err = Coerce(elseExpr, typ); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// NewCoalesceExpr type checks COALESCE(exprs...).
+func NewCoalesceExpr(exprs []Expr) (Expr, error) {
+	typ, err := CommonType("COALESCE", exprTypes(exprs))
+	if err != nil {
+		return nil, err
+	}
+	coerced, err := coerceAll(exprs, typ)
+	if err != nil {
+		return nil, err
+	}
+	return &CoalesceExpr{Exprs: coerced, Typ: typ}, nil
+}
+
+// NewNullIfExpr type checks NULLIF(left, right).
+func NewNullIfExpr(left, right Expr) (Expr, error) {
+	left, right, err := comparable(left, right)
+	if err != nil {
+		return nil, err
+	}
+	return &NullIfExpr{Left: left, Right: right}, nil
+}
+
+// NewInListExpr type checks operand [NOT] IN (list).
+func NewInListExpr(operand Expr, list []Expr, not bool) (Expr, error) {
+	typ, err := CommonType("IN", append([]*types.T{operand.ResolvedType()}, exprTypes(list)...))
+	if err != nil {
+		return nil, err
+	}
+	if operand, err = Coerce(operand, typ); err != nil {
+		return nil, err
+	}
+	coerced, err := coerceAll(list, typ)
+	if err != nil {
+		return nil, err
+	}
+	return &InListExpr{Operand: operand, List: coerced, Not: not}, nil
+}
@@ -0,0 +1,608 @@
+package eval
+
+import (
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// FrameMode is how the bounds of a window frame are counted: in rows, in
+// ORDER BY values, or in peer groups.
+type FrameMode uint8
+
+// Frame modes.
+const (
+	FrameRange FrameMode = iota
+	FrameRows
+	FrameGroups
+)
+
+// FrameBoundKind is the kind of a window frame bound.
+type FrameBoundKind uint8
+
+// Frame bound kinds.
+const (
+	UnboundedPreceding FrameBoundKind = iota
+	OffsetPreceding
+	CurrentRow
+	OffsetFollowing
+	UnboundedFollowing
+)
+
+// FrameExclusion is the EXCLUDE option of a window frame.
+type FrameExclusion uint8
+
+// Frame exclusions.
+const (
+	ExcludeNoOthers FrameExclusion = iota
+	ExcludeCurrentRow
+	ExcludeGroup
+	ExcludeTies
+)
+
+// FrameBound is the start or end of a window frame.
+type FrameBound struct {
+	Kind FrameBoundKind
+	// Offset is the offset of an OffsetPreceding or OffsetFollowing bound.
+	// It does not refer to columns. In ROWS and GROUPS mode it is int8.
+	Offset Expr
+	// In RANGE mode, Value computes an offset bound from the ORDER BY value
+	// of the current row, which is column 0 of the row it is evaluated
+	// over. InFrame reports whether an ORDER BY value, column 0, is on the
+	// frame's side of that bound, column 1.
+	Value, InFrame Expr
+}
+
+// WindowFrame is the frame of a window: for each row, the rows of its
+// partition that an aggregate, first_value, last_value or nth_value over
+// the window sees.
+type WindowFrame struct {
+	Mode       FrameMode
+	Start, End FrameBound
+	Exclude    FrameExclusion
+}
+
+// DefaultFrame is the frame of a window without a frame clause: the rows
+// up to the current row's last peer.
+var DefaultFrame = WindowFrame{
+	Mode:  FrameRange,
+	Start: FrameBound{Kind: UnboundedPreceding},
+	End:   FrameBound{Kind: CurrentRow},
+}
+
+var frameModeNames = [...]string{FrameRange: "RANGE", FrameRows: "ROWS", FrameGroups: "GROUPS"}
+
+func (m FrameMode) String() string { return frameModeNames[m] }
+
+func (f *WindowFrame) String() string {
+	s := f.Mode.String() + " BETWEEN " + f.Start.String() + " AND " + f.End.String()
+	switch f.Exclude {
+	case ExcludeCurrentRow:
+		s += " EXCLUDE CURRENT ROW"
+	case ExcludeGroup:
+		s += " EXCLUDE GROUP"
+	case ExcludeTies:
+		s += " EXCLUDE TIES"
+	}
+	return s
+}
+
+func (b FrameBound) String() string {
+	switch b.Kind {
+	case UnboundedPreceding:
+		return "UNBOUNDED PRECEDING"
+	case OffsetPreceding:
+		return b.Offset.String() + " PRECEDING"
+	case CurrentRow:
+		return "CURRENT ROW"
+	case OffsetFollowing:
+		return b.Offset.String() + " FOLLOWING"
+	}
+	return "UNBOUNDED FOLLOWING"
+}
+
+// WindowPartition describes the rows of one window partition, in window
+// order, to the functions computed over it.
+type WindowPartition struct {
+	frame *WindowFrame
+	// peers holds the peer group of each row: rows are peers when their
+	// ORDER BY values are equal. groups holds the first row of each peer
+	// group, followed by the number of rows.
+	peers  []int
+	groups []int
+	// start and end hold the frame of each row, [start, end).
+	start, end []int
+}
+
+// NewWindowPartition computes the frames of a partition whose rows are in
+// the peer groups peers, numbered from 0. keys holds the ORDER BY value of
+// each row; it is needed only for RANGE frames with an offset.
+func NewWindowPartition(ctx *Context, f *WindowFrame, peers []int, keys []types.Datum) (*WindowPartition, error) {
+	n := len(peers)
+	p := &WindowPartition{frame: f, peers: peers, start: make([]int, n), end: make([]int, n)}
+	for i, g := range peers {
+		if i == 0 || g != peers[i-1] {
+			p.groups = append(p.groups, i)
+		}
+	}
+	p.groups = append(p.groups, n)
+	startOffset, err := frameOffset(ctx, f, f.Start, "starting")
+	if err != nil {
+		return nil, err
+	}
+	endOffset, err := frameOffset(ctx, f, f.End, "ending")
+	if err != nil {
+		return nil, err
+	}
+	for i := range peers {
+		if p.start[i], err = p.bound(ctx, f.Start, startOffset, i, keys, false); err != nil {
+			return nil, err
+		}
+		if p.end[i], err = p.bound(ctx, f.End, endOffset, i, keys, true); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// frameOffset evaluates the offset of b, checking that it is not NULL or
+// negative.
+func frameOffset(ctx *Context, f *WindowFrame, b FrameBound, which string) (types.Datum, error) {
+	if b.Offset == nil {
+		return nil, nil
+	}
+	ctx.Row = nil
+	d, err := b.Offset.Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d == types.DNull {
+		return nil, pgerror.Newf(pgerror.CodeNullValueNotAllowed, "frame %s offset must not be null", which)
+	}
+	if !negative(d) {
+		return d, nil
+	}
+	if f.Mode == FrameRange {
+		return nil, pgerror.New(pgerror.CodeInvalidFrameOffset, "invalid preceding or following size in window function")
+	}
+	return nil, pgerror.Newf(pgerror.CodeInvalidFrameOffset, "frame %s offset must not be negative", which)
+}
+
+// negative reports whether a frame offset is less than zero.
+func negative(d types.Datum) bool {
+	switch d := d.(type) {
+	case types.DInt:
+		return d < 0
+	case types.DFloat:
+		return d < 0
+	case *types.DDecimal:
+		return d.Dec.Sign() < 0
+	case types.DInterval:
+		return d.Compare(types.DInterval{}) < 0
+	}
+	return false
+}
+
+// bound returns the first row of the frame of row i, or with end set, the
+// row after its last.
+func (p *WindowPartition) bound(ctx *Context, b FrameBound, offset types.Datum, i int, keys []types.Datum, end bool) (int, error) {
+	n, g := len(p.peers), p.peers[i]
+	last := 0
+	if end {
+		last = 1
+	}
+	switch b.Kind {
+	case UnboundedPreceding:
+		return 0, nil
+	case UnboundedFollowing:
+		return n, nil
+	case CurrentRow:
+		if p.frame.Mode == FrameRows {
+			return i + last, nil
+		}
+		return p.groups[g+last], nil
+	}
+	if p.frame.Mode == FrameRange {
+		return p.rangeBound(ctx, b, i, keys, end)
+	}
+	// Offsets beyond the partition are clamped so the arithmetic cannot
+	// overflow.
+	off := int(min(int64(offset.(types.DInt)), int64(n)))
+	if b.Kind == OffsetPreceding {
+		off = -off
+	}
+	if p.frame.Mode == FrameRows {
+		return min(max(i+off+last, 0), n), nil
+	}
+	return p.groups[min(max(g+off+last, 0), len(p.groups)-1)], nil
+}
+
+// rangeBound is bound for an offset in RANGE mode. A row whose ORDER BY
+// value is NULL is bounded by its peers; other rows by the non-NULL values
+// within the offset of their own.
+func (p *WindowPartition) rangeBound(ctx *Context, b FrameBound, i int, keys []types.Datum, end bool) (int, error) {
+	if keys[i] == types.DNull {
+		if end {
+			return p.groups[p.peers[i]+1], nil
+		}
+		return p.groups[p.peers[i]], nil
+	}
+	// NULLs sort together at one end of the partition.
+	lo, hi := 0, len(keys)
+	for lo < hi && keys[lo] == types.DNull {
+		lo++
+	}
+	for hi > lo && keys[hi-1] == types.DNull {
+		hi--
+	}
+	ctx.Row = []types.Datum{keys[i]}
+	bound, err := b.Value.Eval(ctx)
+	if err != nil {
+		return 0, err
+	}
+	j := lo + sort.Search(hi-lo, func(k int) bool {
+		if err != nil {
+			return true
+		}
+		ctx.Row = []types.Datum{keys[lo+k], bound}
+		var in types.Datum
+		if in, err = b.InFrame.Eval(ctx); err != nil {
+			return true
+		}
+		// The frame starts at the first row in it and ends before the
+		// first row past it.
+		return in == types.DBool(!end)
+	})
+	return j, err
+}
+
+// Len returns the number of rows of the partition.
+func (p *WindowPartition) Len() int { return len(p.peers) }
+
+// PeerGroup returns the number of row i's peer group, counting from 0,
+// and the rows of the group, [start, end).
+func (p *WindowPartition) PeerGroup(i int) (group, start, end int) {
+	g := p.peers[i]
+	return g, p.groups[g], p.groups[g+1]
+}
+
+// Frame returns the frame of row i, [start, end). Rows within it for which
+// Excluded is true are not part of the frame.
+func (p *WindowPartition) Frame(i int) (start, end int) {
+	return p.start[i], p.end[i]
+}
+
+// Excluded reports whether the frame exclusion removes row j from the
+// frame of row i.
+func (p *WindowPartition) Excluded(i, j int) bool {
+	switch p.frame.Exclude {
+	case ExcludeCurrentRow:
+		return i == j
+	case ExcludeGroup:
+		return p.peers[i] == p.peers[j]
+	case ExcludeTies:
+		return i != j && p.peers[i] == p.peers[j]
+	}
+	return false
+}
+
+// frameRows calls fn for each row in the frame of row i, in order, until
+// fn returns false.
+func (p *WindowPartition) frameRows(i int, fn func(j int) bool) {
+	start, end := p.Frame(i)
+	for j := start; j < end; j++ {
+		if !p.Excluded(i, j) && !fn(j) {
+			return
+		}
+	}
+}
+
+// WindowFunc is one signature of a window function.
+type WindowFunc struct {
+	// Params are the declared parameter types. types.Any accepts any type.
+	Params []*types.T
+	// ReturnType is the result type. ReturnFn overrides it for polymorphic
+	// functions.
+	ReturnType *types.T
+	ReturnFn   func(args []*types.T) *types.T
+	// Compatible means the arguments of the parameters declared types.Any
+	// are converted to their common type, like PostgreSQL's anycompatible
+	// parameters.
+	Compatible bool
+	// Fn sets out[i] to the result for row i of partition p, given the
+	// arguments of each row.
+	Fn func(ctx *Context, p *WindowPartition, args [][]types.Datum, out []types.Datum) error
+	// Oid identifies the function in pg_proc. It is assigned on
+	// registration.
+	Oid types.Oid
+}
+
+// WindowCall is a resolved call of a window function, or of an aggregate
+// computed over a window. Like AggregateCall, it is not an Expr: it is
+// computed over partitions of rows by a window operator.
+type WindowCall struct {
+	Name string
+	// Args are evaluated over each input row.
+	Args []Expr
+	// Func is the window function called, or nil when Agg is set.
+	Func *WindowFunc
+	Agg  *Aggregate
+	Typ  *types.T
+}
+
+// NewWindowCall resolves name in r, as a window function or an aggregate,
+// for args.
+func (r *Registry) NewWindowCall(name string, args []Expr) (*WindowCall, error) {
+	if !r.IsWindowFunc(name) {
+		if !r.IsAggregate(name) {
+			if _, err := r.ResolveFunc(name, exprTypes(args)); err != nil {
+				return nil, err
+			}
+			return nil, pgerror.Newf(pgerror.CodeWrongObjectType,
+				"OVER specified, but %s is not a window function nor an aggregate function", name)
+		}
+		a, err := r.NewAggregateCall(name, args, false)
+		if err != nil {
+			return nil, err
+		}
+		return &WindowCall{Name: name, Args: a.Args, Agg: a.Agg, Typ: a.Typ}, nil
+	}
+	argTypes := exprTypes(args)
+	w, err := r.ResolveWindowFunc(name, argTypes)
+	if err != nil {
+		return nil, err
+	}
+	var common *types.T
+	if w.Compatible {
+		var ts []*types.T
+		for i, p := range w.Params {
+			if p.Family == types.AnyFamily {
+				ts = append(ts, argTypes[i])
+			}
+		}
+		if common, err = CommonType(name, ts); err != nil {
+			return nil, err
+		}
+	}
+	coerced := make([]Expr, len(args))
+	for i, arg := range args {
+		to := w.Params[i]
+		if common != nil && to.Family == types.AnyFamily {
+			to = common
+		}
+		if coerced[i], err = Coerce(arg, to); err != nil {
+			return nil, err
+		}
+		// Untyped literals passed to polymorphic parameters are text.
+		if coerced[i].ResolvedType().Family == types.UnknownFamily {
+			if coerced[i], err = Coerce(coerced[i], types.String); err != nil {
+				return nil, err
+			}
+		}
+		argTypes[i] = coerced[i].ResolvedType()
+	}
+	typ := w.ReturnType
+	if w.ReturnFn != nil {
+		typ = w.ReturnFn(argTypes)
+	}
+	return &WindowCall{Name: name, Args: coerced, Func: w, Typ: typ}, nil
+}
+
+func (c *WindowCall) String() string {
+	if len(c.Args) == 0 && c.Agg != nil {
+		return c.Name + "(*)"
+	}
+	return c.Name + "(" + joinExprs(c.Args) + ")"
+}
+
+// Compute sets out[i] to the result of the call for row i of partition p,
+// given the arguments of each row.
+func (c *WindowCall) Compute(ctx *Context, p *WindowPartition, args [][]types.Datum, out []types.Datum) error {
+	if c.Func != nil {
+		return c.Func.Fn(ctx, p, args, out)
+	}
+	argTypes := exprTypes(c.Args)
+	add := func(f AggregateFunc, j int) error {
+		if !c.Agg.NullCall {
+			for _, d := range args[j] {
+				if d == types.DNull {
+					return nil
+				}
+			}
+		}
+		return f.Add(ctx, args[j])
+	}
+	// A frame that starts with the partition only grows from one row to
+	// the next, so the rows entering it are added to a running state.
+	if f := p.frame; f.Start.Kind == UnboundedPreceding && f.Exclude == ExcludeNoOthers {
+		state := c.Agg.New(argTypes)
+		added := 0
+		for i := range out {
+			_, end := p.Frame(i)
+			for ; added < end; added++ {
+				if err := add(state, added); err != nil {
+					return err
+				}
+			}
+			var err error
+			if out[i], err = state.Result(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := range out {
+		state := c.Agg.New(argTypes)
+		var err error
+		p.frameRows(i, func(j int) bool {
+			err = add(state, j)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		if out[i], err = state.Result(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// argInt returns an int argument of a window function, or false if it is
+// NULL.
+func argInt(d types.Datum) (int64, bool) {
+	if d == types.DNull {
+		return 0, false
+	}
+	return int64(d.(types.DInt)), true
+}
+
+// shift implements lag and lead: the value of the row offset rows after
+// each row, or the default.
+func shift(sign int64) func(*Context, *WindowPartition, [][]types.Datum, []types.Datum) error {
+	return func(_ *Context, p *WindowPartition, args [][]types.Datum, out []types.Datum) error {
+		for i, a := range args {
+			offset := int64(1)
+			if len(a) > 1 {
+				var ok bool
+				if offset, ok = argInt(a[1]); !ok {
+					out[i] = types.DNull
+					continue
+				}
+			}
+			switch j := int64(i) + sign*offset; {
+			case j >= 0 && j < int64(p.Len()):
+				out[i] = args[j][0]
+			case len(a) > 2:
+				out[i] = a[2]
+			default:
+				out[i] = types.DNull
+			}
+		}
+		return nil
+	}
+}
+
+func init() {
+	r := Builtins
+	ranking := func(fn func(p *WindowPartition, i int) types.Datum) func(*Context, *WindowPartition, [][]types.Datum, []types.Datum) error {
+		return func(_ *Context, p *WindowPartition, _ [][]types.Datum, out []types.Datum) error {
+			for i := range out {
+				out[i] = fn(p, i)
+			}
+			return nil
+		}
+	}
+	r.RegisterWindowFunc("row_number", &WindowFunc{ReturnType: types.Int8,
+		Fn: ranking(func(_ *WindowPartition, i int) types.Datum { return types.DInt(i + 1) })})
+	r.RegisterWindowFunc("rank", &WindowFunc{ReturnType: types.Int8,
+		Fn: ranking(func(p *WindowPartition, i int) types.Datum {
+			_, start, _ := p.PeerGroup(i)
+			return types.DInt(start + 1)
+		})})
+	r.RegisterWindowFunc("dense_rank", &WindowFunc{ReturnType: types.Int8,
+		Fn: ranking(func(p *WindowPartition, i int) types.Datum {
+			g, _, _ := p.PeerGroup(i)
+			return types.DInt(g + 1)
+		})})
+	r.RegisterWindowFunc("percent_rank", &WindowFunc{ReturnType: types.Float,
+		Fn: ranking(func(p *WindowPartition, i int) types.Datum {
+			if p.Len() == 1 {
+				return types.DFloat(0)
+			}
+			_, start, _ := p.PeerGroup(i)
+			return types.DFloat(float64(start) / float64(p.Len()-1))
+		})})
+	r.RegisterWindowFunc("cume_dist", &WindowFunc{ReturnType: types.Float,
+		Fn: ranking(func(p *WindowPartition, i int) types.Datum {
+			_, _, end := p.PeerGroup(i)
+			return types.DFloat(float64(end) / float64(p.Len()))
+		})})
+
+	// ntile divides the partition into buckets as equal as possible, the
+	// first ones taking the remainder. As in PostgreSQL, the bucket count
+	// is read from the partition's first row.
+	r.RegisterWindowFunc("ntile", &WindowFunc{Params: []*types.T{types.Int4}, ReturnType: types.Int4,
+		Fn: func(_ *Context, p *WindowPartition, args [][]types.Datum, out []types.Datum) error {
+			buckets, ok := argInt(args[0][0])
+			if !ok {
+				for i := range out {
+					out[i] = types.DNull
+				}
+				return nil
+			}
+			if buckets <= 0 {
+				return pgerror.New(pgerror.CodeInvalidArgumentForNtile, "argument of ntile must be greater than zero")
+			}
+			n := int64(len(out))
+			size, extra := n/buckets, n%buckets
+			bucket, left := int64(1), size
+			if extra > 0 {
+				left++
+			}
+			for i := range out {
+				if left == 0 {
+					bucket++
+					if left = size; bucket <= extra {
+						left++
+					}
+				}
+				out[i] = types.DInt(bucket)
+				left--
+			}
+			return nil
+		}})
+
+	for name, sign := range map[string]int64{"lag": -1, "lead": 1} {
+		r.RegisterWindowFunc(name,
+			&WindowFunc{Params: []*types.T{types.Any}, ReturnFn: sameAsArg, Fn: shift(sign)},
+			&WindowFunc{Params: []*types.T{types.Any, types.Int4}, ReturnFn: sameAsArg, Fn: shift(sign)},
+			&WindowFunc{Params: []*types.T{types.Any, types.Int4, types.Any}, ReturnFn: sameAsArg,
+				Compatible: true, Fn: shift(sign)})
+	}
+
+	// nth returns the value of the nth row of the frame of each row, where
+	// n < 0 counts from the end of the frame.
+	nth := func(n func(args []types.Datum) (int64, error)) func(*Context, *WindowPartition, [][]types.Datum, []types.Datum) error {
+		return func(_ *Context, p *WindowPartition, args [][]types.Datum, out []types.Datum) error {
+			for i := range out {
+				want, err := n(args[i])
+				if err != nil {
+					return err
+				}
+				out[i] = types.DNull
+				if want == 0 {
+					continue
+				}
+				var frame []int
+				p.frameRows(i, func(j int) bool {
+					frame = append(frame, j)
+					return want < 0 || int64(len(frame)) < want
+				})
+				if want < 0 {
+					want += int64(len(frame)) + 1
+				}
+				if want >= 1 && want <= int64(len(frame)) {
+					out[i] = args[frame[want-1]][0]
+				}
+			}
+			return nil
+		}
+	}
+	r.RegisterWindowFunc("first_value", &WindowFunc{Params: []*types.T{types.Any}, ReturnFn: sameAsArg,
+		Fn: nth(func([]types.Datum) (int64, error) { return 1, nil })})
+	r.RegisterWindowFunc("last_value", &WindowFunc{Params: []*types.T{types.Any}, ReturnFn: sameAsArg,
+		Fn: nth(func([]types.Datum) (int64, error) { return -1, nil })})
+	r.RegisterWindowFunc("nth_value", &WindowFunc{Params: []*types.T{types.Any, types.Int4}, ReturnFn: sameAsArg,
+		Fn: nth(func(args []types.Datum) (int64, error) {
+			n, ok := argInt(args[1])
+			switch {
+			case !ok:
+				return 0, nil
+			case n <= 0:
+				return 0, pgerror.New(pgerror.CodeInvalidNthValueArgument, "argument of nth_value must be greater than zero")
+			}
+			return n, nil
+		})})
+}
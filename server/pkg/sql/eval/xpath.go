@@ -0,0 +1,737 @@
+package eval
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// This file implements the subset of XPath 1.0 used with xpath() in
+// practice: location paths over the child, descendant, self, parent and
+// attribute axes with their abbreviations, name, *, text(), node() and
+// comment() tests, predicates, the boolean and comparison operators, and
+// the common core functions. Names match as written, prefix included, as
+// no namespace mappings are supported.
+
+// An xpathValue is a node set ([]*types.XMLNode), string, float64 or
+// bool.
+type xpathValue any
+
+// xpathContext is the context of evaluating an expression.
+type xpathContext struct {
+	node           *types.XMLNode
+	position, size int
+}
+
+type xpathExpr interface {
+	eval(c xpathContext) (xpathValue, error)
+}
+
+func errXPath() error {
+	return pgerror.New(pgerror.CodeDataException, "invalid XPath expression")
+}
+
+// xpathAxis is the axis of a location step.
+type xpathAxis uint8
+
+const (
+	axisChild xpathAxis = iota
+	axisDescendant
+	axisDescendantOrSelf
+	axisSelf
+	axisParent
+	axisAttribute
+)
+
+var xpathAxes = map[string]xpathAxis{
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"self":               axisSelf,
+	"parent":             axisParent,
+	"attribute":          axisAttribute,
+}
+
+// xpathStep is one step of a location path: an axis, a node test (a name,
+// "*", or "text()", "node()" or "comment()") and predicates.
+type xpathStep struct {
+	axis       xpathAxis
+	test       string
+	predicates []xpathExpr
+}
+
+func (s *xpathStep) matches(n *types.XMLNode) bool {
+	switch s.test {
+	case "node()":
+		return true
+	case "text()":
+		return n.Kind == types.XMLTextNode
+	case "comment()":
+		return n.Kind == types.XMLCommentNode
+	}
+	// The principal node kind of the attribute axis is attributes, and of
+	// the others elements.
+	want := types.XMLElementNode
+	if s.axis == axisAttribute {
+		want = types.XMLAttributeNode
+	}
+	return n.Kind == want && (s.test == "*" || s.test == n.Name)
+}
+
+// candidates returns the nodes along the axis of s from n, in document
+// order.
+func (s *xpathStep) candidates(n *types.XMLNode) []*types.XMLNode {
+	var out []*types.XMLNode
+	var descend func(*types.XMLNode)
+	descend = func(n *types.XMLNode) {
+		for _, c := range n.Children {
+			out = append(out, c)
+			descend(c)
+		}
+	}
+	switch s.axis {
+	case axisChild:
+		return n.Children
+	case axisDescendant:
+		descend(n)
+	case axisDescendantOrSelf:
+		out = append(out, n)
+		descend(n)
+	case axisSelf:
+		out = append(out, n)
+	case axisParent:
+		if n.Parent != nil {
+			out = append(out, n.Parent)
+		}
+	case axisAttribute:
+		return n.Attrs
+	}
+	return out
+}
+
+// xpathPath is a location path, relative to the context node or, if
+// absolute, to the root.
+type xpathPath struct {
+	absolute bool
+	// start, if set, gives the nodes a path following a filter expression
+	// starts from.
+	start xpathExpr
+	steps []*xpathStep
+}
+
+func (p *xpathPath) eval(c xpathContext) (xpathValue, error) {
+	nodes := []*types.XMLNode{c.node}
+	switch {
+	case p.absolute:
+		root := c.node
+		for root.Parent != nil {
+			root = root.Parent
+		}
+		nodes = []*types.XMLNode{root}
+	case p.start != nil:
+		v, err := p.start.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if nodes, ok = v.([]*types.XMLNode); !ok {
+			return nil, errXPath()
+		}
+	}
+	for _, s := range p.steps {
+		var next []*types.XMLNode
+		seen := map[*types.XMLNode]bool{}
+		for _, n := range nodes {
+			var matched []*types.XMLNode
+			for _, cand := range s.candidates(n) {
+				if s.matches(cand) {
+					matched = append(matched, cand)
+				}
+			}
+			for _, pred := range s.predicates {
+				var kept []*types.XMLNode
+				for i, m := range matched {
+					v, err := pred.eval(xpathContext{node: m, position: i + 1, size: len(matched)})
+					if err != nil {
+						return nil, err
+					}
+					if f, ok := v.(float64); ok {
+						if f == float64(i+1) {
+							kept = append(kept, m)
+						}
+					} else if xpathBool(v) {
+						kept = append(kept, m)
+					}
+				}
+				matched = kept
+			}
+			for _, m := range matched {
+				if !seen[m] {
+					seen[m] = true
+					next = append(next, m)
+				}
+			}
+		}
+		slices.SortFunc(next, func(a, b *types.XMLNode) int { return a.Order - b.Order })
+		nodes = next
+	}
+	return nodes, nil
+}
+
+type xpathLiteral struct{ v xpathValue }
+
+func (l *xpathLiteral) eval(xpathContext) (xpathValue, error) { return l.v, nil }
+
+type xpathBinary struct {
+	op          string
+	left, right xpathExpr
+}
+
+func (b *xpathBinary) eval(c xpathContext) (xpathValue, error) {
+	l, err := b.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "and", "or":
+		if xpathBool(l) == (b.op == "or") {
+			return b.op == "or", nil
+		}
+		r, err := b.right.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		return xpathBool(r), nil
+	}
+	r, err := b.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if b.op == "|" {
+		ln, lok := l.([]*types.XMLNode)
+		rn, rok := r.([]*types.XMLNode)
+		if !lok || !rok {
+			return nil, errXPath()
+		}
+		out := slices.Concat(ln, rn)
+		slices.SortFunc(out, func(a, b *types.XMLNode) int { return a.Order - b.Order })
+		return slices.Compact(out), nil
+	}
+	return xpathCompare(b.op, l, r), nil
+}
+
+// xpathCompare compares two values. A node set compares true if any of
+// its nodes does.
+func xpathCompare(op string, l, r xpathValue) bool {
+	if ln, ok := l.([]*types.XMLNode); ok {
+		if _, ok := r.(bool); ok {
+			return xpathCompare(op, xpathBool(l), r)
+		}
+		for _, n := range ln {
+			if xpathCompare(op, n.StringValue(), r) {
+				return true
+			}
+		}
+		return false
+	}
+	if rn, ok := r.([]*types.XMLNode); ok {
+		if _, ok := l.(bool); ok {
+			return xpathCompare(op, l, xpathBool(r))
+		}
+		for _, n := range rn {
+			if xpathCompare(op, l, n.StringValue()) {
+				return true
+			}
+		}
+		return false
+	}
+	var c int
+	switch {
+	case op != "=" && op != "!=":
+		a, b := xpathNumber(l), xpathNumber(r)
+		if math.IsNaN(a) || math.IsNaN(b) {
+			return false
+		}
+		c = compareFloats(a, b)
+	case isBool(l) || isBool(r):
+		if xpathBool(l) != xpathBool(r) {
+			c = 1
+		}
+	case isNumber(l) || isNumber(r):
+		a, b := xpathNumber(l), xpathNumber(r)
+		if a != b {
+			c = 1
+		}
+	default:
+		c = strings.Compare(xpathString(l), xpathString(r))
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isBool(v xpathValue) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+func isNumber(v xpathValue) bool {
+	_, ok := v.(float64)
+	return ok
+}
+
+func xpathBool(v xpathValue) bool {
+	switch v := v.(type) {
+	case []*types.XMLNode:
+		return len(v) > 0
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	}
+	return v.(bool)
+}
+
+func xpathString(v xpathValue) string {
+	switch v := v.(type) {
+	case []*types.XMLNode:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].StringValue()
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	}
+	return v.(string)
+}
+
+func xpathNumber(v xpathValue) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(xpathString(v)), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+type xpathCall struct {
+	name string
+	args []xpathExpr
+}
+
+// xpathFuncs are the supported core functions, with their minimum and
+// maximum number of arguments.
+var xpathFuncs = map[string][2]int{
+	"position": {0, 0}, "last": {0, 0}, "count": {1, 1}, "true": {0, 0}, "false": {0, 0},
+	"not": {1, 1}, "string": {0, 1}, "name": {0, 1}, "local-name": {0, 1},
+	"contains": {2, 2}, "starts-with": {2, 2}, "string-length": {0, 1}, "normalize-space": {0, 1},
+}
+
+func (f *xpathCall) eval(c xpathContext) (xpathValue, error) {
+	args := make([]xpathValue, len(f.args))
+	for i, a := range f.args {
+		var err error
+		if args[i], err = a.eval(c); err != nil {
+			return nil, err
+		}
+	}
+	// Functions of an optional node set or string default to the context
+	// node.
+	if len(args) == 0 {
+		args = []xpathValue{[]*types.XMLNode{c.node}}
+	}
+	switch f.name {
+	case "position":
+		return float64(c.position), nil
+	case "last":
+		return float64(c.size), nil
+	case "count":
+		nodes, ok := args[0].([]*types.XMLNode)
+		if !ok {
+			return nil, errXPath()
+		}
+		return float64(len(nodes)), nil
+	case "true", "false":
+		return f.name == "true", nil
+	case "not":
+		return !xpathBool(args[0]), nil
+	case "string":
+		return xpathString(args[0]), nil
+	case "name", "local-name":
+		nodes, ok := args[0].([]*types.XMLNode)
+		if !ok {
+			return nil, errXPath()
+		}
+		if len(nodes) == 0 {
+			return "", nil
+		}
+		name := nodes[0].Name
+		if f.name == "local-name" {
+			name = name[strings.IndexByte(name, ':')+1:]
+		}
+		return name, nil
+	case "contains":
+		return strings.Contains(xpathString(args[0]), xpathString(args[1])), nil
+	case "starts-with":
+		return strings.HasPrefix(xpathString(args[0]), xpathString(args[1])), nil
+	case "string-length":
+		return float64(len([]rune(xpathString(args[0])))), nil
+	}
+	return strings.Join(strings.Fields(xpathString(args[0])), " "), nil
+}
+
+// xpathParser parses an XPath expression by recursive descent over its
+// tokens.
+type xpathParser struct {
+	toks []string
+	pos  int
+}
+
+// compileXPath parses expr.
+func compileXPath(expr string) (xpathExpr, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, pgerror.New(pgerror.CodeDataException, "empty XPath expression")
+	}
+	toks, err := lexXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &xpathParser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, errXPath()
+	}
+	return e, nil
+}
+
+func lexXPath(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, errXPath()
+			}
+			toks = append(toks, s[i:i+end+2])
+			i += end + 2
+		case strings.HasPrefix(s[i:], "//"), strings.HasPrefix(s[i:], "::"), strings.HasPrefix(s[i:], ".."),
+			strings.HasPrefix(s[i:], "!="), strings.HasPrefix(s[i:], "<="), strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case strings.IndexByte("/[]()@,|=<>.*", c) >= 0:
+			toks = append(toks, s[i:i+1])
+			i++
+		case isXPathNameChar(c) && !(c >= '0' && c <= '9') && c != '-' && c != '.':
+			j := i
+			for j < len(s) && (isXPathNameChar(s[j]) || s[j] == ':' && j+1 < len(s) && s[j+1] != ':' && isXPathNameChar(s[j+1])) {
+				j++
+			}
+			// A prefixed wildcard, e.g. dc:*.
+			if j+1 < len(s) && s[j] == ':' && s[j+1] == '*' {
+				j += 2
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, errXPath()
+		}
+	}
+	return toks, nil
+}
+
+func isXPathNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '-' || c == '.' || c >= 0x80
+}
+
+func (p *xpathParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *xpathParser) accept(tok string) bool {
+	if p.peek() == tok {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) parseBinary(ops []string, next func() (xpathExpr, error)) (xpathExpr, error) {
+	l, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for slices.Contains(ops, p.peek()) {
+		op := p.toks[p.pos]
+		p.pos++
+		r, err := next()
+		if err != nil {
+			return nil, err
+		}
+		l = &xpathBinary{op: op, left: l, right: r}
+	}
+	return l, nil
+}
+
+func (p *xpathParser) parseOr() (xpathExpr, error) {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *xpathParser) parseAnd() (xpathExpr, error) {
+	return p.parseBinary([]string{"and"}, p.parseEquality)
+}
+
+func (p *xpathParser) parseEquality() (xpathExpr, error) {
+	return p.parseBinary([]string{"=", "!="}, p.parseRelational)
+}
+
+func (p *xpathParser) parseRelational() (xpathExpr, error) {
+	return p.parseBinary([]string{"<", "<=", ">", ">="}, p.parseUnion)
+}
+
+func (p *xpathParser) parseUnion() (xpathExpr, error) {
+	return p.parseBinary([]string{"|"}, p.parsePath)
+}
+
+func (p *xpathParser) parsePath() (xpathExpr, error) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return nil, errXPath()
+	case tok[0] == '\'' || tok[0] == '"':
+		p.pos++
+		return &xpathLiteral{v: tok[1 : len(tok)-1]}, nil
+	case tok[0] >= '0' && tok[0] <= '9' || len(tok) > 1 && tok[0] == '.' && tok != "..":
+		p.pos++
+		f, err := strconv.ParseFloat(tok, 64)
+		if err != nil {
+			return nil, errXPath()
+		}
+		return &xpathLiteral{v: f}, nil
+	case tok == "(":
+		p.pos++
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errXPath()
+		}
+		return p.parseFilterPath(e)
+	case p.pos+1 < len(p.toks) && p.toks[p.pos+1] == "(" && !isNodeType(tok):
+		call, err := p.parseCall()
+		if err != nil {
+			return nil, err
+		}
+		return p.parseFilterPath(call)
+	}
+	path := &xpathPath{}
+	switch {
+	case p.accept("/"):
+		path.absolute = true
+		if !p.startsStep() {
+			return path, nil
+		}
+	case p.accept("//"):
+		path.absolute = true
+		path.steps = append(path.steps, &xpathStep{axis: axisDescendantOrSelf, test: "node()"})
+	}
+	return path, p.parseSteps(path)
+}
+
+// parseFilterPath parses the path that may follow a primary expression.
+func (p *xpathParser) parseFilterPath(e xpathExpr) (xpathExpr, error) {
+	if p.peek() != "/" && p.peek() != "//" {
+		return e, nil
+	}
+	path := &xpathPath{start: e}
+	if p.accept("//") {
+		path.steps = append(path.steps, &xpathStep{axis: axisDescendantOrSelf, test: "node()"})
+	} else {
+		p.pos++
+	}
+	return path, p.parseSteps(path)
+}
+
+func isNodeType(tok string) bool {
+	return tok == "text" || tok == "node" || tok == "comment"
+}
+
+func (p *xpathParser) startsStep() bool {
+	tok := p.peek()
+	return tok == "." || tok == ".." || tok == "@" || tok == "*" ||
+		tok != "" && (isXPathNameChar(tok[0]) && !(tok[0] >= '0' && tok[0] <= '9'))
+}
+
+func (p *xpathParser) parseSteps(path *xpathPath) error {
+	for {
+		s, err := p.parseStep()
+		if err != nil {
+			return err
+		}
+		path.steps = append(path.steps, s)
+		switch {
+		case p.accept("/"):
+		case p.accept("//"):
+			path.steps = append(path.steps, &xpathStep{axis: axisDescendantOrSelf, test: "node()"})
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *xpathParser) parseStep() (*xpathStep, error) {
+	switch {
+	case p.accept("."):
+		return &xpathStep{axis: axisSelf, test: "node()"}, nil
+	case p.accept(".."):
+		return &xpathStep{axis: axisParent, test: "node()"}, nil
+	}
+	s := &xpathStep{axis: axisChild}
+	if p.accept("@") {
+		s.axis = axisAttribute
+	} else if p.pos+1 < len(p.toks) && p.toks[p.pos+1] == "::" {
+		axis, ok := xpathAxes[p.toks[p.pos]]
+		if !ok {
+			return nil, errXPath()
+		}
+		s.axis = axis
+		p.pos += 2
+	}
+	tok := p.peek()
+	if !p.startsStep() || tok == "." || tok == ".." || tok == "@" {
+		return nil, errXPath()
+	}
+	p.pos++
+	s.test = tok
+	if isNodeType(tok) && p.accept("(") {
+		if !p.accept(")") {
+			return nil, errXPath()
+		}
+		s.test = tok + "()"
+	}
+	for p.accept("[") {
+		pred, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept("]") {
+			return nil, errXPath()
+		}
+		s.predicates = append(s.predicates, pred)
+	}
+	return s, nil
+}
+
+func (p *xpathParser) parseCall() (xpathExpr, error) {
+	name := p.toks[p.pos]
+	arity, ok := xpathFuncs[name]
+	if !ok {
+		return nil, errXPath()
+	}
+	p.pos += 2 // name (
+	call := &xpathCall{name: name}
+	if !p.accept(")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.accept(")") {
+				break
+			}
+			if !p.accept(",") {
+				return nil, errXPath()
+			}
+		}
+	}
+	if len(call.args) < arity[0] || len(call.args) > arity[1] {
+		return nil, errXPath()
+	}
+	return call, nil
+}
+
+// evalXPath evaluates expr against the document doc, returning the nodes
+// it selects serialized, or its value if it is not a node set.
+func evalXPath(expr string, doc *types.XMLNode) ([]string, error) {
+	e, err := compileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	v, err := e.eval(xpathContext{node: doc, position: 1, size: 1})
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]*types.XMLNode)
+	if !ok {
+		return []string{xpathString(v)}, nil
+	}
+	out := make([]string, len(nodes))
+	for i, n := range nodes {
+		out[i] = n.Serialize()
+	}
+	return out, nil
+}
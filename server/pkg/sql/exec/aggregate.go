@@ -0,0 +1,248 @@
+package exec
+
+import (
+	"hash/maphash"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// hashAggOp groups its input in a hash table keyed by the key encoding of
+// the grouping values. It reads all of its input on the first call to Next
+// and then returns one row per group, in order of first appearance.
+//
+// Once its groups take it past work_mem, it aggregates as a grace hash
+// join partitions, if the context can spill: the rows of the groups in
+// the table go on being added to them, while those of new groups are
+// written to one of spillPartitions temporary ranges by the hash of their
+// grouping values. Once the groups of the table are returned, those of
+// each range are aggregated in turn, partitioning it again if they too
+// exceed work_mem. Groups are then only in order of first appearance
+// within the table they are aggregated in. The sets of values of DISTINCT
+// aggregates grow as rows are added to groups already in the table, so
+// with them, half of work_mem is kept for the sets; a set that grows past
+// work_mem nonetheless fails with out_of_memory.
+type hashAggOp struct {
+	ctx   *Context
+	input Operator
+	n     *planner.Aggregate
+	rows  [][]types.Datum
+	done  bool
+	mem   *memAccount
+	// pending are the partitions spilled and not aggregated yet, and seed
+	// the seed of the hash that partitions rows.
+	pending []aggPartition
+	seed    maphash.Seed
+}
+
+// aggPartition is a temporary range of input rows of a hashAggOp, whose
+// groups are aggregated in a pass of their own. depth counts the times
+// its rows were partitioned.
+type aggPartition struct {
+	r     *spillRange
+	depth int
+}
+
+const (
+	// spillPartitions is the number of partitions a hash aggregation
+	// spills the rows of new groups to.
+	spillPartitions = 16
+	// maxSpillDepth bounds the times rows are partitioned, beyond which
+	// the hash aggregation fails with out_of_memory.
+	maxSpillDepth = 4
+)
+
+// aggGroup is the state of one group.
+type aggGroup struct {
+	vals  []types.Datum
+	funcs []eval.AggregateFunc
+	// seen holds the encoded argument tuples already added to each
+	// DISTINCT aggregate.
+	seen []map[string]struct{}
+}
+
+func (o *hashAggOp) Next() ([]types.Datum, error) {
+	for len(o.rows) == 0 {
+		switch {
+		case !o.done:
+			o.done = true
+			o.seed = maphash.MakeSeed()
+			if err := o.aggregate(o.input, 0); err != nil {
+				return nil, err
+			}
+		case len(o.pending) > 0:
+			p := o.pending[0]
+			o.pending = o.pending[1:]
+			if err := o.aggregatePartition(p); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, nil
+		}
+	}
+	row := o.rows[0]
+	o.rows = o.rows[1:]
+	return row, nil
+}
+
+// aggregatePartition aggregates the groups of the rows of p, and deletes
+// its range.
+func (o *hashAggOp) aggregatePartition(p aggPartition) error {
+	in, err := p.r.rows()
+	if err == nil {
+		err = o.aggregate(in, p.depth)
+		in.Close()
+	}
+	if dropErr := p.r.drop(); err == nil {
+		err = dropErr
+	}
+	return err
+}
+
+// aggregate aggregates the groups of the rows of in, which have been
+// partitioned depth times, into o.rows, spilling the rows of the groups
+// beyond work_mem to partitions added to o.pending.
+func (o *hashAggOp) aggregate(in Operator, depth int) error {
+	argTypes := make([][]*types.T, len(o.n.Aggs))
+	for i, a := range o.n.Aggs {
+		argTypes[i] = a.ArgTypes()
+	}
+	newGroup := func(vals []types.Datum) *aggGroup {
+		g := &aggGroup{
+			vals:  vals,
+			funcs: make([]eval.AggregateFunc, len(o.n.Aggs)),
+			seen:  make([]map[string]struct{}, len(o.n.Aggs)),
+		}
+		for i, a := range o.n.Aggs {
+			g.funcs[i] = a.Agg.New(argTypes[i])
+			if a.Distinct {
+				g.seen[i] = make(map[string]struct{})
+			}
+		}
+		return g
+	}
+
+	groups := make(map[string]*aggGroup)
+	var order []*aggGroup
+	var parts []*spillRange
+	var reserve int64
+	if o.ctx.Memory != nil && slices.ContainsFunc(o.n.Aggs, func(a *eval.AggregateCall) bool { return a.Distinct }) {
+		reserve = o.ctx.Memory.WorkMem / 2
+	}
+	defer o.mem.close()
+	for {
+		row, err := in.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		o.ctx.Eval.Row = row
+		vals := make([]types.Datum, len(o.n.GroupBy))
+		var key []byte
+		for i, e := range o.n.GroupBy {
+			if vals[i], err = e.Eval(o.ctx.Eval); err != nil {
+				return err
+			}
+			if key, err = rowcodec.EncodeKey(key, e.ResolvedType(), vals[i]); err != nil {
+				return err
+			}
+		}
+		g, ok := groups[string(key)]
+		if !ok {
+			size := int64(len(key)) + rowSize(vals) + groupSize*int64(1+len(o.n.Aggs))
+			if parts == nil && !o.mem.fits(size, reserve) && o.ctx.Spill != nil && len(order) > 0 && depth < maxSpillDepth {
+				parts = make([]*spillRange, spillPartitions)
+			}
+			if parts != nil {
+				if err := o.spill(parts, key, row, depth); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := o.mem.grow(size); err != nil {
+				return err
+			}
+			g = newGroup(vals)
+			groups[string(key)] = g
+			order = append(order, g)
+		}
+		for i, a := range o.n.Aggs {
+			if err := o.add(g, i, a, argTypes[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if len(o.n.GroupBy) == 0 && len(order) == 0 && depth == 0 {
+		order = append(order, newGroup(nil))
+	}
+
+	o.rows = make([][]types.Datum, len(order))
+	for i, g := range order {
+		row := append(make([]types.Datum, 0, len(g.vals)+len(g.funcs)), g.vals...)
+		for _, f := range g.funcs {
+			d, err := f.Result(o.ctx.Eval)
+			if err != nil {
+				return err
+			}
+			row = append(row, d)
+		}
+		o.rows[i] = row
+	}
+	return nil
+}
+
+// spill writes row, whose grouping values have the key encoding key, to
+// its partition of parts, by the bits of the hash of key for depth.
+func (o *hashAggOp) spill(parts []*spillRange, key []byte, row []types.Datum, depth int) error {
+	i := (maphash.Bytes(o.seed, key) >> (4 * depth)) % spillPartitions
+	if parts[i] == nil {
+		parts[i] = newSpillRange(o.ctx, columnTypes(o.n.Input.Columns()))
+		o.pending = append(o.pending, aggPartition{r: parts[i], depth: depth + 1})
+	}
+	return parts[i].add(row)
+}
+
+// add adds the current row to aggregate i of g.
+func (o *hashAggOp) add(g *aggGroup, i int, a *eval.AggregateCall, argTypes []*types.T) error {
+	args := make([]types.Datum, len(a.Args))
+	for j, e := range a.Args {
+		d, err := e.Eval(o.ctx.Eval)
+		if err != nil {
+			return err
+		}
+		if d == types.DNull && !a.Agg.NullCall {
+			return nil
+		}
+		args[j] = d
+	}
+	if a.Distinct {
+		var key []byte
+		for j, d := range args {
+			var err error
+			if key, err = rowcodec.EncodeKey(key, argTypes[j], d); err != nil {
+				return err
+			}
+		}
+		if _, dup := g.seen[i][string(key)]; dup {
+			return nil
+		}
+		if err := o.mem.grow(int64(len(key)) + datumSize); err != nil {
+			return err
+		}
+		g.seen[i][string(key)] = struct{}{}
+	}
+	return g.funcs[i].Add(o.ctx.Eval, args)
+}
+
+func (o *hashAggOp) Close() {
+	for _, p := range o.pending {
+		p.r.drop()
+	}
+	o.mem.close()
+	o.input.Close()
+}
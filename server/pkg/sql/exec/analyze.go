@@ -0,0 +1,145 @@
+package exec
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// sampleSize is the number of rows ANALYZE samples from a table, and
+// histogramBuckets the number of buckets of the histograms it builds.
+const (
+	sampleSize       = 30000
+	histogramBuckets = 100
+)
+
+func runAnalyze(ctx *Context, n *planner.Analyze) error {
+	for _, t := range n.Tables {
+		// As in PostgreSQL, tables the role does not own are skipped.
+		if ok, err := ctx.owns(t.Owner); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		stats, err := analyzeTable(ctx, t)
+		if err != nil {
+			return err
+		}
+		if err := catalog.PutTableStats(ctx.Txn, t.ID, stats); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// analyzeTable reads the rows of t, keeping a uniform sample of them by
+// reservoir sampling, and computes the statistics of t from the sample.
+// The sample is seeded by the table's ID, so that analyzing the same rows
+// gives the same statistics.
+func analyzeTable(ctx *Context, t *catalog.Table) (*catalog.TableStats, error) {
+	p := startProgress(ctx, "ANALYZE", t)
+	defer p.finish()
+	p.phase("acquiring sample rows", -1)
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	op := newScan(ctx, &planner.Scan{
+		Table: t,
+		Index: t.PrimaryIndex,
+		Spans: []planner.Span{{Start: prefix, End: rowcodec.PrefixEnd(prefix)}},
+	})
+	defer op.Close()
+	rng := rand.New(rand.NewPCG(uint64(t.ID), 0))
+	var sample [][]types.Datum
+	var count int64
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		count++
+		p.tuple()
+		if len(sample) < sampleSize {
+			sample = append(sample, row)
+		} else if i := rng.Int64N(count); i < sampleSize {
+			sample[i] = row
+		}
+	}
+	p.phase("computing statistics", -1)
+	stats := &catalog.TableStats{RowCount: count}
+	for ord, c := range t.Columns {
+		stats.Columns = append(stats.Columns, columnStats(c, ord, sample, count))
+	}
+	return stats, nil
+}
+
+// columnStats computes the statistics of the column c, at ordinal ord of
+// the rows of sample, which was taken from rows rows.
+func columnStats(c *catalog.Column, ord int, sample [][]types.Datum, rows int64) *catalog.ColumnStats {
+	cs := &catalog.ColumnStats{Column: c.ID}
+	if len(sample) == 0 {
+		return cs
+	}
+	// Keys of registered types are not ordered like their values, so
+	// they get no histogram.
+	ordered := c.Type.Extension() == nil
+	counts := make(map[string]int)
+	var keys [][]byte
+	nulls := 0
+	for _, row := range sample {
+		d := row[ord]
+		if d == types.DNull {
+			nulls++
+			continue
+		}
+		k, err := rowcodec.EncodeKey(nil, c.Type, d)
+		if err != nil {
+			// The type has no key encoding; values are told apart by
+			// their text.
+			ordered = false
+			counts[d.String()]++
+			continue
+		}
+		counts[string(k)]++
+		keys = append(keys, k)
+	}
+	cs.NullFrac = float64(nulls) / float64(len(sample))
+	cs.Distinct = estimateDistinct(len(sample)-nulls, counts, float64(rows)*(1-cs.NullFrac))
+	if ordered && len(keys) >= 2 {
+		slices.SortFunc(keys, bytes.Compare)
+		buckets := min(histogramBuckets, len(keys)-1)
+		for i := range buckets + 1 {
+			cs.Histogram = append(cs.Histogram, keys[i*(len(keys)-1)/buckets])
+		}
+	}
+	return cs
+}
+
+// estimateDistinct estimates the number of distinct values among rows
+// non-NULL values from a sample of n of them, in which counts counts the
+// occurrences of each value.
+func estimateDistinct(n int, counts map[string]int, rows float64) float64 {
+	d := float64(len(counts))
+	if n == 0 || float64(n) >= rows {
+		return d
+	}
+	f1 := 0
+	for _, c := range counts {
+		if c == 1 {
+			f1++
+		}
+	}
+	if f1 == len(counts) {
+		// Every sampled value is unique, as in a key column.
+		return rows
+	}
+	// Haas and Stokes' Duj1 estimator, as PostgreSQL uses.
+	est := float64(n) * d / (float64(n) - float64(f1) + float64(f1)*float64(n)/rows)
+	return min(max(est, d), rows)
+}
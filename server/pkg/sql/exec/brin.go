@@ -0,0 +1,176 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// addToRange widens the summary of the range of row in the BRIN index idx
+// to cover it. A row after the last range is left for
+// summarizeNewRanges.
+func addToRange(ctx *Context, t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, row)
+	if err != nil {
+		return err
+	}
+	prefix := rowcodec.IndexPrefix(t.ID, idx.ID)
+	primary := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	it, err := ctx.Txn.Scan(append(bytes.Clone(prefix), pk[len(primary):]...), rowcodec.PrefixEnd(prefix))
+	if err != nil {
+		return err
+	}
+	k, v, err := it.Next()
+	k = bytes.Clone(k)
+	it.Close()
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s, err := rowcodec.DecodeRangeSummary(t, idx, v)
+	if err != nil {
+		return err
+	}
+	if !s.Add(t, idx, row) {
+		return nil
+	}
+	if v, err = rowcodec.EncodeRangeSummary(t, idx, s); err != nil {
+		return err
+	}
+	return ctx.Txn.Put(k, v)
+}
+
+// summarizeNewRanges summarizes the rows after the last range of the BRIN
+// index idx in ranges of PagesPerRange rows, leaving fewer than that for a
+// later statement.
+func summarizeNewRanges(ctx *Context, t *catalog.Table, idx *catalog.Index) error {
+	prefix := rowcodec.IndexPrefix(t.ID, idx.ID)
+	primary := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	start := primary
+	last, err := ctx.Txn.Get(prefix)
+	switch {
+	case err == nil:
+		start = rowcodec.PrefixEnd(append(bytes.Clone(primary), last...))
+	case !errors.Is(err, engine.ErrNotFound):
+		return err
+	}
+	rows, err := readAll(ctx, &planner.Scan{
+		Table: t,
+		Index: t.PrimaryIndex,
+		Spans: []planner.Span{{Start: start, End: rowcodec.PrefixEnd(primary)}},
+	})
+	if err != nil {
+		return err
+	}
+	n := idx.BRIN.PagesPerRange
+	if len(rows) < n {
+		return nil
+	}
+	for ; len(rows) >= n; rows = rows[n:] {
+		s := make(rowcodec.RangeSummary, len(idx.ColumnIDs))
+		for _, row := range rows[:n] {
+			s.Add(t, idx, row)
+		}
+		v, err := rowcodec.EncodeRangeSummary(t, idx, s)
+		if err != nil {
+			return err
+		}
+		pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, rows[n-1])
+		if err != nil {
+			return err
+		}
+		last = pk[len(primary):]
+		if err := ctx.Txn.Put(append(bytes.Clone(prefix), last...), v); err != nil {
+			return err
+		}
+	}
+	return ctx.Txn.Put(prefix, last)
+}
+
+// brinScan returns a scan of the primary key spans of the ranges a BRIN
+// scan cannot skip, and of the rows after the last range.
+func brinScan(ctx *Context, n *planner.BRINScan) (Operator, error) {
+	t, idx := n.Table, n.Index
+	prefix := rowcodec.IndexPrefix(t.ID, idx.ID)
+	primary := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	spans := []planner.Span{}
+	add := func(start, end []byte) {
+		if l := len(spans); l > 0 && bytes.Equal(spans[l-1].End, start) {
+			spans[l-1].End = end
+		} else {
+			spans = append(spans, planner.Span{Start: start, End: end})
+		}
+	}
+	it, err := ctx.Txn.Scan(prefix, rowcodec.PrefixEnd(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	start := primary
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(k) == len(prefix) {
+			// The entry of the last summarized row.
+			continue
+		}
+		s, err := rowcodec.DecodeRangeSummary(t, idx, v)
+		if err != nil {
+			return nil, err
+		}
+		end := rowcodec.PrefixEnd(append(bytes.Clone(primary), k[len(prefix):]...))
+		if mayMeet(s, n.Bounds) {
+			add(start, end)
+		}
+		start = end
+	}
+	add(start, rowcodec.PrefixEnd(primary))
+	return newScan(ctx, &planner.Scan{Table: t, Index: t.PrimaryIndex, Spans: spans, Filter: n.Filter}), nil
+}
+
+// mayMeet reports whether a range with summary s may have rows whose
+// values meet bounds.
+func mayMeet(s rowcodec.RangeSummary, bounds []planner.Bound) bool {
+	for _, b := range bounds {
+		c := s[b.Col]
+		if b.Eq != nil {
+			if !slices.ContainsFunc(b.Eq, func(d types.Datum) bool {
+				if d == types.DNull {
+					return c.HasNull
+				}
+				return c.Min != nil && types.CompareDatums(d, c.Min) >= 0 && types.CompareDatums(d, c.Max) <= 0
+			}) {
+				return false
+			}
+			continue
+		}
+		if c.Min == nil {
+			// Comparisons are never true for NULL.
+			return false
+		}
+		if b.Lo != nil {
+			if cmp := types.CompareDatums(c.Max, b.Lo); cmp < 0 || (cmp == 0 && !b.LoInc) {
+				return false
+			}
+		}
+		if b.Hi != nil {
+			if cmp := types.CompareDatums(c.Min, b.Hi); cmp > 0 || (cmp == 0 && !b.HiInc) {
+				return false
+			}
+		}
+	}
+	return true
+}
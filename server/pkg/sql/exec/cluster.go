@@ -0,0 +1,116 @@
+package exec
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+func runCluster(ctx *Context, n *planner.Cluster) error {
+	for _, t := range n.Tables {
+		// As in PostgreSQL, CLUSTER of every table skips those the role
+		// does not own.
+		if ok, err := ctx.owns(t.Owner); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		if err := clusterTable(ctx, t, n.Index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterTable rewrites the rows of t, and the entries of its indexes,
+// into a key range it first empties, so that they are written in order
+// rather than where churn left them. If idx is set, the rows of t, which
+// is keyed by its hidden rowid, take its order: the rowids they had are
+// handed out again in that order.
+//
+// It runs in the statement's transaction, so sessions reading t see its
+// rows as they were until it commits, and a transaction writing to t
+// concurrently conflicts with it.
+func clusterTable(ctx *Context, t *catalog.Table, idx *catalog.Index) error {
+	p := startProgress(ctx, "CLUSTER", t)
+	if idx != nil {
+		p.p.IndexRelname = idx.Name
+	}
+	defer p.finish()
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	rows, err := p.readAll("seq scanning heap", &planner.Scan{
+		Table: t,
+		Index: t.PrimaryIndex,
+		Spans: []planner.Span{{Start: prefix, End: rowcodec.PrefixEnd(prefix)}},
+	})
+	if err != nil {
+		return err
+	}
+	if idx != nil {
+		p.phase("sorting tuples", int64(len(rows)))
+		if err := sortByIndex(t, idx, rows); err != nil {
+			return err
+		}
+	}
+	p.phase("writing new heap", int64(len(rows)))
+	table := rowcodec.TablePrefix(t.ID)
+	if err := ctx.Txn.DeleteRange(table, rowcodec.PrefixEnd(table)); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, row)
+		if err != nil {
+			return err
+		}
+		if err := writeRow(ctx, t, pk, row); err != nil {
+			return err
+		}
+		p.tuple()
+	}
+	for _, sec := range t.Indexes {
+		if sec.BRIN != nil {
+			p.phase("rebuilding index", -1)
+			if err := summarizeNewRanges(ctx, t, sec); err != nil {
+				return err
+			}
+			continue
+		}
+		p.phase("rebuilding index", int64(len(rows)))
+		for _, row := range rows {
+			if err := putIndexEntry(ctx, t, sec, row); err != nil {
+				return err
+			}
+			p.tuple()
+		}
+	}
+	return nil
+}
+
+// sortByIndex sorts rows, which are in the order of their hidden rowids,
+// by their keys in idx, and gives them the rowids in turn.
+func sortByIndex(t *catalog.Table, idx *catalog.Index, rows [][]types.Datum) error {
+	type keyed struct {
+		key []byte
+		row []types.Datum
+	}
+	ord := t.ColumnOrdinals(t.PrimaryIndex)[0]
+	ids := make([]types.Datum, len(rows))
+	sorted := make([]keyed, len(rows))
+	for i, row := range rows {
+		key, err := rowcodec.EncodeIndexKey(t, idx, row)
+		if err != nil {
+			return err
+		}
+		ids[i], sorted[i] = row[ord], keyed{key, row}
+	}
+	slices.SortFunc(sorted, func(a, b keyed) int { return bytes.Compare(a.key, b.key) })
+	for i, k := range sorted {
+		k.row[ord] = ids[i]
+		rows[i] = k.row
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package exec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// CopyReject is a row of COPY FROM that ON_ERROR ignore skipped.
+type CopyReject struct {
+	// Line is the number of the line the row starts at, counting from 1.
+	Line int64
+	// Column names the column of the field that was invalid, if the row
+	// was rejected for one field.
+	Column string
+	Err    error
+	// Raw is the text of the row, without its line ending.
+	Raw string
+}
+
+// runCopy reads the rows of COPY FROM and inserts them. Rows that
+// ON_ERROR ignore skips are returned as the result's Rejects and inserted
+// into the error table, if there is one.
+func runCopy(ctx *Context, n *planner.Copy) (*Result, error) {
+	t := n.Insert.Table
+	p := startProgress(ctx, "COPY", t)
+	defer p.finish()
+	r := ctx.CopyIn
+	switch {
+	case n.Source.File != "":
+		f, err := os.Open(n.Source.File)
+		if err != nil {
+			code := pgerror.CodeIOError
+			if errors.Is(err, fs.ErrNotExist) {
+				code = pgerror.CodeUndefinedFile
+			}
+			return nil, pgerror.Newf(code, "could not open file %q for reading: %v", n.Source.File, errors.Unwrap(err))
+		}
+		defer f.Close()
+		r = f
+	case r == nil:
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "COPY FROM STDIN is not supported without the client's data")
+	}
+	p.phase("reading data", -1)
+	cr := &copyReader{src: &n.Source, r: bufio.NewReader(r)}
+	var rows [][]types.Datum
+	var rejects []CopyReject
+	for first := true; ; first = false {
+		if err := ctx.interrupted(); err != nil {
+			return nil, err
+		}
+		rec, err := cr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, copyError(err, t.Name, cr.line, "")
+		}
+		if first && n.Source.Header {
+			continue
+		}
+		row, col, err := copyRow(ctx.Eval, n.Insert, rec.fields)
+		if err != nil {
+			if !n.Source.IgnoreErrors {
+				return nil, copyError(err, t.Name, rec.line, col)
+			}
+			rejects = append(rejects, CopyReject{Line: rec.line, Column: col, Err: err, Raw: rec.raw})
+			if limit := n.Source.RejectLimit; limit > 0 && int64(len(rejects)) > limit {
+				return nil, pgerror.Newf(pgerror.CodeDataException,
+					"skipped more than REJECT_LIMIT (%d) rows due to data type incompatibility", limit)
+			}
+			continue
+		}
+		rows = append(rows, row)
+		p.tuple()
+	}
+	p.phase("inserting rows", int64(len(rows)))
+	res, err := insertRows(ctx, p, n.Insert, rows)
+	if err != nil {
+		return nil, err
+	}
+	if n.Errors != nil && len(rejects) > 0 {
+		errRows := make([][]types.Datum, len(rejects))
+		for i, rej := range rejects {
+			errRows[i] = []types.Datum{types.DInt(rej.Line), types.DString(rej.Err.Error()), types.DString(rej.Raw)}
+		}
+		p.phase("inserting rejected rows", int64(len(errRows)))
+		if _, err := insertRows(ctx, p, n.Errors, errRows); err != nil {
+			return nil, err
+		}
+	}
+	res.Rejects = rejects
+	return res, nil
+}
+
+// copyRow converts the fields of a row of COPY to the types of the target
+// columns of ins. If a field is invalid, it also returns the name of its
+// column.
+func copyRow(ctx *eval.Context, ins *planner.Insert, fields []copyField) ([]types.Datum, string, error) {
+	t := ins.Table
+	switch {
+	case len(fields) > len(ins.Targets):
+		return nil, "", pgerror.New(pgerror.CodeBadCopyFileFormat, "extra data after last expected column")
+	case len(fields) < len(ins.Targets):
+		name := t.Columns[ins.Targets[len(fields)]].Name
+		return nil, name, pgerror.Newf(pgerror.CodeBadCopyFileFormat, "missing data for column %q", name)
+	}
+	row := make([]types.Datum, len(fields))
+	for i, f := range fields {
+		col := t.Columns[ins.Targets[i]]
+		if f.null {
+			row[i] = types.DNull
+			continue
+		}
+		d, err := types.ParseDatum(col.Type, f.text)
+		if err == nil {
+			d, err = eval.AssignCast(ctx, d, col.Type)
+		}
+		if err != nil {
+			return nil, col.Name, err
+		}
+		row[i] = d
+	}
+	return row, "", nil
+}
+
+// copyError adds where in the data of COPY into table err occurred to it,
+// as PostgreSQL's context of the error does.
+func copyError(err error, table string, line int64, column string) error {
+	e := *pgerror.Flatten(err)
+	e.Detail = fmt.Sprintf("COPY %s, line %d", table, line)
+	if column != "" {
+		e.Detail += ", column " + column
+	}
+	return &e
+}
+
+// copyField is a field of a row of COPY data.
+type copyField struct {
+	text string
+	null bool
+}
+
+// copyRecord is a row of COPY data, which starts at line and reads raw.
+type copyRecord struct {
+	fields []copyField
+	line   int64
+	raw    string
+}
+
+// copyReader splits the data of COPY FROM into rows and fields, in the
+// text or CSV format. The data ends at its end or at a line of \. alone.
+type copyReader struct {
+	src *planner.CopySource
+	r   *bufio.Reader
+	// line counts the lines read.
+	line int64
+	done bool
+}
+
+// readLine returns the next line without its line ending, or io.EOF.
+func (c *copyReader) readLine() (string, error) {
+	if c.done {
+		return "", io.EOF
+	}
+	line, err := c.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		c.done = true
+		return "", io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return "", pgerror.Newf(pgerror.CodeIOError, "could not read COPY data: %v", err)
+	}
+	c.line++
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == `\.` {
+		c.done = true
+		return "", io.EOF
+	}
+	return line, nil
+}
+
+// next returns the next row, or io.EOF at the end of the data. Fields
+// that are not valid text, which escapes of the text format can spell, are
+// errors.
+func (c *copyReader) next() (*copyRecord, error) {
+	rec, err := c.split()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range rec.fields {
+		if err := types.CheckText(f.text); err != nil {
+			return nil, err
+		}
+	}
+	return rec, nil
+}
+
+// split reads the next row and splits it into its fields.
+func (c *copyReader) split() (*copyRecord, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	rec := &copyRecord{line: c.line, raw: line}
+	if !c.src.CSV {
+		rec.fields = c.splitText(line)
+		return rec, nil
+	}
+	for {
+		var quoted bool
+		rec.fields, quoted = c.splitCSV(rec.raw)
+		if !quoted {
+			return rec, nil
+		}
+		// A quoted field goes on over the line break.
+		line, err := c.readLine()
+		if err == io.EOF {
+			return nil, pgerror.New(pgerror.CodeBadCopyFileFormat, "unterminated CSV quoted field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.raw += "\n" + line
+	}
+}
+
+// splitText splits a line of the text format into its fields. A backslash
+// escapes the next character, and a field that reads Null before its
+// escapes are undone is NULL.
+func (c *copyReader) splitText(line string) []copyField {
+	var fields []copyField
+	start := 0
+	for i := 0; ; i++ {
+		if i < len(line) && line[i] == '\\' {
+			i++
+			continue
+		}
+		if i < len(line) && line[i] != c.src.Delimiter {
+			continue
+		}
+		raw := line[start:min(i, len(line))]
+		if raw == c.src.Null {
+			fields = append(fields, copyField{null: true})
+		} else {
+			fields = append(fields, copyField{text: unescapeCopyText(raw)})
+		}
+		if i >= len(line) {
+			return fields
+		}
+		start = i + 1
+	}
+}
+
+// unescapeCopyText undoes the backslash escapes of a field of the text
+// format.
+func unescapeCopyText(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			v, n := 0, 0
+			for ; n < 2 && i+1 < len(s) && isHexDigit(s[i+1]); n++ {
+				i++
+				v = v*16 + hexValue(s[i])
+			}
+			if n == 0 {
+				b.WriteByte('x')
+				continue
+			}
+			b.WriteByte(byte(v))
+		default:
+			if c < '0' || c > '7' {
+				b.WriteByte(c)
+				continue
+			}
+			v := int(c - '0')
+			for n := 1; n < 3 && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '7'; n++ {
+				i++
+				v = v*8 + int(s[i]-'0')
+			}
+			b.WriteByte(byte(v))
+		}
+	}
+	return b.String()
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= 'a':
+		return int(c-'a') + 10
+	case c >= 'A':
+		return int(c-'A') + 10
+	}
+	return int(c - '0')
+}
+
+// splitCSV splits the text of a CSV row into its fields, reporting whether
+// it ends within a quoted field. An unquoted field that reads Null is
+// NULL.
+func (c *copyReader) splitCSV(s string) (fields []copyField, quoted bool) {
+	var b strings.Builder
+	start, wasQuoted := 0, false
+	for i := 0; ; i++ {
+		if i == len(s) || !quoted && s[i] == c.src.Delimiter {
+			if !wasQuoted && s[start:i] == c.src.Null {
+				fields = append(fields, copyField{null: true})
+			} else {
+				fields = append(fields, copyField{text: b.String()})
+			}
+			if i == len(s) {
+				return fields, quoted
+			}
+			b.Reset()
+			start, wasQuoted = i+1, false
+			continue
+		}
+		ch := s[i]
+		switch {
+		case !quoted && ch == c.src.Quote:
+			quoted, wasQuoted = true, true
+		case quoted && ch == c.src.Escape && i+1 < len(s) && (s[i+1] == c.src.Quote || s[i+1] == c.src.Escape):
+			i++
+			b.WriteByte(s[i])
+		case quoted && ch == c.src.Quote:
+			quoted = false
+		default:
+			b.WriteByte(ch)
+		}
+	}
+}
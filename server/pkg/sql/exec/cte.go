@@ -0,0 +1,141 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// cteRows holds the rows of a materialized CTE read so far. The CTE's
+// query runs only as far as its scans read, so a LIMIT over a scan of an
+// unbounded recursive query ends it.
+type cteRows struct {
+	// input produces the rows not yet read. It is nil once exhausted.
+	input Operator
+	rows  [][]types.Datum
+}
+
+// cte returns the rows of n, starting its query on first use.
+func (ctx *Context) cte(n *planner.CTE) (*cteRows, error) {
+	if r, ok := ctx.ctes[n]; ok {
+		return r, nil
+	}
+	in, err := Build(ctx, n.Plan)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.ctes == nil {
+		ctx.ctes = map[*planner.CTE]*cteRows{}
+	}
+	r := &cteRows{input: in}
+	ctx.ctes[n] = r
+	return r, nil
+}
+
+// closeCTEs stops the queries of the CTEs of the statement.
+func (ctx *Context) closeCTEs() {
+	for _, r := range ctx.ctes {
+		if r.input != nil {
+			r.input.Close()
+		}
+	}
+	ctx.ctes = nil
+}
+
+// cteScanOp reads the rows of a materialized CTE, computing those no
+// scan has read yet.
+type cteScanOp struct {
+	rows *cteRows
+	pos  int
+}
+
+func (o *cteScanOp) Next() ([]types.Datum, error) {
+	r := o.rows
+	if o.pos == len(r.rows) {
+		if r.input == nil {
+			return nil, nil
+		}
+		row, err := r.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			r.input.Close()
+			r.input = nil
+			return nil, nil
+		}
+		r.rows = append(r.rows, row)
+	}
+	o.pos++
+	return r.rows[o.pos-1], nil
+}
+
+func (o *cteScanOp) Close() {}
+
+// recursiveUnionOp evaluates a recursive query, returning each row as it
+// is produced. The rows of each iteration become the work table the next
+// one reads.
+type recursiveUnionOp struct {
+	ctx   *Context
+	n     *planner.RecursiveUnion
+	input Operator
+	// next collects the rows of the current iteration.
+	next [][]types.Datum
+	// seen holds the key encodings of the rows returned, unless the
+	// union keeps duplicates.
+	seen map[string]struct{}
+}
+
+func newRecursiveUnion(ctx *Context, n *planner.RecursiveUnion) (Operator, error) {
+	in, err := Build(ctx, n.Initial)
+	if err != nil {
+		return nil, err
+	}
+	o := &recursiveUnionOp{ctx: ctx, n: n, input: in}
+	if !n.All {
+		o.seen = map[string]struct{}{}
+	}
+	return o, nil
+}
+
+func (o *recursiveUnionOp) Next() ([]types.Datum, error) {
+	for {
+		row, err := o.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			if o.seen != nil {
+				var key []byte
+				for i, d := range row {
+					if key, err = rowcodec.EncodeKey(key, o.n.Cols[i].Type, d); err != nil {
+						return nil, err
+					}
+				}
+				if _, dup := o.seen[string(key)]; dup {
+					continue
+				}
+				o.seen[string(key)] = struct{}{}
+			}
+			o.next = append(o.next, row)
+			return row, nil
+		}
+		o.input.Close()
+		o.input = &rowsOp{}
+		if len(o.next) == 0 {
+			return nil, nil
+		}
+		if err := o.ctx.interrupted(); err != nil {
+			return nil, err
+		}
+		if o.ctx.work == nil {
+			o.ctx.work = map[*planner.RecursiveUnion][][]types.Datum{}
+		}
+		o.ctx.work[o.n], o.next = o.next, nil
+		if o.input, err = Build(o.ctx, o.n.Recursive); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (o *recursiveUnionOp) Close() { o.input.Close() }
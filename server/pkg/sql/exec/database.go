@@ -0,0 +1,62 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+)
+
+func runCreateDatabase(ctx *Context, n *planner.CreateDatabase) error {
+	return catalog.CreateDatabase(ctx.Txn, n.Database)
+}
+
+// runDropDatabase drops a database no other session is connected to, or,
+// with FORCE, terminates those that are first. A database with
+// replication slots cannot be dropped.
+func runDropDatabase(ctx *Context, n *planner.DropDatabase) error {
+	if n.Database == nil {
+		return nil
+	}
+	slots, err := catalog.ListReplicationSlots(ctx.Txn)
+	if err != nil {
+		return err
+	}
+	used := 0
+	for _, s := range slots {
+		if s.Database == n.Database.ID {
+			used++
+		}
+	}
+	if used > 0 {
+		detail := "There is 1 slot."
+		if used > 1 {
+			detail = fmt.Sprintf("There are %d slots.", used)
+		}
+		return pgerror.Newf(pgerror.CodeObjectInUse, "database %q is used by a logical replication slot", n.Database.Name).
+			WithDetail(detail)
+	}
+	var others []int32
+	if ctx.Activity != nil {
+		for _, a := range ctx.Activity() {
+			if a.Database == n.Database.Name && a.PID != ctx.Eval.BackendPID {
+				others = append(others, a.PID)
+			}
+		}
+	}
+	if len(others) > 0 {
+		if !n.Force || ctx.TerminateBackend == nil {
+			detail := "There is 1 other session using the database."
+			if len(others) > 1 {
+				detail = fmt.Sprintf("There are %d other sessions using the database.", len(others))
+			}
+			return pgerror.Newf(pgerror.CodeObjectInUse, "database %q is being accessed by other users", n.Database.Name).
+				WithDetail(detail)
+		}
+		for _, pid := range others {
+			ctx.TerminateBackend(pid)
+		}
+	}
+	return catalog.DropDatabase(ctx.Txn, n.Database)
+}
@@ -0,0 +1,317 @@
+package exec
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+func runCreateTable(ctx *Context, n *planner.CreateTable) error {
+	if n.IfNotExists {
+		existing, err := catalog.LookupTable(ctx.Txn, n.Table.Schema, n.Table.Name)
+		if err != nil || existing != nil {
+			return err
+		}
+	}
+	owner, err := ctx.roleID()
+	if err != nil {
+		return err
+	}
+	n.Table.Owner = owner
+	if err := catalog.CreateTable(ctx.Txn, n.Table); err != nil {
+		return err
+	}
+	if n.Table.Compression != nil {
+		if err := setCompression(ctx, n.Table); err != nil {
+			return err
+		}
+	}
+	for _, seq := range n.Sequences {
+		seq.OwnedBy.Table, seq.Owner = n.Table.ID, owner
+		if err := catalog.CreateSequence(ctx.Txn, seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setCompression sets the compression of the key range of t in the
+// engine, as its storage parameters give it. Engines that do not compress
+// are left as they are, and the parameter is refused with
+// feature_not_supported if the engine cannot compress with its algorithm.
+// Like VACUUM's compaction, it is of the engine, not of the statement's
+// transaction, so it stays if the transaction rolls back; that only
+// changes how the data is compressed, not what it is.
+func setCompression(ctx *Context, t *catalog.Table) error {
+	c, ok := ctx.Engine.(engine.Compressor)
+	if !ok {
+		return nil
+	}
+	var comp *engine.Compression
+	if t.Compression != nil {
+		comp = &engine.Compression{Algorithm: t.Compression.Algorithm, DictionaryBytes: t.Compression.DictionarySize}
+	}
+	err := c.SetCompression(rowcodec.TablePrefix(t.ID), comp)
+	if errors.Is(err, errors.ErrUnsupported) && comp != nil {
+		return pgerror.Newf(pgerror.CodeFeatureNotSupported, "the storage engine cannot compress with %s yet", comp.Algorithm)
+	}
+	return err
+}
+
+// runCreateIndex adds the index to its table and writes an entry for every
+// existing row, or for a BRIN index, summarizes the rows.
+func runCreateIndex(ctx *Context, n *planner.CreateIndex) error {
+	if n.Exists {
+		return nil
+	}
+	t := n.Table
+	idx := t.AddIndex(n.Index.Name, n.Index.Unique, n.Index.ColumnIDs)
+	idx.Descending = n.Index.Descending
+	idx.HNSW = n.Index.HNSW
+	idx.Inverted = n.Index.Inverted
+	idx.Hash = n.Index.Hash
+	idx.BRIN = n.Index.BRIN
+	if err := catalog.AddIndex(ctx.Txn, t, idx); err != nil {
+		return err
+	}
+	if idx.BRIN != nil {
+		return summarizeNewRanges(ctx, t, idx)
+	}
+	p := startProgress(ctx, "CREATE INDEX", t)
+	p.p.IndexRelname = idx.Name
+	defer p.finish()
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	rows, err := p.readAll("building index: scanning table", &planner.Scan{
+		Table: t,
+		Index: t.PrimaryIndex,
+		Spans: []planner.Span{{Start: prefix, End: rowcodec.PrefixEnd(prefix)}},
+	})
+	if err != nil {
+		return err
+	}
+	p.phase("building index: loading tuples in tree", int64(len(rows)))
+	for _, row := range rows {
+		if err := putIndexEntry(ctx, t, idx, row); err != nil {
+			return err
+		}
+		p.tuple()
+	}
+	return nil
+}
+
+// runAlterTable stores the changed descriptor, deletes the entries of
+// dropped indexes and, if columns were added with a default or NOT NULL or
+// with a unique index, rewrites every row to fill and check them. Other
+// changes leave rows as they are.
+func runAlterTable(ctx *Context, n *planner.AlterTable) error {
+	t := n.Table
+	if t == nil {
+		return nil
+	}
+	if err := catalog.AlterTable(ctx.Txn, n.Old, t); err != nil {
+		return err
+	}
+	if old, c := n.Old.Compression, t.Compression; (old == nil) != (c == nil) || c != nil && *old != *c {
+		if err := setCompression(ctx, t); err != nil {
+			return err
+		}
+	}
+	if err := dropSequences(ctx, n.DropSequences); err != nil {
+		return err
+	}
+	for _, seq := range n.CreateSequences {
+		seq.Owner = t.Owner
+		if err := catalog.CreateSequence(ctx.Txn, seq); err != nil {
+			return err
+		}
+	}
+	for _, idx := range n.Old.Indexes {
+		if slices.ContainsFunc(t.Indexes, func(x *catalog.Index) bool { return x.ID == idx.ID }) {
+			continue
+		}
+		prefix := rowcodec.IndexPrefix(t.ID, idx.ID)
+		if err := ctx.Txn.DeleteRange(prefix, rowcodec.PrefixEnd(prefix)); err != nil {
+			return err
+		}
+	}
+	var added []*catalog.Index
+	for _, idx := range t.Indexes {
+		if idx.ID >= n.Old.NextIndexID {
+			added = append(added, idx)
+		}
+	}
+	// Existing rows are checked against added NOT NULL columns, and
+	// against columns made NOT NULL by becoming identity columns.
+	var notNull []int
+	for ord, c := range t.Columns {
+		if c.Nullable {
+			continue
+		}
+		if old := n.Old.ColumnOrdinal(c.ID); old < 0 || n.Old.Columns[old].Nullable {
+			notNull = append(notNull, ord)
+		}
+	}
+	rewrite := len(n.Fills) > 0 || len(added) > 0 || len(notNull) > 0
+	if !rewrite && len(n.Validate) == 0 && len(n.ValidateForeignKeys) == 0 {
+		return nil
+	}
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	rows, err := readAll(ctx, &planner.Scan{
+		Table: t,
+		Index: t.PrimaryIndex,
+		Spans: []planner.Span{{Start: prefix, End: rowcodec.PrefixEnd(prefix)}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		// Generated columns are filled from the rest of the row.
+		ctx.Eval.Row = row
+		for _, f := range n.Fills {
+			if row[f.Ord], err = f.Expr.Eval(ctx.Eval); err != nil {
+				return err
+			}
+		}
+		for _, ord := range notNull {
+			if row[ord] == types.DNull {
+				return pgerror.Newf(pgerror.CodeNotNullViolation,
+					"column %q of relation %q contains null values", t.Columns[ord].Name, t.Name).
+					WithTable(t.Name).WithColumn(t.Columns[ord].Name)
+			}
+		}
+		for _, ck := range n.Validate {
+			ok, err := satisfies(ctx, ck, row)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return pgerror.Newf(pgerror.CodeCheckViolation,
+					"check constraint %q of relation %q is violated by some row", ck.Name, t.Name).
+					WithTable(t.Name).WithConstraint(ck.Name)
+			}
+		}
+		if !rewrite {
+			continue
+		}
+		if err := prepareRow(ctx, t, nil, row); err != nil {
+			return err
+		}
+		pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, row)
+		if err != nil {
+			return err
+		}
+		if err := writeRow(ctx, t, pk, row); err != nil {
+			return err
+		}
+		for _, idx := range added {
+			if err := putIndexEntry(ctx, t, idx, row); err != nil {
+				return err
+			}
+		}
+	}
+	// Check the foreign keys once every row is rewritten, as one may
+	// reference the table itself.
+	for _, row := range rows {
+		for _, fk := range n.ValidateForeignKeys {
+			if err := checkReferenced(ctx, fkRow{t: t, fk: fk, row: row}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runDropTable(ctx *Context, n *planner.DropTable) error {
+	for _, t := range n.Detached {
+		if err := catalog.WriteTable(ctx.Txn, t); err != nil {
+			return err
+		}
+	}
+	for _, t := range n.Tables {
+		prefix := rowcodec.TablePrefix(t.ID)
+		if err := ctx.Txn.DeleteRange(prefix, rowcodec.PrefixEnd(prefix)); err != nil {
+			return err
+		}
+		if err := catalog.DropTable(ctx.Txn, t); err != nil {
+			return err
+		}
+	}
+	return dropSequences(ctx, n.Sequences)
+}
+
+func runCreateSchema(ctx *Context, n *planner.CreateSchema) error {
+	if n.Exists {
+		return nil
+	}
+	if n.Schema.Owner == catalog.Public {
+		owner, err := ctx.roleID()
+		if err != nil {
+			return err
+		}
+		n.Schema.Owner = owner
+	}
+	return catalog.CreateSchema(ctx.Txn, n.Schema)
+}
+
+func runDropSchema(ctx *Context, n *planner.DropSchema) error {
+	if n.DropTable != nil {
+		if err := runDropTable(ctx, n.DropTable); err != nil {
+			return err
+		}
+	}
+	if err := dropSequences(ctx, n.Sequences); err != nil {
+		return err
+	}
+	for _, s := range n.Schemas {
+		if err := catalog.DropSchema(ctx.Txn, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTruncate deletes the key range of each table, rows and index entries
+// alike, in one call rather than row by row.
+func runTruncate(ctx *Context, n *planner.Truncate) error {
+	for _, t := range n.Tables {
+		prefix := rowcodec.TablePrefix(t.ID)
+		if err := ctx.Txn.DeleteRange(prefix, rowcodec.PrefixEnd(prefix)); err != nil {
+			return err
+		}
+		if ctx.Changed != nil {
+			ctx.Changed(RowChange{Table: t, RestartIdentity: len(n.Sequences) > 0})
+		}
+	}
+	s := &sequences{ctx: ctx}
+	for _, seq := range n.Sequences {
+		if err := s.restart(seq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runDropIndex(ctx *Context, n *planner.DropIndex) error {
+	for _, ref := range n.Indexes {
+		// Re-read the descriptor: an earlier index in the list may have
+		// belonged to the same table.
+		t, err := catalog.GetTableByID(ctx.Txn, ref.Table.ID)
+		if err != nil {
+			return err
+		}
+		prefix := rowcodec.IndexPrefix(t.ID, ref.Index.ID)
+		if err := ctx.Txn.DeleteRange(prefix, rowcodec.PrefixEnd(prefix)); err != nil {
+			return err
+		}
+		if err := catalog.DropIndex(ctx.Txn, t, t.FindIndex(ref.Index.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
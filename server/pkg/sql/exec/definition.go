@@ -0,0 +1,90 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+func runShowCreate(ctx *Context, n *planner.ShowCreate) (*Result, error) {
+	def, err := planner.TableDef(ctx.Txn, n.Table)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Columns: n.Columns(), Rows: [][]types.Datum{{types.DString(n.Table.Name), types.DString(def)}}}, nil
+}
+
+// definitions implements eval.Definitions for the statement of ctx.
+type definitions struct {
+	ctx *Context
+}
+
+func (d *definitions) TableDef(schema, name string) (string, error) {
+	id, ok, err := d.ctx.resolve(schema, name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", qualifiedName(schema, name))
+	}
+	t, err := catalog.LookupTable(d.ctx.Txn, id, name)
+	if err != nil {
+		return "", err
+	}
+	if t == nil {
+		return "", pgerror.Newf(pgerror.CodeWrongObjectType, "%q is not a table", qualifiedName(schema, name))
+	}
+	return planner.TableDef(d.ctx.Txn, t)
+}
+
+func (d *definitions) IndexDef(schema, name string) (string, error) {
+	id, ok, err := d.ctx.resolve(schema, name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", qualifiedName(schema, name))
+	}
+	t, idx, err := catalog.LookupIndex(d.ctx.Txn, id, name)
+	if err != nil {
+		return "", err
+	}
+	if idx == nil {
+		return "", pgerror.Newf(pgerror.CodeWrongObjectType, "%q is not an index", qualifiedName(schema, name))
+	}
+	return planner.IndexDef(d.ctx.Txn, t, idx)
+}
+
+func (d *definitions) SerialSequence(schema, table, column string) (string, bool, error) {
+	id, ok, err := d.ctx.resolve(schema, table)
+	if err != nil {
+		return "", false, err
+	}
+	if !ok {
+		return "", false, pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", qualifiedName(schema, table))
+	}
+	t, err := catalog.MustLookupTable(d.ctx.Txn, id, table)
+	if err != nil {
+		return "", false, err
+	}
+	i := t.FindColumn(column)
+	if i < 0 {
+		return "", false, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q of relation %q does not exist", column, table)
+	}
+	seqs, err := catalog.ListSequences(d.ctx.Txn)
+	if err != nil {
+		return "", false, err
+	}
+	for _, seq := range seqs {
+		if seq.OwnedBy != nil && seq.OwnedBy.Table == t.ID && seq.OwnedBy.Column == t.Columns[i].ID {
+			s, err := catalog.GetSchemaByID(d.ctx.Txn, seq.Schema)
+			if err != nil {
+				return "", false, err
+			}
+			return parser.QuoteIdent(s.Name) + "." + parser.QuoteIdent(seq.Name), true, nil
+		}
+	}
+	return "", false, nil
+}
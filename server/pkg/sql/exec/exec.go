@@ -0,0 +1,388 @@
+// Package exec runs query plans.
+//
+// A plan is turned into a tree of operators that pull rows from their
+// inputs one at a time. Statements that modify data read their input to
+// completion before writing, so a statement never observes its own writes.
+package exec
+
+import (
+	"io"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// Context is the state shared by the operators of one statement.
+type Context struct {
+	// Txn is the statement's transaction. Writes to it fail while a
+	// parallel scan reads it.
+	Txn  engine.Txn
+	Eval *eval.Context
+	// Registry holds the functions listed by pg_proc.
+	Registry *eval.Registry
+	// Engine runs the transactions that advance sequences, which are not
+	// rolled back with Txn. Without it, sequences are advanced in Txn. It
+	// is also the keyspace VACUUM compacts and the compression of tables
+	// is set on.
+	Engine engine.Engine
+	// Sequences is the session's state of sequences. A statement without
+	// one starts from none.
+	Sequences *SequenceState
+	// Statements lists the statistics of pg_stat_statements.
+	Statements func() []vtable.StatementStats
+	// Activity lists the sessions of pg_stat_activity, and Progress the
+	// commands of the pg_stat_progress_* tables.
+	Activity func() []vtable.Activity
+	Progress func() []vtable.Progress
+	// TerminateBackend, if set, terminates the session with the given
+	// process ID, as DROP DATABASE ... WITH (FORCE) terminates those
+	// connected to the database.
+	TerminateBackend func(pid int32)
+	// ReportProgress, if set, is called as long commands advance, with the
+	// progress they have made, and with the zero Progress once they end.
+	ReportProgress func(vtable.Progress)
+	// Settings lists the settings of pg_settings.
+	Settings func() []vtable.Setting
+	// ReplicationSlots lists the replication slots of
+	// pg_replication_slots.
+	ReplicationSlots func() ([]vtable.ReplicationSlot, error)
+	// StorageStats reports the statistics of the server's engine for
+	// pgz_storage_stats(), or is nil if the engine does not report them.
+	StorageStats func() (engine.StorageStats, error)
+	// CopyIn is the data the client sends for COPY FROM STDIN.
+	CopyIn io.Reader
+	// Interrupted, if set, is polled as rows are read and returns an error
+	// once the statement has been canceled. The workers of parallel scans
+	// poll it too, so it must be safe for concurrent use.
+	Interrupted func() error
+	// User is the role the statement runs as, whose privileges its reads
+	// and writes are checked against. Without one, everything is allowed.
+	User string
+	// SearchPath are the schemas that the names nextval and its kin are
+	// given are looked up in, as planner.Planner's.
+	SearchPath []string
+	// Changed, if set, is called with each change the statement makes to
+	// the rows of a table, for logical replication.
+	Changed func(RowChange)
+	// Memory accounts for the memory of the statement's sorts, hash
+	// aggregations and hash joins, which its WorkMem bounds. Without it,
+	// they are not bounded.
+	Memory *Memory
+	// Spill, if set, is the engine sorts and hash aggregations spill the
+	// rows beyond work_mem to, in temporary ranges; see catalog.TempRange.
+	// Without it, they fail with out_of_memory instead.
+	Spill engine.Engine
+	// ParallelWorkers bounds the workers that read the shards of a scan at
+	// once; see planner.Scan.Shards. With none, the shards are read in
+	// turn, as one scan.
+	ParallelWorkers int
+
+	// ctes holds the rows of the materialized CTEs read so far, and work
+	// the work tables of the recursive queries being evaluated.
+	ctes map[*planner.CTE]*cteRows
+	work map[*planner.RecursiveUnion][][]types.Datum
+	// privs are the privileges of User, once checked.
+	privs *privileges
+	// gathers counts the gatherOps reading Txn, which is read-only while
+	// there are any; see beginGather.
+	gathers int
+}
+
+// interrupted returns the error that cancels the statement, if it has
+// been canceled.
+func (ctx *Context) interrupted() error {
+	if ctx.Interrupted == nil {
+		return nil
+	}
+	return ctx.Interrupted()
+}
+
+// resolve returns the schema of the relation named name in schema, or in
+// the first schema of the search path holding one if schema is empty. ok
+// is false if there is none.
+func (ctx *Context) resolve(schema, name string) (id catalog.ID, ok bool, err error) {
+	if schema != "" {
+		s, err := catalog.MustLookupSchema(ctx.Txn, schema)
+		if err != nil {
+			return 0, false, err
+		}
+		return s.ID, true, nil
+	}
+	path, err := catalog.SearchPath(ctx.Txn, ctx.SearchPath, ctx.User)
+	if err != nil {
+		return 0, false, err
+	}
+	return catalog.Resolve(ctx.Txn, path, name)
+}
+
+// qualifiedName returns name, qualified with schema unless it is empty, as
+// in errors about relations.
+func qualifiedName(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
+
+// Operator produces rows.
+type Operator interface {
+	// Next returns the next row, or nil when there are no more.
+	Next() ([]types.Datum, error)
+	// Close releases the operator and its inputs.
+	Close()
+}
+
+// Result is the outcome of a statement.
+type Result struct {
+	Columns []planner.Column
+	Rows    [][]types.Datum
+	// RowsAffected counts the rows written by INSERT, UPDATE, DELETE and
+	// COPY.
+	RowsAffected int
+	// Rejects are the rows COPY skipped for ON_ERROR ignore.
+	Rejects []CopyReject
+}
+
+// Run executes plan in ctx.
+func Run(ctx *Context, plan planner.Node) (*Result, error) {
+	if ctx.Eval.Subqueries == nil {
+		ctx.Eval.Subqueries = newSubqueries(ctx)
+	}
+	if ctx.Eval.Sequences == nil {
+		if ctx.Sequences == nil {
+			ctx.Sequences = NewSequenceState()
+		}
+		ctx.Eval.Sequences = &sequences{ctx: ctx}
+	}
+	if ctx.Eval.Privileges == nil {
+		ctx.Eval.Privileges = &tablePrivileges{ctx: ctx}
+	}
+	if ctx.Eval.Definitions == nil {
+		ctx.Eval.Definitions = &definitions{ctx: ctx}
+	}
+	if err := ctx.authorize(plan); err != nil {
+		return nil, err
+	}
+	defer ctx.closeCTEs()
+	switch n := plan.(type) {
+	case *planner.Insert:
+		return runInsert(ctx, n)
+	case *planner.Update:
+		return runUpdate(ctx, n)
+	case *planner.Delete:
+		return runDelete(ctx, n)
+	case *planner.CreateTable:
+		return &Result{}, runCreateTable(ctx, n)
+	case *planner.CreateIndex:
+		return &Result{}, runCreateIndex(ctx, n)
+	case *planner.AlterTable:
+		return &Result{}, runAlterTable(ctx, n)
+	case *planner.DropTable:
+		return &Result{}, runDropTable(ctx, n)
+	case *planner.DropIndex:
+		return &Result{}, runDropIndex(ctx, n)
+	case *planner.Truncate:
+		return &Result{}, runTruncate(ctx, n)
+	case *planner.CreateSequence:
+		return &Result{}, runCreateSequence(ctx, n)
+	case *planner.DropSequence:
+		return &Result{}, runDropSequence(ctx, n)
+	case *planner.CreateSchema:
+		return &Result{}, runCreateSchema(ctx, n)
+	case *planner.DropSchema:
+		return &Result{}, runDropSchema(ctx, n)
+	case *planner.CreateDatabase:
+		return &Result{}, runCreateDatabase(ctx, n)
+	case *planner.DropDatabase:
+		return &Result{}, runDropDatabase(ctx, n)
+	case *planner.CreatePublication:
+		return &Result{}, runCreatePublication(ctx, n)
+	case *planner.DropPublication:
+		return &Result{}, runDropPublication(ctx, n)
+	case *planner.CreateRole:
+		return &Result{}, runCreateRole(ctx, n)
+	case *planner.AlterRole:
+		return &Result{}, runAlterRole(ctx, n)
+	case *planner.AlterRoleSettings:
+		return &Result{}, runAlterRoleSettings(ctx, n)
+	case *planner.DropRole:
+		return &Result{}, runDropRole(ctx, n)
+	case *planner.GrantRole:
+		return &Result{}, runGrantRole(ctx, n)
+	case *planner.Grant:
+		return &Result{}, runGrant(ctx, n)
+	case *planner.Analyze:
+		return &Result{}, runAnalyze(ctx, n)
+	case *planner.Cluster:
+		return &Result{}, runCluster(ctx, n)
+	case *planner.Vacuum:
+		return &Result{}, runVacuum(ctx, n)
+	case *planner.Copy:
+		return runCopy(ctx, n)
+	case *planner.ShowCreate:
+		return runShowCreate(ctx, n)
+	case *planner.Explain:
+		res := &Result{Columns: n.Columns()}
+		for _, line := range planner.ExplainLines(n.Plan) {
+			res.Rows = append(res.Rows, []types.Datum{types.DString(line)})
+		}
+		return res, nil
+	}
+	op, err := Build(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	defer op.Close()
+	res := &Result{Columns: plan.Columns()}
+	if res.Rows, err = drain(op); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Build returns the operator tree for a plan that produces rows.
+func Build(ctx *Context, plan planner.Node) (Operator, error) {
+	switch n := plan.(type) {
+	case *planner.Scan:
+		if err := ctx.checkTable(n.Table, catalog.PrivSelect); err != nil {
+			return nil, err
+		}
+		if workers := min(ctx.ParallelWorkers, len(n.Shards)); workers > 0 {
+			return newGather(ctx, n, workers), nil
+		}
+		return newScan(ctx, n), nil
+	case *planner.VectorSearch:
+		if err := ctx.checkTable(n.Table, catalog.PrivSelect); err != nil {
+			return nil, err
+		}
+		return &vectorSearchOp{ctx: ctx, n: n}, nil
+	case *planner.InvertedScan:
+		if err := ctx.checkTable(n.Table, catalog.PrivSelect); err != nil {
+			return nil, err
+		}
+		return &invertedScanOp{ctx: ctx, n: n}, nil
+	case *planner.BRINScan:
+		if err := ctx.checkTable(n.Table, catalog.PrivSelect); err != nil {
+			return nil, err
+		}
+		return brinScan(ctx, n)
+	case *planner.VirtualScan:
+		vctx := &vtable.Context{Txn: ctx.Txn, Registry: ctx.Registry, Statements: ctx.Statements, Activity: ctx.Activity, Progress: ctx.Progress, Settings: ctx.Settings, ReplicationSlots: ctx.ReplicationSlots, StorageStats: ctx.StorageStats}
+		if r, ok := ctx.Engine.(engine.SpaceReporter); ok {
+			vctx.Space = r.Space
+		}
+		rows, err := n.Table.Rows(vctx)
+		if err != nil {
+			return nil, err
+		}
+		var op Operator = &rowsOp{rows: rows}
+		if n.Filter != nil {
+			op = &filterOp{ctx: ctx, input: op, pred: n.Filter}
+		}
+		return op, nil
+	case *planner.Values:
+		return &valuesOp{ctx: ctx, rows: n.Rows}, nil
+	case *planner.With:
+		return Build(ctx, n.Input)
+	case *planner.CTEScan:
+		rows, err := ctx.cte(n.CTE)
+		if err != nil {
+			return nil, err
+		}
+		return &cteScanOp{rows: rows}, nil
+	case *planner.SetOp:
+		left, err := Build(ctx, n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := Build(ctx, n.Right)
+		if err != nil {
+			left.Close()
+			return nil, err
+		}
+		o := &setOpOp{n: n, left: left, right: right}
+		if !n.All {
+			o.seen = map[string]struct{}{}
+		}
+		return o, nil
+	case *planner.RecursiveUnion:
+		return newRecursiveUnion(ctx, n)
+	case *planner.WorkTableScan:
+		return &rowsOp{rows: ctx.work[n.Union]}, nil
+	case *planner.Filter:
+		in, err := Build(ctx, n.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &filterOp{ctx: ctx, input: in, pred: n.Pred}, nil
+	case *planner.Project:
+		in, err := Build(ctx, n.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &projectOp{ctx: ctx, input: in, exprs: n.Exprs}, nil
+	case *planner.Distinct:
+		in, err := Build(ctx, n.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &distinctOp{input: in, cols: n.Columns(), seen: map[string]struct{}{}}, nil
+	case *planner.Sort:
+		return buildSort(ctx, n, -1)
+	case *planner.Limit:
+		return buildLimit(ctx, n)
+	case *planner.Aggregate:
+		in, err := Build(ctx, n.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &hashAggOp{ctx: ctx, input: in, n: n, mem: ctx.Memory.account("hash aggregate")}, nil
+	case *planner.Window:
+		in, err := Build(ctx, n.Input)
+		if err != nil {
+			return nil, err
+		}
+		return &windowOp{ctx: ctx, input: in, n: n}, nil
+	case *planner.Join:
+		left, err := Build(ctx, n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := Build(ctx, n.Right)
+		if err != nil {
+			left.Close()
+			return nil, err
+		}
+		return newJoin(ctx, n, left, right), nil
+	case *planner.LookupJoin:
+		if err := ctx.checkTable(n.Table, catalog.PrivSelect); err != nil {
+			return nil, err
+		}
+		in, err := Build(ctx, n.Input)
+		if err != nil {
+			return nil, err
+		}
+		return newLookupJoin(ctx, n, in), nil
+	}
+	return nil, pgerror.Newf(pgerror.CodeInternalError, "cannot execute %T", plan)
+}
+
+// drain reads every row of op.
+func drain(op Operator) ([][]types.Datum, error) {
+	var rows [][]types.Datum
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
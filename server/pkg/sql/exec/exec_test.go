@@ -0,0 +1,149 @@
+package exec_test
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+func connect(t *testing.T) *sql.Session {
+	t.Helper()
+	s, err := sql.NewServer(memory.New()).Connect("postgres", "postgres")
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func exec(t *testing.T, s *sql.Session, query string) []*sql.Result {
+	t.Helper()
+	res, err := s.Exec(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return res
+}
+
+// rows returns the rows of query, in the order it returned them.
+func rows(t *testing.T, s *sql.Session, query string) string {
+	t.Helper()
+	res := exec(t, s, query)
+	return fmt.Sprint(res[len(res)-1].Rows)
+}
+
+// explain returns the lines of the plan of query, unindented.
+func explain(t *testing.T, s *sql.Session, query string) []string {
+	t.Helper()
+	var lines []string
+	for _, row := range exec(t, s, "EXPLAIN "+query)[0].Rows {
+		lines = append(lines, strings.TrimSpace(fmt.Sprint(row[0])))
+	}
+	return lines
+}
+
+// check runs each query, and its EXPLAIN, comparing the rows with want
+// and requiring the plan to use op.
+func check(t *testing.T, s *sql.Session, tests []struct{ query, op, want string }) {
+	t.Helper()
+	for _, tc := range tests {
+		if got := rows(t, s, tc.query); got != tc.want {
+			t.Errorf("%s = %s, want %s", tc.query, got, tc.want)
+		}
+		if plan := explain(t, s, tc.query); !slices.Contains(plan, tc.op) {
+			t.Errorf("%s was not planned with %s: %q", tc.query, tc.op, plan)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	s := connect(t)
+	exec(t, s, "CREATE TABLE l (id int PRIMARY KEY, k int)")
+	exec(t, s, "CREATE TABLE r (id int PRIMARY KEY, k int, v text)")
+	exec(t, s, "INSERT INTO l VALUES (1, 1), (2, 2), (3, NULL), (4, 4)")
+	exec(t, s, "INSERT INTO r VALUES (10, 1, 'a'), (11, 1, 'b'), (12, 3, 'c'), (13, NULL, 'd')")
+	// NULL keys join with nothing, not even each other.
+	check(t, s, []struct{ query, op, want string }{
+		{"SELECT l.id, r.id FROM l JOIN r ON l.k = r.k ORDER BY l.id, r.id",
+			"Hash Join", "[[1 10] [1 11]]"},
+		{"SELECT l.id, r.id FROM l LEFT JOIN r ON l.k = r.k ORDER BY l.id, r.id",
+			"Hash Left Join", "[[1 10] [1 11] [2 NULL] [3 NULL] [4 NULL]]"},
+		{"SELECT l.id, r.id FROM l RIGHT JOIN r ON l.k = r.k ORDER BY l.id, r.id",
+			"Hash Right Join", "[[1 10] [1 11] [NULL 12] [NULL 13]]"},
+		{"SELECT l.id, r.id FROM l FULL JOIN r ON l.k = r.k ORDER BY l.id, r.id",
+			"Hash Full Join", "[[1 10] [1 11] [2 NULL] [3 NULL] [4 NULL] [NULL 12] [NULL 13]]"},
+		{"SELECT l.id, r.id FROM l JOIN r ON l.k < r.k ORDER BY l.id, r.id",
+			"Nested Loop", "[[1 12] [2 12]]"},
+		{"SELECT l.id, r.v FROM l JOIN r ON r.id = l.k + 9 ORDER BY l.id",
+			"Lookup Join: r@r_pkey", "[[1 a] [2 b] [4 d]]"},
+		{"SELECT id FROM l WHERE EXISTS (SELECT 1 FROM r WHERE r.k = l.k) ORDER BY id",
+			"Hash Semi Join", "[[1]]"},
+		{"SELECT id FROM l WHERE NOT EXISTS (SELECT 1 FROM r WHERE r.k = l.k) ORDER BY id",
+			"Hash Anti Join", "[[2] [3] [4]]"},
+	})
+}
+
+func TestSortAndAggregate(t *testing.T) {
+	s := connect(t)
+	exec(t, s, "CREATE TABLE t (id int PRIMARY KEY, g text, x int)")
+	exec(t, s, "INSERT INTO t VALUES (1, 'a', 5), (2, 'b', NULL), (3, 'a', 2), (4, NULL, 7), (5, 'b', 2), (6, 'c', 9)")
+	check(t, s, []struct{ query, op, want string }{
+		// NULLs sort as larger than any value.
+		{"SELECT id, x FROM t ORDER BY x DESC, id",
+			"Sort: x DESC, id", "[[2 NULL] [6 9] [4 7] [1 5] [3 2] [5 2]]"},
+		{"SELECT id, x FROM t ORDER BY x NULLS FIRST, id DESC",
+			"Sort: x NULLS FIRST, id DESC", "[[2 NULL] [5 2] [3 2] [1 5] [4 7] [6 9]]"},
+		{"SELECT id FROM t ORDER BY x, id LIMIT 2 OFFSET 1",
+			"Top-K Sort: x, id", "[[5] [1]]"},
+		// count(x) skips NULLs, and NULL is a group of its own.
+		{"SELECT g, count(*), count(x), sum(x), min(x), max(x) FROM t GROUP BY g ORDER BY g",
+			"Hash Aggregate", "[[a 2 2 7 2 5] [b 2 1 2 2 2] [c 1 1 9 9 9] [NULL 1 1 7 7 7]]"},
+		{"SELECT g, sum(x) FROM t GROUP BY g HAVING count(x) > 1 ORDER BY g",
+			"Filter: (count(x) > 1)", "[[a 7]]"},
+		// Without GROUP BY there is a row even for no input.
+		{"SELECT count(*), count(x), sum(x), avg(x) FROM t WHERE id > 100",
+			"Aggregate", "[[0 0 NULL NULL]]"},
+		{"SELECT count(DISTINCT x) FROM t",
+			"Aggregate", "[[4]]"},
+		{"SELECT DISTINCT g FROM t ORDER BY g",
+			"Distinct", "[[a] [b] [c] [NULL]]"},
+	})
+}
+
+func TestSpill(t *testing.T) {
+	s := connect(t)
+	exec(t, s, "CREATE TABLE t (id int PRIMARY KEY, g int, v text)")
+	var values []string
+	for i := range 2000 {
+		values = append(values, fmt.Sprintf("(%d, %d, '%s')", i, i%500, strings.Repeat("x", 100)))
+	}
+	exec(t, s, "INSERT INTO t VALUES "+strings.Join(values, ", "))
+	queries := []string{
+		"SELECT id FROM t ORDER BY v, id DESC",
+		"SELECT g, count(*), min(id), max(v) FROM t GROUP BY g ORDER BY g",
+	}
+	var want []string
+	for _, q := range queries {
+		want = append(want, rows(t, s, q))
+	}
+	// The rows take the same queries well past work_mem, so they only
+	// succeed by spilling to temporary ranges.
+	exec(t, s, "SET work_mem = '64kB'")
+	for i, q := range queries {
+		if got := rows(t, s, q); got != want[i] {
+			t.Errorf("%s returned different rows past work_mem", q)
+		}
+	}
+	// A hash join cannot spill.
+	_, err := s.Exec("SELECT count(*) FROM t a JOIN t b ON a.v = b.v")
+	var pgErr *pgerror.Error
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeOutOfMemory {
+		t.Errorf("hash join past work_mem: %v, want out of memory", err)
+	}
+}
@@ -0,0 +1,258 @@
+package exec
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// fkState is the foreign key work of a statement. As in PostgreSQL, it is
+// done once the statement has written its own rows: first the referential
+// actions, in the order they were queued, and then the checks, which see
+// the final state. A row may so reference a row the statement writes after
+// it, and a key may be deleted and inserted again.
+type fkState struct {
+	actions []fkAction
+	rows    []fkRow
+	keys    []fkKey
+}
+
+// fkAction is a referential action on the rows referencing the key of
+// old, a row of t that was deleted, or whose key changed to new.
+type fkAction struct {
+	t      *catalog.Table
+	ref    *planner.Reference
+	action catalog.RefAction
+	old    []types.Datum
+	new    []types.Datum
+}
+
+// fkRow is a written row of t whose foreign key fk must reference a row.
+type fkRow struct {
+	t   *catalog.Table
+	fk  planner.ForeignKey
+	row []types.Datum
+}
+
+// fkKey is a row of t that was deleted or whose key ref references
+// changed, so that no row may reference its old key.
+type fkKey struct {
+	t      *catalog.Table
+	ref    *planner.Reference
+	action catalog.RefAction
+	row    []types.Datum
+}
+
+// wrote queues the foreign key work of changing a row of t from old to
+// row. old is nil for an insert and row is nil for a delete.
+func (s *fkState) wrote(t *catalog.Table, w *planner.Writes, old, row []types.Datum) {
+	if row != nil {
+		for _, fk := range w.ForeignKeys {
+			if old == nil || !sameValues(old, row, fk.Ords) {
+				s.rows = append(s.rows, fkRow{t: t, fk: fk, row: row})
+			}
+		}
+	}
+	if old == nil {
+		return
+	}
+	for _, ref := range w.References {
+		if row != nil && sameValues(old, row, ref.RefOrds) {
+			continue
+		}
+		if slices.Contains(values(old, ref.RefOrds), types.DNull) {
+			// No row references a key with a NULL.
+			continue
+		}
+		a := fkAction{t: t, ref: ref, action: ref.OnDelete, old: old}
+		if row != nil {
+			a.action, a.new = ref.OnUpdate, values(row, ref.RefOrds)
+		}
+		switch a.action {
+		case catalog.NoAction, catalog.Restrict:
+			s.keys = append(s.keys, fkKey{t: t, ref: ref, action: a.action, row: old})
+		default:
+			s.actions = append(s.actions, a)
+		}
+	}
+}
+
+// finish does the queued work. Actions may queue more.
+func (s *fkState) finish(ctx *Context) error {
+	for len(s.actions) > 0 {
+		a := s.actions[0]
+		s.actions = s.actions[1:]
+		if err := s.act(ctx, a); err != nil {
+			return err
+		}
+	}
+	for _, r := range s.rows {
+		if err := checkReferenced(ctx, r); err != nil {
+			return err
+		}
+	}
+	for _, k := range s.keys {
+		if err := checkUnreferenced(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// act takes a CASCADE, SET NULL or SET DEFAULT action on the rows
+// referencing the key of a.
+func (s *fkState) act(ctx *Context, a fkAction) error {
+	r := a.ref
+	rows, err := referencingRows(ctx, r, values(a.old, r.RefOrds))
+	if err != nil {
+		return err
+	}
+	if a.action == catalog.SetDefault && len(rows) > 0 {
+		// Setting a row to a default equal to the old key leaves it
+		// referencing the key, which it may only if a row has it again.
+		s.keys = append(s.keys, fkKey{t: a.t, ref: r, action: catalog.NoAction, row: a.old})
+	}
+	for _, old := range rows {
+		if a.action == catalog.Cascade && a.new == nil {
+			if err := deleteRow(ctx, r.Table, r.Writes, old, s); err != nil {
+				return err
+			}
+			continue
+		}
+		row := slices.Clone(old)
+		for i, ord := range r.Ords {
+			switch a.action {
+			case catalog.Cascade:
+				row[ord] = a.new[i]
+			case catalog.SetNull:
+				row[ord] = types.DNull
+			case catalog.SetDefault:
+				row[ord] = types.DNull
+				if e := r.Defaults[i]; e != nil {
+					if row[ord], err = e.Eval(ctx.Eval); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err := updateRow(ctx, r.Table, r.Writes, old, row, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencingRows returns the rows of r.Table referencing key, through the
+// index on the foreign key's columns if there is one.
+func referencingRows(ctx *Context, r *planner.Reference, key []types.Datum) ([][]types.Datum, error) {
+	t := r.Table
+	if r.Index != nil {
+		prefix, err := rowcodec.EncodeIndexPrefix(t, r.Index, key)
+		if err != nil {
+			return nil, err
+		}
+		span := planner.Span{Start: prefix, End: rowcodec.PrefixEnd(prefix)}
+		return readAll(ctx, &planner.Scan{Table: t, Index: r.Index, Spans: []planner.Span{span}})
+	}
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	span := planner.Span{Start: prefix, End: rowcodec.PrefixEnd(prefix)}
+	rows, err := readAll(ctx, &planner.Scan{Table: t, Index: t.PrimaryIndex, Spans: []planner.Span{span}})
+	if err != nil {
+		return nil, err
+	}
+	var out [][]types.Datum
+	for _, row := range rows {
+		if slices.EqualFunc(values(row, r.Ords), key, func(a, b types.Datum) bool {
+			return a != types.DNull && types.CompareDatums(a, b) == 0
+		}) {
+			out = append(out, row)
+		}
+	}
+	return out, nil
+}
+
+// checkReferenced reports a violation if the foreign key of r references
+// no row. As with MATCH SIMPLE, a key with a NULL references nothing and
+// passes.
+func checkReferenced(ctx *Context, r fkRow) error {
+	fk := r.fk
+	key := values(r.row, fk.Ords)
+	if slices.Contains(key, types.DNull) {
+		return nil
+	}
+	prefix, err := rowcodec.EncodeIndexPrefix(fk.Referenced, fk.Index, key)
+	if err != nil {
+		return err
+	}
+	found, err := anyKey(ctx.Txn, prefix, rowcodec.PrefixEnd(prefix))
+	if err != nil || found {
+		return err
+	}
+	return pgerror.Newf(pgerror.CodeForeignKeyViolation,
+		"insert or update on table %q violates foreign key constraint %q", r.t.Name, fk.Name).
+		WithDetail(fmt.Sprintf("Key %s is not present in table %q.",
+			formatKey(r.t, fk.Ords, r.row), fk.Referenced.Name)).
+		WithTable(r.t.Name).WithConstraint(fk.Name)
+}
+
+// checkUnreferenced reports a violation if a row still references the old
+// key of k. Under NO ACTION, a row that now has the key again satisfies
+// the references instead.
+func checkUnreferenced(ctx *Context, k fkKey) error {
+	r := k.ref
+	if k.action == catalog.NoAction {
+		prefix, err := rowcodec.EncodeIndexPrefix(k.t, r.Key, values(k.row, k.t.ColumnOrdinals(r.Key)))
+		if err != nil {
+			return err
+		}
+		found, err := anyKey(ctx.Txn, prefix, rowcodec.PrefixEnd(prefix))
+		if err != nil || found {
+			return err
+		}
+	}
+	rows, err := referencingRows(ctx, r, values(k.row, r.RefOrds))
+	if err != nil || len(rows) == 0 {
+		return err
+	}
+	return pgerror.Newf(pgerror.CodeForeignKeyViolation,
+		"update or delete on table %q violates foreign key constraint %q on table %q",
+		k.t.Name, r.Name, r.Table.Name).
+		WithDetail(fmt.Sprintf("Key %s is still referenced from table %q.",
+			formatKey(k.t, r.RefOrds, k.row), r.Table.Name)).
+		WithTable(r.Table.Name).WithConstraint(r.Name)
+}
+
+// values returns the values of row at ords.
+func values(row []types.Datum, ords []int) []types.Datum {
+	vals := make([]types.Datum, len(ords))
+	for i, ord := range ords {
+		vals[i] = row[ord]
+	}
+	return vals
+}
+
+// sameValues reports whether a and b hold the same values at ords.
+func sameValues(a, b []types.Datum, ords []int) bool {
+	for _, ord := range ords {
+		if types.CompareDatums(a[ord], b[ord]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatKey formats the columns ords of row the way PostgreSQL shows a key
+// in error details.
+func formatKey(t *catalog.Table, ords []int, row []types.Datum) string {
+	names := make([]string, len(ords))
+	for i, ord := range ords {
+		names[i] = t.Columns[ord].Name
+	}
+	return "(" + strings.Join(names, ", ") + ")=" + formatRow(values(row, ords))
+}
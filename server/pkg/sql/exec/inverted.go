@@ -0,0 +1,173 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// putInvertedEntries adds row to an inverted index.
+func putInvertedEntries(ctx *Context, t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	keys, err := rowcodec.EncodeInvertedKeys(t, idx, row)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ctx.Txn.Put(key, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteInvertedEntries removes row from an inverted index.
+func deleteInvertedEntries(ctx *Context, t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	keys, err := rowcodec.EncodeInvertedKeys(t, idx, row)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ctx.Txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateInvertedEntries moves a row of an inverted index from old to row,
+// touching only the entries of tokens one has and the other lacks.
+func updateInvertedEntries(ctx *Context, t *catalog.Table, idx *catalog.Index, old, row []types.Datum) error {
+	oldKeys, err := rowcodec.EncodeInvertedKeys(t, idx, old)
+	if err != nil {
+		return err
+	}
+	newKeys, err := rowcodec.EncodeInvertedKeys(t, idx, row)
+	if err != nil {
+		return err
+	}
+	has := func(keys [][]byte, key []byte) bool {
+		return slices.ContainsFunc(keys, func(k []byte) bool { return bytes.Equal(k, key) })
+	}
+	for _, key := range oldKeys {
+		if !has(newKeys, key) {
+			if err := ctx.Txn.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range newKeys {
+		if !has(oldKeys, key) {
+			if err := ctx.Txn.Put(key, []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// invertedScanOp returns the rows of an inverted index scan in primary key
+// order. It reads the primary keys of each token's entries, intersects
+// them, and then fetches the rows.
+type invertedScanOp struct {
+	ctx  *Context
+	n    *planner.InvertedScan
+	pks  [][]byte
+	done bool
+}
+
+// postings returns the primary keys of the rows with the token of span s,
+// in order.
+func (o *invertedScanOp) postings(s planner.Span) ([][]byte, error) {
+	it, err := o.ctx.Txn.Scan(s.Start, s.End)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	prefix := rowcodec.IndexPrefix(o.n.Table.ID, catalog.PrimaryIndexID)
+	var pks [][]byte
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return pks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		pks = append(pks, append(bytes.Clone(prefix), k[len(s.Start):]...))
+	}
+}
+
+// intersect returns the keys in both a and b, which are sorted.
+func intersect(a, b [][]byte) [][]byte {
+	var out [][]byte
+	for len(a) > 0 && len(b) > 0 {
+		switch c := bytes.Compare(a[0], b[0]); {
+		case c < 0:
+			a = a[1:]
+		case c > 0:
+			b = b[1:]
+		default:
+			out = append(out, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return out
+}
+
+func (o *invertedScanOp) search() error {
+	for i, s := range o.n.Spans {
+		pks, err := o.postings(s)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			o.pks = pks
+		} else {
+			o.pks = intersect(o.pks, pks)
+		}
+		if len(o.pks) == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func (o *invertedScanOp) Next() ([]types.Datum, error) {
+	if !o.done {
+		if err := o.search(); err != nil {
+			return nil, err
+		}
+		o.done = true
+	}
+	for len(o.pks) > 0 {
+		pk := o.pks[0]
+		o.pks = o.pks[1:]
+		v, err := o.ctx.Txn.Get(pk)
+		if err != nil {
+			return nil, err
+		}
+		row, err := rowcodec.DecodeRow(o.n.Table, pk, v)
+		if err != nil {
+			return nil, err
+		}
+		if o.n.Filter != nil {
+			ok, err := evalPredicate(o.ctx, o.n.Filter, row)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		return row, nil
+	}
+	return nil, nil
+}
+
+func (o *invertedScanOp) Close() {}
@@ -0,0 +1,338 @@
+package exec
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// joinOp reads all of its right input on the first call to Next, then
+// joins each left row with the right rows. With keys the right rows are
+// hashed by the key encoding of their key values, and a left row is only
+// compared with the rows in its bucket; without, it is compared with all
+// of them. Semi and anti joins stop at the first match.
+type joinOp struct {
+	ctx         *Context
+	n           *planner.Join
+	left, right Operator
+	rightWidth  int
+	mem         *memAccount
+
+	built   bool
+	rows    [][]types.Datum
+	all     []int
+	buckets map[string][]int
+	// matched records the right rows that joined, for RIGHT and FULL
+	// joins, which return the others once the left input is exhausted.
+	matched []bool
+
+	row        []types.Datum
+	cands      []int
+	pos        int
+	rowMatched bool
+	leftDone   bool
+	unmatched  int
+}
+
+func newJoin(ctx *Context, n *planner.Join, left, right Operator) *joinOp {
+	op := "hash join"
+	if len(n.RightKeys) == 0 {
+		op = "nested loop join"
+	}
+	return &joinOp{ctx: ctx, n: n, left: left, right: right, rightWidth: len(n.Right.Columns()),
+		mem: ctx.Memory.account(op)}
+}
+
+func (o *joinOp) build() error {
+	var rows [][]types.Datum
+	for {
+		row, err := o.right.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if err := o.mem.grow(rowSize(row)); err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	o.rows = rows
+	if o.n.Type == planner.RightJoin || o.n.Type == planner.FullJoin {
+		o.matched = make([]bool, len(rows))
+	}
+	if len(o.n.RightKeys) == 0 {
+		o.all = make([]int, len(rows))
+		for i := range o.all {
+			o.all[i] = i
+		}
+		return nil
+	}
+	o.buckets = make(map[string][]int)
+	for i, row := range rows {
+		key, ok, err := o.key(o.n.RightKeys, row)
+		if err != nil {
+			return err
+		}
+		// A row with a NULL key matches nothing.
+		if ok {
+			if err := o.mem.grow(int64(len(key)) + datumSize); err != nil {
+				return err
+			}
+			o.buckets[key] = append(o.buckets[key], i)
+		}
+	}
+	return nil
+}
+
+// key returns the key encoding of keys evaluated over row, and false if
+// any of them is NULL.
+func (o *joinOp) key(keys []eval.Expr, row []types.Datum) (string, bool, error) {
+	o.ctx.Eval.Row = row
+	var buf []byte
+	for _, k := range keys {
+		d, err := k.Eval(o.ctx.Eval)
+		if err != nil {
+			return "", false, err
+		}
+		if d == types.DNull {
+			return "", false, nil
+		}
+		if buf, err = rowcodec.EncodeKey(buf, k.ResolvedType(), d); err != nil {
+			return "", false, err
+		}
+	}
+	return string(buf), true, nil
+}
+
+func (o *joinOp) Next() ([]types.Datum, error) {
+	if !o.built {
+		if err := o.build(); err != nil {
+			return nil, err
+		}
+		o.built = true
+	}
+	for {
+		if o.leftDone {
+			return o.nextUnmatched(), nil
+		}
+		if o.row == nil {
+			row, err := o.left.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				o.leftDone = true
+				continue
+			}
+			o.row, o.pos, o.rowMatched = row, 0, false
+			o.cands = o.all
+			if o.buckets != nil {
+				key, ok, err := o.key(o.n.LeftKeys, row)
+				if err != nil {
+					return nil, err
+				}
+				o.cands = nil
+				if ok {
+					o.cands = o.buckets[key]
+				}
+			}
+		}
+		for o.pos < len(o.cands) {
+			i := o.cands[o.pos]
+			o.pos++
+			out := slices.Concat(o.row, o.rows[i])
+			if o.n.On != nil {
+				ok, err := evalPredicate(o.ctx, o.n.On, out)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+			}
+			o.rowMatched = true
+			if o.matched != nil {
+				o.matched[i] = true
+			}
+			if o.n.Type == planner.SemiJoin || o.n.Type == planner.AntiJoin {
+				break
+			}
+			return out, nil
+		}
+		row := o.row
+		o.row = nil
+		switch o.n.Type {
+		case planner.LeftJoin, planner.FullJoin:
+			if !o.rowMatched {
+				return slices.Concat(row, nullRow(o.rightWidth)), nil
+			}
+		case planner.SemiJoin:
+			if o.rowMatched {
+				return row, nil
+			}
+		case planner.AntiJoin:
+			if !o.rowMatched {
+				return row, nil
+			}
+		}
+	}
+}
+
+// nextUnmatched returns the next right row that matched no left row,
+// extended with NULLs, or nil when there are no more.
+func (o *joinOp) nextUnmatched() []types.Datum {
+	for ; o.unmatched < len(o.matched); o.unmatched++ {
+		if !o.matched[o.unmatched] {
+			row := o.rows[o.unmatched]
+			o.unmatched++
+			return slices.Concat(nullRow(len(o.n.Left.Columns())), row)
+		}
+	}
+	return nil
+}
+
+func (o *joinOp) Close() {
+	o.mem.close()
+	o.left.Close()
+	o.right.Close()
+}
+
+// lookupJoinOp joins each input row with the table rows found by looking
+// up its key values in an index: a point read when they give the whole
+// primary key, otherwise a scan of the index entries that start with them.
+type lookupJoinOp struct {
+	ctx   *Context
+	n     *planner.LookupJoin
+	input Operator
+	// point is set when the keys cover the primary key.
+	point bool
+
+	row     []types.Datum
+	inner   Operator
+	matched bool
+}
+
+func newLookupJoin(ctx *Context, n *planner.LookupJoin, input Operator) *lookupJoinOp {
+	return &lookupJoinOp{
+		ctx:   ctx,
+		n:     n,
+		input: input,
+		point: n.Index.ID == catalog.PrimaryIndexID && len(n.Keys) == len(n.Index.ColumnIDs),
+	}
+}
+
+func (o *lookupJoinOp) Next() ([]types.Datum, error) {
+	for {
+		if o.row == nil {
+			row, err := o.input.Next()
+			if err != nil || row == nil {
+				return nil, err
+			}
+			o.row, o.matched = row, false
+			if o.inner, err = o.lookup(row); err != nil {
+				return nil, err
+			}
+		}
+		if o.inner != nil {
+			tr, err := o.inner.Next()
+			if err != nil {
+				return nil, err
+			}
+			if tr != nil {
+				out := slices.Concat(o.row, tr)
+				if o.n.On != nil {
+					ok, err := evalPredicate(o.ctx, o.n.On, out)
+					if err != nil {
+						return nil, err
+					}
+					if !ok {
+						continue
+					}
+				}
+				o.matched = true
+				if o.n.Type != planner.SemiJoin && o.n.Type != planner.AntiJoin {
+					return out, nil
+				}
+			}
+			o.inner.Close()
+			o.inner = nil
+		}
+		row := o.row
+		o.row = nil
+		switch o.n.Type {
+		case planner.LeftJoin:
+			if !o.matched {
+				return slices.Concat(row, nullRow(len(o.n.Table.Columns))), nil
+			}
+		case planner.SemiJoin:
+			if o.matched {
+				return row, nil
+			}
+		case planner.AntiJoin:
+			if !o.matched {
+				return row, nil
+			}
+		}
+	}
+}
+
+// lookup returns the table rows matching the keys of row, or nil if a key
+// is NULL.
+func (o *lookupJoinOp) lookup(row []types.Datum) (Operator, error) {
+	o.ctx.Eval.Row = row
+	vals := make([]types.Datum, len(o.n.Keys))
+	for i, k := range o.n.Keys {
+		d, err := k.Eval(o.ctx.Eval)
+		if err != nil {
+			return nil, err
+		}
+		if d == types.DNull {
+			return nil, nil
+		}
+		vals[i] = d
+	}
+	t := o.n.Table
+	prefix, err := rowcodec.EncodeIndexPrefix(t, o.n.Index, vals)
+	if err != nil {
+		return nil, err
+	}
+	if !o.point {
+		span := planner.Span{Start: prefix, End: rowcodec.PrefixEnd(prefix)}
+		return newScan(o.ctx, &planner.Scan{Table: t, Index: o.n.Index, Spans: []planner.Span{span}}), nil
+	}
+	v, err := o.ctx.Txn.Get(prefix)
+	if errors.Is(err, engine.ErrNotFound) {
+		return &rowsOp{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tr, err := rowcodec.DecodeRow(t, prefix, v)
+	if err != nil {
+		return nil, err
+	}
+	return &rowsOp{rows: [][]types.Datum{tr}}, nil
+}
+
+func (o *lookupJoinOp) Close() {
+	if o.inner != nil {
+		o.inner.Close()
+	}
+	o.input.Close()
+}
+
+func nullRow(n int) []types.Datum {
+	row := make([]types.Datum, n)
+	for i := range row {
+		row[i] = types.DNull
+	}
+	return row
+}
@@ -0,0 +1,130 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/config"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Memory accounts for the memory the operators of a statement hold: the
+// rows a sort reads, the groups of a hash aggregation and the rows a hash
+// join builds its table of. Each of them may hold up to WorkMem, as
+// PostgreSQL's work_mem bounds each sort and hash table of a query rather
+// than the query as a whole. Beyond it, sorts and hash aggregations spill
+// to Context.Spill, and the others fail with out_of_memory. The sizes are
+// estimates of the memory of the values held, not exact counts.
+type Memory struct {
+	// WorkMem bounds the bytes each operator may hold, 0 not at all.
+	WorkMem int64
+
+	used, peak int64
+}
+
+// Peak returns the most memory the operators of the statement held at
+// once.
+func (m *Memory) Peak() int64 {
+	if m == nil {
+		return 0
+	}
+	return m.peak
+}
+
+// account returns the account of an operator, named op in errors, such as
+// "sort". A nil Memory returns a nil account, which accounts for nothing.
+func (m *Memory) account(op string) *memAccount {
+	if m == nil {
+		return nil
+	}
+	return &memAccount{m: m, op: op}
+}
+
+// memAccount is the memory an operator holds.
+type memAccount struct {
+	m    *Memory
+	op   string
+	used int64
+}
+
+// grow accounts for n more bytes, or returns an error if they take the
+// operator past work_mem.
+func (a *memAccount) grow(n int64) error {
+	if a == nil {
+		return nil
+	}
+	if a.m.WorkMem > 0 && a.used+n > a.m.WorkMem {
+		return a.exceeded()
+	}
+	a.used += n
+	a.m.used += n
+	a.m.peak = max(a.m.peak, a.m.used)
+	return nil
+}
+
+// fits reports whether n more bytes keep the operator within work_mem,
+// with reserve bytes to spare.
+func (a *memAccount) fits(n, reserve int64) bool {
+	return a == nil || a.m.WorkMem <= 0 || a.used+n+reserve <= a.m.WorkMem
+}
+
+// exceeded returns the error of an operator that needs more than work_mem.
+func (a *memAccount) exceeded() error {
+	return pgerror.New(pgerror.CodeOutOfMemory, "out of memory").
+		WithDetail(fmt.Sprintf("The %s needs more than work_mem (%s).", a.op, config.FormatBytes(a.m.WorkMem))).
+		WithHint("Increase work_mem, or narrow the rows the query sorts, groups or joins.")
+}
+
+// close returns the memory of the operator, once it no longer holds it.
+func (a *memAccount) close() {
+	if a == nil {
+		return
+	}
+	a.m.used -= a.used
+	a.used = 0
+}
+
+// Estimated sizes of the parts of rows, as the Go runtime lays them out on
+// 64-bit platforms.
+const (
+	sliceSize = 24
+	datumSize = 16
+	groupSize = 64
+)
+
+// rowSize estimates the memory of row.
+func rowSize(row []types.Datum) int64 {
+	n := int64(sliceSize)
+	for _, d := range row {
+		n += valueSize(d)
+	}
+	return n
+}
+
+// valueSize estimates the memory of d: its interface value and what it
+// points to.
+func valueSize(d types.Datum) int64 {
+	switch d := d.(type) {
+	case types.DString:
+		return datumSize + int64(len(d))
+	case types.DCIText:
+		return datumSize + int64(len(d))
+	case types.DBytes:
+		return datumSize + int64(len(d))
+	case types.DXML:
+		return datumSize + int64(len(d))
+	case types.DJSONB:
+		return datumSize + int64(len(d))
+	case *types.DDecimal:
+		return datumSize + 48
+	case *types.DVector:
+		return datumSize + sliceSize + 4*int64(len(d.Elems))
+	case *types.DArray:
+		n := int64(datumSize + 8 + sliceSize)
+		for _, e := range d.Elems {
+			n += valueSize(e)
+		}
+		return n
+	}
+	return datumSize
+}
@@ -0,0 +1,476 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+func runInsert(ctx *Context, n *planner.Insert) (*Result, error) {
+	p := startProgress(ctx, "INSERT", n.Table)
+	defer p.finish()
+	rows, err := p.readAll("reading rows", n.Input)
+	if err != nil {
+		return nil, err
+	}
+	p.phase("inserting rows", int64(len(rows)))
+	return insertRows(ctx, p, n, rows)
+}
+
+// insertRows writes rows as n does the rows of its input, counting them as
+// the tuples p processes.
+func insertRows(ctx *Context, p *progress, n *planner.Insert, rows [][]types.Datum) (*Result, error) {
+	t := n.Table
+	fks := &fkState{}
+	var err error
+	for _, in := range rows {
+		row := make([]types.Datum, len(t.Columns))
+		for i := range row {
+			row[i] = types.DNull
+		}
+		for ord, e := range n.Defaults {
+			if e != nil {
+				if row[ord], err = e.Eval(ctx.Eval); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for i, ord := range n.Targets {
+			row[ord] = in[i]
+		}
+		if err := computeGenerated(ctx, t, n.Generated, row); err != nil {
+			return nil, err
+		}
+		if err := prepareRow(ctx, t, n.Checks, row); err != nil {
+			return nil, err
+		}
+		pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, row)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPrimaryKey(ctx, t, pk, row); err != nil {
+			return nil, err
+		}
+		if err := writeRow(ctx, t, pk, row); err != nil {
+			return nil, err
+		}
+		for _, idx := range t.Indexes {
+			if err := putIndexEntry(ctx, t, idx, row); err != nil {
+				return nil, err
+			}
+		}
+		fks.wrote(t, n.Writes, nil, row)
+		ctx.changed(t, nil, row)
+		p.tuple()
+	}
+	for _, idx := range t.Indexes {
+		if idx.BRIN != nil {
+			if err := summarizeNewRanges(ctx, t, idx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := fks.finish(ctx); err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: len(rows)}, nil
+}
+
+func runUpdate(ctx *Context, n *planner.Update) (*Result, error) {
+	p := startProgress(ctx, "UPDATE", n.Table)
+	defer p.finish()
+	rows, err := p.readAll("reading rows", n.Input)
+	if err != nil {
+		return nil, err
+	}
+	p.phase("updating rows", int64(len(rows)))
+	fks := &fkState{}
+	for _, old := range rows {
+		row := append([]types.Datum(nil), old...)
+		ctx.Eval.Row = old
+		for i, ord := range n.Targets {
+			if row[ord], err = n.Exprs[i].Eval(ctx.Eval); err != nil {
+				return nil, err
+			}
+		}
+		if err := updateRow(ctx, n.Table, n.Writes, old, row, fks); err != nil {
+			return nil, err
+		}
+		p.tuple()
+	}
+	if err := fks.finish(ctx); err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: len(rows)}, nil
+}
+
+// updateRow replaces the row old of t with row, enforcing w, and queues
+// the foreign key work of the change in fks.
+func updateRow(ctx *Context, t *catalog.Table, w *planner.Writes, old, row []types.Datum, fks *fkState) error {
+	if err := computeGenerated(ctx, t, w.Generated, row); err != nil {
+		return err
+	}
+	if err := prepareRow(ctx, t, w.Checks, row); err != nil {
+		return err
+	}
+	oldPK, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, old)
+	if err != nil {
+		return err
+	}
+	pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, row)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pk, oldPK) {
+		if err := ctx.Txn.Delete(oldPK); err != nil {
+			return err
+		}
+		if err := checkPrimaryKey(ctx, t, pk, row); err != nil {
+			return err
+		}
+	}
+	if err := writeRow(ctx, t, pk, row); err != nil {
+		return err
+	}
+	for _, idx := range t.Indexes {
+		switch {
+		case idx.HNSW != nil:
+			if err := updateVectorEntry(ctx, t, idx, old, row); err != nil {
+				return err
+			}
+			continue
+		case idx.Inverted:
+			if err := updateInvertedEntries(ctx, t, idx, old, row); err != nil {
+				return err
+			}
+			continue
+		case idx.BRIN != nil:
+			if err := addToRange(ctx, t, idx, row); err != nil {
+				return err
+			}
+			continue
+		}
+		oldKey, err := rowcodec.EncodeIndexKey(t, idx, old)
+		if err != nil {
+			return err
+		}
+		newKey, err := rowcodec.EncodeIndexKey(t, idx, row)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(oldKey, newKey) {
+			continue
+		}
+		if err := ctx.Txn.Delete(oldKey); err != nil {
+			return err
+		}
+		if err := putIndexEntry(ctx, t, idx, row); err != nil {
+			return err
+		}
+	}
+	fks.wrote(t, w, old, row)
+	ctx.changed(t, old, row)
+	return nil
+}
+
+func runDelete(ctx *Context, n *planner.Delete) (*Result, error) {
+	if n.All {
+		return deleteAll(ctx, n.Table)
+	}
+	p := startProgress(ctx, "DELETE", n.Table)
+	defer p.finish()
+	rows, err := p.readAll("reading rows", n.Input)
+	if err != nil {
+		return nil, err
+	}
+	p.phase("deleting rows", int64(len(rows)))
+	fks := &fkState{}
+	for _, row := range rows {
+		if err := deleteRow(ctx, n.Table, n.Writes, row, fks); err != nil {
+			return nil, err
+		}
+		p.tuple()
+	}
+	if err := fks.finish(ctx); err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: len(rows)}, nil
+}
+
+// deleteAll deletes every row of t by counting its primary keys and then
+// deleting its key range, index entries and all, in one call. If ctx
+// reports changes, each row is decoded and reported as it is counted.
+func deleteAll(ctx *Context, t *catalog.Table) (*Result, error) {
+	prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+	it, err := ctx.Txn.Scan(prefix, rowcodec.PrefixEnd(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	n := 0
+	for {
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ctx.Changed != nil {
+			row, err := rowcodec.DecodeRow(t, k, v)
+			if err != nil {
+				return nil, err
+			}
+			ctx.changed(t, row, nil)
+		}
+		n++
+	}
+	prefix = rowcodec.TablePrefix(t.ID)
+	if err := ctx.Txn.DeleteRange(prefix, rowcodec.PrefixEnd(prefix)); err != nil {
+		return nil, err
+	}
+	return &Result{RowsAffected: n}, nil
+}
+
+// deleteRow deletes row from t and queues the foreign key work of the
+// delete in fks.
+func deleteRow(ctx *Context, t *catalog.Table, w *planner.Writes, row []types.Datum, fks *fkState) error {
+	for _, idx := range t.AllIndexes() {
+		switch {
+		case idx.HNSW != nil:
+			if err := deleteVectorEntry(ctx, t, idx, row); err != nil {
+				return err
+			}
+			continue
+		case idx.Inverted:
+			if err := deleteInvertedEntries(ctx, t, idx, row); err != nil {
+				return err
+			}
+			continue
+		case idx.BRIN != nil:
+			// Summaries only ever widen.
+			continue
+		}
+		key, err := rowcodec.EncodeIndexKey(t, idx, row)
+		if err != nil {
+			return err
+		}
+		if err := ctx.Txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	fks.wrote(t, w, row, nil)
+	ctx.changed(t, row, nil)
+	return nil
+}
+
+// readAll runs a plan to completion.
+func readAll(ctx *Context, plan planner.Node) ([][]types.Datum, error) {
+	op, err := Build(ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	defer op.Close()
+	return drain(op)
+}
+
+// computeGenerated computes the generated columns gen of row from the
+// rest of it, which is first converted to the columns' types, so that the
+// generation expressions see the values that are stored.
+func computeGenerated(ctx *Context, t *catalog.Table, gen []planner.ColumnFill, row []types.Datum) error {
+	if len(gen) == 0 {
+		return nil
+	}
+	for i, c := range t.Columns {
+		if c.Generated != "" {
+			continue
+		}
+		d, err := eval.AssignCast(ctx.Eval, row[i], c.Type)
+		if err != nil {
+			return err
+		}
+		row[i] = d
+	}
+	saved := ctx.Eval.Row
+	ctx.Eval.Row = row
+	defer func() { ctx.Eval.Row = saved }()
+	for _, g := range gen {
+		d, err := g.Expr.Eval(ctx.Eval)
+		if err != nil {
+			return err
+		}
+		row[g.Ord] = d
+	}
+	return nil
+}
+
+// prepareRow converts each value of row to its column's type and enforces
+// NOT NULL and CHECK constraints.
+func prepareRow(ctx *Context, t *catalog.Table, checks []planner.Check, row []types.Datum) error {
+	for i, c := range t.Columns {
+		d, err := eval.AssignCast(ctx.Eval, row[i], c.Type)
+		if err != nil {
+			return err
+		}
+		if d == types.DNull && !c.Nullable {
+			return pgerror.Newf(pgerror.CodeNotNullViolation,
+				"null value in column %q of relation %q violates not-null constraint", c.Name, t.Name).
+				WithDetail(fmt.Sprintf("Failing row contains %s.", failingRow(t, row))).
+				WithTable(t.Name).WithColumn(c.Name).WithConstraint(notNullName(t, c))
+		}
+		row[i] = d
+	}
+	for _, ck := range checks {
+		ok, err := satisfies(ctx, ck, row)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return pgerror.Newf(pgerror.CodeCheckViolation,
+				"new row for relation %q violates check constraint %q", t.Name, ck.Name).
+				WithDetail(fmt.Sprintf("Failing row contains %s.", failingRow(t, row))).
+				WithTable(t.Name).WithConstraint(ck.Name)
+		}
+	}
+	return nil
+}
+
+// satisfies reports whether row satisfies the CHECK constraint ck. As in
+// PostgreSQL, a condition that is NULL is satisfied.
+func satisfies(ctx *Context, ck planner.Check, row []types.Datum) (bool, error) {
+	saved := ctx.Eval.Row
+	ctx.Eval.Row = row
+	d, err := ck.Expr.Eval(ctx.Eval)
+	ctx.Eval.Row = saved
+	if err != nil {
+		return false, err
+	}
+	return d != types.DFalse, nil
+}
+
+// notNullName is the name PostgreSQL gives the NOT NULL constraint of
+// column c.
+func notNullName(t *catalog.Table, c *catalog.Column) string {
+	return t.Name + "_" + c.Name + "_not_null"
+}
+
+// checkPrimaryKey reports a unique violation if a row is stored at pk.
+func checkPrimaryKey(ctx *Context, t *catalog.Table, pk []byte, row []types.Datum) error {
+	_, err := ctx.Txn.Get(pk)
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return uniqueViolation(t, t.PrimaryIndex, row)
+}
+
+func writeRow(ctx *Context, t *catalog.Table, pk []byte, row []types.Datum) error {
+	value, err := rowcodec.EncodeRowValue(t, row)
+	if err != nil {
+		return err
+	}
+	return ctx.Txn.Put(pk, value)
+}
+
+// putIndexEntry adds row to a secondary index, first checking uniqueness if
+// the index is unique. As in PostgreSQL, rows with a NULL in any indexed
+// column never conflict.
+func putIndexEntry(ctx *Context, t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	switch {
+	case idx.HNSW != nil:
+		return putVectorEntry(ctx, t, idx, row)
+	case idx.Inverted:
+		return putInvertedEntries(ctx, t, idx, row)
+	case idx.BRIN != nil:
+		return addToRange(ctx, t, idx, row)
+	}
+	if idx.Unique {
+		vals := make([]types.Datum, 0, len(idx.ColumnIDs))
+		hasNull := false
+		for _, ord := range t.ColumnOrdinals(idx) {
+			vals = append(vals, row[ord])
+			hasNull = hasNull || row[ord] == types.DNull
+		}
+		if !hasNull {
+			prefix, err := rowcodec.EncodeIndexPrefix(t, idx, vals)
+			if err != nil {
+				return err
+			}
+			found, err := anyKey(ctx.Txn, prefix, rowcodec.PrefixEnd(prefix))
+			if err != nil {
+				return err
+			}
+			if found {
+				return uniqueViolation(t, idx, row)
+			}
+		}
+	}
+	key, err := rowcodec.EncodeIndexKey(t, idx, row)
+	if err != nil {
+		return err
+	}
+	return ctx.Txn.Put(key, []byte{})
+}
+
+// anyKey reports whether any key lies in [start, end).
+func anyKey(r engine.Reader, start, end []byte) (bool, error) {
+	it, err := r.Scan(start, end)
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+	_, _, err = it.Next()
+	if errors.Is(err, engine.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func uniqueViolation(t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	ords := t.ColumnOrdinals(idx)
+	vals := make([]string, len(ords))
+	for i, ord := range ords {
+		vals[i] = row[ord].String()
+	}
+	return pgerror.Newf(pgerror.CodeUniqueViolation,
+		"duplicate key value violates unique constraint %q", idx.Name).
+		WithDetail(fmt.Sprintf("Key (%s)=(%s) already exists.",
+			strings.Join(t.IndexColumnNames(idx), ", "), strings.Join(vals, ", "))).
+		WithTable(t.Name).WithConstraint(idx.Name)
+}
+
+// failingRow formats the visible columns of row of t for the detail of a
+// constraint violation.
+func failingRow(t *catalog.Table, row []types.Datum) string {
+	var vals []types.Datum
+	for i, c := range t.Columns {
+		if !c.Hidden {
+			vals = append(vals, row[i])
+		}
+	}
+	return formatRow(vals)
+}
+
+// formatRow formats a row the way PostgreSQL shows it in error details.
+func formatRow(row []types.Datum) string {
+	vals := make([]string, len(row))
+	for i, d := range row {
+		if d == types.DNull {
+			vals[i] = "null"
+		} else {
+			vals[i] = d.String()
+		}
+	}
+	return "(" + strings.Join(vals, ", ") + ")"
+}
@@ -0,0 +1,140 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// valuesOp evaluates constant rows.
+type valuesOp struct {
+	ctx  *Context
+	rows [][]eval.Expr
+	pos  int
+}
+
+func (o *valuesOp) Next() ([]types.Datum, error) {
+	if o.pos >= len(o.rows) {
+		return nil, nil
+	}
+	exprs := o.rows[o.pos]
+	o.pos++
+	o.ctx.Eval.Row = nil
+	row := make([]types.Datum, len(exprs))
+	for i, e := range exprs {
+		d, err := e.Eval(o.ctx.Eval)
+		if err != nil {
+			return nil, err
+		}
+		row[i] = d
+	}
+	return row, nil
+}
+
+func (o *valuesOp) Close() {}
+
+// rowsOp returns rows computed in advance.
+type rowsOp struct {
+	rows [][]types.Datum
+}
+
+func (o *rowsOp) Next() ([]types.Datum, error) {
+	if len(o.rows) == 0 {
+		return nil, nil
+	}
+	row := o.rows[0]
+	o.rows = o.rows[1:]
+	return row, nil
+}
+
+func (o *rowsOp) Close() {}
+
+// filterOp passes rows for which pred is true.
+type filterOp struct {
+	ctx   *Context
+	input Operator
+	pred  eval.Expr
+}
+
+func (o *filterOp) Next() ([]types.Datum, error) {
+	for {
+		row, err := o.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		ok, err := evalPredicate(o.ctx, o.pred, row)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return row, nil
+		}
+	}
+}
+
+func (o *filterOp) Close() { o.input.Close() }
+
+// evalPredicate reports whether pred is true for row.
+func evalPredicate(ctx *Context, pred eval.Expr, row []types.Datum) (bool, error) {
+	ctx.Eval.Row = row
+	d, err := pred.Eval(ctx.Eval)
+	if err != nil {
+		return false, err
+	}
+	return eval.IsTrue(d), nil
+}
+
+// projectOp computes expressions over each input row.
+type projectOp struct {
+	ctx   *Context
+	input Operator
+	exprs []eval.Expr
+}
+
+func (o *projectOp) Next() ([]types.Datum, error) {
+	row, err := o.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	o.ctx.Eval.Row = row
+	out := make([]types.Datum, len(o.exprs))
+	for i, e := range o.exprs {
+		if out[i], err = e.Eval(o.ctx.Eval); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func (o *projectOp) Close() { o.input.Close() }
+
+// distinctOp drops rows equal to an earlier row. Rows are compared by
+// their key encoding, under which equal values encode identically.
+type distinctOp struct {
+	input Operator
+	cols  []planner.Column
+	seen  map[string]struct{}
+}
+
+func (o *distinctOp) Next() ([]types.Datum, error) {
+	for {
+		row, err := o.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		var key []byte
+		for i, d := range row {
+			if key, err = rowcodec.EncodeKey(key, o.cols[i].Type, d); err != nil {
+				return nil, err
+			}
+		}
+		if _, dup := o.seen[string(key)]; dup {
+			continue
+		}
+		o.seen[string(key)] = struct{}{}
+		return row, nil
+	}
+}
+
+func (o *distinctOp) Close() { o.input.Close() }
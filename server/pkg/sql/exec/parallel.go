@@ -0,0 +1,249 @@
+package exec
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// gatherBatchRows is how many rows a worker of a gatherOp hands over at a
+// time.
+const gatherBatchRows = 64
+
+// gatherOp reads the shards of a scan with workers, each of which reads a
+// shard at a time through its own iterator, decoding and filtering its
+// rows, and returns the rows as the workers hand them over, in no
+// particular order. A transaction is used by one goroutine at a time, so
+// the iterators of the shards are all opened up front, and closed once
+// the workers have stopped; the iterators of a transaction that is not
+// being written may be stepped at once, so the transaction is read-only
+// until then, as beginGather makes it.
+type gatherOp struct {
+	ctx     *Context
+	n       *planner.Scan
+	workers int
+	its     []engine.Iterator
+	// reading is whether the gatherOp has made the transaction read-only.
+	reading bool
+	// out carries the batches of rows of the workers, and is closed once
+	// they have all stopped. done is closed to stop them early.
+	out  chan gatherBatch
+	done chan struct{}
+	wg   sync.WaitGroup
+	// rows are the rows of the batch being returned.
+	rows [][]types.Datum
+}
+
+// gatherBatch is rows a worker of a gatherOp read, or the error it failed
+// with.
+type gatherBatch struct {
+	rows [][]types.Datum
+	err  error
+}
+
+func newGather(ctx *Context, n *planner.Scan, workers int) *gatherOp {
+	return &gatherOp{ctx: ctx, n: n, workers: workers}
+}
+
+func (o *gatherOp) Next() ([]types.Datum, error) {
+	if o.out == nil {
+		if err := o.start(); err != nil {
+			return nil, err
+		}
+	}
+	for len(o.rows) == 0 {
+		if err := o.ctx.interrupted(); err != nil {
+			return nil, err
+		}
+		b, ok := <-o.out
+		if !ok {
+			return nil, nil
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+		o.rows = b.rows
+	}
+	row := o.rows[0]
+	o.rows = o.rows[1:]
+	return row, nil
+}
+
+// start opens the iterators of the shards and starts the workers.
+func (o *gatherOp) start() error {
+	o.ctx.beginGather()
+	o.reading = true
+	shards := make(chan engine.Iterator, len(o.n.Shards))
+	for _, s := range o.n.Shards {
+		it, err := o.ctx.Txn.Scan(s.Start, s.End)
+		if err != nil {
+			return err
+		}
+		o.its = append(o.its, it)
+		shards <- it
+	}
+	close(shards)
+	o.out = make(chan gatherBatch, o.workers)
+	o.done = make(chan struct{})
+	for range o.workers {
+		// Each worker evaluates the filter in its own context, with its
+		// own cache of regular expressions; the filter reads nothing else
+		// of the context that changes.
+		ev := *o.ctx.Eval
+		ev.Regexps = eval.NewRegexCache()
+		o.wg.Add(1)
+		go o.work(&ev, shards)
+	}
+	go func() {
+		o.wg.Wait()
+		close(o.out)
+	}()
+	return nil
+}
+
+// work reads the shards left until there are none, or the gatherOp stops
+// it.
+func (o *gatherOp) work(ev *eval.Context, shards <-chan engine.Iterator) {
+	defer o.wg.Done()
+	for it := range shards {
+		if err := o.read(ev, it); err != nil {
+			if !errors.Is(err, errGatherDone) {
+				o.send(gatherBatch{err: err})
+			}
+			return
+		}
+	}
+}
+
+// errGatherDone stops a worker whose gatherOp is closed.
+var errGatherDone = errors.New("gather closed")
+
+// read reads the rows of a shard that pass the filter of the scan, and
+// hands them over in batches.
+func (o *gatherOp) read(ev *eval.Context, it engine.Iterator) error {
+	var rows [][]types.Datum
+	for {
+		if err := o.ctx.interrupted(); err != nil {
+			return err
+		}
+		k, v, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		row, err := rowcodec.DecodeRow(o.n.Table, k, v)
+		if err != nil {
+			return err
+		}
+		if o.n.Filter != nil {
+			ev.Row = row
+			d, err := o.n.Filter.Eval(ev)
+			if err != nil {
+				return err
+			}
+			if !eval.IsTrue(d) {
+				continue
+			}
+		}
+		if rows = append(rows, row); len(rows) == gatherBatchRows {
+			if !o.send(gatherBatch{rows: rows}) {
+				return errGatherDone
+			}
+			rows = nil
+		}
+	}
+	if len(rows) > 0 && !o.send(gatherBatch{rows: rows}) {
+		return errGatherDone
+	}
+	return nil
+}
+
+// send hands b over, reporting whether it was taken before the gatherOp
+// was closed.
+func (o *gatherOp) send(b gatherBatch) bool {
+	select {
+	case o.out <- b:
+		return true
+	case <-o.done:
+		return false
+	}
+}
+
+func (o *gatherOp) Close() {
+	if o.done != nil {
+		close(o.done)
+		o.wg.Wait()
+		o.done = nil
+	}
+	for _, it := range o.its {
+		it.Close()
+	}
+	o.its, o.rows = nil, nil
+	if o.reading {
+		o.ctx.endGather()
+		o.reading = false
+	}
+}
+
+// beginGather makes ctx.Txn read-only until the matching endGather, while
+// the workers of a gatherOp step its iterators. The memory engine's
+// iterators read the writes of their transaction unlocked, and native
+// serializes the calls on a transaction with its lock, so a write would
+// race the workers or wait on them. Gathers nest, as those of both sides
+// of a join do.
+func (ctx *Context) beginGather() {
+	if ctx.gathers == 0 {
+		ctx.Txn = readOnlyTxn{ctx.Txn}
+	}
+	ctx.gathers++
+}
+
+// endGather ends a beginGather, restoring ctx.Txn once none is left.
+func (ctx *Context) endGather() {
+	if ctx.gathers--; ctx.gathers == 0 {
+		ctx.Txn = ctx.Txn.(readOnlyTxn).Txn
+	}
+}
+
+// readOnlyTxn is the transaction of a statement while gathers read it.
+// Its writes fail; a statement reads its input to completion before it
+// writes, so none is expected.
+type readOnlyTxn struct {
+	engine.Txn
+}
+
+// GetMany reads keys in one call if the underlying transaction is a
+// MultiGetter.
+func (t readOnlyTxn) GetMany(keys [][]byte) ([][]byte, error) {
+	return engine.GetMany(t.Txn, keys)
+}
+
+func (t readOnlyTxn) Put(key, value []byte) error {
+	return errGatherWrite()
+}
+
+func (t readOnlyTxn) Delete(key []byte) error {
+	return errGatherWrite()
+}
+
+func (t readOnlyTxn) DeleteRange(start, end []byte) error {
+	return errGatherWrite()
+}
+
+func (t readOnlyTxn) WriteBatch(writes []engine.Write) error {
+	return errGatherWrite()
+}
+
+// errGatherWrite is the error of writing a transaction while a parallel
+// scan reads it.
+func errGatherWrite() error {
+	return pgerror.New(pgerror.CodeInternalError, "cannot write the transaction while a parallel scan reads it")
+}
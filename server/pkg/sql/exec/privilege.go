@@ -0,0 +1,343 @@
+package exec
+
+import (
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+)
+
+// privileges is the role a statement runs as, which its reads and writes
+// are checked against.
+type privileges struct {
+	// role is the statement's role, and roles the IDs of those whose
+	// privileges it has; see catalog.InheritedRoles.
+	role  *catalog.Role
+	roles map[catalog.ID]bool
+	// allowed are the privileges on tables that were checked already, or
+	// that the statement needs no privilege for.
+	allowed map[tablePrivilege]bool
+}
+
+type tablePrivilege struct {
+	table catalog.ID
+	priv  catalog.Privilege
+}
+
+// privileges returns the privileges of the statement's role, or nil if it
+// runs as none and may do anything.
+func (ctx *Context) privileges() (*privileges, error) {
+	if ctx.User == "" {
+		return nil, nil
+	}
+	if ctx.privs == nil {
+		role, err := catalog.MustLookupRole(ctx.Txn, ctx.User)
+		if err != nil {
+			return nil, err
+		}
+		roles, err := catalog.InheritedRoles(ctx.Txn, role)
+		if err != nil {
+			return nil, err
+		}
+		ctx.privs = &privileges{role: role, roles: roles, allowed: make(map[tablePrivilege]bool)}
+	}
+	if ctx.privs.role.Superuser {
+		return nil, nil
+	}
+	return ctx.privs, nil
+}
+
+// roleID returns the ID of the statement's role, which owns the objects it
+// creates, or catalog.Public if it runs as none.
+func (ctx *Context) roleID() (catalog.ID, error) {
+	if ctx.User == "" {
+		return catalog.Public, nil
+	}
+	if _, err := ctx.privileges(); err != nil {
+		return 0, err
+	}
+	return ctx.privs.role.ID, nil
+}
+
+// checkTable returns an error unless the statement's role has privilege p
+// on t.
+func (ctx *Context) checkTable(t *catalog.Table, p catalog.Privilege) error {
+	privs, err := ctx.privileges()
+	if err != nil || privs == nil {
+		return err
+	}
+	key := tablePrivilege{t.ID, p}
+	if privs.allowed[key] {
+		return nil
+	}
+	if !catalog.HasPrivilege(privs.roles, t.Owner, t.ACL, p, false) {
+		return pgerror.Newf(pgerror.CodeInsufficientPrivilege, "permission denied for table %s", t.Name)
+	}
+	privs.allowed[key] = true
+	return nil
+}
+
+// allowTable lets the statement use privilege p on t without having it.
+func (ctx *Context) allowTable(t *catalog.Table, p catalog.Privilege) error {
+	privs, err := ctx.privileges()
+	if err != nil || privs == nil {
+		return err
+	}
+	privs.allowed[tablePrivilege{t.ID, p}] = true
+	return nil
+}
+
+// checkOwner returns an error unless the statement's role is a member of
+// owner, the owner of the relation of kind named name, as changing or
+// dropping it requires.
+func (ctx *Context) checkOwner(owner catalog.ID, kind, name string) error {
+	ok, err := ctx.owns(owner)
+	if err == nil && !ok {
+		err = pgerror.Newf(pgerror.CodeInsufficientPrivilege, "must be owner of %s %s", kind, name)
+	}
+	return err
+}
+
+// owns reports whether the statement's role is a member of owner, the
+// owner of an object.
+func (ctx *Context) owns(owner catalog.ID) (bool, error) {
+	privs, err := ctx.privileges()
+	if err != nil || privs == nil {
+		return err == nil, err
+	}
+	return owner != catalog.Public && privs.roles[owner], nil
+}
+
+// checkCreate returns an error unless the statement's role may create
+// relations in the schema with the given ID.
+func (ctx *Context) checkCreate(schema catalog.ID) error {
+	privs, err := ctx.privileges()
+	if err != nil || privs == nil {
+		return err
+	}
+	s, err := catalog.GetSchemaByID(ctx.Txn, schema)
+	if err != nil {
+		return err
+	}
+	if !catalog.HasPrivilege(privs.roles, s.Owner, s.ACL, catalog.PrivCreate, false) {
+		return pgerror.Newf(pgerror.CodeInsufficientPrivilege, "permission denied for schema %s", s.Name)
+	}
+	return nil
+}
+
+// checkSuperuser returns an error unless the statement's role is a
+// superuser, as what ought to be done needs.
+func (ctx *Context) checkSuperuser(what string) error {
+	privs, err := ctx.privileges()
+	if err != nil || privs == nil {
+		return err
+	}
+	return pgerror.Newf(pgerror.CodeInsufficientPrivilege, "must be superuser to %s", what)
+}
+
+// authorize checks the privileges that running plan needs beyond reading
+// the tables it scans, which the scans check as they are built.
+func (ctx *Context) authorize(plan planner.Node) error {
+	switch n := plan.(type) {
+	case *planner.Insert:
+		return ctx.checkTable(n.Table, catalog.PrivInsert)
+	case *planner.Update:
+		if err := ctx.checkTable(n.Table, catalog.PrivUpdate); err != nil {
+			return err
+		}
+		return ctx.allowUnfiltered(n.Table, n.Input)
+	case *planner.Delete:
+		if err := ctx.checkTable(n.Table, catalog.PrivDelete); err != nil {
+			return err
+		}
+		return ctx.allowUnfiltered(n.Table, n.Input)
+	case *planner.Copy:
+		if n.Source.File != "" {
+			if err := ctx.checkSuperuser("COPY from a file"); err != nil {
+				return err
+			}
+		}
+		if n.Errors != nil {
+			if err := ctx.checkTable(n.Errors.Table, catalog.PrivInsert); err != nil {
+				return err
+			}
+		}
+		return ctx.checkTable(n.Insert.Table, catalog.PrivInsert)
+	case *planner.Truncate:
+		// TRUNCATE deletes every row, as DELETE without WHERE does.
+		for _, t := range n.Tables {
+			if err := ctx.checkTable(t, catalog.PrivDelete); err != nil {
+				return err
+			}
+		}
+	case *planner.CreateTable:
+		return ctx.checkCreate(n.Table.Schema)
+	case *planner.CreateSequence:
+		return ctx.checkCreate(n.Sequence.Schema)
+	case *planner.CreateSchema:
+		if err := ctx.checkSuperuser("create a schema"); err != nil {
+			return err
+		}
+	case *planner.DropSchema:
+		for _, s := range n.Schemas {
+			if err := ctx.checkOwner(s.Owner, "schema", s.Name); err != nil {
+				return err
+			}
+		}
+	case *planner.DropDatabase:
+		if n.Database != nil {
+			return ctx.checkOwner(n.Database.Owner, "database", n.Database.Name)
+		}
+	case *planner.CreatePublication:
+		if n.Publication.AllTables {
+			return ctx.checkSuperuser("create FOR ALL TABLES publication")
+		}
+		for _, t := range n.Tables {
+			if err := ctx.checkOwner(t.Owner, "table", t.Name); err != nil {
+				return err
+			}
+		}
+	case *planner.DropPublication:
+		for _, pub := range n.Publications {
+			if err := ctx.checkOwner(pub.Owner, "publication", pub.Name); err != nil {
+				return err
+			}
+		}
+	case *planner.CreateIndex:
+		return ctx.checkOwner(n.Table.Owner, "table", n.Table.Name)
+	case *planner.Cluster:
+		if n.Named {
+			return ctx.checkOwner(n.Tables[0].Owner, "table", n.Tables[0].Name)
+		}
+	case *planner.Vacuum:
+		if n.Database == nil {
+			for _, t := range n.Tables {
+				if err := ctx.checkOwner(t.Owner, "table", t.Name); err != nil {
+					return err
+				}
+			}
+		}
+	case *planner.AlterTable:
+		if n.Old != nil {
+			return ctx.checkOwner(n.Old.Owner, "table", n.Old.Name)
+		}
+	case *planner.DropTable:
+		for _, t := range n.Tables {
+			if err := ctx.checkOwner(t.Owner, "table", t.Name); err != nil {
+				return err
+			}
+		}
+	case *planner.DropIndex:
+		for _, ref := range n.Indexes {
+			if err := ctx.checkOwner(ref.Table.Owner, "index", ref.Index.Name); err != nil {
+				return err
+			}
+		}
+	case *planner.DropSequence:
+		for _, seq := range n.Sequences {
+			if err := ctx.checkOwner(seq.Owner, "sequence", seq.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// allowUnfiltered lets UPDATE and DELETE of t read the rows they write
+// without SELECT when input reads them all: only a WHERE clause needs it.
+func (ctx *Context) allowUnfiltered(t *catalog.Table, input planner.Node) error {
+	if scan, ok := input.(*planner.Scan); ok && scan.Filter == nil && scan.Table.ID == t.ID {
+		return ctx.allowTable(t, catalog.PrivSelect)
+	}
+	return nil
+}
+
+// tablePrivileges implements eval.Privileges for the statement of ctx.
+type tablePrivileges struct {
+	ctx *Context
+}
+
+// rolesOf returns the IDs of the roles whose privileges user has, or nil if
+// it has every privilege. An empty user is the statement's role.
+func (p *tablePrivileges) rolesOf(user string) (map[catalog.ID]bool, error) {
+	if user == "" {
+		privs, err := p.ctx.privileges()
+		if err != nil || privs == nil {
+			return nil, err
+		}
+		return privs.roles, nil
+	}
+	if user == "public" {
+		return catalog.InheritedRoles(p.ctx.Txn, nil)
+	}
+	role, err := catalog.MustLookupRole(p.ctx.Txn, user)
+	if err != nil || role.Superuser {
+		return nil, err
+	}
+	return catalog.InheritedRoles(p.ctx.Txn, role)
+}
+
+func (p *tablePrivileges) HasTablePrivilege(user, schema, table, privilege string) (bool, error) {
+	id, ok, err := p.ctx.resolve(schema, table)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", qualifiedName(schema, table))
+	}
+	t, err := catalog.MustLookupTable(p.ctx.Txn, id, table)
+	if err != nil {
+		return false, err
+	}
+	return p.has(user, t.Owner, t.ACL, catalog.TablePrivileges, privilege)
+}
+
+func (p *tablePrivileges) HasSchemaPrivilege(user, schema, privilege string) (bool, error) {
+	s, err := catalog.LookupSchema(p.ctx.Txn, schema)
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		return false, pgerror.Newf(pgerror.CodeInvalidSchemaName, "schema %q does not exist", schema)
+	}
+	return p.has(user, s.Owner, s.ACL, catalog.SchemaPrivileges, privilege)
+}
+
+func (p *tablePrivileges) CheckSuperuser(what string) error {
+	return p.ctx.checkSuperuser(what)
+}
+
+// has reports whether user has any of the privileges privilege lists, of
+// those valid of an object with owner and acl, as has_table_privilege
+// does. Each may be followed by WITH GRANT OPTION.
+func (p *tablePrivileges) has(user string, owner catalog.ID, acl catalog.ACL, valid catalog.Privilege, privilege string) (bool, error) {
+	roles, err := p.rolesOf(user)
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, name := range strings.Split(privilege, ",") {
+		name = strings.TrimSpace(name)
+		option := false
+		if base, ok := cutSuffixFold(name, " with grant option"); ok {
+			name, option = strings.TrimSpace(base), true
+		}
+		priv, ok := catalog.ParsePrivilege(name)
+		if !ok || priv&valid == 0 {
+			return false, pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized privilege type: %q", name)
+		}
+		if roles == nil || catalog.HasPrivilege(roles, owner, acl, priv, option) {
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// cutSuffixFold is strings.CutSuffix, ignoring case.
+func cutSuffixFold(s, suffix string) (string, bool) {
+	if len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix) {
+		return s[:len(s)-len(suffix)], true
+	}
+	return s, false
+}
@@ -0,0 +1,76 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// progressInterval is the number of tuples a command processes between
+// reports of its progress.
+const progressInterval = 1024
+
+// progress reports the progress of a long command to ctx.ReportProgress,
+// when it starts a phase and every progressInterval tuples.
+type progress struct {
+	ctx *Context
+	p   vtable.Progress
+}
+
+// startProgress starts reporting the progress of command on t. The caller
+// must call finish once the command ends.
+func startProgress(ctx *Context, command string, t *catalog.Table) *progress {
+	return &progress{ctx: ctx, p: vtable.Progress{Command: command, Relname: t.Name, TuplesTotal: -1}}
+}
+
+// phase starts the phase name, of total tuples, or of an unknown number if
+// total is negative.
+func (p *progress) phase(name string, total int64) {
+	p.p.Phase, p.p.TuplesTotal, p.p.TuplesDone = name, total, 0
+	p.report()
+}
+
+// tuple counts a tuple of the phase as processed.
+func (p *progress) tuple() {
+	p.p.TuplesDone++
+	if p.p.TuplesDone%progressInterval == 0 {
+		p.report()
+	}
+}
+
+func (p *progress) report() {
+	if p.ctx.ReportProgress != nil {
+		p.ctx.ReportProgress(p.p)
+	}
+}
+
+// finish reports that the command ended.
+func (p *progress) finish() {
+	if p.ctx.ReportProgress != nil {
+		p.ctx.ReportProgress(vtable.Progress{})
+	}
+}
+
+// readAll runs plan to completion in the phase name, counting the rows it
+// returns as the tuples processed.
+func (p *progress) readAll(name string, plan planner.Node) ([][]types.Datum, error) {
+	p.phase(name, -1)
+	op, err := Build(p.ctx, plan)
+	if err != nil {
+		return nil, err
+	}
+	defer op.Close()
+	var rows [][]types.Datum
+	for {
+		row, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			return rows, nil
+		}
+		rows = append(rows, row)
+		p.tuple()
+	}
+}
@@ -0,0 +1,42 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// RowChange is a change a statement made to the rows of a table, which
+// logical replication sends: Old is nil for an insert, New for a delete,
+// and both are nil for a truncate.
+type RowChange struct {
+	Table    *catalog.Table
+	Old, New []types.Datum
+	// RestartIdentity is set for a truncate with RESTART IDENTITY.
+	RestartIdentity bool
+}
+
+// changed reports a change to the rows of t to ctx.Changed, if it is set.
+func (ctx *Context) changed(t *catalog.Table, old, row []types.Datum) {
+	if ctx.Changed != nil {
+		ctx.Changed(RowChange{Table: t, Old: old, New: row})
+	}
+}
+
+func runCreatePublication(ctx *Context, n *planner.CreatePublication) error {
+	owner, err := ctx.roleID()
+	if err != nil {
+		return err
+	}
+	n.Publication.Owner = owner
+	return catalog.CreatePublication(ctx.Txn, n.Publication)
+}
+
+func runDropPublication(ctx *Context, n *planner.DropPublication) error {
+	for _, pub := range n.Publications {
+		if err := catalog.DropPublication(ctx.Txn, pub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+)
+
+func runCreateRole(ctx *Context, n *planner.CreateRole) error {
+	if err := catalog.CreateRole(ctx.Txn, n.Role); err != nil {
+		return err
+	}
+	for _, m := range n.Members {
+		m.MemberOf = append(m.MemberOf, n.Role.ID)
+		if err := catalog.WriteRole(ctx.Txn, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runAlterRole(ctx *Context, n *planner.AlterRole) error {
+	if n.OldName != "" {
+		return catalog.RenameRole(ctx.Txn, n.OldName, n.Role)
+	}
+	return catalog.WriteRole(ctx.Txn, n.Role)
+}
+
+func runAlterRoleSettings(ctx *Context, n *planner.AlterRoleSettings) error {
+	return catalog.WriteRoleSettings(ctx.Txn, n.Settings)
+}
+
+func runDropRole(ctx *Context, n *planner.DropRole) error {
+	for _, role := range n.Roles {
+		if err := catalog.DropRole(ctx.Txn, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runGrantRole(ctx *Context, n *planner.GrantRole) error {
+	for _, role := range n.Roles {
+		if err := catalog.WriteRole(ctx.Txn, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runGrant(ctx *Context, n *planner.Grant) error {
+	for _, t := range n.Tables {
+		if err := catalog.WriteTable(ctx.Txn, t); err != nil {
+			return err
+		}
+	}
+	for _, s := range n.Schemas {
+		if err := catalog.WriteSchema(ctx.Txn, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
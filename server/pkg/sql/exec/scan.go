@@ -0,0 +1,209 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// fetchBatchMin and fetchBatchMax bound how many entries of a secondary
+// index a scan reads ahead to fetch their rows in one call. The batch
+// starts small, so that a scan a LIMIT stops early reads little ahead, and
+// doubles with each fetch.
+const (
+	fetchBatchMin = 8
+	fetchBatchMax = 256
+)
+
+// scanOp reads the rows of a table through an index, span by span. Entries
+// of a secondary index are joined back to the primary index to fetch the
+// full row, a batch of entries at a time.
+type scanOp struct {
+	ctx   *Context
+	n     *planner.Scan
+	spans []planner.Span
+	span  int
+	it    engine.Iterator
+	// ahead are the rows fetched for the entries read ahead, and batch is
+	// how many entries the next fetch reads.
+	ahead [][]types.Datum
+	batch int
+}
+
+func newScan(ctx *Context, n *planner.Scan) *scanOp {
+	return &scanOp{ctx: ctx, n: n, spans: n.Spans}
+}
+
+func (o *scanOp) Next() ([]types.Datum, error) {
+	if o.n.Parameterized && o.spans == nil {
+		spans, err := planner.BindSpans(o.n, o.ctx.Eval.Placeholders)
+		if err != nil {
+			return nil, err
+		}
+		o.spans = spans
+	}
+	for {
+		if err := o.ctx.interrupted(); err != nil {
+			return nil, err
+		}
+		if len(o.ahead) > 0 {
+			row := o.ahead[0]
+			o.ahead = o.ahead[1:]
+			ok, err := o.filter(row)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				return row, nil
+			}
+			continue
+		}
+		if o.it == nil {
+			if o.span >= len(o.spans) {
+				return nil, nil
+			}
+			s := o.spans[o.span]
+			o.span++
+			it, err := o.ctx.Txn.Scan(s.Start, s.End)
+			if err != nil {
+				return nil, err
+			}
+			o.it = it
+		}
+		k, v, err := o.it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			o.it.Close()
+			o.it = nil
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// A loose scan skips ahead after each row it returns, so it fetches
+		// its rows one at a time.
+		if o.n.Index.ID != catalog.PrimaryIndexID && !o.n.Loose {
+			if err := o.fetchBatch(k); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		row, err := o.fetch(k, v)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := o.filter(row)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if o.n.Loose {
+			if err := o.skip(k); err != nil {
+				return nil, err
+			}
+		}
+		return row, nil
+	}
+}
+
+// filter reports whether row passes the filter of the scan.
+func (o *scanOp) filter(row []types.Datum) (bool, error) {
+	if o.n.Filter == nil {
+		return true, nil
+	}
+	return evalPredicate(o.ctx, o.n.Filter, row)
+}
+
+// fetchBatch reads ahead up to a batch of entries of a secondary index,
+// from the entry at key, and fetches their rows from the primary index in
+// one read.
+func (o *scanOp) fetchBatch(key []byte) error {
+	t, idx := o.n.Table, o.n.Index
+	o.batch = min(max(o.batch*2, fetchBatchMin), fetchBatchMax)
+	pks := make([][]byte, 0, o.batch)
+	for {
+		pk, err := rowcodec.PrimaryKeyFromIndexKey(t, idx, key)
+		if err != nil {
+			return err
+		}
+		pks = append(pks, pk)
+		if len(pks) == o.batch {
+			break
+		}
+		key, _, err = o.it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			o.it.Close()
+			o.it = nil
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	vals, err := engine.GetMany(o.ctx.Txn, pks)
+	if err != nil {
+		return err
+	}
+	for i, pk := range pks {
+		if vals[i] == nil {
+			return engine.ErrNotFound
+		}
+		row, err := rowcodec.DecodeRow(t, pk, vals[i])
+		if err != nil {
+			return err
+		}
+		o.ahead = append(o.ahead, row)
+	}
+	return nil
+}
+
+// skip moves a loose scan past the entries with the same values in the
+// leading columns as the entry at key.
+func (o *scanOp) skip(key []byte) error {
+	if o.n.Prefix == 0 {
+		o.it.Close()
+		o.it, o.span = nil, len(o.spans)
+		return nil
+	}
+	prefix, err := rowcodec.IndexKeyPrefix(o.n.Table, o.n.Index, key, o.n.Prefix)
+	if err != nil {
+		return err
+	}
+	next, end := rowcodec.PrefixEnd(prefix), o.spans[o.span-1].End
+	if next == nil || end != nil && bytes.Compare(next, end) >= 0 {
+		o.it.Close()
+		o.it = nil
+		return nil
+	}
+	o.it, err = engine.Seek(o.ctx.Txn, o.it, next, end)
+	return err
+}
+
+// fetch decodes the row an index entry refers to.
+func (o *scanOp) fetch(k, v []byte) ([]types.Datum, error) {
+	t, idx := o.n.Table, o.n.Index
+	if idx.ID == catalog.PrimaryIndexID {
+		return rowcodec.DecodeRow(t, k, v)
+	}
+	pk, err := rowcodec.PrimaryKeyFromIndexKey(t, idx, k)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = o.ctx.Txn.Get(pk); err != nil {
+		return nil, err
+	}
+	return rowcodec.DecodeRow(t, pk, v)
+}
+
+func (o *scanOp) Close() {
+	if o.it != nil {
+		o.it.Close()
+		o.it = nil
+	}
+}
@@ -0,0 +1,232 @@
+package exec
+
+import (
+	"errors"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+)
+
+// SequenceState is the state a session keeps of sequences: the value
+// nextval last returned for each, for currval and lastval, and the values
+// taken but not yet handed out of sequences with a CACHE.
+type SequenceState struct {
+	curr   map[catalog.ID]int64
+	last   *int64
+	cached map[catalog.ID]*cachedValues
+}
+
+// cachedValues are the values a session took from a sequence at once:
+// those after last up to and including end.
+type cachedValues struct {
+	last, end int64
+}
+
+// NewSequenceState returns the state of a session that has not used any
+// sequence.
+func NewSequenceState() *SequenceState {
+	return &SequenceState{curr: make(map[catalog.ID]int64), cached: make(map[catalog.ID]*cachedValues)}
+}
+
+// sequences implements eval.Sequences for the statement of ctx.
+type sequences struct {
+	ctx *Context
+}
+
+// lookup returns the sequence named name in schema, or in the search path
+// if schema is empty.
+func (s *sequences) lookup(schema, name string) (*catalog.Sequence, error) {
+	id, ok, err := s.ctx.resolve(schema, name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", qualifiedName(schema, name))
+	}
+	return catalog.MustLookupSequence(s.ctx.Txn, id, name)
+}
+
+func (s *sequences) NextVal(schema, name string) (int64, error) {
+	seq, err := s.lookup(schema, name)
+	if err != nil {
+		return 0, err
+	}
+	state := s.ctx.Sequences
+	if c := state.cached[seq.ID]; c != nil {
+		v, err := nextValue(seq, catalog.SequenceValue{Last: c.last, Called: true})
+		if err != nil {
+			return 0, err
+		}
+		if c.last = v; v == c.end {
+			delete(state.cached, seq.ID)
+		}
+		state.curr[seq.ID] = v
+		state.last = &v
+		return v, nil
+	}
+	// A sequence with a CACHE is advanced past the values taken before
+	// the first is handed out, in one transaction for all of them.
+	var v, end int64
+	own, err := s.change(seq, func(txn engine.Txn) error {
+		cur, err := catalog.GetSequenceValue(txn, seq.ID)
+		if err != nil {
+			return err
+		}
+		if v, err = nextValue(seq, cur); err != nil {
+			return err
+		}
+		end = v
+		for i := int64(1); i < seq.Cache; i++ {
+			next, err := nextValue(seq, catalog.SequenceValue{Last: end, Called: true})
+			if err != nil || next == v {
+				// The cache stops short at the end of a sequence that
+				// does not cycle, or once it holds every value.
+				break
+			}
+			end = next
+		}
+		return catalog.PutSequenceValue(txn, seq.ID, catalog.SequenceValue{Last: end, Called: true})
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Values taken in the statement's transaction are returned if it
+	// rolls back, so they are not kept past it.
+	if own && end != v {
+		state.cached[seq.ID] = &cachedValues{last: v, end: end}
+	}
+	state.curr[seq.ID] = v
+	s.ctx.Sequences.last = &v
+	return v, nil
+}
+
+func (s *sequences) CurrVal(schema, name string) (int64, error) {
+	seq, err := s.lookup(schema, name)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := s.ctx.Sequences.curr[seq.ID]
+	if !ok {
+		return 0, pgerror.Newf(pgerror.CodeObjectNotInPrerequisite,
+			"currval of sequence %q is not yet defined in this session", name)
+	}
+	return v, nil
+}
+
+func (s *sequences) SetVal(schema, name string, v int64, called bool) error {
+	seq, err := s.lookup(schema, name)
+	if err != nil {
+		return err
+	}
+	if v < seq.MinValue || v > seq.MaxValue {
+		return pgerror.Newf(pgerror.CodeNumericValueOutOfRange,
+			"setval: value %d is out of bounds for sequence %q (%d..%d)", v, name, seq.MinValue, seq.MaxValue)
+	}
+	_, err = s.change(seq, func(txn engine.Txn) error {
+		return catalog.PutSequenceValue(txn, seq.ID, catalog.SequenceValue{Last: v, Called: called})
+	})
+	delete(s.ctx.Sequences.cached, seq.ID)
+	if err == nil && called {
+		s.ctx.Sequences.curr[seq.ID] = v
+	}
+	return err
+}
+
+func (s *sequences) LastVal() (int64, error) {
+	if s.ctx.Sequences.last == nil {
+		return 0, pgerror.New(pgerror.CodeObjectNotInPrerequisite, "lastval is not yet defined in this session")
+	}
+	return *s.ctx.Sequences.last, nil
+}
+
+// restart makes the next nextval of seq return its start value.
+func (s *sequences) restart(seq *catalog.Sequence) error {
+	delete(s.ctx.Sequences.cached, seq.ID)
+	_, err := s.change(seq, func(txn engine.Txn) error {
+		return catalog.PutSequenceValue(txn, seq.ID, catalog.SequenceValue{Last: seq.Start})
+	})
+	return err
+}
+
+// change runs fn on the value of seq in a transaction of its own, so that
+// the change is not rolled back with the statement's transaction,
+// retrying it when a concurrent transaction changes the value first, and
+// reports whether it did. A sequence created by the statement's
+// transaction is visible to no other, so its value is changed in that
+// transaction, as is the value of a gapless sequence.
+func (s *sequences) change(seq *catalog.Sequence, fn func(engine.Txn) error) (bool, error) {
+	if s.ctx.Engine == nil || seq.Gapless {
+		return false, fn(s.ctx.Txn)
+	}
+	_, err := catalog.GetSequenceValue(s.ctx.Engine, seq.ID)
+	switch {
+	case errors.Is(err, engine.ErrNotFound):
+		return false, fn(s.ctx.Txn)
+	case err != nil:
+		return false, err
+	}
+	for {
+		if err := engine.RunTxn(s.ctx.Engine, fn); !errors.Is(err, engine.ErrConflict) {
+			return true, err
+		}
+	}
+}
+
+// nextValue returns the value after cur of seq, wrapping around if seq
+// cycles.
+func nextValue(seq *catalog.Sequence, cur catalog.SequenceValue) (int64, error) {
+	if !cur.Called {
+		return cur.Last, nil
+	}
+	next := cur.Last + seq.Increment
+	// The sum overflows if it moves against the increment.
+	overflow := (next > cur.Last) != (seq.Increment > 0)
+	switch {
+	case seq.Increment > 0 && (overflow || next > seq.MaxValue):
+		if !seq.Cycle {
+			return 0, pgerror.Newf(pgerror.CodeSequenceGeneratorLimit,
+				"nextval: reached maximum value of sequence %q (%d)", seq.Name, seq.MaxValue)
+		}
+		next = seq.MinValue
+	case seq.Increment < 0 && (overflow || next < seq.MinValue):
+		if !seq.Cycle {
+			return 0, pgerror.Newf(pgerror.CodeSequenceGeneratorLimit,
+				"nextval: reached minimum value of sequence %q (%d)", seq.Name, seq.MinValue)
+		}
+		next = seq.MaxValue
+	}
+	return next, nil
+}
+
+func runCreateSequence(ctx *Context, n *planner.CreateSequence) error {
+	if n.Exists {
+		return nil
+	}
+	owner, err := ctx.roleID()
+	if err != nil {
+		return err
+	}
+	n.Sequence.Owner = owner
+	return catalog.CreateSequence(ctx.Txn, n.Sequence)
+}
+
+func runDropSequence(ctx *Context, n *planner.DropSequence) error {
+	for _, t := range n.Detached {
+		if err := catalog.WriteTable(ctx.Txn, t); err != nil {
+			return err
+		}
+	}
+	return dropSequences(ctx, n.Sequences)
+}
+
+func dropSequences(ctx *Context, seqs []*catalog.Sequence) error {
+	for _, seq := range seqs {
+		if err := catalog.DropSequence(ctx.Txn, seq); err != nil {
+			return err
+		}
+		delete(ctx.Sequences.cached, seq.ID)
+	}
+	return nil
+}
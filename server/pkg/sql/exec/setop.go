@@ -0,0 +1,104 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// setOpOp evaluates a set operation. A union streams the rows of the left
+// then the right input; an intersection or exception first reads the right
+// input into a table counting its rows by key, then streams the left rows
+// against it. Without All, the keys of the rows returned are remembered so
+// each distinct row is returned once.
+type setOpOp struct {
+	n           *planner.SetOp
+	left, right Operator
+	// counts holds the number of right rows of each key not yet matched
+	// by a left row, for an intersection or exception.
+	counts map[string]int
+	seen   map[string]struct{}
+}
+
+func (o *setOpOp) key(row []types.Datum) (string, error) {
+	var key []byte
+	var err error
+	for i, d := range row {
+		if key, err = rowcodec.EncodeKey(key, o.n.Cols[i].Type, d); err != nil {
+			return "", err
+		}
+	}
+	return string(key), nil
+}
+
+func (o *setOpOp) Next() ([]types.Datum, error) {
+	if o.n.Type != planner.UnionOp && o.counts == nil {
+		o.counts = map[string]int{}
+		for {
+			row, err := o.right.Next()
+			if err != nil {
+				return nil, err
+			}
+			if row == nil {
+				break
+			}
+			key, err := o.key(row)
+			if err != nil {
+				return nil, err
+			}
+			o.counts[key]++
+		}
+	}
+	for {
+		row, err := o.left.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			if o.n.Type != planner.UnionOp || o.right == nil {
+				return nil, nil
+			}
+			// Continue with the right rows.
+			o.left.Close()
+			o.left, o.right = o.right, nil
+			continue
+		}
+		if o.n.Type == planner.UnionOp && o.seen == nil {
+			return row, nil
+		}
+		key, err := o.key(row)
+		if err != nil {
+			return nil, err
+		}
+		if o.seen != nil {
+			if _, dup := o.seen[key]; dup {
+				continue
+			}
+		}
+		switch o.n.Type {
+		case planner.IntersectOp:
+			if o.counts[key] == 0 {
+				continue
+			}
+			o.counts[key]--
+		case planner.ExceptOp:
+			if o.counts[key] > 0 {
+				if o.n.All {
+					o.counts[key]--
+				}
+				continue
+			}
+		}
+		if o.seen != nil {
+			o.seen[key] = struct{}{}
+		}
+		return row, nil
+	}
+}
+
+func (o *setOpOp) Close() {
+	o.left.Close()
+	if o.right != nil {
+		o.right.Close()
+	}
+}
@@ -0,0 +1,318 @@
+package exec
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// compareRows orders two rows by keys.
+func compareRows(keys []planner.SortKey, a, b []types.Datum) int {
+	for _, k := range keys {
+		da, db := a[k.Col], b[k.Col]
+		var c int
+		switch {
+		case da == types.DNull && db == types.DNull:
+			continue
+		case da == types.DNull:
+			c = 1
+			if k.NullsFirst {
+				c = -1
+			}
+			return c
+		case db == types.DNull:
+			c = -1
+			if k.NullsFirst {
+				c = 1
+			}
+			return c
+		}
+		c = da.Compare(db)
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// sortOp reads all of its input on the first call to Next and returns it
+// in order. When limit is not negative only the first limit rows are
+// needed; they are kept in a bounded heap instead of sorting every row.
+//
+// Rows beyond work_mem are sorted externally if the context can spill:
+// each time the rows read take the sort past work_mem, they are sorted
+// and written to a temporary range as a run, and the runs are merged as
+// the sorted rows are returned.
+type sortOp struct {
+	ctx   *Context
+	input Operator
+	keys  []planner.SortKey
+	typs  []*types.T
+	limit int64
+	rows  [][]types.Datum
+	done  bool
+	mem   *memAccount
+	// runs are the runs written to temporary ranges, and merge merges
+	// them with the rows of the last run, which stay in memory.
+	runs  []*spillRange
+	merge *mergeOp
+}
+
+func (o *sortOp) Next() ([]types.Datum, error) {
+	if !o.done {
+		var err error
+		if o.limit >= 0 {
+			o.rows, err = o.topK()
+		} else {
+			o.rows, err = o.sortAll()
+		}
+		if err != nil {
+			return nil, err
+		}
+		o.done = true
+	}
+	if o.merge != nil {
+		return o.merge.Next()
+	}
+	if len(o.rows) == 0 {
+		return nil, nil
+	}
+	row := o.rows[0]
+	o.rows = o.rows[1:]
+	return row, nil
+}
+
+func (o *sortOp) sortAll() ([][]types.Datum, error) {
+	var rows [][]types.Datum
+	for {
+		row, err := o.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		size := rowSize(row)
+		if err := o.mem.grow(size); err != nil {
+			if o.ctx.Spill == nil || len(rows) == 0 {
+				return nil, err
+			}
+			if err := o.spill(rows); err != nil {
+				return nil, err
+			}
+			rows = nil
+			if err := o.mem.grow(size); err != nil {
+				return nil, err
+			}
+		}
+		rows = append(rows, row)
+	}
+	o.sort(rows)
+	if len(o.runs) == 0 {
+		return rows, nil
+	}
+	inputs := make([]Operator, 0, len(o.runs)+1)
+	for _, r := range o.runs {
+		in, err := r.rows()
+		if err != nil {
+			closeAll(inputs)
+			return nil, err
+		}
+		inputs = append(inputs, in)
+	}
+	o.merge = newMerge(o.keys, append(inputs, &rowsOp{rows: rows}))
+	return nil, nil
+}
+
+// spill sorts rows and writes them to a temporary range as a run,
+// returning the memory they held.
+func (o *sortOp) spill(rows [][]types.Datum) error {
+	o.sort(rows)
+	run := newSpillRange(o.ctx, o.typs)
+	o.runs = append(o.runs, run)
+	for _, row := range rows {
+		if err := run.add(row); err != nil {
+			return err
+		}
+	}
+	if err := run.flush(); err != nil {
+		return err
+	}
+	o.mem.close()
+	return nil
+}
+
+func (o *sortOp) sort(rows [][]types.Datum) {
+	slices.SortStableFunc(rows, func(a, b []types.Datum) int { return compareRows(o.keys, a, b) })
+}
+
+// topK returns the first o.limit rows of the input in order. With a limit
+// of zero no row is needed, and the input is not read.
+func (o *sortOp) topK() ([][]types.Datum, error) {
+	if o.limit == 0 {
+		return nil, nil
+	}
+	h := &rowHeap{keys: o.keys}
+	var seq int64
+	for {
+		row, err := o.input.Next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		r := heapRow{row: row, seq: seq}
+		seq++
+		switch {
+		case int64(len(h.rows)) < o.limit:
+			if err := o.mem.grow(rowSize(row)); err != nil {
+				return nil, err
+			}
+			heap.Push(h, r)
+		case len(h.rows) > 0 && h.less(r, h.rows[0]):
+			h.rows[0] = r
+			heap.Fix(h, 0)
+		}
+	}
+	rows := make([][]types.Datum, len(h.rows))
+	for i := len(rows) - 1; i >= 0; i-- {
+		rows[i] = heap.Pop(h).(heapRow).row
+	}
+	return rows, nil
+}
+
+func (o *sortOp) Close() {
+	if o.merge != nil {
+		o.merge.Close()
+	}
+	for _, r := range o.runs {
+		r.drop()
+	}
+	o.mem.close()
+	o.input.Close()
+}
+
+// heapRow is a row in a top-K heap. seq is the input position, which
+// breaks ties so the result matches a stable sort.
+type heapRow struct {
+	row []types.Datum
+	seq int64
+}
+
+// rowHeap is a max-heap of rows: its root is the row that would be
+// returned last, and so the first to be evicted.
+type rowHeap struct {
+	keys []planner.SortKey
+	rows []heapRow
+}
+
+// less reports whether a sorts before b.
+func (h *rowHeap) less(a, b heapRow) bool {
+	if c := compareRows(h.keys, a.row, b.row); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+func (h *rowHeap) Len() int           { return len(h.rows) }
+func (h *rowHeap) Less(i, j int) bool { return h.less(h.rows[j], h.rows[i]) }
+func (h *rowHeap) Swap(i, j int)      { h.rows[i], h.rows[j] = h.rows[j], h.rows[i] }
+func (h *rowHeap) Push(x any)         { h.rows = append(h.rows, x.(heapRow)) }
+
+func (h *rowHeap) Pop() any {
+	last := h.rows[len(h.rows)-1]
+	h.rows = h.rows[:len(h.rows)-1]
+	return last
+}
+
+// limitOp skips offset rows, then returns up to count rows. A negative
+// count means no limit; a count of zero, that the input is not read.
+type limitOp struct {
+	input         Operator
+	count, offset int64
+}
+
+func (o *limitOp) Next() ([]types.Datum, error) {
+	if o.count == 0 {
+		return nil, nil
+	}
+	for ; o.offset > 0; o.offset-- {
+		row, err := o.input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+	}
+	row, err := o.input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	if o.count > 0 {
+		o.count--
+	}
+	return row, nil
+}
+
+func (o *limitOp) Close() { o.input.Close() }
+
+// buildLimit evaluates the bounds of n and returns its operator. A sort
+// directly below the limit is bounded to the rows the limit can return.
+func buildLimit(ctx *Context, n *planner.Limit) (Operator, error) {
+	count, err := evalLimitArg(ctx, n.Count, -1, pgerror.CodeInvalidLimitRowCount, "LIMIT")
+	if err != nil {
+		return nil, err
+	}
+	offset, err := evalLimitArg(ctx, n.Offset, 0, pgerror.CodeInvalidOffsetRowCount, "OFFSET")
+	if err != nil {
+		return nil, err
+	}
+	var in Operator
+	if sort, ok := n.Input.(*planner.Sort); ok && count >= 0 {
+		bound := int64(math.MaxInt64)
+		if count <= math.MaxInt64-offset {
+			bound = count + offset
+		}
+		in, err = buildSort(ctx, sort, bound)
+	} else {
+		in, err = Build(ctx, n.Input)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &limitOp{input: in, count: count, offset: offset}, nil
+}
+
+// evalLimitArg evaluates a LIMIT or OFFSET argument, returning def if it
+// is absent or NULL.
+func evalLimitArg(ctx *Context, e eval.Expr, def int64, code, clause string) (int64, error) {
+	if e == nil {
+		return def, nil
+	}
+	ctx.Eval.Row = nil
+	d, err := e.Eval(ctx.Eval)
+	if err != nil || d == types.DNull {
+		return def, err
+	}
+	v := int64(d.(types.DInt))
+	if v < 0 {
+		return 0, pgerror.Newf(code, "%s must not be negative", clause)
+	}
+	return v, nil
+}
+
+func buildSort(ctx *Context, n *planner.Sort, limit int64) (Operator, error) {
+	in, err := Build(ctx, n.Input)
+	if err != nil {
+		return nil, err
+	}
+	return &sortOp{ctx: ctx, input: in, keys: n.Keys, typs: columnTypes(n.Input.Columns()), limit: limit,
+		mem: ctx.Memory.account("sort")}, nil
+}
@@ -0,0 +1,212 @@
+package exec
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// spillIDs numbers the temporary ranges of the server's process.
+var spillIDs atomic.Uint64
+
+// liveSpills are the IDs of the temporary ranges of spillRanges that have
+// not been dropped.
+var liveSpills struct {
+	sync.Mutex
+	ids map[uint64]struct{}
+}
+
+// SpillLive reports whether the temporary range id belongs to an operator
+// that may still use it. A range of the server's process that is not
+// live, but holds rows, is one whose drop failed, and may be deleted.
+func SpillLive(id uint64) bool {
+	liveSpills.Lock()
+	defer liveSpills.Unlock()
+	_, ok := liveSpills.ids[id]
+	return ok
+}
+
+// spillBatchBytes bounds the rows a spillRange buffers before it writes
+// them to the engine, in one transaction.
+const spillBatchBytes = 256 << 10
+
+// spillRange is a temporary range of Context.Spill that a sort or a hash
+// aggregation writes rows to once they exceed work_mem, and reads back in
+// the order they were written. Its writes are committed as they are
+// buffered, outside the statement's transaction, and the range is deleted
+// once the operator is done with it.
+type spillRange struct {
+	e          engine.Engine
+	typs       []*types.T
+	id         uint64
+	start, end []byte
+	n          uint64
+	batch      []engine.Write
+	size       int
+}
+
+func newSpillRange(ctx *Context, typs []*types.T) *spillRange {
+	id := spillIDs.Add(1)
+	liveSpills.Lock()
+	if liveSpills.ids == nil {
+		liveSpills.ids = make(map[uint64]struct{})
+	}
+	liveSpills.ids[id] = struct{}{}
+	liveSpills.Unlock()
+	start, end := catalog.TempRange(id)
+	return &spillRange{e: ctx.Spill, typs: typs, id: id, start: start, end: end}
+}
+
+// add appends row to the range.
+func (r *spillRange) add(row []types.Datum) error {
+	value, err := rowcodec.EncodeDatums(nil, r.typs, row)
+	if err != nil {
+		return err
+	}
+	key := binary.BigEndian.AppendUint64(bytes.Clone(r.start), r.n)
+	r.n++
+	r.batch = append(r.batch, engine.Write{Key: key, Value: value})
+	if r.size += len(key) + len(value); r.size >= spillBatchBytes {
+		return r.flush()
+	}
+	return nil
+}
+
+// flush writes the rows buffered by add.
+func (r *spillRange) flush() error {
+	if len(r.batch) == 0 {
+		return nil
+	}
+	batch := r.batch
+	r.batch, r.size = nil, 0
+	return engine.RunTxn(r.e, func(txn engine.Txn) error {
+		if b, ok := txn.(engine.Batcher); ok {
+			return b.WriteBatch(batch)
+		}
+		for _, w := range batch {
+			if err := txn.Put(w.Key, w.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rows returns an operator that reads the rows of the range.
+func (r *spillRange) rows() (Operator, error) {
+	if err := r.flush(); err != nil {
+		return nil, err
+	}
+	it, err := r.e.Scan(r.start, r.end)
+	if err != nil {
+		return nil, err
+	}
+	return &spillScanOp{it: it, typs: r.typs}, nil
+}
+
+// drop deletes the range. It is no longer live even if that fails, so
+// that the server's reaper deletes it later.
+func (r *spillRange) drop() error {
+	r.batch, r.size = nil, 0
+	err := engine.DeleteRange(r.e, r.start, r.end)
+	liveSpills.Lock()
+	delete(liveSpills.ids, r.id)
+	liveSpills.Unlock()
+	return err
+}
+
+// spillScanOp reads the rows of a spillRange.
+type spillScanOp struct {
+	it   engine.Iterator
+	typs []*types.T
+}
+
+func (o *spillScanOp) Next() ([]types.Datum, error) {
+	_, v, err := o.it.Next()
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rowcodec.DecodeDatums(v, o.typs)
+}
+
+func (o *spillScanOp) Close() { o.it.Close() }
+
+// mergeOp merges inputs that each return their rows ordered by keys into
+// one ordered sequence. Rows that sort equal are returned in the order of
+// their inputs, so merging the sorted runs of a stable sort is stable.
+type mergeOp struct {
+	inputs []Operator
+	h      mergeHeap
+	primed bool
+}
+
+func newMerge(keys []planner.SortKey, inputs []Operator) *mergeOp {
+	return &mergeOp{inputs: inputs, h: mergeHeap{rowHeap{keys: keys}}}
+}
+
+func (o *mergeOp) Next() ([]types.Datum, error) {
+	if !o.primed {
+		o.primed = true
+		for i, in := range o.inputs {
+			if err := o.pull(in, i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(o.h.rows) == 0 {
+		return nil, nil
+	}
+	top := heap.Pop(&o.h).(heapRow)
+	if err := o.pull(o.inputs[top.seq], int(top.seq)); err != nil {
+		return nil, err
+	}
+	return top.row, nil
+}
+
+// pull pushes the next row of input i onto the heap, if it has one.
+func (o *mergeOp) pull(in Operator, i int) error {
+	row, err := in.Next()
+	if err != nil || row == nil {
+		return err
+	}
+	heap.Push(&o.h, heapRow{row: row, seq: int64(i)})
+	return nil
+}
+
+func (o *mergeOp) Close() { closeAll(o.inputs) }
+
+// closeAll closes ops.
+func closeAll(ops []Operator) {
+	for _, op := range ops {
+		op.Close()
+	}
+}
+
+// columnTypes returns the types of cols.
+func columnTypes(cols []planner.Column) []*types.T {
+	typs := make([]*types.T, len(cols))
+	for i, c := range cols {
+		typs[i] = c.Type
+	}
+	return typs
+}
+
+// mergeHeap is a min-heap of the next rows of the inputs of a mergeOp,
+// whose seq is the index of the input each came from.
+type mergeHeap struct {
+	rowHeap
+}
+
+func (h *mergeHeap) Less(i, j int) bool { return h.less(h.rows[i], h.rows[j]) }
@@ -0,0 +1,109 @@
+package exec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// subqueries runs the subqueries of a statement. The results of
+// uncorrelated subqueries do not depend on the row they are evaluated for,
+// so they are computed once and kept.
+type subqueries struct {
+	ctx  *Context
+	rows map[*eval.SubqueryExpr][][]types.Datum
+	sets map[*eval.SubqueryExpr]*valueSet
+}
+
+// valueSet holds the values of a single-column result by key encoding.
+type valueSet struct {
+	keys    map[string]struct{}
+	hasNull bool
+}
+
+func newSubqueries(ctx *Context) *subqueries {
+	return &subqueries{
+		ctx:  ctx,
+		rows: map[*eval.SubqueryExpr][][]types.Datum{},
+		sets: map[*eval.SubqueryExpr]*valueSet{},
+	}
+}
+
+func (s *subqueries) Rows(ctx *eval.Context, e *eval.SubqueryExpr, limit int) ([][]types.Datum, error) {
+	if rows, ok := s.rows[e]; ok {
+		return rows, nil
+	}
+	rows, err := s.run(ctx, e, limit)
+	if err != nil {
+		return nil, err
+	}
+	if e.Correlation == 0 {
+		s.rows[e] = rows
+	}
+	return rows, nil
+}
+
+func (s *subqueries) Contains(ctx *eval.Context, e *eval.SubqueryExpr, d types.Datum) (types.Datum, error) {
+	set := s.sets[e]
+	if set == nil {
+		rows, err := s.run(ctx, e, -1)
+		if err != nil {
+			return nil, err
+		}
+		set = &valueSet{keys: make(map[string]struct{}, len(rows))}
+		typ := e.Left.ResolvedType()
+		for _, row := range rows {
+			if row[0] == types.DNull {
+				set.hasNull = true
+				continue
+			}
+			key, err := rowcodec.EncodeKey(nil, typ, row[0])
+			if err != nil {
+				return nil, err
+			}
+			set.keys[string(key)] = struct{}{}
+		}
+		if e.Correlation == 0 {
+			s.sets[e] = set
+		}
+	}
+	key, err := rowcodec.EncodeKey(nil, e.Left.ResolvedType(), d)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := set.keys[string(key)]; ok {
+		return types.MakeDBool(true), nil
+	}
+	if set.hasNull {
+		return types.DNull, nil
+	}
+	return types.MakeDBool(false), nil
+}
+
+// run executes e's query with the current row as the enclosing row.
+func (s *subqueries) run(ctx *eval.Context, e *eval.SubqueryExpr, limit int) ([][]types.Datum, error) {
+	row := ctx.Row
+	ctx.Outer = append(ctx.Outer, row)
+	defer func() {
+		ctx.Outer = ctx.Outer[:len(ctx.Outer)-1]
+		ctx.Row = row
+	}()
+	op, err := Build(s.ctx, e.Plan.(planner.Node))
+	if err != nil {
+		return nil, err
+	}
+	defer op.Close()
+	var rows [][]types.Datum
+	for limit < 0 || len(rows) < limit {
+		r, err := op.Next()
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			break
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
@@ -0,0 +1,99 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+)
+
+// runVacuum compacts the key ranges of the tables, their rows and index
+// entries, in the storage engine, which would otherwise reclaim the space
+// of the versions updated or deleted only as background compaction reaches
+// them. Engines that do not compact, and so have nothing to reclaim, are
+// left as they are; those that cannot compact on demand fail it with
+// feature_not_supported.
+//
+// Compaction is of the engine, not of the statement's transaction, and
+// keeps the versions open transactions can still read. Each table's is
+// listed in pg_stat_progress_vacuum while it runs.
+func runVacuum(ctx *Context, n *planner.Vacuum) error {
+	c, ok := ctx.Engine.(engine.Compacter)
+	whole := false
+	if ok && n.Database != nil {
+		var err error
+		if whole, err = ctx.owns(n.Database.Owner); err != nil {
+			return err
+		}
+	}
+	var owned []*catalog.Table
+	for _, t := range n.Tables {
+		// As in PostgreSQL, VACUUM of every table skips those the role
+		// does not own, unless it owns the database.
+		if n.Database != nil && !whole {
+			if ok, err := ctx.owns(t.Owner); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+		}
+		owned = append(owned, t)
+	}
+	switch {
+	case !ok:
+	case whole:
+		// The ranges between the tables too, so that the space of dropped
+		// tables, which no table's range covers any longer, is reclaimed.
+		slices.SortFunc(owned, func(a, b *catalog.Table) int {
+			return bytes.Compare(rowcodec.TablePrefix(a.ID), rowcodec.TablePrefix(b.ID))
+		})
+		var start []byte
+		for _, t := range owned {
+			prefix := rowcodec.TablePrefix(t.ID)
+			if err := compact(c, start, prefix); err != nil {
+				return err
+			}
+			if err := vacuumTable(ctx, c, t); err != nil {
+				return err
+			}
+			start = rowcodec.PrefixEnd(prefix)
+		}
+		if err := compact(c, start, nil); err != nil {
+			return err
+		}
+	default:
+		for _, t := range owned {
+			if err := vacuumTable(ctx, c, t); err != nil {
+				return err
+			}
+		}
+	}
+	if n.Analyze {
+		return runAnalyze(ctx, &planner.Analyze{Tables: owned})
+	}
+	return nil
+}
+
+// vacuumTable compacts the key range of t, reporting it as the phase
+// "vacuuming heap" of VACUUM.
+func vacuumTable(ctx *Context, c engine.Compacter, t *catalog.Table) error {
+	p := startProgress(ctx, "VACUUM", t)
+	defer p.finish()
+	p.phase("vacuuming heap", -1)
+	prefix := rowcodec.TablePrefix(t.ID)
+	return compact(c, prefix, rowcodec.PrefixEnd(prefix))
+}
+
+// compact compacts the keys in [start, end) of c.
+func compact(c engine.Compacter, start, end []byte) error {
+	err := c.CompactRange(start, end)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, "the storage engine cannot compact on demand")
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package exec
+
+import (
+	"errors"
+	"math"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/hnsw"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// efSearch is the number of candidates a vector search considers, unless
+// its LIMIT asks for more rows; pgvector's default for hnsw.ef_search.
+const efSearch = 40
+
+// graph returns the HNSW graph of idx, whose nodes are identified by the
+// primary key columns of their rows.
+func graph(ctx *Context, t *catalog.Table, idx *catalog.Index) *hnsw.Graph {
+	return &hnsw.Graph{
+		Store:          ctx.Txn,
+		Prefix:         rowcodec.IndexPrefix(t.ID, idx.ID),
+		Distance:       eval.VectorDistances[idx.HNSW.Operator],
+		M:              idx.HNSW.M,
+		EfConstruction: idx.HNSW.EfConstruction,
+	}
+}
+
+// vectorEntry returns the vector row has in the column of idx and the ID of
+// its node, or a nil vector if the column is NULL, which is not indexed.
+func vectorEntry(t *catalog.Table, idx *catalog.Index, row []types.Datum) (*types.DVector, []byte, error) {
+	d := row[t.ColumnOrdinal(idx.ColumnIDs[0])]
+	if d == types.DNull {
+		return nil, nil, nil
+	}
+	pk, err := rowcodec.EncodeIndexKey(t, t.PrimaryIndex, row)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.(*types.DVector), pk[len(rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)):], nil
+}
+
+// putVectorEntry adds row to an HNSW index.
+func putVectorEntry(ctx *Context, t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	v, id, err := vectorEntry(t, idx, row)
+	if err != nil || v == nil {
+		return err
+	}
+	return graph(ctx, t, idx).Insert(id, v)
+}
+
+// deleteVectorEntry removes row from an HNSW index.
+func deleteVectorEntry(ctx *Context, t *catalog.Table, idx *catalog.Index, row []types.Datum) error {
+	v, id, err := vectorEntry(t, idx, row)
+	if err != nil || v == nil {
+		return err
+	}
+	return graph(ctx, t, idx).Delete(id)
+}
+
+// updateVectorEntry moves a row of an HNSW index from old to row, unless
+// neither its vector nor its primary key changed.
+func updateVectorEntry(ctx *Context, t *catalog.Table, idx *catalog.Index, old, row []types.Datum) error {
+	ov, oid, err := vectorEntry(t, idx, old)
+	if err != nil {
+		return err
+	}
+	nv, nid, err := vectorEntry(t, idx, row)
+	if err != nil {
+		return err
+	}
+	if ov != nil && nv != nil && ov.Compare(nv) == 0 && string(oid) == string(nid) {
+		return nil
+	}
+	if err := deleteVectorEntry(ctx, t, idx, old); err != nil {
+		return err
+	}
+	return putVectorEntry(ctx, t, idx, row)
+}
+
+// vectorSearchOp returns the rows found by searching an HNSW index, nearest
+// first.
+type vectorSearchOp struct {
+	ctx *Context
+	n   *planner.VectorSearch
+
+	// scan reads the whole table instead when there is no limit.
+	scan    Operator
+	results []hnsw.Result
+	done    bool
+}
+
+func (o *vectorSearchOp) search() error {
+	count, err := evalLimitArg(o.ctx, o.n.Count, -1, pgerror.CodeInvalidLimitRowCount, "LIMIT")
+	if err != nil {
+		return err
+	}
+	offset, err := evalLimitArg(o.ctx, o.n.Offset, 0, pgerror.CodeInvalidOffsetRowCount, "OFFSET")
+	if err != nil {
+		return err
+	}
+	t := o.n.Table
+	if count < 0 {
+		// Without a limit every row is wanted, so read them all.
+		prefix := rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID)
+		o.scan = newScan(o.ctx, &planner.Scan{
+			Table:  t,
+			Index:  t.PrimaryIndex,
+			Spans:  []planner.Span{{Start: prefix, End: rowcodec.PrefixEnd(prefix)}},
+			Filter: o.n.Filter,
+		})
+		return nil
+	}
+	o.ctx.Eval.Row = nil
+	d, err := o.n.Query.Eval(o.ctx.Eval)
+	if err != nil || d == types.DNull {
+		// Distances from NULL are NULL, and there is nothing nearest.
+		return err
+	}
+	q := d.(*types.DVector)
+	if dims := t.Columns[t.ColumnOrdinal(o.n.Index.ColumnIDs[0])].Type.Width; int(dims) != len(q.Elems) {
+		return pgerror.Newf(pgerror.CodeDataException, "different vector dimensions %d and %d", dims, len(q.Elems))
+	}
+	ef := efSearch
+	switch k := count + offset; {
+	case k < 0:
+		// The sum overflowed.
+		ef = math.MaxInt32
+	case k > efSearch:
+		ef = int(min(k, math.MaxInt32))
+	}
+	o.results, err = graph(o.ctx, t, o.n.Index).Search(q, ef)
+	return err
+}
+
+// fetch reads the row of a node, or nil if the row is gone.
+func (o *vectorSearchOp) fetch(id []byte) ([]types.Datum, error) {
+	t := o.n.Table
+	pk := append(rowcodec.IndexPrefix(t.ID, catalog.PrimaryIndexID), id...)
+	v, err := o.ctx.Txn.Get(pk)
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rowcodec.DecodeRow(t, pk, v)
+}
+
+func (o *vectorSearchOp) Next() ([]types.Datum, error) {
+	if !o.done {
+		if err := o.search(); err != nil {
+			return nil, err
+		}
+		o.done = true
+	}
+	if o.scan != nil {
+		return o.scan.Next()
+	}
+	for len(o.results) > 0 {
+		r := o.results[0]
+		o.results = o.results[1:]
+		row, err := o.fetch(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			continue
+		}
+		if o.n.Filter != nil {
+			ok, err := evalPredicate(o.ctx, o.n.Filter, row)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		return row, nil
+	}
+	return nil, nil
+}
+
+func (o *vectorSearchOp) Close() {
+	if o.scan != nil {
+		o.scan.Close()
+	}
+}
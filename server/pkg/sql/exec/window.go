@@ -0,0 +1,153 @@
+package exec
+
+import (
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// windowOp computes window functions. It reads all of its input on the
+// first call to Next, then computes the functions over each window in
+// turn, sorting the rows by it.
+type windowOp struct {
+	ctx   *Context
+	input Operator
+	n     *planner.Window
+	rows  [][]types.Datum
+	done  bool
+}
+
+// windowRow is an input row with its PARTITION BY and ORDER BY values for
+// the window being computed.
+type windowRow struct {
+	row, key []types.Datum
+}
+
+func (o *windowOp) Next() ([]types.Datum, error) {
+	if !o.done {
+		if err := o.compute(); err != nil {
+			return nil, err
+		}
+		o.done = true
+	}
+	if len(o.rows) == 0 {
+		return nil, nil
+	}
+	row := o.rows[0]
+	o.rows = o.rows[1:]
+	return row, nil
+}
+
+func (o *windowOp) compute() error {
+	in, err := drain(o.input)
+	if err != nil {
+		return err
+	}
+	width := len(o.n.Input.Columns())
+	rows := make([]windowRow, len(in))
+	for i, row := range in {
+		rows[i].row = append(make([]types.Datum, 0, width+len(o.n.Funcs)), row...)
+		rows[i].row = rows[i].row[:width+len(o.n.Funcs)]
+	}
+	for w, spec := range o.n.Windows {
+		keys, err := o.sort(rows, spec)
+		if err != nil {
+			return err
+		}
+		np := len(spec.PartitionBy)
+		for start := 0; start < len(rows); {
+			end := start + 1
+			for end < len(rows) && compareRows(keys[:np], rows[start].key, rows[end].key) == 0 {
+				end++
+			}
+			if err := o.computePartition(w, rows[start:end], np, keys[np:]); err != nil {
+				return err
+			}
+			start = end
+		}
+	}
+	o.rows = make([][]types.Datum, len(rows))
+	for i, r := range rows {
+		o.rows[i] = r.row
+	}
+	return nil
+}
+
+// sort evaluates the PARTITION BY and ORDER BY values of spec for each row
+// and sorts the rows by them, returning the sort keys over those values.
+func (o *windowOp) sort(rows []windowRow, spec *planner.WindowSpec) ([]planner.SortKey, error) {
+	np := len(spec.PartitionBy)
+	for i := range rows {
+		o.ctx.Eval.Row = rows[i].row
+		key := make([]types.Datum, np+len(spec.OrderBy))
+		for j, e := range slices.Concat(spec.PartitionBy, spec.OrderBy) {
+			var err error
+			if key[j], err = e.Eval(o.ctx.Eval); err != nil {
+				return nil, err
+			}
+		}
+		rows[i].key = key
+	}
+	keys := make([]planner.SortKey, 0, np+len(spec.Order))
+	for i := range np {
+		keys = append(keys, planner.SortKey{Col: i})
+	}
+	for _, k := range spec.Order {
+		k.Col += np
+		keys = append(keys, k)
+	}
+	slices.SortStableFunc(rows, func(a, b windowRow) int { return compareRows(keys, a.key, b.key) })
+	return keys, nil
+}
+
+// computePartition computes the functions over window w for the rows of
+// one partition, whose ORDER BY values follow their np PARTITION BY
+// values in their keys.
+func (o *windowOp) computePartition(w int, rows []windowRow, np int, order []planner.SortKey) error {
+	peers := make([]int, len(rows))
+	for i := 1; i < len(rows); i++ {
+		peers[i] = peers[i-1]
+		if compareRows(order, rows[i-1].key, rows[i].key) != 0 {
+			peers[i]++
+		}
+	}
+	var orderVals []types.Datum
+	if len(order) > 0 {
+		orderVals = make([]types.Datum, len(rows))
+		for i, r := range rows {
+			orderVals[i] = r.key[np]
+		}
+	}
+	width := len(rows[0].row) - len(o.n.Funcs)
+	out := make([]types.Datum, len(rows))
+	for fi, f := range o.n.Funcs {
+		if f.Window != w {
+			continue
+		}
+		p, err := eval.NewWindowPartition(o.ctx.Eval, &f.Frame, peers, orderVals)
+		if err != nil {
+			return err
+		}
+		args := make([][]types.Datum, len(rows))
+		for i, r := range rows {
+			o.ctx.Eval.Row = r.row
+			args[i] = make([]types.Datum, len(f.Call.Args))
+			for j, e := range f.Call.Args {
+				if args[i][j], err = e.Eval(o.ctx.Eval); err != nil {
+					return err
+				}
+			}
+		}
+		if err := f.Call.Compute(o.ctx.Eval, p, args, out); err != nil {
+			return err
+		}
+		for i, r := range rows {
+			r.row[width+fi] = out[i]
+		}
+	}
+	return nil
+}
+
+func (o *windowOp) Close() { o.input.Close() }
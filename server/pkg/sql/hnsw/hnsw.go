@@ -0,0 +1,531 @@
+// Package hnsw implements Hierarchical Navigable Small World graphs, the
+// approximate nearest neighbor index of pgvector's hnsw access method,
+// stored in the key-value engine.
+//
+// Each vector is a node of the graph, keyed by an ID supplied by the
+// caller. A node is assigned a random level and is linked to its nearest
+// neighbors on every layer up to it; few nodes reach the upper layers, so a
+// search descends greedily from a single entry point through ever denser
+// layers. Nodes are stored one per key, with their vector and neighbor
+// lists, so the graph is read and changed within the caller's transaction
+// like any other index.
+package hnsw
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Store is the storage a graph is read from and written to, usually a
+// transaction.
+type Store interface {
+	engine.Reader
+	engine.Writer
+}
+
+// Graph is an HNSW graph stored under a key prefix.
+type Graph struct {
+	Store  Store
+	Prefix []byte
+	// Distance orders vectors by their distance from one another.
+	Distance func(a, b *types.DVector) float64
+	// M is the number of neighbors of a node on each layer above the
+	// lowest, which has 2*M.
+	M int
+	// EfConstruction is the number of candidates considered for the
+	// neighbors of a new node.
+	EfConstruction int
+
+	// nodes caches the nodes read by one operation, and dirty the ones it
+	// changed.
+	nodes map[string]*node
+	dirty map[string]bool
+}
+
+// Keys under the prefix. The entry key records the entry point; each node
+// is stored at the node key prefix followed by its ID.
+const (
+	entryKeyByte byte = 0x00
+	nodeKeyByte  byte = 0x01
+)
+
+// node is a vector of the graph and its neighbors on each layer from 0 up
+// to its level.
+type node struct {
+	id        string
+	vec       *types.DVector
+	neighbors [][]string
+}
+
+func (n *node) level() int { return len(n.neighbors) - 1 }
+
+// Result is a node found by Search.
+type Result struct {
+	ID       []byte
+	Distance float64
+}
+
+// Insert adds the vector v with the given ID.
+func (g *Graph) Insert(id []byte, v *types.DVector) error {
+	g.begin()
+	n := &node{id: string(id), vec: v, neighbors: make([][]string, g.randomLevel()+1)}
+	g.nodes[n.id], g.dirty[n.id] = n, true
+	entry, err := g.entry()
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return g.finish(n)
+	}
+	eps := []candidate{{n: entry, dist: g.Distance(v, entry.vec)}}
+	for lc := entry.level(); lc > n.level(); lc-- {
+		if eps, err = g.searchLayer(v, eps, 1, lc); err != nil {
+			return err
+		}
+	}
+	for lc := min(n.level(), entry.level()); lc >= 0; lc-- {
+		found, err := g.searchLayer(v, eps, g.EfConstruction, lc)
+		if err != nil {
+			return err
+		}
+		for _, c := range g.selectNeighbors(found, g.maxNeighbors(lc)) {
+			n.neighbors[lc] = append(n.neighbors[lc], c.n.id)
+			if err := g.link(c.n, n, lc); err != nil {
+				return err
+			}
+		}
+		eps = found
+	}
+	if n.level() > entry.level() {
+		return g.finish(n)
+	}
+	return g.finish(nil)
+}
+
+// Delete removes the node with the given ID, if there is one. Its
+// neighbors are relinked among themselves so the graph stays connected.
+func (g *Graph) Delete(id []byte) error {
+	g.begin()
+	n, err := g.node(string(id))
+	if err != nil || n == nil {
+		return err
+	}
+	if err := g.Store.Delete(g.nodeKey(n.id)); err != nil {
+		return err
+	}
+	delete(g.nodes, n.id)
+	for lc, ids := range n.neighbors {
+		for _, nid := range ids {
+			nb, err := g.node(nid)
+			if err != nil {
+				return err
+			}
+			if nb == nil || nb.level() < lc {
+				continue
+			}
+			// Replace the link to n by links to n's other neighbors, if
+			// they are among nb's nearest.
+			cands := make([]candidate, 0, len(nb.neighbors[lc])+len(ids))
+			for _, cid := range slices.Concat(nb.neighbors[lc], ids) {
+				if cid == n.id || cid == nb.id || slices.ContainsFunc(cands, func(c candidate) bool { return c.n.id == cid }) {
+					continue
+				}
+				c, err := g.node(cid)
+				if err != nil {
+					return err
+				}
+				if c != nil && c.level() >= lc {
+					cands = append(cands, candidate{n: c, dist: g.Distance(nb.vec, c.vec)})
+				}
+			}
+			sortCandidates(cands)
+			nb.neighbors[lc] = nb.neighbors[lc][:0]
+			for _, c := range g.selectNeighbors(cands, g.maxNeighbors(lc)) {
+				nb.neighbors[lc] = append(nb.neighbors[lc], c.n.id)
+			}
+			g.dirty[nb.id] = true
+		}
+	}
+	entry, err := g.entryID()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(entry, id) {
+		return g.finish(nil)
+	}
+	// The entry point was removed: promote the neighbor of n on its
+	// highest layer that reaches the highest level.
+	var next *node
+	for lc := n.level(); lc >= 0 && next == nil; lc-- {
+		for _, nid := range n.neighbors[lc] {
+			nb, err := g.node(nid)
+			if err != nil {
+				return err
+			}
+			if nb != nil && (next == nil || nb.level() > next.level()) {
+				next = nb
+			}
+		}
+	}
+	if next == nil {
+		// n had no neighbors left; any remaining node will do.
+		if next, err = g.anyNode(); err != nil {
+			return err
+		}
+	}
+	if next == nil {
+		if err := g.Store.Delete(g.entryKey()); err != nil {
+			return err
+		}
+		return g.finish(nil)
+	}
+	return g.finish(next)
+}
+
+// Search returns the nodes nearest to q, nearest first. It considers ef
+// candidates, finding more of the true nearest nodes the larger ef is, and
+// returns all of them.
+func (g *Graph) Search(q *types.DVector, ef int) ([]Result, error) {
+	g.begin()
+	defer g.begin()
+	entry, err := g.entry()
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	eps := []candidate{{n: entry, dist: g.Distance(q, entry.vec)}}
+	for lc := entry.level(); lc > 0; lc-- {
+		if eps, err = g.searchLayer(q, eps, 1, lc); err != nil {
+			return nil, err
+		}
+	}
+	found, err := g.searchLayer(q, eps, ef, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Result, len(found))
+	for i, c := range found {
+		out[i] = Result{ID: []byte(c.n.id), Distance: c.dist}
+	}
+	return out, nil
+}
+
+// candidate is a node and its distance from the vector being searched for
+// or linked.
+type candidate struct {
+	n    *node
+	dist float64
+}
+
+func sortCandidates(cs []candidate) {
+	slices.SortStableFunc(cs, func(a, b candidate) int { return compareDist(a.dist, b.dist) })
+}
+
+// compareDist orders distances with NaN, the cosine distance of a zero
+// vector, last.
+func compareDist(a, b float64) int {
+	switch an, bn := math.IsNaN(a), math.IsNaN(b); {
+	case an && bn:
+		return 0
+	case an:
+		return 1
+	case bn:
+		return -1
+	}
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// searchLayer returns the ef nodes nearest to q found on layer lc by
+// following links from the entry points eps, nearest first.
+func (g *Graph) searchLayer(q *types.DVector, eps []candidate, ef, lc int) ([]candidate, error) {
+	visited := make(map[string]bool, min(ef, 1024)*4)
+	var cands, found []candidate
+	for _, ep := range eps {
+		visited[ep.n.id] = true
+		cands = insertCandidate(cands, ep, -1)
+		found = insertCandidate(found, ep, ef)
+	}
+	for len(cands) > 0 {
+		c := cands[0]
+		cands = cands[1:]
+		if len(found) >= ef && compareDist(c.dist, found[len(found)-1].dist) > 0 {
+			break
+		}
+		for _, id := range c.n.neighbors[lc] {
+			if visited[id] {
+				continue
+			}
+			visited[id] = true
+			nb, err := g.node(id)
+			if err != nil {
+				return nil, err
+			}
+			if nb == nil || nb.level() < lc {
+				// A link left behind by a deleted node, or by one since
+				// reinserted at a lower level.
+				continue
+			}
+			d := candidate{n: nb, dist: g.Distance(q, nb.vec)}
+			if len(found) < ef || compareDist(d.dist, found[len(found)-1].dist) < 0 {
+				cands = insertCandidate(cands, d, -1)
+				found = insertCandidate(found, d, ef)
+			}
+		}
+	}
+	return found, nil
+}
+
+// insertCandidate inserts c into cs, which is ordered nearest first, and
+// keeps at most limit candidates unless limit is negative.
+func insertCandidate(cs []candidate, c candidate, limit int) []candidate {
+	i, _ := slices.BinarySearchFunc(cs, c.dist, func(x candidate, d float64) int {
+		if compareDist(x.dist, d) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if limit >= 0 && i >= limit {
+		return cs
+	}
+	cs = slices.Insert(cs, i, c)
+	if limit >= 0 && len(cs) > limit {
+		cs = cs[:limit]
+	}
+	return cs
+}
+
+// selectNeighbors picks at most m of cands, which are ordered nearest
+// first, with the heuristic of the HNSW paper: a candidate is skipped if
+// it is nearer to an already selected neighbor than to the node, as the
+// node reaches it through that neighbor. Skipped candidates fill any
+// remaining places.
+func (g *Graph) selectNeighbors(cands []candidate, m int) []candidate {
+	if len(cands) <= m {
+		return cands
+	}
+	selected := make([]candidate, 0, m)
+	var skipped []candidate
+	for _, c := range cands {
+		if len(selected) == m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if compareDist(g.Distance(c.n.vec, s.n.vec), c.dist) < 0 {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c)
+		} else {
+			skipped = append(skipped, c)
+		}
+	}
+	for _, c := range skipped {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, c)
+	}
+	return selected
+}
+
+// link adds a link from nb to n on layer lc, dropping nb's farthest
+// neighbors if it then has too many.
+func (g *Graph) link(nb, n *node, lc int) error {
+	g.dirty[nb.id] = true
+	nb.neighbors[lc] = append(nb.neighbors[lc], n.id)
+	if len(nb.neighbors[lc]) <= g.maxNeighbors(lc) {
+		return nil
+	}
+	cands := make([]candidate, 0, len(nb.neighbors[lc]))
+	for _, id := range nb.neighbors[lc] {
+		c, err := g.node(id)
+		if err != nil {
+			return err
+		}
+		if c != nil && c.level() >= lc {
+			cands = append(cands, candidate{n: c, dist: g.Distance(nb.vec, c.vec)})
+		}
+	}
+	sortCandidates(cands)
+	nb.neighbors[lc] = nb.neighbors[lc][:0]
+	for _, c := range g.selectNeighbors(cands, g.maxNeighbors(lc)) {
+		nb.neighbors[lc] = append(nb.neighbors[lc], c.n.id)
+	}
+	return nil
+}
+
+func (g *Graph) maxNeighbors(lc int) int {
+	if lc == 0 {
+		return 2 * g.M
+	}
+	return g.M
+}
+
+// randomLevel draws a node's level from the exponential distribution the
+// paper recommends, normalized by ln(M).
+func (g *Graph) randomLevel() int {
+	return int(-math.Log(1-rand.Float64()) / math.Log(float64(g.M)))
+}
+
+// begin starts an operation with an empty node cache.
+func (g *Graph) begin() {
+	g.nodes = map[string]*node{}
+	g.dirty = map[string]bool{}
+}
+
+// finish writes the nodes the operation changed, and records entry as the
+// entry point if it is not nil.
+func (g *Graph) finish(entry *node) error {
+	for id := range g.dirty {
+		if n := g.nodes[id]; n != nil {
+			if err := g.Store.Put(g.nodeKey(id), encodeNode(n)); err != nil {
+				return err
+			}
+		}
+	}
+	if entry != nil {
+		if err := g.Store.Put(g.entryKey(), []byte(entry.id)); err != nil {
+			return err
+		}
+	}
+	g.begin()
+	return nil
+}
+
+func (g *Graph) entryKey() []byte {
+	return append(bytes.Clone(g.Prefix), entryKeyByte)
+}
+
+func (g *Graph) nodeKey(id string) []byte {
+	return append(append(bytes.Clone(g.Prefix), nodeKeyByte), id...)
+}
+
+// entryID returns the ID of the entry point, or nil if the graph is
+// empty.
+func (g *Graph) entryID() ([]byte, error) {
+	id, err := g.Store.Get(g.entryKey())
+	if errors.Is(err, engine.ErrNotFound) {
+		return nil, nil
+	}
+	return id, err
+}
+
+func (g *Graph) entry() (*node, error) {
+	id, err := g.entryID()
+	if err != nil || id == nil {
+		return nil, err
+	}
+	return g.node(string(id))
+}
+
+// node returns the node with the given ID, or nil if there is none.
+func (g *Graph) node(id string) (*node, error) {
+	if n, ok := g.nodes[id]; ok {
+		return n, nil
+	}
+	v, err := g.Store.Get(g.nodeKey(id))
+	if errors.Is(err, engine.ErrNotFound) {
+		g.nodes[id] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err := decodeNode(id, v)
+	if err != nil {
+		return nil, err
+	}
+	g.nodes[id] = n
+	return n, nil
+}
+
+// anyNode returns the first node in key order, or nil if there is none.
+func (g *Graph) anyNode() (*node, error) {
+	start := append(bytes.Clone(g.Prefix), nodeKeyByte)
+	it, err := g.Store.Scan(start, rowcodec.PrefixEnd(start))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n, err := g.node(string(k[len(start):])); err != nil || n != nil {
+			return n, err
+		}
+	}
+}
+
+// encodeNode encodes a node as its vector, then its number of layers, then
+// for each layer its number of neighbors and their length-prefixed IDs.
+func encodeNode(n *node) []byte {
+	buf := rowcodec.AppendVector(nil, n.vec)
+	buf = binary.AppendUvarint(buf, uint64(len(n.neighbors)))
+	for _, ids := range n.neighbors {
+		buf = binary.AppendUvarint(buf, uint64(len(ids)))
+		for _, id := range ids {
+			buf = binary.AppendUvarint(buf, uint64(len(id)))
+			buf = append(buf, id...)
+		}
+	}
+	return buf
+}
+
+var errCorrupt = errors.New("hnsw: corrupt node")
+
+func decodeNode(id string, buf []byte) (*node, error) {
+	vec, buf, err := rowcodec.DecodeVector(buf)
+	if err != nil {
+		return nil, err
+	}
+	uvarint := func() (int, bool) {
+		v, k := binary.Uvarint(buf)
+		if k <= 0 || v > uint64(len(buf)) {
+			return 0, false
+		}
+		buf = buf[k:]
+		return int(v), true
+	}
+	layers, ok := uvarint()
+	if !ok || layers == 0 {
+		return nil, errCorrupt
+	}
+	n := &node{id: id, vec: vec, neighbors: make([][]string, layers)}
+	for lc := range n.neighbors {
+		count, ok := uvarint()
+		if !ok {
+			return nil, errCorrupt
+		}
+		n.neighbors[lc] = make([]string, 0, count)
+		for range count {
+			size, ok := uvarint()
+			if !ok || size > len(buf) {
+				return nil, errCorrupt
+			}
+			n.neighbors[lc] = append(n.neighbors[lc], string(buf[:size]))
+			buf = buf[size:]
+		}
+	}
+	return n, nil
+}
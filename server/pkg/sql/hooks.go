@@ -0,0 +1,135 @@
+package sql
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// HookContext describes the statement a hook runs for.
+type HookContext struct {
+	Session *Session
+	// SQL is the full query text the statement came from.
+	SQL string
+	// Stmt is the statement being executed. OnParse hooks may replace it;
+	// later hooks see the replacement.
+	Stmt parser.Statement
+	// Params are the values of the parameters of a prepared statement.
+	Params []types.Datum
+
+	// prepared is the prepared statement being executed, if any.
+	prepared *PreparedStatement
+	// query is the normalized statement, if known, its execution is
+	// counted under in pg_stat_statements.
+	query *parser.Normalized
+}
+
+// ExecFunc runs a statement and returns its result.
+type ExecFunc func() (*Result, error)
+
+// Hooks are callbacks invoked around statement execution. Embedders use
+// them for authorization, query rewriting, tenant scoping or caching. Any
+// field may be nil.
+//
+// For each statement the hooks run in this order: OnParse, then OnExecute,
+// whose next function plans the statement (calling OnPlan) and runs it,
+// and finally OnResult. OnPlan is not called for transaction control
+// statements, which are not planned.
+//
+// A prepared statement passes through OnParse when it is prepared, and
+// through the other hooks each time it is executed. Statements and plans
+// are cached for prepared statements, so hooks must not modify the ones
+// they are passed; they return a rewritten copy instead.
+type Hooks struct {
+	// OnParse is called with each parsed statement. It may return a
+	// rewritten statement, or an error to reject the statement.
+	OnParse func(hc *HookContext, stmt parser.Statement) (parser.Statement, error)
+	// OnPlan is called with the plan of each statement and may return a
+	// different plan, or an error to reject it.
+	OnPlan func(hc *HookContext, plan planner.Node) (planner.Node, error)
+	// OnExecute wraps execution like HTTP middleware: it calls next to run
+	// the statement, and may inspect or replace the result. It may also
+	// return without calling next, e.g. to serve a cached result.
+	OnExecute func(hc *HookContext, next ExecFunc) (*Result, error)
+	// OnResult is called with the outcome of each statement, including
+	// failed ones, and returns the outcome reported to the client.
+	OnResult func(hc *HookContext, res *Result, err error) (*Result, error)
+}
+
+// Use registers hooks. Hooks run in registration order; for OnExecute the
+// first registered hook is the outermost. Use must not be called while
+// sessions are executing statements.
+func (s *Server) Use(h Hooks) {
+	s.hooks = append(s.hooks, h)
+}
+
+// execHooked runs hc.Stmt through the registered hooks.
+func (s *Session) execHooked(hc *HookContext) (*Result, error) {
+	if err := s.parseHooks(hc); err != nil {
+		return s.resultHooks(hc, nil, err)
+	}
+	return s.execute(hc)
+}
+
+// parseHooks passes hc.Stmt through the registered OnParse hooks.
+func (s *Session) parseHooks(hc *HookContext) error {
+	for _, h := range s.server.hooks {
+		if h.OnParse == nil {
+			continue
+		}
+		stmt, err := h.OnParse(hc, hc.Stmt)
+		if err != nil {
+			return err
+		}
+		hc.Stmt = stmt
+	}
+	return nil
+}
+
+// hasParseHooks reports whether OnParse hooks are registered.
+func (s *Server) hasParseHooks() bool {
+	for _, h := range s.hooks {
+		if h.OnParse != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// execute runs the parsed statement hc.Stmt through the registered
+// OnExecute and OnResult hooks.
+func (s *Session) execute(hc *HookContext) (*Result, error) {
+	hooks := s.server.hooks
+	next := ExecFunc(func() (*Result, error) { return s.execStmt(hc) })
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if h := hooks[i]; h.OnExecute != nil {
+			inner := next
+			next = func() (*Result, error) { return h.OnExecute(hc, inner) }
+		}
+	}
+	res, err := next()
+	return s.resultHooks(hc, res, err)
+}
+
+func (s *Session) resultHooks(hc *HookContext, res *Result, err error) (*Result, error) {
+	for _, h := range s.server.hooks {
+		if h.OnResult != nil {
+			res, err = h.OnResult(hc, res, err)
+		}
+	}
+	return res, err
+}
+
+// planHooks passes plan through the registered OnPlan hooks.
+func (s *Session) planHooks(hc *HookContext, plan planner.Node) (planner.Node, error) {
+	for _, h := range s.server.hooks {
+		if h.OnPlan == nil {
+			continue
+		}
+		var err error
+		if plan, err = h.OnPlan(hc, plan); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,146 @@
+package sql
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// logins records the attempts to log in as roles, which pgz_role_logins
+// lists, and locks the roles too many attempts in a row failed for.
+type logins struct {
+	// mu serializes the writes of the login records, so that clients
+	// logging in as a role at once do not conflict.
+	mu sync.Mutex
+	// maxFailures and lockTime, in nanoseconds, are the lockout policy;
+	// see Server.SetLoginLockout.
+	maxFailures atomic.Int64
+	lockTime    atomic.Int64
+}
+
+// SetLoginLockout sets the lockout policy of the server: a role is locked
+// once n attempts in a row to log in as it failed, for lockTime, or until
+// a superuser unlocks it with pgz_unlock_role(name) if lockTime is 0. 0
+// attempts, the default, never lock roles. Roles locked before a change
+// stay locked as they were.
+func (s *Server) SetLoginLockout(n int, lockTime time.Duration) {
+	s.logins.maxFailures.Store(int64(max(n, 0)))
+	s.logins.lockTime.Store(int64(max(lockTime, 0)))
+}
+
+// CheckLogin returns an error if the role user is locked. Connections
+// check it before they authenticate, so that the passwords of locked
+// roles cannot be guessed meanwhile.
+func (s *Server) CheckLogin(user string) error {
+	txn, err := s.engine.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Abort()
+	role, err := catalog.LookupRole(txn, user)
+	if err != nil || role == nil {
+		return err
+	}
+	l, err := catalog.GetRoleLogins(txn, role.ID)
+	if err != nil || l == nil || !l.LockedAt(time.Now()) {
+		return err
+	}
+	return pgerror.Newf(pgerror.CodeInvalidAuthorization, "role %q is locked after too many failed login attempts", user).
+		WithHint("A superuser can unlock it with pgz_unlock_role().")
+}
+
+// RecordLogin records that a client at addr logged in as the role user,
+// or, unless ok, failed to authenticate as it, which locks the role if it
+// reaches the limit of the lockout policy. Attempts as roles that do not
+// exist are not recorded, nor any on a read-only server. Failing to
+// record is logged, and does not fail the login.
+func (s *Server) RecordLogin(user, addr string, ok bool) {
+	if s.readOnly {
+		return
+	}
+	s.logins.mu.Lock()
+	defer s.logins.mu.Unlock()
+	locked := false
+	err := engine.RunTxn(s.engine, func(txn engine.Txn) error {
+		role, err := catalog.LookupRole(txn, user)
+		if err != nil || role == nil {
+			return err
+		}
+		l, err := catalog.GetRoleLogins(txn, role.ID)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			l = &catalog.RoleLogins{Role: role.ID}
+		}
+		now := time.Now()
+		if l.Locked && !l.LockedAt(now) {
+			// The lock expired: the attempts start counting again.
+			l.Locked, l.LockedUntil, l.Failures = false, time.Time{}, 0
+		}
+		if ok {
+			l.LastLogin, l.LastLoginAddr, l.Failures = now, addr, 0
+			return catalog.WriteRoleLogins(txn, l)
+		}
+		l.LastFailure, l.LastFailureAddr = now, addr
+		l.Failures++
+		l.TotalFailures++
+		if n := s.logins.maxFailures.Load(); n > 0 && int64(l.Failures) >= n && !l.Locked {
+			l.Locked, locked = true, true
+			if d := time.Duration(s.logins.lockTime.Load()); d > 0 {
+				l.LockedUntil = now.Add(d)
+			}
+		}
+		return catalog.WriteRoleLogins(txn, l)
+	})
+	if err != nil {
+		s.logger.Warn("could not record login", "role", user, "err", err)
+		return
+	}
+	if locked {
+		s.logger.Warn("locked role after too many failed login attempts", "role", user, "addr", addr)
+	}
+}
+
+// unlockRole is pgz_unlock_role(name), which unlocks a role locked by too
+// many failed login attempts, and reports whether it was locked. Only
+// superusers may call it.
+func unlockRole(s *Server) *eval.Overload {
+	return &eval.Overload{Params: []*types.T{types.String}, ReturnType: types.Bool, Volatility: eval.Volatile,
+		Fn: func(ctx *eval.Context, args []types.Datum) (types.Datum, error) {
+			if !s.backends.get(ctx.BackendPID).Superuser() {
+				return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to unlock role").
+					WithDetail("Only superusers may unlock roles.")
+			}
+			if s.readOnly {
+				return nil, errReadOnly("pgz_unlock_role()")
+			}
+			name := string(args[0].(types.DString))
+			s.logins.mu.Lock()
+			defer s.logins.mu.Unlock()
+			var was bool
+			err := engine.RunTxn(s.engine, func(txn engine.Txn) error {
+				role, err := catalog.MustLookupRole(txn, name)
+				if err != nil {
+					return err
+				}
+				l, err := catalog.GetRoleLogins(txn, role.ID)
+				if err != nil || l == nil {
+					return err
+				}
+				was = l.LockedAt(time.Now())
+				l.Locked, l.LockedUntil, l.Failures = false, time.Time{}, 0
+				return catalog.WriteRoleLogins(txn, l)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return types.DBool(was), nil
+		}}
+}
@@ -0,0 +1,218 @@
+package sql
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// maxChannel and maxPayload bound the length of the channel names and
+// payloads of notifications, as PostgreSQL bounds them.
+const (
+	maxChannel = 64
+	maxPayload = 8000
+)
+
+// Notification is a notification sent with NOTIFY or pg_notify on a
+// channel a session listens on.
+type Notification struct {
+	// PID is the process ID of the session that sent it.
+	PID     int32
+	Channel string
+	Payload string
+}
+
+// notifier delivers notifications to the sessions listening on their
+// channels.
+type notifier struct {
+	mu        sync.Mutex
+	listeners map[string]map[*Session]struct{}
+}
+
+// listen starts or, if on is not set, stops delivering the notifications
+// of channel to s.
+func (n *notifier) listen(s *Session, channel string, on bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !on {
+		delete(n.listeners[channel], s)
+		if len(n.listeners[channel]) == 0 {
+			delete(n.listeners, channel)
+		}
+		return
+	}
+	if n.listeners == nil {
+		n.listeners = make(map[string]map[*Session]struct{})
+	}
+	if n.listeners[channel] == nil {
+		n.listeners[channel] = make(map[*Session]struct{})
+	}
+	n.listeners[channel][s] = struct{}{}
+}
+
+// send delivers notifications to the sessions connected to the database
+// with ID db that listen on their channels. As in PostgreSQL, channels are
+// per database.
+func (n *notifier) send(db catalog.ID, notifications []Notification) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, nt := range notifications {
+		for s := range n.listeners[nt.Channel] {
+			if s.database.ID == db {
+				s.deliver(nt)
+			}
+		}
+	}
+}
+
+// inbox holds the notifications delivered to a session until it takes
+// them.
+type inbox struct {
+	mu    sync.Mutex
+	queue []Notification
+	// ready receives a value when notifications are delivered.
+	ready chan struct{}
+}
+
+// listenAction is a LISTEN or, if on is not set, an UNLISTEN of channel
+// that takes effect when the transaction commits. An UNLISTEN * has no
+// channel.
+type listenAction struct {
+	channel string
+	on      bool
+}
+
+// deliver queues nt for the session to take.
+func (s *Session) deliver(nt Notification) {
+	s.inbox.mu.Lock()
+	defer s.inbox.mu.Unlock()
+	s.inbox.queue = append(s.inbox.queue, nt)
+	select {
+	case s.inbox.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Notifications returns a channel that receives a value when
+// notifications are delivered to the session, which TakeNotifications
+// returns. It is safe to call from any goroutine.
+func (s *Session) Notifications() <-chan struct{} {
+	return s.inbox.ready
+}
+
+// TakeNotifications returns the notifications delivered to the session on
+// the channels it listens on since it was last called, in the order the
+// transactions that sent them committed. It is safe to call from any
+// goroutine.
+func (s *Session) TakeNotifications() []Notification {
+	s.inbox.mu.Lock()
+	defer s.inbox.mu.Unlock()
+	q := s.inbox.queue
+	s.inbox.queue = nil
+	return q
+}
+
+// notify queues a notification on channel, which is sent when the
+// transaction commits. Notifications with the same channel and payload as
+// one the transaction already sent are dropped, as in PostgreSQL.
+func (s *Session) notify(channel, payload string) error {
+	if channel == "" {
+		return pgerror.New(pgerror.CodeInvalidParameterValue, "channel name cannot be empty")
+	}
+	if len(channel) >= maxChannel {
+		return pgerror.New(pgerror.CodeInvalidParameterValue, "channel name too long")
+	}
+	if len(payload) >= maxPayload {
+		return pgerror.New(pgerror.CodeInvalidParameterValue, "payload string too long")
+	}
+	nt := Notification{PID: s.pid, Channel: channel, Payload: payload}
+	if !slices.Contains(s.txnNotifies, nt) {
+		s.txnNotifies = append(s.txnNotifies, nt)
+	}
+	return nil
+}
+
+// notifyStmt runs LISTEN, UNLISTEN and NOTIFY, which take effect when
+// the transaction commits, or at once outside a transaction block.
+func (s *Session) notifyStmt(stmt parser.Statement) (*Result, error) {
+	var tag string
+	switch stmt := stmt.(type) {
+	case *parser.ListenStmt:
+		s.txnListens = append(s.txnListens, listenAction{channel: stmt.Channel, on: true})
+		tag = "LISTEN"
+	case *parser.UnlistenStmt:
+		s.txnListens = append(s.txnListens, listenAction{channel: stmt.Channel})
+		tag = "UNLISTEN"
+	case *parser.NotifyStmt:
+		if err := s.notify(stmt.Channel, stmt.Payload); err != nil {
+			return nil, err
+		}
+		tag = "NOTIFY"
+	}
+	if s.txn == nil {
+		s.endNotify(true)
+	}
+	return &Result{Tag: tag}, nil
+}
+
+// endNotify applies the LISTEN and UNLISTEN of the transaction, and sends
+// its notifications, as it commits, or drops them if it does not. Outside
+// a transaction block, each statement is its own transaction.
+func (s *Session) endNotify(commit bool) {
+	listens, notifies := s.txnListens, s.txnNotifies
+	s.txnListens, s.txnNotifies = nil, nil
+	if !commit {
+		return
+	}
+	for _, a := range listens {
+		if a.channel == "" {
+			s.unlistenAll()
+			continue
+		}
+		if s.listening == nil {
+			s.listening = make(map[string]struct{})
+		}
+		if a.on {
+			s.listening[a.channel] = struct{}{}
+		} else {
+			delete(s.listening, a.channel)
+		}
+		s.server.notifier.listen(s, a.channel, a.on)
+	}
+	if len(notifies) > 0 {
+		s.server.notifier.send(s.database.ID, notifies)
+	}
+}
+
+// unlistenAll stops listening on every channel, as UNLISTEN * and closing
+// the session do.
+func (s *Session) unlistenAll() {
+	for channel := range s.listening {
+		s.server.notifier.listen(s, channel, false)
+	}
+	s.listening = nil
+}
+
+// notifyFunc is pg_notify(channel, payload), which sends a notification
+// like NOTIFY does, with a channel and payload computed by the query.
+func notifyFunc(b *backends) *eval.Overload {
+	return &eval.Overload{Params: []*types.T{types.String, types.String}, ReturnType: types.Void, NullCall: true, Volatility: eval.Volatile,
+		Fn: func(ctx *eval.Context, args []types.Datum) (types.Datum, error) {
+			var channel, payload string
+			if args[0] != types.DNull {
+				channel = string(args[0].(types.DString))
+			}
+			if args[1] != types.DNull {
+				payload = string(args[1].(types.DString))
+			}
+			if err := b.get(ctx.BackendPID).notify(channel, payload); err != nil {
+				return nil, err
+			}
+			return types.DNull, nil
+		}}
+}
@@ -0,0 +1,68 @@
+package sql_test
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+// rows returns the rows of the last result of query as strings, sorted,
+// as the rows of a parallel scan come in no particular order.
+func rows(t *testing.T, s *sql.Session, query string) []string {
+	t.Helper()
+	res := exec(t, s, query)
+	var out []string
+	for _, row := range res[len(res)-1].Rows {
+		out = append(out, fmt.Sprint(row))
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestParallelScan(t *testing.T) {
+	s := connect(t, sql.NewServer(memory.New()))
+	exec(t, s, "CREATE TABLE t (id int PRIMARY KEY, v text)")
+	for i := 0; i < 100000; i += 10000 {
+		var values []string
+		for j := i; j < i+10000; j++ {
+			values = append(values, fmt.Sprintf("(%d, 'v%d')", j, j%97))
+		}
+		exec(t, s, "INSERT INTO t VALUES "+strings.Join(values, ", "))
+	}
+	exec(t, s, "ANALYZE t")
+
+	plan := strings.Join(rows(t, s, "EXPLAIN SELECT id, v FROM t WHERE id % 7 = 3"), "\n")
+	if !strings.Contains(plan, "Shards:") {
+		t.Fatalf("the scan is not split into shards:\n%s", plan)
+	}
+
+	queries := []string{
+		"SELECT id, v FROM t WHERE id % 7 = 3",
+		"SELECT count(*), sum(id), min(v), max(v) FROM t",
+		"SELECT v, count(*) FROM t GROUP BY v",
+	}
+	for _, q := range queries {
+		exec(t, s, "SET max_parallel_workers_per_gather = 0")
+		serial := rows(t, s, q)
+		exec(t, s, "SET max_parallel_workers_per_gather = 4")
+		parallel := rows(t, s, q)
+		if len(serial) == 0 {
+			t.Errorf("%s returned no rows", q)
+		}
+		if !slices.Equal(parallel, serial) {
+			t.Errorf("%s: %d rows read in parallel differ from the %d read serially", q, len(parallel), len(serial))
+		}
+	}
+
+	// A statement that writes reads its input before writing, so it can
+	// read in parallel.
+	exec(t, s, "CREATE TABLE u (id int PRIMARY KEY, v text)")
+	exec(t, s, "INSERT INTO u SELECT id, v FROM t WHERE id % 2 = 0")
+	if got := rows(t, s, "SELECT count(*) FROM u"); fmt.Sprint(got) != "[[50000]]" {
+		t.Errorf("inserted %v rows, want 50000", got)
+	}
+}
@@ -0,0 +1,1179 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is a parsed SQL statement.
+type Statement interface {
+	statementNode()
+}
+
+// Expr is a parsed, untyped scalar expression. The planner resolves names
+// and types to turn it into an eval.Expr.
+type Expr interface {
+	exprNode()
+	// String formats the expression as SQL.
+	String() string
+}
+
+// TableExpr is an item in a FROM clause.
+type TableExpr interface {
+	tableExprNode()
+}
+
+// SelectStmt is a SELECT query, or a set operation combining the rows of
+// two queries when Op is set.
+type SelectStmt struct {
+	// With is the WITH clause, or nil.
+	With     *With
+	Distinct bool
+	Targets  []*SelectTarget
+	From     []TableExpr
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	// Windows are the named windows of the WINDOW clause.
+	Windows []*WindowDef
+	OrderBy []*OrderItem
+	// Limit and Offset are nil when absent. LIMIT ALL leaves Limit nil.
+	Limit  Expr
+	Offset Expr
+
+	// Op, All, Left and Right describe a set operation. Only With,
+	// OrderBy, Limit and Offset are set alongside them.
+	Op          SetOp
+	All         bool
+	Left, Right *SelectStmt
+}
+
+// SetOp is the set operation of a SelectStmt.
+type SetOp uint8
+
+// Set operations. NoSetOp marks a plain SELECT.
+const (
+	NoSetOp SetOp = iota
+	Union
+	Intersect
+	Except
+)
+
+var setOpNames = [...]string{Union: "UNION", Intersect: "INTERSECT", Except: "EXCEPT"}
+
+func (o SetOp) String() string { return setOpNames[o] }
+
+// With is a WITH clause.
+type With struct {
+	Recursive bool
+	CTEs      []*CTE
+}
+
+// Materialize is the [NOT] MATERIALIZED option of a CTE.
+type Materialize uint8
+
+// Materialize options. By default a CTE is inlined into the query that
+// refers to it if it is referred to once.
+const (
+	MaterializeDefault Materialize = iota
+	MaterializeAlways
+	MaterializeNever
+)
+
+// CTE is a common table expression, name [(columns)] AS (query).
+type CTE struct {
+	Name        string
+	Columns     []string
+	Materialize Materialize
+	Select      *SelectStmt
+}
+
+// NullsOrder is the NULLS FIRST/LAST option of an ORDER BY item.
+type NullsOrder uint8
+
+// NULLS placements. NullsDefault puts NULLs last in ascending order and
+// first in descending order, as if NULL were larger than any value.
+const (
+	NullsDefault NullsOrder = iota
+	NullsFirst
+	NullsLast
+)
+
+// OrderItem is one ORDER BY key.
+type OrderItem struct {
+	Expr  Expr
+	Desc  bool
+	Nulls NullsOrder
+}
+
+// SelectTarget is an output column. Expr may be a *Star.
+type SelectTarget struct {
+	Expr  Expr
+	Alias string
+}
+
+// TableName names a table in FROM, optionally with an alias, or any
+// other relation, which has none.
+type TableName struct {
+	// Schema is empty when the name is not schema-qualified.
+	Schema string
+	Name   string
+	Alias  string
+	// Call is set for name() in FROM, a call of a function without
+	// arguments that returns a table.
+	Call bool
+}
+
+// String returns the name as written, schema-qualified or not, without the
+// alias.
+func (tn *TableName) String() string {
+	name := tn.Name
+	if tn.Schema != "" {
+		name = tn.Schema + "." + tn.Name
+	}
+	if tn.Call {
+		name += "()"
+	}
+	return name
+}
+
+// JoinType is the kind of a JOIN.
+type JoinType uint8
+
+// Join types. A comma in FROM is a CrossJoin.
+const (
+	InnerJoin JoinType = iota
+	LeftJoin
+	RightJoin
+	FullJoin
+	CrossJoin
+)
+
+// JoinExpr is a join of two FROM items. At most one of On, Using and
+// Natural is set, and none for a CrossJoin.
+type JoinExpr struct {
+	Type        JoinType
+	Left, Right TableExpr
+	On          Expr
+	Using       []string
+	Natural     bool
+}
+
+// InsertStmt is INSERT INTO ... VALUES, INSERT INTO ... SELECT or INSERT
+// INTO ... DEFAULT VALUES.
+type InsertStmt struct {
+	Table   *TableName
+	Columns []string
+	// Exactly one of Values, Select and DefaultValues is set. An item of
+	// Values may be a *DefaultExpr.
+	Values        [][]Expr
+	Select        *SelectStmt
+	DefaultValues bool
+	// Overriding is "system" or "user" for OVERRIDING SYSTEM VALUE or
+	// OVERRIDING USER VALUE, or empty.
+	Overriding string
+}
+
+// UpdateStmt is UPDATE ... SET ... WHERE.
+type UpdateStmt struct {
+	Table *TableName
+	Set   []*SetClause
+	Where Expr
+}
+
+// SetClause is one column = value assignment in UPDATE. Value may be a
+// *DefaultExpr.
+type SetClause struct {
+	Column string
+	Value  Expr
+}
+
+// DeleteStmt is DELETE FROM ... WHERE.
+type DeleteStmt struct {
+	Table *TableName
+	Where Expr
+}
+
+// CreateTableStmt is CREATE TABLE.
+type CreateTableStmt struct {
+	Name        *TableName
+	IfNotExists bool
+	Columns     []*ColumnDef
+	Constraints []*TableConstraint
+	// With holds the storage parameters of WITH (name = value, ...).
+	With []StorageParam
+}
+
+// ColumnDef is a column definition in CREATE TABLE.
+type ColumnDef struct {
+	Name       string
+	Type       *TypeName
+	NotNull    bool
+	PrimaryKey bool
+	Unique     bool
+	// Default is the DEFAULT expression, or nil.
+	Default Expr
+	// Generated is the expression of GENERATED ALWAYS AS (expr) STORED,
+	// or nil.
+	Generated Expr
+	// Identity is GENERATED ... AS IDENTITY, or nil.
+	Identity *IdentityDef
+	// Checks are the column's CHECK constraints.
+	Checks []*TableConstraint
+	// ForeignKeys are the column's REFERENCES constraints, as FOREIGN KEY
+	// constraints of the column alone.
+	ForeignKeys []*TableConstraint
+}
+
+// IdentityDef is GENERATED {ALWAYS | BY DEFAULT} AS IDENTITY, with the
+// options of the column's sequence.
+type IdentityDef struct {
+	Always bool
+	// Options are the sequence options in parentheses. Name and
+	// IfNotExists are unused.
+	Options CreateSequenceStmt
+}
+
+// TableConstraint is a table-level PRIMARY KEY, UNIQUE, CHECK or FOREIGN
+// KEY constraint.
+type TableConstraint struct {
+	Name       string
+	PrimaryKey bool
+	Unique     bool
+	Columns    []string
+	// Desc marks the columns of a PRIMARY KEY or UNIQUE constraint given
+	// as DESC.
+	Desc []bool
+	// Check is the condition of a CHECK constraint.
+	Check Expr
+	// References is set for a FOREIGN KEY constraint of Columns.
+	References *References
+}
+
+// References is the REFERENCES clause of a foreign key.
+type References struct {
+	Table *TableName
+	// Columns are the referenced columns, or nil for the primary key.
+	Columns []string
+	// OnDelete and OnUpdate are the referential actions, such as
+	// "SET NULL", or empty if not given.
+	OnDelete, OnUpdate string
+}
+
+// CreateIndexStmt is CREATE [UNIQUE] INDEX.
+type CreateIndexStmt struct {
+	Name        string
+	Table       *TableName
+	Unique      bool
+	IfNotExists bool
+	// Using is the access method of USING, or empty.
+	Using   string
+	Columns []string
+	// OpClasses holds the operator class named after each column, or an
+	// empty string where none is.
+	OpClasses []string
+	// Desc and Nulls hold the sort order given for each column.
+	Desc  []bool
+	Nulls []NullsOrder
+	// With holds the storage parameters of WITH (name = value, ...).
+	With []StorageParam
+}
+
+// StorageParam is a name = value storage parameter.
+type StorageParam struct {
+	Name  string
+	Value string
+}
+
+// AlterTableStmt is ALTER TABLE. A rename is the only command of its
+// statement; other commands can be combined.
+type AlterTableStmt struct {
+	Name     *TableName
+	IfExists bool
+	Cmds     []AlterTableCmd
+}
+
+// AlterTableCmd is one command of ALTER TABLE.
+type AlterTableCmd interface {
+	alterTableCmd()
+}
+
+// AddColumn is ADD [COLUMN] [IF NOT EXISTS] column_def.
+type AddColumn struct {
+	Column      *ColumnDef
+	IfNotExists bool
+}
+
+// DropColumn is DROP [COLUMN] [IF EXISTS] name.
+type DropColumn struct {
+	Name     string
+	IfExists bool
+}
+
+// RenameColumn is RENAME [COLUMN] name TO new_name.
+type RenameColumn struct {
+	Name, NewName string
+}
+
+// RenameTable is RENAME TO new_name.
+type RenameTable struct {
+	NewName string
+}
+
+// AddConstraint is ADD table_constraint.
+type AddConstraint struct {
+	Constraint *TableConstraint
+}
+
+// DropConstraint is DROP CONSTRAINT [IF EXISTS] name.
+type DropConstraint struct {
+	Name     string
+	IfExists bool
+}
+
+// SetDefault is ALTER [COLUMN] name SET DEFAULT expr, or DROP DEFAULT when
+// Default is nil.
+type SetDefault struct {
+	Column  string
+	Default Expr
+}
+
+// AddIdentity is ALTER [COLUMN] name ADD GENERATED ... AS IDENTITY.
+type AddIdentity struct {
+	Column   string
+	Identity *IdentityDef
+}
+
+// SetIdentity is ALTER [COLUMN] name SET GENERATED {ALWAYS | BY DEFAULT}.
+type SetIdentity struct {
+	Column string
+	Always bool
+}
+
+// DropIdentity is ALTER [COLUMN] name DROP IDENTITY [IF EXISTS].
+type DropIdentity struct {
+	Column   string
+	IfExists bool
+}
+
+// SetStorageParams is SET (name = value, ...), which sets storage
+// parameters of the table.
+type SetStorageParams struct {
+	Params []StorageParam
+}
+
+// ResetStorageParams is RESET (name, ...), which returns storage
+// parameters of the table to their defaults.
+type ResetStorageParams struct {
+	Names []string
+}
+
+func (*AddColumn) alterTableCmd()      {}
+func (*DropColumn) alterTableCmd()     {}
+func (*RenameColumn) alterTableCmd()   {}
+func (*RenameTable) alterTableCmd()    {}
+func (*SetDefault) alterTableCmd()     {}
+func (*AddIdentity) alterTableCmd()    {}
+func (*SetIdentity) alterTableCmd()    {}
+func (*DropIdentity) alterTableCmd()   {}
+func (*AddConstraint) alterTableCmd()  {}
+func (*DropConstraint) alterTableCmd() {}
+
+func (*SetStorageParams) alterTableCmd()   {}
+func (*ResetStorageParams) alterTableCmd() {}
+
+// DropTableStmt is DROP TABLE.
+type DropTableStmt struct {
+	Names    []*TableName
+	IfExists bool
+	// Cascade drops the foreign keys of other tables referencing the
+	// tables.
+	Cascade bool
+}
+
+// DropIndexStmt is DROP INDEX.
+type DropIndexStmt struct {
+	Names    []*TableName
+	IfExists bool
+}
+
+// TruncateStmt is TRUNCATE.
+type TruncateStmt struct {
+	Names []*TableName
+	// RestartIdentity restarts the sequences owned by columns of the
+	// tables.
+	RestartIdentity bool
+	// Cascade also truncates the tables with foreign keys referencing the
+	// tables.
+	Cascade bool
+}
+
+// CopyStmt is COPY table FROM, which loads rows into Table from the file
+// File on the server, or from the client if File is empty (FROM STDIN).
+type CopyStmt struct {
+	Table   *TableName
+	Columns []string
+	File    string
+	// Options are those of WITH (name value, ...), in order.
+	Options []CopyOption
+}
+
+// CopyOption is an option of COPY. Value is empty for an option given
+// without one, such as HEADER.
+type CopyOption struct {
+	Name  string
+	Value string
+}
+
+// CreateSequenceStmt is CREATE SEQUENCE.
+type CreateSequenceStmt struct {
+	// Name is nil for the options of an identity column.
+	Name        *TableName
+	IfNotExists bool
+	// Type is the data type of AS, or nil.
+	Type *TypeName
+	// The options are nil where they are not given; NO MINVALUE and NO
+	// MAXVALUE leave them nil too.
+	Increment, MinValue, MaxValue, Start, Cache *int64
+	Cycle                                       bool
+	// Gapless is the GAPLESS option, which is not in PostgreSQL.
+	Gapless bool
+}
+
+// DropSequenceStmt is DROP SEQUENCE.
+type DropSequenceStmt struct {
+	Names    []*TableName
+	IfExists bool
+	// Cascade drops the column defaults using the sequences.
+	Cascade bool
+}
+
+// CreateSchemaStmt is CREATE SCHEMA [IF NOT EXISTS] name [AUTHORIZATION
+// role]. CREATE SCHEMA AUTHORIZATION role names the schema after the role.
+type CreateSchemaStmt struct {
+	Name        string
+	IfNotExists bool
+	// Authorization is the role of AUTHORIZATION, which owns the schema,
+	// or empty.
+	Authorization string
+}
+
+// DropSchemaStmt is DROP SCHEMA.
+type DropSchemaStmt struct {
+	Names    []string
+	IfExists bool
+	// Cascade drops the relations in the schemas.
+	Cascade bool
+}
+
+// CreateDatabaseStmt is CREATE DATABASE name [[WITH] OWNER [=] role].
+type CreateDatabaseStmt struct {
+	Name string
+	// Owner is the role of OWNER, or empty.
+	Owner string
+}
+
+// DropDatabaseStmt is DROP DATABASE [IF EXISTS] name [[WITH] (FORCE)].
+type DropDatabaseStmt struct {
+	Name     string
+	IfExists bool
+	// Force terminates the other sessions connected to the database.
+	Force bool
+}
+
+// CreatePublicationStmt is CREATE PUBLICATION name [FOR ALL TABLES | FOR
+// TABLE table [, ...]] [WITH (publish = 'kinds')]. Without FOR, the
+// publication publishes no tables.
+type CreatePublicationStmt struct {
+	Name      string
+	AllTables bool
+	Tables    []*TableName
+	With      []StorageParam
+}
+
+// DropPublicationStmt is DROP PUBLICATION [IF EXISTS] name [, ...].
+type DropPublicationStmt struct {
+	Names    []string
+	IfExists bool
+}
+
+// RoleOptions are the options of CREATE ROLE and ALTER ROLE. They are nil
+// where they are not given.
+type RoleOptions struct {
+	Superuser, CreateDB, CreateRole, Inherit, Login *bool
+	// Password is the PASSWORD, and NoPassword is set for PASSWORD NULL.
+	Password   *string
+	NoPassword bool
+	// ConnLimit is the CONNECTION LIMIT, -1 for none.
+	ConnLimit *int64
+	// InRole are the roles of IN ROLE, which the new role becomes a member
+	// of, and Roles those of ROLE, which become members of it. ALTER ROLE
+	// has neither.
+	InRole, Roles []string
+}
+
+// CreateRoleStmt is CREATE ROLE, or CREATE USER, whose role may log in
+// unless NOLOGIN is given.
+type CreateRoleStmt struct {
+	Name    string
+	Options RoleOptions
+}
+
+// AlterRoleStmt is ALTER ROLE name [WITH] options, or ALTER ROLE name
+// RENAME TO NewName. ALTER USER is the same.
+//
+// ALTER ROLE {name | ALL} [IN DATABASE db] {SET var TO value | RESET var |
+// RESET ALL} has Set, which pins the default of a session variable for
+// the sessions of the role, or of every role if All is set, in Database,
+// or in every database if it is empty.
+type AlterRoleStmt struct {
+	Name    string
+	Options RoleOptions
+	NewName string
+
+	Set      *SetStmt
+	All      bool
+	Database string
+}
+
+// DropRoleStmt is DROP ROLE or DROP USER.
+type DropRoleStmt struct {
+	Names    []string
+	IfExists bool
+}
+
+// GrantRoleStmt is GRANT role, ... TO member, ..., which makes the members
+// members of the roles, or REVOKE role, ... FROM member, ... if Revoke is
+// set.
+type GrantRoleStmt struct {
+	Roles, Members []string
+	Revoke         bool
+}
+
+// GrantStmt is GRANT privilege, ... ON tables or schemas TO grantee, ...,
+// or REVOKE privilege, ... ON ... FROM grantee, ... if Revoke is set. No
+// Privileges means ALL PRIVILEGES. The grantee public is PUBLIC, as the
+// role name is reserved. GrantOption is WITH GRANT OPTION, or for REVOKE,
+// GRANT OPTION FOR.
+type GrantStmt struct {
+	Privileges  []string
+	Tables      []*TableName
+	Schemas     []string
+	Grantees    []string
+	GrantOption bool
+	Revoke      bool
+}
+
+// AnalyzeStmt is ANALYZE, which gathers the statistics of the named
+// tables, or of all tables if Names is empty.
+type AnalyzeStmt struct {
+	Names []*TableName
+}
+
+// VacuumStmt is VACUUM, which reclaims the space of the rows of the named
+// tables that were updated or deleted, or of those of every table if Names
+// is empty. Options are those of its parenthesized list, or the keywords
+// FULL, FREEZE, VERBOSE and ANALYZE that precede the tables without one,
+// each without a value.
+type VacuumStmt struct {
+	Options []CopyOption
+	Names   []*TableName
+}
+
+// ClusterStmt is CLUSTER, which rewrites the rows of the named table in
+// the order of its primary key or of the index Index, or of every table if
+// Table is nil.
+type ClusterStmt struct {
+	Table *TableName
+	Index string
+}
+
+// ShowStmt is SHOW name, or SHOW ALL if All is set.
+type ShowStmt struct {
+	Name string
+	All  bool
+}
+
+// ShowCreateStmt is SHOW CREATE TABLE, which returns the statements that
+// create the table, as CockroachDB and MySQL have it.
+type ShowCreateStmt struct {
+	Table *TableName
+}
+
+// SetStmt is SET [SESSION | LOCAL] name {TO | =} value, ..., which sets
+// the session's setting Name to Values, or to its default if Default is
+// set, as SET name TO DEFAULT does. Local settings last until the end of
+// the transaction. RESET name has Reset and Default set, and RESET ALL
+// All too.
+type SetStmt struct {
+	Name    string
+	Values  []string
+	Default bool
+	Local   bool
+	Reset   bool
+	All     bool
+}
+
+// ListenStmt is LISTEN channel.
+type ListenStmt struct {
+	Channel string
+}
+
+// UnlistenStmt is UNLISTEN channel, or UNLISTEN * if All is set.
+type UnlistenStmt struct {
+	Channel string
+	All     bool
+}
+
+// NotifyStmt is NOTIFY channel [, payload].
+type NotifyStmt struct {
+	Channel string
+	Payload string
+}
+
+// BeginStmt is BEGIN or START TRANSACTION.
+type BeginStmt struct{}
+
+// CommitStmt is COMMIT or END.
+type CommitStmt struct{}
+
+// RollbackStmt is ROLLBACK or ABORT.
+type RollbackStmt struct{}
+
+// ExplainStmt is EXPLAIN.
+type ExplainStmt struct {
+	Stmt Statement
+}
+
+// PrepareStmt is PREPARE name [(type, ...)] AS statement.
+type PrepareStmt struct {
+	Name string
+	// Types are the declared types of the parameters $1, $2, ...; later
+	// parameters have their types inferred.
+	Types []*TypeName
+	Stmt  Statement
+	// SQL is the text of Stmt.
+	SQL string
+}
+
+// ExecuteStmt is EXECUTE name [(expr, ...)].
+type ExecuteStmt struct {
+	Name   string
+	Params []Expr
+}
+
+// DeallocateStmt is DEALLOCATE [PREPARE] name, or DEALLOCATE ALL if All is
+// set.
+type DeallocateStmt struct {
+	Name string
+	All  bool
+}
+
+func (*SelectStmt) statementNode()            {}
+func (*InsertStmt) statementNode()            {}
+func (*UpdateStmt) statementNode()            {}
+func (*DeleteStmt) statementNode()            {}
+func (*CreateTableStmt) statementNode()       {}
+func (*CreateIndexStmt) statementNode()       {}
+func (*AlterTableStmt) statementNode()        {}
+func (*DropTableStmt) statementNode()         {}
+func (*DropIndexStmt) statementNode()         {}
+func (*TruncateStmt) statementNode()          {}
+func (*CopyStmt) statementNode()              {}
+func (*CreateSequenceStmt) statementNode()    {}
+func (*DropSequenceStmt) statementNode()      {}
+func (*CreateSchemaStmt) statementNode()      {}
+func (*DropSchemaStmt) statementNode()        {}
+func (*CreateDatabaseStmt) statementNode()    {}
+func (*DropDatabaseStmt) statementNode()      {}
+func (*CreatePublicationStmt) statementNode() {}
+func (*DropPublicationStmt) statementNode()   {}
+func (*CreateRoleStmt) statementNode()        {}
+func (*AlterRoleStmt) statementNode()         {}
+func (*DropRoleStmt) statementNode()          {}
+func (*GrantRoleStmt) statementNode()         {}
+func (*GrantStmt) statementNode()             {}
+func (*AnalyzeStmt) statementNode()           {}
+func (*ClusterStmt) statementNode()           {}
+func (*VacuumStmt) statementNode()            {}
+func (*ShowStmt) statementNode()              {}
+func (*ShowCreateStmt) statementNode()        {}
+func (*SetStmt) statementNode()               {}
+func (*ListenStmt) statementNode()            {}
+func (*UnlistenStmt) statementNode()          {}
+func (*NotifyStmt) statementNode()            {}
+func (*BeginStmt) statementNode()             {}
+func (*CommitStmt) statementNode()            {}
+func (*RollbackStmt) statementNode()          {}
+func (*ExplainStmt) statementNode()           {}
+func (*PrepareStmt) statementNode()           {}
+func (*ExecuteStmt) statementNode()           {}
+func (*DeallocateStmt) statementNode()        {}
+
+func (*TableName) tableExprNode() {}
+func (*JoinExpr) tableExprNode()  {}
+
+// TypeName is a type as written in SQL, e.g. varchar(20).
+type TypeName struct {
+	// Name is the lower-case type name with multi-word names joined by
+	// single spaces, e.g. "double precision".
+	Name string
+	// Mods are the type modifiers in parentheses.
+	Mods []int32
+}
+
+func (t *TypeName) String() string {
+	if len(t.Mods) == 0 {
+		return t.Name
+	}
+	mods := make([]string, len(t.Mods))
+	for i, m := range t.Mods {
+		mods[i] = fmt.Sprint(m)
+	}
+	return t.Name + "(" + strings.Join(mods, ",") + ")"
+}
+
+// NumberLit is a numeric literal, kept as text until its type is known.
+type NumberLit struct {
+	Text string
+}
+
+// StringLit is a string literal. Its type is unknown until context
+// resolves it.
+type StringLit struct {
+	Val string
+}
+
+// BoolLit is TRUE or FALSE.
+type BoolLit struct {
+	Val bool
+}
+
+// NullLit is NULL.
+type NullLit struct{}
+
+// DefaultExpr is DEFAULT as a value of INSERT or UPDATE, standing for the
+// column's default.
+type DefaultExpr struct{}
+
+// Param is a positional parameter $N.
+type Param struct {
+	Index int
+}
+
+// ColumnRef is a possibly qualified column name.
+type ColumnRef struct {
+	Table  string
+	Column string
+}
+
+// Star is * or table.* in a select list or count(*).
+type Star struct {
+	Table string
+}
+
+// UnaryExpr is a prefix operator application.
+type UnaryExpr struct {
+	Op string
+	X  Expr
+}
+
+// BinaryExpr is an infix operator application, including comparisons and
+// IS [NOT] DISTINCT FROM.
+type BinaryExpr struct {
+	Op   string
+	L, R Expr
+}
+
+// AndExpr is L AND R.
+type AndExpr struct {
+	L, R Expr
+}
+
+// OrExpr is L OR R.
+type OrExpr struct {
+	L, R Expr
+}
+
+// NotExpr is NOT X.
+type NotExpr struct {
+	X Expr
+}
+
+// IsKind is the right-hand side of an IS test.
+type IsKind uint8
+
+// IS test kinds.
+const (
+	IsNull IsKind = iota
+	IsTrue
+	IsFalse
+	IsUnknown
+)
+
+// IsExpr is X IS [NOT] NULL/TRUE/FALSE/UNKNOWN.
+type IsExpr struct {
+	X    Expr
+	Kind IsKind
+	Not  bool
+}
+
+// BetweenExpr is X [NOT] BETWEEN Lo AND Hi.
+type BetweenExpr struct {
+	X, Lo, Hi Expr
+	Not       bool
+}
+
+// InExpr is X [NOT] IN (List) or X [NOT] IN (Subquery).
+type InExpr struct {
+	X    Expr
+	List []Expr
+	// Subquery is set instead of List for X IN (SELECT ...).
+	Subquery *Subquery
+	Not      bool
+}
+
+// Subquery is a parenthesized SELECT used as a value.
+type Subquery struct {
+	Select *SelectStmt
+}
+
+// ExistsExpr is EXISTS (SELECT ...).
+type ExistsExpr struct {
+	Subquery *Subquery
+}
+
+// RowExpr is a row constructor, ROW(Exprs) or (Exprs) with at least two
+// expressions.
+type RowExpr struct {
+	Exprs []Expr
+}
+
+// LikeExpr is X [NOT] LIKE/ILIKE Pattern [ESCAPE Escape].
+type LikeExpr struct {
+	X, Pattern Expr
+	// Escape is nil when no ESCAPE clause was given.
+	Escape          Expr
+	Not             bool
+	CaseInsensitive bool
+}
+
+// CaseExpr is a simple (with Operand) or searched CASE expression.
+type CaseExpr struct {
+	Operand Expr
+	Whens   []*When
+	Else    Expr
+}
+
+// When is one WHEN ... THEN ... arm.
+type When struct {
+	Cond, Val Expr
+}
+
+// FuncCall is a function call. Special syntax forms such as
+// SUBSTRING(x FROM y) and CURRENT_DATE are parsed into ordinary calls.
+type FuncCall struct {
+	Name     string
+	Args     []Expr
+	Distinct bool
+	// Star is set for count(*).
+	Star bool
+	// Over is the window of a window function call, or nil.
+	Over *WindowDef
+}
+
+// WindowDef is a window: the OVER clause of a window function call or an
+// entry of a WINDOW clause.
+type WindowDef struct {
+	// Name is the name a WINDOW clause gives the window.
+	Name string
+	// Ref names a window of the WINDOW clause this one is based on, as in
+	// OVER w or OVER (w ORDER BY x).
+	Ref         string
+	PartitionBy []Expr
+	OrderBy     []*OrderItem
+	// Frame is nil when the window has no frame clause.
+	Frame *WindowFrame
+}
+
+// FrameMode is how the bounds of a window frame are counted.
+type FrameMode uint8
+
+// Frame modes.
+const (
+	FrameRange FrameMode = iota
+	FrameRows
+	FrameGroups
+)
+
+// FrameBoundKind is the kind of a window frame bound.
+type FrameBoundKind uint8
+
+// Frame bound kinds, in the order of the rows they denote.
+const (
+	UnboundedPreceding FrameBoundKind = iota
+	OffsetPreceding
+	CurrentRow
+	OffsetFollowing
+	UnboundedFollowing
+)
+
+// FrameBound is the start or end of a window frame. Offset is set for
+// OffsetPreceding and OffsetFollowing.
+type FrameBound struct {
+	Kind   FrameBoundKind
+	Offset Expr
+}
+
+// FrameExclusion is the EXCLUDE option of a window frame.
+type FrameExclusion uint8
+
+// Frame exclusions.
+const (
+	ExcludeNoOthers FrameExclusion = iota
+	ExcludeCurrentRow
+	ExcludeGroup
+	ExcludeTies
+)
+
+// WindowFrame is the frame clause of a window. Without BETWEEN, End is
+// CURRENT ROW.
+type WindowFrame struct {
+	Mode       FrameMode
+	Start, End FrameBound
+	Exclude    FrameExclusion
+}
+
+// CastExpr is CAST(X AS Type), X::Type, or a typed literal like
+// date '2024-01-01'.
+type CastExpr struct {
+	X    Expr
+	Type *TypeName
+}
+
+func (*NumberLit) exprNode()   {}
+func (*StringLit) exprNode()   {}
+func (*BoolLit) exprNode()     {}
+func (*NullLit) exprNode()     {}
+func (*DefaultExpr) exprNode() {}
+func (*Param) exprNode()       {}
+func (*ColumnRef) exprNode()   {}
+func (*Star) exprNode()        {}
+func (*UnaryExpr) exprNode()   {}
+func (*BinaryExpr) exprNode()  {}
+func (*AndExpr) exprNode()     {}
+func (*OrExpr) exprNode()      {}
+func (*NotExpr) exprNode()     {}
+func (*IsExpr) exprNode()      {}
+func (*BetweenExpr) exprNode() {}
+func (*InExpr) exprNode()      {}
+func (*LikeExpr) exprNode()    {}
+func (*CaseExpr) exprNode()    {}
+func (*FuncCall) exprNode()    {}
+func (*CastExpr) exprNode()    {}
+func (*Subquery) exprNode()    {}
+func (*ExistsExpr) exprNode()  {}
+func (*RowExpr) exprNode()     {}
+
+func (e *NumberLit) String() string   { return e.Text }
+func (e *StringLit) String() string   { return QuoteString(e.Val) }
+func (e *NullLit) String() string     { return "NULL" }
+func (e *DefaultExpr) String() string { return "DEFAULT" }
+func (e *Param) String() string       { return fmt.Sprintf("$%d", e.Index) }
+
+func (e *BoolLit) String() string {
+	if e.Val {
+		return "true"
+	}
+	return "false"
+}
+
+func (e *ColumnRef) String() string {
+	if e.Table != "" {
+		return QuoteIdent(e.Table) + "." + QuoteIdent(e.Column)
+	}
+	return QuoteIdent(e.Column)
+}
+
+func (e *Star) String() string {
+	if e.Table != "" {
+		return QuoteIdent(e.Table) + ".*"
+	}
+	return "*"
+}
+
+func (e *UnaryExpr) String() string  { return fmt.Sprintf("(%s%s)", e.Op, e.X) }
+func (e *BinaryExpr) String() string { return fmt.Sprintf("(%s %s %s)", e.L, e.Op, e.R) }
+func (e *AndExpr) String() string    { return fmt.Sprintf("(%s AND %s)", e.L, e.R) }
+func (e *OrExpr) String() string     { return fmt.Sprintf("(%s OR %s)", e.L, e.R) }
+func (e *NotExpr) String() string    { return fmt.Sprintf("(NOT %s)", e.X) }
+
+func (e *IsExpr) String() string {
+	not := ""
+	if e.Not {
+		not = "NOT "
+	}
+	kind := [...]string{IsNull: "NULL", IsTrue: "TRUE", IsFalse: "FALSE", IsUnknown: "UNKNOWN"}[e.Kind]
+	return fmt.Sprintf("(%s IS %s%s)", e.X, not, kind)
+}
+
+func (e *BetweenExpr) String() string {
+	not := ""
+	if e.Not {
+		not = "NOT "
+	}
+	return fmt.Sprintf("(%s %sBETWEEN %s AND %s)", e.X, not, e.Lo, e.Hi)
+}
+
+func (e *InExpr) String() string {
+	not := ""
+	if e.Not {
+		not = "NOT "
+	}
+	if e.Subquery != nil {
+		return fmt.Sprintf("(%s %sIN %s)", e.X, not, e.Subquery)
+	}
+	return fmt.Sprintf("(%s %sIN (%s))", e.X, not, joinExprs(e.List))
+}
+
+func (e *Subquery) String() string   { return "(SELECT ...)" }
+func (e *ExistsExpr) String() string { return "EXISTS " + e.Subquery.String() }
+func (e *RowExpr) String() string    { return "ROW(" + joinExprs(e.Exprs) + ")" }
+
+func (e *LikeExpr) String() string {
+	op := "LIKE"
+	if e.CaseInsensitive {
+		op = "ILIKE"
+	}
+	if e.Not {
+		op = "NOT " + op
+	}
+	s := fmt.Sprintf("(%s %s %s", e.X, op, e.Pattern)
+	if e.Escape != nil {
+		s += fmt.Sprintf(" ESCAPE %s", e.Escape)
+	}
+	return s + ")"
+}
+
+func (e *CaseExpr) String() string {
+	var b strings.Builder
+	b.WriteString("CASE")
+	if e.Operand != nil {
+		fmt.Fprintf(&b, " %s", e.Operand)
+	}
+	for _, w := range e.Whens {
+		fmt.Fprintf(&b, " WHEN %s THEN %s", w.Cond, w.Val)
+	}
+	if e.Else != nil {
+		fmt.Fprintf(&b, " ELSE %s", e.Else)
+	}
+	b.WriteString(" END")
+	return b.String()
+}
+
+func (e *FuncCall) String() string {
+	if e.Star {
+		return e.Name + "(*)"
+	}
+	distinct := ""
+	if e.Distinct {
+		distinct = "DISTINCT "
+	}
+	call := e.Name + "(" + distinct + joinExprs(e.Args) + ")"
+	if e.Over != nil {
+		call += " OVER " + e.Over.String()
+	}
+	return call
+}
+
+func (w *WindowDef) String() string {
+	if w.Ref != "" && len(w.PartitionBy) == 0 && len(w.OrderBy) == 0 && w.Frame == nil {
+		return w.Ref
+	}
+	var parts []string
+	if w.Ref != "" {
+		parts = append(parts, w.Ref)
+	}
+	if len(w.PartitionBy) > 0 {
+		parts = append(parts, "PARTITION BY "+joinExprs(w.PartitionBy))
+	}
+	if len(w.OrderBy) > 0 {
+		items := make([]string, len(w.OrderBy))
+		for i, o := range w.OrderBy {
+			items[i] = o.Expr.String()
+			if o.Desc {
+				items[i] += " DESC"
+			}
+		}
+		parts = append(parts, "ORDER BY "+strings.Join(items, ", "))
+	}
+	if w.Frame != nil {
+		parts = append(parts, w.Frame.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+var frameModeNames = [...]string{FrameRange: "RANGE", FrameRows: "ROWS", FrameGroups: "GROUPS"}
+
+func (m FrameMode) String() string { return frameModeNames[m] }
+
+func (f *WindowFrame) String() string {
+	s := f.Mode.String() + " BETWEEN " + f.Start.String() + " AND " + f.End.String()
+	switch f.Exclude {
+	case ExcludeCurrentRow:
+		s += " EXCLUDE CURRENT ROW"
+	case ExcludeGroup:
+		s += " EXCLUDE GROUP"
+	case ExcludeTies:
+		s += " EXCLUDE TIES"
+	}
+	return s
+}
+
+func (b FrameBound) String() string {
+	switch b.Kind {
+	case UnboundedPreceding:
+		return "UNBOUNDED PRECEDING"
+	case OffsetPreceding:
+		return b.Offset.String() + " PRECEDING"
+	case CurrentRow:
+		return "CURRENT ROW"
+	case OffsetFollowing:
+		return b.Offset.String() + " FOLLOWING"
+	}
+	return "UNBOUNDED FOLLOWING"
+}
+
+func (e *CastExpr) String() string {
+	return fmt.Sprintf("CAST(%s AS %s)", e.X, e.Type)
+}
+
+func joinExprs(exprs []Expr) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// QuoteString quotes s as a SQL string literal.
+func QuoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// QuoteIdent quotes name if it would not otherwise be read back as the same
+// identifier.
+func QuoteIdent(name string) string {
+	if name == "" {
+		return `""`
+	}
+	plain := !isDigit(name[0]) && !isReserved(name)
+	for i := 0; i < len(name) && plain; i++ {
+		c := name[i]
+		plain = c == '_' || isDigit(c) || (c >= 'a' && c <= 'z')
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
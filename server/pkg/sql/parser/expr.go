@@ -0,0 +1,841 @@
+package parser
+
+import (
+	"strconv"
+)
+
+// Operator precedence, lowest to highest, follows PostgreSQL:
+//
+//	OR
+//	AND
+//	NOT
+//	IS, ISNULL, NOTNULL
+//	< > = <= >= <>
+//	BETWEEN IN LIKE ILIKE
+//	any other operator
+//	+ -
+//	* / %
+//	^
+//	unary + -
+//	::
+
+func (p *parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseExprList() ([]Expr, error) {
+	var exprs []Expr
+	for {
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, e)
+		if !p.acceptPunct(",") {
+			return exprs, nil
+		}
+	}
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("or") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &OrExpr{L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	l, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("and") {
+		r, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l = &AndExpr{L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.acceptKeyword("not") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{X: x}, nil
+	}
+	return p.parseIs()
+}
+
+func (p *parser) parseIs() (Expr, error) {
+	x, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.acceptKeyword("isnull"):
+			x = &IsExpr{X: x, Kind: IsNull}
+		case p.acceptKeyword("notnull"):
+			x = &IsExpr{X: x, Kind: IsNull, Not: true}
+		case p.acceptKeyword("is"):
+			not := p.acceptKeyword("not")
+			switch {
+			case p.acceptKeyword("null"):
+				x = &IsExpr{X: x, Kind: IsNull, Not: not}
+			case p.acceptKeyword("true"):
+				x = &IsExpr{X: x, Kind: IsTrue, Not: not}
+			case p.acceptKeyword("false"):
+				x = &IsExpr{X: x, Kind: IsFalse, Not: not}
+			case p.acceptKeyword("unknown"):
+				x = &IsExpr{X: x, Kind: IsUnknown, Not: not}
+			case p.acceptKeywords("distinct", "from"):
+				r, err := p.parseComparison()
+				if err != nil {
+					return nil, err
+				}
+				op := "IS DISTINCT FROM"
+				if not {
+					op = "IS NOT DISTINCT FROM"
+				}
+				x = &BinaryExpr{Op: op, L: x, R: r}
+			default:
+				return nil, p.unexpected()
+			}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func isComparisonOp(op string) bool {
+	switch op {
+	case "=", "<>", "<", ">", "<=", ">=":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	l, err := p.parsePredicate()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && isComparisonOp(t.str) {
+		p.pos++
+		r, err := p.parsePredicate()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: t.str, L: l, R: r}, nil
+	}
+	return l, nil
+}
+
+// parsePredicate parses BETWEEN, IN, LIKE and ILIKE.
+func (p *parser) parsePredicate() (Expr, error) {
+	x, err := p.parseOther()
+	if err != nil {
+		return nil, err
+	}
+	not := false
+	if p.isKeyword("not") && (p.isKeywordAt(1, "between") || p.isKeywordAt(1, "in") ||
+		p.isKeywordAt(1, "like") || p.isKeywordAt(1, "ilike") || p.isKeywordAt(1, "similar")) {
+		p.pos++
+		not = true
+	}
+	switch {
+	case p.acceptKeyword("between"):
+		p.acceptKeyword("asymmetric")
+		lo, err := p.parseOther()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		hi, err := p.parseOther()
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{X: x, Lo: lo, Hi: hi, Not: not}, nil
+	case p.acceptKeyword("in"):
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		if p.isSelectStart() {
+			sub, err := p.parseSubqueryBody()
+			if err != nil {
+				return nil, err
+			}
+			return &InExpr{X: x, Subquery: sub, Not: not}, nil
+		}
+		list, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return &InExpr{X: x, List: list, Not: not}, nil
+	case p.isKeyword("like") || p.isKeyword("ilike"):
+		ci := p.advance().str == "ilike"
+		pat, err := p.parseOther()
+		if err != nil {
+			return nil, err
+		}
+		like := &LikeExpr{X: x, Pattern: pat, Not: not, CaseInsensitive: ci}
+		if p.acceptKeyword("escape") {
+			if like.Escape, err = p.parseOther(); err != nil {
+				return nil, err
+			}
+		}
+		return like, nil
+	case p.acceptKeyword("similar"):
+		// Like PostgreSQL, rewrite x SIMILAR TO pat as a regular expression
+		// match against similar_to_escape(pat).
+		if err := p.expectKeyword("to"); err != nil {
+			return nil, err
+		}
+		pat, err := p.parseOther()
+		if err != nil {
+			return nil, err
+		}
+		conv := &FuncCall{Name: "similar_to_escape", Args: []Expr{pat}}
+		if p.acceptKeyword("escape") {
+			esc, err := p.parseOther()
+			if err != nil {
+				return nil, err
+			}
+			conv.Args = append(conv.Args, esc)
+		}
+		op := "~"
+		if not {
+			op = "!~"
+		}
+		return &BinaryExpr{Op: op, L: x, R: conv}, nil
+	}
+	return x, nil
+}
+
+// parseOther parses user-defined and other non-arithmetic operators, which
+// PostgreSQL groups at a single left-associative precedence level.
+func (p *parser) parseOther() (Expr, error) {
+	l, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || isComparisonOp(t.str) || isArithmeticOp(t.str) {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		l = &BinaryExpr{Op: t.str, L: l, R: r}
+	}
+}
+
+func isArithmeticOp(op string) bool {
+	switch op {
+	case "+", "-", "*", "/", "%", "^":
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseAdditive() (Expr, error) {
+	l, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := p.advance().str
+		r, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		l = &BinaryExpr{Op: op, L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseMultiplicative() (Expr, error) {
+	l, err := p.parseExponent()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.advance().str
+		r, err := p.parseExponent()
+		if err != nil {
+			return nil, err
+		}
+		l = &BinaryExpr{Op: op, L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseExponent() (Expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("^") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &BinaryExpr{Op: "^", L: l, R: r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if t := p.peek(); t.kind == tokOp && (t.str == "-" || t.str == "+" || t.str == "~") {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// Fold the sign into numeric literals so that the most negative
+		// integer can be written directly.
+		if n, ok := x.(*NumberLit); ok && t.str == "-" && n.Text[0] != '-' {
+			return &NumberLit{Text: "-" + n.Text}, nil
+		}
+		return &UnaryExpr{Op: t.str, X: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (Expr, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptPunct("::") {
+		typ, err := p.parseTypeName()
+		if err != nil {
+			return nil, err
+		}
+		x = &CastExpr{X: x, Type: typ}
+	}
+	return x, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.pos++
+		return &NumberLit{Text: t.str}, nil
+	case tokString:
+		p.pos++
+		return &StringLit{Val: t.str}, nil
+	case tokBitString:
+		// A bit string constant is a bit(n) of its own length.
+		p.pos++
+		n := len(t.str)
+		if n > 0 && t.str[0] == 'x' {
+			n = 4 * (n - 1)
+		}
+		typ := &TypeName{Name: "varbit"}
+		if n > 0 {
+			typ = &TypeName{Name: "bit", Mods: []int32{int32(n)}}
+		}
+		return &CastExpr{X: &StringLit{Val: t.str}, Type: typ}, nil
+	case tokParam:
+		p.pos++
+		n, err := strconv.Atoi(t.str)
+		if err != nil || n < 1 {
+			return nil, syntaxErrorAt(p.src, t.pos, "invalid parameter number $"+t.str)
+		}
+		return &Param{Index: n}, nil
+	case tokPunct:
+		if p.acceptPunct("(") {
+			if p.isSelectStart() {
+				return p.parseSubqueryBody()
+			}
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if p.acceptPunct(",") {
+				rest, err := p.parseExprList()
+				if err != nil {
+					return nil, err
+				}
+				e = &RowExpr{Exprs: append([]Expr{e}, rest...)}
+			}
+			return e, p.expectPunct(")")
+		}
+		return nil, p.unexpected()
+	case tokIdent:
+		if t.quoted {
+			return p.parseNameExpr()
+		}
+		return p.parseKeywordExpr()
+	}
+	return nil, p.unexpected()
+}
+
+// parseSubqueryBody parses the SELECT of a subquery and its closing
+// parenthesis, the opening one having been consumed.
+func (p *parser) parseSubqueryBody() (*Subquery, error) {
+	sel, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	return &Subquery{Select: sel}, p.expectPunct(")")
+}
+
+// parseKeywordExpr parses expressions introduced by an unquoted word:
+// literals, CASE, CAST, special function syntax, typed literals, function
+// calls and column references.
+func (p *parser) parseKeywordExpr() (Expr, error) {
+	t := p.peek()
+	switch t.str {
+	case "null":
+		p.pos++
+		return &NullLit{}, nil
+	case "true", "false":
+		p.pos++
+		return &BoolLit{Val: t.str == "true"}, nil
+	case "case":
+		return p.parseCase()
+	case "cast":
+		p.pos++
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, err
+		}
+		typ, err := p.parseTypeName()
+		if err != nil {
+			return nil, err
+		}
+		return &CastExpr{X: x, Type: typ}, p.expectPunct(")")
+	case "current_date", "current_timestamp", "localtimestamp":
+		p.pos++
+		return &FuncCall{Name: t.str}, nil
+	}
+	if p.peekAt(1).kind == tokPunct && p.peekAt(1).str == "(" {
+		switch t.str {
+		case "substring":
+			return p.parseSubstring()
+		case "position":
+			return p.parsePosition()
+		case "trim":
+			return p.parseTrim()
+		case "xmlparse", "xmlserialize":
+			return p.parseXMLFunc()
+		case "row":
+			p.pos += 2
+			row := &RowExpr{}
+			if !p.acceptPunct(")") {
+				var err error
+				if row.Exprs, err = p.parseExprList(); err != nil {
+					return nil, err
+				}
+				if err := p.expectPunct(")"); err != nil {
+					return nil, err
+				}
+			}
+			return row, nil
+		case "exists":
+			p.pos += 2
+			sub, err := p.parseSubqueryBody()
+			if err != nil {
+				return nil, err
+			}
+			return &ExistsExpr{Subquery: sub}, nil
+		}
+	}
+	if typed, err := p.tryTypedLiteral(); typed != nil || err != nil {
+		return typed, err
+	}
+	if isReserved(t.str) && !(p.peekAt(1).kind == tokPunct && p.peekAt(1).str == "(") {
+		return nil, p.unexpected()
+	}
+	return p.parseNameExpr()
+}
+
+// tryTypedLiteral parses type 'string' literals such as date '2024-01-01'.
+// It returns nil without consuming input if the next tokens are not one.
+func (p *parser) tryTypedLiteral() (Expr, error) {
+	save := p.pos
+	switch p.peek().str {
+	case "date", "timestamp", "timestamptz", "interval", "time", "text", "varchar",
+		"bool", "boolean", "int", "int2", "int4", "int8", "integer", "bigint", "smallint",
+		"numeric", "decimal", "real", "float", "float4", "float8", "double", "bytea",
+		"char", "character":
+	default:
+		return nil, nil
+	}
+	typ, err := p.parseTypeName()
+	if err != nil || p.peek().kind != tokString {
+		p.pos = save
+		return nil, nil
+	}
+	s := p.advance().str
+	return &CastExpr{X: &StringLit{Val: s}, Type: typ}, nil
+}
+
+// parseNameExpr parses a column reference, table.*, or function call.
+func (p *parser) parseNameExpr() (Expr, error) {
+	name := p.advance().str
+	if p.acceptPunct("(") {
+		return p.parseFuncArgs(name)
+	}
+	if p.acceptPunct(".") {
+		if p.acceptOp("*") {
+			return &Star{Table: name}, nil
+		}
+		col := p.peek()
+		if col.kind != tokIdent {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		return &ColumnRef{Table: name, Column: col.str}, nil
+	}
+	return &ColumnRef{Column: name}, nil
+}
+
+// parseFuncArgs parses the arguments of a call to name after the opening
+// parenthesis.
+func (p *parser) parseFuncArgs(name string) (Expr, error) {
+	fc := &FuncCall{Name: name}
+	switch {
+	case p.acceptPunct(")"):
+	case p.acceptOp("*"):
+		fc.Star = true
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	default:
+		if p.acceptKeyword("distinct") {
+			fc.Distinct = true
+		} else {
+			p.acceptKeyword("all")
+		}
+		args, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		fc.Args = args
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("over") {
+		if !p.isPunct("(") {
+			ref, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			fc.Over = &WindowDef{Ref: ref}
+			return fc, nil
+		}
+		var err error
+		if fc.Over, err = p.parseWindowSpec(); err != nil {
+			return nil, err
+		}
+	}
+	return fc, nil
+}
+
+// parseWindowSpec parses a parenthesized window specification:
+// ([existing_window] [PARTITION BY ...] [ORDER BY ...] [frame]).
+func (p *parser) parseWindowSpec() (*WindowDef, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	w := &WindowDef{}
+	if t := p.peek(); t.kind == tokIdent && (t.quoted || !isReserved(t.str) && !isFrameKeyword(t.str)) &&
+		!(t.str == "partition" && p.peekAt(1).str == "by") {
+		p.pos++
+		w.Ref = t.str
+	}
+	var err error
+	if p.acceptKeywords("partition", "by") {
+		if w.PartitionBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeywords("order", "by") {
+		for {
+			item, err := p.parseOrderItem()
+			if err != nil {
+				return nil, err
+			}
+			w.OrderBy = append(w.OrderBy, item)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	if t := p.peek(); t.kind == tokIdent && !t.quoted && isFrameKeyword(t.str) {
+		if w.Frame, err = p.parseFrame(); err != nil {
+			return nil, err
+		}
+	}
+	return w, p.expectPunct(")")
+}
+
+func isFrameKeyword(s string) bool { return s == "rows" || s == "range" || s == "groups" }
+
+// parseFrame parses {ROWS | RANGE | GROUPS} {start | BETWEEN start AND
+// end} [EXCLUDE ...].
+func (p *parser) parseFrame() (*WindowFrame, error) {
+	f := &WindowFrame{}
+	switch p.advance().str {
+	case "rows":
+		f.Mode = FrameRows
+	case "groups":
+		f.Mode = FrameGroups
+	}
+	var err error
+	if p.acceptKeyword("between") {
+		if f.Start, err = p.parseFrameBound(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("and"); err != nil {
+			return nil, err
+		}
+		if f.End, err = p.parseFrameBound(); err != nil {
+			return nil, err
+		}
+	} else {
+		if f.Start, err = p.parseFrameBound(); err != nil {
+			return nil, err
+		}
+		f.End = FrameBound{Kind: CurrentRow}
+	}
+	if p.acceptKeyword("exclude") {
+		switch {
+		case p.acceptKeywords("current", "row"):
+			f.Exclude = ExcludeCurrentRow
+		case p.acceptKeyword("group"):
+			f.Exclude = ExcludeGroup
+		case p.acceptKeyword("ties"):
+			f.Exclude = ExcludeTies
+		case p.acceptKeywords("no", "others"):
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	return f, nil
+}
+
+// parseFrameBound parses UNBOUNDED {PRECEDING | FOLLOWING}, CURRENT ROW,
+// or offset {PRECEDING | FOLLOWING}.
+func (p *parser) parseFrameBound() (FrameBound, error) {
+	switch {
+	case p.acceptKeywords("unbounded", "preceding"):
+		return FrameBound{Kind: UnboundedPreceding}, nil
+	case p.acceptKeywords("unbounded", "following"):
+		return FrameBound{Kind: UnboundedFollowing}, nil
+	case p.acceptKeywords("current", "row"):
+		return FrameBound{Kind: CurrentRow}, nil
+	}
+	offset, err := p.parseExpr()
+	if err != nil {
+		return FrameBound{}, err
+	}
+	switch {
+	case p.acceptKeyword("preceding"):
+		return FrameBound{Kind: OffsetPreceding, Offset: offset}, nil
+	case p.acceptKeyword("following"):
+		return FrameBound{Kind: OffsetFollowing, Offset: offset}, nil
+	}
+	return FrameBound{}, p.unexpected()
+}
+
+func (p *parser) parseCase() (Expr, error) {
+	p.pos++ // CASE
+	c := &CaseExpr{}
+	var err error
+	if !p.isKeyword("when") {
+		if c.Operand, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.acceptKeyword("when") {
+		w := &When{}
+		if w.Cond, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("then"); err != nil {
+			return nil, err
+		}
+		if w.Val, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		c.Whens = append(c.Whens, w)
+	}
+	if len(c.Whens) == 0 {
+		return nil, p.unexpected()
+	}
+	if p.acceptKeyword("else") {
+		if c.Else, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return c, p.expectKeyword("end")
+}
+
+// parseSubstring parses SUBSTRING(s FROM start FOR count) and the plain
+// function-call form.
+func (p *parser) parseSubstring() (Expr, error) {
+	p.pos += 2 // SUBSTRING (
+	s, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	fc := &FuncCall{Name: "substring", Args: []Expr{s}}
+	switch {
+	case p.acceptPunct(","):
+		rest, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		fc.Args = append(fc.Args, rest...)
+	case p.acceptKeyword("from"):
+		from, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		fc.Args = append(fc.Args, from)
+		if p.acceptKeyword("for") {
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			fc.Args = append(fc.Args, n)
+		}
+	case p.acceptKeyword("for"):
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		fc.Args = append(fc.Args, &NumberLit{Text: "1"}, n)
+	}
+	return fc, p.expectPunct(")")
+}
+
+// parsePosition parses POSITION(substr IN s) into strpos(s, substr).
+func (p *parser) parsePosition() (Expr, error) {
+	p.pos += 2 // POSITION (
+	sub, err := p.parseOther()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("in"); err != nil {
+		return nil, err
+	}
+	s, err := p.parseOther()
+	if err != nil {
+		return nil, err
+	}
+	return &FuncCall{Name: "strpos", Args: []Expr{s, sub}}, p.expectPunct(")")
+}
+
+// parseXMLFunc parses XMLPARSE(DOCUMENT|CONTENT x) into xmlparse(x, doc)
+// and XMLSERIALIZE(DOCUMENT|CONTENT x AS type) into a cast of
+// xmlserialize(x, doc), where doc is whether DOCUMENT was given.
+func (p *parser) parseXMLFunc() (Expr, error) {
+	name := p.advance().str
+	p.pos++ // (
+	var doc bool
+	switch {
+	case p.acceptKeyword("document"):
+		doc = true
+	case p.acceptKeyword("content"):
+	default:
+		return nil, p.unexpected()
+	}
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	fc := &FuncCall{Name: name, Args: []Expr{x, &BoolLit{Val: doc}}}
+	if name == "xmlparse" {
+		// Whitespace is always preserved.
+		if (p.acceptKeyword("preserve") || p.acceptKeyword("strip")) && !p.acceptKeyword("whitespace") {
+			return nil, p.unexpected()
+		}
+		return fc, p.expectPunct(")")
+	}
+	if err := p.expectKeyword("as"); err != nil {
+		return nil, err
+	}
+	typ, err := p.parseTypeName()
+	if err != nil {
+		return nil, err
+	}
+	return &CastExpr{X: fc, Type: typ}, p.expectPunct(")")
+}
+
+// parseTrim parses TRIM([LEADING|TRAILING|BOTH] [chars] FROM s) into
+// ltrim, rtrim or btrim.
+func (p *parser) parseTrim() (Expr, error) {
+	p.pos += 2 // TRIM (
+	name := "btrim"
+	switch {
+	case p.acceptKeyword("leading"):
+		name = "ltrim"
+	case p.acceptKeyword("trailing"):
+		name = "rtrim"
+	default:
+		p.acceptKeyword("both")
+	}
+	var args []Expr
+	if p.acceptKeyword("from") {
+		s, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		args = s
+	} else {
+		first, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case p.acceptKeyword("from"):
+			s, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = []Expr{s, first}
+		case p.acceptPunct(","):
+			chars, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = []Expr{first, chars}
+		default:
+			args = []Expr{first}
+		}
+	}
+	return &FuncCall{Name: name, Args: args}, p.expectPunct(")")
+}
@@ -0,0 +1,434 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+type tokenKind uint8
+
+const (
+	tokEOF tokenKind = iota
+	// tokIdent is an identifier or keyword. Unquoted identifiers are folded
+	// to lower case.
+	tokIdent
+	tokString
+	// tokBitString is a B'...' or X'...' bit string constant. Its str is
+	// the binary digits, or the hex digits following an x.
+	tokBitString
+	tokNumber
+	// tokParam is a positional parameter such as $1.
+	tokParam
+	// tokOp is an operator such as + or <=.
+	tokOp
+	// tokPunct is one of ( ) , ; . [ ] : or ::.
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	str  string
+	pos  int
+	// quoted is set for "quoted" identifiers, which are never keywords.
+	quoted bool
+}
+
+// opChars are the characters PostgreSQL allows in operator names.
+const opChars = "+-*/<>=~!@#%^&|`?"
+
+// lex splits sql into tokens, ending with a tokEOF token.
+func lex(sql string) ([]token, error) {
+	l := &lexer{src: sql}
+	var toks []token
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		toks = append(toks, t)
+		if t.kind == tokEOF {
+			return toks, nil
+		}
+	}
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) peekByte(off int) byte {
+	if l.pos+off < len(l.src) {
+		return l.src[l.pos+off]
+	}
+	return 0
+}
+
+func (l *lexer) skipSpaceAndComments() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			l.pos++
+		case c == '-' && l.peekByte(1) == '-':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case c == '/' && l.peekByte(1) == '*':
+			start := l.pos
+			depth := 0
+			for {
+				if l.pos >= len(l.src) {
+					return syntaxErrorAt(l.src, start, "unterminated /* comment")
+				}
+				if strings.HasPrefix(l.src[l.pos:], "/*") {
+					depth++
+					l.pos += 2
+				} else if strings.HasPrefix(l.src[l.pos:], "*/") {
+					depth--
+					l.pos += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					l.pos++
+				}
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) next() (token, error) {
+	if err := l.skipSpaceAndComments(); err != nil {
+		return token{}, err
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '\'':
+		s, err := l.quotedString(false)
+		return token{kind: tokString, str: s, pos: start}, err
+	case (c == 'e' || c == 'E') && l.peekByte(1) == '\'':
+		l.pos++
+		s, err := l.quotedString(true)
+		if err == nil {
+			// Escapes can spell bytes that are not valid text.
+			if err = types.CheckText(s); err != nil {
+				err = pgerror.Flatten(err).WithPosition(utf8.RuneCountInString(l.src[:start]) + 1)
+			}
+		}
+		return token{kind: tokString, str: s, pos: start}, err
+	case (c == 'b' || c == 'B' || c == 'x' || c == 'X') && l.peekByte(1) == '\'':
+		l.pos++
+		s, err := l.quotedString(false)
+		if c == 'x' || c == 'X' {
+			s = "x" + s
+		}
+		return token{kind: tokBitString, str: s, pos: start}, err
+	case (c == 'n' || c == 'N') && l.peekByte(1) == '\'':
+		l.pos++
+		s, err := l.quotedString(false)
+		return token{kind: tokString, str: s, pos: start}, err
+	case c == '"':
+		s, err := l.quotedIdent()
+		return token{kind: tokIdent, str: s, pos: start, quoted: true}, err
+	case c == '$' && l.peekByte(1) >= '0' && l.peekByte(1) <= '9':
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokParam, str: l.src[start+1 : l.pos], pos: start}, nil
+	case c == '$':
+		s, ok, err := l.dollarString()
+		if err != nil {
+			return token{}, err
+		}
+		if ok {
+			return token{kind: tokString, str: s, pos: start}, nil
+		}
+		return token{}, syntaxErrorAt(l.src, start, `syntax error at or near "$"`)
+	case isDigit(c) || (c == '.' && isDigit(l.peekByte(1))):
+		return l.number(), nil
+	case isIdentStart(c):
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokIdent, str: strings.ToLower(l.src[start:l.pos]), pos: start}, nil
+	case c == ':' && l.peekByte(1) == ':':
+		l.pos += 2
+		return token{kind: tokPunct, str: "::", pos: start}, nil
+	case strings.IndexByte("(),;.[]:", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, str: string(c), pos: start}, nil
+	case strings.IndexByte(opChars, c) >= 0:
+		return l.operator(), nil
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, syntaxErrorAt(l.src, start, "syntax error at or near \""+string(r)+"\"")
+}
+
+// operator scans an operator using PostgreSQL's rules: the longest run of
+// operator characters, stopping before a comment start, and dropping a
+// trailing + or - unless the operator contains one of ~!@#%^&|`?.
+func (l *lexer) operator() token {
+	start := l.pos
+	for l.pos < len(l.src) && strings.IndexByte(opChars, l.src[l.pos]) >= 0 {
+		if l.pos > start && (strings.HasPrefix(l.src[l.pos:], "--") || strings.HasPrefix(l.src[l.pos:], "/*")) {
+			break
+		}
+		l.pos++
+	}
+	op := l.src[start:l.pos]
+	if len(op) > 1 && !strings.ContainsAny(op, "~!@#%^&|`?") {
+		for len(op) > 1 && (op[len(op)-1] == '+' || op[len(op)-1] == '-') {
+			op = op[:len(op)-1]
+		}
+		l.pos = start + len(op)
+	}
+	if op == "!=" {
+		op = "<>"
+	}
+	return token{kind: tokOp, str: op, pos: start}
+}
+
+func (l *lexer) number() token {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	if l.peekByte(0) == '.' && l.peekByte(1) != '.' {
+		l.pos++
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	if c := l.peekByte(0); c == 'e' || c == 'E' {
+		off := 1
+		if s := l.peekByte(1); s == '+' || s == '-' {
+			off = 2
+		}
+		if isDigit(l.peekByte(off)) {
+			l.pos += off
+			for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+				l.pos++
+			}
+		}
+	}
+	return token{kind: tokNumber, str: l.src[start:l.pos], pos: start}
+}
+
+// quotedString scans a '...' literal starting at the opening quote. With
+// escapes set it interprets backslash escapes as in E'...' strings.
+// Literals separated only by whitespace containing a newline are joined, as
+// the SQL standard requires.
+func (l *lexer) quotedString(escapes bool) (string, error) {
+	start := l.pos
+	var b strings.Builder
+	for {
+		l.pos++ // opening quote
+		for {
+			if l.pos >= len(l.src) {
+				return "", syntaxErrorAt(l.src, start, "unterminated quoted string")
+			}
+			c := l.src[l.pos]
+			if c == '\'' {
+				if l.peekByte(1) == '\'' {
+					b.WriteByte('\'')
+					l.pos += 2
+					continue
+				}
+				l.pos++
+				break
+			}
+			if c == '\\' && escapes {
+				if err := l.escape(&b); err != nil {
+					return "", err
+				}
+				continue
+			}
+			b.WriteByte(c)
+			l.pos++
+		}
+		// Look for a continuation literal.
+		save := l.pos
+		sawNewline := false
+		for l.pos < len(l.src) && strings.IndexByte(" \t\r\n\f", l.src[l.pos]) >= 0 {
+			if l.src[l.pos] == '\n' {
+				sawNewline = true
+			}
+			l.pos++
+		}
+		if !sawNewline || l.peekByte(0) != '\'' {
+			l.pos = save
+			return b.String(), nil
+		}
+	}
+}
+
+func (l *lexer) escape(b *strings.Builder) error {
+	l.pos++ // backslash
+	if l.pos >= len(l.src) {
+		return syntaxErrorAt(l.src, l.pos, "unterminated quoted string")
+	}
+	c := l.src[l.pos]
+	l.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 'f':
+		b.WriteByte('\f')
+	case 'n':
+		b.WriteByte('\n')
+	case 'r':
+		b.WriteByte('\r')
+	case 't':
+		b.WriteByte('\t')
+	case 'x':
+		n := 0
+		for n < 2 && isHex(l.peekByte(n)) {
+			n++
+		}
+		if n == 0 {
+			b.WriteByte('x')
+			return nil
+		}
+		v, _ := strconv.ParseUint(l.src[l.pos:l.pos+n], 16, 8)
+		b.WriteByte(byte(v))
+		l.pos += n
+	case 'u', 'U':
+		r, err := l.unicodeEscape(c)
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) {
+			// A high surrogate must be followed by the escape of a low one,
+			// which together encode one character.
+			low := utf8.RuneError
+			if next := l.peekByte(1); r < 0xdc00 && l.peekByte(0) == '\\' && (next == 'u' || next == 'U') {
+				l.pos += 2
+				if low, err = l.unicodeEscape(next); err != nil {
+					return err
+				}
+			}
+			if r = utf16.DecodeRune(r, low); r == utf8.RuneError {
+				return pgerror.New(pgerror.CodeInvalidEscapeSequence, "invalid Unicode surrogate pair")
+			}
+		}
+		b.WriteRune(r)
+	default:
+		if c >= '0' && c <= '7' {
+			v := int(c - '0')
+			for n := 0; n < 2 && l.peekByte(0) >= '0' && l.peekByte(0) <= '7'; n++ {
+				v = v*8 + int(l.src[l.pos]-'0')
+				l.pos++
+			}
+			b.WriteByte(byte(v))
+			return nil
+		}
+		b.WriteByte(c)
+	}
+	return nil
+}
+
+// unicodeEscape scans the hex digits of a \u or, if c is 'U', \U escape,
+// and returns the code point they name, which may be a surrogate. NUL is
+// not a valid character of text.
+func (l *lexer) unicodeEscape(c byte) (rune, error) {
+	n := 4
+	if c == 'U' {
+		n = 8
+	}
+	if l.pos+n > len(l.src) {
+		return 0, pgerror.New(pgerror.CodeInvalidEscapeSequence, "invalid Unicode escape")
+	}
+	v, err := strconv.ParseUint(l.src[l.pos:l.pos+n], 16, 32)
+	if err != nil || v == 0 || v > unicode.MaxRune {
+		return 0, pgerror.New(pgerror.CodeInvalidEscapeSequence, "invalid Unicode escape value")
+	}
+	l.pos += n
+	return rune(v), nil
+}
+
+func (l *lexer) quotedIdent() (string, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return "", syntaxErrorAt(l.src, start, "unterminated quoted identifier")
+		}
+		c := l.src[l.pos]
+		if c == '"' {
+			if l.peekByte(1) == '"' {
+				b.WriteByte('"')
+				l.pos += 2
+				continue
+			}
+			l.pos++
+			break
+		}
+		b.WriteByte(c)
+		l.pos++
+	}
+	if b.Len() == 0 {
+		return "", syntaxErrorAt(l.src, start, "zero-length delimited identifier")
+	}
+	return b.String(), nil
+}
+
+// dollarString scans a $tag$...$tag$ literal. ok is false if the input at
+// the current position is not a dollar-quote opener.
+func (l *lexer) dollarString() (s string, ok bool, err error) {
+	start := l.pos
+	end := l.pos + 1
+	for end < len(l.src) && l.src[end] != '$' {
+		if !isIdentChar(l.src[end]) || isDigit(l.src[end]) && end == l.pos+1 {
+			return "", false, nil
+		}
+		end++
+	}
+	if end >= len(l.src) {
+		return "", false, nil
+	}
+	tag := l.src[start : end+1]
+	body := end + 1
+	i := strings.Index(l.src[body:], tag)
+	if i < 0 {
+		return "", false, syntaxErrorAt(l.src, start, "unterminated dollar-quoted string")
+	}
+	l.pos = body + i + len(tag)
+	return l.src[body : body+i], true, nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '$'
+}
+
+// syntaxErrorAt returns a syntax error positioned at byte offset pos of
+// src.
+func syntaxErrorAt(src string, pos int, msg string) error {
+	return pgerror.New(pgerror.CodeSyntaxError, msg).
+		WithPosition(utf8.RuneCountInString(src[:min(pos, len(src))]) + 1)
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Normalized is a statement with its constants taken out, under which
+// executions of the statement with different constants are grouped.
+type Normalized struct {
+	// SQL is the text of the statement with each constant replaced by a
+	// parameter, numbered after the parameters the statement has.
+	SQL string
+	// Fingerprint is the same for statements that differ only in their
+	// constants, whitespace, comments and the case of unquoted names.
+	Fingerprint string
+}
+
+// Normalize splits sql into statements as Parse does and returns their
+// normalized forms. The text is only lexed, so Normalize accepts some
+// text that Parse rejects.
+func Normalize(sql string) ([]Normalized, error) {
+	l := &lexer{src: sql}
+	var out []Normalized
+	var toks []token
+	var ends []int
+	for {
+		t, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != tokEOF && !(t.kind == tokPunct && t.str == ";") {
+			toks, ends = append(toks, t), append(ends, l.pos)
+			continue
+		}
+		if len(toks) > 0 {
+			out = append(out, normalize(sql, toks, ends))
+			toks, ends = toks[:0], ends[:0]
+		}
+		if t.kind == tokEOF {
+			return out, nil
+		}
+	}
+}
+
+// normalize normalizes the statement of the tokens toks of src, which end
+// at the offsets ends.
+func normalize(src string, toks []token, ends []int) Normalized {
+	params := 0
+	for _, t := range toks {
+		if n, err := strconv.Atoi(t.str); t.kind == tokParam && err == nil {
+			params = max(params, n)
+		}
+	}
+	var text, fp strings.Builder
+	last := toks[0].pos
+	for i, t := range toks {
+		if i > 0 {
+			fp.WriteByte(' ')
+		}
+		switch t.kind {
+		case tokString, tokBitString, tokNumber:
+			params++
+			text.WriteString(src[last:t.pos])
+			text.WriteString("$" + strconv.Itoa(params))
+			last = ends[i]
+			fp.WriteByte('?')
+		case tokIdent:
+			if t.quoted {
+				fp.WriteString(QuoteIdent(t.str))
+			} else {
+				fp.WriteString(t.str)
+			}
+		case tokParam:
+			fp.WriteString("$" + t.str)
+		default:
+			fp.WriteString(t.str)
+		}
+	}
+	text.WriteString(src[last:ends[len(ends)-1]])
+	return Normalized{SQL: text.String(), Fingerprint: fp.String()}
+}
@@ -0,0 +1,2161 @@
+// Package parser turns SQL text into an abstract syntax tree.
+//
+// The grammar is a hand-written recursive descent parser covering the
+// subset of PostgreSQL that pgz supports. Names are folded to lower case
+// unless quoted, exactly as PostgreSQL does, so later stages compare names
+// with ==.
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// Parse parses one or more semicolon-separated statements. Empty
+// statements are skipped.
+func Parse(sql string) ([]Statement, error) {
+	p, err := newParser(sql)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []Statement
+	for {
+		for p.acceptPunct(";") {
+		}
+		if p.peek().kind == tokEOF {
+			return stmts, nil
+		}
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+		if p.peek().kind != tokEOF && !p.isPunct(";") {
+			return nil, p.unexpected()
+		}
+	}
+}
+
+// ParseOne parses exactly one statement.
+func ParseOne(sql string) (Statement, error) {
+	stmts, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmts) != 1 {
+		return nil, pgerror.Newf(pgerror.CodeSyntaxError, "expected 1 statement, found %d", len(stmts))
+	}
+	return stmts[0], nil
+}
+
+// ParseExpr parses a standalone scalar expression.
+func ParseExpr(sql string) (Expr, error) {
+	p, err := newParser(sql)
+	if err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.unexpected()
+	}
+	return e, nil
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func newParser(sql string) (*parser, error) {
+	toks, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{src: sql, toks: toks}, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n < len(p.toks) {
+		return p.toks[p.pos+n]
+	}
+	return p.toks[len(p.toks)-1]
+}
+
+func (p *parser) advance() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isKeyword reports whether the next token is the unquoted keyword kw.
+func (p *parser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokIdent && !t.quoted && t.str == kw
+}
+
+func (p *parser) isKeywordAt(n int, kw string) bool {
+	t := p.peekAt(n)
+	return t.kind == tokIdent && !t.quoted && t.str == kw
+}
+
+func (p *parser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptKeywords consumes the keyword sequence kws if all of it is next.
+func (p *parser) acceptKeywords(kws ...string) bool {
+	for i, kw := range kws {
+		if !p.isKeywordAt(i, kw) {
+			return false
+		}
+	}
+	p.pos += len(kws)
+	return true
+}
+
+func (p *parser) expectKeyword(kw string) error {
+	if !p.acceptKeyword(kw) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) isPunct(s string) bool {
+	t := p.peek()
+	return t.kind == tokPunct && t.str == s
+}
+
+func (p *parser) acceptPunct(s string) bool {
+	if p.isPunct(s) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectPunct(s string) error {
+	if !p.acceptPunct(s) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.str == op
+}
+
+func (p *parser) acceptOp(op string) bool {
+	if p.isOp(op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// unexpected returns a syntax error for the next token.
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokEOF {
+		return syntaxErrorAt(p.src, t.pos, "syntax error at end of input")
+	}
+	text := t.str
+	switch t.kind {
+	case tokString:
+		text = "'" + t.str
+	case tokParam:
+		text = "$" + t.str
+	}
+	return syntaxErrorAt(p.src, t.pos, "syntax error at or near \""+text+"\"")
+}
+
+// parseName parses an identifier that is not a reserved keyword.
+func (p *parser) parseName() (string, error) {
+	t := p.peek()
+	if t.kind != tokIdent || (!t.quoted && isReserved(t.str)) {
+		return "", p.unexpected()
+	}
+	p.pos++
+	return t.str, nil
+}
+
+func (p *parser) parseNameList() ([]string, error) {
+	var names []string
+	for {
+		n, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, n)
+		if !p.acceptPunct(",") {
+			return names, nil
+		}
+	}
+}
+
+// parseQualifiedName parses a relation name, which may be
+// schema-qualified.
+func (p *parser) parseQualifiedName() (*TableName, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	tn := &TableName{Name: name}
+	if p.acceptPunct(".") {
+		tn.Schema = name
+		if tn.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	return tn, nil
+}
+
+func (p *parser) parseQualifiedNameList() ([]*TableName, error) {
+	var names []*TableName
+	for {
+		tn, err := p.parseQualifiedName()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, tn)
+		if !p.acceptPunct(",") {
+			return names, nil
+		}
+	}
+}
+
+// parseParenNameList parses (name, ...).
+func (p *parser) parseParenNameList() ([]string, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	names, err := p.parseNameList()
+	if err != nil {
+		return nil, err
+	}
+	return names, p.expectPunct(")")
+}
+
+func (p *parser) parseStatement() (Statement, error) {
+	switch {
+	case p.isSelectStart(), p.isPunct("("):
+		return p.parseSelect()
+	case p.isKeyword("insert"):
+		return p.parseInsert()
+	case p.isKeyword("update"):
+		return p.parseUpdate()
+	case p.isKeyword("delete"):
+		return p.parseDelete()
+	case p.isKeyword("create"):
+		return p.parseCreate()
+	case p.isKeyword("drop"):
+		return p.parseDrop()
+	case p.acceptKeywords("alter", "table"):
+		return p.parseAlterTable()
+	case p.acceptKeywords("alter", "role"), p.acceptKeywords("alter", "user"):
+		return p.parseAlterRole()
+	case p.acceptKeyword("grant"):
+		return p.parseGrant(false)
+	case p.acceptKeyword("revoke"):
+		return p.parseGrant(true)
+	case p.acceptKeyword("truncate"):
+		return p.parseTruncate()
+	case p.acceptKeyword("copy"):
+		return p.parseCopy()
+	case p.acceptKeyword("analyze"), p.acceptKeyword("analyse"):
+		p.acceptKeyword("verbose")
+		s := &AnalyzeStmt{}
+		if p.peek().kind == tokIdent {
+			var err error
+			if s.Names, err = p.parseQualifiedNameList(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case p.acceptKeyword("cluster"):
+		p.acceptKeyword("verbose")
+		s := &ClusterStmt{}
+		if p.peek().kind == tokIdent {
+			var err error
+			if s.Table, err = p.parseQualifiedName(); err != nil {
+				return nil, err
+			}
+			if p.acceptKeyword("using") {
+				if s.Index, err = p.parseName(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return s, nil
+	case p.acceptKeyword("vacuum"):
+		return p.parseVacuum()
+	case p.acceptKeyword("set"):
+		return p.parseSet()
+	case p.acceptKeyword("reset"):
+		if p.acceptKeyword("all") {
+			return &SetStmt{Default: true, Reset: true, All: true}, nil
+		}
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return &SetStmt{Name: name, Default: true, Reset: true}, nil
+	case p.acceptKeyword("show"):
+		if p.acceptKeyword("all") {
+			return &ShowStmt{All: true}, nil
+		}
+		if p.acceptKeyword("create") {
+			if err := p.expectKeyword("table"); err != nil {
+				return nil, err
+			}
+			tn, err := p.parseQualifiedName()
+			if err != nil {
+				return nil, err
+			}
+			return &ShowCreateStmt{Table: tn}, nil
+		}
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return &ShowStmt{Name: name}, nil
+	case p.acceptKeyword("listen"):
+		channel, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return &ListenStmt{Channel: channel}, nil
+	case p.acceptKeyword("unlisten"):
+		if p.acceptOp("*") {
+			return &UnlistenStmt{All: true}, nil
+		}
+		channel, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return &UnlistenStmt{Channel: channel}, nil
+	case p.acceptKeyword("notify"):
+		channel, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		s := &NotifyStmt{Channel: channel}
+		if p.acceptPunct(",") {
+			t := p.peek()
+			if t.kind != tokString {
+				return nil, p.unexpected()
+			}
+			p.pos++
+			s.Payload = t.str
+		}
+		return s, nil
+	case p.acceptKeyword("begin"):
+		p.acceptKeyword("transaction")
+		p.acceptKeyword("work")
+		return &BeginStmt{}, nil
+	case p.acceptKeywords("start", "transaction"):
+		return &BeginStmt{}, nil
+	case p.acceptKeyword("commit"), p.acceptKeyword("end"):
+		p.acceptKeyword("transaction")
+		p.acceptKeyword("work")
+		return &CommitStmt{}, nil
+	case p.acceptKeyword("rollback"), p.acceptKeyword("abort"):
+		p.acceptKeyword("transaction")
+		p.acceptKeyword("work")
+		return &RollbackStmt{}, nil
+	case p.acceptKeyword("explain"):
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		return &ExplainStmt{Stmt: stmt}, nil
+	case p.acceptKeyword("prepare"):
+		return p.parsePrepare()
+	case p.acceptKeyword("execute"):
+		return p.parseExecute()
+	case p.acceptKeyword("deallocate"):
+		p.acceptKeyword("prepare")
+		if p.acceptKeyword("all") {
+			return &DeallocateStmt{All: true}, nil
+		}
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		return &DeallocateStmt{Name: name}, nil
+	}
+	return nil, p.unexpected()
+}
+
+// parseSet parses SET after its keyword. SET NAMES sets client_encoding.
+func (p *parser) parseSet() (*SetStmt, error) {
+	s := &SetStmt{Local: p.acceptKeyword("local")}
+	if !s.Local {
+		p.acceptKeyword("session")
+	}
+	if p.acceptKeyword("names") {
+		s.Name = "client_encoding"
+	} else {
+		var err error
+		if s.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("to") && !p.acceptOp("=") {
+			return nil, p.unexpected()
+		}
+	}
+	if p.acceptKeyword("default") {
+		s.Default = true
+		return s, nil
+	}
+	for {
+		sign := ""
+		if p.acceptOp("-") {
+			sign = "-"
+		}
+		t := p.peek()
+		switch {
+		case t.kind == tokNumber, sign == "" && (t.kind == tokString || t.kind == tokIdent):
+		default:
+			return nil, p.unexpected()
+		}
+		p.pos++
+		s.Values = append(s.Values, sign+t.str)
+		if !p.acceptPunct(",") {
+			return s, nil
+		}
+	}
+}
+
+func (p *parser) parsePrepare() (*PrepareStmt, error) {
+	s := &PrepareStmt{}
+	var err error
+	if s.Name, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if p.acceptPunct("(") {
+		for {
+			t, err := p.parseTypeName()
+			if err != nil {
+				return nil, err
+			}
+			s.Types = append(s.Types, t)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("as"); err != nil {
+		return nil, err
+	}
+	// As in PostgreSQL, only queries and DML can be prepared.
+	if !p.isSelectStart() && !p.isPunct("(") && !p.isKeyword("insert") && !p.isKeyword("update") && !p.isKeyword("delete") {
+		return nil, p.unexpected()
+	}
+	start := p.peek().pos
+	if s.Stmt, err = p.parseStatement(); err != nil {
+		return nil, err
+	}
+	s.SQL = strings.TrimSpace(p.src[start:p.peek().pos])
+	return s, nil
+}
+
+func (p *parser) parseExecute() (*ExecuteStmt, error) {
+	s := &ExecuteStmt{}
+	var err error
+	if s.Name, err = p.parseName(); err != nil {
+		return nil, err
+	}
+	if p.acceptPunct("(") {
+		if s.Params, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// isSelectStart reports whether a query, which may start with a WITH
+// clause, is next.
+func (p *parser) isSelectStart() bool {
+	return p.isKeyword("select") || p.isKeyword("with")
+}
+
+// parseSelect parses a query: an optional WITH clause, SELECTs combined by
+// set operations, then ORDER BY and LIMIT applying to the whole.
+// INTERSECT binds more tightly than UNION and EXCEPT, which associate to
+// the left.
+func (p *parser) parseSelect() (*SelectStmt, error) {
+	var with *With
+	if p.isKeyword("with") {
+		var err error
+		if with, err = p.parseWith(); err != nil {
+			return nil, err
+		}
+	}
+	s, err := p.parseIntersect()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("union") || p.isKeyword("except") {
+		op := Union
+		if p.acceptKeyword("except") {
+			op = Except
+		} else {
+			p.pos++
+		}
+		if s, err = p.parseSetOp(op, s, p.parseIntersect); err != nil {
+			return nil, err
+		}
+	}
+	if with != nil {
+		// A parenthesized query can have its own WITH clause.
+		if s.With != nil {
+			return nil, syntaxErrorAt(p.src, p.peek().pos, "multiple WITH clauses not allowed")
+		}
+		s.With = with
+	}
+	if start := p.peek().pos; p.acceptKeywords("order", "by") {
+		if len(s.OrderBy) > 0 {
+			return nil, syntaxErrorAt(p.src, start, "multiple ORDER BY clauses not allowed")
+		}
+		for {
+			item, err := p.parseOrderItem()
+			if err != nil {
+				return nil, err
+			}
+			s.OrderBy = append(s.OrderBy, item)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	if err := p.parseLimitOffset(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseIntersect parses SELECTs combined by INTERSECT.
+func (p *parser) parseIntersect() (*SelectStmt, error) {
+	s, err := p.parseSelectClause()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("intersect") {
+		if s, err = p.parseSetOp(Intersect, s, p.parseSelectClause); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseSetOp parses the rest of left op [ALL | DISTINCT] right, parsing
+// the right operand with parseRight.
+func (p *parser) parseSetOp(op SetOp, left *SelectStmt, parseRight func() (*SelectStmt, error)) (*SelectStmt, error) {
+	s := &SelectStmt{Op: op, All: p.acceptKeyword("all"), Left: left}
+	if !s.All {
+		p.acceptKeyword("distinct")
+	}
+	var err error
+	if s.Right, err = parseRight(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseWith parses WITH [RECURSIVE] name [(columns)] AS [[NOT]
+// MATERIALIZED] (query), ...
+func (p *parser) parseWith() (*With, error) {
+	if err := p.expectKeyword("with"); err != nil {
+		return nil, err
+	}
+	w := &With{Recursive: p.acceptKeyword("recursive")}
+	for {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		cte := &CTE{Name: name}
+		if p.isPunct("(") {
+			if cte.Columns, err = p.parseParenNameList(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.acceptKeyword("materialized"):
+			cte.Materialize = MaterializeAlways
+		case p.acceptKeywords("not", "materialized"):
+			cte.Materialize = MaterializeNever
+		}
+		if err := p.expectPunct("("); err != nil {
+			return nil, err
+		}
+		if cte.Select, err = p.parseSelect(); err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		w.CTEs = append(w.CTEs, cte)
+		if !p.acceptPunct(",") {
+			return w, nil
+		}
+	}
+}
+
+// parseSelectClause parses a SELECT up to its ORDER BY, or a parenthesized
+// query.
+func (p *parser) parseSelectClause() (*SelectStmt, error) {
+	if p.acceptPunct("(") {
+		s, err := p.parseSelect()
+		if err != nil {
+			return nil, err
+		}
+		return s, p.expectPunct(")")
+	}
+	if err := p.expectKeyword("select"); err != nil {
+		return nil, err
+	}
+	s := &SelectStmt{}
+	if p.acceptKeyword("distinct") {
+		s.Distinct = true
+	} else {
+		p.acceptKeyword("all")
+	}
+	for {
+		t, err := p.parseSelectTarget()
+		if err != nil {
+			return nil, err
+		}
+		s.Targets = append(s.Targets, t)
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("from") {
+		for {
+			te, err := p.parseTableExpr()
+			if err != nil {
+				return nil, err
+			}
+			s.From = append(s.From, te)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	if p.acceptKeyword("where") {
+		var err error
+		if s.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("group") {
+		if err := p.expectKeyword("by"); err != nil {
+			return nil, err
+		}
+		var err error
+		if s.GroupBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("having") {
+		var err error
+		if s.Having, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("window") {
+		for {
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("as"); err != nil {
+				return nil, err
+			}
+			w, err := p.parseWindowSpec()
+			if err != nil {
+				return nil, err
+			}
+			w.Name = name
+			s.Windows = append(s.Windows, w)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	return s, nil
+}
+
+// parseOrderItem parses expr [ASC | DESC] [NULLS {FIRST | LAST}].
+func (p *parser) parseOrderItem() (*OrderItem, error) {
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	item := &OrderItem{Expr: e}
+	item.Desc, item.Nulls, err = p.parseSortOrder()
+	return item, err
+}
+
+// parseSortOrder parses [ASC | DESC] [NULLS {FIRST | LAST}].
+func (p *parser) parseSortOrder() (bool, NullsOrder, error) {
+	desc := p.acceptKeyword("desc")
+	if !desc {
+		p.acceptKeyword("asc")
+	}
+	if !p.acceptKeyword("nulls") {
+		return desc, NullsDefault, nil
+	}
+	switch {
+	case p.acceptKeyword("first"):
+		return desc, NullsFirst, nil
+	case p.acceptKeyword("last"):
+		return desc, NullsLast, nil
+	}
+	return false, NullsDefault, p.unexpected()
+}
+
+// parseLimitOffset parses LIMIT {count | ALL}, OFFSET start [ROW | ROWS]
+// and the standard FETCH {FIRST | NEXT} [count] {ROW | ROWS} ONLY, in any
+// order.
+func (p *parser) parseLimitOffset(s *SelectStmt) error {
+	// A parenthesized query can already have them.
+	haveLimit, haveOffset := s.Limit != nil, s.Offset != nil
+	for {
+		start := p.peek().pos
+		switch {
+		case p.isKeyword("limit"), p.isKeyword("fetch"):
+			if haveLimit {
+				return syntaxErrorAt(p.src, start, "multiple LIMIT clauses not allowed")
+			}
+			haveLimit = true
+			var err error
+			if p.acceptKeyword("limit") {
+				if p.acceptKeyword("all") {
+					continue
+				}
+				if s.Limit, err = p.parseExpr(); err != nil {
+					return err
+				}
+				continue
+			}
+			p.pos++
+			if !p.acceptKeyword("first") && !p.acceptKeyword("next") {
+				return p.unexpected()
+			}
+			if p.isKeyword("row") || p.isKeyword("rows") {
+				s.Limit = &NumberLit{Text: "1"}
+			} else if s.Limit, err = p.parseExpr(); err != nil {
+				return err
+			}
+			if !p.acceptKeyword("row") && !p.acceptKeyword("rows") {
+				return p.unexpected()
+			}
+			if err := p.expectKeyword("only"); err != nil {
+				return err
+			}
+		case p.acceptKeyword("offset"):
+			if haveOffset {
+				return syntaxErrorAt(p.src, start, "multiple OFFSET clauses not allowed")
+			}
+			haveOffset = true
+			var err error
+			if s.Offset, err = p.parseExpr(); err != nil {
+				return err
+			}
+			if !p.acceptKeyword("row") {
+				p.acceptKeyword("rows")
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) parseSelectTarget() (*SelectTarget, error) {
+	if p.acceptOp("*") {
+		return &SelectTarget{Expr: &Star{}}, nil
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	t := &SelectTarget{Expr: e}
+	if p.acceptKeyword("as") {
+		tok := p.peek()
+		if tok.kind != tokIdent {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		t.Alias = tok.str
+	} else if tok := p.peek(); tok.kind == tokIdent && (tok.quoted || !isReserved(tok.str)) {
+		p.pos++
+		t.Alias = tok.str
+	}
+	return t, nil
+}
+
+// parseTableExpr parses a FROM item: a table or a parenthesized FROM item,
+// followed by any number of joins.
+func (p *parser) parseTableExpr() (TableExpr, error) {
+	left, err := p.parseTablePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		start := p.pos
+		j := &JoinExpr{Left: left, Natural: p.acceptKeyword("natural")}
+		switch {
+		case !j.Natural && p.acceptKeyword("cross"):
+			j.Type = CrossJoin
+		case p.acceptKeyword("left"):
+			j.Type = LeftJoin
+			p.acceptKeyword("outer")
+		case p.acceptKeyword("right"):
+			j.Type = RightJoin
+			p.acceptKeyword("outer")
+		case p.acceptKeyword("full"):
+			j.Type = FullJoin
+			p.acceptKeyword("outer")
+		default:
+			p.acceptKeyword("inner")
+		}
+		if p.pos == start && !p.isKeyword("join") {
+			return left, nil
+		}
+		if err := p.expectKeyword("join"); err != nil {
+			return nil, err
+		}
+		if j.Right, err = p.parseTablePrimary(); err != nil {
+			return nil, err
+		}
+		if j.Type != CrossJoin && !j.Natural {
+			switch {
+			case p.acceptKeyword("on"):
+				if j.On, err = p.parseExpr(); err != nil {
+					return nil, err
+				}
+			case p.acceptKeyword("using"):
+				if j.Using, err = p.parseParenNameList(); err != nil {
+					return nil, err
+				}
+			default:
+				return nil, p.unexpected()
+			}
+		}
+		left = j
+	}
+}
+
+func (p *parser) parseTablePrimary() (TableExpr, error) {
+	if p.acceptPunct("(") {
+		te, err := p.parseTableExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		return te, nil
+	}
+	return p.parseTableName()
+}
+
+// parseTableName parses [schema.]name[()] [[AS] alias].
+func (p *parser) parseTableName() (*TableName, error) {
+	tn, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptPunct("(") {
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+		tn.Call = true
+	}
+	if p.acceptKeyword("as") {
+		if tn.Alias, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	} else if tok := p.peek(); tok.kind == tokIdent && (tok.quoted || !isReserved(tok.str)) {
+		p.pos++
+		tn.Alias = tok.str
+	}
+	return tn, nil
+}
+
+func (p *parser) parseInsert() (*InsertStmt, error) {
+	if err := p.expectKeyword("insert"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("into"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	s := &InsertStmt{Table: name}
+	if p.isPunct("(") {
+		if s.Columns, err = p.parseParenNameList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("overriding") {
+		switch {
+		case p.acceptKeyword("system"):
+			s.Overriding = "system"
+		case p.acceptKeyword("user"):
+			s.Overriding = "user"
+		default:
+			return nil, p.unexpected()
+		}
+		if err := p.expectKeyword("value"); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case s.Columns == nil && p.acceptKeywords("default", "values"):
+		s.DefaultValues = true
+	case p.acceptKeyword("values"):
+		for {
+			if err := p.expectPunct("("); err != nil {
+				return nil, err
+			}
+			var row []Expr
+			for {
+				v, err := p.parseValueOrDefault()
+				if err != nil {
+					return nil, err
+				}
+				row = append(row, v)
+				if !p.acceptPunct(",") {
+					break
+				}
+			}
+			if err := p.expectPunct(")"); err != nil {
+				return nil, err
+			}
+			s.Values = append(s.Values, row)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	case p.isSelectStart():
+		if s.Select, err = p.parseSelect(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.unexpected()
+	}
+	return s, nil
+}
+
+// parseValueOrDefault parses a value of INSERT or UPDATE, which may be
+// DEFAULT.
+func (p *parser) parseValueOrDefault() (Expr, error) {
+	if p.acceptKeyword("default") {
+		return &DefaultExpr{}, nil
+	}
+	return p.parseExpr()
+}
+
+func (p *parser) parseUpdate() (*UpdateStmt, error) {
+	if err := p.expectKeyword("update"); err != nil {
+		return nil, err
+	}
+	tn, err := p.parseUpdateTarget()
+	if err != nil {
+		return nil, err
+	}
+	s := &UpdateStmt{Table: tn}
+	if err := p.expectKeyword("set"); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptOp("=") {
+			return nil, p.unexpected()
+		}
+		val, err := p.parseValueOrDefault()
+		if err != nil {
+			return nil, err
+		}
+		s.Set = append(s.Set, &SetClause{Column: col, Value: val})
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	if p.acceptKeyword("where") {
+		if s.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseUpdateTarget parses the table of UPDATE or DELETE, where an alias
+// must not be confused with the following SET or WHERE keyword.
+func (p *parser) parseUpdateTarget() (*TableName, error) {
+	tn, err := p.parseQualifiedName()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("as") || (p.peek().kind == tokIdent && !p.isKeyword("set") && !p.isKeyword("where") &&
+		(p.peek().quoted || !isReserved(p.peek().str))) {
+		if tn.Alias, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	return tn, nil
+}
+
+func (p *parser) parseDelete() (*DeleteStmt, error) {
+	if err := p.expectKeyword("delete"); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	tn, err := p.parseUpdateTarget()
+	if err != nil {
+		return nil, err
+	}
+	s := &DeleteStmt{Table: tn}
+	if p.acceptKeyword("where") {
+		if s.Where, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) parseIfNotExists() bool {
+	return p.acceptKeywords("if", "not", "exists")
+}
+
+func (p *parser) parseCreate() (Statement, error) {
+	if err := p.expectKeyword("create"); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("table"):
+		return p.parseCreateTable()
+	case p.acceptKeyword("index"):
+		return p.parseCreateIndex(false)
+	case p.acceptKeywords("unique", "index"):
+		return p.parseCreateIndex(true)
+	case p.acceptKeyword("sequence"):
+		return p.parseCreateSequence()
+	case p.acceptKeyword("schema"):
+		return p.parseCreateSchema()
+	case p.acceptKeyword("database"):
+		return p.parseCreateDatabase()
+	case p.acceptKeyword("role"):
+		return p.parseCreateRole(false)
+	case p.acceptKeyword("user"):
+		return p.parseCreateRole(true)
+	case p.acceptKeyword("publication"):
+		return p.parseCreatePublication()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) parseCreateTable() (*CreateTableStmt, error) {
+	s := &CreateTableStmt{IfNotExists: p.parseIfNotExists()}
+	var err error
+	if s.Name, err = p.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.isTableConstraint() {
+			c, err := p.parseTableConstraint()
+			if err != nil {
+				return nil, err
+			}
+			s.Constraints = append(s.Constraints, c)
+		} else {
+			col, err := p.parseColumnDef()
+			if err != nil {
+				return nil, err
+			}
+			s.Columns = append(s.Columns, col)
+		}
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("with") {
+		s.With, err = p.parseStorageParams()
+	}
+	return s, err
+}
+
+func (p *parser) parseColumnDef() (*ColumnDef, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	typ, err := p.parseTypeName()
+	if err != nil {
+		return nil, err
+	}
+	col := &ColumnDef{Name: name, Type: typ}
+	for {
+		switch {
+		case p.acceptKeywords("not", "null"):
+			col.NotNull = true
+		case p.acceptKeyword("null"):
+			col.NotNull = false
+		case p.acceptKeywords("primary", "key"):
+			col.PrimaryKey = true
+		case p.acceptKeyword("unique"):
+			col.Unique = true
+		case p.acceptKeyword("default"):
+			if col.Default, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		case p.acceptKeyword("generated"):
+			if p.isKeyword("by") || p.isKeywordAt(2, "identity") {
+				if col.Identity, err = p.parseIdentity(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := p.expectKeyword("always"); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("as"); err != nil {
+				return nil, err
+			}
+			if col.Generated, err = p.parseCheck(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("stored"); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("check") || p.isKeyword("references") || p.isKeyword("constraint"):
+			// Only CHECK and REFERENCES constraints of columns can be
+			// named.
+			c := &TableConstraint{}
+			if p.acceptKeyword("constraint") {
+				if c.Name, err = p.parseName(); err != nil {
+					return nil, err
+				}
+			}
+			if p.acceptKeyword("references") {
+				c.Columns = []string{name}
+				if c.References, err = p.parseReferences(); err != nil {
+					return nil, err
+				}
+				col.ForeignKeys = append(col.ForeignKeys, c)
+				continue
+			}
+			if err := p.expectKeyword("check"); err != nil {
+				return nil, err
+			}
+			if c.Check, err = p.parseCheck(); err != nil {
+				return nil, err
+			}
+			col.Checks = append(col.Checks, c)
+		default:
+			return col, nil
+		}
+	}
+}
+
+// parseIdentity parses the rest of GENERATED {ALWAYS | BY DEFAULT} AS
+// IDENTITY [(sequence options)] after GENERATED.
+func (p *parser) parseIdentity() (*IdentityDef, error) {
+	d := &IdentityDef{}
+	if !p.acceptKeywords("by", "default") {
+		if err := p.expectKeyword("always"); err != nil {
+			return nil, err
+		}
+		d.Always = true
+	}
+	if !p.acceptKeywords("as", "identity") {
+		return nil, p.unexpected()
+	}
+	if p.acceptPunct("(") {
+		if err := p.parseSequenceOptions(&d.Options); err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// parseCheck parses the parenthesized condition of a CHECK constraint, or
+// the parenthesized expression of a generated column.
+func (p *parser) parseCheck() (Expr, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return e, p.expectPunct(")")
+}
+
+// parseReferences parses the rest of a REFERENCES clause.
+func (p *parser) parseReferences() (*References, error) {
+	r := &References{}
+	var err error
+	if r.Table, err = p.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	if p.isPunct("(") {
+		if r.Columns, err = p.parseParenNameList(); err != nil {
+			return nil, err
+		}
+	}
+	p.acceptKeywords("match", "simple")
+	for {
+		switch {
+		case p.acceptKeywords("on", "delete"):
+			r.OnDelete, err = p.parseRefAction()
+		case p.acceptKeywords("on", "update"):
+			r.OnUpdate, err = p.parseRefAction()
+		default:
+			return r, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseRefAction() (string, error) {
+	switch {
+	case p.acceptKeywords("no", "action"):
+		return "NO ACTION", nil
+	case p.acceptKeyword("restrict"):
+		return "RESTRICT", nil
+	case p.acceptKeyword("cascade"):
+		return "CASCADE", nil
+	case p.acceptKeywords("set", "null"):
+		return "SET NULL", nil
+	case p.acceptKeywords("set", "default"):
+		return "SET DEFAULT", nil
+	}
+	return "", p.unexpected()
+}
+
+func (p *parser) parseAlterTable() (*AlterTableStmt, error) {
+	s := &AlterTableStmt{IfExists: p.acceptKeywords("if", "exists")}
+	var err error
+	if s.Name, err = p.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("rename") {
+		var cmd AlterTableCmd
+		if p.acceptKeyword("to") {
+			r := &RenameTable{}
+			r.NewName, err = p.parseName()
+			cmd = r
+		} else {
+			p.acceptKeyword("column")
+			r := &RenameColumn{}
+			if r.Name, err = p.parseName(); err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("to"); err != nil {
+				return nil, err
+			}
+			r.NewName, err = p.parseName()
+			cmd = r
+		}
+		s.Cmds = []AlterTableCmd{cmd}
+		return s, err
+	}
+	for {
+		cmd, err := p.parseAlterTableCmd()
+		if err != nil {
+			return nil, err
+		}
+		s.Cmds = append(s.Cmds, cmd)
+		if !p.acceptPunct(",") {
+			return s, nil
+		}
+	}
+}
+
+func (p *parser) parseAlterTableCmd() (AlterTableCmd, error) {
+	switch {
+	case p.acceptKeyword("add"):
+		if p.isTableConstraint() {
+			c, err := p.parseTableConstraint()
+			return &AddConstraint{Constraint: c}, err
+		}
+		p.acceptKeyword("column")
+		cmd := &AddColumn{IfNotExists: p.parseIfNotExists()}
+		var err error
+		cmd.Column, err = p.parseColumnDef()
+		return cmd, err
+	case p.acceptKeywords("drop", "constraint"):
+		cmd := &DropConstraint{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if cmd.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		p.acceptKeyword("restrict")
+		return cmd, nil
+	case p.acceptKeyword("drop"):
+		p.acceptKeyword("column")
+		cmd := &DropColumn{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if cmd.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		p.acceptKeyword("restrict")
+		return cmd, nil
+	case p.acceptKeyword("alter"):
+		p.acceptKeyword("column")
+		cmd := &SetDefault{}
+		var err error
+		if cmd.Column, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.acceptKeywords("set", "default"):
+			cmd.Default, err = p.parseExpr()
+			return cmd, err
+		case p.acceptKeywords("drop", "default"):
+			return cmd, nil
+		case p.acceptKeywords("add", "generated"):
+			id, err := p.parseIdentity()
+			return &AddIdentity{Column: cmd.Column, Identity: id}, err
+		case p.acceptKeywords("set", "generated"):
+			if p.acceptKeywords("by", "default") {
+				return &SetIdentity{Column: cmd.Column}, nil
+			}
+			return &SetIdentity{Column: cmd.Column, Always: true}, p.expectKeyword("always")
+		case p.acceptKeywords("drop", "identity"):
+			return &DropIdentity{Column: cmd.Column, IfExists: p.acceptKeywords("if", "exists")}, nil
+		}
+	case p.acceptKeyword("set"):
+		params, err := p.parseStorageParams()
+		return &SetStorageParams{Params: params}, err
+	case p.acceptKeyword("reset"):
+		names, err := p.parseParenNameList()
+		return &ResetStorageParams{Names: names}, err
+	}
+	return nil, p.unexpected()
+}
+
+// isTableConstraint reports whether a table constraint starts at the
+// current token.
+func (p *parser) isTableConstraint() bool {
+	return p.isKeyword("primary") || p.isKeyword("unique") || p.isKeyword("check") ||
+		p.isKeyword("foreign") || p.isKeyword("constraint")
+}
+
+func (p *parser) parseTableConstraint() (*TableConstraint, error) {
+	c := &TableConstraint{}
+	if p.acceptKeyword("constraint") {
+		var err error
+		if c.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.acceptKeywords("primary", "key"):
+		c.PrimaryKey = true
+	case p.acceptKeyword("unique"):
+		c.Unique = true
+	case p.acceptKeyword("check"):
+		var err error
+		c.Check, err = p.parseCheck()
+		return c, err
+	case p.acceptKeywords("foreign", "key"):
+		var err error
+		if c.Columns, err = p.parseParenNameList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("references"); err != nil {
+			return nil, err
+		}
+		c.References, err = p.parseReferences()
+		return c, err
+	default:
+		return nil, p.unexpected()
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		desc := p.acceptKeyword("desc")
+		if !desc {
+			p.acceptKeyword("asc")
+		}
+		c.Columns = append(c.Columns, col)
+		c.Desc = append(c.Desc, desc)
+		if !p.acceptPunct(",") {
+			return c, p.expectPunct(")")
+		}
+	}
+}
+
+func (p *parser) parseCreateIndex(unique bool) (*CreateIndexStmt, error) {
+	s := &CreateIndexStmt{Unique: unique, IfNotExists: p.parseIfNotExists()}
+	var err error
+	if !p.isKeyword("on") {
+		if s.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	if s.Table, err = p.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("using") {
+		if s.Using, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		opClass := ""
+		if !p.isPunct(",") && !p.isPunct(")") && !p.isSortOrder() {
+			if opClass, err = p.parseName(); err != nil {
+				return nil, err
+			}
+		}
+		desc, nulls, err := p.parseSortOrder()
+		if err != nil {
+			return nil, err
+		}
+		s.Columns = append(s.Columns, col)
+		s.OpClasses = append(s.OpClasses, opClass)
+		s.Desc = append(s.Desc, desc)
+		s.Nulls = append(s.Nulls, nulls)
+		if !p.acceptPunct(",") {
+			break
+		}
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("with") {
+		if s.With, err = p.parseStorageParams(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// isSortOrder reports whether the next token starts the sort order of an
+// index column.
+func (p *parser) isSortOrder() bool {
+	return p.isKeyword("asc") || p.isKeyword("desc") || p.isKeyword("nulls")
+}
+
+// parseStorageParams parses (name = value, ...), where each value is a
+// number, string or name.
+func (p *parser) parseStorageParams() ([]StorageParam, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	var params []StorageParam
+	for {
+		name, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptOp("=") {
+			return nil, p.unexpected()
+		}
+		t := p.peek()
+		if t.kind != tokNumber && t.kind != tokString && t.kind != tokIdent {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		params = append(params, StorageParam{Name: name, Value: t.str})
+		if !p.acceptPunct(",") {
+			return params, p.expectPunct(")")
+		}
+	}
+}
+
+func (p *parser) parseDrop() (Statement, error) {
+	if err := p.expectKeyword("drop"); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeyword("table"):
+		s := &DropTableStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if s.Names, err = p.parseQualifiedNameList(); err != nil {
+			return nil, err
+		}
+		s.Cascade = p.acceptKeyword("cascade")
+		if !s.Cascade {
+			p.acceptKeyword("restrict")
+		}
+		return s, nil
+	case p.acceptKeyword("index"):
+		s := &DropIndexStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		s.Names, err = p.parseQualifiedNameList()
+		return s, err
+	case p.acceptKeyword("role"), p.acceptKeyword("user"):
+		s := &DropRoleStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		s.Names, err = p.parseNameList()
+		return s, err
+	case p.acceptKeyword("sequence"):
+		s := &DropSequenceStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if s.Names, err = p.parseQualifiedNameList(); err != nil {
+			return nil, err
+		}
+		s.Cascade = p.acceptKeyword("cascade")
+		if !s.Cascade {
+			p.acceptKeyword("restrict")
+		}
+		return s, nil
+	case p.acceptKeyword("schema"):
+		s := &DropSchemaStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if s.Names, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+		s.Cascade = p.acceptKeyword("cascade")
+		if !s.Cascade {
+			p.acceptKeyword("restrict")
+		}
+		return s, nil
+	case p.acceptKeyword("publication"):
+		s := &DropPublicationStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if s.Names, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+		if !p.acceptKeyword("cascade") {
+			p.acceptKeyword("restrict")
+		}
+		return s, nil
+	case p.acceptKeyword("database"):
+		s := &DropDatabaseStmt{IfExists: p.acceptKeywords("if", "exists")}
+		var err error
+		if s.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		p.acceptKeyword("with")
+		if p.acceptPunct("(") {
+			if err := p.expectKeyword("force"); err != nil {
+				return nil, err
+			}
+			s.Force = true
+			return s, p.expectPunct(")")
+		}
+		return s, nil
+	}
+	return nil, p.unexpected()
+}
+
+// parseTruncate parses TRUNCATE after its keyword.
+func (p *parser) parseTruncate() (*TruncateStmt, error) {
+	p.acceptKeyword("table")
+	s := &TruncateStmt{}
+	var err error
+	if s.Names, err = p.parseQualifiedNameList(); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.acceptKeywords("restart", "identity"):
+		s.RestartIdentity = true
+	case p.acceptKeywords("continue", "identity"):
+	}
+	s.Cascade = p.acceptKeyword("cascade")
+	if !s.Cascade {
+		p.acceptKeyword("restrict")
+	}
+	return s, nil
+}
+
+// parseCopy parses COPY after its keyword. Only COPY FROM is supported,
+// and only with the parenthesized option list.
+func (p *parser) parseCopy() (*CopyStmt, error) {
+	s := &CopyStmt{}
+	var err error
+	if s.Table, err = p.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	if p.isPunct("(") {
+		if s.Columns, err = p.parseParenNameList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("from"); err != nil {
+		return nil, err
+	}
+	switch t := p.peek(); {
+	case t.kind == tokString:
+		p.pos++
+		s.File = t.str
+	case !p.acceptKeyword("stdin"):
+		return nil, p.unexpected()
+	}
+	p.acceptKeyword("with")
+	if !p.acceptPunct("(") {
+		return s, nil
+	}
+	for {
+		// Option names such as NULL and FORMAT are keywords.
+		t := p.peek()
+		if t.kind != tokIdent {
+			return nil, p.unexpected()
+		}
+		p.pos++
+		opt := CopyOption{Name: t.str}
+		if v := p.peek(); v.kind == tokNumber || v.kind == tokString || v.kind == tokIdent {
+			p.pos++
+			opt.Value = v.str
+		}
+		s.Options = append(s.Options, opt)
+		if !p.acceptPunct(",") {
+			return s, p.expectPunct(")")
+		}
+	}
+}
+
+// parseVacuum parses VACUUM after its keyword, with either its
+// parenthesized option list or the keywords of the older syntax.
+func (p *parser) parseVacuum() (*VacuumStmt, error) {
+	s := &VacuumStmt{}
+	if p.acceptPunct("(") {
+		for {
+			t := p.peek()
+			if t.kind != tokIdent {
+				return nil, p.unexpected()
+			}
+			p.pos++
+			opt := CopyOption{Name: t.str}
+			if v := p.peek(); v.kind == tokNumber || v.kind == tokString || v.kind == tokIdent {
+				p.pos++
+				opt.Value = v.str
+			}
+			s.Options = append(s.Options, opt)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, kw := range []string{"full", "freeze", "verbose", "analyze"} {
+			if p.acceptKeyword(kw) || kw == "analyze" && p.acceptKeyword("analyse") {
+				s.Options = append(s.Options, CopyOption{Name: kw})
+			}
+		}
+	}
+	if p.peek().kind == tokIdent {
+		var err error
+		if s.Names, err = p.parseQualifiedNameList(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p *parser) parseCreateSequence() (*CreateSequenceStmt, error) {
+	s := &CreateSequenceStmt{IfNotExists: p.parseIfNotExists()}
+	var err error
+	if s.Name, err = p.parseQualifiedName(); err != nil {
+		return nil, err
+	}
+	return s, p.parseSequenceOptions(s)
+}
+
+// parseCreateSchema parses CREATE SCHEMA after its keywords.
+func (p *parser) parseCreateSchema() (*CreateSchemaStmt, error) {
+	s := &CreateSchemaStmt{IfNotExists: p.parseIfNotExists()}
+	var err error
+	if !p.isKeyword("authorization") {
+		if s.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("authorization") {
+		if s.Authorization, err = p.parseName(); err != nil {
+			return nil, err
+		}
+		if s.Name == "" {
+			s.Name = s.Authorization
+		}
+	}
+	if s.Name == "" {
+		return nil, p.unexpected()
+	}
+	return s, nil
+}
+
+// parseCreatePublication parses CREATE PUBLICATION after its keywords.
+func (p *parser) parseCreatePublication() (*CreatePublicationStmt, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	s := &CreatePublicationStmt{Name: name}
+	if p.acceptKeyword("for") {
+		switch {
+		case p.acceptKeywords("all", "tables"):
+			s.AllTables = true
+		case p.acceptKeyword("table"):
+			if s.Tables, err = p.parseQualifiedNameList(); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if p.acceptKeyword("with") {
+		if s.With, err = p.parseStorageParams(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseCreateDatabase parses CREATE DATABASE after its keywords. Of the
+// options, only OWNER is supported; the database is created empty, with
+// the server's encoding.
+func (p *parser) parseCreateDatabase() (*CreateDatabaseStmt, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	s := &CreateDatabaseStmt{Name: name}
+	p.acceptKeyword("with")
+	if p.acceptKeyword("owner") {
+		p.acceptPunct("=")
+		if s.Owner, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseSequenceOptions parses the options of CREATE SEQUENCE, or of the
+// sequence of an identity column, into s.
+func (p *parser) parseSequenceOptions(s *CreateSequenceStmt) error {
+	var err error
+	option := func(opt **int64) error {
+		n, err := p.parseSignedInt()
+		*opt = &n
+		return err
+	}
+	for {
+		switch {
+		case p.acceptKeyword("as"):
+			if s.Type, err = p.parseTypeName(); err != nil {
+				return err
+			}
+		case p.acceptKeyword("increment"):
+			p.acceptKeyword("by")
+			err = option(&s.Increment)
+		case p.acceptKeyword("minvalue"):
+			err = option(&s.MinValue)
+		case p.acceptKeyword("maxvalue"):
+			err = option(&s.MaxValue)
+		case p.acceptKeyword("start"):
+			p.acceptKeyword("with")
+			err = option(&s.Start)
+		case p.acceptKeyword("cache"):
+			err = option(&s.Cache)
+		case p.acceptKeyword("cycle"):
+			s.Cycle = true
+		case p.acceptKeyword("gapless"):
+			s.Gapless = true
+		case p.acceptKeyword("no"):
+			switch {
+			case p.acceptKeyword("minvalue"):
+				s.MinValue = nil
+			case p.acceptKeyword("maxvalue"):
+				s.MaxValue = nil
+			case p.acceptKeyword("cycle"):
+				s.Cycle = false
+			case p.acceptKeyword("gapless"):
+				s.Gapless = false
+			default:
+				return p.unexpected()
+			}
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseCreateRole parses CREATE ROLE after its keywords. CREATE USER
+// creates a role that may log in by default.
+func (p *parser) parseCreateRole(user bool) (*CreateRoleStmt, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	s := &CreateRoleStmt{Name: name}
+	if user {
+		login := true
+		s.Options.Login = &login
+	}
+	return s, p.parseRoleOptions(&s.Options, true)
+}
+
+// parseAlterRole parses ALTER ROLE after its keywords.
+func (p *parser) parseAlterRole() (*AlterRoleStmt, error) {
+	s := &AlterRoleStmt{All: p.acceptKeyword("all")}
+	var err error
+	if !s.All {
+		if s.Name, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeywords("in", "database") {
+		if s.Database, err = p.parseName(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.acceptKeyword("set"):
+		if s.Set, err = p.parseSet(); err != nil {
+			return nil, err
+		}
+		if s.Set.Local {
+			return nil, p.unexpected()
+		}
+		return s, nil
+	case p.acceptKeyword("reset"):
+		s.Set = &SetStmt{Default: true, Reset: true, All: p.acceptKeyword("all")}
+		if !s.Set.All {
+			s.Set.Name, err = p.parseName()
+		}
+		return s, err
+	case s.All || s.Database != "":
+		return nil, p.unexpected()
+	case p.acceptKeywords("rename", "to"):
+		s.NewName, err = p.parseName()
+		return s, err
+	}
+	return s, p.parseRoleOptions(&s.Options, false)
+}
+
+// parseRoleOptions parses the options of CREATE ROLE, or of ALTER ROLE if
+// create is not set, which has no IN ROLE or ROLE.
+func (p *parser) parseRoleOptions(o *RoleOptions, create bool) error {
+	p.acceptKeyword("with")
+	flag := func(dst **bool, v bool) {
+		*dst = &v
+	}
+	for {
+		var err error
+		switch {
+		case p.acceptKeyword("superuser"):
+			flag(&o.Superuser, true)
+		case p.acceptKeyword("nosuperuser"):
+			flag(&o.Superuser, false)
+		case p.acceptKeyword("createdb"):
+			flag(&o.CreateDB, true)
+		case p.acceptKeyword("nocreatedb"):
+			flag(&o.CreateDB, false)
+		case p.acceptKeyword("createrole"):
+			flag(&o.CreateRole, true)
+		case p.acceptKeyword("nocreaterole"):
+			flag(&o.CreateRole, false)
+		case p.acceptKeyword("inherit"):
+			flag(&o.Inherit, true)
+		case p.acceptKeyword("noinherit"):
+			flag(&o.Inherit, false)
+		case p.acceptKeyword("login"):
+			flag(&o.Login, true)
+		case p.acceptKeyword("nologin"):
+			flag(&o.Login, false)
+		case p.acceptKeywords("encrypted", "password"), p.acceptKeyword("password"):
+			if p.acceptKeyword("null") {
+				o.Password, o.NoPassword = nil, true
+				break
+			}
+			t := p.peek()
+			if t.kind != tokString {
+				return p.unexpected()
+			}
+			p.pos++
+			o.Password, o.NoPassword = &t.str, false
+		case p.acceptKeywords("connection", "limit"):
+			var n int64
+			n, err = p.parseSignedInt()
+			o.ConnLimit = &n
+		case create && (p.acceptKeywords("in", "role") || p.acceptKeywords("in", "group")):
+			var names []string
+			names, err = p.parseNameList()
+			o.InRole = append(o.InRole, names...)
+		case create && (p.acceptKeyword("role") || p.acceptKeyword("user")):
+			var names []string
+			names, err = p.parseNameList()
+			o.Roles = append(o.Roles, names...)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parseGrantRole parses GRANT role TO member, or REVOKE role FROM member if
+// revoke is set, after its keyword.
+// parseGrant parses GRANT or REVOKE after its keyword: of privileges if
+// they are granted ON objects, and of role membership otherwise.
+func (p *parser) parseGrant(revoke bool) (Statement, error) {
+	for i := 0; ; i++ {
+		t := p.peekAt(i)
+		switch {
+		case t.kind == tokEOF || t.kind == tokPunct && t.str == ";":
+			return p.parseGrantRole(revoke)
+		case t.kind == tokIdent && !t.quoted && t.str == "on":
+			return p.parseGrantPrivileges(revoke)
+		case t.kind == tokIdent && !t.quoted && (t.str == "to" || t.str == "from"):
+			return p.parseGrantRole(revoke)
+		}
+	}
+}
+
+// parseGrantPrivileges parses GRANT privilege, ... ON [TABLE] name, ... |
+// ON SCHEMA name, ... TO role, ... [WITH GRANT OPTION], or REVOKE [GRANT
+// OPTION FOR] ... FROM role, ... [RESTRICT].
+func (p *parser) parseGrantPrivileges(revoke bool) (*GrantStmt, error) {
+	s := &GrantStmt{Revoke: revoke}
+	if revoke {
+		s.GrantOption = p.acceptKeywords("grant", "option", "for")
+	}
+	if p.acceptKeyword("all") {
+		p.acceptKeyword("privileges")
+	} else {
+		for {
+			t := p.peek()
+			if t.kind != tokIdent || t.quoted {
+				return nil, p.unexpected()
+			}
+			p.pos++
+			s.Privileges = append(s.Privileges, t.str)
+			if p.isPunct("(") {
+				return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "column privileges are not supported")
+			}
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.acceptKeyword("schema") {
+		if s.Schemas, err = p.parseNameList(); err != nil {
+			return nil, err
+		}
+	} else {
+		p.acceptKeyword("table")
+		if s.Tables, err = p.parseQualifiedNameList(); err != nil {
+			return nil, err
+		}
+	}
+	kw := "to"
+	if revoke {
+		kw = "from"
+	}
+	if err := p.expectKeyword(kw); err != nil {
+		return nil, err
+	}
+	if s.Grantees, err = p.parseNameList(); err != nil {
+		return nil, err
+	}
+	if !revoke {
+		s.GrantOption = p.acceptKeywords("with", "grant", "option")
+		return s, nil
+	}
+	if p.acceptKeyword("cascade") {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "REVOKE ... CASCADE is not supported")
+	}
+	p.acceptKeyword("restrict")
+	return s, nil
+}
+
+func (p *parser) parseGrantRole(revoke bool) (*GrantRoleStmt, error) {
+	s := &GrantRoleStmt{Revoke: revoke}
+	var err error
+	if s.Roles, err = p.parseNameList(); err != nil {
+		return nil, err
+	}
+	kw := "to"
+	if revoke {
+		kw = "from"
+	}
+	if err := p.expectKeyword(kw); err != nil {
+		return nil, err
+	}
+	if s.Members, err = p.parseNameList(); err != nil {
+		return nil, err
+	}
+	if !revoke {
+		return s, nil
+	}
+	if p.acceptKeyword("cascade") {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "REVOKE ... CASCADE is not supported")
+	}
+	p.acceptKeyword("restrict")
+	return s, nil
+}
+
+// parseSignedInt parses an integer literal with an optional sign.
+func (p *parser) parseSignedInt() (int64, error) {
+	neg := p.acceptOp("-")
+	if !neg {
+		p.acceptOp("+")
+	}
+	t := p.peek()
+	if t.kind != tokNumber {
+		return 0, p.unexpected()
+	}
+	p.pos++
+	if neg {
+		t.str = "-" + t.str
+	}
+	n, err := strconv.ParseInt(t.str, 10, 64)
+	if err != nil {
+		return 0, syntaxErrorAt(p.src, t.pos, "invalid integer")
+	}
+	return n, nil
+}
+
+// parseTypeName parses a type name with optional modifiers, including the
+// multi-word SQL standard spellings.
+func (p *parser) parseTypeName() (*TypeName, error) {
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "double":
+		if err := p.expectKeyword("precision"); err != nil {
+			return nil, err
+		}
+		name = "double precision"
+	case "character", "char":
+		if p.acceptKeyword("varying") {
+			name = "character varying"
+		}
+	case "bit":
+		if p.acceptKeyword("varying") {
+			name = "bit varying"
+		}
+	}
+	tn := &TypeName{Name: name}
+	if p.acceptPunct("(") {
+		for {
+			t := p.peek()
+			if t.kind != tokNumber {
+				return nil, p.unexpected()
+			}
+			p.pos++
+			n, err := strconv.ParseInt(t.str, 10, 32)
+			if err != nil {
+				return nil, syntaxErrorAt(p.src, t.pos, "invalid type modifier")
+			}
+			tn.Mods = append(tn.Mods, int32(n))
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if err := p.expectPunct(")"); err != nil {
+			return nil, err
+		}
+	}
+	if name == "timestamp" || name == "time" {
+		switch {
+		case p.acceptKeywords("with", "time", "zone"):
+			tn.Name += " with time zone"
+		case p.acceptKeywords("without", "time", "zone"):
+			tn.Name += " without time zone"
+		}
+	}
+	return tn, nil
+}
+
+// reserved lists keywords that cannot be used as bare column or table
+// names or as aliases without AS.
+var reserved = map[string]bool{
+	"all": true, "and": true, "any": true, "as": true, "asc": true,
+	"between": true, "both": true, "case": true, "cast": true, "check": true,
+	"constraint": true, "create": true, "cross": true, "current_date": true,
+	"current_timestamp": true, "default": true, "desc": true,
+	"distinct": true, "else": true, "end": true, "except": true,
+	"escape": true, "false": true, "fetch": true, "for": true,
+	"foreign": true, "from": true, "full": true, "group": true,
+	"having": true, "ilike": true, "in": true, "inner": true,
+	"intersect": true, "into": true, "is": true, "join": true,
+	"leading": true, "left": true, "like": true, "limit": true,
+	"localtimestamp": true, "natural": true, "not": true, "null": true,
+	"offset": true, "on": true, "or": true, "order": true, "outer": true,
+	"primary": true, "references": true, "returning": true, "right": true,
+	"select": true, "similar": true, "table": true, "then": true,
+	"trailing": true, "true": true, "union": true, "unique": true,
+	"using": true, "values": true, "when": true, "where": true,
+	"window": true, "with": true,
+}
+
+func isReserved(s string) bool { return reserved[s] }
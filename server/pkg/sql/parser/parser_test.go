@@ -0,0 +1,152 @@
+package parser_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+func TestExprRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		sql, want string
+	}{
+		// Precedence and associativity.
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"2 - 1 - 1", "((2 - 1) - 1)"},
+		{"-x ^ 2", "((-x) ^ 2)"},
+		{"a AND b OR NOT c", "((a AND b) OR (NOT c))"},
+		{"a OR b AND c", "(a OR (b AND c))"},
+		{"$1 || 'a'", "($1 || 'a')"},
+		// Predicates.
+		{"x IS NOT NULL", "(x IS NOT NULL)"},
+		{"x IS DISTINCT FROM y", "(x IS DISTINCT FROM y)"},
+		{"x BETWEEN 1 AND 10", "(x BETWEEN 1 AND 10)"},
+		{"x NOT IN (1, 2)", "(x NOT IN (1, 2))"},
+		{"name LIKE 'a%' ESCAPE '!'", "(name LIKE 'a%' ESCAPE '!')"},
+		{"x ILIKE 'a'", "(x ILIKE 'a')"},
+		{"x SIMILAR TO 'a'", "(x ~ similar_to_escape('a'))"},
+		{"(a, b) < (1, 2)", "(ROW(a, b) < ROW(1, 2))"},
+		// Literals, names and casts.
+		{"'it''s'", "'it''s'"},
+		{"true", "true"},
+		{"NULL", "NULL"},
+		{`"Foo".Bar`, `"Foo".bar`},
+		{"x::int4", "CAST(x AS int4)"},
+		{"CAST(x AS text)", "CAST(x AS text)"},
+		// Conditionals, aggregates and windows.
+		{"CASE WHEN a THEN 1 ELSE 2 END", "CASE WHEN a THEN 1 ELSE 2 END"},
+		{"count(DISTINCT x)", "count(DISTINCT x)"},
+		{"row_number() OVER (PARTITION BY a ORDER BY b DESC)", "row_number() OVER (PARTITION BY a ORDER BY b DESC)"},
+	} {
+		e, err := parser.ParseExpr(tc.sql)
+		if err != nil {
+			t.Errorf("ParseExpr(%q): %v", tc.sql, err)
+			continue
+		}
+		if got := e.String(); got != tc.want {
+			t.Errorf("ParseExpr(%q) = %s, want %s", tc.sql, got, tc.want)
+			continue
+		}
+		// What an expression prints as parses back to it.
+		again, err := parser.ParseExpr(tc.want)
+		if err != nil {
+			t.Errorf("ParseExpr(%q), printed by ParseExpr(%q): %v", tc.want, tc.sql, err)
+			continue
+		}
+		if got := again.String(); got != tc.want {
+			t.Errorf("ParseExpr(%q) = %s, want it unchanged", tc.want, got)
+		}
+	}
+}
+
+func TestParseStatements(t *testing.T) {
+	stmts, err := parser.Parse("SELECT a FROM t;; INSERT INTO t VALUES (1);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 2 {
+		t.Fatalf("parsed %d statements, want 2", len(stmts))
+	}
+	if _, ok := stmts[0].(*parser.SelectStmt); !ok {
+		t.Errorf("first statement is a %T", stmts[0])
+	}
+	if _, ok := stmts[1].(*parser.InsertStmt); !ok {
+		t.Errorf("second statement is a %T", stmts[1])
+	}
+	if _, err := parser.ParseOne("SELECT 1; SELECT 2"); err == nil {
+		t.Error("ParseOne parsed two statements")
+	}
+}
+
+func TestSetOperationPrecedence(t *testing.T) {
+	for _, tc := range []struct {
+		sql string
+		op  parser.SetOp
+	}{
+		// UNION and EXCEPT associate to the left.
+		{"SELECT 1 UNION ALL SELECT 2 EXCEPT SELECT 3", parser.Except},
+		// INTERSECT binds more tightly.
+		{"SELECT 1 UNION SELECT 2 INTERSECT SELECT 3", parser.Union},
+	} {
+		stmt, err := parser.ParseOne(tc.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.sql, err)
+		}
+		if s := stmt.(*parser.SelectStmt); s.Op != tc.op {
+			t.Errorf("%s is a %s at the top, want %s", tc.sql, s.Op, tc.op)
+		}
+	}
+}
+
+func TestNameFolding(t *testing.T) {
+	stmt, err := parser.ParseOne(`DELETE FROM Public."MixedCase"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unquoted names fold to lower case, quoted ones keep theirs.
+	if n := stmt.(*parser.DeleteStmt).Table; n.Schema != "public" || n.Name != "MixedCase" {
+		t.Errorf("table name %q.%q", n.Schema, n.Name)
+	}
+}
+
+func TestRoleOptions(t *testing.T) {
+	stmt, err := parser.ParseOne("CREATE ROLE r WITH LOGIN NOSUPERUSER CONNECTION LIMIT -1 PASSWORD NULL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := stmt.(*parser.CreateRoleStmt).Options
+	if o.Login == nil || !*o.Login || o.Superuser == nil || *o.Superuser {
+		t.Errorf("LOGIN NOSUPERUSER parsed as %v, %v", o.Login, o.Superuser)
+	}
+	if o.ConnLimit == nil || *o.ConnLimit != -1 {
+		t.Errorf("CONNECTION LIMIT -1 parsed as %v", o.ConnLimit)
+	}
+	if !o.NoPassword {
+		t.Error("PASSWORD NULL was not parsed")
+	}
+}
+
+func TestSyntaxErrors(t *testing.T) {
+	for _, tc := range []struct {
+		sql      string
+		message  string
+		position int
+	}{
+		{"SELECT FROM", `syntax error at or near "from"`, 8},
+		{"SELECT 'unterminated", "unterminated quoted string", 8},
+		{"SELECT 1 +", "syntax error at end of input", 11},
+	} {
+		_, err := parser.Parse(tc.sql)
+		var pgErr *pgerror.Error
+		if !errors.As(err, &pgErr) || pgErr.Code != pgerror.CodeSyntaxError {
+			t.Errorf("%q: %v, want a syntax error", tc.sql, err)
+			continue
+		}
+		if pgErr.Message != tc.message || pgErr.Position != tc.position {
+			t.Errorf("%q: %q at %d, want %q at %d", tc.sql, pgErr.Message, pgErr.Position, tc.message, tc.position)
+		}
+	}
+}
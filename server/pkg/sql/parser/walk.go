@@ -0,0 +1,79 @@
+package parser
+
+// Walk calls fn for e and, while fn returns true, for each of its
+// descendants in depth-first order.
+func Walk(e Expr, fn func(Expr) bool) {
+	if e == nil || !fn(e) {
+		return
+	}
+	for _, c := range Children(e) {
+		Walk(c, fn)
+	}
+}
+
+// Children returns the direct sub-expressions of e. The SELECT of a
+// subquery is a separate query, not a sub-expression.
+func Children(e Expr) []Expr {
+	switch t := e.(type) {
+	case *UnaryExpr:
+		return []Expr{t.X}
+	case *BinaryExpr:
+		return []Expr{t.L, t.R}
+	case *AndExpr:
+		return []Expr{t.L, t.R}
+	case *OrExpr:
+		return []Expr{t.L, t.R}
+	case *NotExpr:
+		return []Expr{t.X}
+	case *IsExpr:
+		return []Expr{t.X}
+	case *BetweenExpr:
+		return []Expr{t.X, t.Lo, t.Hi}
+	case *InExpr:
+		return append([]Expr{t.X}, t.List...)
+	case *LikeExpr:
+		if t.Escape != nil {
+			return []Expr{t.X, t.Pattern, t.Escape}
+		}
+		return []Expr{t.X, t.Pattern}
+	case *CaseExpr:
+		var out []Expr
+		if t.Operand != nil {
+			out = append(out, t.Operand)
+		}
+		for _, w := range t.Whens {
+			out = append(out, w.Cond, w.Val)
+		}
+		if t.Else != nil {
+			out = append(out, t.Else)
+		}
+		return out
+	case *FuncCall:
+		if t.Over == nil {
+			return t.Args
+		}
+		return append(append([]Expr(nil), t.Args...), t.Over.Exprs()...)
+	case *CastExpr:
+		return []Expr{t.X}
+	case *RowExpr:
+		return t.Exprs
+	}
+	return nil
+}
+
+// Exprs returns the expressions of w: its PARTITION BY and ORDER BY
+// expressions and its frame offsets.
+func (w *WindowDef) Exprs() []Expr {
+	out := append([]Expr(nil), w.PartitionBy...)
+	for _, o := range w.OrderBy {
+		out = append(out, o.Expr)
+	}
+	if w.Frame != nil {
+		for _, b := range []FrameBound{w.Frame.Start, w.Frame.End} {
+			if b.Offset != nil {
+				out = append(out, b.Offset)
+			}
+		}
+	}
+	return out
+}
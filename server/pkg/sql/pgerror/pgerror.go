@@ -0,0 +1,203 @@
+// Package pgerror defines errors that carry a PostgreSQL SQLSTATE code.
+//
+// Errors raised anywhere in the SQL layer should be created through this
+// package so the wire protocol can report a meaningful code to clients.
+package pgerror
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SQLSTATE codes used by the server. See Appendix A of the PostgreSQL
+// documentation for the full list.
+const (
+	CodeSuccessfulCompletion      = "00000"
+	CodeFeatureNotSupported       = "0A000"
+	CodeCardinalityViolation      = "21000"
+	CodeDataException             = "22000"
+	CodeStringDataRightTruncation = "22001"
+	CodeNumericValueOutOfRange    = "22003"
+	CodeInvalidDatetimeFormat     = "22007"
+	CodeDatetimeFieldOverflow     = "22008"
+	CodeDivisionByZero            = "22012"
+	CodeInvalidFrameOffset        = "22013"
+	CodeInvalidArgumentForNtile   = "22014"
+	CodeInvalidNthValueArgument   = "22016"
+	CodeNullValueNotAllowed       = "22004"
+	CodeSubstringError            = "22011"
+	CodeInvalidParameterValue     = "22023"
+	CodeInvalidEscapeSequence     = "22025"
+	CodeStringDataLengthMismatch  = "22026"
+	CodeInvalidTextRepresentation = "22P02"
+	CodeBadBinaryFormat           = "22P03"
+	CodeBadCopyFileFormat         = "22P04"
+	CodeUntranslatableCharacter   = "22P05"
+	CodeCharacterNotInRepertoire  = "22021"
+	CodeInvalidRegularExpression  = "2201B"
+	CodeInvalidLimitRowCount      = "2201W"
+	CodeInvalidOffsetRowCount     = "2201X"
+	CodeInvalidArgumentForLog     = "2201E"
+	CodeInvalidArgumentForPower   = "2201F"
+	CodeArraySubscriptError       = "2202E"
+	CodeNotAnXMLDocument          = "2200L"
+	CodeInvalidXMLDocument        = "2200M"
+	CodeInvalidXMLContent         = "2200N"
+	CodeInvalidXMLComment         = "2200S"
+	CodeSequenceGeneratorLimit    = "2200H"
+	CodeNotNullViolation          = "23502"
+	CodeForeignKeyViolation       = "23503"
+	CodeCheckViolation            = "23514"
+	CodeUniqueViolation           = "23505"
+	CodeActiveSQLTransaction      = "25001"
+	CodeNoActiveSQLTransaction    = "25P01"
+	CodeReadOnlySQLTransaction    = "25006"
+	CodeInFailedSQLTransaction    = "25P02"
+	CodeIdleInTransactionTimeout  = "25P03"
+	CodeTransactionTimeout        = "25P04"
+	CodeSerializationFailure      = "40001"
+	CodeObjectNotInPrerequisite   = "55000"
+	CodeObjectInUse               = "55006"
+	CodeCantChangeRuntimeParam    = "55P02"
+	CodeInsufficientResources     = "53000"
+	CodeOutOfMemory               = "53200"
+	CodeTooManyConnections        = "53300"
+	CodeConfigLimitExceeded       = "53400"
+	CodeProgramLimitExceeded      = "54000"
+	CodeQueryCanceled             = "57014"
+	CodeAdminShutdown             = "57P01"
+	CodeCannotConnectNow          = "57P03"
+	CodeIdleSessionTimeout        = "57P05"
+	CodeIOError                   = "58030"
+	CodeUndefinedFile             = "58P01"
+	CodeInternalError             = "XX000"
+	CodeSyntaxError               = "42601"
+	CodeInvalidName               = "42602"
+	CodeUndefinedColumn           = "42703"
+	CodeUndefinedFunction         = "42883"
+	CodeUndefinedTable            = "42P01"
+	CodeUndefinedObject           = "42704"
+	CodeAmbiguousColumn           = "42702"
+	CodeAmbiguousFunction         = "42725"
+	CodeDatatypeMismatch          = "42804"
+	CodeGeneratedAlways           = "428C9"
+	CodeCannotCoerce              = "42846"
+	CodeInvalidColumnReference    = "42P10"
+	CodeGroupingError             = "42803"
+	CodeDuplicateColumn           = "42701"
+	CodeDuplicateObject           = "42710"
+	CodeDuplicateTable            = "42P07"
+	CodeDuplicateSchema           = "42P06"
+	CodeDuplicateDatabase         = "42P04"
+	CodeDuplicateAlias            = "42712"
+	CodeInvalidTableDefinition    = "42P16"
+	CodeInvalidForeignKey         = "42830"
+	CodeDependentObjectsExist     = "2BP01"
+	CodeUndefinedParameter        = "42P02"
+	CodeIndeterminateDatatype     = "42P18"
+	CodeDuplicatePreparedStmt     = "42P05"
+	CodeInvalidSQLStatementName   = "26000"
+	CodeInvalidCursorName         = "34000"
+	CodeDuplicateCursor           = "42P03"
+	CodeProtocolViolation         = "08P01"
+	CodeInvalidAuthorization      = "28000"
+	CodeInvalidPassword           = "28P01"
+	CodeWrongObjectType           = "42809"
+	CodeDuplicateFunction         = "42723"
+	CodeInvalidFunctionDefinition = "42P13"
+	CodeInvalidSchemaName         = "3F000"
+	CodeInvalidCatalogName        = "3D000"
+	CodeInsufficientPrivilege     = "42501"
+	CodeInvalidGrantOperation     = "0LP01"
+	CodeReservedName              = "42939"
+	CodeInvalidObjectDefinition   = "42P17"
+	CodeInvalidRecursion          = "42P19"
+	CodeWindowingError            = "42P20"
+)
+
+// Error is an error with a SQLSTATE code and optional detail fields.
+type Error struct {
+	Code    string
+	Message string
+	Detail  string
+	Hint    string
+	// Position is the 1-based character offset of the error in the query
+	// text, or zero if unknown.
+	Position int
+	// Table, Column and Constraint name the objects an integrity
+	// constraint violation concerns, so clients can tell violations apart
+	// without parsing the message.
+	Table      string
+	Column     string
+	Constraint string
+}
+
+// New returns an error with the given code and message.
+func New(code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Newf returns an error with the given code and a formatted message.
+func Newf(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithDetail sets the detail field and returns the error.
+func (e *Error) WithDetail(detail string) *Error {
+	e.Detail = detail
+	return e
+}
+
+// WithHint sets the hint field and returns the error.
+func (e *Error) WithHint(hint string) *Error {
+	e.Hint = hint
+	return e
+}
+
+// WithPosition sets the position field and returns the error.
+func (e *Error) WithPosition(pos int) *Error {
+	e.Position = pos
+	return e
+}
+
+// WithTable sets the table name field and returns the error.
+func (e *Error) WithTable(table string) *Error {
+	e.Table = table
+	return e
+}
+
+// WithColumn sets the column name field and returns the error.
+func (e *Error) WithColumn(column string) *Error {
+	e.Column = column
+	return e
+}
+
+// WithConstraint sets the constraint name field and returns the error.
+func (e *Error) WithConstraint(constraint string) *Error {
+	e.Constraint = constraint
+	return e
+}
+
+// GetCode returns the SQLSTATE code of err, or CodeInternalError if err
+// does not carry one.
+func GetCode(err error) string {
+	var pgErr *Error
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return CodeInternalError
+}
+
+// Flatten converts any error into an *Error, preserving codes when present.
+func Flatten(err error) *Error {
+	var pgErr *Error
+	if errors.As(err, &pgErr) {
+		return pgErr
+	}
+	return &Error{Code: CodeInternalError, Message: err.Error()}
+}
@@ -0,0 +1,227 @@
+package planner
+
+import (
+	"strconv"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// aggScope is the state of type checking the expressions of a grouped
+// query that are computed after aggregation: the select list and HAVING.
+// Their column references must be grouping expressions, and the aggregate
+// calls within them are collected for the Aggregate node to compute.
+type aggScope struct {
+	// input is the scope of the rows being aggregated.
+	input *scope
+	node  *Aggregate
+}
+
+// isGrouped reports whether a SELECT aggregates its input.
+func (p *Planner) isGrouped(s *parser.SelectStmt) bool {
+	if len(s.GroupBy) > 0 || s.Having != nil {
+		return true
+	}
+	for _, t := range s.Targets {
+		if p.containsAggregate(t.Expr) {
+			return true
+		}
+	}
+	for _, w := range s.Windows {
+		for _, e := range w.Exprs() {
+			if p.containsAggregate(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsAggregate reports whether e calls an aggregate, other than over
+// a window.
+func (p *Planner) containsAggregate(e parser.Expr) bool {
+	found := false
+	parser.Walk(e, func(e parser.Expr) bool {
+		if f, ok := e.(*parser.FuncCall); ok && f.Over == nil && p.Registry.IsAggregate(f.Name) {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// planGroupBy returns the Aggregate node grouping input by the GROUP BY
+// clause of s, and the scope in which to type check the expressions
+// evaluated over its output.
+func (p *Planner) planGroupBy(input Node, s *parser.SelectStmt, sc *scope) (*Aggregate, *scope, error) {
+	n := &Aggregate{Input: input}
+	groupScope := sc.withoutAggregates("aggregate functions are not allowed in GROUP BY").
+		withoutWindows("window functions are not allowed in GROUP BY")
+	for _, g := range s.GroupBy {
+		e, err := p.typeCheckGroupBy(g, s.Targets, groupScope)
+		if err != nil {
+			return nil, nil, err
+		}
+		if e.ResolvedType().Family == types.UnknownFamily {
+			if e, err = eval.Coerce(e, types.String); err != nil {
+				return nil, nil, err
+			}
+		}
+		n.GroupBy = append(n.GroupBy, e)
+	}
+	return n, &scope{agg: &aggScope{input: sc, node: n}}, nil
+}
+
+// typeCheckGroupBy resolves a GROUP BY item. As in PostgreSQL, an integer
+// constant refers to a select list position, and a bare name that is not
+// an input column may refer to an output column alias.
+func (p *Planner) typeCheckGroupBy(g parser.Expr, targets []*parser.SelectTarget, sc *scope) (eval.Expr, error) {
+	switch g := g.(type) {
+	case *parser.NumberLit:
+		pos, err := strconv.Atoi(g.Text)
+		if err != nil {
+			break
+		}
+		if pos < 1 || pos > len(targets) {
+			return nil, pgerror.Newf(pgerror.CodeInvalidColumnReference, "GROUP BY position %d is not in select list", pos)
+		}
+		if _, ok := targets[pos-1].Expr.(*parser.Star); ok {
+			return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "GROUP BY position of * is not supported")
+		}
+		return p.typeCheck(targets[pos-1].Expr, sc)
+	case *parser.ColumnRef:
+		if g.Table != "" {
+			break
+		}
+		if _, _, err := sc.resolve("", g.Column); err == nil {
+			break
+		}
+		for _, t := range targets {
+			if t.Alias == g.Column {
+				return p.typeCheck(t.Expr, sc)
+			}
+		}
+	}
+	return p.typeCheck(g, sc)
+}
+
+// typeCheckGrouped type checks e where it is evaluated over the output of
+// an Aggregate. It handles aggregate calls, expressions matching a
+// grouping expression and constants; ok is false when e must instead be
+// checked piecewise, its sub-expressions being resolved in turn.
+func (p *Planner) typeCheckGrouped(e parser.Expr, s *scope) (out eval.Expr, ok bool, err error) {
+	a := s.agg
+	if f, isFunc := e.(*parser.FuncCall); isFunc && f.Over == nil && p.Registry.IsAggregate(f.Name) {
+		call, err := p.typeCheckAggregate(f, a.input)
+		if err != nil {
+			return nil, true, err
+		}
+		return a.aggregateRef(call), true, nil
+	}
+	// Subqueries refer to grouping columns by their position in the
+	// Aggregate's output, so they are resolved in s, as are window
+	// function calls, which are computed over it.
+	if p.containsAggregate(e) || hasSubquery(e) || containsWindowCall(e) {
+		return nil, false, nil
+	}
+	x, err := p.typeCheck(e, a.input)
+	if err != nil {
+		return nil, true, err
+	}
+	if ref := a.groupRef(x); ref != nil {
+		return ref, true, nil
+	}
+	if ref, isRef := x.(*eval.ColumnRef); isRef {
+		return nil, true, ungroupedColumn(a.input.cols[ref.Idx])
+	}
+	refersToInput := false
+	eval.Walk(x, func(e eval.Expr) bool {
+		if _, isRef := e.(*eval.ColumnRef); isRef {
+			refersToInput = true
+		}
+		return !refersToInput
+	})
+	if !refersToInput {
+		return x, true, nil
+	}
+	return nil, false, nil
+}
+
+func ungroupedColumn(c scopeColumn) error {
+	name := c.name
+	if c.table != "" {
+		name = c.table + "." + name
+	}
+	return pgerror.Newf(pgerror.CodeGroupingError,
+		"column %q must appear in the GROUP BY clause or be used in an aggregate function", name)
+}
+
+// typeCheckAggregate resolves an aggregate call whose arguments are
+// evaluated over the input rows described by s.
+func (p *Planner) typeCheckAggregate(f *parser.FuncCall, s *scope) (*eval.AggregateCall, error) {
+	if !f.Star && len(f.Args) == 0 {
+		return nil, pgerror.Newf(pgerror.CodeWrongObjectType,
+			"%s(*) must be used to call a parameterless aggregate function", f.Name)
+	}
+	args, err := p.typeCheckList(f.Args, s.withoutAggregates("aggregate function calls cannot be nested").
+		withoutWindows("aggregate function calls cannot contain window function calls"))
+	if err != nil {
+		return nil, err
+	}
+	return p.Registry.NewAggregateCall(f.Name, args, f.Distinct)
+}
+
+// groupRef returns a reference to the grouping expression matching e, or
+// nil if there is none.
+func (a *aggScope) groupRef(e eval.Expr) eval.Expr {
+	for i, g := range a.node.GroupBy {
+		if sameExpr(e, g) {
+			return &eval.ColumnRef{Idx: i, Name: g.String(), Typ: g.ResolvedType()}
+		}
+	}
+	return nil
+}
+
+// aggregateRef returns a reference to the result of call, adding it to the
+// Aggregate node unless an identical call was already added.
+func (a *aggScope) aggregateRef(call *eval.AggregateCall) eval.Expr {
+	n := a.node
+	idx := -1
+	for i, c := range n.Aggs {
+		if c.Name == call.Name && c.Distinct == call.Distinct && c.Agg == call.Agg && sameExprs(c.Args, call.Args) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		idx = len(n.Aggs)
+		n.Aggs = append(n.Aggs, call)
+	}
+	return &eval.ColumnRef{Idx: len(n.GroupBy) + idx, Name: call.String(), Typ: call.Typ}
+}
+
+// sameExpr reports whether a and b compute the same value.
+func sameExpr(a, b eval.Expr) bool {
+	if ra, ok := a.(*eval.ColumnRef); ok {
+		rb, ok := b.(*eval.ColumnRef)
+		return ok && ra.Idx == rb.Idx
+	}
+	if a.String() != b.String() || a.ResolvedType().Oid != b.ResolvedType().Oid {
+		return false
+	}
+	return sameExprs(eval.Children(a), eval.Children(b))
+}
+
+func sameExprs(a, b []eval.Expr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !sameExpr(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,411 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// typeCheckDefault type checks the DEFAULT expression of column c and
+// coerces it to the column's type.
+func (p *Planner) typeCheckDefault(e parser.Expr, c *catalog.Column) (eval.Expr, error) {
+	x, err := p.typeCheck(e, &scope{
+		noAggs:    "aggregate functions are not allowed in DEFAULT expressions",
+		noWindows: "window functions are not allowed in DEFAULT expressions",
+		noColumns: "cannot use column reference in DEFAULT expression",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return coerceForAssignment(x, c.Type, c.Name)
+}
+
+// columnDefault returns the DEFAULT expression of c, or nil if it has none.
+func (p *Planner) columnDefault(c *catalog.Column) (eval.Expr, error) {
+	if c.Default == "" {
+		return nil, nil
+	}
+	e, err := parser.ParseExpr(c.Default)
+	if err != nil {
+		return nil, err
+	}
+	return p.typeCheckDefault(e, c)
+}
+
+// setDefault checks the DEFAULT expression e of c and stores it in c.
+func (p *Planner) setDefault(c *catalog.Column, e parser.Expr) (eval.Expr, error) {
+	x, err := p.typeCheckDefault(e, c)
+	if err != nil {
+		return nil, err
+	}
+	c.Default = e.String()
+	return x, nil
+}
+
+// primaryKeyExists is the error for adding a primary key to t, which has
+// one already, if only the hidden rowid.
+func primaryKeyExists(t *catalog.Table) error {
+	if t.Columns[t.ColumnOrdinal(t.PrimaryIndex.ColumnIDs[0])].Hidden {
+		return pgerror.Newf(pgerror.CodeFeatureNotSupported,
+			"cannot add a primary key to table %q, which was created without one", t.Name).
+			WithHint("Changing the primary key of a table is not supported.")
+	}
+	return pgerror.Newf(pgerror.CodeInvalidTableDefinition,
+		"multiple primary keys for table %q are not allowed", t.Name)
+}
+
+// notIdentityColumn is the error for changing the identity of column c of
+// t, which is not an identity column.
+func notIdentityColumn(t *catalog.Table, c *catalog.Column) error {
+	return pgerror.Newf(pgerror.CodeObjectNotInPrerequisite,
+		"column %q of relation %q is not an identity column", c.Name, t.Name)
+}
+
+func (p *Planner) planAlterTable(s *parser.AlterTableStmt) (Node, error) {
+	old, err := p.findTable(s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if old == nil {
+		if s.IfExists {
+			return &AlterTable{}, nil
+		}
+		return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", s.Name.String())
+	}
+	t := old.Clone()
+	n := &AlterTable{Old: old, Table: t}
+	// Later commands may drop or add columns, so fills and checks are
+	// compiled against the final table.
+	fills := make(map[catalog.ColumnID]eval.Expr)
+	var validate, validateFKs []string
+	addCheck := func(c *parser.TableConstraint) error {
+		ck, err := p.addCheck(t, c)
+		if err == nil {
+			validate = append(validate, ck.Name)
+		}
+		return err
+	}
+	addForeignKey := func(c *parser.TableConstraint) error {
+		fk, err := p.addForeignKey(t, c)
+		if err == nil {
+			validateFKs = append(validateFKs, fk.Name)
+		}
+		return err
+	}
+	column := func(name string) (int, error) {
+		ord := t.FindColumn(name)
+		if ord < 0 {
+			return 0, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q of relation %q does not exist", name, t.Name)
+		}
+		return ord, nil
+	}
+	for _, cmd := range s.Cmds {
+		switch cmd := cmd.(type) {
+		case *parser.AddColumn:
+			def := cmd.Column
+			if t.FindColumn(def.Name) >= 0 {
+				if cmd.IfNotExists {
+					continue
+				}
+				return nil, pgerror.Newf(pgerror.CodeDuplicateColumn,
+					"column %q of relation %q already exists", def.Name, t.Name)
+			}
+			if def.PrimaryKey {
+				return nil, primaryKeyExists(t)
+			}
+			c, seq, err := p.addColumn(t, def)
+			if err != nil {
+				return nil, err
+			}
+			if seq != nil {
+				n.CreateSequences = append(n.CreateSequences, seq)
+			}
+			switch {
+			case def.Generated != nil:
+				if fills[c.ID], err = p.setGenerated(t, c, def.Generated); err != nil {
+					return nil, err
+				}
+			case c.Default != "":
+				if fills[c.ID], err = p.columnDefault(c); err != nil {
+					return nil, err
+				}
+			}
+			if def.Unique {
+				name := catalog.DefaultIndexName(t.Name, []string{def.Name}, "key")
+				t.AddIndex(name, true, []catalog.ColumnID{c.ID})
+			}
+			for _, ck := range def.Checks {
+				if err := addCheck(ck); err != nil {
+					return nil, err
+				}
+			}
+			for _, fk := range def.ForeignKeys {
+				if err := addForeignKey(fk); err != nil {
+					return nil, err
+				}
+			}
+		case *parser.DropColumn:
+			ord := t.FindColumn(cmd.Name)
+			if ord < 0 {
+				if cmd.IfExists {
+					continue
+				}
+				return nil, pgerror.Newf(pgerror.CodeUndefinedColumn,
+					"column %q of relation %q does not exist", cmd.Name, t.Name)
+			}
+			id := t.Columns[ord].ID
+			if t.TTL != nil && t.TTL.ColumnID == id {
+				return nil, pgerror.Newf(pgerror.CodeDependentObjectsExist,
+					"cannot drop column %q because it holds the expiration of the rows of table %q", cmd.Name, t.Name).
+					WithHint(fmt.Sprintf("Use ALTER TABLE ... RESET (%s) instead.", paramTTL))
+			}
+			dep, err := generatedDependent(t, cmd.Name)
+			if err != nil {
+				return nil, err
+			}
+			if dep != nil {
+				return nil, pgerror.Newf(pgerror.CodeDependentObjectsExist,
+					"cannot drop column %s of table %s because other objects depend on it", cmd.Name, t.Name).
+					WithDetail(fmt.Sprintf("column %s of table %s depends on column %s", dep.Name, t.Name, cmd.Name)).
+					WithHint("Use DROP ... CASCADE to drop the dependent objects too.")
+			}
+			if slices.Contains(t.PrimaryIndex.ColumnIDs, id) {
+				return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported,
+					"cannot drop column %q because it is part of the primary key", cmd.Name).
+					WithHint("Changing the primary key of a table is not supported.")
+			}
+			// As in PostgreSQL, indexes and constraints on the column are
+			// dropped with it.
+			t.Indexes = slices.DeleteFunc(t.Indexes, func(idx *catalog.Index) bool {
+				return slices.Contains(idx.ColumnIDs, id)
+			})
+			t.Checks = slices.DeleteFunc(t.Checks, func(ck *catalog.Check) bool {
+				return slices.Contains(ck.ColumnIDs, id)
+			})
+			t.ForeignKeys = slices.DeleteFunc(t.ForeignKeys, func(fk *catalog.ForeignKey) bool {
+				return slices.Contains(fk.ColumnIDs, id)
+			})
+			keys, err := p.referencingKeys(t)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range keys {
+				if slices.Contains(k.fk.ReferencedColumnIDs, id) {
+					obj := fmt.Sprintf("column %s of table %s", cmd.Name, t.Name)
+					return nil, dependentObjects(obj, obj, k)
+				}
+			}
+			owned, err := p.ownedSequences(t, func(c catalog.ColumnID) bool { return c == id })
+			if err != nil {
+				return nil, err
+			}
+			n.DropSequences = append(n.DropSequences, owned...)
+			t.Columns = slices.Delete(t.Columns, ord, ord+1)
+		case *parser.RenameColumn:
+			ord, err := column(cmd.Name)
+			if err != nil {
+				return nil, err
+			}
+			if t.FindColumn(cmd.NewName) >= 0 {
+				return nil, pgerror.Newf(pgerror.CodeDuplicateColumn,
+					"column %q of relation %q already exists", cmd.NewName, t.Name)
+			}
+			c := t.Columns[ord]
+			for _, ck := range t.Checks {
+				if slices.Contains(ck.ColumnIDs, c.ID) {
+					if ck.Expr, err = renameColumnRefs(ck.Expr, c.Name, cmd.NewName); err != nil {
+						return nil, err
+					}
+				}
+			}
+			for _, g := range t.Columns {
+				if g.Generated != "" {
+					if g.Generated, err = renameColumnRefs(g.Generated, c.Name, cmd.NewName); err != nil {
+						return nil, err
+					}
+				}
+			}
+			c.Name = cmd.NewName
+		case *parser.RenameTable:
+			exists, err := p.relationExists(t.Schema, cmd.NewName)
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				return nil, pgerror.Newf(pgerror.CodeDuplicateTable, "relation %q already exists", cmd.NewName)
+			}
+			t.Name = cmd.NewName
+		case *parser.SetDefault:
+			ord, err := column(cmd.Column)
+			if err != nil {
+				return nil, err
+			}
+			c := t.Columns[ord]
+			if c.Generated != "" {
+				return nil, pgerror.Newf(pgerror.CodeWrongObjectType,
+					"column %q of relation %q is a generated column", c.Name, t.Name)
+			}
+			if c.Identity != "" {
+				err := pgerror.Newf(pgerror.CodeSyntaxError, "column %q of relation %q is an identity column", c.Name, t.Name)
+				if cmd.Default == nil {
+					err = err.WithHint("Use ALTER TABLE ... ALTER COLUMN ... DROP IDENTITY instead.")
+				}
+				return nil, err
+			}
+			if cmd.Default == nil {
+				c.Default = ""
+			} else if _, err := p.setDefault(c, cmd.Default); err != nil {
+				return nil, err
+			}
+		case *parser.AddIdentity:
+			ord, err := column(cmd.Column)
+			if err != nil {
+				return nil, err
+			}
+			c := t.Columns[ord]
+			switch {
+			case c.Identity != "":
+				return nil, pgerror.Newf(pgerror.CodeObjectNotInPrerequisite,
+					"column %q of relation %q is already an identity column", c.Name, t.Name)
+			case c.Default != "" || c.Generated != "":
+				return nil, pgerror.Newf(pgerror.CodeObjectNotInPrerequisite,
+					"column %q of relation %q already has a default value", c.Name, t.Name)
+			}
+			// The column becomes NOT NULL, which existing rows are checked
+			// against. Its existing values are kept, and the sequence
+			// starts at its START regardless.
+			seq, err := p.addIdentity(t, c, cmd.Identity)
+			if err != nil {
+				return nil, err
+			}
+			n.CreateSequences = append(n.CreateSequences, seq)
+		case *parser.SetIdentity:
+			ord, err := column(cmd.Column)
+			if err != nil {
+				return nil, err
+			}
+			c := t.Columns[ord]
+			if c.Identity == "" {
+				return nil, notIdentityColumn(t, c)
+			}
+			c.Identity = catalog.IdentityByDefault
+			if cmd.Always {
+				c.Identity = catalog.IdentityAlways
+			}
+		case *parser.DropIdentity:
+			ord, err := column(cmd.Column)
+			if err != nil {
+				return nil, err
+			}
+			c := t.Columns[ord]
+			if c.Identity == "" {
+				if cmd.IfExists {
+					continue
+				}
+				return nil, notIdentityColumn(t, c)
+			}
+			c.Identity, c.Default = "", ""
+			owned, err := p.ownedSequences(t, func(id catalog.ColumnID) bool { return id == c.ID })
+			if err != nil {
+				return nil, err
+			}
+			n.DropSequences = append(n.DropSequences, owned...)
+		case *parser.AddConstraint:
+			c := cmd.Constraint
+			switch {
+			case c.Check != nil:
+				if err := addCheck(c); err != nil {
+					return nil, err
+				}
+			case c.References != nil:
+				if err := addForeignKey(c); err != nil {
+					return nil, err
+				}
+			case c.PrimaryKey:
+				return nil, primaryKeyExists(t)
+			default:
+				ids := make([]catalog.ColumnID, len(c.Columns))
+				for i, name := range c.Columns {
+					ord := t.FindColumn(name)
+					if ord < 0 {
+						return nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q named in key does not exist", name)
+					}
+					ids[i] = t.Columns[ord].ID
+				}
+				name := c.Name
+				if name == "" {
+					name = catalog.DefaultIndexName(t.Name, c.Columns, "key")
+				}
+				t.AddIndex(name, true, ids).Descending = descending(c.Desc)
+			}
+		case *parser.DropConstraint:
+			switch idx := t.FindIndex(cmd.Name); {
+			case t.FindCheck(cmd.Name) != nil:
+				t.Checks = slices.DeleteFunc(t.Checks, func(ck *catalog.Check) bool { return ck.Name == cmd.Name })
+			case t.FindForeignKey(cmd.Name) != nil:
+				t.ForeignKeys = slices.DeleteFunc(t.ForeignKeys, func(fk *catalog.ForeignKey) bool { return fk.Name == cmd.Name })
+			case idx == t.PrimaryIndex:
+				return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported,
+					"cannot drop constraint %q on table %q", cmd.Name, t.Name).
+					WithHint("Changing the primary key of a table is not supported.")
+			case idx != nil && idx.Unique:
+				keys, err := p.referencingKeys(t)
+				if err != nil {
+					return nil, err
+				}
+				for _, k := range keys {
+					if referencedIndex(t, k.fk.ReferencedColumnIDs) == idx {
+						return nil, dependentObjects(fmt.Sprintf("constraint %s on table %s", cmd.Name, t.Name), "index "+cmd.Name, k)
+					}
+				}
+				t.Indexes = slices.DeleteFunc(t.Indexes, func(x *catalog.Index) bool { return x == idx })
+			case !cmd.IfExists:
+				return nil, pgerror.Newf(pgerror.CodeUndefinedObject,
+					"constraint %q of relation %q does not exist", cmd.Name, t.Name)
+			}
+		case *parser.SetStorageParams:
+			c, err := setTableParams(t, cmd.Params)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				// The existing rows expire as if written now.
+				if fills[c.ID], err = p.columnDefault(c); err != nil {
+					return nil, err
+				}
+			}
+		case *parser.ResetStorageParams:
+			if err := resetTableParams(t, cmd.Names); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for ord, c := range t.Columns {
+		if e, ok := fills[c.ID]; ok {
+			n.Fills = append(n.Fills, ColumnFill{Ord: ord, Expr: e})
+		}
+	}
+	checks, err := p.tableChecks(t)
+	if err != nil {
+		return nil, err
+	}
+	for _, ck := range checks {
+		if slices.Contains(validate, ck.Name) {
+			n.Validate = append(n.Validate, ck)
+		}
+	}
+	for _, name := range validateFKs {
+		if fk := t.FindForeignKey(name); fk != nil {
+			f, err := p.foreignKey(t, fk)
+			if err != nil {
+				return nil, err
+			}
+			n.ValidateForeignKeys = append(n.ValidateForeignKeys, f)
+		}
+	}
+	return n, nil
+}
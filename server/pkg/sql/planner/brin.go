@@ -0,0 +1,132 @@
+package planner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// brinFamilies are the families of the types a BRIN index can summarize,
+// whose values are ordered so that a range can keep their minimum and
+// maximum.
+var brinFamilies = map[types.Family]bool{
+	types.IntFamily:         true,
+	types.FloatFamily:       true,
+	types.DecimalFamily:     true,
+	types.StringFamily:      true,
+	types.CITextFamily:      true,
+	types.BytesFamily:       true,
+	types.DateFamily:        true,
+	types.TimestampFamily:   true,
+	types.TimestampTZFamily: true,
+	types.IntervalFamily:    true,
+	types.InetFamily:        true,
+	types.MacaddrFamily:     true,
+	types.MoneyFamily:       true,
+	types.BitFamily:         true,
+}
+
+// brinParams checks a BRIN index definition and returns its parameters.
+func brinParams(s *parser.CreateIndexStmt, t *catalog.Table) (*catalog.BRINParams, error) {
+	if s.Unique {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, `access method "brin" does not support unique indexes`)
+	}
+	for i, name := range s.Columns {
+		col := t.Columns[t.FindColumn(name)]
+		if s.OpClasses[i] != "" {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedObject,
+				`operator class %q does not exist for access method "brin"`, s.OpClasses[i])
+		}
+		if !brinFamilies[col.Type.Family] || col.Type.Extension() != nil {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedObject,
+				`data type %s has no default operator class for access method "brin"`, col.Type)
+		}
+	}
+	params := &catalog.BRINParams{PagesPerRange: 128}
+	for _, w := range s.With {
+		if w.Name != "pages_per_range" {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", w.Name)
+		}
+		v, err := strconv.Atoi(w.Value)
+		if err != nil {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"invalid value for integer option %q: %s", w.Name, w.Value)
+		}
+		if v < 1 || v > 131072 {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"value %d out of bounds for option %q", v, w.Name).
+				WithDetail(`Valid values are between "1" and "131072".`)
+		}
+		params.PagesPerRange = v
+	}
+	return params, nil
+}
+
+// planBRINScan returns a scan of the ranges of the first BRIN index on a
+// column of which the filter of scan has constraints, or nil if there is
+// none.
+func planBRINScan(scan *Scan) *BRINScan {
+	t := scan.Table
+	var cons map[int]*colConstraint
+	for _, idx := range t.Indexes {
+		if idx.BRIN == nil {
+			continue
+		}
+		if cons == nil {
+			cons = constraints(t, scan.Filter, nil)
+		}
+		n := &BRINScan{Table: t, Index: idx, Filter: scan.Filter}
+		for i, ord := range t.ColumnOrdinals(idx) {
+			c := cons[ord]
+			if c == nil || !c.bound() || (c.eq == nil && c.lo == nil && c.hi == nil) {
+				continue
+			}
+			n.Bounds = append(n.Bounds, Bound{
+				Col: i, Eq: c.eq,
+				Lo: c.lo, Hi: c.hi, LoInc: c.loInc, HiInc: c.hiInc,
+				Desc: boundDesc(t.Columns[ord].Name, c),
+			})
+		}
+		if len(n.Bounds) > 0 {
+			return n
+		}
+	}
+	return nil
+}
+
+// boundDesc describes the constraint c on the column name for EXPLAIN.
+func boundDesc(name string, c *colConstraint) string {
+	switch {
+	case len(c.eq) == 1 && c.eq[0] == types.DNull:
+		return name + " IS NULL"
+	case len(c.eq) == 1:
+		return name + " = " + formatDatum(c.eq[0])
+	case c.eq != nil:
+		vals := make([]string, len(c.eq))
+		for i, d := range c.eq {
+			vals[i] = formatDatum(d)
+		}
+		return fmt.Sprintf("%s IN (%s)", name, strings.Join(vals, ", "))
+	}
+	var parts []string
+	if c.lo != nil {
+		op := " > "
+		if c.loInc {
+			op = " >= "
+		}
+		parts = append(parts, name+op+formatDatum(c.lo))
+	}
+	if c.hi != nil {
+		op := " < "
+		if c.hiInc {
+			op = " <= "
+		}
+		parts = append(parts, name+op+formatDatum(c.hi))
+	}
+	return strings.Join(parts, " AND ")
+}
@@ -0,0 +1,101 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// typeCheckCheck type checks the condition of a CHECK constraint over the
+// columns of t.
+func (p *Planner) typeCheckCheck(e parser.Expr, t *catalog.Table) (eval.Expr, error) {
+	if containsSubquery(e) {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "cannot use subquery in check constraint")
+	}
+	return p.typeCheckPredicate(e, tableScope(t, ""), "CHECK constraint")
+}
+
+// addCheck checks the condition of the CHECK constraint c and adds it to
+// t, naming it if c is unnamed.
+func (p *Planner) addCheck(t *catalog.Table, c *parser.TableConstraint) (Check, error) {
+	x, err := p.typeCheckCheck(c.Check, t)
+	if err != nil {
+		return Check{}, err
+	}
+	// Store the condition with unqualified column references, so that it
+	// survives renaming the table.
+	e, err := parser.ParseExpr(c.Check.String())
+	if err != nil {
+		return Check{}, err
+	}
+	parser.Walk(e, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			ref.Table = ""
+		}
+		return true
+	})
+	cols := columnRefs(e)
+	ck := &catalog.Check{Name: c.Name, Expr: e.String()}
+	for _, name := range cols {
+		ck.ColumnIDs = append(ck.ColumnIDs, t.Columns[t.FindColumn(name)].ID)
+	}
+	if ck.Name == "" {
+		// As in PostgreSQL, the name includes the column when the
+		// condition refers to exactly one.
+		base := t.Name + "_check"
+		if len(cols) == 1 {
+			base = t.Name + "_" + cols[0] + "_check"
+		}
+		ck.Name = base
+		for i := 1; t.FindCheck(ck.Name) != nil; i++ {
+			ck.Name = fmt.Sprintf("%s%d", base, i)
+		}
+	} else if t.HasConstraint(ck.Name) {
+		return Check{}, pgerror.Newf(pgerror.CodeDuplicateObject,
+			"constraint %q for relation %q already exists", ck.Name, t.Name)
+	}
+	t.Checks = append(t.Checks, ck)
+	return Check{Name: ck.Name, Expr: x}, nil
+}
+
+// tableChecks compiles the CHECK constraints of t. Like PostgreSQL, it
+// orders them by name, which decides the constraint a row violating
+// several is reported against.
+func (p *Planner) tableChecks(t *catalog.Table) ([]Check, error) {
+	checks := make([]Check, len(t.Checks))
+	for i, ck := range t.Checks {
+		e, err := parser.ParseExpr(ck.Expr)
+		if err != nil {
+			return nil, err
+		}
+		x, err := p.typeCheckCheck(e, t)
+		if err != nil {
+			return nil, err
+		}
+		checks[i] = Check{Name: ck.Name, Expr: x}
+	}
+	slices.SortFunc(checks, func(a, b Check) int { return strings.Compare(a.Name, b.Name) })
+	return checks, nil
+}
+
+// renameColumnRefs rewrites the SQL text of an expression stored with a
+// table, such as a CHECK condition, for the column renamed from old to
+// new.
+func renameColumnRefs(expr, old, new string) (string, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return "", err
+	}
+	parser.Walk(e, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok && ref.Column == old {
+			ref.Column = new
+		}
+		return true
+	})
+	return e.String(), nil
+}
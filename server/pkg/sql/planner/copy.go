@@ -0,0 +1,168 @@
+package planner
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// errorTableColumns are the columns of a COPY error table that a rejected
+// row is stored in: its line number, the error and the line itself.
+var errorTableColumns = []string{"line_number", "error", "raw_line"}
+
+func (p *Planner) planCopy(s *parser.CopyStmt) (Node, error) {
+	t, err := p.lookupTable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	var targets []int
+	if s.Columns != nil {
+		if targets, err = resolveColumns(t, s.Columns); err != nil {
+			return nil, err
+		}
+		for _, ord := range targets {
+			if c := t.Columns[ord]; c.Generated != "" {
+				return nil, generatedColumnError(c, false)
+			}
+		}
+	} else {
+		for i, c := range t.Columns {
+			if !c.Hidden && c.Generated == "" {
+				targets = append(targets, i)
+			}
+		}
+	}
+	n := &Copy{Source: CopySource{File: s.File}}
+	if n.Insert, err = p.newInsert(t, nil, targets); err != nil {
+		return nil, err
+	}
+	var errorTable string
+	if errorTable, err = copyOptions(&n.Source, s.Options); err != nil {
+		return nil, err
+	}
+	if errorTable != "" {
+		et, err := p.mustFindTable(&parser.TableName{Name: errorTable})
+		if err != nil {
+			return nil, err
+		}
+		ords, err := resolveColumns(et, errorTableColumns)
+		if err != nil {
+			return nil, err
+		}
+		if n.Errors, err = p.newInsert(et, nil, ords); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// copyOptions sets the options of src from those of COPY, returning the
+// name of the error table of ERROR_TABLE if it is given.
+func copyOptions(src *CopySource, opts []parser.CopyOption) (errorTable string, err error) {
+	seen := make(map[string]bool)
+	var delimiter, null, quote, escape *string
+	onError := ""
+	for _, opt := range opts {
+		if seen[opt.Name] {
+			return "", pgerror.New(pgerror.CodeSyntaxError, "conflicting or redundant options")
+		}
+		seen[opt.Name] = true
+		switch opt.Name {
+		case "format":
+			switch strings.ToLower(opt.Value) {
+			case "text":
+			case "csv":
+				src.CSV = true
+			default:
+				return "", pgerror.Newf(pgerror.CodeInvalidParameterValue, "COPY format %q not recognized", opt.Value)
+			}
+		case "header":
+			if src.Header, err = copyBool(opt); err != nil {
+				return "", err
+			}
+		case "delimiter":
+			delimiter = &opt.Value
+		case "null":
+			null = &opt.Value
+		case "quote":
+			quote = &opt.Value
+		case "escape":
+			escape = &opt.Value
+		case "on_error":
+			onError = strings.ToLower(opt.Value)
+			if onError != "stop" && onError != "ignore" {
+				return "", pgerror.Newf(pgerror.CodeInvalidParameterValue, "COPY ON_ERROR %q not recognized", opt.Value)
+			}
+			src.IgnoreErrors = onError == "ignore"
+		case "reject_limit":
+			n, err := strconv.ParseInt(opt.Value, 10, 64)
+			if err != nil || n <= 0 {
+				return "", pgerror.Newf(pgerror.CodeInvalidParameterValue, "REJECT_LIMIT (%s) must be greater than zero", opt.Value)
+			}
+			src.RejectLimit = n
+		case "error_table":
+			if opt.Value == "" {
+				return "", pgerror.New(pgerror.CodeSyntaxError, "ERROR_TABLE requires a table name")
+			}
+			errorTable = opt.Value
+		default:
+			return "", pgerror.Newf(pgerror.CodeSyntaxError, "option %q not recognized", opt.Name)
+		}
+	}
+	if !src.IgnoreErrors {
+		switch {
+		case src.RejectLimit > 0:
+			return "", pgerror.New(pgerror.CodeInvalidParameterValue, "COPY REJECT_LIMIT requires ON_ERROR to be set to IGNORE")
+		case errorTable != "":
+			return "", pgerror.New(pgerror.CodeInvalidParameterValue, "COPY ERROR_TABLE requires ON_ERROR to be set to IGNORE")
+		}
+	}
+	src.Delimiter, src.Null = '\t', `\N`
+	if src.CSV {
+		src.Delimiter, src.Null, src.Quote = ',', "", '"'
+	}
+	for _, o := range []struct {
+		name string
+		val  *string
+		dst  *byte
+	}{{"delimiter", delimiter, &src.Delimiter}, {"quote", quote, &src.Quote}, {"escape", escape, &src.Escape}} {
+		if o.val == nil {
+			continue
+		}
+		if o.name != "delimiter" && !src.CSV {
+			return "", pgerror.Newf(pgerror.CodeFeatureNotSupported, "COPY %s requires CSV mode", strings.ToUpper(o.name))
+		}
+		if len(*o.val) != 1 {
+			return "", pgerror.Newf(pgerror.CodeFeatureNotSupported, "COPY %s must be a single one-byte character", o.name)
+		}
+		*o.dst = (*o.val)[0]
+	}
+	if src.Escape == 0 {
+		src.Escape = src.Quote
+	}
+	if null != nil {
+		src.Null = *null
+	}
+	switch {
+	case src.Delimiter == '\n' || src.Delimiter == '\r':
+		return "", pgerror.New(pgerror.CodeInvalidParameterValue, "COPY delimiter cannot be newline or carriage return")
+	case src.CSV && src.Delimiter == src.Quote:
+		return "", pgerror.New(pgerror.CodeInvalidParameterValue, "COPY delimiter and quote must be different")
+	case strings.IndexByte(src.Null, src.Delimiter) >= 0:
+		return "", pgerror.New(pgerror.CodeInvalidParameterValue, "COPY delimiter character must not appear in the NULL specification")
+	}
+	return errorTable, nil
+}
+
+// copyBool returns the Boolean value of opt, which is true if it has none.
+func copyBool(opt parser.CopyOption) (bool, error) {
+	switch strings.ToLower(opt.Value) {
+	case "", "true", "on", "1":
+		return true, nil
+	case "false", "off", "0":
+		return false, nil
+	}
+	return false, pgerror.Newf(pgerror.CodeInvalidParameterValue, "%s requires a Boolean value", opt.Name)
+}
@@ -0,0 +1,178 @@
+package planner
+
+import (
+	"bytes"
+	"math"
+	"sort"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// The cost model counts the work of a plan in units of reading one row in
+// a full scan. It is only used for tables that have been analyzed; plans
+// over other tables are chosen by rules.
+const (
+	// rowCost is reading a row or an index entry in key order.
+	rowCost = 1.0
+	// lookupCost is fetching the row of a secondary index entry from
+	// the primary index.
+	lookupCost = 4.0
+	// spanCost is seeking to the start of a span.
+	spanCost = 10.0
+)
+
+// Selectivities assumed where statistics do not tell: of an equality, of
+// a range, and of a join condition other than an equality of columns.
+const (
+	defaultEqSelectivity    = 0.005
+	defaultRangeSelectivity = 1.0 / 3
+	defaultJoinSelectivity  = 1.0 / 3
+)
+
+// columnStats returns the statistics of the column ord of t, or nil if
+// there are none.
+func columnStats(t *catalog.Table, ord int) *catalog.ColumnStats {
+	if t.Stats == nil {
+		return nil
+	}
+	return t.Stats.Column(t.Columns[ord].ID)
+}
+
+// selectivity estimates the fraction of the rows of t whose column ord
+// satisfies c.
+func selectivity(t *catalog.Table, ord int, c *colConstraint) float64 {
+	cs := columnStats(t, ord)
+	if c.eq != nil {
+		sel := 0.0
+		for _, d := range c.eq {
+			switch {
+			case cs == nil:
+				sel += defaultEqSelectivity
+			case d == types.DNull:
+				sel += cs.NullFrac
+			case cs.Distinct >= 1:
+				sel += (1 - cs.NullFrac) / cs.Distinct
+			}
+		}
+		return min(sel, 1)
+	}
+	if cs == nil || len(cs.Histogram) < 2 || !c.bound() {
+		return defaultRangeSelectivity
+	}
+	typ := t.Columns[ord].Type
+	var lo, hi []byte
+	if c.prefix != nil {
+		lo = rowcodec.EncodeStringPrefix(nil, *c.prefix)
+		hi = rowcodec.PrefixEnd(lo)
+	}
+	for _, b := range []struct {
+		d   types.Datum
+		key *[]byte
+	}{{c.lo, &lo}, {c.hi, &hi}} {
+		if b.d == nil {
+			continue
+		}
+		k, err := rowcodec.EncodeKey(nil, typ, b.d)
+		if err != nil {
+			return defaultRangeSelectivity
+		}
+		*b.key = k
+	}
+	frac := 1.0
+	if hi != nil {
+		frac = histogramFraction(cs.Histogram, hi)
+	}
+	if lo != nil {
+		frac -= histogramFraction(cs.Histogram, lo)
+	}
+	// A range within one bucket is taken to hold half of it.
+	buckets := float64(len(cs.Histogram) - 1)
+	return max(frac, 0.5/buckets) * (1 - cs.NullFrac)
+}
+
+// histogramFraction estimates the fraction of the values described by the
+// histogram h whose keys sort before key, taking the values of the bucket
+// key falls in to be half before it.
+func histogramFraction(h [][]byte, key []byte) float64 {
+	i := sort.Search(len(h), func(i int) bool { return bytes.Compare(h[i], key) >= 0 })
+	switch i {
+	case 0:
+		return 0
+	case len(h):
+		return 1
+	}
+	return (float64(i) - 0.5) / float64(len(h)-1)
+}
+
+// tableRows returns the number of rows of t when it was last analyzed.
+func tableRows(t *catalog.Table) float64 {
+	return float64(t.Stats.RowCount)
+}
+
+// filteredRows estimates the number of rows of t that satisfy the
+// constraints cons, which are taken to be independent.
+func filteredRows(t *catalog.Table, cons map[int]*colConstraint) float64 {
+	rows := tableRows(t)
+	for ord, c := range cons {
+		rows *= selectivity(t, ord, c)
+	}
+	return rows
+}
+
+// fullScanCost is the cost of reading all rows of t.
+func fullScanCost(t *catalog.Table) float64 {
+	return spanCost + tableRows(t)*rowCost
+}
+
+// indexCost is the cost of reading the rows of t that the constraints
+// cons on the leading columns of idx select through idx.
+func indexCost(t *catalog.Table, idx *catalog.Index, cons map[int]*colConstraint) float64 {
+	rows, spans := tableRows(t), 1.0
+	for _, ord := range t.ColumnOrdinals(idx) {
+		c := cons[ord]
+		if c == nil {
+			break
+		}
+		rows *= selectivity(t, ord, c)
+		if c.eq == nil {
+			break
+		}
+		spans *= float64(len(c.eq))
+	}
+	cost := spans*spanCost + rows*rowCost
+	if idx != t.PrimaryIndex {
+		cost += rows * lookupCost
+	}
+	return cost
+}
+
+// analyzed reports whether n reads a table with statistics, returning the
+// table and the filter applied to its rows.
+func analyzed(n Node) (*catalog.Table, eval.Expr, bool) {
+	var t *catalog.Table
+	var filter eval.Expr
+	switch n := n.(type) {
+	case *Scan:
+		t, filter = n.Table, n.Filter
+	case *InvertedScan:
+		t, filter = n.Table, n.Filter
+	case *BRINScan:
+		t, filter = n.Table, n.Filter
+	default:
+		return nil, nil, false
+	}
+	return t, filter, t.Stats != nil
+}
+
+// distinctValues estimates the number of distinct values of the column
+// ord of t among rows of its rows.
+func distinctValues(t *catalog.Table, ord int, rows float64) float64 {
+	cs := columnStats(t, ord)
+	if cs == nil {
+		return math.Max(rows, 1)
+	}
+	return math.Max(math.Min(cs.Distinct, rows), 1)
+}
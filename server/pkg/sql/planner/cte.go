@@ -0,0 +1,321 @@
+package planner
+
+import (
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// cte is a common table expression in scope while a query is planned.
+type cte struct {
+	def *parser.CTE
+	// visible are the CTEs in scope where def is, which its query can
+	// refer to. A CTE of WITH RECURSIVE sees itself.
+	visible []*cte
+	// recursive is set when the query refers to the CTE itself.
+	recursive bool
+	// inline is set when each reference plans the query anew, as part of
+	// the query referring to it; otherwise node is planned once.
+	inline bool
+	node   *CTE
+	// referenced is set once a query refers to the CTE.
+	referenced bool
+
+	// planning is set while the CTE's query is planned, and work while
+	// its recursive term is, at a subquery nesting depth of workDepth.
+	planning  bool
+	work      *RecursiveUnion
+	workDepth int
+	workRefs  int
+}
+
+// planWith plans s, whose WITH clause is set. A CTE referred to once is
+// inlined, so that filters on it can reach its scans, unless it is
+// declared MATERIALIZED; others are computed once, and only if read.
+func (p *Planner) planWith(s *parser.SelectStmt) (Node, error) {
+	saved := p.ctes
+	defer func() { p.ctes = saved }()
+	body := *s
+	body.With = nil
+	var ctes []*cte
+	for i, def := range s.With.CTEs {
+		for _, prev := range s.With.CTEs[:i] {
+			if prev.Name == def.Name {
+				return nil, pgerror.Newf(pgerror.CodeDuplicateAlias, "WITH query name %q specified more than once", def.Name)
+			}
+		}
+		c := &cte{def: def, visible: slices.Clip(p.ctes)}
+		if s.With.Recursive {
+			c.recursive = countRefs(def.Select, def.Name) > 0
+			c.visible = append(c.visible, c)
+		}
+		// References from the query itself and from the later CTEs.
+		refs := countRefs(&body, def.Name)
+		for _, later := range s.With.CTEs[i+1:] {
+			refs += countRefs(later.Select, def.Name)
+		}
+		c.inline = def.Materialize == parser.MaterializeNever || (def.Materialize == parser.MaterializeDefault && refs <= 1)
+		p.ctes = append(slices.Clip(p.ctes), c)
+		ctes = append(ctes, c)
+	}
+	n, err := p.planSelect(&body)
+	if err != nil {
+		return nil, err
+	}
+	// The queries of CTEs that are never read are not run, but are still
+	// checked.
+	for _, c := range ctes {
+		if !c.referenced {
+			if _, _, err := p.planCTE(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	w := &With{Input: n}
+	for _, c := range ctes {
+		if c.node != nil {
+			w.CTEs = append(w.CTEs, c.node)
+		}
+	}
+	if len(w.CTEs) == 0 {
+		return n, nil
+	}
+	return w, nil
+}
+
+// lookupCTE returns the innermost CTE in scope called name, or nil.
+func (p *Planner) lookupCTE(name string) *cte {
+	for i := len(p.ctes) - 1; i >= 0; i-- {
+		if p.ctes[i].def.Name == name {
+			return p.ctes[i]
+		}
+	}
+	return nil
+}
+
+// planCTERef plans a FROM item referring to c, filtered by where, and
+// returns it with the scope of its columns.
+func (p *Planner) planCTERef(c *cte, alias string, where parser.Expr) (Node, *scope, error) {
+	c.referenced = true
+	var n Node
+	var cols []Column
+	switch {
+	case c.work != nil:
+		if len(p.outer) > c.workDepth {
+			return nil, nil, pgerror.Newf(pgerror.CodeInvalidRecursion,
+				"recursive reference to query %q must not appear within a subquery", c.def.Name)
+		}
+		if c.workRefs++; c.workRefs > 1 {
+			return nil, nil, pgerror.Newf(pgerror.CodeInvalidRecursion,
+				"recursive reference to query %q must not appear more than once", c.def.Name)
+		}
+		n = &WorkTableScan{Union: c.work}
+		cols = c.work.Cols
+	case c.planning:
+		return nil, nil, pgerror.Newf(pgerror.CodeInvalidRecursion,
+			"recursive reference to query %q must not appear within its non-recursive term", c.def.Name)
+	case c.inline:
+		var err error
+		if n, cols, err = p.planCTE(c); err != nil {
+			return nil, nil, err
+		}
+	default:
+		if c.node == nil {
+			plan, cols, err := p.planCTE(c)
+			if err != nil {
+				return nil, nil, err
+			}
+			c.node = &CTE{Name: c.def.Name, Plan: plan, Cols: cols}
+		}
+		n = &CTEScan{CTE: c.node}
+		cols = c.node.Cols
+	}
+	if alias == "" {
+		alias = c.def.Name
+	}
+	sc := &scope{cols: make([]scopeColumn, len(cols))}
+	for i, col := range cols {
+		sc.cols[i] = scopeColumn{table: alias, name: col.Name, typ: col.Type}
+	}
+	if where != nil {
+		pred, err := p.typeCheckPredicate(where, sc, "WHERE")
+		if err != nil {
+			return nil, nil, err
+		}
+		if n, err = pushFilter(n, conjuncts(pred)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return n, sc, nil
+}
+
+// planCTE plans the query of c and returns it with its columns named as
+// the WITH clause names them. The query sees the CTEs in scope where it
+// is defined, and no enclosing query.
+func (p *Planner) planCTE(c *cte) (Node, []Column, error) {
+	ctes, outer := p.ctes, p.outer
+	p.ctes, p.outer = c.visible, nil
+	c.planning = true
+	defer func() {
+		p.ctes, p.outer = ctes, outer
+		c.planning = false
+	}()
+	if c.recursive {
+		n, err := p.planRecursive(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		return n, n.Columns(), nil
+	}
+	n, err := p.planSelect(c.def.Select)
+	if err != nil {
+		return nil, nil, err
+	}
+	cols, err := cteColumns(c.def, n.Columns())
+	return n, cols, err
+}
+
+// cteColumns renames cols by the column names of def, if it has them.
+func cteColumns(def *parser.CTE, cols []Column) ([]Column, error) {
+	if len(def.Columns) > len(cols) {
+		return nil, pgerror.Newf(pgerror.CodeInvalidColumnReference,
+			"WITH query %q has %d columns available but %d columns specified", def.Name, len(cols), len(def.Columns))
+	}
+	cols = slices.Clone(cols)
+	for i, name := range def.Columns {
+		cols[i].Name = name
+	}
+	return cols, nil
+}
+
+// planRecursive plans the query of a recursive CTE, which must be a UNION
+// of a term not referring to the CTE and a term referring to it once.
+func (p *Planner) planRecursive(c *cte) (Node, error) {
+	s := c.def.Select
+	if s.Op != parser.Union {
+		return nil, pgerror.Newf(pgerror.CodeInvalidRecursion,
+			"recursive query %q does not have the form non-recursive-term UNION [ALL] recursive-term", c.def.Name)
+	}
+	switch {
+	case s.With != nil:
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "WITH in a recursive query is not implemented")
+	case len(s.OrderBy) > 0:
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "ORDER BY in a recursive query is not implemented")
+	case s.Offset != nil:
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "OFFSET in a recursive query is not implemented")
+	case s.Limit != nil:
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "LIMIT in a recursive query is not implemented")
+	}
+	initial, err := p.planSelect(s.Left)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := cteColumns(c.def, initial.Columns())
+	if err != nil {
+		return nil, err
+	}
+	u := &RecursiveUnion{Name: c.def.Name, Initial: initial, All: s.All, Cols: cols}
+	c.work, c.workDepth, c.workRefs = u, len(p.outer), 0
+	rec, err := p.planSelect(s.Right)
+	c.work = nil
+	if err != nil {
+		return nil, err
+	}
+	recCols := rec.Columns()
+	if len(recCols) != len(cols) {
+		return nil, pgerror.New(pgerror.CodeSyntaxError, "each UNION query must have the same number of columns")
+	}
+	// The recursive term's rows take the types of the initial term's,
+	// which must already be the types of the union.
+	proj := &Project{Input: rec, Cols: cols}
+	coerced := false
+	for i, col := range cols {
+		typ, err := eval.CommonType("UNION", []*types.T{col.Type, recCols[i].Type})
+		if err != nil {
+			return nil, err
+		}
+		if !typ.Identical(col.Type) {
+			return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+				"recursive query %q column %d has type %s in non-recursive term but type %s overall",
+				c.def.Name, i+1, col.Type, typ).
+				WithHint("Cast the output of the non-recursive term to the correct type.")
+		}
+		var e eval.Expr = &eval.ColumnRef{Idx: i, Name: recCols[i].Name, Typ: recCols[i].Type}
+		if !recCols[i].Type.Identical(col.Type) {
+			if e, err = eval.Coerce(e, col.Type); err != nil {
+				return nil, err
+			}
+			coerced = true
+		}
+		proj.Exprs = append(proj.Exprs, e)
+	}
+	u.Recursive = rec
+	if coerced {
+		u.Recursive = proj
+	}
+	return u, nil
+}
+
+// countRefs counts the FROM items naming a CTE called name in s and the
+// queries nested in it, up to a WITH clause defining another CTE of that
+// name.
+func countRefs(s *parser.SelectStmt, name string) int {
+	n := 0
+	if s.With != nil {
+		for _, c := range s.With.CTEs {
+			if c.Name == name {
+				return n
+			}
+			n += countRefs(c.Select, name)
+		}
+	}
+	if s.Op != parser.NoSetOp {
+		return n + countRefs(s.Left, name) + countRefs(s.Right, name)
+	}
+	expr := func(e parser.Expr) {
+		parser.Walk(e, func(e parser.Expr) bool {
+			switch e := e.(type) {
+			case *parser.Subquery:
+				n += countRefs(e.Select, name)
+			case *parser.ExistsExpr:
+				n += countRefs(e.Subquery.Select, name)
+			case *parser.InExpr:
+				if e.Subquery != nil {
+					n += countRefs(e.Subquery.Select, name)
+				}
+			}
+			return true
+		})
+	}
+	var from func(te parser.TableExpr)
+	from = func(te parser.TableExpr) {
+		switch te := te.(type) {
+		case *parser.TableName:
+			if te.Schema == "" && te.Name == name {
+				n++
+			}
+		case *parser.JoinExpr:
+			from(te.Left)
+			from(te.Right)
+			expr(te.On)
+		}
+	}
+	for _, te := range s.From {
+		from(te)
+	}
+	for _, t := range s.Targets {
+		expr(t.Expr)
+	}
+	expr(s.Where)
+	for _, g := range s.GroupBy {
+		expr(g)
+	}
+	expr(s.Having)
+	for _, o := range s.OrderBy {
+		expr(o.Expr)
+	}
+	return n
+}
@@ -0,0 +1,77 @@
+package planner
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// currentDatabase returns the name of the database statements run in.
+func (p *Planner) currentDatabase() string {
+	if p.Database == "" {
+		return catalog.DefaultDatabase
+	}
+	return p.Database
+}
+
+func (p *Planner) planCreateDatabase(s *parser.CreateDatabaseStmt) (Node, error) {
+	cur, err := p.currentRole()
+	if err != nil {
+		return nil, err
+	}
+	if cur != nil && !cur.Superuser && !cur.CreateDB {
+		return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to create database")
+	}
+	db := &catalog.Database{Name: s.Name}
+	if cur != nil {
+		db.Owner = cur.ID
+	}
+	if s.Owner != "" {
+		owner, err := catalog.MustLookupRole(p.Txn, s.Owner)
+		if err != nil {
+			return nil, err
+		}
+		// Giving a database away needs the privileges of its new owner,
+		// as SET ROLE to it would.
+		if cur != nil && !cur.Superuser {
+			ok, err := catalog.MemberOf(p.Txn, cur, owner.ID, true)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, pgerror.Newf(pgerror.CodeInsufficientPrivilege, "must be able to SET ROLE %q", owner.Name)
+			}
+		}
+		db.Owner = owner.ID
+	}
+	existing, err := catalog.LookupDatabase(p.Txn, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, pgerror.Newf(pgerror.CodeDuplicateDatabase, "database %q already exists", s.Name)
+	}
+	return &CreateDatabase{Database: db}, nil
+}
+
+func (p *Planner) planDropDatabase(s *parser.DropDatabaseStmt) (Node, error) {
+	db, err := catalog.LookupDatabase(p.Txn, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if db == nil {
+		if s.IfExists {
+			return &DropDatabase{}, nil
+		}
+		return nil, pgerror.Newf(pgerror.CodeInvalidCatalogName, "database %q does not exist", s.Name)
+	}
+	if s.Name == p.currentDatabase() {
+		return nil, pgerror.New(pgerror.CodeObjectInUse, "cannot drop the currently open database")
+	}
+	if db.ID == catalog.DefaultDatabaseID {
+		// The keys of the default database are not under a prefix of
+		// their own, which could be deleted with it.
+		return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "cannot drop the default database %q", s.Name)
+	}
+	return &DropDatabase{Database: db, Force: s.Force}, nil
+}
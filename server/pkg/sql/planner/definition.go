@@ -0,0 +1,184 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+)
+
+// TableDef returns the statements that create t as the catalog records it:
+// CREATE TABLE with its columns, constraints and storage parameters,
+// followed by CREATE INDEX for each secondary index, each ending in a
+// semicolon and a newline. The hidden rowid column of a table declared without a primary key is left
+// out, with its key, as the table is created again with them.
+func TableDef(r engine.Reader, t *catalog.Table) (string, error) {
+	name, err := qualifiedTable(r, t)
+	if err != nil {
+		return "", err
+	}
+	var defs []string
+	for _, c := range t.Columns {
+		if !c.Hidden {
+			defs = append(defs, columnDef(c))
+		}
+	}
+	if pk := t.PrimaryIndex; !hiddenKey(t, pk) {
+		defs = append(defs, fmt.Sprintf("CONSTRAINT %s PRIMARY KEY (%s)",
+			parser.QuoteIdent(pk.Name), indexColumns(t, pk)))
+	}
+	for _, ck := range t.Checks {
+		defs = append(defs, fmt.Sprintf("CONSTRAINT %s CHECK (%s)", parser.QuoteIdent(ck.Name), ck.Expr))
+	}
+	for _, fk := range t.ForeignKeys {
+		def, err := foreignKeyDef(r, t, fk)
+		if err != nil {
+			return "", err
+		}
+		defs = append(defs, def)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE TABLE %s (\n    %s\n)", name, strings.Join(defs, ",\n    "))
+	if params := tableParams(t); params != nil {
+		fmt.Fprintf(&b, " WITH (%s)", strings.Join(params, ", "))
+	}
+	b.WriteString(";\n")
+	for _, idx := range t.Indexes {
+		def, err := IndexDef(r, t, idx)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(def + ";\n")
+	}
+	return b.String(), nil
+}
+
+// IndexDef returns the CREATE INDEX statement that creates idx of t, as
+// pg_get_indexdef does, without a terminating semicolon. The primary
+// index is described as the unique index it is.
+func IndexDef(r engine.Reader, t *catalog.Table, idx *catalog.Index) (string, error) {
+	name, err := qualifiedTable(r, t)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if idx.Unique || idx.ID == catalog.PrimaryIndexID {
+		b.WriteString("UNIQUE ")
+	}
+	fmt.Fprintf(&b, "INDEX %s ON %s USING %s (%s)", parser.QuoteIdent(idx.Name), name, indexMethodName(idx), indexColumns(t, idx))
+	switch {
+	case idx.HNSW != nil:
+		fmt.Fprintf(&b, " WITH (m='%d', ef_construction='%d')", idx.HNSW.M, idx.HNSW.EfConstruction)
+	case idx.BRIN != nil:
+		fmt.Fprintf(&b, " WITH (pages_per_range='%d')", idx.BRIN.PagesPerRange)
+	}
+	return b.String(), nil
+}
+
+// qualifiedTable returns the name of t qualified with its schema, as
+// pg_get_indexdef names tables.
+func qualifiedTable(r engine.Reader, t *catalog.Table) (string, error) {
+	schema, err := catalog.GetSchemaByID(r, t.Schema)
+	if err != nil {
+		return "", err
+	}
+	return parser.QuoteIdent(schema.Name) + "." + parser.QuoteIdent(t.Name), nil
+}
+
+// columnDef returns the definition of column c in CREATE TABLE.
+func columnDef(c *catalog.Column) string {
+	def := parser.QuoteIdent(c.Name) + " " + c.Type.String()
+	switch {
+	case c.Identity == catalog.IdentityAlways:
+		def += " GENERATED ALWAYS AS IDENTITY"
+	case c.Identity == catalog.IdentityByDefault:
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	case c.Generated != "":
+		def += " GENERATED ALWAYS AS (" + c.Generated + ") STORED"
+	case c.Default != "":
+		def += " DEFAULT " + c.Default
+	}
+	if !c.Nullable && c.Identity == "" {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// hiddenKey reports whether idx is the primary index of t on its hidden
+// rowid column.
+func hiddenKey(t *catalog.Table, idx *catalog.Index) bool {
+	return idx.ID == catalog.PrimaryIndexID && len(idx.ColumnIDs) == 1 &&
+		t.Columns[t.ColumnOrdinal(idx.ColumnIDs[0])].Hidden
+}
+
+// indexColumns returns the column list of idx, with the operator class of
+// an HNSW index and the direction of descending columns.
+func indexColumns(t *catalog.Table, idx *catalog.Index) string {
+	cols := t.IndexColumnNames(idx)
+	for i, name := range cols {
+		cols[i] = parser.QuoteIdent(name)
+		if idx.HNSW != nil {
+			for opClass, op := range vectorOpClasses {
+				if op == idx.HNSW.Operator {
+					cols[i] += " " + opClass
+				}
+			}
+		}
+		if idx.Desc(i) {
+			cols[i] += " DESC"
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+// indexMethodName returns the access method of idx, as USING names it.
+func indexMethodName(idx *catalog.Index) string {
+	switch {
+	case idx.HNSW != nil:
+		return "hnsw"
+	case idx.Inverted:
+		return "gin"
+	case idx.Hash:
+		return "hash"
+	case idx.BRIN != nil:
+		return "brin"
+	}
+	return "btree"
+}
+
+// foreignKeyDef returns the definition of foreign key fk of t in CREATE
+// TABLE. The actions are left out where they are NO ACTION, the default.
+func foreignKeyDef(r engine.Reader, t *catalog.Table, fk *catalog.ForeignKey) (string, error) {
+	ref := t
+	if fk.Referenced != t.ID {
+		var err error
+		if ref, err = catalog.GetTableByID(r, fk.Referenced); err != nil {
+			return "", err
+		}
+	}
+	refName, err := qualifiedTable(r, ref)
+	if err != nil {
+		return "", err
+	}
+	def := fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)", parser.QuoteIdent(fk.Name),
+		quoteIdents(t.ColumnNames(fk.ColumnIDs)), refName, quoteIdents(ref.ColumnNames(fk.ReferencedColumnIDs)))
+	if fk.OnDelete != "" && fk.OnDelete != catalog.NoAction {
+		def += " ON DELETE " + string(fk.OnDelete)
+	}
+	if fk.OnUpdate != "" && fk.OnUpdate != catalog.NoAction {
+		def += " ON UPDATE " + string(fk.OnUpdate)
+	}
+	return def, nil
+}
+
+// quoteIdents quotes names and joins them into a list.
+func quoteIdents(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = parser.QuoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,350 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+)
+
+// ExplainLines formats a plan as indented lines, one per node or node property,
+// in the style of PostgreSQL's EXPLAIN.
+func ExplainLines(n Node) []string {
+	var lines []string
+	explainNode(n, 0, &lines)
+	return lines
+}
+
+func explainNode(n Node, depth int, lines *[]string) {
+	indent := strings.Repeat("  ", depth)
+	emit := func(format string, args ...any) {
+		*lines = append(*lines, indent+fmt.Sprintf(format, args...))
+	}
+	prop := func(format string, args ...any) {
+		*lines = append(*lines, indent+"  "+fmt.Sprintf(format, args...))
+	}
+	switch n := n.(type) {
+	case *Scan:
+		switch {
+		case n.Loose:
+			emit("Loose Index Scan: %s@%s", n.Table.Name, n.Index.Name)
+			if n.Prefix > 0 {
+				prop("Distinct Prefix: %s", strings.Join(n.Table.IndexColumnNames(n.Index)[:n.Prefix], ", "))
+			}
+		case n.Index.ID == n.Table.PrimaryIndex.ID:
+			emit("Scan: %s", n.Table.Name)
+		default:
+			emit("Index Scan: %s@%s", n.Table.Name, n.Index.Name)
+		}
+		descs := make([]string, len(n.Spans))
+		for i, s := range n.Spans {
+			descs[i] = s.Desc
+		}
+		switch {
+		case n.Parameterized:
+			descs = []string{"from parameters"}
+		case len(descs) == 0:
+			descs = []string{"none"}
+		}
+		prop("Spans: %s", strings.Join(descs, ", "))
+		if len(n.Shards) > 0 {
+			prop("Shards: %d", len(n.Shards))
+		}
+		if n.Filter != nil {
+			prop("Filter: %s", n.Filter)
+		}
+		explainSubplans(depth+1, lines, n.Filter)
+	case *VectorSearch:
+		emit("Vector Search: %s@%s", n.Table.Name, n.Index.Name)
+		prop("Order By: %s %s %s", n.Table.IndexColumnNames(n.Index)[0], n.Index.HNSW.Operator, n.Query)
+		if n.Filter != nil {
+			prop("Filter: %s", n.Filter)
+		}
+		explainSubplans(depth+1, lines, n.Filter)
+	case *InvertedScan:
+		emit("Inverted Index Scan: %s@%s", n.Table.Name, n.Index.Name)
+		descs := make([]string, len(n.Spans))
+		for i, s := range n.Spans {
+			descs[i] = s.Desc
+		}
+		prop("Tokens: %s", strings.Join(descs, ", "))
+		if n.Filter != nil {
+			prop("Filter: %s", n.Filter)
+		}
+		explainSubplans(depth+1, lines, n.Filter)
+	case *BRINScan:
+		emit("BRIN Scan: %s@%s", n.Table.Name, n.Index.Name)
+		descs := make([]string, len(n.Bounds))
+		for i, b := range n.Bounds {
+			descs[i] = b.Desc
+		}
+		prop("Bounds: %s", strings.Join(descs, ", "))
+		if n.Filter != nil {
+			prop("Filter: %s", n.Filter)
+		}
+		explainSubplans(depth+1, lines, n.Filter)
+	case *VirtualScan:
+		emit("Virtual Scan: %s", n.Table.Desc.Name)
+		if n.Filter != nil {
+			prop("Filter: %s", n.Filter)
+		}
+		explainSubplans(depth+1, lines, n.Filter)
+	case *With:
+		explainNode(n.Input, depth, lines)
+		for _, c := range n.CTEs {
+			prop("CTE %s", c.Name)
+			explainNode(c.Plan, depth+2, lines)
+		}
+	case *CTEScan:
+		emit("CTE Scan: %s", n.CTE.Name)
+	case *SetOp:
+		if n.All {
+			emit("%s All", n.Type)
+		} else {
+			emit("%s", n.Type)
+		}
+		explainNode(n.Left, depth+1, lines)
+		explainNode(n.Right, depth+1, lines)
+	case *RecursiveUnion:
+		if n.All {
+			emit("Recursive Union All: %s", n.Name)
+		} else {
+			emit("Recursive Union: %s", n.Name)
+		}
+		explainNode(n.Initial, depth+1, lines)
+		explainNode(n.Recursive, depth+1, lines)
+	case *WorkTableScan:
+		emit("WorkTable Scan: %s", n.Union.Name)
+	case *Values:
+		emit("Values: %d row(s)", len(n.Rows))
+		for _, row := range n.Rows {
+			explainSubplans(depth+1, lines, row...)
+		}
+	case *Filter:
+		emit("Filter: %s", n.Pred)
+		explainSubplans(depth+1, lines, n.Pred)
+		explainNode(n.Input, depth+1, lines)
+	case *Project:
+		names := make([]string, len(n.Exprs))
+		for i, e := range n.Exprs {
+			names[i] = e.String()
+		}
+		emit("Project: %s", strings.Join(names, ", "))
+		explainSubplans(depth+1, lines, n.Exprs...)
+		explainNode(n.Input, depth+1, lines)
+	case *Distinct:
+		emit("Distinct")
+		explainNode(n.Input, depth+1, lines)
+	case *Aggregate:
+		if len(n.GroupBy) == 0 {
+			emit("Aggregate")
+		} else {
+			keys := make([]string, len(n.GroupBy))
+			for i, g := range n.GroupBy {
+				keys[i] = g.String()
+			}
+			emit("Hash Aggregate")
+			prop("Group Key: %s", strings.Join(keys, ", "))
+		}
+		aggs := make([]string, len(n.Aggs))
+		for i, a := range n.Aggs {
+			aggs[i] = a.String()
+		}
+		if len(aggs) > 0 {
+			prop("Aggregates: %s", strings.Join(aggs, ", "))
+		}
+		explainNode(n.Input, depth+1, lines)
+	case *Window:
+		emit("Window")
+		var args []eval.Expr
+		for i, w := range n.Windows {
+			var funcs []string
+			for _, f := range n.Funcs {
+				if f.Window != i {
+					continue
+				}
+				desc := f.Call.String()
+				if f.Frame != eval.DefaultFrame {
+					desc += " " + f.Frame.String()
+				}
+				funcs = append(funcs, desc)
+				args = append(args, f.Call.Args...)
+			}
+			prop("Over %s: %s", w, strings.Join(funcs, ", "))
+		}
+		explainSubplans(depth+1, lines, args...)
+		explainNode(n.Input, depth+1, lines)
+	case *Sort:
+		explainSort(n, "Sort", depth, lines)
+	case *Limit:
+		count := "ALL"
+		if n.Count != nil {
+			count = n.Count.String()
+		}
+		emit("Limit: %s", count)
+		if n.Offset != nil {
+			prop("Offset: %s", n.Offset)
+		}
+		if sort, ok := n.Input.(*Sort); ok && n.Count != nil {
+			// The limit bounds the sort, which keeps only the rows it returns.
+			explainSort(sort, "Top-K Sort", depth+1, lines)
+			break
+		}
+		explainNode(n.Input, depth+1, lines)
+	case *Join:
+		kind := "Join"
+		if n.Type != InnerJoin {
+			kind = n.Type.String() + " Join"
+		}
+		if len(n.LeftKeys) > 0 {
+			emit("Hash %s", kind)
+			conds := make([]string, len(n.LeftKeys))
+			for i := range n.LeftKeys {
+				conds[i] = fmt.Sprintf("(%s = %s)", n.LeftKeys[i], n.RightKeys[i])
+			}
+			prop("Hash Cond: %s", strings.Join(conds, " AND "))
+		} else if n.Type == InnerJoin {
+			emit("Nested Loop")
+		} else {
+			emit("Nested Loop %s", kind)
+		}
+		if n.On != nil {
+			prop("Join Filter: %s", n.On)
+		}
+		explainSubplans(depth+1, lines, n.On)
+		explainNode(n.Left, depth+1, lines)
+		explainNode(n.Right, depth+1, lines)
+	case *LookupJoin:
+		kind := "Join"
+		if n.Type != InnerJoin {
+			kind = n.Type.String() + " Join"
+		}
+		emit("Lookup %s: %s@%s", kind, n.Table.Name, n.Index.Name)
+		keys := make([]string, len(n.Keys))
+		for i, k := range n.Keys {
+			keys[i] = k.String()
+		}
+		prop("Lookup Key: %s", strings.Join(keys, ", "))
+		if n.On != nil {
+			prop("Join Filter: %s", n.On)
+		}
+		explainSubplans(depth+1, lines, n.On)
+		explainNode(n.Input, depth+1, lines)
+	case *Insert:
+		emit("Insert: %s", n.Table.Name)
+		explainNode(n.Input, depth+1, lines)
+	case *Update:
+		set := make([]string, len(n.Targets))
+		for i, ord := range n.Targets {
+			set[i] = fmt.Sprintf("%s = %s", n.Table.Columns[ord].Name, n.Exprs[i])
+		}
+		emit("Update: %s", n.Table.Name)
+		prop("Set: %s", strings.Join(set, ", "))
+		explainSubplans(depth+1, lines, n.Exprs...)
+		explainNode(n.Input, depth+1, lines)
+	case *Delete:
+		if n.All {
+			emit("Delete Range: %s", n.Table.Name)
+			break
+		}
+		emit("Delete: %s", n.Table.Name)
+		explainNode(n.Input, depth+1, lines)
+	case *CreateTable:
+		emit("Create Table: %s", n.Table.Name)
+	case *CreateIndex:
+		emit("Create Index: %s on %s", n.Index.Name, n.Table.Name)
+	case *AlterTable:
+		if n.Table != nil {
+			emit("Alter Table: %s", n.Old.Name)
+		}
+	case *DropTable:
+		for _, t := range n.Tables {
+			emit("Drop Table: %s", t.Name)
+		}
+	case *Truncate:
+		for _, t := range n.Tables {
+			emit("Truncate: %s", t.Name)
+		}
+	case *Analyze:
+		for _, t := range n.Tables {
+			emit("Analyze: %s", t.Name)
+		}
+	case *Cluster:
+		for _, t := range n.Tables {
+			emit("Cluster: %s", t.Name)
+		}
+		if n.Index != nil {
+			prop("Index: %s", n.Index.Name)
+		}
+	case *Vacuum:
+		if n.Database != nil {
+			emit("Vacuum: database %s", n.Database.Name)
+		}
+		for _, t := range n.Tables {
+			emit("Vacuum: %s", t.Name)
+		}
+		if n.Analyze {
+			prop("Analyze: true")
+		}
+	case *Copy:
+		emit("Copy: %s", n.Insert.Table.Name)
+		if n.Source.File == "" {
+			prop("From: STDIN")
+		} else {
+			prop("From: %s", n.Source.File)
+		}
+		if n.Errors != nil {
+			prop("Errors: %s", n.Errors.Table.Name)
+		}
+	case *DropIndex:
+		for _, ref := range n.Indexes {
+			emit("Drop Index: %s", ref.Index.Name)
+		}
+	case *CreateSequence:
+		emit("Create Sequence: %s", n.Sequence.Name)
+	case *DropSequence:
+		for _, s := range n.Sequences {
+			emit("Drop Sequence: %s", s.Name)
+		}
+	case *ShowCreate:
+		emit("Show Create: %s", n.Table.Name)
+	case *Explain:
+		explainNode(n.Plan, depth, lines)
+	default:
+		emit("%T", n)
+	}
+}
+
+// explainSort describes a sort, naming its keys by input column name.
+func explainSort(n *Sort, label string, depth int, lines *[]string) {
+	cols := n.Input.Columns()
+	keys := make([]string, len(n.Keys))
+	for i, k := range n.Keys {
+		keys[i] = cols[k.Col].Name
+		if k.Desc {
+			keys[i] += " DESC"
+		}
+		if k.NullsFirst != k.Desc {
+			if k.NullsFirst {
+				keys[i] += " NULLS FIRST"
+			} else {
+				keys[i] += " NULLS LAST"
+			}
+		}
+	}
+	*lines = append(*lines, strings.Repeat("  ", depth)+label+": "+strings.Join(keys, ", "))
+	explainNode(n.Input, depth+1, lines)
+}
+
+// explainSubplans describes the queries of the subqueries in exprs.
+func explainSubplans(depth int, lines *[]string, exprs ...eval.Expr) {
+	for _, e := range exprs {
+		eval.Walk(e, func(e eval.Expr) bool {
+			if sq, ok := e.(*eval.SubqueryExpr); ok {
+				*lines = append(*lines, fmt.Sprintf("%sSubPlan %d", strings.Repeat("  ", depth), sq.ID))
+				explainNode(sq.Plan.(Node), depth+1, lines)
+			}
+			return true
+		})
+	}
+}
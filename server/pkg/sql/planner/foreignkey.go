@@ -0,0 +1,329 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// addForeignKey checks the FOREIGN KEY constraint c and adds it to t,
+// naming it if c is unnamed. A constraint referencing t itself, by its
+// name alone or qualified with its schema, is checked against t.
+func (p *Planner) addForeignKey(t *catalog.Table, c *parser.TableConstraint) (*catalog.ForeignKey, error) {
+	r := c.References
+	ref := t
+	self := r.Table.Name == t.Name
+	if self && r.Table.Schema != "" {
+		schema, _, err := p.schemaOf(r.Table)
+		if err != nil {
+			return nil, err
+		}
+		self = schema == t.Schema
+	}
+	if !self {
+		var err error
+		if ref, err = p.mustFindTable(r.Table); err != nil {
+			return nil, err
+		}
+	}
+	fk := &catalog.ForeignKey{Name: c.Name, Referenced: ref.ID}
+	var err error
+	if fk.ColumnIDs, err = foreignKeyColumns(t, c.Columns); err != nil {
+		return nil, err
+	}
+	if r.Columns == nil {
+		fk.ReferencedColumnIDs = slices.Clone(ref.PrimaryIndex.ColumnIDs)
+	} else if fk.ReferencedColumnIDs, err = foreignKeyColumns(ref, r.Columns); err != nil {
+		return nil, err
+	}
+	if len(fk.ColumnIDs) != len(fk.ReferencedColumnIDs) {
+		return nil, pgerror.New(pgerror.CodeInvalidForeignKey,
+			"number of referencing and referenced columns for foreign key disagree")
+	}
+	if referencedIndex(ref, fk.ReferencedColumnIDs) == nil {
+		return nil, pgerror.Newf(pgerror.CodeInvalidForeignKey,
+			"there is no unique constraint matching given keys for referenced table %q", ref.Name)
+	}
+	if fk.Name == "" {
+		base := catalog.DefaultIndexName(t.Name, c.Columns, "fkey")
+		fk.Name = base
+		for i := 1; t.HasConstraint(fk.Name); i++ {
+			fk.Name = fmt.Sprintf("%s%d", base, i)
+		}
+	} else if t.HasConstraint(fk.Name) {
+		return nil, pgerror.Newf(pgerror.CodeDuplicateObject,
+			"constraint %q for relation %q already exists", fk.Name, t.Name)
+	}
+	for i, id := range fk.ColumnIDs {
+		col := t.Columns[t.ColumnOrdinal(id)]
+		refCol := ref.Columns[ref.ColumnOrdinal(fk.ReferencedColumnIDs[i])]
+		if col.Type.Family != refCol.Type.Family {
+			return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+				"foreign key constraint %q cannot be implemented", fk.Name).
+				WithDetail(fmt.Sprintf("Key columns %q of the referencing table and %q of the referenced table are of incompatible types: %s and %s.",
+					col.Name, refCol.Name, col.Type, refCol.Type))
+		}
+	}
+	fk.OnDelete, fk.OnUpdate = refAction(r.OnDelete), refAction(r.OnUpdate)
+	t.ForeignKeys = append(t.ForeignKeys, fk)
+	return fk, nil
+}
+
+// foreignKeyColumns returns the IDs of the columns of t named names.
+func foreignKeyColumns(t *catalog.Table, names []string) ([]catalog.ColumnID, error) {
+	ids := make([]catalog.ColumnID, len(names))
+	for i, name := range names {
+		ord := t.FindColumn(name)
+		if ord < 0 {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedColumn,
+				"column %q referenced in foreign key constraint does not exist", name)
+		}
+		if slices.Contains(ids[:i], t.Columns[ord].ID) {
+			return nil, pgerror.Newf(pgerror.CodeDuplicateColumn,
+				"column %q appears twice in foreign key constraint", name)
+		}
+		ids[i] = t.Columns[ord].ID
+	}
+	return ids, nil
+}
+
+// refAction returns the referential action named s, which defaults to NO
+// ACTION.
+func refAction(s string) catalog.RefAction {
+	if s == "" {
+		return catalog.NoAction
+	}
+	return catalog.RefAction(s)
+}
+
+// sameColumns reports whether a and b hold the same columns in any order.
+func sameColumns(a, b []catalog.ColumnID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, id := range a {
+		if !slices.Contains(b, id) {
+			return false
+		}
+	}
+	return true
+}
+
+// referencedIndex returns the primary key or unique index of t on the
+// columns ids, or nil if there is none.
+func referencedIndex(t *catalog.Table, ids []catalog.ColumnID) *catalog.Index {
+	for _, idx := range t.AllIndexes() {
+		if idx.Unique && idx.Ordered() && sameColumns(idx.ColumnIDs, ids) {
+			return idx
+		}
+	}
+	return nil
+}
+
+// referencingIndex returns an ordered index of t whose leading columns are
+// the columns ids, or nil if there is none.
+func referencingIndex(t *catalog.Table, ids []catalog.ColumnID) *catalog.Index {
+	for _, idx := range t.AllIndexes() {
+		if idx.Ordered() && len(idx.ColumnIDs) >= len(ids) && sameColumns(idx.ColumnIDs[:len(ids)], ids) {
+			return idx
+		}
+	}
+	return nil
+}
+
+// inIndexOrder returns the positions of the columns ids in the order of
+// the leading columns of idx, or in their own order if idx is nil.
+func inIndexOrder(idx *catalog.Index, ids []catalog.ColumnID) []int {
+	pos := make([]int, len(ids))
+	for i := range pos {
+		pos[i] = i
+		if idx != nil {
+			pos[i] = slices.Index(ids, idx.ColumnIDs[i])
+		}
+	}
+	return pos
+}
+
+// ordinals returns the table ordinals of the columns ids, taken in the
+// order pos gives.
+func ordinals(t *catalog.Table, ids []catalog.ColumnID, pos []int) []int {
+	ords := make([]int, len(pos))
+	for i, j := range pos {
+		ords[i] = t.ColumnOrdinal(ids[j])
+	}
+	return ords
+}
+
+// foreignKey compiles the foreign key fk of t.
+func (p *Planner) foreignKey(t *catalog.Table, fk *catalog.ForeignKey) (ForeignKey, error) {
+	ref := t
+	if fk.Referenced != t.ID {
+		var err error
+		if ref, err = catalog.GetTableByID(p.Txn, fk.Referenced); err != nil {
+			return ForeignKey{}, err
+		}
+	}
+	idx := referencedIndex(ref, fk.ReferencedColumnIDs)
+	return ForeignKey{
+		Name:       fk.Name,
+		Ords:       ordinals(t, fk.ColumnIDs, inIndexOrder(idx, fk.ReferencedColumnIDs)),
+		Referenced: ref,
+		Index:      idx,
+	}, nil
+}
+
+// writesBuilder collects what writing the rows of tables enforces. It
+// reads the descriptors of all tables once, to find the foreign keys
+// referencing a table.
+type writesBuilder struct {
+	p      *Planner
+	tables []*catalog.Table
+	byID   map[catalog.ID]*Writes
+}
+
+// tableWrites returns what writing the rows of t enforces. Without
+// references, it leaves out the foreign keys referencing t, which only
+// deleting rows or changing their keys has to enforce.
+func (p *Planner) tableWrites(t *catalog.Table, references bool) (*Writes, error) {
+	b := &writesBuilder{p: p, byID: make(map[catalog.ID]*Writes)}
+	if !references {
+		w := &Writes{}
+		return w, b.fill(w, t, false)
+	}
+	var err error
+	if b.tables, err = catalog.ListTables(p.Txn); err != nil {
+		return nil, err
+	}
+	return b.writes(t)
+}
+
+// writes returns the writes of t, shared by every reference to t.
+func (b *writesBuilder) writes(t *catalog.Table) (*Writes, error) {
+	if w := b.byID[t.ID]; w != nil {
+		return w, nil
+	}
+	w := &Writes{}
+	b.byID[t.ID] = w
+	return w, b.fill(w, t, true)
+}
+
+func (b *writesBuilder) fill(w *Writes, t *catalog.Table, references bool) error {
+	var err error
+	if w.Generated, err = b.p.generatedColumns(t); err != nil {
+		return err
+	}
+	if w.Checks, err = b.p.tableChecks(t); err != nil {
+		return err
+	}
+	for _, fk := range t.ForeignKeys {
+		f, err := b.p.foreignKey(t, fk)
+		if err != nil {
+			return err
+		}
+		w.ForeignKeys = append(w.ForeignKeys, f)
+	}
+	if !references {
+		return nil
+	}
+	for _, child := range b.tables {
+		if child.ID == t.ID {
+			// The statement's descriptor of t is the one to write with.
+			child = t
+		}
+		for _, fk := range child.ForeignKeys {
+			if fk.Referenced != t.ID {
+				continue
+			}
+			r, err := b.reference(child, fk, t)
+			if err != nil {
+				return err
+			}
+			w.References = append(w.References, r)
+		}
+	}
+	return nil
+}
+
+// reference returns the reference of the foreign key fk of child to t.
+func (b *writesBuilder) reference(child *catalog.Table, fk *catalog.ForeignKey, t *catalog.Table) (*Reference, error) {
+	idx := referencingIndex(child, fk.ColumnIDs)
+	pos := inIndexOrder(idx, fk.ColumnIDs)
+	r := &Reference{
+		Name:     fk.Name,
+		Table:    child,
+		Ords:     ordinals(child, fk.ColumnIDs, pos),
+		RefOrds:  ordinals(t, fk.ReferencedColumnIDs, pos),
+		Index:    idx,
+		Key:      referencedIndex(t, fk.ReferencedColumnIDs),
+		OnDelete: fk.OnDelete,
+		OnUpdate: fk.OnUpdate,
+	}
+	writesChild := false
+	for _, a := range []catalog.RefAction{fk.OnDelete, fk.OnUpdate} {
+		switch a {
+		case catalog.Cascade, catalog.SetNull:
+			writesChild = true
+		case catalog.SetDefault:
+			writesChild = true
+			if r.Defaults != nil {
+				continue
+			}
+			r.Defaults = make([]eval.Expr, len(r.Ords))
+			for i, ord := range r.Ords {
+				e, err := b.p.columnDefault(child.Columns[ord])
+				if err != nil {
+					return nil, err
+				}
+				r.Defaults[i] = e
+			}
+		}
+	}
+	if writesChild {
+		var err error
+		if r.Writes, err = b.writes(child); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// tableForeignKey is a foreign key and its table.
+type tableForeignKey struct {
+	table *catalog.Table
+	fk    *catalog.ForeignKey
+}
+
+// referencingKeys returns the foreign keys referencing t, including its
+// own. The descriptor of t is the statement's, which may have dropped some
+// of its own.
+func (p *Planner) referencingKeys(t *catalog.Table) ([]tableForeignKey, error) {
+	tables, err := catalog.ListTables(p.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var out []tableForeignKey
+	for _, child := range tables {
+		if child.ID == t.ID {
+			child = t
+		}
+		for _, fk := range child.ForeignKeys {
+			if fk.Referenced == t.ID {
+				out = append(out, tableForeignKey{child, fk})
+			}
+		}
+	}
+	return out, nil
+}
+
+// dependentObjects is the error for dropping obj, such as "table t",
+// when the foreign key k depends on on, which is obj or part of it.
+func dependentObjects(obj, on string, k tableForeignKey) error {
+	return pgerror.Newf(pgerror.CodeDependentObjectsExist,
+		"cannot drop %s because other objects depend on it", obj).
+		WithDetail(fmt.Sprintf("constraint %s on table %s depends on %s", k.fk.Name, k.table.Name, on)).
+		WithHint("Use DROP ... CASCADE to drop the dependent objects too.")
+}
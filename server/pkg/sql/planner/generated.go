@@ -0,0 +1,146 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// typeCheckGenerated type checks the generation expression e of column c
+// of t and coerces it to the column's type.
+func (p *Planner) typeCheckGenerated(e parser.Expr, t *catalog.Table, c *catalog.Column) (eval.Expr, error) {
+	if containsSubquery(e) {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "cannot use subquery in column generation expression")
+	}
+	for _, name := range columnRefs(e) {
+		if ord := t.FindColumn(name); ord >= 0 && t.Columns[ord].Generated != "" {
+			return nil, pgerror.Newf(pgerror.CodeInvalidObjectDefinition,
+				"cannot use generated column %q in column generation expression", name).
+				WithDetail("A generated column cannot reference another generated column.")
+		}
+	}
+	x, err := p.typeCheck(e, tableScope(t, "").
+		withoutAggregates("aggregate functions are not allowed in column generation expressions").
+		withoutWindows("window functions are not allowed in column generation expressions"))
+	if err != nil {
+		return nil, err
+	}
+	eval.Walk(x, func(e eval.Expr) bool {
+		if f, ok := e.(*eval.FuncExpr); ok && f.Overload.Volatility != eval.Immutable {
+			err = pgerror.New(pgerror.CodeInvalidObjectDefinition, "generation expression is not immutable")
+		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return coerceForAssignment(x, c.Type, c.Name)
+}
+
+// setGenerated checks the generation expression e of column c of t and
+// stores it in c with unqualified column references, so that it survives
+// renaming the table.
+func (p *Planner) setGenerated(t *catalog.Table, c *catalog.Column, e parser.Expr) (eval.Expr, error) {
+	// The column counts as generated while its own expression is checked,
+	// so that it cannot refer to itself.
+	c.Generated = e.String()
+	x, err := p.typeCheckGenerated(e, t, c)
+	if err != nil {
+		return nil, err
+	}
+	u, err := parser.ParseExpr(e.String())
+	if err != nil {
+		return nil, err
+	}
+	parser.Walk(u, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok {
+			ref.Table = ""
+		}
+		return true
+	})
+	c.Generated = u.String()
+	return x, nil
+}
+
+// generatedColumns compiles the generation expressions of the stored
+// generated columns of t.
+func (p *Planner) generatedColumns(t *catalog.Table) ([]ColumnFill, error) {
+	var out []ColumnFill
+	for ord, c := range t.Columns {
+		if c.Generated == "" {
+			continue
+		}
+		e, err := parser.ParseExpr(c.Generated)
+		if err != nil {
+			return nil, err
+		}
+		x, err := p.typeCheckGenerated(e, t, c)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ColumnFill{Ord: ord, Expr: x})
+	}
+	return out, nil
+}
+
+// generatedDependent returns the generated column of t whose expression
+// refers to the column name, or nil if there is none.
+func generatedDependent(t *catalog.Table, name string) (*catalog.Column, error) {
+	for _, c := range t.Columns {
+		if c.Generated == "" || c.Name == name {
+			continue
+		}
+		e, err := parser.ParseExpr(c.Generated)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(columnRefs(e), name) {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// generatedColumnError is the error for giving the generated column c a
+// value other than DEFAULT: in an INSERT, or in an UPDATE if update is
+// set.
+func generatedColumnError(c *catalog.Column, update bool) error {
+	msg := fmt.Sprintf("cannot insert a non-DEFAULT value into column %q", c.Name)
+	if update {
+		msg = fmt.Sprintf("column %q can only be updated to DEFAULT", c.Name)
+	}
+	return pgerror.New(pgerror.CodeGeneratedAlways, msg).
+		WithDetail(fmt.Sprintf("Column %q is a generated column.", c.Name))
+}
+
+// columnRefs returns the names of the columns e refers to, in order of
+// first reference.
+func columnRefs(e parser.Expr) []string {
+	var cols []string
+	parser.Walk(e, func(e parser.Expr) bool {
+		if ref, ok := e.(*parser.ColumnRef); ok && !slices.Contains(cols, ref.Column) {
+			cols = append(cols, ref.Column)
+		}
+		return true
+	})
+	return cols
+}
+
+// containsSubquery reports whether e has a subquery.
+func containsSubquery(e parser.Expr) bool {
+	var sub bool
+	parser.Walk(e, func(e parser.Expr) bool {
+		switch e := e.(type) {
+		case *parser.Subquery, *parser.ExistsExpr:
+			sub = true
+		case *parser.InExpr:
+			sub = sub || e.Subquery != nil
+		}
+		return !sub
+	})
+	return sub
+}
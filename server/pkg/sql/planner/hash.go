@@ -0,0 +1,64 @@
+package planner
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// checkHash checks a hash index definition.
+func checkHash(s *parser.CreateIndexStmt) error {
+	if s.Unique {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, `access method "hash" does not support unique indexes`)
+	}
+	if len(s.Columns) != 1 {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, `access method "hash" does not support multicolumn indexes`)
+	}
+	if opClass := s.OpClasses[0]; opClass != "" {
+		return pgerror.Newf(pgerror.CodeUndefinedObject,
+			`operator class %q does not exist for access method "hash"`, opClass)
+	}
+	if len(s.With) > 0 {
+		return pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", s.With[0].Name)
+	}
+	return nil
+}
+
+// hashConstraint returns the values the column of the hash index idx must
+// equal, or nil if the constraints allow any value. A hash index has no
+// entries to find NULLs by.
+func hashConstraint(t *catalog.Table, idx *catalog.Index, cons map[int]*colConstraint) []types.Datum {
+	c := cons[t.ColumnOrdinal(idx.ColumnIDs[0])]
+	if c == nil || slices.Contains(c.eq, types.DNull) {
+		return nil
+	}
+	return c.eq
+}
+
+// hashSpans returns the spans of the digests of the values the column of
+// the hash index idx must equal. Values sharing a digest share a span. It
+// returns nil if there would be too many spans.
+func hashSpans(t *catalog.Table, idx *catalog.Index, cons map[int]*colConstraint) ([]Span, error) {
+	vals := hashConstraint(t, idx, cons)
+	if len(vals) > maxSpans {
+		return nil, nil
+	}
+	spans := []Span{}
+	for _, v := range vals {
+		prefix, err := rowcodec.EncodeHashPrefix(t, idx, v)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(spans, func(s Span) bool { return bytes.Equal(s.Start, prefix) }) {
+			continue
+		}
+		desc := formatDatum(v)
+		spans = append(spans, Span{Start: prefix, End: rowcodec.PrefixEnd(prefix), Desc: "[" + desc + " - " + desc + "]"})
+	}
+	return spans, nil
+}
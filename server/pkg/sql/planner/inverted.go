@@ -0,0 +1,135 @@
+package planner
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// ginOpClasses maps the GIN operator classes to the family of the type
+// they index. Each is its type's default.
+var ginOpClasses = map[string]types.Family{
+	"gin_hstore_ops": types.HstoreFamily,
+}
+
+// checkGIN checks a GIN index definition.
+func checkGIN(s *parser.CreateIndexStmt, t *catalog.Table) error {
+	if s.Unique {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, `access method "gin" does not support unique indexes`)
+	}
+	if len(s.Columns) != 1 {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, `access method "gin" does not support multicolumn indexes`)
+	}
+	col := t.Columns[t.FindColumn(s.Columns[0])]
+	if opClass := s.OpClasses[0]; opClass != "" {
+		family, ok := ginOpClasses[opClass]
+		if !ok {
+			return pgerror.Newf(pgerror.CodeUndefinedObject,
+				`operator class %q does not exist for access method "gin"`, opClass)
+		}
+		if col.Type.Family != family {
+			return pgerror.Newf(pgerror.CodeDatatypeMismatch,
+				"operator class %q does not accept data type %s", opClass, col.Type)
+		}
+	} else if col.Type.Family != types.HstoreFamily {
+		return pgerror.Newf(pgerror.CodeUndefinedObject,
+			`data type %s has no default operator class for access method "gin"`, col.Type)
+	}
+	if len(s.With) > 0 {
+		return pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", s.With[0].Name)
+	}
+	return nil
+}
+
+// scanTable returns a scan of t under filter. It reads the spans of the
+// index selectIndex picks, or, if that is the whole table, the entries of
+// an inverted index for the tokens filter requires rows to have, or the
+// ranges of rows a BRIN index does not rule out, or else the whole table,
+// split into shards if it is large.
+func scanTable(t *catalog.Table, filter eval.Expr) (Node, error) {
+	scan := &Scan{Table: t, Filter: filter}
+	var err error
+	if scan.Index, scan.Spans, err = selectIndex(t, filter); err != nil {
+		return nil, err
+	}
+	scan.Parameterized = scan.Spans == nil
+	if isFullScan(scan) {
+		if n := planInvertedScan(scan); n != nil {
+			return n, nil
+		}
+		if n := planBRINScan(scan); n != nil {
+			return n, nil
+		}
+		planShards(scan)
+	}
+	return scan, nil
+}
+
+// planInvertedScan returns a scan of the first inverted index for which
+// the filter of scan requires tokens, or nil if there is none. The
+// conjuncts that require tokens are
+//
+//	col @> const, const <@ col, col ? const
+//
+// where const is a non-NULL constant.
+func planInvertedScan(scan *Scan) *InvertedScan {
+	t := scan.Table
+	for _, idx := range t.Indexes {
+		if !idx.Inverted {
+			continue
+		}
+		ord := t.ColumnOrdinal(idx.ColumnIDs[0])
+		n := &InvertedScan{Table: t, Index: idx, Filter: scan.Filter}
+		seen := make(map[string]bool)
+		add := func(token []byte, desc string) {
+			if !seen[string(token)] {
+				seen[string(token)] = true
+				prefix := rowcodec.EncodeInvertedPrefix(t, idx, token)
+				n.Spans = append(n.Spans, Span{Start: prefix, End: rowcodec.PrefixEnd(prefix), Desc: desc})
+			}
+		}
+		for _, c := range conjuncts(scan.Filter) {
+			op, d, ok := columnOpConst(c, ord)
+			if !ok {
+				continue
+			}
+			switch op {
+			case "@>":
+				for _, p := range d.(*types.DHstore).Pairs {
+					add(rowcodec.HstorePairToken(p), formatDatum(types.DString(types.NewDHstore([]types.HstorePair{p}).String())))
+				}
+			case "?":
+				add(rowcodec.HstoreKeyToken(string(d.(types.DString))), formatDatum(d))
+			}
+		}
+		if len(n.Spans) > 0 {
+			return n
+		}
+	}
+	return nil
+}
+
+// columnOpConst matches a binary operator applied to the column ord and a
+// non-NULL constant, normalizing <@ with the column on the right to @>.
+func columnOpConst(e eval.Expr, ord int) (string, types.Datum, bool) {
+	b, ok := e.(*eval.BinaryExpr)
+	if !ok {
+		return "", nil, false
+	}
+	l, r, op := b.Left, b.Right, b.Op
+	if op == "<@" {
+		l, r, op = r, l, "@>"
+	}
+	ref, ok := l.(*eval.ColumnRef)
+	if !ok || ref.Idx != ord {
+		return "", nil, false
+	}
+	k, ok := r.(*eval.Const)
+	if !ok || k.Datum == types.DNull {
+		return "", nil, false
+	}
+	return op, k.Datum, true
+}
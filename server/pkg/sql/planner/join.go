@@ -0,0 +1,612 @@
+package planner
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+var joinTypes = map[parser.JoinType]JoinType{
+	parser.InnerJoin: InnerJoin,
+	parser.LeftJoin:  LeftJoin,
+	parser.RightJoin: RightJoin,
+	parser.FullJoin:  FullJoin,
+	parser.CrossJoin: InnerJoin,
+}
+
+func (t JoinType) String() string {
+	switch t {
+	case LeftJoin:
+		return "Left"
+	case RightJoin:
+		return "Right"
+	case FullJoin:
+		return "Full"
+	case SemiJoin:
+		return "Semi"
+	case AntiJoin:
+		return "Anti"
+	}
+	return "Inner"
+}
+
+// planFrom plans a FROM clause that joins tables, filtered by where, and
+// returns it with the scope of its columns. Items separated by commas are
+// cross joined. Each conjunct of the join conditions and of where is pushed
+// down to the lowest point where it can be evaluated, so filters on one
+// table narrow its scan, before inner joins of analyzed tables are
+// reordered and the join algorithms are chosen.
+func (p *Planner) planFrom(from []parser.TableExpr, where parser.Expr) (Node, *scope, error) {
+	var n Node
+	var sc *scope
+	for i, te := range from {
+		right, rs, err := p.planTableExpr(te)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 {
+			n, sc = right, rs
+			continue
+		}
+		if sc, err = joinScopes(sc, rs); err != nil {
+			return nil, nil, err
+		}
+		n = &Join{Type: InnerJoin, Left: n, Right: right}
+	}
+	if where != nil {
+		pred, err := p.typeCheckPredicate(where, sc, "WHERE")
+		if err != nil {
+			return nil, nil, err
+		}
+		// Conjuncts with correlated subqueries are evaluated over the
+		// rows of the whole FROM clause, unless they can be rewritten as
+		// joins.
+		var plain, correlated, rest []eval.Expr
+		for _, e := range conjuncts(pred) {
+			if isCorrelated(e) {
+				correlated = append(correlated, e)
+			} else {
+				plain = append(plain, e)
+			}
+		}
+		if n, err = pushFilter(n, plain); err != nil {
+			return nil, nil, err
+		}
+		for _, e := range correlated {
+			j, err := decorrelate(n, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			if j == nil {
+				rest = append(rest, e)
+				continue
+			}
+			n = j
+		}
+		return filtered(planJoins(reorderJoins(n)), rest), sc, nil
+	}
+	return planJoins(reorderJoins(n)), sc, nil
+}
+
+func (p *Planner) planTableExpr(te parser.TableExpr) (Node, *scope, error) {
+	switch te := te.(type) {
+	case *parser.TableName:
+		return p.planTableName(te, nil)
+	case *parser.JoinExpr:
+		return p.planJoin(te)
+	}
+	return nil, nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "unsupported FROM item %T", te)
+}
+
+// joinScopes returns the scope of the rows of a join of l and r.
+func joinScopes(l, r *scope) (*scope, error) {
+	for _, c := range r.cols {
+		if c.table != "" && l.hasTable(c.table) {
+			return nil, pgerror.Newf(pgerror.CodeDuplicateAlias, "table name %q specified more than once", c.table)
+		}
+	}
+	return &scope{cols: slices.Concat(l.cols, r.cols), qualified: true}, nil
+}
+
+func (p *Planner) planJoin(j *parser.JoinExpr) (Node, *scope, error) {
+	left, ls, err := p.planTableExpr(j.Left)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, rs, err := p.planTableExpr(j.Right)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc, err := joinScopes(ls, rs)
+	if err != nil {
+		return nil, nil, err
+	}
+	n := &Join{Type: joinTypes[j.Type], Left: left, Right: right}
+	using := j.Using
+	if j.Natural {
+		using = commonColumns(ls, rs)
+	}
+	if len(using) > 0 {
+		return p.planJoinUsing(n, using, ls, rs)
+	}
+	if j.On == nil {
+		return n, sc, nil
+	}
+	pred, err := p.typeCheckPredicate(j.On, sc, "JOIN/ON")
+	if err != nil {
+		return nil, nil, err
+	}
+	if isCorrelated(pred) {
+		return nil, nil, pgerror.New(pgerror.CodeFeatureNotSupported,
+			"subqueries referring to the joined tables are not supported in JOIN conditions")
+	}
+	out, err := pushIntoJoin(n, conjuncts(pred), false)
+	return out, sc, err
+}
+
+// commonColumns returns the column names NATURAL JOIN joins on: those
+// visible on both sides, in left order.
+func commonColumns(ls, rs *scope) []string {
+	var names []string
+	for _, lc := range ls.cols {
+		if lc.hidden || lc.implicit || slices.Contains(names, lc.name) {
+			continue
+		}
+		for _, rc := range rs.cols {
+			if !rc.hidden && !rc.implicit && rc.name == lc.name {
+				names = append(names, lc.name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// planJoinUsing plans JOIN ... USING (names). As in PostgreSQL, the join
+// produces each USING column once, before the other columns; for a FULL
+// join it is the first non-NULL of the two inputs. The input columns
+// remain visible when qualified by their table.
+func (p *Planner) planJoinUsing(n *Join, names []string, ls, rs *scope) (Node, *scope, error) {
+	nl := len(ls.cols)
+	proj := &Project{}
+	sc := &scope{qualified: true}
+	lUsed, rUsed := map[int]bool{}, map[int]bool{}
+	var conds []eval.Expr
+	for i, name := range names {
+		if slices.Contains(names[:i], name) {
+			return nil, nil, pgerror.Newf(pgerror.CodeDuplicateColumn, "column name %q appears more than once in USING clause", name)
+		}
+		li, err := usingColumn(ls, name, "left")
+		if err != nil {
+			return nil, nil, err
+		}
+		ri, err := usingColumn(rs, name, "right")
+		if err != nil {
+			return nil, nil, err
+		}
+		lc, rc := &ls.cols[li], &rs.cols[ri]
+		typ, err := eval.CommonType("JOIN/USING", []*types.T{lc.typ, rc.typ})
+		if err != nil {
+			return nil, nil, err
+		}
+		var l, r eval.Expr = &eval.ColumnRef{Idx: li, Name: sc.columnName(lc), Typ: lc.typ},
+			&eval.ColumnRef{Idx: nl + ri, Name: sc.columnName(rc), Typ: rc.typ}
+		if l, err = eval.Coerce(l, typ); err != nil {
+			return nil, nil, err
+		}
+		if r, err = eval.Coerce(r, typ); err != nil {
+			return nil, nil, err
+		}
+		conds = append(conds, &eval.ComparisonExpr{Op: eval.EQ, Left: l, Right: r})
+		merged := l
+		switch n.Type {
+		case RightJoin:
+			merged = r
+		case FullJoin:
+			merged = &eval.CoalesceExpr{Exprs: []eval.Expr{l, r}, Typ: typ}
+		}
+		proj.Exprs = append(proj.Exprs, merged)
+		proj.Cols = append(proj.Cols, Column{Name: name, Type: typ})
+		sc.cols = append(sc.cols, scopeColumn{name: name, typ: typ})
+		lUsed[li], rUsed[ri] = true, true
+	}
+	add := func(cols []scopeColumn, offset int, used map[int]bool) {
+		for i, c := range cols {
+			proj.Exprs = append(proj.Exprs, &eval.ColumnRef{Idx: offset + i, Name: sc.columnName(&c), Typ: c.typ})
+			proj.Cols = append(proj.Cols, Column{Name: c.name, Type: c.typ})
+			c.hidden = c.hidden || used[i]
+			sc.cols = append(sc.cols, c)
+		}
+	}
+	add(ls.cols, 0, lUsed)
+	add(rs.cols, nl, rUsed)
+	var err error
+	if proj.Input, err = pushIntoJoin(n, conds, false); err != nil {
+		return nil, nil, err
+	}
+	return proj, sc, nil
+}
+
+// usingColumn returns the ordinal of the visible column name on one side
+// of a USING join.
+func usingColumn(s *scope, name, side string) (int, error) {
+	found := -1
+	for i, c := range s.cols {
+		if c.hidden || c.name != name {
+			continue
+		}
+		if found >= 0 {
+			return 0, pgerror.Newf(pgerror.CodeAmbiguousColumn,
+				"common column name %q appears more than once in %s table", name, side)
+		}
+		found = i
+	}
+	if found < 0 {
+		return 0, pgerror.Newf(pgerror.CodeUndefinedColumn,
+			"column %q specified in USING clause does not exist in %s table", name, side)
+	}
+	return found, nil
+}
+
+// pushFilter returns n filtered by the conjunction of preds, which refer to
+// the columns of n. Each predicate is evaluated as close to the scans as
+// the join types allow.
+func pushFilter(n Node, preds []eval.Expr) (Node, error) {
+	if len(preds) == 0 {
+		return n, nil
+	}
+	switch n := n.(type) {
+	case *Scan:
+		return scanTable(n.Table, andAll(append([]eval.Expr{n.Filter}, preds...)))
+	case *InvertedScan:
+		return scanTable(n.Table, andAll(append([]eval.Expr{n.Filter}, preds...)))
+	case *BRINScan:
+		return scanTable(n.Table, andAll(append([]eval.Expr{n.Filter}, preds...)))
+	case *VirtualScan:
+		c := *n
+		c.Filter = andAll(append([]eval.Expr{n.Filter}, preds...))
+		return &c, nil
+	case *Join:
+		return pushIntoJoin(n, preds, true)
+	case *Filter:
+		c := *n
+		var err error
+		if c.Input, err = pushFilter(n.Input, preds); err != nil {
+			return nil, err
+		}
+		return &c, nil
+	case *Project:
+		// Predicates over columns the projection passes through can be
+		// evaluated below it.
+		var below, above []eval.Expr
+		for _, e := range preds {
+			ok := true
+			sub := mapColumns(e, func(ref *eval.ColumnRef) eval.Expr {
+				if in, isRef := n.Exprs[ref.Idx].(*eval.ColumnRef); isRef {
+					return in
+				}
+				ok = false
+				return ref
+			})
+			if ok {
+				below = append(below, sub)
+			} else {
+				above = append(above, e)
+			}
+		}
+		c := *n
+		var err error
+		if c.Input, err = pushFilter(n.Input, below); err != nil {
+			return nil, err
+		}
+		return filtered(&c, above), nil
+	}
+	return filtered(n, preds), nil
+}
+
+// pushIntoJoin adds preds to n: those over one input are pushed into it
+// where that does not change which rows the join returns, and the rest
+// become join conditions or, for outer joins filtered by WHERE, a filter
+// over the join. where distinguishes WHERE conjuncts from ON conditions:
+// an ON condition on the preserved side of an outer join only decides
+// which rows match, so it cannot filter that side.
+func pushIntoJoin(n *Join, preds []eval.Expr, where bool) (Node, error) {
+	nl := len(n.Left.Columns())
+	var pushLeft, pushRight bool
+	switch n.Type {
+	case InnerJoin, SemiJoin:
+		pushLeft, pushRight = true, true
+	case LeftJoin:
+		pushLeft, pushRight = where, !where
+	case RightJoin:
+		pushLeft, pushRight = !where, where
+	case AntiJoin:
+		// WHERE conjuncts over an anti join only refer to the left rows.
+		pushLeft, pushRight = where, true
+	}
+	var left, right, on, above []eval.Expr
+	for _, e := range preds {
+		lo, hi := columnRange(e)
+		switch {
+		case lo >= 0 && hi < nl && pushLeft:
+			left = append(left, e)
+		case lo >= nl && pushRight:
+			right = append(right, shiftColumns(e, -nl))
+		case where && n.Type != InnerJoin:
+			above = append(above, e)
+		default:
+			on = append(on, e)
+		}
+	}
+	c := *n
+	c.On = andAll(append([]eval.Expr{n.On}, on...))
+	var err error
+	if c.Left, err = pushFilter(n.Left, left); err != nil {
+		return nil, err
+	}
+	if c.Right, err = pushFilter(n.Right, right); err != nil {
+		return nil, err
+	}
+	return filtered(&c, above), nil
+}
+
+func filtered(n Node, preds []eval.Expr) Node {
+	if len(preds) == 0 {
+		return n
+	}
+	return &Filter{Input: n, Pred: andAll(preds)}
+}
+
+// planJoins chooses how each join in n is executed. Equality conditions
+// between the inputs become hash join keys, or lookup keys when they give
+// the leading columns of an index of a table that would otherwise be read
+// in full. Joins without such conditions are nested loop joins.
+func planJoins(n Node) Node {
+	switch n := n.(type) {
+	case *Join:
+		left, right := planJoins(n.Left), planJoins(n.Right)
+		nl := len(left.Columns())
+		j := &Join{Type: n.Type, Left: left, Right: right}
+		// A join planned before, as in a decorrelated subquery, is
+		// planned again from all of its conditions.
+		conds := conjuncts(n.On)
+		for i := range n.LeftKeys {
+			conds = append(conds, &eval.ComparisonExpr{Op: eval.EQ, Left: n.LeftKeys[i], Right: shiftColumns(n.RightKeys[i], nl)})
+		}
+		var keyConds, rest []eval.Expr
+		for _, e := range conds {
+			if l, r, ok := equiJoinKey(e, nl); ok {
+				j.LeftKeys = append(j.LeftKeys, l)
+				j.RightKeys = append(j.RightKeys, shiftColumns(r, -nl))
+				keyConds = append(keyConds, e)
+				continue
+			}
+			rest = append(rest, e)
+		}
+		j.On = andAll(rest)
+		if len(keyConds) == 0 {
+			return j
+		}
+		if lj := planLookupJoin(j, keyConds); lj != nil {
+			return lj
+		}
+		return j
+	case *Project:
+		c := *n
+		c.Input = planJoins(n.Input)
+		return &c
+	case *Filter:
+		c := *n
+		c.Input = planJoins(n.Input)
+		return &c
+	}
+	return n
+}
+
+// equiJoinKey matches an equality between an expression over the left
+// input and one over the right input, of the same family so their key
+// encodings agree.
+func equiJoinKey(e eval.Expr, nl int) (left, right eval.Expr, ok bool) {
+	cmp, isCmp := e.(*eval.ComparisonExpr)
+	if !isCmp || cmp.Op != eval.EQ {
+		return nil, nil, false
+	}
+	left, right = cmp.Left, cmp.Right
+	if lo, _ := columnRange(left); lo >= nl {
+		left, right = right, left
+	}
+	llo, lhi := columnRange(left)
+	rlo, _ := columnRange(right)
+	if llo < 0 || lhi >= nl || rlo < nl || left.ResolvedType().Family != right.ResolvedType().Family {
+		return nil, nil, false
+	}
+	return left, right, true
+}
+
+// planLookupJoin returns a lookup join for j if one side is a full scan of
+// a table with an index whose leading columns are given by the keys. The
+// right side is preferred; an inner join may instead look up the left.
+func planLookupJoin(j *Join, keyConds []eval.Expr) Node {
+	if scan, ok := j.Right.(*Scan); ok && isFullScan(scan) && j.Type != RightJoin && j.Type != FullJoin {
+		if lj := newLookupJoin(j.Type, j.Left, scan, j.LeftKeys, j.RightKeys, keyConds, j.On); lj != nil {
+			return lj
+		}
+	}
+	scan, ok := j.Left.(*Scan)
+	if !ok || !isFullScan(scan) || (j.Type != InnerJoin && j.Type != RightJoin) {
+		return nil
+	}
+	// Look up the left table for each right row, then restore the column
+	// order of the join.
+	nl, nr := len(j.Left.Columns()), len(j.Right.Columns())
+	swap := func(e eval.Expr) eval.Expr {
+		return mapColumns(e, func(ref *eval.ColumnRef) eval.Expr {
+			c := *ref
+			if c.Idx < nl {
+				c.Idx += nr
+			} else {
+				c.Idx -= nl
+			}
+			return &c
+		})
+	}
+	swapped := make([]eval.Expr, len(keyConds))
+	for i, e := range keyConds {
+		swapped[i] = swap(e)
+	}
+	var on eval.Expr
+	if j.On != nil {
+		on = swap(j.On)
+	}
+	lj := newLookupJoin(LeftJoin, j.Right, scan, j.RightKeys, j.LeftKeys, swapped, on)
+	if lj == nil {
+		return nil
+	}
+	if j.Type == InnerJoin {
+		lj.Type = InnerJoin
+	}
+	cols := lj.Columns()
+	proj := &Project{Input: lj}
+	for i := range nl + nr {
+		src := nr + i
+		if i >= nl {
+			src = i - nl
+		}
+		proj.Exprs = append(proj.Exprs, &eval.ColumnRef{Idx: src, Name: cols[src].Name, Typ: cols[src].Type})
+		proj.Cols = append(proj.Cols, cols[src])
+	}
+	return proj
+}
+
+// newLookupJoin returns a join of input with the table read by scan,
+// looking up the rows whose columns tableKeys equal inputKeys. keyConds
+// are the equalities the keys come from, over the input columns followed
+// by the table columns, as is on. It returns nil if no index of the table
+// starts with a key column.
+func newLookupJoin(typ JoinType, input Node, scan *Scan, inputKeys, tableKeys, keyConds []eval.Expr, on eval.Expr) *LookupJoin {
+	t := scan.Table
+	idx, keys := lookupIndex(t, tableKeys)
+	if idx == nil {
+		return nil
+	}
+	lj := &LookupJoin{Type: typ, Input: input, Table: t, Index: idx}
+	var rest []eval.Expr
+	for _, k := range keys {
+		lj.Keys = append(lj.Keys, inputKeys[k])
+	}
+	for k, e := range keyConds {
+		if !slices.Contains(keys, k) {
+			rest = append(rest, e)
+		}
+	}
+	rest = append(rest, on)
+	if scan.Filter != nil {
+		rest = append(rest, shiftColumns(scan.Filter, len(input.Columns())))
+	}
+	lj.On = andAll(rest)
+	return lj
+}
+
+// lookupIndex returns the index of t that the most key columns can be
+// looked up in, preferring unique indexes whose columns are all given, and
+// for each of its leading columns the position of the key giving it.
+func lookupIndex(t *catalog.Table, tableKeys []eval.Expr) (*catalog.Index, []int) {
+	ords := make([]int, len(tableKeys))
+	for i, k := range tableKeys {
+		ords[i] = -1
+		if ref, ok := k.(*eval.ColumnRef); ok {
+			ords[i] = ref.Idx
+		}
+	}
+	var best *catalog.Index
+	var bestKeys []int
+	bestUnique := false
+	for _, idx := range t.AllIndexes() {
+		if !idx.Ordered() {
+			continue
+		}
+		var keys []int
+		for _, ord := range t.ColumnOrdinals(idx) {
+			k := slices.Index(ords, ord)
+			if k < 0 {
+				break
+			}
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		unique := idx.Unique && len(keys) == len(idx.ColumnIDs)
+		if best == nil || (unique && !bestUnique) || (unique == bestUnique && len(keys) > len(bestKeys)) {
+			best, bestKeys, bestUnique = idx, keys, unique
+		}
+	}
+	return best, bestKeys
+}
+
+func isFullScan(s *Scan) bool {
+	full := fullSpan(s.Table, s.Index)
+	return len(s.Spans) == 1 && bytes.Equal(s.Spans[0].Start, full.Start) && bytes.Equal(s.Spans[0].End, full.End)
+}
+
+// columnRange returns the lowest and highest column ordinals e refers to,
+// or -1, -1 if it refers to none.
+func columnRange(e eval.Expr) (lo, hi int) {
+	lo, hi = -1, -1
+	eval.Walk(e, func(e eval.Expr) bool {
+		if ref, ok := e.(*eval.ColumnRef); ok {
+			if lo < 0 || ref.Idx < lo {
+				lo = ref.Idx
+			}
+			hi = max(hi, ref.Idx)
+		}
+		return true
+	})
+	return lo, hi
+}
+
+// mapColumns returns e with each column reference replaced by fn(ref).
+func mapColumns(e eval.Expr, fn func(*eval.ColumnRef) eval.Expr) eval.Expr {
+	if ref, ok := e.(*eval.ColumnRef); ok {
+		return fn(ref)
+	}
+	children := eval.Children(e)
+	if len(children) == 0 {
+		return e
+	}
+	mapped := make([]eval.Expr, len(children))
+	for i, c := range children {
+		mapped[i] = mapColumns(c, fn)
+	}
+	return eval.WithChildren(e, mapped)
+}
+
+// shiftColumns returns e with delta added to its column ordinals.
+func shiftColumns(e eval.Expr, delta int) eval.Expr {
+	return mapColumns(e, func(ref *eval.ColumnRef) eval.Expr {
+		c := *ref
+		c.Idx += delta
+		return &c
+	})
+}
+
+// andAll returns the conjunction of the non-nil exprs, or nil if there
+// are none.
+func andAll(exprs []eval.Expr) eval.Expr {
+	var out eval.Expr
+	for _, e := range exprs {
+		switch {
+		case e == nil:
+		case out == nil:
+			out = e
+		default:
+			out = &eval.AndExpr{Left: out, Right: e}
+		}
+	}
+	return out
+}
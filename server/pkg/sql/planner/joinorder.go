@@ -0,0 +1,200 @@
+package planner
+
+import (
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+)
+
+// joinLeaf is a table read by a tree of inner joins.
+type joinLeaf struct {
+	n      Node
+	t      *catalog.Table
+	rows   float64
+	offset int
+	width  int
+}
+
+// joinCond is a condition of a tree of inner joins, over the columns of
+// all its tables, and the tables it refers to.
+type joinCond struct {
+	e      eval.Expr
+	leaves []int
+}
+
+// reorderJoins reorders the trees of inner joins in n, the plan of a FROM
+// clause, by the estimated numbers of rows they produce, if all tables they
+// join have been analyzed. Tables are joined greedily: first the one with
+// the fewest rows selected, then each time the one joined by a condition
+// that gives the fewest rows. A projection restores the column order of
+// the FROM clause.
+func reorderJoins(n Node) Node {
+	switch n := n.(type) {
+	case *Join:
+		if n.Type != InnerJoin || len(n.LeftKeys) > 0 {
+			c := *n
+			c.Left, c.Right = reorderJoins(n.Left), reorderJoins(n.Right)
+			return &c
+		}
+		var leaves []*joinLeaf
+		var conds []eval.Expr
+		if !flattenJoins(n, 0, &leaves, &conds) {
+			return n
+		}
+		return joinInOrder(n, leaves, conds)
+	case *Filter:
+		c := *n
+		c.Input = reorderJoins(n.Input)
+		return &c
+	}
+	return n
+}
+
+// flattenJoins collects the tables joined by the tree of inner joins n,
+// whose columns start at offset, and their conditions over the columns of
+// the whole tree. It reports whether all tables have been analyzed.
+func flattenJoins(n Node, offset int, leaves *[]*joinLeaf, conds *[]eval.Expr) bool {
+	if j, ok := n.(*Join); ok && j.Type == InnerJoin && len(j.LeftKeys) == 0 {
+		if j.On != nil {
+			*conds = append(*conds, conjuncts(shiftColumns(j.On, offset))...)
+		}
+		return flattenJoins(j.Left, offset, leaves, conds) &&
+			flattenJoins(j.Right, offset+len(j.Left.Columns()), leaves, conds)
+	}
+	t, filter, ok := analyzed(n)
+	if !ok {
+		return false
+	}
+	*leaves = append(*leaves, &joinLeaf{
+		n:      n,
+		t:      t,
+		rows:   filteredRows(t, constraints(t, filter, nil)),
+		offset: offset,
+		width:  len(n.Columns()),
+	})
+	return true
+}
+
+// joinInOrder returns the join of leaves in the order that gives the
+// fewest rows, or the tree n they came from if that is its order.
+func joinInOrder(n *Join, leaves []*joinLeaf, exprs []eval.Expr) Node {
+	leafOf := func(col int) int {
+		return slices.IndexFunc(leaves, func(l *joinLeaf) bool { return col >= l.offset && col < l.offset+l.width })
+	}
+	conds := make([]*joinCond, len(exprs))
+	for i, e := range exprs {
+		c := &joinCond{e: e}
+		eval.Walk(e, func(e eval.Expr) bool {
+			if ref, ok := e.(*eval.ColumnRef); ok {
+				if l := leafOf(ref.Idx); !slices.Contains(c.leaves, l) {
+					c.leaves = append(c.leaves, l)
+				}
+			}
+			return true
+		})
+		conds[i] = c
+	}
+	joined := make([]bool, len(leaves))
+	// selectivity estimates the fraction of the rows of the cross join of
+	// the joined tables and leaf next that the conditions between them
+	// keep, and reports whether there are any.
+	selectivity := func(next int) (float64, bool) {
+		sel, connected := 1.0, false
+		for _, c := range conds {
+			if !slices.Contains(c.leaves, next) || slices.ContainsFunc(c.leaves, func(l int) bool { return l != next && !joined[l] }) {
+				continue
+			}
+			connected = connected || len(c.leaves) > 1
+			sel *= condSelectivity(c.e, leaves, leafOf)
+		}
+		return sel, connected
+	}
+	var order []int
+	rows := 0.0
+	for len(order) < len(leaves) {
+		best, bestRows, bestConnected := -1, 0.0, false
+		for i, l := range leaves {
+			if joined[i] {
+				continue
+			}
+			est, connected := l.rows, len(order) > 0
+			if len(order) > 0 {
+				var sel float64
+				sel, connected = selectivity(i)
+				est = rows * l.rows * sel
+			}
+			if best < 0 || connected && !bestConnected || connected == bestConnected && est < bestRows {
+				best, bestRows, bestConnected = i, est, connected
+			}
+		}
+		order = append(order, best)
+		joined[best] = true
+		rows = bestRows
+	}
+	if slices.IsSorted(order) {
+		return n
+	}
+	// Columns move to the positions of their tables in the new order.
+	newOffset := make([]int, len(leaves))
+	pos := 0
+	for _, i := range order {
+		newOffset[i] = pos
+		pos += leaves[i].width
+	}
+	remap := func(e eval.Expr) eval.Expr {
+		return mapColumns(e, func(ref *eval.ColumnRef) eval.Expr {
+			c := *ref
+			l := leafOf(ref.Idx)
+			c.Idx += newOffset[l] - leaves[l].offset
+			return &c
+		})
+	}
+	clear(joined)
+	placed := make([]bool, len(conds))
+	var tree Node
+	for _, i := range order {
+		joined[i] = true
+		if tree == nil {
+			tree = leaves[i].n
+			continue
+		}
+		j := &Join{Type: InnerJoin, Left: tree, Right: leaves[i].n}
+		var on []eval.Expr
+		for k, c := range conds {
+			if !placed[k] && !slices.ContainsFunc(c.leaves, func(l int) bool { return !joined[l] }) {
+				on = append(on, remap(c.e))
+				placed[k] = true
+			}
+		}
+		j.On = andAll(on)
+		tree = j
+	}
+	cols := n.Columns()
+	proj := &Project{Input: tree, Cols: cols}
+	for i, c := range cols {
+		l := leafOf(i)
+		proj.Exprs = append(proj.Exprs, &eval.ColumnRef{Idx: i + newOffset[l] - leaves[l].offset, Name: c.Name, Typ: c.Type})
+	}
+	return proj
+}
+
+// condSelectivity estimates the fraction of rows a join condition keeps.
+// An equality of columns of two tables matches each value of the column
+// with fewer distinct values to one of the other's.
+func condSelectivity(e eval.Expr, leaves []*joinLeaf, leafOf func(int) int) float64 {
+	cmp, ok := e.(*eval.ComparisonExpr)
+	if !ok || cmp.Op != eval.EQ {
+		return defaultJoinSelectivity
+	}
+	l, lok := cmp.Left.(*eval.ColumnRef)
+	r, rok := cmp.Right.(*eval.ColumnRef)
+	if !lok || !rok || leafOf(l.Idx) == leafOf(r.Idx) {
+		return defaultJoinSelectivity
+	}
+	distinct := func(ref *eval.ColumnRef) float64 {
+		leaf := leaves[leafOf(ref.Idx)]
+		return distinctValues(leaf.t, ref.Idx-leaf.offset, leaf.rows)
+	}
+	return 1 / max(distinct(l), distinct(r))
+}
@@ -0,0 +1,167 @@
+package planner
+
+import (
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+)
+
+// looseDistinct makes the scan under proj, the select list of a SELECT
+// DISTINCT, a loose index scan if the list only depends on columns that
+// lead an index: each distinct value of them is then read once, and the
+// Distinct above still removes rows whose keys differ but whose values
+// are equal.
+func looseDistinct(proj *Project) {
+	scan, ok := proj.Input.(*Scan)
+	if !ok {
+		return
+	}
+	var ords []int
+	immutable := true
+	for _, e := range proj.Exprs {
+		eval.Walk(e, func(e eval.Expr) bool {
+			switch e := e.(type) {
+			case *eval.ColumnRef:
+				if !slices.Contains(ords, e.Idx) {
+					ords = append(ords, e.Idx)
+				}
+			case *eval.FuncExpr:
+				immutable = immutable && e.Overload.Volatility == eval.Immutable
+			case *eval.SubqueryExpr:
+				immutable = false
+			}
+			return immutable
+		})
+	}
+	if !immutable || len(ords) == 0 {
+		return
+	}
+	for _, idx := range scan.Table.AllIndexes() {
+		if looseIndex(scan, idx, ords) && useLoose(scan, idx, len(ords)) && setLoose(scan, idx, len(ords)) {
+			return
+		}
+	}
+}
+
+// looseAggregate makes the scan under agg a loose index scan if agg only
+// computes min or max of one column per group, and an index leads with
+// the grouping columns followed by that column in the direction that
+// puts the result first: ascending for min, descending for max. max needs
+// a column without NULLs, which a descending index puts first. Without
+// GROUP BY, the scan stops after its first row.
+func looseAggregate(agg *Aggregate) {
+	scan, ok := agg.Input.(*Scan)
+	if !ok || len(agg.Aggs) == 0 {
+		return
+	}
+	t := scan.Table
+	var ords []int
+	for _, e := range agg.GroupBy {
+		ref, ok := e.(*eval.ColumnRef)
+		if !ok {
+			return
+		}
+		if !slices.Contains(ords, ref.Idx) {
+			ords = append(ords, ref.Idx)
+		}
+	}
+	col, desc := -1, false
+	for i, a := range agg.Aggs {
+		if a.Name != "min" && a.Name != "max" || len(a.Args) != 1 {
+			return
+		}
+		ref, ok := a.Args[0].(*eval.ColumnRef)
+		if !ok || i > 0 && (ref.Idx != col || (a.Name == "max") != desc) {
+			return
+		}
+		col, desc = ref.Idx, a.Name == "max"
+	}
+	c := t.Columns[col]
+	if c.Type.Extension() != nil || desc && c.Nullable || slices.Contains(ords, col) {
+		return
+	}
+	for _, idx := range t.AllIndexes() {
+		cols := t.ColumnOrdinals(idx)
+		if len(cols) <= len(ords) || cols[len(ords)] != col || idx.Desc(len(ords)) != desc {
+			continue
+		}
+		if looseIndex(scan, idx, ords) && useLoose(scan, idx, len(ords)) && setLoose(scan, idx, len(ords)) {
+			return
+		}
+	}
+}
+
+// looseIndex reports whether idx can replace the index of scan in a loose
+// scan by the columns ords, which must be its leading columns in some
+// order. Like scanInOrder, it keeps the scan as narrow as it is.
+func looseIndex(scan *Scan, idx *catalog.Index, ords []int) bool {
+	t := scan.Table
+	cols := t.ColumnOrdinals(idx)
+	if !idx.Ordered() || len(cols) < len(ords) {
+		return false
+	}
+	for _, ord := range cols[:len(ords)] {
+		// Keys of registered types do not tell whether values are equal.
+		if !slices.Contains(ords, ord) || t.Columns[ord].Type.Extension() != nil {
+			return false
+		}
+	}
+	if idx == scan.Index {
+		return true
+	}
+	score := 0
+	if !isFullScan(scan) {
+		score = indexScore(t, scan.Index, constraints(t, scan.Filter, nil))
+	}
+	return indexScore(t, idx, constraints(t, scan.Filter, nil)) == score
+}
+
+// useLoose reports whether a loose scan of idx by its first prefix
+// columns is worth it. For an analyzed table, seeking once per distinct
+// value of those columns must cost less than the scan does now. Otherwise
+// it is, unless the values are unique, so that there is nothing to skip.
+func useLoose(scan *Scan, idx *catalog.Index, prefix int) bool {
+	t := scan.Table
+	if t.Stats == nil {
+		return prefix == 0 || !idx.Unique || len(idx.ColumnIDs) > prefix
+	}
+	rows := tableRows(t)
+	groups := 1.0
+	for _, ord := range t.ColumnOrdinals(idx)[:prefix] {
+		groups *= distinctValues(t, ord, rows)
+	}
+	groups = min(groups, rows)
+	loose := groups * (spanCost + rowCost)
+	if idx != t.PrimaryIndex {
+		loose += groups * lookupCost
+	}
+	current := fullScanCost(t)
+	if !isFullScan(scan) {
+		current = indexCost(t, scan.Index, constraints(t, scan.Filter, nil))
+	}
+	return loose < current
+}
+
+// setLoose makes scan a loose scan of idx by its first prefix columns,
+// reporting whether it could.
+func setLoose(scan *Scan, idx *catalog.Index, prefix int) bool {
+	if idx != scan.Index {
+		t := scan.Table
+		cons := constraints(t, scan.Filter, nil)
+		spans := []Span{fullSpan(t, idx)}
+		if !isFullScan(scan) {
+			if hasParams(cons) {
+				spans = nil
+			} else {
+				var err error
+				if spans, err = indexSpans(t, idx, cons); err != nil || spans == nil {
+					return false
+				}
+			}
+		}
+		scan.Index, scan.Spans, scan.Parameterized = idx, spans, spans == nil
+	}
+	scan.Loose, scan.Prefix, scan.Shards = true, prefix, nil
+	return true
+}
@@ -0,0 +1,223 @@
+package planner
+
+import (
+	"strconv"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// planOrderBy resolves the ORDER BY items of s to columns of proj, the
+// select list, and returns the sort keys. A key that is not an output
+// column is appended to proj; the caller removes such columns after
+// sorting.
+func (p *Planner) planOrderBy(s *parser.SelectStmt, proj *Project, sc *scope) ([]SortKey, error) {
+	width := len(proj.Cols)
+	keys := make([]SortKey, len(s.OrderBy))
+	for i, item := range s.OrderBy {
+		col, err := p.orderByColumn(item.Expr, s, proj, width, sc)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = SortKey{
+			Col:        col,
+			Desc:       item.Desc,
+			NullsFirst: item.Nulls == parser.NullsFirst || item.Nulls == parser.NullsDefault && item.Desc,
+		}
+	}
+	return keys, nil
+}
+
+// orderByColumn resolves an ORDER BY item. As in PostgreSQL, an integer
+// constant is a select list position and a bare name is first looked up
+// among the output column names; anything else is an expression over the
+// input.
+func (p *Planner) orderByColumn(e parser.Expr, s *parser.SelectStmt, proj *Project, width int, sc *scope) (int, error) {
+	switch e := e.(type) {
+	case *parser.NumberLit:
+		pos, err := strconv.Atoi(e.Text)
+		if err != nil {
+			break
+		}
+		if pos < 1 || pos > width {
+			return 0, pgerror.Newf(pgerror.CodeInvalidColumnReference, "ORDER BY position %d is not in select list", pos)
+		}
+		return pos - 1, nil
+	case *parser.ColumnRef:
+		if e.Table != "" {
+			break
+		}
+		found := -1
+		for i, c := range proj.Cols[:width] {
+			if c.Name != e.Column {
+				continue
+			}
+			if found >= 0 && !sameExpr(proj.Exprs[found], proj.Exprs[i]) {
+				return 0, pgerror.Newf(pgerror.CodeAmbiguousColumn, "ORDER BY %q is ambiguous", e.Column)
+			}
+			if found < 0 {
+				found = i
+			}
+		}
+		if found >= 0 {
+			return found, nil
+		}
+	}
+	x, err := p.typeCheck(e, sc)
+	if err != nil {
+		return 0, err
+	}
+	if x.ResolvedType().Family == types.UnknownFamily {
+		if x, err = eval.Coerce(x, types.String); err != nil {
+			return 0, err
+		}
+	}
+	for i, pe := range proj.Exprs {
+		if sameExpr(x, pe) {
+			return i, nil
+		}
+	}
+	if s.Distinct {
+		return 0, pgerror.New(pgerror.CodeInvalidColumnReference,
+			"for SELECT DISTINCT, ORDER BY expressions must appear in select list")
+	}
+	proj.Exprs = append(proj.Exprs, x)
+	proj.Cols = append(proj.Cols, Column{Name: x.String(), Type: x.ResolvedType()})
+	return len(proj.Exprs) - 1, nil
+}
+
+// scanInOrder reports whether the scan under proj can return its rows in
+// the order of keys, so that they need not be sorted. If the scan's index
+// does not return that order, the scan is switched to one that does and
+// narrows the scan as much, if there is one. Indexes are only read
+// forward, so each key must have the direction of its index column, with
+// NULLs where that direction puts them. A scan that returns its rows in
+// order is not split into shards.
+func scanInOrder(proj *Project, keys []SortKey) bool {
+	scan, ok := proj.Input.(*Scan)
+	if !ok {
+		return false
+	}
+	t := scan.Table
+	ords := make([]int, len(keys))
+	for i, k := range keys {
+		ref, ok := proj.Exprs[k.Col].(*eval.ColumnRef)
+		// Keys of registered types are not ordered like their values.
+		if !ok || k.NullsFirst != k.Desc || t.Columns[ref.Idx].Type.Extension() != nil {
+			return false
+		}
+		ords[i] = ref.Idx
+	}
+	cons := constraints(t, scan.Filter, nil)
+	if indexOrder(t, scan.Index, cons, ords, keys) {
+		scan.Shards = nil
+		return true
+	}
+	// A loose scan needs its index.
+	if scan.Loose {
+		return false
+	}
+	score := 0
+	if !isFullScan(scan) {
+		score = indexScore(t, scan.Index, cons)
+	}
+	for _, idx := range t.AllIndexes() {
+		if !idx.Ordered() || indexScore(t, idx, cons) != score || !indexOrder(t, idx, cons, ords, keys) {
+			continue
+		}
+		spans := []Span{fullSpan(t, idx)}
+		switch {
+		case score > 0 && hasParams(cons):
+			spans = nil
+		case score > 0:
+			var err error
+			if spans, err = indexSpans(t, idx, cons); err != nil || spans == nil {
+				continue
+			}
+		}
+		scan.Index, scan.Spans, scan.Parameterized, scan.Shards = idx, spans, spans == nil, nil
+		return true
+	}
+	return false
+}
+
+// indexOrder reports whether the entries of idx, under the constraints
+// cons, are in the order of keys on the columns ords of t. A column
+// constrained to a single value, or already ordered by, is the same in all
+// rows that the following keys order, so it may be skipped in the index
+// and among the keys. Entries of a secondary index are followed by the
+// primary key, and as that is unique, keys past it order nothing.
+func indexOrder(t *catalog.Table, idx *catalog.Index, cons map[int]*colConstraint, ords []int, keys []SortKey) bool {
+	fixed := make(map[int]bool)
+	for ord, c := range cons {
+		fixed[ord] = len(c.eq) == 1
+	}
+	type column struct {
+		ord  int
+		desc bool
+	}
+	var cols []column
+	for i, ord := range t.ColumnOrdinals(idx) {
+		cols = append(cols, column{ord, idx.Desc(i)})
+	}
+	if idx != t.PrimaryIndex {
+		for i, ord := range t.ColumnOrdinals(t.PrimaryIndex) {
+			cols = append(cols, column{ord, t.PrimaryIndex.Desc(i)})
+		}
+	}
+	i := 0
+	for _, c := range cols {
+		for i < len(keys) && fixed[ords[i]] {
+			i++
+		}
+		if i == len(keys) {
+			return true
+		}
+		switch {
+		case ords[i] == c.ord && keys[i].Desc == c.desc:
+			i++
+		case !fixed[c.ord]:
+			return false
+		}
+		fixed[c.ord] = true
+	}
+	return true
+}
+
+// planLimit applies LIMIT and OFFSET to input.
+func (p *Planner) planLimit(input Node, count, offset parser.Expr) (Node, error) {
+	n := &Limit{Input: input}
+	var err error
+	if count != nil {
+		if n.Count, err = p.typeCheckLimit(count, "LIMIT"); err != nil {
+			return nil, err
+		}
+	}
+	if offset != nil {
+		if n.Offset, err = p.typeCheckLimit(offset, "OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// typeCheckLimit type checks the argument of a LIMIT or OFFSET clause,
+// which may not refer to columns, and converts it to bigint.
+func (p *Planner) typeCheckLimit(e parser.Expr, clause string) (eval.Expr, error) {
+	x, err := p.typeCheck(e, &scope{noAggs: "aggregate functions are not allowed in " + clause})
+	if err != nil {
+		return nil, err
+	}
+	switch t := x.ResolvedType(); {
+	case t.Oid == types.OidInt8:
+		return x, nil
+	case t.Family == types.IntFamily || t.Family == types.UnknownFamily ||
+		t.Family == types.DecimalFamily || t.Family == types.FloatFamily:
+		return eval.NewCastExpr(x, types.Int8)
+	default:
+		return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch, "argument of %s must be type bigint, not type %s", clause, t)
+	}
+}
@@ -0,0 +1,69 @@
+package planner
+
+import (
+	"bytes"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+)
+
+// A full scan of a table is split into a shard per parallelShardRows rows
+// the table had when it was last analyzed, up to maxScanShards, so that
+// workers can read a large table at once. The shards are divided at the
+// bounds of the histogram of the first column of the primary key, so that
+// each holds about as many rows. Tables that have not been analyzed are
+// not split, nor those too small for two shards.
+const (
+	parallelShardRows = 25000
+	maxScanShards     = 16
+)
+
+// planShards splits scan, a full scan of the primary index, into shards
+// if its table is large enough and its filter is parallelSafe.
+func planShards(scan *Scan) {
+	t, idx := scan.Table, scan.Index
+	if idx.ID != catalog.PrimaryIndexID || idx.Hash || idx.Desc(0) || t.Stats == nil || !parallelSafe(scan.Filter) {
+		return
+	}
+	n := min(int(tableRows(t)/parallelShardRows), maxScanShards)
+	cs := columnStats(t, t.ColumnOrdinal(idx.ColumnIDs[0]))
+	if n < 2 || cs == nil || len(cs.Histogram) < 2 {
+		return
+	}
+	h := cs.Histogram
+	prefix := rowcodec.IndexPrefix(t.ID, idx.ID)
+	full := scan.Spans[0]
+	start := full.Start
+	var shards []Span
+	for i := 1; i < n; i++ {
+		// Histograms of skewed columns repeat their bounds, which then
+		// divide no rows.
+		bound := append(bytes.Clone(prefix), h[i*(len(h)-1)/n]...)
+		if bytes.Compare(bound, start) <= 0 {
+			continue
+		}
+		shards = append(shards, Span{Start: start, End: bound})
+		start = bound
+	}
+	if len(shards) > 0 {
+		scan.Shards = append(shards, Span{Start: start, End: full.End})
+	}
+}
+
+// parallelSafe reports whether e can be evaluated by several workers at
+// once: it has no subqueries, and calls only immutable functions, which
+// depend on nothing but their arguments.
+func parallelSafe(e eval.Expr) bool {
+	safe := true
+	eval.Walk(e, func(e eval.Expr) bool {
+		switch e := e.(type) {
+		case *eval.FuncExpr:
+			safe = e.Overload.Volatility == eval.Immutable
+		case *eval.SubqueryExpr:
+			safe = false
+		}
+		return safe
+	})
+	return safe
+}
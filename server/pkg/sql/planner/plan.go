@@ -0,0 +1,747 @@
+package planner
+
+import (
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// Column is a result column of a plan node.
+type Column struct {
+	Name string
+	Type *types.T
+}
+
+// Node is a node of a query plan. The executor turns a plan into a tree of
+// operators; nodes themselves hold no execution state.
+type Node interface {
+	// Columns describes the rows the node produces. Statements that do not
+	// return rows have no columns.
+	Columns() []Column
+}
+
+// Span is a key range [Start, End) of an index.
+type Span struct {
+	Start, End []byte
+	// Desc describes the span in terms of index column values for EXPLAIN.
+	Desc string
+}
+
+// Scan reads the rows of a table through one of its indexes. It produces
+// every column of the table in descriptor order.
+type Scan struct {
+	Table *catalog.Table
+	Index *catalog.Index
+	// Spans are ordered, non-overlapping key ranges of Index to read.
+	Spans []Span
+	// Parameterized is set if the spans depend on the parameters of the
+	// statement. Spans is then nil, and BindSpans computes the spans when
+	// the statement is executed.
+	Parameterized bool
+	// Filter, if set, is applied to each row. It is the complete WHERE
+	// clause; the spans only narrow what is read.
+	Filter eval.Expr
+	// Loose makes the scan a loose index scan, which returns only the
+	// first row, in index order, of those that pass Filter and have the
+	// same values in the first Prefix columns of Index: once it returns a
+	// row, it seeks past the entries with the row's values. With a Prefix
+	// of 0 it returns at most one row.
+	Loose  bool
+	Prefix int
+	// Shards, if set, split the span of a full scan of the primary index
+	// into contiguous key ranges that workers may read at once, as many as
+	// the session's max_parallel_workers_per_gather allows. The rows of a
+	// scan read by workers are in no particular order, so the scans whose
+	// order a plan relies on have none.
+	Shards []Span
+}
+
+// VectorSearch reads the rows of Table nearest to Query, nearest first,
+// by searching Index, an HNSW index. It finds the Count+Offset nearest
+// rows of a LIMIT above it, approximately, and applies Filter to them.
+type VectorSearch struct {
+	Table *catalog.Table
+	Index *catalog.Index
+	// Query is the vector to search for. It does not refer to the table's
+	// columns.
+	Query         eval.Expr
+	Count, Offset eval.Expr
+	Filter        eval.Expr
+}
+
+// InvertedScan reads the rows of Table whose value in the column of
+// Index, an inverted index, has every token of Spans, by intersecting the
+// entries of the tokens. Filter is the complete WHERE clause, as for Scan,
+// and is applied to each row.
+type InvertedScan struct {
+	Table *catalog.Table
+	Index *catalog.Index
+	// Spans are the entries of each token, with a description of the
+	// token for EXPLAIN.
+	Spans  []Span
+	Filter eval.Expr
+}
+
+// BRINScan reads the rows of Table in the ranges that Index, a BRIN
+// index, may have rows meeting Bounds in, and in the rows not yet
+// summarized, in primary key order. Which ranges those are depends on the
+// summaries when the scan runs. Filter is the complete WHERE clause, as
+// for Scan, and is applied to each row.
+type BRINScan struct {
+	Table  *catalog.Table
+	Index  *catalog.Index
+	Bounds []Bound
+	Filter eval.Expr
+}
+
+// Bound is what the WHERE clause requires of a column of a BRIN index.
+type Bound struct {
+	// Col is the position of the column in the index.
+	Col int
+	// Eq lists the values the column may equal. DNull stands for IS NULL.
+	Eq []types.Datum
+	// Lo and Hi bound the column when Eq is empty. nil means unbounded.
+	Lo, Hi       types.Datum
+	LoInc, HiInc bool
+	// Desc describes the bound for EXPLAIN.
+	Desc string
+}
+
+// VirtualScan reads the rows of a system catalog table.
+type VirtualScan struct {
+	Table  *vtable.Table
+	Filter eval.Expr
+}
+
+// Values produces constant rows.
+type Values struct {
+	Cols []Column
+	Rows [][]eval.Expr
+}
+
+// Filter passes through the input rows for which Pred is true.
+type Filter struct {
+	Input Node
+	Pred  eval.Expr
+}
+
+// Project computes Exprs over each input row.
+type Project struct {
+	Input Node
+	Exprs []eval.Expr
+	Cols  []Column
+}
+
+// Distinct removes duplicate rows.
+type Distinct struct {
+	Input Node
+}
+
+// SortKey orders rows by one column.
+type SortKey struct {
+	Col  int
+	Desc bool
+	// NullsFirst places NULLs before all other values.
+	NullsFirst bool
+}
+
+// Sort orders its input rows by Keys, the first key being the most
+// significant. Rows that compare equal on every key keep their input
+// order.
+type Sort struct {
+	Input Node
+	Keys  []SortKey
+}
+
+// Limit skips the first Offset input rows and returns at most Count of
+// the rest. Count and Offset are constant bigint expressions; a nil or
+// NULL Count means no limit. A Limit directly over a Sort bounds the sort,
+// which then only keeps the rows it will return.
+type Limit struct {
+	Input         Node
+	Count, Offset eval.Expr
+}
+
+// JoinType is the kind of a join.
+type JoinType uint8
+
+// Join types. Outer joins extend the rows of one or both inputs that match
+// no row of the other with NULLs. Semi and anti joins return the left rows
+// that match some right row or no right row, and only the left columns.
+const (
+	InnerJoin JoinType = iota
+	LeftJoin
+	RightJoin
+	FullJoin
+	SemiJoin
+	AntiJoin
+)
+
+// Join combines rows of Left and Right, producing the left columns followed
+// by the right columns. Rows match when LeftKeys, evaluated over the left
+// row, equal RightKeys, evaluated over the right row, all non-NULL, and On,
+// evaluated over the combined row, is true. With keys the join is a hash
+// join building a table of the right rows; without, a nested loop join.
+type Join struct {
+	Type                JoinType
+	Left, Right         Node
+	LeftKeys, RightKeys []eval.Expr
+	// On is nil when the keys are the only condition.
+	On eval.Expr
+}
+
+// LookupJoin joins each row of Input with the rows of Table whose leading
+// Index columns equal Keys, evaluated over the input row, reading them
+// with a point lookup or a prefix scan of the index. It produces the input
+// columns followed by the table columns. Type is InnerJoin, LeftJoin,
+// SemiJoin or AntiJoin.
+type LookupJoin struct {
+	Type  JoinType
+	Input Node
+	Table *catalog.Table
+	Index *catalog.Index
+	Keys  []eval.Expr
+	// On is evaluated over the combined row; it includes any filter on
+	// Table alone.
+	On eval.Expr
+}
+
+// Aggregate groups its input rows by the values of GroupBy and computes
+// Aggs over each group. Its rows are the grouping values followed by the
+// aggregate results. Without GroupBy there is a single group, which yields
+// a row even when the input is empty.
+type Aggregate struct {
+	Input   Node
+	GroupBy []eval.Expr
+	Aggs    []*eval.AggregateCall
+}
+
+// Window computes window functions over its input. Its rows are the
+// input columns followed by the result of each of Funcs. For each of
+// Windows in turn, the rows are sorted by its PARTITION BY and ORDER BY
+// values and the functions over it are computed, so rows are returned in
+// the order of the last window.
+type Window struct {
+	Input   Node
+	Windows []*WindowSpec
+	Funcs   []*WindowFunc
+}
+
+// WindowSpec is how a window partitions and orders rows. PartitionBy and
+// OrderBy are evaluated over the input rows; Order gives the direction of
+// each OrderBy value, its Col indexing OrderBy.
+type WindowSpec struct {
+	PartitionBy []eval.Expr
+	OrderBy     []eval.Expr
+	Order       []SortKey
+}
+
+// WindowFunc is a function a Window node computes over the frame Frame of
+// the window Windows[Window].
+type WindowFunc struct {
+	Call   *eval.WindowCall
+	Window int
+	Frame  eval.WindowFrame
+}
+
+// SetOpType is the kind of a set operation.
+type SetOpType uint8
+
+// Set operation types.
+const (
+	UnionOp SetOpType = iota
+	IntersectOp
+	ExceptOp
+)
+
+// SetOp combines the rows of Left and Right, whose columns have the types
+// of Cols. A union returns the rows of both, an intersection the left rows
+// matching a right row and an exception the left rows matching none.
+// Unless All is set, duplicate rows are returned once; with All, a row
+// occurring m times on the left and n times on the right is returned m+n,
+// min(m, n) or max(m-n, 0) times.
+type SetOp struct {
+	Type        SetOpType
+	All         bool
+	Left, Right Node
+	Cols        []Column
+}
+
+// CTE is a common table expression of a WITH clause that is materialized:
+// its rows are computed at most once per statement, as they are first
+// read, and shared by the scans of it.
+type CTE struct {
+	Name string
+	Plan Node
+	// Cols are the columns of Plan as the WITH clause names them.
+	Cols []Column
+}
+
+// With runs Input, which reads CTEs through CTEScans.
+type With struct {
+	Input Node
+	CTEs  []*CTE
+}
+
+// CTEScan reads the rows of a materialized CTE.
+type CTEScan struct {
+	CTE *CTE
+}
+
+// RecursiveUnion evaluates the query of a recursive CTE: it returns the
+// rows of Initial, then repeatedly runs Recursive, whose WorkTableScan
+// reads the rows the previous run returned, until a run returns none.
+// Unless All is set, rows equal to one returned before are dropped.
+type RecursiveUnion struct {
+	Name               string
+	Initial, Recursive Node
+	All                bool
+	Cols               []Column
+}
+
+// WorkTableScan reads the rows of the last iteration of Union within its
+// recursive term.
+type WorkTableScan struct {
+	Union *RecursiveUnion
+}
+
+// Insert writes its input rows to Table. Input column i is stored in table
+// column Targets[i]; other columns take their default, or NULL where
+// Defaults, indexed by table ordinal, holds nil.
+type Insert struct {
+	Table    *catalog.Table
+	Input    Node
+	Targets  []int
+	Defaults []eval.Expr
+	*Writes
+}
+
+// Copy loads rows into a table, as COPY FROM does. Source reads them and
+// Insert, which has no Input, writes them: field i of a row is stored in
+// table column Insert.Targets[i].
+type Copy struct {
+	Insert *Insert
+	Source CopySource
+	// Errors, if set, inserts the rows Source rejects into an error table,
+	// as its columns line_number, error and raw_line.
+	Errors *Insert
+}
+
+// CopySource is the data of COPY FROM and its format.
+type CopySource struct {
+	// File is the server file to read, or "" to read the client's data.
+	File string
+	// CSV is set for FORMAT csv and clear for the text format. Quote and
+	// Escape are only used by CSV.
+	CSV                      bool
+	Delimiter, Quote, Escape byte
+	// Null is the string of a NULL field, and Header is set if the first
+	// line is a header to skip.
+	Null   string
+	Header bool
+	// IgnoreErrors is set for ON_ERROR ignore: rows that are malformed or
+	// whose fields are not valid input for their columns' types are
+	// skipped, as long as there are no more than RejectLimit of them if it
+	// is positive.
+	IgnoreErrors bool
+	RejectLimit  int64
+}
+
+// Update rewrites the rows produced by Input, setting table column
+// Targets[i] to Exprs[i] evaluated over the old row.
+type Update struct {
+	Table *catalog.Table
+	// Input scans Table.
+	Input   Node
+	Targets []int
+	Exprs   []eval.Expr
+	*Writes
+}
+
+// Delete removes the rows produced by Input.
+type Delete struct {
+	Table *catalog.Table
+	// Input scans Table.
+	Input Node
+	// All is set if every row of Table is deleted and no foreign key
+	// references it, so that nothing needs to see the rows: the table's
+	// key range is deleted whole rather than row by row.
+	All bool
+	*Writes
+}
+
+// Writes is what writing the rows of a table enforces beyond the types of
+// its columns.
+type Writes struct {
+	// Generated are the table's stored generated columns, which are
+	// computed from the rest of each row written.
+	Generated []ColumnFill
+	// Checks are the table's CHECK constraints.
+	Checks []Check
+	// ForeignKeys are the table's foreign keys.
+	ForeignKeys []ForeignKey
+	// References are the foreign keys referencing the table, which
+	// deleting its rows or changing their keys enforces.
+	References []*Reference
+}
+
+// ForeignKey is a foreign key of a table being written.
+type ForeignKey struct {
+	Name string
+	// Ords are the table ordinals of the foreign key columns, in the
+	// order of the columns of Index they reference.
+	Ords       []int
+	Referenced *catalog.Table
+	// Index is the primary key or unique index of Referenced on the
+	// referenced columns.
+	Index *catalog.Index
+}
+
+// Reference is a foreign key of Table referencing a table being written.
+type Reference struct {
+	Name  string
+	Table *catalog.Table
+	// Ords are the table ordinals of the foreign key columns, and RefOrds
+	// those of the columns they reference in the referenced table.
+	Ords, RefOrds []int
+	// Index is an index of Table whose leading columns are the foreign
+	// key columns, in the order of Ords, or nil if finding the rows
+	// referencing a key reads all of Table.
+	Index *catalog.Index
+	// Key is the primary key or unique index of the referenced table on
+	// the referenced columns.
+	Key                *catalog.Index
+	OnDelete, OnUpdate catalog.RefAction
+	// Defaults are the defaults of the foreign key columns, which SET
+	// DEFAULT sets them to. A nil default is NULL.
+	Defaults []eval.Expr
+	// Writes is what the actions that change rows of Table enforce, or
+	// nil if neither action does. References to the same table share it,
+	// and cycles of references lead back to it.
+	Writes *Writes
+}
+
+// CreateTable creates a table.
+type CreateTable struct {
+	Table       *catalog.Table
+	IfNotExists bool
+	// Sequences are the sequences of the table's serial columns, created
+	// once the table has an ID to be owned by.
+	Sequences []*catalog.Sequence
+}
+
+// CreateIndex adds and backfills a secondary index.
+type CreateIndex struct {
+	Table *catalog.Table
+	Index *catalog.Index
+	// Exists is set when IF NOT EXISTS was given and the name is taken.
+	Exists bool
+}
+
+// AlterTable replaces the descriptor Old with Table, its changed copy, and
+// rewrites the stored rows where the change requires it. Table is nil when
+// IF EXISTS was given and there is no such table.
+type AlterTable struct {
+	Old, Table *catalog.Table
+	// Fills are the added columns with a default, which existing rows take.
+	Fills []ColumnFill
+	// Validate are the added CHECK constraints, which existing rows must
+	// satisfy.
+	Validate []Check
+	// ValidateForeignKeys are the added foreign keys, which existing rows
+	// must satisfy.
+	ValidateForeignKeys []ForeignKey
+	// CreateSequences are the sequences of added serial columns, created
+	// before the rows are filled, and DropSequences those owned by
+	// dropped columns.
+	CreateSequences, DropSequences []*catalog.Sequence
+}
+
+// ColumnFill sets table column Ord of existing rows to Expr.
+type ColumnFill struct {
+	Ord  int
+	Expr eval.Expr
+}
+
+// Check is a CHECK constraint, compiled over the columns of its table.
+type Check struct {
+	Name string
+	Expr eval.Expr
+}
+
+// DropTable drops tables and their data.
+type DropTable struct {
+	Tables []*catalog.Table
+	// Detached are the other tables with foreign keys referencing Tables,
+	// without those foreign keys. DROP TABLE ... CASCADE drops them.
+	Detached []*catalog.Table
+	// Sequences are the sequences owned by columns of Tables.
+	Sequences []*catalog.Sequence
+}
+
+// Truncate deletes all rows of tables.
+type Truncate struct {
+	// Tables are the tables named by TRUNCATE followed, for CASCADE, by
+	// those with foreign keys referencing them.
+	Tables []*catalog.Table
+	// Sequences are the sequences of RESTART IDENTITY.
+	Sequences []*catalog.Sequence
+}
+
+// Analyze gathers the statistics of tables.
+type Analyze struct {
+	Tables []*catalog.Table
+}
+
+// Cluster rewrites the rows of tables in primary key order, and their
+// indexes with them. Index, if set, is the index of the single table whose
+// order its rows take instead, which a table keyed by its hidden rowid
+// column alone allows. Named is set if the statement named the table,
+// which the role must then own; otherwise tables it does not own are
+// skipped.
+type Cluster struct {
+	Tables []*catalog.Table
+	Index  *catalog.Index
+	Named  bool
+}
+
+// Vacuum compacts the keys of tables in the storage engine, reclaiming the
+// space of the versions of their rows that were updated or deleted. If
+// the statement named no tables, Database is the database it runs in,
+// which its owner compacts all of, what dropped tables left among it;
+// other roles compact the tables they own. Otherwise the role must own
+// the tables. Analyze, if set, gathers the statistics of the tables
+// afterwards, as ANALYZE does.
+type Vacuum struct {
+	Tables   []*catalog.Table
+	Database *catalog.Database
+	Analyze  bool
+}
+
+// IndexRef names an index of a table.
+type IndexRef struct {
+	Table *catalog.Table
+	Index *catalog.Index
+}
+
+// DropIndex drops secondary indexes and their entries.
+type DropIndex struct {
+	Indexes []IndexRef
+}
+
+// CreateSequence creates a sequence.
+type CreateSequence struct {
+	Sequence *catalog.Sequence
+	// Exists is set when IF NOT EXISTS was given and the name is taken.
+	Exists bool
+}
+
+// DropSequence drops sequences.
+type DropSequence struct {
+	Sequences []*catalog.Sequence
+	// Detached are the tables with column defaults using Sequences,
+	// without those defaults. DROP SEQUENCE ... CASCADE drops them.
+	Detached []*catalog.Table
+}
+
+// CreateSchema creates a schema.
+type CreateSchema struct {
+	Schema *catalog.Schema
+	// Exists is set when IF NOT EXISTS was given and the name is taken.
+	Exists bool
+}
+
+// DropSchema drops schemas. DROP SCHEMA ... CASCADE drops the relations in
+// them too: the tables with DropTable, if there are any, and Sequences,
+// the sequences not owned by those tables.
+type DropSchema struct {
+	Schemas   []*catalog.Schema
+	DropTable *DropTable
+	Sequences []*catalog.Sequence
+}
+
+// CreateDatabase creates a database.
+type CreateDatabase struct {
+	Database *catalog.Database
+}
+
+// DropDatabase drops a database, which is nil if DROP DATABASE IF EXISTS
+// named none. Force terminates the sessions connected to it, which
+// otherwise keep it from being dropped.
+type DropDatabase struct {
+	Database *catalog.Database
+	Force    bool
+}
+
+// CreatePublication creates a publication of Tables, the tables it names
+// unless it publishes all tables.
+type CreatePublication struct {
+	Publication *catalog.Publication
+	Tables      []*catalog.Table
+}
+
+// DropPublication drops publications.
+type DropPublication struct {
+	Publications []*catalog.Publication
+}
+
+// CreateRole creates a role, which Members become members of.
+type CreateRole struct {
+	Role    *catalog.Role
+	Members []*catalog.Role
+}
+
+// AlterRole stores Role, changed by ALTER ROLE. OldName is its name before
+// ALTER ROLE ... RENAME TO, or empty.
+type AlterRole struct {
+	Role    *catalog.Role
+	OldName string
+}
+
+// AlterRoleSettings stores the role settings ALTER ROLE ... SET or RESET
+// changed.
+type AlterRoleSettings struct {
+	Settings *catalog.RoleSettings
+}
+
+// DropRole drops roles.
+type DropRole struct {
+	Roles []*catalog.Role
+}
+
+// GrantRole stores the roles whose memberships GRANT or REVOKE changed.
+type GrantRole struct {
+	Roles []*catalog.Role
+}
+
+// Grant stores the tables and schemas whose privileges GRANT or REVOKE
+// changed.
+type Grant struct {
+	Tables  []*catalog.Table
+	Schemas []*catalog.Schema
+}
+
+// ShowCreate returns the statements that create a table.
+type ShowCreate struct {
+	Table *catalog.Table
+}
+
+// Explain describes the plan of a statement instead of running it.
+type Explain struct {
+	Plan Node
+}
+
+func (n *Scan) Columns() []Column {
+	cols := make([]Column, len(n.Table.Columns))
+	for i, c := range n.Table.Columns {
+		cols[i] = Column{Name: c.Name, Type: c.Type}
+	}
+	return cols
+}
+
+func (n *VirtualScan) Columns() []Column {
+	cols := make([]Column, len(n.Table.Desc.Columns))
+	for i, c := range n.Table.Desc.Columns {
+		cols[i] = Column{Name: c.Name, Type: c.Type}
+	}
+	return cols
+}
+
+func (n *Aggregate) Columns() []Column {
+	cols := make([]Column, 0, len(n.GroupBy)+len(n.Aggs))
+	for _, g := range n.GroupBy {
+		cols = append(cols, Column{Name: g.String(), Type: g.ResolvedType()})
+	}
+	for _, a := range n.Aggs {
+		cols = append(cols, Column{Name: a.Name, Type: a.Typ})
+	}
+	return cols
+}
+
+func (n *Window) Columns() []Column {
+	cols := slices.Clone(n.Input.Columns())
+	for _, f := range n.Funcs {
+		cols = append(cols, Column{Name: f.Call.Name, Type: f.Call.Typ})
+	}
+	return cols
+}
+
+func (n *Join) Columns() []Column {
+	if n.Type == SemiJoin || n.Type == AntiJoin {
+		return n.Left.Columns()
+	}
+	return slices.Concat(n.Left.Columns(), n.Right.Columns())
+}
+
+func (n *LookupJoin) Columns() []Column {
+	cols := slices.Clone(n.Input.Columns())
+	if n.Type == SemiJoin || n.Type == AntiJoin {
+		return cols
+	}
+	for _, c := range n.Table.Columns {
+		cols = append(cols, Column{Name: c.Name, Type: c.Type})
+	}
+	return cols
+}
+
+func (n *VectorSearch) Columns() []Column {
+	return (&Scan{Table: n.Table}).Columns()
+}
+
+func (n *InvertedScan) Columns() []Column {
+	return (&Scan{Table: n.Table}).Columns()
+}
+
+func (n *BRINScan) Columns() []Column {
+	return (&Scan{Table: n.Table}).Columns()
+}
+
+func (n *With) Columns() []Column              { return n.Input.Columns() }
+func (n *CTEScan) Columns() []Column           { return n.CTE.Cols }
+func (n *SetOp) Columns() []Column             { return n.Cols }
+func (n *RecursiveUnion) Columns() []Column    { return n.Cols }
+func (n *WorkTableScan) Columns() []Column     { return n.Union.Cols }
+func (n *Values) Columns() []Column            { return n.Cols }
+func (n *Filter) Columns() []Column            { return n.Input.Columns() }
+func (n *Project) Columns() []Column           { return n.Cols }
+func (n *Distinct) Columns() []Column          { return n.Input.Columns() }
+func (n *Sort) Columns() []Column              { return n.Input.Columns() }
+func (n *Limit) Columns() []Column             { return n.Input.Columns() }
+func (n *Insert) Columns() []Column            { return nil }
+func (n *Update) Columns() []Column            { return nil }
+func (n *Delete) Columns() []Column            { return nil }
+func (n *CreateTable) Columns() []Column       { return nil }
+func (n *CreateIndex) Columns() []Column       { return nil }
+func (n *AlterTable) Columns() []Column        { return nil }
+func (n *DropTable) Columns() []Column         { return nil }
+func (n *DropIndex) Columns() []Column         { return nil }
+func (n *CreateSequence) Columns() []Column    { return nil }
+func (n *Truncate) Columns() []Column          { return nil }
+func (n *DropSequence) Columns() []Column      { return nil }
+func (n *CreateSchema) Columns() []Column      { return nil }
+func (n *DropSchema) Columns() []Column        { return nil }
+func (n *CreateDatabase) Columns() []Column    { return nil }
+func (n *DropDatabase) Columns() []Column      { return nil }
+func (n *CreatePublication) Columns() []Column { return nil }
+func (n *DropPublication) Columns() []Column   { return nil }
+func (n *CreateRole) Columns() []Column        { return nil }
+func (n *AlterRole) Columns() []Column         { return nil }
+func (n *AlterRoleSettings) Columns() []Column { return nil }
+func (n *DropRole) Columns() []Column          { return nil }
+func (n *GrantRole) Columns() []Column         { return nil }
+func (n *Grant) Columns() []Column             { return nil }
+func (n *Analyze) Columns() []Column           { return nil }
+func (n *Cluster) Columns() []Column           { return nil }
+func (n *Vacuum) Columns() []Column            { return nil }
+func (n *Copy) Columns() []Column              { return nil }
+
+func (n *ShowCreate) Columns() []Column {
+	return []Column{{Name: "table_name", Type: types.String}, {Name: "create_statement", Type: types.String}}
+}
+
+func (n *Explain) Columns() []Column {
+	return []Column{{Name: "QUERY PLAN", Type: types.String}}
+}
@@ -0,0 +1,1239 @@
+// Package planner turns parsed statements into executable plans.
+//
+// Planning resolves names against the catalog, type checks expressions
+// into eval.Exprs and chooses how each table is read: the WHERE clause is
+// analyzed for constraints on indexed columns, and the index whose leading
+// columns are most constrained is scanned over just the matching key spans.
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// Planner plans statements against the catalog visible to a transaction.
+type Planner struct {
+	Txn      engine.Reader
+	Registry *eval.Registry
+	// User is the role statements run as, which their permissions are
+	// checked against. It is empty for the sessions of the embedder, which
+	// may do anything.
+	User string
+	// SearchPath are the names of the schemas that names not qualified
+	// with a schema are looked up in, in order, where "$user" stands for
+	// the schema named after User. Nil means the public schema alone.
+	SearchPath []string
+	// Database is the name of the database statements run in; empty is
+	// catalog.DefaultDatabase.
+	Database string
+	// CheckSetting returns the value SET name TO values gives a session
+	// variable, or an error if there is no such variable or the value is
+	// invalid, for ALTER ROLE ... SET to store. If it is nil, ALTER ROLE
+	// ... SET is not supported.
+	CheckSetting func(name string, values []string) (string, error)
+
+	// outer holds the scopes enclosing the subquery being planned.
+	outer         []*outerScope
+	numSubqueries int
+	// ctes holds the common table expressions in scope, innermost last.
+	ctes []*cte
+	// setOperand is set when the SELECT to be planned next is an operand
+	// of a set operation.
+	setOperand bool
+	// placeholders holds the types of the parameters of a statement being
+	// prepared. It is nil otherwise, when there are no parameters.
+	placeholders *eval.PlaceholderTypes
+}
+
+// New returns a planner reading the catalog through txn and resolving
+// functions and operators in reg.
+func New(txn engine.Reader, reg *eval.Registry) *Planner {
+	return &Planner{Txn: txn, Registry: reg}
+}
+
+// PlanPrepared plans stmt as a prepared statement, whose parameters $N are
+// supplied when it is executed. params are the declared types of its
+// parameters, nil or unknown where a type is not declared. It returns the
+// plan and the types of all parameters, inferred from their use where not
+// declared.
+func (p *Planner) PlanPrepared(stmt parser.Statement, params []*types.T) (Node, []*types.T, error) {
+	typs := make(eval.PlaceholderTypes, len(params))
+	for i, t := range params {
+		if t != nil && t.Family != types.UnknownFamily {
+			typs[i] = t
+		}
+	}
+	p.placeholders = &typs
+	defer func() { p.placeholders = nil }()
+	plan, err := p.Plan(stmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, t := range typs {
+		if t == nil {
+			return nil, nil, pgerror.Newf(pgerror.CodeIndeterminateDatatype, "could not determine data type of parameter $%d", i+1)
+		}
+	}
+	return plan, typs, nil
+}
+
+// PlanParams type checks the arguments of EXECUTE and coerces them to typs,
+// the types of the prepared statement's parameters.
+func (p *Planner) PlanParams(args []parser.Expr, typs []*types.T) ([]eval.Expr, error) {
+	out := make([]eval.Expr, len(args))
+	for i, arg := range args {
+		if containsSubquery(arg) {
+			return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "cannot use subquery in EXECUTE parameter")
+		}
+		x, err := p.typeCheck(arg, &scope{
+			noAggs:    "aggregate functions are not allowed in EXECUTE parameters",
+			noWindows: "window functions are not allowed in EXECUTE parameters",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if from := x.ResolvedType(); !assignable(from, typs[i]) {
+			return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+				"parameter $%d of type %s cannot be coerced to the expected type %s", i+1, from, typs[i]).
+				WithHint("You will need to rewrite or cast the expression.")
+		}
+		if out[i], err = eval.NewCastExpr(x, typs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Plan returns the plan for stmt. Transaction control statements are
+// handled by the session and cannot be planned.
+func (p *Planner) Plan(stmt parser.Statement) (Node, error) {
+	switch s := stmt.(type) {
+	case *parser.SelectStmt:
+		return p.planSelect(s)
+	case *parser.InsertStmt:
+		return p.planInsert(s)
+	case *parser.UpdateStmt:
+		return p.planUpdate(s)
+	case *parser.DeleteStmt:
+		return p.planDelete(s)
+	case *parser.CreateTableStmt:
+		return p.planCreateTable(s)
+	case *parser.CreateIndexStmt:
+		return p.planCreateIndex(s)
+	case *parser.AlterTableStmt:
+		return p.planAlterTable(s)
+	case *parser.DropTableStmt:
+		return p.planDropTable(s)
+	case *parser.DropIndexStmt:
+		return p.planDropIndex(s)
+	case *parser.CreateSequenceStmt:
+		return p.planCreateSequence(s)
+	case *parser.TruncateStmt:
+		return p.planTruncate(s)
+	case *parser.DropSequenceStmt:
+		return p.planDropSequence(s)
+	case *parser.CreateSchemaStmt:
+		return p.planCreateSchema(s)
+	case *parser.DropSchemaStmt:
+		return p.planDropSchema(s)
+	case *parser.CreateDatabaseStmt:
+		return p.planCreateDatabase(s)
+	case *parser.DropDatabaseStmt:
+		return p.planDropDatabase(s)
+	case *parser.CreatePublicationStmt:
+		return p.planCreatePublication(s)
+	case *parser.DropPublicationStmt:
+		return p.planDropPublication(s)
+	case *parser.CreateRoleStmt:
+		return p.planCreateRole(s)
+	case *parser.AlterRoleStmt:
+		return p.planAlterRole(s)
+	case *parser.DropRoleStmt:
+		return p.planDropRole(s)
+	case *parser.GrantRoleStmt:
+		return p.planGrantRole(s)
+	case *parser.GrantStmt:
+		return p.planGrant(s)
+	case *parser.AnalyzeStmt:
+		return p.planAnalyze(s)
+	case *parser.ClusterStmt:
+		return p.planCluster(s)
+	case *parser.VacuumStmt:
+		return p.planVacuum(s)
+	case *parser.CopyStmt:
+		return p.planCopy(s)
+	case *parser.ShowCreateStmt:
+		t, err := p.lookupTable(s.Table)
+		if err != nil {
+			return nil, err
+		}
+		return &ShowCreate{Table: t}, nil
+	case *parser.ExplainStmt:
+		plan, err := p.Plan(s.Stmt)
+		if err != nil {
+			return nil, err
+		}
+		return &Explain{Plan: plan}, nil
+	}
+	return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "cannot plan %T", stmt)
+}
+
+// tableScope returns the scope of a table's columns, qualified by alias if
+// one is given.
+func tableScope(t *catalog.Table, alias string) *scope {
+	if alias == "" {
+		alias = t.Name
+	}
+	s := &scope{cols: make([]scopeColumn, len(t.Columns))}
+	for i, c := range t.Columns {
+		s.cols[i] = scopeColumn{table: alias, name: c.Name, typ: c.Type, implicit: c.Hidden}
+	}
+	return s
+}
+
+// lookupTable returns the stored table tn names. The names of system
+// catalogs, which are looked up first, are an error, as they are not
+// stored.
+func (p *Planner) lookupTable(tn *parser.TableName) (*catalog.Table, error) {
+	switch tn.Schema {
+	case "":
+		if vtable.Lookup(tn.Name) != nil {
+			return nil, pgerror.Newf(pgerror.CodeInsufficientPrivilege,
+				"permission denied: %q is a system catalog", tn.Name)
+		}
+	case vtable.Schema, vtable.InformationSchema:
+		if vtable.LookupIn(tn.Schema, tn.Name) != nil {
+			return nil, pgerror.Newf(pgerror.CodeInsufficientPrivilege,
+				"permission denied: %q is a system catalog", tn.Name)
+		}
+		return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", tn.String())
+	}
+	return p.mustFindTable(tn)
+}
+
+// planTableName plans a read of the table tn names, filtered by where,
+// and returns it with the scope of its columns. As in PostgreSQL, the
+// CTEs in scope are searched first, then pg_catalog and the search path.
+func (p *Planner) planTableName(tn *parser.TableName, where parser.Expr) (Node, *scope, error) {
+	if tn.Call {
+		vt := vtable.LookupFunc(tn.Schema, tn.Name)
+		if vt == nil {
+			return nil, nil, pgerror.Newf(pgerror.CodeUndefinedFunction, "function %s does not exist", tn.String())
+		}
+		return p.planVirtualScan(vt, tn.Alias, where)
+	}
+	if tn.Schema == "" {
+		if c := p.lookupCTE(tn.Name); c != nil {
+			return p.planCTERef(c, tn.Alias, where)
+		}
+	}
+	if tn.Schema == "" || tn.Schema == vtable.Schema || tn.Schema == vtable.InformationSchema {
+		if vt := vtable.LookupIn(tn.Schema, tn.Name); vt != nil {
+			return p.planVirtualScan(vt, tn.Alias, where)
+		}
+	}
+	t, err := p.lookupTable(tn)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc := tableScope(t, tn.Alias)
+	scan, err := p.planScan(t, sc, where)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scan, sc, nil
+}
+
+// planVirtualScan plans a read of the system catalog table or the rows of
+// the function vt, filtered by where, as alias.
+func (p *Planner) planVirtualScan(vt *vtable.Table, alias string, where parser.Expr) (Node, *scope, error) {
+	sc := tableScope(vt.Desc, alias)
+	scan := &VirtualScan{Table: vt}
+	if where != nil {
+		var err error
+		if scan.Filter, err = p.typeCheckPredicate(where, sc, "WHERE"); err != nil {
+			return nil, nil, err
+		}
+	}
+	return scan, sc, nil
+}
+
+// planScan type checks a WHERE clause and returns a scan of t that reads
+// only the index entries the clause allows.
+func (p *Planner) planScan(t *catalog.Table, s *scope, where parser.Expr) (Node, error) {
+	var filter eval.Expr
+	if where != nil {
+		var err error
+		if filter, err = p.typeCheckPredicate(where, s, "WHERE"); err != nil {
+			return nil, err
+		}
+	}
+	return scanTable(t, filter)
+}
+
+func (p *Planner) typeCheckPredicate(e parser.Expr, s *scope, clause string) (eval.Expr, error) {
+	where := clause
+	if clause == "JOIN/ON" {
+		where = "JOIN conditions"
+	}
+	if s.agg == nil {
+		s = s.withoutAggregates(fmt.Sprintf("aggregate functions are not allowed in %s", where))
+	}
+	s = s.withoutWindows(fmt.Sprintf("window functions are not allowed in %s", where))
+	pred, err := p.typeCheck(e, s)
+	if err != nil {
+		return nil, err
+	}
+	switch pred.ResolvedType().Family {
+	case types.BoolFamily:
+		return pred, nil
+	case types.UnknownFamily:
+		return eval.Coerce(pred, types.Bool)
+	}
+	return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch, "argument of %s must be type boolean, not type %s",
+		clause, pred.ResolvedType())
+}
+
+func (p *Planner) planSelect(s *parser.SelectStmt) (Node, error) {
+	// As in PostgreSQL, the untyped literals selected by a set operation's
+	// operand take their type from the set operation, unless the operand
+	// needs them typed to sort or deduplicate its rows.
+	keepUnknown := p.setOperand && !s.Distinct && len(s.OrderBy) == 0 && len(s.GroupBy) == 0
+	p.setOperand = false
+	if s.With != nil {
+		return p.planWith(s)
+	}
+	if s.Op != parser.NoSetOp {
+		return p.planSetOp(s)
+	}
+	var input Node
+	sc := &scope{}
+	switch len(s.From) {
+	case 0:
+		input = &Values{Rows: [][]eval.Expr{{}}}
+		if s.Where != nil {
+			pred, err := p.typeCheckPredicate(s.Where, sc, "WHERE")
+			if err != nil {
+				return nil, err
+			}
+			input = &Filter{Input: input, Pred: pred}
+		}
+	default:
+		var err error
+		if tn, ok := s.From[0].(*parser.TableName); ok && len(s.From) == 1 && !hasSubquery(s.Where) {
+			input, sc, err = p.planTableName(tn, s.Where)
+		} else {
+			input, sc, err = p.planFrom(s.From, s.Where)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var agg *Aggregate
+	if p.isGrouped(s) {
+		var err error
+		if agg, sc, err = p.planGroupBy(input, s, sc); err != nil {
+			return nil, err
+		}
+		input = agg
+	}
+
+	// The select list and ORDER BY are evaluated over the rows of the
+	// Window node computing their window function calls, if any.
+	ws, err := newWindowScope(s)
+	if err != nil {
+		return nil, err
+	}
+	sc = sc.withWindows(ws)
+
+	proj := &Project{Input: input}
+	for _, target := range s.Targets {
+		if star, ok := target.Expr.(*parser.Star); ok {
+			if err := p.expandStar(proj, star, sc, len(s.From) == 0); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		e, err := p.typeCheck(target.Expr, sc)
+		if err != nil {
+			return nil, err
+		}
+		// Untyped literals are output as text, as in PostgreSQL.
+		if e.ResolvedType().Family == types.UnknownFamily && !keepUnknown {
+			if e, err = eval.Coerce(e, types.String); err != nil {
+				return nil, err
+			}
+		}
+		name := target.Alias
+		if name == "" {
+			name = targetName(target.Expr)
+		}
+		proj.Exprs = append(proj.Exprs, e)
+		proj.Cols = append(proj.Cols, Column{Name: name, Type: e.ResolvedType()})
+	}
+	if s.Having != nil {
+		pred, err := p.typeCheckPredicate(s.Having, sc, "HAVING")
+		if err != nil {
+			return nil, err
+		}
+		proj.Input = &Filter{Input: agg, Pred: pred}
+	}
+
+	width := len(proj.Cols)
+	keys, err := p.planOrderBy(s, proj, sc)
+	if err != nil {
+		return nil, err
+	}
+	if len(ws.node.Funcs) > 0 {
+		proj.Input = ws.finish(proj.Input)
+	}
+	if s.Distinct {
+		looseDistinct(proj)
+	}
+	if agg != nil {
+		looseAggregate(agg)
+	}
+	var out Node = proj
+	if s.Distinct {
+		out = &Distinct{Input: out}
+	}
+	if len(keys) > 0 && !scanInOrder(proj, keys) {
+		out = &Sort{Input: out, Keys: keys}
+	}
+	if s.Limit != nil || s.Offset != nil {
+		if out, err = p.planLimit(out, s.Limit, s.Offset); err != nil {
+			return nil, err
+		}
+		planVectorSearch(out.(*Limit))
+	}
+	// Drop the columns added only to sort by.
+	if len(proj.Cols) > width {
+		trim := &Project{Input: out, Cols: proj.Cols[:width]}
+		for i, c := range trim.Cols {
+			trim.Exprs = append(trim.Exprs, &eval.ColumnRef{Idx: i, Name: c.Name, Typ: c.Type})
+		}
+		out = trim
+	}
+	return out, nil
+}
+
+func (p *Planner) expandStar(proj *Project, star *parser.Star, sc *scope, noFrom bool) error {
+	if noFrom {
+		return pgerror.New(pgerror.CodeSyntaxError, "SELECT * with no tables specified is not valid")
+	}
+	in := sc
+	if sc.agg != nil {
+		in = sc.agg.input
+	}
+	if star.Table != "" && !in.hasTable(star.Table) {
+		return pgerror.Newf(pgerror.CodeUndefinedTable, "missing FROM-clause entry for table %q", star.Table)
+	}
+	for i, c := range in.cols {
+		if (star.Table != "" && c.table != star.Table) || (star.Table == "" && c.hidden) || c.implicit {
+			continue
+		}
+		var e eval.Expr = &eval.ColumnRef{Idx: i, Name: in.columnName(&c), Typ: c.typ}
+		if sc.agg != nil {
+			if e = sc.agg.groupRef(e); e == nil {
+				return ungroupedColumn(c)
+			}
+		}
+		proj.Exprs = append(proj.Exprs, e)
+		proj.Cols = append(proj.Cols, Column{Name: c.name, Type: c.typ})
+	}
+	return nil
+}
+
+// targetName returns the column name PostgreSQL gives an unaliased select
+// target.
+func targetName(e parser.Expr) string {
+	switch e := e.(type) {
+	case *parser.ColumnRef:
+		return e.Column
+	case *parser.FuncCall:
+		return e.Name
+	case *parser.CastExpr:
+		if name := targetName(e.X); name != "?column?" {
+			return name
+		}
+		if t, err := resolveType(e.Type); err == nil {
+			return t.Name
+		}
+	case *parser.CaseExpr:
+		return "case"
+	case *parser.ExistsExpr:
+		return "exists"
+	case *parser.Subquery:
+		sel := e.Select
+		for sel.Op != parser.NoSetOp {
+			sel = sel.Left
+		}
+		if targets := sel.Targets; len(targets) == 1 {
+			if targets[0].Alias != "" {
+				return targets[0].Alias
+			}
+			return targetName(targets[0].Expr)
+		}
+	}
+	return "?column?"
+}
+
+// resolveColumns maps column names to ordinals of t, rejecting unknown and
+// repeated names.
+func resolveColumns(t *catalog.Table, names []string) ([]int, error) {
+	ords := make([]int, len(names))
+	for i, name := range names {
+		ord := t.FindColumn(name)
+		if ord < 0 {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q of relation %q does not exist", name, t.Name)
+		}
+		for _, prev := range ords[:i] {
+			if prev == ord {
+				return nil, pgerror.Newf(pgerror.CodeDuplicateColumn, "column %q specified more than once", name)
+			}
+		}
+		ords[i] = ord
+	}
+	return ords, nil
+}
+
+func (p *Planner) planInsert(s *parser.InsertStmt) (Node, error) {
+	t, err := p.mustFindTable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	var targets []int
+	if s.Columns != nil {
+		if targets, err = resolveColumns(t, s.Columns); err != nil {
+			return nil, err
+		}
+	} else {
+		for i, c := range t.Columns {
+			if !c.Hidden {
+				targets = append(targets, i)
+			}
+		}
+	}
+	width := func(n int) error {
+		switch {
+		case n > len(targets):
+			return pgerror.New(pgerror.CodeSyntaxError, "INSERT has more expressions than target columns")
+		case n < len(targets) && s.Columns != nil:
+			return pgerror.New(pgerror.CodeSyntaxError, "INSERT has more target columns than expressions")
+		}
+		return nil
+	}
+
+	var input Node
+	switch {
+	case s.DefaultValues:
+		targets = nil
+		input = &Values{Rows: [][]eval.Expr{{}}}
+	case s.Select != nil:
+		sel, err := p.planSelect(s.Select)
+		if err != nil {
+			return nil, err
+		}
+		cols := sel.Columns()
+		if err := width(len(cols)); err != nil {
+			return nil, err
+		}
+		targets = targets[:len(cols)]
+		proj := &Project{Input: sel}
+		for i, c := range cols {
+			col := t.Columns[targets[i]]
+			if col.Generated != "" {
+				return nil, generatedColumnError(col, false)
+			}
+			var e eval.Expr
+			switch {
+			case col.Identity != "" && s.Overriding == "user":
+				e, err = p.defaultValue(col)
+			case col.Identity == catalog.IdentityAlways && s.Overriding != "system":
+				return nil, identityColumnError(col, false)
+			default:
+				e, err = coerceForAssignment(&eval.ColumnRef{Idx: i, Name: c.Name, Typ: c.Type}, col.Type, col.Name)
+			}
+			if err != nil {
+				return nil, err
+			}
+			proj.Exprs = append(proj.Exprs, e)
+			proj.Cols = append(proj.Cols, Column{Name: col.Name, Type: col.Type})
+		}
+		input = proj
+	default:
+		n := len(s.Values[0])
+		if err := width(n); err != nil {
+			return nil, err
+		}
+		targets = targets[:n]
+		values := &Values{}
+		for _, ord := range targets {
+			values.Cols = append(values.Cols, Column{Name: t.Columns[ord].Name, Type: t.Columns[ord].Type})
+		}
+		for _, row := range s.Values {
+			if len(row) != n {
+				return nil, pgerror.New(pgerror.CodeSyntaxError, "VALUES lists must all be the same length")
+			}
+			exprs := make([]eval.Expr, n)
+			for i, v := range row {
+				col := t.Columns[targets[i]]
+				// OVERRIDING USER VALUE ignores the values given for
+				// identity columns.
+				if _, ok := v.(*parser.DefaultExpr); ok || col.Identity != "" && s.Overriding == "user" {
+					if exprs[i], err = p.defaultValue(col); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if col.Generated != "" {
+					return nil, generatedColumnError(col, false)
+				}
+				if col.Identity == catalog.IdentityAlways && s.Overriding != "system" {
+					return nil, identityColumnError(col, false)
+				}
+				e, err := p.typeCheck(v, &scope{noAggs: "aggregate functions are not allowed in VALUES"})
+				if err != nil {
+					return nil, err
+				}
+				if exprs[i], err = coerceForAssignment(e, col.Type, col.Name); err != nil {
+					return nil, err
+				}
+			}
+			values.Rows = append(values.Rows, exprs)
+		}
+		input = values
+	}
+	return p.newInsert(t, input, targets)
+}
+
+// newInsert returns the Insert of the rows of input into the columns
+// targets of t, with the defaults of the other columns.
+func (p *Planner) newInsert(t *catalog.Table, input Node, targets []int) (*Insert, error) {
+	ins := &Insert{Table: t, Input: input, Targets: targets, Defaults: make([]eval.Expr, len(t.Columns))}
+	var err error
+	for ord, c := range t.Columns {
+		if slices.Contains(targets, ord) {
+			continue
+		}
+		if ins.Defaults[ord], err = p.columnDefault(c); err != nil {
+			return nil, err
+		}
+	}
+	if ins.Writes, err = p.tableWrites(t, false); err != nil {
+		return nil, err
+	}
+	return ins, nil
+}
+
+// defaultValue returns the value DEFAULT stands for in a write of column
+// c: its default, or NULL if it has none. A generated column is computed
+// from the rest of the row once it is complete.
+func (p *Planner) defaultValue(c *catalog.Column) (eval.Expr, error) {
+	e, err := p.columnDefault(c)
+	if e == nil && err == nil {
+		e = &eval.Const{Datum: types.DNull, Typ: c.Type}
+	}
+	return e, err
+}
+
+func (p *Planner) planUpdate(s *parser.UpdateStmt) (Node, error) {
+	t, err := p.lookupTable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	sc := tableScope(t, s.Table.Alias).withoutAggregates("aggregate functions are not allowed in UPDATE")
+	u := &Update{Table: t}
+	for _, set := range s.Set {
+		ord := t.FindColumn(set.Column)
+		if ord < 0 {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q of relation %q does not exist", set.Column, t.Name)
+		}
+		for _, prev := range u.Targets {
+			if prev == ord {
+				return nil, pgerror.Newf(pgerror.CodeSyntaxError, "multiple assignments to same column %q", set.Column)
+			}
+		}
+		col := t.Columns[ord]
+		var e eval.Expr
+		if _, ok := set.Value.(*parser.DefaultExpr); ok {
+			if e, err = p.defaultValue(col); err != nil {
+				return nil, err
+			}
+		} else if col.Generated != "" {
+			return nil, generatedColumnError(col, true)
+		} else if col.Identity == catalog.IdentityAlways {
+			return nil, identityColumnError(col, true)
+		} else {
+			if e, err = p.typeCheck(set.Value, sc); err != nil {
+				return nil, err
+			}
+			if e, err = coerceForAssignment(e, col.Type, set.Column); err != nil {
+				return nil, err
+			}
+		}
+		u.Targets = append(u.Targets, ord)
+		u.Exprs = append(u.Exprs, e)
+	}
+	if t.TTL != nil {
+		// An update pushes the expiration of the rows back, unless it sets
+		// it itself.
+		ord := t.ColumnOrdinal(t.TTL.ColumnID)
+		if !slices.Contains(u.Targets, ord) {
+			e, err := p.defaultValue(t.Columns[ord])
+			if err != nil {
+				return nil, err
+			}
+			u.Targets = append(u.Targets, ord)
+			u.Exprs = append(u.Exprs, e)
+		}
+	}
+	if u.Writes, err = p.tableWrites(t, true); err != nil {
+		return nil, err
+	}
+	if u.Input, err = p.planScan(t, sc, s.Where); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (p *Planner) planDelete(s *parser.DeleteStmt) (Node, error) {
+	t, err := p.lookupTable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	scan, err := p.planScan(t, tableScope(t, s.Table.Alias), s.Where)
+	if err != nil {
+		return nil, err
+	}
+	w, err := p.tableWrites(t, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Delete{Table: t, Input: scan, All: s.Where == nil && len(w.References) == 0, Writes: w}, nil
+}
+
+func (p *Planner) planCreateTable(s *parser.CreateTableStmt) (Node, error) {
+	schema, err := p.creationSchema(s.Name)
+	if err != nil {
+		return nil, err
+	}
+	t := catalog.NewTable(s.Name.Name)
+	t.Schema = schema.ID
+	var pkCols []string
+	var pkDesc []bool
+	pkName := ""
+	setPK := func(name string, cols []string, desc []bool) error {
+		if pkCols != nil {
+			return pgerror.Newf(pgerror.CodeInvalidTableDefinition,
+				"multiple primary keys for table %q are not allowed", t.Name)
+		}
+		pkName, pkCols, pkDesc = name, cols, desc
+		return nil
+	}
+	n := &CreateTable{Table: t, IfNotExists: s.IfNotExists}
+	var uniques []*parser.TableConstraint
+	for _, def := range s.Columns {
+		if t.FindColumn(def.Name) >= 0 {
+			return nil, pgerror.Newf(pgerror.CodeDuplicateColumn, "column %q specified more than once", def.Name)
+		}
+		c, seq, err := p.addColumn(t, def)
+		if err != nil {
+			return nil, err
+		}
+		if seq != nil {
+			n.Sequences = append(n.Sequences, seq)
+		}
+		if def.Generated != nil {
+			// Mark the column generated until its expression is checked
+			// below, once all columns are added.
+			c.Generated = def.Generated.String()
+		}
+		if def.PrimaryKey {
+			if err := setPK("", []string{def.Name}, nil); err != nil {
+				return nil, err
+			}
+		}
+		if def.Unique {
+			uniques = append(uniques, &parser.TableConstraint{Unique: true, Columns: []string{def.Name}})
+		}
+	}
+	if s.With != nil {
+		if _, err := setTableParams(t, s.With); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range s.Constraints {
+		if c.PrimaryKey {
+			if err := setPK(c.Name, c.Columns, c.Desc); err != nil {
+				return nil, err
+			}
+		} else if c.Unique {
+			uniques = append(uniques, c)
+		}
+	}
+	if pkCols == nil {
+		// Rows of a table without a primary key are keyed by a hidden
+		// column, so that they can be addressed all the same.
+		pkCols = []string{t.AddRowID().Name}
+	}
+
+	keyColumns := func(names []string) ([]catalog.ColumnID, error) {
+		ids := make([]catalog.ColumnID, len(names))
+		for i, name := range names {
+			ord := t.FindColumn(name)
+			if ord < 0 {
+				return nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q named in key does not exist", name)
+			}
+			for _, prev := range ids[:i] {
+				if prev == t.Columns[ord].ID {
+					return nil, pgerror.Newf(pgerror.CodeDuplicateColumn,
+						"column %q appears twice in primary key constraint", name)
+				}
+			}
+			ids[i] = t.Columns[ord].ID
+		}
+		return ids, nil
+	}
+	ids, err := keyColumns(pkCols)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		t.Columns[t.ColumnOrdinal(id)].Nullable = false
+	}
+	if pkName == "" {
+		pkName = catalog.DefaultIndexName(t.Name, pkCols, "pkey")
+	}
+	t.PrimaryIndex = &catalog.Index{
+		ID: catalog.PrimaryIndexID, Name: pkName, Unique: true, ColumnIDs: ids,
+		Descending: descending(pkDesc),
+	}
+	for _, c := range uniques {
+		ids, err := keyColumns(c.Columns)
+		if err != nil {
+			return nil, err
+		}
+		name := c.Name
+		if name == "" {
+			name = catalog.DefaultIndexName(t.Name, c.Columns, "key")
+		}
+		t.AddIndex(name, true, ids).Descending = descending(c.Desc)
+	}
+	// Generation expressions and CHECK constraints may refer to any
+	// column, so they are added once all columns are.
+	for ord, def := range s.Columns {
+		if def.Generated != nil {
+			if _, err := p.setGenerated(t, t.Columns[ord], def.Generated); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, def := range s.Columns {
+		for _, c := range def.Checks {
+			if _, err := p.addCheck(t, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, c := range s.Constraints {
+		if c.Check != nil {
+			if _, err := p.addCheck(t, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Foreign keys may reference the table's own keys, which are now
+	// complete.
+	for _, def := range s.Columns {
+		for _, c := range def.ForeignKeys {
+			if _, err := p.addForeignKey(t, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, c := range s.Constraints {
+		if c.References != nil {
+			if _, err := p.addForeignKey(t, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// addColumn adds the column def to t, with its default. It returns the
+// sequence of a serial or identity column, which is to be created with it.
+func (p *Planner) addColumn(t *catalog.Table, def *parser.ColumnDef) (*catalog.Column, *catalog.Sequence, error) {
+	if typ := serialTypes[def.Type.Name]; typ != nil && len(def.Type.Mods) == 0 {
+		return p.serialColumn(t, def, typ)
+	}
+	typ, err := resolveType(def.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := t.AddColumn(def.Name, typ, !def.NotNull)
+	if def.Identity != nil {
+		switch {
+		case def.Default != nil:
+			return nil, nil, pgerror.Newf(pgerror.CodeSyntaxError,
+				"both default and identity specified for column %q of table %q", def.Name, t.Name)
+		case def.Generated != nil:
+			return nil, nil, pgerror.Newf(pgerror.CodeSyntaxError,
+				"both identity and generation expression specified for column %q of table %q", def.Name, t.Name)
+		}
+		seq, err := p.addIdentity(t, c, def.Identity)
+		return c, seq, err
+	}
+	if def.Default != nil {
+		if def.Generated != nil {
+			return nil, nil, pgerror.Newf(pgerror.CodeSyntaxError,
+				"both default and generation expression specified for column %q of table %q", def.Name, t.Name)
+		}
+		if _, err := p.setDefault(c, def.Default); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, nil, nil
+}
+
+func (p *Planner) planCreateIndex(s *parser.CreateIndexStmt) (Node, error) {
+	t, err := p.mustFindTable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]catalog.ColumnID, len(s.Columns))
+	for i, name := range s.Columns {
+		ord := t.FindColumn(name)
+		if ord < 0 {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q does not exist", name)
+		}
+		ids[i] = t.Columns[ord].ID
+	}
+	name := s.Name
+	if name == "" {
+		name = catalog.DefaultIndexName(t.Name, s.Columns, "idx")
+	}
+	idx := &catalog.Index{Name: name, Unique: s.Unique, ColumnIDs: ids, Descending: descending(s.Desc)}
+	if err := indexMethod(s, t, idx); err != nil {
+		return nil, err
+	}
+	n := &CreateIndex{Table: t, Index: idx}
+	if s.IfNotExists {
+		if n.Exists, err = p.relationExists(t.Schema, name); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// descending returns the directions of the columns of a key, of which
+// those marked in desc are descending, or nil if none is.
+func descending(desc []bool) []bool {
+	if !slices.Contains(desc, true) {
+		return nil
+	}
+	return desc
+}
+
+// checkSortOrder checks the sort orders of the columns of a CREATE INDEX.
+// Only a B-tree index is ordered, and as its keys sort NULL as if it were
+// larger than any value, NULLs come last in an ascending column and first
+// in a descending one.
+func checkSortOrder(s *parser.CreateIndexStmt) error {
+	method := s.Using
+	if method == "" {
+		method = "btree"
+	}
+	for i, nulls := range s.Nulls {
+		switch {
+		case method != "btree" && s.Desc[i]:
+			return pgerror.Newf(pgerror.CodeFeatureNotSupported, "access method %q does not support ASC/DESC options", method)
+		case method != "btree" && nulls != parser.NullsDefault:
+			return pgerror.Newf(pgerror.CodeFeatureNotSupported, "access method %q does not support NULLS FIRST/LAST options", method)
+		case nulls == parser.NullsFirst && !s.Desc[i]:
+			return pgerror.Newf(pgerror.CodeFeatureNotSupported, "ascending index column %q cannot sort NULLs first", s.Columns[i]).
+				WithHint("NULLs sort last in an ascending index column and first in a descending one.")
+		case nulls == parser.NullsLast && s.Desc[i]:
+			return pgerror.Newf(pgerror.CodeFeatureNotSupported, "descending index column %q cannot sort NULLs last", s.Columns[i]).
+				WithHint("NULLs sort last in an ascending index column and first in a descending one.")
+		}
+	}
+	return nil
+}
+
+// indexMethod checks the access method, operator classes, sort orders and
+// storage parameters of a CREATE INDEX and sets the parameters of idx.
+func indexMethod(s *parser.CreateIndexStmt, t *catalog.Table, idx *catalog.Index) error {
+	if err := checkSortOrder(s); err != nil {
+		return err
+	}
+	switch s.Using {
+	case "", "btree":
+		for _, opClass := range s.OpClasses {
+			if opClass != "" {
+				return pgerror.Newf(pgerror.CodeUndefinedObject,
+					"operator class %q does not exist for access method \"btree\"", opClass)
+			}
+		}
+		if len(s.With) > 0 {
+			return pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", s.With[0].Name)
+		}
+		return nil
+	case "hnsw":
+		params, err := hnswParams(s, t)
+		if err != nil {
+			return err
+		}
+		idx.HNSW = params
+		return nil
+	case "gin":
+		if err := checkGIN(s, t); err != nil {
+			return err
+		}
+		idx.Inverted = true
+		return nil
+	case "hash":
+		if err := checkHash(s); err != nil {
+			return err
+		}
+		idx.Hash = true
+		return nil
+	case "brin":
+		params, err := brinParams(s, t)
+		if err != nil {
+			return err
+		}
+		idx.BRIN = params
+		return nil
+	}
+	return pgerror.Newf(pgerror.CodeUndefinedObject, "access method %q does not exist", s.Using)
+}
+
+func (p *Planner) planDropTable(s *parser.DropTableStmt) (Node, error) {
+	n := &DropTable{}
+	for _, name := range s.Names {
+		t, err := p.findTable(name)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			if s.IfExists {
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "table %q does not exist", name.String())
+		}
+		n.Tables = append(n.Tables, t)
+	}
+	dropped := func(id catalog.ID) bool {
+		return slices.ContainsFunc(n.Tables, func(t *catalog.Table) bool { return t.ID == id })
+	}
+	detached := make(map[catalog.ID]*catalog.Table)
+	for _, t := range n.Tables {
+		keys, err := p.referencingKeys(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if dropped(k.table.ID) {
+				continue
+			}
+			if !s.Cascade {
+				return nil, dependentObjects("table "+t.Name, "table "+t.Name, k)
+			}
+			c := detached[k.table.ID]
+			if c == nil {
+				c = k.table.Clone()
+				detached[k.table.ID] = c
+				n.Detached = append(n.Detached, c)
+			}
+			c.ForeignKeys = slices.DeleteFunc(c.ForeignKeys, func(fk *catalog.ForeignKey) bool { return fk.Name == k.fk.Name })
+		}
+		seqs, err := p.ownedSequences(t, func(catalog.ColumnID) bool { return true })
+		if err != nil {
+			return nil, err
+		}
+		n.Sequences = append(n.Sequences, seqs...)
+	}
+	return n, nil
+}
+
+func (p *Planner) planAnalyze(s *parser.AnalyzeStmt) (Node, error) {
+	if len(s.Names) == 0 {
+		tables, err := catalog.ListTables(p.Txn)
+		return &Analyze{Tables: tables}, err
+	}
+	n := &Analyze{}
+	for _, name := range s.Names {
+		t, err := p.mustFindTable(name)
+		if err != nil {
+			return nil, err
+		}
+		n.Tables = append(n.Tables, t)
+	}
+	return n, nil
+}
+
+func (p *Planner) planCluster(s *parser.ClusterStmt) (Node, error) {
+	if s.Table == nil {
+		tables, err := catalog.ListTables(p.Txn)
+		return &Cluster{Tables: tables}, err
+	}
+	t, err := p.mustFindTable(s.Table)
+	if err != nil {
+		return nil, err
+	}
+	n := &Cluster{Tables: []*catalog.Table{t}, Named: true}
+	if s.Index == "" {
+		return n, nil
+	}
+	idx := t.FindIndex(s.Index)
+	switch {
+	case idx == nil:
+		return nil, pgerror.Newf(pgerror.CodeUndefinedObject, "index %q for table %q does not exist", s.Index, t.Name)
+	case idx.HNSW != nil || idx.Inverted || idx.Hash || idx.BRIN != nil:
+		return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported,
+			"cannot cluster on index %q because access method does not support clustering", idx.Name)
+	case idx.ID == catalog.PrimaryIndexID:
+		return n, nil
+	case !hiddenKey(t, t.PrimaryIndex):
+		// The rows are stored by their primary key, so they can only take
+		// the order of another index by taking new keys, as rows keyed by
+		// a hidden rowid can.
+		return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "cannot cluster table %q on index %q", t.Name, idx.Name).
+			WithDetail("The rows of a table with a primary key are stored in primary key order.")
+	}
+	n.Index = idx
+	return n, nil
+}
+
+func (p *Planner) planVacuum(s *parser.VacuumStmt) (Node, error) {
+	n := &Vacuum{}
+	for _, opt := range s.Options {
+		switch opt.Name {
+		case "analyze":
+			on, err := copyBool(opt)
+			if err != nil {
+				return nil, err
+			}
+			n.Analyze = on
+		case "full", "freeze", "verbose", "disable_page_skipping", "skip_locked", "process_main",
+			"process_toast", "truncate", "skip_database_stats", "only_database_stats":
+			// Compaction rewrites what it compacts, as VACUUM FULL does,
+			// and has nothing to freeze or skip, so these change nothing.
+			if _, err := copyBool(opt); err != nil {
+				return nil, err
+			}
+		case "index_cleanup", "parallel", "buffer_usage_limit":
+		default:
+			return nil, pgerror.Newf(pgerror.CodeSyntaxError, "unrecognized VACUUM option %q", opt.Name)
+		}
+	}
+	if len(s.Names) == 0 {
+		var err error
+		if n.Database, err = catalog.MustLookupDatabase(p.Txn, p.currentDatabase()); err != nil {
+			return nil, err
+		}
+		n.Tables, err = catalog.ListTables(p.Txn)
+		return n, err
+	}
+	for _, name := range s.Names {
+		t, err := p.mustFindTable(name)
+		if err != nil {
+			return nil, err
+		}
+		n.Tables = append(n.Tables, t)
+	}
+	return n, nil
+}
+
+func (p *Planner) planTruncate(s *parser.TruncateStmt) (Node, error) {
+	n := &Truncate{}
+	truncated := func(id catalog.ID) bool {
+		return slices.ContainsFunc(n.Tables, func(t *catalog.Table) bool { return t.ID == id })
+	}
+	for _, name := range s.Names {
+		t, err := p.mustFindTable(name)
+		if err != nil {
+			return nil, err
+		}
+		if !truncated(t.ID) {
+			n.Tables = append(n.Tables, t)
+		}
+	}
+	// The rows of a table referencing a truncated one would be left
+	// dangling, so it must be truncated too. CASCADE adds it, and those
+	// referencing it in turn.
+	for i := 0; i < len(n.Tables); i++ {
+		t := n.Tables[i]
+		keys, err := p.referencingKeys(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			if truncated(k.table.ID) {
+				continue
+			}
+			if !s.Cascade {
+				return nil, pgerror.New(pgerror.CodeFeatureNotSupported,
+					"cannot truncate a table referenced in a foreign key constraint").
+					WithDetail(fmt.Sprintf("Table %q references %q.", k.table.Name, t.Name)).
+					WithHint(fmt.Sprintf("Truncate table %q at the same time, or use TRUNCATE ... CASCADE.", k.table.Name))
+			}
+			n.Tables = append(n.Tables, k.table)
+		}
+	}
+	if s.RestartIdentity {
+		for _, t := range n.Tables {
+			seqs, err := p.ownedSequences(t, func(catalog.ColumnID) bool { return true })
+			if err != nil {
+				return nil, err
+			}
+			n.Sequences = append(n.Sequences, seqs...)
+		}
+	}
+	return n, nil
+}
+
+func (p *Planner) planDropIndex(s *parser.DropIndexStmt) (Node, error) {
+	n := &DropIndex{}
+	for _, name := range s.Names {
+		t, idx, err := p.findIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		if idx == nil {
+			if s.IfExists {
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.CodeUndefinedObject, "index %q does not exist", name.String())
+		}
+		if idx.ID == catalog.PrimaryIndexID {
+			return nil, pgerror.Newf(pgerror.CodeDependentObjectsExist,
+				"cannot drop index %s because constraint %s on table %s requires it", idx.Name, idx.Name, t.Name).
+				WithHint(fmt.Sprintf("You can drop constraint %s on table %s instead.", idx.Name, t.Name))
+		}
+		if idx.Unique {
+			keys, err := p.referencingKeys(t)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range keys {
+				if referencedIndex(t, k.fk.ReferencedColumnIDs) == idx {
+					return nil, dependentObjects("index "+idx.Name, "index "+idx.Name, k)
+				}
+			}
+		}
+		n.Indexes = append(n.Indexes, IndexRef{Table: t, Index: idx})
+	}
+	return n, nil
+}
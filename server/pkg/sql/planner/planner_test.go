@@ -0,0 +1,125 @@
+package planner_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+func TestPlans(t *testing.T) {
+	s, err := sql.NewServer(memory.New()).Connect("postgres", "postgres")
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer s.Close()
+	for _, q := range []string{
+		"CREATE TABLE t (id int PRIMARY KEY, a int, b text)",
+		"CREATE INDEX t_a ON t (a)",
+		"CREATE TABLE u (id int PRIMARY KEY, t_id int)",
+	} {
+		if _, err := s.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	for _, tc := range []struct {
+		query string
+		plan  []string
+	}{
+		// Conditions on the primary key become spans.
+		{"SELECT * FROM t WHERE id = 5", []string{
+			"Project: id, a, b",
+			"  Scan: t",
+			"    Spans: [5 - 5]",
+			"    Filter: (id = 5)",
+		}},
+		{"SELECT * FROM t WHERE id BETWEEN 2 AND 4", []string{
+			"Project: id, a, b",
+			"  Scan: t",
+			"    Spans: [2 - 4]",
+			"    Filter: ((id >= 2) AND (id <= 4))",
+		}},
+		{"SELECT * FROM t WHERE id > 2 AND b = 'x'", []string{
+			"Project: id, a, b",
+			"  Scan: t",
+			"    Spans: (2 - ]",
+			"    Filter: ((id > 2) AND (b = 'x'))",
+		}},
+		// Constants are folded before spans are derived.
+		{"SELECT * FROM t WHERE 1 + 1 = id", []string{
+			"Project: id, a, b",
+			"  Scan: t",
+			"    Spans: [2 - 2]",
+			"    Filter: (2 = id)",
+		}},
+		{"SELECT 1 + 2", []string{
+			"Project: 3",
+			"  Values: 1 row(s)",
+		}},
+		// A secondary index serves conditions on its columns.
+		{"SELECT * FROM t WHERE a = 3", []string{
+			"Project: id, a, b",
+			"  Index Scan: t@t_a",
+			"    Spans: [3 - 3]",
+			"    Filter: (a = 3)",
+		}},
+		{"SELECT a FROM t WHERE a IN (1, 2)", []string{
+			"Project: a",
+			"  Index Scan: t@t_a",
+			"    Spans: [1 - 1], [2 - 2]",
+			"    Filter: (a IN (1, 2))",
+		}},
+		// A scan in the order asked for needs no sort.
+		{"SELECT * FROM t ORDER BY id", []string{
+			"Project: id, a, b",
+			"  Scan: t",
+			"    Spans: FULL SCAN",
+		}},
+		{"SELECT * FROM t ORDER BY a LIMIT 1", []string{
+			"Limit: 1",
+			"  Project: id, a, b",
+			"    Index Scan: t@t_a",
+			"      Spans: FULL SCAN",
+		}},
+		// Otherwise a LIMIT bounds the sort.
+		{"SELECT * FROM t ORDER BY id DESC LIMIT 3", []string{
+			"Limit: 3",
+			"  Top-K Sort: id DESC",
+			"    Project: id, a, b",
+			"      Scan: t",
+			"        Spans: FULL SCAN",
+		}},
+		// A join on the primary key of one side looks its rows up.
+		{"SELECT t.b FROM u JOIN t ON t.id = u.t_id", []string{
+			"Project: t.b",
+			"  Lookup Join: t@t_pkey",
+			"    Lookup Key: u.t_id",
+			"    Scan: u",
+			"      Spans: FULL SCAN",
+		}},
+		// Any other equality hashes the rows of one side.
+		{"SELECT t.b FROM u JOIN t ON t.b = u.id::text", []string{
+			"Project: t.b",
+			"  Hash Join",
+			"    Hash Cond: (u.id::text = t.b)",
+			"    Scan: u",
+			"      Spans: FULL SCAN",
+			"    Scan: t",
+			"      Spans: FULL SCAN",
+		}},
+	} {
+		res, err := s.Exec("EXPLAIN " + tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		var plan []string
+		for _, row := range res[0].Rows {
+			plan = append(plan, row[0].String())
+		}
+		if got, want := strings.Join(plan, "\n"), strings.Join(tc.plan, "\n"); got != want {
+			t.Errorf("EXPLAIN %s =\n%s\nwant\n%s", tc.query, got, want)
+		}
+	}
+}
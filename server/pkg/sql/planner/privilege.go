@@ -0,0 +1,160 @@
+package planner
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// publicRole is the grantee name that stands for PUBLIC, which no role may
+// take.
+const publicRole = "public"
+
+func (p *Planner) planGrant(s *parser.GrantStmt) (Node, error) {
+	all := catalog.TablePrivileges
+	if s.Schemas != nil {
+		all = catalog.SchemaPrivileges
+	}
+	privs := all
+	if s.Privileges != nil {
+		privs = 0
+		for _, name := range s.Privileges {
+			priv, ok := catalog.ParsePrivilege(name)
+			if !ok || priv&all == 0 {
+				kind := "table"
+				if s.Schemas != nil {
+					kind = "schema"
+				}
+				return nil, pgerror.Newf(pgerror.CodeInvalidGrantOperation, "invalid privilege type %s for %s", name, kind)
+			}
+			privs |= priv
+		}
+	}
+	grantees := make([]catalog.ID, len(s.Grantees))
+	for i, name := range s.Grantees {
+		if name == publicRole {
+			if s.GrantOption && !s.Revoke {
+				return nil, pgerror.New(pgerror.CodeInvalidGrantOperation, "grant options can only be granted to roles")
+			}
+			grantees[i] = catalog.Public
+			continue
+		}
+		role, err := catalog.MustLookupRole(p.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		grantees[i] = role.ID
+	}
+	cur, err := p.currentRole()
+	if err != nil {
+		return nil, err
+	}
+	roles, err := catalog.InheritedRoles(p.Txn, cur)
+	if err != nil {
+		return nil, err
+	}
+	// change applies the statement to the ACL of an object, if the current
+	// role may grant the privileges on it: as a superuser, a member of its
+	// owner, or with the grant option for them.
+	var grantor catalog.ID
+	if cur != nil {
+		grantor = cur.ID
+	}
+	change := func(owner catalog.ID, acl catalog.ACL, kind, name string) (catalog.ACL, error) {
+		if cur != nil && !cur.Superuser && !catalog.HasPrivilege(roles, owner, acl, privs, true) {
+			return nil, pgerror.Newf(pgerror.CodeInsufficientPrivilege, "permission denied for %s %s", kind, name)
+		}
+		for _, g := range grantees {
+			if s.Revoke {
+				acl = acl.Revoke(g, privs, s.GrantOption)
+			} else {
+				acl = acl.Grant(g, grantor, privs, s.GrantOption)
+			}
+		}
+		return acl, nil
+	}
+	n := &Grant{}
+	for _, tn := range s.Tables {
+		t, err := p.lookupTable(tn)
+		if err != nil {
+			return nil, err
+		}
+		t = t.Clone()
+		if t.ACL, err = change(t.Owner, t.ACL, "table", t.Name); err != nil {
+			return nil, err
+		}
+		n.Tables = append(n.Tables, t)
+	}
+	for _, name := range s.Schemas {
+		schema, err := catalog.LookupSchema(p.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		if schema == nil {
+			return nil, pgerror.Newf(pgerror.CodeInvalidSchemaName, "schema %q does not exist", name)
+		}
+		if schema.ACL, err = change(schema.Owner, schema.ACL, "schema", name); err != nil {
+			return nil, err
+		}
+		n.Schemas = append(n.Schemas, schema)
+	}
+	return n, nil
+}
+
+// checkRoleDependents returns an error if objects depend on role: if it
+// owns any, or has been granted privileges on any, as DROP ROLE requires.
+func (p *Planner) checkRoleDependents(role *catalog.Role) error {
+	depends := func(owner catalog.ID, acl catalog.ACL) bool {
+		if owner == role.ID {
+			return true
+		}
+		for _, g := range acl {
+			if g.Grantee == role.ID {
+				return true
+			}
+		}
+		return false
+	}
+	found := func() *pgerror.Error {
+		return pgerror.Newf(pgerror.CodeDependentObjectsExist, "role %q cannot be dropped because some objects depend on it", role.Name)
+	}
+	tables, err := catalog.ListTables(p.Txn)
+	if err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if depends(t.Owner, t.ACL) {
+			return found()
+		}
+	}
+	seqs, err := catalog.ListSequences(p.Txn)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if depends(seq.Owner, nil) {
+			return found()
+		}
+	}
+	schemas, err := catalog.ListSchemas(p.Txn)
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		if depends(schema.Owner, schema.ACL) {
+			return found()
+		}
+	}
+	// Databases are shared, so those the role owns are found from any
+	// database.
+	dbs, err := catalog.ListDatabases(p.Txn)
+	if err != nil {
+		return err
+	}
+	for _, db := range dbs {
+		if depends(db.Owner, nil) {
+			return found().WithDetail("owner of database " + db.Name)
+		}
+	}
+	return nil
+}
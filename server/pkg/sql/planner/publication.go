@@ -0,0 +1,72 @@
+package planner
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+func (p *Planner) planCreatePublication(s *parser.CreatePublicationStmt) (Node, error) {
+	pub := &catalog.Publication{Name: s.Name, AllTables: s.AllTables, Insert: true, Update: true, Delete: true, Truncate: true}
+	n := &CreatePublication{Publication: pub}
+	for _, tn := range s.Tables {
+		t, err := p.mustFindTable(tn)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(pub.Tables, t.ID) {
+			pub.Tables = append(pub.Tables, t.ID)
+			n.Tables = append(n.Tables, t)
+		}
+	}
+	for _, w := range s.With {
+		if w.Name != "publish" {
+			return nil, pgerror.Newf(pgerror.CodeSyntaxError, "unrecognized publication parameter: %q", w.Name)
+		}
+		pub.Insert, pub.Update, pub.Delete, pub.Truncate = false, false, false, false
+		for _, kind := range strings.Split(w.Value, ",") {
+			switch strings.TrimSpace(kind) {
+			case "insert":
+				pub.Insert = true
+			case "update":
+				pub.Update = true
+			case "delete":
+				pub.Delete = true
+			case "truncate":
+				pub.Truncate = true
+			case "":
+			default:
+				return nil, pgerror.Newf(pgerror.CodeSyntaxError, "unrecognized value for publication option %q: %q", w.Name, strings.TrimSpace(kind))
+			}
+		}
+	}
+	existing, err := catalog.LookupPublication(p.Txn, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, pgerror.Newf(pgerror.CodeDuplicateObject, "publication %q already exists", s.Name)
+	}
+	return n, nil
+}
+
+func (p *Planner) planDropPublication(s *parser.DropPublicationStmt) (Node, error) {
+	n := &DropPublication{}
+	for _, name := range s.Names {
+		pub, err := catalog.LookupPublication(p.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		if pub == nil {
+			if s.IfExists {
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.CodeUndefinedObject, "publication %q does not exist", name)
+		}
+		n.Publications = append(n.Publications, pub)
+	}
+	return n, nil
+}
@@ -0,0 +1,340 @@
+package planner
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/scram"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// currentRole returns the role the statement runs as, or nil for a
+// session of the embedder, which runs as no role and may do anything.
+func (p *Planner) currentRole() (*catalog.Role, error) {
+	if p.User == "" {
+		return nil, nil
+	}
+	return catalog.MustLookupRole(p.Txn, p.User)
+}
+
+// canManageRole reports whether the current role may create, change or
+// drop target, or grant membership in it: superusers may manage any role,
+// and roles with CREATEROLE those that are not superusers.
+func (p *Planner) canManageRole(target *catalog.Role) (bool, error) {
+	cur, err := p.currentRole()
+	if err != nil || cur == nil {
+		return cur == nil, err
+	}
+	return cur.Superuser || cur.CreateRole && !target.Superuser, nil
+}
+
+// applyRoleOptions sets the options of o on role.
+func applyRoleOptions(role *catalog.Role, o *parser.RoleOptions) error {
+	for _, f := range []struct {
+		opt *bool
+		dst *bool
+	}{
+		{o.Superuser, &role.Superuser},
+		{o.CreateDB, &role.CreateDB},
+		{o.CreateRole, &role.CreateRole},
+		{o.Inherit, &role.Inherit},
+		{o.Login, &role.Login},
+	} {
+		if f.opt != nil {
+			*f.dst = *f.opt
+		}
+	}
+	if o.ConnLimit != nil {
+		switch n := *o.ConnLimit; {
+		case n < -1 || n > math.MaxInt32:
+			return pgerror.Newf(pgerror.CodeInvalidParameterValue, "invalid connection limit: %d", n)
+		case n == -1:
+			role.ConnLimit = nil
+		default:
+			limit := int(n)
+			role.ConnLimit = &limit
+		}
+	}
+	switch {
+	case o.NoPassword:
+		role.Password = ""
+	case o.Password != nil && *o.Password == "":
+		// As in PostgreSQL, an empty password is no password.
+		role.Password = ""
+	case o.Password != nil && scram.IsVerifier(*o.Password):
+		role.Password = *o.Password
+	case o.Password != nil:
+		v, err := scram.NewVerifier(*o.Password)
+		if err != nil {
+			return err
+		}
+		role.Password = v.String()
+	}
+	return nil
+}
+
+// checkRoleName returns an error if name may not be the name of a role.
+func checkRoleName(name string) error {
+	if name == publicRole {
+		return pgerror.Newf(pgerror.CodeReservedName, "role name %q is reserved", name)
+	}
+	return nil
+}
+
+func (p *Planner) planCreateRole(s *parser.CreateRoleStmt) (Node, error) {
+	if err := checkRoleName(s.Name); err != nil {
+		return nil, err
+	}
+	role := &catalog.Role{Name: s.Name, Inherit: true}
+	if err := applyRoleOptions(role, &s.Options); err != nil {
+		return nil, err
+	}
+	ok, err := p.canManageRole(role)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if role.Superuser {
+			return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "must be superuser to create superusers")
+		}
+		return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to create role")
+	}
+	if existing, err := catalog.LookupRole(p.Txn, s.Name); err != nil {
+		return nil, err
+	} else if existing != nil {
+		return nil, pgerror.Newf(pgerror.CodeDuplicateObject, "role %q already exists", s.Name)
+	}
+	n := &CreateRole{Role: role}
+	for _, name := range s.Options.InRole {
+		of, err := p.grantableRole(name)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(role.MemberOf, of.ID) {
+			role.MemberOf = append(role.MemberOf, of.ID)
+		}
+	}
+	for _, name := range s.Options.Roles {
+		if name == s.Name {
+			return nil, pgerror.Newf(pgerror.CodeInvalidGrantOperation, "role %q is a member of role %q", name, name)
+		}
+		member, err := catalog.MustLookupRole(p.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		n.Members = append(n.Members, member)
+	}
+	return n, nil
+}
+
+// grantableRole returns the role named name, if the current role may grant
+// membership in it.
+func (p *Planner) grantableRole(name string) (*catalog.Role, error) {
+	role, err := catalog.MustLookupRole(p.Txn, name)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := p.canManageRole(role)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, pgerror.Newf(pgerror.CodeInsufficientPrivilege, "permission denied to grant role %q", name)
+	}
+	return role, nil
+}
+
+func (p *Planner) planAlterRole(s *parser.AlterRoleStmt) (Node, error) {
+	if s.Set != nil {
+		return p.planAlterRoleSettings(s)
+	}
+	old, err := catalog.MustLookupRole(p.Txn, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	role := *old
+	role.MemberOf = slices.Clone(old.MemberOf)
+	n := &AlterRole{Role: &role}
+	ok, err := p.canManageRole(old)
+	if err != nil {
+		return nil, err
+	}
+	if s.NewName != "" {
+		if !ok {
+			return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to rename role")
+		}
+		if s.Name == p.User {
+			return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "session user cannot be renamed")
+		}
+		if err := checkRoleName(s.NewName); err != nil {
+			return nil, err
+		}
+		if existing, err := catalog.LookupRole(p.Txn, s.NewName); err != nil {
+			return nil, err
+		} else if existing != nil {
+			return nil, pgerror.Newf(pgerror.CodeDuplicateObject, "role %q already exists", s.NewName)
+		}
+		role.Name, n.OldName = s.NewName, s.Name
+		return n, nil
+	}
+	if err := applyRoleOptions(&role, &s.Options); err != nil {
+		return nil, err
+	}
+	// A role may change its own password without CREATEROLE.
+	o := &s.Options
+	own := s.Name == p.User && o.Superuser == nil && o.CreateDB == nil && o.CreateRole == nil && o.Inherit == nil && o.Login == nil
+	if !ok && !own || role.Superuser && !old.Superuser && !p.isSuperuser() {
+		return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to alter role")
+	}
+	return n, nil
+}
+
+// planAlterRoleSettings plans ALTER ROLE ... SET and RESET. Superusers
+// may pin the defaults of any role, and of every role, roles with
+// CREATEROLE those of the roles that are not superusers, and every role
+// its own.
+func (p *Planner) planAlterRoleSettings(s *parser.AlterRoleStmt) (Node, error) {
+	settings := &catalog.RoleSettings{Database: catalog.AllDatabases, Role: catalog.AllRoles}
+	if s.All {
+		if !p.isSuperuser() {
+			return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to alter settings of all roles")
+		}
+	} else {
+		role, err := catalog.MustLookupRole(p.Txn, s.Name)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := p.canManageRole(role)
+		if err != nil {
+			return nil, err
+		}
+		if !ok && s.Name != p.User {
+			return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to alter role")
+		}
+		settings.Role = role.ID
+	}
+	if s.Database != "" {
+		db, err := catalog.MustLookupDatabase(p.Txn, s.Database)
+		if err != nil {
+			return nil, err
+		}
+		settings.Database = db.ID
+	}
+	old, err := catalog.GetRoleSettings(p.Txn, settings.Database, settings.Role)
+	if err != nil {
+		return nil, err
+	}
+	settings.Settings = make(map[string]string)
+	if old != nil {
+		maps.Copy(settings.Settings, old.Settings)
+	}
+	set := s.Set
+	name := strings.ToLower(set.Name)
+	switch {
+	case set.All:
+		clear(settings.Settings)
+	case set.Default:
+		delete(settings.Settings, name)
+	default:
+		if p.CheckSetting == nil {
+			return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "ALTER ROLE ... SET is not supported")
+		}
+		v, err := p.CheckSetting(name, set.Values)
+		if err != nil {
+			return nil, err
+		}
+		settings.Settings[name] = v
+	}
+	return &AlterRoleSettings{Settings: settings}, nil
+}
+
+// isSuperuser reports whether the current role is a superuser, as is a
+// session of the embedder.
+func (p *Planner) isSuperuser() bool {
+	cur, err := p.currentRole()
+	return err == nil && (cur == nil || cur.Superuser)
+}
+
+func (p *Planner) planDropRole(s *parser.DropRoleStmt) (Node, error) {
+	n := &DropRole{}
+	for _, name := range s.Names {
+		role, err := catalog.LookupRole(p.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			if s.IfExists {
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.CodeUndefinedObject, "role %q does not exist", name)
+		}
+		if name == p.User {
+			return nil, pgerror.New(pgerror.CodeObjectInUse, "current user cannot be dropped")
+		}
+		ok, err := p.canManageRole(role)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to drop role")
+		}
+		if err := p.checkRoleDependents(role); err != nil {
+			return nil, err
+		}
+		n.Roles = append(n.Roles, role)
+	}
+	return n, nil
+}
+
+func (p *Planner) planGrantRole(s *parser.GrantRoleStmt) (Node, error) {
+	n := &GrantRole{}
+	changed := make(map[string]*catalog.Role)
+	for _, memberName := range s.Members {
+		member := changed[memberName]
+		if member == nil {
+			m, err := catalog.MustLookupRole(p.Txn, memberName)
+			if err != nil {
+				return nil, err
+			}
+			member = m
+		}
+		for _, name := range s.Roles {
+			role, err := p.grantableRole(name)
+			if err != nil {
+				return nil, err
+			}
+			i := slices.Index(member.MemberOf, role.ID)
+			if s.Revoke {
+				if i >= 0 {
+					member.MemberOf = slices.Delete(slices.Clone(member.MemberOf), i, i+1)
+					changed[memberName] = member
+				}
+				continue
+			}
+			if i >= 0 {
+				continue
+			}
+			// Membership must not be circular: role must not already be a
+			// member of the new member.
+			cycle, err := catalog.MemberOf(p.Txn, role, member.ID, true)
+			if err != nil {
+				return nil, err
+			}
+			if cycle {
+				return nil, pgerror.Newf(pgerror.CodeInvalidGrantOperation, "role %q is a member of role %q", name, memberName)
+			}
+			member.MemberOf = append(slices.Clone(member.MemberOf), role.ID)
+			changed[memberName] = member
+		}
+	}
+	for _, name := range s.Members {
+		if m := changed[name]; m != nil && !slices.Contains(n.Roles, m) {
+			n.Roles = append(n.Roles, m)
+		}
+	}
+	return n, nil
+}
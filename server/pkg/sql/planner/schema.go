@@ -0,0 +1,217 @@
+package planner
+
+import (
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// searchPath returns the IDs of the schemas of the search path that exist,
+// in order.
+func (p *Planner) searchPath() ([]catalog.ID, error) {
+	return catalog.SearchPath(p.Txn, p.SearchPath, p.User)
+}
+
+// schemaOf returns the schema of the relation tn names: the schema it is
+// qualified with, or else the first schema of the search path holding a
+// relation of that name. ok is false if tn is not qualified and no schema
+// of the search path holds one.
+func (p *Planner) schemaOf(tn *parser.TableName) (id catalog.ID, ok bool, err error) {
+	if tn.Schema != "" {
+		s, err := catalog.MustLookupSchema(p.Txn, tn.Schema)
+		if err != nil {
+			return 0, false, err
+		}
+		return s.ID, true, nil
+	}
+	path, err := p.searchPath()
+	if err != nil {
+		return 0, false, err
+	}
+	return catalog.Resolve(p.Txn, path, tn.Name)
+}
+
+// findTable returns the table tn names, or nil if there is none.
+func (p *Planner) findTable(tn *parser.TableName) (*catalog.Table, error) {
+	schema, ok, err := p.schemaOf(tn)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return catalog.LookupTable(p.Txn, schema, tn.Name)
+}
+
+// mustFindTable is findTable but reports a missing table as an error.
+func (p *Planner) mustFindTable(tn *parser.TableName) (*catalog.Table, error) {
+	t, err := p.findTable(tn)
+	if err == nil && t == nil {
+		err = pgerror.Newf(pgerror.CodeUndefinedTable, "relation %q does not exist", tn.String())
+	}
+	return t, err
+}
+
+// findIndex returns the index tn names and its table, or nils if there is
+// none.
+func (p *Planner) findIndex(tn *parser.TableName) (*catalog.Table, *catalog.Index, error) {
+	schema, ok, err := p.schemaOf(tn)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	return catalog.LookupIndex(p.Txn, schema, tn.Name)
+}
+
+// findSequence returns the sequence tn names, or nil if there is none.
+func (p *Planner) findSequence(tn *parser.TableName) (*catalog.Sequence, error) {
+	schema, ok, err := p.schemaOf(tn)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return catalog.LookupSequence(p.Txn, schema, tn.Name)
+}
+
+// creationSchema returns the schema a relation named tn is created in: the
+// schema it is qualified with, or else the first schema of the search path
+// that exists.
+func (p *Planner) creationSchema(tn *parser.TableName) (*catalog.Schema, error) {
+	if tn.Schema == "" {
+		path, err := p.searchPath()
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return nil, pgerror.New(pgerror.CodeInvalidSchemaName, "no schema has been selected to create in")
+		}
+		return catalog.GetSchemaByID(p.Txn, path[0])
+	}
+	if tn.Schema == vtable.Schema || tn.Schema == vtable.InformationSchema {
+		return nil, pgerror.Newf(pgerror.CodeInsufficientPrivilege, "permission denied to create %q", tn.String()).
+			WithDetail("System catalog modifications are currently disallowed.")
+	}
+	return catalog.MustLookupSchema(p.Txn, tn.Schema)
+}
+
+// relationExists reports whether a table, index or sequence is named name
+// in schema.
+func (p *Planner) relationExists(schema catalog.ID, name string) (bool, error) {
+	_, ok, err := catalog.Resolve(p.Txn, []catalog.ID{schema}, name)
+	return ok, err
+}
+
+func (p *Planner) planCreateSchema(s *parser.CreateSchemaStmt) (Node, error) {
+	if strings.HasPrefix(s.Name, "pg_") {
+		return nil, pgerror.Newf(pgerror.CodeReservedName, "unacceptable schema name %q", s.Name).
+			WithDetail(`The prefix "pg_" is reserved for system schemas.`)
+	}
+	n := &CreateSchema{Schema: &catalog.Schema{Name: s.Name}}
+	if s.Authorization != "" {
+		role, err := catalog.MustLookupRole(p.Txn, s.Authorization)
+		if err != nil {
+			return nil, err
+		}
+		n.Schema.Owner = role.ID
+	}
+	existing, err := catalog.LookupSchema(p.Txn, s.Name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil || s.Name == vtable.InformationSchema {
+		if !s.IfNotExists {
+			return nil, pgerror.Newf(pgerror.CodeDuplicateSchema, "schema %q already exists", s.Name)
+		}
+		n.Exists = true
+	}
+	return n, nil
+}
+
+func (p *Planner) planDropSchema(s *parser.DropSchemaStmt) (Node, error) {
+	n := &DropSchema{}
+	for _, name := range s.Names {
+		if name == catalog.PublicSchema || name == vtable.Schema || name == vtable.InformationSchema {
+			return nil, pgerror.Newf(pgerror.CodeDependentObjectsExist,
+				"cannot drop schema %s because it is required by the database system", name)
+		}
+		schema, err := catalog.LookupSchema(p.Txn, name)
+		if err != nil {
+			return nil, err
+		}
+		if schema == nil {
+			if s.IfExists {
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.CodeInvalidSchemaName, "schema %q does not exist", name)
+		}
+		n.Schemas = append(n.Schemas, schema)
+	}
+	dropped := func(id catalog.ID) bool {
+		for _, schema := range n.Schemas {
+			if schema.ID == id {
+				return true
+			}
+		}
+		return false
+	}
+	tables, err := catalog.ListTables(p.Txn)
+	if err != nil {
+		return nil, err
+	}
+	seqs, err := catalog.ListSequences(p.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var dropTables []*parser.TableName
+	for _, schema := range n.Schemas {
+		for _, t := range tables {
+			if t.Schema != schema.ID {
+				continue
+			}
+			if !s.Cascade {
+				return nil, schemaNotEmpty(schema, "table "+schema.Name+"."+t.Name)
+			}
+			dropTables = append(dropTables, &parser.TableName{Schema: schema.Name, Name: t.Name})
+		}
+		for _, seq := range seqs {
+			if seq.Schema != schema.ID {
+				continue
+			}
+			if !s.Cascade {
+				return nil, schemaNotEmpty(schema, "sequence "+schema.Name+"."+seq.Name)
+			}
+			// Sequences owned by a dropped table go with it.
+			if seq.OwnedBy == nil || !dropped(tableSchema(tables, seq.OwnedBy.Table)) {
+				n.Sequences = append(n.Sequences, seq)
+			}
+		}
+	}
+	if dropTables != nil {
+		// The tables are dropped as DROP TABLE ... CASCADE drops them,
+		// detaching the foreign keys of other schemas' tables.
+		plan, err := p.planDropTable(&parser.DropTableStmt{Names: dropTables, Cascade: true})
+		if err != nil {
+			return nil, err
+		}
+		n.DropTable = plan.(*DropTable)
+	}
+	return n, nil
+}
+
+// schemaNotEmpty is the error of dropping a schema holding a relation
+// without CASCADE.
+func schemaNotEmpty(schema *catalog.Schema, object string) error {
+	return pgerror.Newf(pgerror.CodeDependentObjectsExist,
+		"cannot drop schema %s because other objects depend on it", schema.Name).
+		WithDetail(object + " depends on schema " + schema.Name).
+		WithHint("Use DROP ... CASCADE to drop the dependent objects too.")
+}
+
+// tableSchema returns the schema of the table with the given ID among
+// tables.
+func tableSchema(tables []*catalog.Table, id catalog.ID) catalog.ID {
+	for _, t := range tables {
+		if t.ID == id {
+			return t.Schema
+		}
+	}
+	return catalog.PublicSchemaID
+}
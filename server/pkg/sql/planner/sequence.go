@@ -0,0 +1,328 @@
+package planner
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// sequenceTypeNames are the SQL names of the types of sequences.
+var sequenceTypeNames = map[*types.T]string{
+	types.Int2: "smallint",
+	types.Int4: "integer",
+	types.Int8: "bigint",
+}
+
+// serialTypes are the types of serial columns by the name of their
+// pseudo-type.
+var serialTypes = map[string]*types.T{
+	"smallserial": types.Int2, "serial2": types.Int2,
+	"serial": types.Int4, "serial4": types.Int4,
+	"bigserial": types.Int8, "serial8": types.Int8,
+}
+
+// typeBounds returns the smallest and largest values of the integer type
+// t.
+func typeBounds(t *types.T) (int64, int64) {
+	switch t {
+	case types.Int2:
+		return math.MinInt16, math.MaxInt16
+	case types.Int4:
+		return math.MinInt32, math.MaxInt32
+	}
+	return math.MinInt64, math.MaxInt64
+}
+
+// newSequence checks the options of CREATE SEQUENCE and returns the
+// sequence they describe, unnamed, of type typ unless they give one. As in
+// PostgreSQL, an ascending sequence counts from 1 up to the largest value
+// of its type by default, and a descending one from -1 down to the
+// smallest.
+func newSequence(s *parser.CreateSequenceStmt, typ *types.T) (*catalog.Sequence, error) {
+	seq := &catalog.Sequence{Type: typ, Increment: 1, Cache: 1, Cycle: s.Cycle, Gapless: s.Gapless}
+	if s.Type != nil {
+		t, err := resolveType(s.Type)
+		if err != nil {
+			return nil, err
+		}
+		if sequenceTypeNames[t] == "" {
+			return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "sequence type must be smallint, integer, or bigint")
+		}
+		seq.Type = t
+	}
+	if s.Increment != nil {
+		if *s.Increment == 0 {
+			return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "INCREMENT must not be zero")
+		}
+		seq.Increment = *s.Increment
+	}
+	lo, hi := typeBounds(seq.Type)
+	seq.MinValue, seq.MaxValue = 1, hi
+	if seq.Increment < 0 {
+		seq.MinValue, seq.MaxValue = lo, -1
+	}
+	for _, opt := range []struct {
+		name string
+		v    *int64
+		dst  *int64
+	}{{"MAXVALUE", s.MaxValue, &seq.MaxValue}, {"MINVALUE", s.MinValue, &seq.MinValue}} {
+		if opt.v == nil {
+			continue
+		}
+		if *opt.v < lo || *opt.v > hi {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"%s (%d) is out of range for sequence data type %s", opt.name, *opt.v, sequenceTypeNames[seq.Type])
+		}
+		*opt.dst = *opt.v
+	}
+	if seq.MinValue >= seq.MaxValue {
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+			"MINVALUE (%d) must be less than MAXVALUE (%d)", seq.MinValue, seq.MaxValue)
+	}
+	seq.Start = seq.MinValue
+	if seq.Increment < 0 {
+		seq.Start = seq.MaxValue
+	}
+	if s.Start != nil {
+		seq.Start = *s.Start
+	}
+	switch {
+	case seq.Start < seq.MinValue:
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+			"START value (%d) cannot be less than MINVALUE (%d)", seq.Start, seq.MinValue)
+	case seq.Start > seq.MaxValue:
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+			"START value (%d) cannot be greater than MAXVALUE (%d)", seq.Start, seq.MaxValue)
+	}
+	if s.Cache != nil {
+		if *s.Cache <= 0 {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "CACHE (%d) must be greater than zero", *s.Cache)
+		}
+		seq.Cache = *s.Cache
+	}
+	if seq.Gapless && seq.Cache > 1 {
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "a GAPLESS sequence cannot have a CACHE greater than 1")
+	}
+	return seq, nil
+}
+
+func (p *Planner) planCreateSequence(s *parser.CreateSequenceStmt) (Node, error) {
+	schema, err := p.creationSchema(s.Name)
+	if err != nil {
+		return nil, err
+	}
+	seq, err := newSequence(s, types.Int8)
+	if err != nil {
+		return nil, err
+	}
+	seq.Name, seq.Schema = s.Name.Name, schema.ID
+	n := &CreateSequence{Sequence: seq}
+	if s.IfNotExists {
+		if n.Exists, err = p.relationExists(schema.ID, seq.Name); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// serialColumn makes the column def of t, whose type is a serial
+// pseudo-type, an integer column taking its values from a new sequence,
+// which it returns. The sequence is named as in PostgreSQL and is owned by
+// the column, except that the table's ID is set when it is created.
+func (p *Planner) serialColumn(t *catalog.Table, def *parser.ColumnDef, typ *types.T) (*catalog.Column, *catalog.Sequence, error) {
+	if def.Default != nil || def.Generated != nil || def.Identity != nil {
+		return nil, nil, pgerror.Newf(pgerror.CodeSyntaxError,
+			"multiple default values specified for column %q of table %q", def.Name, t.Name)
+	}
+	c := t.AddColumn(def.Name, typ, false)
+	_, hi := typeBounds(typ)
+	seq := &catalog.Sequence{Type: typ, Increment: 1, MinValue: 1, MaxValue: hi, Start: 1, Cache: 1}
+	if err := p.ownSequence(t, c, seq); err != nil {
+		return nil, nil, err
+	}
+	return c, seq, nil
+}
+
+// ownSequence makes seq the sequence owned by column c of t, named as in
+// PostgreSQL and in the table's schema, and sets the column's default to
+// take its next value.
+func (p *Planner) ownSequence(t *catalog.Table, c *catalog.Column, seq *catalog.Sequence) error {
+	base := t.Name + "_" + c.Name + "_seq"
+	name := base
+	for i := 1; ; i++ {
+		exists, err := p.relationExists(t.Schema, name)
+		if err != nil {
+			return err
+		}
+		if !exists && name != t.Name {
+			break
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	seq.Name, seq.Schema = name, t.Schema
+	seq.OwnedBy = &catalog.SequenceOwner{Table: t.ID, Column: c.ID}
+	// The default names the sequence with its schema unless it is public,
+	// so that it does not depend on the search path.
+	qualified := parser.QuoteIdent(name)
+	if t.Schema != catalog.PublicSchemaID {
+		schema, err := catalog.GetSchemaByID(p.Txn, t.Schema)
+		if err != nil {
+			return err
+		}
+		qualified = parser.QuoteIdent(schema.Name) + "." + qualified
+	}
+	c.Default = fmt.Sprintf("nextval(%s)", parser.QuoteString(qualified))
+	return nil
+}
+
+// addIdentity makes column c of t an identity column, returning its new
+// sequence.
+func (p *Planner) addIdentity(t *catalog.Table, c *catalog.Column, def *parser.IdentityDef) (*catalog.Sequence, error) {
+	if sequenceTypeNames[c.Type] == "" {
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+			"identity column type must be smallint, integer, or bigint")
+	}
+	seq, err := newSequence(&def.Options, c.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ownSequence(t, c, seq); err != nil {
+		return nil, err
+	}
+	c.Identity, c.Nullable = catalog.IdentityByDefault, false
+	if def.Always {
+		c.Identity = catalog.IdentityAlways
+	}
+	return seq, nil
+}
+
+// identityColumnError is the error for giving the GENERATED ALWAYS
+// identity column c a value other than DEFAULT: in an INSERT without
+// OVERRIDING SYSTEM VALUE, or in an UPDATE if update is set.
+func identityColumnError(c *catalog.Column, update bool) error {
+	err := pgerror.Newf(pgerror.CodeGeneratedAlways, "cannot insert a non-DEFAULT value into column %q", c.Name).
+		WithHint("Use OVERRIDING SYSTEM VALUE to override.")
+	if update {
+		err = pgerror.Newf(pgerror.CodeGeneratedAlways, "column %q can only be updated to DEFAULT", c.Name)
+	}
+	return err.WithDetail(fmt.Sprintf("Column %q is an identity column defined as GENERATED ALWAYS.", c.Name))
+}
+
+// sequenceUsers returns the columns of t whose defaults use seq.
+func (p *Planner) sequenceUsers(t *catalog.Table, seq *catalog.Sequence) ([]*catalog.Column, error) {
+	var out []*catalog.Column
+	for _, c := range t.Columns {
+		if c.Default == "" {
+			continue
+		}
+		e, err := parser.ParseExpr(c.Default)
+		if err != nil {
+			return nil, err
+		}
+		uses := false
+		parser.Walk(e, func(e parser.Expr) bool {
+			f, ok := e.(*parser.FuncCall)
+			if !ok || len(f.Args) == 0 || !slices.Contains([]string{"nextval", "currval", "setval"}, f.Name) {
+				return true
+			}
+			if lit, ok := f.Args[0].(*parser.StringLit); ok {
+				uses, err = p.namesSequence(lit.Val, seq)
+			}
+			return !uses && err == nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if uses {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// namesSequence reports whether arg, the text argument of nextval or its
+// kin, names seq.
+func (p *Planner) namesSequence(arg string, seq *catalog.Sequence) (bool, error) {
+	schema, name := eval.SequenceName(arg)
+	if name != seq.Name {
+		return false, nil
+	}
+	if schema != "" {
+		s, err := catalog.LookupSchema(p.Txn, schema)
+		return s != nil && s.ID == seq.Schema, err
+	}
+	found, err := p.findSequence(&parser.TableName{Name: name})
+	return found != nil && found.ID == seq.ID, err
+}
+
+func (p *Planner) planDropSequence(s *parser.DropSequenceStmt) (Node, error) {
+	n := &DropSequence{}
+	for _, name := range s.Names {
+		seq, err := p.findSequence(name)
+		if err != nil {
+			return nil, err
+		}
+		if seq == nil {
+			schema, exists, err := p.schemaOf(name)
+			if err == nil && exists && name.Schema != "" {
+				exists, err = p.relationExists(schema, name.Name)
+			}
+			switch {
+			case err != nil:
+				return nil, err
+			case exists:
+				return nil, pgerror.Newf(pgerror.CodeWrongObjectType, "%q is not a sequence", name.String())
+			case s.IfExists:
+				continue
+			}
+			return nil, pgerror.Newf(pgerror.CodeUndefinedTable, "sequence %q does not exist", name.String())
+		}
+		n.Sequences = append(n.Sequences, seq)
+	}
+	tables, err := catalog.ListTables(p.Txn)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tables {
+		var detached *catalog.Table
+		for _, seq := range n.Sequences {
+			users, err := p.sequenceUsers(t, seq)
+			if err != nil {
+				return nil, err
+			}
+			for _, c := range users {
+				if !s.Cascade {
+					return nil, pgerror.Newf(pgerror.CodeDependentObjectsExist,
+						"cannot drop sequence %s because other objects depend on it", seq.Name).
+						WithDetail(fmt.Sprintf("default value for column %s of table %s depends on sequence %s",
+							c.Name, t.Name, seq.Name)).
+						WithHint("Use DROP ... CASCADE to drop the dependent objects too.")
+				}
+				if detached == nil {
+					detached = t.Clone()
+					n.Detached = append(n.Detached, detached)
+				}
+				detached.Columns[t.ColumnOrdinal(c.ID)].Default = ""
+			}
+		}
+	}
+	return n, nil
+}
+
+// ownedSequences returns the sequences owned by the columns of t for which
+// owned returns true.
+func (p *Planner) ownedSequences(t *catalog.Table, owned func(catalog.ColumnID) bool) ([]*catalog.Sequence, error) {
+	seqs, err := catalog.ListSequences(p.Txn)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(seqs, func(s *catalog.Sequence) bool {
+		return s.OwnedBy == nil || s.OwnedBy.Table != t.ID || !owned(s.OwnedBy.Column)
+	}), nil
+}
@@ -0,0 +1,122 @@
+package planner
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+var setOpTypes = map[parser.SetOp]SetOpType{
+	parser.Union:     UnionOp,
+	parser.Intersect: IntersectOp,
+	parser.Except:    ExceptOp,
+}
+
+func (t SetOpType) String() string {
+	switch t {
+	case IntersectOp:
+		return "Intersect"
+	case ExceptOp:
+		return "Except"
+	}
+	return "Union"
+}
+
+// planSetOp plans s, a set operation, and the ORDER BY and LIMIT applying
+// to its result. As in PostgreSQL, the result columns are named after the
+// left query's and each has the common type of the two queries' columns.
+func (p *Planner) planSetOp(s *parser.SelectStmt) (Node, error) {
+	p.setOperand = true
+	left, err := p.planSelect(s.Left)
+	if err != nil {
+		return nil, err
+	}
+	p.setOperand = true
+	right, err := p.planSelect(s.Right)
+	if err != nil {
+		return nil, err
+	}
+	lcols, rcols := left.Columns(), right.Columns()
+	if len(lcols) != len(rcols) {
+		return nil, pgerror.Newf(pgerror.CodeSyntaxError, "each %s query must have the same number of columns", s.Op)
+	}
+	n := &SetOp{Type: setOpTypes[s.Op], All: s.All, Cols: make([]Column, len(lcols))}
+	for i := range lcols {
+		typ, err := eval.CommonType(s.Op.String(), []*types.T{lcols[i].Type, rcols[i].Type})
+		if err != nil {
+			return nil, err
+		}
+		n.Cols[i] = Column{Name: lcols[i].Name, Type: typ}
+	}
+	if n.Left, err = coerceColumns(left, n.Cols); err != nil {
+		return nil, err
+	}
+	if n.Right, err = coerceColumns(right, n.Cols); err != nil {
+		return nil, err
+	}
+
+	var out Node = n
+	if len(s.OrderBy) > 0 {
+		keys, err := p.planSetOpOrderBy(s, n)
+		if err != nil {
+			return nil, err
+		}
+		out = &Sort{Input: out, Keys: keys}
+	}
+	if s.Limit != nil || s.Offset != nil {
+		if out, err = p.planLimit(out, s.Limit, s.Offset); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// coerceColumns converts the columns of input to the types of cols, if
+// they differ in family.
+func coerceColumns(input Node, cols []Column) (Node, error) {
+	in := input.Columns()
+	proj := &Project{Input: input, Cols: cols}
+	coerced := false
+	for i, c := range in {
+		var e eval.Expr = &eval.ColumnRef{Idx: i, Name: c.Name, Typ: c.Type}
+		if c.Type.Family != cols[i].Type.Family {
+			var err error
+			if e, err = eval.Coerce(e, cols[i].Type); err != nil {
+				return nil, err
+			}
+			coerced = true
+		}
+		proj.Exprs = append(proj.Exprs, e)
+	}
+	if !coerced {
+		return input, nil
+	}
+	return proj, nil
+}
+
+// planSetOpOrderBy returns the sort keys of the ORDER BY of set operation
+// s, planned as n. Its items can only be result column names or
+// positions.
+func (p *Planner) planSetOpOrderBy(s *parser.SelectStmt, n *SetOp) ([]SortKey, error) {
+	for _, item := range s.OrderBy {
+		switch e := item.Expr.(type) {
+		case *parser.NumberLit:
+			continue
+		case *parser.ColumnRef:
+			if e.Table == "" {
+				continue
+			}
+		}
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "invalid UNION/INTERSECT/EXCEPT ORDER BY clause").
+			WithDetail("Only result column names can be used, not expressions or functions.").
+			WithHint("Add the expression/function to every SELECT, or move the UNION into a FROM clause.")
+	}
+	proj := &Project{Input: n, Cols: n.Cols}
+	sc := &scope{}
+	for i, c := range n.Cols {
+		proj.Exprs = append(proj.Exprs, &eval.ColumnRef{Idx: i, Name: c.Name, Typ: c.Type})
+		sc.cols = append(sc.cols, scopeColumn{name: c.Name, typ: c.Type})
+	}
+	return p.planOrderBy(s, proj, sc)
+}
@@ -0,0 +1,662 @@
+package planner
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// maxSpans bounds the number of spans produced by IN lists before the
+// planner falls back to a wider scan.
+const maxSpans = 1000
+
+// colConstraint is what the WHERE clause requires of one column, as far as
+// index selection is concerned.
+type colConstraint struct {
+	// eq lists the values the column may equal. DNull stands for IS NULL.
+	eq []types.Datum
+	// lo and hi bound the column when eq is empty. nil means unbounded.
+	lo, hi       types.Datum
+	loInc, hiInc bool
+	// prefix, when set, requires the column to start with *prefix.
+	prefix *string
+	// rowLo and rowHi bound the column and the ones following it in a
+	// row comparison. lo and hi then include the column's bound.
+	rowLo, rowHi *rowBound
+}
+
+// rowBound is a row comparison's bound on the columns cols, given by
+// table ordinal: the rows after (or, as an upper bound, before) vals, and
+// vals itself if inc.
+type rowBound struct {
+	cols []int
+	vals []types.Datum
+	inc  bool
+}
+
+// narrow tightens the range of c to the values that compare to val by op,
+// one of LT, LE, GT and GE.
+func (c *colConstraint) narrow(op eval.CompareOp, val types.Datum) {
+	switch op {
+	case eval.LT, eval.LE:
+		if c.hi == nil || !isUnbound(val) && !isUnbound(c.hi) &&
+			(types.CompareDatums(val, c.hi) < 0 || (c.hiInc && op == eval.LT && types.CompareDatums(val, c.hi) == 0)) {
+			c.hi, c.hiInc = val, op == eval.LE
+		}
+	case eval.GT, eval.GE:
+		if c.lo == nil || !isUnbound(val) && !isUnbound(c.lo) &&
+			(types.CompareDatums(val, c.lo) > 0 || (c.loInc && op == eval.GT && types.CompareDatums(val, c.lo) == 0)) {
+			c.lo, c.loInc = val, op == eval.GE
+		}
+	}
+}
+
+func (c *colConstraint) hasRange() bool {
+	return c.lo != nil || c.hi != nil || c.prefix != nil
+}
+
+// bound reports whether c constrains the column to known values rather
+// than parameters.
+func (c *colConstraint) bound() bool {
+	return !slices.ContainsFunc(c.eq, isUnbound) && !isUnbound(c.lo) && !isUnbound(c.hi) &&
+		(c.rowLo == nil || !slices.ContainsFunc(c.rowLo.vals, isUnbound)) &&
+		(c.rowHi == nil || !slices.ContainsFunc(c.rowHi.vals, isUnbound))
+}
+
+// unbound stands for the value of a parameter while a statement is
+// prepared. Constraints on it still choose the index to scan, but the
+// spans are computed from the parameter values when the statement is
+// executed. Unbound values are never compared.
+type unbound struct{ idx int }
+
+func (unbound) ResolvedType() *types.T  { return types.Unknown }
+func (unbound) Compare(types.Datum) int { return 0 }
+func (u unbound) String() string        { return fmt.Sprintf("$%d", u.idx+1) }
+
+func isUnbound(d types.Datum) bool {
+	_, ok := d.(unbound)
+	return ok
+}
+
+// hasParams reports whether any of cons constrains a column to a
+// parameter.
+func hasParams(cons map[int]*colConstraint) bool {
+	for _, c := range cons {
+		if !c.bound() {
+			return true
+		}
+	}
+	return false
+}
+
+// constValue returns the value and type of e if it is a constant or a
+// parameter. params are the values of the parameters, or nil while the
+// statement is prepared, when a parameter's value is unbound.
+func constValue(e eval.Expr, params []types.Datum) (types.Datum, *types.T, bool) {
+	switch e := e.(type) {
+	case *eval.Const:
+		return e.Datum, e.Typ, true
+	case *eval.Placeholder:
+		if params == nil {
+			return unbound{e.Idx}, e.ResolvedType(), true
+		}
+		return params[e.Idx], e.ResolvedType(), true
+	}
+	return nil, nil, false
+}
+
+// constraints extracts per-column constraints from the conjuncts of filter,
+// whose column references are table ordinals of t. params are the values
+// of the statement's parameters, as for constValue.
+func constraints(t *catalog.Table, filter eval.Expr, params []types.Datum) map[int]*colConstraint {
+	out := map[int]*colConstraint{}
+	get := func(ord int) *colConstraint {
+		c := out[ord]
+		if c == nil {
+			c = &colConstraint{}
+			out[ord] = c
+		}
+		return c
+	}
+	for _, e := range conjuncts(filter) {
+		switch e := e.(type) {
+		case *eval.ComparisonExpr:
+			col, val, op, ok := columnVsConst(t, e, params)
+			if !ok {
+				continue
+			}
+			c := get(col)
+			if op != eval.EQ {
+				c.narrow(op, val)
+			} else if c.eq == nil {
+				c.eq = []types.Datum{val}
+			}
+		case *eval.RowComparisonExpr:
+			b, op, ok := rowVsConst(t, e, params)
+			if !ok {
+				continue
+			}
+			c := get(b.cols[0])
+			if len(b.cols) == 1 {
+				if !b.inc {
+					op = map[eval.CompareOp]eval.CompareOp{eval.LE: eval.LT, eval.GE: eval.GT}[op]
+				}
+				c.narrow(op, b.vals[0])
+				continue
+			}
+			// The first column is at least (or at most) its value, and
+			// the index spans narrow that by the following columns.
+			c.narrow(op, b.vals[0])
+			switch op {
+			case eval.LE:
+				if c.rowHi == nil {
+					c.rowHi = b
+				}
+			case eval.GE:
+				if c.rowLo == nil {
+					c.rowLo = b
+				}
+			}
+		case *eval.InListExpr:
+			ref, ok := e.Operand.(*eval.ColumnRef)
+			if !ok || e.Not {
+				continue
+			}
+			var vals []types.Datum
+			for _, item := range e.List {
+				d, typ, ok := constValue(item, params)
+				if !ok || typ.Family != ref.Typ.Family {
+					vals = nil
+					break
+				}
+				if d != types.DNull {
+					vals = append(vals, d)
+				}
+			}
+			if vals != nil {
+				if !slices.ContainsFunc(vals, isUnbound) {
+					vals = dedupeDatums(vals)
+				}
+				if c := get(ref.Idx); c.eq == nil {
+					c.eq = vals
+				}
+			}
+		case *eval.IsNullExpr:
+			if ref, ok := e.Operand.(*eval.ColumnRef); ok && !e.Not {
+				if c := get(ref.Idx); c.eq == nil {
+					c.eq = []types.Datum{types.DNull}
+				}
+			}
+		case *eval.LikeExpr:
+			ref, ok := e.Left.(*eval.ColumnRef)
+			if !ok || e.Not || e.Compiled() == nil || ref.Typ.Family != types.StringFamily {
+				continue
+			}
+			prefix, exact := e.Compiled().Prefix()
+			c := get(ref.Idx)
+			switch {
+			case exact:
+				if c.eq == nil {
+					c.eq = []types.Datum{types.DString(prefix)}
+				}
+			case prefix != "" && c.prefix == nil:
+				c.prefix = &prefix
+			}
+		}
+	}
+	return out
+}
+
+func conjuncts(e eval.Expr) []eval.Expr {
+	if e == nil {
+		return nil
+	}
+	if and, ok := e.(*eval.AndExpr); ok {
+		return append(conjuncts(and.Left), conjuncts(and.Right)...)
+	}
+	return []eval.Expr{e}
+}
+
+// columnVsConst matches comparisons between a column and a non-NULL
+// constant or parameter of the column's family, normalizing the column to
+// the left.
+func columnVsConst(t *catalog.Table, e *eval.ComparisonExpr, params []types.Datum) (int, types.Datum, eval.CompareOp, bool) {
+	op := e.Op
+	l, r := e.Left, e.Right
+	if _, _, ok := constValue(l, params); ok {
+		l, r, op = r, l, op.Commute()
+	}
+	ref, ok := l.(*eval.ColumnRef)
+	if !ok {
+		return 0, nil, 0, false
+	}
+	d, typ, ok := constValue(r, params)
+	if !ok || d == types.DNull || typ.Family != t.Columns[ref.Idx].Type.Family {
+		return 0, nil, 0, false
+	}
+	switch op {
+	case eval.EQ:
+		return ref.Idx, d, op, true
+	case eval.LT, eval.LE, eval.GT, eval.GE:
+		// Keys of registered types are not ordered like their values.
+		if t.Columns[ref.Idx].Type.Extension() != nil {
+			break
+		}
+		return ref.Idx, d, op, true
+	}
+	return 0, nil, 0, false
+}
+
+// rowVsConst matches comparisons of a row of columns to a row of constants
+// or parameters of the columns' families, normalizing the columns to the
+// left. The columns are bounded by the values up to the first NULL, past
+// which the comparison is never true. It returns the bound and the
+// comparison, LE or GE, that includes its values.
+func rowVsConst(t *catalog.Table, e *eval.RowComparisonExpr, params []types.Datum) (*rowBound, eval.CompareOp, bool) {
+	op := e.Op
+	l, r := e.Left, e.Right
+	if _, _, ok := constValue(l[0], params); ok {
+		l, r, op = r, l, op.Commute()
+	}
+	b := &rowBound{inc: op == eval.LE || op == eval.GE}
+	for i, x := range l {
+		ref, ok := x.(*eval.ColumnRef)
+		if !ok {
+			return nil, 0, false
+		}
+		d, typ, ok := constValue(r[i], params)
+		// Keys of registered types are not ordered like their values.
+		col := t.Columns[ref.Idx]
+		if !ok || typ.Family != col.Type.Family || col.Type.Extension() != nil {
+			return nil, 0, false
+		}
+		if d == types.DNull {
+			if i == 0 {
+				return nil, 0, false
+			}
+			// The rows equal up to the NULL compare as NULL.
+			b.inc = false
+			break
+		}
+		b.cols, b.vals = append(b.cols, ref.Idx), append(b.vals, d)
+	}
+	switch op {
+	case eval.LT, eval.LE:
+		return b, eval.LE, true
+	case eval.GT, eval.GE:
+		return b, eval.GE, true
+	}
+	return nil, 0, false
+}
+
+func dedupeDatums(ds []types.Datum) []types.Datum {
+	sort.Slice(ds, func(i, j int) bool { return types.CompareDatums(ds[i], ds[j]) < 0 })
+	out := ds[:0]
+	for i, d := range ds {
+		if i == 0 || types.CompareDatums(d, out[len(out)-1]) != 0 {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// indexScore rates how well the constraints narrow a scan of idx: two
+// points per leading equality column and one for a trailing range.
+func indexScore(t *catalog.Table, idx *catalog.Index, cons map[int]*colConstraint) int {
+	if idx.Hash {
+		if hashConstraint(t, idx, cons) != nil {
+			return 2
+		}
+		return 0
+	}
+	score := 0
+	for _, ord := range t.ColumnOrdinals(idx) {
+		c := cons[ord]
+		if c == nil {
+			break
+		}
+		if c.eq == nil {
+			if c.hasRange() {
+				score++
+			}
+			break
+		}
+		score += 2
+	}
+	return score
+}
+
+// selectIndex picks the index and spans for scanning t under filter. With
+// no useful constraint it returns a full scan of the primary index. If t
+// has been analyzed, the index is the one whose scan costs least, which
+// may be none; otherwise it is the one the constraints narrow most. If the
+// filter constrains columns to parameters, the spans are left nil, to be
+// computed by BindSpans.
+func selectIndex(t *catalog.Table, filter eval.Expr) (*catalog.Index, []Span, error) {
+	cons := constraints(t, filter, nil)
+	best, bestScore := t.PrimaryIndex, 0
+	var bestCost float64
+	if t.Stats != nil {
+		bestCost = fullScanCost(t)
+	}
+	for _, idx := range t.AllIndexes() {
+		if !idx.Ordered() && !idx.Hash {
+			continue
+		}
+		s := indexScore(t, idx, cons)
+		switch {
+		case s == 0:
+		case t.Stats != nil:
+			if c := indexCost(t, idx, cons); c < bestCost {
+				best, bestScore, bestCost = idx, s, c
+			}
+		case s > bestScore:
+			best, bestScore = idx, s
+		}
+	}
+	if bestScore > 0 {
+		if hasParams(cons) {
+			return best, nil, nil
+		}
+		spans, err := indexSpans(t, best, cons)
+		if err != nil {
+			return nil, nil, err
+		}
+		if spans != nil {
+			return best, spans, nil
+		}
+	}
+	return t.PrimaryIndex, []Span{fullSpan(t, t.PrimaryIndex)}, nil
+}
+
+// BindSpans returns the spans of scan, whose spans depend on the
+// parameters of its statement, for the parameter values params.
+func BindSpans(scan *Scan, params []types.Datum) ([]Span, error) {
+	spans, err := indexSpans(scan.Table, scan.Index, constraints(scan.Table, scan.Filter, params))
+	if err != nil || spans != nil {
+		return spans, err
+	}
+	return []Span{fullSpan(scan.Table, scan.Index)}, nil
+}
+
+func fullSpan(t *catalog.Table, idx *catalog.Index) Span {
+	prefix := rowcodec.IndexPrefix(t.ID, idx.ID)
+	return Span{Start: prefix, End: rowcodec.PrefixEnd(prefix), Desc: "FULL SCAN"}
+}
+
+// partialKey is a key prefix built from leading equality columns.
+type partialKey struct {
+	key  []byte
+	desc []string
+}
+
+// indexSpans converts the constraints on the leading columns of idx into
+// key spans. It returns nil if an IN list would produce too many spans.
+func indexSpans(t *catalog.Table, idx *catalog.Index, cons map[int]*colConstraint) ([]Span, error) {
+	if idx.Hash {
+		return hashSpans(t, idx, cons)
+	}
+	keys := []partialKey{{key: rowcodec.IndexPrefix(t.ID, idx.ID)}}
+	var rng *colConstraint
+	var rngCols []keyColumn
+	ords := t.ColumnOrdinals(idx)
+	for i, ord := range ords {
+		c := cons[ord]
+		if c == nil {
+			break
+		}
+		typ := t.Columns[ord].Type
+		if c.eq == nil {
+			if c.hasRange() {
+				rng = c
+				for j := i; j < len(ords); j++ {
+					rngCols = append(rngCols, keyColumn{ord: ords[j], typ: t.Columns[ords[j]].Type, desc: idx.Desc(j)})
+				}
+			}
+			break
+		}
+		if len(keys)*len(c.eq) > maxSpans {
+			return nil, nil
+		}
+		next := make([]partialKey, 0, len(keys)*len(c.eq))
+		for _, k := range keys {
+			for _, v := range c.eq {
+				enc, err := rowcodec.EncodeKeyDir(bytes.Clone(k.key), typ, v, idx.Desc(i))
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, partialKey{key: enc, desc: append(k.desc[:len(k.desc):len(k.desc)], formatDatum(v))})
+			}
+		}
+		keys = next
+	}
+	var spans []Span
+	for _, k := range keys {
+		s, err := rangeSpan(k, rng, rngCols)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Compare(s.Start, s.End) < 0 {
+			spans = append(spans, s)
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return bytes.Compare(spans[i].Start, spans[j].Start) < 0 })
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && bytes.Compare(s.Start, merged[n-1].End) < 0 {
+			if bytes.Compare(s.End, merged[n-1].End) > 0 {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	if merged == nil {
+		// The constraints are contradictory; scan nothing.
+		merged = []Span{}
+	}
+	return merged, nil
+}
+
+// keyColumn is a column of an index key.
+type keyColumn struct {
+	ord  int
+	typ  *types.T
+	desc bool
+}
+
+// rangeSpan returns the span of keys starting with k whose next column,
+// the first of cols, satisfies rng. A nil rng covers every key with the
+// prefix. If the column is descending, its larger values come first, so
+// the upper bound starts the span. Row comparisons on the columns cols
+// narrow the span further.
+func rangeSpan(k partialKey, rng *colConstraint, cols []keyColumn) (Span, error) {
+	var typ *types.T
+	var desc bool
+	if len(cols) > 0 {
+		typ, desc = cols[0].typ, cols[0].desc
+	}
+	eqDesc := strings.Join(k.desc, "/")
+	if rng == nil {
+		return Span{Start: k.key, End: rowcodec.PrefixEnd(k.key), Desc: "[" + eqDesc + " - " + eqDesc + "]"}, nil
+	}
+	join := func(v string) string {
+		if eqDesc == "" {
+			return v
+		}
+		return eqDesc + "/" + v
+	}
+	if rng.prefix != nil {
+		start := rowcodec.EncodeStringPrefix(bytes.Clone(k.key), *rng.prefix)
+		if desc {
+			start = rowcodec.Invert(start, len(k.key))
+		}
+		return Span{
+			Start: start,
+			End:   rowcodec.PrefixEnd(start),
+			Desc:  "[" + join(eval.QuoteString(*rng.prefix)) + " - " + join(prefixEndDesc(*rng.prefix)) + ")",
+		}, nil
+	}
+	// first and last are the bounds on the column in key order: the keys
+	// from the first, up to and including the last.
+	var first, last []byte
+	firstInc, lastInc := true, true
+	var loDesc, hiDesc string
+	open, closeBracket := "[", "]"
+	if rng.lo != nil {
+		enc, err := rowcodec.EncodeKeyDir(bytes.Clone(k.key), typ, rng.lo, desc)
+		if err != nil {
+			return Span{}, err
+		}
+		if desc {
+			last, lastInc = enc, rng.loInc
+		} else {
+			first, firstInc = enc, rng.loInc
+		}
+		if !rng.loInc {
+			open = "("
+		}
+		loDesc = join(formatDatum(rng.lo))
+	} else {
+		loDesc = eqDesc
+	}
+	if rng.hi != nil {
+		enc, err := rowcodec.EncodeKeyDir(bytes.Clone(k.key), typ, rng.hi, desc)
+		if err != nil {
+			return Span{}, err
+		}
+		if desc {
+			first, firstInc = enc, rng.hiInc
+		} else {
+			last, lastInc = enc, rng.hiInc
+		}
+		if !rng.hiInc {
+			closeBracket = ")"
+		}
+		hiDesc = join(formatDatum(rng.hi))
+	} else {
+		hiDesc = eqDesc
+	}
+	s := Span{Start: k.key, End: rowcodec.PrefixEnd(k.key)}
+	// Comparisons are never true for NULL, which sorts last, or first in
+	// a descending column.
+	null := rowcodec.EncodeNull(bytes.Clone(k.key))
+	if desc {
+		s.Start = rowcodec.PrefixEnd(rowcodec.Invert(null, len(k.key)))
+	} else {
+		s.End = null
+	}
+	switch {
+	case first == nil:
+	case firstInc:
+		s.Start = first
+	default:
+		s.Start = rowcodec.PrefixEnd(first)
+	}
+	switch {
+	case last == nil:
+	case lastInc:
+		s.End = rowcodec.PrefixEnd(last)
+	default:
+		s.End = last
+	}
+	// A row bound on the columns following in the key is a bound on
+	// the keys, if it is tighter than that of the first column.
+	for _, b := range []struct {
+		row   *rowBound
+		lower bool
+	}{{rng.rowLo, true}, {rng.rowHi, false}} {
+		if b.row == nil {
+			continue
+		}
+		key, n, inc, err := rowKey(k.key, b.row, cols)
+		if err != nil {
+			return Span{}, err
+		}
+		if n < 2 {
+			continue
+		}
+		vals := make([]string, n)
+		for i, v := range b.row.vals[:n] {
+			vals[i] = formatDatum(v)
+		}
+		if b.lower != desc {
+			start := key
+			if !inc {
+				start = rowcodec.PrefixEnd(key)
+			}
+			if bytes.Compare(start, s.Start) <= 0 {
+				continue
+			}
+			s.Start = start
+		} else {
+			end := key
+			if inc {
+				end = rowcodec.PrefixEnd(key)
+			}
+			if bytes.Compare(end, s.End) >= 0 {
+				continue
+			}
+			s.End = end
+		}
+		if b.lower {
+			loDesc, open = join(strings.Join(vals, "/")), "["
+			if !inc {
+				open = "("
+			}
+		} else {
+			hiDesc, closeBracket = join(strings.Join(vals, "/")), "]"
+			if !inc {
+				closeBracket = ")"
+			}
+		}
+	}
+	s.Desc = open + loDesc + " - " + hiDesc + closeBracket
+	return s, nil
+}
+
+// rowKey encodes after prefix the values of the row bound b on the leading
+// columns of cols that it bounds in order, as far as they have the
+// direction of the first, returning the key, the number of columns, and
+// whether the bound includes the key. A bound on fewer columns than b
+// includes the rows whose values equal those in the columns it covers.
+func rowKey(prefix []byte, b *rowBound, cols []keyColumn) ([]byte, int, bool, error) {
+	key := bytes.Clone(prefix)
+	n := 0
+	for n < len(b.cols) && n < len(cols) && b.cols[n] == cols[n].ord && cols[n].desc == cols[0].desc {
+		var err error
+		if key, err = rowcodec.EncodeKeyDir(key, cols[n].typ, b.vals[n], cols[n].desc); err != nil {
+			return nil, 0, false, err
+		}
+		n++
+	}
+	return key, n, b.inc || n < len(b.cols), nil
+}
+
+// prefixEndDesc describes the smallest string greater than every string
+// starting with prefix.
+func prefixEndDesc(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return eval.QuoteString(string(b[:i+1]))
+		}
+	}
+	return ""
+}
+
+func formatDatum(d types.Datum) string {
+	switch d.(type) {
+	case types.DString, types.DBytes, types.DDate, types.DTimestamp, types.DTimestampTZ, types.DInterval:
+		return eval.QuoteString(d.String())
+	}
+	return d.String()
+}
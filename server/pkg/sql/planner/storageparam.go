@@ -0,0 +1,144 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// The storage parameters of tables.
+const (
+	paramCompression     = "compression"
+	paramDictionarySize  = "compression_dictionary_size"
+	paramTTL             = "ttl_expire_after"
+	compressionAlgorithm = `"none", "snappy", and "zstd"`
+)
+
+// setTableParams sets the storage parameters params of t, as CREATE TABLE
+// ... WITH and ALTER TABLE ... SET give them, once t has its columns. It
+// returns the expiration column it adds to t if params give it a TTL.
+func setTableParams(t *catalog.Table, params []parser.StorageParam) (*catalog.Column, error) {
+	var added *catalog.Column
+	compression := false
+	c := catalog.CompressionParams{Algorithm: engine.CompressionNone}
+	if t.Compression != nil {
+		c = *t.Compression
+	}
+	dictSet := false
+	for _, w := range params {
+		switch w.Name {
+		case paramCompression:
+			compression = true
+			switch v := strings.ToLower(w.Value); v {
+			case engine.CompressionNone, engine.CompressionSnappy, engine.CompressionZstd:
+				c.Algorithm = v
+			default:
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for enum option %q: %s", w.Name, w.Value).
+					WithDetail("Valid values are " + compressionAlgorithm + ".")
+			}
+		case paramDictionarySize:
+			v, err := strconv.Atoi(w.Value)
+			if err != nil {
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for integer option %q: %s", w.Name, w.Value)
+			}
+			if v < 0 || v > engine.MaxDictionaryBytes {
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"value %d out of bounds for option %q", v, w.Name).
+					WithDetail(fmt.Sprintf("Valid values are between \"0\" and \"%d\".", engine.MaxDictionaryBytes))
+			}
+			c.DictionarySize, dictSet, compression = v, true, true
+		case paramTTL:
+			iv, err := types.ParseDInterval(w.Value)
+			if err != nil {
+				return nil, err
+			}
+			if iv.Months < 0 || iv.Days < 0 || iv.Micros < 0 || iv == (types.DInterval{}) {
+				return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"value %s for option %q must be a positive interval", w.Value, w.Name)
+			}
+			ttl := catalog.TTLParams{ExpireAfter: iv.String()}
+			if t.TTL == nil {
+				added = t.AddExpirationColumn(ttl.ExpireAfter)
+				ttl.ColumnID = added.ID
+			} else {
+				ttl.ColumnID = t.TTL.ColumnID
+				t.Columns[t.ColumnOrdinal(ttl.ColumnID)].Default = catalog.ExpirationDefault(ttl.ExpireAfter)
+			}
+			t.TTL = &ttl
+		default:
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", w.Name)
+		}
+	}
+	if !compression {
+		return added, nil
+	}
+	// Changing from zstd drops its dictionary, unless one is given too.
+	if !dictSet && c.Algorithm != engine.CompressionZstd {
+		c.DictionarySize = 0
+	}
+	if c.DictionarySize > 0 && c.Algorithm != engine.CompressionZstd {
+		return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+			"%s requires %s = zstd", paramDictionarySize, paramCompression)
+	}
+	t.Compression = &c
+	return added, nil
+}
+
+// resetTableParams returns the storage parameters names of t to their
+// defaults, as ALTER TABLE ... RESET does. Resetting the TTL drops the
+// expiration column, with the indexes and constraints on it.
+func resetTableParams(t *catalog.Table, names []string) error {
+	for _, name := range names {
+		switch name {
+		case paramTTL:
+			if t.TTL == nil {
+				continue
+			}
+			id := t.TTL.ColumnID
+			t.Columns = slices.DeleteFunc(t.Columns, func(c *catalog.Column) bool { return c.ID == id })
+			t.Indexes = slices.DeleteFunc(t.Indexes, func(idx *catalog.Index) bool {
+				return slices.Contains(idx.ColumnIDs, id)
+			})
+			t.Checks = slices.DeleteFunc(t.Checks, func(ck *catalog.Check) bool {
+				return slices.Contains(ck.ColumnIDs, id)
+			})
+			t.TTL = nil
+		case paramCompression:
+			t.Compression = nil
+		case paramDictionarySize:
+			if t.Compression != nil {
+				c := *t.Compression
+				c.DictionarySize = 0
+				t.Compression = &c
+			}
+		default:
+			return pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", name)
+		}
+	}
+	return nil
+}
+
+// tableParams returns the storage parameters of t, as WITH (...) in the
+// CREATE TABLE of TableDef lists them, or nil if it has none.
+func tableParams(t *catalog.Table) []string {
+	var params []string
+	if t.Compression != nil {
+		params = append(params, fmt.Sprintf("%s='%s'", paramCompression, t.Compression.Algorithm))
+		if t.Compression.DictionarySize > 0 {
+			params = append(params, fmt.Sprintf("%s='%d'", paramDictionarySize, t.Compression.DictionarySize))
+		}
+	}
+	if t.TTL != nil {
+		params = append(params, fmt.Sprintf("%s='%s'", paramTTL, t.TTL.ExpireAfter))
+	}
+	return params
+}
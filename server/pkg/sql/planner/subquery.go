@@ -0,0 +1,357 @@
+package planner
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// outerScope is the scope of an expression containing a subquery, while
+// the subquery is planned. Column references the subquery cannot resolve
+// itself are resolved against the outer scopes, innermost first.
+type outerScope struct {
+	scope *scope
+	// correlation becomes the Correlation of the subquery.
+	correlation int
+}
+
+// typeCheckSubquery plans a subquery appearing in an expression over s.
+// left is the operand of IN.
+func (p *Planner) typeCheckSubquery(kind eval.SubqueryKind, sub *parser.Subquery, left eval.Expr, s *scope) (eval.Expr, error) {
+	p.numSubqueries++
+	e := &eval.SubqueryExpr{Kind: kind, ID: p.numSubqueries, Left: left, Typ: types.Bool}
+	p.outer = append(p.outer, &outerScope{scope: s})
+	plan, err := p.planSelect(sub.Select)
+	e.Correlation = p.outer[len(p.outer)-1].correlation
+	p.outer = p.outer[:len(p.outer)-1]
+	if err != nil {
+		return nil, err
+	}
+	e.Plan = plan
+	cols := plan.Columns()
+	switch kind {
+	case eval.ScalarSubquery:
+		if len(cols) != 1 {
+			return nil, pgerror.New(pgerror.CodeSyntaxError, "subquery must return only one column")
+		}
+		e.Typ = cols[0].Type
+	case eval.InSubquery:
+		if len(cols) != 1 {
+			return nil, pgerror.New(pgerror.CodeSyntaxError, "subquery has too many columns")
+		}
+		// Compare the operand and the subquery's values as the equality
+		// of an IN list would, converting either to the other's type.
+		ref := &eval.ColumnRef{Name: cols[0].Name, Typ: cols[0].Type}
+		cmp, err := eval.NewComparisonExpr(eval.EQ, left, ref)
+		if err != nil {
+			return nil, err
+		}
+		c := cmp.(*eval.ComparisonExpr)
+		e.Left = c.Left
+		if c.Right != eval.Expr(ref) {
+			e.Plan = &Project{
+				Input: plan,
+				Exprs: []eval.Expr{c.Right},
+				Cols:  []Column{{Name: cols[0].Name, Type: c.Right.ResolvedType()}},
+			}
+		}
+	}
+	return e, nil
+}
+
+// resolveOuter resolves a column reference that is not a column of the
+// query being planned against the enclosing queries. It returns nil if
+// none of them has the column.
+func (p *Planner) resolveOuter(table, name string) (eval.Expr, error) {
+	for d := 1; d <= len(p.outer); d++ {
+		ref, err := outerColumn(p.outer[len(p.outer)-d].scope, table, name)
+		if code := pgerror.GetCode(err); code == pgerror.CodeUndefinedColumn || code == pgerror.CodeUndefinedTable {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Every subquery between the reference and the query the column
+		// belongs to is correlated.
+		for i := range d {
+			o := p.outer[len(p.outer)-1-i]
+			o.correlation = max(o.correlation, d-i)
+		}
+		return &eval.OuterRef{Depth: d, Idx: ref.Idx, Name: ref.Name, Typ: ref.Typ}, nil
+	}
+	return nil, nil
+}
+
+// outerColumn resolves a column of an enclosing query. Where that query is
+// grouped, the column must be a grouping column, read from the Aggregate's
+// output.
+func outerColumn(s *scope, table, name string) (*eval.ColumnRef, error) {
+	if a := s.agg; a != nil {
+		ref, err := outerColumn(a.input, table, name)
+		if err != nil {
+			return nil, err
+		}
+		g := a.groupRef(ref)
+		if g == nil {
+			return nil, pgerror.Newf(pgerror.CodeGroupingError,
+				"subquery uses ungrouped column %q from outer query", ref.Name)
+		}
+		return g.(*eval.ColumnRef), nil
+	}
+	idx, col, err := s.resolve(table, name)
+	if err != nil {
+		return nil, err
+	}
+	// Outer columns are always named with their table, to tell them from
+	// the subquery's own.
+	qualified := *s
+	qualified.qualified = true
+	return &eval.ColumnRef{Idx: idx, Name: qualified.columnName(col), Typ: col.typ}, nil
+}
+
+// hasSubquery reports whether e contains a subquery.
+func hasSubquery(e parser.Expr) bool {
+	found := false
+	parser.Walk(e, func(e parser.Expr) bool {
+		switch e := e.(type) {
+		case *parser.Subquery, *parser.ExistsExpr:
+			found = true
+		case *parser.InExpr:
+			found = e.Subquery != nil
+		}
+		return !found
+	})
+	return found
+}
+
+// isCorrelated reports whether e contains a subquery that refers to the
+// rows e is evaluated over. Such expressions must be evaluated over rows
+// laid out as when they were type checked, so they are not pushed down.
+func isCorrelated(e eval.Expr) bool {
+	found := false
+	eval.Walk(e, func(e eval.Expr) bool {
+		if sq, ok := e.(*eval.SubqueryExpr); ok && sq.Correlation > 0 {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// decorrelate returns n filtered by pred, an EXISTS, NOT EXISTS or IN
+// subquery referring to the columns of n, as a semi join or anti join of n
+// with the subquery's input. This is possible when the subquery only
+// refers to n in conditions of its WHERE clause or join conditions, and in
+// the compared column of IN; the conditions become join conditions. It
+// returns nil when pred cannot be decorrelated.
+//
+// NOT IN is not rewritten: it is false when the subquery returns a NULL,
+// which an anti join would not account for.
+func decorrelate(n Node, pred eval.Expr) (Node, error) {
+	anti := false
+	if not, ok := pred.(*eval.NotExpr); ok {
+		pred, anti = not.Operand, true
+	}
+	sq, ok := pred.(*eval.SubqueryExpr)
+	if !ok || sq.Correlation != 1 || sq.Kind == eval.ScalarSubquery || (anti && sq.Kind == eval.InSubquery) ||
+		(sq.Left != nil && isCorrelated(sq.Left)) {
+		return nil, nil
+	}
+	proj, ok := sq.Plan.(*Project)
+	if !ok {
+		return nil, nil
+	}
+	// Find the input of the select list, and the compared column in terms
+	// of it.
+	var out eval.Expr
+	if len(proj.Exprs) > 0 {
+		out = proj.Exprs[0]
+	}
+	input := proj.Input
+	for {
+		inner, ok := input.(*Project)
+		if !ok {
+			break
+		}
+		if out != nil {
+			out = mapColumns(out, func(ref *eval.ColumnRef) eval.Expr { return inner.Exprs[ref.Idx] })
+		}
+		input = inner.Input
+	}
+	right, conds, ok := pullCorrelated(input)
+	if !ok {
+		return nil, nil
+	}
+	nl := len(n.Columns())
+	for i, c := range conds {
+		conds[i] = decorrelated(c, nl)
+	}
+	if sq.Kind == eval.InSubquery {
+		if _, _, ok := splitCorrelated(out); !ok {
+			return nil, nil
+		}
+		conds = append(conds, &eval.ComparisonExpr{Op: eval.EQ, Left: sq.Left, Right: decorrelated(out, nl)})
+	}
+	j := &Join{Type: SemiJoin, Left: n, Right: right}
+	if anti {
+		j.Type = AntiJoin
+	}
+	return pushIntoJoin(j, conds, false)
+}
+
+// pullCorrelated returns n without the conditions that refer to the
+// enclosing query, and those conditions over the rows of n. ok is false if
+// n refers to the enclosing query other than in conditions it can pull
+// out.
+func pullCorrelated(n Node) (out Node, conds []eval.Expr, ok bool) {
+	switch n := n.(type) {
+	case *Scan:
+		return pullCorrelatedScan(n.Table, n.Filter)
+	case *InvertedScan:
+		return pullCorrelatedScan(n.Table, n.Filter)
+	case *BRINScan:
+		return pullCorrelatedScan(n.Table, n.Filter)
+	case *VirtualScan:
+		local, pulled, ok := splitCorrelated(n.Filter)
+		if !ok {
+			return nil, nil, false
+		}
+		c := *n
+		c.Filter = andAll(local)
+		return &c, pulled, true
+	case *Values:
+		for _, row := range n.Rows {
+			for _, e := range row {
+				if _, pulled, ok := splitCorrelated(e); !ok || len(pulled) > 0 {
+					return nil, nil, false
+				}
+			}
+		}
+		return n, nil, true
+	case *Filter:
+		in, pulled, ok := pullCorrelated(n.Input)
+		if !ok {
+			return nil, nil, false
+		}
+		local, more, ok := splitCorrelated(n.Pred)
+		if !ok {
+			return nil, nil, false
+		}
+		return filtered(in, local), append(pulled, more...), true
+	case *Join:
+		if n.Type != InnerJoin || refersOuter(n.LeftKeys) || refersOuter(n.RightKeys) {
+			return nil, nil, false
+		}
+		left, lconds, ok := pullCorrelated(n.Left)
+		if !ok {
+			return nil, nil, false
+		}
+		right, rconds, ok := pullCorrelated(n.Right)
+		if !ok {
+			return nil, nil, false
+		}
+		local, pulled, ok := splitCorrelated(n.On)
+		if !ok {
+			return nil, nil, false
+		}
+		c := *n
+		c.Left, c.Right, c.On = left, right, andAll(local)
+		nl := len(left.Columns())
+		for _, e := range rconds {
+			lconds = append(lconds, shiftColumns(e, nl))
+		}
+		return &c, append(lconds, pulled...), true
+	case *LookupJoin:
+		if n.Type != InnerJoin || refersOuter(n.Keys) {
+			return nil, nil, false
+		}
+		in, inConds, ok := pullCorrelated(n.Input)
+		if !ok {
+			return nil, nil, false
+		}
+		local, pulled, ok := splitCorrelated(n.On)
+		if !ok {
+			return nil, nil, false
+		}
+		c := *n
+		c.Input, c.On = in, andAll(local)
+		return &c, append(inConds, pulled...), true
+	}
+	return nil, nil, false
+}
+
+// splitCorrelated splits the conjuncts of e into those that do not refer
+// to the enclosing query and those that only refer to it through column
+// references. ok is false if a conjunct refers to it in a nested
+// subquery.
+func splitCorrelated(e eval.Expr) (local, pulled []eval.Expr, ok bool) {
+	for _, c := range conjuncts(e) {
+		direct, nested := false, false
+		eval.Walk(c, func(e eval.Expr) bool {
+			switch e := e.(type) {
+			case *eval.OuterRef:
+				direct = true
+				nested = nested || e.Depth > 1
+			case *eval.SubqueryExpr:
+				nested = nested || e.Correlation > 1
+			}
+			return true
+		})
+		switch {
+		case nested:
+			return nil, nil, false
+		case direct:
+			pulled = append(pulled, c)
+		default:
+			local = append(local, c)
+		}
+	}
+	return local, pulled, true
+}
+
+// pullCorrelatedScan is pullCorrelated for a scan of t under filter.
+func pullCorrelatedScan(t *catalog.Table, filter eval.Expr) (Node, []eval.Expr, bool) {
+	local, pulled, ok := splitCorrelated(filter)
+	if !ok {
+		return nil, nil, false
+	}
+	n, err := scanTable(t, andAll(local))
+	if err != nil {
+		return nil, nil, false
+	}
+	return n, pulled, true
+}
+
+func refersOuter(exprs []eval.Expr) bool {
+	for _, e := range exprs {
+		if _, pulled, ok := splitCorrelated(e); !ok || len(pulled) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// decorrelated converts e, a condition of a subquery over its input rows,
+// into a condition over the rows of a join of the enclosing query's rows,
+// which have nl columns, with the subquery's input rows.
+func decorrelated(e eval.Expr, nl int) eval.Expr {
+	switch t := e.(type) {
+	case *eval.ColumnRef:
+		c := *t
+		c.Idx += nl
+		return &c
+	case *eval.OuterRef:
+		return &eval.ColumnRef{Idx: t.Idx, Name: t.Name, Typ: t.Typ}
+	}
+	children := eval.Children(e)
+	if len(children) == 0 {
+		return e
+	}
+	mapped := make([]eval.Expr, len(children))
+	for i, c := range children {
+		mapped[i] = decorrelated(c, nl)
+	}
+	return eval.WithChildren(e, mapped)
+}
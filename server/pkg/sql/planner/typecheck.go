@@ -0,0 +1,578 @@
+package planner
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// scope lists the columns visible to expressions, in input row order.
+type scope struct {
+	cols []scopeColumn
+	// agg is set for expressions evaluated after aggregation, in which
+	// case cols is empty and agg.input describes the aggregated rows.
+	agg *aggScope
+	// noAggs, if set, is the error message for an aggregate call where
+	// aggregates are not allowed.
+	noAggs string
+	// window, if set, collects the window function calls of expressions
+	// evaluated over the rows of a Window node. Where it is not set,
+	// noWindows is the error message for a window function call.
+	window    *windowScope
+	noWindows string
+	// noColumns, if set, is the error message for a column reference in
+	// an expression that cannot refer to columns.
+	noColumns string
+	// qualified is set when the columns come from more than one table, in
+	// which case column references are named table.column.
+	qualified bool
+}
+
+type scopeColumn struct {
+	table string
+	name  string
+	typ   *types.T
+	// hidden columns are the inputs of a JOIN USING column. They can only
+	// be referred to qualified and are not expanded by an unqualified *.
+	hidden bool
+	// implicit columns, such as the rowid of a table without a primary
+	// key, can be referred to by name but are not expanded by *.
+	implicit bool
+}
+
+// resolve returns the input ordinal of the named column.
+func (s *scope) resolve(table, name string) (int, *scopeColumn, error) {
+	found := -1
+	for i := range s.cols {
+		c := &s.cols[i]
+		if c.name != name || (table != "" && c.table != table) || (table == "" && c.hidden) {
+			continue
+		}
+		if found >= 0 {
+			return 0, nil, pgerror.Newf(pgerror.CodeAmbiguousColumn, "column reference %q is ambiguous", name)
+		}
+		found = i
+	}
+	if found < 0 {
+		if s.noColumns != "" {
+			return 0, nil, pgerror.New(pgerror.CodeFeatureNotSupported, s.noColumns)
+		}
+		if table != "" {
+			if !s.hasTable(table) {
+				return 0, nil, pgerror.Newf(pgerror.CodeUndefinedTable,
+					"missing FROM-clause entry for table %q", table)
+			}
+			return 0, nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %s.%s does not exist", table, name)
+		}
+		return 0, nil, pgerror.Newf(pgerror.CodeUndefinedColumn, "column %q does not exist", name)
+	}
+	return found, &s.cols[found], nil
+}
+
+// withoutAggregates returns a copy of s in which aggregate calls are
+// rejected with msg.
+func (s *scope) withoutAggregates(msg string) *scope {
+	c := *s
+	c.noAggs = msg
+	return &c
+}
+
+// columnName is the name of c in expressions.
+func (s *scope) columnName(c *scopeColumn) string {
+	if s.qualified && c.table != "" {
+		return c.table + "." + c.name
+	}
+	return c.name
+}
+
+func (s *scope) hasTable(table string) bool {
+	for _, c := range s.cols {
+		if c.table == table {
+			return true
+		}
+	}
+	return false
+}
+
+// typeCheck converts a parsed expression into a typed eval.Expr whose
+// column references index into rows described by s.
+func (p *Planner) typeCheck(e parser.Expr, s *scope) (eval.Expr, error) {
+	out, err := p.typeCheckNode(e, s)
+	if err != nil {
+		return nil, err
+	}
+	return foldConstants(out)
+}
+
+func (p *Planner) typeCheckNode(e parser.Expr, s *scope) (eval.Expr, error) {
+	if s.agg != nil {
+		if out, ok, err := p.typeCheckGrouped(e, s); ok {
+			return out, err
+		}
+	}
+	switch e := e.(type) {
+	case *parser.NumberLit:
+		return numberConst(e.Text)
+	case *parser.StringLit:
+		return &eval.Const{Datum: types.DString(e.Val), Typ: types.Unknown}, nil
+	case *parser.BoolLit:
+		return eval.NewConst(types.MakeDBool(e.Val)), nil
+	case *parser.NullLit:
+		return &eval.Const{Datum: types.DNull, Typ: types.Unknown}, nil
+	case *parser.Param:
+		if p.placeholders == nil {
+			return nil, pgerror.Newf(pgerror.CodeUndefinedParameter, "there is no parameter $%d", e.Index)
+		}
+		for len(*p.placeholders) < e.Index {
+			*p.placeholders = append(*p.placeholders, nil)
+		}
+		return &eval.Placeholder{Idx: e.Index - 1, Types: p.placeholders}, nil
+	case *parser.ColumnRef:
+		idx, col, err := s.resolve(e.Table, e.Column)
+		if code := pgerror.GetCode(err); len(p.outer) > 0 && (code == pgerror.CodeUndefinedColumn || code == pgerror.CodeUndefinedTable) {
+			if ref, oerr := p.resolveOuter(e.Table, e.Column); oerr != nil || ref != nil {
+				return ref, oerr
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &eval.ColumnRef{Idx: idx, Name: s.columnName(col), Typ: col.typ}, nil
+	case *parser.Star:
+		return nil, pgerror.New(pgerror.CodeSyntaxError, "\"*\" is not allowed in this context")
+	case *parser.UnaryExpr:
+		x, err := p.typeCheck(e.X, s)
+		if err != nil {
+			return nil, err
+		}
+		return p.Registry.NewUnaryExpr(e.Op, x)
+	case *parser.BinaryExpr:
+		return p.typeCheckBinary(e, s)
+	case *parser.AndExpr:
+		l, r, err := p.typeCheckPair(e.L, e.R, s)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewAndExpr(l, r)
+	case *parser.OrExpr:
+		l, r, err := p.typeCheckPair(e.L, e.R, s)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewOrExpr(l, r)
+	case *parser.NotExpr:
+		x, err := p.typeCheck(e.X, s)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewNotExpr(x)
+	case *parser.IsExpr:
+		x, err := p.typeCheck(e.X, s)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Kind {
+		case parser.IsTrue, parser.IsFalse:
+			return eval.NewIsBoolExpr(x, e.Kind == parser.IsTrue, e.Not)
+		case parser.IsUnknown:
+			if x, err = eval.Coerce(x, types.Bool); err != nil {
+				return nil, err
+			}
+		}
+		return &eval.IsNullExpr{Operand: x, Not: e.Not}, nil
+	case *parser.BetweenExpr:
+		return p.typeCheckBetween(e, s)
+	case *parser.InExpr:
+		x, err := p.typeCheck(e.X, s)
+		if err != nil {
+			return nil, err
+		}
+		if e.Subquery != nil {
+			in, err := p.typeCheckSubquery(eval.InSubquery, e.Subquery, x, s)
+			if err != nil || !e.Not {
+				return in, err
+			}
+			return eval.NewNotExpr(in)
+		}
+		list, err := p.typeCheckList(e.List, s)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewInListExpr(x, list, e.Not)
+	case *parser.LikeExpr:
+		x, pat, err := p.typeCheckPair(e.X, e.Pattern, s)
+		if err != nil {
+			return nil, err
+		}
+		var esc eval.Expr
+		if e.Escape != nil {
+			if esc, err = p.typeCheck(e.Escape, s); err != nil {
+				return nil, err
+			}
+		}
+		return eval.NewLikeExpr(x, pat, esc, e.Not, e.CaseInsensitive)
+	case *parser.CaseExpr:
+		return p.typeCheckCase(e, s)
+	case *parser.FuncCall:
+		return p.typeCheckFunc(e, s)
+	case *parser.Subquery:
+		return p.typeCheckSubquery(eval.ScalarSubquery, e, nil, s)
+	case *parser.ExistsExpr:
+		return p.typeCheckSubquery(eval.ExistsSubquery, e.Subquery, nil, s)
+	case *parser.CastExpr:
+		x, err := p.typeCheck(e.X, s)
+		if err != nil {
+			return nil, err
+		}
+		typ, err := resolveType(e.Type)
+		if err != nil {
+			return nil, err
+		}
+		return eval.NewCastExpr(x, typ)
+	}
+	return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "unsupported expression %s", e)
+}
+
+func (p *Planner) typeCheckPair(l, r parser.Expr, s *scope) (eval.Expr, eval.Expr, error) {
+	le, err := p.typeCheck(l, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	re, err := p.typeCheck(r, s)
+	if err != nil {
+		return nil, nil, err
+	}
+	return le, re, nil
+}
+
+func (p *Planner) typeCheckList(exprs []parser.Expr, s *scope) ([]eval.Expr, error) {
+	out := make([]eval.Expr, len(exprs))
+	for i, e := range exprs {
+		var err error
+		if out[i], err = p.typeCheck(e, s); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+var compareOps = map[string]eval.CompareOp{
+	"=":                    eval.EQ,
+	"<>":                   eval.NE,
+	"<":                    eval.LT,
+	"<=":                   eval.LE,
+	">":                    eval.GT,
+	">=":                   eval.GE,
+	"IS DISTINCT FROM":     eval.IsDistinctFrom,
+	"IS NOT DISTINCT FROM": eval.IsNotDistinctFrom,
+}
+
+func (p *Planner) typeCheckBinary(e *parser.BinaryExpr, s *scope) (eval.Expr, error) {
+	if lrow, ok := e.L.(*parser.RowExpr); ok {
+		if rrow, ok := e.R.(*parser.RowExpr); ok {
+			if op, ok := compareOps[e.Op]; ok {
+				return p.typeCheckRowComparison(op, lrow, rrow, s)
+			}
+		}
+	}
+	l, r, err := p.typeCheckPair(e.L, e.R, s)
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := compareOps[e.Op]; ok {
+		return eval.NewComparisonExpr(op, l, r)
+	}
+	switch e.Op {
+	case "~~", "!~~", "~~*", "!~~*":
+		return eval.NewLikeExpr(l, r, nil, e.Op[0] == '!', strings.HasSuffix(e.Op, "*"))
+	}
+	return p.Registry.NewBinaryExpr(e.Op, l, r)
+}
+
+// typeCheckRowComparison checks a comparison of two row constructors. A
+// row with one value, as ROW(x) gives, compares as that value.
+func (p *Planner) typeCheckRowComparison(op eval.CompareOp, l, r *parser.RowExpr, s *scope) (eval.Expr, error) {
+	left, err := p.typeCheckList(l.Exprs, s)
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.typeCheckList(r.Exprs, s)
+	if err != nil {
+		return nil, err
+	}
+	if len(left) == 1 && len(right) == 1 {
+		return eval.NewComparisonExpr(op, left[0], right[0])
+	}
+	if op != eval.EQ || len(left) != len(right) {
+		return eval.NewRowComparisonExpr(op, left, right)
+	}
+	// Rows are equal when all their values are, and as conjuncts the
+	// equalities constrain index scans.
+	var out eval.Expr
+	for i := range left {
+		eq, err := eval.NewComparisonExpr(eval.EQ, left[i], right[i])
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			out = eq
+		} else if out, err = eval.NewAndExpr(out, eq); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// typeCheckBetween rewrites x BETWEEN lo AND hi as x >= lo AND x <= hi.
+func (p *Planner) typeCheckBetween(e *parser.BetweenExpr, s *scope) (eval.Expr, error) {
+	x, err := p.typeCheck(e.X, s)
+	if err != nil {
+		return nil, err
+	}
+	lo, hi, err := p.typeCheckPair(e.Lo, e.Hi, s)
+	if err != nil {
+		return nil, err
+	}
+	ge, err := eval.NewComparisonExpr(eval.GE, x, lo)
+	if err != nil {
+		return nil, err
+	}
+	le, err := eval.NewComparisonExpr(eval.LE, x, hi)
+	if err != nil {
+		return nil, err
+	}
+	and, err := eval.NewAndExpr(ge, le)
+	if err != nil || !e.Not {
+		return and, err
+	}
+	return eval.NewNotExpr(and)
+}
+
+func (p *Planner) typeCheckCase(e *parser.CaseExpr, s *scope) (eval.Expr, error) {
+	var operand, elseExpr eval.Expr
+	var err error
+	if e.Operand != nil {
+		if operand, err = p.typeCheck(e.Operand, s); err != nil {
+			return nil, err
+		}
+	}
+	whens := make([]eval.CaseWhen, len(e.Whens))
+	for i, w := range e.Whens {
+		if whens[i].Cond, whens[i].Val, err = p.typeCheckPair(w.Cond, w.Val, s); err != nil {
+			return nil, err
+		}
+	}
+	if e.Else != nil {
+		if elseExpr, err = p.typeCheck(e.Else, s); err != nil {
+			return nil, err
+		}
+	}
+	return eval.NewCaseExpr(operand, whens, elseExpr)
+}
+
+func (p *Planner) typeCheckFunc(e *parser.FuncCall, s *scope) (eval.Expr, error) {
+	if e.Over != nil {
+		return p.typeCheckWindowCall(e, s)
+	}
+	if p.Registry.IsWindowFunc(e.Name) {
+		return nil, pgerror.Newf(pgerror.CodeWrongObjectType, "window function %s requires an OVER clause", e.Name)
+	}
+	if p.Registry.IsAggregate(e.Name) {
+		msg := s.noAggs
+		if msg == "" {
+			msg = "aggregate functions are not allowed in this context"
+		}
+		return nil, pgerror.New(pgerror.CodeGroupingError, msg)
+	}
+	if e.Star || e.Distinct {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedFunction, "%s is not an aggregate function", e.Name)
+	}
+	args, err := p.typeCheckList(e.Args, s)
+	if err != nil {
+		return nil, err
+	}
+	switch e.Name {
+	case "coalesce":
+		return eval.NewCoalesceExpr(args)
+	case "nullif":
+		if len(args) != 2 {
+			return nil, pgerror.New(pgerror.CodeSyntaxError, "NULLIF requires exactly two arguments")
+		}
+		return eval.NewNullIfExpr(args[0], args[1])
+	}
+	return p.Registry.NewFuncExpr(e.Name, args)
+}
+
+// numberConst types a numeric literal the way PostgreSQL does: int4 if it
+// fits, then int8, otherwise numeric.
+func numberConst(text string) (eval.Expr, error) {
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		typ := types.Int8
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			typ = types.Int4
+		}
+		return &eval.Const{Datum: types.DInt(i), Typ: typ}, nil
+	}
+	dec, err := types.ParseDec(text)
+	if err != nil {
+		return nil, pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid numeric literal %q", text)
+	}
+	return eval.NewConst(types.NewDDecimal(dec)), nil
+}
+
+// ResolveTypes converts parsed type names, such as the parameter types of
+// PREPARE, to types.
+func ResolveTypes(tns []*parser.TypeName) ([]*types.T, error) {
+	out := make([]*types.T, len(tns))
+	for i, tn := range tns {
+		var err error
+		if out[i], err = resolveType(tn); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// resolveType converts a parsed type name to a type, applying modifiers.
+func resolveType(tn *parser.TypeName) (*types.T, error) {
+	t := types.LookupType(tn.Name)
+	if t == nil {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedObject, "type %q does not exist", tn.Name)
+	}
+	if len(tn.Mods) == 0 {
+		return t, nil
+	}
+	badMods := func() error {
+		return pgerror.Newf(pgerror.CodeSyntaxError, "invalid type modifier for type %s", tn.Name)
+	}
+	switch {
+	case t.Oid == types.OidVarChar || t.Oid == types.OidBPChar:
+		if len(tn.Mods) != 1 || tn.Mods[0] < 1 {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "length for type %s must be at least 1", t.Name)
+		}
+		if t.Oid == types.OidVarChar {
+			return types.MakeVarChar(tn.Mods[0]), nil
+		}
+		return types.MakeChar(tn.Mods[0]), nil
+	case t.Family == types.BitFamily:
+		if len(tn.Mods) != 1 || tn.Mods[0] < 1 {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "length for type %s must be at least 1", t.Name)
+		}
+		if t.Oid == types.OidVarBit {
+			return types.MakeVarBit(tn.Mods[0]), nil
+		}
+		return types.MakeBit(tn.Mods[0]), nil
+	case t.Family == types.DecimalFamily:
+		if len(tn.Mods) > 2 {
+			return nil, badMods()
+		}
+		prec, scale := tn.Mods[0], int32(0)
+		if len(tn.Mods) == 2 {
+			scale = tn.Mods[1]
+		}
+		if prec < 1 || prec > 1000 {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"NUMERIC precision %d must be between 1 and 1000", prec)
+		}
+		if scale < 0 || scale > prec {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"NUMERIC scale %d must be between 0 and precision %d", scale, prec)
+		}
+		return types.MakeDecimal(prec, scale), nil
+	case t.Family == types.TimestampFamily || t.Family == types.TimestampTZFamily || t.Family == types.IntervalFamily:
+		// Fractional second precision is accepted and ignored; values are
+		// always kept to the microsecond.
+		if len(tn.Mods) != 1 {
+			return nil, badMods()
+		}
+		return t, nil
+	case t.Family == types.VectorFamily:
+		if len(tn.Mods) != 1 {
+			return nil, badMods()
+		}
+		switch dims := tn.Mods[0]; {
+		case dims < 1:
+			return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "dimensions for type vector must be at least 1")
+		case dims > types.MaxVectorDims:
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"dimensions for type vector cannot exceed %d", types.MaxVectorDims)
+		}
+		return types.MakeVector(tn.Mods[0]), nil
+	}
+	return nil, badMods()
+}
+
+// foldConstants evaluates e at plan time when it is immutable and all its
+// operands are constants.
+func foldConstants(e eval.Expr) (eval.Expr, error) {
+	switch t := e.(type) {
+	case *eval.Const, *eval.ColumnRef, *eval.OuterRef, *eval.SubqueryExpr, *eval.Placeholder:
+		return e, nil
+	case *eval.FuncExpr:
+		if t.Overload.Volatility != eval.Immutable || len(t.Args) == 0 {
+			return e, nil
+		}
+	case *eval.CastExpr:
+		if t.Volatility() != eval.Immutable {
+			return e, nil
+		}
+	}
+	for _, c := range eval.Children(e) {
+		if _, ok := c.(*eval.Const); !ok {
+			return e, nil
+		}
+	}
+	d, err := e.Eval(eval.NewContext())
+	if err != nil {
+		return nil, err
+	}
+	return &eval.Const{Datum: d, Typ: e.ResolvedType()}, nil
+}
+
+// assignable reports whether a value of type from may be stored in a
+// column of type to. Like PostgreSQL's assignment casts, this allows
+// conversions between numeric types and between string types even when
+// they would lose information; eval.AssignCast checks the value itself.
+func assignable(from, to *types.T) bool {
+	if from.Family == types.UnknownFamily || from.Family == to.Family {
+		return true
+	}
+	numeric := func(f types.Family) bool {
+		return f == types.IntFamily || f == types.FloatFamily || f == types.DecimalFamily
+	}
+	if numeric(from.Family) && numeric(to.Family) {
+		return true
+	}
+	if to.Family == types.MoneyFamily && (from.Family == types.IntFamily || from.Family == types.DecimalFamily) {
+		return true
+	}
+	datetime := func(f types.Family) bool {
+		return f == types.DateFamily || f == types.TimestampFamily || f == types.TimestampTZFamily
+	}
+	str := to.Family == types.StringFamily || to.Family == types.CITextFamily
+	return datetime(from.Family) && datetime(to.Family) || str && eval.CanCast(from, to)
+}
+
+// coerceForAssignment prepares e to be stored in a column of type to.
+func coerceForAssignment(e eval.Expr, to *types.T, column string) (eval.Expr, error) {
+	from := e.ResolvedType()
+	if !assignable(from, to) {
+		return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+			"column %q is of type %s but expression is of type %s", column, to, from).
+			WithHint("You will need to rewrite or cast the expression.")
+	}
+	if from.Family == to.Family {
+		return e, nil
+	}
+	// Cast to the unbounded string type: an explicit cast to varchar(n)
+	// would silently truncate, while storing must reject long values.
+	if to.Family == types.StringFamily {
+		to = types.String
+	}
+	return eval.NewCastExpr(e, to)
+}
@@ -0,0 +1,142 @@
+package planner
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// vectorOpClasses maps pgvector's HNSW operator classes to the distance
+// operator they order by.
+var vectorOpClasses = map[string]string{
+	"vector_l2_ops":     "<->",
+	"vector_cosine_ops": "<=>",
+	"vector_ip_ops":     "<#>",
+}
+
+// maxHNSWDims is the largest dimension pgvector indexes with HNSW.
+const maxHNSWDims = 2000
+
+// hnswParams checks an HNSW index definition and returns its parameters,
+// with pgvector's defaults for those not given.
+func hnswParams(s *parser.CreateIndexStmt, t *catalog.Table) (*catalog.HNSWParams, error) {
+	if s.Unique {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, `access method "hnsw" does not support unique indexes`)
+	}
+	if len(s.Columns) != 1 {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, `access method "hnsw" does not support multicolumn indexes`)
+	}
+	col := t.Columns[t.FindColumn(s.Columns[0])]
+	if s.OpClasses[0] == "" {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedObject,
+			`data type %s has no default operator class for access method "hnsw"`, col.Type)
+	}
+	op, ok := vectorOpClasses[s.OpClasses[0]]
+	if !ok {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedObject,
+			`operator class %q does not exist for access method "hnsw"`, s.OpClasses[0])
+	}
+	switch {
+	case col.Type.Family != types.VectorFamily:
+		return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch,
+			"operator class %q does not accept data type %s", s.OpClasses[0], col.Type)
+	case col.Type.Width == 0:
+		return nil, pgerror.New(pgerror.CodeDataException, "column does not have dimensions")
+	case col.Type.Width > maxHNSWDims:
+		return nil, pgerror.Newf(pgerror.CodeProgramLimitExceeded,
+			"column cannot have more than %d dimensions for hnsw index", maxHNSWDims)
+	}
+	params := &catalog.HNSWParams{Operator: op, M: 16, EfConstruction: 64}
+	for _, w := range s.With {
+		var dst *int
+		lo, hi := 0, 0
+		switch w.Name {
+		case "m":
+			dst, lo, hi = &params.M, 2, 100
+		case "ef_construction":
+			dst, lo, hi = &params.EfConstruction, 4, 1000
+		default:
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue, "unrecognized parameter %q", w.Name)
+		}
+		v, err := strconv.Atoi(w.Value)
+		if err != nil {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"invalid value for integer option %q: %s", w.Name, w.Value)
+		}
+		if v < lo || v > hi {
+			return nil, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"value %d out of bounds for option %q", v, w.Name).
+				WithDetail(fmt.Sprintf("Valid values are between \"%d\" and \"%d\".", lo, hi))
+		}
+		*dst = v
+	}
+	if params.EfConstruction < 2*params.M {
+		return nil, pgerror.New(pgerror.CodeInvalidParameterValue, "ef_construction must be greater than or equal to 2 * m")
+	}
+	return params, nil
+}
+
+// planVectorSearch replaces the scan below a query of the form
+//
+//	SELECT ... FROM t [WHERE ...] ORDER BY col <-> query LIMIT n
+//
+// with a search of an HNSW index on col for the rows nearest to query,
+// when the index orders by the same distance and the WHERE clause does not
+// restrict the scan to part of an index. Like pgvector's, the search is
+// approximate: it may miss some of the nearest rows, and rows the WHERE
+// clause filters out are not replaced by farther ones.
+func planVectorSearch(limit *Limit) {
+	sort, ok := limit.Input.(*Sort)
+	if !ok || limit.Count == nil || sort.Keys[0].Desc || sort.Keys[0].NullsFirst {
+		return
+	}
+	proj, ok := sort.Input.(*Project)
+	if !ok {
+		return
+	}
+	scan, ok := proj.Input.(*Scan)
+	if !ok || !isFullScan(scan) {
+		return
+	}
+	dist, ok := proj.Exprs[sort.Keys[0].Col].(*eval.BinaryExpr)
+	if !ok {
+		return
+	}
+	ref, query := dist.Left, dist.Right
+	if _, ok := ref.(*eval.ColumnRef); !ok {
+		// All of the distances are symmetric.
+		ref, query = query, ref
+	}
+	col, ok := ref.(*eval.ColumnRef)
+	if lo, _ := columnRange(query); !ok || lo >= 0 || hasSubqueryExpr(query) {
+		return
+	}
+	for _, idx := range scan.Table.Indexes {
+		if idx.HNSW != nil && idx.HNSW.Operator == dist.Op && scan.Table.ColumnOrdinal(idx.ColumnIDs[0]) == col.Idx {
+			proj.Input = &VectorSearch{
+				Table:  scan.Table,
+				Index:  idx,
+				Query:  query,
+				Count:  limit.Count,
+				Offset: limit.Offset,
+				Filter: scan.Filter,
+			}
+			return
+		}
+	}
+}
+
+// hasSubqueryExpr reports whether e contains a subquery.
+func hasSubqueryExpr(e eval.Expr) bool {
+	found := false
+	eval.Walk(e, func(e eval.Expr) bool {
+		_, found = e.(*eval.SubqueryExpr)
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,376 @@
+package planner
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// windowScope collects the window function calls of a query's select list
+// and ORDER BY, which a Window node computes over the rows the query
+// projects.
+type windowScope struct {
+	// defs are the windows of the WINDOW clause.
+	defs []*parser.WindowDef
+	node *Window
+	// refs are the references to the results of node.Funcs. Until finish
+	// sets them, their ordinals are negative, so they match no input
+	// column.
+	refs []*eval.ColumnRef
+}
+
+// newWindowScope checks the WINDOW clause of s and returns the scope
+// collecting the window function calls of s.
+func newWindowScope(s *parser.SelectStmt) (*windowScope, error) {
+	for i, def := range s.Windows {
+		for _, prev := range s.Windows[:i] {
+			if prev.Name == def.Name {
+				return nil, pgerror.Newf(pgerror.CodeWindowingError, "window %q is already defined", def.Name)
+			}
+		}
+		if _, err := mergeWindow(def, s.Windows[:i]); err != nil {
+			return nil, err
+		}
+	}
+	return &windowScope{defs: s.Windows, node: &Window{}}, nil
+}
+
+func (s *scope) withWindows(w *windowScope) *scope {
+	c := *s
+	c.window = w
+	return &c
+}
+
+// withoutWindows returns a copy of s in which window function calls are
+// rejected with msg.
+func (s *scope) withoutWindows(msg string) *scope {
+	c := *s
+	c.window, c.noWindows = nil, msg
+	return &c
+}
+
+func containsWindowCall(e parser.Expr) bool {
+	found := false
+	parser.Walk(e, func(e parser.Expr) bool {
+		if f, ok := e.(*parser.FuncCall); ok && f.Over != nil {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// typeCheckWindowCall resolves a call with an OVER clause, adding it to
+// the Window node of s, and returns a reference to its result.
+func (p *Planner) typeCheckWindowCall(f *parser.FuncCall, s *scope) (eval.Expr, error) {
+	w := s.window
+	if w == nil {
+		msg := s.noWindows
+		if msg == "" {
+			msg = "window functions are not allowed in this context"
+		}
+		return nil, pgerror.New(pgerror.CodeWindowingError, msg)
+	}
+	isAgg := p.Registry.IsAggregate(f.Name)
+	switch {
+	case f.Distinct:
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "DISTINCT is not implemented for window functions")
+	case f.Star && !isAgg:
+		return nil, pgerror.Newf(pgerror.CodeWrongObjectType,
+			"%s(*) specified, but %s is not an aggregate function", f.Name, f.Name)
+	case !f.Star && len(f.Args) == 0 && isAgg:
+		return nil, pgerror.Newf(pgerror.CodeWrongObjectType,
+			"%s(*) must be used to call a parameterless aggregate function", f.Name)
+	}
+	inner := s.withoutWindows("window function calls cannot be nested")
+	args, err := p.typeCheckList(f.Args, inner)
+	if err != nil {
+		return nil, err
+	}
+	call, err := p.Registry.NewWindowCall(f.Name, args)
+	if err != nil {
+		return nil, err
+	}
+	def, err := mergeWindow(f.Over, w.defs)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := p.planWindowSpec(def, inner)
+	if err != nil {
+		return nil, err
+	}
+	frame := eval.DefaultFrame
+	if def.Frame != nil {
+		if frame, err = p.planFrame(def.Frame, spec, inner); err != nil {
+			return nil, err
+		}
+	}
+	return w.funcRef(&WindowFunc{Call: call, Window: w.specIndex(spec), Frame: frame}, f.String()), nil
+}
+
+// mergeWindow returns def with the window of the WINDOW clause it refers
+// to, if any, merged in. defs are the windows def may refer to.
+func mergeWindow(def *parser.WindowDef, defs []*parser.WindowDef) (*parser.WindowDef, error) {
+	if def.Ref == "" {
+		return def, nil
+	}
+	i := slices.IndexFunc(defs, func(d *parser.WindowDef) bool { return d.Name == def.Ref })
+	if i < 0 {
+		return nil, pgerror.Newf(pgerror.CodeUndefinedObject, "window %q does not exist", def.Ref)
+	}
+	base, err := mergeWindow(defs[i], defs[:i])
+	if err != nil {
+		return nil, err
+	}
+	if len(def.PartitionBy) == 0 && len(def.OrderBy) == 0 && def.Frame == nil {
+		return base, nil
+	}
+	switch {
+	case len(def.PartitionBy) > 0:
+		return nil, pgerror.Newf(pgerror.CodeWindowingError, "cannot override PARTITION BY clause of window %q", def.Ref)
+	case len(def.OrderBy) > 0 && len(base.OrderBy) > 0:
+		return nil, pgerror.Newf(pgerror.CodeWindowingError, "cannot override ORDER BY clause of window %q", def.Ref)
+	case base.Frame != nil:
+		return nil, pgerror.Newf(pgerror.CodeWindowingError, "cannot copy window %q because it has a frame clause", def.Ref)
+	}
+	merged := &parser.WindowDef{PartitionBy: base.PartitionBy, OrderBy: base.OrderBy, Frame: def.Frame}
+	if len(def.OrderBy) > 0 {
+		merged.OrderBy = def.OrderBy
+	}
+	return merged, nil
+}
+
+// planWindowSpec type checks the PARTITION BY and ORDER BY clauses of def.
+func (p *Planner) planWindowSpec(def *parser.WindowDef, s *scope) (*WindowSpec, error) {
+	spec := &WindowSpec{}
+	check := func(e parser.Expr) (eval.Expr, error) {
+		x, err := p.typeCheck(e, s)
+		if err != nil {
+			return nil, err
+		}
+		if x.ResolvedType().Family == types.UnknownFamily {
+			return eval.Coerce(x, types.String)
+		}
+		return x, nil
+	}
+	for _, e := range def.PartitionBy {
+		x, err := check(e)
+		if err != nil {
+			return nil, err
+		}
+		spec.PartitionBy = append(spec.PartitionBy, x)
+	}
+	for i, item := range def.OrderBy {
+		x, err := check(item.Expr)
+		if err != nil {
+			return nil, err
+		}
+		spec.OrderBy = append(spec.OrderBy, x)
+		spec.Order = append(spec.Order, SortKey{
+			Col:        i,
+			Desc:       item.Desc,
+			NullsFirst: item.Nulls == parser.NullsFirst || item.Nulls == parser.NullsDefault && item.Desc,
+		})
+	}
+	return spec, nil
+}
+
+// planFrame checks the frame clause f of a window ordered by spec and
+// type checks its offsets.
+func (p *Planner) planFrame(f *parser.WindowFrame, spec *WindowSpec, s *scope) (eval.WindowFrame, error) {
+	out := eval.WindowFrame{
+		Mode:    eval.FrameMode(f.Mode),
+		Start:   eval.FrameBound{Kind: eval.FrameBoundKind(f.Start.Kind)},
+		End:     eval.FrameBound{Kind: eval.FrameBoundKind(f.End.Kind)},
+		Exclude: eval.FrameExclusion(f.Exclude),
+	}
+	fail := func(msg string) (eval.WindowFrame, error) {
+		return eval.WindowFrame{}, pgerror.New(pgerror.CodeWindowingError, msg)
+	}
+	start, end := f.Start.Kind, f.End.Kind
+	switch {
+	case start == parser.UnboundedFollowing:
+		return fail("frame start cannot be UNBOUNDED FOLLOWING")
+	case end == parser.UnboundedPreceding:
+		return fail("frame end cannot be UNBOUNDED PRECEDING")
+	case start == parser.CurrentRow && end == parser.OffsetPreceding:
+		return fail("frame starting from current row cannot have preceding rows")
+	case start == parser.OffsetFollowing && end == parser.CurrentRow:
+		return fail("frame starting from following row cannot end with current row")
+	case start == parser.OffsetFollowing && end == parser.OffsetPreceding:
+		return fail("frame starting from following row cannot have preceding rows")
+	case f.Mode == parser.FrameGroups && len(spec.OrderBy) == 0:
+		return fail("GROUPS mode requires an ORDER BY clause")
+	}
+	mode := f.Mode.String()
+	for _, b := range []struct {
+		in  parser.FrameBound
+		out *eval.FrameBound
+		end bool
+	}{{f.Start, &out.Start, false}, {f.End, &out.End, true}} {
+		if b.in.Offset == nil {
+			continue
+		}
+		offset, err := p.typeCheck(b.in.Offset, s.withoutAggregates("aggregate functions are not allowed in window "+mode))
+		if err != nil {
+			return eval.WindowFrame{}, err
+		}
+		refersToColumn := false
+		eval.Walk(offset, func(e eval.Expr) bool {
+			if _, ok := e.(*eval.ColumnRef); ok {
+				refersToColumn = true
+			}
+			return !refersToColumn
+		})
+		if refersToColumn {
+			return eval.WindowFrame{}, pgerror.Newf(pgerror.CodeInvalidColumnReference,
+				"argument of %s must not contain variables", mode)
+		}
+		if f.Mode != parser.FrameRange {
+			if b.out.Offset, err = frameCount(offset, mode); err != nil {
+				return eval.WindowFrame{}, err
+			}
+			continue
+		}
+		if len(spec.OrderBy) != 1 {
+			return fail("RANGE with offset PRECEDING/FOLLOWING requires exactly one ORDER BY column")
+		}
+		if err := p.planRangeBound(b.out, offset, spec, b.in.Kind == parser.OffsetPreceding, b.end); err != nil {
+			return eval.WindowFrame{}, err
+		}
+	}
+	return out, nil
+}
+
+// frameCount converts the offset of a ROWS or GROUPS frame to bigint.
+func frameCount(offset eval.Expr, mode string) (eval.Expr, error) {
+	switch t := offset.ResolvedType(); {
+	case t.Oid == types.OidInt8:
+		return offset, nil
+	case t.Family == types.IntFamily || t.Family == types.UnknownFamily ||
+		t.Family == types.DecimalFamily || t.Family == types.FloatFamily:
+		return eval.NewCastExpr(offset, types.Int8)
+	default:
+		return nil, pgerror.Newf(pgerror.CodeDatatypeMismatch, "argument of %s must be type bigint, not type %s", mode, t)
+	}
+}
+
+// planRangeBound sets the expressions b needs to bound a RANGE frame by
+// offset from the ORDER BY value of spec: the bound, which is the value
+// less the offset before the current row in window order and plus it
+// after, and the comparison of other rows' values with it.
+func (p *Planner) planRangeBound(b *eval.FrameBound, offset eval.Expr, spec *WindowSpec, preceding, end bool) error {
+	key := spec.OrderBy[0].ResolvedType()
+	unsupported := func() error {
+		return pgerror.Newf(pgerror.CodeFeatureNotSupported,
+			"RANGE with offset PRECEDING/FOLLOWING is not supported for column type %s and offset type %s",
+			key, offset.ResolvedType())
+	}
+	switch key.Family {
+	case types.IntFamily, types.FloatFamily, types.DecimalFamily:
+	case types.DateFamily, types.TimestampFamily, types.TimestampTZFamily, types.IntervalFamily:
+		if offset.ResolvedType().Family == types.UnknownFamily {
+			var err error
+			if offset, err = eval.Coerce(offset, types.Interval); err != nil {
+				return err
+			}
+		}
+	default:
+		return pgerror.Newf(pgerror.CodeFeatureNotSupported,
+			"RANGE with offset PRECEDING/FOLLOWING is not supported for column type %s", key)
+	}
+	desc := spec.Order[0].Desc
+	op := "+"
+	if preceding != desc {
+		op = "-"
+	}
+	cur := &eval.ColumnRef{Idx: 0, Name: spec.OrderBy[0].String(), Typ: key}
+	value, err := p.Registry.NewBinaryExpr(op, cur, offset)
+	if err != nil {
+		return unsupported()
+	}
+	// A row is in the frame if its value is past the start bound, or
+	// before the end bound, in window order.
+	cmp := eval.GE
+	if end != desc {
+		cmp = eval.LE
+	}
+	inFrame, err := eval.NewComparisonExpr(cmp, cur, &eval.ColumnRef{Idx: 1, Name: "bound", Typ: value.ResolvedType()})
+	if err != nil {
+		return unsupported()
+	}
+	b.Offset, b.Value, b.InFrame = offset, value, inFrame
+	return nil
+}
+
+// specIndex returns the index of the window of w's node partitioning and
+// ordering rows as spec does, adding spec if there is none.
+func (w *windowScope) specIndex(spec *WindowSpec) int {
+	for i, o := range w.node.Windows {
+		if sameExprs(o.PartitionBy, spec.PartitionBy) && sameExprs(o.OrderBy, spec.OrderBy) && slices.Equal(o.Order, spec.Order) {
+			return i
+		}
+	}
+	w.node.Windows = append(w.node.Windows, spec)
+	return len(w.node.Windows) - 1
+}
+
+// funcRef returns a reference to the result of f, adding it to w's node
+// unless an identical call over the same window and frame was already
+// added.
+func (w *windowScope) funcRef(f *WindowFunc, name string) eval.Expr {
+	for i, o := range w.node.Funcs {
+		if o.Window == f.Window && o.Call.Name == f.Call.Name && o.Call.Func == f.Call.Func &&
+			o.Call.Agg == f.Call.Agg && sameExprs(o.Call.Args, f.Call.Args) && o.Frame.String() == f.Frame.String() {
+			return w.refs[i]
+		}
+	}
+	ref := &eval.ColumnRef{Idx: -1 - len(w.node.Funcs), Name: name, Typ: f.Call.Typ}
+	w.node.Funcs = append(w.node.Funcs, f)
+	w.refs = append(w.refs, ref)
+	return ref
+}
+
+// finish places w's node over input, setting the references to its
+// results now that the width of the input is known.
+func (w *windowScope) finish(input Node) *Window {
+	width := len(input.Columns())
+	for i, ref := range w.refs {
+		ref.Idx = width + i
+	}
+	w.node.Input = input
+	return w.node
+}
+
+func (w *WindowSpec) String() string {
+	var parts []string
+	if len(w.PartitionBy) > 0 {
+		keys := make([]string, len(w.PartitionBy))
+		for i, e := range w.PartitionBy {
+			keys[i] = e.String()
+		}
+		parts = append(parts, "PARTITION BY "+strings.Join(keys, ", "))
+	}
+	if len(w.OrderBy) > 0 {
+		keys := make([]string, len(w.OrderBy))
+		for i, k := range w.Order {
+			keys[i] = w.OrderBy[i].String()
+			if k.Desc {
+				keys[i] += " DESC"
+			}
+			if k.NullsFirst != k.Desc {
+				if k.NullsFirst {
+					keys[i] += " NULLS FIRST"
+				} else {
+					keys[i] += " NULLS LAST"
+				}
+			}
+		}
+		parts = append(parts, "ORDER BY "+strings.Join(keys, ", "))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
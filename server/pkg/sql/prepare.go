@@ -0,0 +1,418 @@
+package sql
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// maxSharedPlans bounds the plans a server shares between its sessions,
+// and maxParsed the statements a session keeps parsed by query text.
+const (
+	maxSharedPlans = 1024
+	maxParsed      = 256
+)
+
+// PreparedStatement is a statement parsed once by Prepare and executed any
+// number of times with different parameter values, as the extended query
+// protocol's Parse and Execute messages do.
+type PreparedStatement struct {
+	// Name is the name of the statement, empty for the unnamed statement.
+	Name string
+	// SQL is the text of the statement.
+	SQL string
+	// Stmt is the parsed statement, as rewritten by OnParse hooks, or nil
+	// for an empty query.
+	Stmt parser.Statement
+	// ParamTypes are the types of the parameters $1, $2, ..., declared or
+	// inferred from their use.
+	ParamTypes []*types.T
+	// Columns describe the rows the statement returns.
+	Columns []planner.Column
+
+	// plan is the plan of the statement, valid while the catalog is at
+	// version and the search path is searchPath, which names resolve in.
+	plan       planner.Node
+	version    uint64
+	searchPath string
+	// query is the normalized statement its executions are counted under
+	// in pg_stat_statements.
+	query *parser.Normalized
+}
+
+// planCache holds the plans of the statements prepared on a server,
+// shared between its sessions by query text and declared parameter types.
+// Plans are valid for a version of the catalog, which moves on, emptying
+// the cache, whenever a transaction that changed the catalog commits.
+type planCache struct {
+	mu      sync.Mutex
+	version uint64
+	plans   map[string]*sharedPlan
+}
+
+type sharedPlan struct {
+	stmt       parser.Statement
+	plan       planner.Node
+	paramTypes []*types.T
+}
+
+// current returns the version of the catalog.
+func (c *planCache) current() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+// invalidate moves the catalog on to a new version.
+func (c *planCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	clear(c.plans)
+}
+
+func (c *planCache) get(key string, version uint64) *sharedPlan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return nil
+	}
+	return c.plans[key]
+}
+
+// put caches p for version, unless the catalog has moved on since.
+func (c *planCache) put(key string, version uint64, p *sharedPlan) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return
+	}
+	if c.plans == nil {
+		c.plans = make(map[string]*sharedPlan)
+	}
+	if len(c.plans) >= maxSharedPlans {
+		for k := range c.plans {
+			delete(c.plans, k)
+			break
+		}
+	}
+	c.plans[key] = p
+}
+
+// planKey is the key of the plan of query with the declared parameter
+// types in the shared plan cache, for sessions of user connected to the
+// database with ID db with the search path searchPath, which decide what
+// the names of the query resolve to.
+func planKey(query string, declared []*types.T, db catalog.ID, searchPath, user string) string {
+	var b strings.Builder
+	b.WriteString(query)
+	for _, t := range declared {
+		b.WriteByte(0)
+		if t != nil {
+			b.WriteString(t.String())
+		}
+	}
+	b.WriteByte(1)
+	fmt.Fprint(&b, db)
+	b.WriteByte(1)
+	b.WriteString(searchPath)
+	b.WriteByte(1)
+	b.WriteString(user)
+	return b.String()
+}
+
+// Prepare parses query, which holds at most one statement, and plans it as
+// the prepared statement name, replacing the unnamed statement if name is
+// empty. paramTypes declare the types of the parameters $1, $2, ...; the
+// types of parameters not declared, or declared nil or unknown, are
+// inferred from their use.
+//
+// The plan is reused by ExecPrepared, and shared with the statements of
+// the same text prepared by other sessions, until a transaction changing
+// the catalog commits. The shared plans are not used while OnParse hooks
+// are registered, since hooks may rewrite statements per session.
+func (s *Session) Prepare(name, query string, paramTypes []*types.T) (*PreparedStatement, error) {
+	query, err := s.encoding().decode(query)
+	if err != nil {
+		s.fail()
+		return nil, err
+	}
+	ps, err := s.prepare(name, query, nil, paramTypes)
+	if err != nil {
+		s.fail()
+	}
+	return ps, err
+}
+
+// prepare prepares the statement stmt, whose text is query, as name. If
+// stmt is nil, query is parsed.
+func (s *Session) prepare(name, query string, stmt parser.Statement, declared []*types.T) (*PreparedStatement, error) {
+	if _, ok := s.prepared[name]; ok && name != "" {
+		return nil, pgerror.Newf(pgerror.CodeDuplicatePreparedStmt, "prepared statement %q already exists", name)
+	}
+	if s.failed {
+		return nil, errInFailedTxn()
+	}
+	txn := s.txn
+	if txn == nil {
+		s.version = s.server.plans.current()
+		var err error
+		if txn, err = s.engine.Begin(); err != nil {
+			return nil, err
+		}
+		// Planning only reads the catalog.
+		defer txn.Abort()
+	}
+	ps := &PreparedStatement{Name: name, SQL: query, query: normalizeOne(query)}
+	version, cache := s.planVersion()
+	cache = cache && !s.server.hasParseHooks()
+	searchPath := s.getVar("search_path")
+	key := planKey(query, declared, s.database.ID, searchPath, s.user)
+	if sp := s.server.plans.get(key, version); cache && sp != nil {
+		ps.Stmt, ps.plan, ps.version, ps.ParamTypes = sp.stmt, sp.plan, version, sp.paramTypes
+		ps.searchPath = searchPath
+		ps.Columns = sp.plan.Columns()
+		s.addPrepared(ps)
+		return ps, nil
+	}
+	if stmt == nil {
+		var err error
+		if stmt, err = s.parse(query); err != nil {
+			return nil, err
+		}
+	}
+	if stmt == nil {
+		s.addPrepared(ps)
+		return ps, nil
+	}
+	hc := &HookContext{Session: s, SQL: query, Stmt: stmt}
+	if err := s.parseHooks(hc); err != nil {
+		return nil, err
+	}
+	ps.Stmt = hc.Stmt
+	switch ps.Stmt.(type) {
+	case *parser.BeginStmt, *parser.CommitStmt, *parser.RollbackStmt,
+		*parser.PrepareStmt, *parser.ExecuteStmt, *parser.DeallocateStmt,
+		*parser.ShowStmt, *parser.SetStmt,
+		*parser.ListenStmt, *parser.UnlistenStmt, *parser.NotifyStmt:
+		// The session runs these statements without a plan.
+		ps.ParamTypes = slices.Clone(declared)
+		if show, ok := ps.Stmt.(*parser.ShowStmt); ok {
+			ps.Columns = showColumns(show)
+		}
+		s.addPrepared(ps)
+		return ps, nil
+	}
+	plan, typs, err := s.planner(txn).PlanPrepared(ps.Stmt, declared)
+	if err != nil {
+		return nil, err
+	}
+	ps.ParamTypes, ps.Columns = typs, plan.Columns()
+	if !reusable(plan) {
+		s.addPrepared(ps)
+		return ps, nil
+	}
+	ps.plan, ps.version, ps.searchPath = plan, version, searchPath
+	if cache {
+		s.server.plans.put(key, version, &sharedPlan{stmt: ps.Stmt, plan: plan, paramTypes: typs})
+	}
+	s.addPrepared(ps)
+	return ps, nil
+}
+
+// parse parses query, which holds at most one statement, returning nil for
+// an empty query. Statements are cached by query text: the planner and
+// executor do not modify them.
+func (s *Session) parse(query string) (parser.Statement, error) {
+	if stmt, ok := s.parsed[query]; ok {
+		return stmt, nil
+	}
+	stmts, err := parser.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmts) > 1 {
+		return nil, pgerror.New(pgerror.CodeSyntaxError, "cannot insert multiple commands into a prepared statement")
+	}
+	var stmt parser.Statement
+	if len(stmts) == 1 {
+		stmt = stmts[0]
+	}
+	if s.parsed == nil || len(s.parsed) >= maxParsed {
+		s.parsed = make(map[string]parser.Statement)
+	}
+	s.parsed[query] = stmt
+	return stmt, nil
+}
+
+func (s *Session) addPrepared(ps *PreparedStatement) {
+	if s.prepared == nil {
+		s.prepared = make(map[string]*PreparedStatement)
+	}
+	s.prepared[ps.Name] = ps
+}
+
+// Prepared returns the prepared statement name, or nil if there is none.
+func (s *Session) Prepared(name string) *PreparedStatement {
+	return s.prepared[name]
+}
+
+func (s *Session) lookupPrepared(name string) (*PreparedStatement, error) {
+	ps := s.prepared[name]
+	if ps == nil {
+		return nil, pgerror.Newf(pgerror.CodeInvalidSQLStatementName, "prepared statement %q does not exist", name)
+	}
+	return ps, nil
+}
+
+// ExecPrepared executes the prepared statement name with the parameter
+// values args, which are cast to the statement's parameter types.
+func (s *Session) ExecPrepared(name string, args []types.Datum) (*Result, error) {
+	var query string
+	if ps := s.prepared[name]; ps != nil {
+		query = ps.SQL
+	}
+	if err := s.begin(query); err != nil {
+		return nil, err
+	}
+	res, err := s.execPrepared(name, args)
+	if err != nil {
+		s.fail()
+	}
+	s.end(err)
+	return res, err
+}
+
+func (s *Session) execPrepared(name string, args []types.Datum) (*Result, error) {
+	ps, err := s.lookupPrepared(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != len(ps.ParamTypes) {
+		return nil, pgerror.Newf(pgerror.CodeProtocolViolation,
+			"bind message supplies %d parameters, but prepared statement \"%s\" requires %d",
+			len(args), name, len(ps.ParamTypes))
+	}
+	if ps.Stmt == nil {
+		return &Result{}, nil
+	}
+	ctx := &eval.Context{Location: s.location, Styles: s.styles(), Regexps: s.regexps}
+	params := make([]types.Datum, len(args))
+	for i, d := range args {
+		if text, ok := d.(types.DString); ok {
+			if err := types.CheckText(string(text)); err != nil {
+				return nil, err
+			}
+		}
+		if params[i], err = eval.PerformCast(ctx, d, ps.ParamTypes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return s.execute(&HookContext{Session: s, SQL: ps.SQL, Stmt: ps.Stmt, Params: params, prepared: ps, query: ps.query})
+}
+
+// bindExecute returns the context executing the prepared statement named
+// by EXECUTE e, evaluating its arguments.
+func (s *Session) bindExecute(e *parser.ExecuteStmt, txn engine.Txn, ctx *eval.Context) (*HookContext, error) {
+	ps, err := s.lookupPrepared(e.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Params) != len(ps.ParamTypes) {
+		return nil, pgerror.Newf(pgerror.CodeSyntaxError, "wrong number of parameters for prepared statement \"%s\"", e.Name).
+			WithDetail(fmt.Sprintf("Expected %d parameters but got %d.", len(ps.ParamTypes), len(e.Params)))
+	}
+	exprs, err := s.planner(txn).PlanParams(e.Params, ps.ParamTypes)
+	if err != nil {
+		return nil, err
+	}
+	params := make([]types.Datum, len(exprs))
+	for i, x := range exprs {
+		if params[i], err = x.Eval(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return &HookContext{Session: s, SQL: ps.SQL, Stmt: ps.Stmt, Params: params, prepared: ps, query: ps.query}, nil
+}
+
+// Deallocate removes the prepared statement name.
+func (s *Session) Deallocate(name string) error {
+	if _, err := s.lookupPrepared(name); err != nil {
+		return err
+	}
+	delete(s.prepared, name)
+	return nil
+}
+
+// DeallocateAll removes all prepared statements of the session.
+func (s *Session) DeallocateAll() {
+	clear(s.prepared)
+}
+
+// planVersion returns the catalog version of the snapshot the session's
+// statements plan with, and whether plans may be cached and reused for it:
+// not when the transaction changed the catalog, or began before another
+// changed it.
+func (s *Session) planVersion() (uint64, bool) {
+	return s.version, !s.txnDDL && s.version == s.server.plans.current()
+}
+
+// plan returns the plan of hc.Stmt. The plan of a prepared statement is
+// reused while the catalog and the search path are unchanged.
+func (s *Session) plan(hc *HookContext, txn engine.Txn) (planner.Node, error) {
+	ps := hc.prepared
+	if ps == nil {
+		return s.planner(txn).Plan(hc.Stmt)
+	}
+	version, cache := s.planVersion()
+	searchPath := s.getVar("search_path")
+	if cache && ps.plan != nil && ps.version == version && ps.searchPath == searchPath {
+		return ps.plan, nil
+	}
+	plan, _, err := s.planner(txn).PlanPrepared(ps.Stmt, ps.ParamTypes)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.EqualFunc(plan.Columns(), ps.Columns, func(a, b planner.Column) bool {
+		return a.Name == b.Name && a.Type.Identical(b.Type)
+	}) {
+		return nil, pgerror.New(pgerror.CodeFeatureNotSupported, "cached plan must not change result type")
+	}
+	if cache && reusable(plan) {
+		ps.plan, ps.version, ps.searchPath = plan, version, searchPath
+	}
+	return plan, nil
+}
+
+// reusable reports whether plan may be executed more than once. The
+// plans of statements changing the catalog are not: executing them
+// assigns IDs to the objects they describe.
+func reusable(plan planner.Node) bool {
+	return !changesCatalog(plan)
+}
+
+// changesCatalog reports whether plan changes the catalog, or the table
+// statistics plans are chosen by, which invalidates cached plans when its
+// transaction commits.
+func changesCatalog(plan planner.Node) bool {
+	switch n := plan.(type) {
+	case *planner.Vacuum:
+		return n.Analyze
+	case *planner.CreateTable, *planner.CreateIndex, *planner.AlterTable, *planner.DropTable,
+		*planner.DropIndex, *planner.CreateSequence, *planner.DropSequence, *planner.Analyze,
+		*planner.CreateSchema, *planner.DropSchema, *planner.CreateDatabase, *planner.DropDatabase,
+		*planner.CreateRole, *planner.AlterRole, *planner.AlterRoleSettings, *planner.DropRole,
+		*planner.GrantRole, *planner.Grant, *planner.CreatePublication, *planner.DropPublication:
+		return true
+	}
+	return false
+}
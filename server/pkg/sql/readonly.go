@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/exec"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// SetReadOnly makes the sessions of the server only read, as those of a
+// read replica, whose engine is written by the changes it applies: the
+// statements that write fail with read_only_sql_transaction, and so do
+// the writes of the functions queries call, such as nextval. Logins are
+// not recorded, and no bootstrap superuser is created in an engine
+// without roles. It must be called before sessions connect.
+func (s *Server) SetReadOnly(on bool) {
+	s.readOnly = on
+}
+
+// errReadOnly is the error for the statement what, which writes, on a
+// read-only server.
+func errReadOnly(what string) error {
+	return pgerror.Newf(pgerror.CodeReadOnlySQLTransaction, "cannot execute %s in a read-only transaction", what)
+}
+
+// checkReadOnly returns an error for stmt if it writes and the server is
+// read-only, or readOnly is set for a read-only transaction, which began
+// while default_transaction_read_only was on.
+func (s *Session) checkReadOnly(stmt parser.Statement, readOnly bool) error {
+	if !s.server.readOnly && !readOnly {
+		return nil
+	}
+	switch stmt := stmt.(type) {
+	case *parser.SelectStmt, *parser.ShowCreateStmt:
+		return nil
+	case *parser.ExplainStmt:
+		// EXPLAIN only plans the statement.
+		return nil
+	default:
+		// The command tag names the statement, but for its counts.
+		name := strings.TrimRight(commandTag(stmt, &exec.Result{}), " 0123456789")
+		if name == "" {
+			name = "this statement"
+		}
+		return errReadOnly(name)
+	}
+}
+
+// readOnlyEngine is the keyspace of a session of a read-only server, or
+// of the statements of a read-only transaction, whose writes fail. It is
+// what catches the writes of functions, which checkReadOnly cannot tell
+// from the statement.
+type readOnlyEngine struct {
+	engine.Engine
+}
+
+func (e readOnlyEngine) Begin() (engine.Txn, error) {
+	txn, err := e.Engine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return readOnlyTxn{txn}, nil
+}
+
+func (readOnlyEngine) Put(key, value []byte) error         { return errReadOnlyWrite() }
+func (readOnlyEngine) Delete(key []byte) error             { return errReadOnlyWrite() }
+func (readOnlyEngine) DeleteRange(start, end []byte) error { return errReadOnlyWrite() }
+
+// Space reports the space of the keys in [start, end), or
+// errors.ErrUnsupported if the keyspace is not an engine.SpaceReporter.
+func (e readOnlyEngine) Space(start, end []byte) (engine.SpaceStats, error) {
+	r, ok := e.Engine.(engine.SpaceReporter)
+	if !ok {
+		return engine.SpaceStats{}, errors.ErrUnsupported
+	}
+	return r.Space(start, end)
+}
+
+// readOnlyTxn is a transaction of a readOnlyEngine.
+type readOnlyTxn struct {
+	engine.Txn
+}
+
+func (readOnlyTxn) Put(key, value []byte) error         { return errReadOnlyWrite() }
+func (readOnlyTxn) Delete(key []byte) error             { return errReadOnlyWrite() }
+func (readOnlyTxn) DeleteRange(start, end []byte) error { return errReadOnlyWrite() }
+
+// errReadOnlyWrite is the error for a write a statement checkReadOnly let
+// through makes on a read-only server.
+func errReadOnlyWrite() error {
+	return pgerror.New(pgerror.CodeReadOnlySQLTransaction, "cannot write in a read-only transaction")
+}
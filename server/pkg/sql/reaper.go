@@ -0,0 +1,68 @@
+package sql
+
+import (
+	"context"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/exec"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// Reaped counts what Reap cleaned up.
+type Reaped struct {
+	// TempRanges is the number of orphaned temporary ranges deleted.
+	TempRanges int
+	// Transactions is the number of sessions terminated for keeping a
+	// transaction open for too long.
+	Transactions int
+}
+
+// Reap cleans up what sessions left behind. It deletes the temporary
+// ranges that sorts and hash aggregations spilled rows to and failed to
+// delete, those no running statement uses, and, if maxTxnAge is positive,
+// terminates the sessions whose transaction has been open for longer,
+// running or idle, like PostgreSQL's transaction_timeout; their
+// transactions are then aborted, which releases the versions their
+// snapshots keep. A read-only server deletes no temporary ranges: those of
+// a replica are the primary's. It stops when ctx is done.
+func (s *Server) Reap(ctx context.Context, maxTxnAge time.Duration) (Reaped, error) {
+	var r Reaped
+	if maxTxnAge > 0 {
+		before := time.Now().Add(-maxTxnAge)
+		for _, sess := range s.backends.all() {
+			if sess.activity.terminateXactBefore(before, errTransactionTimeout()) {
+				r.Transactions++
+			}
+		}
+	}
+	if s.readOnly {
+		return r, nil
+	}
+	// A range is live from before its first rows are written until after
+	// it is deleted, so one that is not live once its rows are seen is
+	// orphaned.
+	ids, err := catalog.TempRangeIDs(s.engine)
+	if err != nil {
+		return r, err
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return r, err
+		}
+		if exec.SpillLive(id) {
+			continue
+		}
+		if err := catalog.DropTempRange(s.engine, id); err != nil {
+			return r, err
+		}
+		r.TempRanges++
+	}
+	return r, nil
+}
+
+// errTransactionTimeout is the error Reap terminates the sessions whose
+// transaction is too old with.
+func errTransactionTimeout() *pgerror.Error {
+	return pgerror.New(pgerror.CodeTransactionTimeout, "terminating connection due to transaction timeout")
+}
@@ -0,0 +1,108 @@
+package sql_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+func connect(t *testing.T, srv *sql.Server) *sql.Session {
+	t.Helper()
+	s, err := srv.Connect("postgres", "postgres")
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func exec(t *testing.T, s *sql.Session, query string) []*sql.Result {
+	t.Helper()
+	res, err := s.Exec(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return res
+}
+
+func TestReapTempRanges(t *testing.T) {
+	e := memory.New()
+	srv := sql.NewServer(e)
+	// A range left behind by a statement whose drop failed.
+	start, _ := catalog.TempRange(1 << 40)
+	if err := e.Put(append(start, 0), []byte("row")); err != nil {
+		t.Fatal(err)
+	}
+	// A statement that spills deletes its ranges itself.
+	s := connect(t, srv)
+	exec(t, s, "CREATE TABLE t (id int PRIMARY KEY, v text)")
+	var values []string
+	for i := range 2000 {
+		values = append(values, fmt.Sprintf("(%d, '%s')", i, strings.Repeat("x", 100)))
+	}
+	exec(t, s, "INSERT INTO t VALUES "+strings.Join(values, ", "))
+	exec(t, s, "SET work_mem = '64kB'")
+	exec(t, s, "SELECT id FROM t ORDER BY v, id DESC")
+
+	r, err := srv.Reap(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.TempRanges != 1 {
+		t.Errorf("reaped %d temporary ranges, want 1", r.TempRanges)
+	}
+	ids, err := catalog.TempRangeIDs(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Errorf("temporary ranges %v left after reaping", ids)
+	}
+}
+
+func TestReapTransactions(t *testing.T) {
+	srv := sql.NewServer(memory.New())
+	old := connect(t, srv)
+	exec(t, old, "BEGIN")
+	exec(t, old, "SELECT 1")
+	idle := connect(t, srv)
+	exec(t, idle, "SELECT 1")
+	time.Sleep(50 * time.Millisecond)
+	young := connect(t, srv)
+	exec(t, young, "BEGIN")
+
+	r, err := srv.Reap(context.Background(), 25*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Transactions != 1 {
+		t.Errorf("terminated %d sessions, want 1", r.Transactions)
+	}
+	select {
+	case <-old.Done():
+	default:
+		t.Fatal("the session with the old transaction was not terminated")
+	}
+	var pgErr *pgerror.Error
+	if !errors.As(old.Err(), &pgErr) || pgErr.Code != pgerror.CodeTransactionTimeout {
+		t.Errorf("terminated with %v, want a transaction timeout", old.Err())
+	}
+	for _, s := range []*sql.Session{idle, young} {
+		if err := s.Err(); err != nil {
+			t.Errorf("a session without an old transaction was terminated: %v", err)
+		}
+	}
+
+	// Reaping with no age terminates nothing.
+	if r, err := srv.Reap(context.Background(), 0); err != nil || r.Transactions != 0 {
+		t.Errorf("Reap(0) = %+v, %v", r, err)
+	}
+}
@@ -0,0 +1,797 @@
+package sql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/exec"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// The transactions that commit in a database with a logical replication
+// slot record their changes in the replication log as they commit, which
+// the slots stream to clients, filtered by the publications the clients
+// name. A record is kept until every slot has confirmed it received it.
+// Its LSN, its position in the log, is the number of the transaction in
+// commit order.
+
+// Plugin is the output plugin of logical replication slots: pgoutput, the
+// plugin PostgreSQL's logical replication and tools like Debezium use.
+const Plugin = "pgoutput"
+
+// maxSlotName bounds the length of replication slot names, as PostgreSQL
+// bounds names.
+const maxSlotName = 63
+
+// ReplicatedTxn is a committed transaction, as the replication log records
+// it and a replication slot streams it.
+type ReplicatedTxn struct {
+	// LSN is the position of the transaction in the replication log. A
+	// client that flushed it confirms LSN+1, where its changes end.
+	LSN        uint64     `json:"-"`
+	Database   catalog.ID `json:"db"`
+	CommitTime time.Time  `json:"time"`
+	// Relations are the tables the changes are to, as they were as the
+	// changes were made.
+	Relations []*Relation `json:"relations"`
+	Changes   []*Change   `json:"changes"`
+}
+
+// Relation is a table as logical replication describes it to clients.
+type Relation struct {
+	ID     catalog.ID `json:"id"`
+	Schema string     `json:"schema"`
+	Name   string     `json:"name"`
+	// FullIdentity is set for tables without a primary key, whose updates
+	// and deletes identify the old row by all its values, as with REPLICA
+	// IDENTITY FULL. The others identify it by their primary key.
+	FullIdentity bool `json:"full,omitempty"`
+	// Columns are the visible columns of the table.
+	Columns []RelationColumn `json:"columns"`
+}
+
+// RelationColumn is a column of a Relation.
+type RelationColumn struct {
+	Name string   `json:"name"`
+	Type *types.T `json:"type"`
+	// Key is set for the columns identifying the rows.
+	Key bool `json:"key,omitempty"`
+}
+
+// Change is a change a transaction made to the rows of a table, with the
+// text of the values of its columns, nil for NULL.
+type Change struct {
+	// Kind is 'I', 'U', 'D' or 'T', for an insert, update, delete or
+	// truncate, as in pgoutput.
+	Kind byte `json:"kind"`
+	// Relation is the index of the table in the Relations of the
+	// transaction.
+	Relation int `json:"rel"`
+	// New is the row inserted or updated. Old is the row deleted, or the
+	// row updated if its identity changed, with only the values of its
+	// identity unless the table has FullIdentity.
+	Old []*string `json:"old,omitempty"`
+	New []*string `json:"new,omitempty"`
+	// RestartIdentity is set for a TRUNCATE ... RESTART IDENTITY.
+	RestartIdentity bool `json:"restart,omitempty"`
+}
+
+// replication is what the server keeps in memory of logical replication,
+// loaded from the engine when it is first needed.
+type replication struct {
+	mu     sync.Mutex
+	loaded bool
+	// next is the LSN the next record of the log is written at.
+	next uint64
+	// slots counts the replication slots of each database, whose
+	// transactions record their changes while it has any.
+	slots map[catalog.ID]int
+	// uncaptured counts the open transactions of each database that do
+	// not record their changes, as they began before it had a slot, which
+	// creating a slot waits for.
+	uncaptured map[catalog.ID]int
+	// active maps the names of the slots being streamed from to the
+	// process IDs of the sessions streaming them.
+	active map[string]int32
+	// wake is closed, and replaced, when a record is appended to the log
+	// and when uncaptured drops.
+	wake chan struct{}
+}
+
+// load reads the replication slots, and the end of the log, from e once.
+// r.mu must be held.
+func (r *replication) load(e engine.Engine) error {
+	if r.loaded {
+		return nil
+	}
+	txn, err := e.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Abort()
+	slots, err := catalog.ListReplicationSlots(txn)
+	if err != nil {
+		return err
+	}
+	r.next = 1
+	r.slots = make(map[catalog.ID]int)
+	for _, s := range slots {
+		r.slots[s.Database]++
+		r.next = max(r.next, s.ConfirmedFlush)
+	}
+	it, err := txn.Scan(catalog.ReplicationLogKey(0), catalog.ReplicationLogEnd())
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		k, _, err := it.Next()
+		if errors.Is(err, engine.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		r.next = max(r.next, catalog.ReplicationLogLSN(k)+1)
+	}
+	r.uncaptured = make(map[catalog.ID]int)
+	r.active = make(map[string]int32)
+	r.wake = make(chan struct{})
+	r.loaded = true
+	return nil
+}
+
+// wakeAll wakes those waiting on r.wake. r.mu must be held.
+func (r *replication) wakeAll() {
+	close(r.wake)
+	r.wake = make(chan struct{})
+}
+
+// capture is what a transaction of a database records for the replication
+// log: the changes it makes, if the database had a slot as it began.
+type capture struct {
+	db      catalog.ID
+	on      bool
+	changes []exec.RowChange
+}
+
+// beginCapture starts capturing the changes of a transaction of the
+// session's database.
+func (s *Session) beginCapture() error {
+	r := &s.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(s.server.engine); err != nil {
+		return err
+	}
+	c := &capture{db: s.database.ID, on: r.slots[s.database.ID] > 0}
+	if !c.on {
+		r.uncaptured[c.db]++
+	}
+	s.capture = c
+	return nil
+}
+
+// endCapture stops capturing the changes of the transaction as it ends.
+func (s *Session) endCapture() {
+	c := s.capture
+	s.capture = nil
+	if c == nil || c.on {
+		return
+	}
+	r := &s.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.uncaptured[c.db]--; r.uncaptured[c.db] == 0 {
+		delete(r.uncaptured, c.db)
+		r.wakeAll()
+	}
+}
+
+// changed returns the exec.Context Changed of the statements of the
+// transaction, or nil if it does not record its changes.
+func (c *capture) changed() func(exec.RowChange) {
+	if c == nil || !c.on {
+		return nil
+	}
+	return func(ch exec.RowChange) { c.changes = append(c.changes, ch) }
+}
+
+// loggedTxn is a transaction that records its changes in the replication
+// log as it commits.
+type loggedTxn struct {
+	engine.Txn
+	r *replication
+	c *capture
+}
+
+// Commit writes the record of the transaction's changes at the end of the
+// log and commits, in the order of the LSNs.
+func (t *loggedTxn) Commit() error {
+	rec, err := t.c.record(t.Txn)
+	if err != nil {
+		t.Txn.Abort()
+		return err
+	}
+	t.r.mu.Lock()
+	defer t.r.mu.Unlock()
+	if err := t.Txn.Put(catalog.ReplicationLogKey(t.r.next), rec); err != nil {
+		t.Txn.Abort()
+		return err
+	}
+	if err := t.Txn.Commit(); err != nil {
+		return err
+	}
+	t.r.next++
+	t.r.wakeAll()
+	return nil
+}
+
+// record returns the record of the changes of the transaction, whose
+// catalog txn reads.
+func (c *capture) record(txn engine.Reader) ([]byte, error) {
+	rec := &ReplicatedTxn{Database: c.db, CommitTime: time.Now()}
+	// Each statement reads the descriptors anew, and the transaction may
+	// have altered a table between them: a relation is added for each
+	// descriptor unless it is that of the last of the same table.
+	rels := make(map[*catalog.Table]int)
+	last := make(map[catalog.ID]int)
+	for _, ch := range c.changes {
+		i, ok := rels[ch.Table]
+		if !ok {
+			rel, err := newRelation(txn, ch.Table)
+			if err != nil {
+				return nil, err
+			}
+			if j, ok := last[rel.ID]; ok && reflect.DeepEqual(rec.Relations[j], rel) {
+				i = j
+			} else {
+				i = len(rec.Relations)
+				rec.Relations = append(rec.Relations, rel)
+				last[rel.ID] = i
+			}
+			rels[ch.Table] = i
+		}
+		rec.Changes = append(rec.Changes, newChange(ch, rec.Relations[i], i))
+	}
+	return json.Marshal(rec)
+}
+
+func newRelation(txn engine.Reader, t *catalog.Table) (*Relation, error) {
+	schema, err := catalog.GetSchemaByID(txn, t.Schema)
+	if err != nil {
+		return nil, err
+	}
+	rel := &Relation{ID: t.ID, Schema: schema.Name, Name: t.Name}
+	keys := make(map[int]bool)
+	for _, ord := range t.ColumnOrdinals(t.PrimaryIndex) {
+		keys[ord] = true
+		rel.FullIdentity = rel.FullIdentity || t.Columns[ord].Hidden
+	}
+	for i, c := range t.Columns {
+		if !c.Hidden {
+			rel.Columns = append(rel.Columns, RelationColumn{Name: c.Name, Type: c.Type, Key: rel.FullIdentity || keys[i]})
+		}
+	}
+	return rel, nil
+}
+
+func newChange(ch exec.RowChange, rel *Relation, i int) *Change {
+	c := &Change{Relation: i, Old: rowText(ch.Table, ch.Old), New: rowText(ch.Table, ch.New)}
+	switch {
+	case ch.Old == nil && ch.New == nil:
+		c.Kind, c.RestartIdentity = 'T', ch.RestartIdentity
+	case ch.Old == nil:
+		c.Kind = 'I'
+	case ch.New == nil:
+		c.Kind = 'D'
+	default:
+		c.Kind = 'U'
+	}
+	if c.Old == nil || rel.FullIdentity {
+		return c
+	}
+	// Without FullIdentity, the old row is identified by its key, and an
+	// update sends it only if the key changed.
+	changedKey := false
+	for j, col := range rel.Columns {
+		if !col.Key {
+			c.Old[j] = nil
+		} else if c.New != nil && !equalText(c.Old[j], c.New[j]) {
+			changedKey = true
+		}
+	}
+	if c.Kind == 'U' && !changedKey {
+		c.Old = nil
+	}
+	return c
+}
+
+// rowText returns the text of the values of the visible columns of row of
+// t, or nil if row is.
+func rowText(t *catalog.Table, row []types.Datum) []*string {
+	if row == nil {
+		return nil
+	}
+	var out []*string
+	for i, c := range t.Columns {
+		if c.Hidden {
+			continue
+		}
+		if row[i] == types.DNull {
+			out = append(out, nil)
+			continue
+		}
+		s := row[i].String()
+		out = append(out, &s)
+	}
+	return out
+}
+
+func equalText(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// checkReplication returns an error unless the session may manage and
+// stream from replication slots, which only superusers may, and not on a
+// read-only server, whose slots are those of its primary.
+func (s *Session) checkReplication() error {
+	if s.user != "" && !s.superuser {
+		return pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to use replication slots").
+			WithDetail("Only superusers may use replication slots.")
+	}
+	if s.server.readOnly {
+		return pgerror.New(pgerror.CodeReadOnlySQLTransaction, "cannot use replication slots on a read-only server")
+	}
+	return nil
+}
+
+// checkSlotName returns an error unless name is a valid replication slot
+// name: lower case letters, digits and underscores, as in PostgreSQL.
+func checkSlotName(name string) error {
+	if name == "" {
+		return pgerror.New(pgerror.CodeInvalidName, "replication slot name \"\" is too short")
+	}
+	if len(name) > maxSlotName {
+		return pgerror.Newf(pgerror.CodeInvalidName, "replication slot name %q is too long", name)
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return pgerror.Newf(pgerror.CodeInvalidName, "replication slot name %q contains invalid character", name).
+				WithHint("Replication slot names may only contain lower case letters, numbers, and the underscore character.")
+		}
+	}
+	return nil
+}
+
+// CreateReplicationSlot creates a logical replication slot of the
+// session's database named name, whose changes are sent with plugin, which
+// must be Plugin. It returns the LSN the changes of the slot start at:
+// those of the transactions that commit from then on. Transactions of the
+// database that began before it do not record their changes, and it
+// waits for them to end first, until ctx is done.
+func (s *Session) CreateReplicationSlot(ctx context.Context, name, plugin string) (uint64, error) {
+	if err := s.checkReplication(); err != nil {
+		return 0, err
+	}
+	if err := checkSlotName(name); err != nil {
+		return 0, err
+	}
+	if plugin != Plugin {
+		return 0, pgerror.Newf(pgerror.CodeFeatureNotSupported, "output plugin %q is not supported", plugin).
+			WithHint(fmt.Sprintf("The only output plugin is %q.", Plugin))
+	}
+	r := &s.server.replication
+	db := s.database.ID
+	r.mu.Lock()
+	if err := r.load(s.server.engine); err != nil {
+		r.mu.Unlock()
+		return 0, err
+	}
+	// The transactions that begin from now on record their changes.
+	r.slots[db]++
+	r.mu.Unlock()
+	lsn, err := s.createSlot(ctx, name, plugin)
+	if err != nil {
+		r.mu.Lock()
+		r.slots[db]--
+		r.mu.Unlock()
+	}
+	return lsn, err
+}
+
+// createSlot waits for the transactions of the session's database that
+// do not record their changes to end, and then stores the slot starting
+// at the end of the log.
+func (s *Session) createSlot(ctx context.Context, name, plugin string) (uint64, error) {
+	r := &s.server.replication
+	for {
+		r.mu.Lock()
+		if r.uncaptured[s.database.ID] == 0 {
+			break
+		}
+		wake := r.wake
+		r.mu.Unlock()
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	defer r.mu.Unlock()
+	slot := &catalog.ReplicationSlot{Name: name, Database: s.database.ID, Plugin: plugin, ConfirmedFlush: r.next}
+	err := engine.RunTxn(s.server.engine, func(txn engine.Txn) error {
+		return catalog.CreateReplicationSlot(txn, slot)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return slot.ConfirmedFlush, nil
+}
+
+// DropReplicationSlot drops the replication slot named name, which no
+// session may be streaming from, and the records of the log only it kept.
+func (s *Session) DropReplicationSlot(name string) error {
+	if err := s.checkReplication(); err != nil {
+		return err
+	}
+	r := &s.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(s.server.engine); err != nil {
+		return err
+	}
+	if pid, ok := r.active[name]; ok {
+		return pgerror.Newf(pgerror.CodeObjectInUse, "replication slot %q is active for PID %d", name, pid)
+	}
+	var slot *catalog.ReplicationSlot
+	err := engine.RunTxn(s.server.engine, func(txn engine.Txn) (err error) {
+		if slot, err = catalog.MustLookupReplicationSlot(txn, name); err != nil {
+			return err
+		}
+		if err := catalog.DropReplicationSlot(txn, slot); err != nil {
+			return err
+		}
+		return trimLog(txn)
+	})
+	if err != nil {
+		return err
+	}
+	r.slots[slot.Database]--
+	return nil
+}
+
+// trimLog deletes the records of the log every slot has confirmed, or
+// every record if there are no slots.
+func trimLog(txn engine.Txn) error {
+	slots, err := catalog.ListReplicationSlots(txn)
+	if err != nil {
+		return err
+	}
+	end := catalog.ReplicationLogEnd()
+	if len(slots) > 0 {
+		lsn := slots[0].ConfirmedFlush
+		for _, s := range slots[1:] {
+			lsn = min(lsn, s.ConfirmedFlush)
+		}
+		end = catalog.ReplicationLogKey(lsn)
+	}
+	return engine.DeleteRange(txn, catalog.ReplicationLogKey(0), end)
+}
+
+// CurrentLSN returns the LSN at the end of the replication log, which the
+// next transaction to record its changes is written at.
+func (s *Session) CurrentLSN() (uint64, error) {
+	r := &s.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(s.server.engine); err != nil {
+		return 0, err
+	}
+	return r.next, nil
+}
+
+// replicationSlots lists the replication slots of the server for
+// pg_replication_slots.
+func (s *Server) replicationSlots() ([]vtable.ReplicationSlot, error) {
+	txn, err := s.engine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+	slots, err := catalog.ListReplicationSlots(txn)
+	if err != nil || len(slots) == 0 {
+		return nil, err
+	}
+	dbs, err := catalog.ListDatabases(txn)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[catalog.ID]string, len(dbs))
+	for _, db := range dbs {
+		names[db.ID] = db.Name
+	}
+	r := &s.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]vtable.ReplicationSlot, len(slots))
+	for i, slot := range slots {
+		out[i] = vtable.ReplicationSlot{ReplicationSlot: slot, DatabaseName: names[slot.Database], ActivePID: r.active[slot.Name]}
+	}
+	return out, nil
+}
+
+// ReplicationStream streams the transactions of a logical replication
+// slot; see Session.StartReplication.
+type ReplicationStream struct {
+	s    *Session
+	slot *catalog.ReplicationSlot
+	// publications are the names of the publications whose tables'
+	// changes are sent.
+	publications []string
+	// pos is the LSN of the next record to read.
+	pos uint64
+
+	// mu guards confirmed, the LSN the client last confirmed it flushed.
+	mu        sync.Mutex
+	confirmed uint64
+}
+
+// StartReplication starts streaming the transactions of the replication
+// slot named name, which must be of the session's database and not be
+// streamed from by another session, from start, or from the position the
+// client last confirmed if it is later. Only the changes of the tables of
+// the publications named are sent. The stream must be closed.
+func (s *Session) StartReplication(name string, start uint64, publications []string) (*ReplicationStream, error) {
+	if err := s.checkReplication(); err != nil {
+		return nil, err
+	}
+	r := &s.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.load(s.server.engine); err != nil {
+		return nil, err
+	}
+	txn, err := s.engine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+	slot, err := catalog.MustLookupReplicationSlot(txn, name)
+	if err != nil {
+		return nil, err
+	}
+	if slot.Database != s.database.ID {
+		return nil, pgerror.Newf(pgerror.CodeObjectNotInPrerequisite, "replication slot %q was not created in this database", name)
+	}
+	if pid, ok := r.active[name]; ok {
+		return nil, pgerror.Newf(pgerror.CodeObjectInUse, "replication slot %q is active for PID %d", name, pid)
+	}
+	for _, p := range publications {
+		if _, err := catalog.MustLookupPublication(txn, p); err != nil {
+			return nil, err
+		}
+	}
+	r.active[name] = s.pid
+	return &ReplicationStream{s: s, slot: slot, publications: publications, pos: max(start, slot.ConfirmedFlush), confirmed: slot.ConfirmedFlush}, nil
+}
+
+// Position returns the LSN the stream has read up to: that of the next
+// transaction it returns, or which it skips as it publishes no changes.
+func (st *ReplicationStream) Position() uint64 {
+	return st.pos
+}
+
+// Next returns the next transaction with changes to the tables of the
+// publications, with only those changes, waiting for one to commit until
+// ctx is done.
+func (st *ReplicationStream) Next(ctx context.Context) (*ReplicatedTxn, error) {
+	r := &st.s.server.replication
+	for {
+		r.mu.Lock()
+		next, wake := r.next, r.wake
+		r.mu.Unlock()
+		for st.pos < next {
+			rec, err := st.read(next)
+			if err != nil || rec != nil {
+				return rec, err
+			}
+		}
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// read reads the first record of the log from the stream's position and
+// before end, and advances past it. It returns the record filtered by the
+// publications, or nil if it has no changes they publish.
+func (st *ReplicationStream) read(end uint64) (*ReplicatedTxn, error) {
+	txn, err := st.s.engine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+	it, err := txn.Scan(catalog.ReplicationLogKey(st.pos), catalog.ReplicationLogKey(end))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	k, v, err := it.Next()
+	if errors.Is(err, engine.ErrNotFound) {
+		st.pos = end
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec := &ReplicatedTxn{LSN: catalog.ReplicationLogLSN(k)}
+	st.pos = rec.LSN + 1
+	if err := json.Unmarshal(v, rec); err != nil {
+		return nil, fmt.Errorf("corrupt replication log record %s: %w", catalog.FormatLSN(rec.LSN), err)
+	}
+	if rec.Database != st.slot.Database {
+		return nil, nil
+	}
+	return st.filter(txn, rec)
+}
+
+// filter drops the changes of rec the publications do not publish, as of
+// the catalog txn reads, returning nil if none is left.
+func (st *ReplicationStream) filter(txn engine.Reader, rec *ReplicatedTxn) (*ReplicatedTxn, error) {
+	var pubs []*catalog.Publication
+	for _, name := range st.publications {
+		p, err := catalog.MustLookupPublication(txn, name)
+		if err != nil {
+			return nil, err
+		}
+		pubs = append(pubs, p)
+	}
+	publishes := func(ch *Change) bool {
+		id := rec.Relations[ch.Relation].ID
+		for _, p := range pubs {
+			if !p.Publishes(id) {
+				continue
+			}
+			switch ch.Kind {
+			case 'I':
+				if p.Insert {
+					return true
+				}
+			case 'U':
+				if p.Update {
+					return true
+				}
+			case 'D':
+				if p.Delete {
+					return true
+				}
+			case 'T':
+				if p.Truncate {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var changes []*Change
+	for _, ch := range rec.Changes {
+		if publishes(ch) {
+			changes = append(changes, ch)
+		}
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	rec.Changes = changes
+	return rec, nil
+}
+
+// Confirm records that the client flushed the changes before lsn, which
+// streaming from the slot restarts at, and deletes the records of the log
+// no slot needs any more. It may be called concurrently with Next.
+func (st *ReplicationStream) Confirm(lsn uint64) error {
+	r := &st.s.server.replication
+	r.mu.Lock()
+	lsn = min(lsn, r.next)
+	r.mu.Unlock()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if lsn <= st.confirmed {
+		return nil
+	}
+	err := engine.RunTxn(st.s.server.engine, func(txn engine.Txn) error {
+		slot, err := catalog.MustLookupReplicationSlot(txn, st.slot.Name)
+		if err != nil {
+			return err
+		}
+		slot.ConfirmedFlush = lsn
+		if err := catalog.WriteReplicationSlot(txn, slot); err != nil {
+			return err
+		}
+		return trimLog(txn)
+	})
+	if errors.Is(err, engine.ErrConflict) {
+		// Another slot trimmed the log at the same time: the next
+		// confirmation is recorded instead.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st.confirmed = lsn
+	return nil
+}
+
+// Close stops streaming from the slot, which another session may then
+// stream from.
+func (st *ReplicationStream) Close() {
+	r := &st.s.server.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.active, st.slot.Name)
+}
+
+// dropReplicationSlot is pg_drop_replication_slot(name), which drops a
+// replication slot as DROP_REPLICATION_SLOT does.
+func dropReplicationSlot(b *backends) *eval.Overload {
+	return &eval.Overload{Params: []*types.T{types.String}, ReturnType: types.Void, Volatility: eval.Volatile,
+		Fn: func(ctx *eval.Context, args []types.Datum) (types.Datum, error) {
+			if err := b.get(ctx.BackendPID).DropReplicationSlot(string(args[0].(types.DString))); err != nil {
+				return nil, err
+			}
+			return types.DNull, nil
+		}}
+}
+
+// currentLSN is pg_current_wal_lsn(), the LSN at the end of the
+// replication log, as text.
+func currentLSN(b *backends) *eval.Overload {
+	return &eval.Overload{ReturnType: types.String, Volatility: eval.Volatile,
+		Fn: func(ctx *eval.Context, _ []types.Datum) (types.Datum, error) {
+			lsn, err := b.get(ctx.BackendPID).CurrentLSN()
+			if err != nil {
+				return nil, err
+			}
+			return types.DString(catalog.FormatLSN(lsn)), nil
+		}}
+}
+
+// SubscribeChanges streams the changes of the transactions the server's
+// engine commits after sequence number from, those of every database, for
+// a read replica to apply; see engine.ChangeFeed. Only superusers may, and
+// only if the engine keeps its changes.
+func (s *Session) SubscribeChanges(ctx context.Context, from uint64) (<-chan engine.CommittedTxn, error) {
+	if s.user != "" && !s.superuser {
+		return nil, pgerror.New(pgerror.CodeInsufficientPrivilege, "permission denied to stream changes").
+			WithDetail("Only superusers may stream the changes of the storage engine.")
+	}
+	f, ok := s.server.engine.(engine.ChangeFeed)
+	if !ok {
+		return nil, errNoChangeFeed()
+	}
+	ch, err := f.SubscribeChanges(ctx, from)
+	if errors.Is(err, engine.ErrUnsupported) {
+		return nil, errNoChangeFeed()
+	}
+	return ch, err
+}
+
+func errNoChangeFeed() error {
+	return pgerror.New(pgerror.CodeFeatureNotSupported, "the storage engine does not keep its changes for replicas")
+}
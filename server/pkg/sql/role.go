@@ -0,0 +1,134 @@
+package sql
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/scram"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+)
+
+// login checks that clients may connect as user to database, and returns
+// its role and the database. In an engine without roles, it creates user
+// as a superuser, as initdb creates the role of the user that runs it,
+// unless the server is read-only.
+func (s *Server) login(user, database string) (*catalog.Role, *catalog.Database, error) {
+	var role *catalog.Role
+	var db *catalog.Database
+	err := engine.RunTxn(s.engine, func(txn engine.Txn) error {
+		var err error
+		if role, err = catalog.LookupRole(txn, user); err != nil {
+			return err
+		}
+		if role != nil {
+			db, err = catalog.MustLookupDatabase(txn, database)
+			return err
+		}
+		exist, err := catalog.HasRoles(txn)
+		if err != nil {
+			return err
+		}
+		if exist || s.readOnly {
+			return pgerror.Newf(pgerror.CodeInvalidAuthorization, "role %q does not exist", user)
+		}
+		role = &catalog.Role{Name: user, Superuser: true, CreateDB: true, CreateRole: true, Inherit: true, Login: true}
+		if err := catalog.CreateRole(txn, role); err != nil {
+			return err
+		}
+		if db, err = catalog.MustLookupDatabase(txn, database); err != nil {
+			return err
+		}
+		s.logger.Info("created bootstrap superuser", "role", user)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !role.Login {
+		return nil, nil, pgerror.Newf(pgerror.CodeInvalidAuthorization, "role %q is not permitted to log in", user)
+	}
+	return role, db, nil
+}
+
+// roleSettingSources are the sources of the role settings a session
+// starts with, in the order they are applied, by the database and role
+// they are for.
+var roleSettingSources = []struct {
+	allDatabases, allRoles bool
+	source                 string
+}{
+	{true, true, "global"},
+	{false, true, "database"},
+	{true, false, "user"},
+	{false, false, "database user"},
+}
+
+// applyRoleSettings sets the session variables to the defaults ALTER ROLE
+// ... SET pinned for role in the session's database. A value no longer
+// valid is logged and skipped, as it would otherwise keep the role from
+// connecting.
+func (s *Session) applyRoleSettings(role *catalog.Role) error {
+	txn, err := s.server.engine.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Abort()
+	for _, src := range roleSettingSources {
+		db, r := s.database.ID, role.ID
+		if src.allDatabases {
+			db = catalog.AllDatabases
+		}
+		if src.allRoles {
+			r = catalog.AllRoles
+		}
+		settings, err := catalog.GetRoleSettings(txn, db, r)
+		if err != nil {
+			return err
+		}
+		if settings == nil {
+			continue
+		}
+		for _, name := range slices.Sorted(maps.Keys(settings.Settings)) {
+			v, err := s.checkSetting(name, []string{settings.Settings[name]})
+			if err != nil {
+				s.server.logger.Warn("ignoring role setting", "role", role.Name, "database", s.database.Name,
+					"setting", name, "err", err)
+				continue
+			}
+			s.setDefault(name, varValue{value: v, source: src.source})
+		}
+	}
+	return nil
+}
+
+// PasswordVerifier returns the verifier of the password of user, or nil if
+// there is no such role or it has no password.
+func (s *Server) PasswordVerifier(user string) (*scram.Verifier, error) {
+	txn, err := s.engine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Abort()
+	role, err := catalog.LookupRole(txn, user)
+	if err != nil || role == nil || role.Password == "" {
+		return nil, err
+	}
+	return scram.ParseVerifier(role.Password)
+}
+
+// Superuser reports whether the session's role was a superuser as the
+// session started. Sessions of the embedder are.
+func (s *Session) Superuser() bool {
+	return s.user == "" || s.superuser
+}
+
+// planner returns a planner of statements of the session in txn.
+func (s *Session) planner(txn engine.Reader) *planner.Planner {
+	p := planner.New(txn, s.server.registry)
+	p.User, p.SearchPath, p.Database = s.user, s.searchPath(), s.database.Name
+	p.CheckSetting = s.checkSetting
+	return p
+}
@@ -0,0 +1,70 @@
+package sql_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// refused reports whether err is the error of Connect refusing a session
+// with code.
+func refused(err error, code string) bool {
+	var pgErr *pgerror.Error
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+func TestRoleConnectionLimit(t *testing.T) {
+	srv := sql.NewServer(memory.New())
+	admin := connect(t, srv)
+	exec(t, admin, "CREATE ROLE r LOGIN CONNECTION LIMIT 1")
+	res := exec(t, admin, "SELECT rolconnlimit FROM pg_roles WHERE rolname = 'r'")
+	if got := fmt.Sprint(res[0].Rows); got != "[[1]]" {
+		t.Errorf("rolconnlimit %s, want 1", got)
+	}
+
+	s, err := srv.Connect("r", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Connect("r", ""); !refused(err, pgerror.CodeTooManyConnections) {
+		t.Fatalf("a second session past the limit: %v, want too_many_connections", err)
+	}
+
+	// The sessions of a role count towards its limit once it is renamed.
+	exec(t, admin, "ALTER ROLE r RENAME TO r2")
+	if _, err := srv.Connect("r2", ""); !refused(err, pgerror.CodeTooManyConnections) {
+		t.Fatalf("a session of the renamed role: %v, want too_many_connections", err)
+	}
+
+	// Closing a session makes room for another.
+	s.Close()
+	s, err = srv.Connect("r2", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	exec(t, admin, "ALTER ROLE r2 CONNECTION LIMIT -1")
+	s2, err := srv.Connect("r2", "")
+	if err != nil {
+		t.Fatalf("a session of a role without a limit: %v", err)
+	}
+	s2.Close()
+	res = exec(t, admin, "SELECT rolconnlimit FROM pg_roles WHERE rolname = 'r2'")
+	if got := fmt.Sprint(res[0].Rows); got != "[[-1]]" {
+		t.Errorf("rolconnlimit %s, want -1", got)
+	}
+
+	// A limit of 0 refuses every session.
+	exec(t, admin, "ALTER ROLE r2 CONNECTION LIMIT 0")
+	if _, err := srv.Connect("r2", ""); !refused(err, pgerror.CodeTooManyConnections) {
+		t.Fatalf("a session of a role limited to none: %v, want too_many_connections", err)
+	}
+	if _, err := admin.Exec("ALTER ROLE r2 CONNECTION LIMIT -2"); !refused(err, pgerror.CodeInvalidParameterValue) {
+		t.Errorf("a limit of -2: %v, want invalid_parameter_value", err)
+	}
+}
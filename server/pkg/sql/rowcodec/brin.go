@@ -0,0 +1,120 @@
+package rowcodec
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// A BRIN index has an entry for each range of consecutive rows in primary
+// key order, keyed by the primary key columns of the range's last row, so
+// the first entry at or after a row's primary key is that of its range.
+// Its value summarizes the values of the index's columns in the range.
+// Rows after the last range belong to no range until enough of them are
+// summarized together; the entry at the index prefix itself holds the
+// primary key columns of the last summarized row.
+//
+// A summary only ever widens: deleting a row or changing its values away
+// leaves it covering values no row has, which costs a scan of the range
+// but never skips a row.
+
+// RangeSummary summarizes the values of each column of a BRIN index in a
+// range of rows.
+type RangeSummary []ColumnSummary
+
+// ColumnSummary summarizes the values of a column in a range of rows. Min
+// and Max are nil if every value is NULL.
+type ColumnSummary struct {
+	Min, Max types.Datum
+	HasNull  bool
+}
+
+// Summary flags.
+const (
+	summaryHasNull   byte = 0x01
+	summaryHasValues byte = 0x02
+)
+
+var errTruncatedSummary = fmt.Errorf("rowcodec: truncated range summary")
+
+// Add widens s to cover the values of row, reporting whether it changed.
+func (s RangeSummary) Add(t *catalog.Table, idx *catalog.Index, row []types.Datum) bool {
+	changed := false
+	for i, ord := range t.ColumnOrdinals(idx) {
+		c, d := &s[i], row[ord]
+		switch {
+		case d == types.DNull:
+			changed = changed || !c.HasNull
+			c.HasNull = true
+		case c.Min == nil:
+			c.Min, c.Max, changed = d, d, true
+		case types.CompareDatums(d, c.Min) < 0:
+			c.Min, changed = d, true
+		case types.CompareDatums(d, c.Max) > 0:
+			c.Max, changed = d, true
+		}
+	}
+	return changed
+}
+
+// EncodeRangeSummary returns the value of the entry of a range with
+// summary s in the BRIN index idx.
+func EncodeRangeSummary(t *catalog.Table, idx *catalog.Index, s RangeSummary) ([]byte, error) {
+	var buf []byte
+	for i, ord := range t.ColumnOrdinals(idx) {
+		c := s[i]
+		var flags byte
+		if c.HasNull {
+			flags |= summaryHasNull
+		}
+		if c.Min != nil {
+			flags |= summaryHasValues
+		}
+		buf = append(buf, flags)
+		if c.Min == nil {
+			continue
+		}
+		for _, d := range []types.Datum{c.Min, c.Max} {
+			payload, err := encodeValue(nil, t.Columns[ord].Type, d)
+			if err != nil {
+				return nil, err
+			}
+			buf = binary.AppendUvarint(buf, uint64(len(payload)))
+			buf = append(buf, payload...)
+		}
+	}
+	return buf, nil
+}
+
+// DecodeRangeSummary decodes the value of an entry of the BRIN index idx.
+func DecodeRangeSummary(t *catalog.Table, idx *catalog.Index, buf []byte) (RangeSummary, error) {
+	ords := t.ColumnOrdinals(idx)
+	s := make(RangeSummary, len(ords))
+	for i, ord := range ords {
+		if len(buf) == 0 {
+			return nil, errTruncatedSummary
+		}
+		flags := buf[0]
+		buf = buf[1:]
+		s[i].HasNull = flags&summaryHasNull != 0
+		if flags&summaryHasValues == 0 {
+			continue
+		}
+		var bounds [2]types.Datum
+		for j := range bounds {
+			n, w := binary.Uvarint(buf)
+			if w <= 0 || uint64(len(buf)-w) < n {
+				return nil, errTruncatedSummary
+			}
+			d, err := decodeValue(buf[w:w+int(n)], t.Columns[ord].Type)
+			if err != nil {
+				return nil, err
+			}
+			bounds[j], buf = d, buf[w+int(n):]
+		}
+		s[i].Min, s[i].Max = bounds[0], bounds[1]
+	}
+	return s, nil
+}
@@ -0,0 +1,631 @@
+// Package rowcodec encodes SQL rows as key-value pairs.
+//
+// Index keys use an order-preserving encoding: comparing two encoded keys
+// bytewise gives the same result as comparing the datums they encode, with
+// NULL sorting after every value as in PostgreSQL's default ordering. No
+// encoded datum is a prefix of another, so the complement of each byte of
+// the encoding sorts in the reverse order, which keys the columns of
+// descending indexes with NULL first. Row
+// values store each non-key column tagged with its column ID, so rows
+// written under an older table descriptor remain decodable.
+package rowcodec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"net/netip"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Markers that start every encoded datum in a key.
+const (
+	markerValue byte = 0x01
+	markerNull  byte = 0x02
+)
+
+// String and bytes values escape 0x00 as 0x00 0xff and end with 0x00 0x01,
+// which keeps shorter strings ordered before their extensions.
+const (
+	escapeByte  byte = 0x00
+	escapedZero byte = 0xff
+	terminator  byte = 0x01
+)
+
+// EncodeKey appends the order-preserving encoding of d, whose type is t,
+// to buf.
+func EncodeKey(buf []byte, t *types.T, d types.Datum) ([]byte, error) {
+	if d == types.DNull {
+		return append(buf, markerNull), nil
+	}
+	buf = append(buf, markerValue)
+	return encodeKeyPayload(buf, t, d)
+}
+
+// EncodeKeyDir is EncodeKey for an index column, whose keys are inverted
+// if desc is set.
+func EncodeKeyDir(buf []byte, t *types.T, d types.Datum, desc bool) ([]byte, error) {
+	start := len(buf)
+	buf, err := EncodeKey(buf, t, d)
+	if err != nil || !desc {
+		return buf, err
+	}
+	return Invert(buf, start), nil
+}
+
+// Invert complements the bytes of buf from start on, turning the encoding
+// of a datum, or a prefix of one, into its descending form and back.
+func Invert(buf []byte, start int) []byte {
+	for i := start; i < len(buf); i++ {
+		buf[i] = ^buf[i]
+	}
+	return buf
+}
+
+// EncodeStringPrefix appends the encoding of every string starting with
+// prefix, without the terminator. Keys of strings with that prefix all
+// start with the result.
+func EncodeStringPrefix(buf []byte, prefix string) []byte {
+	return appendEscaped(append(buf, markerValue), prefix)
+}
+
+// EncodeNull appends the encoding of NULL.
+func EncodeNull(buf []byte) []byte {
+	return append(buf, markerNull)
+}
+
+func encodeKeyPayload(buf []byte, t *types.T, d types.Datum) ([]byte, error) {
+	switch t.Family {
+	case types.BoolFamily:
+		if d.(types.DBool) {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case types.IntFamily:
+		return appendInt(buf, int64(d.(types.DInt))), nil
+	case types.DateFamily:
+		return appendInt(buf, int64(d.(types.DDate))), nil
+	case types.FloatFamily:
+		return appendFloat(buf, float64(d.(types.DFloat))), nil
+	case types.DecimalFamily:
+		return appendDecimal(buf, &d.(*types.DDecimal).Dec), nil
+	case types.StringFamily:
+		return append(appendEscaped(buf, string(d.(types.DString))), escapeByte, terminator), nil
+	case types.XMLFamily:
+		return append(appendEscaped(buf, string(d.(types.DXML))), escapeByte, terminator), nil
+	case types.JSONBFamily:
+		return append(appendEscaped(buf, string(d.(types.DJSONB))), escapeByte, terminator), nil
+	case types.CITextFamily:
+		// Keyed by the lower-case form, so strings differing only in case
+		// collide; the value keeps the string as written.
+		return append(appendEscaped(buf, d.(types.DCIText).Folded()), escapeByte, terminator), nil
+	case types.BytesFamily:
+		return append(appendEscaped(buf, string(d.(types.DBytes))), escapeByte, terminator), nil
+	case types.TimestampFamily:
+		return appendInt(buf, d.(types.DTimestamp).UnixMicro()), nil
+	case types.TimestampTZFamily:
+		return appendInt(buf, d.(types.DTimestampTZ).UnixMicro()), nil
+	case types.IntervalFamily:
+		// Order by the normalized length first, then keep the fields so the
+		// exact value can be decoded.
+		iv := d.(types.DInterval)
+		norm := (iv.Months*30+iv.Days)*microsPerDay + iv.Micros
+		buf = appendInt(buf, norm)
+		buf = appendInt(buf, iv.Months)
+		return appendInt(buf, iv.Days), nil
+	case types.VectorFamily:
+		// Each element follows a marker byte and the vector ends with a
+		// lower one, so a vector sorts before its extensions.
+		for _, f := range d.(*types.DVector).Elems {
+			buf = appendFloat(append(buf, markerValue), float64(f))
+		}
+		return append(buf, escapeByte), nil
+	case types.InetFamily:
+		// The family, the network address, the prefix length and the
+		// whole address, which sorts as DInet.Compare does.
+		ip := d.(*types.DInet)
+		family := byte(4)
+		if !ip.Addr().Is4() {
+			family = 6
+		}
+		buf = append(buf, family)
+		buf = append(buf, ip.Network().AsSlice()...)
+		buf = append(buf, byte(ip.Bits()))
+		return append(buf, ip.Addr().AsSlice()...), nil
+	case types.MacaddrFamily:
+		mac := d.(types.DMacaddr)
+		return append(buf, mac[:]...), nil
+	case types.MoneyFamily:
+		return appendInt(buf, int64(d.(types.DMoney))), nil
+	case types.BitFamily:
+		// The packed bits, then the length, which breaks ties between
+		// strings that differ only in trailing zeros.
+		b := d.(*types.DBitString)
+		buf = append(appendEscaped(buf, string(b.Bytes)), escapeByte, terminator)
+		return appendInt(buf, int64(b.Len)), nil
+	case types.HstoreFamily:
+		// The number of pairs, then each key and its value or NULL, in
+		// the order DHstore.Compare compares them.
+		h := d.(*types.DHstore)
+		buf = appendInt(buf, int64(len(h.Pairs)))
+		for _, p := range h.Pairs {
+			buf = append(appendEscaped(buf, p.Key), escapeByte, terminator)
+			if p.Null {
+				buf = append(buf, markerNull)
+				continue
+			}
+			buf = append(appendEscaped(append(buf, markerValue), p.Value), escapeByte, terminator)
+		}
+		return buf, nil
+	case types.RangeFamily:
+		return appendRange(buf, d.(*types.DRange), encodeKeyPayload)
+	case types.MultirangeFamily:
+		// Each range after a 0x01, then 0x00, so that a multirange sorts
+		// before its extensions.
+		for _, r := range d.(*types.DMultirange).Ranges {
+			var err error
+			if buf, err = appendRange(append(buf, 0x01), r, encodeKeyPayload); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, 0x00), nil
+	}
+	if def := t.Extension(); def != nil {
+		// Registered types are keyed by their binary form, which preserves
+		// equality but not order.
+		return append(appendEscaped(buf, string(def.Send(d))), escapeByte, terminator), nil
+	}
+	return nil, fmt.Errorf("rowcodec: cannot encode type %s", t)
+}
+
+// DecodeKey decodes a datum of type t from the front of buf and returns the
+// remaining bytes.
+func DecodeKey(buf []byte, t *types.T) (types.Datum, []byte, error) {
+	if len(buf) == 0 {
+		return nil, nil, errTruncated
+	}
+	switch buf[0] {
+	case markerNull:
+		return types.DNull, buf[1:], nil
+	case markerValue:
+		return decodeKeyPayload(buf[1:], t)
+	}
+	return nil, nil, fmt.Errorf("rowcodec: invalid key marker %#x", buf[0])
+}
+
+// DecodeKeyDir is DecodeKey for an index column whose keys are inverted if
+// desc is set.
+func DecodeKeyDir(buf []byte, t *types.T, desc bool) (types.Datum, []byte, error) {
+	if !desc {
+		return DecodeKey(buf, t)
+	}
+	// The length of the encoding is only known once it is decoded.
+	d, rest, err := DecodeKey(Invert(bytes.Clone(buf), 0), t)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d, buf[len(buf)-len(rest):], nil
+}
+
+var errTruncated = fmt.Errorf("rowcodec: truncated key")
+
+const microsPerDay = 24 * int64(time.Hour/time.Microsecond)
+
+func decodeKeyPayload(buf []byte, t *types.T) (types.Datum, []byte, error) {
+	switch t.Family {
+	case types.BoolFamily:
+		if len(buf) < 1 {
+			return nil, nil, errTruncated
+		}
+		return types.MakeDBool(buf[0] == 1), buf[1:], nil
+	case types.IntFamily, types.DateFamily, types.TimestampFamily, types.TimestampTZFamily:
+		i, rest, err := decodeInt(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t.Family {
+		case types.IntFamily:
+			return types.DInt(i), rest, nil
+		case types.DateFamily:
+			return types.DDate(i), rest, nil
+		case types.TimestampFamily:
+			return types.MakeDTimestamp(time.UnixMicro(i).UTC()), rest, nil
+		}
+		return types.MakeDTimestampTZ(time.UnixMicro(i).UTC()), rest, nil
+	case types.FloatFamily:
+		if len(buf) < 8 {
+			return nil, nil, errTruncated
+		}
+		return types.DFloat(decodeFloat(binary.BigEndian.Uint64(buf))), buf[8:], nil
+	case types.DecimalFamily:
+		return decodeDecimal(buf)
+	case types.StringFamily, types.BytesFamily, types.CITextFamily, types.XMLFamily, types.JSONBFamily:
+		s, rest, err := decodeEscaped(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t.Family {
+		case types.BytesFamily:
+			return types.DBytes(s), rest, nil
+		case types.XMLFamily:
+			return types.DXML(s), rest, nil
+		case types.JSONBFamily:
+			return types.DJSONB(s), rest, nil
+		case types.CITextFamily:
+			return types.DCIText(s), rest, nil
+		}
+		return types.DString(s), rest, nil
+	case types.IntervalFamily:
+		if len(buf) < 24 {
+			return nil, nil, errTruncated
+		}
+		norm, _, _ := decodeInt(buf)
+		months, _, _ := decodeInt(buf[8:])
+		days, _, _ := decodeInt(buf[16:])
+		micros := norm - (months*30+days)*microsPerDay
+		return types.DInterval{Months: months, Days: days, Micros: micros}, buf[24:], nil
+	case types.VectorFamily:
+		var elems []float32
+		for {
+			if len(buf) == 0 {
+				return nil, nil, errTruncated
+			}
+			if buf[0] == escapeByte {
+				return types.NewDVector(elems), buf[1:], nil
+			}
+			if len(buf) < 9 {
+				return nil, nil, errTruncated
+			}
+			elems = append(elems, float32(decodeFloat(binary.BigEndian.Uint64(buf[1:]))))
+			buf = buf[9:]
+		}
+	case types.InetFamily:
+		if len(buf) < 1 {
+			return nil, nil, errTruncated
+		}
+		size := 4
+		if buf[0] == 6 {
+			size = 16
+		}
+		if len(buf) < 2+2*size {
+			return nil, nil, errTruncated
+		}
+		addr, _ := netip.AddrFromSlice(buf[2+size : 2+2*size])
+		ip := &types.DInet{Prefix: netip.PrefixFrom(addr, int(buf[1+size])), Cidr: t.Oid == types.OidCidr}
+		return ip, buf[2+2*size:], nil
+	case types.MacaddrFamily:
+		var mac types.DMacaddr
+		if len(buf) < len(mac) {
+			return nil, nil, errTruncated
+		}
+		copy(mac[:], buf)
+		return mac, buf[len(mac):], nil
+	case types.MoneyFamily:
+		i, rest, err := decodeInt(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		return types.DMoney(i), rest, nil
+	case types.BitFamily:
+		bits, rest, err := decodeEscaped(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		n, rest, err := decodeInt(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &types.DBitString{Bytes: []byte(bits), Len: int(n)}, rest, nil
+	case types.HstoreFamily:
+		n, buf, err := decodeInt(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		if n < 0 || n > int64(len(buf)) {
+			return nil, nil, errTruncated
+		}
+		pairs := make([]types.HstorePair, n)
+		for i := range pairs {
+			p := &pairs[i]
+			if p.Key, buf, err = decodeEscaped(buf); err != nil {
+				return nil, nil, err
+			}
+			if len(buf) == 0 {
+				return nil, nil, errTruncated
+			}
+			if buf[0] == markerNull {
+				p.Null, buf = true, buf[1:]
+				continue
+			}
+			if p.Value, buf, err = decodeEscaped(buf[1:]); err != nil {
+				return nil, nil, err
+			}
+		}
+		return &types.DHstore{Pairs: pairs}, buf, nil
+	case types.RangeFamily:
+		return decodeRange(buf, t, decodeKeyPayload)
+	case types.MultirangeFamily:
+		m := &types.DMultirange{Typ: t}
+		for {
+			if len(buf) == 0 {
+				return nil, nil, errTruncated
+			}
+			if buf[0] == 0x00 {
+				return m, buf[1:], nil
+			}
+			r, rest, err := decodeRange(buf[1:], types.RangeOf(t.RangeContents), decodeKeyPayload)
+			if err != nil {
+				return nil, nil, err
+			}
+			m.Ranges, buf = append(m.Ranges, r.(*types.DRange)), rest
+		}
+	}
+	if def := t.Extension(); def != nil {
+		s, rest, err := decodeEscaped(buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		d, err := def.Recv([]byte(s))
+		return d, rest, err
+	}
+	return nil, nil, fmt.Errorf("rowcodec: cannot decode type %s", t)
+}
+
+// Range bound tags. A range is 0x00 if empty, or 0x01 followed by its
+// lower and then its upper bound. An infinite lower bound sorts first and
+// an infinite upper bound last; a finite bound is its value, then a byte
+// that orders an inclusive lower bound before an exclusive one at the same
+// value, and an exclusive upper bound before an inclusive one.
+const (
+	boundLowerInf byte = 0x00
+	boundFinite   byte = 0x01
+	boundUpperInf byte = 0x02
+)
+
+// boundEncoder and boundDecoder encode and decode a single range bound.
+type (
+	boundEncoder func([]byte, *types.T, types.Datum) ([]byte, error)
+	boundDecoder func([]byte, *types.T) (types.Datum, []byte, error)
+)
+
+func appendRange(buf []byte, r *types.DRange, enc boundEncoder) ([]byte, error) {
+	if r.Empty {
+		return append(buf, 0x00), nil
+	}
+	buf = append(buf, 0x01)
+	sub := r.Typ.RangeContents
+	if r.Lower == nil {
+		buf = append(buf, boundLowerInf)
+	} else {
+		var err error
+		if buf, err = enc(append(buf, boundFinite), sub, r.Lower); err != nil {
+			return nil, err
+		}
+		buf = append(buf, boolByte(!r.LowerInc))
+	}
+	if r.Upper == nil {
+		return append(buf, boundUpperInf), nil
+	}
+	buf, err := enc(append(buf, boundFinite), sub, r.Upper)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, boolByte(r.UpperInc)), nil
+}
+
+func decodeRange(buf []byte, t *types.T, dec boundDecoder) (types.Datum, []byte, error) {
+	if len(buf) == 0 {
+		return nil, nil, errTruncated
+	}
+	if buf[0] == 0x00 {
+		return types.EmptyRange(t), buf[1:], nil
+	}
+	r := &types.DRange{Typ: t}
+	buf = buf[1:]
+	var err error
+	for _, lower := range []bool{true, false} {
+		if len(buf) == 0 {
+			return nil, nil, errTruncated
+		}
+		tag := buf[0]
+		buf = buf[1:]
+		if tag != boundFinite {
+			continue
+		}
+		var d types.Datum
+		if d, buf, err = dec(buf, t.RangeContents); err != nil {
+			return nil, nil, err
+		}
+		if len(buf) == 0 {
+			return nil, nil, errTruncated
+		}
+		if lower {
+			r.Lower, r.LowerInc = d, buf[0] == 0x00
+		} else {
+			r.Upper, r.UpperInc = d, buf[0] == 0x01
+		}
+		buf = buf[1:]
+	}
+	return r, buf, nil
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 0x01
+	}
+	return 0x00
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	return binary.BigEndian.AppendUint64(buf, uint64(i)^(1<<63))
+}
+
+func decodeInt(buf []byte) (int64, []byte, error) {
+	if len(buf) < 8 {
+		return 0, nil, errTruncated
+	}
+	return int64(binary.BigEndian.Uint64(buf) ^ (1 << 63)), buf[8:], nil
+}
+
+// appendFloat encodes f so that bytewise order matches numeric order, with
+// NaN after +Inf as PostgreSQL sorts it.
+func appendFloat(buf []byte, f float64) []byte {
+	var u uint64
+	switch {
+	case math.IsNaN(f):
+		u = math.MaxUint64
+	case f == 0:
+		u = 1 << 63 // fold -0 into +0
+	case f < 0:
+		u = ^math.Float64bits(f)
+	default:
+		u = math.Float64bits(f) | 1<<63
+	}
+	return binary.BigEndian.AppendUint64(buf, u)
+}
+
+func decodeFloat(u uint64) float64 {
+	switch {
+	case u == math.MaxUint64:
+		return math.NaN()
+	case u&(1<<63) != 0:
+		return math.Float64frombits(u &^ (1 << 63))
+	}
+	return math.Float64frombits(^u)
+}
+
+// Decimal sign bytes.
+const (
+	decNeg  byte = 0x01
+	decZero byte = 0x02
+	decPos  byte = 0x03
+)
+
+// appendDecimal encodes d as a sign byte, then for non-zero values the
+// base-10 exponent E such that |d| = 0.d1d2...dn * 10^E, then the
+// significant digits each stored as digit+1 and a 0x00 terminator. Negative
+// values invert the exponent and digit bytes so larger magnitudes sort
+// first. Trailing zeros are dropped so equal values encode identically.
+func appendDecimal(buf []byte, d *types.Dec) []byte {
+	if d.Sign() == 0 {
+		return append(buf, decZero)
+	}
+	digits := new(big.Int).Abs(&d.Coeff).String()
+	exp := int64(len(digits)) - int64(d.Scale)
+	digits = string(bytes.TrimRight([]byte(digits), "0"))
+	body := binary.BigEndian.AppendUint32(nil, uint32(int32(exp))^(1<<31))
+	for i := 0; i < len(digits); i++ {
+		body = append(body, digits[i]-'0'+1)
+	}
+	body = append(body, 0)
+	if d.Sign() < 0 {
+		for i := range body {
+			body[i] = ^body[i]
+		}
+		return append(append(buf, decNeg), body...)
+	}
+	return append(append(buf, decPos), body...)
+}
+
+func decodeDecimal(buf []byte) (types.Datum, []byte, error) {
+	if len(buf) < 1 {
+		return nil, nil, errTruncated
+	}
+	sign := buf[0]
+	buf = buf[1:]
+	if sign == decZero {
+		return types.NewDDecimal(types.NewDecFromInt(0)), buf, nil
+	}
+	if len(buf) < 5 {
+		return nil, nil, errTruncated
+	}
+	flip := func(b byte) byte {
+		if sign == decNeg {
+			return ^b
+		}
+		return b
+	}
+	var expBytes [4]byte
+	for i := range expBytes {
+		expBytes[i] = flip(buf[i])
+	}
+	exp := int64(int32(binary.BigEndian.Uint32(expBytes[:]) ^ (1 << 31)))
+	buf = buf[4:]
+	var digits []byte
+	for {
+		if len(buf) == 0 {
+			return nil, nil, errTruncated
+		}
+		b := flip(buf[0])
+		buf = buf[1:]
+		if b == 0 {
+			break
+		}
+		digits = append(digits, b-1+'0')
+	}
+	coeff, ok := new(big.Int).SetString(string(digits), 10)
+	if !ok {
+		return nil, nil, fmt.Errorf("rowcodec: invalid decimal digits")
+	}
+	if sign == decNeg {
+		coeff.Neg(coeff)
+	}
+	dec := &types.Dec{Scale: int32(int64(len(digits)) - exp)}
+	dec.Coeff.Set(coeff)
+	if dec.Scale < 0 {
+		dec.Coeff.Mul(&dec.Coeff, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(-dec.Scale)), nil))
+		dec.Scale = 0
+	}
+	return types.NewDDecimal(dec), buf, nil
+}
+
+func appendEscaped(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] == escapeByte {
+			buf = append(buf, escapeByte, escapedZero)
+		} else {
+			buf = append(buf, s[i])
+		}
+	}
+	return buf
+}
+
+func decodeEscaped(buf []byte) (string, []byte, error) {
+	var out []byte
+	for i := 0; i < len(buf); i++ {
+		if buf[i] != escapeByte {
+			out = append(out, buf[i])
+			continue
+		}
+		if i+1 >= len(buf) {
+			return "", nil, errTruncated
+		}
+		switch buf[i+1] {
+		case terminator:
+			return string(out), buf[i+2:], nil
+		case escapedZero:
+			out = append(out, 0)
+			i++
+		default:
+			return "", nil, fmt.Errorf("rowcodec: invalid escape %#x", buf[i+1])
+		}
+	}
+	return "", nil, errTruncated
+}
+
+// PrefixEnd returns the smallest key greater than every key with the given
+// prefix, or nil if there is none.
+func PrefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package rowcodec
+
+import (
+	"hash/fnv"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// A hash index keys its entries by a fixed-size digest of the key encoding
+// of the value in its column, followed by the primary key columns, so an
+// entry takes the same space however long the value is. Equal values have
+// equal key encodings and so equal digests, but distinct values may share
+// a digest, so the rows of a digest's entries must be checked again.
+const digestSize = 8
+
+// EncodeHashPrefix returns the prefix of the entries of the hash index idx
+// for the value d.
+func EncodeHashPrefix(t *catalog.Table, idx *catalog.Index, d types.Datum) ([]byte, error) {
+	enc, err := EncodeKey(nil, t.Columns[t.ColumnOrdinal(idx.ColumnIDs[0])].Type, d)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	h.Write(enc)
+	return h.Sum(IndexPrefix(t.ID, idx.ID)), nil
+}
@@ -0,0 +1,65 @@
+package rowcodec
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// An inverted index has an entry for each token of the value in its
+// column, keyed by the token and then the primary key columns, so the
+// entries of a token list the rows whose value has it in primary key
+// order. Tokens encode their kind first and are self-delimiting.
+const (
+	tokenKey  byte = 0x01
+	tokenPair byte = 0x02
+)
+
+// HstoreKeyToken returns the token of an hstore with key k.
+func HstoreKeyToken(k string) []byte {
+	return append(appendEscaped([]byte{tokenKey}, k), escapeByte, terminator)
+}
+
+// HstorePairToken returns the token of an hstore with the pair p.
+func HstorePairToken(p types.HstorePair) []byte {
+	buf := append(appendEscaped([]byte{tokenPair}, p.Key), escapeByte, terminator)
+	if p.Null {
+		return append(buf, markerNull)
+	}
+	return append(appendEscaped(append(buf, markerValue), p.Value), escapeByte, terminator)
+}
+
+// InvertedTokens returns the tokens of d, an hstore: one for each of its
+// keys and one for each of its pairs.
+func InvertedTokens(d types.Datum) [][]byte {
+	h := d.(*types.DHstore)
+	tokens := make([][]byte, 0, 2*len(h.Pairs))
+	for _, p := range h.Pairs {
+		tokens = append(tokens, HstoreKeyToken(p.Key), HstorePairToken(p))
+	}
+	return tokens
+}
+
+// EncodeInvertedPrefix returns the prefix of the entries of token in the
+// inverted index idx.
+func EncodeInvertedPrefix(t *catalog.Table, idx *catalog.Index, token []byte) []byte {
+	return append(IndexPrefix(t.ID, idx.ID), token...)
+}
+
+// EncodeInvertedKeys returns the keys of row's entries in the inverted
+// index idx, one per token. A NULL value has none.
+func EncodeInvertedKeys(t *catalog.Table, idx *catalog.Index, row []types.Datum) ([][]byte, error) {
+	d := row[t.ColumnOrdinal(idx.ColumnIDs[0])]
+	if d == types.DNull {
+		return nil, nil
+	}
+	tokens := InvertedTokens(d)
+	keys := make([][]byte, len(tokens))
+	for i, token := range tokens {
+		var err error
+		keys[i], err = appendColumns(EncodeInvertedPrefix(t, idx, token), t, t.PrimaryIndex, row)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
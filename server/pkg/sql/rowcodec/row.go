@@ -0,0 +1,353 @@
+package rowcodec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// tablePrefixByte starts every table data key. It sorts after
+// catalog.SystemPrefix.
+const tablePrefixByte byte = 0x10
+
+// TablePrefix returns the prefix of all keys of table id.
+func TablePrefix(id catalog.ID) []byte {
+	return binary.BigEndian.AppendUint32([]byte{tablePrefixByte}, uint32(id))
+}
+
+// IndexPrefix returns the prefix of all keys of an index.
+func IndexPrefix(table catalog.ID, index catalog.IndexID) []byte {
+	return binary.BigEndian.AppendUint32(TablePrefix(table), uint32(index))
+}
+
+// EncodeIndexKey returns the key of row in idx. Secondary index keys end
+// with the primary key columns, which makes every entry unique and lets a
+// scan find the primary row.
+func EncodeIndexKey(t *catalog.Table, idx *catalog.Index, row []types.Datum) ([]byte, error) {
+	if idx.Hash {
+		key, err := EncodeHashPrefix(t, idx, row[t.ColumnOrdinal(idx.ColumnIDs[0])])
+		if err != nil {
+			return nil, err
+		}
+		return appendColumns(key, t, t.PrimaryIndex, row)
+	}
+	key := IndexPrefix(t.ID, idx.ID)
+	var err error
+	if key, err = appendColumns(key, t, idx, row); err != nil {
+		return nil, err
+	}
+	if idx.ID != catalog.PrimaryIndexID {
+		if key, err = appendColumns(key, t, t.PrimaryIndex, row); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// EncodeIndexPrefix returns the key prefix shared by all entries of idx
+// whose leading columns equal vals.
+func EncodeIndexPrefix(t *catalog.Table, idx *catalog.Index, vals []types.Datum) ([]byte, error) {
+	key := IndexPrefix(t.ID, idx.ID)
+	ords := t.ColumnOrdinals(idx)
+	var err error
+	for i, d := range vals {
+		if key, err = EncodeKeyDir(key, t.Columns[ords[i]].Type, d, idx.Desc(i)); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// IndexKeyPrefix returns the prefix of key, an entry of idx, holding its
+// first n columns. It is shared by the entries with the same values in
+// those columns.
+func IndexKeyPrefix(t *catalog.Table, idx *catalog.Index, key []byte, n int) ([]byte, error) {
+	rest := key[len(IndexPrefix(t.ID, idx.ID)):]
+	for i, ord := range t.ColumnOrdinals(idx)[:n] {
+		var err error
+		if _, rest, err = DecodeKeyDir(rest, t.Columns[ord].Type, idx.Desc(i)); err != nil {
+			return nil, err
+		}
+	}
+	return key[:len(key)-len(rest)], nil
+}
+
+// appendColumns appends the columns of idx in row, in the order of idx.
+// The primary key columns ending a secondary index key are in the order
+// of the primary index, so that they can be copied into its key.
+func appendColumns(key []byte, t *catalog.Table, idx *catalog.Index, row []types.Datum) ([]byte, error) {
+	var err error
+	for i, ord := range t.ColumnOrdinals(idx) {
+		if key, err = EncodeKeyDir(key, t.Columns[ord].Type, row[ord], idx.Desc(i)); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// PrimaryKeyFromIndexKey returns the primary index key of the row that a
+// secondary index entry points to.
+func PrimaryKeyFromIndexKey(t *catalog.Table, idx *catalog.Index, key []byte) ([]byte, error) {
+	rest := key[len(IndexPrefix(t.ID, idx.ID)):]
+	if idx.Hash {
+		return append(IndexPrefix(t.ID, catalog.PrimaryIndexID), rest[digestSize:]...), nil
+	}
+	for i, ord := range t.ColumnOrdinals(idx) {
+		var err error
+		if _, rest, err = DecodeKeyDir(rest, t.Columns[ord].Type, idx.Desc(i)); err != nil {
+			return nil, err
+		}
+	}
+	return append(IndexPrefix(t.ID, catalog.PrimaryIndexID), rest...), nil
+}
+
+// EncodeRowValue returns the primary index value of row: every non-NULL
+// column that is not part of the primary key, tagged with its column ID.
+// Primary key columns whose key encoding loses the exact value, such as
+// citext's lower-case form, are stored in the value too.
+func EncodeRowValue(t *catalog.Table, row []types.Datum) ([]byte, error) {
+	inKey := make(map[catalog.ColumnID]bool, len(t.PrimaryIndex.ColumnIDs))
+	for _, ord := range t.ColumnOrdinals(t.PrimaryIndex) {
+		c := t.Columns[ord]
+		inKey[c.ID] = c.Type.Family != types.CITextFamily
+	}
+	buf := []byte{}
+	for i, c := range t.Columns {
+		if inKey[c.ID] || row[i] == types.DNull {
+			continue
+		}
+		payload, err := encodeValue(nil, c.Type, row[i])
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(c.ID))
+		buf = binary.AppendUvarint(buf, uint64(len(payload)))
+		buf = append(buf, payload...)
+	}
+	return buf, nil
+}
+
+// DecodeRow decodes a primary index entry into a row with one datum per
+// column of t. Columns missing from the value are NULL; values of columns
+// no longer in t are skipped.
+func DecodeRow(t *catalog.Table, key, value []byte) ([]types.Datum, error) {
+	row := make([]types.Datum, len(t.Columns))
+	for i := range row {
+		row[i] = types.DNull
+	}
+	rest := key[len(IndexPrefix(t.ID, catalog.PrimaryIndexID)):]
+	for i, ord := range t.ColumnOrdinals(t.PrimaryIndex) {
+		var err error
+		if row[ord], rest, err = DecodeKeyDir(rest, t.Columns[ord].Type, t.PrimaryIndex.Desc(i)); err != nil {
+			return nil, err
+		}
+	}
+	for len(value) > 0 {
+		id, n := binary.Uvarint(value)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		value = value[n:]
+		size, n := binary.Uvarint(value)
+		if n <= 0 || uint64(len(value)-n) < size {
+			return nil, errTruncated
+		}
+		payload := value[n : n+int(size)]
+		value = value[n+int(size):]
+		ord := t.ColumnOrdinal(catalog.ColumnID(id))
+		if ord < 0 {
+			continue
+		}
+		d, err := decodeValue(payload, t.Columns[ord].Type)
+		if err != nil {
+			return nil, err
+		}
+		row[ord] = d
+	}
+	return row, nil
+}
+
+// EncodeDatums appends the value encoding of row, whose values have the
+// types typs, for rows kept apart from any table, such as those a sort
+// spills. Each value is prefixed by its length plus one, and NULL by 0.
+func EncodeDatums(buf []byte, typs []*types.T, row []types.Datum) ([]byte, error) {
+	for i, d := range row {
+		if d == types.DNull {
+			buf = binary.AppendUvarint(buf, 0)
+			continue
+		}
+		payload, err := encodeValue(nil, typs[i], d)
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(payload))+1)
+		buf = append(buf, payload...)
+	}
+	return buf, nil
+}
+
+// DecodeDatums decodes a row of values of the types typs that
+// EncodeDatums encoded.
+func DecodeDatums(buf []byte, typs []*types.T) ([]types.Datum, error) {
+	row := make([]types.Datum, len(typs))
+	for i, t := range typs {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < max(size, 1)-1 {
+			return nil, errTruncated
+		}
+		buf = buf[n:]
+		if size == 0 {
+			row[i] = types.DNull
+			continue
+		}
+		var err error
+		if row[i], err = decodeValue(buf[:size-1], t); err != nil {
+			return nil, err
+		}
+		buf = buf[size-1:]
+	}
+	return row, nil
+}
+
+// encodeValue appends the value encoding of d. It is the key payload for
+// every family except decimal, whose key encoding drops the display scale,
+// citext, whose key encoding is lower-case, vector, which is stored as its
+// float4 elements, ranges and multiranges, whose bounds use their value
+// encoding, and registered types, which are stored in their binary form
+// unescaped.
+func encodeValue(buf []byte, t *types.T, d types.Datum) ([]byte, error) {
+	if def := t.Extension(); def != nil {
+		return append(buf, def.Send(d)...), nil
+	}
+	if t.Family == types.CITextFamily {
+		return append(buf, d.(types.DCIText)...), nil
+	}
+	if t.Family == types.VectorFamily {
+		return AppendVector(buf, d.(*types.DVector)), nil
+	}
+	if t.Family == types.DecimalFamily {
+		dec := &d.(*types.DDecimal).Dec
+		buf = binary.AppendVarint(buf, int64(dec.Scale))
+		return dec.Coeff.Append(buf, 10), nil
+	}
+	switch t.Family {
+	case types.RangeFamily:
+		return appendRange(buf, d.(*types.DRange), encodeBoundValue)
+	case types.MultirangeFamily:
+		ranges := d.(*types.DMultirange).Ranges
+		buf = binary.AppendUvarint(buf, uint64(len(ranges)))
+		for _, r := range ranges {
+			var err error
+			if buf, err = appendRange(buf, r, encodeBoundValue); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return encodeKeyPayload(buf, t, d)
+}
+
+// encodeBoundValue appends the value encoding of a range bound, prefixed
+// with its length.
+func encodeBoundValue(buf []byte, t *types.T, d types.Datum) ([]byte, error) {
+	v, err := encodeValue(nil, t, d)
+	if err != nil {
+		return nil, err
+	}
+	return append(binary.AppendUvarint(buf, uint64(len(v))), v...), nil
+}
+
+func decodeBoundValue(buf []byte, t *types.T) (types.Datum, []byte, error) {
+	n, k := binary.Uvarint(buf)
+	if k <= 0 || uint64(len(buf)-k) < n {
+		return nil, nil, errTruncated
+	}
+	d, err := decodeValue(buf[k:k+int(n)], t)
+	return d, buf[k+int(n):], err
+}
+
+func decodeValue(buf []byte, t *types.T) (types.Datum, error) {
+	if def := t.Extension(); def != nil {
+		return def.Recv(buf)
+	}
+	if t.Family == types.CITextFamily {
+		return types.DCIText(buf), nil
+	}
+	if t.Family == types.VectorFamily {
+		v, rest, err := DecodeVector(buf)
+		if err == nil && len(rest) != 0 {
+			err = fmt.Errorf("rowcodec: %d trailing bytes in %s value", len(rest), t)
+		}
+		return v, err
+	}
+	if t.Family == types.DecimalFamily {
+		scale, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		dec := &types.Dec{Scale: int32(scale)}
+		if _, ok := dec.Coeff.SetString(string(buf[n:]), 10); !ok {
+			return nil, fmt.Errorf("rowcodec: invalid decimal value")
+		}
+		return types.NewDDecimal(dec), nil
+	}
+	var d types.Datum
+	var rest []byte
+	var err error
+	switch t.Family {
+	case types.RangeFamily:
+		d, rest, err = decodeRange(buf, t, decodeBoundValue)
+	case types.MultirangeFamily:
+		n, k := binary.Uvarint(buf)
+		if k <= 0 || uint64(len(buf)-k) < n {
+			return nil, errTruncated
+		}
+		m := &types.DMultirange{Typ: t, Ranges: make([]*types.DRange, n)}
+		rest = buf[k:]
+		for i := range m.Ranges {
+			var r types.Datum
+			if r, rest, err = decodeRange(rest, types.RangeOf(t.RangeContents), decodeBoundValue); err != nil {
+				return nil, err
+			}
+			m.Ranges[i] = r.(*types.DRange)
+		}
+		d = m
+	default:
+		d, rest, err = decodeKeyPayload(buf, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("rowcodec: %d trailing bytes in %s value", len(rest), t)
+	}
+	return d, nil
+}
+
+// AppendVector appends the compact encoding of v: its dimension as a
+// uvarint, then each element as a little-endian float4.
+func AppendVector(buf []byte, v *types.DVector) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(v.Elems)))
+	for _, f := range v.Elems {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(f))
+	}
+	return buf
+}
+
+// DecodeVector decodes a vector written by AppendVector from the front of
+// buf and returns the remaining bytes.
+func DecodeVector(buf []byte) (*types.DVector, []byte, error) {
+	n, k := binary.Uvarint(buf)
+	if k <= 0 || uint64(len(buf)-k)/4 < n {
+		return nil, nil, errTruncated
+	}
+	buf = buf[k:]
+	elems := make([]float32, n)
+	for i := range elems {
+		elems[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[4*i:]))
+	}
+	return types.NewDVector(elems), buf[4*n:], nil
+}
@@ -0,0 +1,1037 @@
+// Package sql executes SQL statements against a storage engine.
+//
+// A Server holds what is shared between connections; each connection owns
+// a Session, which tracks the connection's transaction. Statements outside
+// an explicit transaction block run in their own implicit transaction.
+package sql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/logging"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/exec"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+	"github.com/alivenotions/pgz/server/pkg/trace"
+)
+
+// Server executes SQL on an engine.
+type Server struct {
+	engine      engine.Engine
+	registry    *eval.Registry
+	hooks       []Hooks
+	plans       planCache
+	statements  statementStats
+	backends    backends
+	notifier    notifier
+	commits     engine.GroupCommit
+	replication replication
+	logins      logins
+	tracer      trace.Exporter
+
+	logger *slog.Logger
+	// logMinDuration is the log_min_duration_statement setting, and
+	// idleSessionTimeout and idleInTxnTimeout are idle_session_timeout and
+	// idle_in_transaction_session_timeout, in nanoseconds.
+	logMinDuration     atomic.Int64
+	idleSessionTimeout atomic.Int64
+	idleInTxnTimeout   atomic.Int64
+	// maxTxnBytes is the max_transaction_bytes setting.
+	maxTxnBytes atomic.Int64
+	// settings lists the server's settings; see SetSettings.
+	settings func() []vtable.Setting
+	// readOnly is set for read replicas; see SetReadOnly.
+	readOnly bool
+}
+
+// NewServer returns a server for e with the builtin functions, and
+// pgz_stat_statements_reset() to reset the statistics the server keeps of
+// the statements it executes, which pg_stat_statements lists. Its sessions
+// are listed in pg_stat_activity, and the progress of their long commands
+// in the pg_stat_progress_* tables. pg_cancel_backend(pid) and
+// pg_terminate_backend(pid) signal them, and pg_notify(channel, payload)
+// sends notifications to those that LISTEN. pgz_backup(dir) takes a hot
+// backup; see Backup. pg_drop_replication_slot(name) drops a logical
+// replication slot, and pg_current_wal_lsn() returns the end of the
+// replication log; see CreateReplicationSlot. pgz_unlock_role(name)
+// unlocks a role locked by failed logins; see SetLoginLockout.
+func NewServer(e engine.Engine) *Server {
+	s := &Server{engine: e, registry: eval.Builtins.Clone(), logger: logging.Component(slog.Default(), "sql")}
+	s.logMinDuration.Store(-1)
+	for name, o := range map[string]*eval.Overload{
+		"pgz_stat_statements_reset": resetStatementStats(&s.statements),
+		"pg_backend_pid":            backendPID,
+		"current_database":          currentDatabase,
+		"pg_cancel_backend":         signalBackend(&s.backends, (*activity).cancel),
+		"pg_terminate_backend": signalBackend(&s.backends, func(a *activity) {
+			a.terminate(errTerminated())
+		}),
+		"pg_notify":                notifyFunc(&s.backends),
+		"pgz_backup":               backupFunc(s),
+		"pg_drop_replication_slot": dropReplicationSlot(&s.backends),
+		"pg_current_wal_lsn":       currentLSN(&s.backends),
+		"pgz_unlock_role":          unlockRole(s),
+	} {
+		if err := s.registry.Define(name, o); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// RegisterFunction makes a function callable from SQL on this server. Each
+// overload declares its parameter and result types and is implemented by
+// its Fn; overloads are resolved against the arguments of a call like
+// builtins are. Registered functions are listed in pg_proc. It is an error
+// to register an overload whose parameter types match one the function
+// already has, including builtin overloads.
+//
+// Functions may be registered while sessions are executing statements;
+// they are visible to statements planned afterwards.
+func (s *Server) RegisterFunction(name string, overloads ...*eval.Overload) error {
+	if err := s.registry.Define(name, overloads...); err != nil {
+		return err
+	}
+	// Calls in cached plans may resolve to a different overload now.
+	s.plans.invalidate()
+	return nil
+}
+
+// RegisterAggregate makes an aggregate function callable from SQL on this
+// server. Each definition declares the parameter and result types of one
+// overload and implements it with callbacks over a per-group state; see
+// eval.AggregateDef. Registered aggregates are listed in pg_proc. The name
+// must not already be used by an ordinary function.
+func (s *Server) RegisterAggregate(name string, defs ...*eval.AggregateDef) error {
+	if err := s.registry.DefineAggregate(name, defs...); err != nil {
+		return err
+	}
+	s.plans.invalidate()
+	return nil
+}
+
+// CatalogChanged tells the server that the catalog changed other than by
+// the statements of its sessions, as when a read replica applies the
+// changes of its primary, so that statements are planned anew.
+func (s *Server) CatalogChanged() {
+	s.plans.invalidate()
+}
+
+// SetCommitDelay sets how long a commit waits for concurrent commits to
+// make them durable together, like PostgreSQL's commit_delay. It trades
+// the latency of commits for fewer syncs of the engine when many small
+// transactions commit at once. The default is 0: commits that arrive while
+// the engine syncs are still synced together, but none waits for more.
+func (s *Server) SetCommitDelay(d time.Duration) {
+	s.commits.SetDelay(d)
+}
+
+// SetWalWriterDelay sets how long after a transaction commits with
+// synchronous_commit off the engine is synced, like PostgreSQL's
+// wal_writer_delay; such transactions may be lost in a crash until then.
+// 0 is the default of 200ms.
+func (s *Server) SetWalWriterDelay(d time.Duration) {
+	s.commits.SetWriterDelay(d)
+}
+
+// Sync makes the writes of the transactions committed so far durable,
+// including those committed with synchronous_commit off, if the engine
+// syncs at all.
+func (s *Server) Sync() error {
+	return s.commits.Sync(s.engine)
+}
+
+// Backup takes a hot backup of the server's databases: it copies a
+// consistent snapshot of the transactions committed so far into the
+// directory dir, which must not exist, while sessions go on. The copy is
+// a data directory the server can be started on. Engines that are not an
+// engine.Backuper, such as the in-memory one, cannot be backed up.
+func (s *Server) Backup(dir string) error {
+	b, ok := s.engine.(engine.Backuper)
+	if !ok {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, "the storage engine does not support backups")
+	}
+	// The transactions committed with synchronous_commit off are synced
+	// first, so that the backup is not ahead of the database it copies.
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	if err := b.Backup(dir); errors.Is(err, engine.ErrUnsupported) {
+		return pgerror.New(pgerror.CodeFeatureNotSupported, "the storage engine does not support backups")
+	} else if err != nil {
+		return pgerror.Newf(pgerror.CodeIOError, "could not back up to directory %q: %v", dir, err)
+	}
+	s.logger.Info("took a backup", "dir", dir)
+	return nil
+}
+
+// StorageStats returns the statistics of the server's storage engine, for
+// monitoring; see engine.StorageStats. Engines that are not an
+// engine.StatsReporter, such as the in-memory one, and those that keep no
+// statistics yet, such as the native one, do not report them.
+func (s *Server) StorageStats() (engine.StorageStats, error) {
+	errNoStats := pgerror.New(pgerror.CodeFeatureNotSupported, "the storage engine does not report statistics")
+	r, ok := s.engine.(engine.StatsReporter)
+	if !ok {
+		return engine.StorageStats{}, errNoStats
+	}
+	st, err := r.StorageStats()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return engine.StorageStats{}, errNoStats
+	}
+	return st, err
+}
+
+// SetTraceExporter makes the server trace the queries it runs, exporting
+// their spans to e; see package trace. It must not be called while
+// sessions are executing statements.
+func (s *Server) SetTraceExporter(e trace.Exporter) {
+	s.tracer = e
+}
+
+// SetLogger sets the logger the server logs to, as its "sql" component.
+// It must not be called while sessions are executing statements.
+func (s *Server) SetLogger(l *slog.Logger) {
+	s.logger = logging.Component(l, "sql")
+}
+
+// SetLogMinDurationStatement sets the duration from which statements are
+// logged when they complete, with their duration and plan, like
+// PostgreSQL's log_min_duration_statement. 0 logs every statement; a
+// negative duration, the default, logs none.
+func (s *Server) SetLogMinDurationStatement(d time.Duration) {
+	s.logMinDuration.Store(int64(max(d, -1)))
+}
+
+// SetMaxConnections sets the number of sessions Connect allows at once,
+// like PostgreSQL's max_connections. 0, the default, is no limit.
+func (s *Server) SetMaxConnections(n int) {
+	s.backends.setMax(n)
+}
+
+// SetIdleInTransactionSessionTimeout sets how long a session may wait for
+// its client in an open transaction before it is terminated, like
+// PostgreSQL's idle_in_transaction_session_timeout. 0, the default, is no
+// timeout. A change applies from the next time a session waits; see
+// Session.Done.
+func (s *Server) SetIdleInTransactionSessionTimeout(d time.Duration) {
+	s.idleInTxnTimeout.Store(int64(max(d, 0)))
+}
+
+// SetIdleSessionTimeout sets how long a session may wait for its client
+// outside of a transaction before it is terminated, like PostgreSQL's
+// idle_session_timeout. 0, the default, is no timeout.
+func (s *Server) SetIdleSessionTimeout(d time.Duration) {
+	s.idleSessionTimeout.Store(int64(max(d, 0)))
+}
+
+// SetSettings sets the function listing the server's settings, which
+// pg_settings lists and SHOW shows along with the settings of the session,
+// such as client_encoding. The server is configured by its embedder, which
+// reports its configuration here.
+func (s *Server) SetSettings(f func() []vtable.Setting) {
+	s.settings = f
+}
+
+// SetMaxTransactionBytes sets the size of the keys and values a
+// transaction may write, summed over its statements. A statement whose
+// writes take its transaction past it fails with
+// configuration_limit_exceeded, so that a runaway bulk UPDATE cannot
+// exhaust the memory the engine holds a transaction's writes in until it
+// commits. 0, the default, is no limit.
+func (s *Server) SetMaxTransactionBytes(n int64) {
+	s.maxTxnBytes.Store(max(n, 0))
+}
+
+// Registry returns the functions and operators available to the server's
+// queries.
+func (s *Server) Registry() *eval.Registry {
+	return s.registry
+}
+
+// NewSession starts a session with no open transaction. It is listed in
+// pg_stat_activity until it is closed. It counts towards the connection
+// limits, but is not refused by them; see Connect. A session started once
+// the server shuts down is terminated from the start.
+func (s *Server) NewSession() *Session {
+	sess, err := s.newSession(nil, &catalog.Database{ID: catalog.DefaultDatabaseID, Name: catalog.DefaultDatabase})
+	if err != nil {
+		sess.activity.terminate(pgerror.Flatten(err))
+	}
+	return sess
+}
+
+// Connect starts a session for a client that connected as user to
+// database, or to catalog.DefaultDatabase if it is empty. user must be a
+// role with LOGIN; the first client to connect to an engine without roles
+// creates its role, as a superuser. The session starts with the defaults
+// ALTER ROLE ... SET pinned for the role and database, which the
+// parameters the client sets with SetParameter override. It fails with invalid_catalog_name if
+// there is no such database, with too_many_connections if the server
+// already has as many sessions as SetMaxConnections allows, or user as
+// many as its CONNECTION LIMIT allows it, and with cannot_connect_now
+// once the server shuts down.
+func (s *Server) Connect(user, database string) (*Session, error) {
+	if database == "" {
+		database = catalog.DefaultDatabase
+	}
+	role, db, err := s.login(user, database)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := s.newSession(role, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := sess.applyRoleSettings(role); err != nil {
+		sess.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// CancelBackend cancels the query the session pid is running, as
+// pg_cancel_backend does, and reports whether there is such a session.
+func (s *Server) CancelBackend(pid int32) bool {
+	sess := s.backends.get(pid)
+	if sess == nil {
+		return false
+	}
+	sess.activity.cancel()
+	return true
+}
+
+// newSession starts a session of a client connected as role, or of the
+// embedder if role is nil, which is returned unlisted with the error if
+// the server refuses it.
+func (s *Server) newSession(role *catalog.Role, db *catalog.Database) (*Session, error) {
+	sess := &Session{server: s, location: time.UTC, regexps: eval.NewRegexCache(), sequences: exec.NewSequenceState(), vars: make(map[string]varValue)}
+	sess.database, sess.engine = db, catalog.Keyspace(s.engine, db)
+	if s.readOnly {
+		sess.engine = readOnlyEngine{sess.engine}
+	}
+	sess.inbox.ready = make(chan struct{}, 1)
+	if role != nil {
+		sess.user, sess.role, sess.superuser = role.Name, role.ID, role.Superuser
+	}
+	sess.activity.start(sess, sess.user)
+	if err := s.backends.add(sess, role); err != nil {
+		sess.activity.stop()
+		return sess, err
+	}
+	return sess, nil
+}
+
+// shutdownPoll is how often Shutdown checks whether the sessions have
+// finished.
+const shutdownPoll = 10 * time.Millisecond
+
+// Shutdown shuts the server down gracefully. It refuses new sessions and
+// terminates each session once it is idle outside of a transaction,
+// waiting for them to finish their transactions until ctx is done. Then it
+// terminates the remaining sessions, which cancels their queries, and
+// waits for the queries to stop. Last, it makes the committed transactions
+// durable, if the engine is an engine.Syncer. It returns ctx.Err() if ctx
+// was done before the sessions finished.
+//
+// Terminated sessions should be closed, which rolls back their open
+// transactions; see Session.Done. The engine is left open for its owner to
+// close.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.backends.close()
+	ticker := time.NewTicker(shutdownPoll)
+	defer ticker.Stop()
+	var err error
+	for force := false; ; {
+		busy := false
+		for _, sess := range s.backends.all() {
+			if !sess.activity.shutdown(errTerminated(), force) || sess.activity.running() {
+				busy = true
+			}
+		}
+		if !busy {
+			break
+		}
+		done := ctx.Done()
+		if force {
+			done = nil
+		}
+		select {
+		case <-done:
+			force, err = true, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	if err := s.Sync(); err != nil {
+		return err
+	}
+	return err
+}
+
+// Session is the state of one client connection.
+type Session struct {
+	server   *Server
+	location *time.Location
+	// pid identifies the session in pg_stat_activity, which shows its
+	// activity.
+	pid      int32
+	activity activity
+	// user is the role the client connected as, which statements run as;
+	// empty for sessions of the embedder, role its ID, and superuser
+	// whether the role was a superuser as the session started.
+	user      string
+	role      catalog.ID
+	superuser bool
+	// database is the database the session is connected to, and engine
+	// its keyspace, which the session's transactions read and write.
+	database *catalog.Database
+	engine   engine.Engine
+	// span traces the running query, if it is traced.
+	span *trace.Span
+	// read is when the connection read the message that runs the next
+	// query, and its size; see TraceRead.
+	read struct {
+		start, end time.Time
+		bytes      int
+	}
+	// labels are those of the running query's sqlcommenter comments; see
+	// queryLabels.
+	labels []trace.Label
+	// copyIn is the client's data for COPY FROM STDIN; see ExecCopy.
+	copyIn io.Reader
+
+	// txn is the open explicit transaction, if any.
+	txn engine.Txn
+	// txnTime is the start time of txn.
+	txnTime time.Time
+	// failed is set when a statement in txn failed; later statements are
+	// rejected until the block ends.
+	failed bool
+	// version is the catalog version when the transaction began, and
+	// txnDDL is set once the transaction has changed the catalog; see
+	// planVersion.
+	version uint64
+	txnDDL  bool
+	// txnBytes is the size of the writes of txn so far; see
+	// SetMaxTransactionBytes.
+	txnBytes int64
+	// txnReadOnly is set if txn only reads, as it began while
+	// default_transaction_read_only was on.
+	txnReadOnly bool
+	// capture is what the open transaction, explicit or not, records for
+	// the replication log.
+	capture *capture
+
+	// vars are the values of the session variables that were set,
+	// resetVars those the client started the session with, which RESET
+	// restores, and txnVars what to restore them to when txn ends.
+	vars      map[string]varValue
+	resetVars map[string]varValue
+	txnVars   map[string]savedVar
+
+	// listening are the channels the session listens on, inbox the
+	// notifications delivered to it, and txnListens and txnNotifies the
+	// LISTEN and NOTIFY of the transaction, which take effect as it
+	// commits.
+	listening   map[string]struct{}
+	inbox       inbox
+	txnListens  []listenAction
+	txnNotifies []Notification
+
+	// prepared are the prepared statements by name, and parsed the
+	// statements Prepare parsed by query text.
+	prepared map[string]*PreparedStatement
+	parsed   map[string]parser.Statement
+
+	regexps *eval.RegexCache
+	// sequences holds the values nextval returned in the session.
+	sequences *exec.SequenceState
+	values    map[any]any
+}
+
+// Result is the outcome of one statement.
+type Result struct {
+	exec.Result
+	// Tag is the PostgreSQL command tag, e.g. "INSERT 0 1".
+	Tag string
+}
+
+// SetValue stores embedder data on the session, such as the tenant a
+// connection belongs to, for hooks to read with Value.
+func (s *Session) SetValue(key, value any) {
+	if s.values == nil {
+		s.values = make(map[any]any)
+	}
+	s.values[key] = value
+}
+
+// Value returns the data stored under key by SetValue, or nil.
+func (s *Session) Value(key any) any {
+	return s.values[key]
+}
+
+// SetUser sets the name of the user the client connected as, shown in
+// pg_stat_activity.
+func (s *Session) SetUser(name string) {
+	s.activity.mu.Lock()
+	defer s.activity.mu.Unlock()
+	s.activity.a.User = name
+}
+
+// SetApplicationName sets the application_name the client connected with,
+// shown in pg_stat_activity.
+func (s *Session) SetApplicationName(name string) {
+	s.activity.mu.Lock()
+	defer s.activity.mu.Unlock()
+	s.activity.a.ApplicationName = name
+}
+
+// TraceRead records that the connection read the client's last message,
+// of n bytes, off the wire from start to end. If the message runs a query
+// that is traced, the read is a span of its trace.
+func (s *Session) TraceRead(start, end time.Time, n int) {
+	s.read.start, s.read.end, s.read.bytes = start, end, n
+}
+
+// PID returns the process ID of the session, which identifies it to
+// pg_cancel_backend and pg_terminate_backend.
+func (s *Session) PID() int32 {
+	return s.pid
+}
+
+// Database returns the name of the session's database.
+func (s *Session) Database() string {
+	return s.database.Name
+}
+
+// Terminated reports whether the session has been terminated, by
+// pg_terminate_backend or for idling too long. Its statements then fail,
+// and the connection should be closed.
+func (s *Session) Terminated() bool {
+	return s.activity.terminated.Load() != nil
+}
+
+// Done returns a channel that is closed when the session is terminated,
+// for the connection to be closed even while it waits for the client.
+func (s *Session) Done() <-chan struct{} {
+	return s.activity.done
+}
+
+// Err returns the error the session was terminated with, or nil.
+func (s *Session) Err() error {
+	if err := s.activity.terminated.Load(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// InTxn reports whether an explicit transaction block is open.
+func (s *Session) InTxn() bool {
+	return s.txn != nil
+}
+
+// Failed reports whether the open transaction block has failed.
+func (s *Session) Failed() bool {
+	return s.failed
+}
+
+// Exec parses and runs the statements in query, which is in the client
+// encoding. It stops at the first error, returning the results of the
+// statements that ran before it.
+func (s *Session) Exec(query string) ([]*Result, error) {
+	return s.ExecCopy(query, nil)
+}
+
+// ExecCopy runs query like Exec, reading the data of its COPY FROM STDIN
+// from r, which a connection feeds with the client's CopyData messages.
+func (s *Session) ExecCopy(query string, r io.Reader) ([]*Result, error) {
+	var results []*Result
+	err := s.ExecFunc(query, r, func(res *Result) error {
+		results = append(results, res)
+		return nil
+	})
+	return results, err
+}
+
+// ExecFunc runs query like ExecCopy, but passes the result of each
+// statement to fn as soon as the statement ends, as the simple query
+// protocol sends them to the client, instead of returning them. An error
+// of fn fails the statement, and the statements after it do not run.
+func (s *Session) ExecFunc(query string, r io.Reader, fn func(*Result) error) error {
+	query, err := s.encoding().decode(query)
+	if err != nil {
+		s.fail()
+		return err
+	}
+	if e := s.encoding(); e.high != nil && r != nil {
+		r = &decodingReader{r: r, enc: e}
+	}
+	if err := s.begin(query); err != nil {
+		return err
+	}
+	s.copyIn = r
+	err = s.exec(query, fn)
+	s.copyIn = nil
+	s.end(err)
+	return err
+}
+
+func (s *Session) exec(query string, fn func(*Result) error) error {
+	span := s.span.Child("pgz.parse")
+	stmts, err := parser.Parse(query)
+	span.Finish(err)
+	if err != nil {
+		s.fail()
+		return err
+	}
+	// Each statement is counted in pg_stat_statements under its own text.
+	queries, err := parser.Normalize(query)
+	if err != nil || len(queries) != len(stmts) {
+		queries = make([]parser.Normalized, len(stmts))
+	}
+	for i, stmt := range stmts {
+		hc := &HookContext{Session: s, SQL: query, Stmt: stmt}
+		if queries[i].Fingerprint != "" {
+			hc.query = &queries[i]
+		}
+		res, err := s.execHooked(hc)
+		if err == nil {
+			err = fn(res)
+		}
+		if err != nil {
+			s.fail()
+			return err
+		}
+	}
+	return nil
+}
+
+// Close aborts any open transaction and stops listing the session in
+// pg_stat_activity.
+func (s *Session) Close() {
+	if s.txn != nil {
+		s.txn.Abort()
+		s.txn, s.txnDDL = nil, false
+		s.endCapture()
+	}
+	s.endNotify(false)
+	s.unlistenAll()
+	s.activity.stop()
+	s.server.backends.remove(s)
+}
+
+// begin marks the session as running query, unless it has been
+// terminated, and starts tracing it, labeled with the labels of its
+// comments.
+func (s *Session) begin(query string) error {
+	var xactStart time.Time
+	if s.txn != nil {
+		xactStart = s.txnTime
+	}
+	if err := s.activity.begin(query, xactStart, s.statementTimeout()); err != nil {
+		s.Close()
+		return err
+	}
+	parent, _ := trace.FromComment(query)
+	s.labels = queryLabels(query)
+	attrs := []slog.Attr{slog.String("db.system", "postgresql"), slog.String("db.statement", query), slog.Int("pgz.pid", int(s.pid))}
+	for _, l := range s.labels {
+		attrs = append(attrs, slog.String("pgz.label."+l.Key, l.Value))
+	}
+	s.span = trace.Start(s.server.tracer, parent, "pgz.query", attrs...)
+	if !s.read.start.IsZero() {
+		s.span.Record("pgz.wire.read", s.read.start, s.read.end, slog.Int("pgz.bytes", s.read.bytes))
+		s.read.start = time.Time{}
+	}
+	return nil
+}
+
+// end marks the session as idle after its query ended with err.
+func (s *Session) end(err error) {
+	s.span.Finish(err)
+	s.span, s.labels = nil, nil
+	s.activity.end(s)
+}
+
+// fail marks an open transaction block as failed.
+func (s *Session) fail() {
+	if s.txn != nil {
+		s.failed = true
+	}
+}
+
+func (s *Session) execStmt(hc *HookContext) (*Result, error) {
+	switch hc.Stmt.(type) {
+	case *parser.BeginStmt:
+		if s.txn == nil {
+			s.version = s.server.plans.current()
+			txn, err := s.engine.Begin()
+			if err != nil {
+				return nil, err
+			}
+			if err := s.beginCapture(); err != nil {
+				txn.Abort()
+				return nil, err
+			}
+			s.txn, s.txnTime, s.txnBytes = txn, time.Now(), 0
+			s.txnReadOnly = s.getVar("default_transaction_read_only") == "on"
+		}
+		return &Result{Tag: "BEGIN"}, nil
+	case *parser.CommitStmt:
+		return s.endTxn(true)
+	case *parser.RollbackStmt:
+		return s.endTxn(false)
+	}
+	if s.failed {
+		return nil, errInFailedTxn()
+	}
+	switch stmt := hc.Stmt.(type) {
+	case *parser.PrepareStmt:
+		typs, err := planner.ResolveTypes(stmt.Types)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := s.prepare(stmt.Name, stmt.SQL, stmt.Stmt, typs); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "PREPARE"}, nil
+	case *parser.DeallocateStmt:
+		if stmt.All {
+			s.DeallocateAll()
+			return &Result{Tag: "DEALLOCATE ALL"}, nil
+		}
+		if err := s.Deallocate(stmt.Name); err != nil {
+			return nil, err
+		}
+		return &Result{Tag: "DEALLOCATE"}, nil
+	case *parser.ShowStmt:
+		return s.show(stmt)
+	case *parser.SetStmt:
+		return s.set(stmt)
+	case *parser.ListenStmt, *parser.UnlistenStmt, *parser.NotifyStmt:
+		return s.notifyStmt(stmt)
+	}
+	if s.txn != nil {
+		if err := checkTxnBlock(hc.Stmt); err != nil {
+			return nil, err
+		}
+		return s.run(hc, s.txn, s.txnTime)
+	}
+	s.version = s.server.plans.current()
+	txn, err := s.engine.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.beginCapture(); err != nil {
+		txn.Abort()
+		return nil, err
+	}
+	defer s.endCapture()
+	res, err := s.run(hc, txn, time.Now())
+	ddl := s.txnDDL
+	s.txnDDL = false
+	if err != nil {
+		txn.Abort()
+		s.endNotify(false)
+		return nil, err
+	}
+	if err := s.server.commit(txn, s.capture, s.span, s.getVar("synchronous_commit") != "off"); err != nil {
+		s.endNotify(false)
+		return nil, err
+	}
+	s.endNotify(true)
+	if ddl {
+		s.server.plans.invalidate()
+	}
+	return res, nil
+}
+
+// checkTxnBlock returns an error for the statements that cannot run inside
+// a transaction block, as in PostgreSQL.
+func checkTxnBlock(stmt parser.Statement) error {
+	var name string
+	switch stmt := stmt.(type) {
+	case *parser.CreateDatabaseStmt:
+		name = "CREATE DATABASE"
+	case *parser.DropDatabaseStmt:
+		name = "DROP DATABASE"
+	case *parser.VacuumStmt:
+		name = "VACUUM"
+	case *parser.ClusterStmt:
+		// Only CLUSTER of every table, which PostgreSQL runs a
+		// transaction per table for.
+		if stmt.Table != nil {
+			return nil
+		}
+		name = "CLUSTER"
+	default:
+		return nil
+	}
+	return pgerror.Newf(pgerror.CodeActiveSQLTransaction, "%s cannot run inside a transaction block", name)
+}
+
+// errInFailedTxn is the error for a statement in a failed transaction
+// block.
+func errInFailedTxn() error {
+	return pgerror.New(pgerror.CodeInFailedSQLTransaction,
+		"current transaction is aborted, commands ignored until end of transaction block")
+}
+
+// endTxn finishes the open transaction block. Committing a failed block
+// rolls it back, as PostgreSQL does.
+func (s *Session) endTxn(commitTxn bool) (*Result, error) {
+	txn, failed, ddl := s.txn, s.failed, s.txnDDL
+	s.txn, s.failed, s.txnDDL = nil, false, false
+	tag := "ROLLBACK"
+	if commitTxn && !failed {
+		tag = "COMMIT"
+	}
+	if txn == nil {
+		return &Result{Tag: tag}, nil
+	}
+	defer s.endCapture()
+	if tag == "ROLLBACK" {
+		txn.Abort()
+		s.endVars(false)
+		s.endNotify(false)
+		return &Result{Tag: tag}, nil
+	}
+	if err := s.server.commit(txn, s.capture, s.span, s.getVar("synchronous_commit") != "off"); err != nil {
+		s.endVars(false)
+		s.endNotify(false)
+		return nil, err
+	}
+	s.endVars(true)
+	s.endNotify(true)
+	if ddl {
+		s.server.plans.invalidate()
+	}
+	return &Result{Tag: tag}, nil
+}
+
+// commit commits txn, recording the changes c captured in the
+// replication log, and reports write conflicts as serialization failures.
+// If wait is set, it returns once txn is durable, which concurrent commits
+// become together; otherwise the engine is synced in the background, as
+// with synchronous_commit off. The commit is traced within span.
+func (s *Server) commit(txn engine.Txn, c *capture, span *trace.Span, wait bool) error {
+	if c != nil && len(c.changes) > 0 {
+		txn = &loggedTxn{Txn: txn, r: &s.replication, c: c}
+	}
+	span = span.Child("pgz.commit")
+	var err error
+	if wait {
+		err = s.commits.Commit(s.engine, txn)
+	} else {
+		err = s.commits.CommitAsync(s.engine, txn)
+	}
+	span.Finish(err)
+	if errors.Is(err, engine.ErrConflict) {
+		return pgerror.New(pgerror.CodeSerializationFailure,
+			"could not serialize access due to concurrent update")
+	}
+	return err
+}
+
+func (s *Session) run(hc *HookContext, txn engine.Txn, txnTime time.Time) (*Result, error) {
+	start := time.Now()
+	readOnly := s.getVar("default_transaction_read_only") == "on"
+	if txn == s.txn {
+		readOnly = s.txnReadOnly
+	}
+	// The writes of the functions of a read-only transaction's statements
+	// fail too, as those of a read-only server do.
+	keyspace, target := s.engine, txn
+	if readOnly {
+		keyspace, target = readOnlyEngine{keyspace}, readOnlyTxn{txn}
+	}
+	// The statement's writes are buffered and reach txn in one batch when
+	// it ends, or when it deletes a range.
+	writes := engine.NewWriteBuffer(target)
+	writes.MaxBytes = s.server.maxTxnBytes.Load()
+	if txn == s.txn {
+		writes.Written = s.txnBytes
+	}
+	span := s.span.Child("pgz.plan")
+	ctx := &exec.Context{
+		Txn:        writes,
+		Eval:       &eval.Context{TxnTimestamp: txnTime, Location: s.location, Styles: s.styles(), Regexps: s.regexps},
+		Registry:   s.server.registry,
+		Engine:     keyspace,
+		Sequences:  s.sequences,
+		Statements: s.server.statements.list,
+		Activity:   s.server.backends.list,
+		TerminateBackend: func(pid int32) {
+			if sess := s.server.backends.get(pid); sess != nil {
+				sess.activity.terminate(errTerminated())
+			}
+		},
+		Progress:         s.server.backends.progress,
+		ReportProgress:   s.activity.report,
+		Settings:         s.settings,
+		ReplicationSlots: s.server.replicationSlots,
+		Changed:          s.capture.changed(),
+		Interrupted:      s.activity.interrupted,
+		CopyIn:           s.copyIn,
+		User:             s.user,
+		SearchPath:       s.searchPath(),
+		Memory:           &exec.Memory{WorkMem: s.workMem()},
+		ParallelWorkers:  s.parallelWorkers(),
+	}
+	if r, ok := s.server.engine.(engine.StatsReporter); ok {
+		ctx.StorageStats = r.StorageStats
+	}
+	// A replica's engine is only written by the changes it applies.
+	if !s.server.readOnly {
+		ctx.Spill = s.server.engine
+	}
+	ctx.Eval.BackendPID, ctx.Eval.Database = s.pid, s.database.Name
+	plan, hc, err := s.planStmt(hc, txn, ctx.Eval)
+	if err == nil {
+		err = s.checkReadOnly(hc.Stmt, readOnly)
+	}
+	span.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+	if changesCatalog(plan) {
+		s.txnDDL = true
+	}
+	ctx.Eval.Placeholders = hc.Params
+	span = s.span.Child("pgz.execute")
+	writes.Trace = span
+	res, err := exec.Run(ctx, plan)
+	if err == nil {
+		err = writes.Flush()
+	}
+	if txn == s.txn {
+		s.txnBytes = writes.Written
+	}
+	if errors.Is(err, engine.ErrTxnTooLarge) {
+		err = pgerror.Newf(pgerror.CodeConfigLimitExceeded,
+			"transaction size exceeds max_transaction_bytes (%d bytes)", writes.MaxBytes)
+	}
+	span.Finish(err)
+	if err == nil {
+		err = s.activity.interrupted()
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := time.Since(start)
+	labels := labelsText(s.labels)
+	if hc.query != nil {
+		s.server.statements.record(hc.query, labels, d, max(res.RowsAffected, len(res.Rows)))
+	}
+	if limit := s.server.logMinDuration.Load(); limit >= 0 && int64(d) >= limit {
+		args := []any{"pid", s.pid, "duration_ms", float64(d) / float64(time.Millisecond), "statement", hc.SQL}
+		if labels != "" {
+			args = append(args, "labels", labels)
+		}
+		args = append(args, "peak_memory_bytes", ctx.Memory.Peak(), "plan", strings.Join(planner.ExplainLines(plan), "\n"))
+		s.server.logger.Info("statement completed", args...)
+	}
+	return &Result{Result: *res, Tag: commandTag(hc.Stmt, res)}, nil
+}
+
+// planStmt plans the statement of hc, passing the plan through the OnPlan
+// hooks. For EXECUTE, it returns the context of the prepared statement,
+// whose arguments it evaluates in ctx.
+func (s *Session) planStmt(hc *HookContext, txn engine.Txn, ctx *eval.Context) (planner.Node, *HookContext, error) {
+	if e, ok := hc.Stmt.(*parser.ExecuteStmt); ok {
+		var err error
+		if hc, err = s.bindExecute(e, txn, ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	plan, err := s.plan(hc, txn)
+	if err != nil {
+		return nil, nil, err
+	}
+	if plan, err = s.planHooks(hc, plan); err != nil {
+		return nil, nil, err
+	}
+	return plan, hc, nil
+}
+
+func commandTag(stmt parser.Statement, res *exec.Result) string {
+	switch stmt.(type) {
+	case *parser.SelectStmt:
+		return fmt.Sprintf("SELECT %d", len(res.Rows))
+	case *parser.InsertStmt:
+		return fmt.Sprintf("INSERT 0 %d", res.RowsAffected)
+	case *parser.UpdateStmt:
+		return fmt.Sprintf("UPDATE %d", res.RowsAffected)
+	case *parser.DeleteStmt:
+		return fmt.Sprintf("DELETE %d", res.RowsAffected)
+	case *parser.CopyStmt:
+		return fmt.Sprintf("COPY %d", res.RowsAffected)
+	case *parser.CreateTableStmt:
+		return "CREATE TABLE"
+	case *parser.CreateIndexStmt:
+		return "CREATE INDEX"
+	case *parser.AlterTableStmt:
+		return "ALTER TABLE"
+	case *parser.DropTableStmt:
+		return "DROP TABLE"
+	case *parser.DropIndexStmt:
+		return "DROP INDEX"
+	case *parser.TruncateStmt:
+		return "TRUNCATE TABLE"
+	case *parser.CreateSequenceStmt:
+		return "CREATE SEQUENCE"
+	case *parser.DropSequenceStmt:
+		return "DROP SEQUENCE"
+	case *parser.CreateSchemaStmt:
+		return "CREATE SCHEMA"
+	case *parser.DropSchemaStmt:
+		return "DROP SCHEMA"
+	case *parser.CreateDatabaseStmt:
+		return "CREATE DATABASE"
+	case *parser.DropDatabaseStmt:
+		return "DROP DATABASE"
+	case *parser.CreatePublicationStmt:
+		return "CREATE PUBLICATION"
+	case *parser.DropPublicationStmt:
+		return "DROP PUBLICATION"
+	case *parser.CreateRoleStmt:
+		return "CREATE ROLE"
+	case *parser.AlterRoleStmt:
+		return "ALTER ROLE"
+	case *parser.DropRoleStmt:
+		return "DROP ROLE"
+	case *parser.GrantRoleStmt:
+		if stmt.(*parser.GrantRoleStmt).Revoke {
+			return "REVOKE ROLE"
+		}
+		return "GRANT ROLE"
+	case *parser.GrantStmt:
+		if stmt.(*parser.GrantStmt).Revoke {
+			return "REVOKE"
+		}
+		return "GRANT"
+	case *parser.AnalyzeStmt:
+		return "ANALYZE"
+	case *parser.ClusterStmt:
+		return "CLUSTER"
+	case *parser.VacuumStmt:
+		return "VACUUM"
+	case *parser.ExplainStmt:
+		return "EXPLAIN"
+	case *parser.ShowCreateStmt:
+		return "SHOW"
+	}
+	return ""
+}
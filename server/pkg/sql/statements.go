@@ -0,0 +1,150 @@
+package sql
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+	"github.com/alivenotions/pgz/server/pkg/trace"
+)
+
+// maxStatementStats bounds the number of statements whose statistics are
+// kept. Once it is reached, a new statement replaces the one executed
+// least often.
+const maxStatementStats = 5000
+
+// statementStats are the statistics of pg_stat_statements, by the
+// fingerprint of the normalized statement and the labels of the query.
+type statementStats struct {
+	mu      sync.Mutex
+	entries map[string]*vtable.StatementStats
+}
+
+// record counts an execution of the statement q, of a query labeled
+// labels, that took d and returned or wrote rows rows.
+func (s *statementStats) record(q *parser.Normalized, labels string, d time.Duration, rows int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := q.Fingerprint + "\x00" + labels
+	e := s.entries[key]
+	if e == nil {
+		if s.entries == nil {
+			s.entries = make(map[string]*vtable.StatementStats)
+		}
+		if len(s.entries) >= maxStatementStats {
+			s.evict()
+		}
+		h := fnv.New64a()
+		h.Write([]byte(q.Fingerprint))
+		e = &vtable.StatementStats{QueryID: int64(h.Sum64()), Query: q.SQL, Labels: labels, MinTime: d}
+		s.entries[key] = e
+	}
+	e.Calls++
+	e.TotalTime += d
+	e.MinTime = min(e.MinTime, d)
+	e.MaxTime = max(e.MaxTime, d)
+	e.Rows += int64(rows)
+}
+
+// evict drops the statement executed least often.
+func (s *statementStats) evict() {
+	var victim string
+	var fewest int64
+	for fp, e := range s.entries {
+		if victim == "" || e.Calls < fewest {
+			victim, fewest = fp, e.Calls
+		}
+	}
+	delete(s.entries, victim)
+}
+
+// list returns a copy of the statistics, ordered by query text.
+func (s *statementStats) list() []vtable.StatementStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]vtable.StatementStats, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, *e)
+	}
+	slices.SortFunc(out, func(a, b vtable.StatementStats) int {
+		return cmp.Or(strings.Compare(a.Query, b.Query), strings.Compare(a.Labels, b.Labels))
+	})
+	return out
+}
+
+// reset discards the statistics.
+func (s *statementStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.entries)
+}
+
+// maxLabels bounds the labels of a query that are kept, and
+// maxLabelLength the length of their values, so that the comments of
+// queries cannot bloat the statistics, the logs and the traces.
+const (
+	maxLabels      = 16
+	maxLabelLength = 256
+)
+
+// queryLabels returns the labels of the sqlcommenter comments of query,
+// such as application and controller, which attribute it in
+// pg_stat_statements, the log of slow statements and its trace. The
+// trace context is left out: it is the trace's, and differs from one
+// query to the next. Of labels with the same key, the first is kept.
+func queryLabels(query string) []trace.Label {
+	var labels []trace.Label
+	for _, l := range trace.CommentLabels(query) {
+		switch {
+		case l.Key == "traceparent" || l.Key == "tracestate":
+			continue
+		case slices.ContainsFunc(labels, func(o trace.Label) bool { return o.Key == l.Key }):
+			continue
+		case len(labels) == maxLabels:
+			return labels
+		}
+		if len(l.Value) > maxLabelLength {
+			l.Value = l.Value[:maxLabelLength]
+		}
+		labels = append(labels, l)
+	}
+	return labels
+}
+
+// labelsText formats labels as pg_stat_statements lists them: key=value
+// pairs, sorted by key and separated by commas.
+func labelsText(labels []trace.Label) string {
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = l.Key + "=" + l.Value
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// normalizeOne returns the normalized form of query, which holds one
+// statement, or nil if it holds none or more.
+func normalizeOne(query string) *parser.Normalized {
+	qs, err := parser.Normalize(query)
+	if err != nil || len(qs) != 1 {
+		return nil
+	}
+	return &qs[0]
+}
+
+// resetStatementStats is pgz_stat_statements_reset(), which discards the
+// statistics of s and returns the time it did.
+func resetStatementStats(s *statementStats) *eval.Overload {
+	return &eval.Overload{ReturnType: types.TimestampTZ, Volatility: eval.Volatile,
+		Fn: func(*eval.Context, []types.Datum) (types.Datum, error) {
+			s.reset()
+			return types.MakeDTimestampTZ(time.Now()), nil
+		}}
+}
@@ -0,0 +1,75 @@
+package sql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+)
+
+// DeleteExpired deletes the expired rows of the tables with a TTL, those
+// whose expiration time has passed, in every database, and returns how
+// many it deleted. Until then, expired rows are read as any other. Each
+// table's rows are deleted by a DELETE statement of its own, in a session
+// of the embedder, so foreign keys act on them and logical replication
+// streams their deletes; the sessions are listed in pg_stat_activity while
+// they run. It stops when ctx is done, canceling the running DELETE. A
+// read-only server deletes nothing: a replica's rows are deleted by the
+// primary.
+func (s *Server) DeleteExpired(ctx context.Context) (int64, error) {
+	if s.readOnly {
+		return 0, nil
+	}
+	dbs, err := catalog.ListDatabases(s.engine)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, db := range dbs {
+		n, err := s.deleteExpired(ctx, db)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteExpired deletes the expired rows of the tables of db.
+func (s *Server) deleteExpired(ctx context.Context, db *catalog.Database) (int64, error) {
+	sess, err := s.newSession(nil, db)
+	if err != nil {
+		return 0, err
+	}
+	defer sess.Close()
+	defer context.AfterFunc(ctx, sess.activity.cancel)()
+	tables, err := catalog.ListTables(sess.engine)
+	if err != nil {
+		return 0, err
+	}
+	var deleted int64
+	for _, t := range tables {
+		if t.TTL == nil {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		schema, err := catalog.GetSchemaByID(sess.engine, t.Schema)
+		if err != nil {
+			return deleted, err
+		}
+		col := t.Columns[t.ColumnOrdinal(t.TTL.ColumnID)]
+		res, err := sess.Exec(fmt.Sprintf("DELETE FROM %s.%s WHERE %s <= now()",
+			parser.QuoteIdent(schema.Name), parser.QuoteIdent(t.Name), parser.QuoteIdent(col.Name)))
+		if err != nil && ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
+		if err != nil {
+			return deleted, fmt.Errorf("deleting the expired rows of %s.%s: %w", schema.Name, t.Name, err)
+		}
+		deleted += int64(res[0].RowsAffected)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,74 @@
+package types
+
+import "strings"
+
+// DArray is a one-dimensional array datum. Elements may be DNull.
+type DArray struct {
+	Typ   *T
+	Elems []Datum
+}
+
+// NewDArray returns an array of the given array type.
+func NewDArray(typ *T, elems []Datum) *DArray {
+	return &DArray{Typ: typ, Elems: elems}
+}
+
+func (d *DArray) ResolvedType() *T { return d.Typ }
+
+// Compare orders arrays element by element, then by length.
+func (d *DArray) Compare(other Datum) int {
+	o := other.(*DArray)
+	for i := 0; i < len(d.Elems) && i < len(o.Elems); i++ {
+		if c := CompareDatums(d.Elems[i], o.Elems[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(d.Elems) < len(o.Elems):
+		return -1
+	case len(d.Elems) > len(o.Elems):
+		return 1
+	}
+	return 0
+}
+
+// String formats the array as PostgreSQL does, e.g. {a,"b c",NULL}.
+func (d *DArray) String() string {
+	return d.format(Datum.String)
+}
+
+// format formats the array with its elements formatted by elem.
+func (d *DArray) format(elem func(Datum) string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, e := range d.Elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if e == DNull {
+			b.WriteString("NULL")
+			continue
+		}
+		writeArrayElem(&b, elem(e))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// writeArrayElem writes s, double-quoted if it would otherwise be
+// ambiguous in array syntax.
+func writeArrayElem(b *strings.Builder, s string) {
+	quote := s == "" || strings.EqualFold(s, "NULL") || strings.ContainsAny(s, "{},\"\\ \t\n\r\v\f")
+	if !quote {
+		b.WriteString(s)
+		return
+	}
+	b.WriteByte('"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+}
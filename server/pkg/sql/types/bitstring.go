@@ -0,0 +1,132 @@
+package types
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DBitString is a bit or varbit datum. Its bits are packed into Bytes,
+// most significant bit first; the bits of the last byte past Len are
+// zero.
+type DBitString struct {
+	Bytes []byte
+	Len   int
+}
+
+// NewDBitString returns a bit string of n zero bits.
+func NewDBitString(n int) *DBitString {
+	return &DBitString{Bytes: make([]byte, (n+7)/8), Len: n}
+}
+
+func (d *DBitString) ResolvedType() *T { return VarBit }
+
+// Bit returns bit i of d, counting from the left.
+func (d *DBitString) Bit(i int) bool {
+	return d.Bytes[i/8]&(0x80>>(i%8)) != 0
+}
+
+// SetBit sets bit i of d, counting from the left, to v.
+func (d *DBitString) SetBit(i int, v bool) {
+	if v {
+		d.Bytes[i/8] |= 0x80 >> (i % 8)
+	} else {
+		d.Bytes[i/8] &^= 0x80 >> (i % 8)
+	}
+}
+
+// Resize returns d cut or padded with zeros on the right to n bits.
+func (d *DBitString) Resize(n int) *DBitString {
+	out := NewDBitString(n)
+	copy(out.Bytes, d.Bytes)
+	for i := n; i < len(out.Bytes)*8; i++ {
+		out.SetBit(i, false)
+	}
+	return out
+}
+
+// Compare orders bit strings as PostgreSQL does: bitwise from the left,
+// with a string sorting before its extensions.
+func (d *DBitString) Compare(other Datum) int {
+	o := other.(*DBitString)
+	// As the bits past Len are zero, bytewise order is bitwise order;
+	// equal bytes leave the shorter string first.
+	if c := bytes.Compare(d.Bytes, o.Bytes); c != 0 {
+		return c
+	}
+	return d.Len - o.Len
+}
+
+func (d *DBitString) String() string {
+	var b strings.Builder
+	b.Grow(d.Len)
+	for i := 0; i < d.Len; i++ {
+		if d.Bit(i) {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+// ParseDBitString parses the binary digits of a bit string, or its hex
+// digits if they follow an x, and checks the result against the length of
+// t: exactly it for bit(n), at most it for varbit(n).
+func ParseDBitString(t *T, s string) (*DBitString, error) {
+	var d *DBitString
+	if len(s) > 0 && (s[0] == 'x' || s[0] == 'X') {
+		hex := s[1:]
+		d = NewDBitString(4 * len(hex))
+		for i, c := range []byte(hex) {
+			var v byte
+			switch {
+			case c >= '0' && c <= '9':
+				v = c - '0'
+			case c >= 'a' && c <= 'f':
+				v = c - 'a' + 10
+			case c >= 'A' && c <= 'F':
+				v = c - 'A' + 10
+			default:
+				return nil, pgerror.Newf(pgerror.CodeInvalidTextRepresentation,
+					"%q is not a valid hexadecimal digit", string(c))
+			}
+			for j := 0; j < 4; j++ {
+				d.SetBit(4*i+j, v&(8>>j) != 0)
+			}
+		}
+	} else {
+		if len(s) > 0 && (s[0] == 'b' || s[0] == 'B') {
+			s = s[1:]
+		}
+		d = NewDBitString(len(s))
+		for i, c := range []byte(s) {
+			switch c {
+			case '0':
+			case '1':
+				d.SetBit(i, true)
+			default:
+				return nil, pgerror.Newf(pgerror.CodeInvalidTextRepresentation,
+					"%q is not a valid binary digit", string(c))
+			}
+		}
+	}
+	return d, CheckBitLength(t, d)
+}
+
+// CheckBitLength reports whether d is too long for t, or of other than its
+// length if t is bit(n).
+func CheckBitLength(t *T, d *DBitString) error {
+	switch {
+	case t.Width == 0:
+		return nil
+	case t.Oid == OidBit && d.Len != int(t.Width):
+		return pgerror.Newf(pgerror.CodeStringDataLengthMismatch,
+			"bit string length %d does not match type %s", d.Len, t)
+	case d.Len > int(t.Width):
+		return pgerror.Newf(pgerror.CodeStringDataRightTruncation,
+			"bit string too long for type %s", t)
+	}
+	return nil
+}
@@ -0,0 +1,425 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DateOutput is the format of dates and times in text, the first part of
+// the DateStyle setting.
+type DateOutput uint8
+
+const (
+	DateISO DateOutput = iota
+	DateSQL
+	DatePostgres
+	DateGerman
+)
+
+// DateOrder is the order of the day, month and year in dates, the second
+// part of the DateStyle setting.
+type DateOrder uint8
+
+const (
+	OrderMDY DateOrder = iota
+	OrderDMY
+	OrderYMD
+)
+
+// DateStyle is the DateStyle setting, e.g. "ISO, MDY", the default and
+// zero value.
+type DateStyle struct {
+	Output DateOutput
+	Order  DateOrder
+}
+
+var (
+	dateOutputNames = [...]string{DateISO: "ISO", DateSQL: "SQL", DatePostgres: "Postgres", DateGerman: "German"}
+	dateOrderNames  = [...]string{OrderMDY: "MDY", OrderDMY: "DMY", OrderYMD: "YMD"}
+)
+
+func (s DateStyle) String() string {
+	return dateOutputNames[s.Output] + ", " + dateOrderNames[s.Order]
+}
+
+// ParseDateStyle parses the values of SET DateStyle, each of which may list
+// several comma-separated keywords, changing cur. A value that sets only
+// the output format keeps the order of cur, except German, which implies
+// DMY, as in PostgreSQL.
+func ParseDateStyle(cur DateStyle, values []string) (DateStyle, error) {
+	s := cur
+	var output, order bool
+	for _, v := range values {
+		for _, word := range strings.Split(v, ",") {
+			word = strings.TrimSpace(word)
+			var conflict bool
+			switch strings.ToLower(word) {
+			case "iso":
+				conflict, output, s.Output = output && s.Output != DateISO, true, DateISO
+			case "sql":
+				conflict, output, s.Output = output && s.Output != DateSQL, true, DateSQL
+			case "postgres":
+				conflict, output, s.Output = output && s.Output != DatePostgres, true, DatePostgres
+			case "german":
+				conflict, output, s.Output = output && s.Output != DateGerman, true, DateGerman
+				if !order {
+					s.Order = OrderDMY
+				}
+			case "mdy", "us", "noneuropean":
+				conflict, order, s.Order = order && s.Order != OrderMDY, true, OrderMDY
+			case "dmy", "euro", "european":
+				conflict, order, s.Order = order && s.Order != OrderDMY, true, OrderDMY
+			case "ymd":
+				conflict, order, s.Order = order && s.Order != OrderYMD, true, OrderYMD
+			case "default":
+				// DEFAULT within a list selects the default of both parts
+				// that the list does not set.
+				if !output {
+					s.Output = DateISO
+				}
+				if !order {
+					s.Order = OrderMDY
+				}
+			default:
+				return cur, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for parameter \"DateStyle\": %q", strings.Join(values, ", "))
+			}
+			if conflict {
+				return cur, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for parameter \"DateStyle\": %q: conflicting \"datestyle\" specifications", strings.Join(values, ", "))
+			}
+		}
+	}
+	return s, nil
+}
+
+// IntervalStyle is the IntervalStyle setting, the format of intervals in
+// text. The zero value is postgres, the default.
+type IntervalStyle uint8
+
+const (
+	IntervalPostgres IntervalStyle = iota
+	IntervalPostgresVerbose
+	IntervalSQLStandard
+	IntervalISO8601
+)
+
+var intervalStyleNames = [...]string{
+	IntervalPostgres:        "postgres",
+	IntervalPostgresVerbose: "postgres_verbose",
+	IntervalSQLStandard:     "sql_standard",
+	IntervalISO8601:         "iso_8601",
+}
+
+func (s IntervalStyle) String() string { return intervalStyleNames[s] }
+
+// ParseIntervalStyle parses the value of SET IntervalStyle.
+func ParseIntervalStyle(v string) (IntervalStyle, error) {
+	for s, name := range intervalStyleNames {
+		if strings.EqualFold(v, name) {
+			return IntervalStyle(s), nil
+		}
+	}
+	return 0, pgerror.Newf(pgerror.CodeInvalidParameterValue,
+		"invalid value for parameter \"IntervalStyle\": %q", v)
+}
+
+// Styles are the settings that change the text of dates, times and
+// intervals. The zero value formats them as String does.
+type Styles struct {
+	Date     DateStyle
+	Interval IntervalStyle
+}
+
+// Format returns the text of d in the styles. Arrays and ranges format
+// their elements in them; other datums are formatted by String.
+func (s Styles) Format(d Datum) string {
+	if s == (Styles{}) {
+		return d.String()
+	}
+	switch v := d.(type) {
+	case DDate:
+		return s.Date.formatDate(v.Time())
+	case DTimestamp:
+		return s.Date.formatTimestamp(v.Time, false)
+	case DTimestampTZ:
+		return s.Date.formatTimestamp(v.Time, true)
+	case DInterval:
+		return v.format(s.Interval)
+	case *DArray:
+		return v.format(s.Format)
+	case *DRange:
+		var b strings.Builder
+		v.format(&b, s.Format)
+		return b.String()
+	case *DMultirange:
+		return v.format(s.Format)
+	}
+	return d.String()
+}
+
+// formatDate formats the date of t, e.g. 12/17/1997 in SQL, MDY.
+func (s DateStyle) formatDate(t time.Time) string {
+	y, m, d := t.Date()
+	bc := ""
+	if y <= 0 {
+		y, bc = 1-y, " BC"
+	}
+	switch s.Output {
+	case DateSQL:
+		if s.Order == OrderDMY {
+			return fmt.Sprintf("%02d/%02d/%04d%s", d, m, y, bc)
+		}
+		return fmt.Sprintf("%02d/%02d/%04d%s", m, d, y, bc)
+	case DatePostgres:
+		if s.Order == OrderDMY {
+			return fmt.Sprintf("%02d-%02d-%04d%s", d, m, y, bc)
+		}
+		return fmt.Sprintf("%02d-%02d-%04d%s", m, d, y, bc)
+	case DateGerman:
+		return fmt.Sprintf("%02d.%02d.%04d%s", d, m, y, bc)
+	}
+	return formatDate(t)
+}
+
+// formatTimestamp formats t, with its zone if tz is set, e.g.
+// "Wed Dec 17 07:37:16 1997 PST" in Postgres, MDY.
+func (s DateStyle) formatTimestamp(t time.Time, tz bool) string {
+	if s.Output == DateISO {
+		if tz {
+			return DTimestampTZ{t}.String()
+		}
+		return DTimestamp{t}.String()
+	}
+	var str string
+	if s.Output == DatePostgres {
+		y, bc := t.Year(), ""
+		if y <= 0 {
+			y, bc = 1-y, " BC"
+		}
+		day := t.Format("Jan 02")
+		if s.Order == OrderDMY {
+			day = t.Format("02 Jan")
+		}
+		str = fmt.Sprintf("%s %s %s %04d", t.Format("Mon"), day, formatClock(t), y)
+		if tz {
+			str += " " + zoneName(t)
+		}
+		return str + bc
+	}
+	date := s.formatDate(t)
+	date, bc := strings.CutSuffix(date, " BC")
+	str = date + " " + formatClock(t)
+	if tz {
+		str += " " + zoneName(t)
+	}
+	if bc {
+		str += " BC"
+	}
+	return str
+}
+
+// zoneName returns the abbreviation of the zone of t, or its offset from
+// UTC if it has none.
+func zoneName(t time.Time) string {
+	name, off := t.Zone()
+	if name != "" && name[0] != '+' && name[0] != '-' {
+		return name
+	}
+	return formatOffset(off)
+}
+
+// formatOffset formats an offset from UTC in seconds as ISO does, e.g.
+// +05:30 or -08.
+func formatOffset(off int) string {
+	sign := "+"
+	if off < 0 {
+		sign = "-"
+		off = -off
+	}
+	s := fmt.Sprintf("%s%02d", sign, off/3600)
+	if m := off % 3600 / 60; m != 0 {
+		s += fmt.Sprintf(":%02d", m)
+	}
+	return s
+}
+
+// format formats the interval in style.
+func (d DInterval) format(style IntervalStyle) string {
+	switch style {
+	case IntervalPostgresVerbose:
+		return d.formatVerbose()
+	case IntervalSQLStandard:
+		return d.formatSQLStandard()
+	case IntervalISO8601:
+		return d.formatISO8601()
+	}
+	return d.String()
+}
+
+// fields splits the interval into the fields PostgreSQL formats: years,
+// months, days, hours, minutes, seconds and microseconds, each with the
+// sign of the part of the interval it comes from.
+func (d DInterval) fields() (year, mon, day, hour, min, sec, frac int64) {
+	us := d.Micros
+	return d.Months / 12, d.Months % 12, d.Days,
+		us / microsPerHour, us % microsPerHour / microsPerMinute, us % microsPerMinute / microsPerSecond, us % microsPerSecond
+}
+
+// formatSeconds formats seconds and microseconds of the same sign, e.g.
+// 6.5, with the seconds zero-padded to width.
+func formatSeconds(sec, frac int64, width int) string {
+	neg := ""
+	if sec < 0 || frac < 0 {
+		neg, sec, frac = "-", -sec, -frac
+	}
+	s := fmt.Sprintf("%s%0*d", neg, width, sec)
+	if frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return s
+}
+
+func abs64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formatVerbose formats the interval in postgres_verbose, e.g.
+// "@ 1 year 2 mons 3 days 4 hours 5 mins 6 secs". The sign of the first
+// field is shown by ending the text with "ago", and the later fields are
+// shown relative to it.
+func (d DInterval) formatVerbose() string {
+	year, mon, day, hour, min, sec, frac := d.fields()
+	var b strings.Builder
+	b.WriteString("@")
+	zero, before := true, false
+	part := func(n int64, unit string) {
+		if n == 0 {
+			return
+		}
+		if zero {
+			before, n = n < 0, abs64(n)
+		} else if before {
+			n = -n
+		}
+		s := "s"
+		if n == 1 {
+			s = ""
+		}
+		fmt.Fprintf(&b, " %d %s%s", n, unit, s)
+		zero = false
+	}
+	part(year, "year")
+	part(mon, "mon")
+	part(day, "day")
+	part(hour, "hour")
+	part(min, "min")
+	if sec != 0 || frac != 0 {
+		b.WriteString(" ")
+		if sec < 0 || frac < 0 {
+			if zero {
+				before = true
+			} else if !before {
+				b.WriteString("-")
+			}
+		} else if before {
+			b.WriteString("-")
+		}
+		b.WriteString(formatSeconds(abs64(sec), abs64(frac), 0))
+		if abs64(sec) == 1 && frac == 0 {
+			b.WriteString(" sec")
+		} else {
+			b.WriteString(" secs")
+		}
+		zero = false
+	}
+	if zero {
+		b.WriteString(" 0")
+	}
+	if before {
+		b.WriteString(" ago")
+	}
+	return b.String()
+}
+
+// formatSQLStandard formats the interval in sql_standard, e.g. "1-2" for
+// a year-month interval and "3 4:05:06" for a day-time one. An interval
+// with both kinds of field, or with fields of both signs, is shown with
+// every field signed, e.g. "+1-2 +3 +4:05:06".
+func (d DInterval) formatSQLStandard() string {
+	year, mon, day, hour, min, sec, frac := d.fields()
+	all := []int64{year, mon, day, hour, min, sec, frac}
+	var negative, positive bool
+	for _, n := range all {
+		negative = negative || n < 0
+		positive = positive || n > 0
+	}
+	yearMonth := year != 0 || mon != 0
+	dayTime := day != 0 || hour != 0 || min != 0 || sec != 0 || frac != 0
+	standard := !(negative && positive) && !(yearMonth && dayTime)
+	if !negative && !positive {
+		return "0"
+	}
+	if !standard {
+		sign := func(neg bool) string {
+			if neg {
+				return "-"
+			}
+			return "+"
+		}
+		return fmt.Sprintf("%s%d-%d %s%d %s%d:%02d:%s",
+			sign(year < 0 || mon < 0), abs64(year), abs64(mon),
+			sign(day < 0), abs64(day),
+			sign(hour < 0 || min < 0 || sec < 0 || frac < 0), abs64(hour), abs64(min), formatSeconds(abs64(sec), abs64(frac), 2))
+	}
+	prefix := ""
+	if negative {
+		prefix = "-"
+		year, mon, day, hour, min, sec, frac = -year, -mon, -day, -hour, -min, -sec, -frac
+	}
+	switch {
+	case yearMonth:
+		return fmt.Sprintf("%s%d-%d", prefix, year, mon)
+	case day != 0:
+		return fmt.Sprintf("%s%d %d:%02d:%s", prefix, day, hour, min, formatSeconds(sec, frac, 2))
+	}
+	return fmt.Sprintf("%s%d:%02d:%s", prefix, hour, min, formatSeconds(sec, frac, 2))
+}
+
+// formatISO8601 formats the interval as an ISO 8601 duration, e.g.
+// P1Y2M3DT4H5M6S, with each field signed separately.
+func (d DInterval) formatISO8601() string {
+	year, mon, day, hour, min, sec, frac := d.fields()
+	if year == 0 && mon == 0 && day == 0 && hour == 0 && min == 0 && sec == 0 && frac == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	b.WriteString("P")
+	for _, f := range []struct {
+		n    int64
+		unit string
+	}{{year, "Y"}, {mon, "M"}, {day, "D"}} {
+		if f.n != 0 {
+			fmt.Fprintf(&b, "%d%s", f.n, f.unit)
+		}
+	}
+	if hour != 0 || min != 0 || sec != 0 || frac != 0 {
+		b.WriteString("T")
+		if hour != 0 {
+			fmt.Fprintf(&b, "%dH", hour)
+		}
+		if min != 0 {
+			fmt.Fprintf(&b, "%dM", min)
+		}
+		if sec != 0 || frac != 0 {
+			b.WriteString(formatSeconds(sec, frac, 0) + "S")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,401 @@
+package types
+
+import (
+	"cmp"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Datum is a single SQL value.
+type Datum interface {
+	// ResolvedType returns the type of the datum.
+	ResolvedType() *T
+	// Compare orders the datum against another non-NULL datum of an
+	// equivalent type, returning -1, 0 or +1.
+	Compare(other Datum) int
+	// String returns the PostgreSQL text representation of the datum.
+	String() string
+}
+
+// dNull is the type of DNull.
+type dNull struct{}
+
+// DNull is the SQL NULL value.
+var DNull Datum = dNull{}
+
+func (dNull) ResolvedType() *T { return Unknown }
+func (dNull) String() string   { return "NULL" }
+
+func (dNull) Compare(other Datum) int {
+	if other == DNull {
+		return 0
+	}
+	return 1
+}
+
+// DBool is a boolean datum.
+type DBool bool
+
+// Boolean datums.
+var (
+	DTrue  = DBool(true)
+	DFalse = DBool(false)
+)
+
+// MakeDBool returns the datum for b.
+func MakeDBool(b bool) DBool { return DBool(b) }
+
+func (d DBool) ResolvedType() *T { return Bool }
+
+func (d DBool) Compare(other Datum) int {
+	o := other.(DBool)
+	switch {
+	case d == o:
+		return 0
+	case !bool(d):
+		return -1
+	}
+	return 1
+}
+
+func (d DBool) String() string {
+	if d {
+		return "t"
+	}
+	return "f"
+}
+
+// DInt is an integer datum. All integer widths share this representation.
+type DInt int64
+
+func (d DInt) ResolvedType() *T { return Int }
+
+func (d DInt) Compare(other Datum) int {
+	switch o := other.(type) {
+	case DInt:
+		return cmp.Compare(d, o)
+	case DFloat:
+		return compareFloat(float64(d), float64(o))
+	case *DDecimal:
+		return NewDecFromInt(int64(d)).Cmp(&o.Dec)
+	}
+	panic(fmt.Sprintf("cannot compare int with %T", other))
+}
+
+func (d DInt) String() string { return strconv.FormatInt(int64(d), 10) }
+
+// DFloat is a floating point datum.
+type DFloat float64
+
+func (d DFloat) ResolvedType() *T { return Float }
+
+func (d DFloat) Compare(other Datum) int {
+	switch o := other.(type) {
+	case DFloat:
+		return compareFloat(float64(d), float64(o))
+	case DInt:
+		return compareFloat(float64(d), float64(o))
+	case *DDecimal:
+		return compareFloat(float64(d), o.Float64())
+	}
+	panic(fmt.Sprintf("cannot compare float with %T", other))
+}
+
+// compareFloat orders NaN after all other values, as PostgreSQL does.
+func compareFloat(a, b float64) int {
+	switch an, bn := math.IsNaN(a), math.IsNaN(b); {
+	case an && bn:
+		return 0
+	case an:
+		return 1
+	case bn:
+		return -1
+	}
+	return cmp.Compare(a, b)
+}
+
+func (d DFloat) String() string {
+	return FormatFloat(float64(d))
+}
+
+// FormatFloat formats f like PostgreSQL's float8out: shortest round-trip
+// digits, switching to exponent notation for very large or small values.
+func FormatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		if math.Signbit(f) {
+			return "-0"
+		}
+		return "0"
+	}
+	exp := int(math.Floor(math.Log10(math.Abs(f))))
+	if exp < -4 || exp >= 15 {
+		return strconv.FormatFloat(f, 'e', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// DDecimal is an arbitrary precision numeric datum.
+type DDecimal struct {
+	Dec
+}
+
+// NewDDecimal wraps d in a datum.
+func NewDDecimal(d *Dec) *DDecimal {
+	return &DDecimal{Dec: *d}
+}
+
+func (d *DDecimal) ResolvedType() *T { return Decimal }
+
+func (d *DDecimal) Compare(other Datum) int {
+	switch o := other.(type) {
+	case *DDecimal:
+		return d.Cmp(&o.Dec)
+	case DInt:
+		return d.Cmp(NewDecFromInt(int64(o)))
+	case DFloat:
+		return compareFloat(d.Float64(), float64(o))
+	}
+	panic(fmt.Sprintf("cannot compare decimal with %T", other))
+}
+
+func (d *DDecimal) String() string { return d.Dec.String() }
+
+// DString is a text datum.
+type DString string
+
+func (d DString) ResolvedType() *T { return String }
+
+func (d DString) Compare(other Datum) int {
+	return strings.Compare(string(d), string(other.(DString)))
+}
+
+func (d DString) String() string { return string(d) }
+
+// DCIText is a citext datum: text that compares case-insensitively.
+type DCIText string
+
+func (d DCIText) ResolvedType() *T { return CIText }
+
+// Compare compares the lower-case forms of the strings, as citext does, so
+// strings differing only in case are equal.
+func (d DCIText) Compare(other Datum) int {
+	return strings.Compare(d.Folded(), other.(DCIText).Folded())
+}
+
+func (d DCIText) String() string { return string(d) }
+
+// Folded returns the lower-case form of d that it compares by.
+func (d DCIText) Folded() string { return strings.ToLower(string(d)) }
+
+// DBytes is a bytea datum. It is a string so datums stay immutable.
+type DBytes string
+
+func (d DBytes) ResolvedType() *T { return Bytes }
+
+func (d DBytes) Compare(other Datum) int {
+	return strings.Compare(string(d), string(other.(DBytes)))
+}
+
+func (d DBytes) String() string {
+	return `\x` + hex.EncodeToString([]byte(d))
+}
+
+// DDate is a date datum, stored as days since 1970-01-01.
+type DDate int64
+
+const secondsPerDay = 24 * 60 * 60
+
+// MakeDDate returns the date containing t.
+func MakeDDate(t time.Time) DDate {
+	y, m, d := t.Date()
+	u := time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix()
+	return DDate(u / secondsPerDay)
+}
+
+// Time returns midnight UTC on the date.
+func (d DDate) Time() time.Time {
+	return time.Unix(int64(d)*secondsPerDay, 0).UTC()
+}
+
+func (d DDate) ResolvedType() *T { return Date }
+
+func (d DDate) Compare(other Datum) int {
+	switch o := other.(type) {
+	case DDate:
+		return cmp.Compare(d, o)
+	case DTimestamp:
+		return d.Time().Compare(o.Time)
+	case DTimestampTZ:
+		return d.Time().Compare(o.Time)
+	}
+	panic(fmt.Sprintf("cannot compare date with %T", other))
+}
+
+func (d DDate) String() string { return formatDate(d.Time()) }
+
+func formatDate(t time.Time) string {
+	if t.Year() <= 0 {
+		return fmt.Sprintf("%04d-%02d-%02d BC", 1-t.Year(), t.Month(), t.Day())
+	}
+	return t.Format("2006-01-02")
+}
+
+// DTimestamp is a timestamp without time zone, stored in UTC.
+type DTimestamp struct {
+	time.Time
+}
+
+// MakeDTimestamp returns the timestamp datum for t with the zone dropped.
+func MakeDTimestamp(t time.Time) DTimestamp {
+	y, mo, d := t.Date()
+	h, mi, s := t.Clock()
+	return DTimestamp{time.Date(y, mo, d, h, mi, s, t.Nanosecond(), time.UTC).Truncate(time.Microsecond)}
+}
+
+func (d DTimestamp) ResolvedType() *T { return Timestamp }
+
+func (d DTimestamp) Compare(other Datum) int {
+	switch o := other.(type) {
+	case DTimestamp:
+		return d.Time.Compare(o.Time)
+	case DTimestampTZ:
+		return d.Time.Compare(o.Time)
+	case DDate:
+		return d.Time.Compare(o.Time())
+	}
+	panic(fmt.Sprintf("cannot compare timestamp with %T", other))
+}
+
+func (d DTimestamp) String() string {
+	return formatDate(d.Time) + " " + formatClock(d.Time)
+}
+
+func formatClock(t time.Time) string {
+	s := t.Format("15:04:05")
+	if us := t.Nanosecond() / 1000; us != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", us), "0")
+	}
+	return s
+}
+
+// DTimestampTZ is a timestamp with time zone. The instant is stored; the
+// location only affects formatting.
+type DTimestampTZ struct {
+	time.Time
+}
+
+// MakeDTimestampTZ returns the timestamptz datum for t.
+func MakeDTimestampTZ(t time.Time) DTimestampTZ {
+	return DTimestampTZ{t.Truncate(time.Microsecond)}
+}
+
+func (d DTimestampTZ) ResolvedType() *T { return TimestampTZ }
+
+func (d DTimestampTZ) Compare(other Datum) int {
+	switch o := other.(type) {
+	case DTimestampTZ:
+		return d.Time.Compare(o.Time)
+	case DTimestamp:
+		return d.Time.Compare(o.Time)
+	case DDate:
+		return d.Time.Compare(o.Time())
+	}
+	panic(fmt.Sprintf("cannot compare timestamptz with %T", other))
+}
+
+func (d DTimestampTZ) String() string {
+	_, off := d.Zone()
+	return formatDate(d.Time) + " " + formatClock(d.Time) + formatOffset(off)
+}
+
+// DInterval is an interval datum. Months, days and microseconds are kept
+// separately because their lengths vary with the calendar.
+type DInterval struct {
+	Months int64
+	Days   int64
+	Micros int64
+}
+
+const (
+	microsPerSecond = int64(time.Second / time.Microsecond)
+	microsPerMinute = 60 * microsPerSecond
+	microsPerHour   = 60 * microsPerMinute
+	microsPerDay    = 24 * microsPerHour
+)
+
+func (d DInterval) ResolvedType() *T { return Interval }
+
+// approxMicros converts d to microseconds assuming 30-day months, which is
+// how PostgreSQL orders intervals.
+func (d DInterval) approxMicros() float64 {
+	return float64(d.Months)*30*float64(microsPerDay) + float64(d.Days)*float64(microsPerDay) + float64(d.Micros)
+}
+
+func (d DInterval) Compare(other Datum) int {
+	return cmp.Compare(d.approxMicros(), other.(DInterval).approxMicros())
+}
+
+func (d DInterval) String() string {
+	var parts []string
+	plural := func(n int64, unit string) string {
+		if n == 1 || n == -1 {
+			return fmt.Sprintf("%d %s", n, unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	if y := d.Months / 12; y != 0 {
+		parts = append(parts, plural(y, "year"))
+	}
+	if m := d.Months % 12; m != 0 {
+		parts = append(parts, plural(m, "mon"))
+	}
+	if d.Days != 0 {
+		parts = append(parts, plural(d.Days, "day"))
+	}
+	if d.Micros != 0 || len(parts) == 0 {
+		us := d.Micros
+		sign := ""
+		if us < 0 {
+			sign = "-"
+			us = -us
+		}
+		h := us / microsPerHour
+		m := us % microsPerHour / microsPerMinute
+		s := us % microsPerMinute / microsPerSecond
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, h, m, s)
+		if frac := us % microsPerSecond; frac != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Duration returns the interval as a time.Duration assuming 30-day months.
+func (d DInterval) Duration() time.Duration {
+	return time.Duration(d.approxMicros()) * time.Microsecond
+}
+
+// CompareDatums orders two datums with NULLs sorting last.
+func CompareDatums(a, b Datum) int {
+	switch {
+	case a == DNull && b == DNull:
+		return 0
+	case a == DNull:
+		return 1
+	case b == DNull:
+		return -1
+	}
+	return a.Compare(b)
+}
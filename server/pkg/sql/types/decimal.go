@@ -0,0 +1,237 @@
+package types
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DivisionScale is the minimum number of fractional digits kept when
+// dividing decimals, matching PostgreSQL's numeric division.
+const DivisionScale = 16
+
+// Dec is an arbitrary precision decimal: Coeff * 10^-Scale.
+type Dec struct {
+	Coeff big.Int
+	Scale int32
+}
+
+var errInvalidDecimal = errors.New("invalid decimal")
+
+var bigTen = big.NewInt(10)
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+// NewDecFromInt returns the decimal value of i.
+func NewDecFromInt(i int64) *Dec {
+	d := &Dec{}
+	d.Coeff.SetInt64(i)
+	return d
+}
+
+// NewDecFromFloat returns the shortest decimal that round trips to f.
+func NewDecFromFloat(f float64) (*Dec, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errInvalidDecimal
+	}
+	return ParseDec(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// ParseDec parses a decimal literal such as "-12.50" or "1.5e3".
+func ParseDec(s string) (*Dec, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errInvalidDecimal
+	}
+	var exp int64
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			return nil, errInvalidDecimal
+		}
+		exp = e
+		s = s[:i]
+	}
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return nil, errInvalidDecimal
+	}
+	digits := intPart + fracPart
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return nil, errInvalidDecimal
+		}
+	}
+	d := &Dec{}
+	if digits == "" {
+		digits = "0"
+	}
+	d.Coeff.SetString(digits, 10)
+	if neg {
+		d.Coeff.Neg(&d.Coeff)
+	}
+	scale := int64(len(fracPart)) - exp
+	if scale < 0 {
+		d.Coeff.Mul(&d.Coeff, pow10(int32(-scale)))
+		scale = 0
+	}
+	d.Scale = int32(scale)
+	return d, nil
+}
+
+// rescaled returns the coefficient of d expressed at the given scale,
+// which must not be smaller than d.Scale.
+func (d *Dec) rescaled(scale int32) *big.Int {
+	if scale == d.Scale {
+		return new(big.Int).Set(&d.Coeff)
+	}
+	return new(big.Int).Mul(&d.Coeff, pow10(scale-d.Scale))
+}
+
+// Round returns d rounded half away from zero to the given scale.
+func (d *Dec) Round(scale int32) *Dec {
+	if scale >= d.Scale {
+		return &Dec{Coeff: *d.rescaled(scale), Scale: scale}
+	}
+	div := pow10(d.Scale - scale)
+	q, r := new(big.Int).QuoRem(&d.Coeff, div, new(big.Int))
+	r.Abs(r).Mul(r, big.NewInt(2))
+	if r.Cmp(div) >= 0 {
+		if d.Coeff.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return &Dec{Coeff: *q, Scale: scale}
+}
+
+// Trunc returns d truncated towards zero at the given scale.
+func (d *Dec) Trunc(scale int32) *Dec {
+	if scale >= d.Scale {
+		return &Dec{Coeff: *d.rescaled(scale), Scale: scale}
+	}
+	q := new(big.Int).Quo(&d.Coeff, pow10(d.Scale-scale))
+	return &Dec{Coeff: *q, Scale: scale}
+}
+
+// Add returns d + o.
+func (d *Dec) Add(o *Dec) *Dec {
+	s := max(d.Scale, o.Scale)
+	r := &Dec{Scale: s}
+	r.Coeff.Add(d.rescaled(s), o.rescaled(s))
+	return r
+}
+
+// Sub returns d - o.
+func (d *Dec) Sub(o *Dec) *Dec {
+	s := max(d.Scale, o.Scale)
+	r := &Dec{Scale: s}
+	r.Coeff.Sub(d.rescaled(s), o.rescaled(s))
+	return r
+}
+
+// Mul returns d * o.
+func (d *Dec) Mul(o *Dec) *Dec {
+	r := &Dec{Scale: d.Scale + o.Scale}
+	r.Coeff.Mul(&d.Coeff, &o.Coeff)
+	return r
+}
+
+// Quo returns d / o rounded to at least DivisionScale fractional digits.
+// The caller must check o for zero.
+func (d *Dec) Quo(o *Dec) *Dec {
+	scale := max(DivisionScale, d.Scale, o.Scale)
+	// Compute with one extra digit so the result can be rounded.
+	num := new(big.Int).Mul(&d.Coeff, pow10(scale+1+o.Scale-d.Scale))
+	q := new(big.Int).Quo(num, &o.Coeff)
+	return (&Dec{Coeff: *q, Scale: scale + 1}).Round(scale)
+}
+
+// Rem returns the remainder of d / o truncated towards zero.
+func (d *Dec) Rem(o *Dec) *Dec {
+	s := max(d.Scale, o.Scale)
+	r := &Dec{Scale: s}
+	r.Coeff.Rem(d.rescaled(s), o.rescaled(s))
+	return r
+}
+
+// Neg returns -d.
+func (d *Dec) Neg() *Dec {
+	r := &Dec{Scale: d.Scale}
+	r.Coeff.Neg(&d.Coeff)
+	return r
+}
+
+// Abs returns |d|.
+func (d *Dec) Abs() *Dec {
+	r := &Dec{Scale: d.Scale}
+	r.Coeff.Abs(&d.Coeff)
+	return r
+}
+
+// Sign returns -1, 0 or +1.
+func (d *Dec) Sign() int {
+	return d.Coeff.Sign()
+}
+
+// IsZero reports whether d is zero.
+func (d *Dec) IsZero() bool {
+	return d.Coeff.Sign() == 0
+}
+
+// Cmp compares d and o.
+func (d *Dec) Cmp(o *Dec) int {
+	s := max(d.Scale, o.Scale)
+	return d.rescaled(s).Cmp(o.rescaled(s))
+}
+
+// Int64 returns d rounded to an integer, and false if it does not fit.
+func (d *Dec) Int64() (int64, bool) {
+	r := d.Round(0)
+	if !r.Coeff.IsInt64() {
+		return 0, false
+	}
+	return r.Coeff.Int64(), true
+}
+
+// Float64 returns the nearest float64 to d.
+func (d *Dec) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// Digits returns the number of significant integer digits in d.
+func (d *Dec) Digits() int32 {
+	abs := new(big.Int).Abs(&d.Coeff)
+	n := int32(len(abs.String())) - d.Scale
+	return max(n, 0)
+}
+
+// String formats d in plain notation, keeping all scale digits.
+func (d *Dec) String() string {
+	s := new(big.Int).Abs(&d.Coeff).String()
+	neg := d.Coeff.Sign() < 0
+	if d.Scale > 0 {
+		if int32(len(s)) <= d.Scale {
+			s = strings.Repeat("0", int(d.Scale)-len(s)+1) + s
+		}
+		s = s[:int32(len(s))-d.Scale] + "." + s[int32(len(s))-d.Scale:]
+	}
+	if neg {
+		return "-" + s
+	}
+	return s
+}
@@ -0,0 +1,109 @@
+package types
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// TypeDef defines a type added by an embedder, such as a geometry or a
+// domain-specific identifier. It plays the role of PostgreSQL's CREATE TYPE
+// with its input, output, send and receive functions.
+//
+// Values of the type are Datums implemented by the embedder: ResolvedType
+// returns the *T that RegisterType returned, Compare orders two values of
+// the type, and String is the text output function.
+type TypeDef struct {
+	// Name is the SQL name of the type, e.g. "geometry".
+	Name string
+	// Aliases are other names the type may be referred to by in SQL.
+	Aliases []string
+	// Oid identifies the type to clients and in stored table descriptors,
+	// so it must not change between runs.
+	Oid Oid
+	// Input parses the text representation of a value.
+	Input func(s string) (Datum, error)
+	// Send and Recv convert values to and from PostgreSQL's binary format.
+	// Values are stored in this format, and are grouped and deduplicated
+	// by it, so equal values must have identical binary forms.
+	Send func(d Datum) []byte
+	Recv func(b []byte) (Datum, error)
+}
+
+// Families from firstExtensionFamily up are assigned to registered types,
+// one each, so that a registered type never shares a family with another
+// type. Built-in families stay below it.
+const firstExtensionFamily Family = 128
+
+// IsExtension reports whether f is the family of a registered type.
+func (f Family) IsExtension() bool { return f >= firstExtensionFamily }
+
+var (
+	typesMu    sync.RWMutex
+	nextFamily = firstExtensionFamily
+)
+
+// RegisterType adds the type defined by def and returns it. Tables with
+// columns of the type can only be read once it is registered, so it is
+// usually called from an init function.
+func RegisterType(def *TypeDef) (*T, error) {
+	if err := def.check(); err != nil {
+		return nil, err
+	}
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	if _, dup := typeOids[def.Oid]; dup {
+		return nil, pgerror.Newf(pgerror.CodeDuplicateObject, "type with OID %d already exists", def.Oid)
+	}
+	names := append([]string{def.Name}, def.Aliases...)
+	for _, name := range names {
+		if _, dup := typeNames[strings.ToLower(name)]; dup {
+			return nil, pgerror.Newf(pgerror.CodeDuplicateObject, "type %q already exists", name)
+		}
+	}
+	if nextFamily == 0 {
+		return nil, pgerror.New(pgerror.CodeProgramLimitExceeded, "too many registered types")
+	}
+	t := &T{Family: nextFamily, Oid: def.Oid, Name: def.Name, def: def}
+	nextFamily++
+	typeOids[t.Oid] = t
+	for _, name := range names {
+		typeNames[strings.ToLower(name)] = t
+	}
+	return t, nil
+}
+
+func (d *TypeDef) check() error {
+	switch {
+	case d == nil:
+		return pgerror.New(pgerror.CodeInvalidObjectDefinition, "type definition is nil")
+	case d.Name == "":
+		return pgerror.New(pgerror.CodeInvalidObjectDefinition, "type name must be specified")
+	case d.Oid == 0:
+		return pgerror.Newf(pgerror.CodeInvalidObjectDefinition, "type %s has no OID", d.Name)
+	case d.Input == nil:
+		return pgerror.Newf(pgerror.CodeInvalidObjectDefinition, "type %s has no input function", d.Name)
+	case d.Send == nil || d.Recv == nil:
+		return pgerror.Newf(pgerror.CodeInvalidObjectDefinition, "type %s must have both send and receive functions", d.Name)
+	}
+	return nil
+}
+
+// Extension returns the definition of a type added with RegisterType, or
+// nil for a built-in type.
+func (t *T) Extension() *TypeDef { return t.def }
+
+// Types returns the canonical type of every OID, built-in and registered,
+// in OID order.
+func Types() []*T {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	out := make([]*T, 0, len(typeOids))
+	for _, t := range typeOids {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Oid < out[j].Oid })
+	return out
+}
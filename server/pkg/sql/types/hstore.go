@@ -0,0 +1,219 @@
+package types
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// HstorePair is a key and its value in an hstore. Null marks a NULL value.
+type HstorePair struct {
+	Key   string
+	Value string
+	Null  bool
+}
+
+// DHstore is a set of key/value pairs with distinct keys, the hstore
+// extension's type. Its pairs are kept in hstore's order: shorter keys
+// first, then keys of the same length bytewise.
+type DHstore struct {
+	Pairs []HstorePair
+}
+
+// NewDHstore returns the hstore of pairs, which need not be sorted. Of
+// pairs with the same key, the first is kept.
+func NewDHstore(pairs []HstorePair) *DHstore {
+	sort.SliceStable(pairs, func(i, j int) bool { return hstoreKeyLess(pairs[i].Key, pairs[j].Key) })
+	out := pairs[:0]
+	for _, p := range pairs {
+		if len(out) > 0 && out[len(out)-1].Key == p.Key {
+			continue
+		}
+		out = append(out, p)
+	}
+	return &DHstore{Pairs: out}
+}
+
+func hstoreKeyLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// Find returns the index of key's pair, or -1.
+func (d *DHstore) Find(key string) int {
+	i := sort.Search(len(d.Pairs), func(i int) bool { return !hstoreKeyLess(d.Pairs[i].Key, key) })
+	if i < len(d.Pairs) && d.Pairs[i].Key == key {
+		return i
+	}
+	return -1
+}
+
+func (d *DHstore) ResolvedType() *T { return Hstore }
+
+// Compare orders hstores by their number of pairs, then pair by pair. The
+// order only serves to sort, group and index hstores.
+func (d *DHstore) Compare(other Datum) int {
+	a, b := d.Pairs, other.(*DHstore).Pairs
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	for i := range a {
+		if c := strings.Compare(a[i].Key, b[i].Key); c != 0 {
+			return c
+		}
+		switch {
+		case a[i].Null && b[i].Null:
+			continue
+		case a[i].Null:
+			return -1
+		case b[i].Null:
+			return 1
+		}
+		if c := strings.Compare(a[i].Value, b[i].Value); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// String formats the hstore as the extension does, e.g. "a"=>"1", "b"=>NULL.
+func (d *DHstore) String() string {
+	var b strings.Builder
+	for i, p := range d.Pairs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeHstoreString(&b, p.Key)
+		b.WriteString("=>")
+		if p.Null {
+			b.WriteString("NULL")
+		} else {
+			writeHstoreString(&b, p.Value)
+		}
+	}
+	return b.String()
+}
+
+func writeHstoreString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+}
+
+// ParseDHstore parses the hstore text format: comma-separated key => value
+// pairs, where keys and values are double-quoted strings with backslash
+// escapes or unquoted words, and an unquoted NULL value is NULL.
+func ParseDHstore(s string) (*DHstore, error) {
+	p := hstoreParser{s: s}
+	var pairs []HstorePair
+	for {
+		p.skipSpace()
+		if p.pos == len(s) {
+			break
+		}
+		key, _, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !strings.HasPrefix(s[p.pos:], "=>") {
+			return nil, p.syntaxError()
+		}
+		p.pos += 2
+		p.skipSpace()
+		val, quoted, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, HstorePair{Key: key, Value: val, Null: !quoted && strings.EqualFold(val, "null")})
+		p.skipSpace()
+		if p.pos == len(s) {
+			break
+		}
+		if s[p.pos] != ',' {
+			return nil, p.syntaxError()
+		}
+		p.pos++
+	}
+	return NewDHstore(pairs), nil
+}
+
+type hstoreParser struct {
+	s   string
+	pos int
+}
+
+func (p *hstoreParser) skipSpace() {
+	for p.pos < len(p.s) && isHstoreSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+func isHstoreSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+// word parses a quoted or unquoted key or value.
+func (p *hstoreParser) word() (string, bool, error) {
+	if p.pos == len(p.s) {
+		return "", false, pgerror.New(pgerror.CodeSyntaxError, "syntax error in hstore: unexpected end of string")
+	}
+	var b strings.Builder
+	if p.s[p.pos] == '"' {
+		p.pos++
+		for {
+			if p.pos == len(p.s) {
+				return "", false, pgerror.New(pgerror.CodeSyntaxError, "syntax error in hstore: unexpected end of string")
+			}
+			c := p.s[p.pos]
+			p.pos++
+			switch c {
+			case '"':
+				return b.String(), true, nil
+			case '\\':
+				if p.pos == len(p.s) {
+					return "", false, pgerror.New(pgerror.CodeSyntaxError, "syntax error in hstore: unexpected end of string")
+				}
+				c = p.s[p.pos]
+				p.pos++
+			}
+			b.WriteByte(c)
+		}
+	}
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if isHstoreSpace(c) || c == ',' || c == '"' || strings.HasPrefix(p.s[p.pos:], "=>") {
+			break
+		}
+		if c == '\\' && p.pos+1 < len(p.s) {
+			p.pos++
+			c = p.s[p.pos]
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+	if p.pos == start {
+		return "", false, p.syntaxError()
+	}
+	return b.String(), false, nil
+}
+
+func (p *hstoreParser) syntaxError() error {
+	if p.pos == len(p.s) {
+		return pgerror.New(pgerror.CodeSyntaxError, "syntax error in hstore: unexpected end of string")
+	}
+	end := p.pos + 1
+	return pgerror.Newf(pgerror.CodeSyntaxError, "syntax error in hstore, near %q at position %d", p.s[p.pos:end], p.pos)
+}
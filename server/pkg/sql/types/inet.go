@@ -0,0 +1,221 @@
+package types
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DInet is an inet or cidr datum: an IPv4 or IPv6 address and the length
+// of its network prefix. Cidr marks cidr values, whose bits past the
+// prefix are zero and which always show the prefix length.
+type DInet struct {
+	Prefix netip.Prefix
+	Cidr   bool
+}
+
+// NewDInet returns the inet or cidr datum of addr with a prefix of bits.
+// A cidr's bits past the prefix are cleared.
+func NewDInet(addr netip.Addr, bits int, cidr bool) *DInet {
+	d := &DInet{Prefix: netip.PrefixFrom(addr, bits), Cidr: cidr}
+	if cidr {
+		d.Prefix = d.Prefix.Masked()
+	}
+	return d
+}
+
+func (d *DInet) ResolvedType() *T {
+	if d.Cidr {
+		return Cidr
+	}
+	return Inet
+}
+
+// Addr returns the address of d.
+func (d *DInet) Addr() netip.Addr { return d.Prefix.Addr() }
+
+// Bits returns the prefix length of d.
+func (d *DInet) Bits() int { return d.Prefix.Bits() }
+
+// MaxBits returns the length of d's addresses: 32 for IPv4, 128 for IPv6.
+func (d *DInet) MaxBits() int { return d.Addr().BitLen() }
+
+// Network returns the address of d's network: its bits past the prefix
+// cleared.
+func (d *DInet) Network() netip.Addr { return d.Prefix.Masked().Addr() }
+
+// Broadcast returns the address of d with its bits past the prefix set.
+func (d *DInet) Broadcast() netip.Addr {
+	b := d.Addr().AsSlice()
+	setBits(b, d.Bits(), len(b)*8, true)
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Mask returns the address with the first n bits set, of the family of d,
+// or its inverse if inverse is set.
+func (d *DInet) Mask(n int, inverse bool) netip.Addr {
+	b := make([]byte, d.MaxBits()/8)
+	setBits(b, 0, n, true)
+	if inverse {
+		for i := range b {
+			b[i] = ^b[i]
+		}
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// setBits sets or clears bits [from, to) of b, counting from the most
+// significant bit of b[0].
+func setBits(b []byte, from, to int, set bool) {
+	for i := from; i < to; i++ {
+		m := byte(0x80) >> (i % 8)
+		if set {
+			b[i/8] |= m
+		} else {
+			b[i/8] &^= m
+		}
+	}
+}
+
+// Contains reports whether d's network contains other's: they are of
+// the same family, other's prefix is at least as long, or longer if
+// strict, and its address is within d's network.
+func (d *DInet) Contains(other *DInet, strict bool) bool {
+	if d.Addr().Is4() != other.Addr().Is4() {
+		return false
+	}
+	if other.Bits() < d.Bits() || strict && other.Bits() == d.Bits() {
+		return false
+	}
+	return d.Prefix.Masked().Contains(other.Addr())
+}
+
+// Overlaps reports whether either of d and other contains the other.
+func (d *DInet) Overlaps(other *DInet) bool {
+	return d.Contains(other, false) || other.Contains(d, false)
+}
+
+// Compare orders inet values as PostgreSQL does: IPv4 before IPv6, then by
+// the network part common to both prefix lengths, then by prefix length,
+// then by the whole address.
+func (d *DInet) Compare(other Datum) int {
+	o := other.(*DInet)
+	if d.Addr().Is4() != o.Addr().Is4() {
+		if d.Addr().Is4() {
+			return -1
+		}
+		return 1
+	}
+	a, b := d.Addr().AsSlice(), o.Addr().AsSlice()
+	n := min(d.Bits(), o.Bits())
+	ma, mb := bytes.Clone(a), bytes.Clone(b)
+	setBits(ma, n, len(ma)*8, false)
+	setBits(mb, n, len(mb)*8, false)
+	if c := bytes.Compare(ma, mb); c != 0 {
+		return c
+	}
+	if c := d.Bits() - o.Bits(); c != 0 {
+		return c
+	}
+	return bytes.Compare(a, b)
+}
+
+// String formats d as PostgreSQL does: an inet omits a prefix length
+// covering the whole address.
+func (d *DInet) String() string {
+	if !d.Cidr && d.Bits() == d.MaxBits() {
+		return d.Addr().String()
+	}
+	return d.Prefix.String()
+}
+
+// ParseDInet parses an inet or cidr value, address[/bits], as type t. A
+// missing prefix length covers the whole address; a cidr must not have
+// bits set past its prefix.
+func ParseDInet(t *T, s string) (*DInet, error) {
+	s = strings.TrimSpace(s)
+	addrPart, bitsPart, hasBits := strings.Cut(s, "/")
+	addr, err := netip.ParseAddr(addrPart)
+	if err != nil || addr.Zone() != "" {
+		return nil, invalidSyntax(t, s)
+	}
+	bits := addr.BitLen()
+	if hasBits {
+		if bits, err = strconv.Atoi(bitsPart); err != nil || bits < 0 || bits > addr.BitLen() {
+			return nil, invalidSyntax(t, s)
+		}
+	}
+	d := &DInet{Prefix: netip.PrefixFrom(addr, bits), Cidr: t.Oid == OidCidr}
+	if d.Cidr && d.Prefix.Masked() != d.Prefix {
+		return nil, pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid cidr value: %q", s).
+			WithDetail("Value has bits set to right of mask.")
+	}
+	return d, nil
+}
+
+// DMacaddr is a macaddr datum, a 6-byte MAC address.
+type DMacaddr [6]byte
+
+func (d DMacaddr) ResolvedType() *T { return Macaddr }
+
+func (d DMacaddr) Compare(other Datum) int {
+	o := other.(DMacaddr)
+	return bytes.Compare(d[:], o[:])
+}
+
+func (d DMacaddr) String() string {
+	var b strings.Builder
+	for i, c := range d {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(hex.EncodeToString([]byte{c}))
+	}
+	return b.String()
+}
+
+// ParseDMacaddr parses a MAC address in any of the formats PostgreSQL
+// accepts: six groups of two hex digits separated by : or -, two groups of
+// six separated by : or -, three groups of four separated by . or -, or
+// twelve digits.
+func ParseDMacaddr(s string) (DMacaddr, error) {
+	var d DMacaddr
+	s = strings.TrimSpace(s)
+	sep := strings.IndexAny(s, ":-.")
+	var groups []string
+	if sep < 0 {
+		groups = []string{s}
+	} else {
+		groups = strings.Split(s, s[sep:sep+1])
+	}
+	size := 0
+	switch len(groups) {
+	case 1:
+		size = 12
+	case 2:
+		size = 6
+	case 3:
+		size = 4
+	case 6:
+		size = 2
+	}
+	dot := sep >= 0 && s[sep] == '.'
+	if size == 0 || dot && size != 4 {
+		return d, invalidSyntax(Macaddr, s)
+	}
+	for _, g := range groups {
+		if len(g) != size {
+			return d, invalidSyntax(Macaddr, s)
+		}
+	}
+	if _, err := hex.Decode(d[:], []byte(strings.Join(groups, ""))); err != nil {
+		return d, invalidSyntax(Macaddr, s)
+	}
+	return d, nil
+}
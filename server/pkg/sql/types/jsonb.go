@@ -0,0 +1,149 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DJSONB is a jsonb datum. It is kept as the text PostgreSQL outputs for
+// it: object keys in jsonb's order, the same as hstore's, with the last
+// of duplicate keys kept, numbers as numeric formats them, and ", " and
+// ": " between elements.
+type DJSONB string
+
+func (d DJSONB) ResolvedType() *T { return JSONB }
+
+// Compare orders jsonb values by their text. The order only serves to
+// sort, group and index them, and is not PostgreSQL's.
+func (d DJSONB) Compare(other Datum) int {
+	return strings.Compare(string(d), string(other.(DJSONB)))
+}
+
+func (d DJSONB) String() string { return string(d) }
+
+// ParseDJSONB parses JSON text into a jsonb value.
+func ParseDJSONB(s string) (DJSONB, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	err := dec.Decode(&v)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = errors.New("trailing data")
+		}
+	}
+	if err != nil {
+		return "", pgerror.New(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type json").
+			WithDetail(err.Error())
+	}
+	var b strings.Builder
+	if err := writeJSONB(&b, v); err != nil {
+		return "", err
+	}
+	return DJSONB(b.String()), nil
+}
+
+func writeJSONB(b *strings.Builder, v any) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		if v {
+			b.WriteString("true")
+		} else {
+			b.WriteString("false")
+		}
+	case json.Number:
+		d, err := ParseDec(string(v))
+		if err != nil {
+			return pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type json: %q", string(v))
+		}
+		b.WriteString(d.String())
+	case string:
+		if strings.ContainsRune(v, 0) {
+			return pgerror.New(pgerror.CodeUntranslatableCharacter, "unsupported Unicode escape sequence").
+				WithDetail(`\u0000 cannot be converted to text.`)
+		}
+		WriteJSONString(b, v)
+	case []any:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if err := writeJSONB(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			switch {
+			case hstoreKeyLess(a, b):
+				return -1
+			case hstoreKeyLess(b, a):
+				return 1
+			}
+			return 0
+		})
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			WriteJSONString(b, k)
+			b.WriteString(": ")
+			if err := writeJSONB(b, v[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	}
+	return nil
+}
+
+// WriteJSONString writes s to b as a JSON string, escaping as PostgreSQL
+// does: quotes, backslashes and control characters, but not the rest of
+// Unicode.
+func WriteJSONString(b *strings.Builder, s string) {
+	const hex = "0123456789abcdef"
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\u00`)
+				b.WriteByte(hex[r>>4])
+				b.WriteByte(hex[r&0xf])
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+}
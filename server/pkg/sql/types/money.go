@@ -0,0 +1,117 @@
+package types
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DMoney is a money datum, an amount in cents. It is formatted as
+// PostgreSQL formats money in the C locale, e.g. $1,234.56.
+type DMoney int64
+
+func (d DMoney) ResolvedType() *T { return Money }
+
+func (d DMoney) Compare(other Datum) int {
+	o := other.(DMoney)
+	switch {
+	case d < o:
+		return -1
+	case d > o:
+		return 1
+	}
+	return 0
+}
+
+func (d DMoney) String() string {
+	u := uint64(d)
+	sign := ""
+	if d < 0 {
+		sign, u = "-", -u
+	}
+	units := strconv.FormatUint(u/100, 10)
+	var b strings.Builder
+	b.WriteString(sign)
+	b.WriteByte('$')
+	for i, c := range units {
+		if i > 0 && (len(units)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	cents := u % 100
+	b.WriteByte('.')
+	b.WriteByte(byte('0' + cents/10))
+	b.WriteByte(byte('0' + cents%10))
+	return b.String()
+}
+
+// MoneyRangeError is the error for an amount that does not fit in money.
+func MoneyRangeError() error {
+	return pgerror.New(pgerror.CodeNumericValueOutOfRange, "money out of range")
+}
+
+// ParseDMoney parses a money value: an optionally signed amount, with an
+// optional $ and thousands separators, or a negative amount written in
+// parentheses. Digits past the cents are rounded.
+func ParseDMoney(s string) (DMoney, error) {
+	in := s
+	s = strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		neg, s = true, strings.TrimSpace(s[1:len(s)-1])
+	}
+	if rest, ok := strings.CutPrefix(s, "-"); ok && !neg {
+		neg, s = true, strings.TrimSpace(rest)
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+	s = strings.TrimPrefix(s, "$")
+	units, frac, _ := strings.Cut(s, ".")
+	units = strings.ReplaceAll(units, ",", "")
+	if units == "" && frac == "" {
+		return 0, invalidSyntax(Money, in)
+	}
+	for _, part := range []string{units, frac} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, invalidSyntax(Money, in)
+			}
+		}
+	}
+	// Accumulate the magnitude in cents, which may be one more than
+	// MaxInt64 for the most negative amount.
+	limit := uint64(math.MaxInt64)
+	if neg {
+		limit++
+	}
+	digits := units
+	if len(frac) < 2 {
+		frac += "00"[len(frac):]
+	}
+	digits += frac[:2]
+	var cents uint64
+	for _, c := range digits {
+		d := uint64(c - '0')
+		if cents > (limit-d)/10 {
+			return 0, moneyInputRangeError(in)
+		}
+		cents = cents*10 + d
+	}
+	if frac[2:] != "" && frac[2] >= '5' {
+		if cents == limit {
+			return 0, moneyInputRangeError(in)
+		}
+		cents++
+	}
+	if neg {
+		return DMoney(-cents), nil
+	}
+	return DMoney(cents), nil
+}
+
+func moneyInputRangeError(s string) error {
+	return pgerror.Newf(pgerror.CodeNumericValueOutOfRange, "value %q is out of range for type money", s)
+}
@@ -0,0 +1,408 @@
+package types
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// ParseDatum parses the PostgreSQL text representation of a value of type t.
+func ParseDatum(t *T, s string) (Datum, error) {
+	switch t.Family {
+	case BoolFamily:
+		return ParseDBool(s)
+	case IntFamily:
+		return ParseDInt(t, s)
+	case FloatFamily:
+		return ParseDFloat(s)
+	case DecimalFamily:
+		d, err := ParseDec(s)
+		if err != nil {
+			return nil, invalidSyntax(t, s)
+		}
+		return NewDDecimal(d), nil
+	case StringFamily, UnknownFamily, AnyFamily:
+		return DString(s), nil
+	case BytesFamily:
+		return ParseDBytes(s)
+	case DateFamily:
+		return ParseDDate(s)
+	case TimestampFamily:
+		tm, err := parseTimestamp(s, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		return MakeDTimestamp(tm), nil
+	case TimestampTZFamily:
+		tm, err := parseTimestamp(s, time.UTC)
+		if err != nil {
+			return nil, err
+		}
+		return MakeDTimestampTZ(tm), nil
+	case IntervalFamily:
+		return ParseDInterval(s)
+	case VectorFamily:
+		v, err := ParseDVector(s)
+		if err != nil {
+			return nil, err
+		}
+		return CheckVectorDims(t, v)
+	case HstoreFamily:
+		return ParseDHstore(s)
+	case CITextFamily:
+		return DCIText(s), nil
+	case InetFamily:
+		return ParseDInet(t, s)
+	case MacaddrFamily:
+		return ParseDMacaddr(s)
+	case MoneyFamily:
+		return ParseDMoney(s)
+	case BitFamily:
+		return ParseDBitString(t, s)
+	case XMLFamily:
+		return ParseDXML(s, false)
+	case JSONBFamily:
+		return ParseDJSONB(s)
+	case RangeFamily:
+		return ParseDRange(t, s)
+	case MultirangeFamily:
+		return ParseDMultirange(t, s)
+	}
+	if t.def != nil {
+		return t.def.Input(s)
+	}
+	return nil, pgerror.Newf(pgerror.CodeFeatureNotSupported, "cannot parse values of type %s", t)
+}
+
+// CheckText returns an error if s cannot be text: if it is not valid
+// UTF-8, which rejects encoded surrogates too, or holds a NUL byte, which
+// PostgreSQL's text cannot hold either.
+func CheckText(s string) error {
+	if utf8.ValidString(s) && strings.IndexByte(s, 0) < 0 {
+		return nil
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if c != 0 && (r != utf8.RuneError || size > 1) {
+			i += size
+			continue
+		}
+		// The error shows as many bytes as the first byte of the sequence
+		// says it has.
+		n := 1
+		switch {
+		case c >= 0xf8:
+		case c >= 0xf0:
+			n = 4
+		case c >= 0xe0:
+			n = 3
+		case c >= 0xc0:
+			n = 2
+		}
+		seq := make([]string, 0, n)
+		for _, b := range []byte(s[i:min(i+n, len(s))]) {
+			seq = append(seq, fmt.Sprintf("0x%02x", b))
+		}
+		return pgerror.Newf(pgerror.CodeCharacterNotInRepertoire, "invalid byte sequence for encoding \"UTF8\": %s", strings.Join(seq, " "))
+	}
+	return nil
+}
+
+func invalidSyntax(t *T, s string) error {
+	name := t.Name
+	switch t.Family {
+	case IntFamily:
+		name = "integer"
+	case FloatFamily:
+		name = "double precision"
+	case BoolFamily:
+		name = "boolean"
+	}
+	return pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "invalid input syntax for type %s: %q", name, s)
+}
+
+// ParseDBool parses a boolean literal.
+func ParseDBool(s string) (DBool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "t", "true", "y", "yes", "on", "1":
+		return DTrue, nil
+	case "f", "false", "n", "no", "off", "0":
+		return DFalse, nil
+	}
+	return DFalse, invalidSyntax(Bool, s)
+}
+
+// ParseDInt parses an integer literal, checking the range of t.
+func ParseDInt(t *T, s string) (DInt, error) {
+	i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok && ne.Err == strconv.ErrRange {
+			return 0, pgerror.Newf(pgerror.CodeNumericValueOutOfRange, "value %q is out of range for type %s", s, t.Name)
+		}
+		return 0, invalidSyntax(t, s)
+	}
+	return CheckIntWidth(t, i)
+}
+
+// CheckIntWidth returns i as a datum if it fits in t.
+func CheckIntWidth(t *T, i int64) (DInt, error) {
+	var lo, hi int64 = math.MinInt64, math.MaxInt64
+	switch t.Width {
+	case 16:
+		lo, hi = math.MinInt16, math.MaxInt16
+	case 32:
+		lo, hi = math.MinInt32, math.MaxInt32
+	}
+	if t.Oid == OidOid {
+		lo, hi = 0, math.MaxUint32
+	}
+	if i < lo || i > hi {
+		return 0, IntRangeError(t)
+	}
+	return DInt(i), nil
+}
+
+// IntRangeError returns the error for a value that does not fit in t.
+func IntRangeError(t *T) error {
+	name := map[int32]string{16: "smallint", 32: "integer", 64: "bigint"}[t.Width]
+	if t.Oid == OidOid {
+		name = "OID"
+	}
+	return pgerror.Newf(pgerror.CodeNumericValueOutOfRange, "%s out of range", name)
+}
+
+// ParseDFloat parses a floating point literal.
+func ParseDFloat(s string) (DFloat, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "nan":
+		return DFloat(math.NaN()), nil
+	case "infinity", "+infinity", "inf":
+		return DFloat(math.Inf(1)), nil
+	case "-infinity", "-inf":
+		return DFloat(math.Inf(-1)), nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, invalidSyntax(Float, s)
+	}
+	return DFloat(f), nil
+}
+
+// ParseDBytes parses the hex (\x...) or escape format of bytea.
+func ParseDBytes(s string) (DBytes, error) {
+	if strings.HasPrefix(s, `\x`) {
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return "", invalidSyntax(Bytes, s)
+		}
+		return DBytes(b), nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch {
+		case i+1 < len(s) && s[i+1] == '\\':
+			b.WriteByte('\\')
+			i++
+		case i+3 < len(s):
+			v, err := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			if err != nil {
+				return "", invalidSyntax(Bytes, s)
+			}
+			b.WriteByte(byte(v))
+			i += 3
+		default:
+			return "", invalidSyntax(Bytes, s)
+		}
+	}
+	return DBytes(b.String()), nil
+}
+
+// ParseDDate parses a date in ISO format.
+func ParseDDate(s string) (DDate, error) {
+	s = strings.TrimSpace(s)
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		tm, terr := parseTimestamp(s, time.UTC)
+		if terr != nil {
+			return 0, pgerror.Newf(pgerror.CodeInvalidDatetimeFormat, "invalid input syntax for type date: %q", s)
+		}
+		t = tm
+	}
+	return MakeDDate(t), nil
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999-0700",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "epoch":
+		return time.Unix(0, 0).UTC(), nil
+	case "infinity", "-infinity":
+		return time.Time{}, pgerror.Newf(pgerror.CodeFeatureNotSupported, "infinite timestamps are not supported")
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, pgerror.Newf(pgerror.CodeInvalidDatetimeFormat, "invalid input syntax for type timestamp: %q", s)
+}
+
+var intervalUnits = map[string]DInterval{
+	"microsecond": {Micros: 1},
+	"millisecond": {Micros: 1000},
+	"second":      {Micros: microsPerSecond},
+	"minute":      {Micros: microsPerMinute},
+	"hour":        {Micros: microsPerHour},
+	"day":         {Days: 1},
+	"week":        {Days: 7},
+	"month":       {Months: 1},
+	"mon":         {Months: 1},
+	"year":        {Months: 12},
+	"decade":      {Months: 120},
+	"century":     {Months: 1200},
+}
+
+func lookupIntervalUnit(u string) (DInterval, bool) {
+	u = strings.ToLower(u)
+	switch u {
+	case "us", "usec", "usecs":
+		u = "microsecond"
+	case "ms", "msec", "msecs":
+		u = "millisecond"
+	case "s", "sec", "secs":
+		u = "second"
+	case "m", "min", "mins":
+		u = "minute"
+	case "h", "hr", "hrs":
+		u = "hour"
+	case "d":
+		u = "day"
+	case "w":
+		u = "week"
+	case "y", "yr", "yrs":
+		u = "year"
+	case "mons":
+		u = "mon"
+	}
+	if v, ok := intervalUnits[u]; ok {
+		return v, true
+	}
+	v, ok := intervalUnits[strings.TrimSuffix(u, "s")]
+	return v, ok
+}
+
+// ParseDInterval parses intervals in PostgreSQL's verbose format, e.g.
+// "1 year 2 mons 3 days 04:05:06" or "90 minutes".
+func ParseDInterval(s string) (DInterval, error) {
+	var iv DInterval
+	bad := func() (DInterval, error) {
+		return DInterval{}, pgerror.Newf(pgerror.CodeInvalidDatetimeFormat, "invalid input syntax for type interval: %q", s)
+	}
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(s), "@"))
+	if len(fields) == 0 {
+		return bad()
+	}
+	ago := false
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if strings.EqualFold(f, "ago") {
+			ago = true
+			continue
+		}
+		if strings.Contains(f, ":") {
+			us, ok := parseClock(f)
+			if !ok {
+				return bad()
+			}
+			iv.Micros += us
+			continue
+		}
+		// A number may be followed by its unit with or without a space.
+		num, unit := f, ""
+		if j := strings.IndexFunc(f, func(r rune) bool { return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' }); j > 0 {
+			num, unit = f[:j], f[j:]
+		} else if i+1 < len(fields) {
+			i++
+			unit = fields[i]
+		}
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			return bad()
+		}
+		u, ok := lookupIntervalUnit(unit)
+		if !ok && unit == "" {
+			u, ok = DInterval{Micros: microsPerSecond}, true
+		}
+		if !ok {
+			return bad()
+		}
+		iv = addScaledInterval(iv, u, v)
+	}
+	if ago {
+		iv = DInterval{Months: -iv.Months, Days: -iv.Days, Micros: -iv.Micros}
+	}
+	return iv, nil
+}
+
+// addScaledInterval adds unit*v to iv, cascading fractional months and
+// days down to smaller fields like PostgreSQL does.
+func addScaledInterval(iv, unit DInterval, v float64) DInterval {
+	months := float64(unit.Months) * v
+	wholeMonths := math.Trunc(months)
+	days := float64(unit.Days)*v + (months-wholeMonths)*30
+	wholeDays := math.Trunc(days)
+	micros := float64(unit.Micros)*v + (days-wholeDays)*float64(microsPerDay)
+	iv.Months += int64(wholeMonths)
+	iv.Days += int64(wholeDays)
+	iv.Micros += int64(math.Round(micros))
+	return iv
+}
+
+func parseClock(s string) (int64, bool) {
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimLeft(s, "+-")
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+	h, err1 := strconv.ParseInt(parts[0], 10, 64)
+	m, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	us := h*microsPerHour + m*microsPerMinute
+	if len(parts) == 3 {
+		sec, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil {
+			return 0, false
+		}
+		us += int64(math.Round(sec * float64(microsPerSecond)))
+	}
+	if neg {
+		us = -us
+	}
+	return us, true
+}
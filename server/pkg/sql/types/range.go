@@ -0,0 +1,594 @@
+package types
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DRange is a range datum: the values between Lower and Upper. A nil bound
+// leaves the range unbounded on that side, and is never inclusive. Ranges
+// are kept in canonical form: an empty range has no bounds, and ranges of
+// discrete subtypes have an inclusive lower and an exclusive upper bound.
+type DRange struct {
+	Typ                *T
+	Lower, Upper       Datum
+	LowerInc, UpperInc bool
+	Empty              bool
+}
+
+// EmptyRange returns the empty range of type t.
+func EmptyRange(t *T) *DRange {
+	return &DRange{Typ: t, Empty: true}
+}
+
+// discrete reports whether t is a subtype whose ranges are canonicalized
+// to [lower, upper).
+func discrete(t *T) bool {
+	return t.Family == IntFamily || t.Family == DateFamily
+}
+
+// nextValue returns the value following d in the discrete subtype t.
+func nextValue(t *T, d Datum) (Datum, error) {
+	switch v := d.(type) {
+	case DInt:
+		if v == math.MaxInt64 {
+			return nil, IntRangeError(t)
+		}
+		return CheckIntWidth(t, int64(v)+1)
+	case DDate:
+		return v + 1, nil
+	}
+	return d, nil
+}
+
+// MakeDRange returns the range of type t between lower and upper in
+// canonical form. Nil bounds are unbounded.
+func MakeDRange(t *T, lower, upper Datum, lowerInc, upperInc bool) (*DRange, error) {
+	if lower != nil && upper != nil && lower.Compare(upper) > 0 {
+		return nil, pgerror.New(pgerror.CodeDataException,
+			"range lower bound must be less than or equal to range upper bound")
+	}
+	r := &DRange{Typ: t, Lower: lower, Upper: upper, LowerInc: lowerInc && lower != nil, UpperInc: upperInc && upper != nil}
+	if discrete(t.RangeContents) {
+		var err error
+		if r.Lower != nil && !r.LowerInc {
+			if r.Lower, err = nextValue(t.RangeContents, r.Lower); err != nil {
+				return nil, err
+			}
+			r.LowerInc = true
+		}
+		if r.Upper != nil && r.UpperInc {
+			if r.Upper, err = nextValue(t.RangeContents, r.Upper); err != nil {
+				return nil, err
+			}
+			r.UpperInc = false
+		}
+	}
+	if r.Lower != nil && r.Upper != nil {
+		if c := r.Lower.Compare(r.Upper); c > 0 || c == 0 && !(r.LowerInc && r.UpperInc) {
+			return EmptyRange(t), nil
+		}
+	}
+	return r, nil
+}
+
+func (d *DRange) ResolvedType() *T { return d.Typ }
+
+// RangeBound is one bound of a range.
+type RangeBound struct {
+	// Val is nil for an unbounded side.
+	Val       Datum
+	Inclusive bool
+	Lower     bool
+}
+
+// LowerBound returns the lower bound of a non-empty range.
+func (d *DRange) LowerBound() RangeBound {
+	return RangeBound{Val: d.Lower, Inclusive: d.LowerInc, Lower: true}
+}
+
+// UpperBound returns the upper bound of a non-empty range.
+func (d *DRange) UpperBound() RangeBound { return RangeBound{Val: d.Upper, Inclusive: d.UpperInc} }
+
+// CompareBounds orders two range bounds, either of which may be a lower or
+// upper bound, by the values they admit: a lower bound admitting a value
+// sorts before one that does not, and an upper bound after.
+func CompareBounds(a, b RangeBound) int {
+	switch {
+	case a.Val == nil && b.Val == nil:
+		if a.Lower == b.Lower {
+			return 0
+		}
+		return infSign(a)
+	case a.Val == nil:
+		return infSign(a)
+	case b.Val == nil:
+		return -infSign(b)
+	}
+	if c := a.Val.Compare(b.Val); c != 0 {
+		return c
+	}
+	switch {
+	case !a.Inclusive && !b.Inclusive:
+		if a.Lower == b.Lower {
+			return 0
+		}
+		return exclusiveSign(a)
+	case !a.Inclusive:
+		return exclusiveSign(a)
+	case !b.Inclusive:
+		return -exclusiveSign(b)
+	}
+	return 0
+}
+
+// infSign is the order of an unbounded bound against a bounded one.
+func infSign(b RangeBound) int {
+	if b.Lower {
+		return -1
+	}
+	return 1
+}
+
+// exclusiveSign is the order of an exclusive bound against an inclusive
+// one at the same value.
+func exclusiveSign(b RangeBound) int {
+	if b.Lower {
+		return 1
+	}
+	return -1
+}
+
+// rangeFromBounds returns the range between two bounds.
+func rangeFromBounds(t *T, lower, upper RangeBound) (*DRange, error) {
+	return MakeDRange(t, lower.Val, upper.Val, lower.Inclusive, upper.Inclusive)
+}
+
+// Compare orders ranges by lower bound, then upper bound, with the empty
+// range first.
+func (d *DRange) Compare(other Datum) int {
+	o := other.(*DRange)
+	switch {
+	case d.Empty && o.Empty:
+		return 0
+	case d.Empty:
+		return -1
+	case o.Empty:
+		return 1
+	}
+	if c := CompareBounds(d.LowerBound(), o.LowerBound()); c != 0 {
+		return c
+	}
+	return CompareBounds(d.UpperBound(), o.UpperBound())
+}
+
+// String formats the range as PostgreSQL does, e.g. [1,10) or empty.
+func (d *DRange) String() string {
+	if d.Empty {
+		return "empty"
+	}
+	var b strings.Builder
+	d.format(&b, Datum.String)
+	return b.String()
+}
+
+// format writes the range to b with its bounds formatted by elem.
+func (d *DRange) format(b *strings.Builder, elem func(Datum) string) {
+	if d.Empty {
+		b.WriteString("empty")
+		return
+	}
+	if d.LowerInc {
+		b.WriteByte('[')
+	} else {
+		b.WriteByte('(')
+	}
+	if d.Lower != nil {
+		writeRangeBound(b, elem(d.Lower))
+	}
+	b.WriteByte(',')
+	if d.Upper != nil {
+		writeRangeBound(b, elem(d.Upper))
+	}
+	if d.UpperInc {
+		b.WriteByte(']')
+	} else {
+		b.WriteByte(')')
+	}
+}
+
+// writeRangeBound writes s, double-quoted if it would otherwise be
+// ambiguous in range syntax.
+func writeRangeBound(b *strings.Builder, s string) {
+	if s != "" && !strings.ContainsAny(s, "()[],\"\\ \t\n\r\v\f") {
+		b.WriteString(s)
+		return
+	}
+	b.WriteByte('"')
+	for _, c := range s {
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+}
+
+// ContainsElem reports whether v lies within d.
+func (d *DRange) ContainsElem(v Datum) bool {
+	if d.Empty {
+		return false
+	}
+	if d.Lower != nil {
+		if c := d.Lower.Compare(v); c > 0 || c == 0 && !d.LowerInc {
+			return false
+		}
+	}
+	if d.Upper != nil {
+		if c := d.Upper.Compare(v); c < 0 || c == 0 && !d.UpperInc {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether every value of o lies within d.
+func (d *DRange) Contains(o *DRange) bool {
+	switch {
+	case o.Empty:
+		return true
+	case d.Empty:
+		return false
+	}
+	return CompareBounds(d.LowerBound(), o.LowerBound()) <= 0 &&
+		CompareBounds(d.UpperBound(), o.UpperBound()) >= 0
+}
+
+// Overlaps reports whether d and o have a value in common.
+func (d *DRange) Overlaps(o *DRange) bool {
+	if d.Empty || o.Empty {
+		return false
+	}
+	return CompareBounds(d.LowerBound(), o.UpperBound()) <= 0 &&
+		CompareBounds(o.LowerBound(), d.UpperBound()) <= 0
+}
+
+// Before reports whether every value of d is less than every value of o.
+func (d *DRange) Before(o *DRange) bool {
+	if d.Empty || o.Empty {
+		return false
+	}
+	return CompareBounds(d.UpperBound(), o.LowerBound()) < 0
+}
+
+// OverLeft reports whether d does not extend to the right of o.
+func (d *DRange) OverLeft(o *DRange) bool {
+	if d.Empty || o.Empty {
+		return false
+	}
+	return CompareBounds(d.UpperBound(), o.UpperBound()) <= 0
+}
+
+// OverRight reports whether d does not extend to the left of o.
+func (d *DRange) OverRight(o *DRange) bool {
+	if d.Empty || o.Empty {
+		return false
+	}
+	return CompareBounds(d.LowerBound(), o.LowerBound()) >= 0
+}
+
+// Adjacent reports whether d and o meet without a gap or an overlap.
+func (d *DRange) Adjacent(o *DRange) bool {
+	if d.Empty || o.Empty {
+		return false
+	}
+	touches := func(upper, lower RangeBound) bool {
+		return upper.Val != nil && lower.Val != nil && upper.Val.Compare(lower.Val) == 0 &&
+			upper.Inclusive != lower.Inclusive
+	}
+	return touches(d.UpperBound(), o.LowerBound()) || touches(o.UpperBound(), d.LowerBound())
+}
+
+// lowerOf and upperOf pick the outermost of two bounds.
+func lowerOf(a, b RangeBound) RangeBound {
+	if CompareBounds(a, b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func upperOf(a, b RangeBound) RangeBound {
+	if CompareBounds(a, b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// Merge returns the smallest range containing both d and o.
+func (d *DRange) Merge(o *DRange) (*DRange, error) {
+	switch {
+	case d.Empty:
+		return o, nil
+	case o.Empty:
+		return d, nil
+	}
+	return rangeFromBounds(d.Typ, lowerOf(d.LowerBound(), o.LowerBound()), upperOf(d.UpperBound(), o.UpperBound()))
+}
+
+// Union returns the values in d or o, which must form a single range.
+func (d *DRange) Union(o *DRange) (*DRange, error) {
+	if !d.Empty && !o.Empty && !d.Overlaps(o) && !d.Adjacent(o) {
+		return nil, pgerror.New(pgerror.CodeDataException, "result of range union would not be contiguous")
+	}
+	return d.Merge(o)
+}
+
+// Intersect returns the values in both d and o.
+func (d *DRange) Intersect(o *DRange) (*DRange, error) {
+	if !d.Overlaps(o) {
+		return EmptyRange(d.Typ), nil
+	}
+	return rangeFromBounds(d.Typ, upperOf(d.LowerBound(), o.LowerBound()), lowerOf(d.UpperBound(), o.UpperBound()))
+}
+
+// Minus returns the values of d that are not in o, as up to two ranges in
+// order.
+func (d *DRange) Minus(o *DRange) ([]*DRange, error) {
+	if d.Empty {
+		return nil, nil
+	}
+	if !d.Overlaps(o) {
+		return []*DRange{d}, nil
+	}
+	var out []*DRange
+	if CompareBounds(d.LowerBound(), o.LowerBound()) < 0 {
+		// The part of d before o ends where o starts.
+		end := RangeBound{Val: o.Lower, Inclusive: !o.LowerInc}
+		r, err := rangeFromBounds(d.Typ, d.LowerBound(), end)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	if CompareBounds(d.UpperBound(), o.UpperBound()) > 0 {
+		start := RangeBound{Val: o.Upper, Inclusive: !o.UpperInc, Lower: true}
+		r, err := rangeFromBounds(d.Typ, start, d.UpperBound())
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return slices.DeleteFunc(out, func(r *DRange) bool { return r.Empty }), nil
+}
+
+// ParseDRange parses a range literal of type t, e.g. [1,10) or empty.
+func ParseDRange(t *T, s string) (*DRange, error) {
+	if t.RangeContents == nil {
+		return nil, polymorphicUnknownError()
+	}
+	p := &rangeParser{s: s}
+	r, err := p.parseRange(t)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.i != len(s) {
+		return nil, malformedRange(s, "Junk after right parenthesis or bracket.")
+	}
+	return r, nil
+}
+
+func polymorphicUnknownError() error {
+	return pgerror.New(pgerror.CodeDatatypeMismatch,
+		"could not determine polymorphic type because input has type unknown")
+}
+
+func malformedRange(s, detail string) error {
+	return pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "malformed range literal: %q", s).WithDetail(detail)
+}
+
+// rangeParser reads range literals, alone or within a multirange.
+type rangeParser struct {
+	s string
+	i int
+}
+
+func (p *rangeParser) skipSpace() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\n\r\v\f", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *rangeParser) parseRange(t *T) (*DRange, error) {
+	p.skipSpace()
+	if len(p.s)-p.i >= 5 && strings.EqualFold(p.s[p.i:p.i+5], "empty") {
+		p.i += 5
+		return EmptyRange(t), nil
+	}
+	if p.i >= len(p.s) || p.s[p.i] != '[' && p.s[p.i] != '(' {
+		return nil, malformedRange(p.s, "Missing left parenthesis or bracket.")
+	}
+	lowerInc := p.s[p.i] == '['
+	p.i++
+	lower, err := p.parseBound(t.RangeContents)
+	if err != nil {
+		return nil, err
+	}
+	if p.i >= len(p.s) || p.s[p.i] != ',' {
+		return nil, malformedRange(p.s, "Missing comma after lower bound.")
+	}
+	p.i++
+	upper, err := p.parseBound(t.RangeContents)
+	if err != nil {
+		return nil, err
+	}
+	if p.i >= len(p.s) || p.s[p.i] != ']' && p.s[p.i] != ')' {
+		return nil, malformedRange(p.s, "Too many commas.")
+	}
+	upperInc := p.s[p.i] == ']'
+	p.i++
+	return MakeDRange(t, lower, upper, lowerInc, upperInc)
+}
+
+// parseBound reads a bound up to the next unquoted comma or closing
+// bracket. An empty bound is unbounded.
+func (p *rangeParser) parseBound(sub *T) (Datum, error) {
+	var b strings.Builder
+	quoted, seen := false, false
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		switch {
+		case c == '"':
+			quoted, seen = !quoted, true
+			if !quoted && p.i+1 < len(p.s) && p.s[p.i+1] == '"' {
+				// A doubled quote within quotes is a literal quote.
+				b.WriteByte('"')
+				quoted = true
+				p.i++
+			}
+		case c == '\\':
+			if p.i+1 >= len(p.s) {
+				return nil, malformedRange(p.s, "Unexpected end of input.")
+			}
+			p.i++
+			b.WriteByte(p.s[p.i])
+			seen = true
+		case !quoted && (c == ',' || c == ')' || c == ']'):
+			if !seen {
+				return nil, nil
+			}
+			return ParseDatum(sub, b.String())
+		default:
+			b.WriteByte(c)
+			seen = true
+		}
+		p.i++
+	}
+	return nil, malformedRange(p.s, "Unexpected end of input.")
+}
+
+// DMultirange is a multirange datum: ranges in order that neither overlap
+// nor touch. None of them is empty.
+type DMultirange struct {
+	Typ    *T
+	Ranges []*DRange
+}
+
+// MakeDMultirange returns the multirange of type t covering the values of
+// ranges, merging those that overlap or touch.
+func MakeDMultirange(t *T, ranges []*DRange) (*DMultirange, error) {
+	sorted := slices.DeleteFunc(slices.Clone(ranges), func(r *DRange) bool { return r.Empty })
+	slices.SortFunc(sorted, func(a, b *DRange) int { return a.Compare(b) })
+	m := &DMultirange{Typ: t}
+	for _, r := range sorted {
+		if n := len(m.Ranges); n > 0 && (m.Ranges[n-1].Overlaps(r) || m.Ranges[n-1].Adjacent(r)) {
+			merged, err := m.Ranges[n-1].Merge(r)
+			if err != nil {
+				return nil, err
+			}
+			m.Ranges[n-1] = merged
+			continue
+		}
+		m.Ranges = append(m.Ranges, r)
+	}
+	return m, nil
+}
+
+func (d *DMultirange) ResolvedType() *T { return d.Typ }
+
+// Span returns the smallest range containing every range of d.
+func (d *DMultirange) Span() *DRange {
+	rt := RangeOf(d.Typ.RangeContents)
+	if len(d.Ranges) == 0 {
+		return EmptyRange(rt)
+	}
+	first, last := d.Ranges[0], d.Ranges[len(d.Ranges)-1]
+	return &DRange{Typ: rt, Lower: first.Lower, LowerInc: first.LowerInc, Upper: last.Upper, UpperInc: last.UpperInc}
+}
+
+// Compare orders multiranges range by range, then by count.
+func (d *DMultirange) Compare(other Datum) int {
+	o := other.(*DMultirange)
+	for i := 0; i < len(d.Ranges) && i < len(o.Ranges); i++ {
+		if c := d.Ranges[i].Compare(o.Ranges[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(d.Ranges) < len(o.Ranges):
+		return -1
+	case len(d.Ranges) > len(o.Ranges):
+		return 1
+	}
+	return 0
+}
+
+// String formats the multirange as PostgreSQL does, e.g. {[1,3),[5,7)}.
+func (d *DMultirange) String() string {
+	return d.format(Datum.String)
+}
+
+// format formats the multirange with the bounds of its ranges formatted
+// by elem.
+func (d *DMultirange) format(elem func(Datum) string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, r := range d.Ranges {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		r.format(&b, elem)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseDMultirange parses a multirange literal of type t, e.g.
+// {[1,3), [5,7)}.
+func ParseDMultirange(t *T, s string) (*DMultirange, error) {
+	if t.RangeContents == nil {
+		return nil, polymorphicUnknownError()
+	}
+	malformed := func(detail string) error {
+		return pgerror.Newf(pgerror.CodeInvalidTextRepresentation, "malformed multirange literal: %q", s).
+			WithDetail(detail)
+	}
+	p := &rangeParser{s: s}
+	p.skipSpace()
+	if p.i >= len(s) || s[p.i] != '{' {
+		return nil, malformed("Missing left brace.")
+	}
+	p.i++
+	rt := RangeOf(t.RangeContents)
+	var ranges []*DRange
+	p.skipSpace()
+	if p.i < len(s) && s[p.i] == '}' {
+		p.i++
+	} else {
+		for {
+			r, err := p.parseRange(rt)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, r)
+			p.skipSpace()
+			if p.i < len(s) && s[p.i] == ',' {
+				p.i++
+				continue
+			}
+			if p.i < len(s) && s[p.i] == '}' {
+				p.i++
+				break
+			}
+			return nil, malformed("Expected comma or end of multirange.")
+		}
+	}
+	p.skipSpace()
+	if p.i != len(s) {
+		return nil, malformed("Junk after closing right brace.")
+	}
+	return MakeDMultirange(t, ranges)
+}
@@ -0,0 +1,408 @@
+// Package types defines SQL data types and the datums that carry values of
+// those types through the SQL layer.
+//
+// Every type maps onto a PostgreSQL type OID so results can be described to
+// clients over the wire protocol without translation.
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Oid is a PostgreSQL object identifier.
+type Oid uint32
+
+// Type OIDs from PostgreSQL's pg_type catalog.
+const (
+	OidBool        Oid = 16
+	OidBytea       Oid = 17
+	OidInt8        Oid = 20
+	OidInt2        Oid = 21
+	OidInt4        Oid = 23
+	OidText        Oid = 25
+	OidOid         Oid = 26
+	OidXML         Oid = 142
+	OidXMLArray    Oid = 143
+	OidCidr        Oid = 650
+	OidFloat4      Oid = 700
+	OidFloat8      Oid = 701
+	OidUnknown     Oid = 705
+	OidMoney       Oid = 790
+	OidMacaddr     Oid = 829
+	OidInet        Oid = 869
+	OidBPChar      Oid = 1042
+	OidVarChar     Oid = 1043
+	OidDate        Oid = 1082
+	OidTimestamp   Oid = 1114
+	OidTimestampTZ Oid = 1184
+	OidInterval    Oid = 1186
+	OidBit         Oid = 1560
+	OidVarBit      Oid = 1562
+	OidNumeric     Oid = 1700
+	OidTextArray   Oid = 1009
+	OidAny         Oid = 2276
+	OidVoid        Oid = 2278
+	OidJSONB       Oid = 3802
+	OidAnyRange    Oid = 3831
+	OidInt4Range   Oid = 3904
+	OidNumRange    Oid = 3906
+	OidTsRange     Oid = 3908
+	OidTstzRange   Oid = 3910
+	OidDateRange   Oid = 3912
+	OidInt8Range   Oid = 3926
+
+	OidInt4Multirange Oid = 4451
+	OidNumMultirange  Oid = 4532
+	OidTsMultirange   Oid = 4533
+	OidTstzMultirange Oid = 4534
+	OidDateMultirange Oid = 4535
+	OidInt8Multirange Oid = 4536
+	OidAnyMultirange  Oid = 4537
+
+	// OidVector, OidHstore and OidCIText are the OIDs of pgvector's vector
+	// type and of the hstore and citext extensions' types. Extension types
+	// get their OIDs when the extension is created, so there are no
+	// standard ones; these are taken from the range PostgreSQL leaves
+	// unassigned.
+	OidVector Oid = 8100
+	OidHstore Oid = 8101
+	OidCIText Oid = 8102
+)
+
+// Family groups types that share a datum representation. Types within a
+// family are compared and operated on together; e.g. int2, int4 and int8
+// are all IntFamily.
+type Family uint8
+
+const (
+	UnknownFamily Family = iota
+	AnyFamily
+	BoolFamily
+	IntFamily
+	FloatFamily
+	DecimalFamily
+	StringFamily
+	BytesFamily
+	DateFamily
+	TimestampFamily
+	TimestampTZFamily
+	IntervalFamily
+	ArrayFamily
+	VectorFamily
+	HstoreFamily
+	CITextFamily
+	InetFamily
+	MacaddrFamily
+	MoneyFamily
+	BitFamily
+	XMLFamily
+	RangeFamily
+	MultirangeFamily
+	JSONBFamily
+)
+
+var familyNames = [...]string{
+	UnknownFamily:     "unknown",
+	AnyFamily:         "any",
+	BoolFamily:        "bool",
+	IntFamily:         "int",
+	FloatFamily:       "float",
+	DecimalFamily:     "decimal",
+	StringFamily:      "string",
+	BytesFamily:       "bytes",
+	DateFamily:        "date",
+	TimestampFamily:   "timestamp",
+	TimestampTZFamily: "timestamptz",
+	IntervalFamily:    "interval",
+	ArrayFamily:       "array",
+	VectorFamily:      "vector",
+	HstoreFamily:      "hstore",
+	CITextFamily:      "citext",
+	InetFamily:        "inet",
+	MacaddrFamily:     "macaddr",
+	MoneyFamily:       "money",
+	BitFamily:         "bit",
+	XMLFamily:         "xml",
+	RangeFamily:       "range",
+	MultirangeFamily:  "multirange",
+	JSONBFamily:       "jsonb",
+}
+
+func (f Family) String() string {
+	if int(f) < len(familyNames) {
+		return familyNames[f]
+	}
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	for _, t := range typeOids {
+		if t.Family == f {
+			return t.Name
+		}
+	}
+	return fmt.Sprintf("family(%d)", f)
+}
+
+// T describes a SQL type.
+type T struct {
+	Family Family
+	Oid    Oid
+	// Name is the canonical PostgreSQL name of the type, e.g. "int4".
+	Name string
+	// Width is the bit width of numeric types, the maximum character
+	// length of varchar(n)/char(n) and bit(n)/varbit(n) or the dimension
+	// of vector(n). Zero means unbounded.
+	Width int32
+	// Precision and Scale are the numeric(p,s) type modifiers. Zero
+	// precision means unconstrained.
+	Precision int32
+	Scale     int32
+	// ArrayContents is the element type of array types.
+	ArrayContents *T `json:",omitempty"`
+	// RangeContents is the subtype of range and multirange types.
+	RangeContents *T `json:",omitempty"`
+
+	// def is the definition of a registered type.
+	def *TypeDef
+}
+
+// Predefined types.
+var (
+	Unknown     = &T{Family: UnknownFamily, Oid: OidUnknown, Name: "unknown"}
+	Any         = &T{Family: AnyFamily, Oid: OidAny, Name: "any"}
+	Void        = &T{Family: AnyFamily, Oid: OidVoid, Name: "void"}
+	Bool        = &T{Family: BoolFamily, Oid: OidBool, Name: "bool"}
+	Int2        = &T{Family: IntFamily, Oid: OidInt2, Name: "int2", Width: 16}
+	Int4        = &T{Family: IntFamily, Oid: OidInt4, Name: "int4", Width: 32}
+	Int8        = &T{Family: IntFamily, Oid: OidInt8, Name: "int8", Width: 64}
+	OidType     = &T{Family: IntFamily, Oid: OidOid, Name: "oid", Width: 32}
+	Float4      = &T{Family: FloatFamily, Oid: OidFloat4, Name: "float4", Width: 32}
+	Float8      = &T{Family: FloatFamily, Oid: OidFloat8, Name: "float8", Width: 64}
+	Decimal     = &T{Family: DecimalFamily, Oid: OidNumeric, Name: "numeric"}
+	String      = &T{Family: StringFamily, Oid: OidText, Name: "text"}
+	VarChar     = &T{Family: StringFamily, Oid: OidVarChar, Name: "varchar"}
+	BPChar      = &T{Family: StringFamily, Oid: OidBPChar, Name: "bpchar"}
+	Bytes       = &T{Family: BytesFamily, Oid: OidBytea, Name: "bytea"}
+	Date        = &T{Family: DateFamily, Oid: OidDate, Name: "date"}
+	Timestamp   = &T{Family: TimestampFamily, Oid: OidTimestamp, Name: "timestamp"}
+	TimestampTZ = &T{Family: TimestampTZFamily, Oid: OidTimestampTZ, Name: "timestamptz"}
+	Interval    = &T{Family: IntervalFamily, Oid: OidInterval, Name: "interval"}
+	Vector      = &T{Family: VectorFamily, Oid: OidVector, Name: "vector"}
+	Hstore      = &T{Family: HstoreFamily, Oid: OidHstore, Name: "hstore"}
+	CIText      = &T{Family: CITextFamily, Oid: OidCIText, Name: "citext"}
+	Inet        = &T{Family: InetFamily, Oid: OidInet, Name: "inet"}
+	Cidr        = &T{Family: InetFamily, Oid: OidCidr, Name: "cidr"}
+	Macaddr     = &T{Family: MacaddrFamily, Oid: OidMacaddr, Name: "macaddr"}
+	Money       = &T{Family: MoneyFamily, Oid: OidMoney, Name: "money"}
+	Bit         = &T{Family: BitFamily, Oid: OidBit, Name: "bit"}
+	VarBit      = &T{Family: BitFamily, Oid: OidVarBit, Name: "varbit"}
+	XML         = &T{Family: XMLFamily, Oid: OidXML, Name: "xml"}
+	JSONB       = &T{Family: JSONBFamily, Oid: OidJSONB, Name: "jsonb"}
+
+	Int4Range = &T{Family: RangeFamily, Oid: OidInt4Range, Name: "int4range", RangeContents: Int4}
+	Int8Range = &T{Family: RangeFamily, Oid: OidInt8Range, Name: "int8range", RangeContents: Int8}
+	NumRange  = &T{Family: RangeFamily, Oid: OidNumRange, Name: "numrange", RangeContents: Decimal}
+	TsRange   = &T{Family: RangeFamily, Oid: OidTsRange, Name: "tsrange", RangeContents: Timestamp}
+	TstzRange = &T{Family: RangeFamily, Oid: OidTstzRange, Name: "tstzrange", RangeContents: TimestampTZ}
+	DateRange = &T{Family: RangeFamily, Oid: OidDateRange, Name: "daterange", RangeContents: Date}
+
+	Int4Multirange = &T{Family: MultirangeFamily, Oid: OidInt4Multirange, Name: "int4multirange", RangeContents: Int4}
+	Int8Multirange = &T{Family: MultirangeFamily, Oid: OidInt8Multirange, Name: "int8multirange", RangeContents: Int8}
+	NumMultirange  = &T{Family: MultirangeFamily, Oid: OidNumMultirange, Name: "nummultirange", RangeContents: Decimal}
+	TsMultirange   = &T{Family: MultirangeFamily, Oid: OidTsMultirange, Name: "tsmultirange", RangeContents: Timestamp}
+	TstzMultirange = &T{Family: MultirangeFamily, Oid: OidTstzMultirange, Name: "tstzmultirange", RangeContents: TimestampTZ}
+	DateMultirange = &T{Family: MultirangeFamily, Oid: OidDateMultirange, Name: "datemultirange", RangeContents: Date}
+
+	// AnyRange and AnyMultirange are the polymorphic parameter types of
+	// functions over every range and multirange type. They have no
+	// subtype, so their values cannot be parsed.
+	AnyRange      = &T{Family: RangeFamily, Oid: OidAnyRange, Name: "anyrange"}
+	AnyMultirange = &T{Family: MultirangeFamily, Oid: OidAnyMultirange, Name: "anymultirange"}
+
+	StringArray = &T{Family: ArrayFamily, Oid: OidTextArray, Name: "_text", ArrayContents: String}
+	XMLArray    = &T{Family: ArrayFamily, Oid: OidXMLArray, Name: "_xml", ArrayContents: XML}
+
+	// Int is the default integer type.
+	Int = Int8
+	// Float is the default floating point type.
+	Float = Float8
+)
+
+// RangeTypes lists the built-in range types.
+var RangeTypes = []*T{Int4Range, Int8Range, NumRange, TsRange, TstzRange, DateRange}
+
+var multiranges = map[Oid]*T{
+	OidInt4Range: Int4Multirange, OidInt8Range: Int8Multirange, OidNumRange: NumMultirange,
+	OidTsRange: TsMultirange, OidTstzRange: TstzMultirange, OidDateRange: DateMultirange,
+}
+
+// RangeOf returns the range type over subtype sub, or AnyRange if there is
+// none.
+func RangeOf(sub *T) *T {
+	for _, t := range RangeTypes {
+		if sub != nil && t.RangeContents.Oid == sub.Oid {
+			return t
+		}
+	}
+	return AnyRange
+}
+
+// MultirangeOf returns the multirange type of the range type t, or
+// AnyMultirange if there is none.
+func MultirangeOf(t *T) *T {
+	if m := multiranges[t.Oid]; m != nil {
+		return m
+	}
+	return AnyMultirange
+}
+
+// Polymorphic reports whether t is AnyRange or AnyMultirange, which stand
+// for the range or multirange type of a call's arguments.
+func (t *T) Polymorphic() bool {
+	return t.Oid == OidAnyRange || t.Oid == OidAnyMultirange
+}
+
+// MakeVarChar returns the varchar(n) type.
+func MakeVarChar(n int32) *T {
+	return &T{Family: StringFamily, Oid: OidVarChar, Name: "varchar", Width: n}
+}
+
+// MakeChar returns the char(n) type.
+func MakeChar(n int32) *T {
+	return &T{Family: StringFamily, Oid: OidBPChar, Name: "bpchar", Width: n}
+}
+
+// MakeBit returns the bit(n) type.
+func MakeBit(n int32) *T {
+	return &T{Family: BitFamily, Oid: OidBit, Name: "bit", Width: n}
+}
+
+// MakeVarBit returns the varbit(n) type.
+func MakeVarBit(n int32) *T {
+	return &T{Family: BitFamily, Oid: OidVarBit, Name: "varbit", Width: n}
+}
+
+// MakeDecimal returns the numeric(p,s) type.
+func MakeDecimal(precision, scale int32) *T {
+	return &T{Family: DecimalFamily, Oid: OidNumeric, Name: "numeric", Precision: precision, Scale: scale}
+}
+
+// String returns the type in SQL syntax, including modifiers.
+func (t *T) String() string {
+	switch {
+	case t.Family == ArrayFamily:
+		return t.ArrayContents.String() + "[]"
+	case (t.Family == StringFamily && t.Oid != OidText || t.Family == BitFamily) && t.Width > 0:
+		return fmt.Sprintf("%s(%d)", t.Name, t.Width)
+	case t.Family == DecimalFamily && t.Precision > 0:
+		return fmt.Sprintf("numeric(%d,%d)", t.Precision, t.Scale)
+	case t.Family == VectorFamily && t.Width > 0:
+		return fmt.Sprintf("vector(%d)", t.Width)
+	}
+	return t.Name
+}
+
+// Equivalent reports whether values of t and other can be compared
+// without a cast. Unknown and Any are equivalent to every type.
+func (t *T) Equivalent(other *T) bool {
+	if t.Family == UnknownFamily || other.Family == UnknownFamily ||
+		t.Family == AnyFamily || other.Family == AnyFamily {
+		return true
+	}
+	return t.Family == other.Family
+}
+
+// Identical reports whether t and other are the same type, including
+// modifiers.
+func (t *T) Identical(other *T) bool {
+	return t.Oid == other.Oid && t.Width == other.Width &&
+		t.Precision == other.Precision && t.Scale == other.Scale
+}
+
+// typeNames maps SQL type names, including common aliases, to types.
+var typeNames = map[string]*T{
+	"bool":                        Bool,
+	"boolean":                     Bool,
+	"int2":                        Int2,
+	"smallint":                    Int2,
+	"int4":                        Int4,
+	"int":                         Int4,
+	"integer":                     Int4,
+	"int8":                        Int8,
+	"bigint":                      Int8,
+	"oid":                         OidType,
+	"float4":                      Float4,
+	"real":                        Float4,
+	"float8":                      Float8,
+	"float":                       Float8,
+	"double precision":            Float8,
+	"numeric":                     Decimal,
+	"decimal":                     Decimal,
+	"text":                        String,
+	"varchar":                     VarChar,
+	"character varying":           VarChar,
+	"char":                        MakeChar(1),
+	"character":                   MakeChar(1),
+	"bpchar":                      BPChar,
+	"bytea":                       Bytes,
+	"date":                        Date,
+	"timestamp":                   Timestamp,
+	"timestamp without time zone": Timestamp,
+	"timestamptz":                 TimestampTZ,
+	"timestamp with time zone":    TimestampTZ,
+	"interval":                    Interval,
+	"vector":                      Vector,
+	"hstore":                      Hstore,
+	"citext":                      CIText,
+	"inet":                        Inet,
+	"cidr":                        Cidr,
+	"macaddr":                     Macaddr,
+	"money":                       Money,
+	"bit":                         MakeBit(1),
+	"varbit":                      VarBit,
+	"bit varying":                 VarBit,
+	"xml":                         XML,
+	"jsonb":                       JSONB,
+	"int4range":                   Int4Range,
+	"int8range":                   Int8Range,
+	"numrange":                    NumRange,
+	"tsrange":                     TsRange,
+	"tstzrange":                   TstzRange,
+	"daterange":                   DateRange,
+	"int4multirange":              Int4Multirange,
+	"int8multirange":              Int8Multirange,
+	"nummultirange":               NumMultirange,
+	"tsmultirange":                TsMultirange,
+	"tstzmultirange":              TstzMultirange,
+	"datemultirange":              DateMultirange,
+}
+
+// LookupType returns the type with the given SQL name, or nil.
+func LookupType(name string) *T {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	return typeNames[strings.ToLower(name)]
+}
+
+// typeOids maps OIDs to their canonical types.
+var typeOids = map[Oid]*T{}
+
+func init() {
+	for _, t := range []*T{
+		Unknown, Void, Bool, Int2, Int4, Int8, OidType, Float4, Float8, Decimal,
+		String, VarChar, BPChar, Bytes, Date, Timestamp, TimestampTZ, Interval,
+		StringArray, Vector, Hstore, CIText, Inet, Cidr, Macaddr,
+		Money, Bit, VarBit, XML, XMLArray, JSONB,
+		Int4Range, Int8Range, NumRange, TsRange, TstzRange, DateRange,
+		Int4Multirange, Int8Multirange, NumMultirange, TsMultirange, TstzMultirange, DateMultirange,
+	} {
+		typeOids[t.Oid] = t
+	}
+}
+
+// TypeForOid returns the canonical type with the given OID, or nil.
+func TypeForOid(oid Oid) *T {
+	typesMu.RLock()
+	defer typesMu.RUnlock()
+	return typeOids[oid]
+}
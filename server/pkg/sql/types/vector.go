@@ -0,0 +1,187 @@
+package types
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// MaxVectorDims is the largest dimension of a vector, as in pgvector.
+const MaxVectorDims = 16000
+
+// MakeVector returns the vector(n) type.
+func MakeVector(dims int32) *T {
+	return &T{Family: VectorFamily, Oid: OidVector, Name: "vector", Width: dims}
+}
+
+// DVector is a vector of single precision floats, pgvector's vector type.
+type DVector struct {
+	Elems []float32
+}
+
+// NewDVector returns the vector with the given elements.
+func NewDVector(elems []float32) *DVector {
+	return &DVector{Elems: elems}
+}
+
+func (d *DVector) ResolvedType() *T { return Vector }
+
+// Compare orders vectors element by element, then by dimension, as
+// pgvector does.
+func (d *DVector) Compare(other Datum) int {
+	a, b := d.Elems, other.(*DVector).Elems
+	for i := 0; i < len(a) && i < len(b); i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// String formats the vector as pgvector does, e.g. [1,2.5,3e-05].
+func (d *DVector) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range d.Elems {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(formatFloat4(f))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// formatFloat4 formats f like PostgreSQL's float4out.
+func formatFloat4(f float32) string {
+	if f == 0 {
+		if math.Signbit(float64(f)) {
+			return "-0"
+		}
+		return "0"
+	}
+	exp := int(math.Floor(math.Log10(math.Abs(float64(f)))))
+	if exp < -4 || exp >= 6 {
+		return strconv.FormatFloat(float64(f), 'e', -1, 32)
+	}
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+// ParseDVector parses pgvector's text format, e.g. [1,2,3].
+func ParseDVector(s string) (*DVector, error) {
+	body := strings.TrimSpace(s)
+	if !strings.HasPrefix(body, "[") || !strings.HasSuffix(body, "]") {
+		return nil, invalidSyntax(Vector, s)
+	}
+	body = strings.TrimSpace(body[1 : len(body)-1])
+	if body == "" {
+		return nil, pgerror.New(pgerror.CodeDataException, "vector must have at least 1 dimension")
+	}
+	parts := strings.Split(body, ",")
+	if len(parts) > MaxVectorDims {
+		return nil, pgerror.Newf(pgerror.CodeProgramLimitExceeded, "vector cannot have more than %d dimensions", MaxVectorDims)
+	}
+	v := make([]float32, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil && !isRangeError(err) {
+			return nil, invalidSyntax(Vector, s)
+		}
+		if err := checkVectorElem(f); err != nil {
+			return nil, err
+		}
+		v[i] = float32(f)
+	}
+	return NewDVector(v), nil
+}
+
+func isRangeError(err error) bool {
+	ne, ok := err.(*strconv.NumError)
+	return ok && ne.Err == strconv.ErrRange
+}
+
+func checkVectorElem(f float64) error {
+	switch {
+	case math.IsNaN(f):
+		return pgerror.New(pgerror.CodeDataException, "NaN not allowed in vector")
+	case math.IsInf(f, 0) || math.Abs(f) > math.MaxFloat32:
+		return pgerror.New(pgerror.CodeDataException, "infinite value not allowed in vector")
+	}
+	return nil
+}
+
+// CheckVectorDims returns d if it has the dimension of the vector(n) type
+// t, or t is unconstrained.
+func CheckVectorDims(t *T, d *DVector) (*DVector, error) {
+	if t.Width > 0 && int(t.Width) != len(d.Elems) {
+		return nil, pgerror.Newf(pgerror.CodeDataException, "expected %d dimensions, not %d", t.Width, len(d.Elems))
+	}
+	return d, nil
+}
+
+// SameVectorDims returns an error unless a and b have the same dimension,
+// which operators combining two vectors require.
+func SameVectorDims(a, b *DVector) error {
+	if len(a.Elems) != len(b.Elems) {
+		return pgerror.Newf(pgerror.CodeDataException, "different vector dimensions %d and %d", len(a.Elems), len(b.Elems))
+	}
+	return nil
+}
+
+// L2Distance returns the Euclidean distance between a and b, which have
+// the same dimension.
+func L2Distance(a, b *DVector) float64 {
+	var sum float32
+	for i, x := range a.Elems {
+		d := x - b.Elems[i]
+		sum += d * d
+	}
+	return math.Sqrt(float64(sum))
+}
+
+// InnerProduct returns the dot product of a and b.
+func InnerProduct(a, b *DVector) float64 {
+	var sum float32
+	for i, x := range a.Elems {
+		sum += x * b.Elems[i]
+	}
+	return float64(sum)
+}
+
+// CosineDistance returns one minus the cosine of the angle between a and
+// b, or NaN if either is the zero vector.
+func CosineDistance(a, b *DVector) float64 {
+	var dot, na, nb float32
+	for i, x := range a.Elems {
+		y := b.Elems[i]
+		dot += x * y
+		na += x * x
+		nb += y * y
+	}
+	sim := float64(dot) / math.Sqrt(float64(na)*float64(nb))
+	if math.IsNaN(sim) {
+		return math.NaN()
+	}
+	// Keep rounding from taking the result out of [0, 2].
+	return 1 - max(-1, min(1, sim))
+}
+
+// Norm returns the Euclidean norm of d.
+func (d *DVector) Norm() float64 {
+	var sum float64
+	for _, f := range d.Elems {
+		sum += float64(f) * float64(f)
+	}
+	return math.Sqrt(sum)
+}
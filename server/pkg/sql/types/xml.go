@@ -0,0 +1,235 @@
+package types
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+)
+
+// DXML is an xml datum. It keeps the text as written, which is checked to
+// be well-formed XML content when parsed.
+type DXML string
+
+func (d DXML) ResolvedType() *T { return XML }
+
+// Compare orders xml values by their text. SQL has no comparison of xml
+// values; the order only serves to sort and group them.
+func (d DXML) Compare(other Datum) int {
+	return strings.Compare(string(d), string(other.(DXML)))
+}
+
+func (d DXML) String() string { return string(d) }
+
+// XMLNodeKind is the kind of an XMLNode.
+type XMLNodeKind uint8
+
+// XML node kinds.
+const (
+	XMLDocumentNode XMLNodeKind = iota
+	XMLElementNode
+	XMLAttributeNode
+	XMLTextNode
+	XMLCommentNode
+	XMLProcInstNode
+)
+
+// XMLNode is a node of a parsed XML tree. Names keep their namespace
+// prefix as written, e.g. "dc:title"; namespace declarations are
+// attributes like any other.
+type XMLNode struct {
+	Kind     XMLNodeKind
+	Name     string
+	Value    string // the text of attributes, text, comments and processing instructions
+	Attrs    []*XMLNode
+	Children []*XMLNode
+	Parent   *XMLNode
+	// Order is the node's position in document order.
+	Order int
+}
+
+// StringValue returns the XPath string-value of n: the text it contains.
+func (n *XMLNode) StringValue() string {
+	switch n.Kind {
+	case XMLDocumentNode, XMLElementNode:
+		var b strings.Builder
+		var walk func(*XMLNode)
+		walk = func(n *XMLNode) {
+			for _, c := range n.Children {
+				switch c.Kind {
+				case XMLTextNode:
+					b.WriteString(c.Value)
+				case XMLElementNode:
+					walk(c)
+				}
+			}
+		}
+		walk(n)
+		return b.String()
+	}
+	return n.Value
+}
+
+// Serialize returns the XML text of n, as an xpath result: elements as
+// markup, and text and attribute values escaped.
+func (n *XMLNode) Serialize() string {
+	var b strings.Builder
+	n.serialize(&b)
+	return b.String()
+}
+
+func (n *XMLNode) serialize(b *strings.Builder) {
+	switch n.Kind {
+	case XMLDocumentNode:
+		for _, c := range n.Children {
+			c.serialize(b)
+		}
+	case XMLElementNode:
+		b.WriteByte('<')
+		b.WriteString(n.Name)
+		for _, a := range n.Attrs {
+			b.WriteByte(' ')
+			b.WriteString(a.Name)
+			b.WriteString(`="`)
+			b.WriteString(escapeXML(a.Value, true))
+			b.WriteByte('"')
+		}
+		if len(n.Children) == 0 {
+			b.WriteString("/>")
+			return
+		}
+		b.WriteByte('>')
+		for _, c := range n.Children {
+			c.serialize(b)
+		}
+		b.WriteString("</")
+		b.WriteString(n.Name)
+		b.WriteByte('>')
+	case XMLTextNode:
+		b.WriteString(escapeXML(n.Value, false))
+	case XMLCommentNode:
+		b.WriteString("<!--")
+		b.WriteString(n.Value)
+		b.WriteString("-->")
+	case XMLProcInstNode:
+		b.WriteString("<?")
+		b.WriteString(n.Name)
+		if n.Value != "" {
+			b.WriteByte(' ')
+			b.WriteString(n.Value)
+		}
+		b.WriteString("?>")
+	case XMLAttributeNode:
+		b.WriteString(escapeXML(n.Value, false))
+	}
+}
+
+func escapeXML(s string, attr bool) string {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	if attr {
+		r = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;")
+	}
+	return r.Replace(s)
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+// ParseXMLTree parses s into a tree under a document node. If document is
+// set, s must be a well-formed document, with a single root element;
+// otherwise it may be any well-formed content.
+func ParseXMLTree(s string, document bool) (*XMLNode, error) {
+	dec := xml.NewDecoder(strings.NewReader(s))
+	root := &XMLNode{Kind: XMLDocumentNode}
+	cur := root
+	order := 0
+	add := func(n *XMLNode) {
+		order++
+		n.Parent, n.Order = cur, order
+		cur.Children = append(cur.Children, n)
+	}
+	elements := 0
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var syn *xml.SyntaxError
+			if errors.As(err, &syn) {
+				return nil, errors.New(syn.Msg)
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if cur == root {
+				elements++
+			}
+			el := &XMLNode{Kind: XMLElementNode, Name: xmlName(t.Name)}
+			add(el)
+			for _, a := range t.Attr {
+				order++
+				el.Attrs = append(el.Attrs, &XMLNode{Kind: XMLAttributeNode, Name: xmlName(a.Name),
+					Value: a.Value, Parent: el, Order: order})
+			}
+			cur = el
+		case xml.EndElement:
+			if cur == root || cur.Name != xmlName(t.Name) {
+				return nil, errors.New("unexpected end tag </" + xmlName(t.Name) + ">")
+			}
+			cur = cur.Parent
+		case xml.CharData:
+			if cur == root && document && strings.TrimSpace(string(t)) != "" {
+				return nil, errors.New("text outside the root element")
+			}
+			if n := len(cur.Children); n > 0 && cur.Children[n-1].Kind == XMLTextNode {
+				cur.Children[n-1].Value += string(t)
+				continue
+			}
+			add(&XMLNode{Kind: XMLTextNode, Value: string(t)})
+		case xml.Comment:
+			add(&XMLNode{Kind: XMLCommentNode, Value: string(t)})
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				continue
+			}
+			add(&XMLNode{Kind: XMLProcInstNode, Name: t.Target, Value: string(t.Inst)})
+		}
+	}
+	if cur != root {
+		return nil, errors.New("element <" + cur.Name + "> is not closed")
+	}
+	if document && elements != 1 {
+		return nil, errors.New("a document must have exactly one root element")
+	}
+	if document {
+		// Whitespace around the root element is not part of the document.
+		kept := root.Children[:0]
+		for _, c := range root.Children {
+			if c.Kind != XMLTextNode {
+				kept = append(kept, c)
+			}
+		}
+		root.Children = kept
+	}
+	return root, nil
+}
+
+// ParseDXML parses an xml value, checking that it is well-formed XML
+// content, or a well-formed document if document is set.
+func ParseDXML(s string, document bool) (DXML, error) {
+	if _, err := ParseXMLTree(s, document); err != nil {
+		if document {
+			return "", pgerror.New(pgerror.CodeInvalidXMLDocument, "invalid XML document").WithDetail(err.Error())
+		}
+		return "", pgerror.New(pgerror.CodeInvalidXMLContent, "invalid XML content").WithDetail(err.Error())
+	}
+	return DXML(s), nil
+}
@@ -0,0 +1,50 @@
+package sql_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alivenotions/pgz/server/pkg/engine/memory"
+	"github.com/alivenotions/pgz/server/pkg/sql"
+)
+
+// blockingCompacter is an engine whose compactions wait until they are
+// released.
+type blockingCompacter struct {
+	*memory.Engine
+	compacting chan struct{}
+	release    chan struct{}
+}
+
+func (e *blockingCompacter) CompactRange(start, end []byte) error {
+	e.compacting <- struct{}{}
+	<-e.release
+	return e.Engine.CompactRange(start, end)
+}
+
+func TestVacuumProgress(t *testing.T) {
+	e := &blockingCompacter{Engine: memory.New(), compacting: make(chan struct{}), release: make(chan struct{})}
+	srv := sql.NewServer(e)
+	s := connect(t, srv)
+	exec(t, s, "CREATE TABLE t (id int PRIMARY KEY)")
+	exec(t, s, "INSERT INTO t VALUES (1), (2)")
+	v := connect(t, srv)
+	done := make(chan error)
+	go func() {
+		_, err := v.Exec("VACUUM t")
+		done <- err
+	}()
+	// Hold the compaction of t to see it listed as it runs.
+	<-e.compacting
+	got := fmt.Sprint(exec(t, s, "SELECT command, relname, phase, tuples_total FROM pg_stat_progress_vacuum")[0].Rows)
+	if want := "[[VACUUM t vacuuming heap NULL]]"; got != want {
+		t.Errorf("pg_stat_progress_vacuum = %s, want %s", got, want)
+	}
+	close(e.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(exec(t, s, "SELECT relname FROM pg_stat_progress_vacuum")[0].Rows); got != "[]" {
+		t.Errorf("pg_stat_progress_vacuum after VACUUM = %s", got)
+	}
+}
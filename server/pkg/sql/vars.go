@@ -0,0 +1,548 @@
+package sql
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/config"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/exec"
+	"github.com/alivenotions/pgz/server/pkg/sql/parser"
+	"github.com/alivenotions/pgz/server/pkg/sql/pgerror"
+	"github.com/alivenotions/pgz/server/pkg/sql/planner"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+	"github.com/alivenotions/pgz/server/pkg/sql/vtable"
+)
+
+// sessionVar is a setting of a session, which SET and RESET change and
+// SHOW and pg_settings show along with the server's settings.
+type sessionVar struct {
+	description string
+	// def is the value of the variable in a session that has not set it.
+	def string
+	// set returns the value of SET name TO values for a variable whose
+	// value is cur, or an error if it is invalid. It is nil for variables
+	// that cannot be changed.
+	set func(name, cur string, values []string) (string, error)
+	// report is the name the variable is reported to the client by when it
+	// changes, for the variables PostgreSQL reports; see ParameterStatus.
+	report string
+}
+
+// maxParallelWorkers bounds max_parallel_workers_per_gather, as
+// PostgreSQL's max_worker_processes does.
+const maxParallelWorkers = 1024
+
+// sessionVars are the session variables by name.
+var sessionVars = map[string]*sessionVar{
+	"client_encoding": {
+		description: "Sets the client's character set encoding.",
+		def:         "UTF8",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			e, err := lookupEncoding(v)
+			if err != nil {
+				return "", err
+			}
+			return e.name, nil
+		},
+		report: "client_encoding",
+	},
+	"datestyle": {
+		description: "Sets the display format for date and time values.",
+		def:         "ISO, MDY",
+		set: func(_, cur string, values []string) (string, error) {
+			ds, err := types.ParseDateStyle(parseDateStyle(cur), values)
+			if err != nil {
+				return "", err
+			}
+			return ds.String(), nil
+		},
+		report: "DateStyle",
+	},
+	"intervalstyle": {
+		description: "Sets the display format for interval values.",
+		def:         "postgres",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			is, err := types.ParseIntervalStyle(v)
+			if err != nil {
+				return "", err
+			}
+			return is.String(), nil
+		},
+		report: "IntervalStyle",
+	},
+	"search_path": {
+		description: "Sets the schema search order for names that are not schema-qualified.",
+		def:         `"$user", public`,
+		set: func(_, _ string, values []string) (string, error) {
+			var names []string
+			for _, v := range values {
+				// A value listing schemas, as the parameters a client
+				// starts a session with do, is parsed as the list; a name
+				// alone, which SET has already parsed, is taken as it is.
+				list := []string{v}
+				if strings.ContainsAny(v, `,"`) {
+					list = splitSearchPath(v)
+				}
+				for _, name := range list {
+					if name != "" {
+						names = append(names, parser.QuoteIdent(name))
+					}
+				}
+			}
+			return strings.Join(names, ", "), nil
+		},
+	},
+	"default_transaction_isolation": {
+		description: "Sets the transaction isolation level of each new transaction.",
+		def:         "repeatable read",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			// Transactions read a snapshot and fail on write conflicts,
+			// which is REPEATABLE READ, and at least the levels below it.
+			switch v = strings.ToLower(v); v {
+			case "read uncommitted", "read committed", "repeatable read":
+				return v, nil
+			case "serializable":
+				return "", pgerror.New(pgerror.CodeFeatureNotSupported,
+					"SERIALIZABLE isolation is not supported; transactions run at REPEATABLE READ")
+			}
+			return "", pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"invalid value for parameter \"default_transaction_isolation\": %q", v)
+		},
+	},
+	"default_transaction_read_only": {
+		description: "Sets the default read-only status of new transactions.",
+		def:         "off",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			return boolValue(name, v)
+		},
+	},
+	"server_encoding": {
+		description: "Shows the server (database) character set encoding.",
+		def:         "UTF8",
+	},
+	"statement_timeout": {
+		description: "Sets the maximum allowed duration of any statement.",
+		def:         "0",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			d, err := config.ParseDuration(v)
+			if err != nil || d < 0 {
+				return "", pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for parameter \"statement_timeout\": %q", v)
+			}
+			return config.FormatDuration(d), nil
+		},
+	},
+	"synchronous_commit": {
+		description: "Sets the current transaction's synchronization level.",
+		def:         "on",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			// With no standbys, the levels that wait for one are on.
+			switch v = strings.ToLower(v); v {
+			case "on", "off", "local", "remote_write", "remote_apply":
+				return v, nil
+			case "true", "yes", "1":
+				return "on", nil
+			case "false", "no", "0":
+				return "off", nil
+			}
+			return "", pgerror.Newf(pgerror.CodeInvalidParameterValue,
+				"invalid value for parameter \"synchronous_commit\": %q", v)
+		},
+	},
+	"max_parallel_workers_per_gather": {
+		description: "Sets the maximum number of workers that read the shards of a scan at once.",
+		def:         "2",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxParallelWorkers {
+				return "", pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for parameter \"max_parallel_workers_per_gather\": %q", v).
+					WithHint(fmt.Sprintf("Valid values are between 0 and %d.", maxParallelWorkers))
+			}
+			return strconv.Itoa(n), nil
+		},
+	},
+	"work_mem": {
+		description: "Sets the maximum memory to be used by each sort, hash aggregation and hash join of a query.",
+		def:         "4MB",
+		set: func(name, _ string, values []string) (string, error) {
+			v, err := oneValue(name, values)
+			if err != nil {
+				return "", err
+			}
+			n, err := config.ParseBytes(v, 1<<10)
+			if err != nil || n < 64<<10 {
+				return "", pgerror.Newf(pgerror.CodeInvalidParameterValue,
+					"invalid value for parameter \"work_mem\": %q", v).
+					WithHint("Valid values are at least 64kB, in units of kB without a unit.")
+			}
+			return config.FormatBytes(n), nil
+		},
+	},
+}
+
+// oneValue returns the value of SET name TO values for a variable that
+// takes a single one.
+func oneValue(name string, values []string) (string, error) {
+	if len(values) != 1 {
+		return "", pgerror.Newf(pgerror.CodeSyntaxError, "SET %s takes only one argument", name)
+	}
+	return values[0], nil
+}
+
+// boolValue returns the value, on or off, of SET name TO v for a boolean
+// variable.
+func boolValue(name, v string) (string, error) {
+	switch strings.ToLower(v) {
+	case "on", "true", "yes", "1":
+		return "on", nil
+	case "off", "false", "no", "0":
+		return "off", nil
+	}
+	return "", pgerror.Newf(pgerror.CodeInvalidParameterValue, "parameter %q requires a Boolean value", name)
+}
+
+// splitSearchPath returns the schema names of a search_path value, a list
+// of identifiers separated by commas.
+func splitSearchPath(v string) []string {
+	names := []string{}
+	quoted, start := false, 0
+	for i := 0; i <= len(v); i++ {
+		switch {
+		case i < len(v) && v[i] == '"':
+			quoted = !quoted
+		case i == len(v) || v[i] == ',' && !quoted:
+			if name := strings.TrimSpace(v[start:i]); name != "" {
+				names = append(names, eval.Identifier(name))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}
+
+// searchPath returns the names of the schemas of the session's search
+// path.
+func (s *Session) searchPath() []string {
+	return splitSearchPath(s.getVar("search_path"))
+}
+
+// statementTimeout returns the session's statement_timeout, or 0 for
+// none.
+func (s *Session) statementTimeout() time.Duration {
+	d, _ := config.ParseDuration(s.getVar("statement_timeout"))
+	return max(d, 0)
+}
+
+// workMem returns the session's work_mem in bytes.
+func (s *Session) workMem() int64 {
+	n, _ := config.ParseBytes(s.getVar("work_mem"), 1<<10)
+	return n
+}
+
+// parallelWorkers returns the session's max_parallel_workers_per_gather.
+func (s *Session) parallelWorkers() int {
+	n, _ := strconv.Atoi(s.getVar("max_parallel_workers_per_gather"))
+	return n
+}
+
+// parseDateStyle returns the DateStyle a datestyle variable holds, which
+// its set function wrote.
+func parseDateStyle(v string) types.DateStyle {
+	ds, _ := types.ParseDateStyle(types.DateStyle{}, []string{v})
+	return ds
+}
+
+// styles returns the session's DateStyle and IntervalStyle, in which the
+// text of dates, times and intervals is formatted.
+func (s *Session) styles() types.Styles {
+	is, _ := types.ParseIntervalStyle(s.getVar("intervalstyle"))
+	return types.Styles{Date: parseDateStyle(s.getVar("datestyle")), Interval: is}
+}
+
+// FormatText returns the text format of d, a value of a result, which
+// follows the session's DateStyle and IntervalStyle. It must still be
+// converted to the client encoding with EncodeText.
+func (s *Session) FormatText(d types.Datum) string {
+	return s.styles().Format(d)
+}
+
+// SetParameter sets the session variable name to value as the client
+// started the session with it, as the parameters of its startup message
+// do. RESET restores the variable to it. Names are case-insensitive, and
+// the error for a name that is not a session variable has the code
+// undefined_object.
+func (s *Session) SetParameter(name, value string) error {
+	name = strings.ToLower(name)
+	v := sessionVars[name]
+	if v == nil || v.set == nil {
+		return unrecognizedParameter(name)
+	}
+	val, err := v.set(name, v.def, []string{value})
+	if err != nil {
+		return err
+	}
+	s.setDefault(name, varValue{value: val, source: "client"})
+	return nil
+}
+
+// setDefault sets the session variable name to v as the session starts,
+// which RESET restores it to.
+func (s *Session) setDefault(name string, v varValue) {
+	s.vars[name] = v
+	if s.resetVars == nil {
+		s.resetVars = make(map[string]varValue)
+	}
+	s.resetVars[name] = v
+}
+
+// ParameterStatus returns the values of the session variables PostgreSQL
+// reports to the client, by the names the client knows them by:
+// client_encoding, DateStyle and IntervalStyle. A connection reports them
+// as the session starts, and again when a statement changes them.
+func (s *Session) ParameterStatus() map[string]string {
+	status := make(map[string]string)
+	for name, v := range sessionVars {
+		if v.report != "" {
+			status[v.report] = s.getVar(name)
+		}
+	}
+	return status
+}
+
+// varValue is the value a session set a variable to, and where it was
+// set: "session" for SET, "client" for the client's startup parameters,
+// and "global", "database", "user" or "database user" for the role
+// settings of ALTER ROLE ... SET; see applyRoleSettings.
+type varValue struct {
+	value, source string
+}
+
+// savedVar is the value of a variable before a transaction set it, and
+// the value SET gave it in the transaction, if it did, which the variable
+// keeps if the transaction commits. SET LOCAL only lasts until the
+// transaction ends.
+type savedVar struct {
+	prior, commit varValue
+	set           bool
+}
+
+// getVar returns the value of the session variable name.
+func (s *Session) getVar(name string) string {
+	if v, ok := s.vars[name]; ok {
+		return v.value
+	}
+	return sessionVars[name].def
+}
+
+// assignVar sets the session variable name to v, or to its default if v
+// is the zero varValue, until the transaction ends if local is set.
+func (s *Session) assignVar(name string, v varValue, local bool) {
+	if s.txn != nil {
+		saved, ok := s.txnVars[name]
+		if !ok {
+			saved.prior = s.vars[name]
+		}
+		if !local {
+			saved.commit, saved.set = v, true
+		}
+		if s.txnVars == nil {
+			s.txnVars = make(map[string]savedVar)
+		}
+		s.txnVars[name] = saved
+	}
+	s.putVar(name, v)
+}
+
+func (s *Session) putVar(name string, v varValue) {
+	if v.source == "" {
+		delete(s.vars, name)
+		return
+	}
+	s.vars[name] = v
+}
+
+// endVars restores the variables the transaction set as it ends, keeping
+// the values of SET if it commits.
+func (s *Session) endVars(commit bool) {
+	for name, saved := range s.txnVars {
+		v := saved.prior
+		if commit && saved.set {
+			v = saved.commit
+		}
+		s.putVar(name, v)
+	}
+	s.txnVars = nil
+}
+
+// set runs SET and RESET. SET LOCAL outside a transaction block has no
+// effect, as in PostgreSQL.
+func (s *Session) set(stmt *parser.SetStmt) (*Result, error) {
+	tag := "SET"
+	if stmt.Reset {
+		tag = "RESET"
+	}
+	if stmt.All {
+		for name, v := range sessionVars {
+			if v.set != nil {
+				s.assignVar(name, s.resetVars[name], false)
+			}
+		}
+		return &Result{Tag: tag}, nil
+	}
+	name := strings.ToLower(stmt.Name)
+	v, err := s.settableVar(name)
+	if err != nil {
+		return nil, err
+	}
+	// Variables are reset to the values the client started the session
+	// with.
+	value := s.resetVars[name]
+	if !stmt.Default {
+		val, err := v.set(name, s.getVar(name), stmt.Values)
+		if err != nil {
+			return nil, err
+		}
+		value = varValue{value: val, source: "session"}
+	}
+	if stmt.Local && s.txn == nil {
+		return &Result{Tag: tag}, nil
+	}
+	s.assignVar(name, value, stmt.Local)
+	return &Result{Tag: tag}, nil
+}
+
+// settableVar returns the session variable name, or an error if there is
+// none or it cannot be changed.
+func (s *Session) settableVar(name string) (*sessionVar, error) {
+	v := sessionVars[name]
+	if v == nil {
+		if s.serverSetting(name) {
+			return nil, pgerror.Newf(pgerror.CodeCantChangeRuntimeParam, "parameter %q cannot be changed now", name)
+		}
+		return nil, unrecognizedParameter(name)
+	}
+	if v.set == nil {
+		return nil, pgerror.Newf(pgerror.CodeCantChangeRuntimeParam, "parameter %q cannot be changed", name)
+	}
+	return v, nil
+}
+
+// checkSetting returns the value SET name TO values gives the session
+// variable name in a session that has not set it, for ALTER ROLE ... SET
+// to store.
+func (s *Session) checkSetting(name string, values []string) (string, error) {
+	v, err := s.settableVar(name)
+	if err != nil {
+		return "", err
+	}
+	return v.set(name, v.def, values)
+}
+
+// serverSetting reports whether name is a setting of the server.
+func (s *Session) serverSetting(name string) bool {
+	if s.server.settings == nil {
+		return false
+	}
+	return slices.ContainsFunc(s.server.settings(), func(st vtable.Setting) bool { return st.Name == name })
+}
+
+func unrecognizedParameter(name string) error {
+	return pgerror.Newf(pgerror.CodeUndefinedObject, "unrecognized configuration parameter %q", name)
+}
+
+// settings returns the settings of the server and the session, ordered by
+// name, for pg_settings and SHOW.
+func (s *Session) settings() []vtable.Setting {
+	var settings []vtable.Setting
+	if s.server.settings != nil {
+		settings = s.server.settings()
+	}
+	for name, v := range sessionVars {
+		st := vtable.Setting{
+			Name:        name,
+			Setting:     s.getVar(name),
+			Description: v.description,
+			Context:     "internal",
+			VarType:     "string",
+			Source:      "default",
+			BootVal:     v.def,
+		}
+		if v.set != nil {
+			st.Context = "user"
+		}
+		if val, ok := s.vars[name]; ok {
+			st.Source = val.source
+		}
+		settings = append(settings, st)
+	}
+	slices.SortFunc(settings, func(a, b vtable.Setting) int { return strings.Compare(a.Name, b.Name) })
+	return settings
+}
+
+// show runs SHOW. SHOW ALL lists the settings with their values and
+// descriptions, and SHOW name the value of one, in a column named after
+// it.
+func (s *Session) show(stmt *parser.ShowStmt) (*Result, error) {
+	settings := s.settings()
+	res := &Result{Tag: "SHOW", Result: exec.Result{Columns: showColumns(stmt)}}
+	if stmt.All {
+		for _, st := range settings {
+			res.Rows = append(res.Rows, []types.Datum{types.DString(st.Name), types.DString(st.Setting), types.DString(st.Description)})
+		}
+		return res, nil
+	}
+	name := strings.ToLower(stmt.Name)
+	for _, st := range settings {
+		if st.Name == name {
+			res.Rows = [][]types.Datum{{types.DString(st.Setting)}}
+			return res, nil
+		}
+	}
+	return nil, unrecognizedParameter(stmt.Name)
+}
+
+// showColumns returns the columns of the result of SHOW.
+func showColumns(stmt *parser.ShowStmt) []planner.Column {
+	if stmt.All {
+		return []planner.Column{
+			{Name: "name", Type: types.String},
+			{Name: "setting", Type: types.String},
+			{Name: "description", Type: types.String},
+		}
+	}
+	return []planner.Column{{Name: strings.ToLower(stmt.Name), Type: types.String}}
+}
@@ -0,0 +1,47 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// utf8Encoding is the number of the UTF8 encoding in pg_database, which
+// every database has.
+const utf8Encoding = 6
+
+// pg_database lists the databases.
+func init() {
+	register("pg_database", []catalog.Column{
+		{Name: "oid", Type: types.OidType},
+		{Name: "datname", Type: types.String},
+		{Name: "datdba", Type: types.OidType},
+		{Name: "encoding", Type: types.Int4},
+		{Name: "datistemplate", Type: types.Bool},
+		{Name: "datallowconn", Type: types.Bool},
+		{Name: "datconnlimit", Type: types.Int4},
+	}, databaseRows)
+}
+
+func databaseRows(ctx *Context) ([][]types.Datum, error) {
+	dbs, err := catalog.ListDatabases(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, db := range dbs {
+		oid := types.DInt(db.ID)
+		if db.ID == catalog.DefaultDatabaseID {
+			oid = types.DInt(DefaultDatabase)
+		}
+		rows = append(rows, []types.Datum{
+			oid,
+			types.DString(db.Name),
+			types.DInt(db.Owner),
+			types.DInt(utf8Encoding),
+			types.DBool(false),
+			types.DBool(true),
+			types.DInt(-1),
+		})
+	}
+	return rows, nil
+}
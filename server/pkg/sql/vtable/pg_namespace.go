@@ -0,0 +1,31 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pg_namespace lists the schemas: pg_catalog and those of the catalog.
+func init() {
+	register("pg_namespace", []catalog.Column{
+		{Name: "oid", Type: types.OidType},
+		{Name: "nspname", Type: types.String},
+		{Name: "nspowner", Type: types.OidType},
+	}, namespaceRows)
+}
+
+func namespaceRows(ctx *Context) ([][]types.Datum, error) {
+	schemas, err := catalog.ListSchemas(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	rows := [][]types.Datum{{types.DInt(PgCatalogNamespace), types.DString(Schema), types.DInt(catalog.Public)}}
+	for _, s := range schemas {
+		oid := types.DInt(s.ID)
+		if s.ID == catalog.PublicSchemaID {
+			oid = types.DInt(PublicNamespace)
+		}
+		rows = append(rows, []types.Datum{oid, types.DString(s.Name), types.DInt(s.Owner)})
+	}
+	return rows, nil
+}
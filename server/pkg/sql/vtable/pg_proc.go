@@ -0,0 +1,94 @@
+package vtable
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pg_proc lists the functions and aggregates of the server's registry.
+// Builtins are in pg_catalog; functions registered by the embedder are in
+// public, as if created with CREATE FUNCTION.
+func init() {
+	register("pg_proc", []catalog.Column{
+		{Name: "oid", Type: types.OidType},
+		{Name: "proname", Type: types.String},
+		{Name: "pronamespace", Type: types.OidType},
+		{Name: "prokind", Type: types.String},
+		{Name: "provolatile", Type: types.String},
+		{Name: "proisstrict", Type: types.Bool},
+		{Name: "pronargs", Type: types.Int2},
+		{Name: "prorettype", Type: types.OidType},
+		{Name: "proargtypes", Type: types.String},
+		{Name: "provariadic", Type: types.OidType},
+	}, procRows)
+}
+
+func procRows(ctx *Context) ([][]types.Datum, error) {
+	reg := ctx.Registry
+	var rows [][]types.Datum
+	for _, name := range reg.FuncNames() {
+		for _, o := range reg.Overloads(name) {
+			variadic := types.Oid(0)
+			if o.Variadic != nil {
+				variadic = o.Variadic.Oid
+			}
+			rows = append(rows, procRow(o.Oid, name, "f", volatilityCode(o.Volatility), !o.NullCall,
+				o.Params, o.ReturnType, variadic))
+		}
+	}
+	for _, name := range reg.AggregateNames() {
+		for _, a := range reg.Aggregates(name) {
+			rows = append(rows, procRow(a.Oid, name, "a", "i", !a.NullCall, a.Params, a.ReturnType, 0))
+		}
+	}
+	for _, name := range reg.WindowFuncNames() {
+		for _, w := range reg.WindowFuncs(name) {
+			rows = append(rows, procRow(w.Oid, name, "w", "i", false, w.Params, w.ReturnType, 0))
+		}
+	}
+	return rows, nil
+}
+
+func procRow(oid types.Oid, name, kind, volatility string, strict bool,
+	params []*types.T, ret *types.T, variadic types.Oid) []types.Datum {
+	ns := PgCatalogNamespace
+	if oid >= eval.FirstUserOid {
+		ns = PublicNamespace
+	}
+	// Polymorphic functions compute their result type from their
+	// arguments.
+	retOid := types.OidAny
+	if ret != nil {
+		retOid = ret.Oid
+	}
+	argOids := make([]string, len(params))
+	for i, p := range params {
+		argOids[i] = strconv.FormatUint(uint64(p.Oid), 10)
+	}
+	return []types.Datum{
+		types.DInt(oid),
+		types.DString(name),
+		types.DInt(ns),
+		types.DString(kind),
+		types.DString(volatility),
+		types.MakeDBool(strict),
+		types.DInt(len(params)),
+		types.DInt(retOid),
+		types.DString(strings.Join(argOids, " ")),
+		types.DInt(variadic),
+	}
+}
+
+func volatilityCode(v eval.Volatility) string {
+	switch v {
+	case eval.Stable:
+		return "s"
+	case eval.Volatile:
+		return "v"
+	}
+	return "i"
+}
@@ -0,0 +1,77 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pg_publication lists the publications of the database, and
+// pg_publication_tables the tables each publishes.
+func init() {
+	register("pg_publication", []catalog.Column{
+		{Name: "oid", Type: types.OidType},
+		{Name: "pubname", Type: types.String},
+		{Name: "pubowner", Type: types.OidType},
+		{Name: "puballtables", Type: types.Bool},
+		{Name: "pubinsert", Type: types.Bool},
+		{Name: "pubupdate", Type: types.Bool},
+		{Name: "pubdelete", Type: types.Bool},
+		{Name: "pubtruncate", Type: types.Bool},
+		{Name: "pubviaroot", Type: types.Bool},
+	}, publicationRows)
+	register("pg_publication_tables", []catalog.Column{
+		{Name: "pubname", Type: types.String},
+		{Name: "schemaname", Type: types.String},
+		{Name: "tablename", Type: types.String},
+	}, publicationTableRows)
+}
+
+func publicationRows(ctx *Context) ([][]types.Datum, error) {
+	pubs, err := catalog.ListPublications(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, p := range pubs {
+		rows = append(rows, []types.Datum{
+			types.DInt(p.ID),
+			types.DString(p.Name),
+			types.DInt(p.Owner),
+			types.DBool(p.AllTables),
+			types.DBool(p.Insert),
+			types.DBool(p.Update),
+			types.DBool(p.Delete),
+			types.DBool(p.Truncate),
+			types.DBool(false),
+		})
+	}
+	return rows, nil
+}
+
+func publicationTableRows(ctx *Context) ([][]types.Datum, error) {
+	pubs, err := catalog.ListPublications(ctx.Txn)
+	if err != nil || len(pubs) == 0 {
+		return nil, err
+	}
+	schemas, err := catalog.ListSchemas(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[catalog.ID]string, len(schemas))
+	for _, s := range schemas {
+		names[s.ID] = s.Name
+	}
+	tables, err := catalog.ListTables(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, p := range pubs {
+		for _, t := range tables {
+			if p.Publishes(t.ID) {
+				rows = append(rows, []types.Datum{types.DString(p.Name), types.DString(names[t.Schema]), types.DString(t.Name)})
+			}
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,71 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// ReplicationSlot is a logical replication slot, and whether a client
+// streams changes from it.
+type ReplicationSlot struct {
+	*catalog.ReplicationSlot
+	// DatabaseName names the database of the slot.
+	DatabaseName string
+	// ActivePID is the process ID of the session streaming from the slot,
+	// or 0 if none is.
+	ActivePID int32
+}
+
+// pg_replication_slots lists the replication slots of the server. There
+// being no pg_lsn type, the LSNs are text in its format.
+func init() {
+	register("pg_replication_slots", []catalog.Column{
+		{Name: "slot_name", Type: types.String},
+		{Name: "plugin", Type: types.String},
+		{Name: "slot_type", Type: types.String},
+		{Name: "datoid", Type: types.OidType},
+		{Name: "database", Type: types.String},
+		{Name: "temporary", Type: types.Bool},
+		{Name: "active", Type: types.Bool},
+		{Name: "active_pid", Type: types.Int4},
+		{Name: "restart_lsn", Type: types.String},
+		{Name: "confirmed_flush_lsn", Type: types.String},
+	}, replicationSlotRows)
+}
+
+func replicationSlotRows(ctx *Context) ([][]types.Datum, error) {
+	if ctx.ReplicationSlots == nil {
+		return nil, nil
+	}
+	slots, err := ctx.ReplicationSlots()
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, s := range slots {
+		oid := types.DInt(s.Database)
+		if s.Database == catalog.DefaultDatabaseID {
+			oid = types.DInt(DefaultDatabase)
+		}
+		var pid types.Datum = types.DNull
+		if s.ActivePID != 0 {
+			pid = types.DInt(s.ActivePID)
+		}
+		// The changes are kept from the confirmed position on, which is
+		// where streaming restarts.
+		lsn := types.DString(catalog.FormatLSN(s.ConfirmedFlush))
+		rows = append(rows, []types.Datum{
+			types.DString(s.Name),
+			types.DString(s.Plugin),
+			types.DString("logical"),
+			oid,
+			types.DString(s.DatabaseName),
+			types.DBool(false),
+			types.DBool(s.ActivePID != 0),
+			pid,
+			lsn,
+			lsn,
+		})
+	}
+	return rows, nil
+}
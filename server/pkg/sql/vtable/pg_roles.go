@@ -0,0 +1,66 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pg_roles lists the roles, with their passwords masked, and
+// pg_auth_members their memberships in each other.
+func init() {
+	register("pg_roles", []catalog.Column{
+		{Name: "oid", Type: types.OidType},
+		{Name: "rolname", Type: types.String},
+		{Name: "rolsuper", Type: types.Bool},
+		{Name: "rolinherit", Type: types.Bool},
+		{Name: "rolcreaterole", Type: types.Bool},
+		{Name: "rolcreatedb", Type: types.Bool},
+		{Name: "rolcanlogin", Type: types.Bool},
+		{Name: "rolconnlimit", Type: types.Int4},
+		{Name: "rolpassword", Type: types.String},
+	}, roleRows)
+	register("pg_auth_members", []catalog.Column{
+		{Name: "roleid", Type: types.OidType},
+		{Name: "member", Type: types.OidType},
+	}, authMemberRows)
+}
+
+func roleRows(ctx *Context) ([][]types.Datum, error) {
+	roles, err := catalog.ListRoles(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, r := range roles {
+		var password types.Datum = types.DNull
+		if r.Password != "" {
+			password = types.DString("********")
+		}
+		rows = append(rows, []types.Datum{
+			types.DInt(r.ID),
+			types.DString(r.Name),
+			types.DBool(r.Superuser),
+			types.DBool(r.Inherit),
+			types.DBool(r.CreateRole),
+			types.DBool(r.CreateDB),
+			types.DBool(r.Login),
+			types.DInt(r.ConnectionLimit()),
+			password,
+		})
+	}
+	return rows, nil
+}
+
+func authMemberRows(ctx *Context) ([][]types.Datum, error) {
+	roles, err := catalog.ListRoles(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, r := range roles {
+		for _, of := range r.MemberOf {
+			rows = append(rows, []types.Datum{types.DInt(of), types.DInt(r.ID)})
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,69 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Setting is a run-time parameter of the server and its effective value.
+type Setting struct {
+	Name, Setting, Description string
+	// Context is "sighup" if the setting changes when the server reloads
+	// its configuration, and "postmaster" if it only changes on restart.
+	Context string
+	// VarType is "bool", "integer", "string" or "enum", and EnumVals the
+	// values of an enum.
+	VarType  string
+	EnumVals []string
+	// Source is where the value comes from, such as "default" or
+	// "configuration file", and BootVal the default value.
+	Source, BootVal string
+	// PendingRestart is set if the setting was changed in the
+	// configuration but does not take the change until the server
+	// restarts.
+	PendingRestart bool
+}
+
+// pg_settings lists the server's settings, as PostgreSQL does.
+func init() {
+	register("pg_settings", []catalog.Column{
+		{Name: "name", Type: types.String},
+		{Name: "setting", Type: types.String},
+		{Name: "short_desc", Type: types.String},
+		{Name: "context", Type: types.String},
+		{Name: "vartype", Type: types.String},
+		{Name: "source", Type: types.String},
+		{Name: "enumvals", Type: types.StringArray},
+		{Name: "boot_val", Type: types.String},
+		{Name: "pending_restart", Type: types.Bool},
+	}, settingsRows)
+}
+
+func settingsRows(ctx *Context) ([][]types.Datum, error) {
+	if ctx.Settings == nil {
+		return nil, nil
+	}
+	var rows [][]types.Datum
+	for _, s := range ctx.Settings() {
+		enumVals := types.Datum(types.DNull)
+		if s.EnumVals != nil {
+			elems := make([]types.Datum, len(s.EnumVals))
+			for i, v := range s.EnumVals {
+				elems[i] = types.DString(v)
+			}
+			enumVals = types.NewDArray(types.StringArray, elems)
+		}
+		rows = append(rows, []types.Datum{
+			types.DString(s.Name),
+			types.DString(s.Setting),
+			types.DString(s.Description),
+			types.DString(s.Context),
+			types.DString(s.VarType),
+			types.DString(s.Source),
+			enumVals,
+			types.DString(s.BootVal),
+			types.DBool(s.PendingRestart),
+		})
+	}
+	return rows, nil
+}
@@ -0,0 +1,87 @@
+package vtable
+
+import (
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Activity is what a session is doing.
+type Activity struct {
+	// PID identifies the session, and Database names the database it is
+	// connected to.
+	PID      int32
+	Database string
+	// User and ApplicationName are those the client connected with, if
+	// known.
+	User, ApplicationName string
+	// BackendStart is when the session started, XactStart when its
+	// transaction started, QueryStart when its current or last query
+	// started, and StateChange when State last changed. XactStart is zero
+	// outside a transaction.
+	BackendStart, XactStart, QueryStart, StateChange time.Time
+	// State is "active", "idle", "idle in transaction" or "idle in
+	// transaction (aborted)".
+	State string
+	// Query is the current query, or the last one if the session is idle.
+	Query string
+	// WaitEventType and WaitEvent name what the session waits for, if
+	// anything.
+	WaitEventType, WaitEvent string
+}
+
+// pg_stat_activity lists the sessions of the server, one row each, as
+// PostgreSQL does for its backends.
+func init() {
+	register("pg_stat_activity", []catalog.Column{
+		{Name: "datname", Type: types.String},
+		{Name: "pid", Type: types.Int4},
+		{Name: "usename", Type: types.String},
+		{Name: "application_name", Type: types.String},
+		{Name: "backend_start", Type: types.TimestampTZ},
+		{Name: "xact_start", Type: types.TimestampTZ},
+		{Name: "query_start", Type: types.TimestampTZ},
+		{Name: "state_change", Type: types.TimestampTZ},
+		{Name: "wait_event_type", Type: types.String},
+		{Name: "wait_event", Type: types.String},
+		{Name: "state", Type: types.String},
+		{Name: "query", Type: types.String},
+	}, activityRows)
+}
+
+func activityRows(ctx *Context) ([][]types.Datum, error) {
+	if ctx.Activity == nil {
+		return nil, nil
+	}
+	str := func(s string) types.Datum {
+		if s == "" {
+			return types.DNull
+		}
+		return types.DString(s)
+	}
+	ts := func(t time.Time) types.Datum {
+		if t.IsZero() {
+			return types.DNull
+		}
+		return types.MakeDTimestampTZ(t)
+	}
+	var rows [][]types.Datum
+	for _, a := range ctx.Activity() {
+		rows = append(rows, []types.Datum{
+			str(a.Database),
+			types.DInt(a.PID),
+			str(a.User),
+			types.DString(a.ApplicationName),
+			ts(a.BackendStart),
+			ts(a.XactStart),
+			ts(a.QueryStart),
+			ts(a.StateChange),
+			str(a.WaitEventType),
+			str(a.WaitEvent),
+			types.DString(a.State),
+			types.DString(a.Query),
+		})
+	}
+	return rows, nil
+}
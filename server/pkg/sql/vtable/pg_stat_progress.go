@@ -0,0 +1,89 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Progress is how far a session's long running command has got.
+type Progress struct {
+	// PID identifies the session.
+	PID int32
+	// Command is the command, such as "CREATE INDEX" or "UPDATE", which
+	// works on the table Relname and, for CREATE INDEX and CLUSTER, the
+	// index IndexRelname.
+	Command, Relname, IndexRelname string
+	// Phase is the phase the command is in, of which it has processed
+	// TuplesDone tuples out of TuplesTotal, or out of an unknown number if
+	// TuplesTotal is negative.
+	Phase                   string
+	TuplesTotal, TuplesDone int64
+}
+
+// The pg_stat_progress_* tables list the commands that the server's
+// sessions are running, one row each, as PostgreSQL does for its
+// backends: pg_stat_progress_create_index lists CREATE INDEX,
+// pg_stat_progress_analyze ANALYZE, pg_stat_progress_cluster CLUSTER,
+// pg_stat_progress_copy COPY, pg_stat_progress_vacuum VACUUM, and
+// pgz_stat_progress all of them, including INSERT, UPDATE and DELETE.
+// percent_done is NULL while the number of tuples of the phase is not
+// known.
+func init() {
+	registerProgress("pg_stat_progress_create_index", "CREATE INDEX")
+	registerProgress("pg_stat_progress_analyze", "ANALYZE")
+	registerProgress("pg_stat_progress_cluster", "CLUSTER")
+	registerProgress("pg_stat_progress_copy", "COPY")
+	registerProgress("pg_stat_progress_vacuum", "VACUUM")
+	registerProgress("pgz_stat_progress", "")
+}
+
+// registerProgress adds a table of the progress of command, or of all
+// commands if it is "".
+func registerProgress(name, command string) {
+	register(name, []catalog.Column{
+		{Name: "pid", Type: types.Int4},
+		{Name: "command", Type: types.String},
+		{Name: "relname", Type: types.String},
+		{Name: "index_relname", Type: types.String},
+		{Name: "phase", Type: types.String},
+		{Name: "tuples_total", Type: types.Int8},
+		{Name: "tuples_done", Type: types.Int8},
+		{Name: "percent_done", Type: types.Float8},
+	}, func(ctx *Context) ([][]types.Datum, error) {
+		return progressRows(ctx, command)
+	})
+}
+
+func progressRows(ctx *Context, command string) ([][]types.Datum, error) {
+	if ctx.Progress == nil {
+		return nil, nil
+	}
+	var rows [][]types.Datum
+	for _, p := range ctx.Progress() {
+		if command != "" && p.Command != command {
+			continue
+		}
+		row := []types.Datum{
+			types.DInt(p.PID),
+			types.DString(p.Command),
+			types.DString(p.Relname),
+			types.DNull,
+			types.DString(p.Phase),
+			types.DNull,
+			types.DInt(p.TuplesDone),
+			types.DNull,
+		}
+		if p.IndexRelname != "" {
+			row[3] = types.DString(p.IndexRelname)
+		}
+		if p.TuplesTotal >= 0 {
+			row[5] = types.DInt(p.TuplesTotal)
+			row[7] = types.DFloat(100)
+			if p.TuplesTotal > 0 {
+				row[7] = types.DFloat(100 * float64(p.TuplesDone) / float64(p.TuplesTotal))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
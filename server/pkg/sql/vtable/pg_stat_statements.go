@@ -0,0 +1,74 @@
+package vtable
+
+import (
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// StatementStats are the statistics of the executions of the statements
+// with one normalized text.
+type StatementStats struct {
+	// QueryID identifies the normalized statement.
+	QueryID int64
+	// Query is the statement's text with its constants replaced by
+	// parameters.
+	Query string
+	// Labels are the labels of the queries the statement ran in, from
+	// their sqlcommenter comments, as key=value pairs separated by
+	// commas. The statistics of a statement are kept apart by labels.
+	Labels string
+	// Calls counts the executions, which took TotalTime altogether, at
+	// least MinTime and at most MaxTime each, and returned or wrote Rows
+	// rows altogether.
+	Calls                       int64
+	TotalTime, MinTime, MaxTime time.Duration
+	Rows                        int64
+}
+
+// pg_stat_statements lists the statistics of the statements the server
+// has executed, as PostgreSQL's extension of the same name does. Times
+// are in milliseconds. labels are those of the queries' sqlcommenter
+// comments, or NULL.
+func init() {
+	register("pg_stat_statements", []catalog.Column{
+		{Name: "queryid", Type: types.Int8},
+		{Name: "query", Type: types.String},
+		{Name: "calls", Type: types.Int8},
+		{Name: "total_exec_time", Type: types.Float8},
+		{Name: "min_exec_time", Type: types.Float8},
+		{Name: "max_exec_time", Type: types.Float8},
+		{Name: "mean_exec_time", Type: types.Float8},
+		{Name: "rows", Type: types.Int8},
+		{Name: "labels", Type: types.String},
+	}, statementRows)
+}
+
+func statementRows(ctx *Context) ([][]types.Datum, error) {
+	if ctx.Statements == nil {
+		return nil, nil
+	}
+	ms := func(d time.Duration) types.Datum {
+		return types.DFloat(float64(d) / float64(time.Millisecond))
+	}
+	var rows [][]types.Datum
+	for _, s := range ctx.Statements() {
+		var labels types.Datum = types.DNull
+		if s.Labels != "" {
+			labels = types.DString(s.Labels)
+		}
+		rows = append(rows, []types.Datum{
+			types.DInt(s.QueryID),
+			types.DString(s.Query),
+			types.DInt(s.Calls),
+			ms(s.TotalTime),
+			ms(s.MinTime),
+			ms(s.MaxTime),
+			ms(s.TotalTime / time.Duration(s.Calls)),
+			types.DInt(s.Rows),
+			labels,
+		})
+	}
+	return rows, nil
+}
@@ -0,0 +1,117 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pg_tables lists the tables, and pg_sequences the sequences with their
+// options and last values. Relations created by the embedder's sessions
+// have no owner.
+func init() {
+	register("pg_tables", []catalog.Column{
+		{Name: "schemaname", Type: types.String},
+		{Name: "tablename", Type: types.String},
+		{Name: "tableowner", Type: types.String},
+		{Name: "hasindexes", Type: types.Bool},
+	}, tableRows)
+	register("pg_sequences", []catalog.Column{
+		{Name: "schemaname", Type: types.String},
+		{Name: "sequencename", Type: types.String},
+		{Name: "sequenceowner", Type: types.String},
+		{Name: "data_type", Type: types.String},
+		{Name: "start_value", Type: types.Int8},
+		{Name: "min_value", Type: types.Int8},
+		{Name: "max_value", Type: types.Int8},
+		{Name: "increment_by", Type: types.Int8},
+		{Name: "cycle", Type: types.Bool},
+		{Name: "cache_size", Type: types.Int8},
+		{Name: "last_value", Type: types.Int8},
+	}, sequenceRows)
+}
+
+// relationNames returns the names of the schemas and of the roles, by ID.
+func relationNames(ctx *Context) (schemas, roles map[catalog.ID]string, err error) {
+	ss, err := catalog.ListSchemas(ctx.Txn)
+	if err != nil {
+		return nil, nil, err
+	}
+	schemas = make(map[catalog.ID]string, len(ss))
+	for _, s := range ss {
+		schemas[s.ID] = s.Name
+	}
+	rs, err := catalog.ListRoles(ctx.Txn)
+	if err != nil {
+		return nil, nil, err
+	}
+	roles = make(map[catalog.ID]string, len(rs))
+	for _, r := range rs {
+		roles[r.ID] = r.Name
+	}
+	return schemas, roles, nil
+}
+
+// ownerName returns the name of the role owner, or NULL for none.
+func ownerName(roles map[catalog.ID]string, owner catalog.ID) types.Datum {
+	if name, ok := roles[owner]; ok && owner != catalog.Public {
+		return types.DString(name)
+	}
+	return types.DNull
+}
+
+func tableRows(ctx *Context) ([][]types.Datum, error) {
+	schemas, roles, err := relationNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := catalog.ListTables(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, t := range tables {
+		rows = append(rows, []types.Datum{
+			types.DString(schemas[t.Schema]),
+			types.DString(t.Name),
+			ownerName(roles, t.Owner),
+			types.DBool(len(t.Indexes) > 0),
+		})
+	}
+	return rows, nil
+}
+
+func sequenceRows(ctx *Context) ([][]types.Datum, error) {
+	schemas, roles, err := relationNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seqs, err := catalog.ListSequences(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, s := range seqs {
+		v, err := catalog.GetSequenceValue(ctx.Txn, s.ID)
+		if err != nil {
+			return nil, err
+		}
+		var last types.Datum = types.DNull
+		if v.Called {
+			last = types.DInt(v.Last)
+		}
+		rows = append(rows, []types.Datum{
+			types.DString(schemas[s.Schema]),
+			types.DString(s.Name),
+			ownerName(roles, s.Owner),
+			types.DString(s.Type.String()),
+			types.DInt(s.Start),
+			types.DInt(s.MinValue),
+			types.DInt(s.MaxValue),
+			types.DInt(s.Increment),
+			types.DBool(s.Cycle),
+			types.DInt(s.Cache),
+			last,
+		})
+	}
+	return rows, nil
+}
@@ -0,0 +1,110 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pg_type lists the built-in types and those registered with
+// types.RegisterType. Registered types with user OIDs are in public, as if
+// created with CREATE TYPE, and so are vector, hstore and citext, as if
+// created by CREATE EXTENSION.
+func init() {
+	register("pg_type", []catalog.Column{
+		{Name: "oid", Type: types.OidType},
+		{Name: "typname", Type: types.String},
+		{Name: "typnamespace", Type: types.OidType},
+		{Name: "typlen", Type: types.Int2},
+		{Name: "typtype", Type: types.String},
+		{Name: "typcategory", Type: types.String},
+		{Name: "typelem", Type: types.OidType},
+	}, typeRows)
+}
+
+func typeRows(*Context) ([][]types.Datum, error) {
+	var rows [][]types.Datum
+	for _, t := range types.Types() {
+		ns := PgCatalogNamespace
+		if t.Oid >= eval.FirstUserOid || t.Family == types.VectorFamily ||
+			t.Family == types.HstoreFamily || t.Family == types.CITextFamily {
+			ns = PublicNamespace
+		}
+		typtype := "b"
+		switch t.Family {
+		case types.UnknownFamily, types.AnyFamily:
+			typtype = "p"
+		case types.RangeFamily:
+			typtype = "r"
+		case types.MultirangeFamily:
+			typtype = "m"
+		}
+		elem := types.Oid(0)
+		if t.ArrayContents != nil {
+			elem = t.ArrayContents.Oid
+		}
+		rows = append(rows, []types.Datum{
+			types.DInt(t.Oid),
+			types.DString(t.Name),
+			types.DInt(ns),
+			types.DInt(TypeLen(t)),
+			types.DString(typtype),
+			types.DString(typeCategory(t)),
+			types.DInt(elem),
+		})
+	}
+	return rows, nil
+}
+
+// TypeLen is the size of a fixed-length type's values, or -1 for types of
+// variable length.
+func TypeLen(t *types.T) int {
+	switch t.Family {
+	case types.BoolFamily:
+		return 1
+	case types.IntFamily, types.FloatFamily:
+		return int(t.Width / 8)
+	case types.DateFamily:
+		return 4
+	case types.TimestampFamily, types.TimestampTZFamily, types.MoneyFamily:
+		return 8
+	case types.IntervalFamily:
+		return 16
+	case types.MacaddrFamily:
+		return 6
+	case types.UnknownFamily:
+		return -2
+	case types.AnyFamily:
+		return 4
+	}
+	return -1
+}
+
+// typeCategory is PostgreSQL's typcategory code for t.
+func typeCategory(t *types.T) string {
+	switch t.Family {
+	case types.BoolFamily:
+		return "B"
+	case types.IntFamily, types.FloatFamily, types.DecimalFamily, types.MoneyFamily:
+		return "N"
+	case types.StringFamily, types.CITextFamily:
+		return "S"
+	case types.DateFamily, types.TimestampFamily, types.TimestampTZFamily:
+		return "D"
+	case types.IntervalFamily:
+		return "T"
+	case types.InetFamily:
+		return "I"
+	case types.BitFamily:
+		return "V"
+	case types.ArrayFamily:
+		return "A"
+	case types.RangeFamily, types.MultirangeFamily:
+		return "R"
+	case types.UnknownFamily:
+		return "X"
+	case types.AnyFamily:
+		return "P"
+	}
+	return "U"
+}
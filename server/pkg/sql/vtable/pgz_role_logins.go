@@ -0,0 +1,79 @@
+package vtable
+
+import (
+	"time"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pgz_role_logins lists the attempts to log in as each role: when and
+// from which address a client last logged in and last failed to, the
+// failed attempts since the last login and in all, and whether they
+// locked the role, until locked_until, or until it is unlocked if that is
+// NULL. Roles no client tried to log in as have NULLs and zero counts.
+func init() {
+	register("pgz_role_logins", []catalog.Column{
+		{Name: "rolname", Type: types.String},
+		{Name: "last_login", Type: types.TimestampTZ},
+		{Name: "last_login_addr", Type: types.String},
+		{Name: "last_failure", Type: types.TimestampTZ},
+		{Name: "last_failure_addr", Type: types.String},
+		{Name: "failed_attempts", Type: types.Int8},
+		{Name: "total_failed_attempts", Type: types.Int8},
+		{Name: "locked", Type: types.Bool},
+		{Name: "locked_until", Type: types.TimestampTZ},
+	}, roleLoginRows)
+}
+
+func roleLoginRows(ctx *Context) ([][]types.Datum, error) {
+	roles, err := catalog.ListRoles(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	logins, err := catalog.ListRoleLogins(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	byRole := make(map[catalog.ID]*catalog.RoleLogins, len(logins))
+	for _, l := range logins {
+		byRole[l.Role] = l
+	}
+	str := func(s string) types.Datum {
+		if s == "" {
+			return types.DNull
+		}
+		return types.DString(s)
+	}
+	ts := func(t time.Time) types.Datum {
+		if t.IsZero() {
+			return types.DNull
+		}
+		return types.MakeDTimestampTZ(t)
+	}
+	now := time.Now()
+	var rows [][]types.Datum
+	for _, r := range roles {
+		l := byRole[r.ID]
+		if l == nil {
+			l = &catalog.RoleLogins{}
+		}
+		locked := l.LockedAt(now)
+		lockedUntil := types.Datum(types.DNull)
+		if locked {
+			lockedUntil = ts(l.LockedUntil)
+		}
+		rows = append(rows, []types.Datum{
+			types.DString(r.Name),
+			ts(l.LastLogin),
+			str(l.LastLoginAddr),
+			ts(l.LastFailure),
+			str(l.LastFailureAddr),
+			types.DInt(l.Failures),
+			types.DInt(l.TotalFailures),
+			types.DBool(locked),
+			lockedUntil,
+		})
+	}
+	return rows, nil
+}
@@ -0,0 +1,65 @@
+package vtable
+
+import (
+	"errors"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/rowcodec"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pgz_stat_table_space lists the space each table takes in the storage
+// engine, its rows and index entries together: live_bytes is what reads
+// see, dead_bytes what compaction would reclaim, and total_bytes both.
+// dead_ratio is the share of the total that is dead, and
+// space_amplification the total over the live bytes, which a table whose
+// rows churn drives up. The byte counts are NULL if the engine does not
+// report them, and the ratios if the table takes no space.
+func init() {
+	register("pgz_stat_table_space", []catalog.Column{
+		{Name: "schemaname", Type: types.String},
+		{Name: "relname", Type: types.String},
+		{Name: "live_bytes", Type: types.Int8},
+		{Name: "dead_bytes", Type: types.Int8},
+		{Name: "total_bytes", Type: types.Int8},
+		{Name: "dead_ratio", Type: types.Float8},
+		{Name: "space_amplification", Type: types.Float8},
+	}, tableSpaceRows)
+}
+
+func tableSpaceRows(ctx *Context) ([][]types.Datum, error) {
+	tables, err := catalog.ListTables(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	schemas, _, err := relationNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	for _, t := range tables {
+		row := []types.Datum{types.DString(schemas[t.Schema]), types.DString(t.Name),
+			types.DNull, types.DNull, types.DNull, types.DNull, types.DNull}
+		rows = append(rows, row)
+		if ctx.Space == nil {
+			continue
+		}
+		prefix := rowcodec.TablePrefix(t.ID)
+		s, err := ctx.Space(prefix, rowcodec.PrefixEnd(prefix))
+		if errors.Is(err, errors.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		total := s.LiveBytes + s.DeadBytes
+		row[2], row[3], row[4] = types.DInt(s.LiveBytes), types.DInt(s.DeadBytes), types.DInt(total)
+		if total > 0 {
+			row[5] = types.DFloat(float64(s.DeadBytes) / float64(total))
+		}
+		if s.LiveBytes > 0 {
+			row[6] = types.DFloat(float64(total) / float64(s.LiveBytes))
+		}
+	}
+	return rows, nil
+}
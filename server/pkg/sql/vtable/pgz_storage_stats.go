@@ -0,0 +1,65 @@
+package vtable
+
+import (
+	"errors"
+
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pgz_storage_stats() reports the statistics of the storage engine as a
+// whole, of every database: the keys with a live version, the size of its
+// files on disk, its live and dead bytes as pgz_stat_table_space counts
+// them, whether a compaction is running and how much it is due to rewrite,
+// the reads the block cache served and missed since the server started,
+// the log written but not yet synced, and the size of the data before
+// compression and after. block_cache_hit_ratio is NULL until the cache is
+// read, and compression_ratio, uncompressed over compressed bytes, until
+// data is written. It returns no row if the engine does not report
+// statistics, as the in-memory one does not, nor the native one, which
+// keeps none yet.
+func init() {
+	registerFunc("pgz_storage_stats", []catalog.Column{
+		{Name: "key_count", Type: types.Int8},
+		{Name: "data_bytes", Type: types.Int8},
+		{Name: "live_bytes", Type: types.Int8},
+		{Name: "dead_bytes", Type: types.Int8},
+		{Name: "compacting", Type: types.Bool},
+		{Name: "compaction_pending_bytes", Type: types.Int8},
+		{Name: "block_cache_hits", Type: types.Int8},
+		{Name: "block_cache_misses", Type: types.Int8},
+		{Name: "block_cache_hit_ratio", Type: types.Float8},
+		{Name: "pending_wal_bytes", Type: types.Int8},
+		{Name: "uncompressed_bytes", Type: types.Int8},
+		{Name: "compressed_bytes", Type: types.Int8},
+		{Name: "compression_ratio", Type: types.Float8},
+	}, storageStatsRows)
+}
+
+func storageStatsRows(ctx *Context) ([][]types.Datum, error) {
+	if ctx.StorageStats == nil {
+		return nil, nil
+	}
+	s, err := ctx.StorageStats()
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ratio types.Datum = types.DNull
+	if reads := s.BlockCacheHits + s.BlockCacheMisses; reads > 0 {
+		ratio = types.DFloat(float64(s.BlockCacheHits) / float64(reads))
+	}
+	var compression types.Datum = types.DNull
+	if s.CompressedBytes > 0 {
+		compression = types.DFloat(float64(s.UncompressedBytes) / float64(s.CompressedBytes))
+	}
+	return [][]types.Datum{{
+		types.DInt(s.Keys), types.DInt(s.DataBytes), types.DInt(s.LiveBytes), types.DInt(s.DeadBytes),
+		types.DBool(s.Compacting), types.DInt(s.CompactionPendingBytes),
+		types.DInt(s.BlockCacheHits), types.DInt(s.BlockCacheMisses), ratio,
+		types.DInt(s.PendingWALBytes),
+		types.DInt(s.UncompressedBytes), types.DInt(s.CompressedBytes), compression,
+	}}, nil
+}
@@ -0,0 +1,65 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// pgz_supported_features() lists the features of the PostgreSQL protocol
+// a client may use, and whether the server supports them, for debugging a
+// client that fails to connect or to run its queries. detail says how the
+// server answers the features it does not support.
+func init() {
+	registerFunc("pgz_supported_features", []catalog.Column{
+		{Name: "area", Type: types.String},
+		{Name: "feature", Type: types.String},
+		{Name: "supported", Type: types.Bool},
+		{Name: "detail", Type: types.String},
+	}, supportedFeatureRows)
+}
+
+// supportedFeatures are the rows of pgz_supported_features().
+var supportedFeatures = []struct {
+	area, feature string
+	supported     bool
+	detail        string
+}{
+	{"protocol", "protocol 3.0", true, ""},
+	{"protocol", "protocol 3.1 and later", false, "downgraded to 3.0 with NegotiateProtocolVersion"},
+	{"protocol", "protocol 2.0", false, "refused at startup"},
+	{"protocol", "protocol extensions (_pq_ options)", false, "reported as unrecognized with NegotiateProtocolVersion"},
+	{"protocol", "simple query", true, ""},
+	{"protocol", "extended query", true, ""},
+	{"protocol", "binary format", true, "for the types whose binary form is simple"},
+	{"protocol", "COPY FROM STDIN", true, ""},
+	{"protocol", "COPY TO STDOUT", false, "fails with an error"},
+	{"protocol", "function call (fastpath)", false, "fails with an error"},
+	{"protocol", "cancel requests", true, ""},
+	{"protocol", "LISTEN and NOTIFY", true, ""},
+	{"protocol", "startup options", true, "-c name=value and --name=value only; other switches are refused"},
+	{"security", "SSL", true, "on TCP, when ssl is on"},
+	{"security", "GSSAPI encryption", false, "GSSENCRequest is declined, and the client goes on without it"},
+	{"authentication", "trust", true, ""},
+	{"authentication", "password", true, ""},
+	{"authentication", "SCRAM-SHA-256", true, ""},
+	{"authentication", "SCRAM-SHA-256-PLUS (channel binding)", false, "clients that require channel binding fail to authenticate"},
+	{"authentication", "peer", true, "on Unix-domain sockets"},
+	{"authentication", "md5", false, "not a valid auth_method"},
+	{"authentication", "GSSAPI and SSPI", false, "not a valid auth_method"},
+	{"replication", "logical replication (replication=database)", true, "pgoutput, through logical replication slots"},
+	{"replication", "physical replication (replication=true)", true, "streams the changes of the storage engine to pgz read replicas, not PostgreSQL's WAL"},
+	{"replication", "physical replication slots", false, "CREATE_REPLICATION_SLOT ... PHYSICAL fails with an error"},
+	{"replication", "BASE_BACKUP, TIMELINE_HISTORY, READ_REPLICATION_SLOT and UPLOAD_MANIFEST", false, "fail with an error"},
+}
+
+func supportedFeatureRows(*Context) ([][]types.Datum, error) {
+	rows := make([][]types.Datum, len(supportedFeatures))
+	for i, f := range supportedFeatures {
+		var detail types.Datum = types.DNull
+		if f.detail != "" {
+			detail = types.DString(f.detail)
+		}
+		rows[i] = []types.Datum{types.DString(f.area), types.DString(f.feature), types.DBool(f.supported), detail}
+	}
+	return rows, nil
+}
@@ -0,0 +1,86 @@
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// information_schema.table_privileges lists the privileges on tables: those
+// granted, and those of their owners, as if granted by the owners to
+// themselves, as PostgreSQL lists them. table_catalog is NULL: the server
+// has a single database without a name.
+func init() {
+	registerInfo("table_privileges", []catalog.Column{
+		{Name: "grantor", Type: types.String},
+		{Name: "grantee", Type: types.String},
+		{Name: "table_catalog", Type: types.String},
+		{Name: "table_schema", Type: types.String},
+		{Name: "table_name", Type: types.String},
+		{Name: "privilege_type", Type: types.String},
+		{Name: "is_grantable", Type: types.String},
+		{Name: "with_hierarchy", Type: types.String},
+	}, tablePrivilegeRows)
+}
+
+func tablePrivilegeRows(ctx *Context) ([][]types.Datum, error) {
+	roles, err := catalog.ListRoles(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	names := map[catalog.ID]string{catalog.Public: "PUBLIC"}
+	for _, r := range roles {
+		names[r.ID] = r.Name
+	}
+	schemas, err := catalog.ListSchemas(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	schemaNames := make(map[catalog.ID]string, len(schemas))
+	for _, s := range schemas {
+		schemaNames[s.ID] = s.Name
+	}
+	tables, err := catalog.ListTables(ctx.Txn)
+	if err != nil {
+		return nil, err
+	}
+	var rows [][]types.Datum
+	add := func(t *catalog.Table, g catalog.Grant) {
+		for _, p := range []catalog.Privilege{catalog.PrivSelect, catalog.PrivInsert, catalog.PrivUpdate, catalog.PrivDelete} {
+			if g.Privileges&p == 0 {
+				continue
+			}
+			grantable := "NO"
+			if g.GrantOption&p != 0 {
+				grantable = "YES"
+			}
+			hierarchy := "NO"
+			if p == catalog.PrivSelect {
+				hierarchy = "YES"
+			}
+			// Privileges granted by the embedder's sessions have no grantor.
+			var grantor types.Datum = types.DNull
+			if g.Grantor != catalog.Public {
+				grantor = types.DString(names[g.Grantor])
+			}
+			rows = append(rows, []types.Datum{
+				grantor,
+				types.DString(names[g.Grantee]),
+				types.DNull,
+				types.DString(schemaNames[t.Schema]),
+				types.DString(t.Name),
+				types.DString(p.String()),
+				types.DString(grantable),
+				types.DString(hierarchy),
+			})
+		}
+	}
+	for _, t := range tables {
+		if t.Owner != catalog.Public {
+			add(t, catalog.Grant{Grantee: t.Owner, Grantor: t.Owner, Privileges: catalog.TablePrivileges, GrantOption: catalog.TablePrivileges})
+		}
+		for _, g := range t.ACL {
+			add(t, g)
+		}
+	}
+	return rows, nil
+}
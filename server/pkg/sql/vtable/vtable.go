@@ -0,0 +1,122 @@
+// Package vtable defines the system catalog tables, such as pg_proc. Their
+// rows are not stored but computed from server state each time they are
+// read.
+package vtable
+
+import (
+	"github.com/alivenotions/pgz/server/pkg/engine"
+	"github.com/alivenotions/pgz/server/pkg/sql/catalog"
+	"github.com/alivenotions/pgz/server/pkg/sql/eval"
+	"github.com/alivenotions/pgz/server/pkg/sql/types"
+)
+
+// Schema is the schema the tables belong to, but for those of
+// InformationSchema, the views of the SQL standard.
+const (
+	Schema            = "pg_catalog"
+	InformationSchema = "information_schema"
+)
+
+// OIDs of PostgreSQL's predefined schemas, and of its postgres database,
+// which is catalog.DefaultDatabase.
+const (
+	PgCatalogNamespace types.Oid = 11
+	PublicNamespace    types.Oid = 2200
+	DefaultDatabase    types.Oid = 5
+)
+
+// Context is the state a table's rows are computed from.
+type Context struct {
+	Txn      engine.Reader
+	Registry *eval.Registry
+	// Statements returns the statistics of the statements the server has
+	// executed. It may be nil when there are none.
+	Statements func() []StatementStats
+	// Activity returns what the server's sessions are doing. It may be
+	// nil when there are none.
+	Activity func() []Activity
+	// Progress returns the progress of the commands the server's sessions
+	// are running. It may be nil when there are none.
+	Progress func() []Progress
+	// Settings returns the server's settings. It may be nil when there are
+	// none.
+	Settings func() []Setting
+	// Space reports the space a key range of the database takes, as
+	// engine.SpaceReporter does. It is nil if the engine does not report
+	// it.
+	Space func(start, end []byte) (engine.SpaceStats, error)
+	// StorageStats reports the statistics of the server's engine, as
+	// engine.StatsReporter does. It is nil if the engine does not report
+	// them.
+	StorageStats func() (engine.StorageStats, error)
+	// ReplicationSlots returns the server's replication slots. It may be
+	// nil when there are none.
+	ReplicationSlots func() ([]ReplicationSlot, error)
+}
+
+// Table is a system catalog table.
+type Table struct {
+	// Desc describes the table's columns. It has no indexes and is never
+	// stored.
+	Desc *catalog.Table
+	// Rows returns the current rows of the table.
+	Rows func(ctx *Context) ([][]types.Datum, error)
+}
+
+var (
+	tables     = make(map[string]*Table)
+	infoTables = make(map[string]*Table)
+	// functions are the functions of Schema that return a table, which
+	// are called without arguments in FROM.
+	functions = make(map[string]*Table)
+)
+
+// register adds a table with the given columns.
+func register(name string, cols []catalog.Column, rows func(*Context) ([][]types.Datum, error)) {
+	tables[name] = newTable(name, cols, rows)
+}
+
+// registerInfo adds a table of InformationSchema.
+func registerInfo(name string, cols []catalog.Column, rows func(*Context) ([][]types.Datum, error)) {
+	infoTables[name] = newTable(name, cols, rows)
+}
+
+// registerFunc adds a function returning a table with the given columns.
+func registerFunc(name string, cols []catalog.Column, rows func(*Context) ([][]types.Datum, error)) {
+	functions[name] = newTable(name, cols, rows)
+}
+
+func newTable(name string, cols []catalog.Column, rows func(*Context) ([][]types.Datum, error)) *Table {
+	desc := catalog.NewTable(name)
+	for _, c := range cols {
+		desc.AddColumn(c.Name, c.Type, true)
+	}
+	return &Table{Desc: desc, Rows: rows}
+}
+
+// Lookup returns the named table of Schema, or nil if there is none.
+func Lookup(name string) *Table {
+	return tables[name]
+}
+
+// LookupIn returns the named table of schema, or nil if there is none. The
+// tables of Schema are also found without a schema.
+func LookupIn(schema, name string) *Table {
+	switch schema {
+	case "", Schema:
+		return tables[name]
+	case InformationSchema:
+		return infoTables[name]
+	}
+	return nil
+}
+
+// LookupFunc returns the named function of schema that returns a table,
+// or nil if there is none. The functions of Schema are also found without
+// a schema.
+func LookupFunc(schema, name string) *Table {
+	if schema == "" || schema == Schema {
+		return functions[name]
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package storage
+
+/*
+#include "pgz.h"
+#include <stdlib.h>
+
+extern int pgzArchiveSegment(uintptr_t arg, char* path, uint64_t segment);
+
+static int archive_segment(uintptr_t arg, const char* path, uint64_t segment) {
+	return pgzArchiveSegment(arg, (char*)path, segment);
+}
+
+static void set_archive_fn(DB* db, uintptr_t arg) {
+	pgz_set_archive_fn(db, arg ? archive_segment : NULL, arg);
+}
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"runtime/cgo"
+	"time"
+	"unsafe"
+)
+
+// ArchiveFunc archives the full, synced log segment at path, the segment'th
+// of the database. Until it returns nil, the database keeps the segment and
+// calls it again later.
+type ArchiveFunc func(path string, segment uint64) error
+
+// SegmentName returns the file name of the segment'th log segment, which
+// the segments Recover replays must be named by.
+func SegmentName(segment uint64) string {
+	return fmt.Sprintf("%016X.log", segment)
+}
+
+// SetArchiveFunc makes the database call fn with each log segment once it
+// is full, in order, or stops archiving if fn is nil. fn may be called
+// from any goroutine.
+func (db *DB) SetArchiveFunc(fn ArchiveFunc) {
+	if db.acquire() != nil {
+		return
+	}
+	defer db.release()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	old := db.archive
+	db.archive = 0
+	if fn != nil {
+		db.archive = cgo.NewHandle(fn)
+	}
+	C.set_archive_fn(db.ptr, C.uintptr_t(db.archive))
+	if old != 0 {
+		old.Delete()
+	}
+}
+
+// Recover replays the log segments in the directory segments onto the
+// database at path, which must not be open: a backup taken before the
+// first of them. Replay stops before the first transaction committed after
+// target, or at the end of the last segment if target is zero. The engine
+// cannot replay its log yet, so it fails with ErrUnsupported.
+func Recover(path, segments string, target time.Time) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cpath, csegments := C.CString(path), C.CString(segments)
+	defer C.free(unsafe.Pointer(cpath))
+	defer C.free(unsafe.Pointer(csegments))
+	targetUS := int64(-1)
+	if !target.IsZero() {
+		targetUS = target.UnixMicro()
+	}
+	if C.pgz_recover(cpath, csegments, C.int64_t(targetUS)) != C.PGZ_OK {
+		return lastError()
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package storage
+
+// #include "pgz.h"
+import "C"
+import "runtime/cgo"
+
+// pgzArchiveSegment is the archive function of the databases given an
+// ArchiveFunc, whose handle is arg.
+//
+//export pgzArchiveSegment
+func pgzArchiveSegment(arg C.uintptr_t, path *C.char, segment C.uint64_t) C.int {
+	fn := cgo.Handle(arg).Value().(ArchiveFunc)
+	if err := fn(C.GoString(path), uint64(segment)); err != nil {
+		return C.PGZ_ERR
+	}
+	return C.PGZ_OK
+}
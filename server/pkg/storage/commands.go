@@ -0,0 +1,180 @@
+package storage
+
+/*
+#include "pgz.h"
+*/
+import "C"
+import (
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// Commands is a buffer of storage operations that one call to Txn.Execute
+// runs in order, which saves the cost of a call into the engine per
+// operation.
+type Commands struct {
+	buf   []byte
+	ops   []byte
+	iters []*Iterator
+	err   error
+}
+
+// Get adds a read of key.
+func (c *Commands) Get(key []byte) {
+	c.checkKey(key)
+	c.add(C.PGZ_CMD_GET)
+	c.buf = appendBytes(c.buf, key)
+}
+
+// Put adds a write of value at key.
+func (c *Commands) Put(key, value []byte) {
+	c.checkKey(key)
+	c.add(C.PGZ_CMD_PUT)
+	c.buf = appendBytes(c.buf, key)
+	c.buf = appendBytes(c.buf, value)
+}
+
+// Delete adds a delete of key.
+func (c *Commands) Delete(key []byte) {
+	c.checkKey(key)
+	c.add(C.PGZ_CMD_DELETE)
+	c.buf = appendBytes(c.buf, key)
+}
+
+// DeleteRange adds a delete of the keys in [start, end). A nil end is the
+// end of the keyspace.
+func (c *Commands) DeleteRange(start, end []byte) {
+	c.add(C.PGZ_CMD_DELETE_RANGE)
+	c.buf = appendBytes(c.buf, start)
+	c.buf = appendBytes(c.buf, end)
+}
+
+// Next adds a step of it, which must be an iterator of the transaction
+// that runs the commands, and stay open until they have run. The engine
+// does not iterate yet, so running a step fails with ErrUnsupported.
+func (c *Commands) Next(it *Iterator) {
+	c.iters = append(c.iters, it)
+	c.add(C.PGZ_CMD_ITER_NEXT)
+	c.buf = binary.LittleEndian.AppendUint64(c.buf, uint64(uintptr(unsafe.Pointer(it.ptr))))
+}
+
+// Len returns the number of commands in c.
+func (c *Commands) Len() int {
+	return len(c.ops)
+}
+
+// Reset empties c, so that it can be reused.
+func (c *Commands) Reset() {
+	c.buf, c.ops, c.iters, c.err = c.buf[:0], c.ops[:0], c.iters[:0], nil
+}
+
+func (c *Commands) add(op byte) {
+	c.buf = append(c.buf, op)
+	c.ops = append(c.ops, op)
+}
+
+func (c *Commands) checkKey(key []byte) {
+	if len(key) == 0 {
+		c.fail(errors.New("empty key"))
+	}
+}
+
+// fail records err as the error of running c, unless it has one.
+func (c *Commands) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// Result is the result of a command run by Txn.Execute.
+type Result struct {
+	// Found is whether a Get found its key, or a Next returned a pair,
+	// which Key and Value are then. A Get's Key is nil. Writes are always
+	// found.
+	Found      bool
+	Key, Value []byte
+}
+
+// Execute runs the commands of c in txn in one call, returning a result
+// for each in order. Writes are staged in txn, and reads see them. If a
+// command fails, it returns its Error and no results; the commands before
+// it have taken effect.
+func (txn *Txn) Execute(c *Commands) ([]Result, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if len(c.ops) == 0 {
+		return nil, nil
+	}
+	if err := txn.enter(); err != nil {
+		return nil, err
+	}
+	defer txn.exit()
+	for _, it := range c.iters {
+		if it.txn != txn {
+			return nil, errors.New("iterator of another transaction")
+		}
+		if it.ptr == nil {
+			return nil, errIterClosed
+		}
+	}
+	var out *C.char
+	var outLen C.size_t
+	rc := C.pgz_execute_batch(txn.db.ptr, txn.ptr,
+		(*C.char)(unsafe.Pointer(&c.buf[0])), C.size_t(len(c.buf)), &out, &outLen)
+	if rc != C.PGZ_OK {
+		return nil, lastError()
+	}
+	resp := C.GoBytes(unsafe.Pointer(out), C.int(outLen))
+	C.pgz_free(out, outLen)
+
+	results := make([]Result, len(c.ops))
+	for i, op := range c.ops {
+		if len(resp) == 0 {
+			return nil, errMalformedResponse
+		}
+		status := resp[0]
+		resp = resp[1:]
+		r := &results[i]
+		r.Found = status == C.PGZ_OK
+		if !r.Found {
+			continue
+		}
+		var ok bool
+		switch op {
+		case C.PGZ_CMD_GET:
+			r.Value, resp, ok = takeBytes(resp)
+		case C.PGZ_CMD_ITER_NEXT:
+			if r.Key, resp, ok = takeBytes(resp); ok {
+				r.Value, resp, ok = takeBytes(resp)
+			}
+		default:
+			ok = true
+		}
+		if !ok {
+			return nil, errMalformedResponse
+		}
+	}
+	return results, nil
+}
+
+// errMalformedResponse is the error of a response of the engine that does
+// not decode as the call that returned it describes.
+var errMalformedResponse = errors.New("malformed response from the storage engine")
+
+// takeBytes takes a byte string prefixed by its length, as appendBytes
+// appends it, off the front of buf.
+func takeBytes(buf []byte) (p, rest []byte, ok bool) {
+	if len(buf) < 4 {
+		return nil, buf, false
+	}
+	n := binary.LittleEndian.Uint32(buf)
+	if uint64(len(buf)-4) < uint64(n) {
+		return nil, buf, false
+	}
+	return buf[4 : 4+n : 4+n], buf[4+n:], true
+}
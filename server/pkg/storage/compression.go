@@ -0,0 +1,91 @@
+package storage
+
+/*
+#include "pgz.h"
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// Compression is an algorithm the engine compresses the values of its
+// sstable blocks with.
+type Compression int
+
+const (
+	CompressionNone Compression = iota
+	CompressionSnappy
+	CompressionZstd
+)
+
+// MaxDictionaryBytes is the largest zstd dictionary a compression rule may
+// have.
+const MaxDictionaryBytes = 1 << 20
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Compression(%d)", int(c))
+}
+
+// CompressionOptions are how the blocks of a key range are compressed.
+type CompressionOptions struct {
+	Algorithm Compression
+	// DictionaryBytes is the size of the dictionary zstd trains on the
+	// blocks of the range, up to MaxDictionaryBytes, or 0 for none. Only
+	// zstd takes a dictionary.
+	DictionaryBytes int
+}
+
+func (o CompressionOptions) validate() error {
+	if o.Algorithm < CompressionNone || o.Algorithm > CompressionZstd {
+		return fmt.Errorf("unknown compression algorithm %v", o.Algorithm)
+	}
+	if o.DictionaryBytes < 0 || o.DictionaryBytes > MaxDictionaryBytes {
+		return fmt.Errorf("a compression dictionary must be at most %d bytes", MaxDictionaryBytes)
+	}
+	if o.DictionaryBytes > 0 && o.Algorithm != CompressionZstd {
+		return fmt.Errorf("%v compression takes no dictionary", o.Algorithm)
+	}
+	return nil
+}
+
+// SetCompression sets how the blocks of the keys starting with prefix are
+// compressed, or if opts is nil, removes the rule of prefix, so that they
+// take that of a shorter prefix. A nil prefix sets the default of the
+// database, which is CompressionNone until set. The rules persist in the
+// database, and apply to the blocks written from then on: existing blocks
+// are rewritten with them as compaction reaches them, or CompactRange. The
+// engine does not compress blocks yet, so a valid rule of snappy or zstd
+// fails with ErrUnsupported.
+func (db *DB) SetCompression(prefix []byte, opts *CompressionOptions) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	algorithm, dict := C.int(C.PGZ_COMPRESSION_DEFAULT), C.uint32_t(0)
+	if opts != nil {
+		if err := opts.validate(); err != nil {
+			return err
+		}
+		algorithm, dict = C.int(opts.Algorithm), C.uint32_t(opts.DictionaryBytes)
+	}
+	var prefixPtr *C.char
+	if len(prefix) > 0 {
+		prefixPtr = (*C.char)(unsafe.Pointer(&prefix[0]))
+	}
+	if err := db.acquire(); err != nil {
+		return err
+	}
+	defer db.release()
+	if C.pgz_set_compression(db.ptr, prefixPtr, C.size_t(len(prefix)), algorithm, dict) != C.PGZ_OK {
+		return lastError()
+	}
+	return nil
+}
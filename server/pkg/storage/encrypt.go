@@ -0,0 +1,57 @@
+package storage
+
+/*
+#include "pgz.h"
+#include <stdlib.h>
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// KeySize is the size of an encryption key, in bytes: the engine is to
+// encrypt with AES-256-GCM.
+const KeySize = 32
+
+var errKeySize = fmt.Errorf("an encryption key must be %d bytes", KeySize)
+
+// Reencrypt rewrites the database at path, encrypted with newKey instead
+// of oldKey, to rotate its key. A nil key stands for no encryption. The
+// database must not be open: Reencrypt takes its lock, and fails with
+// ErrLocked if a process has it open for writing. The engine does not
+// encrypt yet, so it fails with ErrUnsupported and leaves the database as
+// it is.
+func Reencrypt(path string, oldKey, newKey []byte) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for _, key := range [][]byte{oldKey, newKey} {
+		if key != nil && len(key) != KeySize {
+			return errKeySize
+		}
+	}
+	lock, err := lockDir(path, 0)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	oldPtr, oldLen := keyBytes(oldKey)
+	newPtr, newLen := keyBytes(newKey)
+	if C.pgz_reencrypt(cpath, oldPtr, oldLen, newPtr, newLen) != C.PGZ_OK {
+		return fmt.Errorf("failed to re-encrypt database: %w", lastError())
+	}
+	return nil
+}
+
+// keyBytes returns a pointer to key and its length, or nil and 0 for no
+// key.
+func keyBytes(key []byte) (*C.uint8_t, C.size_t) {
+	if len(key) == 0 {
+		return nil, 0
+	}
+	return (*C.uint8_t)(unsafe.Pointer(&key[0])), C.size_t(len(key))
+}
@@ -0,0 +1,107 @@
+package storage
+
+/*
+#include "pgz.h"
+*/
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// Code classifies an Error of the engine.
+type Code int
+
+const (
+	CodeInvalidArgument Code = C.PGZ_E_INVALID_ARGUMENT
+	CodeIO              Code = C.PGZ_E_IO
+	CodeCorruption      Code = C.PGZ_E_CORRUPTION
+	CodeReadOnly        Code = C.PGZ_E_READ_ONLY
+	CodeOutOfMemory     Code = C.PGZ_E_OUT_OF_MEMORY
+	CodeConflict        Code = C.PGZ_E_CONFLICT
+	CodeNoSpace         Code = C.PGZ_E_NO_SPACE
+	CodeInternal        Code = C.PGZ_E_INTERNAL
+	CodeUnsupported     Code = C.PGZ_E_UNSUPPORTED
+)
+
+func (c Code) String() string {
+	switch c {
+	case CodeInvalidArgument:
+		return "invalid argument"
+	case CodeIO:
+		return "I/O error"
+	case CodeCorruption:
+		return "corruption"
+	case CodeReadOnly:
+		return "read-only"
+	case CodeOutOfMemory:
+		return "out of memory"
+	case CodeConflict:
+		return "conflict"
+	case CodeNoSpace:
+		return "no space left"
+	case CodeInternal:
+		return "internal error"
+	case CodeUnsupported:
+		return "unsupported"
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// The sentinels an Error of each code matches with errors.Is. Every Error
+// matches ErrDatabase.
+var (
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrIO              = errors.New("I/O error")
+	ErrCorruption      = errors.New("data corruption")
+	ErrReadOnly        = errors.New("database is read-only")
+	ErrOutOfMemory     = errors.New("out of memory")
+	ErrConflict        = errors.New("write conflict")
+	ErrNoSpace         = errors.New("no space left on device")
+	ErrUnsupported     = errors.New("operation not supported")
+)
+
+var codeErrors = map[Code]error{
+	CodeInvalidArgument: ErrInvalidArgument,
+	CodeIO:              ErrIO,
+	CodeCorruption:      ErrCorruption,
+	CodeReadOnly:        ErrReadOnly,
+	CodeOutOfMemory:     ErrOutOfMemory,
+	CodeConflict:        ErrConflict,
+	CodeNoSpace:         ErrNoSpace,
+	CodeUnsupported:     ErrUnsupported,
+}
+
+// Error is an error the engine returned, with its code and the message it
+// gave.
+type Error struct {
+	Code Code
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("database error: %v: %s", e.Code, e.Msg)
+}
+
+// Is reports whether target is ErrDatabase or the sentinel of e's code.
+func (e *Error) Is(target error) bool {
+	return target == ErrDatabase || target == codeErrors[e.Code]
+}
+
+// maxErrorLen bounds the message of an Error.
+const maxErrorLen = 256
+
+// lastError returns the Error of the call into the engine that just failed.
+// The engine keeps it per thread, so the caller locks its goroutine to its
+// thread, with runtime.LockOSThread, from before the call until after
+// lastError.
+func lastError() error {
+	var buf [maxErrorLen]byte
+	var n C.size_t
+	code := C.pgz_last_error((*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)), &n)
+	if code == C.PGZ_E_NONE {
+		return &Error{Code: CodeInternal, Msg: "no error reported"}
+	}
+	return &Error{Code: Code(code), Msg: string(buf[:min(int(n), len(buf))])}
+}
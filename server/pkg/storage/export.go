@@ -0,0 +1,271 @@
+package storage
+
+/*
+#include "pgz.h"
+*/
+import "C"
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+// ErrChangesCompacted is returned by ExportSince when the changes after the
+// sequence number are no longer kept.
+var ErrChangesCompacted = errors.New("changes since the sequence number are no longer kept")
+
+// The stream ExportSince writes and Import reads starts with exportMagic,
+// the version of its format and the sequence number it starts after, as a
+// little-endian uint64. Each change follows: its kind, its sequence number
+// as a little-endian uint64, then its key and value, each prefixed by its
+// length as a little-endian uint32. changeEnd and the sequence number the
+// stream goes up to end it.
+const (
+	exportMagic   = "PGZX"
+	exportVersion = 1
+
+	changePut         = C.PGZ_CHANGE_PUT
+	changeDelete      = C.PGZ_CHANGE_DELETE
+	changeDeleteRange = C.PGZ_CHANGE_DELETE_RANGE
+	changeEnd         = 0xff
+)
+
+// ExportSince writes the changes of the transactions committed after
+// sequence number seq to w, and returns the sequence number of the last
+// one, which the next incremental export starts after. The sequence number
+// of a change is the commit timestamp of its transaction; 0 exports every
+// change the database keeps, to seed a copy of it.
+//
+// If the changes after seq are no longer kept, it returns
+// ErrChangesCompacted, and a full backup must be taken instead. The engine
+// does not keep its changes yet, so it fails with ErrUnsupported.
+func (db *DB) ExportSince(seq uint64, w io.Writer) (through uint64, err error) {
+	// The stream is read out of the database before any of it is written,
+	// so that a slow writer does not hold off Close.
+	stream, through, err := db.exportStream(seq)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(stream); err != nil {
+		return 0, err
+	}
+	return through, nil
+}
+
+// exportStream returns the stream ExportSince writes for the changes after
+// seq, and the sequence number it goes up to.
+func (db *DB) exportStream(seq uint64) ([]byte, uint64, error) {
+	if err := db.acquire(); err != nil {
+		return nil, 0, err
+	}
+	defer db.release()
+	it, through, err := db.changes(seq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer it.close()
+
+	buf := append([]byte(exportMagic), exportVersion)
+	buf = binary.LittleEndian.AppendUint64(buf, seq)
+	for {
+		kind, cSeq, key, val, err := it.next()
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		buf = binary.LittleEndian.AppendUint64(append(buf, kind), cSeq)
+		buf = appendBytes(buf, key)
+		buf = appendBytes(buf, val)
+	}
+	buf = binary.LittleEndian.AppendUint64(append(buf, changeEnd), through)
+	return buf, through, nil
+}
+
+// changeIterator iterates over the changes of committed transactions, in
+// commit order.
+type changeIterator struct {
+	ptr *C.ChangeIterator
+}
+
+// changes returns an iterator over the changes of the transactions
+// committed after seq, and the sequence number of the last of them. The
+// caller holds db against Close until the iterator is closed.
+func (db *DB) changes(seq uint64) (*changeIterator, uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var it *C.ChangeIterator
+	var cThrough C.uint64_t
+	switch C.pgz_changes(db.ptr, C.uint64_t(seq), &cThrough, &it) {
+	case C.PGZ_OK:
+		return &changeIterator{ptr: it}, uint64(cThrough), nil
+	case C.PGZ_NOT_FOUND:
+		return nil, 0, ErrChangesCompacted
+	default:
+		return nil, 0, lastError()
+	}
+}
+
+// next returns the next change: its kind, the sequence number of its
+// transaction, and its key and value. It returns ErrNotFound at the end.
+func (it *changeIterator) next() (kind byte, seq uint64, key, val []byte, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var cKind C.int
+	var cSeq C.uint64_t
+	var outKey, outVal *C.char
+	var outKeyLen, outValLen C.size_t
+	switch C.pgz_change_next(it.ptr, &cKind, &cSeq, &outKey, &outKeyLen, &outVal, &outValLen) {
+	case C.PGZ_OK:
+	case C.PGZ_NOT_FOUND:
+		return 0, 0, nil, nil, ErrNotFound
+	default:
+		return 0, 0, nil, nil, lastError()
+	}
+	key = C.GoBytes(unsafe.Pointer(outKey), C.int(outKeyLen))
+	val = C.GoBytes(unsafe.Pointer(outVal), C.int(outValLen))
+	C.pgz_free(outKey, outKeyLen)
+	C.pgz_free(outVal, outValLen)
+	return byte(cKind), uint64(cSeq), key, val, nil
+}
+
+func (it *changeIterator) close() {
+	C.pgz_changes_close(it.ptr)
+}
+
+// Import applies the changes of a stream ExportSince wrote, those of each
+// exported transaction in a transaction of its own, and returns the
+// sequence number the stream goes up to. The database should hold what
+// the exporting one did as of the sequence number the stream starts
+// after, as a backup of it or the import of the streams before, or be
+// empty for a stream from 0.
+//
+// On error, the transactions applied before it stay applied, and through
+// is the sequence number of the last of them, after which an export can
+// be taken again to resume.
+func (db *DB) Import(r io.Reader) (through uint64, err error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(exportMagic)+1+8)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return 0, fmt.Errorf("storage: reading export header: %w", err)
+	}
+	if string(head[:len(exportMagic)]) != exportMagic {
+		return 0, errors.New("storage: not an export stream")
+	}
+	if v := head[len(exportMagic)]; v != exportVersion {
+		return 0, fmt.Errorf("storage: unsupported export format version %d", v)
+	}
+	through = binary.LittleEndian.Uint64(head[len(exportMagic)+1:])
+
+	var txn *Txn
+	var b Batch
+	var seq uint64
+	defer func() {
+		if txn != nil {
+			txn.Abort()
+		}
+	}()
+	// commit commits the changes of the transaction of seq.
+	commit := func() error {
+		if txn == nil {
+			return nil
+		}
+		err := txn.Write(&b)
+		if err == nil {
+			err = txn.Commit()
+		} else {
+			txn.Abort()
+		}
+		txn, b = nil, Batch{}
+		if err != nil {
+			return err
+		}
+		through = seq
+		return nil
+	}
+	for {
+		kind, cSeq, err := readChangeHead(br)
+		if err != nil {
+			return through, err
+		}
+		if kind == changeEnd {
+			if err := commit(); err != nil {
+				return through, err
+			}
+			return cSeq, nil
+		}
+		key, err := readBytes(br)
+		if err != nil {
+			return through, err
+		}
+		val, err := readBytes(br)
+		if err != nil {
+			return through, err
+		}
+		if txn != nil && cSeq != seq {
+			if err := commit(); err != nil {
+				return through, err
+			}
+		}
+		if txn == nil {
+			if txn, err = db.Begin(); err != nil {
+				return through, err
+			}
+			seq = cSeq
+		}
+		switch kind {
+		case changePut:
+			b.Put(key, val)
+		case changeDelete:
+			b.Delete(key)
+		case changeDeleteRange:
+			// In order with the writes batched before it.
+			if err := txn.Write(&b); err != nil {
+				return through, err
+			}
+			b = Batch{}
+			if err := txn.DeleteRange(key, val); err != nil {
+				return through, err
+			}
+		default:
+			return through, fmt.Errorf("storage: unknown change kind %d in export stream", kind)
+		}
+	}
+}
+
+// readChangeHead reads the kind and sequence number of the next change of
+// an export stream.
+func readChangeHead(br *bufio.Reader) (kind byte, seq uint64, err error) {
+	var head [9]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return 0, 0, truncated(err)
+	}
+	return head[0], binary.LittleEndian.Uint64(head[1:]), nil
+}
+
+// readBytes reads a byte string prefixed by its length, as appendBytes
+// writes it.
+func readBytes(br *bufio.Reader) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(br, n[:]); err != nil {
+		return nil, truncated(err)
+	}
+	p := make([]byte, binary.LittleEndian.Uint32(n[:]))
+	if _, err := io.ReadFull(br, p); err != nil {
+		return nil, truncated(err)
+	}
+	return p, nil
+}
+
+// truncated reports a stream that ends before its end marker.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("storage: export stream is truncated")
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package storage
+
+import "C"
+import (
+	"maps"
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// Leak is a transaction or iterator left open, as OpenOptions.OnLeak
+// reports it.
+type Leak struct {
+	// Kind is "transaction" or "iterator".
+	Kind string
+	// Stack is the stack trace of the goroutine that began the
+	// transaction or created the iterator.
+	Stack []byte
+	// Collected is whether it was garbage collected before it was
+	// finished or closed, rather than open when the database was closed.
+	Collected bool
+}
+
+// leakTracker records the stacks of the open transactions and iterators of
+// a database, by ID. Its methods do nothing on a nil tracker, that of a
+// database without leak detection.
+type leakTracker struct {
+	fn   func(Leak)
+	mu   sync.Mutex
+	next uint64
+	open map[uint64]Leak
+}
+
+// add records a transaction or iterator of kind being created, returning
+// its ID.
+func (t *leakTracker) add(kind string) uint64 {
+	stack := debug.Stack()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.open[t.next] = Leak{Kind: kind, Stack: stack}
+	return t.next
+}
+
+// remove forgets the transaction or iterator id, which was finished or
+// closed.
+func (t *leakTracker) remove(id uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	delete(t.open, id)
+	t.mu.Unlock()
+}
+
+// collected reports the transaction or iterator id as garbage collected
+// open, and forgets it.
+func (t *leakTracker) collected(id uint64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	l, ok := t.open[id]
+	delete(t.open, id)
+	t.mu.Unlock()
+	if ok {
+		l.Collected = true
+		t.fn(l)
+	}
+}
+
+// reportOpen reports the transactions and iterators still open, in the
+// order they were created, as Close does.
+func (t *leakTracker) reportOpen() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	leaks := make([]Leak, 0, len(t.open))
+	for _, id := range slices.Sorted(maps.Keys(t.open)) {
+		leaks = append(leaks, t.open[id])
+	}
+	t.mu.Unlock()
+	for _, l := range leaks {
+		t.fn(l)
+	}
+}
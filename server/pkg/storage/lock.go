@@ -0,0 +1,67 @@
+package storage
+
+import "C"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrLocked is the error of opening a database another process has open
+// for writing. The error Open returns wraps it with the process's ID.
+var ErrLocked = errors.New("database is locked")
+
+// lockFile is the file in the directory of a database that the process
+// writing it holds an exclusive lock on, and writes its ID to.
+const lockFile = "LOCK"
+
+// lockRetryInterval is how often a lock is tried again until the
+// LockTimeout of OpenOptions.
+const lockRetryInterval = 10 * time.Millisecond
+
+// lockDir takes the exclusive lock of the database at path, creating the
+// directory if it is missing, waiting up to timeout for the process that
+// holds it to close the database. The lock is held until the returned
+// file is closed, or the process exits.
+func lockDir(path string, timeout time.Duration) (*os.File, error) {
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(path, lockFile), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) || !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(lockRetryInterval)
+	}
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		defer f.Close()
+		b, _ := os.ReadFile(f.Name())
+		pid, perr := strconv.Atoi(string(bytes.TrimSpace(b)))
+		if perr != nil {
+			return nil, fmt.Errorf("%w by another process: %s", ErrLocked, path)
+		}
+		return nil, fmt.Errorf("%w by PID %d: %s", ErrLocked, pid, path)
+	}
+	if err == nil {
+		err = f.Truncate(0)
+	}
+	if err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
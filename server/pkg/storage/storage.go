@@ -2,6 +2,13 @@
 //
 // This package uses cgo to call into the Zig-based storage engine
 // via the C API defined in pgz.h.
+//
+// A DB is safe for concurrent use. Close waits for the calls in progress
+// to return, and the calls after it fail with ErrClosed, but the
+// transactions and iterators open go on until they are finished or
+// closed. A Txn may also be used by several goroutines, though its calls,
+// and those of its iterators, run one at a time; once it commits or
+// aborts, they fail.
 package storage
 
 /*
@@ -13,86 +20,542 @@ package storage
 */
 import "C"
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"os"
 	"runtime"
+	"runtime/cgo"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 var (
 	ErrNotFound = errors.New("key not found")
+	// ErrDatabase is matched by every Error of the engine.
 	ErrDatabase = errors.New("database error")
+	// ErrClosed is the error of a call on a closed database, other than
+	// those of the transactions and iterators still open.
+	ErrClosed = errors.New("database is closed")
+)
+
+var (
+	errTxnDone    = errors.New("transaction already finished")
+	errIterClosed = errors.New("iterator is closed")
 )
 
 // DB represents an open database.
 type DB struct {
-	ptr *C.DB
+	// closeMu guards ptr, which is set to nil when the database is freed:
+	// the calls into the engine hold it for reading, and freeing it for
+	// writing.
+	closeMu sync.RWMutex
+	ptr     *C.DB
+	// refs counts the open transactions and iterators, which keep the
+	// database from being freed by Close until the last is finished.
+	refs atomic.Int64
+	// leaks tracks the open transactions and iterators for
+	// OpenOptions.OnLeak, or is nil.
+	leaks *leakTracker
+	// lock is the lock file held while the database is open for writing,
+	// or nil if it is read-only or in memory.
+	lock *os.File
+	// archive is the handle of the ArchiveFunc, or 0.
+	archive cgo.Handle
+
+	// mu guards committed, which is closed, and replaced, when a
+	// transaction commits; see SubscribeChanges. It also guards archive,
+	// and closing, which Close closes under it before it waits for subs,
+	// the subscriptions to changes, to stop using the database.
+	mu        sync.Mutex
+	committed chan struct{}
+	closing   chan struct{}
+	closed    bool
+	subs      sync.WaitGroup
+}
+
+func newDB(ptr *C.DB, lock *os.File, opts OpenOptions) *DB {
+	db := &DB{ptr: ptr, lock: lock, committed: make(chan struct{}), closing: make(chan struct{})}
+	if opts.OnLeak != nil {
+		db.leaks = &leakTracker{fn: opts.OnLeak, open: make(map[uint64]Leak)}
+	}
+	return db
+}
+
+// MemoryPath is the path that opens a database with no disk backing, as
+// OpenOptions.InMemory does.
+const MemoryPath = ":memory:"
+
+// OpenOptions are the options of OpenWithOptions.
+type OpenOptions struct {
+	// InMemory opens a new, empty database kept in memory, which writes
+	// nothing to disk and is lost on Close, for tests to run hermetically
+	// and in parallel. The path is ignored, and each database is separate
+	// from the others.
+	InMemory bool
+	// ReadOnly opens the existing database without writing to it: its log
+	// is replayed in memory, and writes fail. It takes no lock, so that
+	// tools can read a database a server has open, as of when they open
+	// it.
+	ReadOnly bool
+	// LockTimeout is how long opening a database for writing waits for
+	// another process that has it open to close it, before failing with
+	// ErrLocked. Zero fails at once.
+	LockTimeout time.Duration
+	// EncryptionKey, if set, is the KeySize-byte key the database is to
+	// be encrypted at rest with. The engine does not encrypt yet, so
+	// opening with a key fails with ErrUnsupported rather than store the
+	// data in the clear.
+	EncryptionKey []byte
+	// Compression, if set, is the default compression of the database,
+	// as SetCompression with a nil prefix sets it, failing as it fails.
+	// Otherwise the database keeps the default it has. It cannot be set
+	// with ReadOnly.
+	Compression *CompressionOptions
+	// OnLeak, if set, turns on leak detection: the stack that begins each
+	// transaction and creates each iterator is recorded, and OnLeak is
+	// called with those garbage collected before they were finished or
+	// closed, and with those still open when Close is called. Those
+	// garbage collected are finished whether or not it is set. Recording the stacks slows them, so it is meant for tests
+	// and debugging.
+	OnLeak func(Leak)
 }
 
-// Open opens a database at the given path.
+// Open opens a database at the given path for writing, creating it if it
+// is missing. It fails with ErrLocked if another process has it open for
+// writing. MemoryPath opens a new database in memory.
 func Open(path string) (*DB, error) {
+	return OpenWithOptions(path, OpenOptions{})
+}
+
+// OpenReadOnly opens the existing database at path without writing to it,
+// as OpenOptions.ReadOnly describes.
+func OpenReadOnly(path string) (*DB, error) {
+	return OpenWithOptions(path, OpenOptions{ReadOnly: true})
+}
+
+// OpenWithOptions opens the database at path with opts. Opening it for
+// writing takes the lock of its directory, which it holds until Close, so
+// that two processes never write it at once.
+func OpenWithOptions(path string, opts OpenOptions) (*DB, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if opts.Compression != nil {
+		if opts.ReadOnly {
+			return nil, errors.New("the compression of a database cannot be set read-only")
+		}
+		if err := opts.Compression.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.InMemory || path == MemoryPath {
+		switch {
+		case opts.ReadOnly:
+			return nil, errors.New("an in-memory database cannot be opened read-only")
+		case opts.EncryptionKey != nil:
+			return nil, errors.New("an in-memory database cannot be encrypted")
+		}
+		ptr := C.pgz_open_in_memory()
+		if ptr == nil {
+			return nil, fmt.Errorf("failed to open in-memory database: %w", lastError())
+		}
+		return setDefaultCompression(newDB(ptr, nil, opts), opts)
+	}
+
+	if opts.EncryptionKey != nil && len(opts.EncryptionKey) != KeySize {
+		return nil, errKeySize
+	}
+
 	cpath := C.CString(path)
 	defer C.free(unsafe.Pointer(cpath))
 
-	ptr := C.pgz_open(cpath)
+	if opts.ReadOnly {
+		ptr := openDB(cpath, opts)
+		if ptr == nil {
+			return nil, fmt.Errorf("failed to open database read-only: %w", lastError())
+		}
+		return newDB(ptr, nil, opts), nil
+	}
+	lock, err := lockDir(path, opts.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ptr := openDB(cpath, opts)
 	if ptr == nil {
-		return nil, errors.New("failed to open database")
+		lock.Close()
+		return nil, fmt.Errorf("failed to open database: %w", lastError())
 	}
+	return setDefaultCompression(newDB(ptr, lock, opts), opts)
+}
 
-	db := &DB{ptr: ptr}
-	runtime.SetFinalizer(db, (*DB).Close)
+// setDefaultCompression sets the default compression of db that opts
+// give, if any, closing db if it cannot.
+func setDefaultCompression(db *DB, opts OpenOptions) (*DB, error) {
+	if opts.Compression == nil {
+		return db, nil
+	}
+	if err := db.SetCompression(nil, opts.Compression); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
-// Close closes the database.
+// openDB opens the database at path, read-only or encrypted as opts say.
+func openDB(path *C.char, opts OpenOptions) *C.DB {
+	if opts.EncryptionKey != nil {
+		var readOnly C.int
+		if opts.ReadOnly {
+			readOnly = 1
+		}
+		key, n := keyBytes(opts.EncryptionKey)
+		return C.pgz_open_encrypted(path, key, n, readOnly)
+	}
+	if opts.ReadOnly {
+		return C.pgz_open_read_only(path)
+	}
+	return C.pgz_open(path)
+}
+
+// Close closes the database, ending its subscriptions to changes. It
+// waits for the calls in progress to return. The database, and its lock,
+// are released once the transactions and iterators open are finished or
+// closed, at once if there are none.
 func (db *DB) Close() error {
+	db.mu.Lock()
+	if !db.closed {
+		close(db.closing)
+		db.closed = true
+	}
+	db.mu.Unlock()
+	db.subs.Wait()
+	db.leaks.reportOpen()
+
+	db.closeMu.Lock()
+	defer db.closeMu.Unlock()
+	if db.refs.Load() == 0 {
+		db.free()
+	}
+	return nil
+}
+
+// unref drops the reference of a transaction or iterator to db, freeing
+// it if that was the last of a closed database.
+func (db *DB) unref() {
+	if db.refs.Add(-1) > 0 {
+		return
+	}
+	db.closeMu.Lock()
+	defer db.closeMu.Unlock()
+	if db.refs.Load() == 0 && db.isClosed() {
+		db.free()
+	}
+}
+
+func (db *DB) isClosed() bool {
+	select {
+	case <-db.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// free releases the database and its lock, once. The caller holds closeMu
+// for writing.
+func (db *DB) free() {
 	if db.ptr != nil {
 		C.pgz_close(db.ptr)
 		db.ptr = nil
 	}
+	if db.lock != nil {
+		db.lock.Close()
+		db.lock = nil
+	}
+	if db.archive != 0 {
+		db.archive.Delete()
+		db.archive = 0
+	}
+}
+
+// acquire read-locks db against being freed for a call into the engine,
+// until release, or returns ErrClosed if it is closed.
+func (db *DB) acquire() error {
+	db.closeMu.RLock()
+	if db.ptr == nil || db.isClosed() {
+		db.closeMu.RUnlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (db *DB) release() {
+	db.closeMu.RUnlock()
+}
+
+// Sync makes the writes of committed transactions durable.
+func (db *DB) Sync() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := db.acquire(); err != nil {
+		return err
+	}
+	defer db.release()
+	if C.pgz_sync(db.ptr) != C.PGZ_OK {
+		return lastError()
+	}
+	return nil
+}
+
+// Backup copies a consistent snapshot of the committed data into dir, which
+// must not exist, as a database Open can open. Transactions may run and
+// commit meanwhile. The engine cannot copy its files yet: it fails with
+// ErrUnsupported, leaving dir uncreated.
+func (db *DB) Backup(dir string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := db.acquire(); err != nil {
+		return err
+	}
+	defer db.release()
+	cdir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cdir))
+	if C.pgz_backup(db.ptr, cdir) != C.PGZ_OK {
+		return lastError()
+	}
+	return nil
+}
+
+// Space returns the live and dead bytes of the keys in [start, end), as
+// engine.SpaceStats describes them. A nil end is the end of the keyspace.
+// The engine does not account them yet: it fails with ErrUnsupported.
+func (db *DB) Space(start, end []byte) (live, dead uint64, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var startPtr, endPtr *C.char
+	var startLen, endLen C.size_t
+
+	if len(start) > 0 {
+		startPtr = (*C.char)(unsafe.Pointer(&start[0]))
+		startLen = C.size_t(len(start))
+	}
+	if len(end) > 0 {
+		endPtr = (*C.char)(unsafe.Pointer(&end[0]))
+		endLen = C.size_t(len(end))
+	}
+
+	if err := db.acquire(); err != nil {
+		return 0, 0, err
+	}
+	defer db.release()
+	var cLive, cDead C.uint64_t
+	if C.pgz_space(db.ptr, startPtr, startLen, endPtr, endLen, &cLive, &cDead) != C.PGZ_OK {
+		return 0, 0, lastError()
+	}
+	return uint64(cLive), uint64(cDead), nil
+}
+
+// CompactRange compacts the keys in [start, end) at once, rather than
+// waiting for background compaction, reclaiming the space of the versions
+// overwritten or deleted that no open snapshot reads. A nil end is the end
+// of the keyspace. There is no compactor yet, so it fails with
+// ErrUnsupported.
+func (db *DB) CompactRange(start, end []byte) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var startPtr, endPtr *C.char
+	var startLen, endLen C.size_t
+
+	if len(start) > 0 {
+		startPtr = (*C.char)(unsafe.Pointer(&start[0]))
+		startLen = C.size_t(len(start))
+	}
+	if len(end) > 0 {
+		endPtr = (*C.char)(unsafe.Pointer(&end[0]))
+		endLen = C.size_t(len(end))
+	}
+
+	if err := db.acquire(); err != nil {
+		return err
+	}
+	defer db.release()
+	if C.pgz_compact_range(db.ptr, startPtr, startLen, endPtr, endLen) != C.PGZ_OK {
+		return lastError()
+	}
 	return nil
 }
 
+// Compact compacts the whole database, as CompactRange(nil, nil) does.
+func (db *DB) Compact() error {
+	return db.CompactRange(nil, nil)
+}
+
+// Stats are statistics of a database as a whole.
+type Stats struct {
+	// KeyCount is the number of keys whose latest committed version is not
+	// a tombstone.
+	KeyCount uint64
+	// DataBytes is the size of the sstables and value log segments on
+	// disk.
+	DataBytes uint64
+	// LiveBytes and DeadBytes are those of the whole keyspace, as Space
+	// reports them.
+	LiveBytes, DeadBytes uint64
+	// Compacting is whether a compaction is running, and
+	// CompactionPendingBytes the size of the sstables compaction is due to
+	// rewrite.
+	Compacting             bool
+	CompactionPendingBytes uint64
+	// BlockCacheHits and BlockCacheMisses count the reads of sstable
+	// blocks the block cache served and those it did not, since the
+	// database was opened.
+	BlockCacheHits, BlockCacheMisses uint64
+	// PendingWALBytes is the size of the log written but not yet synced.
+	PendingWALBytes uint64
+	// UncompressedBytes and CompressedBytes are the size of the sstable
+	// blocks before compression and after, as they are on disk.
+	UncompressedBytes, CompressedBytes uint64
+}
+
+// Stats returns the statistics of the database. The database keeps none
+// yet, so it fails with ErrUnsupported.
+func (db *DB) Stats() (Stats, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := db.acquire(); err != nil {
+		return Stats{}, err
+	}
+	defer db.release()
+	var c C.DBStats
+	if C.pgz_stats(db.ptr, &c) != C.PGZ_OK {
+		return Stats{}, lastError()
+	}
+	return Stats{
+		KeyCount:               uint64(c.key_count),
+		DataBytes:              uint64(c.data_bytes),
+		LiveBytes:              uint64(c.live_bytes),
+		DeadBytes:              uint64(c.dead_bytes),
+		Compacting:             c.compacting != 0,
+		CompactionPendingBytes: uint64(c.compaction_pending_bytes),
+		BlockCacheHits:         uint64(c.block_cache_hits),
+		BlockCacheMisses:       uint64(c.block_cache_misses),
+		PendingWALBytes:        uint64(c.pending_wal_bytes),
+		UncompressedBytes:      uint64(c.uncompressed_bytes),
+		CompressedBytes:        uint64(c.compressed_bytes),
+	}, nil
+}
+
 // Txn represents a transaction.
 type Txn struct {
-	db  *DB
+	db *DB
+	// mu serializes the calls on the transaction and its iterators, and
+	// guards ptr, which is nil once it has finished.
+	mu  sync.Mutex
 	ptr *C.Transaction
+	// leak is the ID of the transaction in the leak tracker of db.
+	leak uint64
 }
 
 // Begin starts a new transaction.
 func (db *DB) Begin() (*Txn, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.release()
 	ptr := C.pgz_txn_begin(db.ptr)
 	if ptr == nil {
-		return nil, errors.New("failed to begin transaction")
+		return nil, lastError()
+	}
+	db.refs.Add(1)
+	txn := &Txn{db: db, ptr: ptr}
+	if db.leaks != nil {
+		txn.leak = db.leaks.add("transaction")
+	}
+	runtime.SetFinalizer(txn, (*Txn).collect)
+	return txn, nil
+}
+
+// enter starts a call on txn, which exit ends, holding its lock and the
+// database against being freed, which the reference of txn keeps it from
+// until it finishes. It fails if txn has finished.
+func (txn *Txn) enter() error {
+	txn.mu.Lock()
+	if txn.ptr == nil {
+		txn.mu.Unlock()
+		return errTxnDone
+	}
+	txn.db.closeMu.RLock()
+	return nil
+}
+
+// finish ends txn, whose call holds it, dropping its reference to the
+// database.
+func (txn *Txn) finish() {
+	txn.ptr = nil
+	txn.exit()
+	txn.db.leaks.remove(txn.leak)
+	txn.db.unref()
+}
+
+// collect aborts txn if it is garbage collected unfinished, dropping its
+// reference to the database so that Close can free it, and reports it as a
+// leak if leak detection is on.
+func (txn *Txn) collect() {
+	if txn.ptr != nil {
+		txn.db.leaks.collected(txn.leak)
+		txn.Abort()
 	}
-	return &Txn{db: db, ptr: ptr}, nil
+}
+
+func (txn *Txn) exit() {
+	txn.db.release()
+	txn.mu.Unlock()
 }
 
 // Commit commits the transaction.
 func (txn *Txn) Commit() error {
-	if txn.ptr == nil {
-		return errors.New("transaction already finished")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := txn.enter(); err != nil {
+		return err
 	}
 	rc := C.pgz_txn_commit(txn.db.ptr, txn.ptr)
-	txn.ptr = nil
+	txn.finish()
 	if rc != C.PGZ_OK {
-		return ErrDatabase
+		return lastError()
 	}
+	txn.db.mu.Lock()
+	close(txn.db.committed)
+	txn.db.committed = make(chan struct{})
+	txn.db.mu.Unlock()
 	return nil
 }
 
-// Abort aborts the transaction.
+// Abort aborts the transaction. Aborting a finished transaction does
+// nothing.
 func (txn *Txn) Abort() {
-	if txn.ptr != nil {
-		C.pgz_txn_abort(txn.db.ptr, txn.ptr)
-		txn.ptr = nil
+	if txn.enter() != nil {
+		return
 	}
+	C.pgz_txn_abort(txn.db.ptr, txn.ptr)
+	txn.finish()
 }
 
-// Get retrieves a value by key.
+// Get retrieves a value by key, as the writes txn has staged leave it.
 func (txn *Txn) Get(key []byte) ([]byte, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	if len(key) == 0 {
 		return nil, errors.New("empty key")
 	}
+	if err := txn.enter(); err != nil {
+		return nil, err
+	}
+	defer txn.exit()
 
 	var outVal *C.char
 	var outLen C.size_t
@@ -114,15 +577,21 @@ func (txn *Txn) Get(key []byte) ([]byte, error) {
 	case C.PGZ_NOT_FOUND:
 		return nil, ErrNotFound
 	default:
-		return nil, ErrDatabase
+		return nil, lastError()
 	}
 }
 
-// Put stores a key-value pair.
+// Put stores a key-value pair, staging it in txn for its commit to apply.
 func (txn *Txn) Put(key, value []byte) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	if len(key) == 0 {
 		return errors.New("empty key")
 	}
+	if err := txn.enter(); err != nil {
+		return err
+	}
+	defer txn.exit()
 
 	var valPtr *C.char
 	var valLen C.size_t
@@ -141,16 +610,22 @@ func (txn *Txn) Put(key, value []byte) error {
 	)
 
 	if rc != C.PGZ_OK {
-		return ErrDatabase
+		return lastError()
 	}
 	return nil
 }
 
-// Delete removes a key.
+// Delete removes a key, staging the delete in txn for its commit to apply.
 func (txn *Txn) Delete(key []byte) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	if len(key) == 0 {
 		return errors.New("empty key")
 	}
+	if err := txn.enter(); err != nil {
+		return err
+	}
+	defer txn.exit()
 
 	rc := C.pgz_delete(
 		txn.db.ptr,
@@ -160,18 +635,111 @@ func (txn *Txn) Delete(key []byte) error {
 	)
 
 	if rc != C.PGZ_OK {
-		return ErrDatabase
+		return lastError()
 	}
 	return nil
 }
 
-// Iterator represents a range scan iterator.
+// DeleteRange removes the keys in the range [start, end).
+func (txn *Txn) DeleteRange(start, end []byte) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var startPtr, endPtr *C.char
+	var startLen, endLen C.size_t
+
+	if len(start) > 0 {
+		startPtr = (*C.char)(unsafe.Pointer(&start[0]))
+		startLen = C.size_t(len(start))
+	}
+	if len(end) > 0 {
+		endPtr = (*C.char)(unsafe.Pointer(&end[0]))
+		endLen = C.size_t(len(end))
+	}
+
+	if err := txn.enter(); err != nil {
+		return err
+	}
+	defer txn.exit()
+	rc := C.pgz_delete_range(txn.db.ptr, txn.ptr, startPtr, startLen, endPtr, endLen)
+	if rc != C.PGZ_OK {
+		return lastError()
+	}
+	return nil
+}
+
+// Batch is a sequence of writes applied by one call to Txn.Write.
+type Batch struct {
+	buf []byte
+}
+
+// Put adds a write of value at key.
+func (b *Batch) Put(key, value []byte) {
+	b.buf = append(b.buf, C.PGZ_BATCH_PUT)
+	b.buf = appendBytes(b.buf, key)
+	b.buf = appendBytes(b.buf, value)
+}
+
+// Delete adds a delete of key.
+func (b *Batch) Delete(key []byte) {
+	b.buf = append(b.buf, C.PGZ_BATCH_DELETE)
+	b.buf = appendBytes(b.buf, key)
+}
+
+// appendBytes appends p prefixed by its length.
+func appendBytes(buf, p []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(p)))
+	return append(buf, p...)
+}
+
+// Write stages the writes of b in txn, in order, for its commit to apply.
+func (txn *Txn) Write(b *Batch) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if len(b.buf) == 0 {
+		return nil
+	}
+	if err := txn.enter(); err != nil {
+		return err
+	}
+	defer txn.exit()
+	rc := C.pgz_write_batch(txn.db.ptr, txn.ptr, (*C.char)(unsafe.Pointer(&b.buf[0])), C.size_t(len(b.buf)))
+	if rc != C.PGZ_OK {
+		return lastError()
+	}
+	return nil
+}
+
+// Iterator represents a range scan iterator. Its calls run one at a time
+// with those of its transaction.
 type Iterator struct {
+	txn *Txn
 	ptr *C.Iterator
+	// leak is the ID of the iterator in the leak tracker of its database.
+	leak uint64
+}
+
+// enter starts a call on it, as Txn.enter does, failing if it is closed.
+// Like a transaction, an open iterator holds a reference to the database,
+// so that it can outlive its transaction.
+func (it *Iterator) enter() error {
+	it.txn.mu.Lock()
+	if it.ptr == nil {
+		it.txn.mu.Unlock()
+		return errIterClosed
+	}
+	it.txn.db.closeMu.RLock()
+	return nil
+}
+
+func (it *Iterator) exit() {
+	it.txn.exit()
 }
 
-// Scan creates an iterator for the key range [start, end).
+// Scan creates an iterator for the key range [start, end). The engine
+// does not iterate yet, so it fails with ErrUnsupported.
 func (txn *Txn) Scan(start, end []byte) (*Iterator, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 	var startPtr, endPtr *C.char
 	var startLen, endLen C.size_t
 
@@ -184,16 +752,32 @@ func (txn *Txn) Scan(start, end []byte) (*Iterator, error) {
 		endLen = C.size_t(len(end))
 	}
 
+	if err := txn.enter(); err != nil {
+		return nil, err
+	}
+	defer txn.exit()
 	ptr := C.pgz_scan(txn.db.ptr, txn.ptr, startPtr, startLen, endPtr, endLen)
 	if ptr == nil {
-		return nil, errors.New("failed to create iterator")
+		return nil, lastError()
+	}
+	txn.db.refs.Add(1)
+	it := &Iterator{txn: txn, ptr: ptr}
+	if txn.db.leaks != nil {
+		it.leak = txn.db.leaks.add("iterator")
 	}
-	return &Iterator{ptr: ptr}, nil
+	runtime.SetFinalizer(it, (*Iterator).collect)
+	return it, nil
 }
 
 // Next advances the iterator and returns the next key-value pair.
 // Returns nil, nil, ErrNotFound when exhausted.
 func (it *Iterator) Next() (key, value []byte, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := it.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer it.exit()
 	var outKey, outVal *C.char
 	var outKeyLen, outValLen C.size_t
 
@@ -209,15 +793,76 @@ func (it *Iterator) Next() (key, value []byte, err error) {
 	case C.PGZ_NOT_FOUND:
 		return nil, nil, ErrNotFound
 	default:
-		return nil, nil, ErrDatabase
+		return nil, nil, lastError()
+	}
+}
+
+// nextNBytes is the size of the pairs after which NextN stops, even if it
+// has fewer than n, so that its response stays bounded.
+const nextNBytes = 1 << 20
+
+// NextN advances the iterator by up to n pairs in one call, returning their
+// keys and values. It returns fewer once their size reaches 1 MiB, and
+// nil, nil, ErrNotFound when exhausted.
+func (it *Iterator) NextN(n int) (keys, values [][]byte, err error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if n <= 0 {
+		return nil, nil, errors.New("NextN of no pairs")
+	}
+	if err := it.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer it.exit()
+	var out *C.char
+	var outLen, count C.size_t
+
+	rc := C.pgz_iter_next_n(it.ptr, C.size_t(n), nextNBytes, &out, &outLen, &count)
+
+	switch rc {
+	case C.PGZ_OK:
+	case C.PGZ_NOT_FOUND:
+		return nil, nil, ErrNotFound
+	default:
+		return nil, nil, lastError()
+	}
+	resp := C.GoBytes(unsafe.Pointer(out), C.int(outLen))
+	C.pgz_free(out, outLen)
+
+	keys = make([][]byte, count)
+	values = make([][]byte, count)
+	for i := range keys {
+		var ok bool
+		if keys[i], resp, ok = takeBytes(resp); ok {
+			values[i], resp, ok = takeBytes(resp)
+		}
+		if !ok {
+			return nil, nil, errMalformedResponse
+		}
 	}
+	return keys, values, nil
 }
 
-// Close closes the iterator.
+// Close closes the iterator. Closing it again does nothing.
 func (it *Iterator) Close() {
+	if it.enter() != nil {
+		return
+	}
+	C.pgz_iter_close(it.ptr)
+	it.ptr = nil
+	it.exit()
+	db := it.txn.db
+	db.leaks.remove(it.leak)
+	db.unref()
+}
+
+// collect closes it if it is garbage collected open, dropping its
+// reference to the database, and reports it as a leak if leak detection is
+// on.
+func (it *Iterator) collect() {
 	if it.ptr != nil {
-		C.pgz_iter_close(it.ptr)
-		it.ptr = nil
+		it.txn.db.leaks.collected(it.leak)
+		it.Close()
 	}
 }
 
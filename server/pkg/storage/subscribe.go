@@ -0,0 +1,144 @@
+package storage
+
+// #include "pgz.h"
+import "C"
+import (
+	"context"
+	"errors"
+)
+
+// ChangeKind is the kind of a Change.
+type ChangeKind byte
+
+// Kinds of change.
+const (
+	ChangePut         ChangeKind = C.PGZ_CHANGE_PUT
+	ChangeDelete      ChangeKind = C.PGZ_CHANGE_DELETE
+	ChangeDeleteRange ChangeKind = C.PGZ_CHANGE_DELETE_RANGE
+)
+
+// Change is a write of a committed transaction. A put has the key and the
+// value written, and a delete the key. A deleted range has its start as
+// Key and its end as Value, empty for the end of the keyspace.
+type Change struct {
+	Kind       ChangeKind
+	Key, Value []byte
+}
+
+// ChangeEvent is a committed transaction, as SubscribeChanges sends it.
+type ChangeEvent struct {
+	// Seq is the sequence number of the transaction, its commit
+	// timestamp, from which a subscription resumes after it.
+	Seq     uint64
+	Changes []Change
+	// Err is set on the last event of a subscription that failed, as when
+	// it fell so far behind that the changes it had yet to send are no
+	// longer kept. Its other fields are zero.
+	Err error
+}
+
+// SubscribeChanges returns a channel that receives the transactions
+// committed after sequence number fromSeq, one event each in commit
+// order, first those already committed and then the others as they
+// commit. 0 starts from the first change the database keeps, as
+// ExportSince does. The channel is closed when ctx is done or the
+// database is closed, or after an event with Err if the subscription
+// fails.
+//
+// The subscription holds the changes it has read until they are
+// received, so a subscriber that falls behind only delays itself. If the
+// changes after fromSeq are no longer kept, it returns
+// ErrChangesCompacted.
+func (db *DB) SubscribeChanges(ctx context.Context, fromSeq uint64) (<-chan ChangeEvent, error) {
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.release()
+	// Close waits for the subscriptions added before it closes closing,
+	// while they use the database.
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil, ErrClosed
+	}
+	committed := db.committed
+	db.subs.Add(1)
+	db.mu.Unlock()
+	it, through, err := db.changes(fromSeq)
+	if err != nil {
+		db.subs.Done()
+		return nil, err
+	}
+	ch := make(chan ChangeEvent)
+	go func() {
+		defer db.subs.Done()
+		defer close(ch)
+		send := func(ev ChangeEvent) bool {
+			select {
+			case ch <- ev:
+				return true
+			case <-ctx.Done():
+			case <-db.closing:
+			}
+			return false
+		}
+		seq := fromSeq
+		for {
+			err := db.sendChanges(it, send)
+			it.close()
+			if errors.Is(err, errStopped) {
+				return
+			}
+			if err != nil {
+				send(ChangeEvent{Err: err})
+				return
+			}
+			seq = max(seq, through)
+			select {
+			case <-committed:
+			case <-ctx.Done():
+				return
+			case <-db.closing:
+				return
+			}
+			db.mu.Lock()
+			committed = db.committed
+			db.mu.Unlock()
+			if it, through, err = db.changes(seq); err != nil {
+				send(ChangeEvent{Err: err})
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// errStopped stops sendChanges when the subscriber is gone.
+var errStopped = errors.New("subscription stopped")
+
+// sendChanges sends the transactions of it with send, one event each, and
+// returns errStopped if send fails.
+func (db *DB) sendChanges(it *changeIterator, send func(ChangeEvent) bool) error {
+	var ev ChangeEvent
+	for {
+		kind, seq, key, val, err := it.next()
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(ev.Changes) > 0 && seq != ev.Seq {
+			if !send(ev) {
+				return errStopped
+			}
+			ev = ChangeEvent{}
+		}
+		ev.Seq = seq
+		ev.Changes = append(ev.Changes, Change{Kind: ChangeKind(kind), Key: key, Value: val})
+	}
+	if len(ev.Changes) > 0 && !send(ev) {
+		return errStopped
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+/*
+#include "pgz.h"
+#include <stdlib.h>
+
+extern int pgzReportCorruption(uintptr_t arg, char* file, uint64_t offset,
+                               char* start_key, size_t start_len, char* end_key, size_t end_len);
+
+static int report_corruption(uintptr_t arg, const char* file, uint64_t offset,
+                             const char* start_key, size_t start_len, const char* end_key, size_t end_len) {
+	return pgzReportCorruption(arg, (char*)file, offset, (char*)start_key, start_len, (char*)end_key, end_len);
+}
+
+static int verify_checksums(DB* db, const int* cancel, uintptr_t arg, uint64_t* checked, uint64_t* corrupt) {
+	return pgz_verify_checksums(db, cancel, report_corruption, arg, checked, corrupt);
+}
+*/
+import "C"
+import (
+	"context"
+	"runtime"
+	"runtime/cgo"
+	"sync/atomic"
+	"unsafe"
+)
+
+// maxCorruptions is the number of corruptions a ChecksumReport lists; the
+// others are only counted.
+const maxCorruptions = 1000
+
+// Corruption is a block of an sstable, or a record of the value log, whose
+// checksum does not match.
+type Corruption struct {
+	// File is the file it is in, relative to the database directory, and
+	// Offset where it starts in it.
+	File   string
+	Offset uint64
+	// StartKey and EndKey are the first and last keys it holds, as far as
+	// the index of its sstable or its header tells them, or nil.
+	StartKey, EndKey []byte
+}
+
+// ChecksumReport is what VerifyChecksums found.
+type ChecksumReport struct {
+	// Checked is the number of blocks and records read, and Corrupt the
+	// number of them whose checksum did not match.
+	Checked, Corrupt uint64
+	// Corruptions are the first of those that did not match, up to a
+	// thousand of them.
+	Corruptions []Corruption
+}
+
+// VerifyChecksums reads every sstable block and value log record and checks
+// it against its checksum, so that corruption is found before reads return
+// it. Corrupt data is reported, not returned as an error. It stops with
+// ctx's error once ctx is done.
+func (db *DB) VerifyChecksums(ctx context.Context) (*ChecksumReport, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.release()
+	// The flag is C memory, which the engine reads as it goes on.
+	cancel := (*C.int)(C.calloc(1, C.size_t(unsafe.Sizeof(C.int(0)))))
+	defer C.free(unsafe.Pointer(cancel))
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32((*int32)(unsafe.Pointer(cancel)), 1)
+		case <-done:
+		}
+	}()
+
+	r := &ChecksumReport{}
+	h := cgo.NewHandle(r)
+	defer h.Delete()
+	var cChecked, cCorrupt C.uint64_t
+	switch C.verify_checksums(db.ptr, cancel, C.uintptr_t(h), &cChecked, &cCorrupt) {
+	case C.PGZ_OK:
+	case C.PGZ_CANCELED:
+		return nil, ctx.Err()
+	default:
+		return nil, lastError()
+	}
+	r.Checked, r.Corrupt = uint64(cChecked), uint64(cCorrupt)
+	return r, nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+// #include "pgz.h"
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// pgzReportCorruption is the function VerifyChecksums gives the engine to
+// report corruptions with, adding them to the ChecksumReport whose handle
+// is arg.
+//
+//export pgzReportCorruption
+func pgzReportCorruption(arg C.uintptr_t, file *C.char, offset C.uint64_t,
+	startKey *C.char, startLen C.size_t, endKey *C.char, endLen C.size_t) C.int {
+	r := cgo.Handle(arg).Value().(*ChecksumReport)
+	if len(r.Corruptions) < maxCorruptions {
+		r.Corruptions = append(r.Corruptions, Corruption{
+			File:     C.GoString(file),
+			Offset:   uint64(offset),
+			StartKey: goBytes(startKey, startLen),
+			EndKey:   goBytes(endKey, endLen),
+		})
+	}
+	return 0
+}
+
+// goBytes copies the n bytes at p, or returns nil if there are none.
+func goBytes(p *C.char, n C.size_t) []byte {
+	if n == 0 {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(p), C.int(n))
+}
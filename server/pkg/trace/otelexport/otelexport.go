@@ -0,0 +1,111 @@
+// Package otelexport hands the spans of package trace to an OpenTelemetry
+// SDK, so that the server's traces go wherever the embedder's do:
+//
+//	p := sdktrace.NewBatchSpanProcessor(exporter)
+//	defer p.Shutdown(ctx)
+//	srv.SetTraceExporter(otelexport.New(p, res))
+//
+// The span processor does the batching and exporting; spans are handed to
+// it as they end.
+package otelexport
+
+import (
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/alivenotions/pgz/server/pkg/trace"
+)
+
+// scope is the instrumentation scope of the server's spans.
+var scope = instrumentation.Scope{Name: "github.com/alivenotions/pgz/server"}
+
+// Exporter is a trace.Exporter that ends the spans it is given in an
+// OpenTelemetry span processor.
+type Exporter struct {
+	p   sdktrace.SpanProcessor
+	res *resource.Resource
+}
+
+// New returns an Exporter that ends spans in p, as spans of the resource
+// res, or of resource.Default if res is nil.
+func New(p sdktrace.SpanProcessor, res *resource.Resource) *Exporter {
+	if res == nil {
+		res = resource.Default()
+	}
+	return &Exporter{p: p, res: res}
+}
+
+// ExportSpan implements trace.Exporter.
+func (e *Exporter) ExportSpan(s *trace.Span) {
+	stub := tracetest.SpanStub{
+		Name:                 s.Name,
+		SpanContext:          spanContext(s.Context),
+		Parent:               spanContext(s.Parent),
+		SpanKind:             oteltrace.SpanKindInternal,
+		StartTime:            s.Start,
+		EndTime:              s.End,
+		Attributes:           attributes(nil, "", s.Attrs),
+		Resource:             e.res,
+		InstrumentationScope: scope,
+	}
+	// The root span of the server's part of a trace is the server's
+	// handling of the client's request.
+	if !s.Parent.IsValid() || s.Parent.Remote {
+		stub.SpanKind = oteltrace.SpanKindServer
+	}
+	if s.Err != nil {
+		stub.Status = sdktrace.Status{Code: codes.Error, Description: s.Err.Error()}
+	}
+	e.p.OnEnd(stub.Snapshot())
+}
+
+func spanContext(c trace.SpanContext) oteltrace.SpanContext {
+	if !c.IsValid() {
+		return oteltrace.SpanContext{}
+	}
+	var flags oteltrace.TraceFlags
+	if c.Sampled {
+		flags = oteltrace.FlagsSampled
+	}
+	return oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID(c.TraceID),
+		SpanID:     oteltrace.SpanID(c.SpanID),
+		TraceFlags: flags,
+		Remote:     c.Remote,
+	})
+}
+
+// attributes appends attrs to kvs, with the keys of groups prefixed by
+// the group's key and a dot.
+func attributes(kvs []attribute.KeyValue, prefix string, attrs []slog.Attr) []attribute.KeyValue {
+	for _, a := range attrs {
+		k, v := prefix+a.Key, a.Value.Resolve()
+		switch v.Kind() {
+		case slog.KindString:
+			kvs = append(kvs, attribute.String(k, v.String()))
+		case slog.KindInt64:
+			kvs = append(kvs, attribute.Int64(k, v.Int64()))
+		case slog.KindUint64:
+			kvs = append(kvs, attribute.Int64(k, int64(v.Uint64())))
+		case slog.KindFloat64:
+			kvs = append(kvs, attribute.Float64(k, v.Float64()))
+		case slog.KindBool:
+			kvs = append(kvs, attribute.Bool(k, v.Bool()))
+		case slog.KindDuration:
+			kvs = append(kvs, attribute.Int64(k, v.Duration().Nanoseconds()))
+		case slog.KindGroup:
+			kvs = attributes(kvs, k+".", v.Group())
+		default:
+			kvs = append(kvs, attribute.String(k, fmt.Sprint(v.Any())))
+		}
+	}
+	return kvs
+}
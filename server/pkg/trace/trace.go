@@ -0,0 +1,228 @@
+// Package trace records the spans of the work the server does for a
+// query: parsing, planning, executing and the calls into the storage
+// engine. Spans follow the OpenTelemetry model and carry W3C trace
+// context, so an Exporter can hand them to an OpenTelemetry SDK and they
+// show up in the client's traces; package otelexport is such an Exporter.
+//
+// A client continues its trace into the server by putting its traceparent
+// in a comment of the query, as sqlcommenter does:
+//
+//	SELECT 1 /*traceparent='00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01'*/
+//
+// The other labels of such comments, as application and controller, are
+// returned by CommentLabels, for the server to label the query's spans,
+// statistics and logs with.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TraceID identifies a trace, and SpanID a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext is the part of a span that is propagated to its children,
+// as in a W3C traceparent.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is the sampled flag of the traceparent.
+	Sampled bool
+	// Remote is set for the context of a span of another process, as
+	// parsed from a traceparent.
+	Remote bool
+}
+
+// IsValid reports whether c has a trace and a span ID.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+// Traceparent returns c as the value of a W3C traceparent header.
+func (c SpanContext) Traceparent() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + c.TraceID.String() + "-" + c.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses the value of a W3C traceparent header.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	// Later versions may append fields.
+	parts := strings.Split(s, "-")
+	if len(parts) < 4 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	var c SpanContext
+	var version, flags [1]byte
+	for _, f := range []struct {
+		s   string
+		dst []byte
+	}{{parts[0], version[:]}, {parts[1], c.TraceID[:]}, {parts[2], c.SpanID[:]}, {parts[3], flags[:]}} {
+		if len(f.s) != 2*len(f.dst) || strings.ToLower(f.s) != f.s {
+			return SpanContext{}, false
+		}
+		if _, err := hex.Decode(f.dst, []byte(f.s)); err != nil {
+			return SpanContext{}, false
+		}
+	}
+	c.Sampled, c.Remote = flags[0]&1 != 0, true
+	return c, c.IsValid()
+}
+
+// FromComment returns the trace context of the traceparent in a comment
+// of sql, written traceparent='...' as sqlcommenter does, or unquoted.
+func FromComment(sql string) (SpanContext, bool) {
+	for _, l := range CommentLabels(sql) {
+		if l.Key != "traceparent" {
+			continue
+		}
+		if c, ok := ParseTraceparent(l.Value); ok {
+			return c, true
+		}
+	}
+	return SpanContext{}, false
+}
+
+// Label is a key-value pair of a comment of a query, such as the
+// application or controller sqlcommenter labels a query with.
+type Label struct {
+	Key, Value string
+}
+
+// CommentLabels returns the key-value pairs of the comments of sql, in
+// order, written key='value' and separated by commas as sqlcommenter
+// writes them. Quoted values are URL-decoded, as sqlcommenter encodes
+// them; unquoted values are taken as they are.
+func CommentLabels(sql string) []Label {
+	var labels []Label
+	for rest := sql; ; {
+		start := strings.Index(rest, "/*")
+		if start < 0 {
+			return labels
+		}
+		rest = rest[start+2:]
+		end := strings.Index(rest, "*/")
+		if end < 0 {
+			return labels
+		}
+		comment := rest[:end]
+		rest = rest[end+2:]
+		for _, kv := range strings.Split(comment, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+			if !ok || k == "" {
+				continue
+			}
+			if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+				v = strings.ReplaceAll(v[1:len(v)-1], `\'`, "'")
+				if d, err := url.QueryUnescape(v); err == nil {
+					v = d
+				}
+			}
+			if k, err := url.QueryUnescape(k); err == nil {
+				labels = append(labels, Label{Key: k, Value: v})
+			}
+		}
+	}
+}
+
+// Span is a timed operation of a trace.
+type Span struct {
+	Name    string
+	Context SpanContext
+	// Parent is the context of the span's parent, which is invalid for
+	// the root span of a trace.
+	Parent     SpanContext
+	Start, End time.Time
+	Attrs      []slog.Attr
+	// Err is the error the operation failed with, if any.
+	Err error
+
+	exporter Exporter
+}
+
+// Exporter receives the spans that end.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// Start starts a root span named name that exports to e when it ends. It
+// continues the trace of parent if that is valid, and starts a trace
+// otherwise. Without an exporter, or when parent is not sampled, it
+// returns nil, which records nothing.
+func Start(e Exporter, parent SpanContext, name string, attrs ...slog.Attr) *Span {
+	if e == nil || parent.IsValid() && !parent.Sampled {
+		return nil
+	}
+	s := &Span{Name: name, Parent: parent, Start: time.Now(), Attrs: attrs, exporter: e}
+	s.Context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: true}
+	if !parent.IsValid() {
+		rand.Read(s.Context.TraceID[:])
+	}
+	return s
+}
+
+// Child starts a span named name within s. The child of nil is nil.
+func (s *Span) Child(name string, attrs ...slog.Attr) *Span {
+	if s == nil {
+		return nil
+	}
+	return &Span{
+		Name:     name,
+		Context:  SpanContext{TraceID: s.Context.TraceID, SpanID: newSpanID(), Sampled: true},
+		Parent:   s.Context,
+		Start:    time.Now(),
+		Attrs:    attrs,
+		exporter: s.exporter,
+	}
+}
+
+// Record exports a span named name within s that ran from start to end,
+// for work done before s was started, such as reading the query off the
+// wire. s is moved to start with it.
+func (s *Span) Record(name string, start, end time.Time, attrs ...slog.Attr) {
+	if s == nil {
+		return
+	}
+	c := s.Child(name, attrs...)
+	c.Start, c.End = start, end
+	s.exporter.ExportSpan(c)
+	if start.Before(s.Start) {
+		s.Start = start
+	}
+}
+
+// SetAttrs adds attributes to s.
+func (s *Span) SetAttrs(attrs ...slog.Attr) {
+	if s != nil {
+		s.Attrs = append(s.Attrs, attrs...)
+	}
+}
+
+// Finish ends s, recording err as its outcome, and exports it.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End, s.Err = time.Now(), err
+	s.exporter.ExportSpan(s)
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
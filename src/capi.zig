@@ -22,6 +22,77 @@ const allocator = gpa.allocator();
 pub const PGZ_OK: c_int = 0;
 pub const PGZ_ERR: c_int = -1;
 pub const PGZ_NOT_FOUND: c_int = 1;
+pub const PGZ_CANCELED: c_int = 2;
+
+// =============================================================================
+// Error Details
+// =============================================================================
+
+pub const PGZ_E_NONE: c_int = 0;
+pub const PGZ_E_INVALID_ARGUMENT: c_int = 1;
+pub const PGZ_E_IO: c_int = 2;
+pub const PGZ_E_CORRUPTION: c_int = 3;
+pub const PGZ_E_READ_ONLY: c_int = 4;
+pub const PGZ_E_OUT_OF_MEMORY: c_int = 5;
+pub const PGZ_E_CONFLICT: c_int = 6;
+pub const PGZ_E_NO_SPACE: c_int = 7;
+pub const PGZ_E_INTERNAL: c_int = 8;
+pub const PGZ_E_UNSUPPORTED: c_int = 9;
+
+/// The code and message of the last call that failed on this thread, for
+/// pgz_last_error.
+threadlocal var last_error_code: c_int = PGZ_E_NONE;
+threadlocal var last_error_msg: [256]u8 = undefined;
+threadlocal var last_error_len: usize = 0;
+
+/// Records err as the last error of this thread, returning PGZ_ERR.
+fn fail(err: anyerror) c_int {
+    return failMsg(errorCode(err), @errorName(err));
+}
+
+/// Records code and msg, truncated, as the last error of this thread,
+/// returning PGZ_ERR.
+fn failMsg(code: c_int, msg: []const u8) c_int {
+    const n = @min(msg.len, last_error_msg.len);
+    @memcpy(last_error_msg[0..n], msg[0..n]);
+    last_error_code = code;
+    last_error_len = n;
+    return PGZ_ERR;
+}
+
+/// Returns the code of the errors like err.
+fn errorCode(err: anyerror) c_int {
+    return switch (err) {
+        error.InvalidArgument, error.InvalidKeyLength, error.KeyTooLarge, error.ValueTooLarge => PGZ_E_INVALID_ARGUMENT,
+        error.ChecksumMismatch, error.Corruption, error.WrongKey => PGZ_E_CORRUPTION,
+        error.ReadOnly => PGZ_E_READ_ONLY,
+        error.OutOfMemory => PGZ_E_OUT_OF_MEMORY,
+        error.WriteConflict => PGZ_E_CONFLICT,
+        error.NoSpaceLeft, error.DiskQuota => PGZ_E_NO_SPACE,
+        error.InputOutput, error.AccessDenied, error.FileNotFound, error.PathAlreadyExists, error.Unexpected => PGZ_E_IO,
+        error.Unsupported => PGZ_E_UNSUPPORTED,
+        else => PGZ_E_INTERNAL,
+    };
+}
+
+/// Returns the code of the last call that failed on this thread, or
+/// PGZ_E_NONE if none has, copying its message into buf, truncated to
+/// buf_len bytes, and setting msg_len to its full length.
+export fn pgz_last_error(buf: ?[*]u8, buf_len: usize, msg_len: *usize) c_int {
+    if (buf) |b| {
+        const n = @min(buf_len, last_error_len);
+        @memcpy(b[0..n], last_error_msg[0..n]);
+    }
+    msg_len.* = last_error_len;
+    return last_error_code;
+}
+
+// =============================================================================
+// Batch Operations
+// =============================================================================
+
+pub const PGZ_BATCH_PUT: u8 = 0;
+pub const PGZ_BATCH_DELETE: u8 = 1;
 
 // =============================================================================
 // Database Operations
@@ -31,7 +102,51 @@ pub const PGZ_NOT_FOUND: c_int = 1;
 /// Returns null on error.
 export fn pgz_open(path: [*:0]const u8) ?*DB {
     const path_slice = std.mem.span(path);
-    return db_mod.DB.open(allocator, path_slice, .{}) catch null;
+    return db_mod.DB.open(allocator, path_slice, .{}) catch |err| {
+        _ = fail(err);
+        return null;
+    };
+}
+
+/// Opens the existing database at the given path without writing to it.
+/// Returns null on error.
+export fn pgz_open_read_only(path: [*:0]const u8) ?*DB {
+    const path_slice = std.mem.span(path);
+    return db_mod.DB.open(allocator, path_slice, .{ .create_if_missing = false, .read_only = true }) catch |err| {
+        _ = fail(err);
+        return null;
+    };
+}
+
+/// Opens the database at the given path, encrypted with the key_len bytes
+/// of key, read-only if read_only is nonzero.
+/// Returns null on error, or if the key is not 32 bytes. Nothing is
+/// encrypted yet, so a key of the right length fails with
+/// PGZ_E_UNSUPPORTED as the last error code.
+export fn pgz_open_encrypted(path: [*:0]const u8, key: [*]const u8, key_len: usize, read_only: c_int) ?*DB {
+    if (key_len != db_mod.key_length) {
+        _ = failMsg(PGZ_E_INVALID_ARGUMENT, "encryption key is not 32 bytes");
+        return null;
+    }
+    const path_slice = std.mem.span(path);
+    var options: db_mod.Options = .{ .encryption_key = key[0..db_mod.key_length].* };
+    if (read_only != 0) {
+        options.create_if_missing = false;
+        options.read_only = true;
+    }
+    return db_mod.DB.open(allocator, path_slice, options) catch |err| {
+        _ = fail(err);
+        return null;
+    };
+}
+
+/// Opens a new database with no disk backing.
+/// Returns null on error.
+export fn pgz_open_in_memory() ?*DB {
+    return db_mod.DB.open(allocator, "", .{ .in_memory = true }) catch |err| {
+        _ = fail(err);
+        return null;
+    };
 }
 
 /// Closes a database and frees its resources.
@@ -41,6 +156,202 @@ export fn pgz_close(database: ?*DB) void {
     }
 }
 
+/// Makes the writes of committed transactions durable.
+/// Returns PGZ_OK on success, PGZ_ERR on failure.
+export fn pgz_sync(database: ?*DB) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    d.sync() catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Copies a consistent snapshot of the committed data into dir, which must
+/// not exist, as a database pgz_open can open. Fails with
+/// PGZ_E_UNSUPPORTED until the data files can be copied.
+/// Returns PGZ_OK on success, PGZ_ERR on failure.
+export fn pgz_backup(database: ?*DB, dir: [*:0]const u8) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    d.backup(std.mem.span(dir)) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Reports the live and dead bytes of the keys in [start_key, end_key).
+/// An empty end_key is the end of the keyspace. Fails with
+/// PGZ_E_UNSUPPORTED until the space of ranges is accounted.
+/// Returns PGZ_OK on success, PGZ_ERR on failure.
+export fn pgz_space(
+    database: ?*DB,
+    start_key: ?[*]const u8,
+    start_len: usize,
+    end_key: ?[*]const u8,
+    end_len: usize,
+    live_bytes: *u64,
+    dead_bytes: *u64,
+) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+
+    const start_slice: []const u8 = if (start_key) |k| k[0..start_len] else &.{};
+    const end_slice: ?[]const u8 = if (end_key) |k| (if (end_len > 0) k[0..end_len] else null) else null;
+
+    const s = d.space(start_slice, end_slice) catch |err| return fail(err);
+    live_bytes.* = s.live_bytes;
+    dead_bytes.* = s.dead_bytes;
+    return PGZ_OK;
+}
+
+/// Compacts the keys in [start_key, end_key), returning once the space of
+/// their dead versions is reclaimed. An empty end_key is the end of the
+/// keyspace, so empty keys compact the whole database.
+/// Returns PGZ_OK on success, PGZ_ERR on failure, with PGZ_E_UNSUPPORTED
+/// as the last error code while there is no compactor.
+export fn pgz_compact_range(
+    database: ?*DB,
+    start_key: ?[*]const u8,
+    start_len: usize,
+    end_key: ?[*]const u8,
+    end_len: usize,
+) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+
+    const start_slice: []const u8 = if (start_key) |k| k[0..start_len] else &.{};
+    const end_slice: ?[]const u8 = if (end_key) |k| (if (end_len > 0) k[0..end_len] else null) else null;
+
+    d.compactRange(start_slice, end_slice) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Sets the compression of the blocks of the keys starting with prefix: an
+/// algorithm of PGZ_COMPRESSION_DEFAULT removes the rule of the prefix,
+/// and an empty prefix is the default of the database.
+/// Returns PGZ_OK on success, PGZ_ERR on failure, with PGZ_E_UNSUPPORTED
+/// as the last error code for a valid rule of snappy or zstd while blocks
+/// are not compressed.
+export fn pgz_set_compression(
+    database: ?*DB,
+    prefix: ?[*]const u8,
+    prefix_len: usize,
+    algorithm: c_int,
+    dictionary_bytes: u32,
+) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+
+    const prefix_slice: []const u8 = if (prefix) |p| p[0..prefix_len] else &.{};
+    const a: ?db_mod.Compression = switch (algorithm) {
+        -1 => null,
+        0 => .none,
+        1 => .snappy,
+        2 => .zstd,
+        else => return failMsg(PGZ_E_INVALID_ARGUMENT, "unknown compression algorithm"),
+    };
+
+    d.setCompression(prefix_slice, a, dictionary_bytes) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Checks every sstable block and value log record against its checksum,
+/// calling f with those that do not match.
+/// Returns PGZ_OK if all were read, PGZ_CANCELED if cancel was set or f
+/// stopped it, PGZ_ERR on failure.
+export fn pgz_verify_checksums(
+    database: ?*DB,
+    cancel: ?*const c_int,
+    f: ?db_mod.CorruptFn,
+    arg: usize,
+    checked: *u64,
+    corrupt: *u64,
+) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const v = d.verifyChecksums(cancel, f, arg) catch |err| switch (err) {
+        error.Canceled => return PGZ_CANCELED,
+    };
+    checked.* = v.checked;
+    corrupt.* = v.corrupt;
+    return PGZ_OK;
+}
+
+/// The statistics pgz_stats reports, laid out as DBStats in pgz.h.
+pub const DBStats = extern struct {
+    key_count: u64,
+    data_bytes: u64,
+    live_bytes: u64,
+    dead_bytes: u64,
+    compaction_pending_bytes: u64,
+    block_cache_hits: u64,
+    block_cache_misses: u64,
+    pending_wal_bytes: u64,
+    uncompressed_bytes: u64,
+    compressed_bytes: u64,
+    compacting: c_int,
+};
+
+/// Reports the statistics of the database in out.
+/// Returns PGZ_OK on success, PGZ_ERR on failure, with PGZ_E_UNSUPPORTED
+/// as the last error code while the database keeps no statistics.
+export fn pgz_stats(database: ?*DB, out: *DBStats) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const s = d.stats() catch |err| return fail(err);
+    out.* = .{
+        .key_count = s.key_count,
+        .data_bytes = s.data_bytes,
+        .live_bytes = s.live_bytes,
+        .dead_bytes = s.dead_bytes,
+        .compaction_pending_bytes = s.compaction_pending_bytes,
+        .block_cache_hits = s.block_cache_hits,
+        .block_cache_misses = s.block_cache_misses,
+        .pending_wal_bytes = s.pending_wal_bytes,
+        .uncompressed_bytes = s.uncompressed_bytes,
+        .compressed_bytes = s.compressed_bytes,
+        .compacting = @intFromBool(s.compacting),
+    };
+    return PGZ_OK;
+}
+
+// =============================================================================
+// Log Archiving and Recovery
+// =============================================================================
+
+/// Sets the function called with each full log segment to archive it, or
+/// stops archiving if f is null.
+export fn pgz_set_archive_fn(database: ?*DB, f: ?db_mod.ArchiveFn, arg: usize) void {
+    const d = database orelse return;
+    d.setArchiveFn(f, arg);
+}
+
+/// Replays the log segments in the directory segments onto the closed
+/// database at path, up to target_us microseconds since the Unix epoch, or
+/// to the end if target_us is negative. Fails with PGZ_E_UNSUPPORTED until
+/// the log can be replayed.
+/// Returns PGZ_OK on success, PGZ_ERR on failure.
+export fn pgz_recover(path: [*:0]const u8, segments: [*:0]const u8, target_us: i64) c_int {
+    const target: ?u64 = if (target_us < 0) null else @intCast(target_us);
+    db_mod.DB.recover(allocator, std.mem.span(path), std.mem.span(segments), target) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Re-encrypts the closed database at path from old_key to new_key. A key
+/// of length 0 is the clear; others must be 32 bytes.
+/// Returns PGZ_OK on success, PGZ_ERR on failure. Nothing is encrypted
+/// yet, so valid keys fail with PGZ_E_UNSUPPORTED as the last error code.
+export fn pgz_reencrypt(
+    path: [*:0]const u8,
+    old_key: ?[*]const u8,
+    old_len: usize,
+    new_key: ?[*]const u8,
+    new_len: usize,
+) c_int {
+    const old = encryptionKey(old_key, old_len) catch |err| return fail(err);
+    const new = encryptionKey(new_key, new_len) catch |err| return fail(err);
+    db_mod.DB.reencrypt(allocator, std.mem.span(path), old, new) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Returns the key of len bytes at key, or null if len is 0.
+fn encryptionKey(key: ?[*]const u8, len: usize) !?[db_mod.key_length]u8 {
+    if (len == 0) return null;
+    if (len != db_mod.key_length) return error.InvalidKeyLength;
+    const k = key orelse return error.InvalidKeyLength;
+    return k[0..db_mod.key_length].*;
+}
+
 // =============================================================================
 // Transaction Operations
 // =============================================================================
@@ -48,16 +359,22 @@ export fn pgz_close(database: ?*DB) void {
 /// Begins a new transaction.
 /// Returns null on error.
 export fn pgz_txn_begin(database: ?*DB) ?*Transaction {
-    const d = database orelse return null;
-    return d.txn_mgr.begin() catch null;
+    const d = database orelse {
+        _ = failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+        return null;
+    };
+    return d.txn_mgr.begin() catch |err| {
+        _ = fail(err);
+        return null;
+    };
 }
 
 /// Commits a transaction.
 /// Returns PGZ_OK on success, PGZ_ERR on failure.
 export fn pgz_txn_commit(database: ?*DB, txn: ?*Transaction) c_int {
-    const d = database orelse return PGZ_ERR;
-    const t = txn orelse return PGZ_ERR;
-    _ = d.txn_mgr.commit(t) catch return PGZ_ERR;
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const t = txn orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null transaction");
+    _ = d.txn_mgr.commit(t) catch |err| return fail(err);
     return PGZ_OK;
 }
 
@@ -72,117 +389,266 @@ export fn pgz_txn_abort(database: ?*DB, txn: ?*Transaction) void {
 // Key-Value Operations
 // =============================================================================
 
-/// Gets a value by key within a transaction.
+/// Gets a value by key within a transaction, reading the writes staged in
+/// txn before the database.
 /// On success, allocates memory for the value and sets out_val and out_len.
 /// Caller must free the returned memory with pgz_free().
 /// Returns: PGZ_OK (found), PGZ_NOT_FOUND, or PGZ_ERR.
 export fn pgz_get(
     database: ?*DB,
-    _: ?*Transaction, // txn - unused for now
+    txn: ?*Transaction,
     key: [*]const u8,
     key_len: usize,
     out_val: *?[*]u8,
     out_len: *usize,
 ) c_int {
-    const d = database orelse return PGZ_ERR;
-    if (key_len == 0) return PGZ_ERR;
+    out_val.* = null;
+    out_len.* = 0;
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const t = txn orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null transaction");
+    if (key_len == 0) return failMsg(PGZ_E_INVALID_ARGUMENT, "empty key");
 
     const key_slice = key[0..key_len];
 
     // Allocate buffer for result
     var buf: [64 * 1024]u8 = undefined; // 64KB max value for now
-    const result = d.get(key_slice, &buf) catch return PGZ_ERR;
+    const result = if (t.staged(key_slice)) |w|
+        w.value
+    else
+        d.get(key_slice, &buf) catch |err| return fail(err);
 
     if (result) |val| {
         // Allocate memory that Go can free
-        const out_buf = allocator.alloc(u8, val.len) catch return PGZ_ERR;
+        const out_buf = allocator.alloc(u8, val.len) catch |err| return fail(err);
         @memcpy(out_buf, val);
         out_val.* = out_buf.ptr;
         out_len.* = val.len;
         return PGZ_OK;
     }
 
-    out_val.* = null;
-    out_len.* = 0;
     return PGZ_NOT_FOUND;
 }
 
-/// Puts a key-value pair within a transaction.
+/// Puts a key-value pair within a transaction, staging it in txn for its
+/// commit to apply.
 /// Returns PGZ_OK on success, PGZ_ERR on failure.
 export fn pgz_put(
     database: ?*DB,
-    _: ?*Transaction, // txn - unused for now
+    txn: ?*Transaction,
     key: [*]const u8,
     key_len: usize,
     val: [*]const u8,
     val_len: usize,
 ) c_int {
-    const d = database orelse return PGZ_ERR;
-    if (key_len == 0) return PGZ_ERR;
-
-    const key_slice = key[0..key_len];
-    const val_slice = val[0..val_len];
+    _ = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const t = txn orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null transaction");
+    if (key_len == 0) return failMsg(PGZ_E_INVALID_ARGUMENT, "empty key");
 
-    d.put(key_slice, val_slice) catch return PGZ_ERR;
+    t.stagePut(key[0..key_len], val[0..val_len]) catch |err| return fail(err);
     return PGZ_OK;
 }
 
-/// Deletes a key within a transaction.
+/// Deletes a key within a transaction, staging the delete in txn for its
+/// commit to apply.
 /// Returns PGZ_OK on success, PGZ_ERR on failure.
 export fn pgz_delete(
     database: ?*DB,
-    _: ?*Transaction, // txn - unused for now
+    txn: ?*Transaction,
     key: [*]const u8,
     key_len: usize,
 ) c_int {
-    const d = database orelse return PGZ_ERR;
-    if (key_len == 0) return PGZ_ERR;
+    _ = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const t = txn orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null transaction");
+    if (key_len == 0) return failMsg(PGZ_E_INVALID_ARGUMENT, "empty key");
 
-    const key_slice = key[0..key_len];
-    d.delete(key_slice) catch return PGZ_ERR;
+    t.stageDelete(key[0..key_len]) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Deletes every key in the range [start_key, end_key) within a transaction.
+/// An empty end_key deletes to the end of the keyspace. Range deletes are
+/// not implemented yet, so it fails with PGZ_E_UNSUPPORTED, and callers
+/// delete the keys one by one.
+/// Returns PGZ_OK on success, PGZ_ERR on failure.
+export fn pgz_delete_range(
+    database: ?*DB,
+    _: ?*Transaction, // txn
+    start_key: ?[*]const u8,
+    start_len: usize,
+    end_key: ?[*]const u8,
+    end_len: usize,
+) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+
+    const start_slice: []const u8 = if (start_key) |k| k[0..start_len] else &.{};
+    const end_slice: ?[]const u8 = if (end_key) |k| (if (end_len > 0) k[0..end_len] else null) else null;
+
+    d.deleteRange(start_slice, end_slice) catch |err| return fail(err);
+    return PGZ_OK;
+}
+
+/// Stages a batch of writes in the write set of a transaction, in order,
+/// for its commit to apply. Each operation is an operation byte followed
+/// by the key and, for puts, the value, each prefixed by its length as a
+/// little-endian u32. A malformed batch stages nothing.
+/// Returns PGZ_OK on success, PGZ_ERR on failure or a malformed batch.
+export fn pgz_write_batch(
+    database: ?*DB,
+    txn: ?*Transaction,
+    batch: ?[*]const u8,
+    batch_len: usize,
+) c_int {
+    _ = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const t = txn orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null transaction");
+
+    const b: []const u8 = if (batch) |p| p[0..batch_len] else &.{};
+    if (checkBatch(b)) |msg| return failMsg(PGZ_E_INVALID_ARGUMENT, msg);
+    var rest = b;
+    while (rest.len > 0) {
+        const op = rest[0];
+        rest = rest[1..];
+        const key = takeBytes(&rest).?;
+        switch (op) {
+            PGZ_BATCH_PUT => t.stagePut(key, takeBytes(&rest).?) catch |err| return fail(err),
+            else => t.stageDelete(key) catch |err| return fail(err),
+        }
+    }
     return PGZ_OK;
 }
 
+/// Returns what is wrong with a write batch, or null if it is well formed.
+fn checkBatch(batch: []const u8) ?[]const u8 {
+    var rest = batch;
+    while (rest.len > 0) {
+        const op = rest[0];
+        rest = rest[1..];
+        const key = takeBytes(&rest) orelse return "malformed batch";
+        if (key.len == 0) return "empty key";
+        switch (op) {
+            PGZ_BATCH_PUT => {
+                _ = takeBytes(&rest) orelse return "malformed batch";
+            },
+            PGZ_BATCH_DELETE => {},
+            else => return "malformed batch",
+        }
+    }
+    return null;
+}
+
+/// Takes a length-prefixed byte string off the front of rest.
+fn takeBytes(rest: *[]const u8) ?[]const u8 {
+    if (rest.len < 4) return null;
+    const n = std.mem.readInt(u32, rest.*[0..4], .little);
+    if (rest.len - 4 < n) return null;
+    const out = rest.*[4 .. 4 + n];
+    rest.* = rest.*[4 + n ..];
+    return out;
+}
+
 // =============================================================================
 // Iterator Operations
 // =============================================================================
 
+/// An iterator over a key range. It holds no state yet, as pgz_scan
+/// creates none, and is exhausted once it has been.
 pub const Iterator = struct {
-    // TODO: implement actual iterator state
     started: bool = false,
     exhausted: bool = false,
 };
 
-/// Creates an iterator for scanning a key range.
+/// Creates an iterator for scanning a key range. Iteration is not
+/// implemented yet, so it fails with PGZ_E_UNSUPPORTED rather than
+/// returning an iterator that finds no keys.
 /// Returns null on error.
 export fn pgz_scan(
-    _: ?*DB, // database
+    database: ?*DB,
     _: ?*Transaction, // txn
     _: [*]const u8, // start_key
     _: usize, // start_len
     _: [*]const u8, // end_key
     _: usize, // end_len
 ) ?*Iterator {
-    const iter = allocator.create(Iterator) catch return null;
-    iter.* = .{};
-    return iter;
+    if (database == null) {
+        _ = failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+        return null;
+    }
+    _ = fail(error.Unsupported);
+    return null;
 }
 
 /// Advances the iterator and returns the next key-value pair.
 /// Returns PGZ_OK if a value was returned, PGZ_NOT_FOUND if exhausted, PGZ_ERR on error.
 export fn pgz_iter_next(
     iter: ?*Iterator,
-    _: *?[*]u8, // out_key
-    _: *usize, // out_key_len
-    _: *?[*]u8, // out_val
-    _: *usize, // out_val_len
+    out_key: *?[*]u8,
+    out_key_len: *usize,
+    out_val: *?[*]u8,
+    out_val_len: *usize,
 ) c_int {
-    const it = iter orelse return PGZ_ERR;
-    if (it.exhausted) return PGZ_NOT_FOUND;
+    const it = iter orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null iterator");
+    const pair = (iterNext(it) catch |err| return fail(err)) orelse return PGZ_NOT_FOUND;
 
-    // TODO: implement actual iteration
-    it.exhausted = true;
-    return PGZ_NOT_FOUND;
+    const key = allocator.dupe(u8, pair.key) catch |err| return fail(err);
+    const val = allocator.dupe(u8, pair.value) catch {
+        allocator.free(key);
+        return fail(error.OutOfMemory);
+    };
+    out_key.* = key.ptr;
+    out_key_len.* = key.len;
+    out_val.* = val.ptr;
+    out_val_len.* = val.len;
+    return PGZ_OK;
+}
+
+/// Advances the iterator by up to max_n pairs, packed into one response:
+/// each key then value, prefixed by its length as a little-endian u32. It
+/// stops early once the response holds max_bytes, if max_bytes is not 0.
+/// The caller frees the response with pgz_free().
+/// Returns PGZ_OK if pairs were returned, setting out_count to how many,
+/// PGZ_NOT_FOUND if exhausted, PGZ_ERR on error.
+export fn pgz_iter_next_n(
+    iter: ?*Iterator,
+    max_n: usize,
+    max_bytes: usize,
+    out: *?[*]u8,
+    out_len: *usize,
+    out_count: *usize,
+) c_int {
+    out.* = null;
+    out_len.* = 0;
+    out_count.* = 0;
+    const it = iter orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null iterator");
+    if (max_n == 0) return failMsg(PGZ_E_INVALID_ARGUMENT, "max_n is 0");
+
+    var resp: std.ArrayList(u8) = .empty;
+    defer resp.deinit(allocator);
+    var count: usize = 0;
+    while (count < max_n and (max_bytes == 0 or resp.items.len < max_bytes)) {
+        const pair = (iterNext(it) catch |err| return fail(err)) orelse break;
+        appendBytes(&resp, pair.key) catch |err| return fail(err);
+        appendBytes(&resp, pair.value) catch |err| return fail(err);
+        count += 1;
+    }
+    if (count == 0) return PGZ_NOT_FOUND;
+    const buf = resp.toOwnedSlice(allocator) catch |err| return fail(err);
+    out.* = buf.ptr;
+    out_len.* = buf.len;
+    out_count.* = count;
+    return PGZ_OK;
+}
+
+/// A key-value pair an iterator returns.
+const Pair = struct {
+    key: []const u8,
+    value: []const u8,
+};
+
+/// Returns the next pair of it, or null if it is exhausted. Iteration is
+/// not implemented yet, so an iterator that is not exhausted fails with
+/// error.Unsupported rather than returning no pairs.
+fn iterNext(it: *Iterator) error{ InputOutput, ChecksumMismatch, Unsupported }!?Pair {
+    if (it.exhausted) return null;
+    return error.Unsupported;
 }
 
 /// Closes an iterator and frees its resources.
@@ -192,11 +658,198 @@ export fn pgz_iter_close(iter: ?*Iterator) void {
     }
 }
 
+// =============================================================================
+// Command Buffers
+// =============================================================================
+
+pub const PGZ_CMD_GET: u8 = 0;
+pub const PGZ_CMD_PUT: u8 = 1;
+pub const PGZ_CMD_DELETE: u8 = 2;
+pub const PGZ_CMD_DELETE_RANGE: u8 = 3;
+pub const PGZ_CMD_ITER_NEXT: u8 = 4;
+
+/// Runs a buffer of commands within a transaction, in order, so that many
+/// storage operations cost one call. Each command is an operation byte
+/// followed by its arguments, byte strings prefixed by their length as a
+/// little-endian u32, or for PGZ_CMD_ITER_NEXT the iterator handle as a
+/// little-endian u64. The response holds a status byte per command,
+/// PGZ_OK or PGZ_NOT_FOUND, followed for gets by the value and for
+/// iterator steps by the key and value, length-prefixed in the same way.
+/// Puts and deletes are staged in txn, and gets read its staged writes
+/// before the database. Range deletes and iterator steps fail with
+/// PGZ_E_UNSUPPORTED until they are implemented.
+/// The caller frees the response with pgz_free().
+/// Returns PGZ_OK if every command ran, PGZ_ERR at the first that failed or
+/// on a malformed buffer, with no response.
+export fn pgz_execute_batch(
+    database: ?*DB,
+    txn: ?*Transaction,
+    cmds: ?[*]const u8,
+    cmds_len: usize,
+    out: *?[*]u8,
+    out_len: *usize,
+) c_int {
+    out.* = null;
+    out_len.* = 0;
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const t = txn orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null transaction");
+
+    var resp: std.ArrayList(u8) = .empty;
+    defer resp.deinit(allocator);
+    var rest: []const u8 = if (cmds) |c| c[0..cmds_len] else &.{};
+    while (rest.len > 0) {
+        const op = rest[0];
+        rest = rest[1..];
+        executeCommand(d, t, op, &rest, &resp) catch |err| return fail(err);
+    }
+    if (resp.items.len == 0) return PGZ_OK;
+    const buf = resp.toOwnedSlice(allocator) catch |err| return fail(err);
+    out.* = buf.ptr;
+    out_len.* = buf.len;
+    return PGZ_OK;
+}
+
+/// Runs the command op in t, taking its arguments off the front of rest and
+/// appending its status and results to resp.
+fn executeCommand(d: *DB, t: *Transaction, op: u8, rest: *[]const u8, resp: *std.ArrayList(u8)) !void {
+    switch (op) {
+        PGZ_CMD_GET => {
+            const key = takeBytes(rest) orelse return error.InvalidArgument;
+            if (key.len == 0) return error.InvalidArgument;
+            if (t.staged(key)) |w| {
+                if (w.value) |val| {
+                    try resp.append(allocator, @intCast(PGZ_OK));
+                    try appendBytes(resp, val);
+                } else {
+                    try resp.append(allocator, @intCast(PGZ_NOT_FOUND));
+                }
+                return;
+            }
+            var buf: [64 * 1024]u8 = undefined; // 64KB max value for now
+            if (try d.get(key, &buf)) |val| {
+                try resp.append(allocator, @intCast(PGZ_OK));
+                try appendBytes(resp, val);
+            } else {
+                try resp.append(allocator, @intCast(PGZ_NOT_FOUND));
+            }
+        },
+        PGZ_CMD_PUT => {
+            const key = takeBytes(rest) orelse return error.InvalidArgument;
+            const val = takeBytes(rest) orelse return error.InvalidArgument;
+            if (key.len == 0) return error.InvalidArgument;
+            try t.stagePut(key, val);
+            try resp.append(allocator, @intCast(PGZ_OK));
+        },
+        PGZ_CMD_DELETE => {
+            const key = takeBytes(rest) orelse return error.InvalidArgument;
+            if (key.len == 0) return error.InvalidArgument;
+            try t.stageDelete(key);
+            try resp.append(allocator, @intCast(PGZ_OK));
+        },
+        PGZ_CMD_DELETE_RANGE => {
+            const start = takeBytes(rest) orelse return error.InvalidArgument;
+            const end = takeBytes(rest) orelse return error.InvalidArgument;
+            try d.deleteRange(start, if (end.len > 0) end else null);
+            try resp.append(allocator, @intCast(PGZ_OK));
+        },
+        PGZ_CMD_ITER_NEXT => {
+            if (rest.len < 8) return error.InvalidArgument;
+            const handle = std.mem.readInt(u64, rest.*[0..8], .little);
+            rest.* = rest.*[8..];
+            if (handle == 0) return error.InvalidArgument;
+            const it: *Iterator = @ptrFromInt(@as(usize, @intCast(handle)));
+            if (try iterNext(it)) |pair| {
+                try resp.append(allocator, @intCast(PGZ_OK));
+                try appendBytes(resp, pair.key);
+                try appendBytes(resp, pair.value);
+            } else {
+                try resp.append(allocator, @intCast(PGZ_NOT_FOUND));
+            }
+        },
+        else => return error.InvalidArgument,
+    }
+}
+
+/// Appends p to resp prefixed by its length as a little-endian u32.
+fn appendBytes(resp: *std.ArrayList(u8), p: []const u8) !void {
+    var len: [4]u8 = undefined;
+    std.mem.writeInt(u32, &len, @intCast(p.len), .little);
+    try resp.appendSlice(allocator, &len);
+    try resp.appendSlice(allocator, p);
+}
+
+// =============================================================================
+// Change Export
+// =============================================================================
+
+pub const PGZ_CHANGE_PUT: c_int = 0;
+pub const PGZ_CHANGE_DELETE: c_int = 1;
+pub const PGZ_CHANGE_DELETE_RANGE: c_int = 2;
+
+/// Creates an iterator over the changes committed after since, setting
+/// through to the sequence number of the last committed transaction.
+/// Returns PGZ_OK, PGZ_NOT_FOUND if the changes are no longer kept, or
+/// PGZ_ERR, with PGZ_E_UNSUPPORTED until commits record their changes.
+export fn pgz_changes(
+    database: ?*DB,
+    since: u64,
+    through: *u64,
+    out_iter: *?*db_mod.ChangeReader,
+) c_int {
+    const d = database orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    const reader = d.changes(since) catch |err| switch (err) {
+        error.ChangesCompacted => return PGZ_NOT_FOUND,
+        error.Unsupported => return fail(err),
+    };
+    const it = allocator.create(db_mod.ChangeReader) catch |err| return fail(err);
+    it.* = reader;
+    through.* = reader.through;
+    out_iter.* = it;
+    return PGZ_OK;
+}
+
+/// Returns the next change in commit order. The caller frees its key and
+/// value with pgz_free().
+/// Returns PGZ_OK if a change was returned, PGZ_NOT_FOUND if exhausted, PGZ_ERR on error.
+export fn pgz_change_next(
+    iter: ?*db_mod.ChangeReader,
+    out_kind: *c_int,
+    out_seq: *u64,
+    out_key: *?[*]u8,
+    out_key_len: *usize,
+    out_val: *?[*]u8,
+    out_val_len: *usize,
+) c_int {
+    const it = iter orelse return failMsg(PGZ_E_INVALID_ARGUMENT, "null iterator");
+    const change = (it.next() catch |err| return fail(err)) orelse return PGZ_NOT_FOUND;
+
+    const key = allocator.dupe(u8, change.key) catch |err| return fail(err);
+    const val = allocator.dupe(u8, change.value) catch {
+        allocator.free(key);
+        return fail(error.OutOfMemory);
+    };
+    out_kind.* = @intFromEnum(change.kind);
+    out_seq.* = change.seq;
+    out_key.* = key.ptr;
+    out_key_len.* = key.len;
+    out_val.* = val.ptr;
+    out_val_len.* = val.len;
+    return PGZ_OK;
+}
+
+/// Closes a change iterator and frees its resources.
+export fn pgz_changes_close(iter: ?*db_mod.ChangeReader) void {
+    if (iter) |it| {
+        allocator.destroy(it);
+    }
+}
+
 // =============================================================================
 // Memory Management
 // =============================================================================
 
-/// Frees memory allocated by pgz_get or pgz_iter_next.
+/// Frees memory allocated by pgz_get, pgz_iter_next, pgz_iter_next_n,
+/// pgz_execute_batch or pgz_change_next.
 export fn pgz_free(ptr: ?[*]u8, len: usize) void {
     if (ptr) |p| {
         if (len > 0) {
@@ -213,3 +866,229 @@ export fn pgz_free(ptr: ?[*]u8, len: usize) void {
 export fn pgz_version() [*:0]const u8 {
     return "0.1.0";
 }
+
+// =============================================================================
+// Tests
+// =============================================================================
+
+test "pgz_last_error reports the last failure" {
+    _ = failMsg(PGZ_E_INVALID_ARGUMENT, "null database");
+    var buf: [64]u8 = undefined;
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(&buf, buf.len, &n));
+    try std.testing.expectEqualStrings("null database", buf[0..n]);
+
+    // A buffer too short gets the message truncated, and its full length.
+    var short: [4]u8 = undefined;
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(&short, short.len, &n));
+    try std.testing.expectEqual(@as(usize, 13), n);
+    try std.testing.expectEqualStrings("null", &short);
+}
+
+test "errors map to their codes" {
+    try std.testing.expectEqual(PGZ_E_CONFLICT, errorCode(error.WriteConflict));
+    try std.testing.expectEqual(PGZ_E_CORRUPTION, errorCode(error.ChecksumMismatch));
+    try std.testing.expectEqual(PGZ_E_INTERNAL, errorCode(error.SomethingElse));
+}
+
+test "length-prefixed byte strings round-trip" {
+    var resp: std.ArrayList(u8) = .empty;
+    defer resp.deinit(allocator);
+    try appendBytes(&resp, "key");
+    try appendBytes(&resp, "");
+    var rest: []const u8 = resp.items;
+    try std.testing.expectEqualStrings("key", takeBytes(&rest).?);
+    try std.testing.expectEqualStrings("", takeBytes(&rest).?);
+    try std.testing.expectEqual(@as(usize, 0), rest.len);
+    try std.testing.expect(takeBytes(&rest) == null);
+
+    // A length past the end of the buffer is malformed.
+    var truncated: []const u8 = resp.items[0..5];
+    try std.testing.expect(takeBytes(&truncated) == null);
+}
+
+test "encryption keys are 32 bytes or none" {
+    try std.testing.expect((try encryptionKey(null, 0)) == null);
+    const key = [_]u8{7} ** db_mod.key_length;
+    const k = (try encryptionKey(&key, key.len)).?;
+    try std.testing.expectEqualSlices(u8, &key, &k);
+    try std.testing.expectError(error.InvalidKeyLength, encryptionKey(&key, 16));
+}
+
+test "pgz_open_encrypted fails as unsupported" {
+    const key = [_]u8{7} ** db_mod.key_length;
+    var n: usize = 0;
+    try std.testing.expect(pgz_open_encrypted("db", &key, key.len, 0) == null);
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+    try std.testing.expect(pgz_open_encrypted("db", &key, key.len, 1) == null);
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+    try std.testing.expect(pgz_open_encrypted("db", &key, 16, 0) == null);
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+}
+
+test "pgz_reencrypt fails as unsupported" {
+    const key = [_]u8{7} ** db_mod.key_length;
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_ERR, pgz_reencrypt("db", null, 0, &key, key.len));
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+    try std.testing.expectEqual(PGZ_ERR, pgz_reencrypt("db", &key, key.len, &key, 16));
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+}
+
+test "pgz_delete_range fails as unsupported" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    try std.testing.expectEqual(PGZ_ERR, pgz_delete_range(d, null, "a", 1, "b", 1));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+}
+
+test "pgz_compact_range fails as unsupported" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    try std.testing.expectEqual(PGZ_ERR, pgz_compact_range(d, null, 0, null, 0));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+}
+
+test "pgz_set_compression fails as unsupported but for none" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_ERR, pgz_set_compression(d, null, 0, 2, 1024));
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+    try std.testing.expectEqual(PGZ_ERR, pgz_set_compression(d, null, 0, 0, 1024));
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+    try std.testing.expectEqual(PGZ_OK, pgz_set_compression(d, null, 0, 0, 0));
+    try std.testing.expectEqual(PGZ_OK, pgz_set_compression(d, "t", 1, -1, 0));
+}
+
+test "pgz_stats fails as unsupported" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    var s: DBStats = undefined;
+    try std.testing.expectEqual(PGZ_ERR, pgz_stats(d, &s));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+}
+
+test "pgz_write_batch stages the batch in the transaction" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    var t = Transaction.init(allocator, 1, 0);
+    defer t.deinit();
+
+    var batch: std.ArrayList(u8) = .empty;
+    defer batch.deinit(allocator);
+    try batch.append(allocator, PGZ_BATCH_PUT);
+    try appendBytes(&batch, "a");
+    try appendBytes(&batch, "1");
+    try batch.append(allocator, PGZ_BATCH_DELETE);
+    try appendBytes(&batch, "b");
+    try std.testing.expectEqual(PGZ_OK, pgz_write_batch(d, &t, batch.items.ptr, batch.items.len));
+    try std.testing.expectEqual(@as(usize, 2), t.writes.items.len);
+    try std.testing.expectEqualStrings("1", t.writes.items[0].value.?);
+    try std.testing.expect(t.writes.items[1].value == null);
+
+    // A malformed batch stages none of its writes.
+    try batch.append(allocator, PGZ_BATCH_PUT);
+    try appendBytes(&batch, "c");
+    try std.testing.expectEqual(PGZ_ERR, pgz_write_batch(d, &t, batch.items.ptr, batch.items.len));
+    try std.testing.expectEqual(@as(usize, 2), t.writes.items.len);
+
+    // A batch needs a transaction.
+    try std.testing.expectEqual(PGZ_ERR, pgz_write_batch(d, null, batch.items.ptr, batch.items.len));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+}
+
+test "point reads see the writes staged in the transaction" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    var t = Transaction.init(allocator, 1, 0);
+    defer t.deinit();
+
+    try std.testing.expectEqual(PGZ_OK, pgz_put(d, &t, "a", 1, "1", 1));
+    try std.testing.expectEqual(PGZ_OK, pgz_put(d, &t, "b", 1, "2", 1));
+    try std.testing.expectEqual(PGZ_OK, pgz_delete(d, &t, "b", 1));
+    try std.testing.expectEqual(@as(usize, 3), t.writes.items.len);
+
+    var val: ?[*]u8 = null;
+    var len: usize = 0;
+    try std.testing.expectEqual(PGZ_OK, pgz_get(d, &t, "a", 1, &val, &len));
+    try std.testing.expectEqualStrings("1", val.?[0..len]);
+    pgz_free(val, len);
+    try std.testing.expectEqual(PGZ_NOT_FOUND, pgz_get(d, &t, "b", 1, &val, &len));
+
+    // Reads and writes need a transaction.
+    try std.testing.expectEqual(PGZ_ERR, pgz_put(d, null, "a", 1, "1", 1));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+    try std.testing.expectEqual(PGZ_ERR, pgz_get(d, null, "a", 1, &val, &len));
+}
+
+test "pgz_execute_batch runs in the transaction" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    var t = Transaction.init(allocator, 1, 0);
+    defer t.deinit();
+
+    var cmds: std.ArrayList(u8) = .empty;
+    defer cmds.deinit(allocator);
+    try cmds.append(allocator, PGZ_CMD_PUT);
+    try appendBytes(&cmds, "a");
+    try appendBytes(&cmds, "1");
+    try cmds.append(allocator, PGZ_CMD_DELETE);
+    try appendBytes(&cmds, "b");
+    try cmds.append(allocator, PGZ_CMD_GET);
+    try appendBytes(&cmds, "a");
+    try cmds.append(allocator, PGZ_CMD_GET);
+    try appendBytes(&cmds, "b");
+    var out: ?[*]u8 = null;
+    var out_len: usize = 0;
+    try std.testing.expectEqual(PGZ_OK, pgz_execute_batch(d, &t, cmds.items.ptr, cmds.items.len, &out, &out_len));
+    defer pgz_free(out, out_len);
+
+    // The writes are staged, and the gets read them.
+    try std.testing.expectEqual(@as(usize, 2), t.writes.items.len);
+    try std.testing.expectEqualStrings("1", t.writes.items[0].value.?);
+    try std.testing.expect(t.writes.items[1].value == null);
+    var resp: []const u8 = out.?[0..out_len];
+    try std.testing.expectEqual(@as(u8, PGZ_OK), resp[0]);
+    try std.testing.expectEqual(@as(u8, PGZ_OK), resp[1]);
+    try std.testing.expectEqual(@as(u8, PGZ_OK), resp[2]);
+    resp = resp[3..];
+    try std.testing.expectEqualStrings("1", takeBytes(&resp).?);
+    try std.testing.expectEqual(@as(u8, PGZ_NOT_FOUND), resp[0]);
+    try std.testing.expectEqual(@as(usize, 1), resp.len);
+
+    // A batch needs a transaction.
+    try std.testing.expectEqual(PGZ_ERR, pgz_execute_batch(d, null, cmds.items.ptr, cmds.items.len, &out, &out_len));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+}
+
+test "pgz_scan fails as unsupported" {
+    const d = pgz_open_in_memory().?;
+    defer pgz_close(d);
+    try std.testing.expect(pgz_scan(d, null, "a", 1, "b", 1) == null);
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+}
+
+test "iterator steps fail as unsupported" {
+    var it: Iterator = .{};
+    var key: ?[*]u8 = null;
+    var key_len: usize = 0;
+    var val: ?[*]u8 = null;
+    var val_len: usize = 0;
+    try std.testing.expectEqual(PGZ_ERR, pgz_iter_next(&it, &key, &key_len, &val, &val_len));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_UNSUPPORTED, pgz_last_error(null, 0, &n));
+}
+
+test "calls on a null database fail" {
+    try std.testing.expectEqual(PGZ_ERR, pgz_sync(null));
+    var n: usize = 0;
+    try std.testing.expectEqual(PGZ_E_INVALID_ARGUMENT, pgz_last_error(null, 0, &n));
+}
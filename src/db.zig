@@ -11,6 +11,120 @@ pub const Options = struct {
     create_if_missing: bool = true,
     error_if_exists: bool = false,
     sync_writes: bool = false,
+    /// Replay the log in memory only, and fail writes.
+    read_only: bool = false,
+    /// Keep the log, sstables and manifest in memory, writing nothing to
+    /// disk. The path is ignored, and the data is lost on close.
+    in_memory: bool = false,
+    /// The AES-256 key the log, sstables and manifest are to be encrypted
+    /// with, block by block with AES-GCM, or null for a database stored in
+    /// the clear. Nothing is encrypted yet, so opening with a key fails
+    /// with error.Unsupported rather than store the data in the clear.
+    encryption_key: ?[key_length]u8 = null,
+};
+
+/// The length of an encryption key, in bytes.
+pub const key_length = 32;
+
+/// An algorithm the values of sstable blocks are compressed with.
+pub const Compression = enum(u8) {
+    none = 0,
+    snappy = 1,
+    zstd = 2,
+};
+
+/// The largest zstd dictionary a compression rule may train, in bytes.
+pub const max_dictionary_bytes = 1 << 20;
+
+/// Archives the full, synced log segment at path, returning 0 once it is
+/// archived. The segment is kept, and offered again later, until it is.
+pub const ArchiveFn = *const fn (arg: usize, path: [*:0]const u8, segment: u64) callconv(.c) c_int;
+
+/// The space a key range takes.
+pub const Space = struct {
+    /// The size of the keys and values of the latest committed versions.
+    live_bytes: u64 = 0,
+    /// The size of the versions overwritten or deleted, and of their
+    /// tombstones, which compaction reclaims.
+    dead_bytes: u64 = 0,
+};
+
+/// What a checksum verification found.
+pub const Verification = struct {
+    /// The sstable blocks and value log records read.
+    checked: u64 = 0,
+    /// Those of them whose checksum did not match.
+    corrupt: u64 = 0,
+};
+
+/// Statistics of the database as a whole.
+pub const Stats = struct {
+    /// The keys whose latest committed version is not a tombstone.
+    key_count: u64 = 0,
+    /// The size of the sstables and value log segments on disk.
+    data_bytes: u64 = 0,
+    /// The live and dead bytes of the whole keyspace, as Space counts them.
+    live_bytes: u64 = 0,
+    dead_bytes: u64 = 0,
+    /// Whether a compaction is running.
+    compacting: bool = false,
+    /// The size of the sstables the levels over their target size hold,
+    /// which compaction is due to rewrite.
+    compaction_pending_bytes: u64 = 0,
+    /// The reads of sstable blocks the block cache served, and those it
+    /// did not, since the database was opened.
+    block_cache_hits: u64 = 0,
+    block_cache_misses: u64 = 0,
+    /// The size of the log written but not yet synced.
+    pending_wal_bytes: u64 = 0,
+    /// The size of the sstable blocks before compression, and after, as
+    /// they are on disk.
+    uncompressed_bytes: u64 = 0,
+    compressed_bytes: u64 = 0,
+};
+
+/// Reports a block or record whose checksum did not match: the file it is
+/// in, relative to the database directory, its offset there, and its first
+/// and last keys, as far as the index of its sstable or its header tells
+/// them, or empty. Returns 0 to go on verifying.
+pub const CorruptFn = *const fn (
+    arg: usize,
+    file: [*:0]const u8,
+    offset: u64,
+    start_key: [*]const u8,
+    start_len: usize,
+    end_key: [*]const u8,
+    end_len: usize,
+) callconv(.c) c_int;
+
+/// A change a committed transaction made.
+pub const Change = struct {
+    kind: Kind,
+    /// The commit timestamp of the transaction.
+    seq: types.Timestamp,
+    /// The key written or deleted, or the start of the range deleted.
+    key: []const u8,
+    /// The value of a put, or the end of the range deleted, empty for the
+    /// end of the keyspace. Empty for a delete.
+    value: []const u8,
+
+    pub const Kind = enum(c_int) { put = 0, delete = 1, delete_range = 2 };
+};
+
+/// Reads the changes of the transactions committed after since, up to
+/// through, from the value log, in commit order. DB.changes does not return
+/// one until the value log keeps the changes.
+pub const ChangeReader = struct {
+    db: *DB,
+    since: types.Timestamp,
+    through: types.Timestamp,
+
+    /// Returns the next change, or null at the end. Its key and value are
+    /// valid until the next call.
+    pub fn next(self: *ChangeReader) !?Change {
+        _ = self;
+        return null;
+    }
 };
 
 pub const DB = struct {
@@ -22,8 +136,11 @@ pub const DB = struct {
     tree: lsm.Tree,
     txn_mgr: txn_mod.Manager,
     manifest_mgr: manifest.Manager,
+    archive_fn: ?ArchiveFn = null,
+    archive_arg: usize = 0,
 
     pub fn open(allocator: std.mem.Allocator, path: []const u8, options: Options) !*DB {
+        if (options.encryption_key != null) return error.Unsupported;
         const db = try allocator.create(DB);
         db.* = .{
             .allocator = allocator,
@@ -61,6 +178,110 @@ pub const DB = struct {
         _ = key;
     }
 
+    /// Deletes every key in [start, end). A null end deletes to the end of
+    /// the keyspace. Range tombstones are not implemented yet, so it fails
+    /// with error.Unsupported rather than deleting nothing.
+    pub fn deleteRange(self: *DB, start: []const u8, end: ?[]const u8) error{Unsupported}!void {
+        _ = self;
+        _ = start;
+        _ = end;
+        return error.Unsupported;
+    }
+
+    /// Returns the space the keys in [start, end) take, from the sizes the
+    /// manifest records of the sstables overlapping the range and the
+    /// garbage accounted to the value log segments. A null end is the end
+    /// of the keyspace. Neither is recorded yet, so it fails with
+    /// error.Unsupported rather than report an empty range.
+    pub fn space(self: *DB, start: []const u8, end: ?[]const u8) error{Unsupported}!Space {
+        _ = self;
+        _ = start;
+        _ = end;
+        return error.Unsupported;
+    }
+
+    /// Compacts the sstables overlapping [start, end) down into the last
+    /// level, dropping the versions no open snapshot reads and the
+    /// tombstones that shadow nothing below them, then collects the value
+    /// log segments whose garbage that frees. Background compaction is
+    /// paused while it runs. A null end is the end of the keyspace. There
+    /// is no compactor yet, so it fails with error.Unsupported rather than
+    /// return as if the space were reclaimed.
+    pub fn compactRange(self: *DB, start: []const u8, end: ?[]const u8) error{Unsupported}!void {
+        _ = self;
+        _ = start;
+        _ = end;
+        return error.Unsupported;
+    }
+
+    /// Sets how the blocks of the keys starting with prefix are compressed:
+    /// with algorithm, and for zstd, a dictionary of up to dictionary_bytes
+    /// trained on the blocks of the prefix as they are first compacted, or
+    /// none if 0. A null algorithm removes the rule of prefix. Blocks take
+    /// the rule of the longest prefix matching their first key, and an
+    /// empty prefix is the default of the database. The rules are kept in
+    /// the manifest and apply to the blocks flushes and compactions write
+    /// from then on; existing blocks keep theirs until rewritten. Blocks
+    /// are not compressed yet, so a rule of snappy or zstd fails with
+    /// error.Unsupported once validated; one of none, or removing one, is
+    /// what the blocks already are.
+    pub fn setCompression(
+        self: *DB,
+        prefix: []const u8,
+        algorithm: ?Compression,
+        dictionary_bytes: u32,
+    ) error{ InvalidArgument, ReadOnly, Unsupported }!void {
+        if (dictionary_bytes > max_dictionary_bytes) return error.InvalidArgument;
+        if (dictionary_bytes > 0 and algorithm != .zstd) return error.InvalidArgument;
+        _ = self;
+        _ = prefix;
+        const a = algorithm orelse return;
+        if (a != .none) return error.Unsupported;
+    }
+
+    /// Reads every block of the sstables the manifest lists, and every
+    /// record of the value log, and checks each against its checksum,
+    /// calling on_corrupt with those that do not match. It stops with
+    /// error.Canceled once cancel is set, which is checked between blocks,
+    /// or on_corrupt returns nonzero. No sstables or log segments are
+    /// written to disk yet, so there is nothing to read: it checks nothing,
+    /// and callers take a checked count of zero as a failed verification.
+    pub fn verifyChecksums(
+        self: *DB,
+        cancel: ?*const c_int,
+        on_corrupt: ?CorruptFn,
+        arg: usize,
+    ) error{Canceled}!Verification {
+        _ = self;
+        if (cancel) |c| {
+            if (@atomicLoad(c_int, c, .monotonic) != 0) return error.Canceled;
+        }
+        _ = on_corrupt;
+        _ = arg;
+        return .{};
+    }
+
+    /// Returns the statistics of the database, from the manifest, the
+    /// counters of the block cache and compactor, and the log writer. None
+    /// of them keeps those counts yet, so it fails with error.Unsupported
+    /// rather than report an empty database.
+    pub fn stats(self: *DB) error{Unsupported}!Stats {
+        _ = self;
+        return error.Unsupported;
+    }
+
+    /// Returns a reader of the changes committed after since, up to the
+    /// last committed transaction. The value log keeps them until the
+    /// garbage collection of its segments, after which they are
+    /// error.ChangesCompacted. Commits do not record their changes in the
+    /// value log yet, so it fails with error.Unsupported rather than
+    /// return a reader that never yields.
+    pub fn changes(self: *DB, since: types.Timestamp) error{ ChangesCompacted, Unsupported }!ChangeReader {
+        _ = self;
+        _ = since;
+        return error.Unsupported;
+    }
+
     pub fn flush(self: *DB) !void {
         _ = self;
     }
@@ -68,4 +289,135 @@ pub const DB = struct {
     pub fn sync(self: *DB) !void {
         _ = self;
     }
+
+    /// Sets the function each log segment is handed to once it is full and
+    /// synced, before it may be recycled, or stops archiving if f is null.
+    pub fn setArchiveFn(self: *DB, f: ?ArchiveFn, arg: usize) void {
+        self.archive_fn = f;
+        self.archive_arg = arg;
+    }
+
+    /// Replays the log segments in the directory segments, in order, onto
+    /// the database at path, stopping before the first transaction
+    /// committed after target, or at the end of the last segment if target
+    /// is null. The log cannot be replayed yet, so it fails with
+    /// error.Unsupported rather than report a recovery that did nothing.
+    pub fn recover(allocator: std.mem.Allocator, path: []const u8, segments: []const u8, target: ?types.Timestamp) !void {
+        _ = allocator;
+        _ = path;
+        _ = segments;
+        _ = target;
+        return error.Unsupported;
+    }
+
+    /// Rewrites every file of the closed database at path, encrypted with
+    /// new_key instead of old_key; a null key is the clear. Each file is
+    /// to be written beside the old one and renamed over it, and the
+    /// manifest last, so that a crash leaves the database readable with
+    /// one key or the other. Nothing is encrypted yet, so it fails with
+    /// error.Unsupported rather than report a rotation that rewrote
+    /// nothing.
+    pub fn reencrypt(
+        allocator: std.mem.Allocator,
+        path: []const u8,
+        old_key: ?[key_length]u8,
+        new_key: ?[key_length]u8,
+    ) error{Unsupported}!void {
+        _ = allocator;
+        _ = path;
+        _ = old_key;
+        _ = new_key;
+        return error.Unsupported;
+    }
+
+    /// Copies a consistent snapshot of the committed data into dir, which
+    /// is created and must not exist, while transactions go on. Flushing
+    /// first leaves the snapshot in the immutable sstables and the value
+    /// log up to its current end, which are what is to be copied. There are
+    /// no sstables or log segments on disk to copy yet, so it fails with
+    /// error.Unsupported before creating dir, rather than leave an empty
+    /// directory that passes for a backup.
+    pub fn backup(self: *DB, dir: []const u8) !void {
+        _ = self;
+        _ = dir;
+        return error.Unsupported;
+    }
 };
+
+// =============================================================================
+// Tests
+// =============================================================================
+
+test "open and close an in-memory database" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    db.close();
+}
+
+test "backups are unsupported and create no directory" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    var tmp = std.testing.tmpDir(.{});
+    defer tmp.cleanup();
+    const dir = try tmp.dir.realpathAlloc(std.testing.allocator, ".");
+    defer std.testing.allocator.free(dir);
+    const backup_dir = try std.fs.path.join(std.testing.allocator, &.{ dir, "backup" });
+    defer std.testing.allocator.free(backup_dir);
+
+    try std.testing.expectError(error.Unsupported, db.backup(backup_dir));
+    try std.testing.expectError(error.FileNotFound, tmp.dir.access("backup", .{}));
+}
+
+test "point-in-time recovery is unsupported" {
+    try std.testing.expectError(error.Unsupported, DB.recover(std.testing.allocator, "db", "segments", null));
+    try std.testing.expectError(error.Unsupported, DB.recover(std.testing.allocator, "db", "segments", 1));
+}
+
+test "space accounting is unsupported" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    try std.testing.expectError(error.Unsupported, db.space("", null));
+}
+
+test "statistics are unsupported" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    try std.testing.expectError(error.Unsupported, db.stats());
+}
+
+test "compaction is unsupported" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    try std.testing.expectError(error.Unsupported, db.compactRange("a", "b"));
+    try std.testing.expectError(error.Unsupported, db.compactRange("", null));
+}
+
+test "encryption is unsupported" {
+    const key = [_]u8{7} ** key_length;
+    try std.testing.expectError(error.Unsupported, DB.open(std.testing.allocator, "", .{ .in_memory = true, .encryption_key = key }));
+    try std.testing.expectError(error.Unsupported, DB.reencrypt(std.testing.allocator, "db", null, key));
+    try std.testing.expectError(error.Unsupported, DB.reencrypt(std.testing.allocator, "db", key, null));
+}
+
+test "compression is validated, then unsupported" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    try std.testing.expectError(error.InvalidArgument, db.setCompression("t", .snappy, 1024));
+    try std.testing.expectError(error.InvalidArgument, db.setCompression("t", .zstd, max_dictionary_bytes + 1));
+    try std.testing.expectError(error.Unsupported, db.setCompression("t", .snappy, 0));
+    try std.testing.expectError(error.Unsupported, db.setCompression("", .zstd, 1024));
+    try db.setCompression("", .none, 0);
+    try db.setCompression("t", null, 0);
+}
+
+test "change feeds are unsupported" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    try std.testing.expectError(error.Unsupported, db.changes(0));
+}
+
+test "range deletes are unsupported" {
+    const db = try DB.open(std.testing.allocator, "", .{ .in_memory = true });
+    defer db.close();
+    try std.testing.expectError(error.Unsupported, db.deleteRange("a", "b"));
+    try std.testing.expectError(error.Unsupported, db.deleteRange("", null));
+}
@@ -16,4 +16,5 @@ pub const Options = db.Options;
 test {
     _ = types;
     _ = crc32c;
+    _ = db;
 }
@@ -6,17 +6,62 @@ const types = @import("types.zig");
 pub const Status = enum { active, committed, aborted };
 
 pub const Transaction = struct {
+    allocator: std.mem.Allocator,
     id: types.TransactionId,
     read_ts: types.Timestamp,
     status: Status = .active,
+    /// The puts and deletes staged in the transaction, in order, which its
+    /// commit applies. Their keys and values are owned copies.
+    writes: std.ArrayList(Write) = .empty,
+
+    /// A staged put, or a delete if value is null.
+    pub const Write = struct {
+        key: []u8,
+        value: ?[]u8,
+    };
 
     pub fn init(allocator: std.mem.Allocator, id: types.TransactionId, read_ts: types.Timestamp) Transaction {
-        _ = allocator;
-        return .{ .id = id, .read_ts = read_ts };
+        return .{ .allocator = allocator, .id = id, .read_ts = read_ts };
     }
     pub fn deinit(self: *Transaction) void {
-        _ = self;
+        for (self.writes.items) |w| {
+            self.allocator.free(w.key);
+            if (w.value) |v| self.allocator.free(v);
+        }
+        self.writes.deinit(self.allocator);
+    }
+
+    /// Stages a put of key to a copy of value.
+    pub fn stagePut(self: *Transaction, key: []const u8, value: []const u8) !void {
+        try self.stage(key, value);
+    }
+
+    /// Stages a delete of key.
+    pub fn stageDelete(self: *Transaction, key: []const u8) !void {
+        try self.stage(key, null);
+    }
+
+    /// Returns the last write staged to key, or null if none is, so the
+    /// transaction reads its own writes.
+    pub fn staged(self: *const Transaction, key: []const u8) ?Write {
+        var i = self.writes.items.len;
+        while (i > 0) {
+            i -= 1;
+            const w = self.writes.items[i];
+            if (std.mem.eql(u8, w.key, key)) return w;
+        }
+        return null;
+    }
+
+    fn stage(self: *Transaction, key: []const u8, value: ?[]const u8) !void {
+        if (self.status != .active) return error.InvalidArgument;
+        const k = try self.allocator.dupe(u8, key);
+        errdefer self.allocator.free(k);
+        const v = if (value) |v| try self.allocator.dupe(u8, v) else null;
+        errdefer if (v) |b| self.allocator.free(b);
+        try self.writes.append(self.allocator, .{ .key = k, .value = v });
     }
+
     pub fn recordWrite(self: *Transaction, key: []const u8, vptr: types.ValuePointer) !void {
         _ = self;
         _ = key;
@@ -50,3 +95,40 @@ pub const Manager = struct {
         _ = txn;
     }
 };
+
+// =============================================================================
+// Tests
+// =============================================================================
+
+test "writes are staged in order" {
+    var t = Transaction.init(std.testing.allocator, 1, 0);
+    defer t.deinit();
+    try t.stagePut("a", "1");
+    try t.stageDelete("b");
+    try t.stagePut("a", "2");
+    try std.testing.expectEqual(@as(usize, 3), t.writes.items.len);
+    try std.testing.expectEqualStrings("a", t.writes.items[0].key);
+    try std.testing.expectEqualStrings("1", t.writes.items[0].value.?);
+    try std.testing.expectEqualStrings("b", t.writes.items[1].key);
+    try std.testing.expect(t.writes.items[1].value == null);
+    try std.testing.expectEqualStrings("2", t.writes.items[2].value.?);
+}
+
+test "the last staged write to a key is read" {
+    var t = Transaction.init(std.testing.allocator, 1, 0);
+    defer t.deinit();
+    try t.stagePut("a", "1");
+    try t.stageDelete("b");
+    try t.stagePut("a", "2");
+    try std.testing.expectEqualStrings("2", t.staged("a").?.value.?);
+    try std.testing.expect(t.staged("b").?.value == null);
+    try std.testing.expect(t.staged("c") == null);
+}
+
+test "a finished transaction stages nothing" {
+    var t = Transaction.init(std.testing.allocator, 1, 0);
+    defer t.deinit();
+    t.status = .aborted;
+    try std.testing.expectError(error.InvalidArgument, t.stagePut("a", "1"));
+    try std.testing.expectEqual(@as(usize, 0), t.writes.items.len);
+}